@@ -18,15 +18,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/transport"
 	"github.com/ollama/ollama/version"
 )
 
@@ -35,6 +38,9 @@ import (
 type Client struct {
 	base *url.URL
 	http *http.Client
+
+	// apiKey is sent to servers which require API keys
+	apiKey string
 }
 
 func checkError(resp *http.Response, body []byte) error {
@@ -50,6 +56,20 @@ func checkError(resp *http.Response, body []byte) error {
 		apiError.ErrorMessage = string(body)
 	}
 
+	var details struct {
+		Quota *QuotaError `json:"quota"`
+		Limit *LimitError `json:"limit"`
+	}
+
+	if err := json.Unmarshal(body, &details); err == nil {
+		switch {
+		case details.Quota != nil:
+			return *details.Quota
+		case details.Limit != nil:
+			return *details.Limit
+		}
+	}
+
 	return apiError
 }
 
@@ -61,11 +81,19 @@ func checkError(resp *http.Response, body []byte) error {
 //	<scheme>://<host>:<port>
 //
 // If the variable is not specified, a default ollama host and port will be
-// used.
+// used. Requests are authenticated with the API key in OLLAMA_API_KEY, if
+// it's set, and made through the proxy in HTTPS_PROXY or HTTP_PROXY trusting
+// the certificates in OLLAMA_CA_BUNDLE.
 func ClientFromEnvironment() (*Client, error) {
+	t, err := transport.Default()
+	if err != nil {
+		return nil, err
+	}
+
 	return &Client{
-		base: envconfig.Host(),
-		http: http.DefaultClient,
+		base:   envconfig.Host(),
+		http:   &http.Client{Transport: t},
+		apiKey: envconfig.APIKey(),
 	}, nil
 }
 
@@ -96,7 +124,10 @@ func (c *Client) do(ctx context.Context, method, path string, reqData, respData
 		reqBody = bytes.NewReader(data)
 	}
 
+	// JoinPath would escape the query, so it's set apart from the path
+	path, query, _ := strings.Cut(path, "?")
 	requestURL := c.base.JoinPath(path)
+	requestURL.RawQuery = query
 	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), reqBody)
 	if err != nil {
 		return err
@@ -105,6 +136,9 @@ func (c *Client) do(ctx context.Context, method, path string, reqData, respData
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/json")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 
 	respObj, err := c.http.Do(request)
 	if err != nil {
@@ -142,7 +176,10 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 		buf = bytes.NewBuffer(bts)
 	}
 
+	// JoinPath would escape the query, so it's set apart from the path
+	path, query, _ := strings.Cut(path, "?")
 	requestURL := c.base.JoinPath(path)
+	requestURL.RawQuery = query
 	request, err := http.NewRequestWithContext(ctx, method, requestURL.String(), buf)
 	if err != nil {
 		return err
@@ -151,6 +188,9 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/x-ndjson")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
 
 	response, err := c.http.Do(request)
 	if err != nil {
@@ -164,7 +204,11 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 	scanner.Buffer(scanBuf, maxBufferSize)
 	for scanner.Scan() {
 		var errorResponse struct {
-			Error string `json:"error,omitempty"`
+			Error     string      `json:"error,omitempty"`
+			Code      ErrorCode   `json:"code,omitempty"`
+			Detail    string      `json:"detail,omitempty"`
+			Retryable bool        `json:"retryable,omitempty"`
+			Quota     *QuotaError `json:"quota,omitempty"`
 		}
 
 		bts := scanner.Bytes()
@@ -172,15 +216,24 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 			return fmt.Errorf("unmarshal: %w", err)
 		}
 
-		if errorResponse.Error != "" {
-			return errors.New(errorResponse.Error)
+		if errorResponse.Quota != nil {
+			return *errorResponse.Quota
 		}
 
-		if response.StatusCode >= http.StatusBadRequest {
+		if errorResponse.Error != "" {
 			return StatusError{
 				StatusCode:   response.StatusCode,
-				Status:       response.Status,
 				ErrorMessage: errorResponse.Error,
+				Code:         errorResponse.Code,
+				Detail:       errorResponse.Detail,
+				Retryable:    errorResponse.Retryable,
+			}
+		}
+
+		if response.StatusCode >= http.StatusBadRequest {
+			return StatusError{
+				StatusCode: response.StatusCode,
+				Status:     response.Status,
 			}
 		}
 
@@ -231,6 +284,24 @@ func (c *Client) Chat(ctx context.Context, req *ChatRequest, fn ChatResponseFunc
 	})
 }
 
+// FIMResponseFunc is a function that [Client.FIM] invokes every time a
+// response is received from the service. If this function returns an error,
+// [Client.FIM] will stop generating and return this error.
+type FIMResponseFunc func(FIMResponse) error
+
+// FIM fills in the middle of a file at its cursors. fn is called for each
+// response, as in [Client.Generate].
+func (c *Client) FIM(ctx context.Context, req *FIMRequest, fn FIMResponseFunc) error {
+	return c.stream(ctx, http.MethodPost, "/api/fim", req, func(bts []byte) error {
+		var resp FIMResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+}
+
 // PullProgressFunc is a function that [Client.Pull] invokes every time there
 // is progress with a "pull" request sent to the service. If this function
 // returns an error, [Client.Pull] will stop the process and return this error.
@@ -250,6 +321,16 @@ func (c *Client) Pull(ctx context.Context, req *PullRequest, fn PullProgressFunc
 	})
 }
 
+// ListPulls lists the pulls running on the server, including those started
+// by other clients.
+func (c *Client) ListPulls(ctx context.Context) (*ListPullsResponse, error) {
+	var resp ListPullsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/pull", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // PushProgressFunc is a function that [Client.Push] invokes when progress is
 // made.
 // It's similar to other progress function types like [PullProgressFunc].
@@ -269,6 +350,25 @@ func (c *Client) Push(ctx context.Context, req *PushRequest, fn PushProgressFunc
 	})
 }
 
+// QuantizeProgressFunc is a function that [Client.Quantize] invokes when
+// progress is made.
+// It's similar to other progress function types like [PullProgressFunc].
+type QuantizeProgressFunc func(ProgressResponse) error
+
+// Quantize creates a model from the weights of another quantized to a
+// different type. fn is a progress function that behaves similarly to other
+// methods (see [Client.Pull]).
+func (c *Client) Quantize(ctx context.Context, req *QuantizeRequest, fn QuantizeProgressFunc) error {
+	return c.stream(ctx, http.MethodPost, "/api/quantize", req, func(bts []byte) error {
+		var resp ProgressResponse
+		if err := json.Unmarshal(bts, &resp); err != nil {
+			return err
+		}
+
+		return fn(resp)
+	})
+}
+
 // CreateProgressFunc is a function that [Client.Create] invokes when progress
 // is made.
 // It's similar to other progress function types like [PullProgressFunc].
@@ -324,6 +424,307 @@ func (c *Client) Delete(ctx context.Context, req *DeleteRequest) error {
 	return nil
 }
 
+// Config returns the settings which can be changed while the server is
+// running.
+func (c *Client) Config(ctx context.Context) (*ConfigResponse, error) {
+	var resp ConfigResponse
+	if err := c.do(ctx, http.MethodGet, "/api/config", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SetConfig changes settings while the server is running. The server only
+// accepts it from clients on the same host.
+func (c *Client) SetConfig(ctx context.Context, req *ConfigRequest) (*ConfigResponse, error) {
+	var resp ConfigResponse
+	if err := c.do(ctx, http.MethodPost, "/api/config", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Shutdown asks the server to shut down once requests in flight finish. The
+// server only accepts it from clients on the same host.
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/api/shutdown", nil, nil)
+}
+
+// CancelRequest stops the request with the given ID, such as the RequestID
+// of a [GenerateResponse], as if its client had disconnected. Requests which
+// are streamed end with an error with the code [ErrorCodeCanceled].
+func (c *Client) CancelRequest(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, "/api/requests/"+url.PathEscape(id)+"/cancel", nil, nil)
+}
+
+// Prune removes models from the model store according to the rules in req.
+func (c *Client) Prune(ctx context.Context, req *PruneRequest) (*PruneResponse, error) {
+	var resp PruneResponse
+	if err := c.do(ctx, http.MethodPost, "/api/prune", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// StoreStats reports how much of the model store each model uses, and how
+// much models save by sharing blobs.
+func (c *Client) StoreStats(ctx context.Context) (*StoreStatsResponse, error) {
+	var resp StoreStatsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/store/stats", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// LinkBlob imports the file at req.Path as the blob with digest by linking
+// it into the model store rather than copying it. The server must be on the
+// same machine as the file.
+func (c *Client) LinkBlob(ctx context.Context, digest string, req *LinkBlobRequest) (*LinkBlobResponse, error) {
+	var resp LinkBlobResponse
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/api/blobs/%s/link", digest), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SetAlias creates an alias for a model or retargets an existing alias.
+func (c *Client) SetAlias(ctx context.Context, req *AliasRequest) error {
+	if err := c.do(ctx, http.MethodPost, "/api/aliases", req, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteAlias deletes an alias without deleting the model it refers to.
+func (c *Client) DeleteAlias(ctx context.Context, req *AliasRequest) error {
+	if err := c.do(ctx, http.MethodDelete, "/api/aliases", req, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListAliases lists the aliases and the models they refer to.
+func (c *Client) ListAliases(ctx context.Context) (*ListAliasesResponse, error) {
+	var resp ListAliasesResponse
+	if err := c.do(ctx, http.MethodGet, "/api/aliases", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SetSplit splits the requests to an alias between models, replacing the
+// alias's split if it has one.
+func (c *Client) SetSplit(ctx context.Context, req *SplitRequest) error {
+	if err := c.do(ctx, http.MethodPost, "/api/splits", req, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteSplit deletes the split of an alias, so its requests go to its
+// target again.
+func (c *Client) DeleteSplit(ctx context.Context, req *SplitRequest) error {
+	if err := c.do(ctx, http.MethodDelete, "/api/splits", req, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListSplits lists the splits and the metrics of each of their arms.
+func (c *Client) ListSplits(ctx context.Context) (*ListSplitsResponse, error) {
+	var resp ListSplitsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/splits", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Replay sends the request of capture again and returns it along with the
+// new response, whatever its status.
+func (c *Client) Replay(ctx context.Context, capture *Capture) (*Capture, error) {
+	// JoinPath would escape the query, so it's set apart from the path
+	path, query, _ := strings.Cut(capture.Path, "?")
+	requestURL := c.base.JoinPath(path)
+	requestURL.RawQuery = query
+
+	// requests which weren't JSON are captured as strings
+	body := []byte(capture.Request)
+	var s string
+	if err := json.Unmarshal(capture.Request, &s); err == nil {
+		body = []byte(s)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, capture.Method, requestURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if c.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	start := time.Now()
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	respBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Capture{
+		ID:        response.Header.Get("X-Request-ID"),
+		CreatedAt: start.UTC(),
+		Method:    capture.Method,
+		Path:      capture.Path,
+		Tenant:    capture.Tenant,
+		Request:   capture.Request,
+		Status:    response.StatusCode,
+		Response:  string(respBody),
+		Duration:  time.Since(start),
+	}, nil
+}
+
+// ListProfiles lists the VRAM profiles measured for models on the GPUs
+// they were loaded on.
+func (c *Client) ListProfiles(ctx context.Context) (*ListProfilesResponse, error) {
+	var resp ListProfilesResponse
+	if err := c.do(ctx, http.MethodGet, "/api/profiles", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteProfiles deletes VRAM profiles so they're measured again the next
+// time their model is loaded.
+func (c *Client) DeleteProfiles(ctx context.Context, req *DeleteProfilesRequest) (*DeleteProfilesResponse, error) {
+	var resp DeleteProfilesResponse
+	if err := c.do(ctx, http.MethodDelete, "/api/profiles", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateCollection creates a collection of embeddings.
+func (c *Client) CreateCollection(ctx context.Context, req *CollectionRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/collections", req, nil)
+}
+
+// DeleteCollection deletes a collection and its points.
+func (c *Client) DeleteCollection(ctx context.Context, req *CollectionRequest) error {
+	return c.do(ctx, http.MethodDelete, "/api/collections", req, nil)
+}
+
+// ListCollections lists the collections of embeddings.
+func (c *Client) ListCollections(ctx context.Context) (*ListCollectionsResponse, error) {
+	var resp ListCollectionsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/collections", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// UpsertPoints adds points to a collection or replaces its points with the
+// same IDs.
+func (c *Client) UpsertPoints(ctx context.Context, req *UpsertPointsRequest) error {
+	return c.do(ctx, http.MethodPost, "/api/collections/points", req, nil)
+}
+
+// DeletePoints deletes points from a collection.
+func (c *Client) DeletePoints(ctx context.Context, req *DeletePointsRequest) error {
+	return c.do(ctx, http.MethodDelete, "/api/collections/points", req, nil)
+}
+
+// SearchCollection returns the points of a collection which are most
+// similar to an embedding.
+func (c *Client) SearchCollection(ctx context.Context, req *SearchCollectionRequest) (*SearchCollectionResponse, error) {
+	var resp SearchCollectionResponse
+	if err := c.do(ctx, http.MethodPost, "/api/collections/search", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SaveChat saves a conversation on the server as name, replacing the one
+// with the same name if there is one.
+func (c *Client) SaveChat(ctx context.Context, name string, req *SaveChatRequest) (*SavedChat, error) {
+	var resp SavedChat
+	if err := c.do(ctx, http.MethodPut, "/api/chats/"+url.PathEscape(name), req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// GetChat returns the conversation saved on the server as name.
+func (c *Client) GetChat(ctx context.Context, name string) (*SavedChat, error) {
+	var resp SavedChat
+	if err := c.do(ctx, http.MethodGet, "/api/chats/"+url.PathEscape(name), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteChat deletes the conversation saved on the server as name.
+func (c *Client) DeleteChat(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/api/chats/"+url.PathEscape(name), nil, nil)
+}
+
+// ListChats lists the conversations saved on the server, the most recently
+// saved first.
+func (c *Client) ListChats(ctx context.Context, req *ListChatsRequest) (*ListChatsResponse, error) {
+	q := url.Values{}
+	if req.Query != "" {
+		q.Set("q", req.Query)
+	}
+	if req.Limit > 0 {
+		q.Set("limit", strconv.Itoa(req.Limit))
+	}
+	if req.Offset > 0 {
+		q.Set("offset", strconv.Itoa(req.Offset))
+	}
+
+	path := "/api/chats"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+
+	var resp ListChatsResponse
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListAdapters lists the models with LoRA adapters, which may be passed as
+// the adapter of requests. If model isn't empty only the adapters of model
+// are listed.
+func (c *Client) ListAdapters(ctx context.Context, model string) (*ListAdaptersResponse, error) {
+	path := "/api/adapters"
+	if model != "" {
+		path += "?" + url.Values{"model": {model}}.Encode()
+	}
+
+	var resp ListAdaptersResponse
+	if err := c.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Search searches the public model registry for models matching req.
+func (c *Client) Search(ctx context.Context, req *SearchRequest) (*SearchResponse, error) {
+	var resp SearchResponse
+	if err := c.do(ctx, http.MethodPost, "/api/search", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Show obtains model information, including details, modelfile, license etc.
 func (c *Client) Show(ctx context.Context, req *ShowRequest) (*ShowResponse, error) {
 	var resp ShowResponse
@@ -333,6 +734,25 @@ func (c *Client) Show(ctx context.Context, req *ShowRequest) (*ShowResponse, err
 	return &resp, nil
 }
 
+// RenderTemplate renders the prompt template of a model for the messages of
+// req without running the model.
+func (c *Client) RenderTemplate(ctx context.Context, req *RenderTemplateRequest) (*RenderTemplateResponse, error) {
+	var resp RenderTemplateResponse
+	if err := c.do(ctx, http.MethodPost, "/api/template/render", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SBOM returns a CycloneDX bill of materials of a model.
+func (c *Client) SBOM(ctx context.Context, req *SBOMRequest) (*SBOM, error) {
+	var resp SBOM
+	if err := c.do(ctx, http.MethodPost, "/api/sbom", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Heartbeat checks if the server has started and is responsive; if yes, it
 // returns nil, otherwise an error.
 func (c *Client) Heartbeat(ctx context.Context) error {
@@ -351,6 +771,44 @@ func (c *Client) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse,
 	return &resp, nil
 }
 
+// Classify scores the labels of each input with a sequence classification
+// model.
+func (c *Client) Classify(ctx context.Context, req *ClassifyRequest) (*ClassifyResponse, error) {
+	var resp ClassifyResponse
+	if err := c.do(ctx, http.MethodPost, "/api/classify", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Moderate screens text or a conversation with a safety classifier model,
+// such as llama-guard3.
+func (c *Client) Moderate(ctx context.Context, req *ModerationRequest) (*ModerationResponse, error) {
+	var resp ModerationResponse
+	if err := c.do(ctx, http.MethodPost, "/api/moderations", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Agent runs a model with the server's agent tools until it answers.
+func (c *Client) Agent(ctx context.Context, req *AgentRequest) (*AgentResponse, error) {
+	var resp AgentResponse
+	if err := c.do(ctx, http.MethodPost, "/api/agent", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// AgentTools lists the tools models can call with [Client.Agent].
+func (c *Client) AgentTools(ctx context.Context) (*AgentToolsResponse, error) {
+	var resp AgentToolsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/agent/tools", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Embeddings generates an embedding from a model.
 func (c *Client) Embeddings(ctx context.Context, req *EmbeddingRequest) (*EmbeddingResponse, error) {
 	var resp EmbeddingResponse
@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -56,6 +57,7 @@ func TestClientFromEnvironment(t *testing.T) {
 type testError struct {
 	message    string
 	statusCode int
+	code       ErrorCode
 }
 
 func (e testError) Error() string {
@@ -67,6 +69,7 @@ func TestClientStream(t *testing.T) {
 		name      string
 		responses []any
 		wantErr   string
+		wantCode  error
 	}{
 		{
 			name: "immediate error response",
@@ -90,6 +93,18 @@ func TestClientStream(t *testing.T) {
 			},
 			wantErr: "mid-stream error",
 		},
+		{
+			name: "error with code",
+			responses: []any{
+				testError{
+					message:    "model 'llama' not found",
+					statusCode: http.StatusNotFound,
+					code:       ErrorCodeModelNotFound,
+				},
+			},
+			wantErr:  "model 'llama' not found",
+			wantCode: ErrModelNotFound,
+		},
 		{
 			name: "successful stream completion",
 			responses: []any{
@@ -117,8 +132,9 @@ func TestClientStream(t *testing.T) {
 				for _, resp := range tc.responses {
 					if errResp, ok := resp.(testError); ok {
 						w.WriteHeader(errResp.statusCode)
-						err := json.NewEncoder(w).Encode(map[string]string{
+						err := json.NewEncoder(w).Encode(map[string]any{
 							"error": errResp.message,
+							"code":  errResp.code,
 						})
 						if err != nil {
 							t.Fatal("failed to encode error response:", err)
@@ -153,6 +169,9 @@ func TestClientStream(t *testing.T) {
 				if !strings.Contains(err.Error(), tc.wantErr) {
 					t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
 				}
+				if tc.wantCode != nil && !errors.Is(err, tc.wantCode) {
+					t.Errorf("expected error to be %v, got %#v", tc.wantCode, err)
+				}
 				return
 			}
 			if err != nil {
@@ -167,6 +186,7 @@ func TestClientDo(t *testing.T) {
 		name     string
 		response any
 		wantErr  string
+		wantCode error
 	}{
 		{
 			name: "immediate error response",
@@ -184,6 +204,16 @@ func TestClientDo(t *testing.T) {
 			},
 			wantErr: "internal error",
 		},
+		{
+			name: "busy response",
+			response: testError{
+				message:    "server busy",
+				statusCode: http.StatusServiceUnavailable,
+				code:       ErrorCodeServerBusy,
+			},
+			wantErr:  "server busy",
+			wantCode: ErrServerBusy,
+		},
 		{
 			name: "successful response",
 			response: struct {
@@ -201,8 +231,9 @@ func TestClientDo(t *testing.T) {
 			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				if errResp, ok := tc.response.(testError); ok {
 					w.WriteHeader(errResp.statusCode)
-					err := json.NewEncoder(w).Encode(map[string]string{
+					err := json.NewEncoder(w).Encode(map[string]any{
 						"error": errResp.message,
+						"code":  errResp.code,
 					})
 					if err != nil {
 						t.Fatal("failed to encode error response:", err)
@@ -232,6 +263,9 @@ func TestClientDo(t *testing.T) {
 				if err.Error() != tc.wantErr {
 					t.Errorf("error message mismatch: got %q, want %q", err.Error(), tc.wantErr)
 				}
+				if tc.wantCode != nil && !errors.Is(err, tc.wantCode) {
+					t.Errorf("expected error to be %v, got %#v", tc.wantCode, err)
+				}
 				return
 			}
 
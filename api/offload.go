@@ -0,0 +1,208 @@
+package api
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Offload is where the offload option asks for the parts of a model to be
+// placed. It is set as space separated part=value pairs, for example
+// "blocks=0-15,20 output=cpu kv_cache=gpu", where the parts are:
+//
+//   - blocks: the repeating blocks on GPUs, as ranges and indexes or all or
+//     none
+//   - embeddings: the token embeddings, gpu or cpu
+//   - output: the output layer, gpu or cpu
+//   - kv_cache: the key/value cache of blocks on GPUs, gpu or cpu
+//
+// Parts which aren't set are placed automatically as num_gpu would.
+type Offload struct {
+	// Blocks are the indexes of the blocks to place on GPUs, in order. If
+	// it's nil and AllBlocks isn't set, as many blocks as fit are placed.
+	Blocks    []int
+	AllBlocks bool
+
+	// Embeddings, Output and KVCache are whether the part is placed on
+	// GPUs, or nil if it's placed automatically.
+	Embeddings *bool
+	Output     *bool
+	KVCache    *bool
+}
+
+// ParseOffload parses the value of the offload option.
+func ParseOffload(s string) (Offload, error) {
+	var o Offload
+	seen := make(map[string]bool)
+	for _, field := range strings.Fields(s) {
+		part, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return Offload{}, fmt.Errorf("offload %q must be set as part=value", field)
+		}
+
+		part = strings.ToLower(part)
+		if seen[part] {
+			return Offload{}, fmt.Errorf("offload part %q is set more than once", part)
+		}
+		seen[part] = true
+
+		var err error
+		switch part {
+		case "blocks":
+			o.Blocks, o.AllBlocks, err = parseBlocks(value)
+		case "embeddings":
+			o.Embeddings, err = parseDevice(part, value)
+		case "output":
+			o.Output, err = parseDevice(part, value)
+		case "kv_cache":
+			o.KVCache, err = parseDevice(part, value)
+		default:
+			err = fmt.Errorf("unknown offload part %q, must be blocks, embeddings, output or kv_cache", part)
+		}
+		if err != nil {
+			return Offload{}, err
+		}
+	}
+
+	return o, nil
+}
+
+// GPUBlocks returns the blocks of a model with n blocks to place on GPUs,
+// and whether they were requested rather than left to be placed
+// automatically. It's an error to request blocks the model doesn't have.
+func (o Offload) GPUBlocks(n int) ([]int, bool, error) {
+	switch {
+	case o.AllBlocks:
+		blocks := make([]int, n)
+		for i := range blocks {
+			blocks[i] = i
+		}
+		return blocks, true, nil
+	case o.Blocks == nil:
+		return nil, false, nil
+	}
+
+	if len(o.Blocks) > 0 && o.Blocks[len(o.Blocks)-1] >= n {
+		return nil, false, fmt.Errorf("offload block %d is out of range, the model has %d blocks", o.Blocks[len(o.Blocks)-1], n)
+	}
+
+	return o.Blocks, true, nil
+}
+
+// maxBlocks bounds the indexes of blocks, which no model comes close to, so
+// that a mistyped range doesn't take all of memory.
+const maxBlocks = 1 << 16
+
+func parseBlocks(s string) ([]int, bool, error) {
+	switch strings.ToLower(s) {
+	case "all":
+		return nil, true, nil
+	case "none":
+		return []int{}, false, nil
+	}
+
+	blocks := []int{}
+	for _, r := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(r, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return nil, false, fmt.Errorf("invalid offload blocks %q", r)
+		}
+
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil || end < start {
+				return nil, false, fmt.Errorf("invalid offload blocks %q", r)
+			}
+		}
+
+		if end >= maxBlocks {
+			return nil, false, fmt.Errorf("offload block %d is out of range", end)
+		}
+
+		for i := start; i <= end; i++ {
+			blocks = append(blocks, i)
+		}
+	}
+
+	slices.Sort(blocks)
+	return slices.Compact(blocks), false, nil
+}
+
+func parseDevice(part, s string) (*bool, error) {
+	var gpu bool
+	switch strings.ToLower(s) {
+	case "gpu":
+		gpu = true
+	case "cpu":
+	default:
+		return nil, fmt.Errorf("offload %s must be gpu or cpu, got %q", part, s)
+	}
+
+	return &gpu, nil
+}
+
+// OffloadPlan is where the parts of a model are placed by a runner, and how
+// much memory they take.
+type OffloadPlan struct {
+	// Library is the library of the GPUs the model is placed on, or cpu if
+	// none of it is.
+	Library string `json:"library"`
+
+	// Blocks is the number of blocks the model has and GPUBlocks are the
+	// indexes of those on GPUs.
+	Blocks    int   `json:"blocks"`
+	GPUBlocks []int `json:"gpu_blocks,omitempty"`
+
+	// Embeddings, Output and KVCache are where the part is placed, gpu or
+	// cpu.
+	Embeddings string `json:"embeddings"`
+	Output     string `json:"output"`
+	KVCache    string `json:"kv_cache"`
+
+	// Size is the memory the model takes, VRAMSize how much of it is on
+	// GPUs and GPUSizes how much is on each GPU.
+	Size     uint64   `json:"size"`
+	VRAMSize uint64   `json:"size_vram"`
+	GPUSizes []uint64 `json:"gpu_sizes,omitempty"`
+
+	// Streaming is whether the weights of blocks in system memory are
+	// streamed to GPUs to process prompts, as the weight_streaming option
+	// asks. It's only done when some blocks don't fit on GPUs.
+	Streaming bool `json:"streaming,omitempty"`
+}
+
+// String returns the plan as a value of the offload option, which places
+// the model the same way.
+func (p OffloadPlan) String() string {
+	return fmt.Sprintf("blocks=%s embeddings=%s output=%s kv_cache=%s", FormatBlocks(p.GPUBlocks, p.Blocks), p.Embeddings, p.Output, p.KVCache)
+}
+
+// FormatBlocks returns the indexes of blocks of a model with n blocks as
+// they're set in the offload option.
+func FormatBlocks(blocks []int, n int) string {
+	switch {
+	case len(blocks) == 0:
+		return "none"
+	case len(blocks) == n:
+		return "all"
+	}
+
+	var ranges []string
+	for i := 0; i < len(blocks); {
+		j := i
+		for j+1 < len(blocks) && blocks[j+1] == blocks[j]+1 {
+			j++
+		}
+
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(blocks[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", blocks[i], blocks[j]))
+		}
+		i = j + 1
+	}
+
+	return strings.Join(ranges, ",")
+}
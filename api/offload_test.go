@@ -0,0 +1,94 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOffload(t *testing.T) {
+	gpu, cpu := true, false
+
+	tests := []struct {
+		name string
+		s    string
+		exp  Offload
+		err  string
+	}{
+		{name: "empty", s: "", exp: Offload{}},
+		{name: "all", s: "blocks=all", exp: Offload{AllBlocks: true}},
+		{name: "none", s: "blocks=none", exp: Offload{Blocks: []int{}}},
+		{name: "ranges", s: "blocks=4-6,0,5,2-2", exp: Offload{Blocks: []int{0, 2, 4, 5, 6}}},
+		{
+			name: "parts",
+			s:    "embeddings=gpu  OUTPUT=CPU kv_cache=cpu",
+			exp:  Offload{Embeddings: &gpu, Output: &cpu, KVCache: &cpu},
+		},
+		{name: "no value", s: "blocks", err: `offload "blocks" must be set as part=value`},
+		{name: "unknown part", s: "layers=all", err: `unknown offload part "layers"`},
+		{name: "repeated part", s: "output=gpu output=cpu", err: `offload part "output" is set more than once`},
+		{name: "bad device", s: "kv_cache=host", err: `offload kv_cache must be gpu or cpu, got "host"`},
+		{name: "bad range", s: "blocks=6-4", err: `invalid offload blocks "6-4"`},
+		{name: "negative", s: "blocks=-1", err: `invalid offload blocks "-1"`},
+		{name: "too large", s: "blocks=0-100000000", err: "offload block 100000000 is out of range"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o, err := ParseOffload(test.s)
+			if test.err != "" {
+				require.ErrorContains(t, err, test.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.exp, o)
+		})
+	}
+}
+
+func TestOffloadGPUBlocks(t *testing.T) {
+	blocks, ok, err := Offload{}.GPUBlocks(4)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, blocks)
+
+	blocks, ok, err = Offload{AllBlocks: true}.GPUBlocks(4)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []int{0, 1, 2, 3}, blocks)
+
+	blocks, ok, err = Offload{Blocks: []int{}}.GPUBlocks(4)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Empty(t, blocks)
+
+	_, _, err = Offload{Blocks: []int{2, 4}}.GPUBlocks(4)
+	require.ErrorContains(t, err, "offload block 4 is out of range, the model has 4 blocks")
+}
+
+func TestOffloadPlanString(t *testing.T) {
+	plan := OffloadPlan{
+		Blocks:     32,
+		GPUBlocks:  []int{0, 1, 2, 5, 7, 8},
+		Embeddings: "cpu",
+		Output:     "gpu",
+		KVCache:    "gpu",
+	}
+	assert.Equal(t, "blocks=0-2,5,7-8 embeddings=cpu output=gpu kv_cache=gpu", plan.String())
+
+	o, err := ParseOffload(plan.String())
+	require.NoError(t, err)
+	assert.Equal(t, plan.GPUBlocks, o.Blocks)
+
+	assert.Equal(t, "none", FormatBlocks(nil, 32))
+	assert.Equal(t, "all", FormatBlocks([]int{0, 1, 2}, 3))
+}
+
+func TestOffloadOption(t *testing.T) {
+	opts := DefaultOptions()
+	assert.Empty(t, opts.Offload)
+	require.NoError(t, opts.FromMap(map[string]interface{}{"offload": "blocks=all kv_cache=cpu"}))
+	assert.Equal(t, "blocks=all kv_cache=cpu", opts.Offload)
+}
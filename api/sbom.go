@@ -0,0 +1,107 @@
+package api
+
+import "time"
+
+// SBOMRequest is the request passed to [Client.SBOM].
+type SBOMRequest struct {
+	Model string `json:"model"`
+}
+
+// SBOM is a CycloneDX bill of materials of a model. The model is the
+// component of the metadata, and its layers are the components of the
+// document which it depends on. The models it was created from are its
+// ancestors, as is the repository its weights were converted from.
+//
+// Provenance which CycloneDX has no field for, such as the version of Ollama
+// which converted the weights of a model, is recorded as properties of the
+// model prefixed with "ollama:".
+type SBOM struct {
+	BOMFormat    string           `json:"bomFormat"`
+	SpecVersion  string           `json:"specVersion"`
+	SerialNumber string           `json:"serialNumber,omitempty"`
+	Version      int              `json:"version"`
+	Metadata     SBOMMetadata     `json:"metadata"`
+	Components   []SBOMComponent  `json:"components,omitempty"`
+	Dependencies []SBOMDependency `json:"dependencies,omitempty"`
+}
+
+// SBOMMetadata is the metadata of an [SBOM]: when and by what it was
+// generated, and the model it describes.
+type SBOMMetadata struct {
+	Timestamp time.Time      `json:"timestamp"`
+	Tools     SBOMTools      `json:"tools"`
+	Component *SBOMComponent `json:"component,omitempty"`
+}
+
+// SBOMTools are the tools which generated an [SBOM].
+type SBOMTools struct {
+	Components []SBOMComponent `json:"components"`
+}
+
+// SBOMComponent is a model, a layer of a model, or a tool in an [SBOM].
+type SBOMComponent struct {
+	BOMRef             string                  `json:"bom-ref,omitempty"`
+	Type               string                  `json:"type"`
+	MimeType           string                  `json:"mime-type,omitempty"`
+	Group              string                  `json:"group,omitempty"`
+	Name               string                  `json:"name"`
+	Version            string                  `json:"version,omitempty"`
+	Hashes             []SBOMHash              `json:"hashes,omitempty"`
+	Licenses           []SBOMLicense           `json:"licenses,omitempty"`
+	PURL               string                  `json:"purl,omitempty"`
+	ExternalReferences []SBOMExternalReference `json:"externalReferences,omitempty"`
+	Pedigree           *SBOMPedigree           `json:"pedigree,omitempty"`
+	Properties         []SBOMProperty          `json:"properties,omitempty"`
+}
+
+// SBOMHash is a hash of a component, such as the SHA-256 digest of a layer.
+type SBOMHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+// SBOMLicense is a license of a component. License is identified by its
+// SPDX ID if it's a well known license, and by its first line otherwise.
+type SBOMLicense struct {
+	License SBOMLicenseInfo `json:"license"`
+}
+
+// SBOMLicenseInfo identifies a license and has its full text.
+type SBOMLicenseInfo struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name,omitempty"`
+	Text *SBOMAttachment `json:"text,omitempty"`
+}
+
+// SBOMAttachment is text attached to a component, such as the full text of a
+// license.
+type SBOMAttachment struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+// SBOMExternalReference is where more about a component can be found, such as
+// the repository its weights were converted from.
+type SBOMExternalReference struct {
+	Type    string `json:"type"`
+	URL     string `json:"url"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// SBOMPedigree is what a component was derived from.
+type SBOMPedigree struct {
+	Ancestors []SBOMComponent `json:"ancestors,omitempty"`
+}
+
+// SBOMProperty is a name and value describing a component which CycloneDX
+// has no field for.
+type SBOMProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// SBOMDependency lists the components the component Ref depends on.
+type SBOMDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
@@ -12,13 +12,26 @@ import (
 	"time"
 
 	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
 )
 
-// StatusError is an error with an HTTP status code and message.
+// StatusError is an error with an HTTP status code and message. Code tells
+// errors apart without matching their messages, and is checked by
+// [errors.Is] against the Err values of this package, for example:
+//
+//	if errors.Is(err, api.ErrModelNotFound) {
+//		// pull the model
+//	}
 type StatusError struct {
 	StatusCode   int
 	Status       string
-	ErrorMessage string `json:"error"`
+	ErrorMessage string    `json:"error"`
+	Code         ErrorCode `json:"code,omitempty"`
+	// Detail is more information on the error, if any.
+	Detail string `json:"detail,omitempty"`
+	// Retryable is set for errors which may not happen again if the request
+	// is retried later.
+	Retryable bool `json:"retryable,omitempty"`
 }
 
 func (e StatusError) Error() string {
@@ -35,9 +48,58 @@ func (e StatusError) Error() string {
 	}
 }
 
+// Is reports whether target is a StatusError with the same Code.
+func (e StatusError) Is(target error) bool {
+	t, ok := target.(StatusError)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+// ErrorCode identifies the kind of an error returned by the server.
+type ErrorCode string
+
+const (
+	ErrorCodeInvalidRequest    ErrorCode = "INVALID_REQUEST"
+	ErrorCodeInvalidModelName  ErrorCode = "INVALID_MODEL_NAME"
+	ErrorCodeUnauthorized      ErrorCode = "UNAUTHORIZED"
+	ErrorCodeForbidden         ErrorCode = "FORBIDDEN"
+	ErrorCodeNotFound          ErrorCode = "NOT_FOUND"
+	ErrorCodeModelNotFound     ErrorCode = "MODEL_NOT_FOUND"
+	ErrorCodeCapabilityMissing ErrorCode = "CAPABILITY_MISSING"
+	ErrorCodeContextExceeded   ErrorCode = "CONTEXT_EXCEEDED"
+	ErrorCodeOutOfMemory       ErrorCode = "OUT_OF_MEMORY"
+	ErrorCodeQuotaExceeded     ErrorCode = "QUOTA_EXCEEDED"
+	ErrorCodeServerBusy        ErrorCode = "SERVER_BUSY"
+	ErrorCodeContentBlocked    ErrorCode = "CONTENT_BLOCKED"
+	ErrorCodeLimitExceeded     ErrorCode = "LIMIT_EXCEEDED"
+	ErrorCodeCanceled          ErrorCode = "CANCELED"
+	ErrorCodeInternal          ErrorCode = "INTERNAL"
+)
+
+// Errors returned by the server with each code, to match with [errors.Is].
+var (
+	ErrInvalidRequest    = StatusError{Code: ErrorCodeInvalidRequest}
+	ErrInvalidModelName  = StatusError{Code: ErrorCodeInvalidModelName}
+	ErrUnauthorized      = StatusError{Code: ErrorCodeUnauthorized}
+	ErrForbidden         = StatusError{Code: ErrorCodeForbidden}
+	ErrNotFound          = StatusError{Code: ErrorCodeNotFound}
+	ErrModelNotFound     = StatusError{Code: ErrorCodeModelNotFound}
+	ErrCapabilityMissing = StatusError{Code: ErrorCodeCapabilityMissing}
+	ErrContextExceeded   = StatusError{Code: ErrorCodeContextExceeded}
+	ErrOutOfMemory       = StatusError{Code: ErrorCodeOutOfMemory}
+	ErrQuotaExceeded     = StatusError{Code: ErrorCodeQuotaExceeded}
+	ErrServerBusy        = StatusError{Code: ErrorCodeServerBusy}
+	ErrContentBlocked    = StatusError{Code: ErrorCodeContentBlocked}
+	ErrLimitExceeded     = StatusError{Code: ErrorCodeLimitExceeded}
+	ErrCanceled          = StatusError{Code: ErrorCodeCanceled}
+	ErrInternal          = StatusError{Code: ErrorCodeInternal}
+)
+
 // ImageData represents the raw binary data of an image file.
 type ImageData []byte
 
+// AudioData represents the raw binary data of an audio file.
+type AudioData []byte
+
 // GenerateRequest describes a request sent by [Client.Generate]. While you
 // have to specify the Model and Prompt fields, all the other fields have
 // reasonable defaults for basic uses.
@@ -79,6 +141,22 @@ type GenerateRequest struct {
 	// request, for multimodal models.
 	Images []ImageData `json:"images,omitempty"`
 
+	// Adapter is the name of a model created with a LoRA adapter of Model,
+	// which is applied to this request instead of the adapters of Model.
+	// Requests with different adapters share the runner of Model.
+	Adapter string `json:"adapter,omitempty"`
+
+	// N is the number of completions to generate for the prompt, which is
+	// only processed once. Each completion uses one of the parallel requests
+	// of the model. Zero is one completion.
+	N int `json:"n,omitempty"`
+
+	// TimeoutMS is how many milliseconds after the request is received
+	// generation stops, and the response so far is returned with the done
+	// reason "timeout". Zero uses the server's default, which is set by
+	// OLLAMA_GENERATE_TIMEOUT.
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+
 	// Options lists model-specific options. For example, temperature can be
 	// set through this field, if the model supports it.
 	Options map[string]interface{} `json:"options"`
@@ -105,10 +183,78 @@ type ChatRequest struct {
 	// Tools is an optional list of tools the model has access to.
 	Tools `json:"tools,omitempty"`
 
+	// Adapter is the name of a model created with a LoRA adapter of Model,
+	// as in [GenerateRequest].
+	Adapter string `json:"adapter,omitempty"`
+
+	// N is the number of completions to generate, as in [GenerateRequest].
+	N int `json:"n,omitempty"`
+
+	// TimeoutMS is when generation stops, as in [GenerateRequest].
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+
+	// Think enables or disables the reasoning of models whose template
+	// supports thinking. Reasoning is returned in the Thinking field of the
+	// response message unless Think is false, in which case it's removed.
+	// Models think by default.
+	Think *bool `json:"think,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// FIMRequest is the request passed to [Client.FIM]. Text is generated at
+// each of the Cursors of File, which are byte offsets into its content in
+// ascending order. Cursors are filled in order, so each insertion is
+// generated with the earlier ones in place.
+//
+// Files are other files of the repository Repo which are given to the model
+// as context. Code models whose fill-in-the-middle format is known, such as
+// StarCoder2, CodeGemma and DeepSeek-Coder, see them along with the paths of
+// the files. Other models are prompted with their template and only see
+// File.
+type FIMRequest struct {
+	// Model is the model name, as in [GenerateRequest].
+	Model string `json:"model"`
+
+	// File is the file text is inserted into.
+	File FIMFile `json:"file"`
+
+	// Cursors are where text is inserted into File.
+	Cursors []int `json:"cursors"`
+
+	// Repo is the name of the repository of the files.
+	Repo string `json:"repo,omitempty"`
+
+	// Files are the other files of the repository.
+	Files []FIMFile `json:"files,omitempty"`
+
+	// Style is the fill-in-the-middle format of the model: "starcoder2",
+	// "codegemma" or "deepseek-coder". By default it's detected from the
+	// template of the model.
+	Style string `json:"style,omitempty"`
+
+	// Stream enables streaming of returned responses; true by default.
+	Stream *bool `json:"stream,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded into memory
+	// following the request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// TimeoutMS is when generation stops, as in [GenerateRequest]. Cursors
+	// which aren't reached by then are left empty.
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]interface{} `json:"options"`
 }
 
+// FIMFile is a file of a [FIMRequest].
+type FIMFile struct {
+	Path    string `json:"path,omitempty"`
+	Content string `json:"content"`
+}
+
 type Tools []Tool
 
 func (t Tools) String() string {
@@ -122,13 +268,29 @@ func (t Tool) String() string {
 }
 
 // Message is a single message in a chat sequence. The message contains the
-// role ("system", "user", or "assistant"), the content and an optional list
-// of images.
+// role ("system", "user", or "assistant"), the content and optional lists
+// of images and audio.
 type Message struct {
 	Role      string      `json:"role"`
 	Content   string      `json:"content"`
 	Images    []ImageData `json:"images,omitempty"`
+	Audio     []AudioData `json:"audio,omitempty"`
 	ToolCalls []ToolCall  `json:"tool_calls,omitempty"`
+
+	// Thinking is the reasoning of the model before its content, for models
+	// whose template supports thinking.
+	Thinking string `json:"thinking,omitempty"`
+
+	// Name is the name of the author of the message, such as the tool
+	// whose result it is.
+	Name string `json:"name,omitempty"`
+
+	// ToolCallID is the ID of the tool call a tool message is the result of.
+	ToolCallID string `json:"tool_call_id,omitempty"`
+
+	// Metadata is passed to templates as is, for templates which render
+	// more than the content of messages, such as documents to cite.
+	Metadata map[string]any `json:"metadata,omitempty"`
 }
 
 func (m *Message) UnmarshalJSON(b []byte) error {
@@ -144,6 +306,9 @@ func (m *Message) UnmarshalJSON(b []byte) error {
 }
 
 type ToolCall struct {
+	// ID identifies the tool call to the tool message of its result, for
+	// templates which render it.
+	ID       string           `json:"id,omitempty"`
 	Function ToolCallFunction `json:"function"`
 }
 
@@ -189,14 +354,51 @@ func (t *ToolFunction) String() string {
 type ChatResponse struct {
 	Model      string    `json:"model"`
 	CreatedAt  time.Time `json:"created_at"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Index      int       `json:"index,omitempty"`
 	Message    Message   `json:"message"`
 	DoneReason string    `json:"done_reason,omitempty"`
 
 	Done bool `json:"done"`
 
+	// Choices are all the completions of a request with N greater than
+	// one, when it isn't streamed.
+	Choices []ChatChoice `json:"choices,omitempty"`
+
+	// Regions are the areas of images that the message points out, for
+	// vision models which write their coordinates, such as Qwen2-VL. They
+	// are set on the last response of a completion once its whole message
+	// is known.
+	Regions []Region `json:"regions,omitempty"`
+
+	// Moderation is set on the last response, as in [GenerateResponse].
+	Moderation *Moderation `json:"moderation,omitempty"`
+
+	// CacheHit is set as in [GenerateResponse].
+	CacheHit bool `json:"cache_hit,omitempty"`
+
 	Metrics
 }
 
+// ChatChoice is one of the completions of a [ChatRequest].
+type ChatChoice struct {
+	Index      int      `json:"index"`
+	Message    Message  `json:"message"`
+	DoneReason string   `json:"done_reason,omitempty"`
+	Regions    []Region `json:"regions,omitempty"`
+}
+
+// Region is an area of an image that a vision model points out in its
+// response, such as an object it was asked to find.
+type Region struct {
+	// Label is what the model says is in the region, if anything.
+	Label string `json:"label,omitempty"`
+
+	// Box is the region's bounding box as the left, top, right and bottom
+	// edges, each a fraction of the image's width or height from 0 to 1.
+	Box [4]float64 `json:"box"`
+}
+
 type Metrics struct {
 	TotalDuration      time.Duration `json:"total_duration,omitempty"`
 	LoadDuration       time.Duration `json:"load_duration,omitempty"`
@@ -227,7 +429,21 @@ type Options struct {
 	Mirostat         int      `json:"mirostat,omitempty"`
 	MirostatTau      float32  `json:"mirostat_tau,omitempty"`
 	MirostatEta      float32  `json:"mirostat_eta,omitempty"`
+	DryMultiplier    float32  `json:"dry_multiplier,omitempty"`
+	DryBase          float32  `json:"dry_base,omitempty"`
+	DryAllowedLength int      `json:"dry_allowed_length,omitempty"`
+	DryPenaltyLastN  int      `json:"dry_penalty_last_n,omitempty"`
+	DryBreakers      []string `json:"dry_sequence_breakers,omitempty"`
+	XTCProbability   float32  `json:"xtc_probability,omitempty"`
+	XTCThreshold     float32  `json:"xtc_threshold,omitempty"`
 	Stop             []string `json:"stop,omitempty"`
+	StopRegex        []string `json:"stop_regex,omitempty"`
+	StopTokens       []int    `json:"stop_tokens,omitempty"`
+	PostProcess      []string `json:"post_process,omitempty"`
+	TokenHealing     bool     `json:"token_healing,omitempty"`
+	ParseSpecial     *bool    `json:"parse_special,omitempty"`
+	RenderSpecial    *bool    `json:"render_special,omitempty"`
+	CacheMode        string   `json:"cache_mode,omitempty"`
 }
 
 // Runner options which must be set when the model is loaded into memory
@@ -243,6 +459,37 @@ type Runner struct {
 	UseMMap   *bool `json:"use_mmap,omitempty"`
 	UseMLock  bool  `json:"use_mlock,omitempty"`
 	NumThread int   `json:"num_thread,omitempty"`
+
+	// KVCacheType is the quantization type of the key/value cache: f16, q8_0
+	// or q4_0. If empty, OLLAMA_KV_CACHE_TYPE is used.
+	KVCacheType string `json:"kv_cache_type,omitempty"`
+
+	// FlashAttention enables flash attention where the GPUs and model
+	// support it. If nil, OLLAMA_FLASH_ATTENTION is used.
+	FlashAttention *bool `json:"flash_attention,omitempty"`
+
+	// PagedAttention allocates the key/value cache in fixed-size blocks
+	// which concurrent requests take as they grow, rather than in space
+	// reserved for each batch. Only the Ollama engine supports it.
+	PagedAttention bool `json:"paged_attention,omitempty"`
+
+	// EncoderCache is where the Ollama engine keeps the encoder outputs of
+	// images for models which attend to them in every step, such as the
+	// cross attention states of mllama: device (the default), f16 to keep
+	// them on the GPU at half precision, or host to keep them in system
+	// memory and copy them to the GPU when they are used.
+	EncoderCache string `json:"encoder_cache,omitempty"`
+
+	// Offload overrides where parts of the model are placed, as described
+	// by [Offload]. If it's set, blocks it places on GPUs replace NumGPU.
+	Offload string `json:"offload,omitempty"`
+
+	// WeightStreaming keeps the weights of blocks which don't fit on GPUs
+	// in pinned system memory and uploads them to a GPU as prompts are
+	// processed, so models slightly larger than VRAM process prompts much
+	// faster than on the CPU. Tokens are still generated on the CPU for
+	// those blocks, and their weights aren't memory mapped.
+	WeightStreaming bool `json:"weight_streaming,omitempty"`
 }
 
 // EmbedRequest is the request passed to [Client.Embed].
@@ -259,20 +506,260 @@ type EmbedRequest struct {
 
 	Truncate *bool `json:"truncate,omitempty"`
 
+	// Dimensions truncates embeddings to their first Dimensions values,
+	// which keeps most of their meaning for models trained with Matryoshka
+	// representation learning. Zero leaves embeddings as they are.
+	Dimensions int `json:"dimensions,omitempty"`
+
+	// Normalize scales embeddings to a length of one, after they're
+	// truncated to Dimensions; true by default.
+	Normalize *bool `json:"normalize,omitempty"`
+
+	// Chunking splits each input into chunks which are embedded separately,
+	// so inputs can be longer than the context length of the model.
+	Chunking *EmbedChunking `json:"chunking,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]interface{} `json:"options"`
 }
 
-// EmbedResponse is the response from [Client.Embed].
+// EmbedChunking is how the inputs of an [EmbedRequest] are split into
+// chunks. Chunks are Size tokens long, or the context length of the model
+// if Size is zero, and consecutive chunks share Overlap tokens. If Pool is
+// set, the embeddings of the chunks of each input are averaged, weighted by
+// their length, into an embedding of the whole input.
+type EmbedChunking struct {
+	Size    int  `json:"size,omitempty"`
+	Overlap int  `json:"overlap,omitempty"`
+	Pool    bool `json:"pool,omitempty"`
+}
+
+// EmbedResponse is the response from [Client.Embed]. Inputs which are split
+// into chunks have their chunks in Documents, and Embeddings has their
+// pooled embeddings if they're pooled.
 type EmbedResponse struct {
-	Model      string      `json:"model"`
-	Embeddings [][]float32 `json:"embeddings"`
+	Model      string          `json:"model"`
+	Embeddings [][]float32     `json:"embeddings"`
+	Documents  []EmbedDocument `json:"documents,omitempty"`
+
+	TotalDuration   time.Duration `json:"total_duration,omitempty"`
+	LoadDuration    time.Duration `json:"load_duration,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+}
+
+// EmbedDocument is an input of an [EmbedRequest] which is split into chunks.
+// Embedding is the pooled embedding of its chunks, if they're pooled.
+type EmbedDocument struct {
+	Chunks    []EmbedChunk `json:"chunks"`
+	Embedding []float32    `json:"embedding,omitempty"`
+}
+
+// EmbedChunk is a chunk of an [EmbedDocument] and its embedding.
+type EmbedChunk struct {
+	Text      string    `json:"text"`
+	Embedding []float32 `json:"embedding"`
+}
+
+// ClassifyRequest is the request passed to [Client.Classify].
+type ClassifyRequest struct {
+	// Model is the name of a sequence classification model.
+	Model string `json:"model"`
+
+	// Input is the text or texts to classify.
+	Input any `json:"input"`
+
+	// Labels are candidate labels for zero-shot classification with a
+	// natural language inference model. Each label is scored by how much
+	// the input entails HypothesisTemplate with the label in place of {}.
+	// If Labels is empty, the input is classified with the labels of the
+	// model.
+	Labels []string `json:"labels,omitempty"`
+
+	// HypothesisTemplate is the hypothesis of zero-shot classification,
+	// "This example is {}." by default.
+	HypothesisTemplate string `json:"hypothesis_template,omitempty"`
+
+	// MultiLabel scores each label independently rather than as
+	// probabilities which sum to one. It's the default for models trained
+	// for multi-label classification.
+	MultiLabel *bool `json:"multi_label,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	Truncate *bool `json:"truncate,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// ClassifyResponse is the response from [Client.Classify]. It has the
+// labels of each input, from most to least likely.
+type ClassifyResponse struct {
+	Model   string            `json:"model"`
+	Results [][]ClassifyLabel `json:"results"`
 
 	TotalDuration   time.Duration `json:"total_duration,omitempty"`
 	LoadDuration    time.Duration `json:"load_duration,omitempty"`
 	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
 }
 
+// ClassifyLabel is a label of a [ClassifyResponse] and its score.
+type ClassifyLabel struct {
+	Label string  `json:"label"`
+	Score float32 `json:"score"`
+}
+
+// ModerationRequest is the request passed to [Client.Moderate]. Either
+// Input or Messages is screened.
+type ModerationRequest struct {
+	// Model is the name of a safety classifier model, such as llama-guard3.
+	// By default it's the server's moderation model, OLLAMA_MODERATION_MODEL.
+	Model string `json:"model,omitempty"`
+
+	// Input is text from a user to screen.
+	Input string `json:"input,omitempty"`
+
+	// Messages is a conversation to screen. The last message is screened in
+	// the context of the ones before it.
+	Messages []Message `json:"messages,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+}
+
+// ModerationResponse is the response from [Client.Moderate].
+type ModerationResponse struct {
+	Model string `json:"model"`
+	ModerationResult
+}
+
+// ModerationResult is the verdict of a moderation model on some content.
+type ModerationResult struct {
+	// Flagged is true if the content is unsafe.
+	Flagged bool `json:"flagged"`
+
+	// Categories are the codes of the model's hazard categories which the
+	// content falls into, such as "S1", if it's flagged.
+	Categories []string `json:"categories,omitempty"`
+}
+
+// Moderation is the verdict of the server's moderation model on a request
+// and its response, which is added to the last response when the
+// moderation policy is "annotate".
+type Moderation struct {
+	Model  string            `json:"model"`
+	Input  *ModerationResult `json:"input,omitempty"`
+	Output *ModerationResult `json:"output,omitempty"`
+}
+
+// AgentRequest is the request passed to [Client.Agent]. The model is run
+// until it answers without calling a tool, or MaxSteps is reached, with
+// the results of the tools it calls.
+type AgentRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Messages is the conversation so far, which the model continues.
+	Messages []Message `json:"messages"`
+
+	// Tools are the names of the server's agent tools the model may call.
+	// It may call all of them if there are none.
+	Tools []string `json:"tools,omitempty"`
+
+	// MaxSteps limits how many times the model is run. Default is 10.
+	MaxSteps int `json:"max_steps,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]any `json:"options,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+}
+
+// AgentResponse is the response from [Client.Agent].
+type AgentResponse struct {
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+	RequestID string    `json:"request_id,omitempty"`
+
+	// Message is the model's answer, which is empty if it didn't answer
+	// within the request's steps.
+	Message Message `json:"message"`
+
+	// DoneReason is "stop" when the model answered, or "max_steps" when it
+	// was still calling tools after its last step.
+	DoneReason string `json:"done_reason"`
+
+	// Steps are the messages of each run of the model and the results of
+	// the tools it called, in order.
+	Steps []AgentStep `json:"steps"`
+
+	Metrics
+}
+
+// AgentStep is a run of the model by the agent.
+type AgentStep struct {
+	Message     Message           `json:"message"`
+	ToolResults []AgentToolResult `json:"tool_results,omitempty"`
+	Metrics
+}
+
+// AgentToolResult is the result of a tool called by the model.
+type AgentToolResult struct {
+	Name      string                    `json:"name"`
+	Arguments ToolCallFunctionArguments `json:"arguments"`
+
+	// Result is what the tool returned, and Error why it failed. The model
+	// is given either.
+	Result   string        `json:"result,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// AgentToolsResponse is the response from [Client.AgentTools].
+type AgentToolsResponse struct {
+	Tools Tools `json:"tools"`
+}
+
+// The events which the server sends webhooks for.
+const (
+	WebhookModelPulled      = "model.pulled"
+	WebhookModelCreated     = "model.created"
+	WebhookModelDeleted     = "model.deleted"
+	WebhookRunnerLoaded     = "runner.loaded"
+	WebhookRunnerUnloaded   = "runner.unloaded"
+	WebhookRunnerCrashed    = "runner.crashed"
+	WebhookRequestCompleted = "request.completed"
+)
+
+// WebhookEvent is the body of a webhook sent by the server when a model or
+// runner changes, or a request completes.
+type WebhookEvent struct {
+	// Event is what happened, such as "model.pulled".
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// Model is the name of the model the event is about.
+	Model string `json:"model,omitempty"`
+
+	// Tenant is the namespace of the API key of the request which caused
+	// the event, if it has one.
+	Tenant string `json:"tenant,omitempty"`
+
+	// RequestID is the ID of the completed request, for request events.
+	RequestID string `json:"request_id,omitempty"`
+
+	// Error is why a runner crashed.
+	Error string `json:"error,omitempty"`
+
+	// Metrics are the timings and token counts of a completed request.
+	Metrics *Metrics `json:"metrics,omitempty"`
+}
+
 // EmbeddingRequest is the request passed to [Client.Embeddings].
 type EmbeddingRequest struct {
 	// Model is the model name.
@@ -302,6 +789,7 @@ type CreateRequest struct {
 
 	From       string            `json:"from,omitempty"`
 	Files      map[string]string `json:"files,omitempty"`
+	Remote     string            `json:"remote,omitempty"`
 	Adapters   map[string]string `json:"adapters,omitempty"`
 	Template   string            `json:"template,omitempty"`
 	License    any               `json:"license,omitempty"`
@@ -309,12 +797,30 @@ type CreateRequest struct {
 	Parameters map[string]any    `json:"parameters,omitempty"`
 	Messages   []Message         `json:"messages,omitempty"`
 
+	// MergeAdapters are LoRA adapters which are merged into the weights of
+	// the model rather than applied when it's run.
+	MergeAdapters []MergeAdapter `json:"merge_adapters,omitempty"`
+
+	// ModelfileDigest is the SHA-256 digest of the Modelfile the request
+	// was made from, recorded in the provenance of the model.
+	ModelfileDigest string `json:"modelfile_digest,omitempty"`
+
 	// Deprecated: set the model name with Model instead
 	Name string `json:"name"`
 	// Deprecated: use Quantize instead
 	Quantization string `json:"quantization,omitempty"`
 }
 
+// MergeAdapter is a LoRA adapter of a [CreateRequest] which is merged into
+// the weights of the model.
+type MergeAdapter struct {
+	// Files maps the file names of the adapter to the digests of their blobs.
+	Files map[string]string `json:"files"`
+
+	// Weight scales the adapter before it's merged, defaulting to 1.
+	Weight float32 `json:"weight,omitempty"`
+}
+
 // DeleteRequest is the request passed to [Client.Delete].
 type DeleteRequest struct {
 	Model string `json:"model"`
@@ -351,6 +857,44 @@ type ShowResponse struct {
 	ProjectorInfo map[string]any `json:"projector_info,omitempty"`
 	Tensors       []Tensor       `json:"tensors,omitempty"`
 	ModifiedAt    time.Time      `json:"modified_at,omitempty"`
+
+	// Target is the model an alias resolves to and AliasChain is the
+	// aliases followed to reach it, starting with the requested name. Both
+	// are empty if the requested name isn't an alias.
+	Target     string   `json:"target,omitempty"`
+	AliasChain []string `json:"alias_chain,omitempty"`
+
+	// Offload is where the parts of the model are placed: by the runner
+	// that has it loaded, or as it would be loaded with the options of the
+	// request on the GPUs that are free otherwise.
+	Offload *OffloadPlan `json:"offload,omitempty"`
+
+	// Provenance is how the model was created. It's only recorded for
+	// models created by a server which records it.
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// RenderTemplateRequest is the request passed to [Client.RenderTemplate].
+// Messages are rendered as a chat request would be, or Prompt, System and
+// Suffix as a generate request would be if there are no messages. Template
+// overrides the template of the model and Think is as in [ChatRequest].
+type RenderTemplateRequest struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages,omitempty"`
+	Tools    `json:"tools,omitempty"`
+	Prompt   string `json:"prompt,omitempty"`
+	System   string `json:"system,omitempty"`
+	Suffix   string `json:"suffix,omitempty"`
+	Template string `json:"template,omitempty"`
+	Think    *bool  `json:"think,omitempty"`
+}
+
+// RenderTemplateResponse is the response from [Client.RenderTemplate].
+// Prompt is the exact prompt which would be sent to the model and Stop are
+// the stop sequences generation would end at.
+type RenderTemplateResponse struct {
+	Prompt string   `json:"prompt"`
+	Stop   []string `json:"stop,omitempty"`
 }
 
 // CopyRequest is the request passed to [Client.Copy].
@@ -359,6 +903,313 @@ type CopyRequest struct {
 	Destination string `json:"destination"`
 }
 
+// QuantizeRequest is the request passed to [Client.Quantize]. Type is the
+// quantization type, such as "q4_K_M", of the model created as Destination
+// from the weights of Source.
+type QuantizeRequest struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Type        string `json:"type"`
+	Stream      *bool  `json:"stream,omitempty"`
+
+	// Calibration is sample text which is run through Source, which must be
+	// unquantized, to find the weights which matter most to its output. It's
+	// required for some types such as "iq2_xs" and improves the quality of
+	// others at low bit widths.
+	Calibration []string `json:"calibration,omitempty"`
+}
+
+// AliasRequest is the request passed to [Client.SetAlias] and
+// [Client.DeleteAlias]. Target is the model or alias the alias refers to
+// and is ignored when deleting an alias.
+type AliasRequest struct {
+	Alias  string `json:"alias"`
+	Target string `json:"target,omitempty"`
+}
+
+// Alias is a single alias in [ListAliasesResponse].
+type Alias struct {
+	Alias  string `json:"alias"`
+	Target string `json:"target"`
+}
+
+// ListAliasesResponse is the response from [Client.ListAliases].
+type ListAliasesResponse struct {
+	Aliases []Alias `json:"aliases"`
+}
+
+// SplitRequest is the request passed to [Client.SetSplit] and
+// [Client.DeleteSplit]. Arms are the models the alias's requests are split
+// between, and are ignored when deleting a split.
+type SplitRequest struct {
+	Alias string     `json:"alias"`
+	Arms  []SplitArm `json:"arms,omitempty"`
+}
+
+// SplitArm is a model which a split sends a percentage of the requests to
+// its alias to.
+type SplitArm struct {
+	Model   string `json:"model"`
+	Percent int    `json:"percent"`
+}
+
+// Split is a single split in [ListSplitsResponse].
+type Split struct {
+	Alias string          `json:"alias"`
+	Arms  []SplitArmStats `json:"arms"`
+
+	// Since is when the metrics of the arms started to be counted, which
+	// is when the split was set or the server started.
+	Since time.Time `json:"since"`
+}
+
+// SplitArmStats is an arm of a split and the requests it was sent.
+type SplitArmStats struct {
+	SplitArm
+
+	Requests int64 `json:"requests"`
+
+	// Errors are the requests which failed with an error status.
+	Errors int64 `json:"errors"`
+
+	// Metrics are the sums of the metrics of the requests which generated
+	// text.
+	Metrics
+}
+
+// ListSplitsResponse is the response from [Client.ListSplits].
+type ListSplitsResponse struct {
+	Splits []Split `json:"splits"`
+}
+
+// Capture is a request and its response, recorded by a server with
+// OLLAMA_CAPTURE set and sent again by [Client.Replay].
+type Capture struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+
+	// Tenant is the namespace of the request's API key, if it has one.
+	Tenant string `json:"tenant,omitempty"`
+
+	// Request is the body of the request.
+	Request json.RawMessage `json:"request"`
+
+	// Status and Response are the status code and body of the response,
+	// which has a JSON object on each line if it was streamed.
+	Status   int           `json:"status"`
+	Response string        `json:"response"`
+	Duration time.Duration `json:"duration"`
+
+	// Truncated is set if the response was too long to be recorded in
+	// full.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// VRAMProfile is the VRAM a model was measured to take on a kind of GPU the
+// first time it was loaded on one, which the scheduler fits later loads of
+// the model on those GPUs with rather than its estimate alone. NumCtx and
+// NumParallel are the context length and parallel requests it was loaded
+// with.
+type VRAMProfile struct {
+	Model         string    `json:"model"`
+	Digest        string    `json:"digest"`
+	Library       string    `json:"library"`
+	GPU           string    `json:"gpu"`
+	NumCtx        int       `json:"num_ctx"`
+	NumParallel   int       `json:"num_parallel"`
+	EstimatedVRAM uint64    `json:"estimated_vram"`
+	MeasuredVRAM  uint64    `json:"measured_vram"`
+	MeasuredAt    time.Time `json:"measured_at"`
+}
+
+// ListProfilesResponse is the response from [Client.ListProfiles].
+type ListProfilesResponse struct {
+	Profiles []VRAMProfile `json:"profiles"`
+}
+
+// DeleteProfilesRequest is the request passed to [Client.DeleteProfiles].
+// Model is the model whose profiles are deleted, or all profiles are
+// deleted if it's empty.
+type DeleteProfilesRequest struct {
+	Model string `json:"model,omitempty"`
+}
+
+// DeleteProfilesResponse is the response from [Client.DeleteProfiles].
+type DeleteProfilesResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// ReadyResponse is the response from the /readyz endpoint. Status is
+// "ready" if every check passed and "not ready" otherwise.
+type ReadyResponse struct {
+	Status string       `json:"status"`
+	Checks []ReadyCheck `json:"checks"`
+}
+
+// ReadyCheck is the result of one of the checks of the /readyz endpoint.
+// Status is "ok" or "fail", with the reason for a failure in Error.
+type ReadyCheck struct {
+	Name    string         `json:"name"`
+	Status  string         `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// ConfigRequest is the request passed to [Client.SetConfig]. Settings maps
+// environment variables to their new values, where an empty value restores
+// the value the server started with.
+type ConfigRequest struct {
+	Settings map[string]string `json:"settings"`
+}
+
+// ConfigResponse is the response from [Client.Config] and [Client.SetConfig],
+// with the settings which can be changed while the server is running.
+type ConfigResponse struct {
+	Settings map[string]string `json:"settings"`
+
+	// Env is the value of every environment variable the server uses,
+	// including defaults, with secrets masked. Warnings are the problems
+	// with the environment, such as unknown variables, invalid values and
+	// deprecated names. Both are only returned by [Client.Config].
+	Env      map[string]string `json:"env,omitempty"`
+	Warnings []string          `json:"warnings,omitempty"`
+}
+
+// CollectionRequest is the request passed to [Client.CreateCollection] and
+// [Client.DeleteCollection]. Dimensions is the length of the embeddings of
+// the collection, or zero to use the length of the first embedding which is
+// upserted. Metric is how embeddings are compared when searching, "cosine"
+// by default or "ip" for their inner product. Both are ignored when deleting
+// a collection.
+type CollectionRequest struct {
+	Name       string `json:"name"`
+	Dimensions int    `json:"dimensions,omitempty"`
+	Metric     string `json:"metric,omitempty"`
+}
+
+// Collection is a single collection in [ListCollectionsResponse]. Count is
+// its number of points.
+type Collection struct {
+	Name       string `json:"name"`
+	Dimensions int    `json:"dimensions"`
+	Metric     string `json:"metric"`
+	Count      int    `json:"count"`
+}
+
+// ListCollectionsResponse is the response from [Client.ListCollections].
+type ListCollectionsResponse struct {
+	Collections []Collection `json:"collections"`
+}
+
+// CollectionPoint is an embedding stored in a collection along with its
+// metadata and optionally the text it's the embedding of.
+type CollectionPoint struct {
+	ID        string         `json:"id"`
+	Embedding []float32      `json:"embedding"`
+	Text      string         `json:"text,omitempty"`
+	Metadata  map[string]any `json:"metadata,omitempty"`
+}
+
+// UpsertPointsRequest is the request passed to [Client.UpsertPoints]. Points
+// replace the points of the collection with the same IDs.
+type UpsertPointsRequest struct {
+	Collection string            `json:"collection"`
+	Points     []CollectionPoint `json:"points"`
+}
+
+// DeletePointsRequest is the request passed to [Client.DeletePoints].
+type DeletePointsRequest struct {
+	Collection string   `json:"collection"`
+	IDs        []string `json:"ids"`
+}
+
+// SearchCollectionRequest is the request passed to [Client.SearchCollection].
+// It returns the Limit points most similar to Embedding, 10 if Limit is
+// zero. If Filter is set, only points whose metadata has each of its keys
+// are returned. A key matches if its value is equal to the value in Filter,
+// or to any of the values if that's an array.
+type SearchCollectionRequest struct {
+	Collection string         `json:"collection"`
+	Embedding  []float32      `json:"embedding"`
+	Limit      int            `json:"limit,omitempty"`
+	Filter     map[string]any `json:"filter,omitempty"`
+}
+
+// SearchCollectionResponse is the response from [Client.SearchCollection],
+// with the most similar points first.
+type SearchCollectionResponse struct {
+	Results []CollectionResult `json:"results"`
+}
+
+// CollectionResult is a point in [SearchCollectionResponse] and its
+// similarity to the embedding which was searched for.
+type CollectionResult struct {
+	ID       string         `json:"id"`
+	Score    float32        `json:"score"`
+	Text     string         `json:"text,omitempty"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// SaveChatRequest is the request passed to [Client.SaveChat].
+type SaveChatRequest struct {
+	Model    string         `json:"model"`
+	Messages []Message      `json:"messages"`
+	Options  map[string]any `json:"options,omitempty"`
+}
+
+// SavedChat is a conversation saved on the server, which clients can
+// resume by sending its messages to the model.
+type SavedChat struct {
+	Name      string         `json:"name"`
+	Model     string         `json:"model"`
+	Messages  []Message      `json:"messages"`
+	Options   map[string]any `json:"options,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// ListChatsRequest is the request passed to [Client.ListChats]. Query only
+// lists the chats with it in their name or the content of their messages,
+// ignoring case. Limit is the most chats to list, 20 if it's zero, after
+// skipping the first Offset.
+type ListChatsRequest struct {
+	Query  string
+	Limit  int
+	Offset int
+}
+
+// ListChatsResponse is the response from [Client.ListChats]. Total is the
+// number of chats which match the request, of which Chats are a page.
+type ListChatsResponse struct {
+	Chats []ChatSummary `json:"chats"`
+	Total int           `json:"total"`
+}
+
+// ChatSummary is a single chat in [ListChatsResponse].
+type ChatSummary struct {
+	Name      string    `json:"name"`
+	Model     string    `json:"model"`
+	Messages  int       `json:"messages"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AdapterResponse is a single model with a LoRA adapter in
+// [ListAdaptersResponse]. Digest is the digest of its adapter and Loaded
+// reports whether the adapter is loaded with a running model.
+type AdapterResponse struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest"`
+	Loaded bool   `json:"loaded"`
+}
+
+// ListAdaptersResponse is the response from [Client.ListAdapters].
+type ListAdaptersResponse struct {
+	Adapters []AdapterResponse `json:"adapters"`
+}
+
 // PullRequest is the request passed to [Client.Pull].
 type PullRequest struct {
 	Model    string `json:"model"`
@@ -367,6 +1218,10 @@ type PullRequest struct {
 	Password string `json:"password"`           // Deprecated: ignored
 	Stream   *bool  `json:"stream,omitempty"`
 
+	// MaxRate limits the download rate of this pull in bytes per second,
+	// e.g. "10MB". It can only lower the rate allowed by the server.
+	MaxRate string `json:"max_rate,omitempty"`
+
 	// Deprecated: set the model name with Model instead
 	Name string `json:"name"`
 }
@@ -380,6 +1235,25 @@ type ProgressResponse struct {
 	Completed int64  `json:"completed,omitempty"`
 }
 
+// PullStatus is the progress of a pull running on the server, which may have
+// been started by another client or resumed when the server started.
+type PullStatus struct {
+	Model     string    `json:"model"`
+	Status    string    `json:"status"`
+	Resumed   bool      `json:"resumed,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	Total     int64     `json:"total"`
+	Completed int64     `json:"completed"`
+
+	// Layers is the progress of each layer the pull has started
+	Layers []ProgressResponse `json:"layers,omitempty"`
+}
+
+// ListPullsResponse is the response from [Client.ListPulls].
+type ListPullsResponse struct {
+	Pulls []PullStatus `json:"pulls"`
+}
+
 // PushRequest is the request passed to [Client.Push].
 type PushRequest struct {
 	Model    string `json:"model"`
@@ -388,6 +1262,10 @@ type PushRequest struct {
 	Password string `json:"password"`
 	Stream   *bool  `json:"stream,omitempty"`
 
+	// OCI pushes the model as an OCI artifact which any OCI registry can
+	// store. Adapters are pushed as artifacts referring to the base model.
+	OCI bool `json:"oci,omitempty"`
+
 	// Deprecated: set the model name with Model instead
 	Name string `json:"name"`
 }
@@ -395,6 +1273,67 @@ type PushRequest struct {
 // ListResponse is the response from [Client.List].
 type ListResponse struct {
 	Models []ListModelResponse `json:"models"`
+
+	// Usage is the disk space used by each namespace of the model store.
+	Usage []NamespaceUsage `json:"usage,omitempty"`
+}
+
+// NamespaceUsage is the disk space used by the models in a namespace, such
+// as "library" for library/llama3.2. Blobs shared by several models in the
+// namespace are only counted once.
+type NamespaceUsage struct {
+	Namespace string `json:"namespace"`
+	Size      int64  `json:"size"`
+
+	// Quota is the most disk space the namespace may use. Zero means there
+	// is no quota.
+	Quota int64 `json:"quota,omitempty"`
+}
+
+// QuotaError is returned when pulling or creating a model would exceed the
+// disk quota of its namespace.
+type QuotaError struct {
+	Namespace string `json:"namespace"`
+	Quota     int64  `json:"quota"`
+	Used      int64  `json:"used"`
+	Required  int64  `json:"required"`
+}
+
+func (e QuotaError) Error() string {
+	return fmt.Sprintf("quota exceeded for namespace %q: %s required but %s of %s already used",
+		e.Namespace, format.HumanBytes(e.Required), format.HumanBytes(e.Used), format.HumanBytes(e.Quota))
+}
+
+// Is reports whether target is [ErrQuotaExceeded].
+func (e QuotaError) Is(target error) bool {
+	t, ok := target.(StatusError)
+	return ok && t.Code == ErrorCodeQuotaExceeded
+}
+
+// LimitError is returned when a request goes over one of the usage limits
+// of the server, such as the most num_ctx a request may ask for.
+type LimitError struct {
+	// Limit is what was limited: "num_ctx", "num_predict", "images" or
+	// "request_size".
+	Limit string `json:"limit"`
+	Max   int64  `json:"max"`
+
+	// Value is what the request asked for, if it's known.
+	Value int64 `json:"value,omitempty"`
+}
+
+func (e LimitError) Error() string {
+	if e.Value == 0 {
+		return fmt.Sprintf("%s is over the server's limit of %d", e.Limit, e.Max)
+	}
+
+	return fmt.Sprintf("%s of %d is over the server's limit of %d", e.Limit, e.Value, e.Max)
+}
+
+// Is reports whether target is [ErrLimitExceeded].
+func (e LimitError) Is(target error) bool {
+	t, ok := target.(StatusError)
+	return ok && t.Code == ErrorCodeLimitExceeded
 }
 
 // ProcessResponse is the response from [Client.Process].
@@ -402,6 +1341,79 @@ type ProcessResponse struct {
 	Models []ProcessModelResponse `json:"models"`
 }
 
+// PruneRequest is the request passed to [Client.Prune]. A model is removed if
+// any of the rules which are set selects it.
+type PruneRequest struct {
+	// UnusedFor removes models which haven't been used for this long.
+	UnusedFor *Duration `json:"unused_for,omitempty"`
+
+	// KeepTags removes all but this many of the most recently used tags of
+	// each model.
+	KeepTags int `json:"keep_tags,omitempty"`
+
+	// MaxSize removes the least recently used models until the model store
+	// is at most this many bytes.
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// DryRun reports the models which would be removed without removing them.
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// PruneResponse is the response from [Client.Prune].
+type PruneResponse struct {
+	Models []string `json:"models"`
+	Freed  int64    `json:"freed"`
+}
+
+// StoreStatsResponse is the response from [Client.StoreStats].
+type StoreStatsResponse struct {
+	// Models are the models in the store, those using the most bytes which
+	// no other model uses first.
+	Models []ModelStoreStats `json:"models"`
+
+	// Blobs is the number of blobs in the store.
+	Blobs int `json:"blobs"`
+
+	// Size is the number of bytes the blobs take up.
+	Size int64 `json:"size"`
+
+	// Logical is the number of bytes the models would take up if they
+	// didn't share blobs.
+	Logical int64 `json:"logical"`
+
+	// Unused is the number of bytes of blobs which no model uses.
+	Unused int64 `json:"unused"`
+}
+
+// ModelStoreStats is how much of the model store a model uses.
+type ModelStoreStats struct {
+	Name string `json:"name"`
+
+	// Size is the number of bytes of the model's blobs.
+	Size int64 `json:"size"`
+
+	// Unique is the number of bytes of blobs no other model uses, which
+	// deleting the model frees.
+	Unique int64 `json:"unique"`
+
+	// Shared is the number of bytes of blobs other models use too.
+	Shared int64 `json:"shared"`
+}
+
+// LinkBlobRequest is the request passed to [Client.LinkBlob].
+type LinkBlobRequest struct {
+	// Path is the absolute path of the file on the server's machine.
+	Path string `json:"path"`
+}
+
+// LinkBlobResponse is the response from [Client.LinkBlob].
+type LinkBlobResponse struct {
+	// Method is how the file was imported: "reflink" for a copy-on-write
+	// clone, "hardlink" for a hard link, or "existing" if the store
+	// already had the blob.
+	Method string `json:"method"`
+}
+
 // ListModelResponse is a single model description in [ListResponse].
 type ListModelResponse struct {
 	Name       string       `json:"name"`
@@ -412,6 +1424,41 @@ type ListModelResponse struct {
 	Details    ModelDetails `json:"details,omitempty"`
 }
 
+// SearchRequest is the request passed to [Client.Search].
+type SearchRequest struct {
+	// Query matches the names and descriptions of models. An empty query
+	// matches every model.
+	Query string `json:"query"`
+
+	// Capabilities only matches models with all of these capabilities, such
+	// as "vision" or "tools".
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// MaxSize only matches models with a parameter size of at most this
+	// many parameters, such as "8b".
+	MaxSize string `json:"max_size,omitempty"`
+
+	// Limit is the most models returned. Zero returns every match.
+	Limit int `json:"limit,omitempty"`
+}
+
+// SearchModel is a single model in [SearchResponse].
+type SearchModel struct {
+	Name           string    `json:"name"`
+	Description    string    `json:"description,omitempty"`
+	Tags           []string  `json:"tags,omitempty"`
+	ParameterSizes []string  `json:"parameter_sizes,omitempty"`
+	Quantizations  []string  `json:"quantizations,omitempty"`
+	Capabilities   []string  `json:"capabilities,omitempty"`
+	Pulls          int64     `json:"pulls,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// SearchResponse is the response from [Client.Search].
+type SearchResponse struct {
+	Models []SearchModel `json:"models"`
+}
+
 // ProcessModelResponse is a single model description in [ProcessResponse].
 type ProcessModelResponse struct {
 	Name      string       `json:"name"`
@@ -421,6 +1468,15 @@ type ProcessModelResponse struct {
 	Details   ModelDetails `json:"details,omitempty"`
 	ExpiresAt time.Time    `json:"expires_at"`
 	SizeVRAM  int64        `json:"size_vram"`
+
+	// FlashAttention and PagedAttention report whether the runner serving
+	// the model is using flash attention and a paged key/value cache.
+	FlashAttention bool `json:"flash_attention,omitempty"`
+	PagedAttention bool `json:"paged_attention,omitempty"`
+
+	// Offload is where the runner placed the parts of the model. It is set
+	// as soon as the model starts loading.
+	Offload *OffloadPlan `json:"offload,omitempty"`
 }
 
 type RetrieveModelResponse struct {
@@ -442,6 +1498,15 @@ type GenerateResponse struct {
 	// CreatedAt is the timestamp of the response.
 	CreatedAt time.Time `json:"created_at"`
 
+	// RequestID identifies the request, to cancel it with
+	// [Client.CancelRequest].
+	RequestID string `json:"request_id,omitempty"`
+
+	// Index is the completion of a request with N greater than one which
+	// the response is for. A streamed completion ends with a response with
+	// its DoneReason, and the request with a response which is Done.
+	Index int `json:"index,omitempty"`
+
 	// Response is the textual response itself.
 	Response string `json:"response"`
 
@@ -455,6 +1520,52 @@ type GenerateResponse struct {
 	// can be sent in the next request to keep a conversational memory.
 	Context []int `json:"context,omitempty"`
 
+	// Choices are all the completions of a request with N greater than
+	// one, when it isn't streamed. The other fields are the first of them.
+	Choices []GenerateChoice `json:"choices,omitempty"`
+
+	// Moderation is the verdict of the server's moderation model on the
+	// request and response. It's set on the last response, when the server
+	// is set to annotate responses.
+	Moderation *Moderation `json:"moderation,omitempty"`
+
+	// CacheHit is set on each response which the server's semantic cache
+	// returned, which was generated for a similar prompt rather than this
+	// one.
+	CacheHit bool `json:"cache_hit,omitempty"`
+
+	Metrics
+}
+
+// GenerateChoice is one of the completions of a [GenerateRequest].
+type GenerateChoice struct {
+	Index      int    `json:"index"`
+	Response   string `json:"response"`
+	DoneReason string `json:"done_reason,omitempty"`
+	Context    []int  `json:"context,omitempty"`
+}
+
+// FIMResponse is the response returned by [Client.FIM]. A streamed
+// insertion ends with a response with its DoneReason, and the request with a
+// response which is Done.
+type FIMResponse struct {
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+	RequestID string    `json:"request_id,omitempty"`
+
+	// Cursor is the index of the cursor which Response is inserted at.
+	Cursor int `json:"cursor"`
+
+	Response   string `json:"response"`
+	Done       bool   `json:"done"`
+	DoneReason string `json:"done_reason,omitempty"`
+
+	// Insertions are the text inserted at each cursor and Content is the
+	// content of the file with every insertion, in the response which is
+	// Done.
+	Insertions []string `json:"insertions,omitempty"`
+	Content    string   `json:"content,omitempty"`
+
 	Metrics
 }
 
@@ -468,6 +1579,49 @@ type ModelDetails struct {
 	QuantizationLevel string   `json:"quantization_level"`
 }
 
+// Provenance records how a model was created: what it was created from and
+// what was done to the weights along the way.
+type Provenance struct {
+	// Parent is the model the model was created from, if any.
+	Parent *ProvenanceParent `json:"parent,omitempty"`
+
+	// ModelfileDigest is the SHA-256 digest of the Modelfile the model was
+	// created with, after includes and build args were expanded. It's set
+	// by the client which created the model.
+	ModelfileDigest string `json:"modelfile_digest,omitempty"`
+
+	// Converter is the version of Ollama which converted the weights of the
+	// model to GGUF, if they were converted.
+	Converter string `json:"converter,omitempty"`
+
+	// Quantization is the quantization performed when the model was
+	// created, if any.
+	Quantization *ProvenanceQuantization `json:"quantization,omitempty"`
+
+	// SourceURL is where the weights of the model were read from to be
+	// converted, pinned to a revision where the source has them.
+	SourceURL string `json:"source_url,omitempty"`
+}
+
+// ProvenanceParent is the model another model was created from.
+type ProvenanceParent struct {
+	Model string `json:"model"`
+
+	// Digest is the digest of the manifest of the model, as listed by
+	// [Client.List].
+	Digest string `json:"digest"`
+
+	// Provenance is the provenance of the model itself, if it was recorded.
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// ProvenanceQuantization is a quantization of the weights of a model from
+// one file type to another, such as F16 to Q4_K_M.
+type ProvenanceQuantization struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
 // Tensor describes the metadata for a given tensor.
 type Tensor struct {
 	Name  string   `json:"name"`
@@ -565,6 +1719,24 @@ func (opts *Options) FromMap(m map[string]interface{}) error {
 				if !ok {
 					return fmt.Errorf("option %q must be of type array", key)
 				}
+
+				if field.Type().Elem().Kind() == reflect.Int {
+					// convert []interface{} to []int
+					slice := make([]int, len(val))
+					for i, item := range val {
+						switch n := item.(type) {
+						case int64:
+							slice[i] = int(n)
+						case float64:
+							slice[i] = int(n)
+						default:
+							return fmt.Errorf("option %q must be of an array of integers", key)
+						}
+					}
+					field.Set(reflect.ValueOf(slice))
+					continue
+				}
+
 				// convert []interface{} to []string
 				slice := make([]string, len(val))
 				for i, item := range val {
@@ -615,6 +1787,13 @@ func DefaultOptions() Options {
 		Mirostat:         0,
 		MirostatTau:      5.0,
 		MirostatEta:      0.1,
+		DryMultiplier:    0.0,
+		DryBase:          1.75,
+		DryAllowedLength: 2,
+		DryPenaltyLastN:  -1,
+		DryBreakers:      []string{"\n", ":", "\"", "*"},
+		XTCProbability:   0.0,
+		XTCThreshold:     0.1,
 		Seed:             -1,
 
 		Runner: Runner{
@@ -719,8 +1898,21 @@ func FormatParams(params map[string][]string) (map[string]interface{}, error) {
 				case reflect.String:
 					out[key] = vals[0]
 				case reflect.Slice:
-					// TODO: only string slices are supported right now
-					out[key] = vals
+					if field.Type().Elem().Kind() != reflect.Int {
+						out[key] = vals
+						continue
+					}
+
+					ints := make([]int, len(vals))
+					for i, val := range vals {
+						n, err := strconv.Atoi(val)
+						if err != nil {
+							return nil, fmt.Errorf("invalid int value %s", vals)
+						}
+						ints[i] = n
+					}
+
+					out[key] = ints
 				case reflect.Pointer:
 					var b bool
 					if field.Type() == reflect.TypeOf(&b) {
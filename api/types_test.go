@@ -209,6 +209,27 @@ func TestUseMmapFormatParams(t *testing.T) {
 	}
 }
 
+func TestStopTokens(t *testing.T) {
+	var oMap map[string]interface{}
+	err := json.Unmarshal([]byte(`{ "stop_tokens": [128001, 128009], "stop_regex": ["^User:"] }`), &oMap)
+	require.NoError(t, err)
+	opts := DefaultOptions()
+	err = opts.FromMap(oMap)
+	require.NoError(t, err)
+	assert.Equal(t, []int{128001, 128009}, opts.StopTokens)
+	assert.Equal(t, []string{"^User:"}, opts.StopRegex)
+
+	err = opts.FromMap(map[string]interface{}{"stop_tokens": []interface{}{"<|eot_id|>"}})
+	require.Error(t, err)
+
+	resp, err := FormatParams(map[string][]string{"stop_tokens": {"128001", "128009"}})
+	require.NoError(t, err)
+	assert.Equal(t, []int{128001, 128009}, resp["stop_tokens"])
+
+	_, err = FormatParams(map[string][]string{"stop_tokens": {"<|eot_id|>"}})
+	require.Equal(t, errors.New("invalid int value [<|eot_id|>]"), err)
+}
+
 func TestMessage_UnmarshalJSON(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -231,3 +252,30 @@ func TestMessage_UnmarshalJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestKVCacheType(t *testing.T) {
+	opts := DefaultOptions()
+	require.NoError(t, opts.FromMap(map[string]interface{}{"kv_cache_type": "q8_0"}))
+	assert.Equal(t, "q8_0", opts.KVCacheType)
+
+	resp, err := FormatParams(map[string][]string{"kv_cache_type": {"q4_0"}})
+	require.NoError(t, err)
+	assert.Equal(t, "q4_0", resp["kv_cache_type"])
+}
+
+func TestAttentionOptions(t *testing.T) {
+	opts := DefaultOptions()
+	assert.Nil(t, opts.FlashAttention)
+	require.NoError(t, opts.FromMap(map[string]interface{}{"flash_attention": false, "paged_attention": true}))
+	if assert.NotNil(t, opts.FlashAttention) {
+		assert.False(t, *opts.FlashAttention)
+	}
+	assert.True(t, opts.PagedAttention)
+}
+
+func TestEncoderCache(t *testing.T) {
+	opts := DefaultOptions()
+	assert.Empty(t, opts.EncoderCache)
+	require.NoError(t, opts.FromMap(map[string]interface{}{"encoder_cache": "host"}))
+	assert.Equal(t, "host", opts.EncoderCache)
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
@@ -18,11 +19,13 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
 	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/containerd/console"
@@ -34,7 +37,9 @@ import (
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/eval"
 	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/mcp"
 	"github.com/ollama/ollama/parser"
 	"github.com/ollama/ollama/progress"
 	"github.com/ollama/ollama/runner"
@@ -95,6 +100,22 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	buildArgs := make(map[string]string)
+	flags, _ := cmd.Flags().GetStringArray("build-arg")
+	for _, flag := range flags {
+		name, value, ok := strings.Cut(flag, "=")
+		if !ok {
+			return fmt.Errorf("invalid build arg %q, expected name=value", flag)
+		}
+
+		buildArgs[name] = value
+	}
+
+	modelfile, err = modelfile.Expand(filepath.Dir(filename), buildArgs)
+	if err != nil {
+		return err
+	}
+
 	status := "gathering model components"
 	spinner := progress.NewSpinner(status)
 	p.Add(status, spinner)
@@ -106,6 +127,7 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 	spinner.Stop()
 
 	req.Name = args[0]
+	req.ModelfileDigest = fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(modelfile.String())))
 	quantize, _ := cmd.Flags().GetString("quantize")
 	if quantize != "" {
 		req.Quantize = quantize
@@ -116,10 +138,11 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	link, _ := cmd.Flags().GetBool("link")
 	if len(req.Files) > 0 {
 		fileMap := map[string]string{}
 		for f, digest := range req.Files {
-			if _, err := createBlob(cmd, client, f, digest, p); err != nil {
+			if _, err := createBlob(cmd, client, f, digest, p, link); err != nil {
 				return err
 			}
 			fileMap[filepath.Base(f)] = digest
@@ -130,7 +153,7 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 	if len(req.Adapters) > 0 {
 		fileMap := map[string]string{}
 		for f, digest := range req.Adapters {
-			if _, err := createBlob(cmd, client, f, digest, p); err != nil {
+			if _, err := createBlob(cmd, client, f, digest, p, link); err != nil {
 				return err
 			}
 			fileMap[filepath.Base(f)] = digest
@@ -138,6 +161,17 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 		req.Adapters = fileMap
 	}
 
+	for i, adapter := range req.MergeAdapters {
+		fileMap := map[string]string{}
+		for f, digest := range adapter.Files {
+			if _, err := createBlob(cmd, client, f, digest, p, link); err != nil {
+				return err
+			}
+			fileMap[filepath.Base(f)] = digest
+		}
+		req.MergeAdapters[i].Files = fileMap
+	}
+
 	bars := make(map[string]*progress.Bar)
 	fn := func(resp api.ProgressResponse) error {
 		if resp.Digest != "" {
@@ -148,6 +182,19 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 				p.Add(resp.Digest, bar)
 			}
 
+			bar.Set(resp.Completed)
+		} else if resp.Total > 0 {
+			// remote models report the progress of converting them
+			bar, ok := bars[resp.Status]
+			if !ok {
+				spinner.Stop()
+
+				status = resp.Status
+				bar = progress.NewBar(status, resp.Total, resp.Completed)
+				bars[status] = bar
+				p.Add(status, bar)
+			}
+
 			bar.Set(resp.Completed)
 		} else if status != resp.Status {
 			spinner.Stop()
@@ -170,12 +217,35 @@ func CreateHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func createBlob(cmd *cobra.Command, client *api.Client, path string, digest string, p *progress.Progress) (string, error) {
+func createBlob(cmd *cobra.Command, client *api.Client, path string, digest string, p *progress.Progress, link bool) (string, error) {
 	realPath, err := filepath.EvalSymlinks(path)
 	if err != nil {
 		return "", err
 	}
 
+	if link {
+		absPath, err := filepath.Abs(realPath)
+		if err != nil {
+			return "", err
+		}
+
+		status := fmt.Sprintf("linking file %s", digest)
+		spinner := progress.NewSpinner(status)
+		p.Add(status, spinner)
+
+		// servers on other machines, or which can't link the file, are
+		// sent a copy
+		resp, err := client.LinkBlob(cmd.Context(), digest, &api.LinkBlobRequest{Path: absPath})
+		if err == nil {
+			spinner.SetMessage(fmt.Sprintf("linking file %s (%s)", digest, resp.Method))
+			spinner.Stop()
+			return digest, nil
+		}
+
+		spinner.SetMessage(fmt.Sprintf("couldn't link file %s, copying it instead: %v", digest, err))
+		spinner.Stop()
+	}
+
 	bin, err := os.Open(realPath)
 	if err != nil {
 		return "", err
@@ -247,13 +317,86 @@ func loadOrUnloadModel(cmd *cobra.Command, opts *runOptions) error {
 	return client.Generate(cmd.Context(), req, func(api.GenerateResponse) error { return nil })
 }
 
+// saveChat saves the conversation of opts on the server as name, so it can
+// be resumed with loadChat, here or from another client.
+func saveChat(cmd *cobra.Command, name string, opts runOptions) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.SaveChat(cmd.Context(), name, &api.SaveChatRequest{
+		Model:    opts.Model,
+		Messages: opts.Messages,
+		Options:  opts.Options,
+	})
+	return err
+}
+
+// loadChat replaces the conversation of opts with the one saved on the
+// server as name, and loads its model.
+func loadChat(cmd *cobra.Command, name string, opts *runOptions) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	chat, err := client.GetChat(cmd.Context(), name)
+	if err != nil {
+		return err
+	}
+
+	opts.Model = chat.Model
+	opts.Messages = chat.Messages
+	if chat.Options != nil {
+		opts.Options = chat.Options
+	}
+
+	return loadOrUnloadModel(cmd, opts)
+}
+
+// listChats prints the conversations saved on the server which match query.
+func listChats(cmd *cobra.Command, query string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.ListChats(cmd.Context(), &api.ListChatsRequest{Query: query})
+	if err != nil {
+		return err
+	}
+
+	var data [][]string
+	for _, c := range resp.Chats {
+		data = append(data, []string{c.Name, c.Model, strconv.Itoa(c.Messages), format.HumanTime(c.UpdatedAt, "Never")})
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"NAME", "MODEL", "MESSAGES", "SAVED"})
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("    ")
+	table.AppendBulk(data)
+	table.Render()
+
+	if resp.Total > len(resp.Chats) {
+		fmt.Printf("... and %d more\n", resp.Total-len(resp.Chats))
+	}
+
+	return nil
+}
+
 func StopHandler(cmd *cobra.Command, args []string) error {
 	opts := &runOptions{
 		Model:     args[0],
 		KeepAlive: &api.Duration{Duration: 0},
 	}
 	if err := loadOrUnloadModel(cmd, opts); err != nil {
-		if strings.Contains(err.Error(), "not found") {
+		if errors.Is(err, api.ErrModelNotFound) {
 			return fmt.Errorf("couldn't find model \"%s\" to stop", args[0])
 		}
 		return err
@@ -276,6 +419,11 @@ func RunHandler(cmd *cobra.Command, args []string) error {
 	}
 	opts.Format = format
 
+	opts.JSONStream, err = cmd.Flags().GetBool("json-stream")
+	if err != nil {
+		return err
+	}
+
 	keepAlive, err := cmd.Flags().GetString("keepalive")
 	if err != nil {
 		return err
@@ -288,27 +436,53 @@ func RunHandler(cmd *cobra.Command, args []string) error {
 		opts.KeepAlive = &api.Duration{Duration: d}
 	}
 
-	prompts := args[1:]
-	// prepend stdin to the prompt if provided
+	stdinAs, err := cmd.Flags().GetString("stdin-as")
+	if err != nil {
+		return err
+	}
+
+	stdinTemplate, err := cmd.Flags().GetString("stdin-template")
+	if err != nil {
+		return err
+	}
+
+	opts.Prompt = strings.Join(args[1:], " ")
+	if len(args) > 1 {
+		interactive = false
+	}
+
+	// combine stdin with the prompt if provided
+	var input []byte
 	if !term.IsTerminal(int(os.Stdin.Fd())) {
-		in, err := io.ReadAll(os.Stdin)
+		input, err = io.ReadAll(os.Stdin)
 		if err != nil {
 			return err
 		}
 
-		prompts = append([]string{string(in)}, prompts...)
+		switch stdinAs {
+		case "text":
+			opts.Prompt, err = stdinPrompt(stdinTemplate, opts.Prompt, string(input))
+			if err != nil {
+				return err
+			}
+		case "image":
+			opts.Images = append(opts.Images, api.ImageData(input))
+		default:
+			return fmt.Errorf("unknown --stdin-as %q, must be text or image", stdinAs)
+		}
+
 		opts.WordWrap = false
 		interactive = false
 	}
-	opts.Prompt = strings.Join(prompts, " ")
-	if len(prompts) > 0 {
-		interactive = false
-	}
 	// Be quiet if we're redirecting to a pipe or file
 	if !term.IsTerminal(int(os.Stdout.Fd())) {
 		interactive = false
 	}
 
+	if opts.JSONStream && interactive {
+		return errors.New("--json-stream needs a prompt, as an argument or on standard input")
+	}
+
 	nowrap, err := cmd.Flags().GetBool("nowordwrap")
 	if err != nil {
 		return err
@@ -345,12 +519,42 @@ func RunHandler(cmd *cobra.Command, args []string) error {
 	for k := range info.ModelInfo {
 		if strings.Contains(k, ".vision.") {
 			opts.MultiModal = true
-			break
+		}
+		if strings.Contains(k, ".audio.") {
+			opts.Audio = true
 		}
 	}
 
 	opts.ParentModel = info.Details.ParentModel
 
+	if len(opts.Images) > 0 && !opts.MultiModal {
+		return fmt.Errorf("%s doesn't accept images", name)
+	}
+
+	if stdinAs == "text" && len(input) > 0 {
+		fitContext(&opts, info)
+	}
+
+	mcpConfig, err := cmd.Flags().GetString("mcp")
+	if err != nil {
+		return err
+	}
+
+	if mcpConfig != "" {
+		config, err := mcp.LoadConfig(mcpConfig)
+		if err != nil {
+			return err
+		}
+
+		session, err := mcp.Connect(cmd.Context(), config)
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+
+		opts.MCP = session
+	}
+
 	if interactive {
 		if err := loadOrUnloadModel(cmd, &opts); err != nil {
 			return err
@@ -370,9 +574,65 @@ func RunHandler(cmd *cobra.Command, args []string) error {
 
 		return generateInteractive(cmd, opts)
 	}
+
+	if opts.MCP != nil {
+		// tools can only be called in chats
+		opts.Messages = append(opts.Messages, api.Message{Role: "user", Content: opts.Prompt, Images: opts.Images})
+		_, err := chat(cmd, opts)
+		return err
+	}
+
 	return generate(cmd, opts)
 }
 
+// stdinPrompt combines input piped to ollama run with the prompt given as
+// arguments using tmpl, whose fields are .Prompt and .Input. By default the
+// prompt comes first, followed by the input.
+func stdinPrompt(tmpl, prompt, input string) (string, error) {
+	if tmpl == "" {
+		tmpl = "{{ if .Prompt }}{{ .Prompt }}\n\n{{ end }}{{ .Input }}"
+	}
+
+	t, err := template.New("stdin").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid --stdin-template: %w", err)
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, struct{ Prompt, Input string }{prompt, input}); err != nil {
+		return "", fmt.Errorf("invalid --stdin-template: %w", err)
+	}
+
+	return b.String(), nil
+}
+
+// fitContext raises num_ctx for prompts too long for the default context
+// length, up to the context length of the model, since the runner cuts off
+// prompts which don't fit. Prompts are estimated to be a token for every 3
+// bytes, which is more than most text has, with room left for the response.
+func fitContext(opts *runOptions, info *api.ShowResponse) {
+	if _, ok := opts.Options["num_ctx"]; ok {
+		return
+	}
+
+	tokens := len(opts.Prompt) / 3
+	numCtx := tokens + 1024
+	if numCtx <= int(envconfig.ContextLength()) {
+		return
+	}
+
+	// round up so small differences in input don't reload the model
+	numCtx = (numCtx + 1023) / 1024 * 1024
+
+	arch, _ := info.ModelInfo["general.architecture"].(string)
+	if contextLength, ok := info.ModelInfo[arch+".context_length"].(float64); ok && numCtx > int(contextLength) {
+		fmt.Fprintf(os.Stderr, "warning: the input is about %d tokens, more than the context length of %s, so some of it will be cut off\n", tokens, opts.Model)
+		numCtx = int(contextLength)
+	}
+
+	opts.Options["num_ctx"] = numCtx
+}
+
 func PushHandler(cmd *cobra.Command, args []string) error {
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
@@ -384,6 +644,11 @@ func PushHandler(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	oci, err := cmd.Flags().GetBool("oci")
+	if err != nil {
+		return err
+	}
+
 	p := progress.NewProgress(os.Stderr)
 	defer p.Stop()
 
@@ -418,7 +683,7 @@ func PushHandler(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	request := api.PushRequest{Name: args[0], Insecure: insecure}
+	request := api.PushRequest{Name: args[0], Insecure: insecure, OCI: oci}
 
 	n := model.ParseName(args[0])
 	if err := client.Push(cmd.Context(), &request, fn); err != nil {
@@ -477,6 +742,110 @@ func ListHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func StoreStatsHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	stats, err := client.StoreStats(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	var data [][]string
+	for _, m := range stats.Models {
+		data = append(data, []string{m.Name, format.HumanBytes(m.Size), format.HumanBytes(m.Unique), format.HumanBytes(m.Shared)})
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"NAME", "SIZE", "UNIQUE", "SHARED"})
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("    ")
+	table.AppendBulk(data)
+	table.Render()
+
+	fmt.Println()
+	fmt.Printf("%d models in %d blobs take up %s", len(stats.Models), stats.Blobs, format.HumanBytes(stats.Size))
+	if saved := stats.Logical - (stats.Size - stats.Unused); saved > 0 {
+		fmt.Printf(", sharing blobs saves %s", format.HumanBytes(saved))
+	}
+	fmt.Println()
+
+	if stats.Unused > 0 {
+		fmt.Printf("%s of blobs aren't used by any model and are removed when the server restarts\n", format.HumanBytes(stats.Unused))
+	}
+
+	return nil
+}
+
+func SearchHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	capabilities, err := cmd.Flags().GetStringSlice("capability")
+	if err != nil {
+		return err
+	}
+
+	maxSize, err := cmd.Flags().GetString("max-size")
+	if err != nil {
+		return err
+	}
+
+	limit, err := cmd.Flags().GetInt("limit")
+	if err != nil {
+		return err
+	}
+
+	req := api.SearchRequest{
+		Query:        strings.Join(args, " "),
+		Capabilities: capabilities,
+		MaxSize:      maxSize,
+		Limit:        limit,
+	}
+
+	resp, err := client.Search(cmd.Context(), &req)
+	if err != nil {
+		return err
+	}
+
+	if len(resp.Models) == 0 {
+		fmt.Println("no models found")
+		return nil
+	}
+
+	var data [][]string
+	for _, m := range resp.Models {
+		data = append(data, []string{
+			m.Name,
+			strings.Join(m.ParameterSizes, ", "),
+			strings.Join(m.Capabilities, ", "),
+			format.HumanNumber(uint64(m.Pulls)),
+			format.HumanTime(m.UpdatedAt, "Never"),
+		})
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"NAME", "SIZES", "CAPABILITIES", "PULLS", "UPDATED"})
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("    ")
+	table.AppendBulk(data)
+	table.Render()
+
+	return nil
+}
+
 func ListRunningHandler(cmd *cobra.Command, args []string) error {
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
@@ -543,7 +912,7 @@ func DeleteHandler(cmd *cobra.Command, args []string) error {
 		KeepAlive: &api.Duration{Duration: 0},
 	}
 	if err := loadOrUnloadModel(cmd, opts); err != nil {
-		if !strings.Contains(err.Error(), "not found") {
+		if !errors.Is(err, api.ErrModelNotFound) {
 			return fmt.Errorf("unable to stop existing running model \"%s\": %s", args[0], err)
 		}
 	}
@@ -558,6 +927,156 @@ func DeleteHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func PruneHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	unusedFor, err := cmd.Flags().GetDuration("unused-for")
+	if err != nil {
+		return err
+	}
+
+	keepTags, err := cmd.Flags().GetInt("keep-tags")
+	if err != nil {
+		return err
+	}
+
+	maxSize, err := cmd.Flags().GetString("max-size")
+	if err != nil {
+		return err
+	}
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+
+	req := api.PruneRequest{KeepTags: keepTags, DryRun: dryRun}
+	if unusedFor > 0 {
+		req.UnusedFor = &api.Duration{Duration: unusedFor}
+	}
+
+	if maxSize != "" {
+		req.MaxSize, err = format.ParseBytes(maxSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	resp, err := client.Prune(cmd.Context(), &req)
+	if err != nil {
+		return err
+	}
+
+	verb := "deleted"
+	if dryRun {
+		verb = "would delete"
+	}
+
+	for _, name := range resp.Models {
+		fmt.Printf("%s '%s'\n", verb, name)
+	}
+
+	if dryRun {
+		fmt.Printf("%s would be freed\n", format.HumanBytes(resp.Freed))
+	} else {
+		fmt.Printf("freed %s\n", format.HumanBytes(resp.Freed))
+	}
+
+	return nil
+}
+
+// EvalHandler runs suites of questions against a model and writes a report
+// of how many it answered correctly.
+func EvalHandler(cmd *cobra.Command, args []string) error {
+	names, err := cmd.Flags().GetStringSlice("suite")
+	if err != nil {
+		return err
+	}
+
+	judge, err := cmd.Flags().GetString("judge")
+	if err != nil {
+		return err
+	}
+
+	limit, err := cmd.Flags().GetInt("limit")
+	if err != nil {
+		return err
+	}
+
+	noCache, err := cmd.Flags().GetBool("no-cache")
+	if err != nil {
+		return err
+	}
+
+	reportFormat, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+
+	if reportFormat != "markdown" && reportFormat != "json" {
+		return fmt.Errorf("unknown format %q, must be markdown or json", reportFormat)
+	}
+
+	var suites []*eval.Suite
+	for _, name := range names {
+		suite, err := eval.LoadSuite(name)
+		if err != nil {
+			return err
+		}
+		suites = append(suites, suite)
+	}
+
+	opts := eval.Options{Model: args[0], Judge: judge, Limit: limit}
+	if !noCache {
+		opts.CacheDir, err = eval.DefaultCacheDir()
+		if err != nil {
+			return err
+		}
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	spinner := progress.NewSpinner("")
+	p.Add("", spinner)
+	opts.Progress = func(suite string, done, total int) {
+		spinner.SetMessage(fmt.Sprintf("evaluating %s %d/%d", suite, done, total))
+	}
+
+	report, err := eval.Run(cmd.Context(), client, suites, opts)
+	p.StopAndClear()
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if reportFormat == "json" {
+		return report.WriteJSON(w)
+	}
+
+	return report.WriteMarkdown(w)
+}
+
 func ShowHandler(cmd *cobra.Command, args []string) error {
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
@@ -570,8 +1089,11 @@ func ShowHandler(cmd *cobra.Command, args []string) error {
 	system, errSystem := cmd.Flags().GetBool("system")
 	template, errTemplate := cmd.Flags().GetBool("template")
 	verbose, errVerbose := cmd.Flags().GetBool("verbose")
+	render, errRender := cmd.Flags().GetBool("render-template")
+	provenance, errProvenance := cmd.Flags().GetBool("provenance")
+	sbom, errSBOM := cmd.Flags().GetBool("sbom")
 
-	for _, boolErr := range []error{errLicense, errModelfile, errParams, errSystem, errTemplate, errVerbose} {
+	for _, boolErr := range []error{errLicense, errModelfile, errParams, errSystem, errTemplate, errVerbose, errRender, errProvenance, errSBOM} {
 		if boolErr != nil {
 			return errors.New("error retrieving flags")
 		}
@@ -600,39 +1122,157 @@ func ShowHandler(cmd *cobra.Command, args []string) error {
 		showType = "system"
 	}
 
-	if template {
-		flagsSet++
-		showType = "template"
-	}
+	if template {
+		flagsSet++
+		showType = "template"
+	}
+
+	if render {
+		flagsSet++
+		showType = "render-template"
+	}
+
+	if provenance {
+		flagsSet++
+		showType = "provenance"
+	}
+
+	if sbom {
+		flagsSet++
+		showType = "sbom"
+	}
+
+	if flagsSet > 1 {
+		return errors.New("only one of '--license', '--modelfile', '--parameters', '--system', '--template', '--render-template', '--provenance', or '--sbom' can be specified")
+	}
+
+	switch showType {
+	case "render-template":
+		return renderTemplate(cmd, client, args[0])
+	case "sbom":
+		resp, err := client.SBOM(cmd.Context(), &api.SBOMRequest{Model: args[0]})
+		if err != nil {
+			return err
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(resp)
+	}
+
+	req := api.ShowRequest{Name: args[0], Verbose: verbose}
+	resp, err := client.Show(cmd.Context(), &req)
+	if err != nil {
+		return err
+	}
+
+	if flagsSet == 1 {
+		switch showType {
+		case "license":
+			fmt.Println(resp.License)
+		case "modelfile":
+			fmt.Println(resp.Modelfile)
+		case "parameters":
+			fmt.Println(resp.Parameters)
+		case "system":
+			fmt.Print(resp.System)
+		case "template":
+			fmt.Print(resp.Template)
+		case "provenance":
+			return showProvenance(args[0], resp.Provenance, os.Stdout)
+		}
+
+		return nil
+	}
+
+	return showInfo(resp, verbose, os.Stdout)
+}
+
+// showProvenance prints how model was created, followed by how each model it
+// was created from was created in turn.
+func showProvenance(model string, p *api.Provenance, w io.Writer) error {
+	if p == nil {
+		_, err := fmt.Fprintf(w, "No provenance is recorded for %s\n", model)
+		return err
+	}
+
+	for p != nil {
+		fmt.Fprintln(w, " ", model)
+		table := tablewriter.NewWriter(w)
+		table.SetAlignment(tablewriter.ALIGN_LEFT)
+		table.SetBorder(false)
+		table.SetNoWhiteSpace(true)
+		table.SetTablePadding("    ")
+		table.SetColWidth(100)
+
+		if p.ModelfileDigest != "" {
+			table.Append([]string{"", "modelfile", p.ModelfileDigest})
+		}
+		if p.SourceURL != "" {
+			table.Append([]string{"", "source", p.SourceURL})
+		}
+		if p.Converter != "" {
+			table.Append([]string{"", "converter", p.Converter})
+		}
+		if p.Quantization != nil {
+			table.Append([]string{"", "quantization", p.Quantization.From + " -> " + p.Quantization.To})
+		}
+
+		next := p.Parent
+		p = nil
+		if next != nil {
+			table.Append([]string{"", "parent", next.Model})
+			table.Append([]string{"", "parent digest", next.Digest})
+			model, p = next.Model, next.Provenance
+		}
+
+		table.Render()
+		fmt.Fprintln(w)
+	}
+
+	return nil
+}
 
-	if flagsSet > 1 {
-		return errors.New("only one of '--license', '--modelfile', '--parameters', '--system', or '--template' can be specified")
+// renderTemplate prints the prompt which the template of model renders for
+// the --messages file, which is either a list of messages or a chat request
+// with messages and tools. Stop sequences are printed to stderr.
+func renderTemplate(cmd *cobra.Command, client *api.Client, model string) error {
+	var req api.RenderTemplateRequest
+	if path, _ := cmd.Flags().GetString("messages"); path != "" {
+		bts, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if s := strings.TrimSpace(string(bts)); strings.HasPrefix(s, "[") {
+			err = json.Unmarshal(bts, &req.Messages)
+		} else {
+			err = json.Unmarshal(bts, &req)
+		}
+
+		if err != nil {
+			return fmt.Errorf("invalid messages file %s: %w", path, err)
+		}
 	}
 
-	req := api.ShowRequest{Name: args[0], Verbose: verbose}
-	resp, err := client.Show(cmd.Context(), &req)
+	req.Model = model
+	resp, err := client.RenderTemplate(cmd.Context(), &req)
 	if err != nil {
 		return err
 	}
 
-	if flagsSet == 1 {
-		switch showType {
-		case "license":
-			fmt.Println(resp.License)
-		case "modelfile":
-			fmt.Println(resp.Modelfile)
-		case "parameters":
-			fmt.Println(resp.Parameters)
-		case "system":
-			fmt.Print(resp.System)
-		case "template":
-			fmt.Print(resp.Template)
+	fmt.Print(resp.Prompt)
+
+	if len(resp.Stop) > 0 {
+		stop := make([]string, len(resp.Stop))
+		for i, s := range resp.Stop {
+			stop[i] = strconv.Quote(s)
 		}
 
-		return nil
+		fmt.Fprintf(os.Stderr, "\n\nstop: %s\n", strings.Join(stop, " "))
 	}
 
-	return showInfo(resp, verbose, os.Stdout)
+	return nil
 }
 
 func showInfo(resp *api.ShowResponse, verbose bool, w io.Writer) error {
@@ -645,7 +1285,7 @@ func showInfo(resp *api.ShowResponse, verbose bool, w io.Writer) error {
 		table.SetTablePadding("    ")
 
 		switch header {
-		case "Template", "System", "License":
+		case "Alias", "Template", "System", "License":
 			table.SetColWidth(100)
 		}
 
@@ -654,6 +1294,14 @@ func showInfo(resp *api.ShowResponse, verbose bool, w io.Writer) error {
 		fmt.Fprintln(w)
 	}
 
+	if resp.Target != "" {
+		tableRender("Alias", func() (rows [][]string) {
+			rows = append(rows, []string{"", "target", resp.Target})
+			rows = append(rows, []string{"", "chain", strings.Join(append(resp.AliasChain, resp.Target), " -> ")})
+			return
+		})
+	}
+
 	tableRender("Model", func() (rows [][]string) {
 		if resp.ModelInfo != nil {
 			arch := resp.ModelInfo["general.architecture"].(string)
@@ -770,12 +1418,147 @@ func CopyHandler(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func QuantizeHandler(cmd *cobra.Command, args []string) error {
+	quantizeType, err := cmd.Flags().GetString("type")
+	if err != nil {
+		return err
+	}
+
+	calibrationFile, err := cmd.Flags().GetString("calibration")
+	if err != nil {
+		return err
+	}
+
+	// samples of calibration data are separated by blank lines
+	var calibration []string
+	if calibrationFile != "" {
+		bts, err := os.ReadFile(calibrationFile)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range strings.Split(strings.ReplaceAll(string(bts), "\r\n", "\n"), "\n\n") {
+			if s = strings.TrimSpace(s); s != "" {
+				calibration = append(calibration, s)
+			}
+		}
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	p := progress.NewProgress(os.Stderr)
+	defer p.Stop()
+
+	bars := make(map[string]*progress.Bar)
+
+	var status string
+	var spinner *progress.Spinner
+
+	fn := func(resp api.ProgressResponse) error {
+		if resp.Total > 0 {
+			if spinner != nil {
+				spinner.Stop()
+			}
+
+			bar, ok := bars[resp.Status]
+			if !ok {
+				bar = progress.NewBar(resp.Status, resp.Total, resp.Completed)
+				bars[resp.Status] = bar
+				p.Add(resp.Status, bar)
+			}
+
+			bar.Set(resp.Completed)
+		} else if status != resp.Status {
+			if spinner != nil {
+				spinner.Stop()
+			}
+
+			status = resp.Status
+			spinner = progress.NewSpinner(status)
+			p.Add(status, spinner)
+		}
+
+		return nil
+	}
+
+	req := api.QuantizeRequest{Source: args[0], Destination: args[1], Type: quantizeType, Calibration: calibration}
+	return client.Quantize(cmd.Context(), &req, fn)
+}
+
+func SetAliasHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	req := api.AliasRequest{Alias: args[0], Target: args[1]}
+	if err := client.SetAlias(cmd.Context(), &req); err != nil {
+		return err
+	}
+	fmt.Printf("'%s' now refers to '%s'\n", args[0], args[1])
+	return nil
+}
+
+func DeleteAliasHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	for _, alias := range args {
+		req := api.AliasRequest{Alias: alias}
+		if err := client.DeleteAlias(cmd.Context(), &req); err != nil {
+			return err
+		}
+		fmt.Printf("deleted alias '%s'\n", alias)
+	}
+	return nil
+}
+
+func ListAliasesHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.ListAliases(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	var data [][]string
+	for _, a := range resp.Aliases {
+		data = append(data, []string{a.Alias, a.Target})
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"ALIAS", "TARGET"})
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("    ")
+	table.AppendBulk(data)
+	table.Render()
+
+	return nil
+}
+
 func PullHandler(cmd *cobra.Command, args []string) error {
 	insecure, err := cmd.Flags().GetBool("insecure")
 	if err != nil {
 		return err
 	}
 
+	maxRate, err := cmd.Flags().GetString("max-rate")
+	if err != nil {
+		return err
+	}
+
 	client, err := api.ClientFromEnvironment()
 	if err != nil {
 		return err
@@ -816,7 +1599,7 @@ func PullHandler(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	request := api.PullRequest{Name: args[0], Insecure: insecure}
+	request := api.PullRequest{Name: args[0], Insecure: insecure, MaxRate: maxRate}
 	if err := client.Pull(cmd.Context(), &request, fn); err != nil {
 		return err
 	}
@@ -837,7 +1620,72 @@ type runOptions struct {
 	Images      []api.ImageData
 	Options     map[string]interface{}
 	MultiModal  bool
+	Audio       bool
 	KeepAlive   *api.Duration
+
+	// JSONStream writes responses as JSON lines of [streamFrame] rather
+	// than text
+	JSONStream bool
+
+	// MCP are the tools of the MCP servers given with --mcp, which are
+	// called for the model when it asks
+	MCP *mcp.Session
+}
+
+// maxToolRounds limits how many times in a row a model is run again with
+// the results of the tools it called, in case it never stops calling them.
+const maxToolRounds = 10
+
+// streamFrame is a line written by ollama run --json-stream.
+type streamFrame struct {
+	// Type is "token" for generated text, "done" at the end of a response,
+	// or "error" if it failed
+	Type      string         `json:"type"`
+	Text      string         `json:"text,omitempty"`
+	Thinking  string         `json:"thinking,omitempty"`
+	ToolCalls []api.ToolCall `json:"tool_calls,omitempty"`
+	Error     string         `json:"error,omitempty"`
+
+	// Elapsed is the time since the request was sent
+	Elapsed time.Duration `json:"elapsed"`
+
+	DoneReason string       `json:"done_reason,omitempty"`
+	Metrics    *api.Metrics `json:"metrics,omitempty"`
+}
+
+// streamWriter writes the frames of a response for --json-stream.
+type streamWriter struct {
+	start time.Time
+	enc   *json.Encoder
+}
+
+func newStreamWriter(w io.Writer) *streamWriter {
+	return &streamWriter{start: time.Now(), enc: json.NewEncoder(w)}
+}
+
+func (w *streamWriter) write(f streamFrame) error {
+	f.Elapsed = time.Since(w.start)
+	return w.enc.Encode(f)
+}
+
+// token writes a frame of generated text, unless there's none.
+func (w *streamWriter) token(text, thinking string, toolCalls []api.ToolCall) error {
+	if text == "" && thinking == "" && len(toolCalls) == 0 {
+		return nil
+	}
+	return w.write(streamFrame{Type: "token", Text: text, Thinking: thinking, ToolCalls: toolCalls})
+}
+
+func (w *streamWriter) done(doneReason string, metrics api.Metrics) error {
+	return w.write(streamFrame{Type: "done", DoneReason: doneReason, Metrics: &metrics})
+}
+
+// fail writes a frame for err and returns it.
+func (w *streamWriter) fail(err error) error {
+	if werr := w.write(streamFrame{Type: "error", Error: err.Error()}); werr != nil {
+		return errors.Join(err, werr)
+	}
+	return err
 }
 
 type displayResponseState struct {
@@ -920,6 +1768,12 @@ func chat(cmd *cobra.Command, opts runOptions) (*api.Message, error) {
 	var latest api.ChatResponse
 	var fullResponse strings.Builder
 	var role string
+	var toolCalls []api.ToolCall
+
+	var stream *streamWriter
+	if opts.JSONStream {
+		stream = newStreamWriter(os.Stdout)
+	}
 
 	fn := func(response api.ChatResponse) error {
 		p.StopAndClear()
@@ -929,6 +1783,11 @@ func chat(cmd *cobra.Command, opts runOptions) (*api.Message, error) {
 		role = response.Message.Role
 		content := response.Message.Content
 		fullResponse.WriteString(content)
+		toolCalls = append(toolCalls, response.Message.ToolCalls...)
+
+		if stream != nil {
+			return stream.token(content, response.Message.Thinking, response.Message.ToolCalls)
+		}
 
 		displayResponse(content, opts.WordWrap, state)
 
@@ -950,11 +1809,52 @@ func chat(cmd *cobra.Command, opts runOptions) (*api.Message, error) {
 		req.KeepAlive = opts.KeepAlive
 	}
 
-	if err := client.Chat(cancelCtx, req, fn); err != nil {
-		if errors.Is(err, context.Canceled) {
-			return nil, nil
+	if opts.MCP != nil {
+		req.Tools = opts.MCP.Tools()
+		req.Messages = slices.Clone(req.Messages)
+	}
+
+	for round := 0; ; round++ {
+		if err := client.Chat(cancelCtx, req, fn); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil, nil
+			}
+			if stream != nil {
+				return nil, stream.fail(err)
+			}
+			return nil, err
 		}
-		return nil, err
+
+		if opts.MCP == nil || len(toolCalls) == 0 {
+			break
+		}
+
+		if round == maxToolRounds {
+			return nil, fmt.Errorf("the model called tools %d times in a row without answering", maxToolRounds)
+		}
+
+		req.Messages = append(req.Messages, api.Message{Role: "assistant", Content: fullResponse.String(), ToolCalls: toolCalls})
+		for _, call := range toolCalls {
+			fmt.Fprintf(os.Stderr, "Calling %s(%s)\n", call.Function.Name, call.Function.Arguments.String())
+
+			// the model is told when a tool fails, so it can try another way
+			result, err := opts.MCP.Call(cancelCtx, call)
+			if err != nil {
+				result = "Error: " + err.Error()
+			}
+
+			req.Messages = append(req.Messages, api.Message{Role: "tool", Content: result, Name: call.Function.Name, ToolCallID: call.ID})
+		}
+
+		toolCalls = nil
+		fullResponse.Reset()
+	}
+
+	if stream != nil {
+		if err := stream.done(latest.DoneReason, latest.Metrics); err != nil {
+			return nil, err
+		}
+		return &api.Message{Role: role, Content: fullResponse.String()}, nil
 	}
 
 	if len(opts.Messages) > 0 {
@@ -1006,22 +1906,33 @@ func generate(cmd *cobra.Command, opts runOptions) error {
 
 	var state *displayResponseState = &displayResponseState{}
 
+	var stream *streamWriter
+	if opts.JSONStream {
+		stream = newStreamWriter(os.Stdout)
+	}
+
 	fn := func(response api.GenerateResponse) error {
 		p.StopAndClear()
 
 		latest = response
 		content := response.Response
 
+		if stream != nil {
+			return stream.token(content, "", nil)
+		}
+
 		displayResponse(content, opts.WordWrap, state)
 
 		return nil
 	}
 
 	if opts.MultiModal {
-		opts.Prompt, opts.Images, err = extractFileData(opts.Prompt)
+		var images []api.ImageData
+		opts.Prompt, images, err = extractFileData(opts.Prompt)
 		if err != nil {
 			return err
 		}
+		opts.Images = append(opts.Images, images...)
 	}
 
 	if opts.Format == "json" {
@@ -1043,9 +1954,16 @@ func generate(cmd *cobra.Command, opts runOptions) error {
 		if errors.Is(err, context.Canceled) {
 			return nil
 		}
+		if stream != nil {
+			return stream.fail(err)
+		}
 		return err
 	}
 
+	if stream != nil {
+		return stream.done(latest.DoneReason, latest.Metrics)
+	}
+
 	if opts.Prompt != "" {
 		fmt.Println()
 		fmt.Println()
@@ -1075,12 +1993,20 @@ func RunServer(_ *cobra.Command, _ []string) error {
 		return err
 	}
 
-	ln, err := net.Listen("tcp", envconfig.Host().Host)
+	// systemd passes the socket of an ollama.socket unit
+	ln, err := server.ActivationListener()
 	if err != nil {
 		return err
 	}
 
-	err = server.Serve(ln)
+	if ln == nil {
+		ln, err = net.Listen("tcp", envconfig.Host().Host)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = serve(ln)
 	if errors.Is(err, http.ErrServerClosed) {
 		return nil
 	}
@@ -1223,6 +2149,8 @@ func NewCLI() *cobra.Command {
 
 	createCmd.Flags().StringP("file", "f", "", "Name of the Modelfile (default \"Modelfile\"")
 	createCmd.Flags().StringP("quantize", "q", "", "Quantize model to this level (e.g. q4_0)")
+	createCmd.Flags().StringArray("build-arg", nil, "Set a build arg declared by ARG in the Modelfile (e.g. quant=q8_0)")
+	createCmd.Flags().Bool("link", false, "Link model files into the model store rather than copying them, if the server is on this machine")
 
 	showCmd := &cobra.Command{
 		Use:     "show MODEL",
@@ -1238,6 +2166,10 @@ func NewCLI() *cobra.Command {
 	showCmd.Flags().Bool("template", false, "Show template of a model")
 	showCmd.Flags().Bool("system", false, "Show system message of a model")
 	showCmd.Flags().BoolP("verbose", "v", false, "Show detailed model information")
+	showCmd.Flags().Bool("render-template", false, "Show the prompt the template of a model renders, without running it")
+	showCmd.Flags().String("messages", "", "JSON file of the messages to render with --render-template")
+	showCmd.Flags().Bool("provenance", false, "Show how a model was created and the models it was created from")
+	showCmd.Flags().Bool("sbom", false, "Show a CycloneDX bill of materials of a model")
 
 	runCmd := &cobra.Command{
 		Use:     "run MODEL [PROMPT]",
@@ -1252,6 +2184,10 @@ func NewCLI() *cobra.Command {
 	runCmd.Flags().Bool("insecure", false, "Use an insecure registry")
 	runCmd.Flags().Bool("nowordwrap", false, "Don't wrap words to the next line automatically")
 	runCmd.Flags().String("format", "", "Response format (e.g. json)")
+	runCmd.Flags().Bool("json-stream", false, "Write the response as JSON lines of tokens and timings rather than text")
+	runCmd.Flags().String("stdin-as", "text", "How to use input piped to standard input, text or image")
+	runCmd.Flags().String("stdin-template", "", "Template combining piped input with the prompt, using {{ .Prompt }} and {{ .Input }} (default: the prompt, then the input)")
+	runCmd.Flags().String("mcp", "", "JSON file of MCP servers whose tools the model can call")
 
 	stopCmd := &cobra.Command{
 		Use:     "stop MODEL",
@@ -1278,6 +2214,7 @@ func NewCLI() *cobra.Command {
 	}
 
 	pullCmd.Flags().Bool("insecure", false, "Use an insecure registry")
+	pullCmd.Flags().String("max-rate", "", "Limit the download rate per second, e.g. 10MB")
 
 	pushCmd := &cobra.Command{
 		Use:     "push MODEL",
@@ -1288,6 +2225,7 @@ func NewCLI() *cobra.Command {
 	}
 
 	pushCmd.Flags().Bool("insecure", false, "Use an insecure registry")
+	pushCmd.Flags().Bool("oci", false, "Push the model as an OCI artifact")
 
 	listCmd := &cobra.Command{
 		Use:     "list",
@@ -1297,6 +2235,17 @@ func NewCLI() *cobra.Command {
 		RunE:    ListHandler,
 	}
 
+	searchCmd := &cobra.Command{
+		Use:     "search [TERM...]",
+		Short:   "Search for models in the registry",
+		PreRunE: checkServerHeartbeat,
+		RunE:    SearchHandler,
+	}
+
+	searchCmd.Flags().StringSlice("capability", nil, "Only show models with this capability, e.g. vision, tools or embedding")
+	searchCmd.Flags().String("max-size", "", "Only show models with at most this many parameters, e.g. 8b")
+	searchCmd.Flags().Int("limit", 0, "Show at most this many models")
+
 	psCmd := &cobra.Command{
 		Use:     "ps",
 		Short:   "List running models",
@@ -1312,6 +2261,17 @@ func NewCLI() *cobra.Command {
 		RunE:    CopyHandler,
 	}
 
+	quantizeCmd := &cobra.Command{
+		Use:     "quantize SOURCE DESTINATION",
+		Short:   "Create a model from another quantized to a different type",
+		Args:    cobra.ExactArgs(2),
+		PreRunE: checkServerHeartbeat,
+		RunE:    QuantizeHandler,
+	}
+
+	quantizeCmd.Flags().StringP("type", "t", "q4_K_M", "Quantization type, e.g. q4_0, q4_K_M or q8_0")
+	quantizeCmd.Flags().String("calibration", "", "File of sample text, separated by blank lines, to calibrate the quantization with")
+
 	deleteCmd := &cobra.Command{
 		Use:     "rm MODEL [MODEL...]",
 		Short:   "Remove a model",
@@ -1320,6 +2280,126 @@ func NewCLI() *cobra.Command {
 		RunE:    DeleteHandler,
 	}
 
+	pruneCmd := &cobra.Command{
+		Use:     "prune",
+		Short:   "Remove unused models",
+		Args:    cobra.ExactArgs(0),
+		PreRunE: checkServerHeartbeat,
+		RunE:    PruneHandler,
+	}
+
+	pruneCmd.Flags().Duration("unused-for", 0, "Remove models which haven't been used for this long, e.g. 720h")
+	pruneCmd.Flags().Int("keep-tags", 0, "Keep only this many of the most recently used tags of each model")
+	pruneCmd.Flags().String("max-size", "", "Remove the least recently used models until the model store fits in this size, e.g. 100GB")
+	pruneCmd.Flags().Bool("dry-run", false, "Show the models which would be removed without removing them")
+
+	storeCmd := &cobra.Command{
+		Use:   "store",
+		Short: "Inspect the model store",
+	}
+
+	storeStatsCmd := &cobra.Command{
+		Use:     "stats",
+		Short:   "Show how much of the model store each model uses",
+		Args:    cobra.ExactArgs(0),
+		PreRunE: checkServerHeartbeat,
+		RunE:    StoreStatsHandler,
+	}
+
+	storeCmd.AddCommand(storeStatsCmd)
+
+	evalCmd := &cobra.Command{
+		Use:     "eval MODEL",
+		Short:   "Score a model on suites of questions",
+		Args:    cobra.ExactArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    EvalHandler,
+	}
+
+	evalCmd.Flags().StringSlice("suite", []string{"mmlu-mini", "gsm8k-mini"}, "Built-in suites or JSON lines files of questions to ask, e.g. mmlu-mini,questions.jsonl")
+	evalCmd.Flags().String("judge", "", "Model which scores the answers, rather than matching them with the expected answers")
+	evalCmd.Flags().Int("limit", 0, "Ask at most this many questions from each suite")
+	evalCmd.Flags().Bool("no-cache", false, "Ask every question again, rather than using the results of earlier runs")
+	evalCmd.Flags().String("format", "markdown", "Report format, markdown or json")
+	evalCmd.Flags().StringP("output", "o", "", "File to write the report to, rather than standard output")
+
+	replayCmd := &cobra.Command{
+		Use:     "replay CAPTURE...",
+		Short:   "Send captured requests again and compare the responses",
+		Long:    "Send requests captured with OLLAMA_CAPTURE again and compare the responses. Each CAPTURE is a capture file or a directory of them.",
+		Args:    cobra.MinimumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    ReplayHandler,
+	}
+
+	aliasCmd := &cobra.Command{
+		Use:   "alias",
+		Short: "Manage model aliases",
+	}
+
+	aliasSetCmd := &cobra.Command{
+		Use:     "set ALIAS MODEL",
+		Short:   "Point an alias at a model or another alias",
+		Args:    cobra.ExactArgs(2),
+		PreRunE: checkServerHeartbeat,
+		RunE:    SetAliasHandler,
+	}
+
+	aliasDeleteCmd := &cobra.Command{
+		Use:     "rm ALIAS [ALIAS...]",
+		Short:   "Remove an alias",
+		Args:    cobra.MinimumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    DeleteAliasHandler,
+	}
+
+	aliasListCmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List aliases",
+		Args:    cobra.ExactArgs(0),
+		PreRunE: checkServerHeartbeat,
+		RunE:    ListAliasesHandler,
+	}
+
+	aliasCmd.AddCommand(aliasSetCmd, aliasDeleteCmd, aliasListCmd)
+
+	serviceCmd := &cobra.Command{
+		Use:   "service",
+		Short: "Manage the Ollama Windows service",
+	}
+
+	serviceInstallCmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install ollama serve as a Windows service which starts with the system",
+		Args:  cobra.ExactArgs(0),
+		RunE:  ServiceInstallHandler,
+	}
+	serviceInstallCmd.Flags().StringArray("env", nil, "Environment variable for the service, as KEY=VALUE (may be repeated)")
+
+	serviceUninstallCmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: "Stop and remove the Windows service",
+		Args:  cobra.ExactArgs(0),
+		RunE:  ServiceUninstallHandler,
+	}
+
+	serviceStartCmd := &cobra.Command{
+		Use:   "start",
+		Short: "Start the Windows service",
+		Args:  cobra.ExactArgs(0),
+		RunE:  ServiceStartHandler,
+	}
+
+	serviceStopCmd := &cobra.Command{
+		Use:   "stop",
+		Short: "Stop the Windows service once the requests in flight finish",
+		Args:  cobra.ExactArgs(0),
+		RunE:  ServiceStopHandler,
+	}
+
+	serviceCmd.AddCommand(serviceInstallCmd, serviceUninstallCmd, serviceStartCmd, serviceStopCmd)
+
 	runnerCmd := &cobra.Command{
 		Use:    "runner",
 		Hidden: true,
@@ -1344,9 +2424,18 @@ func NewCLI() *cobra.Command {
 		pullCmd,
 		pushCmd,
 		listCmd,
+		searchCmd,
 		psCmd,
 		copyCmd,
+		quantizeCmd,
 		deleteCmd,
+		pruneCmd,
+		storeStatsCmd,
+		evalCmd,
+		replayCmd,
+		aliasSetCmd,
+		aliasDeleteCmd,
+		aliasListCmd,
 		serveCmd,
 	} {
 		switch cmd {
@@ -1354,17 +2443,39 @@ func NewCLI() *cobra.Command {
 			appendEnvDocs(cmd, []envconfig.EnvVar{envVars["OLLAMA_HOST"], envVars["OLLAMA_NOHISTORY"]})
 		case serveCmd:
 			appendEnvDocs(cmd, []envconfig.EnvVar{
+				envVars["OLLAMA_BLOB_CACHE_SIZE"],
+				envVars["OLLAMA_BLOB_STORE"],
+				envVars["OLLAMA_CA_BUNDLE"],
+				envVars["OLLAMA_CAPTURE"],
+				envVars["OLLAMA_CAPTURE_MAX"],
+				envVars["OLLAMA_CAPTURE_REDACT"],
 				envVars["OLLAMA_DEBUG"],
+				envVars["OLLAMA_DOWNLOAD_SCHEDULE"],
 				envVars["OLLAMA_HOST"],
 				envVars["OLLAMA_KEEP_ALIVE"],
+				envVars["OLLAMA_MAX_DOWNLOAD_RATE"],
 				envVars["OLLAMA_MAX_LOADED_MODELS"],
+				envVars["OLLAMA_MAX_LOADED_LORAS"],
 				envVars["OLLAMA_MAX_QUEUE"],
+				envVars["OLLAMA_MAX_UPLOAD_RATE"],
 				envVars["OLLAMA_MODELS"],
+				envVars["OLLAMA_NAMESPACE_QUOTAS"],
 				envVars["OLLAMA_NUM_PARALLEL"],
 				envVars["OLLAMA_NOPRUNE"],
 				envVars["OLLAMA_ORIGINS"],
+				envVars["OLLAMA_ORIGINS_MAX_AGE"],
+				envVars["OLLAMA_PEERS"],
+				envVars["OLLAMA_PRUNE_INTERVAL"],
+				envVars["OLLAMA_PRUNE_UNUSED_FOR"],
+				envVars["OLLAMA_PRUNE_KEEP_TAGS"],
+				envVars["OLLAMA_PRUNE_MAX_SIZE"],
+				envVars["OLLAMA_REGISTRIES"],
 				envVars["OLLAMA_SCHED_SPREAD"],
-				envVars["OLLAMA_TMPDIR"],
+				envVars["OLLAMA_SERVE_BLOBS"],
+				envVars["OLLAMA_SYSTEM_PREAMBLE"],
+				envVars["OLLAMA_SYSTEM_POSTAMBLE"],
+				envVars["OLLAMA_SYSTEM_POLICY"],
+				envVars["OLLAMA_UPLOAD_CONCURRENCY"],
 				envVars["OLLAMA_FLASH_ATTENTION"],
 				envVars["OLLAMA_KV_CACHE_TYPE"],
 				envVars["OLLAMA_LLM_LIBRARY"],
@@ -1385,9 +2496,17 @@ func NewCLI() *cobra.Command {
 		pullCmd,
 		pushCmd,
 		listCmd,
+		searchCmd,
 		psCmd,
 		copyCmd,
+		quantizeCmd,
 		deleteCmd,
+		pruneCmd,
+		storeCmd,
+		evalCmd,
+		replayCmd,
+		aliasCmd,
+		serviceCmd,
 		runnerCmd,
 	)
 
@@ -3,7 +3,10 @@ package cmd
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -36,6 +39,36 @@ func TestShowInfo(t *testing.T) {
     parameters      7B      
     quantization    FP16    
 
+`
+
+		if diff := cmp.Diff(expect, b.String()); diff != "" {
+			t.Errorf("unexpected output (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("alias", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := showInfo(&api.ShowResponse{
+			Details: api.ModelDetails{
+				Family:            "test",
+				ParameterSize:     "7B",
+				QuantizationLevel: "FP16",
+			},
+			Target:     "test:7b",
+			AliasChain: []string{"app:latest", "test:stable"},
+		}, false, &b); err != nil {
+			t.Fatal(err)
+		}
+
+		expect := `  Alias
+    target    test:7b                                 
+    chain     app:latest -> test:stable -> test:7b    
+
+  Model
+    architecture    test    
+    parameters      7B      
+    quantization    FP16    
+
 `
 
 		if diff := cmp.Diff(expect, b.String()); diff != "" {
@@ -258,6 +291,53 @@ Weigh anchor!
 	})
 }
 
+func TestShowProvenance(t *testing.T) {
+	t.Run("none", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := showProvenance("test", nil, &b); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff("No provenance is recorded for test\n", b.String()); diff != "" {
+			t.Errorf("unexpected output (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("lineage", func(t *testing.T) {
+		var b bytes.Buffer
+		if err := showProvenance("test", &api.Provenance{
+			ModelfileDigest: "sha256:abc",
+			Quantization:    &api.ProvenanceQuantization{From: "F16", To: "Q4_K_M"},
+			Parent: &api.ProvenanceParent{
+				Model:  "base",
+				Digest: "def",
+				Provenance: &api.Provenance{
+					SourceURL: "https://huggingface.co/org/model/tree/123",
+					Converter: "ollama 0.1.0",
+				},
+			},
+		}, &b); err != nil {
+			t.Fatal(err)
+		}
+
+		expect := `  test
+    modelfile        sha256:abc       
+    quantization     F16 -> Q4_K_M    
+    parent           base             
+    parent digest    def              
+
+  base
+    source       https://huggingface.co/org/model/tree/123    
+    converter    ollama 0.1.0                                 
+
+`
+
+		if diff := cmp.Diff(expect, b.String()); diff != "" {
+			t.Errorf("unexpected output (-want +got):\n%s", diff)
+		}
+	})
+}
+
 func TestDeleteHandler(t *testing.T) {
 	stopped := false
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -496,6 +576,7 @@ func TestPushHandler(t *testing.T) {
 
 			cmd := &cobra.Command{}
 			cmd.Flags().Bool("insecure", false, "")
+			cmd.Flags().Bool("oci", false, "")
 			cmd.SetContext(context.TODO())
 
 			// Redirect stderr to capture progress output
@@ -664,6 +745,10 @@ func TestCreateHandler(t *testing.T) {
 						t.Errorf("expected from 'foo', got %s", req.From)
 					}
 
+					if want := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("FROM foo\n"))); req.ModelfileDigest != want {
+						t.Errorf("expected modelfile digest %s, got %s", want, req.ModelfileDigest)
+					}
+
 					responses := []api.ProgressResponse{
 						{Status: "using existing layer sha256:56bb8bd477a519ffa694fc449c2413c6f0e1d3b1c88fa7e3c9d88d3ae49d4dcb"},
 						{Status: "writing manifest"},
@@ -886,3 +971,146 @@ func TestNewCreateRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerateJSONStream(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/generate" {
+			http.NotFound(w, r)
+			return
+		}
+
+		var req api.GenerateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Model != "test-model" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "model not found"})
+			return
+		}
+
+		enc := json.NewEncoder(w)
+		for _, s := range []string{"Hello", ", world"} {
+			enc.Encode(api.GenerateResponse{Model: req.Model, Response: s})
+		}
+		enc.Encode(api.GenerateResponse{Model: req.Model, Done: true, DoneReason: "stop", Metrics: api.Metrics{EvalCount: 2}})
+	}))
+	defer mockServer.Close()
+
+	t.Setenv("OLLAMA_HOST", mockServer.URL)
+
+	run := func(model string) ([]streamFrame, error) {
+		t.Helper()
+
+		cmd := &cobra.Command{}
+		cmd.SetContext(context.TODO())
+		cmd.Flags().Bool("verbose", false, "")
+
+		oldStdout := os.Stdout
+		r, w, _ := os.Pipe()
+		os.Stdout = w
+
+		err := generate(cmd, runOptions{Model: model, Prompt: "hi", JSONStream: true})
+
+		w.Close()
+		os.Stdout = oldStdout
+
+		var frames []streamFrame
+		dec := json.NewDecoder(r)
+		for {
+			var f streamFrame
+			if err := dec.Decode(&f); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			f.Elapsed = 0
+			frames = append(frames, f)
+		}
+
+		return frames, err
+	}
+
+	frames, err := run("test-model")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []streamFrame{
+		{Type: "token", Text: "Hello"},
+		{Type: "token", Text: ", world"},
+		{Type: "done", DoneReason: "stop", Metrics: &api.Metrics{EvalCount: 2}},
+	}
+	if diff := cmp.Diff(frames, want); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	frames, err = run("unknown")
+	if err == nil || len(frames) != 1 || frames[0].Type != "error" || frames[0].Error != "model not found" {
+		t.Errorf("expected an error frame, got %+v and %v", frames, err)
+	}
+}
+
+func TestStdinPrompt(t *testing.T) {
+	cases := []struct {
+		tmpl, prompt, input, want string
+	}{
+		{"", "summarize:", "some text", "summarize:\n\nsome text"},
+		{"", "", "some text", "some text"},
+		{"{{ .Input }}\n\nQuestion: {{ .Prompt }}", "why?", "some text", "some text\n\nQuestion: why?"},
+	}
+
+	for _, tt := range cases {
+		got, err := stdinPrompt(tt.tmpl, tt.prompt, tt.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.tmpl, tt.want, got)
+		}
+	}
+
+	big := strings.Repeat("a", 8<<20)
+	if got, err := stdinPrompt("", "", big); err != nil || len(got) != len(big) {
+		t.Errorf("expected all of the input, got %d bytes and %v", len(got), err)
+	}
+
+	if _, err := stdinPrompt("{{ .Input", "", ""); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+}
+
+func TestFitContext(t *testing.T) {
+	t.Setenv("OLLAMA_CONTEXT_LENGTH", "")
+
+	info := &api.ShowResponse{ModelInfo: map[string]any{
+		"general.architecture": "llama",
+		"llama.context_length": float64(32768),
+	}}
+
+	cases := []struct {
+		prompt int
+		want   any
+	}{
+		{100, nil},
+		{30000, 11264},
+		{1 << 20, 32768},
+	}
+
+	for _, tt := range cases {
+		opts := runOptions{Model: "test", Prompt: strings.Repeat("a", tt.prompt), Options: map[string]any{}}
+		fitContext(&opts, info)
+		if got := opts.Options["num_ctx"]; got != tt.want {
+			t.Errorf("%d bytes: expected num_ctx %v, got %v", tt.prompt, tt.want, got)
+		}
+	}
+
+	opts := runOptions{Prompt: strings.Repeat("a", 30000), Options: map[string]any{"num_ctx": 4096}}
+	if fitContext(&opts, info); opts.Options["num_ctx"] != 4096 {
+		t.Errorf("expected num_ctx to be left alone, got %v", opts.Options["num_ctx"])
+	}
+}
@@ -17,7 +17,6 @@ import (
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/readline"
-	"github.com/ollama/ollama/types/errtypes"
 	"github.com/ollama/ollama/types/model"
 )
 
@@ -36,6 +35,9 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 		fmt.Fprintln(os.Stderr, "  /show           Show model information")
 		fmt.Fprintln(os.Stderr, "  /load <model>   Load a session or model")
 		fmt.Fprintln(os.Stderr, "  /save <model>   Save your current session")
+		fmt.Fprintln(os.Stderr, "  /load chat <name>  Resume a chat saved on the server")
+		fmt.Fprintln(os.Stderr, "  /save chat <name>  Save this chat on the server")
+		fmt.Fprintln(os.Stderr, "  /chats [search] List the chats saved on the server")
 		fmt.Fprintln(os.Stderr, "  /clear          Clear session context")
 		fmt.Fprintln(os.Stderr, "  /bye            Exit")
 		fmt.Fprintln(os.Stderr, "  /?, /help       Help for a command")
@@ -47,6 +49,10 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 			fmt.Fprintf(os.Stderr, "Use %s to include .jpg or .png images.\n", filepath.FromSlash("/path/to/file"))
 		}
 
+		if opts.Audio {
+			fmt.Fprintf(os.Stderr, "Use %s to include .wav audio.\n", filepath.FromSlash("/path/to/file"))
+		}
+
 		fmt.Fprintln(os.Stderr, "")
 	}
 
@@ -106,6 +112,8 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 		fmt.Fprintln(os.Stderr, "  /set parameter temperature <float>    Set creativity level")
 		fmt.Fprintln(os.Stderr, "  /set parameter repeat_penalty <float> How strongly to penalize repetitions")
 		fmt.Fprintln(os.Stderr, "  /set parameter repeat_last_n <int>    Set how far back to look for repetitions")
+		fmt.Fprintln(os.Stderr, "  /set parameter dry_multiplier <float> How strongly to penalize repeated sequences")
+		fmt.Fprintln(os.Stderr, "  /set parameter mirostat <int>         Use Mirostat sampling (0 = disabled)")
 		fmt.Fprintln(os.Stderr, "  /set parameter num_gpu <int>          The number of layers to send to the GPU")
 		fmt.Fprintln(os.Stderr, "  /set parameter stop <string> <string> ...   Set the stop parameters")
 		fmt.Fprintln(os.Stderr, "")
@@ -191,6 +199,41 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 			if err := ListHandler(cmd, args[1:]); err != nil {
 				return err
 			}
+		case strings.HasPrefix(line, "/chats"):
+			if err := listChats(cmd, strings.TrimSpace(strings.TrimPrefix(line, "/chats"))); err != nil {
+				fmt.Printf("error: %v\n", err)
+			}
+			continue
+		case strings.HasPrefix(line, "/load chat "):
+			args := strings.Fields(line)
+			if len(args) != 3 {
+				fmt.Println("Usage:\n  /load chat <name>")
+				continue
+			}
+			if err := loadChat(cmd, args[2], &opts); err != nil {
+				if errors.Is(err, api.ErrNotFound) || errors.Is(err, api.ErrModelNotFound) {
+					fmt.Printf("error: %v\n", err)
+					continue
+				}
+				return err
+			}
+			fmt.Printf("Loaded chat '%s' with %d messages using model '%s'\n", args[2], len(opts.Messages), opts.Model)
+			continue
+		case strings.HasPrefix(line, "/save chat "):
+			args := strings.Fields(line)
+			if len(args) != 3 {
+				fmt.Println("Usage:\n  /save chat <name>")
+				continue
+			}
+			if err := saveChat(cmd, args[2], opts); err != nil {
+				if errors.Is(err, api.ErrInvalidRequest) {
+					fmt.Printf("error: %v\n", err)
+					continue
+				}
+				return err
+			}
+			fmt.Printf("Saved chat '%s'\n", args[2])
+			continue
 		case strings.HasPrefix(line, "/load"):
 			args := strings.Fields(line)
 			if len(args) != 2 {
@@ -201,7 +244,7 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 			opts.Messages = []api.Message{}
 			fmt.Printf("Loading model '%s'\n", opts.Model)
 			if err := loadOrUnloadModel(cmd, &opts); err != nil {
-				if strings.Contains(err.Error(), "not found") {
+				if errors.Is(err, api.ErrModelNotFound) {
 					fmt.Printf("error: %v\n", err)
 					continue
 				}
@@ -225,7 +268,7 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 			fn := func(resp api.ProgressResponse) error { return nil }
 			err = client.Create(cmd.Context(), req, fn)
 			if err != nil {
-				if strings.Contains(err.Error(), errtypes.InvalidModelNameErrMsg) {
+				if errors.Is(err, api.ErrInvalidModelName) {
 					fmt.Printf("error: The model name '%s' is invalid\n", args[1])
 					continue
 				}
@@ -455,6 +498,16 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 				newMessage.Images = images
 			}
 
+			if opts.Audio {
+				msg, audio, err := extractAudioData(newMessage.Content)
+				if err != nil {
+					return err
+				}
+
+				newMessage.Content = msg
+				newMessage.Audio = audio
+			}
+
 			opts.Messages = append(opts.Messages, newMessage)
 
 			assistant, err := chat(cmd, opts)
@@ -470,6 +523,48 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 	}
 }
 
+var audioFileRegex = regexp.MustCompile(`(?:[a-zA-Z]:)?(?:\./|/|\\)[\S\\ ]+?\.(?i:wav)\b`)
+
+// extractAudioData removes the paths of audio files from input and returns
+// the audio in them. Paths which don't exist are left in place.
+func extractAudioData(input string) (string, []api.AudioData, error) {
+	var audio []api.AudioData
+	for _, fp := range audioFileRegex.FindAllString(input, -1) {
+		data, err := getAudioData(fp)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't process audio: %q\n", err)
+			return "", audio, err
+		}
+
+		fmt.Fprintf(os.Stderr, "Added audio '%s'\n", fp)
+		input = strings.ReplaceAll(input, "'"+fp+"'", "")
+		input = strings.ReplaceAll(input, fp, "")
+		audio = append(audio, data)
+	}
+
+	return strings.TrimSpace(input), audio, nil
+}
+
+func getAudioData(filePath string) ([]byte, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType := http.DetectContentType(data); contentType != "audio/wave" {
+		return nil, fmt.Errorf("invalid audio type: %s", contentType)
+	}
+
+	const maxSize = 100 * 1024 * 1024
+	if len(data) > maxSize {
+		return nil, errors.New("file size exceeds maximum limit (100MB)")
+	}
+
+	return data, nil
+}
+
 func NewCreateRequest(name string, opts runOptions) *api.CreateRequest {
 	parentModel := opts.ParentModel
 
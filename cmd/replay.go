@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ollama/ollama/api"
+)
+
+// ReplayHandler sends the requests of captures made with OLLAMA_CAPTURE
+// again and shows how the new responses differ from the captured ones.
+func ReplayHandler(cmd *cobra.Command, args []string) error {
+	var paths []string
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return err
+		}
+
+		if !fi.IsDir() {
+			paths = append(paths, arg)
+			continue
+		}
+
+		// captures are named by when they were made, and Glob sorts its
+		// matches, so they're sent again in order
+		matches, err := filepath.Glob(filepath.Join(arg, "*.json"))
+		if err != nil {
+			return err
+		}
+		paths = append(paths, matches...)
+	}
+
+	if len(paths) == 0 {
+		return fmt.Errorf("no captures in %s", strings.Join(args, ", "))
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	var differ int
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var capture api.Capture
+		if err := json.Unmarshal(b, &capture); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		replayed, err := client.Replay(cmd.Context(), &capture)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		before, after := replayOutput(capture.Response), replayOutput(replayed.Response)
+		if capture.Status == replayed.Status && before == after {
+			fmt.Printf("%s %s %s: same (%s, was %s)\n", filepath.Base(path), capture.Method, capture.Path, replayed.Duration.Round(time.Millisecond), capture.Duration.Round(time.Millisecond))
+			continue
+		}
+
+		differ++
+		fmt.Printf("%s %s %s: differs (%s, was %s)\n", filepath.Base(path), capture.Method, capture.Path, replayed.Duration.Round(time.Millisecond), capture.Duration.Round(time.Millisecond))
+		if capture.Status != replayed.Status {
+			fmt.Printf("- status %d\n+ status %d\n", capture.Status, replayed.Status)
+		}
+		if capture.Truncated {
+			fmt.Println("  (the captured response was cut off)")
+		}
+		for _, line := range diffLines(strings.Split(before, "\n"), strings.Split(after, "\n")) {
+			fmt.Println(line)
+		}
+	}
+
+	if differ > 0 {
+		return fmt.Errorf("%d of %d responses differ", differ, len(paths))
+	}
+
+	return nil
+}
+
+// volatileKeys are left out of responses when comparing them, since they
+// change from one request to the next.
+var volatileKeys = map[string]bool{
+	"created_at":         true,
+	"created":            true,
+	"id":                 true,
+	"request_id":         true,
+	"system_fingerprint": true,
+}
+
+// replayOutput returns the text generated in response, which is JSON, one
+// JSON object per line, or server-sent events. Responses without text, such
+// as embeddings, are returned as JSON lines without their volatile keys.
+func replayOutput(response string) string {
+	var text strings.Builder
+	var lines []string
+	for _, line := range strings.Split(response, "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimPrefix(line, "data: ")
+		if line == "" || line == "[DONE]" {
+			continue
+		}
+
+		var v map[string]any
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			lines = append(lines, line)
+			continue
+		}
+
+		writeOutputText(&text, v)
+		if choices, ok := v["choices"].([]any); ok {
+			for _, choice := range choices {
+				if choice, ok := choice.(map[string]any); ok {
+					writeOutputText(&text, choice)
+				}
+			}
+		}
+
+		b, err := json.Marshal(withoutVolatileKeys(v))
+		if err != nil {
+			return response
+		}
+		lines = append(lines, string(b))
+	}
+
+	if text.Len() > 0 {
+		return text.String()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// writeOutputText writes the text in a response or chunk in the formats of
+// the Ollama and OpenAI-compatible APIs.
+func writeOutputText(b *strings.Builder, v map[string]any) {
+	if s, ok := v["response"].(string); ok {
+		b.WriteString(s)
+	}
+	if s, ok := v["text"].(string); ok {
+		b.WriteString(s)
+	}
+
+	for _, key := range []string{"message", "delta"} {
+		if m, ok := v[key].(map[string]any); ok {
+			if s, ok := m["thinking"].(string); ok {
+				b.WriteString(s)
+			}
+			if s, ok := m["content"].(string); ok {
+				b.WriteString(s)
+			}
+		}
+	}
+}
+
+func withoutVolatileKeys(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if volatileKeys[k] || strings.HasSuffix(k, "_duration") {
+				delete(v, k)
+			} else {
+				v[k] = withoutVolatileKeys(e)
+			}
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = withoutVolatileKeys(e)
+		}
+	}
+
+	return v
+}
+
+// maxDiffCells limits the lines compared by diffLines, which otherwise
+// takes time and memory proportional to the product of their counts.
+const maxDiffCells = 1 << 22
+
+// diffLines returns the lines of a and b prefixed with "- " if they were
+// removed, "+ " if they were added, or two spaces if they're in both.
+func diffLines(a, b []string) []string {
+	var diff []string
+	if len(a)*len(b) > maxDiffCells {
+		for _, s := range a {
+			diff = append(diff, "- "+s)
+		}
+		for _, s := range b {
+			diff = append(diff, "+ "+s)
+		}
+		return diff
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff = append(diff, "  "+a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, "- "+a[i])
+			i++
+		default:
+			diff = append(diff, "+ "+b[j])
+			j++
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReplayOutput(t *testing.T) {
+	cases := map[string]string{
+		`{"model":"test","created_at":"2024-01-01T00:00:00Z","response":"Hello","done":true,"total_duration":5}`:                                                      "Hello",
+		"{\"message\":{\"role\":\"assistant\",\"content\":\"Hel\"}}\n{\"message\":{\"role\":\"assistant\",\"content\":\"lo\"}}\n":                                     "Hello",
+		"data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\ndata: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\ndata: [DONE]\n\n": "Hello",
+		`{"id":"cmpl-1","choices":[{"text":"Hello"}]}`:                               "Hello",
+		`{"model":"test","embeddings":[[0.5]],"total_duration":5,"load_duration":2}`: `{"embeddings":[[0.5]],"model":"test"}`,
+		`{"error":"model not found"}`:                                                `{"error":"model not found"}`,
+	}
+
+	for response, want := range cases {
+		if got := replayOutput(response); got != want {
+			t.Errorf("%q: expected %q, got %q", response, want, got)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	got := diffLines(strings.Split("a\nb\nc\nd", "\n"), strings.Split("a\nc\ne\nd", "\n"))
+	want := []string{"  a", "- b", "  c", "+ e", "  d"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	if got := diffLines([]string{"a"}, []string{"a"}); len(got) != 1 || got[0] != "  a" {
+		t.Errorf("expected no changes, got %v", got)
+	}
+}
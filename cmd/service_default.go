@@ -0,0 +1,34 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"errors"
+	"net"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ollama/ollama/server"
+)
+
+func serve(ln net.Listener) error {
+	return server.Serve(ln)
+}
+
+var errServiceUnsupported = errors.New("ollama service is only available on Windows, run Ollama with systemd on Linux as described in docs/linux.md")
+
+func ServiceInstallHandler(*cobra.Command, []string) error {
+	return errServiceUnsupported
+}
+
+func ServiceUninstallHandler(*cobra.Command, []string) error {
+	return errServiceUnsupported
+}
+
+func ServiceStartHandler(*cobra.Command, []string) error {
+	return errServiceUnsupported
+}
+
+func ServiceStopHandler(*cobra.Command, []string) error {
+	return errServiceUnsupported
+}
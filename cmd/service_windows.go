@@ -0,0 +1,244 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/server"
+)
+
+// serviceName is the name of the Windows service ollama serve is installed
+// as.
+const serviceName = "Ollama"
+
+// serve serves the API on ln, as a Windows service when it was started by
+// the service manager.
+func serve(ln net.Listener) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+
+	if !isService {
+		return server.Serve(ln)
+	}
+
+	return svc.Run(serviceName, &service{ln: ln})
+}
+
+// service runs the server for the Windows service manager, which stops it
+// with a request rather than a signal.
+type service struct {
+	ln net.Listener
+}
+
+func (s *service) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ServeContext(ctx, s.ln)
+	}()
+
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				slog.Error("server stopped", "error", err)
+				return true, 1
+			}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				// the server finishes the requests in flight before it stops
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32((envconfig.DrainTimeout() + 10*time.Second).Milliseconds())}
+				stop()
+			}
+		}
+	}
+}
+
+func ServiceInstallHandler(cmd *cobra.Command, _ []string) error {
+	env, err := cmd.Flags().GetStringArray("env")
+	if err != nil {
+		return err
+	}
+
+	for _, kv := range env {
+		if key, _, ok := strings.Cut(kv, "="); !ok || key == "" {
+			return fmt.Errorf("invalid --env %q, expected KEY=VALUE", kv)
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("couldn't connect to the service manager, run this from an administrator prompt: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("the %s service is already installed", serviceName)
+	}
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Ollama",
+		Description: "Serves the Ollama API",
+		StartType:   mgr.StartAutomatic,
+	}, "serve")
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// restart the server if it crashes
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 5 * time.Second}}, uint32((24 * time.Hour).Seconds())); err != nil {
+		return err
+	}
+
+	if len(env) > 0 {
+		key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+serviceName, registry.SET_VALUE)
+		if err != nil {
+			return err
+		}
+		defer key.Close()
+
+		if err := key.SetStringsValue("Environment", env); err != nil {
+			return err
+		}
+	}
+
+	// the source may be left over from a service which was removed by hand
+	eventlog.Remove(server.EventSource) //nolint:errcheck
+	if err := eventlog.InstallAsEventCreate(server.EventSource, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		return err
+	}
+
+	fmt.Printf("installed the %s service, start it with 'ollama service start'\n", serviceName)
+	return nil
+}
+
+func ServiceUninstallHandler(*cobra.Command, []string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("couldn't connect to the service manager, run this from an administrator prompt: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("the %s service isn't installed", serviceName)
+	}
+	defer s.Close()
+
+	if err := stopService(s); err != nil {
+		return err
+	}
+
+	if err := s.Delete(); err != nil {
+		return err
+	}
+
+	if err := eventlog.Remove(server.EventSource); err != nil {
+		slog.Warn("failed to remove the event log source", "error", err)
+	}
+
+	fmt.Printf("uninstalled the %s service\n", serviceName)
+	return nil
+}
+
+func ServiceStartHandler(*cobra.Command, []string) error {
+	return withService(func(s *mgr.Service) error {
+		if err := s.Start(); err != nil && !errors.Is(err, windows.ERROR_SERVICE_ALREADY_RUNNING) {
+			return err
+		}
+
+		return waitForService(s, svc.Running, 30*time.Second)
+	})
+}
+
+func ServiceStopHandler(*cobra.Command, []string) error {
+	return withService(stopService)
+}
+
+// withService calls fn with the installed service.
+func withService(fn func(*mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("couldn't connect to the service manager, run this from an administrator prompt: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("the %s service isn't installed, install it with 'ollama service install'", serviceName)
+	}
+	defer s.Close()
+
+	return fn(s)
+}
+
+// stopService stops s, waiting for the requests in flight to finish.
+func stopService(s *mgr.Service) error {
+	status, err := s.Query()
+	if err != nil {
+		return err
+	}
+
+	if status.State == svc.Stopped {
+		return nil
+	}
+
+	if _, err := s.Control(svc.Stop); err != nil && !errors.Is(err, windows.ERROR_SERVICE_NOT_ACTIVE) {
+		return err
+	}
+
+	return waitForService(s, svc.Stopped, envconfig.DrainTimeout()+30*time.Second)
+}
+
+// waitForService waits for s to reach state.
+func waitForService(s *mgr.Service, state svc.State, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := s.Query()
+		if err != nil {
+			return err
+		}
+
+		if status.State == state {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for the %s service", serviceName)
+		}
+
+		time.Sleep(500 * time.Millisecond)
+	}
+}
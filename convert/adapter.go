@@ -0,0 +1,173 @@
+package convert
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+var errDoRA = errors.New("DoRA adapters are unsupported, merge the adapter into its base model, e.g. with PEFT's merge_and_unload, and import the merged model instead")
+
+// validateAdapter checks that the tensors of an adapter are LoRA pairs of
+// modules which the base model has and that their dimensions match those of
+// the base model. Dimensions which the base model doesn't report aren't
+// checked.
+func validateAdapter(p AdapterParameters, ts []Tensor, baseKV ggml.KV) error {
+	if p.UseDoRA {
+		return errDoRA
+	}
+
+	for module, alpha := range p.AlphaPattern {
+		if alpha != float32(p.Alpha) {
+			return fmt.Errorf("adapters with a different alpha for some modules are unsupported, %q has an alpha of %v rather than %d", module, alpha, p.Alpha)
+		}
+	}
+
+	hint := ""
+	if p.BaseModelName != "" {
+		hint = fmt.Sprintf(", the adapter was trained for %s", p.BaseModelName)
+	}
+
+	blocks := adapterBaseValue(baseKV, "block_count")
+	dims := adapterModuleDims(baseKV)
+
+	pairs := make(map[string][2]Tensor)
+	for _, t := range ts {
+		name := t.Name()
+		switch {
+		case strings.Contains(name, "lora_magnitude_vector"):
+			return errDoRA
+		case strings.Contains(name, "lora_embedding_"):
+			return fmt.Errorf("adapters of the token embeddings are unsupported, remove embed_tokens from target_modules")
+		}
+
+		base, lora, ok := strings.Cut(name, ".weight.lora_")
+		if !ok || (lora != "a" && lora != "b") {
+			return fmt.Errorf("adapter includes the full weights of %s which are unsupported, remove it from modules_to_save", name)
+		}
+
+		pair := pairs[base]
+		if lora == "a" {
+			pair[0] = t
+		} else {
+			pair[1] = t
+		}
+		pairs[base] = pair
+	}
+
+	for _, base := range slices.Sorted(maps.Keys(pairs)) {
+		pair := pairs[base]
+		switch {
+		case pair[0] == nil:
+			return fmt.Errorf("adapter is missing the lora_a of %s", base)
+		case pair[1] == nil:
+			return fmt.Errorf("adapter is missing the lora_b of %s", base)
+		}
+
+		module := base
+		if rest, ok := strings.CutPrefix(base, "blk."); ok {
+			layer, rest, _ := strings.Cut(rest, ".")
+			n, err := strconv.ParseUint(layer, 10, 64)
+			if err != nil {
+				return fmt.Errorf("unsupported adapter tensor %s", base)
+			}
+
+			if blocks > 0 && n >= blocks {
+				return fmt.Errorf("adapter doesn't match the base model, it adapts layer %d but the base model has %d layers%s", n, blocks, hint)
+			}
+
+			module = rest
+		}
+
+		dim, ok := dims[module]
+		if !ok {
+			return fmt.Errorf("unsupported target module %s, adapters may only target the attention and feed forward projections and lm_head", module)
+		}
+
+		// PEFT stores lora_a as rank x input and lora_b as output x rank while
+		// MLX stores them transposed
+		a, b := pair[0].Shape(), pair[1].Shape()
+		if len(a) != 2 || len(b) != 2 {
+			return fmt.Errorf("unsupported adapter tensor %s, expected lora_a and lora_b to be matrices", base)
+		}
+
+		// the input and output dimensions of each orientation in which the
+		// ranks of lora_a and lora_b agree
+		var candidates [][2]uint64
+		if a[0] == b[1] {
+			candidates = append(candidates, [2]uint64{a[1], b[0]})
+		}
+
+		if a[1] == b[0] {
+			candidates = append(candidates, [2]uint64{a[0], b[1]})
+		}
+
+		if len(candidates) == 0 {
+			return fmt.Errorf("adapter's lora_a and lora_b of %s have different ranks", base)
+		}
+
+		if !slices.ContainsFunc(candidates, func(c [2]uint64) bool {
+			return (dim[0] == 0 || c[0] == dim[0]) && (dim[1] == 0 || c[1] == dim[1])
+		}) {
+			if c := candidates[0]; dim[0] > 0 && c[0] != dim[0] {
+				return fmt.Errorf("adapter doesn't match the base model, the input of %s is %d but the base model's is %d%s", base, c[0], dim[0], hint)
+			}
+
+			return fmt.Errorf("adapter doesn't match the base model, the output of %s is %d but the base model's is %d%s", base, candidates[0][1], dim[1], hint)
+		}
+	}
+
+	return nil
+}
+
+// adapterModuleDims returns the input and output dimensions of each module
+// of the base model which adapters may target, or 0 if it's unknown.
+func adapterModuleDims(kv ggml.KV) map[string][2]uint64 {
+	embd := adapterBaseValue(kv, "embedding_length")
+	ff := adapterBaseValue(kv, "feed_forward_length")
+	heads := adapterBaseValue(kv, "attention.head_count")
+	kvHeads := cmp.Or(adapterBaseValue(kv, "attention.head_count_kv"), heads)
+
+	var headDimK, headDimV uint64
+	if heads > 0 {
+		headDimK = cmp.Or(adapterBaseValue(kv, "attention.key_length"), embd/heads)
+		headDimV = cmp.Or(adapterBaseValue(kv, "attention.value_length"), embd/heads)
+	}
+
+	var vocab uint64
+	switch tokens := kv["tokenizer.ggml.tokens"].(type) {
+	case nil:
+		// unknown
+	case []string:
+		vocab = uint64(len(tokens))
+	default:
+		vocab = uint64(len(kv.Strings("tokenizer.ggml.tokens")))
+	}
+
+	return map[string][2]uint64{
+		"attn_q":      {embd, heads * headDimK},
+		"attn_k":      {embd, kvHeads * headDimK},
+		"attn_v":      {embd, kvHeads * headDimV},
+		"attn_output": {heads * headDimV, embd},
+		"ffn_gate":    {embd, ff},
+		"ffn_up":      {embd, ff},
+		"ffn_down":    {ff, embd},
+		"output":      {embd, vocab},
+	}
+}
+
+// adapterBaseValue returns the value of key for the architecture of the base
+// model, or 0 if it isn't set.
+func adapterBaseValue(kv ggml.KV, key string) uint64 {
+	if v, ok := kv[fmt.Sprintf("%v.%s", kv["general.architecture"], key)].(uint32); ok {
+		return uint64(v)
+	}
+
+	return 0
+}
@@ -0,0 +1,226 @@
+package convert
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+// convertPEFT converts a PEFT adapter with config and tensors for baseKV.
+func convertPEFT(t *testing.T, config map[string]any, tensors map[string]moeTensor, baseKV ggml.KV) (ggml.KV, map[string]*ggml.Tensor, error) {
+	t.Helper()
+
+	dir := t.TempDir()
+	writeSafetensors(t, filepath.Join(dir, "adapter_model.safetensors"), tensors)
+
+	bts, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "adapter_config.json"), bts, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "adapter.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := ConvertAdapter(os.DirFS(dir), f, baseKV); err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	g, _, err := ggml.Decode(f, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := make(map[string]*ggml.Tensor)
+	for _, tensor := range g.Tensors().Items() {
+		ts[tensor.Name] = tensor
+	}
+
+	return g.KV(), ts, nil
+}
+
+// peftTensors returns the LoRA pairs of rank r for modules of layer 0, each
+// of which has an input and output dimension.
+func peftTensors(r uint64, modules map[string][2]uint64) map[string]moeTensor {
+	ts := make(map[string]moeTensor)
+	for module, dims := range modules {
+		ts["base_model.model.model.layers.0."+module+".lora_A.weight"] = moeTensor{[]uint64{r, dims[0]}, 1}
+		ts["base_model.model.model.layers.0."+module+".lora_B.weight"] = moeTensor{[]uint64{dims[1], r}, 1}
+	}
+
+	return ts
+}
+
+func TestConvertPEFTAdapter(t *testing.T) {
+	baseKV := ggml.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(2),
+		"llama.embedding_length":        uint32(16),
+		"llama.feed_forward_length":     uint32(32),
+		"llama.attention.head_count":    uint32(4),
+		"llama.attention.head_count_kv": uint32(2),
+	}
+
+	modules := map[string][2]uint64{
+		"self_attn.q_proj": {16, 16},
+		"self_attn.k_proj": {16, 8},
+		"self_attn.v_proj": {16, 8},
+		"self_attn.o_proj": {16, 16},
+		"mlp.gate_proj":    {16, 32},
+		"mlp.up_proj":      {16, 32},
+		"mlp.down_proj":    {32, 16},
+	}
+
+	config := map[string]any{
+		"peft_type":               "LORA",
+		"r":                       4,
+		"lora_alpha":              8,
+		"target_modules":          []string{"q_proj", "k_proj", "v_proj", "o_proj", "gate_proj", "up_proj", "down_proj"},
+		"base_model_name_or_path": "example/llama",
+	}
+
+	t.Run("all linear", func(t *testing.T) {
+		kv, ts, err := convertPEFT(t, config, peftTensors(4, modules), baseKV)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if alpha := kv["adapter.lora.alpha"]; alpha != float32(8) {
+			t.Errorf("expected alpha 8, got %v", alpha)
+		}
+
+		for _, name := range []string{"attn_q", "attn_k", "attn_v", "attn_output", "ffn_gate", "ffn_up", "ffn_down"} {
+			for _, lora := range []string{"lora_a", "lora_b"} {
+				if _, ok := ts["blk.0."+name+".weight."+lora]; !ok {
+					t.Errorf("expected blk.0.%s.weight.%s", name, lora)
+				}
+			}
+		}
+	})
+
+	t.Run("rank stabilized", func(t *testing.T) {
+		config := map[string]any{"r": 4, "lora_alpha": 8, "use_rslora": true}
+		kv, _, err := convertPEFT(t, config, peftTensors(4, modules), baseKV)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// alpha / sqrt(r) == (alpha * sqrt(r)) / r
+		if alpha := kv["adapter.lora.alpha"]; alpha != float32(16) {
+			t.Errorf("expected alpha 16, got %v", alpha)
+		}
+	})
+
+	cases := []struct {
+		name    string
+		config  map[string]any
+		tensors map[string]moeTensor
+		err     string
+	}{
+		{
+			name:    "wrong base model",
+			config:  config,
+			tensors: peftTensors(4, map[string][2]uint64{"self_attn.q_proj": {32, 32}}),
+			err:     "adapter doesn't match the base model, the input of blk.0.attn_q is 32 but the base model's is 16, the adapter was trained for example/llama",
+		},
+		{
+			name:    "wrong output",
+			config:  config,
+			tensors: peftTensors(4, map[string][2]uint64{"self_attn.k_proj": {16, 16}}),
+			err:     "adapter doesn't match the base model, the output of blk.0.attn_k is 16 but the base model's is 8, the adapter was trained for example/llama",
+		},
+		{
+			name:   "too many layers",
+			config: config,
+			tensors: map[string]moeTensor{
+				"base_model.model.model.layers.2.self_attn.q_proj.lora_A.weight": {[]uint64{4, 16}, 1},
+				"base_model.model.model.layers.2.self_attn.q_proj.lora_B.weight": {[]uint64{16, 4}, 1},
+			},
+			err: "adapter doesn't match the base model, it adapts layer 2 but the base model has 2 layers, the adapter was trained for example/llama",
+		},
+		{
+			name:   "unsupported module",
+			config: config,
+			tensors: map[string]moeTensor{
+				"base_model.model.model.layers.0.self_attn.rotary.lora_A.weight": {[]uint64{4, 16}, 1},
+				"base_model.model.model.layers.0.self_attn.rotary.lora_B.weight": {[]uint64{16, 4}, 1},
+			},
+			err: "unsupported target module self_attn.rotary",
+		},
+		{
+			name:   "missing lora_b",
+			config: config,
+			tensors: map[string]moeTensor{
+				"base_model.model.model.layers.0.self_attn.q_proj.lora_A.weight": {[]uint64{4, 16}, 1},
+			},
+			err: "adapter is missing the lora_b of blk.0.attn_q",
+		},
+		{
+			name:   "different ranks",
+			config: config,
+			tensors: map[string]moeTensor{
+				"base_model.model.model.layers.0.self_attn.q_proj.lora_A.weight": {[]uint64{4, 16}, 1},
+				"base_model.model.model.layers.0.self_attn.q_proj.lora_B.weight": {[]uint64{32, 8}, 1},
+			},
+			err: "adapter's lora_a and lora_b of blk.0.attn_q have different ranks",
+		},
+		{
+			name:   "modules to save",
+			config: config,
+			tensors: map[string]moeTensor{
+				"base_model.model.lm_head.weight": {[]uint64{2, 16}, 1},
+			},
+			err: "adapter includes the full weights of output.weight which are unsupported",
+		},
+		{
+			name:    "dora config",
+			config:  map[string]any{"r": 4, "lora_alpha": 8, "use_dora": true},
+			tensors: peftTensors(4, modules),
+			err:     "DoRA adapters are unsupported",
+		},
+		{
+			name:   "dora weights",
+			config: config,
+			tensors: map[string]moeTensor{
+				"base_model.model.model.layers.0.self_attn.q_proj.lora_magnitude_vector": {[]uint64{16}, 1},
+			},
+			err: "DoRA adapters are unsupported",
+		},
+		{
+			name:    "alpha pattern",
+			config:  map[string]any{"r": 4, "lora_alpha": 8, "alpha_pattern": map[string]any{"q_proj": 16}},
+			tensors: peftTensors(4, modules),
+			err:     "adapters with a different alpha for some modules are unsupported",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := convertPEFT(t, tt.config, tt.tensors, baseKV)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestConvertAdapterUnsupportedArchitecture(t *testing.T) {
+	_, _, err := convertPEFT(t, map[string]any{"r": 4}, nil, ggml.KV{"general.architecture": "bert"})
+	if err == nil || err.Error() != `adapters of "bert" models are unsupported` {
+		t.Errorf("expected unsupported architecture error, got %v", err)
+	}
+}
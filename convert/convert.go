@@ -1,12 +1,14 @@
 package convert
 
 import (
+	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
+	"math"
 	"strings"
 
 	"github.com/ollama/ollama/fs/ggml"
@@ -30,6 +32,13 @@ type AdapterParameters struct {
 		Alpha float32 `json:"alpha"`
 		Scale float32 `json:"scale"`
 	} `json:"lora_parameters"`
+
+	// PEFT parameters
+	Rank          uint32             `json:"r"`
+	UseRSLoRA     bool               `json:"use_rslora"`
+	UseDoRA       bool               `json:"use_dora"`
+	AlphaPattern  map[string]float32 `json:"alpha_pattern"`
+	BaseModelName string             `json:"base_model_name_or_path"`
 }
 
 func (ModelParameters) KV(t *Tokenizer) ggml.KV {
@@ -67,6 +76,12 @@ func (p AdapterParameters) KV() ggml.KV {
 		alpha = p.LoraParameters.Alpha
 	}
 
+	if rank := cmp.Or(p.Rank, p.LoraParameters.Rank); p.UseRSLoRA && rank > 0 {
+		// rank stabilized adapters are scaled by alpha / sqrt(rank) rather than
+		// alpha / rank so alpha is adjusted for the latter
+		alpha *= float32(math.Sqrt(float64(rank)))
+	}
+
 	kv := ggml.KV{
 		"adapter.lora.alpha": alpha,
 		"adapter.type":       "lora",
@@ -146,7 +161,7 @@ func ConvertAdapter(fsys fs.FS, ws io.WriteSeeker, baseKV ggml.KV) error {
 	case "gemma2":
 		conv = &gemma2Adapter{}
 	default:
-		return errors.New("unsupported architecture")
+		return fmt.Errorf("adapters of %q models are unsupported", arch)
 	}
 
 	ts, err := parseTensors(fsys, strings.NewReplacer(conv.Replacements()...))
@@ -154,6 +169,10 @@ func ConvertAdapter(fsys fs.FS, ws io.WriteSeeker, baseKV ggml.KV) error {
 		return err
 	}
 
+	if err := validateAdapter(p, ts, baseKV); err != nil {
+		return err
+	}
+
 	if err := json.Unmarshal(bts, conv); err != nil {
 		return err
 	}
@@ -196,7 +215,17 @@ func ConvertModel(fsys fs.FS, ws io.WriteSeeker) error {
 		conv = &phi3Model{}
 	case "Qwen2ForCausalLM":
 		conv = &qwen2Model{}
-	case "BertModel":
+	case "Qwen2VLForConditionalGeneration":
+		conv = &qwen2VLModel{}
+	case "Mistral3ForConditionalGeneration", "LlavaForConditionalGeneration":
+		conv = &mistral3Model{}
+	case "Qwen2MoeForCausalLM":
+		conv = &qwen2MoeModel{}
+	case "Qwen3MoeForCausalLM":
+		conv = &qwen3MoeModel{}
+	case "DeepseekV2ForCausalLM", "DeepseekV3ForCausalLM":
+		conv = &deepseek2Model{}
+	case "BertModel", "BertForSequenceClassification":
 		conv = &bertModel{}
 	case "CohereForCausalLM":
 		conv = &commandrModel{}
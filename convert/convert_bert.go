@@ -3,9 +3,10 @@ package convert
 import (
 	"cmp"
 	"encoding/json"
+	"io"
 	"io/fs"
 	"path/filepath"
-	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/ollama/ollama/fs/ggml"
@@ -29,7 +30,17 @@ type bertModel struct {
 	LayerNormEpsilon      float32 `json:"layer_norm_epsilon"`
 	NormEpsilon           float32 `json:"norm_epsilon"`
 
+	// ID2Label and ProblemType describe the labels of sequence
+	// classification models
+	ID2Label    map[string]string `json:"id2label"`
+	ProblemType string            `json:"problem_type"`
+
 	PoolingType uint32
+
+	// head is the pooler and classifier of sequence classification models,
+	// which llama.cpp doesn't run so they're written as key-values rather
+	// than tensors
+	head []Tensor
 }
 
 var (
@@ -37,7 +48,19 @@ var (
 	_ moreParser     = (*bertModel)(nil)
 )
 
+// sequenceClassification returns whether the model has a classification head
+// on top of its pooled output.
+func (p *bertModel) sequenceClassification() bool {
+	return len(p.Architectures) > 0 && strings.HasSuffix(p.Architectures[0], "ForSequenceClassification")
+}
+
 func (p *bertModel) parseMore(fsys fs.FS) error {
+	if p.sequenceClassification() {
+		// the classifier is run on the hidden state of the CLS token
+		p.PoolingType = 2
+		return nil
+	}
+
 	bts, err := fs.ReadFile(fsys, "modules.json")
 	if err != nil {
 		return err
@@ -129,17 +152,29 @@ func (p *bertModel) KV(t *Tokenizer) ggml.KV {
 
 	kv["tokenizer.ggml.tokens"] = t.Tokens
 
+	if p.sequenceClassification() {
+		labels := make([]string, len(p.ID2Label))
+		for i := range labels {
+			labels[i] = cmp.Or(p.ID2Label[strconv.Itoa(i)], "LABEL_"+strconv.Itoa(i))
+		}
+
+		kv["bert.classifier.output_labels"] = labels
+		kv["bert.classifier.multi_label"] = p.ProblemType == "multi_label_classification"
+	}
+
 	return kv
 }
 
 func (p *bertModel) Tensors(ts []Tensor) []ggml.Tensor {
 	var out []ggml.Tensor
 	for _, t := range ts {
-		if slices.Contains([]string{
-			"embeddings.position_ids",
-			"pooler.dense.weight",
-			"pooler.dense.bias",
-		}, t.Name()) {
+		switch t.Name() {
+		case "embeddings.position_ids":
+			continue
+		case "pooler.dense.weight", "pooler.dense.bias", "classifier.weight", "classifier.bias":
+			if p.sequenceClassification() {
+				p.head = append(p.head, t)
+			}
 			continue
 		}
 
@@ -154,8 +189,25 @@ func (p *bertModel) Tensors(ts []Tensor) []ggml.Tensor {
 	return out
 }
 
+func (p *bertModel) writeFile(ws io.WriteSeeker, kv ggml.KV, ts []ggml.Tensor) error {
+	for _, t := range p.head {
+		values, err := tensorValues(t)
+		if err != nil {
+			return err
+		}
+
+		// e.g. pooler.dense.weight is written as bert.classifier.pooler.weight
+		name := strings.Replace(t.Name(), ".dense", "", 1)
+		name = strings.Replace(name, "classifier.", "output.", 1)
+		kv["bert.classifier."+name] = values
+	}
+
+	return ggml.WriteGGUF(ws, kv, ts)
+}
+
 func (bertModel) Replacements() []string {
 	return []string{
+		"bert.", "",
 		"encoder.layer", "blk",
 		"encoder.layers", "blk",
 		"embeddings.word_embeddings", "token_embd",
@@ -63,6 +63,7 @@ func (p *llamaAdapter) Replacements() []string {
 		"mlp.gate_proj", "ffn_gate",
 		"mlp.down_proj", "ffn_down",
 		"mlp.up_proj", "ffn_up",
+		"lm_head", "output",
 		"lora_A.weight", "weight.lora_a",
 		"lora_B.weight", "weight.lora_b",
 		"lora_a", "weight.lora_a",
@@ -0,0 +1,158 @@
+package convert
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+// mistral3Model converts Mistral 3 and Pixtral, which pair a Mistral text
+// model with a Pixtral vision encoder. Pixtral checkpoints don't merge
+// patches before projecting them, i.e. their spatial merge size is 1.
+type mistral3Model struct {
+	ModelParameters
+	TextModel          llamaModel `json:"text_config"`
+	ImageTokenIndex    uint32     `json:"image_token_index"`
+	SpatialMergeSize   uint32     `json:"spatial_merge_size"`
+	VisionFeatureLayer int32      `json:"vision_feature_layer"`
+	VisionModel        struct {
+		ModelType         string  `json:"model_type"`
+		NumAttentionHeads uint32  `json:"num_attention_heads"`
+		NumHiddenLayers   uint32  `json:"num_hidden_layers"`
+		HiddenSize        uint32  `json:"hidden_size"`
+		IntermediateSize  uint32  `json:"intermediate_size"`
+		ImageSize         uint32  `json:"image_size"`
+		NumChannels       uint32  `json:"num_channels"`
+		PatchSize         uint32  `json:"patch_size"`
+		HeadDim           uint32  `json:"head_dim"`
+		RopeTheta         float32 `json:"rope_theta"`
+	} `json:"vision_config"`
+	ProjectorHiddenAct string `json:"projector_hidden_act"`
+}
+
+var (
+	_ ModelConverter = (*mistral3Model)(nil)
+	_ moreParser     = (*mistral3Model)(nil)
+)
+
+func (p *mistral3Model) parseMore(fs.FS) error {
+	// llava checkpoints are only supported with a pixtral vision encoder
+	if p.VisionModel.ModelType != "" && p.VisionModel.ModelType != "pixtral" {
+		return fmt.Errorf("unsupported vision model %q", p.VisionModel.ModelType)
+	}
+
+	// pixtral's configuration omits values which are the defaults of transformers
+	p.TextModel.NumAttentionHeads = cmp.Or(p.TextModel.NumAttentionHeads, 32)
+	return nil
+}
+
+func (p *mistral3Model) KV(t *Tokenizer) ggml.KV {
+	kv := ggml.KV{}
+	for k, v := range p.TextModel.KV(t) {
+		if name, ok := strings.CutPrefix(k, "llama."); ok {
+			k = "mistral3." + name
+		}
+
+		kv[k] = v
+	}
+
+	kv["general.architecture"] = "mistral3"
+	if p.TextModel.HeadDim > 0 {
+		// the heads of mistral aren't necessarily hidden_size / num_attention_heads
+		kv["mistral3.rope.dimension_count"] = p.TextModel.HeadDim
+	}
+
+	hiddenSize := cmp.Or(p.VisionModel.HiddenSize, 1024)
+	numHeads := cmp.Or(p.VisionModel.NumAttentionHeads, 16)
+	kv["mistral3.vision.block_count"] = cmp.Or(p.VisionModel.NumHiddenLayers, 24)
+	kv["mistral3.vision.embedding_length"] = hiddenSize
+	kv["mistral3.vision.feed_forward_length"] = cmp.Or(p.VisionModel.IntermediateSize, 4096)
+	kv["mistral3.vision.attention.head_count"] = numHeads
+	kv["mistral3.vision.attention.key_length"] = cmp.Or(p.VisionModel.HeadDim, hiddenSize/numHeads)
+	kv["mistral3.vision.attention.layer_norm_epsilon"] = float32(1e-5)
+	kv["mistral3.vision.image_size"] = cmp.Or(p.VisionModel.ImageSize, 1024)
+	kv["mistral3.vision.patch_size"] = cmp.Or(p.VisionModel.PatchSize, 16)
+	kv["mistral3.vision.num_channels"] = cmp.Or(p.VisionModel.NumChannels, 3)
+	// patches are positioned with a 2D rope over their row and column
+	kv["mistral3.vision.rope.freq_base"] = cmp.Or(p.VisionModel.RopeTheta, 10000)
+	kv["mistral3.vision.feature_layer"] = cmp.Or(p.VisionFeatureLayer, -1)
+
+	kv["mistral3.spatial_merge_size"] = cmp.Or(p.SpatialMergeSize, 1)
+	kv["mistral3.mm.projector_hidden_act"] = cmp.Or(p.ProjectorHiddenAct, "gelu")
+	kv["mistral3.image_token_index"] = cmp.Or(p.ImageTokenIndex, 10)
+
+	// rows of patches are separated by a break token and the image is
+	// terminated by an end token
+	for key, token := range map[string]string{
+		"mistral3.image_break_token_index": "[IMG_BREAK]",
+		"mistral3.image_end_token_index":   "[IMG_END]",
+	} {
+		if i := slices.Index(t.Vocabulary.Tokens, token); i >= 0 {
+			kv[key] = uint32(i)
+		}
+	}
+
+	return kv
+}
+
+func (p *mistral3Model) Tensors(ts []Tensor) []ggml.Tensor {
+	var text []Tensor
+	var out []ggml.Tensor
+	for _, t := range ts {
+		// only the text model's queries and keys are permuted for its rope
+		if !strings.HasPrefix(t.Name(), "v.") && !strings.HasPrefix(t.Name(), "mm.") {
+			text = append(text, t)
+			continue
+		}
+
+		out = append(out, ggml.Tensor{
+			Name:     t.Name(),
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return append(p.TextModel.Tensors(text), out...)
+}
+
+func (p *mistral3Model) Replacements() []string {
+	return []string{
+		"language_model.lm_head", "output",
+		"lm_head", "output",
+		"language_model.model.embed_tokens", "token_embd",
+		"model.language_model.embed_tokens", "token_embd",
+		"language_model.model.norm", "output_norm",
+		"model.language_model.norm", "output_norm",
+		"language_model.model.layers", "blk",
+		"model.language_model.layers", "blk",
+		"model.vision_tower", "v",
+		"vision_tower", "v",
+		"model.multi_modal_projector", "mm",
+		"multi_modal_projector", "mm",
+		"transformer.layers", "blk",
+		"patch_conv", "patch_embd",
+		"ln_pre", "encoder_norm",
+		"input_layernorm", "attn_norm",
+		"post_attention_layernorm", "ffn_norm",
+		"self_attn.q_proj", "attn_q",
+		"self_attn.k_proj", "attn_k",
+		"self_attn.v_proj", "attn_v",
+		"self_attn.o_proj", "attn_output",
+		"attention.q_proj", "attn_q",
+		"attention.k_proj", "attn_k",
+		"attention.v_proj", "attn_v",
+		"attention.o_proj", "attn_output",
+		"attention_norm", "attn_norm",
+		"mlp.gate_proj", "ffn_gate",
+		"mlp.down_proj", "ffn_down",
+		"mlp.up_proj", "ffn_up",
+		"feed_forward.gate_proj", "ffn_gate",
+		"feed_forward.down_proj", "ffn_down",
+		"feed_forward.up_proj", "ffn_up",
+	}
+}
@@ -3,7 +3,9 @@ package convert
 import (
 	"fmt"
 	"io"
+	"maps"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/ollama/ollama/fs/ggml"
@@ -30,58 +32,78 @@ func (p *mixtralModel) KV(t *Tokenizer) ggml.KV {
 }
 
 func (p *mixtralModel) Tensors(ts []Tensor) []ggml.Tensor {
-	oldnew := []string{
-		"model.layers", "blk",
-		"w1", "ffn_gate_exps",
-		"w2", "ffn_down_exps",
-		"w3", "ffn_up_exps",
-	}
+	ts, out := mergeExperts(ts, "block_sparse_moe.experts", map[string]string{
+		"w1": "ffn_gate_exps",
+		"w2": "ffn_down_exps",
+		"w3": "ffn_up_exps",
+	})
 
-	for i := range p.NumLocalExperts {
-		oldnew = append(oldnew, fmt.Sprintf(".block_sparse_moe.experts.%d.", i), ".")
-	}
+	return append(out, p.llamaModel.Tensors(ts)...)
+}
+
+func (p *mixtralModel) Replacements() []string {
+	return append(
+		p.llamaModel.Replacements(),
+		"block_sparse_moe.gate", "ffn_gate_inp",
+	)
+}
 
-	// group experts of the same layer (model.layers.%d) and type (w[123]) into a single tensor
-	namer := strings.NewReplacer(oldnew...)
-	experts := make(map[string]experts)
+// mergeExperts merges the tensors of the experts of each layer, named like
+// "blk.0.<prefix>.<expert>.<name>.weight", into a single tensor which stacks
+// the experts along a new, first dimension. names maps the name of each kind
+// of expert tensor to the name of the merged tensor, e.g. "w1" to
+// "ffn_gate_exps" for "blk.0.ffn_gate_exps.weight". The merged tensors are
+// removed from ts.
+func mergeExperts(ts []Tensor, prefix string, names map[string]string) ([]Tensor, []ggml.Tensor) {
+	prefix = "." + prefix + "."
 
-	// merge experts into a single tensor while removing them from ts
+	merged := make(map[string]experts)
 	ts = slices.DeleteFunc(ts, func(t Tensor) bool {
-		if !strings.Contains(t.Name(), ".block_sparse_moe.experts.") {
+		layer, rest, ok := strings.Cut(t.Name(), prefix)
+		if !ok {
 			return false
 		}
 
-		name := namer.Replace(t.Name())
-		experts[name] = append(experts[name], t)
+		expert, rest, _ := strings.Cut(rest, ".")
+		name, suffix, _ := strings.Cut(rest, ".")
+		i, err := strconv.Atoi(expert)
+		if err != nil || i < 0 || names[name] == "" {
+			return false
+		}
+
+		// experts are ordered numerically rather than by name, i.e. 2 before 10
+		name = layer + "." + names[name] + "." + suffix
+		if i >= len(merged[name]) {
+			merged[name] = append(merged[name], make(experts, i+1-len(merged[name]))...)
+		}
+
+		merged[name][i] = t
 		return true
 	})
 
-	var out []ggml.Tensor
-	for n, e := range experts {
-		// TODO(mxyng): sanity check experts
+	out := make([]ggml.Tensor, 0, len(merged))
+	for _, name := range slices.Sorted(maps.Keys(merged)) {
+		e := merged[name]
+		first := e[slices.IndexFunc(e, func(t Tensor) bool { return t != nil })]
 		out = append(out, ggml.Tensor{
-			Name:     n,
-			Kind:     e[0].Kind(),
-			Shape:    append([]uint64{uint64(len(e))}, e[0].Shape()...),
+			Name:     name,
+			Kind:     first.Kind(),
+			Shape:    append([]uint64{uint64(len(e))}, first.Shape()...),
 			WriterTo: e,
 		})
 	}
 
-	return append(out, p.llamaModel.Tensors(ts)...)
-}
-
-func (p *mixtralModel) Replacements() []string {
-	return append(
-		p.llamaModel.Replacements(),
-		"block_sparse_moe.gate", "ffn_gate_inp",
-	)
+	return ts, out
 }
 
 type experts []Tensor
 
 func (e experts) WriteTo(w io.Writer) (int64, error) {
-	// TODO(mxyng): experts _should_ be numerically sorted by expert but this should check
-	for _, t := range e {
+	for i, t := range e {
+		if t == nil {
+			return 0, fmt.Errorf("expert %d is missing", i)
+		}
+
 		// the canonical merged experts tensor stacks all experts along a new, 0 axis,
 		// e.g. `tensor.Stack(0, e[0], e[1:]...)`, which requires allocating temporary buffers
 		// this accomplishes the same thing by writing each expert tensor in sequence
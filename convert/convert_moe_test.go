@@ -0,0 +1,430 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/x448/float16"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type moeTensor struct {
+	shape []uint64
+	value float32
+}
+
+// convertMoE converts a model with config and tensors, each of which is
+// filled with its value, returning the converted model and the values of its
+// tensors.
+func convertMoE(t *testing.T, config map[string]any, tensors map[string]moeTensor) (ggml.KV, map[string]*ggml.Tensor, map[string][]float32) {
+	t.Helper()
+
+	dir := t.TempDir()
+	writeSafetensors(t, filepath.Join(dir, "model.safetensors"), tensors)
+
+	bts, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), bts, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "tokenizer.json"), []byte(`{"model": {"type": "BPE", "vocab": {"a": 0, "b": 1}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "model.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := ConvertModel(os.DirFS(dir), f); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	g, _, err := ggml.Decode(f, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := make(map[string]*ggml.Tensor)
+	values := make(map[string][]float32)
+	for _, tensor := range g.Tensors().Items() {
+		ts[tensor.Name] = tensor
+
+		data := make([]byte, tensor.Size())
+		if _, err := f.ReadAt(data, int64(g.Tensors().Offset+tensor.Offset)); err != nil {
+			t.Fatal(err)
+		}
+
+		switch tensor.Kind {
+		case tensorKindF32:
+			f32s := make([]float32, len(data)/4)
+			if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, f32s); err != nil {
+				t.Fatal(err)
+			}
+			values[tensor.Name] = f32s
+		case tensorKindF16:
+			u16s := make([]uint16, len(data)/2)
+			if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, u16s); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, u16 := range u16s {
+				values[tensor.Name] = append(values[tensor.Name], float16.Frombits(u16).Float32())
+			}
+		}
+	}
+
+	return g.KV(), ts, values
+}
+
+// writeSafetensors writes tensors, each of which is filled with its value,
+// to a safetensors file at path.
+func writeSafetensors(t *testing.T, path string, tensors map[string]moeTensor) {
+	t.Helper()
+
+	headers := make(map[string]safetensorMetadata)
+	var data bytes.Buffer
+	for _, name := range slices.Sorted(maps.Keys(tensors)) {
+		tensor := tensors[name]
+		n := uint64(1)
+		for _, dim := range tensor.shape {
+			n *= dim
+		}
+
+		start := int64(data.Len())
+		for range n {
+			if err := binary.Write(&data, binary.LittleEndian, tensor.value); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		headers[name] = safetensorMetadata{Type: "F32", Shape: tensor.shape, Offsets: []int64{start, int64(data.Len())}}
+	}
+
+	header, err := json.Marshal(headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, int64(len(header))); err != nil {
+		t.Fatal(err)
+	}
+	b.Write(header)
+	b.Write(data.Bytes())
+
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkExperts checks that the experts of tensor name are stacked in order,
+// the values of each expert being its index.
+func checkExperts(t *testing.T, ts map[string]*ggml.Tensor, values map[string][]float32, name string, numExperts uint64) {
+	t.Helper()
+
+	tensor, ok := ts[name]
+	if !ok {
+		t.Fatalf("%s is missing", name)
+	}
+
+	// shapes of gguf tensors are reversed
+	if n := tensor.Shape[len(tensor.Shape)-1]; n != numExperts {
+		t.Fatalf("%s: expected %d experts, got %d", name, numExperts, n)
+	}
+
+	v := values[name]
+	perExpert := uint64(len(v)) / numExperts
+	for i := range numExperts {
+		if got := v[i*perExpert]; got != float32(i) {
+			t.Errorf("%s: expected expert %d to have value %d, got %f", name, i, i, got)
+		}
+	}
+}
+
+func TestConvertQwen3Moe(t *testing.T) {
+	const numExperts = 12
+
+	tensors := map[string]moeTensor{
+		"model.embed_tokens.weight":                      {[]uint64{2, 16}, 1},
+		"model.norm.weight":                              {[]uint64{16}, 1},
+		"lm_head.weight":                                 {[]uint64{2, 16}, 1},
+		"model.layers.0.input_layernorm.weight":          {[]uint64{16}, 1},
+		"model.layers.0.post_attention_layernorm.weight": {[]uint64{16}, 1},
+		"model.layers.0.self_attn.q_proj.weight":         {[]uint64{16, 16}, 1},
+		"model.layers.0.self_attn.q_norm.weight":         {[]uint64{8}, 1},
+		"model.layers.0.self_attn.k_proj.weight":         {[]uint64{8, 16}, 1},
+		"model.layers.0.self_attn.k_norm.weight":         {[]uint64{8}, 1},
+		"model.layers.0.self_attn.v_proj.weight":         {[]uint64{8, 16}, 1},
+		"model.layers.0.self_attn.o_proj.weight":         {[]uint64{16, 16}, 1},
+		"model.layers.0.mlp.gate.weight":                 {[]uint64{numExperts, 16}, 1},
+	}
+
+	for i := range numExperts {
+		for _, name := range []string{"gate_proj", "up_proj"} {
+			tensors[fmt.Sprintf("model.layers.0.mlp.experts.%d.%s.weight", i, name)] = moeTensor{[]uint64{4, 16}, float32(i)}
+		}
+		tensors[fmt.Sprintf("model.layers.0.mlp.experts.%d.down_proj.weight", i)] = moeTensor{[]uint64{16, 4}, float32(i)}
+	}
+
+	kv, ts, values := convertMoE(t, map[string]any{
+		"architectures":           []string{"Qwen3MoeForCausalLM"},
+		"vocab_size":              2,
+		"hidden_size":             16,
+		"num_hidden_layers":       1,
+		"num_attention_heads":     2,
+		"num_key_value_heads":     1,
+		"head_dim":                8,
+		"intermediate_size":       32,
+		"moe_intermediate_size":   4,
+		"num_experts":             numExperts,
+		"num_experts_per_tok":     2,
+		"norm_topk_prob":          true,
+		"max_position_embeddings": 4096,
+		"rope_theta":              1000000,
+		"rms_norm_eps":            1e-6,
+	}, tensors)
+
+	if diff := cmp.Diff(ggml.KV{
+		"general.architecture":                "qwen3moe",
+		"qwen3moe.expert_count":               uint32(numExperts),
+		"qwen3moe.expert_used_count":          uint32(2),
+		"qwen3moe.expert_feed_forward_length": uint32(4),
+		"qwen3moe.attention.key_length":       uint32(8),
+		"qwen3moe.attention.value_length":     uint32(8),
+		"qwen3moe.expert_weights_norm":        true,
+	}, ggml.KV{
+		"general.architecture":                kv["general.architecture"],
+		"qwen3moe.expert_count":               kv["qwen3moe.expert_count"],
+		"qwen3moe.expert_used_count":          kv["qwen3moe.expert_used_count"],
+		"qwen3moe.expert_feed_forward_length": kv["qwen3moe.expert_feed_forward_length"],
+		"qwen3moe.attention.key_length":       kv["qwen3moe.attention.key_length"],
+		"qwen3moe.attention.value_length":     kv["qwen3moe.attention.value_length"],
+		"qwen3moe.expert_weights_norm":        kv["qwen3moe.expert_weights_norm"],
+	}); diff != "" {
+		t.Errorf("kv mismatch (-want +got):\n%s", diff)
+	}
+
+	names := slices.Sorted(maps.Keys(ts))
+
+	if diff := cmp.Diff([]string{
+		"blk.0.attn_k.weight",
+		"blk.0.attn_k_norm.weight",
+		"blk.0.attn_norm.weight",
+		"blk.0.attn_output.weight",
+		"blk.0.attn_q.weight",
+		"blk.0.attn_q_norm.weight",
+		"blk.0.attn_v.weight",
+		"blk.0.ffn_down_exps.weight",
+		"blk.0.ffn_gate_exps.weight",
+		"blk.0.ffn_gate_inp.weight",
+		"blk.0.ffn_norm.weight",
+		"blk.0.ffn_up_exps.weight",
+		"output.weight",
+		"output_norm.weight",
+		"token_embd.weight",
+	}, names); diff != "" {
+		t.Errorf("tensors mismatch (-want +got):\n%s", diff)
+	}
+
+	// the router is kept in full precision
+	if kind := ts["blk.0.ffn_gate_inp.weight"].Kind; kind != tensorKindF32 {
+		t.Errorf("expected router to be f32, got %d", kind)
+	}
+
+	for _, name := range []string{"blk.0.ffn_gate_exps.weight", "blk.0.ffn_up_exps.weight", "blk.0.ffn_down_exps.weight"} {
+		checkExperts(t, ts, values, name, numExperts)
+	}
+}
+
+func TestConvertQwen2Moe(t *testing.T) {
+	tensors := map[string]moeTensor{
+		"model.embed_tokens.weight":                         {[]uint64{2, 16}, 1},
+		"model.norm.weight":                                 {[]uint64{16}, 1},
+		"model.layers.0.mlp.gate.weight":                    {[]uint64{2, 16}, 1},
+		"model.layers.0.mlp.shared_expert_gate.weight":      {[]uint64{1, 16}, 1},
+		"model.layers.0.mlp.shared_expert.gate_proj.weight": {[]uint64{8, 16}, 1},
+		"model.layers.0.mlp.shared_expert.up_proj.weight":   {[]uint64{8, 16}, 1},
+		"model.layers.0.mlp.shared_expert.down_proj.weight": {[]uint64{16, 8}, 1},
+		"model.layers.0.mlp.experts.0.gate_proj.weight":     {[]uint64{4, 16}, 0},
+		"model.layers.0.mlp.experts.1.gate_proj.weight":     {[]uint64{4, 16}, 1},
+	}
+
+	kv, ts, values := convertMoE(t, map[string]any{
+		"architectures":                   []string{"Qwen2MoeForCausalLM"},
+		"vocab_size":                      2,
+		"hidden_size":                     16,
+		"num_hidden_layers":               1,
+		"num_attention_heads":             2,
+		"num_key_value_heads":             2,
+		"num_experts":                     2,
+		"num_experts_per_tok":             1,
+		"moe_intermediate_size":           4,
+		"shared_expert_intermediate_size": 8,
+	}, tensors)
+
+	if arch := kv.Architecture(); arch != "qwen2moe" {
+		t.Errorf("expected architecture qwen2moe, got %s", arch)
+	}
+
+	if n := kv.Uint("block_count"); n != 1 {
+		t.Errorf("expected qwen2 parameters to be renamed, got block count %d", n)
+	}
+
+	if n := kv.Uint("expert_shared_feed_forward_length"); n != 8 {
+		t.Errorf("expected shared expert feed forward length 8, got %d", n)
+	}
+
+	for _, name := range []string{
+		"blk.0.ffn_gate_inp.weight",
+		"blk.0.ffn_gate_inp_shexp.weight",
+		"blk.0.ffn_gate_shexp.weight",
+		"blk.0.ffn_up_shexp.weight",
+		"blk.0.ffn_down_shexp.weight",
+	} {
+		if _, ok := ts[name]; !ok {
+			t.Errorf("%s is missing", name)
+		}
+	}
+
+	checkExperts(t, ts, values, "blk.0.ffn_gate_exps.weight", 2)
+}
+
+func TestConvertDeepseek2(t *testing.T) {
+	tensors := map[string]moeTensor{
+		"model.embed_tokens.weight":                          {[]uint64{2, 16}, 1},
+		"model.norm.weight":                                  {[]uint64{16}, 1},
+		"lm_head.weight":                                     {[]uint64{2, 16}, 1},
+		"model.layers.0.self_attn.q_a_proj.weight":           {[]uint64{8, 16}, 1},
+		"model.layers.0.self_attn.q_a_layernorm.weight":      {[]uint64{8}, 1},
+		"model.layers.0.self_attn.q_b_proj.weight":           {[]uint64{24, 8}, 1},
+		"model.layers.0.self_attn.kv_a_proj_with_mqa.weight": {[]uint64{12, 16}, 1},
+		"model.layers.0.self_attn.kv_a_layernorm.weight":     {[]uint64{8}, 1},
+		"model.layers.0.self_attn.kv_b_proj.weight":          {[]uint64{16, 8}, 1},
+		"model.layers.0.self_attn.o_proj.weight":             {[]uint64{16, 16}, 1},
+		"model.layers.0.mlp.gate_proj.weight":                {[]uint64{32, 16}, 1},
+		"model.layers.0.mlp.up_proj.weight":                  {[]uint64{32, 16}, 1},
+		"model.layers.0.mlp.down_proj.weight":                {[]uint64{16, 32}, 1},
+		"model.layers.1.mlp.gate.weight":                     {[]uint64{3, 16}, 1},
+		"model.layers.1.mlp.gate.e_score_correction_bias":    {[]uint64{3}, 1},
+		"model.layers.1.mlp.shared_experts.gate_proj.weight": {[]uint64{4, 16}, 1},
+		"model.layers.1.mlp.shared_experts.up_proj.weight":   {[]uint64{4, 16}, 1},
+		"model.layers.1.mlp.shared_experts.down_proj.weight": {[]uint64{16, 4}, 1},
+		// multi-token prediction layers are dropped
+		"model.layers.2.eh_proj.weight": {[]uint64{16, 32}, 1},
+	}
+
+	for i := range 3 {
+		tensors[fmt.Sprintf("model.layers.1.mlp.experts.%d.down_proj.weight", i)] = moeTensor{[]uint64{16, 4}, float32(i)}
+	}
+
+	kv, ts, values := convertMoE(t, map[string]any{
+		"architectures":           []string{"DeepseekV3ForCausalLM"},
+		"vocab_size":              2,
+		"hidden_size":             16,
+		"num_hidden_layers":       2,
+		"num_attention_heads":     2,
+		"num_key_value_heads":     2,
+		"intermediate_size":       32,
+		"moe_intermediate_size":   4,
+		"q_lora_rank":             8,
+		"kv_lora_rank":            8,
+		"qk_nope_head_dim":        8,
+		"qk_rope_head_dim":        4,
+		"v_head_dim":              8,
+		"n_routed_experts":        3,
+		"n_shared_experts":        1,
+		"num_experts_per_tok":     2,
+		"first_k_dense_replace":   1,
+		"routed_scaling_factor":   2.5,
+		"norm_topk_prob":          true,
+		"scoring_func":            "sigmoid",
+		"max_position_embeddings": 4096,
+		"rope_scaling": map[string]any{
+			"type":                             "yarn",
+			"factor":                           40,
+			"original_max_position_embeddings": 4096,
+			"mscale_all_dim":                   1,
+		},
+	}, tensors)
+
+	for key, want := range map[string]any{
+		"general.architecture":                       "deepseek2",
+		"deepseek2.leading_dense_block_count":        uint32(1),
+		"deepseek2.attention.q_lora_rank":            uint32(8),
+		"deepseek2.attention.kv_lora_rank":           uint32(8),
+		"deepseek2.attention.key_length":             uint32(12),
+		"deepseek2.attention.value_length":           uint32(8),
+		"deepseek2.rope.dimension_count":             uint32(4),
+		"deepseek2.expert_count":                     uint32(3),
+		"deepseek2.expert_used_count":                uint32(2),
+		"deepseek2.expert_shared_count":              uint32(1),
+		"deepseek2.expert_feed_forward_length":       uint32(4),
+		"deepseek2.expert_weights_scale":             float32(2.5),
+		"deepseek2.expert_weights_norm":              true,
+		"deepseek2.expert_gating_func":               expertGatingFuncSigmoid,
+		"deepseek2.rope.scaling.type":                "yarn",
+		"deepseek2.rope.scaling.yarn_log_multiplier": float32(0.1),
+	} {
+		if diff := cmp.Diff(want, kv[key]); diff != "" {
+			t.Errorf("%s mismatch (-want +got):\n%s", key, diff)
+		}
+	}
+
+	for _, name := range []string{
+		"blk.0.attn_q_a.weight",
+		"blk.0.attn_q_a_norm.weight",
+		"blk.0.attn_q_b.weight",
+		"blk.0.attn_kv_a_mqa.weight",
+		"blk.0.attn_kv_a_norm.weight",
+		"blk.0.attn_kv_b.weight",
+		"blk.0.ffn_gate.weight",
+		"blk.1.ffn_gate_inp.weight",
+		"blk.1.exp_probs_b.bias",
+		"blk.1.ffn_gate_shexp.weight",
+		"blk.1.ffn_down_shexp.weight",
+	} {
+		if _, ok := ts[name]; !ok {
+			t.Errorf("%s is missing", name)
+		}
+	}
+
+	if _, ok := ts["blk.2.eh_proj.weight"]; ok {
+		t.Error("expected multi-token prediction layers to be dropped")
+	}
+
+	checkExperts(t, ts, values, "blk.1.ffn_down_exps.weight", 3)
+}
+
+func TestMergeExpertsMissing(t *testing.T) {
+	ts := []Tensor{
+		safetensor{tensorBase: &tensorBase{name: "blk.0.mlp.experts.1.up_proj.weight", shape: []uint64{4, 4}}},
+	}
+
+	ts, merged := mergeExperts(ts, "mlp.experts", map[string]string{"up_proj": "ffn_up_exps"})
+	if len(ts) != 0 || len(merged) != 1 {
+		t.Fatalf("expected experts to be merged, got %d tensors and %d merged", len(ts), len(merged))
+	}
+
+	if _, err := merged[0].WriteTo(&bytes.Buffer{}); err == nil || err.Error() != "expert 0 is missing" {
+		t.Errorf("expected missing expert error, got %v", err)
+	}
+}
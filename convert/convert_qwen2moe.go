@@ -0,0 +1,56 @@
+package convert
+
+import (
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type qwen2MoeModel struct {
+	qwen2Model
+	NumExperts                   uint32 `json:"num_experts"`
+	NumExpertsPerToken           uint32 `json:"num_experts_per_tok"`
+	MoeIntermediateSize          uint32 `json:"moe_intermediate_size"`
+	SharedExpertIntermediateSize uint32 `json:"shared_expert_intermediate_size"`
+}
+
+var _ ModelConverter = (*qwen2MoeModel)(nil)
+
+func (q *qwen2MoeModel) KV(t *Tokenizer) ggml.KV {
+	kv := ggml.KV{}
+	for k, v := range q.qwen2Model.KV(t) {
+		if name, ok := strings.CutPrefix(k, "qwen2."); ok {
+			k = "qwen2moe." + name
+		}
+
+		kv[k] = v
+	}
+
+	kv["general.architecture"] = "qwen2moe"
+	kv["qwen2moe.expert_count"] = q.NumExperts
+	kv["qwen2moe.expert_used_count"] = q.NumExpertsPerToken
+	kv["qwen2moe.expert_feed_forward_length"] = q.MoeIntermediateSize
+	kv["qwen2moe.expert_shared_feed_forward_length"] = q.SharedExpertIntermediateSize
+	return kv
+}
+
+func (q *qwen2MoeModel) Tensors(ts []Tensor) []ggml.Tensor {
+	ts, out := mergeExperts(ts, "mlp.experts", map[string]string{
+		"gate_proj": "ffn_gate_exps",
+		"down_proj": "ffn_down_exps",
+		"up_proj":   "ffn_up_exps",
+	})
+
+	return append(out, q.qwen2Model.Tensors(ts)...)
+}
+
+func (q *qwen2MoeModel) Replacements() []string {
+	return append(
+		q.qwen2Model.Replacements(),
+		"mlp.gate.", "ffn_gate_inp.",
+		"mlp.shared_expert_gate.", "ffn_gate_inp_shexp.",
+		"mlp.shared_expert.gate_proj", "ffn_gate_shexp",
+		"mlp.shared_expert.down_proj", "ffn_down_shexp",
+		"mlp.shared_expert.up_proj", "ffn_up_shexp",
+	)
+}
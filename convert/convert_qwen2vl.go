@@ -0,0 +1,133 @@
+package convert
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type qwen2VLModel struct {
+	qwen2Model
+	RopeScaling struct {
+		Type         string  `json:"type"`
+		RopeType     string  `json:"rope_type"`
+		MRopeSection []int32 `json:"mrope_section"`
+	} `json:"rope_scaling"`
+	ImageTokenID       uint32 `json:"image_token_id"`
+	VisionStartTokenID uint32 `json:"vision_start_token_id"`
+	VisionEndTokenID   uint32 `json:"vision_end_token_id"`
+	VisionModel        struct {
+		Depth             uint32  `json:"depth"`
+		EmbedDim          uint32  `json:"embed_dim"`
+		MLPRatio          float32 `json:"mlp_ratio"`
+		NumHeads          uint32  `json:"num_heads"`
+		InChans           uint32  `json:"in_chans"`
+		InChannels        uint32  `json:"in_channels"`
+		PatchSize         uint32  `json:"patch_size"`
+		SpatialMergeSize  uint32  `json:"spatial_merge_size"`
+		TemporalPatchSize uint32  `json:"temporal_patch_size"`
+		LayerNormEpsilon  float32 `json:"layer_norm_eps"`
+	} `json:"vision_config"`
+}
+
+var _ ModelConverter = (*qwen2VLModel)(nil)
+
+func (q *qwen2VLModel) KV(t *Tokenizer) ggml.KV {
+	kv := ggml.KV{}
+	for k, v := range q.qwen2Model.KV(t) {
+		if name, ok := strings.CutPrefix(k, "qwen2."); ok {
+			k = "qwen2vl." + name
+		}
+
+		kv[k] = v
+	}
+
+	kv["general.architecture"] = "qwen2vl"
+
+	switch cmp.Or(q.RopeScaling.Type, q.RopeScaling.RopeType) {
+	case "", "default", "mrope":
+		// no scaling
+	default:
+		panic("unknown rope scaling type")
+	}
+
+	// multimodal rope divides the rotary dimensions between the temporal, height
+	// and width positions of each token. llama.cpp expects four sections
+	sections := []int32{16, 24, 24, 0}
+	if len(q.RopeScaling.MRopeSection) > 0 {
+		sections = make([]int32, 4)
+		copy(sections, q.RopeScaling.MRopeSection)
+	}
+	kv["qwen2vl.rope.dimension_sections"] = sections
+
+	kv["qwen2vl.image_token_id"] = q.ImageTokenID
+	kv["qwen2vl.vision_start_token_id"] = q.VisionStartTokenID
+	kv["qwen2vl.vision_end_token_id"] = q.VisionEndTokenID
+
+	embedDim := cmp.Or(q.VisionModel.EmbedDim, 1280)
+	kv["qwen2vl.vision.block_count"] = cmp.Or(q.VisionModel.Depth, 32)
+	kv["qwen2vl.vision.embedding_length"] = embedDim
+	kv["qwen2vl.vision.feed_forward_length"] = uint32(float32(embedDim) * cmp.Or(q.VisionModel.MLPRatio, 4))
+	kv["qwen2vl.vision.attention.head_count"] = cmp.Or(q.VisionModel.NumHeads, 16)
+	kv["qwen2vl.vision.attention.layer_norm_epsilon"] = cmp.Or(q.VisionModel.LayerNormEpsilon, 1e-6)
+	kv["qwen2vl.vision.num_channels"] = cmp.Or(q.VisionModel.InChans, q.VisionModel.InChannels, 3)
+	kv["qwen2vl.vision.patch_size"] = cmp.Or(q.VisionModel.PatchSize, 14)
+	kv["qwen2vl.vision.spatial_merge_size"] = cmp.Or(q.VisionModel.SpatialMergeSize, 2)
+	kv["qwen2vl.vision.temporal_patch_size"] = cmp.Or(q.VisionModel.TemporalPatchSize, 2)
+	// patches are positioned with a 2D rope over their row and column
+	kv["qwen2vl.vision.rope.freq_base"] = float32(10000)
+	return kv
+}
+
+func (q *qwen2VLModel) Tensors(ts []Tensor) []ggml.Tensor {
+	var out []ggml.Tensor
+	for _, t := range ts {
+		switch {
+		case strings.HasPrefix(t.Name(), "v.patch_embd."):
+			// the 3D convolution over pairs of frames is split into a 2D convolution
+			// for each frame, a still image being repeated for both
+			for _, part := range splitDim(t, 2,
+				strings.NewReplacer("v.patch_embd", "v.patch_embd_0"),
+				strings.NewReplacer("v.patch_embd", "v.patch_embd_1"),
+			) {
+				part.Shape = slices.Delete(part.Shape, 2, 3)
+				out = append(out, part)
+			}
+		case strings.Contains(t.Name(), ".attn_qkv."):
+			out = append(out, splitDim(t, 0,
+				strings.NewReplacer("attn_qkv", "attn_q"),
+				strings.NewReplacer("attn_qkv", "attn_k"),
+				strings.NewReplacer("attn_qkv", "attn_v"),
+			)...)
+		default:
+			out = append(out, ggml.Tensor{
+				Name:     t.Name(),
+				Kind:     t.Kind(),
+				Shape:    t.Shape(),
+				WriterTo: t,
+			})
+		}
+	}
+
+	return out
+}
+
+func (q *qwen2VLModel) Replacements() []string {
+	return append([]string{
+		"model.language_model.embed_tokens", "token_embd",
+		"model.language_model.layers", "blk",
+		"model.language_model.norm", "output_norm",
+		"model.visual", "v",
+		"visual", "v",
+		"patch_embed.proj", "patch_embd",
+		"blocks", "blk",
+		"attn.qkv", "attn_qkv",
+		"attn.proj", "attn_output",
+		"norm1", "ln1",
+		"norm2", "ln2",
+		"mlp.fc1", "ffn_up",
+		"mlp.fc2", "ffn_down",
+	}, q.qwen2Model.Replacements()...)
+}
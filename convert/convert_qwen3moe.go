@@ -0,0 +1,107 @@
+package convert
+
+import (
+	"cmp"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+type qwen3MoeModel struct {
+	ModelParameters
+	MaxPositionEmbeddings uint32  `json:"max_position_embeddings"`
+	HiddenSize            uint32  `json:"hidden_size"`
+	HiddenLayers          uint32  `json:"num_hidden_layers"`
+	IntermediateSize      uint32  `json:"intermediate_size"`
+	NumAttentionHeads     uint32  `json:"num_attention_heads"`
+	NumKeyValueHeads      uint32  `json:"num_key_value_heads"`
+	HeadDim               uint32  `json:"head_dim"`
+	RopeTheta             float32 `json:"rope_theta"`
+	RopeScaling           struct {
+		Type                          string     `json:"type"`
+		RopeType                      string     `json:"rope_type"`
+		Factor                        ropeFactor `json:"factor"`
+		OriginalMaxPositionEmbeddings uint32     `json:"original_max_position_embeddings"`
+	} `json:"rope_scaling"`
+	RMSNormEPS          float32 `json:"rms_norm_eps"`
+	NumExperts          uint32  `json:"num_experts"`
+	NumExpertsPerToken  uint32  `json:"num_experts_per_tok"`
+	MoeIntermediateSize uint32  `json:"moe_intermediate_size"`
+	NormTopKProb        bool    `json:"norm_topk_prob"`
+}
+
+var _ ModelConverter = (*qwen3MoeModel)(nil)
+
+func (q *qwen3MoeModel) KV(t *Tokenizer) ggml.KV {
+	kv := q.ModelParameters.KV(t)
+	kv["general.architecture"] = "qwen3moe"
+	kv["qwen3moe.block_count"] = q.HiddenLayers
+	kv["qwen3moe.context_length"] = q.MaxPositionEmbeddings
+	kv["qwen3moe.embedding_length"] = q.HiddenSize
+	kv["qwen3moe.feed_forward_length"] = q.IntermediateSize
+	kv["qwen3moe.attention.head_count"] = q.NumAttentionHeads
+	kv["qwen3moe.attention.head_count_kv"] = q.NumKeyValueHeads
+
+	// the heads of qwen3 aren't necessarily hidden_size / num_attention_heads
+	headDim := cmp.Or(q.HeadDim, q.HiddenSize/max(q.NumAttentionHeads, 1))
+	kv["qwen3moe.attention.key_length"] = headDim
+	kv["qwen3moe.attention.value_length"] = headDim
+	kv["qwen3moe.rope.freq_base"] = q.RopeTheta
+	kv["qwen3moe.attention.layer_norm_rms_epsilon"] = q.RMSNormEPS
+
+	kv["qwen3moe.expert_count"] = q.NumExperts
+	kv["qwen3moe.expert_used_count"] = q.NumExpertsPerToken
+	kv["qwen3moe.expert_feed_forward_length"] = q.MoeIntermediateSize
+	kv["qwen3moe.expert_weights_norm"] = q.NormTopKProb
+
+	switch cmp.Or(q.RopeScaling.Type, q.RopeScaling.RopeType) {
+	case "":
+		// no scaling
+	case "yarn":
+		kv["qwen3moe.rope.scaling.type"] = "yarn"
+		kv["qwen3moe.rope.scaling.factor"] = q.RopeScaling.Factor
+		if q.RopeScaling.OriginalMaxPositionEmbeddings > 0 {
+			kv["qwen3moe.rope.scaling.original_context_length"] = q.RopeScaling.OriginalMaxPositionEmbeddings
+		}
+	default:
+		panic("unknown rope scaling type")
+	}
+
+	return kv
+}
+
+func (q *qwen3MoeModel) Tensors(ts []Tensor) []ggml.Tensor {
+	ts, out := mergeExperts(ts, "mlp.experts", map[string]string{
+		"gate_proj": "ffn_gate_exps",
+		"down_proj": "ffn_down_exps",
+		"up_proj":   "ffn_up_exps",
+	})
+
+	for _, t := range ts {
+		out = append(out, ggml.Tensor{
+			Name:     t.Name(),
+			Kind:     t.Kind(),
+			Shape:    t.Shape(),
+			WriterTo: t,
+		})
+	}
+
+	return out
+}
+
+func (q *qwen3MoeModel) Replacements() []string {
+	return []string{
+		"lm_head", "output",
+		"model.embed_tokens", "token_embd",
+		"model.layers", "blk",
+		"input_layernorm", "attn_norm",
+		"self_attn.k_proj", "attn_k",
+		"self_attn.k_norm", "attn_k_norm",
+		"self_attn.v_proj", "attn_v",
+		"self_attn.q_proj", "attn_q",
+		"self_attn.q_norm", "attn_q_norm",
+		"self_attn.o_proj", "attn_output",
+		"mlp.gate.", "ffn_gate_inp.",
+		"post_attention_layernorm", "ffn_norm",
+		"model.norm", "output_norm",
+	}
+}
@@ -0,0 +1,255 @@
+package convert
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+func TestConvertQwen2VL(t *testing.T) {
+	config := map[string]any{
+		"architectures":         []string{"Qwen2VLForConditionalGeneration"},
+		"vocab_size":            2,
+		"hidden_size":           8,
+		"intermediate_size":     16,
+		"num_hidden_layers":     1,
+		"num_attention_heads":   2,
+		"num_key_value_heads":   1,
+		"rope_theta":            1000000,
+		"rms_norm_eps":          1e-6,
+		"image_token_id":        151655,
+		"vision_start_token_id": 151652,
+		"vision_end_token_id":   151653,
+		"rope_scaling":          map[string]any{"type": "mrope", "mrope_section": []int{1, 1, 2}},
+		"vision_config": map[string]any{
+			"depth":               1,
+			"embed_dim":           4,
+			"mlp_ratio":           2,
+			"num_heads":           2,
+			"in_chans":            3,
+			"patch_size":          2,
+			"spatial_merge_size":  2,
+			"temporal_patch_size": 2,
+		},
+	}
+
+	tensors := map[string]moeTensor{
+		"model.embed_tokens.weight":              {[]uint64{2, 8}, 1},
+		"model.layers.0.self_attn.q_proj.weight": {[]uint64{8, 8}, 1},
+		"model.norm.weight":                      {[]uint64{8}, 1},
+		"lm_head.weight":                         {[]uint64{2, 8}, 1},
+		"visual.patch_embed.proj.weight":         {[]uint64{4, 3, 2, 2, 2}, 1},
+		"visual.blocks.0.norm1.weight":           {[]uint64{4}, 1},
+		"visual.blocks.0.norm2.bias":             {[]uint64{4}, 1},
+		"visual.blocks.0.attn.qkv.weight":        {[]uint64{12, 4}, 1},
+		"visual.blocks.0.attn.qkv.bias":          {[]uint64{12}, 1},
+		"visual.blocks.0.attn.proj.weight":       {[]uint64{4, 4}, 1},
+		"visual.blocks.0.mlp.fc1.weight":         {[]uint64{8, 4}, 1},
+		"visual.blocks.0.mlp.fc2.weight":         {[]uint64{4, 8}, 1},
+		"visual.merger.ln_q.weight":              {[]uint64{4}, 1},
+		"visual.merger.mlp.0.weight":             {[]uint64{16, 16}, 1},
+		"visual.merger.mlp.2.weight":             {[]uint64{8, 16}, 1},
+	}
+
+	kv, ts, _ := convertMoE(t, config, tensors)
+
+	if got := kv.Architecture(); got != "qwen2vl" {
+		t.Errorf("expected architecture qwen2vl, got %q", got)
+	}
+
+	for key, want := range map[string]any{
+		"qwen2vl.block_count":                 uint32(1),
+		"qwen2vl.embedding_length":            uint32(8),
+		"qwen2vl.image_token_id":              uint32(151655),
+		"qwen2vl.vision.block_count":          uint32(1),
+		"qwen2vl.vision.embedding_length":     uint32(4),
+		"qwen2vl.vision.feed_forward_length":  uint32(8),
+		"qwen2vl.vision.attention.head_count": uint32(2),
+		"qwen2vl.vision.spatial_merge_size":   uint32(2),
+		"qwen2vl.vision.temporal_patch_size":  uint32(2),
+	} {
+		if got := kv[key]; got != want {
+			t.Errorf("expected %s to be %v, got %v", key, want, kv[key])
+		}
+	}
+
+	if diff := cmp.Diff([]uint32{1, 1, 2, 0}, kv.Uints("rope.dimension_sections")); diff != "" {
+		t.Errorf("unexpected rope sections (-want +got):\n%s", diff)
+	}
+
+	shapes := map[string][]uint64{
+		"token_embd.weight":          {2, 8},
+		"blk.0.attn_q.weight":        {8, 8},
+		"output_norm.weight":         {8},
+		"output.weight":              {2, 8},
+		"v.patch_embd_0.weight":      {4, 3, 2, 2},
+		"v.patch_embd_1.weight":      {4, 3, 2, 2},
+		"v.blk.0.ln1.weight":         {4},
+		"v.blk.0.ln2.bias":           {4},
+		"v.blk.0.attn_q.weight":      {4, 4},
+		"v.blk.0.attn_k.weight":      {4, 4},
+		"v.blk.0.attn_v.weight":      {4, 4},
+		"v.blk.0.attn_q.bias":        {4},
+		"v.blk.0.attn_k.bias":        {4},
+		"v.blk.0.attn_v.bias":        {4},
+		"v.blk.0.attn_output.weight": {4, 4},
+		"v.blk.0.ffn_up.weight":      {8, 4},
+		"v.blk.0.ffn_down.weight":    {4, 8},
+		"v.merger.ln_q.weight":       {4},
+		"v.merger.mlp.0.weight":      {16, 16},
+		"v.merger.mlp.2.weight":      {8, 16},
+	}
+
+	checkShapes(t, ts, shapes)
+}
+
+func TestConvertMistral3(t *testing.T) {
+	config := map[string]any{
+		"architectures":      []string{"Mistral3ForConditionalGeneration"},
+		"image_token_index":  10,
+		"spatial_merge_size": 2,
+		"text_config": map[string]any{
+			"vocab_size":          2,
+			"hidden_size":         8,
+			"head_dim":            2,
+			"intermediate_size":   16,
+			"num_hidden_layers":   1,
+			"num_attention_heads": 2,
+			"num_key_value_heads": 1,
+			"rope_theta":          1000000000,
+			"rms_norm_eps":        1e-5,
+		},
+		"vision_config": map[string]any{
+			"model_type":          "pixtral",
+			"hidden_size":         4,
+			"intermediate_size":   8,
+			"num_hidden_layers":   1,
+			"num_attention_heads": 2,
+			"head_dim":            2,
+			"image_size":          16,
+			"patch_size":          4,
+			"rope_theta":          10000,
+		},
+	}
+
+	tensors := map[string]moeTensor{
+		"language_model.model.embed_tokens.weight":                      {[]uint64{2, 8}, 1},
+		"language_model.model.layers.0.self_attn.q_proj.weight":         {[]uint64{4, 8}, 1},
+		"language_model.model.layers.0.self_attn.k_proj.weight":         {[]uint64{2, 8}, 1},
+		"language_model.model.layers.0.input_layernorm.weight":          {[]uint64{8}, 1},
+		"language_model.model.norm.weight":                              {[]uint64{8}, 1},
+		"language_model.lm_head.weight":                                 {[]uint64{2, 8}, 1},
+		"vision_tower.patch_conv.weight":                                {[]uint64{4, 3, 4, 4}, 1},
+		"vision_tower.ln_pre.weight":                                    {[]uint64{4}, 1},
+		"vision_tower.transformer.layers.0.attention.q_proj.weight":     {[]uint64{4, 4}, 1},
+		"vision_tower.transformer.layers.0.attention_norm.weight":       {[]uint64{4}, 1},
+		"vision_tower.transformer.layers.0.feed_forward.up_proj.weight": {[]uint64{8, 4}, 1},
+		"vision_tower.transformer.layers.0.ffn_norm.weight":             {[]uint64{4}, 1},
+		"multi_modal_projector.norm.weight":                             {[]uint64{4}, 1},
+		"multi_modal_projector.patch_merger.merging_layer.weight":       {[]uint64{4, 16}, 1},
+		"multi_modal_projector.linear_1.weight":                         {[]uint64{8, 4}, 1},
+		"multi_modal_projector.linear_2.weight":                         {[]uint64{8, 8}, 1},
+	}
+
+	kv, ts, _ := convertMoE(t, config, tensors)
+
+	if got := kv.Architecture(); got != "mistral3" {
+		t.Errorf("expected architecture mistral3, got %q", got)
+	}
+
+	for key, want := range map[string]any{
+		"mistral3.block_count":                 uint32(1),
+		"mistral3.embedding_length":            uint32(8),
+		"mistral3.attention.head_count":        uint32(2),
+		"mistral3.rope.dimension_count":        uint32(2),
+		"mistral3.image_token_index":           uint32(10),
+		"mistral3.spatial_merge_size":          uint32(2),
+		"mistral3.vision.block_count":          uint32(1),
+		"mistral3.vision.embedding_length":     uint32(4),
+		"mistral3.vision.attention.key_length": uint32(2),
+		"mistral3.vision.image_size":           uint32(16),
+		"mistral3.vision.patch_size":           uint32(4),
+		"mistral3.vision.rope.freq_base":       float32(10000),
+		"mistral3.mm.projector_hidden_act":     "gelu",
+	} {
+		if got := kv[key]; got != want {
+			t.Errorf("expected %s to be %v, got %v", key, want, kv[key])
+		}
+	}
+
+	shapes := map[string][]uint64{
+		"token_embd.weight":                    {2, 8},
+		"blk.0.attn_q.weight":                  {4, 8},
+		"blk.0.attn_k.weight":                  {2, 8},
+		"blk.0.attn_norm.weight":               {8},
+		"output_norm.weight":                   {8},
+		"output.weight":                        {2, 8},
+		"v.patch_embd.weight":                  {4, 3, 4, 4},
+		"v.encoder_norm.weight":                {4},
+		"v.blk.0.attn_q.weight":                {4, 4},
+		"v.blk.0.attn_norm.weight":             {4},
+		"v.blk.0.ffn_up.weight":                {8, 4},
+		"v.blk.0.ffn_norm.weight":              {4},
+		"mm.norm.weight":                       {4},
+		"mm.patch_merger.merging_layer.weight": {4, 16},
+		"mm.linear_1.weight":                   {8, 4},
+		"mm.linear_2.weight":                   {8, 8},
+	}
+
+	checkShapes(t, ts, shapes)
+}
+
+func TestConvertLlavaUnsupportedVision(t *testing.T) {
+	config := map[string]any{
+		"architectures": []string{"LlavaForConditionalGeneration"},
+		"text_config":   map[string]any{"vocab_size": 2},
+		"vision_config": map[string]any{"model_type": "clip_vision_model"},
+	}
+
+	dir := t.TempDir()
+	bts, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), bts, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ConvertModel(os.DirFS(dir), nil); err == nil || err.Error() != `unsupported vision model "clip_vision_model"` {
+		t.Errorf("expected unsupported vision model error, got %v", err)
+	}
+}
+
+// checkShapes checks that ts are exactly the tensors of shapes, which are in
+// the order of the checkpoint rather than that of ggml.
+func checkShapes(t *testing.T, ts map[string]*ggml.Tensor, shapes map[string][]uint64) {
+	t.Helper()
+
+	for _, name := range slices.Sorted(maps.Keys(ts)) {
+		want, ok := shapes[name]
+		if !ok {
+			t.Errorf("unexpected tensor %s", name)
+			continue
+		}
+
+		got := slices.Clone(ts[name].Shape)
+		slices.Reverse(got)
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("unexpected shape of %s (-want +got):\n%s", name, diff)
+		}
+	}
+
+	for name := range shapes {
+		if _, ok := ts[name]; !ok {
+			t.Errorf("expected tensor %s", name)
+		}
+	}
+}
@@ -0,0 +1,438 @@
+package convert
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ollama/ollama/transport"
+)
+
+// HubReader is a read-only fs.FS of a Hugging Face Hub repository. Nothing is
+// downloaded up front: files are read lazily with HTTP range requests, so
+// safetensors are converted tensor by tensor without first copying the whole
+// repository to disk.
+type HubReader struct {
+	ctx    context.Context
+	client *http.Client
+	base   string
+	token  string
+
+	// commit is the commit the revision resolved to, which files are read
+	// at so they're all from the same revision
+	commit string
+
+	// files maps the path of each file in the repository to its size
+	files map[string]int64
+
+	downloaded atomic.Int64
+}
+
+type hubSibling struct {
+	Name string `json:"rfilename"`
+	Size int64  `json:"size"`
+}
+
+// NewHubReader lists the files of repo, e.g. "org/model", at revision using
+// the Hub API at endpoint. token, if set, authorizes access to gated and
+// private repositories.
+func NewHubReader(ctx context.Context, endpoint, repo, revision, token string) (*HubReader, error) {
+	if !validHubRepo(repo) {
+		return nil, fmt.Errorf("invalid repository %q", repo)
+	}
+
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	revision = url.PathEscape(cmp.Or(revision, "main"))
+
+	t, err := transport.Default()
+	if err != nil {
+		return nil, err
+	}
+
+	h := HubReader{
+		ctx:    ctx,
+		client: &http.Client{Transport: t},
+		base:   fmt.Sprintf("%s/%s/resolve/%s/", endpoint, repo, revision),
+		token:  token,
+	}
+
+	resp, err := h.do(fmt.Sprintf("%s/api/models/%s/revision/%s?blobs=true", endpoint, repo, revision), "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, hubError(repo, resp)
+	}
+
+	var info struct {
+		SHA      string       `json:"sha"`
+		Siblings []hubSibling `json:"siblings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+
+	if info.SHA != "" {
+		h.commit = info.SHA
+		h.base = fmt.Sprintf("%s/%s/resolve/%s/", endpoint, repo, url.PathEscape(info.SHA))
+	}
+
+	h.files = make(map[string]int64, len(info.Siblings))
+	for _, s := range info.Siblings {
+		if fs.ValidPath(s.Name) {
+			h.files[s.Name] = s.Size
+		}
+	}
+
+	return &h, nil
+}
+
+// validHubRepo reports whether repo is a repository name, optionally
+// prefixed with its namespace.
+func validHubRepo(repo string) bool {
+	parts := strings.Split(repo, "/")
+	if len(parts) > 2 {
+		return false
+	}
+
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || strings.ContainsFunc(part, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.')
+		}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hubError(name string, resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		// the hub answers 401 for repositories which don't exist too
+		return fmt.Errorf("%s: access denied, set HF_TOKEN to a token with access to the repository", name)
+	default:
+		return fmt.Errorf("%s: unexpected status %s", name, resp.Status)
+	}
+}
+
+func (h *HubReader) do(rawURL, rangeHeader string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(h.ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+
+	return h.client.Do(req)
+}
+
+// get returns the bytes from start up to and including end of file name.
+func (h *HubReader) get(name string, start, end int64) (io.ReadCloser, error) {
+	segments := strings.Split(name, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+
+	resp, err := h.do(h.base+strings.Join(segments, "/"), fmt.Sprintf("bytes=%d-%d", start, end))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusOK && start == 0:
+		// the whole file is returned when ranges aren't supported, which
+		// is only usable if reading from the start
+	case resp.StatusCode == http.StatusOK:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: range requests aren't supported", name)
+	default:
+		resp.Body.Close()
+		return nil, hubError(name, resp)
+	}
+
+	return &countingReadCloser{resp.Body, &h.downloaded}, nil
+}
+
+// Size returns the combined size of the files in the repository matching
+// pattern, e.g. "*.safetensors".
+func (h *HubReader) Size(pattern string) (size int64) {
+	for name, n := range h.files {
+		if ok, _ := path.Match(pattern, name); ok {
+			size += n
+		}
+	}
+
+	return size
+}
+
+// Commit returns the commit of the repository the files are read at, or an
+// empty string if the Hub didn't resolve the revision to one.
+func (h *HubReader) Commit() string {
+	return h.commit
+}
+
+// Downloaded returns the number of bytes read from the repository so far.
+func (h *HubReader) Downloaded() int64 {
+	return h.downloaded.Load()
+}
+
+func (h *HubReader) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if size, ok := h.files[name]; ok {
+		return &hubFile{h: h, name: name, size: size}, nil
+	}
+
+	entries, err := h.ReadDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return &hubDir{name: name, entries: entries}, nil
+}
+
+func (h *HubReader) Stat(name string) (fs.FileInfo, error) {
+	f, err := h.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Stat()
+}
+
+func (h *HubReader) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+
+	seen := make(map[string]struct{})
+	var entries []fs.DirEntry
+	for p, size := range h.files {
+		rest, ok := strings.CutPrefix(p, prefix)
+		if !ok {
+			continue
+		}
+
+		child, _, isDir := strings.Cut(rest, "/")
+		if _, ok := seen[child]; ok {
+			continue
+		}
+		seen[child] = struct{}{}
+
+		if isDir {
+			entries = append(entries, fs.FileInfoToDirEntry(hubFileInfo{name: child, dir: true}))
+		} else {
+			entries = append(entries, fs.FileInfoToDirEntry(hubFileInfo{name: child, size: size}))
+		}
+	}
+
+	if entries == nil && name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	return entries, nil
+}
+
+type hubFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (fi hubFileInfo) Name() string       { return fi.name }
+func (fi hubFileInfo) Size() int64        { return fi.size }
+func (fi hubFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi hubFileInfo) IsDir() bool        { return fi.dir }
+func (fi hubFileInfo) Sys() any           { return nil }
+
+func (fi hubFileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0o555
+	}
+
+	return 0o444
+}
+
+// hubFile reads a file of a HubReader. Reads stream the file from the
+// current offset, while ReadAt requests exactly the range it reads so
+// tensors are downloaded one at a time.
+type hubFile struct {
+	h      *HubReader
+	name   string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (f *hubFile) Stat() (fs.FileInfo, error) {
+	return hubFileInfo{name: path.Base(f.name), size: f.size}, nil
+}
+
+func (f *hubFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+
+	if f.body == nil {
+		body, err := f.h.get(f.name, f.offset, f.size-1)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+
+	n, err := f.body.Read(p[:min(int64(len(p)), f.size-f.offset)])
+	f.offset += int64(n)
+	if errors.Is(err, io.EOF) {
+		f.body.Close()
+		f.body = nil
+		if f.offset < f.size {
+			err = io.ErrUnexpectedEOF
+		} else if n > 0 {
+			err = nil
+		}
+	}
+
+	return n, err
+}
+
+func (f *hubFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &fs.PathError{Op: "readat", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if off >= f.size {
+		return 0, io.EOF
+	}
+
+	end := min(off+int64(len(p)), f.size)
+	if end == off {
+		return 0, nil
+	}
+
+	body, err := f.h.get(f.name, off, end-1)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	n, err := io.ReadFull(body, p[:end-off])
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (f *hubFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *hubFile) Close() error {
+	if f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+
+	return nil
+}
+
+type hubDir struct {
+	name    string
+	entries []fs.DirEntry
+}
+
+func (d *hubDir) Stat() (fs.FileInfo, error) {
+	return hubFileInfo{name: path.Base(d.name), dir: true}, nil
+}
+
+func (d *hubDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *hubDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *hubDir) Close() error {
+	return nil
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	n *atomic.Int64
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,237 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testCommit = "4a5c1f0e8d2b7c3a9e6f1d0b2c8a7e5f3d1b9c6a"
+
+// newTestHub serves files as the repository org/model of a Hugging Face Hub,
+// returning the number of bytes of files served.
+func newTestHub(t *testing.T, files map[string][]byte) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+
+	var served atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/models/org/model/revision/main", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var siblings []hubSibling
+		for name, data := range files {
+			siblings = append(siblings, hubSibling{Name: name, Size: int64(len(data))})
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"sha": testCommit, "siblings": siblings})
+	})
+
+	mux.HandleFunc("GET /org/model/resolve/"+testCommit+"/{name...}", func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.PathValue("name")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		cw := countingWriter{ResponseWriter: w, n: &served}
+		http.ServeContent(cw, r, r.PathValue("name"), time.Time{}, bytes.NewReader(data))
+	})
+
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s, &served
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	w.n.Add(int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}
+
+func safetensorsFile(t *testing.T, tensors map[string][]float32) []byte {
+	t.Helper()
+
+	headers := make(map[string]safetensorMetadata)
+	var data bytes.Buffer
+	for _, name := range []string{"a.weight", "b.weight"} {
+		start := int64(data.Len())
+		if err := binary.Write(&data, binary.LittleEndian, tensors[name]); err != nil {
+			t.Fatal(err)
+		}
+
+		headers[name] = safetensorMetadata{Type: "F32", Shape: []uint64{uint64(len(tensors[name]))}, Offsets: []int64{start, int64(data.Len())}}
+	}
+
+	header, err := json.Marshal(headers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, int64(len(header))); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Write(header)
+	b.Write(data.Bytes())
+	return b.Bytes()
+}
+
+func TestHubReader(t *testing.T) {
+	tensors := map[string][]float32{
+		"a.weight": make([]float32, 4096),
+		"b.weight": {1, 2, 3, 4},
+	}
+
+	files := map[string][]byte{
+		"config.json":                      []byte(`{"architectures": ["LlamaForCausalLM"]}`),
+		"1_Pooling/config.json":            []byte(`{}`),
+		"model-00001-of-00001.safetensors": safetensorsFile(t, tensors),
+	}
+
+	s, served := newTestHub(t, files)
+
+	fsys, err := NewHubReader(t.Context(), s.URL, "org/model", "main", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fstest.TestFS(fsys, "config.json", "1_Pooling/config.json", "model-00001-of-00001.safetensors"); err != nil {
+		t.Fatal(err)
+	}
+
+	if commit := fsys.Commit(); commit != testCommit {
+		t.Errorf("expected commit %s, got %s", testCommit, commit)
+	}
+
+	if size := fsys.Size("*.safetensors"); size != int64(len(files["model-00001-of-00001.safetensors"])) {
+		t.Errorf("expected size of safetensors %d, got %d", len(files["model-00001-of-00001.safetensors"]), size)
+	}
+
+	t.Run("tensors", func(t *testing.T) {
+		served.Store(0)
+		ts, err := parseTensors(fsys, strings.NewReplacer())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n := served.Load(); n > 1024 {
+			t.Errorf("expected only the header to be read, read %d bytes", n)
+		}
+
+		for _, tensor := range ts {
+			if tensor.Name() != "b.weight" {
+				continue
+			}
+
+			served.Store(0)
+			var b bytes.Buffer
+			if _, err := tensor.WriteTo(&b); err != nil {
+				t.Fatal(err)
+			}
+
+			if n := served.Load(); n != 16 {
+				t.Errorf("expected only the tensor to be read, read %d bytes", n)
+			}
+
+			f32s := make([]float32, 4)
+			if err := binary.Read(&b, binary.LittleEndian, f32s); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tensors["b.weight"], f32s); diff != "" {
+				t.Errorf("tensor mismatch (-want +got):\n%s", diff)
+			}
+		}
+
+		if fsys.Downloaded() == 0 {
+			t.Error("expected downloaded bytes to be counted")
+		}
+	})
+
+	t.Run("matches local", func(t *testing.T) {
+		dir := t.TempDir()
+		for name, data := range files {
+			if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, fsys := range []fs.FS{os.DirFS(dir), fsys} {
+			ts, err := parseTensors(fsys, strings.NewReplacer())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(ts) != 2 {
+				t.Fatalf("expected 2 tensors, got %d", len(ts))
+			}
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := fs.ReadFile(fsys, "tokenizer.json"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected not exist, got %v", err)
+		}
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		if _, err := NewHubReader(t.Context(), s.URL, "org/model", "main", ""); err == nil || !strings.Contains(err.Error(), "HF_TOKEN") {
+			t.Errorf("expected access denied, got %v", err)
+		}
+	})
+
+	t.Run("invalid repository", func(t *testing.T) {
+		for _, repo := range []string{"", "org/../model", "a/b/c", "org/model?x"} {
+			if _, err := NewHubReader(t.Context(), s.URL, repo, "main", "token"); err == nil {
+				t.Errorf("%q: expected error", repo)
+			}
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		f, err := fsys.Open("model-00001-of-00001.safetensors")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		if _, err := f.(io.Seeker).Seek(-16, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(b, files["model-00001-of-00001.safetensors"][len(files["model-00001-of-00001.safetensors"])-16:]) {
+			t.Errorf("unexpected data %v", b)
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"io"
 	"io/fs"
+	"slices"
 	"strings"
 )
 
@@ -13,6 +14,8 @@ type Tensor interface {
 	Kind() uint32
 	SetRepacker(repacker)
 	WriteTo(io.Writer) (int64, error)
+	// Clone returns a copy of the tensor which can be repacked separately
+	Clone() Tensor
 }
 
 type tensorBase struct {
@@ -55,6 +58,14 @@ func (t *tensorBase) SetRepacker(fn repacker) {
 	t.repacker = fn
 }
 
+func (t tensorBase) clone() *tensorBase {
+	return &tensorBase{
+		name:     t.name,
+		shape:    slices.Clone(t.shape),
+		repacker: t.repacker,
+	}
+}
+
 type repacker func(string, []float32, []uint64) ([]float32, error)
 
 func parseTensors(fsys fs.FS, replacer *strings.Replacer) ([]Tensor, error) {
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"slices"
 	"strings"
 
@@ -25,26 +26,10 @@ type safetensorMetadata struct {
 func parseSafetensors(fsys fs.FS, replacer *strings.Replacer, ps ...string) ([]Tensor, error) {
 	var ts []Tensor
 	for _, p := range ps {
-		f, err := fsys.Open(p)
+		headers, n, err := readSafetensorsHeader(fsys, p)
 		if err != nil {
 			return nil, err
 		}
-		defer f.Close()
-
-		var n int64
-		if err := binary.Read(f, binary.LittleEndian, &n); err != nil {
-			return nil, err
-		}
-
-		b := bytes.NewBuffer(make([]byte, 0, n))
-		if _, err = io.CopyN(b, f, n); err != nil {
-			return nil, err
-		}
-
-		var headers map[string]safetensorMetadata
-		if err := json.NewDecoder(b).Decode(&headers); err != nil {
-			return nil, err
-		}
 
 		keys := maps.Keys(headers)
 		slices.Sort(keys)
@@ -80,6 +65,40 @@ func parseSafetensors(fsys fs.FS, replacer *strings.Replacer, ps ...string) ([]T
 	return ts, nil
 }
 
+// readSafetensorsHeader returns the tensors of safetensors file p and the
+// size of its header. Only the header is read from the file.
+func readSafetensorsHeader(fsys fs.FS, p string) (map[string]safetensorMetadata, int64, error) {
+	f, err := fsys.Open(p)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if ra, ok := f.(io.ReaderAt); ok {
+		// files which are read remotely would otherwise be requested
+		// from the header to the end
+		r = io.NewSectionReader(ra, 0, math.MaxInt64)
+	}
+
+	var n int64
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, 0, err
+	}
+
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return nil, 0, err
+	}
+
+	var headers map[string]safetensorMetadata
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&headers); err != nil {
+		return nil, 0, err
+	}
+
+	return headers, n, nil
+}
+
 // safetensorsPad returns the padded size of the safetensors file given a length n and offset s
 func safetensorsPad(n, offset int64) int64 {
 	return 8 + n + offset
@@ -94,6 +113,17 @@ type safetensor struct {
 	*tensorBase
 }
 
+func (st safetensor) Clone() Tensor {
+	return safetensor{
+		fs:         st.fs,
+		path:       st.path,
+		dtype:      st.dtype,
+		offset:     st.offset,
+		size:       st.size,
+		tensorBase: st.tensorBase.clone(),
+	}
+}
+
 func (st safetensor) WriteTo(w io.Writer) (int64, error) {
 	f, err := st.fs.Open(st.path)
 	if err != nil {
@@ -101,7 +131,12 @@ func (st safetensor) WriteTo(w io.Writer) (int64, error) {
 	}
 	defer f.Close()
 
-	if seeker, ok := f.(io.Seeker); ok {
+	var r io.Reader = f
+	if ra, ok := f.(io.ReaderAt); ok {
+		// read exactly the tensor, which is a single range request for
+		// files which are read remotely
+		r = io.NewSectionReader(ra, st.offset, st.size)
+	} else if seeker, ok := f.(io.Seeker); ok {
 		if _, err := seeker.Seek(st.offset, io.SeekStart); err != nil {
 			return 0, err
 		}
@@ -115,12 +150,12 @@ func (st safetensor) WriteTo(w io.Writer) (int64, error) {
 	switch st.dtype {
 	case "F32":
 		f32s = make([]float32, st.size/4)
-		if err = binary.Read(f, binary.LittleEndian, f32s); err != nil {
+		if err = binary.Read(r, binary.LittleEndian, f32s); err != nil {
 			return 0, err
 		}
 	case "F16":
 		u16s := make([]uint16, st.size/2)
-		if err = binary.Read(f, binary.LittleEndian, u16s); err != nil {
+		if err = binary.Read(r, binary.LittleEndian, u16s); err != nil {
 			return 0, err
 		}
 
@@ -131,7 +166,7 @@ func (st safetensor) WriteTo(w io.Writer) (int64, error) {
 
 	case "BF16":
 		u8s := make([]uint8, st.size)
-		if err = binary.Read(f, binary.LittleEndian, u8s); err != nil {
+		if err = binary.Read(r, binary.LittleEndian, u8s); err != nil {
 			return 0, err
 		}
 
@@ -43,6 +43,13 @@ type torch struct {
 	*tensorBase
 }
 
+func (pt torch) Clone() Tensor {
+	return torch{
+		storage:    pt.storage,
+		tensorBase: pt.tensorBase.clone(),
+	}
+}
+
 func (pt torch) WriteTo(w io.Writer) (int64, error) {
 	return 0, nil
 }
@@ -0,0 +1,85 @@
+package convert
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+// splitDim splits t along dim into equal parts, one for each replacer, which
+// names the part by replacing the name of t. This is used for tensors which
+// are fused in the checkpoint, e.g. a combined query, key and value
+// projection. Any repacker of t is replaced by one which selects the part.
+func splitDim(t Tensor, dim int, replacers ...*strings.Replacer) []ggml.Tensor {
+	shape := slices.Clone(t.Shape())
+	size := shape[dim] / uint64(len(replacers))
+	shape[dim] = size
+
+	out := make([]ggml.Tensor, 0, len(replacers))
+	for i, r := range replacers {
+		offset := uint64(i) * size
+
+		part := t.Clone()
+		part.SetRepacker(func(_ string, data []float32, shape []uint64) ([]float32, error) {
+			return sliceDim(data, shape, dim, offset, size), nil
+		})
+
+		out = append(out, ggml.Tensor{
+			Name:     r.Replace(t.Name()),
+			Kind:     t.Kind(),
+			Shape:    slices.Clone(shape),
+			WriterTo: part,
+		})
+	}
+
+	return out
+}
+
+// sliceDim returns the elements of data, which is row major with shape, from
+// offset to offset+size along dim.
+func sliceDim(data []float32, shape []uint64, dim int, offset, size uint64) []float32 {
+	outer, inner := uint64(1), uint64(1)
+	for _, n := range shape[:dim] {
+		outer *= n
+	}
+
+	for _, n := range shape[dim+1:] {
+		inner *= n
+	}
+
+	out := make([]float32, 0, outer*size*inner)
+	for i := range outer {
+		start := (i*shape[dim] + offset) * inner
+		out = append(out, data[start:start+size*inner]...)
+	}
+
+	return out
+}
+
+// tensorValues returns the values of t as float32s in row major order.
+func tensorValues(t Tensor) ([]float32, error) {
+	var values []float32
+	c := t.Clone()
+	c.SetRepacker(func(_ string, data []float32, _ []uint64) ([]float32, error) {
+		values = data
+		return data, nil
+	})
+
+	if _, err := c.WriteTo(io.Discard); err != nil {
+		return nil, err
+	}
+
+	var size uint64 = 1
+	for _, n := range t.Shape() {
+		size *= n
+	}
+
+	if uint64(len(values)) != size {
+		return nil, fmt.Errorf("couldn't read values of tensor %s", t.Name())
+	}
+
+	return values, nil
+}
@@ -0,0 +1,104 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeTensor is an F32 tensor of data held in memory.
+type fakeTensor struct {
+	data []float32
+	*tensorBase
+}
+
+func (t fakeTensor) Clone() Tensor {
+	return fakeTensor{data: t.data, tensorBase: t.tensorBase.clone()}
+}
+
+func (t fakeTensor) WriteTo(w io.Writer) (int64, error) {
+	data := t.data
+	if t.repacker != nil {
+		var err error
+		data, err = t.repacker(t.name, slices.Clone(data), t.shape)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return 0, binary.Write(w, binary.LittleEndian, data)
+}
+
+func TestSplitDim(t *testing.T) {
+	// values are their index so slices of the tensor are easily recognized
+	data := make([]float32, 12)
+	for i := range data {
+		data[i] = float32(i)
+	}
+
+	tensor := fakeTensor{data: data, tensorBase: &tensorBase{name: "blk.0.attn_qkv.weight", shape: []uint64{2, 6}}}
+
+	cases := []struct {
+		dim   int
+		names []string
+		want  [][]float32
+	}{
+		{
+			dim:   0,
+			names: []string{"attn_q", "attn_k"},
+			want:  [][]float32{{0, 1, 2, 3, 4, 5}, {6, 7, 8, 9, 10, 11}},
+		},
+		{
+			dim:   1,
+			names: []string{"attn_q", "attn_k", "attn_v"},
+			want:  [][]float32{{0, 1, 6, 7}, {2, 3, 8, 9}, {4, 5, 10, 11}},
+		},
+	}
+
+	for _, tt := range cases {
+		var replacers []*strings.Replacer
+		for _, name := range tt.names {
+			replacers = append(replacers, strings.NewReplacer("attn_qkv", name))
+		}
+
+		parts := splitDim(tensor, tt.dim, replacers...)
+		if len(parts) != len(tt.names) {
+			t.Fatalf("expected %d parts, got %d", len(tt.names), len(parts))
+		}
+
+		for i, part := range parts {
+			if want := "blk.0." + tt.names[i] + ".weight"; part.Name != want {
+				t.Errorf("expected name %q, got %q", want, part.Name)
+			}
+
+			wantShape := []uint64{2, 6}
+			wantShape[tt.dim] /= uint64(len(tt.names))
+			if diff := cmp.Diff(wantShape, part.Shape); diff != "" {
+				t.Errorf("unexpected shape (-want +got):\n%s", diff)
+			}
+
+			var b bytes.Buffer
+			if _, err := part.WriteTo(&b); err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]float32, b.Len()/4)
+			if err := binary.Read(&b, binary.LittleEndian, got); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.want[i], got); diff != "" {
+				t.Errorf("unexpected values of %s (-want +got):\n%s", part.Name, diff)
+			}
+		}
+	}
+
+	if tensor.repacker != nil {
+		t.Error("expected the split tensor to be unchanged")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"log/slog"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
 
 	"golang.org/x/exp/maps"
@@ -46,7 +47,27 @@ func parseTokenizer(fsys fs.FS, specialTokenTypes []string) (*Tokenizer, error)
 	}
 
 	addedTokens := make(map[string]token)
+	for i, tt := range v.Types {
+		// vocabularies without tokenizer.json include their special tokens
+		if tt == tokenTypeControl || tt == tokenTypeUserDefined {
+			addedTokens[v.Tokens[i]] = token{ID: i, Content: v.Tokens[i], Special: tt == tokenTypeControl}
+		}
+	}
+
 	if f, err := fsys.Open("tokenizer.json"); errors.Is(err, os.ErrNotExist) {
+		if v.Model == "gpt2" {
+			// tiktoken and tekken vocabularies don't include merges but
+			// they can be recovered from the ranks of the tokens
+			t.Merges = bpeMerges(v)
+
+			if _, err := fs.Stat(fsys, "tekken.json"); err == nil {
+				t.Pre = "tekken"
+			} else {
+				// tiktoken vocabularies don't include their pretokenizer,
+				// which is usually that of cl100k_base like llama 3
+				t.Pre = "llama-bpe"
+			}
+		}
 	} else if err != nil {
 		return nil, err
 	} else {
@@ -171,15 +192,28 @@ func parseTokenizer(fsys fs.FS, specialTokenTypes []string) (*Tokenizer, error)
 		}
 	}
 
+	if _, err := fs.Stat(fsys, "tekken.json"); err == nil && len(t.SpecialVocabulary) == 0 {
+		// tekken doesn't need a tokenizer_config.json so fall back to
+		// the special tokens of mistral's other tokenizers
+		for _, st := range specialTokenTypes {
+			content, ok := map[string]string{"unk": "<unk>", "bos": "<s>", "eos": "</s>"}[st]
+			if id, found := addedTokens[content]; ok && found {
+				t.SpecialVocabulary = append(t.SpecialVocabulary, &SpecialVocabulary{Type: st, ID: id.ID, Content: content, AddToken: st == "bos"})
+			}
+		}
+	}
+
 	return t, nil
 }
 
 type tokenizer struct {
 	AddedTokens []token `json:"added_tokens"`
 	Model       struct {
-		Type   string          `json:"type"`
-		Vocab  map[string]int  `json:"vocab"`
-		Merges json.RawMessage `json:"merges"`
+		Type         string          `json:"type"`
+		Vocab        map[string]int  `json:"vocab"`
+		Merges       json.RawMessage `json:"merges"`
+		ByteFallback bool            `json:"byte_fallback"`
+		UnkToken     string          `json:"unk_token"`
 	} `json:"model"`
 
 	PreTokenizer struct {
@@ -235,16 +269,30 @@ func parseVocabularyFromTokenizer(fsys fs.FS) (*Vocabulary, error) {
 	slices.Sort(keys)
 
 	v := Vocabulary{Model: "gpt2"}
+	if t.Model.ByteFallback {
+		// byte fallback BPE is converted from sentencepiece and is
+		// tokenized like it, preferring tokens with higher scores
+		v.Model = "llama"
+	}
+
 	for _, k := range keys {
 		token := tokens[k]
 		v.Tokens = append(v.Tokens, token.Content)
-		v.Scores = append(v.Scores, float32(token.ID))
+		if t.Model.ByteFallback {
+			v.Scores = append(v.Scores, -float32(token.ID))
+		} else {
+			v.Scores = append(v.Scores, float32(token.ID))
+		}
 
 		switch {
 		case token.Special:
 			v.Types = append(v.Types, tokenTypeControl)
 		case token.UserDefined:
 			v.Types = append(v.Types, tokenTypeUserDefined)
+		case t.Model.ByteFallback && token.Content == t.Model.UnkToken:
+			v.Types = append(v.Types, tokenTypeUnknown)
+		case t.Model.ByteFallback && isByteToken(token.Content):
+			v.Types = append(v.Types, tokenTypeByte)
 		default:
 			v.Types = append(v.Types, tokenTypeNormal)
 		}
@@ -253,6 +301,16 @@ func parseVocabularyFromTokenizer(fsys fs.FS) (*Vocabulary, error) {
 	return &v, nil
 }
 
+// isByteToken reports whether s is a byte fallback token such as <0x0A>.
+func isByteToken(s string) bool {
+	if len(s) != 6 || !strings.HasPrefix(s, "<0x") || !strings.HasSuffix(s, ">") {
+		return false
+	}
+
+	_, err := strconv.ParseUint(s[3:5], 16, 8)
+	return err == nil
+}
+
 func parseVocabulary(fsys fs.FS) (*Vocabulary, error) {
 	patterns := []struct {
 		Pattern string
@@ -260,13 +318,16 @@ func parseVocabulary(fsys fs.FS) (*Vocabulary, error) {
 	}{
 		{"tokenizer.model", parseSentencePiece},
 		{"tokenizer.json", parseVocabularyFromTokenizer},
+		{"tekken.json", parseTekken},
+		{"*.tiktoken", parseTiktoken},
+		{"tiktoken.model", parseTiktoken},
 	}
 
 	for _, pattern := range patterns {
-		if _, err := fs.Stat(fsys, pattern.Pattern); errors.Is(err, os.ErrNotExist) {
-			continue
-		} else if err != nil {
+		if matches, err := fs.Glob(fsys, pattern.Pattern); err != nil {
 			return nil, err
+		} else if len(matches) == 0 {
+			continue
 		}
 
 		return pattern.Func(fsys)
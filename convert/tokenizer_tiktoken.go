@@ -0,0 +1,298 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// byteEncoder maps each byte to the printable rune which represents it in
+// byte-level BPE vocabularies, as in GPT-2.
+var byteEncoder = func() (encoder [256]rune) {
+	n := 0
+	for b := range encoder {
+		if b >= '!' && b <= '~' || b >= 0xa1 && b <= 0xac || b >= 0xae {
+			encoder[b] = rune(b)
+		} else {
+			encoder[b] = rune(256 + n)
+			n++
+		}
+	}
+
+	return encoder
+}()
+
+func encodeBytes(bts []byte) string {
+	var sb strings.Builder
+	for _, b := range bts {
+		sb.WriteRune(byteEncoder[b])
+	}
+
+	return sb.String()
+}
+
+// rankedVocabulary builds a byte-level BPE vocabulary from tokens ordered by
+// rank, placing them at offset after any special tokens.
+func rankedVocabulary(tokens [][]byte, offset int, special map[int]token) *Vocabulary {
+	n := offset + len(tokens)
+	for id := range special {
+		n = max(n, id+1)
+	}
+
+	v := Vocabulary{
+		Model:  "gpt2",
+		Tokens: make([]string, n),
+		Scores: make([]float32, n),
+		Types:  make([]int32, n),
+	}
+
+	for i := range n {
+		v.Scores[i] = float32(i)
+		v.Tokens[i] = fmt.Sprintf("[PAD%d]", i)
+		v.Types[i] = tokenTypeUserDefined
+	}
+
+	for i, bts := range tokens {
+		v.Tokens[offset+i] = encodeBytes(bts)
+		v.Types[offset+i] = tokenTypeNormal
+	}
+
+	for id, t := range special {
+		v.Tokens[id] = t.Content
+		v.Types[id] = tokenTypeUserDefined
+		if t.Special {
+			v.Types[id] = tokenTypeControl
+		}
+	}
+
+	return &v
+}
+
+type tekken struct {
+	Config struct {
+		Pattern                 string `json:"pattern"`
+		DefaultVocabSize        int    `json:"default_vocab_size"`
+		DefaultNumSpecialTokens int    `json:"default_num_special_tokens"`
+	} `json:"config"`
+	Vocab []struct {
+		Rank       int    `json:"rank"`
+		TokenBytes string `json:"token_bytes"`
+	} `json:"vocab"`
+	SpecialTokens []struct {
+		Rank     int    `json:"rank"`
+		TokenStr string `json:"token_str"`
+	} `json:"special_tokens"`
+}
+
+// parseTekken parses the vocabulary of Mistral's tekken tokenizer, which is
+// tiktoken based with special tokens taking the first ids.
+func parseTekken(fsys fs.FS) (*Vocabulary, error) {
+	slog.Debug("using tekken vocabulary")
+
+	bts, err := fs.ReadFile(fsys, "tekken.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var t tekken
+	if err := json.Unmarshal(bts, &t); err != nil {
+		return nil, err
+	}
+
+	numSpecial := cmp.Or(t.Config.DefaultNumSpecialTokens, 1000)
+	special := make(map[int]token, numSpecial)
+	if len(t.SpecialTokens) == 0 {
+		// older versions don't list their special tokens
+		for i, s := range []string{"<unk>", "<s>", "</s>"} {
+			special[i] = token{ID: i, Content: s, Special: true}
+		}
+	}
+
+	for _, s := range t.SpecialTokens {
+		if s.Rank >= numSpecial {
+			return nil, fmt.Errorf("special token %q has rank %d, expected less than %d", s.TokenStr, s.Rank, numSpecial)
+		}
+
+		special[s.Rank] = token{ID: s.Rank, Content: s.TokenStr, Special: true}
+	}
+
+	for i := range numSpecial {
+		if _, ok := special[i]; !ok {
+			special[i] = token{ID: i, Content: fmt.Sprintf("<SPECIAL_%d>", i), Special: true}
+		}
+	}
+
+	// the vocabulary may be larger than the model uses
+	n := len(t.Vocab)
+	if t.Config.DefaultVocabSize > 0 {
+		n = min(n, t.Config.DefaultVocabSize-numSpecial)
+	}
+
+	tokens := make([][]byte, n)
+	for _, e := range t.Vocab {
+		if e.Rank >= n {
+			continue
+		}
+
+		bts, err := base64.StdEncoding.DecodeString(e.TokenBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token of rank %d: %w", e.Rank, err)
+		}
+
+		tokens[e.Rank] = bts
+	}
+
+	for i, bts := range tokens {
+		if bts == nil {
+			return nil, fmt.Errorf("token of rank %d is missing", i)
+		}
+	}
+
+	return rankedVocabulary(tokens, numSpecial, special), nil
+}
+
+// parseTiktoken parses a tiktoken vocabulary, in which each line is a
+// base64 encoded token and its rank. Special tokens aren't included so they
+// are read from tokenizer_config.json.
+func parseTiktoken(fsys fs.FS) (*Vocabulary, error) {
+	slog.Debug("using tiktoken vocabulary")
+
+	p := "tiktoken.model"
+	if matches, err := fs.Glob(fsys, "*.tiktoken"); err != nil {
+		return nil, err
+	} else if len(matches) > 0 {
+		p = matches[0]
+	}
+
+	f, err := fsys.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var tokens [][]byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		encoded, rank, ok := bytes.Cut(line, []byte(" "))
+		if !ok {
+			return nil, fmt.Errorf("%s: invalid line %q", p, line)
+		}
+
+		i, err := strconv.Atoi(string(rank))
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid rank %q", p, rank)
+		}
+
+		bts, err := base64.StdEncoding.DecodeString(string(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid token of rank %d: %w", p, i, err)
+		}
+
+		if i >= len(tokens) {
+			tokens = append(tokens, make([][]byte, i+1-len(tokens))...)
+		}
+
+		tokens[i] = bts
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, bts := range tokens {
+		if bts == nil {
+			return nil, fmt.Errorf("%s: token of rank %d is missing", p, i)
+		}
+	}
+
+	special := make(map[int]token)
+	if bts, err := fs.ReadFile(fsys, "tokenizer_config.json"); errors.Is(err, os.ErrNotExist) {
+		// no special tokens
+	} else if err != nil {
+		return nil, err
+	} else {
+		var config struct {
+			AddedTokensDecoder map[string]token `json:"added_tokens_decoder"`
+		}
+		if err := json.Unmarshal(bts, &config); err != nil {
+			return nil, err
+		}
+
+		for k, t := range config.AddedTokensDecoder {
+			id, err := strconv.Atoi(k)
+			if err != nil {
+				return nil, fmt.Errorf("invalid added token id %q", k)
+			}
+
+			t.ID = id
+			special[id] = t
+		}
+	}
+
+	return rankedVocabulary(tokens, 0, special), nil
+}
+
+// bpeMerges recovers the merges of a byte-level BPE vocabulary, such as one
+// built from tiktoken, from the rank of each normal token which is its order
+// in the vocabulary. Each token is the merge of the pair its bytes reduce to
+// using only merges of lower ranks.
+func bpeMerges(v *Vocabulary) []string {
+	ranks := make(map[string]int, len(v.Tokens))
+	for i, t := range v.Tokens {
+		if v.Types[i] == tokenTypeNormal {
+			ranks[t] = i
+		}
+	}
+
+	var merges []string
+	for i, t := range v.Tokens {
+		if v.Types[i] != tokenTypeNormal {
+			continue
+		}
+
+		// tokens are made of runes which each represent a byte
+		parts := strings.Split(t, "")
+		if len(parts) < 2 {
+			continue
+		}
+
+		for len(parts) > 2 {
+			best, bestRank := -1, i
+			for j := range len(parts) - 1 {
+				if rank, ok := ranks[parts[j]+parts[j+1]]; ok && rank < bestRank {
+					best, bestRank = j, rank
+				}
+			}
+
+			if best < 0 {
+				break
+			}
+
+			parts[best] += parts[best+1]
+			parts = append(parts[:best+1], parts[best+2:]...)
+		}
+
+		if len(parts) != 2 {
+			slog.Debug("token can't be merged from lower ranks", "token", t)
+			continue
+		}
+
+		merges = append(merges, parts[0]+" "+parts[1])
+	}
+
+	return merges
+}
@@ -0,0 +1,148 @@
+package convert
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseTiktoken(t *testing.T) {
+	fsys := createTokenizerFS(t, t.TempDir(), map[string]io.Reader{
+		// a, b, " ", ab, abb, " ab"
+		"tokenizer.tiktoken": strings.NewReader("YQ== 0\nYg== 1\nIA== 2\nYWI= 3\nYWJi 4\nIGFi 5\n"),
+		"tokenizer_config.json": strings.NewReader(`{
+			"add_bos_token": true,
+			"bos_token": "<|begin_of_text|>",
+			"eos_token": "<|end_of_text|>",
+			"added_tokens_decoder": {
+				"6": {"content": "<|begin_of_text|>", "special": true},
+				"8": {"content": "<|end_of_text|>", "special": true}
+			}
+		}`),
+	})
+
+	tokenizer, err := parseTokenizer(fsys, []string{"bos", "eos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Tokenizer{
+		Vocabulary: &Vocabulary{
+			Model:  "gpt2",
+			Tokens: []string{"a", "b", "Ġ", "ab", "abb", "Ġab", "<|begin_of_text|>", "[PAD7]", "<|end_of_text|>"},
+			Scores: []float32{0, 1, 2, 3, 4, 5, 6, 7, 8},
+			Types:  []int32{1, 1, 1, 1, 1, 1, 3, 4, 3},
+		},
+		SpecialVocabulary: []*SpecialVocabulary{
+			{Type: "bos", ID: 6, Content: "<|begin_of_text|>", AddToken: true},
+			{Type: "eos", ID: 8, Content: "<|end_of_text|>"},
+		},
+		Merges: []string{"a b", "ab b", "Ġ ab"},
+		Pre:    "llama-bpe",
+	}
+
+	if diff := cmp.Diff(want, tokenizer); diff != "" {
+		t.Errorf("unexpected tokenizer (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseTiktokenMissingRank(t *testing.T) {
+	fsys := createTokenizerFS(t, t.TempDir(), map[string]io.Reader{
+		"tiktoken.model": strings.NewReader("YQ== 0\nYWI= 2\n"),
+	})
+
+	if _, err := parseTokenizer(fsys, nil); err == nil || !strings.Contains(err.Error(), "rank 1 is missing") {
+		t.Fatalf("expected missing rank error, got %v", err)
+	}
+}
+
+func TestParseTekken(t *testing.T) {
+	fsys := createTokenizerFS(t, t.TempDir(), map[string]io.Reader{
+		"tekken.json": strings.NewReader(`{
+			"config": {
+				"default_vocab_size": 9,
+				"default_num_special_tokens": 4
+			},
+			"vocab": [
+				{"rank": 0, "token_bytes": "YQ=="},
+				{"rank": 1, "token_bytes": "Yg=="},
+				{"rank": 2, "token_bytes": "IA=="},
+				{"rank": 3, "token_bytes": "IGE="},
+				{"rank": 4, "token_bytes": "Cg=="},
+				{"rank": 5, "token_bytes": "IGFi"}
+			],
+			"special_tokens": [
+				{"rank": 0, "token_str": "<unk>"},
+				{"rank": 1, "token_str": "<s>"},
+				{"rank": 2, "token_str": "</s>"}
+			]
+		}`),
+	})
+
+	tokenizer, err := parseTokenizer(fsys, []string{"bos", "eos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Tokenizer{
+		Vocabulary: &Vocabulary{
+			Model: "gpt2",
+			// the last token is past the default vocabulary size
+			Tokens: []string{"<unk>", "<s>", "</s>", "<SPECIAL_3>", "a", "b", "Ġ", "Ġa", "Ċ"},
+			Scores: []float32{0, 1, 2, 3, 4, 5, 6, 7, 8},
+			Types:  []int32{3, 3, 3, 3, 1, 1, 1, 1, 1},
+		},
+		SpecialVocabulary: []*SpecialVocabulary{
+			{Type: "bos", ID: 1, Content: "<s>", AddToken: true},
+			{Type: "eos", ID: 2, Content: "</s>"},
+		},
+		Merges: []string{"Ġ a"},
+		Pre:    "tekken",
+	}
+
+	if diff := cmp.Diff(want, tokenizer); diff != "" {
+		t.Errorf("unexpected tokenizer (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseTokenizerByteFallback(t *testing.T) {
+	fsys := createTokenizerFS(t, t.TempDir(), map[string]io.Reader{
+		"tokenizer.json": strings.NewReader(`{
+			"added_tokens": [
+				{"id": 0, "content": "<unk>", "special": true},
+				{"id": 1, "content": "<s>", "special": true}
+			],
+			"model": {
+				"type": "BPE",
+				"byte_fallback": true,
+				"unk_token": "<unk>",
+				"vocab": {
+					"<unk>": 0,
+					"<s>": 1,
+					"<0x0A>": 2,
+					"<0xFF>": 3,
+					"▁a": 4
+				},
+				"merges": ["▁ a"]
+			}
+		}`),
+	})
+
+	tokenizer, err := parseTokenizer(fsys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Vocabulary{
+		Model:  "llama",
+		Tokens: []string{"<unk>", "<s>", "<0x0A>", "<0xFF>", "▁a"},
+		Scores: []float32{0, -1, -2, -3, -4},
+		Types:  []int32{3, 3, 6, 6, 1},
+	}
+
+	if diff := cmp.Diff(want, tokenizer.Vocabulary); diff != "" {
+		t.Errorf("unexpected vocabulary (-want +got):\n%s", diff)
+	}
+}
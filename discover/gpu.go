@@ -32,6 +32,11 @@ type cudaHandles struct {
 	nvml        *C.nvml_handle_t
 }
 
+type vulkanHandles struct {
+	deviceCount int
+	vulkan      *C.vk_handle_t
+}
+
 type oneapiHandles struct {
 	oneapi      *C.oneapi_handle_t
 	deviceCount int
@@ -40,6 +45,8 @@ type oneapiHandles struct {
 const (
 	cudaMinimumMemory = 457 * format.MebiByte
 	rocmMinimumMemory = 457 * format.MebiByte
+	// TODO Vulkan minimum memory, which depends on the driver of the GPU
+	vulkanMinimumMemory = 457 * format.MebiByte
 	// TODO OneAPI minimum memory
 )
 
@@ -51,9 +58,12 @@ var (
 	nvcudaLibPath string
 	cudartLibPath string
 	oneapiLibPath string
+	vulkanLibPath string
 	nvmlLibPath   string
 	rocmGPUs      []RocmGPUInfo
 	oneapiGPUs    []OneapiGPUInfo
+	vulkanGPUs    []VulkanGPUInfo
+	pluginGPUs    []GpuInfo
 
 	// If any discovered GPUs are incompatible, report why
 	unsupportedGPUs []UnsupportedGPUInfo
@@ -174,6 +184,28 @@ func initOneAPIHandles() *oneapiHandles {
 	return oHandles
 }
 
+// Note: gpuMutex must already be held
+func initVulkanHandles() *vulkanHandles {
+	vHandles := &vulkanHandles{}
+
+	// Short Circuit if we already know which library to use
+	// ignore bootstrap errors in this case since we already recorded them
+	if vulkanLibPath != "" {
+		vHandles.deviceCount, vHandles.vulkan, _, _ = loadVulkanMgmt([]string{vulkanLibPath})
+		return vHandles
+	}
+
+	// the Vulkan loader is found in the system's library path, and finds
+	// the drivers of each GPU itself
+	var err error
+	vHandles.deviceCount, vHandles.vulkan, vulkanLibPath, err = loadVulkanMgmt([]string{VulkanMgmtName})
+	if err != nil {
+		bootstrapErrors = append(bootstrapErrors, err)
+	}
+
+	return vHandles
+}
+
 func GetCPUInfo() GpuInfoList {
 	gpuMutex.Lock()
 	if !bootstrapped {
@@ -193,6 +225,7 @@ func GetGPUInfo() GpuInfoList {
 	needRefresh := true
 	var cHandles *cudaHandles
 	var oHandles *oneapiHandles
+	var vHandles *vulkanHandles
 	defer func() {
 		if cHandles != nil {
 			if cHandles.cudart != nil {
@@ -211,6 +244,9 @@ func GetGPUInfo() GpuInfoList {
 				C.oneapi_release(*oHandles.oneapi)
 			}
 		}
+		if vHandles != nil && vHandles.vulkan != nil {
+			C.vk_release(*vHandles.vulkan)
+		}
 	}()
 
 	if !bootstrapped {
@@ -372,8 +408,57 @@ func GetGPUInfo() GpuInfoList {
 		if err != nil {
 			bootstrapErrors = append(bootstrapErrors, err)
 		}
+
+		// Vulkan, for GPUs that the libraries above don't support
+		if envconfig.Vulkan() {
+			vHandles = initVulkanHandles()
+			if vHandles != nil && vHandles.vulkan != nil {
+				for i := range vHandles.deviceCount {
+					var info C.vk_device_info_t
+					C.vk_check_vram(*vHandles.vulkan, C.int(i), &info)
+					if info.mem.err != nil {
+						slog.Info("error looking up vulkan GPU memory", "device", i, "error", C.GoString(info.mem.err))
+						C.free(unsafe.Pointer(info.mem.err))
+						continue
+					}
+
+					if info.device_type == C.VK_PHYSICAL_DEVICE_TYPE_CPU {
+						// software renderers such as llvmpipe are slower than the CPU runner
+						slog.Debug("skipping vulkan CPU device", "device", i, "name", C.GoString(&info.mem.gpu_name[0]))
+						continue
+					}
+
+					gpuInfo := VulkanGPUInfo{
+						GpuInfo: GpuInfo{
+							Library: "vulkan",
+						},
+						index:    i,
+						uuid:     C.GoString(&info.mem.gpu_id[0]),
+						vendorID: uint32(info.vendor_id),
+					}
+					gpuInfo.TotalMemory = uint64(info.mem.total)
+					gpuInfo.FreeMemory = uint64(info.mem.free)
+					gpuInfo.UnreliableFreeMemory = info.memory_budget == 0
+					gpuInfo.MinimumMemory = vulkanMinimumMemory
+					gpuInfo.ID = strconv.Itoa(i)
+					gpuInfo.Name = C.GoString(&info.mem.gpu_name[0])
+					gpuInfo.Compute = fmt.Sprintf("%d.%d", info.mem.major, info.mem.minor)
+
+					if reason := vulkanSkipReason(gpuInfo, cudaGPUs, rocmGPUs, oneapiGPUs); reason != "" {
+						slog.Info("skipping vulkan GPU", "id", gpuInfo.ID, "name", gpuInfo.Name, "reason", reason)
+						continue
+					}
+
+					vulkanGPUs = append(vulkanGPUs, gpuInfo)
+				}
+			}
+		}
+		var errs []error
+		pluginGPUs, errs = discoverPluginGPUs()
+		bootstrapErrors = append(bootstrapErrors, errs...)
+
 		bootstrapped = true
-		if len(cudaGPUs) == 0 && len(rocmGPUs) == 0 && len(oneapiGPUs) == 0 {
+		if len(cudaGPUs) == 0 && len(rocmGPUs) == 0 && len(oneapiGPUs) == 0 && len(vulkanGPUs) == 0 && len(pluginGPUs) == 0 {
 			slog.Info("no compatible GPUs were discovered")
 		}
 
@@ -473,10 +558,45 @@ func GetGPUInfo() GpuInfoList {
 			oneapiGPUs[i].FreeMemory = uint64(memInfo.free)
 		}
 
+		if vHandles == nil && len(vulkanGPUs) > 0 {
+			vHandles = initVulkanHandles()
+		}
+		for i, gpu := range vulkanGPUs {
+			if vHandles.vulkan == nil {
+				// shouldn't happen
+				slog.Warn("nil vulkan handle with device count", "count", vHandles.deviceCount)
+				break
+			}
+			var info C.vk_device_info_t
+			C.vk_check_vram(*vHandles.vulkan, C.int(gpu.index), &info)
+			if info.mem.err != nil {
+				slog.Warn("error looking up vulkan GPU memory", "error", C.GoString(info.mem.err))
+				C.free(unsafe.Pointer(info.mem.err))
+				continue
+			}
+			slog.Debug("updating vulkan memory data",
+				"gpu", gpu.ID,
+				"name", gpu.Name,
+				slog.Group(
+					"before",
+					"total", format.HumanBytes2(gpu.TotalMemory),
+					"free", format.HumanBytes2(gpu.FreeMemory),
+				),
+				slog.Group(
+					"now",
+					"total", format.HumanBytes2(uint64(info.mem.total)),
+					"free", format.HumanBytes2(uint64(info.mem.free)),
+				),
+			)
+			vulkanGPUs[i].FreeMemory = uint64(info.mem.free)
+		}
+
 		err = RocmGPUInfoList(rocmGPUs).RefreshFreeMemory()
 		if err != nil {
 			slog.Debug("problem refreshing ROCm free memory", "error", err)
 		}
+
+		refreshPluginGPUs(pluginGPUs)
 	}
 
 	resp := []GpuInfo{}
@@ -489,6 +609,10 @@ func GetGPUInfo() GpuInfoList {
 	for _, gpu := range oneapiGPUs {
 		resp = append(resp, gpu.GpuInfo)
 	}
+	for _, gpu := range vulkanGPUs {
+		resp = append(resp, gpu.GpuInfo)
+	}
+	resp = append(resp, pluginGPUs...)
 	if len(resp) == 0 {
 		resp = append(resp, cpus[0].GpuInfo)
 	}
@@ -669,6 +793,27 @@ func loadOneapiMgmt(oneapiLibPaths []string) (int, *C.oneapi_handle_t, string, e
 	return 0, nil, "", err
 }
 
+// bootstrap the Vulkan loader
+// Returns: num devices, handle, libPath, error
+func loadVulkanMgmt(vulkanLibPaths []string) (int, *C.vk_handle_t, string, error) {
+	var resp C.vk_init_resp_t
+	resp.vh.verbose = getVerboseState()
+	var err error
+	for _, libPath := range vulkanLibPaths {
+		lib := C.CString(libPath)
+		defer C.free(unsafe.Pointer(lib))
+		C.vk_init(lib, &resp)
+		if resp.err != nil {
+			err = fmt.Errorf("Unable to load Vulkan library %s: %s", libPath, C.GoString(resp.err))
+			slog.Debug(err.Error())
+			C.free(unsafe.Pointer(resp.err))
+		} else {
+			return int(resp.vh.num_devices), &resp.vh, libPath, nil
+		}
+	}
+	return 0, nil, "", err
+}
+
 func getVerboseState() C.uint16_t {
 	if envconfig.Debug() {
 		return C.uint16_t(1)
@@ -691,7 +836,13 @@ func (l GpuInfoList) GetVisibleDevicesEnv() (string, string) {
 		return rocmGetVisibleDevicesEnv(l)
 	case "oneapi":
 		return oneapiGetVisibleDevicesEnv(l)
+	case "vulkan":
+		return vulkanGetVisibleDevicesEnv(l)
 	default:
+		if d, ok := discoverers[l[0].Library]; ok {
+			return d.VisibleDevicesEnv(l)
+		}
+
 		slog.Debug("no filter required for library " + l[0].Library)
 		return "", ""
 	}
@@ -65,6 +65,7 @@ void cpu_check_ram(mem_info_t *resp);
 #include "gpu_info_nvcuda.h"
 #include "gpu_info_nvml.h"
 #include "gpu_info_oneapi.h"
+#include "gpu_info_vulkan.h"
 
 #endif  // __GPU_INFO_H__
 #endif  // __APPLE__
@@ -0,0 +1,252 @@
+#ifndef __APPLE__
+
+#include "gpu_info_vulkan.h"
+
+#include <string.h>
+
+void vk_init(char *vk_lib_path, vk_init_resp_t *resp) {
+  VkResult ret;
+  resp->err = NULL;
+  resp->vh.instance = NULL;
+  resp->vh.devices = NULL;
+  resp->vh.num_devices = 0;
+  resp->vh.vkDestroyInstance = NULL;
+  const int buflen = 256;
+  char buf[buflen + 1];
+  int i;
+
+  resp->vh.handle = LOAD_LIBRARY(vk_lib_path, RTLD_LAZY);
+  if (!resp->vh.handle) {
+    char *msg = LOAD_ERR();
+    snprintf(buf, buflen,
+             "Unable to load %s library to query for Vulkan GPUs: %s\n",
+             vk_lib_path, msg);
+    free(msg);
+    resp->err = strdup(buf);
+    return;
+  }
+
+  LOG(resp->vh.verbose, "wiring Vulkan loader functions in %s\n", vk_lib_path);
+
+  resp->vh.vkGetInstanceProcAddr =
+      (void *)LOAD_SYMBOL(resp->vh.handle, "vkGetInstanceProcAddr");
+  if (!resp->vh.vkGetInstanceProcAddr) {
+    char *msg = LOAD_ERR();
+    LOG(resp->vh.verbose, "dlerr: %s\n", msg);
+    UNLOAD_LIBRARY(resp->vh.handle);
+    resp->vh.handle = NULL;
+    snprintf(buf, buflen, "symbol lookup for vkGetInstanceProcAddr failed: %s",
+             msg);
+    free(msg);
+    resp->err = strdup(buf);
+    return;
+  }
+
+  resp->vh.vkCreateInstance =
+      (void *)(*resp->vh.vkGetInstanceProcAddr)(NULL, "vkCreateInstance");
+  if (!resp->vh.vkCreateInstance) {
+    UNLOAD_LIBRARY(resp->vh.handle);
+    resp->vh.handle = NULL;
+    resp->err = strdup("symbol lookup for vkCreateInstance failed");
+    return;
+  }
+
+  // devices are only queried, so the instance doesn't need any layers or
+  // extensions, but properties and memory budgets of devices need Vulkan 1.1
+  VkApplicationInfo app = {
+      .sType = VK_STRUCTURE_TYPE_APPLICATION_INFO,
+      .pApplicationName = "ollama",
+      .apiVersion = VK_API_VERSION_1_1,
+  };
+  VkInstanceCreateInfo info = {
+      .sType = VK_STRUCTURE_TYPE_INSTANCE_CREATE_INFO,
+      .pApplicationInfo = &app,
+  };
+
+  LOG(resp->vh.verbose, "calling vkCreateInstance\n");
+  ret = (*resp->vh.vkCreateInstance)(&info, NULL, &resp->vh.instance);
+  if (ret != VK_SUCCESS) {
+    LOG(resp->vh.verbose, "vkCreateInstance err: %d\n", ret);
+    UNLOAD_LIBRARY(resp->vh.handle);
+    resp->vh.handle = NULL;
+    snprintf(buf, buflen, "vulkan instance init failure: %d", ret);
+    resp->err = strdup(buf);
+    return;
+  }
+
+  struct lookup {
+    char *s;
+    void **p;
+  } l[] = {
+      {"vkDestroyInstance", (void *)&resp->vh.vkDestroyInstance},
+      {"vkEnumeratePhysicalDevices",
+       (void *)&resp->vh.vkEnumeratePhysicalDevices},
+      {"vkGetPhysicalDeviceProperties2",
+       (void *)&resp->vh.vkGetPhysicalDeviceProperties2},
+      {"vkGetPhysicalDeviceMemoryProperties2",
+       (void *)&resp->vh.vkGetPhysicalDeviceMemoryProperties2},
+      {"vkEnumerateDeviceExtensionProperties",
+       (void *)&resp->vh.vkEnumerateDeviceExtensionProperties},
+      {NULL, NULL},
+  };
+
+  for (i = 0; l[i].s != NULL; i++) {
+    LOG(resp->vh.verbose, "vkGetInstanceProcAddr: %s\n", l[i].s);
+
+    *l[i].p = (void *)(*resp->vh.vkGetInstanceProcAddr)(resp->vh.instance,
+                                                        l[i].s);
+    if (!*(l[i].p)) {
+      snprintf(buf, buflen, "symbol lookup for %s failed", l[i].s);
+      resp->err = strdup(buf);
+      vk_release(resp->vh);
+      return;
+    }
+  }
+
+  LOG(resp->vh.verbose, "calling vkEnumeratePhysicalDevices\n");
+  ret = (*resp->vh.vkEnumeratePhysicalDevices)(resp->vh.instance,
+                                               &resp->vh.num_devices, NULL);
+  if (ret != VK_SUCCESS) {
+    LOG(resp->vh.verbose, "vkEnumeratePhysicalDevices err: %d\n", ret);
+    snprintf(buf, buflen, "unable to get device count: %d", ret);
+    resp->err = strdup(buf);
+    vk_release(resp->vh);
+    return;
+  }
+
+  LOG(resp->vh.verbose, "vulkan device count: %d\n", resp->vh.num_devices);
+  resp->vh.devices = malloc(resp->vh.num_devices * sizeof(VkPhysicalDevice));
+  ret = (*resp->vh.vkEnumeratePhysicalDevices)(
+      resp->vh.instance, &resp->vh.num_devices, resp->vh.devices);
+  if (ret != VK_SUCCESS && ret != VK_INCOMPLETE) {
+    LOG(resp->vh.verbose, "vkEnumeratePhysicalDevices err: %d\n", ret);
+    snprintf(buf, buflen, "unable to get devices: %d", ret);
+    resp->err = strdup(buf);
+    vk_release(resp->vh);
+    return;
+  }
+}
+
+static int vk_has_extension(vk_handle_t h, VkPhysicalDevice device,
+                            const char *name) {
+  uint32_t count = 0;
+  int found = 0;
+  if ((*h.vkEnumerateDeviceExtensionProperties)(device, NULL, &count, NULL) !=
+      VK_SUCCESS) {
+    return 0;
+  }
+
+  VkExtensionProperties *exts = malloc(count * sizeof(VkExtensionProperties));
+  if ((*h.vkEnumerateDeviceExtensionProperties)(device, NULL, &count, exts) ==
+      VK_SUCCESS) {
+    for (uint32_t i = 0; i < count; i++) {
+      if (strcmp(exts[i].extensionName, name) == 0) {
+        found = 1;
+        break;
+      }
+    }
+  }
+
+  free(exts);
+  return found;
+}
+
+void vk_check_vram(vk_handle_t h, int device, vk_device_info_t *resp) {
+  resp->mem.err = NULL;
+  resp->mem.total = 0;
+  resp->mem.free = 0;
+  resp->mem.used = 0;
+  uint32_t i;
+
+  if (h.handle == NULL) {
+    resp->mem.err = strdup("Vulkan handle not initialized");
+    return;
+  }
+
+  if (device < 0 || device >= h.num_devices) {
+    resp->mem.err = strdup("device index out of bounds");
+    return;
+  }
+
+  VkPhysicalDevice d = h.devices[device];
+
+  VkPhysicalDeviceIDProperties id = {
+      .sType = VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_ID_PROPERTIES,
+  };
+  VkPhysicalDeviceProperties2 props = {
+      .sType = VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_PROPERTIES_2,
+      .pNext = &id,
+  };
+  (*h.vkGetPhysicalDeviceProperties2)(d, &props);
+
+  resp->vendor_id = props.properties.vendorID;
+  resp->device_type = props.properties.deviceType;
+  resp->mem.major = VK_API_VERSION_MAJOR(props.properties.apiVersion);
+  resp->mem.minor = VK_API_VERSION_MINOR(props.properties.apiVersion);
+  snprintf(&resp->mem.gpu_name[0], GPU_NAME_LEN, "%s",
+           props.properties.deviceName);
+
+  // Same format as CUDA, whose UUIDs NVIDIA devices have in Vulkan too
+  // GPU-d110a105-ac29-1d54-7b49-9c90440f215b
+  uint8_t *u = id.deviceUUID;
+  snprintf(&resp->mem.gpu_id[0], GPU_ID_LEN,
+      "GPU-%02x%02x%02x%02x-%02x%02x-%02x%02x-%02x%02x-%02x%02x%02x%02x%02x%02x",
+           u[0], u[1], u[2], u[3], u[4], u[5], u[6], u[7], u[8], u[9], u[10],
+           u[11], u[12], u[13], u[14], u[15]);
+
+  VkPhysicalDeviceMemoryBudgetPropertiesEXT budget = {
+      .sType = VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_MEMORY_BUDGET_PROPERTIES_EXT,
+  };
+  VkPhysicalDeviceMemoryProperties2 mem = {
+      .sType = VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_MEMORY_PROPERTIES_2,
+  };
+  resp->memory_budget = vk_has_extension(h, d, VK_EXT_MEMORY_BUDGET_EXTENSION_NAME);
+  if (resp->memory_budget) {
+    mem.pNext = &budget;
+  }
+  (*h.vkGetPhysicalDeviceMemoryProperties2)(d, &mem);
+
+  for (i = 0; i < mem.memoryProperties.memoryHeapCount; i++) {
+    VkMemoryHeap heap = mem.memoryProperties.memoryHeaps[i];
+    if (!(heap.flags & VK_MEMORY_HEAP_DEVICE_LOCAL_BIT)) {
+      continue;
+    }
+
+    resp->mem.total += heap.size;
+    if (resp->memory_budget) {
+      // the budget is how much of the heap the process can use, which
+      // includes what it already uses
+      if (budget.heapBudget[i] > budget.heapUsage[i]) {
+        resp->mem.free += budget.heapBudget[i] - budget.heapUsage[i];
+      }
+    } else {
+      resp->mem.free += heap.size;
+    }
+  }
+  resp->mem.used = resp->mem.total - resp->mem.free;
+
+  LOG(h.verbose, "[%s] Vulkan device name: %s\n", resp->mem.gpu_id,
+      resp->mem.gpu_name);
+  LOG(h.verbose, "[%s] Vulkan vendor: 0x%04x type: %d version: %d.%d\n",
+      resp->mem.gpu_id, resp->vendor_id, resp->device_type, resp->mem.major,
+      resp->mem.minor);
+  LOG(h.verbose, "[%s] Vulkan totalMem %lu freeMem %lu budget %d\n",
+      resp->mem.gpu_id, resp->mem.total, resp->mem.free, resp->memory_budget);
+}
+
+void vk_release(vk_handle_t h) {
+  LOG(h.verbose, "releasing vulkan library\n");
+  if (h.devices != NULL) {
+    free(h.devices);
+    h.devices = NULL;
+  }
+  if (h.instance != NULL && h.vkDestroyInstance != NULL) {
+    (*h.vkDestroyInstance)(h.instance, NULL);
+    h.instance = NULL;
+  }
+  h.num_devices = 0;
+  UNLOAD_LIBRARY(h.handle);
+  h.handle = NULL;
+}
+
+#endif // __APPLE__
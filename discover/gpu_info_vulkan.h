@@ -0,0 +1,180 @@
+#ifndef __APPLE__
+#ifndef __GPU_INFO_VULKAN_H__
+#define __GPU_INFO_VULKAN_H__
+#include "gpu_info.h"
+
+#define VK_MAX_PHYSICAL_DEVICE_NAME_SIZE 256
+#define VK_MAX_EXTENSION_NAME_SIZE 256
+#define VK_UUID_SIZE 16
+#define VK_LUID_SIZE 8
+#define VK_MAX_MEMORY_TYPES 32
+#define VK_MAX_MEMORY_HEAPS 16
+#define VK_MEMORY_HEAP_DEVICE_LOCAL_BIT 0x1
+#define VK_API_VERSION_1_1 ((1u << 22) | (1u << 12))
+#define VK_API_VERSION_MAJOR(version) (((uint32_t)(version) >> 22) & 0x7fu)
+#define VK_API_VERSION_MINOR(version) (((uint32_t)(version) >> 12) & 0x3ffu)
+#define VK_EXT_MEMORY_BUDGET_EXTENSION_NAME "VK_EXT_memory_budget"
+
+// Just enough typedef's to dlopen/dlsym for memory information
+typedef enum VkResult {
+  VK_SUCCESS = 0,
+  VK_INCOMPLETE = 5,
+  // Other values omitted for now...
+  VK_RESULT_MAX_ENUM = 0x7fffffff
+} VkResult;
+
+typedef enum VkStructureType {
+  VK_STRUCTURE_TYPE_APPLICATION_INFO = 0,
+  VK_STRUCTURE_TYPE_INSTANCE_CREATE_INFO = 1,
+  VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_PROPERTIES_2 = 1000059001,
+  VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_MEMORY_PROPERTIES_2 = 1000059006,
+  VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_ID_PROPERTIES = 1000071004,
+  VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_MEMORY_BUDGET_PROPERTIES_EXT = 1000237000,
+  VK_STRUCTURE_TYPE_MAX_ENUM = 0x7fffffff
+} VkStructureType;
+
+typedef enum VkPhysicalDeviceType {
+  VK_PHYSICAL_DEVICE_TYPE_OTHER = 0,
+  VK_PHYSICAL_DEVICE_TYPE_INTEGRATED_GPU = 1,
+  VK_PHYSICAL_DEVICE_TYPE_DISCRETE_GPU = 2,
+  VK_PHYSICAL_DEVICE_TYPE_VIRTUAL_GPU = 3,
+  VK_PHYSICAL_DEVICE_TYPE_CPU = 4,
+  VK_PHYSICAL_DEVICE_TYPE_MAX_ENUM = 0x7fffffff
+} VkPhysicalDeviceType;
+
+typedef uint32_t VkBool32;
+typedef uint32_t VkFlags;
+typedef uint64_t VkDeviceSize;
+typedef struct VkInstance_T *VkInstance;
+typedef struct VkPhysicalDevice_T *VkPhysicalDevice;
+typedef void (*PFN_vkVoidFunction)(void);
+
+typedef struct VkApplicationInfo {
+  VkStructureType sType;
+  const void *pNext;
+  const char *pApplicationName;
+  uint32_t applicationVersion;
+  const char *pEngineName;
+  uint32_t engineVersion;
+  uint32_t apiVersion;
+} VkApplicationInfo;
+
+typedef struct VkInstanceCreateInfo {
+  VkStructureType sType;
+  const void *pNext;
+  VkFlags flags;
+  const VkApplicationInfo *pApplicationInfo;
+  uint32_t enabledLayerCount;
+  const char *const *ppEnabledLayerNames;
+  uint32_t enabledExtensionCount;
+  const char *const *ppEnabledExtensionNames;
+} VkInstanceCreateInfo;
+
+typedef struct VkPhysicalDeviceProperties {
+  uint32_t apiVersion;
+  uint32_t driverVersion;
+  uint32_t vendorID;
+  uint32_t deviceID;
+  VkPhysicalDeviceType deviceType;
+  char deviceName[VK_MAX_PHYSICAL_DEVICE_NAME_SIZE];
+  uint8_t pipelineCacheUUID[VK_UUID_SIZE];
+  // VkPhysicalDeviceLimits and VkPhysicalDeviceSparseProperties, which
+  // aren't needed but take less space than this
+  uint64_t limits[128];
+} VkPhysicalDeviceProperties;
+
+typedef struct VkPhysicalDeviceProperties2 {
+  VkStructureType sType;
+  void *pNext;
+  VkPhysicalDeviceProperties properties;
+} VkPhysicalDeviceProperties2;
+
+typedef struct VkPhysicalDeviceIDProperties {
+  VkStructureType sType;
+  void *pNext;
+  uint8_t deviceUUID[VK_UUID_SIZE];
+  uint8_t driverUUID[VK_UUID_SIZE];
+  uint8_t deviceLUID[VK_LUID_SIZE];
+  uint32_t deviceNodeMask;
+  VkBool32 deviceLUIDValid;
+} VkPhysicalDeviceIDProperties;
+
+typedef struct VkMemoryType {
+  VkFlags propertyFlags;
+  uint32_t heapIndex;
+} VkMemoryType;
+
+typedef struct VkMemoryHeap {
+  VkDeviceSize size;
+  VkFlags flags;
+} VkMemoryHeap;
+
+typedef struct VkPhysicalDeviceMemoryProperties {
+  uint32_t memoryTypeCount;
+  VkMemoryType memoryTypes[VK_MAX_MEMORY_TYPES];
+  uint32_t memoryHeapCount;
+  VkMemoryHeap memoryHeaps[VK_MAX_MEMORY_HEAPS];
+} VkPhysicalDeviceMemoryProperties;
+
+typedef struct VkPhysicalDeviceMemoryProperties2 {
+  VkStructureType sType;
+  void *pNext;
+  VkPhysicalDeviceMemoryProperties memoryProperties;
+} VkPhysicalDeviceMemoryProperties2;
+
+typedef struct VkPhysicalDeviceMemoryBudgetPropertiesEXT {
+  VkStructureType sType;
+  void *pNext;
+  VkDeviceSize heapBudget[VK_MAX_MEMORY_HEAPS];
+  VkDeviceSize heapUsage[VK_MAX_MEMORY_HEAPS];
+} VkPhysicalDeviceMemoryBudgetPropertiesEXT;
+
+typedef struct VkExtensionProperties {
+  char extensionName[VK_MAX_EXTENSION_NAME_SIZE];
+  uint32_t specVersion;
+} VkExtensionProperties;
+
+typedef struct vk_handle {
+  void *handle;
+  uint16_t verbose;
+
+  VkInstance instance;
+  uint32_t num_devices;
+  VkPhysicalDevice *devices;
+
+  PFN_vkVoidFunction (*vkGetInstanceProcAddr)(VkInstance instance,
+                                              const char *pName);
+  VkResult (*vkCreateInstance)(const VkInstanceCreateInfo *pCreateInfo,
+                               const void *pAllocator, VkInstance *pInstance);
+  void (*vkDestroyInstance)(VkInstance instance, const void *pAllocator);
+  VkResult (*vkEnumeratePhysicalDevices)(VkInstance instance,
+                                         uint32_t *pPhysicalDeviceCount,
+                                         VkPhysicalDevice *pPhysicalDevices);
+  void (*vkGetPhysicalDeviceProperties2)(
+      VkPhysicalDevice physicalDevice, VkPhysicalDeviceProperties2 *pProperties);
+  void (*vkGetPhysicalDeviceMemoryProperties2)(
+      VkPhysicalDevice physicalDevice,
+      VkPhysicalDeviceMemoryProperties2 *pMemoryProperties);
+  VkResult (*vkEnumerateDeviceExtensionProperties)(
+      VkPhysicalDevice physicalDevice, const char *pLayerName,
+      uint32_t *pPropertyCount, VkExtensionProperties *pProperties);
+} vk_handle_t;
+
+typedef struct vk_init_resp {
+  char *err; // If err is non-null handle is invalid
+  vk_handle_t vh;
+} vk_init_resp_t;
+
+typedef struct vk_device_info {
+  mem_info_t mem; // gpu_id is the device UUID, major and minor the Vulkan version
+  uint32_t vendor_id;
+  VkPhysicalDeviceType device_type;
+  int memory_budget; // 0 if the device can't report its free memory
+} vk_device_info_t;
+
+void vk_init(char *vk_lib_path, vk_init_resp_t *resp);
+void vk_check_vram(vk_handle_t h, int device, vk_device_info_t *resp);
+void vk_release(vk_handle_t h);
+
+#endif // __GPU_INFO_VULKAN_H__
+#endif // __APPLE__
@@ -0,0 +1,62 @@
+//go:build linux || windows
+
+package discover
+
+import (
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// PCI vendor IDs of GPUs that other libraries support
+const (
+	vendorAMD    = 0x1002
+	vendorIntel  = 0x8086
+	vendorNVIDIA = 0x10de
+)
+
+// vulkanSkipReason returns why a GPU found with Vulkan isn't used with it, or
+// "" if it is. GPUs that CUDA, ROCm or oneAPI support run faster with them,
+// so Vulkan is only used for the rest, such as GPUs that are too old for
+// them or whose libraries aren't installed.
+func vulkanSkipReason(gpu VulkanGPUInfo, cuda []CudaGPUInfo, rocm []RocmGPUInfo, oneapi []OneapiGPUInfo) string {
+	if visible := envconfig.VkVisibleDevices(); visible != "" && !slices.Contains(strings.Split(visible, ","), strconv.Itoa(gpu.index)) {
+		return "not in GGML_VK_VISIBLE_DEVICES"
+	}
+
+	switch gpu.vendorID {
+	case vendorNVIDIA:
+		// NVIDIA GPUs have the same UUID in CUDA and Vulkan
+		if slices.ContainsFunc(cuda, func(g CudaGPUInfo) bool { return g.ID == gpu.uuid }) {
+			return "supported by cuda"
+		}
+	case vendorAMD:
+		// ROCm doesn't report UUIDs for every GPU, so if it found any, it
+		// is taken to support all of them
+		if len(rocm) > 0 {
+			return "supported by rocm"
+		}
+	case vendorIntel:
+		if len(oneapi) > 0 {
+			return "supported by oneapi"
+		}
+	}
+
+	return ""
+}
+
+func vulkanGetVisibleDevicesEnv(gpuInfo []GpuInfo) (string, string) {
+	ids := []string{}
+	for _, info := range gpuInfo {
+		if info.Library != "vulkan" {
+			// TODO shouldn't happen if things are wired correctly...
+			slog.Debug("vulkanGetVisibleDevicesEnv skipping over non-vulkan device", "library", info.Library)
+			continue
+		}
+		ids = append(ids, info.ID)
+	}
+	return "GGML_VK_VISIBLE_DEVICES", strings.Join(ids, ",")
+}
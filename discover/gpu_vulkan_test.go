@@ -0,0 +1,44 @@
+//go:build linux || windows
+
+package discover
+
+import "testing"
+
+func TestVulkanSkipReason(t *testing.T) {
+	cuda := []CudaGPUInfo{{GpuInfo: GpuInfo{Library: "cuda", ID: "GPU-d110a105-ac29-1d54-7b49-9c90440f215b"}}}
+	rocm := []RocmGPUInfo{{GpuInfo: GpuInfo{Library: "rocm", ID: "0"}}}
+
+	cases := []struct {
+		name    string
+		gpu     VulkanGPUInfo
+		visible string
+		skip    bool
+	}{
+		{"nvidia in cuda", VulkanGPUInfo{index: 0, vendorID: vendorNVIDIA, uuid: cuda[0].ID}, "", true},
+		{"nvidia not in cuda", VulkanGPUInfo{index: 1, vendorID: vendorNVIDIA, uuid: "GPU-00000000-0000-0000-0000-000000000000"}, "", false},
+		{"amd with rocm", VulkanGPUInfo{index: 2, vendorID: vendorAMD}, "", true},
+		{"intel without oneapi", VulkanGPUInfo{index: 3, vendorID: vendorIntel}, "", false},
+		{"visible", VulkanGPUInfo{index: 3, vendorID: vendorIntel}, "1,3", false},
+		{"not visible", VulkanGPUInfo{index: 3, vendorID: vendorIntel}, "1", true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GGML_VK_VISIBLE_DEVICES", tt.visible)
+			reason := vulkanSkipReason(tt.gpu, cuda, rocm, nil)
+			if (reason != "") != tt.skip {
+				t.Errorf("expected skip %v, got %q", tt.skip, reason)
+			}
+		})
+	}
+}
+
+func TestVulkanGetVisibleDevicesEnv(t *testing.T) {
+	env, val := GpuInfoList{
+		{Library: "vulkan", ID: "0"},
+		{Library: "vulkan", ID: "2"},
+	}.GetVisibleDevicesEnv()
+	if env != "GGML_VK_VISIBLE_DEVICES" || val != "0,2" {
+		t.Errorf("expected GGML_VK_VISIBLE_DEVICES=0,2, got %s=%s", env, val)
+	}
+}
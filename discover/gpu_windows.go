@@ -65,6 +65,7 @@ var (
 	NvcudaMgmtName = "nvcuda.dll"
 	NvmlMgmtName   = "nvml.dll"
 	OneapiMgmtName = "ze_intel_gpu64.dll"
+	VulkanMgmtName = "vulkan-1.dll"
 )
 
 func GetCPUMem() (memInfo, error) {
@@ -0,0 +1,113 @@
+package discover
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+)
+
+// Discoverer finds the GPUs of an accelerator that isn't built in, such as
+// one run by an Ascend or MUSA ggml backend library, so that models are
+// scheduled on them like on any other GPU. Discoverers add themselves with
+// RegisterDiscoverer from the init function of their package, which is
+// imported from a file with a build tag of its own.
+//
+// Discoverers are only used on Linux and Windows.
+type Discoverer interface {
+	// Library is the name of the library of the GPUs it finds, which is
+	// also the directory in LibOllamaPath with the libraries that runners
+	// need to use them.
+	Library() string
+
+	// Discover returns the GPUs that are available. It is called once,
+	// when GPUs are first looked up.
+	Discover() ([]GpuInfo, error)
+
+	// RefreshFreeMemory updates the free memory of GPUs that it found.
+	RefreshFreeMemory(gpus []GpuInfo) error
+
+	// VisibleDevicesEnv returns the name and value of an environment
+	// variable which limits a runner to gpus, or empty strings if there
+	// is none.
+	VisibleDevicesEnv(gpus GpuInfoList) (string, string)
+
+	// Backend is the name of the ml backend that the Ollama engine runs
+	// models on the GPUs with, or "" for the default backend, which loads
+	// the ggml libraries in the library's directory.
+	Backend() string
+}
+
+// builtinLibraries are the libraries of GPUs found without a Discoverer.
+var builtinLibraries = []string{"cpu", "cuda", "rocm", "oneapi", "vulkan", "metal"}
+
+var discoverers = make(map[string]Discoverer)
+
+// RegisterDiscoverer adds a Discoverer, which GPUs are looked up with along
+// with the built in libraries.
+func RegisterDiscoverer(d Discoverer) {
+	library := d.Library()
+	if slices.Contains(builtinLibraries, library) {
+		panic(fmt.Sprintf("discover: %q is a built in library", library))
+	}
+
+	if _, ok := discoverers[library]; ok {
+		panic(fmt.Sprintf("discover: discoverer for %q already registered", library))
+	}
+
+	discoverers[library] = d
+}
+
+// Backend returns the name of the ml backend for GPUs of a library, or "" if
+// the default backend runs them.
+func Backend(library string) string {
+	if d, ok := discoverers[library]; ok {
+		return d.Backend()
+	}
+
+	return ""
+}
+
+// discoverPluginGPUs returns the GPUs found by every registered discoverer,
+// and the errors of those that failed.
+func discoverPluginGPUs() ([]GpuInfo, []error) {
+	var gpus []GpuInfo
+	var errs []error
+	for _, library := range slices.Sorted(maps.Keys(discoverers)) {
+		found, err := discoverers[library].Discover()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", library, err))
+			continue
+		}
+
+		for _, gpu := range found {
+			// GPUs are grouped by their library, so they have to match
+			// the discoverer that found them
+			gpu.Library = library
+			slog.Info("discovered GPU", "library", library, "id", gpu.ID, "name", gpu.Name)
+			gpus = append(gpus, gpu)
+		}
+	}
+
+	return gpus, errs
+}
+
+// refreshPluginGPUs updates the free memory of GPUs returned by
+// discoverPluginGPUs, which are in order of their library.
+func refreshPluginGPUs(gpus []GpuInfo) {
+	for len(gpus) > 0 {
+		library := gpus[0].Library
+		n := 1
+		for n < len(gpus) && gpus[n].Library == library {
+			n++
+		}
+
+		if d, ok := discoverers[library]; ok {
+			if err := d.RefreshFreeMemory(gpus[:n]); err != nil {
+				slog.Debug("problem refreshing free memory", "library", library, "error", err)
+			}
+		}
+
+		gpus = gpus[n:]
+	}
+}
@@ -0,0 +1,109 @@
+package discover
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type testDiscoverer struct {
+	library string
+	gpus    []GpuInfo
+	err     error
+	free    uint64
+}
+
+func (d *testDiscoverer) Library() string { return d.library }
+
+func (d *testDiscoverer) Discover() ([]GpuInfo, error) { return d.gpus, d.err }
+
+func (d *testDiscoverer) RefreshFreeMemory(gpus []GpuInfo) error {
+	for i := range gpus {
+		gpus[i].FreeMemory = d.free
+	}
+	return nil
+}
+
+func (d *testDiscoverer) VisibleDevicesEnv(gpus GpuInfoList) (string, string) {
+	return "TEST_VISIBLE_DEVICES", gpus[0].ID
+}
+
+func (d *testDiscoverer) Backend() string { return d.library + "-backend" }
+
+func registerTestDiscoverers(t *testing.T, ds ...Discoverer) {
+	t.Helper()
+	saved := discoverers
+	discoverers = make(map[string]Discoverer)
+	t.Cleanup(func() { discoverers = saved })
+
+	for _, d := range ds {
+		RegisterDiscoverer(d)
+	}
+}
+
+func TestPluginGPUs(t *testing.T) {
+	gpu := func(id string) GpuInfo {
+		var g GpuInfo
+		g.ID = id
+		g.FreeMemory = 100
+		return g
+	}
+
+	musa := &testDiscoverer{library: "musa", gpus: []GpuInfo{gpu("0"), gpu("1")}, free: 50}
+	ascend := &testDiscoverer{library: "ascend", gpus: []GpuInfo{gpu("0")}, free: 70}
+	broken := &testDiscoverer{library: "broken", err: errors.New("no driver")}
+	registerTestDiscoverers(t, musa, ascend, broken)
+
+	gpus, errs := discoverPluginGPUs()
+	if len(errs) != 1 || !errors.Is(errs[0], broken.err) {
+		t.Errorf("expected the error of the broken discoverer, got %v", errs)
+	}
+
+	var libraries []string
+	for _, g := range gpus {
+		libraries = append(libraries, g.Library+"/"+g.ID)
+	}
+	if diff := cmp.Diff([]string{"ascend/0", "musa/0", "musa/1"}, libraries); diff != "" {
+		t.Errorf("GPUs mismatch (-want +got):\n%s", diff)
+	}
+
+	refreshPluginGPUs(gpus)
+	for i, want := range []uint64{70, 50, 50} {
+		if gpus[i].FreeMemory != want {
+			t.Errorf("GPU %d: expected %d free, got %d", i, want, gpus[i].FreeMemory)
+		}
+	}
+
+	if got := Backend("musa"); got != "musa-backend" {
+		t.Errorf("expected musa-backend, got %q", got)
+	}
+
+	if got := Backend("cuda"); got != "" {
+		t.Errorf("expected the default backend for cuda, got %q", got)
+	}
+
+	if runtime.GOOS != "darwin" {
+		env, val := GpuInfoList(gpus[1:]).GetVisibleDevicesEnv()
+		if env != "TEST_VISIBLE_DEVICES" || val != "0" {
+			t.Errorf("expected TEST_VISIBLE_DEVICES=0, got %s=%s", env, val)
+		}
+	}
+}
+
+func TestRegisterDiscoverer(t *testing.T) {
+	registerTestDiscoverers(t, &testDiscoverer{library: "musa"})
+
+	for _, library := range []string{"musa", "cuda"} {
+		t.Run(library, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic")
+				}
+			}()
+
+			RegisterDiscoverer(&testDiscoverer{library: library})
+		})
+	}
+}
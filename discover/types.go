@@ -92,6 +92,14 @@ type OneapiGPUInfo struct {
 }
 type OneapiGPUInfoList []OneapiGPUInfo
 
+type VulkanGPUInfo struct {
+	GpuInfo
+	index    int    //nolint:unused,nolintlint
+	uuid     string //nolint:unused,nolintlint
+	vendorID uint32 //nolint:unused,nolintlint
+}
+type VulkanGPUInfoList []VulkanGPUInfo
+
 type GpuInfoList []GpuInfo
 
 type UnsupportedGPUInfo struct {
@@ -12,14 +12,20 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/ollama/ollama/format"
 )
 
 // Host returns the scheme and host. Host can be configured via the OLLAMA_HOST environment variable.
 // Default is scheme "http" and host "127.0.0.1:11434"
 func Host() *url.URL {
+	return parseHost(Var("OLLAMA_HOST"))
+}
+
+func parseHost(s string) *url.URL {
 	defaultPort := "11434"
 
-	s := strings.TrimSpace(Var("OLLAMA_HOST"))
+	s = strings.TrimSpace(s)
 	scheme, hostport, ok := strings.Cut(s, "://")
 	switch {
 	case !ok:
@@ -53,28 +59,127 @@ func Host() *url.URL {
 	}
 }
 
-// AllowedOrigins returns a list of allowed origins. AllowedOrigins can be configured via the OLLAMA_ORIGINS environment variable.
-func AllowedOrigins() (origins []string) {
-	if s := Var("OLLAMA_ORIGINS"); s != "" {
-		origins = strings.Split(s, ",")
+// Peers returns the Ollama servers on the local network which are checked for model blobs before
+// pulling them from a registry. Peers can be configured via the OLLAMA_PEERS environment variable
+// as a comma separated list of hosts using the same format as OLLAMA_HOST.
+func Peers() (peers []*url.URL) {
+	for _, s := range strings.Split(Var("OLLAMA_PEERS"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			peers = append(peers, parseHost(s))
+		}
+	}
+
+	return peers
+}
+
+// ReadyModels returns the models which must be loaded for the server to report that it's ready on /readyz.
+// ReadyModels can be configured via the OLLAMA_READY_MODELS environment variable as a comma separated list.
+func ReadyModels() (models []string) {
+	for _, s := range strings.Split(Var("OLLAMA_READY_MODELS"), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			models = append(models, s)
+		}
+	}
+
+	return models
+}
+
+// The scopes of the API which origins may be allowed to call from a browser.
+const (
+	// ScopeInference covers generating, embedding and the other endpoints which run models, and saved chats.
+	ScopeInference = "inference"
+
+	// ScopeRead covers listing and showing models and checking on the server.
+	ScopeRead = "read"
+
+	// ScopeManage covers pulling, pushing, creating, copying and deleting models, and changing the server.
+	ScopeManage = "manage"
+)
+
+// Origin is a pattern of origins which browsers may call the API from, and the scopes of the API they may call.
+type Origin struct {
+	// Pattern is an origin, such as https://example.com, which may have a * matching any characters. A Pattern of
+	// * alone matches every origin.
+	Pattern string
+
+	// Scopes are the scopes of the API browsers at the origin may call.
+	Scopes []string
+}
+
+func (o Origin) String() string {
+	return o.Pattern + "=" + strings.Join(o.Scopes, "+")
+}
+
+// Origins returns the origins which browsers may call the API from. Origins can be configured via the OLLAMA_ORIGINS
+// environment variable as a comma separated list, where each origin may be followed by = and the scopes it may call
+// separated by +, e.g. https://chat.example.com,https://admin.example.com=inference+read+manage. The scope all
+// allows every scope.
+//
+// Origins without scopes, and the local origins which are always allowed, may call the inference and read scopes.
+// Browsers can only manage models and the server from origins which are given the manage scope.
+func Origins() (origins []Origin) {
+	defaultScopes := []string{ScopeInference, ScopeRead}
+
+	for _, s := range strings.Split(Var("OLLAMA_ORIGINS"), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		pattern, scopes, ok := strings.Cut(s, "=")
+		if pattern != "*" && (!strings.Contains(pattern, "://") || strings.Count(pattern, "*") > 1) {
+			slog.Warn("invalid origin, ignoring it", "key", "OLLAMA_ORIGINS", "origin", pattern)
+			continue
+		}
+
+		if !ok {
+			origins = append(origins, Origin{Pattern: pattern, Scopes: defaultScopes})
+			continue
+		}
+
+		origin := Origin{Pattern: pattern}
+		for _, scope := range strings.Split(scopes, "+") {
+			switch scope = strings.ToLower(strings.TrimSpace(scope)); scope {
+			case ScopeInference, ScopeRead, ScopeManage:
+				origin.Scopes = append(origin.Scopes, scope)
+			case "all":
+				origin.Scopes = append(origin.Scopes, ScopeInference, ScopeRead, ScopeManage)
+			default:
+				slog.Warn("invalid origin scope, ignoring it", "key", "OLLAMA_ORIGINS", "origin", pattern, "scope", scope)
+			}
+		}
+
+		origins = append(origins, origin)
 	}
 
 	for _, origin := range []string{"localhost", "127.0.0.1", "0.0.0.0"} {
 		origins = append(origins,
-			fmt.Sprintf("http://%s", origin),
-			fmt.Sprintf("https://%s", origin),
-			fmt.Sprintf("http://%s", net.JoinHostPort(origin, "*")),
-			fmt.Sprintf("https://%s", net.JoinHostPort(origin, "*")),
+			Origin{fmt.Sprintf("http://%s", origin), defaultScopes},
+			Origin{fmt.Sprintf("https://%s", origin), defaultScopes},
+			Origin{fmt.Sprintf("http://%s", net.JoinHostPort(origin, "*")), defaultScopes},
+			Origin{fmt.Sprintf("https://%s", net.JoinHostPort(origin, "*")), defaultScopes},
 		)
 	}
 
-	origins = append(origins,
+	for _, origin := range []string{
 		"app://*",
 		"file://*",
 		"tauri://*",
 		"vscode-webview://*",
 		"vscode-file://*",
-	)
+	} {
+		origins = append(origins, Origin{origin, defaultScopes})
+	}
+
+	return origins
+}
+
+// AllowedOrigins returns the patterns of the origins which browsers may call the API from, whatever their scopes.
+// AllowedOrigins can be configured via the OLLAMA_ORIGINS environment variable.
+func AllowedOrigins() (origins []string) {
+	for _, origin := range Origins() {
+		origins = append(origins, origin.Pattern)
+	}
 
 	return origins
 }
@@ -134,6 +239,47 @@ func LoadTimeout() (loadTimeout time.Duration) {
 	return loadTimeout
 }
 
+// Duration returns a duration which can be set either as a Go duration, e.g. 24h, or as a number of seconds.
+func Duration(key string, defaultValue time.Duration) func() time.Duration {
+	return func() time.Duration {
+		if s := Var(key); s != "" {
+			if d, err := time.ParseDuration(s); err == nil {
+				return d
+			} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return time.Duration(n) * time.Second
+			}
+
+			slog.Warn("invalid environment variable, using default", "key", key, "value", s, "default", defaultValue)
+		}
+
+		return defaultValue
+	}
+}
+
+var (
+	// PruneInterval sets how often the server prunes the model store with the policy set by PruneUnusedFor,
+	// PruneKeepTags and PruneMaxSize. PruneInterval can be configured via the OLLAMA_PRUNE_INTERVAL environment variable.
+	// Zero disables background pruning.
+	PruneInterval = Duration("OLLAMA_PRUNE_INTERVAL", 0)
+	// PruneUnusedFor prunes models which haven't been used for this long. PruneUnusedFor can be configured via the
+	// OLLAMA_PRUNE_UNUSED_FOR environment variable.
+	PruneUnusedFor = Duration("OLLAMA_PRUNE_UNUSED_FOR", 0)
+	// DrainTimeout is how long the server waits for in-flight requests to finish when it shuts down before
+	// cutting them off. DrainTimeout can be configured via the OLLAMA_DRAIN_TIMEOUT environment variable.
+	DrainTimeout = Duration("OLLAMA_DRAIN_TIMEOUT", 20*time.Second)
+	// GenerateTimeout is how long after a request is received generation stops, for requests which don't set
+	// their own timeout_ms. GenerateTimeout can be configured via the OLLAMA_GENERATE_TIMEOUT environment variable.
+	// Zero lets generation run until it finishes.
+	GenerateTimeout = Duration("OLLAMA_GENERATE_TIMEOUT", 0)
+	// ResponseCacheTTL is how long the responses to deterministic requests, which set a seed or a temperature of zero,
+	// are returned again for identical requests to the same model. ResponseCacheTTL can be configured via the
+	// OLLAMA_RESPONSE_CACHE_TTL environment variable. Zero disables the response cache.
+	ResponseCacheTTL = Duration("OLLAMA_RESPONSE_CACHE_TTL", 0)
+	// IdempotencyTTL is how long the response to a request with an Idempotency-Key header is returned again for
+	// retries with the same key. IdempotencyTTL can be configured via the OLLAMA_IDEMPOTENCY_TTL environment variable.
+	IdempotencyTTL = Duration("OLLAMA_IDEMPOTENCY_TTL", time.Hour)
+)
+
 func Bool(k string) func() bool {
 	return func() bool {
 		if s := Var(k); s != "" {
@@ -162,8 +308,14 @@ var (
 	NoPrune = Bool("OLLAMA_NOPRUNE")
 	// SchedSpread allows scheduling models across all GPUs.
 	SchedSpread = Bool("OLLAMA_SCHED_SPREAD")
+	// ServeBlobs allows model blobs to be downloaded with GET /api/blobs,
+	// so that servers with this one in OLLAMA_PEERS can pull from it.
+	ServeBlobs = Bool("OLLAMA_SERVE_BLOBS")
 	// IntelGPU enables experimental Intel GPU detection.
 	IntelGPU = Bool("OLLAMA_INTEL_GPU")
+	// Vulkan enables experimental detection of GPUs with Vulkan, which are
+	// used if CUDA, ROCm or oneAPI don't support them.
+	Vulkan = Bool("OLLAMA_VULKAN")
 	// MultiUserCache optimizes prompt caching for multi-user scenarios
 	MultiUserCache = Bool("OLLAMA_MULTIUSER_CACHE")
 	// Enable the new Ollama engine
@@ -185,6 +337,7 @@ var (
 	HipVisibleDevices     = String("HIP_VISIBLE_DEVICES")
 	RocrVisibleDevices    = String("ROCR_VISIBLE_DEVICES")
 	GpuDeviceOrdinal      = String("GPU_DEVICE_ORDINAL")
+	VkVisibleDevices      = String("GGML_VK_VISIBLE_DEVICES")
 	HsaOverrideGfxVersion = String("HSA_OVERRIDE_GFX_VERSION")
 )
 
@@ -193,6 +346,8 @@ func Uint(key string, defaultValue uint) func() uint {
 		if s := Var(key); s != "" {
 			if n, err := strconv.ParseUint(s, 10, 64); err != nil {
 				slog.Warn("invalid environment variable, using default", "key", key, "value", s, "default", defaultValue)
+			} else if err := checkRange(key, float64(n)); err != nil {
+				slog.Warn("invalid environment variable, using default", "key", key, "value", s, "default", defaultValue, "error", err)
 			} else {
 				return uint(n)
 			}
@@ -207,10 +362,27 @@ var (
 	NumParallel = Uint("OLLAMA_NUM_PARALLEL", 0)
 	// MaxRunners sets the maximum number of loaded models. MaxRunners can be configured via the OLLAMA_MAX_LOADED_MODELS environment variable.
 	MaxRunners = Uint("OLLAMA_MAX_LOADED_MODELS", 0)
+	// MaxAdapters sets the maximum number of LoRA adapters loaded with each model. MaxAdapters can be configured via the OLLAMA_MAX_LOADED_LORAS environment variable.
+	MaxAdapters = Uint("OLLAMA_MAX_LOADED_LORAS", 8)
 	// MaxQueue sets the maximum number of queued requests. MaxQueue can be configured via the OLLAMA_MAX_QUEUE environment variable.
 	MaxQueue = Uint("OLLAMA_MAX_QUEUE", 512)
 	// MaxVRAM sets a maximum VRAM override in bytes. MaxVRAM can be configured via the OLLAMA_MAX_VRAM environment variable.
 	MaxVRAM = Uint("OLLAMA_MAX_VRAM", 0)
+	// UploadConcurrency sets the maximum number of parts of a blob uploaded at the same time. UploadConcurrency can be configured via the OLLAMA_UPLOAD_CONCURRENCY environment variable.
+	UploadConcurrency = Uint("OLLAMA_UPLOAD_CONCURRENCY", 16)
+	// PruneKeepTags prunes all but this many of the most recently used tags of each model. PruneKeepTags can be configured via the OLLAMA_PRUNE_KEEP_TAGS environment variable.
+	PruneKeepTags = Uint("OLLAMA_PRUNE_KEEP_TAGS", 0)
+	// MaxNumCtx limits the context length of each request. Requests which ask for more are refused, and defaults above
+	// it are lowered to it. MaxNumCtx can be configured via the OLLAMA_MAX_NUM_CTX environment variable. Zero means no
+	// limit.
+	MaxNumCtx = Uint("OLLAMA_MAX_NUM_CTX", 0)
+	// MaxNumPredict limits the tokens generated for each request. Requests which ask for more are refused, and requests
+	// which don't ask for a number stop at it. MaxNumPredict can be configured via the OLLAMA_MAX_NUM_PREDICT
+	// environment variable. Zero means no limit.
+	MaxNumPredict = Uint("OLLAMA_MAX_NUM_PREDICT", 0)
+	// MaxImages limits the images in each request. MaxImages can be configured via the OLLAMA_MAX_IMAGES environment
+	// variable. Zero means no limit.
+	MaxImages = Uint("OLLAMA_MAX_IMAGES", 0)
 )
 
 func Uint64(key string, defaultValue uint64) func() uint64 {
@@ -227,9 +399,237 @@ func Uint64(key string, defaultValue uint64) func() uint64 {
 	}
 }
 
+// Float returns a number which may have a fractional part, e.g. 0.9.
+func Float(key string, defaultValue float64) func() float64 {
+	return func() float64 {
+		if s := Var(key); s != "" {
+			if n, err := strconv.ParseFloat(s, 64); err != nil {
+				slog.Warn("invalid environment variable, using default", "key", key, "value", s, "default", defaultValue)
+			} else if err := checkRange(key, n); err != nil {
+				slog.Warn("invalid environment variable, using default", "key", key, "value", s, "default", defaultValue, "error", err)
+			} else {
+				return n
+			}
+		}
+
+		return defaultValue
+	}
+}
+
+var (
+	// SemanticCache is an embedding model, e.g. all-minilm, which the prompts of requests are embedded with to
+	// return the cached response to a similar prompt, with the same parameters, to the same model. Responses are kept for
+	// OLLAMA_RESPONSE_CACHE_TTL, which must be set. SemanticCache can be configured via the
+	// OLLAMA_SEMANTIC_CACHE environment variable.
+	SemanticCache = String("OLLAMA_SEMANTIC_CACHE")
+	// SemanticThreshold is how similar the embeddings of two prompts must be, from 0 to 1, for the response to
+	// one to be returned for the other. SemanticThreshold can be configured via the
+	// OLLAMA_SEMANTIC_THRESHOLD environment variable.
+	SemanticThreshold = Float("OLLAMA_SEMANTIC_THRESHOLD", 0.95)
+)
+
 // Set aside VRAM per GPU
 var GpuOverhead = Uint64("OLLAMA_GPU_OVERHEAD", 0)
 
+// Bytes returns a size in bytes which can be set either as a plain number or with a unit, e.g. 10MB.
+func Bytes(key string, defaultValue int64) func() int64 {
+	return func() int64 {
+		if s := Var(key); s != "" {
+			if n, err := format.ParseBytes(s); err != nil {
+				slog.Warn("invalid environment variable, using default", "key", key, "value", s, "default", defaultValue)
+			} else {
+				return n
+			}
+		}
+
+		return defaultValue
+	}
+}
+
+var (
+	// MaxUploadRate limits the combined bandwidth of blob uploads in bytes per second. MaxUploadRate can be configured via the OLLAMA_MAX_UPLOAD_RATE environment variable.
+	// Zero means no limit.
+	MaxUploadRate = Bytes("OLLAMA_MAX_UPLOAD_RATE", 0)
+	// MaxDownloadRate limits the combined bandwidth of blob downloads in bytes per second. MaxDownloadRate can be configured via the OLLAMA_MAX_DOWNLOAD_RATE environment variable.
+	// Zero means no limit.
+	MaxDownloadRate = Bytes("OLLAMA_MAX_DOWNLOAD_RATE", 0)
+	// PruneMaxSize prunes the least recently used models until the model store is at most this many bytes. PruneMaxSize can be configured via the OLLAMA_PRUNE_MAX_SIZE environment variable.
+	PruneMaxSize = Bytes("OLLAMA_PRUNE_MAX_SIZE", 0)
+	// BlobCacheSize limits the blobs kept in the models directory when models are stored in a blob store. The least
+	// recently used blobs are evicted first. BlobCacheSize can be configured via the OLLAMA_BLOB_CACHE_SIZE environment variable.
+	// Zero means no limit.
+	BlobCacheSize = Bytes("OLLAMA_BLOB_CACHE_SIZE", 0)
+	// ResponseCacheSize limits the memory used to keep responses for idempotency keys and the response cache. The
+	// responses which expire soonest are evicted first. ResponseCacheSize can be configured via the
+	// OLLAMA_RESPONSE_CACHE_SIZE environment variable.
+	ResponseCacheSize = Bytes("OLLAMA_RESPONSE_CACHE_SIZE", 64*format.MegaByte)
+	// MaxRequestSize limits the body of inference requests, including their images. MaxRequestSize can be configured
+	// via the OLLAMA_MAX_REQUEST_SIZE environment variable. Zero means no limit.
+	MaxRequestSize = Bytes("OLLAMA_MAX_REQUEST_SIZE", 0)
+)
+
+// DownloadSchedule overrides MaxDownloadRate during the given times of day, e.g. "19:00-07:00" for full speed
+// overnight or "09:00-17:00=1MB" to slow down during office hours. DownloadSchedule can be configured via the
+// OLLAMA_DOWNLOAD_SCHEDULE environment variable.
+var DownloadSchedule = String("OLLAMA_DOWNLOAD_SCHEDULE")
+
+// BlobStore is the URL of a directory or object store to keep models in, e.g. "s3://bucket/prefix",
+// "gs://bucket/prefix", "azblob://container/prefix" or "file:///mnt/models". The models directory then caches the
+// blobs in use. BlobStore can be configured via the OLLAMA_BLOB_STORE environment variable.
+var BlobStore = String("OLLAMA_BLOB_STORE")
+
+// NamespaceQuotas limits the disk space used by the models in each namespace, e.g. "team-a=100GB,*=20GB" where "*"
+// applies to namespaces without their own quota. NamespaceQuotas can be configured via the OLLAMA_NAMESPACE_QUOTAS
+// environment variable.
+var NamespaceQuotas = String("OLLAMA_NAMESPACE_QUOTAS")
+
+var (
+	// SystemPreamble is prepended to the system prompt of every chat and generate request, e.g. to enforce a safety
+	// policy clients can't remove. SystemPreamble can be configured via the OLLAMA_SYSTEM_PREAMBLE environment variable.
+	SystemPreamble = String("OLLAMA_SYSTEM_PREAMBLE")
+	// SystemPostamble is appended to the system prompt of every chat and generate request. SystemPostamble can be
+	// configured via the OLLAMA_SYSTEM_POSTAMBLE environment variable.
+	SystemPostamble = String("OLLAMA_SYSTEM_POSTAMBLE")
+	// SystemPolicy is the path of a JSON file which overrides SystemPreamble and SystemPostamble for some models, e.g.
+	// {"llama3.2": {"preamble": "...", "postamble": ""}}. SystemPolicy can be configured via the OLLAMA_SYSTEM_POLICY
+	// environment variable.
+	SystemPolicy = String("OLLAMA_SYSTEM_POLICY")
+)
+
+// ModerationModel is a safety classifier model, such as llama-guard3, which screens chat and generate requests and
+// their responses. ModerationModel can be configured via the OLLAMA_MODERATION_MODEL environment variable. Nothing
+// is screened if it isn't set.
+var ModerationModel = String("OLLAMA_MODERATION_MODEL")
+
+// ModerationPolicy is what happens to requests and responses flagged by ModerationModel: "block" refuses them,
+// "annotate" adds the verdict to the response and "log" only logs it. ModerationPolicy can be configured via the
+// OLLAMA_MODERATION_POLICY environment variable. Default is "block".
+func ModerationPolicy() string {
+	switch s := strings.ToLower(strings.TrimSpace(Var("OLLAMA_MODERATION_POLICY"))); s {
+	case "block", "annotate", "log":
+		return s
+	case "":
+	default:
+		slog.Warn("invalid environment variable, using default", "key", "OLLAMA_MODERATION_POLICY", "value", s, "default", "block")
+	}
+
+	return "block"
+}
+
+// Plugins is the path of a JSON file of executables which see and can change requests to generate text or embeddings
+// and their responses, such as to redact them. The file is read again when it changes. Plugins can be configured via
+// the OLLAMA_PLUGINS environment variable.
+var Plugins = String("OLLAMA_PLUGINS")
+
+// AgentTools is the path of a JSON file of the tools models can call through /api/agent: HTTP endpoints, commands from
+// an allowlist, and MCP servers. The file is read again when it changes. AgentTools can be configured via the
+// OLLAMA_AGENT_TOOLS environment variable.
+var AgentTools = String("OLLAMA_AGENT_TOOLS")
+
+// APIKeys are the API keys which requests must have, each with the model namespace it can use, e.g.
+// "key1=team-a,key2=team-b,key3=*" where "*" can use every namespace. Requests with other keys can only see and change
+// the models in their namespace, and use those in the shared "library" namespace. APIKeys can be configured via the
+// OLLAMA_API_KEYS environment variable. Requests don't need keys if it isn't set.
+var APIKeys = String("OLLAMA_API_KEYS")
+
+// APIKey is the API key clients send to the server. APIKey can be configured via the OLLAMA_API_KEY environment
+// variable.
+var APIKey = String("OLLAMA_API_KEY")
+
+var (
+	// Webhooks are the URLs, separated by commas, which the server POSTs events to when models are pulled, created or
+	// deleted and runners are loaded, unloaded or crash. Webhooks can be configured via the OLLAMA_WEBHOOKS environment
+	// variable.
+	Webhooks = String("OLLAMA_WEBHOOKS")
+	// WebhookSecret signs the bodies of webhooks with HMAC-SHA256 so receivers can check they came from the server.
+	// WebhookSecret can be configured via the OLLAMA_WEBHOOK_SECRET environment variable.
+	WebhookSecret = String("OLLAMA_WEBHOOK_SECRET")
+	// WebhookEvents are the events, separated by commas, which webhooks are sent for, e.g. "model.pulled,runner.crashed"
+	// or "*" for every event. WebhookEvents can be configured via the OLLAMA_WEBHOOK_EVENTS environment variable.
+	// Default is every event except "request.completed".
+	WebhookEvents = String("OLLAMA_WEBHOOK_EVENTS")
+)
+
+var (
+	// Capture is a directory which requests to generate text or embeddings, and their responses, are recorded to, for
+	// ollama replay to send again. Capture can be configured via the OLLAMA_CAPTURE environment variable.
+	Capture = String("OLLAMA_CAPTURE")
+	// CaptureMax is the number of captures kept, after which the oldest is deleted for each new one. CaptureMax can be
+	// configured via the OLLAMA_CAPTURE_MAX environment variable.
+	CaptureMax = Uint("OLLAMA_CAPTURE_MAX", 1000)
+	// CaptureRedact are rules, separated by commas, for what is left out of captures: the names of JSON fields whose
+	// values are replaced, e.g. "images,api_key", and regular expressions between slashes whose matches in any string
+	// are, e.g. "/[0-9]{3}-[0-9]{4}/". CaptureRedact can be configured via the OLLAMA_CAPTURE_REDACT environment
+	// variable.
+	CaptureRedact = String("OLLAMA_CAPTURE_REDACT")
+)
+
+var (
+	// MaxGGUFTensors is the most tensors a model file may have. MaxGGUFTensors can be configured via the
+	// OLLAMA_MAX_GGUF_TENSORS environment variable.
+	MaxGGUFTensors = Uint("OLLAMA_MAX_GGUF_TENSORS", 1<<16)
+	// MaxGGUFKV is the most key-values a model file may have. MaxGGUFKV can be configured via the OLLAMA_MAX_GGUF_KV
+	// environment variable.
+	MaxGGUFKV = Uint("OLLAMA_MAX_GGUF_KV", 1<<16)
+	// MaxGGUFArray is the most elements an array in a model file may have. MaxGGUFArray can be configured via the
+	// OLLAMA_MAX_GGUF_ARRAY environment variable.
+	MaxGGUFArray = Uint("OLLAMA_MAX_GGUF_ARRAY", 1<<24)
+	// MaxGGUFString is the longest a string in a model file may be, in bytes. MaxGGUFString can be configured via the
+	// OLLAMA_MAX_GGUF_STRING environment variable.
+	MaxGGUFString = Bytes("OLLAMA_MAX_GGUF_STRING", 32<<20)
+	// MaxGGUFMetadata is the most memory the strings and arrays read from a model file may take up together.
+	// MaxGGUFMetadata can be configured via the OLLAMA_MAX_GGUF_METADATA environment variable.
+	MaxGGUFMetadata = Bytes("OLLAMA_MAX_GGUF_METADATA", 1<<30)
+)
+
+var (
+	// CABundle is the path of a PEM file of certificates to trust as well as the system's when connecting to
+	// registries and servers, such as those of a proxy which intercepts TLS. CABundle can be configured via the
+	// OLLAMA_CA_BUNDLE environment variable.
+	CABundle = String("OLLAMA_CA_BUNDLE")
+	// Registries is the path of a JSON file of settings for each registry, such as a CA bundle to trust for it,
+	// whether to verify its certificate or mirrors to pull from before it. The file is read again when it changes. Registries can be configured via the
+	// OLLAMA_REGISTRIES environment variable.
+	Registries = String("OLLAMA_REGISTRIES")
+)
+
+var (
+	// OriginsMaxAge is how long browsers may cache the response to a preflight request, which says whether an origin
+	// may call an endpoint. OriginsMaxAge can be configured via the OLLAMA_ORIGINS_MAX_AGE environment variable.
+	OriginsMaxAge = Duration("OLLAMA_ORIGINS_MAX_AGE", 12*time.Hour)
+)
+
+var (
+	// RunnerSandbox confines runners to the model files they load and a temporary directory, without network access,
+	// so a model file which exploits a bug in a decoder can do little harm. RunnerSandbox can be configured via the
+	// OLLAMA_RUNNER_SANDBOX environment variable.
+	RunnerSandbox = Bool("OLLAMA_RUNNER_SANDBOX")
+	// RunnerAppArmor is an AppArmor profile which sandboxed runners change to on Linux. RunnerAppArmor can be
+	// configured via the OLLAMA_RUNNER_APPARMOR environment variable.
+	RunnerAppArmor = String("OLLAMA_RUNNER_APPARMOR")
+)
+
+// RunnerPaths returns the files and directories, other than the model files and those of the system, which
+// sandboxed runners may read, such as GPU drivers installed in unusual places. RunnerPaths can be configured via the
+// OLLAMA_RUNNER_PATHS environment variable as a list separated like PATH.
+func RunnerPaths() (paths []string) {
+	for _, s := range filepath.SplitList(Var("OLLAMA_RUNNER_PATHS")) {
+		if s = strings.TrimSpace(s); s != "" {
+			paths = append(paths, s)
+		}
+	}
+
+	return paths
+}
+
+// HFEndpoint is the Hugging Face Hub which models are converted from without downloading them first (default:
+// https://huggingface.co). HFEndpoint can be configured via the HF_ENDPOINT environment variable.
+var HFEndpoint = String("HF_ENDPOINT")
+
+// HFToken authorizes access to gated and private Hugging Face repositories. HFToken can be configured via the HF_TOKEN
+// environment variable.
+var HFToken = String("HF_TOKEN")
+
 type EnvVar struct {
 	Name        string
 	Value       any
@@ -238,27 +638,77 @@ type EnvVar struct {
 
 func AsMap() map[string]EnvVar {
 	ret := map[string]EnvVar{
-		"OLLAMA_DEBUG":             {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
-		"OLLAMA_FLASH_ATTENTION":   {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
-		"OLLAMA_KV_CACHE_TYPE":     {"OLLAMA_KV_CACHE_TYPE", KvCacheType(), "Quantization type for the K/V cache (default: f16)"},
-		"OLLAMA_GPU_OVERHEAD":      {"OLLAMA_GPU_OVERHEAD", GpuOverhead(), "Reserve a portion of VRAM per GPU (bytes)"},
-		"OLLAMA_HOST":              {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
-		"OLLAMA_KEEP_ALIVE":        {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
-		"OLLAMA_LLM_LIBRARY":       {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
-		"OLLAMA_LOAD_TIMEOUT":      {"OLLAMA_LOAD_TIMEOUT", LoadTimeout(), "How long to allow model loads to stall before giving up (default \"5m\")"},
-		"OLLAMA_MAX_LOADED_MODELS": {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
-		"OLLAMA_MAX_QUEUE":         {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
-		"OLLAMA_MODELS":            {"OLLAMA_MODELS", Models(), "The path to the models directory"},
-		"OLLAMA_NOHISTORY":         {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
-		"OLLAMA_NOPRUNE":           {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
-		"OLLAMA_NUM_PARALLEL":      {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
-		"OLLAMA_ORIGINS":           {"OLLAMA_ORIGINS", AllowedOrigins(), "A comma separated list of allowed origins"},
-		"OLLAMA_SCHED_SPREAD":      {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
-		"OLLAMA_MULTIUSER_CACHE":   {"OLLAMA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
-		"OLLAMA_CONTEXT_LENGTH":    {"OLLAMA_CONTEXT_LENGTH", ContextLength(), "Context length to use unless otherwise specified (default: 2048)"},
-		"OLLAMA_NEW_ENGINE":        {"OLLAMA_NEW_ENGINE", NewEngine(), "Enable the new Ollama engine"},
+		"OLLAMA_AGENT_TOOLS":         {"OLLAMA_AGENT_TOOLS", AgentTools(), "Path of a JSON file of the tools models can call through /api/agent"},
+		"OLLAMA_BLOB_CACHE_SIZE":     {"OLLAMA_BLOB_CACHE_SIZE", BlobCacheSize(), "Maximum size of blobs cached from the blob store, e.g. 100GB (default: unlimited)"},
+		"OLLAMA_BLOB_STORE":          {"OLLAMA_BLOB_STORE", BlobStore(), "Keep models in a blob store, e.g. s3://bucket/prefix, gs://bucket, azblob://container or file:///mnt/models"},
+		"OLLAMA_CAPTURE":             {"OLLAMA_CAPTURE", Capture(), "Directory to record requests and their responses to, for ollama replay"},
+		"OLLAMA_CAPTURE_MAX":         {"OLLAMA_CAPTURE_MAX", CaptureMax(), "Maximum number of captures kept (default: 1000)"},
+		"OLLAMA_CAPTURE_REDACT":      {"OLLAMA_CAPTURE_REDACT", CaptureRedact(), "JSON fields and /regular expressions/ left out of captures, separated by commas"},
+		"OLLAMA_CA_BUNDLE":           {"OLLAMA_CA_BUNDLE", CABundle(), "Path of a PEM file of certificates to trust for registries and servers"},
+		"OLLAMA_CONFIG":              {"OLLAMA_CONFIG", ConfigFile(), "Path of a file of settings which are reloaded on SIGHUP"},
+		"OLLAMA_DEBUG":               {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
+		"OLLAMA_DOWNLOAD_SCHEDULE":   {"OLLAMA_DOWNLOAD_SCHEDULE", DownloadSchedule(), "Times of day with a different download rate, e.g. 19:00-07:00 or 09:00-17:00=1MB"},
+		"OLLAMA_DRAIN_TIMEOUT":       {"OLLAMA_DRAIN_TIMEOUT", DrainTimeout(), "How long to wait for in-flight requests to finish when shutting down (default \"20s\")"},
+		"OLLAMA_GENERATE_TIMEOUT":    {"OLLAMA_GENERATE_TIMEOUT", GenerateTimeout(), "How long to generate for before returning the response so far (default none)"},
+		"OLLAMA_FLASH_ATTENTION":     {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
+		"OLLAMA_KV_CACHE_TYPE":       {"OLLAMA_KV_CACHE_TYPE", KvCacheType(), "Quantization type for the K/V cache (default: f16)"},
+		"OLLAMA_GPU_OVERHEAD":        {"OLLAMA_GPU_OVERHEAD", GpuOverhead(), "Reserve a portion of VRAM per GPU (bytes)"},
+		"OLLAMA_IDEMPOTENCY_TTL":     {"OLLAMA_IDEMPOTENCY_TTL", IdempotencyTTL(), "How long to keep responses to requests with an Idempotency-Key header (default \"1h\")"},
+		"OLLAMA_HOST":                {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
+		"OLLAMA_KEEP_ALIVE":          {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
+		"OLLAMA_LLM_LIBRARY":         {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
+		"OLLAMA_LOAD_TIMEOUT":        {"OLLAMA_LOAD_TIMEOUT", LoadTimeout(), "How long to allow model loads to stall before giving up (default \"5m\")"},
+		"OLLAMA_MAX_DOWNLOAD_RATE":   {"OLLAMA_MAX_DOWNLOAD_RATE", MaxDownloadRate(), "Maximum bandwidth used to pull models per second, e.g. 10MB (default: unlimited)"},
+		"OLLAMA_MAX_GGUF_ARRAY":      {"OLLAMA_MAX_GGUF_ARRAY", MaxGGUFArray(), "Maximum number of elements of an array in a model file (default: 16777216)"},
+		"OLLAMA_MAX_GGUF_KV":         {"OLLAMA_MAX_GGUF_KV", MaxGGUFKV(), "Maximum number of key-values in a model file (default: 65536)"},
+		"OLLAMA_MAX_GGUF_METADATA":   {"OLLAMA_MAX_GGUF_METADATA", MaxGGUFMetadata(), "Maximum memory used by the strings and arrays of a model file (default: 1GiB)"},
+		"OLLAMA_MAX_GGUF_STRING":     {"OLLAMA_MAX_GGUF_STRING", MaxGGUFString(), "Maximum length of a string in a model file (default: 32MiB)"},
+		"OLLAMA_MAX_GGUF_TENSORS":    {"OLLAMA_MAX_GGUF_TENSORS", MaxGGUFTensors(), "Maximum number of tensors in a model file (default: 65536)"},
+		"OLLAMA_MAX_IMAGES":          {"OLLAMA_MAX_IMAGES", MaxImages(), "Maximum number of images in a request (default: unlimited)"},
+		"OLLAMA_MAX_LOADED_MODELS":   {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
+		"OLLAMA_MAX_LOADED_LORAS":    {"OLLAMA_MAX_LOADED_LORAS", MaxAdapters(), "Maximum number of LoRA adapters loaded with each model (default: 8)"},
+		"OLLAMA_MAX_NUM_CTX":         {"OLLAMA_MAX_NUM_CTX", MaxNumCtx(), "Maximum context length a request may use (default: unlimited)"},
+		"OLLAMA_MAX_NUM_PREDICT":     {"OLLAMA_MAX_NUM_PREDICT", MaxNumPredict(), "Maximum number of tokens generated for a request (default: unlimited)"},
+		"OLLAMA_MAX_QUEUE":           {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
+		"OLLAMA_MAX_REQUEST_SIZE":    {"OLLAMA_MAX_REQUEST_SIZE", MaxRequestSize(), "Maximum size of the body of an inference request, e.g. 20MB (default: unlimited)"},
+		"OLLAMA_MAX_UPLOAD_RATE":     {"OLLAMA_MAX_UPLOAD_RATE", MaxUploadRate(), "Maximum bandwidth used to push models per second, e.g. 10MB (default: unlimited)"},
+		"OLLAMA_MODERATION_MODEL":    {"OLLAMA_MODERATION_MODEL", ModerationModel(), "A safety classifier model, e.g. llama-guard3, which screens requests and responses"},
+		"OLLAMA_MODERATION_POLICY":   {"OLLAMA_MODERATION_POLICY", ModerationPolicy(), "What to do with flagged content: block, annotate or log (default: block)"},
+		"OLLAMA_MODELS":              {"OLLAMA_MODELS", Models(), "The path to the models directory"},
+		"OLLAMA_NAMESPACE_QUOTAS":    {"OLLAMA_NAMESPACE_QUOTAS", NamespaceQuotas(), "Disk quotas for the models in each namespace, e.g. team-a=100GB,*=20GB"},
+		"OLLAMA_NOHISTORY":           {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
+		"OLLAMA_NOPRUNE":             {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
+		"OLLAMA_NUM_PARALLEL":        {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
+		"OLLAMA_ORIGINS":             {"OLLAMA_ORIGINS", Origins(), "A comma separated list of allowed origins, each with the scopes it may call after ="},
+		"OLLAMA_ORIGINS_MAX_AGE":     {"OLLAMA_ORIGINS_MAX_AGE", OriginsMaxAge(), "How long browsers may cache the origins allowed to call an endpoint (default: 12h)"},
+		"OLLAMA_PEERS":               {"OLLAMA_PEERS", Peers(), "A comma separated list of Ollama servers to fetch model blobs from before the registry"},
+		"OLLAMA_PLUGINS":             {"OLLAMA_PLUGINS", Plugins(), "Path of a JSON file of plugins which see and change requests and responses"},
+		"OLLAMA_PRUNE_INTERVAL":      {"OLLAMA_PRUNE_INTERVAL", PruneInterval(), "How often to prune models from the model store (default: never)"},
+		"OLLAMA_PRUNE_UNUSED_FOR":    {"OLLAMA_PRUNE_UNUSED_FOR", PruneUnusedFor(), "Prune models which haven't been used for this long, e.g. 720h"},
+		"OLLAMA_PRUNE_KEEP_TAGS":     {"OLLAMA_PRUNE_KEEP_TAGS", PruneKeepTags(), "Prune all but this many of the most recently used tags of each model"},
+		"OLLAMA_PRUNE_MAX_SIZE":      {"OLLAMA_PRUNE_MAX_SIZE", PruneMaxSize(), "Prune the least recently used models until the model store fits in this size, e.g. 100GB"},
+		"OLLAMA_RUNNER_APPARMOR":     {"OLLAMA_RUNNER_APPARMOR", RunnerAppArmor(), "AppArmor profile which sandboxed runners change to"},
+		"OLLAMA_RUNNER_PATHS":        {"OLLAMA_RUNNER_PATHS", RunnerPaths(), "Additional paths sandboxed runners may read, separated like PATH"},
+		"OLLAMA_RUNNER_SANDBOX":      {"OLLAMA_RUNNER_SANDBOX", RunnerSandbox(), "Confine runners to their model files, without network access"},
+		"OLLAMA_RESPONSE_CACHE_SIZE": {"OLLAMA_RESPONSE_CACHE_SIZE", ResponseCacheSize(), "Maximum memory used by kept responses (default: 64MB)"},
+		"OLLAMA_RESPONSE_CACHE_TTL":  {"OLLAMA_RESPONSE_CACHE_TTL", ResponseCacheTTL(), "How long to return cached responses to identical deterministic requests (default: disabled)"},
+		"OLLAMA_SEMANTIC_CACHE":      {"OLLAMA_SEMANTIC_CACHE", SemanticCache(), "An embedding model to return cached responses to similar prompts with"},
+		"OLLAMA_SEMANTIC_THRESHOLD":  {"OLLAMA_SEMANTIC_THRESHOLD", SemanticThreshold(), "How similar prompts must be to share a cached response, from 0 to 1 (default: 0.95)"},
+		"OLLAMA_SERVE_BLOBS":         {"OLLAMA_SERVE_BLOBS", ServeBlobs(), "Serve model blobs to other Ollama servers which list this one in OLLAMA_PEERS"},
+		"OLLAMA_READY_MODELS":        {"OLLAMA_READY_MODELS", ReadyModels(), "A comma separated list of models which must be loaded for /readyz to report ready"},
+		"OLLAMA_REGISTRIES":          {"OLLAMA_REGISTRIES", Registries(), "Path of a JSON file of settings and mirrors for each registry"},
+		"OLLAMA_SCHED_SPREAD":        {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
+		"OLLAMA_SYSTEM_PREAMBLE":     {"OLLAMA_SYSTEM_PREAMBLE", SystemPreamble(), "Text prepended to the system prompt of every request"},
+		"OLLAMA_SYSTEM_POSTAMBLE":    {"OLLAMA_SYSTEM_POSTAMBLE", SystemPostamble(), "Text appended to the system prompt of every request"},
+		"OLLAMA_SYSTEM_POLICY":       {"OLLAMA_SYSTEM_POLICY", SystemPolicy(), "Path of a JSON file of per-model system prompt preambles and postambles"},
+		"OLLAMA_WEBHOOK_EVENTS":      {"OLLAMA_WEBHOOK_EVENTS", WebhookEvents(), "Events to send webhooks for, e.g. model.pulled,runner.crashed or * (default: all but request.completed)"},
+		"OLLAMA_UPLOAD_CONCURRENCY":  {"OLLAMA_UPLOAD_CONCURRENCY", UploadConcurrency(), "Maximum number of parts of a blob to push at the same time (default: 16)"},
+		"OLLAMA_MULTIUSER_CACHE":     {"OLLAMA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
+		"OLLAMA_CONTEXT_LENGTH":      {"OLLAMA_CONTEXT_LENGTH", ContextLength(), "Context length to use unless otherwise specified (default: 2048)"},
+		"OLLAMA_NEW_ENGINE":          {"OLLAMA_NEW_ENGINE", NewEngine(), "Enable the new Ollama engine"},
 
 		// Informational
+		"HF_ENDPOINT": {"HF_ENDPOINT", HFEndpoint(), "Hugging Face Hub to convert remote models from (default: https://huggingface.co)"},
 		"HTTP_PROXY":  {"HTTP_PROXY", String("HTTP_PROXY")(), "HTTP proxy"},
 		"HTTPS_PROXY": {"HTTPS_PROXY", String("HTTPS_PROXY")(), "HTTPS proxy"},
 		"NO_PROXY":    {"NO_PROXY", String("NO_PROXY")(), "No proxy"},
@@ -278,6 +728,8 @@ func AsMap() map[string]EnvVar {
 		ret["GPU_DEVICE_ORDINAL"] = EnvVar{"GPU_DEVICE_ORDINAL", GpuDeviceOrdinal(), "Set which AMD devices are visible by numeric ID"}
 		ret["HSA_OVERRIDE_GFX_VERSION"] = EnvVar{"HSA_OVERRIDE_GFX_VERSION", HsaOverrideGfxVersion(), "Override the gfx used for all detected AMD GPUs"}
 		ret["OLLAMA_INTEL_GPU"] = EnvVar{"OLLAMA_INTEL_GPU", IntelGPU(), "Enable experimental Intel GPU detection"}
+		ret["OLLAMA_VULKAN"] = EnvVar{"OLLAMA_VULKAN", Vulkan(), "Enable experimental Vulkan GPU detection"}
+		ret["GGML_VK_VISIBLE_DEVICES"] = EnvVar{"GGML_VK_VISIBLE_DEVICES", VkVisibleDevices(), "Set which Vulkan devices are visible by numeric ID"}
 	}
 
 	return ret
@@ -292,6 +744,16 @@ func Values() map[string]string {
 }
 
 // Var returns an environment variable stripped of leading and trailing quotes or spaces
+// Var returns the value of the environment variable key, or of the first of its deprecated aliases which is set if
+// it isn't.
 func Var(key string) string {
-	return strings.Trim(strings.TrimSpace(os.Getenv(key)), "\"'")
+	s := os.Getenv(key)
+	for _, alias := range specs[key].Aliases {
+		if s != "" {
+			break
+		}
+		s = os.Getenv(alias)
+	}
+
+	return strings.Trim(strings.TrimSpace(s), "\"'")
 }
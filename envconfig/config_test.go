@@ -145,6 +145,59 @@ func TestOrigins(t *testing.T) {
 	}
 }
 
+func TestOriginScopes(t *testing.T) {
+	cases := map[string][]string{
+		"https://chat.example.com":                             {"https://chat.example.com=inference+read"},
+		"https://admin.example.com=all, https://*.example.com": {"https://admin.example.com=inference+read+manage", "https://*.example.com=inference+read"},
+		"https://dash.example.com=READ+bogus":                  {"https://dash.example.com=read"},
+		"*=inference,example.com,https://*.*.example.com":      {"*=inference"},
+	}
+
+	for value, expect := range cases {
+		t.Run(value, func(t *testing.T) {
+			t.Setenv("OLLAMA_ORIGINS", value)
+
+			var actual []string
+			for _, origin := range Origins() {
+				actual = append(actual, origin.String())
+			}
+
+			// the local origins follow those which are configured
+			if diff := cmp.Diff(expect, actual[:len(expect)]); diff != "" {
+				t.Errorf("%s: mismatch (-want +got):\n%s", value, diff)
+			}
+
+			if actual[len(expect)] != "http://localhost=inference+read" {
+				t.Errorf("%s: expected the local origins next, got %s", value, actual[len(expect)])
+			}
+		})
+	}
+}
+
+func TestPeers(t *testing.T) {
+	cases := map[string][]string{
+		"":                                  nil,
+		"10.0.0.2":                          {"http://10.0.0.2:11434"},
+		"10.0.0.2, lab-server:8080":         {"http://10.0.0.2:11434", "http://lab-server:8080"},
+		"https://peer.local,,http://[::1]/": {"https://peer.local:443", "http://[::1]:80"},
+	}
+
+	for value, expect := range cases {
+		t.Run(value, func(t *testing.T) {
+			t.Setenv("OLLAMA_PEERS", value)
+
+			var actual []string
+			for _, peer := range Peers() {
+				actual = append(actual, peer.String())
+			}
+
+			if diff := cmp.Diff(expect, actual); diff != "" {
+				t.Errorf("%s: mismatch (-want +got):\n%s", value, diff)
+			}
+		})
+	}
+}
+
 func TestBool(t *testing.T) {
 	cases := map[string]bool{
 		"":      false,
@@ -190,6 +243,29 @@ func TestUint(t *testing.T) {
 	}
 }
 
+func TestBytes(t *testing.T) {
+	cases := map[string]int64{
+		"0":      0,
+		"1337":   1337,
+		"10MB":   10_000_000,
+		"1.5KiB": 1536,
+		// default values
+		"":       11434,
+		"-1":     11434,
+		"string": 11434,
+		"10XB":   11434,
+	}
+
+	for k, v := range cases {
+		t.Run(k, func(t *testing.T) {
+			t.Setenv("OLLAMA_BYTES", k)
+			if i := Bytes("OLLAMA_BYTES", 11434)(); i != v {
+				t.Errorf("%s: expected %d, got %d", k, v, i)
+			}
+		})
+	}
+}
+
 func TestKeepAlive(t *testing.T) {
 	cases := map[string]time.Duration{
 		"":       5 * time.Minute,
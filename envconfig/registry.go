@@ -0,0 +1,313 @@
+package envconfig
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/format"
+)
+
+// Kind is how the value of a variable is parsed.
+type Kind string
+
+const (
+	KindString   Kind = "string"
+	KindBool     Kind = "bool"
+	KindUint     Kind = "uint"
+	KindFloat    Kind = "float"
+	KindDuration Kind = "duration"
+	KindBytes    Kind = "bytes"
+)
+
+// Spec declares an environment variable: how its value is parsed and checked, and the names it used to have.
+type Spec struct {
+	Name string
+	Kind Kind
+
+	// Min and Max bound the values of numeric variables, when Max is more than Min.
+	Min, Max float64
+
+	// Values are the values the variable may have, ignoring case, or any value when empty.
+	Values []string
+
+	// Secret variables are masked wherever the configuration is shown.
+	Secret bool
+
+	// Reloadable variables take effect without restarting the server, so they can be changed with Set.
+	Reloadable bool
+
+	// Aliases are names the variable had before, which are read if it isn't set, with a warning that they're
+	// deprecated.
+	Aliases []string
+
+	// Removed is why a variable which no longer has any effect was removed.
+	Removed string
+}
+
+// specs are the environment variables Ollama reads.
+var specs = func() map[string]Spec {
+	m := make(map[string]Spec)
+	for _, spec := range []Spec{
+		{Name: "OLLAMA_AGENT_TOOLS", Kind: KindString},
+		{Name: "OLLAMA_API_KEY", Kind: KindString, Secret: true},
+		{Name: "OLLAMA_API_KEYS", Kind: KindString, Secret: true},
+		{Name: "OLLAMA_BLOB_CACHE_SIZE", Kind: KindBytes},
+		{Name: "OLLAMA_BLOB_STORE", Kind: KindString},
+		{Name: "OLLAMA_CAPTURE", Kind: KindString},
+		{Name: "OLLAMA_CAPTURE_MAX", Kind: KindUint, Min: 1, Max: math32},
+		{Name: "OLLAMA_CAPTURE_REDACT", Kind: KindString},
+		{Name: "OLLAMA_CA_BUNDLE", Kind: KindString},
+		{Name: "OLLAMA_CONFIG", Kind: KindString},
+		{Name: "OLLAMA_CONTEXT_LENGTH", Kind: KindUint, Min: 1, Max: math32},
+		{Name: "OLLAMA_DEBUG", Kind: KindBool, Reloadable: true},
+		{Name: "OLLAMA_DOWNLOAD_SCHEDULE", Kind: KindString},
+		{Name: "OLLAMA_DRAIN_TIMEOUT", Kind: KindDuration},
+		{Name: "OLLAMA_EXPERIMENT", Kind: KindString},
+		{Name: "OLLAMA_FLASH_ATTENTION", Kind: KindBool},
+		{Name: "OLLAMA_GENERATE_TIMEOUT", Kind: KindDuration},
+		{Name: "OLLAMA_GPU_OVERHEAD", Kind: KindUint},
+		{Name: "OLLAMA_HOST", Kind: KindString},
+		{Name: "OLLAMA_IDEMPOTENCY_TTL", Kind: KindDuration},
+		{Name: "OLLAMA_INTEL_GPU", Kind: KindBool},
+		{Name: "OLLAMA_KEEP_ALIVE", Kind: KindDuration, Reloadable: true},
+		{Name: "OLLAMA_KV_CACHE_TYPE", Kind: KindString, Values: []string{"f16", "q8_0", "q4_0"}},
+		{Name: "OLLAMA_LLM_LIBRARY", Kind: KindString},
+		{Name: "OLLAMA_LOAD_TIMEOUT", Kind: KindDuration},
+		{Name: "OLLAMA_MAX_DOWNLOAD_RATE", Kind: KindBytes},
+		{Name: "OLLAMA_MAX_GGUF_ARRAY", Kind: KindUint, Min: 1, Max: 1 << 32},
+		{Name: "OLLAMA_MAX_GGUF_KV", Kind: KindUint, Min: 1, Max: 1 << 32},
+		{Name: "OLLAMA_MAX_GGUF_METADATA", Kind: KindBytes},
+		{Name: "OLLAMA_MAX_GGUF_STRING", Kind: KindBytes},
+		{Name: "OLLAMA_MAX_GGUF_TENSORS", Kind: KindUint, Min: 1, Max: 1 << 32},
+		{Name: "OLLAMA_MAX_IMAGES", Kind: KindUint},
+		{Name: "OLLAMA_MAX_LOADED_LORAS", Kind: KindUint},
+		{Name: "OLLAMA_MAX_LOADED_MODELS", Kind: KindUint, Reloadable: true},
+		{Name: "OLLAMA_MAX_NUM_CTX", Kind: KindUint},
+		{Name: "OLLAMA_MAX_NUM_PREDICT", Kind: KindUint},
+		{Name: "OLLAMA_MAX_QUEUE", Kind: KindUint},
+		{Name: "OLLAMA_MAX_REQUEST_SIZE", Kind: KindBytes},
+		{Name: "OLLAMA_MAX_UPLOAD_RATE", Kind: KindBytes},
+		{Name: "OLLAMA_MAX_VRAM", Kind: KindUint, Removed: "the memory of each GPU is detected"},
+		{Name: "OLLAMA_MODELS", Kind: KindString},
+		{Name: "OLLAMA_MODERATION_MODEL", Kind: KindString, Reloadable: true},
+		{Name: "OLLAMA_MODERATION_POLICY", Kind: KindString, Values: []string{"block", "annotate", "log"}, Reloadable: true},
+		{Name: "OLLAMA_MULTIUSER_CACHE", Kind: KindBool},
+		{Name: "OLLAMA_NAMESPACE_QUOTAS", Kind: KindString},
+		{Name: "OLLAMA_NEW_ENGINE", Kind: KindBool},
+		{Name: "OLLAMA_NOHISTORY", Kind: KindBool},
+		{Name: "OLLAMA_NOPRUNE", Kind: KindBool},
+		{Name: "OLLAMA_NUM_PARALLEL", Kind: KindUint},
+		{Name: "OLLAMA_ORIGINS", Kind: KindString, Reloadable: true},
+		{Name: "OLLAMA_ORIGINS_MAX_AGE", Kind: KindDuration, Reloadable: true},
+		{Name: "OLLAMA_PEERS", Kind: KindString},
+		{Name: "OLLAMA_PLUGINS", Kind: KindString},
+		{Name: "OLLAMA_PRUNE_INTERVAL", Kind: KindDuration},
+		{Name: "OLLAMA_PRUNE_KEEP_TAGS", Kind: KindUint},
+		{Name: "OLLAMA_PRUNE_MAX_SIZE", Kind: KindBytes},
+		{Name: "OLLAMA_PRUNE_UNUSED_FOR", Kind: KindDuration},
+		{Name: "OLLAMA_READY_MODELS", Kind: KindString},
+		{Name: "OLLAMA_REGISTRIES", Kind: KindString},
+		{Name: "OLLAMA_REGISTRY_MAXSTREAMS", Kind: KindUint},
+		{Name: "OLLAMA_RESPONSE_CACHE_SIZE", Kind: KindBytes},
+		{Name: "OLLAMA_RESPONSE_CACHE_TTL", Kind: KindDuration},
+		{Name: "OLLAMA_RUNNERS_DIR", Kind: KindString, Removed: "runners are found next to the ollama executable"},
+		{Name: "OLLAMA_RUNNER_APPARMOR", Kind: KindString},
+		{Name: "OLLAMA_RUNNER_PATHS", Kind: KindString},
+		{Name: "OLLAMA_RUNNER_SANDBOX", Kind: KindBool},
+		{Name: "OLLAMA_SCHED_SPREAD", Kind: KindBool},
+		{Name: "OLLAMA_SEMANTIC_CACHE", Kind: KindString},
+		{Name: "OLLAMA_SEMANTIC_THRESHOLD", Kind: KindFloat, Min: 0, Max: 1},
+		{Name: "OLLAMA_SERVE_BLOBS", Kind: KindBool},
+		{Name: "OLLAMA_SYSTEM_POLICY", Kind: KindString},
+		{Name: "OLLAMA_SYSTEM_POSTAMBLE", Kind: KindString},
+		{Name: "OLLAMA_SYSTEM_PREAMBLE", Kind: KindString},
+		{Name: "OLLAMA_TMPDIR", Kind: KindString, Removed: "runners are no longer extracted to a temporary directory"},
+		{Name: "OLLAMA_UPLOAD_CONCURRENCY", Kind: KindUint, Min: 1, Max: 256},
+		{Name: "OLLAMA_VULKAN", Kind: KindBool},
+		{Name: "OLLAMA_WEBHOOKS", Kind: KindString},
+		{Name: "OLLAMA_WEBHOOK_EVENTS", Kind: KindString},
+		{Name: "OLLAMA_WEBHOOK_SECRET", Kind: KindString, Secret: true},
+
+		{Name: "HF_ENDPOINT", Kind: KindString},
+		{Name: "HF_TOKEN", Kind: KindString, Secret: true, Aliases: []string{"HUGGING_FACE_HUB_TOKEN"}},
+	} {
+		m[spec.Name] = spec
+	}
+
+	return m
+}()
+
+// math32 is the largest value of variables which are converted to 32-bit integers.
+const math32 = 1<<31 - 1
+
+// Specs returns the declared environment variables, sorted by name.
+func Specs() []Spec {
+	return slices.SortedFunc(maps.Values(specs), func(a, b Spec) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// Check checks s, a value of the variable declared by spec.
+func (spec Spec) Check(s string) error {
+	var n float64
+	switch spec.Kind {
+	case KindBool:
+		if _, err := strconv.ParseBool(s); err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		return nil
+	case KindUint:
+		u, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q, expected a whole number of at least 0", s)
+		}
+		n = float64(u)
+	case KindFloat:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		n = f
+	case KindDuration:
+		if _, err := time.ParseDuration(s); err != nil {
+			if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+				return fmt.Errorf("invalid duration %q", s)
+			}
+		}
+		return nil
+	case KindBytes:
+		b, err := format.ParseBytes(s)
+		if err != nil {
+			return fmt.Errorf("invalid size %q", s)
+		}
+		n = float64(b)
+	default:
+		if len(spec.Values) > 0 && !slices.Contains(spec.Values, strings.ToLower(s)) {
+			return fmt.Errorf("invalid value %q, expected one of %s", s, strings.Join(spec.Values, ", "))
+		}
+		return nil
+	}
+
+	return spec.checkRange(n)
+}
+
+func (spec Spec) checkRange(n float64) error {
+	if spec.Max > spec.Min && (n < spec.Min || n > spec.Max) {
+		return fmt.Errorf("%v is out of range, expected %v to %v", n, spec.Min, spec.Max)
+	}
+	return nil
+}
+
+// checkRange reports an error for values of key outside the range it's declared with.
+func checkRange(key string, n float64) error {
+	return specs[key].checkRange(n)
+}
+
+// Check returns warnings about the environment: variables starting with OLLAMA_ which aren't known, values which
+// are invalid, and variables which are deprecated or no longer have any effect.
+func Check() []string {
+	aliases := make(map[string]string)
+	for _, spec := range specs {
+		for _, alias := range spec.Aliases {
+			aliases[alias] = spec.Name
+		}
+	}
+
+	var warnings []string
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		value = strings.Trim(strings.TrimSpace(value), "\"'")
+
+		spec, known := specs[key]
+		switch {
+		case aliases[key] != "":
+			warnings = append(warnings, fmt.Sprintf("%s is deprecated, use %s instead", key, aliases[key]))
+		case known && spec.Removed != "":
+			warnings = append(warnings, fmt.Sprintf("%s no longer has any effect: %s", key, spec.Removed))
+		case known && value != "":
+			if err := spec.Check(value); err != nil {
+				warnings = append(warnings, fmt.Sprintf("%s: %v", key, err))
+			}
+		case !known && strings.HasPrefix(key, "OLLAMA_"):
+			warning := "unknown variable " + key
+			if name := closestName(key); name != "" {
+				warning += fmt.Sprintf(", did you mean %s?", name)
+			}
+			warnings = append(warnings, warning)
+		}
+	}
+
+	slices.Sort(warnings)
+	return warnings
+}
+
+// closestName returns the declared variable which key is most likely a misspelling of, if any.
+func closestName(key string) string {
+	var closest string
+	best := 3
+	for name := range specs {
+		if d := editDistance(key, name); d < best || d == best && name < closest {
+			closest, best = name, d
+		}
+	}
+
+	if best >= 3 {
+		return ""
+	}
+	return closest
+}
+
+// editDistance returns the number of characters which must be inserted, deleted or changed to turn a into b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
+
+// masked replaces the values of secrets.
+const masked = "********"
+
+// Effective returns the value of each variable the server uses, with defaults for those which aren't set, and with
+// secrets and the passwords in URLs masked.
+func Effective() map[string]string {
+	vals := Values()
+	for _, spec := range specs {
+		if spec.Secret {
+			if Var(spec.Name) != "" {
+				vals[spec.Name] = masked
+			} else {
+				delete(vals, spec.Name)
+			}
+		}
+	}
+
+	for key, value := range vals {
+		if u, err := url.Parse(value); err == nil && u.User != nil {
+			vals[key] = u.Redacted()
+		}
+	}
+
+	return vals
+}
@@ -0,0 +1,141 @@
+package envconfig
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ConfigFile returns the path of a file of KEY=value lines which set environment variables that can be changed while
+// the server is running. The server reads it when it starts and again on SIGHUP. ConfigFile can be configured via
+// the OLLAMA_CONFIG environment variable.
+var ConfigFile = String("OLLAMA_CONFIG")
+
+// Reloadable returns the names of the variables which can be changed with Set while the server is running.
+func Reloadable() (keys []string) {
+	for _, spec := range Specs() {
+		if spec.Reloadable {
+			keys = append(keys, spec.Name)
+		}
+	}
+
+	return keys
+}
+
+var (
+	overridesMu sync.Mutex
+	// overrides are the values variables had before Set changed them, nil for variables which weren't set
+	overrides = make(map[string]*string)
+)
+
+// Set changes variables to vals while the server is running. An empty value restores a variable to what it was
+// before Set first changed it. Set changes nothing if any of vals can't be changed or is invalid.
+func Set(vals map[string]string) error {
+	overridesMu.Lock()
+	defer overridesMu.Unlock()
+
+	return set(vals)
+}
+
+func set(vals map[string]string) error {
+	var errs []error
+	for _, key := range slices.Sorted(maps.Keys(vals)) {
+		spec, ok := specs[key]
+		if !ok || !spec.Reloadable {
+			errs = append(errs, fmt.Errorf("%s can't be changed without restarting", key))
+		} else if s := strings.Trim(strings.TrimSpace(vals[key]), "\"'"); s != "" {
+			if err := spec.Check(s); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			}
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	for key, value := range vals {
+		if _, ok := overrides[key]; !ok {
+			if s, ok := os.LookupEnv(key); ok {
+				overrides[key] = &s
+			} else {
+				overrides[key] = nil
+			}
+		}
+
+		if value != "" {
+			os.Setenv(key, value)
+			continue
+		}
+
+		if original := overrides[key]; original != nil {
+			os.Setenv(key, *original)
+		} else {
+			os.Unsetenv(key)
+		}
+		delete(overrides, key)
+	}
+
+	return nil
+}
+
+// Reload sets the variables in ConfigFile and restores those which were changed but no longer are in it.
+func Reload() error {
+	path := ConfigFile()
+	if path == "" {
+		return nil
+	}
+
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	vals, err := parseConfig(bts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	overridesMu.Lock()
+	defer overridesMu.Unlock()
+
+	for key := range overrides {
+		if _, ok := vals[key]; !ok {
+			vals[key] = ""
+		}
+	}
+
+	if err := set(vals); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+// parseConfig parses KEY=value lines, skipping blank lines and those starting with #.
+func parseConfig(bts []byte) (map[string]string, error) {
+	vals := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(bts))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=value", n)
+		}
+
+		vals[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	return vals, scanner.Err()
+}
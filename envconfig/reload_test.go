@@ -0,0 +1,93 @@
+package envconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSet(t *testing.T) {
+	t.Setenv("OLLAMA_KEEP_ALIVE", "10m")
+	t.Setenv("OLLAMA_DEBUG", "")
+	os.Unsetenv("OLLAMA_DEBUG")
+	t.Cleanup(func() { clear(overrides) })
+
+	if err := Set(map[string]string{"OLLAMA_KEEP_ALIVE": "1h", "OLLAMA_DEBUG": "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if KeepAlive() != time.Hour || !Debug() {
+		t.Errorf("expected the variables to change, got %v %v", KeepAlive(), Debug())
+	}
+
+	for name, vals := range map[string]map[string]string{
+		"not reloadable": {"OLLAMA_MODELS": "/tmp"},
+		"invalid":        {"OLLAMA_KEEP_ALIVE": "2m", "OLLAMA_MAX_LOADED_MODELS": "many"},
+	} {
+		if err := Set(vals); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if KeepAlive() != time.Hour {
+		t.Errorf("expected an invalid change to change nothing, got %v", KeepAlive())
+	}
+
+	if err := Set(map[string]string{"OLLAMA_KEEP_ALIVE": "", "OLLAMA_DEBUG": ""}); err != nil {
+		t.Fatal(err)
+	}
+
+	if KeepAlive() != 10*time.Minute {
+		t.Errorf("expected the keep alive to be restored, got %v", KeepAlive())
+	}
+
+	if _, ok := os.LookupEnv("OLLAMA_DEBUG"); ok {
+		t.Error("expected OLLAMA_DEBUG to be unset again")
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ollama.env")
+	t.Setenv("OLLAMA_CONFIG", path)
+	t.Setenv("OLLAMA_MAX_LOADED_MODELS", "2")
+	t.Setenv("OLLAMA_KEEP_ALIVE", "5m")
+	t.Cleanup(func() { clear(overrides) })
+
+	write := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("# settings\nOLLAMA_MAX_LOADED_MODELS = 4\n\nOLLAMA_KEEP_ALIVE=\"30m\"\n")
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if MaxRunners() != 4 || KeepAlive() != 30*time.Minute {
+		t.Errorf("expected the config to be applied, got %d %v", MaxRunners(), KeepAlive())
+	}
+
+	// settings removed from the file are restored
+	write("OLLAMA_KEEP_ALIVE=1h\n")
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if MaxRunners() != 2 || KeepAlive() != time.Hour {
+		t.Errorf("expected the removed setting to be restored, got %d %v", MaxRunners(), KeepAlive())
+	}
+
+	for _, s := range []string{"OLLAMA_KEEP_ALIVE", "OLLAMA_HOST=0.0.0.0", "OLLAMA_DEBUG=maybe"} {
+		write(s)
+		if err := Reload(); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+
+	if KeepAlive() != time.Hour {
+		t.Errorf("expected an invalid config to change nothing, got %v", KeepAlive())
+	}
+}
@@ -0,0 +1,406 @@
+// Package eval runs suites of questions against a model and scores its
+// answers, by matching them with the expected answers or by asking another
+// model to judge them. Results are cached per question, so running a suite
+// again only asks the questions whose model, judge or wording changed.
+package eval
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+//go:embed suites/*.jsonl
+var builtin embed.FS
+
+// Scoring is how the answers to a question are scored.
+type Scoring string
+
+const (
+	// ScoringChoice matches the letter of the chosen choice.
+	ScoringChoice Scoring = "choice"
+
+	// ScoringNumber matches the last number in the answer.
+	ScoringNumber Scoring = "number"
+
+	// ScoringExact matches the whole answer, ignoring case, spaces and
+	// trailing punctuation.
+	ScoringExact Scoring = "exact"
+
+	// ScoringJudge asks the judge model whether the answer agrees with
+	// the expected one.
+	ScoringJudge Scoring = "judge"
+)
+
+// Question is a line of a suite file.
+type Question struct {
+	ID       string   `json:"id"`
+	Question string   `json:"question"`
+	Choices  []string `json:"choices,omitempty"`
+	Answer   string   `json:"answer"`
+
+	// Scoring defaults to ScoringChoice for questions with choices,
+	// ScoringNumber for those whose answer is a number and ScoringExact
+	// otherwise.
+	Scoring Scoring `json:"scoring,omitempty"`
+}
+
+// Suite is a named set of questions.
+type Suite struct {
+	Name      string
+	Questions []Question
+}
+
+// Suites returns the names of the built-in suites.
+func Suites() []string {
+	entries, _ := builtin.ReadDir("suites")
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".jsonl"))
+	}
+	return names
+}
+
+// LoadSuite loads the built-in suite name, or the suite in the JSON lines
+// file name if it's a path to one.
+func LoadSuite(name string) (*Suite, error) {
+	var r io.Reader
+	if f, err := builtin.Open("suites/" + name + ".jsonl"); err == nil {
+		defer f.Close()
+		r = f
+	} else if f, err := os.Open(name); err == nil {
+		defer f.Close()
+		r = f
+		name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	} else if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("unknown suite %q, built-in suites are: %s", name, strings.Join(Suites(), ", "))
+	} else {
+		return nil, err
+	}
+
+	suite := Suite{Name: name}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var q Question
+		if err := json.Unmarshal(scanner.Bytes(), &q); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, line, err)
+		}
+
+		if q.Question == "" || q.Answer == "" {
+			return nil, fmt.Errorf("%s:%d: question and answer are required", name, line)
+		}
+
+		if q.ID == "" {
+			q.ID = strconv.Itoa(line)
+		}
+
+		if q.Scoring == "" {
+			q.Scoring = defaultScoring(q)
+		}
+
+		switch q.Scoring {
+		case ScoringChoice:
+			if i := choiceIndex(q.Answer); i < 0 || i >= len(q.Choices) {
+				return nil, fmt.Errorf("%s:%d: answer %q isn't the letter of a choice", name, line, q.Answer)
+			}
+		case ScoringNumber, ScoringExact, ScoringJudge:
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown scoring %q", name, line, q.Scoring)
+		}
+
+		suite.Questions = append(suite.Questions, q)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(suite.Questions) == 0 {
+		return nil, fmt.Errorf("suite %s has no questions", name)
+	}
+
+	return &suite, nil
+}
+
+// Client is the part of [api.Client] used to run suites.
+type Client interface {
+	Chat(context.Context, *api.ChatRequest, api.ChatResponseFunc) error
+	Show(context.Context, *api.ShowRequest) (*api.ShowResponse, error)
+}
+
+// Options are the options of [Run].
+type Options struct {
+	Model string
+
+	// Judge is the model which scores the answers to all questions, if
+	// it's set, rather than matching them.
+	Judge string
+
+	// Limit is the number of questions asked from each suite, or all of
+	// them if it's 0.
+	Limit int
+
+	// CacheDir is where results are kept. Nothing is cached if it's
+	// empty.
+	CacheDir string
+
+	// Progress is called after each question is scored.
+	Progress func(suite string, done, total int)
+}
+
+// options are the options the model and judge are run with, so their
+// answers are the same each time.
+var options = map[string]any{"temperature": 0, "seed": 42}
+
+// Run asks the questions of suites and scores the answers.
+func Run(ctx context.Context, client Client, suites []*Suite, opts Options) (*Report, error) {
+	model, err := fingerprint(ctx, client, opts.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	var judge string
+	if opts.Judge != "" {
+		judge, err = fingerprint(ctx, client, opts.Judge)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, suite := range suites {
+		if judge == "" && slices.ContainsFunc(suite.Questions, func(q Question) bool { return q.Scoring == ScoringJudge }) {
+			return nil, fmt.Errorf("suite %s needs a judge model", suite.Name)
+		}
+	}
+
+	report := Report{Model: opts.Model, Judge: opts.Judge, CreatedAt: time.Now().UTC()}
+	for _, suite := range suites {
+		questions := suite.Questions
+		if opts.Limit > 0 && opts.Limit < len(questions) {
+			questions = questions[:opts.Limit]
+		}
+
+		s := SuiteReport{Name: suite.Name, Results: []Result{}}
+		for i, q := range questions {
+			key := cacheKey(model, judge, q)
+			r, ok := readCache(opts.CacheDir, key)
+			if !ok {
+				r, err = ask(ctx, client, opts, q)
+				if err != nil {
+					return nil, fmt.Errorf("%s %s: %w", suite.Name, q.ID, err)
+				}
+
+				if err := writeCache(opts.CacheDir, key, r); err != nil {
+					return nil, err
+				}
+			}
+
+			s.Results = append(s.Results, *r)
+			if opts.Progress != nil {
+				opts.Progress(suite.Name, i+1, len(questions))
+			}
+		}
+
+		report.add(s)
+	}
+
+	return &report, nil
+}
+
+// fingerprint identifies the weights, template and parameters of model,
+// so cached results aren't used once it changes.
+func fingerprint(ctx context.Context, client Client, model string) (string, error) {
+	resp, err := client.Show(ctx, &api.ShowRequest{Model: model})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(resp.Modelfile))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func ask(ctx context.Context, client Client, opts Options, q Question) (*Result, error) {
+	start := time.Now()
+	response, err := chat(ctx, client, opts.Model, prompt(q))
+	if err != nil {
+		return nil, err
+	}
+
+	r := Result{ID: q.ID, Question: q.Question, Answer: q.Answer, Response: response}
+	switch {
+	case opts.Judge != "" || q.Scoring == ScoringJudge:
+		verdict, err := chat(ctx, client, opts.Judge, judgePrompt(q, response))
+		if err != nil {
+			return nil, fmt.Errorf("judge: %w", err)
+		}
+
+		r.Correct = judged(verdict)
+	case q.Scoring == ScoringChoice:
+		r.Correct = choiceIndex(extractChoice(response, len(q.Choices))) == choiceIndex(q.Answer)
+	case q.Scoring == ScoringNumber:
+		r.Correct = sameNumber(extractNumber(response), q.Answer)
+	default:
+		r.Correct = normalize(response) == normalize(q.Answer)
+	}
+
+	r.Duration = time.Since(start)
+	return &r, nil
+}
+
+func chat(ctx context.Context, client Client, model, prompt string) (string, error) {
+	stream := false
+	req := api.ChatRequest{
+		Model:    model,
+		Messages: []api.Message{{Role: "user", Content: prompt}},
+		Stream:   &stream,
+		Options:  options,
+	}
+
+	var content strings.Builder
+	if err := client.Chat(ctx, &req, func(resp api.ChatResponse) error {
+		content.WriteString(resp.Message.Content)
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(content.String()), nil
+}
+
+func cacheKey(model, judge string, q Question) string {
+	b, _ := json.Marshal(struct {
+		Model    string         `json:"model"`
+		Judge    string         `json:"judge,omitempty"`
+		Question Question       `json:"question"`
+		Options  map[string]any `json:"options"`
+	}{model, judge, q, options})
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func readCache(dir, key string) (*Result, bool) {
+	if dir == "" {
+		return nil, false
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, key+".json"))
+	if err != nil {
+		return nil, false
+	}
+
+	var r Result
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, false
+	}
+
+	r.Cached = true
+	return &r, true
+}
+
+func writeCache(dir, key string, r *Result) error {
+	if dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, key+".json"), b, 0o644)
+}
+
+// DefaultCacheDir is where results are cached unless told otherwise.
+func DefaultCacheDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, ".ollama", "eval"), nil
+}
+
+func defaultScoring(q Question) Scoring {
+	if len(q.Choices) > 0 {
+		return ScoringChoice
+	} else if _, err := strconv.ParseFloat(q.Answer, 64); err == nil {
+		return ScoringNumber
+	}
+	return ScoringExact
+}
+
+// choiceIndex is the index of the choice with letter s, or -1.
+func choiceIndex(s string) int {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if len(s) != 1 || s[0] < 'A' || s[0] > 'Z' {
+		return -1
+	}
+	return int(s[0] - 'A')
+}
+
+// letters are the letters of the choices of a question, which may have
+// at most as many choices.
+var letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+func prompt(q Question) string {
+	var b strings.Builder
+	b.WriteString(q.Question)
+	switch q.Scoring {
+	case ScoringChoice:
+		b.WriteString("\n\n")
+		for i, c := range q.Choices[:min(len(q.Choices), len(letters))] {
+			fmt.Fprintf(&b, "%c. %s\n", letters[i], c)
+		}
+		b.WriteString("\nAnswer with the letter of the correct choice.")
+	case ScoringNumber:
+		b.WriteString("\n\nSolve the problem step by step, then give the final answer as a number on the last line.")
+	case ScoringExact:
+		b.WriteString("\n\nAnswer with only the answer, and nothing else.")
+	}
+	return b.String()
+}
+
+func judgePrompt(q Question, response string) string {
+	var b strings.Builder
+	b.WriteString("You are grading an answer to a question.\n\nQuestion: ")
+	b.WriteString(q.Question)
+	for i, c := range q.Choices[:min(len(q.Choices), len(letters))] {
+		fmt.Fprintf(&b, "\n%c. %s", letters[i], c)
+	}
+	fmt.Fprintf(&b, "\n\nReference answer: %s\n\nAnswer to grade: %s\n\n", q.Answer, response)
+	b.WriteString("Reply with CORRECT if the answer to grade agrees with the reference answer, or INCORRECT if it doesn't. Reply with only one word.")
+	return b.String()
+}
+
+// judged reports whether the judge's verdict is that the answer is correct.
+func judged(verdict string) bool {
+	verdict = strings.ToUpper(verdict)
+	return !strings.Contains(verdict, "INCORRECT") && strings.Contains(verdict, "CORRECT")
+}
@@ -0,0 +1,238 @@
+package eval
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+type fakeClient struct {
+	// answer answers the prompt of a request to model
+	answer func(model, prompt string) string
+
+	// asked counts the requests to each model
+	asked map[string]int
+}
+
+func (c *fakeClient) Chat(_ context.Context, req *api.ChatRequest, fn api.ChatResponseFunc) error {
+	if c.asked == nil {
+		c.asked = make(map[string]int)
+	}
+	c.asked[req.Model]++
+
+	return fn(api.ChatResponse{Message: api.Message{Role: "assistant", Content: c.answer(req.Model, req.Messages[0].Content)}})
+}
+
+func (c *fakeClient) Show(_ context.Context, req *api.ShowRequest) (*api.ShowResponse, error) {
+	return &api.ShowResponse{Modelfile: "FROM " + req.Model}, nil
+}
+
+func TestLoadSuite(t *testing.T) {
+	for _, name := range Suites() {
+		suite, err := LoadSuite(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if len(suite.Questions) < 10 {
+			t.Errorf("%s: expected at least 10 questions, got %d", name, len(suite.Questions))
+		}
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "capitals.jsonl")
+	if err := os.WriteFile(path, []byte(`{"question":"What is the capital of France?","answer":"Paris"}
+
+{"id":"q2","question":"What is 6 times 7?","answer":"42"}
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	suite, err := LoadSuite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Suite{Name: "capitals", Questions: []Question{
+		{ID: "1", Question: "What is the capital of France?", Answer: "Paris", Scoring: ScoringExact},
+		{ID: "q2", Question: "What is 6 times 7?", Answer: "42", Scoring: ScoringNumber},
+	}}
+	if diff := cmp.Diff(suite, want); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	for _, s := range []string{
+		`{"question":"What?"}`,
+		`{"question":"Which?","choices":["a","b"],"answer":"C"}`,
+		`{"question":"What?","answer":"a","scoring":"fuzzy"}`,
+		``,
+	} {
+		if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := LoadSuite(path); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+
+	if _, err := LoadSuite("mmlu-huge"); err == nil || !strings.Contains(err.Error(), "gsm8k-mini") {
+		t.Errorf("expected an error listing the built-in suites, got %v", err)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	choices := map[string]string{
+		"B":                "B",
+		"(C)":              "C",
+		"The answer is D.": "D",
+		"**Answer: A**":    "A",
+		"I think it's Mercury, so the answer is A": "A",
+		"A stack is LIFO, so D. Stack":             "D",
+		"I don't know":                             "",
+		"Option E":                                 "",
+	}
+
+	for s, want := range choices {
+		if got := extractChoice(s, 4); got != want {
+			t.Errorf("%q: expected choice %q, got %q", s, want, got)
+		}
+	}
+
+	numbers := map[string]string{
+		"42":                              "42",
+		"12 - 6 = 6, and 6 + 5 = 11.\n11": "11",
+		"The total is $1,250.":            "1250",
+		"It is -3.5 degrees":              "-3.5",
+		"no idea":                         "",
+	}
+
+	for s, want := range numbers {
+		if got := extractNumber(s); got != want {
+			t.Errorf("%q: expected number %q, got %q", s, want, got)
+		}
+	}
+
+	if !sameNumber("6000.0", "6,000") || sameNumber("", "0") {
+		t.Error("expected numbers to be compared by value")
+	}
+
+	if normalize("  The  Eiffel Tower. ") != normalize("the eiffel tower") {
+		t.Error("expected answers to be normalized")
+	}
+}
+
+func TestRun(t *testing.T) {
+	suites := []*Suite{
+		{Name: "choices", Questions: []Question{
+			{ID: "1", Question: "Pick the first", Choices: []string{"a", "b"}, Answer: "A", Scoring: ScoringChoice},
+			{ID: "2", Question: "Pick the second", Choices: []string{"a", "b"}, Answer: "B", Scoring: ScoringChoice},
+		}},
+		{Name: "math", Questions: []Question{
+			{ID: "1", Question: "What is 2 + 2?", Answer: "4", Scoring: ScoringNumber},
+			{ID: "2", Question: "What is 3 + 3?", Answer: "6", Scoring: ScoringNumber},
+			{ID: "3", Question: "What is 4 + 4?", Answer: "8", Scoring: ScoringNumber},
+		}},
+	}
+
+	client := fakeClient{answer: func(model, prompt string) string {
+		switch {
+		case model == "judge":
+			if strings.Contains(prompt, "Answer to grade: 2 + 2 = 4") {
+				return "CORRECT"
+			}
+			return "INCORRECT"
+		case strings.Contains(prompt, "2 + 2"):
+			return "2 + 2 = 4"
+		case strings.Contains(prompt, "3 + 3"):
+			return "5"
+		case strings.Contains(prompt, "4 + 4"):
+			return "4 + 4 = 8"
+		default:
+			return "The answer is A"
+		}
+	}}
+
+	dir := t.TempDir()
+	opts := Options{Model: "test", CacheDir: dir}
+	report, err := Run(t.Context(), &client, suites, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Correct != 3 || report.Total != 5 || report.Suites[0].Correct != 1 || report.Suites[1].Correct != 2 {
+		t.Errorf("unexpected scores %+v", report)
+	}
+
+	if client.asked["test"] != 5 {
+		t.Errorf("expected 5 questions to be asked, got %d", client.asked["test"])
+	}
+
+	t.Run("cache", func(t *testing.T) {
+		cached, err := Run(t.Context(), &client, suites, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if client.asked["test"] != 5 {
+			t.Errorf("expected cached questions not to be asked again, got %d", client.asked["test"])
+		}
+
+		if !cached.Suites[1].Results[0].Cached || cached.Correct != report.Correct {
+			t.Errorf("expected the cached results, got %+v", cached)
+		}
+	})
+
+	t.Run("judge", func(t *testing.T) {
+		judged, err := Run(t.Context(), &client, suites[1:], Options{Model: "test", Judge: "judge", CacheDir: dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if judged.Correct != 1 || client.asked["judge"] != 3 {
+			t.Errorf("expected the judge to score each answer, got %d correct and %d judged", judged.Correct, client.asked["judge"])
+		}
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		limited, err := Run(t.Context(), &client, suites, Options{Model: "test", Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if limited.Total != 2 {
+			t.Errorf("expected a question from each suite, got %d", limited.Total)
+		}
+	})
+
+	t.Run("judge required", func(t *testing.T) {
+		suite := &Suite{Name: "essays", Questions: []Question{{ID: "1", Question: "Why?", Answer: "Because", Scoring: ScoringJudge}}}
+		if _, err := Run(t.Context(), &client, []*Suite{suite}, Options{Model: "test"}); err == nil {
+			t.Error("expected an error without a judge")
+		}
+	})
+
+	t.Run("markdown", func(t *testing.T) {
+		var b strings.Builder
+		if err := report.WriteMarkdown(&b); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, want := range []string{
+			"| choices | 1 | 2 | 50.0% |",
+			"| **All** | 3 | 5 | 60.0% |",
+			"## Incorrect answers in math",
+			"| 2 | 6 | 5 |",
+		} {
+			if !strings.Contains(b.String(), want) {
+				t.Errorf("expected %q in:\n%s", want, b.String())
+			}
+		}
+	})
+}
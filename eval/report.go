@@ -0,0 +1,120 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Result is the answer to a question and whether it's correct.
+type Result struct {
+	ID       string        `json:"id"`
+	Question string        `json:"question"`
+	Answer   string        `json:"answer"`
+	Response string        `json:"response"`
+	Correct  bool          `json:"correct"`
+	Duration time.Duration `json:"duration"`
+
+	// Cached is set on results which were read from the cache rather
+	// than asked again.
+	Cached bool `json:"cached,omitempty"`
+}
+
+// SuiteReport is the results of a suite.
+type SuiteReport struct {
+	Name     string   `json:"name"`
+	Correct  int      `json:"correct"`
+	Total    int      `json:"total"`
+	Accuracy float64  `json:"accuracy"`
+	Results  []Result `json:"results"`
+}
+
+// Report is the results of [Run].
+type Report struct {
+	Model     string        `json:"model"`
+	Judge     string        `json:"judge,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	Correct   int           `json:"correct"`
+	Total     int           `json:"total"`
+	Accuracy  float64       `json:"accuracy"`
+	Suites    []SuiteReport `json:"suites"`
+}
+
+func (r *Report) add(s SuiteReport) {
+	for _, result := range s.Results {
+		if result.Correct {
+			s.Correct++
+		}
+	}
+
+	s.Total = len(s.Results)
+	s.Accuracy = accuracy(s.Correct, s.Total)
+	r.Suites = append(r.Suites, s)
+
+	r.Correct += s.Correct
+	r.Total += s.Total
+	r.Accuracy = accuracy(r.Correct, r.Total)
+}
+
+func accuracy(correct, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(correct) / float64(total)
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(r)
+}
+
+// WriteMarkdown writes a table of the accuracy of each suite, followed by
+// the questions which were answered incorrectly.
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Evaluation of %s\n\n", r.Model)
+	if r.Judge != "" {
+		fmt.Fprintf(&b, "Answers were scored by %s.\n\n", r.Judge)
+	}
+
+	b.WriteString("| Suite | Correct | Total | Accuracy |\n")
+	b.WriteString("| --- | ---: | ---: | ---: |\n")
+	for _, s := range r.Suites {
+		fmt.Fprintf(&b, "| %s | %d | %d | %.1f%% |\n", s.Name, s.Correct, s.Total, s.Accuracy*100)
+	}
+	fmt.Fprintf(&b, "| **All** | %d | %d | %.1f%% |\n", r.Correct, r.Total, r.Accuracy*100)
+
+	for _, s := range r.Suites {
+		if s.Correct == s.Total {
+			continue
+		}
+
+		fmt.Fprintf(&b, "\n## Incorrect answers in %s\n\n", s.Name)
+		b.WriteString("| ID | Expected | Response |\n")
+		b.WriteString("| --- | --- | --- |\n")
+		for _, result := range s.Results {
+			if !result.Correct {
+				fmt.Fprintf(&b, "| %s | %s | %s |\n", cell(result.ID), cell(result.Answer), cell(result.Response))
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// maxCellLength is the length responses are cut to in markdown reports.
+const maxCellLength = 200
+
+// cell escapes s to fit in a markdown table cell.
+func cell(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > maxCellLength {
+		s = string(r[:maxCellLength]) + "…"
+	}
+	return strings.ReplaceAll(s, "|", `\|`)
+}
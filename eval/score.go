@@ -0,0 +1,65 @@
+package eval
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// answerRegexp finds choices given as e.g. "The answer is (B)"
+	answerRegexp = regexp.MustCompile(`(?i)answer(?:\s+is)?\s*[:：]?\s*\**\(?([A-Z])\b`)
+
+	// letterRegexp finds choices given on their own, e.g. "B." or "(B)"
+	letterRegexp = regexp.MustCompile(`(?:^|[\s(*])([A-Z])(?:$|[\s).:*])`)
+
+	numberRegexp = regexp.MustCompile(`-?\d[\d,]*(?:\.\d+)?`)
+)
+
+// extractChoice returns the letter of the choice in an answer to a
+// question with n choices.
+func extractChoice(s string, n int) string {
+	for _, re := range []*regexp.Regexp{answerRegexp, letterRegexp} {
+		// models which explain their answer usually give it last
+		m := re.FindAllStringSubmatch(s, -1)
+		for i := len(m) - 1; i >= 0; i-- {
+			if choiceIndex(m[i][1]) < n {
+				return m[i][1]
+			}
+		}
+	}
+
+	return ""
+}
+
+// extractNumber returns the last number in an answer, which is the final
+// answer of one that shows its working.
+func extractNumber(s string) string {
+	m := numberRegexp.FindAllString(s, -1)
+	if len(m) == 0 {
+		return ""
+	}
+
+	return strings.ReplaceAll(m[len(m)-1], ",", "")
+}
+
+func sameNumber(a, b string) bool {
+	x, err := strconv.ParseFloat(a, 64)
+	if err != nil {
+		return false
+	}
+
+	y, err := strconv.ParseFloat(strings.ReplaceAll(b, ",", ""), 64)
+	if err != nil {
+		return false
+	}
+
+	return x == y
+}
+
+// normalize ignores the differences between answers which don't change
+// their meaning: case, spaces and trailing punctuation.
+func normalize(s string) string {
+	s = strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	return strings.TrimRight(s, ".!?;:\"' ")
+}
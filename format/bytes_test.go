@@ -89,3 +89,43 @@ func TestHumanBytes2(t *testing.T) {
 		})
 	}
 }
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0", 0},
+		{"1500", 1500},
+		{"1500B", 1500},
+		{"10KB", 10 * KiloByte},
+		{"10 kb", 10 * KiloByte},
+		{"10M", 10 * MegaByte},
+		{"1.5GB", 1500 * MegaByte},
+		{"2TB", 2 * TeraByte},
+		{"4KiB", 4 * KibiByte},
+		{"512MiB", 512 * MebiByte},
+		{" 1 GiB ", GibiByte},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			result, err := ParseBytes(tc.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result != tc.expected {
+				t.Errorf("Expected %d, got %d", tc.expected, result)
+			}
+		})
+	}
+
+	for _, input := range []string{"", "MB", "-1", "1.2.3", "10XB"} {
+		t.Run(input, func(t *testing.T) {
+			if _, err := ParseBytes(input); err == nil {
+				t.Errorf("Expected error for %q", input)
+			}
+		})
+	}
+}
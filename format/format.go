@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
+	"unicode"
 )
 
 const (
 	Thousand = 1000
 	Million  = Thousand * 1000
 	Billion  = Million * 1000
+	Trillion = Billion * 1000
 )
 
 func HumanNumber(b uint64) string {
@@ -32,3 +35,50 @@ func HumanNumber(b uint64) string {
 		return strconv.FormatUint(b, 10)
 	}
 }
+
+// ParseNumber parses a number such as "500M", "8b" or "1.5B", the inverse of
+// [HumanNumber]. A mixture of experts size such as "8x7b" is the total of
+// its experts. Units are case insensitive.
+func ParseNumber(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+
+	multiplier := 1.0
+	if experts, size, ok := strings.Cut(strings.ToLower(s), "x"); ok {
+		n, err := strconv.ParseUint(experts, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", s)
+		}
+
+		multiplier, s = float64(n), size
+	}
+
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+
+	var unit float64
+	switch strings.ToUpper(strings.TrimSpace(s[i:])) {
+	case "":
+		unit = 1
+	case "K":
+		unit = Thousand
+	case "M":
+		unit = Million
+	case "B":
+		unit = Billion
+	case "T":
+		unit = Trillion
+	default:
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+
+	return uint64(math.Round(value * unit * multiplier)), nil
+}
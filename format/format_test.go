@@ -34,3 +34,34 @@ func TestHumanNumber(t *testing.T) {
 		})
 	}
 }
+
+func TestParseNumber(t *testing.T) {
+	cases := map[string]uint64{
+		"999":  999,
+		"1K":   1000,
+		"500M": 500000000,
+		"8b":   8000000000,
+		"1.5B": 1500000000,
+		"2T":   2000000000000,
+		"8x7b": 56000000000,
+	}
+
+	for input, expect := range cases {
+		t.Run(input, func(t *testing.T) {
+			n, err := ParseNumber(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != expect {
+				t.Errorf("expected %d, got %d", expect, n)
+			}
+		})
+	}
+
+	for _, input := range []string{"", "b", "8 bytes", "-1b", "x7b"} {
+		if _, err := ParseNumber(input); err == nil {
+			t.Errorf("%q: expected error", input)
+		}
+	}
+}
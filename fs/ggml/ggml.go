@@ -77,7 +77,11 @@ func (kv KV) EmbeddingHeadCountV() uint64 {
 }
 
 func (kv KV) GQA() uint64 {
-	return kv.HeadCount() / kv.HeadCountKV()
+	if headsKV := kv.HeadCountKV(); headsKV > 0 {
+		return kv.HeadCount() / headsKV
+	}
+
+	return 0
 }
 
 func (kv KV) ContextLength() uint64 {
@@ -88,6 +92,17 @@ func (kv KV) ChatTemplate() string {
 	return kv.String("tokenizer.chat_template")
 }
 
+// MatryoshkaDimensions returns the sizes which embeddings of a model trained
+// with Matryoshka representation learning can be truncated to, or nil if
+// the model doesn't declare them.
+func (kv KV) MatryoshkaDimensions() []uint32 {
+	if _, ok := kv[kv.Architecture()+".embedding.matryoshka_dimensions"]; !ok {
+		return nil
+	}
+
+	return kv.Uints("embedding.matryoshka_dimensions")
+}
+
 func (kv KV) String(key string, defaultValue ...string) string {
 	return keyValue(kv, key, append(defaultValue, "")...)
 }
@@ -106,9 +121,9 @@ func (kv KV) Bool(key string, defaultValue ...bool) bool {
 
 func (kv KV) Strings(key string, defaultValue ...[]string) []string {
 	r := keyValue(kv, key, &array{})
-	s := make([]string, r.size)
-	for i := range r.size {
-		s[i] = r.values[i].(string)
+	s := make([]string, len(r.values))
+	for i := range r.values {
+		s[i], _ = r.values[i].(string)
 	}
 
 	return s
@@ -116,9 +131,14 @@ func (kv KV) Strings(key string, defaultValue ...[]string) []string {
 
 func (kv KV) Uints(key string, defaultValue ...[]uint32) []uint32 {
 	r := keyValue(kv, key, &array{})
-	s := make([]uint32, r.size)
-	for i := range r.size {
-		s[i] = uint32(r.values[i].(int32))
+	s := make([]uint32, len(r.values))
+	for i := range r.values {
+		switch v := r.values[i].(type) {
+		case int32:
+			s[i] = uint32(v)
+		case uint32:
+			s[i] = v
+		}
 	}
 
 	return s
@@ -126,13 +146,28 @@ func (kv KV) Uints(key string, defaultValue ...[]uint32) []uint32 {
 
 func (kv KV) Floats(key string, defaultValue ...[]float32) []float32 {
 	r := keyValue(kv, key, &array{})
-	s := make([]float32, r.size)
-	for i := range r.size {
-		s[i] = float32(r.values[i].(float32))
+	s := make([]float32, len(r.values))
+	for i := range r.values {
+		s[i], _ = r.values[i].(float32)
 	}
 	return s
 }
 
+func (kv KV) Bytes(key string, defaultValue ...[]byte) []byte {
+	r := keyValue(kv, key, &array{})
+	s := make([]byte, len(r.values))
+	for i := range r.values {
+		switch v := r.values[i].(type) {
+		case int8:
+			s[i] = byte(v)
+		case uint8:
+			s[i] = v
+		}
+	}
+
+	return s
+}
+
 func (kv KV) OllamaEngineRequired() bool {
 	return kv.Architecture() == "gemma3"
 }
@@ -143,7 +178,12 @@ func keyValue[T string | uint32 | uint64 | float32 | *array | bool](kv KV, key s
 	}
 
 	if val, ok := kv[key]; ok {
-		return val.(T)
+		if v, ok := val.(T); ok {
+			return v
+		}
+
+		slog.Warn("key has the wrong type", "key", key, "type", fmt.Sprintf("%T", val), "default", defaultValue[0])
+		return defaultValue[0]
 	}
 
 	slog.Warn("key not found", "key", key, "default", defaultValue[0])
@@ -310,6 +350,10 @@ func (t Tensor) typeSize() uint64 {
 		return blockSize/8 + blockSize/16 + blockSize/32
 	case 30: // BF16
 		return 2
+	case 34: // TQ1_0
+		return 2 + (blockSize-4*blockSize/64)/5 + blockSize/64
+	case 35: // TQ2_0
+		return 2 + blockSize/4
 	default:
 		return 0
 	}
@@ -374,6 +418,9 @@ func DetectContentType(b []byte) string {
 // It collects array values for arrays with a size less than or equal to
 // maxArraySize. If maxArraySize is 0, the default value of 1024 is used. If
 // the maxArraySize is negative, all arrays are collected.
+//
+// Files which aren't valid GGUF return an error wrapping [ErrInvalid], and
+// those over the [Limits] set in the environment a [LimitError].
 func Decode(rs io.ReadSeeker, maxArraySize int) (*GGML, int64, error) {
 	if maxArraySize == 0 {
 		maxArraySize = 1024
@@ -389,11 +436,11 @@ func Decode(rs io.ReadSeeker, maxArraySize int) (*GGML, int64, error) {
 	var c container
 	switch magic {
 	case FILE_MAGIC_GGUF_LE:
-		c = &containerGGUF{ByteOrder: binary.LittleEndian, maxArraySize: maxArraySize}
+		c = &containerGGUF{ByteOrder: binary.LittleEndian, maxArraySize: maxArraySize, limits: DefaultLimits()}
 	case FILE_MAGIC_GGUF_BE:
-		c = &containerGGUF{ByteOrder: binary.BigEndian, maxArraySize: maxArraySize}
+		c = &containerGGUF{ByteOrder: binary.BigEndian, maxArraySize: maxArraySize, limits: DefaultLimits()}
 	default:
-		return nil, 0, errors.New("invalid file magic")
+		return nil, 0, fmt.Errorf("%w: invalid file magic", ErrInvalid)
 	}
 
 	model, err := c.Decode(rs)
@@ -417,7 +464,10 @@ func (f GGML) GraphSize(context, batch uint64, kvCacheType string) (kv, partialO
 	embedding := f.KV().EmbeddingLength()
 	heads := f.KV().HeadCount()
 	headsKV := f.KV().HeadCountKV()
-	vocab := uint64(f.KV()["tokenizer.ggml.tokens"].(*array).size)
+	var vocab uint64
+	if tokens, ok := f.KV()["tokenizer.ggml.tokens"].(*array); ok {
+		vocab = uint64(tokens.size)
+	}
 
 	embeddingHeads := f.KV().EmbeddingHeadCount()
 	embeddingHeadsK := f.KV().EmbeddingHeadCountK()
@@ -443,12 +493,12 @@ func (f GGML) GraphSize(context, batch uint64, kvCacheType string) (kv, partialO
 
 		if ffnGateExpsWeight, ok := layers["blk.0"]["ffn_gate_exps.weight"]; ok {
 			// mixtral 8x22b
-			ff := uint64(f.KV()["llama.feed_forward_length"].(uint32))
+			ff := uint64(f.KV().Uint("feed_forward_length"))
 			partialOffload = max(
 				3*ffnGateExpsWeight.Size()+4*batch*(2*ff+headsKV+embedding+context+embeddingHeads*headsKV),
 				4*(context*batch*heads+context*embeddingHeads*headsKV+batch*1024+embeddingHeads*headsKV*batch),
 			)
-		} else if ffnGateWeight, ok := layers["blk.0"]["ffn_gate.0.weight"]; ok {
+		} else if ffnGateWeight, ok := layers["blk.0"]["ffn_gate.0.weight"]; ok && len(ffnGateWeight.Shape) > 1 && heads > 0 {
 			// mixtral 8x7b
 			ffnGateWeight1 := ffnGateWeight.Shape[1]
 			fullOffload = 4 * batch * (2 + 3*embedding + context*(1+heads) + 2*headsKV + ffnGateWeight1)
@@ -458,18 +508,14 @@ func (f GGML) GraphSize(context, batch uint64, kvCacheType string) (kv, partialO
 			)
 		}
 	case "mllama":
-		var visionTokens, tiles uint64 = 1601, 4
-
+		// the cross attention layers keep the outputs of the vision model
+		// instead, which are counted by EncoderCacheSize
 		if crossAttentionLayers, ok := f.KV()["mllama.attention.cross_attention_layers"].(*array); ok {
 			kv = headsKV *
 				(embeddingHeadsK + embeddingHeadsV) * // one for K, one for V
-				(2* // sizeof(float16)
-					(f.KV().BlockCount()-uint64(crossAttentionLayers.size))* // num non-cross attention layers
-					context +
-					4* // sizeof(float32)
-						uint64(crossAttentionLayers.size)* // num cross attention layers
-						visionTokens*
-						tiles)
+				2 * // sizeof(float16)
+				(f.KV().BlockCount() - uint64(crossAttentionLayers.size)) * // num non-cross attention layers
+				context
 		}
 
 		fullOffload = max(
@@ -554,7 +600,7 @@ func (f GGML) GraphSize(context, batch uint64, kvCacheType string) (kv, partialO
 	case "chatglm":
 		fullOffload = 4 * batch * (embedding + vocab)
 		partialOffload = 4*batch*(embedding+vocab) + embedding*vocab*105/128
-		if qkvBias, ok := layers["blk.0"]["attn_qkv.bias"]; ok {
+		if qkvBias, ok := layers["blk.0"]["attn_qkv.bias"]; ok && len(qkvBias.Shape) > 0 {
 			fullOffload = max(
 				fullOffload,
 				4*batch*(2+
@@ -632,6 +678,30 @@ func (llm GGML) VisionGraphSize() (weights, graphSize uint64) {
 	return weights, graphSize
 }
 
+// EncoderCacheSize returns the memory used to keep the encoder outputs of an
+// image, such as the cross attention states of mllama, in each layer that
+// attends to them and in all of those layers together, with bytesPerElement
+// bytes for each value. It is zero for models which don't keep them.
+func (f GGML) EncoderCacheSize(bytesPerElement float64) (layer, total uint64) {
+	switch f.KV().Architecture() {
+	case "mllama":
+		var visionTokens, tiles uint64 = 1601, 4
+
+		crossAttentionLayers, ok := f.KV()["mllama.attention.cross_attention_layers"].(*array)
+		if !ok {
+			return 0, 0
+		}
+
+		layer = uint64(float64(f.KV().HeadCountKV()*
+			(f.KV().EmbeddingHeadCountK()+f.KV().EmbeddingHeadCountV())* // one for K, one for V
+			visionTokens*
+			tiles) * bytesPerElement)
+		return layer, layer * uint64(crossAttentionLayers.size)
+	}
+
+	return 0, 0
+}
+
 // SupportsKVCacheType checks if the requested cache type is supported
 func (f GGML) SupportsKVCacheType(cacheType string) bool {
 	return slices.Contains([]string{"f16", "q8_0", "q4_0"}, cacheType)
@@ -9,6 +9,7 @@ import (
 	"io"
 	"log/slog"
 	"maps"
+	"math/bits"
 	"slices"
 	"strings"
 )
@@ -34,6 +35,7 @@ type containerGGUF struct {
 	}
 
 	maxArraySize int
+	limits       Limits
 }
 
 func (c *containerGGUF) canCollectArray(size int) bool {
@@ -55,8 +57,10 @@ func (c *containerGGUF) Decode(rs io.ReadSeeker) (model, error) {
 		err = binary.Read(rs, c.ByteOrder, &c.V1)
 	case 2:
 		err = binary.Read(rs, c.ByteOrder, &c.V2)
-	default:
+	case 3:
 		err = binary.Read(rs, c.ByteOrder, &c.V3)
+	default:
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalid, c.Version)
 	}
 	if err != nil {
 		return nil, err
@@ -70,6 +74,15 @@ func (c *containerGGUF) Decode(rs io.ReadSeeker) (model, error) {
 	return model, nil
 }
 
+const (
+	// ggufMaxDims is the most dimensions a tensor may have
+	ggufMaxDims = 4
+
+	// ggufMaxName is the longest a tensor name may be, including the null
+	// terminator ggml adds to it
+	ggufMaxName = 64
+)
+
 const (
 	ggufTypeUint8 uint32 = iota
 	ggufTypeInt8
@@ -95,6 +108,9 @@ type gguf struct {
 	parameters   uint64
 	tensorOffset uint64
 
+	// metadata is the memory taken up by the strings and arrays kept so far
+	metadata uint64
+
 	scratch [16 << 10]byte
 }
 
@@ -138,7 +154,22 @@ func (llm *gguf) numKV() uint64 {
 	}
 }
 
+// keep accounts for n bytes of strings and arrays which are kept, returning
+// a LimitError once they take up more memory than the limits allow.
+func (llm *gguf) keep(n uint64) error {
+	llm.metadata += n
+	return checkLimit("metadata", llm.metadata, llm.limits.Metadata)
+}
+
 func (llm *gguf) Decode(rs io.ReadSeeker) error {
+	if err := checkLimit("kv", llm.numKV(), llm.limits.KV); err != nil {
+		return err
+	}
+
+	if err := checkLimit("tensors", llm.numTensor(), llm.limits.Tensors); err != nil {
+		return err
+	}
+
 	// decode key-values
 	for i := 0; uint64(i) < llm.numKV(); i++ {
 		k, err := readGGUFString(llm, rs)
@@ -146,6 +177,10 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 			return err
 		}
 
+		if _, ok := llm.kv[k]; ok {
+			return fmt.Errorf("%w: duplicate key %q", ErrInvalid, k)
+		}
+
 		t, err := readGGUF[uint32](llm, rs)
 		if err != nil {
 			return err
@@ -180,7 +215,7 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 		case ggufTypeArray:
 			v, err = readGGUFArray(llm, rs)
 		default:
-			return fmt.Errorf("invalid type: %d", t)
+			return fmt.Errorf("%w: invalid type %d of key %q", ErrInvalid, t, k)
 		}
 
 		if err != nil {
@@ -191,24 +226,44 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 	}
 
 	// decode tensors
+	names := make(map[string]struct{})
 	for range llm.numTensor() {
 		name, err := readGGUFString(llm, rs)
 		if err != nil {
 			return fmt.Errorf("failed to read tensor name: %w", err)
 		}
 
+		if len(name) >= ggufMaxName {
+			return fmt.Errorf("%w: tensor name %q is too long", ErrInvalid, name)
+		}
+
+		if _, ok := names[name]; ok {
+			return fmt.Errorf("%w: duplicate tensor %q", ErrInvalid, name)
+		}
+		names[name] = struct{}{}
+
 		// dims is the number of dimensions in the tensor
 		dims, err := readGGUF[uint32](llm, rs)
 		if err != nil {
 			return fmt.Errorf("failed to read tensor dimensions: %w", err)
 		}
 
+		if dims > ggufMaxDims {
+			return fmt.Errorf("%w: tensor %q has %d dimensions", ErrInvalid, name, dims)
+		}
+
 		shape := make([]uint64, dims)
+		parameters := uint64(1)
 		for i := 0; uint32(i) < dims; i++ {
 			shape[i], err = readGGUF[uint64](llm, rs)
 			if err != nil {
 				return fmt.Errorf("failed to read tensor shape: %w", err)
 			}
+
+			var hi uint64
+			if hi, parameters = bits.Mul64(parameters, shape[i]); hi != 0 {
+				return fmt.Errorf("%w: tensor %q has too many elements", ErrInvalid, name)
+			}
 		}
 
 		kind, err := readGGUF[uint32](llm, rs)
@@ -228,6 +283,19 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 			Shape:  shape[:],
 		}
 
+		if tensor.typeSize() == 0 {
+			return fmt.Errorf("%w: tensor %q has unsupported type %d", ErrInvalid, name, kind)
+		}
+
+		// rows are made up of whole blocks
+		if dims > 0 && shape[0]%tensor.blockSize() != 0 {
+			return fmt.Errorf("%w: tensor %q has %d elements in a row, which isn't a multiple of %d", ErrInvalid, name, shape[0], tensor.blockSize())
+		}
+
+		if hi, _ := bits.Mul64(parameters, tensor.typeSize()); hi != 0 {
+			return fmt.Errorf("%w: tensor %q is too large", ErrInvalid, name)
+		}
+
 		llm.tensors = append(llm.tensors, &tensor)
 		llm.parameters += tensor.parameters()
 	}
@@ -235,9 +303,11 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 	// patch KV with parameter count
 	llm.kv["general.parameter_count"] = llm.parameters
 
-	alignment, ok := llm.kv["general.alignment"].(uint32)
-	if !ok {
-		alignment = 32
+	alignment := uint32(32)
+	if v, ok := llm.kv["general.alignment"]; ok {
+		if alignment, ok = v.(uint32); !ok || alignment == 0 || alignment&(alignment-1) != 0 {
+			return fmt.Errorf("%w: invalid alignment %v", ErrInvalid, v)
+		}
 	}
 
 	offset, err := rs.Seek(0, io.SeekCurrent)
@@ -248,6 +318,27 @@ func (llm *gguf) Decode(rs io.ReadSeeker) error {
 	padding := ggufPadding(offset, int64(alignment))
 	llm.tensorOffset = uint64(offset + padding)
 
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	// data is the size of the tensor data, which every tensor must lie within
+	var data uint64
+	if uint64(end) > llm.tensorOffset {
+		data = uint64(end) - llm.tensorOffset
+	}
+
+	for _, tensor := range llm.tensors {
+		if tensor.Offset > data || tensor.Size() > data-tensor.Offset {
+			return fmt.Errorf("%w: tensor %q is outside the file", ErrInvalid, tensor.Name)
+		}
+	}
+
 	for _, tensor := range llm.tensors {
 		offset, err := rs.Seek(0, io.SeekCurrent)
 		if err != nil {
@@ -287,15 +378,46 @@ func readGGUFV1String(llm *gguf, r io.Reader) (string, error) {
 		return "", err
 	}
 
-	var b bytes.Buffer
-	if _, err := io.CopyN(&b, r, int64(length)); err != nil {
+	if length == 0 {
+		return "", fmt.Errorf("%w: string isn't null-terminated", ErrInvalid)
+	}
+
+	s, err := readGGUFStringBytes(llm, r, length)
+	if err != nil {
 		return "", err
 	}
 
 	// gguf v1 strings are null-terminated
-	b.Truncate(b.Len() - 1)
+	return s[:len(s)-1], nil
+}
+
+// readGGUFStringBytes reads a string of length bytes. Strings which don't fit
+// in the scratch buffer are allocated as they're read, so that a length longer
+// than the rest of the file doesn't allocate memory up front.
+func readGGUFStringBytes(llm *gguf, r io.Reader, length uint64) (string, error) {
+	if err := checkLimit("string", length, llm.limits.String); err != nil {
+		return "", err
+	}
+
+	if err := llm.keep(length); err != nil {
+		return "", err
+	}
+
+	if length <= uint64(len(llm.scratch)) {
+		buf := llm.scratch[:length]
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
 
-	return b.String(), nil
+		return string(buf), nil
+	}
+
+	var sb strings.Builder
+	if _, err := io.CopyN(&sb, r, int64(length)); err != nil {
+		return "", err
+	}
+
+	return sb.String(), nil
 }
 
 func discardGGUFString(llm *gguf, r io.Reader) error {
@@ -305,15 +427,13 @@ func discardGGUFString(llm *gguf, r io.Reader) error {
 		return err
 	}
 
-	size := int(llm.ByteOrder.Uint64(buf))
-	for size > 0 {
-		n, err := r.Read(llm.scratch[:min(size, cap(llm.scratch))])
-		if err != nil {
-			return err
-		}
-		size -= n
+	size := llm.ByteOrder.Uint64(buf)
+	if err := checkLimit("string", size, llm.limits.String); err != nil {
+		return err
 	}
-	return nil
+
+	_, err = io.CopyN(io.Discard, r, int64(size))
+	return err
 }
 
 func readGGUFString(llm *gguf, r io.Reader) (string, error) {
@@ -327,19 +447,7 @@ func readGGUFString(llm *gguf, r io.Reader) (string, error) {
 		return "", err
 	}
 
-	length := int(llm.ByteOrder.Uint64(buf))
-	if length > len(llm.scratch) {
-		buf = make([]byte, length)
-	} else {
-		buf = llm.scratch[:length]
-	}
-	clear(buf)
-
-	_, err = io.ReadFull(r, buf)
-	if err != nil {
-		return "", err
-	}
-	return string(buf), nil
+	return readGGUFStringBytes(llm, r, llm.ByteOrder.Uint64(buf))
 }
 
 func writeGGUFString(w io.Writer, s string) error {
@@ -356,6 +464,8 @@ func writeGGUFString(w io.Writer, s string) error {
 }
 
 type array struct {
+	// t is the type of the elements of the array
+	t      uint32
 	size   int
 	values []any
 }
@@ -364,85 +474,45 @@ func (a *array) MarshalJSON() ([]byte, error) {
 	return json.Marshal(a.values)
 }
 
-func readGGUFV1Array(llm *gguf, r io.Reader) (*array, error) {
-	t, err := readGGUF[uint32](llm, r)
-	if err != nil {
-		return nil, err
-	}
+// arrayElementSize is the memory an element of an array takes up, that of
+// an interface value
+const arrayElementSize = 16
 
-	n, err := readGGUF[uint32](llm, r)
+func readGGUFArray(llm *gguf, r io.Reader) (*array, error) {
+	t, err := readGGUF[uint32](llm, r)
 	if err != nil {
 		return nil, err
 	}
 
-	a := &array{size: int(n)}
-	if llm.canCollectArray(int(n)) {
-		a.values = make([]any, 0, int(n))
-	}
-
-	for i := range n {
-		var e any
-		switch t {
-		case ggufTypeUint8:
-			e, err = readGGUF[uint8](llm, r)
-		case ggufTypeInt8:
-			e, err = readGGUF[int8](llm, r)
-		case ggufTypeUint16:
-			e, err = readGGUF[uint16](llm, r)
-		case ggufTypeInt16:
-			e, err = readGGUF[int16](llm, r)
-		case ggufTypeUint32:
-			e, err = readGGUF[uint32](llm, r)
-		case ggufTypeInt32:
-			e, err = readGGUF[int32](llm, r)
-		case ggufTypeUint64:
-			e, err = readGGUF[uint64](llm, r)
-		case ggufTypeInt64:
-			e, err = readGGUF[int64](llm, r)
-		case ggufTypeFloat32:
-			e, err = readGGUF[float32](llm, r)
-		case ggufTypeFloat64:
-			e, err = readGGUF[float64](llm, r)
-		case ggufTypeBool:
-			e, err = readGGUF[bool](llm, r)
-		case ggufTypeString:
-			e, err = readGGUFV1String(llm, r)
-		default:
-			return nil, fmt.Errorf("invalid array type: %d", t)
-		}
-		if err != nil {
-			return nil, err
-		}
-
-		if a.values != nil {
-			a.values[i] = e
-		}
-	}
-
-	return a, nil
-}
-
-func readGGUFArray(llm *gguf, r io.Reader) (*array, error) {
+	var n uint64
 	if llm.Version == 1 {
-		return readGGUFV1Array(llm, r)
+		var n32 uint32
+		n32, err = readGGUF[uint32](llm, r)
+		n = uint64(n32)
+	} else {
+		n, err = readGGUF[uint64](llm, r)
 	}
-
-	t, err := readGGUF[uint32](llm, r)
 	if err != nil {
 		return nil, err
 	}
 
-	n, err := readGGUF[uint64](llm, r)
-	if err != nil {
+	if err := checkLimit("array", n, llm.limits.Array); err != nil {
 		return nil, err
 	}
 
-	a := &array{size: int(n)}
-	if llm.canCollectArray(int(n)) {
-		a.values = make([]any, int(n))
+	a := &array{t: t, size: int(n)}
+	collect := llm.canCollectArray(int(n))
+	if collect {
+		if err := llm.keep(n * arrayElementSize); err != nil {
+			return nil, err
+		}
+
+		// values are appended as they're read, rather than allocated up
+		// front, in case the file is shorter than the array
+		a.values = make([]any, 0, min(n, 1024))
 	}
 
-	for i := range n {
+	for range n {
 		var e any
 		switch t {
 		case ggufTypeUint8:
@@ -468,20 +538,20 @@ func readGGUFArray(llm *gguf, r io.Reader) (*array, error) {
 		case ggufTypeBool:
 			e, err = readGGUF[bool](llm, r)
 		case ggufTypeString:
-			if a.values != nil {
+			if collect {
 				e, err = readGGUFString(llm, r)
 			} else {
 				err = discardGGUFString(llm, r)
 			}
 		default:
-			return nil, fmt.Errorf("invalid array type: %d", t)
+			return nil, fmt.Errorf("%w: invalid array type %d", ErrInvalid, t)
 		}
 		if err != nil {
 			return nil, err
 		}
 
-		if a.values != nil {
-			a.values[i] = e
+		if collect {
+			a.values = append(a.values, e)
 		}
 	}
 
@@ -572,10 +642,26 @@ func ggufWriteKV(ws io.WriteSeeker, k string, v any) error {
 
 	var err error
 	switch v := v.(type) {
+	case uint8:
+		err = writeGGUF(ws, ggufTypeUint8, v)
+	case int8:
+		err = writeGGUF(ws, ggufTypeInt8, v)
+	case uint16:
+		err = writeGGUF(ws, ggufTypeUint16, v)
+	case int16:
+		err = writeGGUF(ws, ggufTypeInt16, v)
 	case uint32:
 		err = writeGGUF(ws, ggufTypeUint32, v)
+	case int32:
+		err = writeGGUF(ws, ggufTypeInt32, v)
+	case uint64:
+		err = writeGGUF(ws, ggufTypeUint64, v)
+	case int64:
+		err = writeGGUF(ws, ggufTypeInt64, v)
 	case float32:
 		err = writeGGUF(ws, ggufTypeFloat32, v)
+	case float64:
+		err = writeGGUF(ws, ggufTypeFloat64, v)
 	case bool:
 		err = writeGGUF(ws, ggufTypeBool, v)
 	case string:
@@ -586,6 +672,8 @@ func ggufWriteKV(ws io.WriteSeeker, k string, v any) error {
 		err = writeGGUFArray(ws, ggufTypeUint32, v)
 	case []float32:
 		err = writeGGUFArray(ws, ggufTypeFloat32, v)
+	case *array:
+		err = writeGGUFDecodedArray(ws, v)
 	case []string:
 		if err := binary.Write(ws, binary.LittleEndian, ggufTypeArray); err != nil {
 			return err
@@ -615,6 +703,43 @@ func ggufWriteKV(ws io.WriteSeeker, k string, v any) error {
 	return err
 }
 
+// writeGGUFDecodedArray writes an array read by Decode, which must have
+// collected its values.
+func writeGGUFDecodedArray(w io.Writer, a *array) error {
+	if len(a.values) != a.size {
+		return fmt.Errorf("array of %d values wasn't decoded", a.size)
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, ggufTypeArray); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, a.t); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint64(a.size)); err != nil {
+		return err
+	}
+
+	for _, e := range a.values {
+		var err error
+		if s, ok := e.(string); ok {
+			if err = binary.Write(w, binary.LittleEndian, uint64(len(s))); err == nil {
+				_, err = io.WriteString(w, s)
+			}
+		} else {
+			err = binary.Write(w, binary.LittleEndian, e)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func ggufWriteTensorInfo(ws io.WriteSeeker, t Tensor) error {
 	slog.Debug(t.Name, "kind", t.Kind, "shape", t.Shape, "offset", t.Offset)
 	if err := binary.Write(ws, binary.LittleEndian, uint64(len(t.Name))); err != nil {
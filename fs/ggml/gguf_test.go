@@ -0,0 +1,273 @@
+package ggml
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// ggufBytes returns the parts of a GGUF file written one after another in
+// little endian order, with strings written after their length.
+func ggufBytes(tb testing.TB, parts ...any) []byte {
+	tb.Helper()
+
+	var b bytes.Buffer
+	for _, p := range parts {
+		var err error
+		switch p := p.(type) {
+		case string:
+			if err = binary.Write(&b, binary.LittleEndian, uint64(len(p))); err == nil {
+				_, err = b.WriteString(p)
+			}
+		default:
+			err = binary.Write(&b, binary.LittleEndian, p)
+		}
+
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	return b.Bytes()
+}
+
+// ggufHeader returns the parts of the header of a version 3 GGUF file.
+func ggufHeader(tensors, kv uint64) []any {
+	return []any{[]byte("GGUF"), uint32(3), tensors, kv}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	cases := []struct {
+		name  string
+		env   map[string]string
+		parts []any
+		limit *LimitError
+	}{
+		{
+			name:  "tensors",
+			parts: ggufHeader(1<<40, 0),
+			limit: &LimitError{Limit: "tensors", Max: 1 << 16, Value: 1 << 40},
+		},
+		{
+			name:  "kv",
+			parts: ggufHeader(0, 1<<40),
+			limit: &LimitError{Limit: "kv", Max: 1 << 16, Value: 1 << 40},
+		},
+		{
+			name:  "tensors set",
+			env:   map[string]string{"OLLAMA_MAX_GGUF_TENSORS": "1"},
+			parts: ggufHeader(2, 0),
+			limit: &LimitError{Limit: "tensors", Max: 1, Value: 2},
+		},
+		{
+			name:  "string",
+			parts: append(ggufHeader(0, 1), uint64(1<<40)),
+			limit: &LimitError{Limit: "string", Max: 32 << 20, Value: 1 << 40},
+		},
+		{
+			name:  "array",
+			parts: append(ggufHeader(0, 1), "tokenizer.ggml.tokens", ggufTypeArray, ggufTypeString, uint64(1<<40)),
+			limit: &LimitError{Limit: "array", Max: 1 << 24, Value: 1 << 40},
+		},
+		{
+			name:  "metadata",
+			env:   map[string]string{"OLLAMA_MAX_GGUF_METADATA": "1KB"},
+			parts: append(ggufHeader(0, 1), "tokenizer.chat_template", ggufTypeString, string(make([]byte, 2000))),
+			limit: &LimitError{Limit: "metadata", Max: 1000, Value: 2023},
+		},
+		{
+			name:  "metadata array",
+			env:   map[string]string{"OLLAMA_MAX_GGUF_METADATA": "1KB"},
+			parts: append(ggufHeader(0, 1), "a", ggufTypeArray, ggufTypeUint8, uint64(100)),
+			limit: &LimitError{Limit: "metadata", Max: 1000, Value: 1601},
+		},
+		{
+			name:  "magic",
+			parts: []any{[]byte("GGML"), uint32(3)},
+		},
+		{
+			name:  "version",
+			parts: []any{[]byte("GGUF"), uint32(4), uint64(0), uint64(0)},
+		},
+		{
+			name:  "v1 string",
+			parts: []any{[]byte("GGUF"), uint32(1), uint32(0), uint32(1), uint64(0)},
+		},
+		{
+			name:  "type",
+			parts: append(ggufHeader(0, 1), "a", uint32(13)),
+		},
+		{
+			name:  "array type",
+			parts: append(ggufHeader(0, 1), "a", ggufTypeArray, ggufTypeArray, uint64(1)),
+		},
+		{
+			name:  "duplicate key",
+			parts: append(ggufHeader(0, 2), "a", ggufTypeUint32, uint32(1), "a", ggufTypeUint32, uint32(2)),
+		},
+		{
+			name:  "alignment",
+			parts: append(ggufHeader(0, 1), "general.alignment", ggufTypeUint32, uint32(24)),
+		},
+		{
+			name:  "alignment type",
+			parts: append(ggufHeader(0, 1), "general.alignment", ggufTypeString, "32"),
+		},
+		{
+			name:  "dimensions",
+			parts: append(ggufHeader(1, 0), "output.weight", uint32(5), uint64(1), uint64(1), uint64(1), uint64(1), uint64(1), uint32(0), uint64(0)),
+		},
+		{
+			name:  "elements",
+			parts: append(ggufHeader(1, 0), "output.weight", uint32(2), uint64(1<<32), uint64(1<<32), uint32(0), uint64(0)),
+		},
+		{
+			name:  "tensor type",
+			parts: append(ggufHeader(1, 0), "output.weight", uint32(1), uint64(256), uint32(4), uint64(0)),
+		},
+		{
+			name:  "blocks",
+			parts: append(ggufHeader(1, 0), "output.weight", uint32(1), uint64(16), uint32(2), uint64(0)),
+		},
+		{
+			name:  "tensor name",
+			parts: append(ggufHeader(1, 0), string(bytes.Repeat([]byte("a"), 64)), uint32(1), uint64(1), uint32(0), uint64(0)),
+		},
+		{
+			name: "duplicate tensor",
+			parts: append(ggufHeader(2, 0),
+				"output.weight", uint32(1), uint64(1), uint32(0), uint64(0),
+				"output.weight", uint32(1), uint64(1), uint32(0), uint64(32),
+				make([]byte, 4+28+64)),
+		},
+		{
+			name:  "outside",
+			parts: append(ggufHeader(1, 0), "output.weight", uint32(1), uint64(32), uint32(0), uint64(0), make([]byte, 64)),
+		},
+		{
+			name:  "offset",
+			parts: append(ggufHeader(1, 0), "output.weight", uint32(1), uint64(1), uint32(0), uint64(1<<63), make([]byte, 64)),
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			_, _, err := Decode(bytes.NewReader(ggufBytes(t, tt.parts...)), -1)
+			if !errors.Is(err, ErrInvalid) {
+				t.Fatalf("expected an invalid model file, got %v", err)
+			}
+
+			var limit LimitError
+			if tt.limit == nil {
+				if errors.As(err, &limit) {
+					t.Errorf("expected an invalid model file under the limits, got %v", err)
+				}
+				return
+			}
+
+			if !errors.As(err, &limit) {
+				t.Fatalf("expected a limit error, got %v", err)
+			}
+
+			if diff := cmp.Diff(limit, *tt.limit); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeValid(t *testing.T) {
+	b := ggufBytes(t, append(ggufHeader(2, 2),
+		"general.alignment", ggufTypeUint32, uint32(64),
+		"tokenizer.ggml.tokens", ggufTypeArray, ggufTypeString, uint64(2), "a", "b",
+		"token_embd.weight", uint32(2), uint64(2), uint64(2), uint32(0), uint64(0),
+		"output.weight", uint32(1), uint64(256), uint32(35), uint64(64),
+	)...)
+	b = append(b, make([]byte, ggufPadding(int64(len(b)), 64)+64+66)...)
+
+	f, n, err := Decode(bytes.NewReader(b), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != int64(len(b)) {
+		t.Errorf("expected to decode %d bytes, got %d", len(b), n)
+	}
+
+	if diff := cmp.Diff(f.KV().Strings("tokenizer.ggml.tokens"), []string{"a", "b"}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	if f.KV().ParameterCount() != 260 {
+		t.Errorf("expected 260 parameters, got %d", f.KV().ParameterCount())
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	path := filepath.Join(f.TempDir(), "model.gguf")
+	w, err := os.Create(path)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	if err := WriteGGUF(w, KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(1),
+		"llama.embedding_length":        uint32(4),
+		"llama.attention.head_count":    uint32(2),
+		"llama.attention.head_count_kv": uint32(0),
+		"tokenizer.ggml.tokens":         []string{"a", "b", "c"},
+		"tokenizer.ggml.scores":         []float32{0, 1, 2},
+		"tokenizer.ggml.token_type":     []int32{1, 1, 1},
+	}, []Tensor{
+		{Name: "token_embd.weight", Kind: 0, Shape: []uint64{4, 3}, WriterTo: bytes.NewReader(make([]byte, 48))},
+		{Name: "blk.0.ffn_gate.0.weight", Kind: 1, Shape: []uint64{4}, WriterTo: bytes.NewReader(make([]byte, 8))},
+	}); err != nil {
+		f.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		f.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(b)
+	f.Add(ggufBytes(f, []byte("GGUF"), uint32(1), uint32(1), uint32(1),
+		"a\x00", ggufTypeArray, ggufTypeString, uint32(1), "b\x00",
+		"output.weight\x00", uint32(1), uint64(1), uint32(0), uint64(0), make([]byte, 36)))
+	f.Add(ggufBytes(f, append(ggufHeader(0, 1), "general.architecture", ggufTypeString, "chatglm")...))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		g, _, err := Decode(bytes.NewReader(b), -1)
+		if err != nil {
+			return
+		}
+
+		kv := g.KV()
+		kv.Architecture()
+		kv.ChatTemplate()
+		kv.GQA()
+		kv.MatryoshkaDimensions()
+		kv.Strings("tokenizer.ggml.tokens")
+		kv.Floats("tokenizer.ggml.scores")
+		kv.Uints("tokenizer.ggml.token_type")
+		kv.Bytes("tokenizer.ggml.precompiled_charsmap")
+		g.Tensors().GroupLayers()
+		g.GraphSize(2048, 512, "f16")
+		g.VisionGraphSize()
+		g.EncoderCacheSize(2)
+	})
+}
@@ -0,0 +1,71 @@
+package ggml
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// ErrInvalid is returned, wrapped, by Decode for files which aren't valid
+// GGUF, such as those which are truncated or whose tensors lie outside them.
+var ErrInvalid = errors.New("invalid model file")
+
+// Limits bound what Decode reads from a file, so that a crafted file can't
+// make it allocate more memory than a model could need.
+type Limits struct {
+	// Tensors is the most tensors a file may have.
+	Tensors uint64
+
+	// KV is the most key-values a file may have.
+	KV uint64
+
+	// String is the longest a string may be, in bytes.
+	String uint64
+
+	// Array is the most elements an array may have.
+	Array uint64
+
+	// Metadata is the most memory, in bytes, the strings and arrays which are
+	// kept may take up together.
+	Metadata uint64
+}
+
+// DefaultLimits returns the limits set with the OLLAMA_MAX_GGUF_*
+// environment variables.
+func DefaultLimits() Limits {
+	return Limits{
+		Tensors:  uint64(envconfig.MaxGGUFTensors()),
+		KV:       uint64(envconfig.MaxGGUFKV()),
+		String:   uint64(envconfig.MaxGGUFString()),
+		Array:    uint64(envconfig.MaxGGUFArray()),
+		Metadata: uint64(envconfig.MaxGGUFMetadata()),
+	}
+}
+
+// LimitError is returned by Decode for files which go over one of its
+// [Limits]. It wraps [ErrInvalid].
+type LimitError struct {
+	// Limit is what was limited: "tensors", "kv", "string", "array" or
+	// "metadata".
+	Limit string
+	Max   uint64
+	Value uint64
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("%s: %s of %d is over the limit of %d", ErrInvalid, e.Limit, e.Value, e.Max)
+}
+
+func (e LimitError) Unwrap() error {
+	return ErrInvalid
+}
+
+// checkLimit returns a LimitError if value is over the limit of name.
+func checkLimit(name string, value, limit uint64) error {
+	if value > limit {
+		return LimitError{Limit: name, Max: limit, Value: value}
+	}
+
+	return nil
+}
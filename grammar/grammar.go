@@ -0,0 +1,520 @@
+// Package grammar constrains the text a model generates to a formal language,
+// such as the documents matching a JSON Schema.
+//
+// A Grammar is compiled from GBNF, the grammar format of llama.cpp, or from a
+// JSON Schema or regular expression. A Matcher then tracks how far generated
+// text has advanced through the grammar and, at each step, which tokens of a
+// model's Vocabulary may come next:
+//
+//	g, err := grammar.FromJSONSchema(schema)
+//	if err != nil {
+//		return err
+//	}
+//
+//	vocab := grammar.NewVocabulary(pieces, eog)
+//	m := grammar.NewMatcher(g, vocab)
+//	for !m.Done() {
+//		m.Mask(allowed)
+//		// sample a token which is allowed
+//		if err := m.Accept(token); err != nil {
+//			return err
+//		}
+//	}
+package grammar
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// element is a character which is in (or, if negate is set, not in) ranges,
+// pairs of inclusive bounds, or if rule isn't negative, text matching that
+// rule.
+type element struct {
+	ranges []rune
+	negate bool
+	rule   int
+}
+
+func (e element) matches(r rune) bool {
+	for i := 0; i < len(e.ranges); i += 2 {
+		if r >= e.ranges[i] && r <= e.ranges[i+1] {
+			return !e.negate
+		}
+	}
+
+	return e.negate
+}
+
+type rule struct {
+	name string
+
+	// alts are the sequences of elements the rule matches, any of which may
+	// be empty
+	alts [][]element
+}
+
+// Grammar is a compiled context free grammar. It's safe to use from multiple
+// goroutines.
+type Grammar struct {
+	rules []rule
+	root  int
+}
+
+// Parse compiles a grammar in GBNF, the grammar format of llama.cpp. The text
+// generated must match the rule named root.
+func Parse(gbnf string) (*Grammar, error) {
+	p := parser{s: gbnf, names: make(map[string]int)}
+	g, err := p.parse()
+	if err != nil {
+		line := strings.Count(gbnf[:p.pos], "\n") + 1
+		return nil, fmt.Errorf("grammar: line %d: %w", line, err)
+	}
+
+	return g, nil
+}
+
+// MustParse is like Parse but panics if the grammar can't be compiled.
+func MustParse(gbnf string) *Grammar {
+	g, err := Parse(gbnf)
+	if err != nil {
+		panic(err)
+	}
+
+	return g
+}
+
+type parser struct {
+	s   string
+	pos int
+
+	rules []rule
+	names map[string]int
+
+	// defined are whether each rule has been defined, rather than only
+	// referenced
+	defined []bool
+}
+
+// symbol returns the rule named name, adding it if it hasn't been seen before.
+func (p *parser) symbol(name string) int {
+	if i, ok := p.names[name]; ok {
+		return i
+	}
+
+	p.rules = append(p.rules, rule{name: name})
+	p.defined = append(p.defined, false)
+	p.names[name] = len(p.rules) - 1
+	return len(p.rules) - 1
+}
+
+// generate adds an unnamed rule matching alts, which errors refer to by the
+// name of the rule it's part of.
+func (p *parser) generate(base string, alts [][]element) int {
+	p.rules = append(p.rules, rule{name: base + "-" + strconv.Itoa(len(p.rules)), alts: alts})
+	p.defined = append(p.defined, true)
+	return len(p.rules) - 1
+}
+
+func (p *parser) parse() (*Grammar, error) {
+	for p.space(true); p.pos < len(p.s); p.space(true) {
+		name := p.name()
+		if name == "" {
+			return nil, fmt.Errorf("expected rule name, found %q", p.peek())
+		}
+
+		p.space(false)
+		if !strings.HasPrefix(p.s[p.pos:], "::=") {
+			return nil, fmt.Errorf("expected ::= after %s", name)
+		}
+		p.pos += len("::=")
+		p.space(true)
+
+		i := p.symbol(name)
+		if p.defined[i] {
+			return nil, fmt.Errorf("rule %s is defined more than once", name)
+		}
+
+		alts, err := p.alternatives(name, false)
+		if err != nil {
+			return nil, err
+		}
+
+		p.rules[i].alts = alts
+		p.defined[i] = true
+
+		if p.pos < len(p.s) && p.s[p.pos] != '\n' && p.s[p.pos] != '\r' {
+			return nil, fmt.Errorf("expected end of rule %s, found %q", name, p.peek())
+		}
+	}
+
+	root, ok := p.names["root"]
+	if !ok {
+		return nil, errors.New("grammar has no root rule")
+	}
+
+	for i, defined := range p.defined {
+		if !defined {
+			return nil, fmt.Errorf("rule %s is referenced but not defined", p.rules[i].name)
+		}
+	}
+
+	g := &Grammar{rules: p.rules, root: root}
+	if err := g.checkLeftRecursion(); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// peek returns the next character, for errors.
+func (p *parser) peek() string {
+	if p.pos >= len(p.s) {
+		return "end of grammar"
+	}
+
+	r, _ := utf8.DecodeRuneInString(p.s[p.pos:])
+	return string(r)
+}
+
+// space skips spaces and comments, and newlines if newlines is set.
+func (p *parser) space(newlines bool) {
+	for p.pos < len(p.s) {
+		switch c := p.s[p.pos]; {
+		case c == ' ' || c == '\t':
+			p.pos++
+		case (c == '\n' || c == '\r') && newlines:
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.s) && p.s[p.pos] != '\n' && p.s[p.pos] != '\r' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+}
+
+func (p *parser) name() string {
+	start := p.pos
+	for p.pos < len(p.s) && isNameChar(p.s[p.pos]) {
+		p.pos++
+	}
+
+	return p.s[start:p.pos]
+}
+
+// alternatives parses sequences separated by |. Sequences may only continue
+// onto the next line when nested in parentheses.
+func (p *parser) alternatives(name string, nested bool) ([][]element, error) {
+	var alts [][]element
+	for {
+		seq, err := p.sequence(name, nested)
+		if err != nil {
+			return nil, err
+		}
+
+		alts = append(alts, seq)
+		if p.pos >= len(p.s) || p.s[p.pos] != '|' {
+			return alts, nil
+		}
+
+		p.pos++
+		p.space(true)
+	}
+}
+
+func (p *parser) sequence(name string, nested bool) ([]element, error) {
+	var seq []element
+
+	// last is where the most recent item starts, which repetitions apply to
+	last := -1
+	for p.pos < len(p.s) {
+		start := len(seq)
+		switch c := p.s[p.pos]; {
+		case c == '"':
+			p.pos++
+			for p.pos < len(p.s) && p.s[p.pos] != '"' {
+				r, err := p.char()
+				if err != nil {
+					return nil, err
+				}
+
+				seq = append(seq, element{ranges: []rune{r, r}, rule: -1})
+			}
+
+			if p.pos >= len(p.s) {
+				return nil, errors.New("unterminated string")
+			}
+			p.pos++
+		case c == '[':
+			e, err := p.class()
+			if err != nil {
+				return nil, err
+			}
+
+			seq = append(seq, e)
+		case c == '.':
+			p.pos++
+			seq = append(seq, element{ranges: []rune{0, utf8.MaxRune}, rule: -1})
+		case c == '(':
+			p.pos++
+			p.space(true)
+			alts, err := p.alternatives(name, true)
+			if err != nil {
+				return nil, err
+			}
+
+			if p.pos >= len(p.s) || p.s[p.pos] != ')' {
+				return nil, fmt.Errorf("expected ) in rule %s, found %q", name, p.peek())
+			}
+			p.pos++
+
+			seq = append(seq, element{rule: p.generate(name, alts)})
+		case isNameChar(c):
+			seq = append(seq, element{rule: p.symbol(p.name())})
+		case c == '*' || c == '+' || c == '?' || c == '{':
+			if last < 0 {
+				return nil, fmt.Errorf("expected item before %c in rule %s", c, name)
+			}
+
+			lo, hi, err := p.repetition()
+			if err != nil {
+				return nil, err
+			}
+
+			seq = append(seq[:last], p.repeat(name, slices.Clone(seq[last:]), lo, hi)...)
+			start = last
+		default:
+			return seq, nil
+		}
+
+		last = start
+		p.space(nested)
+	}
+
+	return seq, nil
+}
+
+// repetition parses *, +, ? or {lo,hi}, returning how many times the item
+// before it repeats. hi is negative if it may repeat any number of times.
+func (p *parser) repetition() (lo, hi int, err error) {
+	c := p.s[p.pos]
+	p.pos++
+	switch c {
+	case '*':
+		return 0, -1, nil
+	case '+':
+		return 1, -1, nil
+	case '?':
+		return 0, 1, nil
+	}
+
+	number := func() (int, bool) {
+		p.space(false)
+		start := p.pos
+		for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+			p.pos++
+		}
+
+		n, err := strconv.Atoi(p.s[start:p.pos])
+		p.space(false)
+		return n, err == nil
+	}
+
+	lo, ok := number()
+	if !ok {
+		return 0, 0, errors.New("expected number in repetition")
+	}
+
+	hi = lo
+	if p.pos < len(p.s) && p.s[p.pos] == ',' {
+		p.pos++
+		if hi, ok = number(); !ok {
+			hi = -1
+		} else if hi < lo {
+			return 0, 0, fmt.Errorf("invalid repetition {%d,%d}", lo, hi)
+		}
+	}
+
+	if p.pos >= len(p.s) || p.s[p.pos] != '}' {
+		return 0, 0, errors.New("expected } after repetition")
+	}
+	p.pos++
+
+	return lo, hi, nil
+}
+
+// repeat returns the elements matching item repeated at least lo and at most
+// hi times, or any number of times if hi is negative.
+func (p *parser) repeat(name string, item []element, lo, hi int) []element {
+	var seq []element
+	for range lo {
+		seq = append(seq, item...)
+	}
+
+	if hi < 0 {
+		// rest ::= item rest |
+		rest := p.generate(name, nil)
+		p.rules[rest].alts = [][]element{append(slices.Clone(item), element{rule: rest}), nil}
+		return append(seq, element{rule: rest})
+	}
+
+	if hi > lo {
+		// item (item (item)?)?
+		var opt []element
+		for range hi - lo {
+			opt = []element{{rule: p.generate(name, [][]element{append(slices.Clone(item), opt...), nil})}}
+		}
+
+		seq = append(seq, opt...)
+	}
+
+	return seq
+}
+
+// class parses a character class such as [a-z] or [^"\\].
+func (p *parser) class() (element, error) {
+	p.pos++
+
+	e := element{rule: -1}
+	if p.pos < len(p.s) && p.s[p.pos] == '^' {
+		e.negate = true
+		p.pos++
+	}
+
+	for p.pos < len(p.s) && p.s[p.pos] != ']' {
+		lo, err := p.char()
+		if err != nil {
+			return element{}, err
+		}
+
+		hi := lo
+		if p.pos+1 < len(p.s) && p.s[p.pos] == '-' && p.s[p.pos+1] != ']' {
+			p.pos++
+			if hi, err = p.char(); err != nil {
+				return element{}, err
+			}
+		}
+
+		e.ranges = append(e.ranges, lo, hi)
+	}
+
+	if p.pos >= len(p.s) {
+		return element{}, errors.New("unterminated character class")
+	}
+	p.pos++
+
+	return e, nil
+}
+
+// char parses a possibly escaped character in a string or class.
+func (p *parser) char() (rune, error) {
+	if p.s[p.pos] != '\\' {
+		r, n := utf8.DecodeRuneInString(p.s[p.pos:])
+		p.pos += n
+		return r, nil
+	}
+
+	if p.pos+1 >= len(p.s) {
+		return 0, errors.New("unterminated escape")
+	}
+
+	c := p.s[p.pos+1]
+	p.pos += 2
+	switch c {
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case 'x', 'u', 'U':
+		n := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+		if p.pos+n > len(p.s) {
+			return 0, fmt.Errorf("invalid escape \\%c", c)
+		}
+
+		r, err := strconv.ParseUint(p.s[p.pos:p.pos+n], 16, 32)
+		if err != nil || r > utf8.MaxRune {
+			return 0, fmt.Errorf("invalid escape \\%c%s", c, p.s[p.pos:p.pos+n])
+		}
+
+		p.pos += n
+		return rune(r), nil
+	case '\\', '"', '[', ']', '-', '^', '.', '/':
+		return rune(c), nil
+	default:
+		return 0, fmt.Errorf("unknown escape \\%c", c)
+	}
+}
+
+// checkLeftRecursion returns an error if a rule can match itself before
+// matching any text, which would make matching loop forever.
+func (g *Grammar) checkLeftRecursion() error {
+	// nullable is whether each rule can match empty text
+	nullable := make([]bool, len(g.rules))
+	for changed := true; changed; {
+		changed = false
+		for i, r := range g.rules {
+			if nullable[i] {
+				continue
+			}
+
+			for _, alt := range r.alts {
+				if !slices.ContainsFunc(alt, func(e element) bool { return e.rule < 0 || !nullable[e.rule] }) {
+					nullable[i], changed = true, true
+					break
+				}
+			}
+		}
+	}
+
+	// visiting is 1 for the rules being visited and 2 for those visited
+	visiting := make([]int, len(g.rules))
+	var visit func(int) error
+	visit = func(i int) error {
+		switch visiting[i] {
+		case 1:
+			return fmt.Errorf("rule %s is left recursive", g.rules[i].name)
+		case 2:
+			return nil
+		}
+
+		visiting[i] = 1
+		for _, alt := range g.rules[i].alts {
+			for _, e := range alt {
+				if e.rule < 0 {
+					break
+				}
+
+				if err := visit(e.rule); err != nil {
+					return err
+				}
+
+				if !nullable[e.rule] {
+					break
+				}
+			}
+		}
+
+		visiting[i] = 2
+		return nil
+	}
+
+	for i := range g.rules {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
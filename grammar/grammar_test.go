@@ -0,0 +1,161 @@
+package grammar
+
+import (
+	"strings"
+	"testing"
+)
+
+// jsonGBNF is the grammar of JSON which the runners use for the "json" format.
+const jsonGBNF = `
+root   ::= object
+value  ::= object | array | string | number | ("true" | "false" | "null") ws
+object ::=
+  "{" ws (
+            string ":" ws value
+    ("," ws string ":" ws value)*
+  )? "}" ws
+array  ::=
+  "[" ws (
+            value
+    ("," ws value)*
+  )? "]" ws
+string ::=
+  "\"" (
+    [^"\\\x7F\x00-\x1F] |
+    "\\" (["\\/bfnrt] | "u" [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F] [0-9a-fA-F]) # escapes
+  )* "\"" ws
+number ::= ("-"? ([0-9] | [1-9] [0-9]*)) ("." [0-9]+)? ([eE] [-+]? [0-9]+)? ws
+# Optional space: by convention, applied in this grammar after literal chars when allowed
+ws ::= ([ \t\n] ws)?
+`
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name    string
+		gbnf    string
+		match   []string
+		prefix  []string
+		invalid []string
+	}{
+		{
+			name:    "json",
+			gbnf:    jsonGBNF,
+			match:   []string{`{}`, `{"a": [1, 2.5e3, "x\n"], "b": {"c": null}} `, "{\n\t\"é\": true}"},
+			prefix:  []string{`{"a": [1, `, `{"a`, `{`},
+			invalid: []string{`[]`, `{"a": 01}`, `{"a" 1}`, `{} x`, "{\"\x01\": 1}"},
+		},
+		{
+			name:    "repetition",
+			gbnf:    `root ::= "a"{2} "b"{1,3} ("c" "d"){0,} [x-z]? "e"+`,
+			match:   []string{"aabe", "aabbbcdcdxeee", "aabbze"},
+			prefix:  []string{"a", "aabbb", "aabcd"},
+			invalid: []string{"abe", "aabbbbe", "aabcdcxe", "aabxye"},
+		},
+		{
+			name:    "classes",
+			gbnf:    `root ::= [^\]\-abc] [\x41-\x43] [é] . "\U0001F600"`,
+			match:   []string{"x\x42é\n😀", "zAé😀😀"},
+			invalid: []string{"aAé.😀", "-Dé.😀", "]Aé.😀"},
+		},
+		{
+			name:    "empty alternatives",
+			gbnf:    "root ::= | \"a\" root-b\nroot-b ::= \"b\" |",
+			match:   []string{"", "a", "ab"},
+			invalid: []string{"b", "abb"},
+		},
+		{
+			name:    "comments",
+			gbnf:    "# a comment\nroot ::= \"a\" # another\n  # and another\n",
+			match:   []string{"a"},
+			invalid: []string{"", "aa"},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := Parse(tt.gbnf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range tt.match {
+				if state := g.Start().AdvanceString(s); !state.Complete() {
+					t.Errorf("%q doesn't match", s)
+				}
+			}
+
+			for _, s := range tt.prefix {
+				if state := g.Start().AdvanceString(s); state.Complete() || state.Dead() {
+					t.Errorf("%q isn't only a prefix of matching text", s)
+				}
+			}
+
+			for _, s := range tt.invalid {
+				if state := g.Start().AdvanceString(s); state.Complete() {
+					t.Errorf("%q matches", s)
+				}
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		gbnf string
+		err  string
+	}{
+		{`a ::= "a"`, "no root rule"},
+		{`root ::= a`, "rule a is referenced but not defined"},
+		{"root ::= \"a\"\nroot ::= \"b\"", "line 2: rule root is defined more than once"},
+		{`root ::= root "a" | "b"`, "rule root is left recursive"},
+		{"root ::= a \"b\"\na ::= \"a\"? root", "left recursive"},
+		{`root ::= "a`, "unterminated string"},
+		{`root ::= [a-`, "unterminated character class"},
+		{`root ::= ("a"`, "expected )"},
+		{`root ::= * "a"`, "expected item before *"},
+		{`root ::= "a"{3,1}`, "invalid repetition"},
+		{`root ::= "\q"`, `unknown escape \q`},
+		{`root = "a"`, "expected ::= after root"},
+	}
+
+	for _, tt := range cases {
+		if _, err := Parse(tt.gbnf); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.gbnf, tt.err, err)
+		}
+	}
+}
+
+func TestStateFinal(t *testing.T) {
+	g := MustParse(`root ::= "a" "b"?`)
+
+	state := g.Start().Advance('a')
+	if !state.Complete() || state.Final() {
+		t.Errorf("after a: complete %v, final %v", state.Complete(), state.Final())
+	}
+
+	state = state.Advance('b')
+	if !state.Final() {
+		t.Error("after ab: expected final")
+	}
+
+	if state = state.Advance('b'); !state.Dead() {
+		t.Error("after abb: expected dead")
+	}
+}
+
+func TestRightRecursionDepth(t *testing.T) {
+	// states of right recursive rules shouldn't grow with the text
+	g := MustParse(jsonGBNF)
+	state := g.Start().AdvanceString(`{"a": "` + strings.Repeat("x", 10000))
+
+	for _, stack := range state.stacks {
+		var depth int
+		for f := stack; f != nil; f = f.next {
+			depth++
+		}
+
+		if depth > 8 {
+			t.Fatalf("expected shallow stacks, got depth %d", depth)
+		}
+	}
+}
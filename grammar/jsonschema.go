@@ -0,0 +1,440 @@
+package grammar
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// primitives are the rules of JSON values which schemas build on, in the
+// order they're written.
+var primitives = []struct{ name, body string }{
+	{"space", `| " " | "\n" [ \t]{0,20}`},
+	{"boolean", `("true" | "false") space`},
+	{"null", `"null" space`},
+	{"integer", `("-"? ([0-9] | [1-9] [0-9]{0,15})) space`},
+	{"number", `("-"? ([0-9] | [1-9] [0-9]{0,15})) ("." [0-9]+)? ([eE] [-+]? [0-9]{1,15})? space`},
+	{"char", `[^"\\\x7F\x00-\x1F] | [\\] (["\\/bfnrt] | "u" [0-9a-fA-F]{4})`},
+	{"string", `"\"" char* "\"" space`},
+	{"object", `"{" space (string ":" space value ("," space string ":" space value)*)? "}" space`},
+	{"array", `"[" space (value ("," space value)*)? "]" space`},
+	{"value", `object | array | string | number | boolean | null`},
+}
+
+// FromJSONSchema compiles a JSON Schema into a grammar of the JSON documents
+// it describes. An empty schema, or {}, describes any JSON value.
+func FromJSONSchema(schema []byte) (*Grammar, error) {
+	gbnf, err := JSONSchemaToGBNF(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(gbnf)
+}
+
+// JSONSchemaToGBNF converts a JSON Schema to GBNF, with a root rule which
+// matches the JSON documents it describes.
+//
+// The schema keywords supported are type, properties, required, items,
+// minItems, maxItems, minLength, maxLength, pattern, enum, const, anyOf,
+// oneOf and $ref to $defs or definitions. Objects with properties may only
+// have those properties, in the order they're listed, and patterns match the
+// unescaped text of strings.
+func JSONSchemaToGBNF(schema []byte) (string, error) {
+	if len(bytes.TrimSpace(schema)) == 0 {
+		schema = []byte("{}")
+	}
+
+	c := schemaConverter{rules: make(map[string]string)}
+	if err := json.Unmarshal(schema, &c.root); err != nil {
+		return "", fmt.Errorf("invalid JSON schema: %w", err)
+	}
+
+	body, err := c.visit(c.root, "root")
+	if err != nil {
+		return "", err
+	}
+	c.add("root", body)
+
+	var sb strings.Builder
+	for _, name := range c.order {
+		fmt.Fprintf(&sb, "%s ::= %s\n", name, c.rules[name])
+	}
+
+	return sb.String(), nil
+}
+
+type jsonSchema struct {
+	Type                 json.RawMessage            `json:"type"`
+	Properties           properties                 `json:"properties"`
+	Required             []string                   `json:"required"`
+	AdditionalProperties json.RawMessage            `json:"additionalProperties"`
+	Items                *jsonSchema                `json:"items"`
+	MinItems             int                        `json:"minItems"`
+	MaxItems             *int                       `json:"maxItems"`
+	MinLength            int                        `json:"minLength"`
+	MaxLength            *int                       `json:"maxLength"`
+	Pattern              string                     `json:"pattern"`
+	Enum                 []json.RawMessage          `json:"enum"`
+	Const                json.RawMessage            `json:"const"`
+	AnyOf                []*jsonSchema              `json:"anyOf"`
+	OneOf                []*jsonSchema              `json:"oneOf"`
+	Ref                  string                     `json:"$ref"`
+	Defs                 map[string]json.RawMessage `json:"$defs"`
+	Definitions          map[string]json.RawMessage `json:"definitions"`
+}
+
+type property struct {
+	name   string
+	schema *jsonSchema
+}
+
+// properties are the properties of an object schema in the order they're
+// listed, which maps lose.
+type properties []property
+
+func (ps *properties) UnmarshalJSON(b []byte) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	if t, err := d.Token(); err != nil {
+		return err
+	} else if t != json.Delim('{') {
+		return errors.New("properties must be an object")
+	}
+
+	for d.More() {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+
+		var p property
+		p.name = t.(string)
+		if err := d.Decode(&p.schema); err != nil {
+			return err
+		}
+
+		*ps = append(*ps, p)
+	}
+
+	return nil
+}
+
+type schemaConverter struct {
+	root  jsonSchema
+	rules map[string]string
+	order []string
+}
+
+// add adds a rule named name, or with a number appended to name if it's
+// taken, and returns the name of the rule.
+func (c *schemaConverter) add(name, body string) string {
+	unique := name
+	for i := 1; ; i++ {
+		if existing, ok := c.rules[unique]; !ok || existing == body {
+			break
+		}
+
+		unique = name + strconv.Itoa(i)
+	}
+
+	if _, ok := c.rules[unique]; !ok {
+		c.order = append(c.order, unique)
+	}
+
+	c.rules[unique] = body
+	return unique
+}
+
+// primitive adds the rule of a primitive and those it depends on, returning
+// its name.
+func (c *schemaConverter) primitive(name string) string {
+	if _, ok := c.rules[name]; ok {
+		return name
+	}
+
+	for _, p := range primitives {
+		if p.name == name {
+			c.rules[name] = p.body
+			for _, ref := range ruleReference.FindAllString(literals.ReplaceAllString(p.body, " "), -1) {
+				if ref != name {
+					c.primitive(ref)
+				}
+			}
+
+			c.order = append(c.order, name)
+			break
+		}
+	}
+
+	return name
+}
+
+var (
+	// literals are the strings and classes of a rule, leaving its rule
+	// references
+	literals      = regexp.MustCompile(`"(\\.|[^"\\])*"|\[(\\.|[^\]\\])*\]`)
+	ruleReference = regexp.MustCompile(`\b[a-z]+\b`)
+
+	ruleNameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+)
+
+// literal returns the rule body matching the JSON value v exactly.
+func literal(v json.RawMessage) (string, error) {
+	var b bytes.Buffer
+	if err := json.Compact(&b, v); err != nil {
+		return "", err
+	}
+
+	return quote(b.String()) + " space", nil
+}
+
+// visit returns the body of the rule of schema, adding the rules it depends on
+// with names starting with name.
+func (c *schemaConverter) visit(schema jsonSchema, name string) (string, error) {
+	switch {
+	case schema.Ref != "":
+		return c.ref(schema.Ref)
+	case schema.Const != nil:
+		c.primitive("space")
+		return literal(schema.Const)
+	case schema.Enum != nil:
+		c.primitive("space")
+		alts := make([]string, len(schema.Enum))
+		for i, v := range schema.Enum {
+			var err error
+			if alts[i], err = literal(v); err != nil {
+				return "", err
+			}
+		}
+
+		return strings.Join(alts, " | "), nil
+	case schema.AnyOf != nil || schema.OneOf != nil:
+		var alts []string
+		for i, sub := range append(schema.AnyOf, schema.OneOf...) {
+			body, err := c.visit(*sub, name+"-"+strconv.Itoa(i))
+			if err != nil {
+				return "", err
+			}
+
+			alts = append(alts, c.add(name+"-"+strconv.Itoa(i), body))
+		}
+
+		return strings.Join(alts, " | "), nil
+	}
+
+	var types []string
+	if len(schema.Type) > 0 {
+		if err := json.Unmarshal(schema.Type, &types); err != nil {
+			var t string
+			if err := json.Unmarshal(schema.Type, &t); err != nil {
+				return "", fmt.Errorf("invalid JSON schema type %s", schema.Type)
+			}
+
+			types = []string{t}
+		}
+	} else {
+		switch {
+		case schema.Properties != nil:
+			types = []string{"object"}
+		case schema.Items != nil:
+			types = []string{"array"}
+		default:
+			return c.primitive("value"), nil
+		}
+	}
+
+	var alts []string
+	for _, t := range types {
+		var body string
+		var err error
+		switch t {
+		case "object":
+			body, err = c.object(schema, name)
+		case "array":
+			body, err = c.array(schema, name)
+		case "string":
+			body, err = c.string(schema)
+		case "number", "integer", "boolean", "null":
+			body = c.primitive(t)
+		default:
+			err = fmt.Errorf("unsupported JSON schema type %q", t)
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		alts = append(alts, body)
+	}
+
+	if len(alts) == 1 {
+		return alts[0], nil
+	}
+
+	for i := range alts {
+		alts[i] = c.add(name+"-"+types[i], alts[i])
+	}
+
+	return strings.Join(alts, " | "), nil
+}
+
+// ref returns the name of the rule of a definition, adding it if it hasn't
+// been already.
+func (c *schemaConverter) ref(ref string) (string, error) {
+	var defs map[string]json.RawMessage
+	var key string
+	switch {
+	case strings.HasPrefix(ref, "#/$defs/"):
+		defs, key = c.root.Defs, strings.TrimPrefix(ref, "#/$defs/")
+	case strings.HasPrefix(ref, "#/definitions/"):
+		defs, key = c.root.Definitions, strings.TrimPrefix(ref, "#/definitions/")
+	default:
+		return "", fmt.Errorf("unsupported JSON schema $ref %q", ref)
+	}
+
+	raw, ok := defs[key]
+	if !ok {
+		return "", fmt.Errorf("JSON schema $ref %q not found", ref)
+	}
+
+	name := "ref-" + ruleNameUnsafe.ReplaceAllString(key, "-")
+	if _, ok := c.rules[name]; ok {
+		return name, nil
+	}
+
+	// added before visiting so recursive definitions refer to it
+	c.rules[name] = ""
+	c.order = append(c.order, name)
+
+	var def jsonSchema
+	if err := json.Unmarshal(raw, &def); err != nil {
+		return "", err
+	}
+
+	body, err := c.visit(def, name)
+	if err != nil {
+		return "", err
+	}
+
+	c.rules[name] = body
+	return name, nil
+}
+
+// object returns the body of the rule of an object schema. Each property
+// which isn't required may be left out, so after the first property written,
+// the rest are each preceded by a comma.
+func (c *schemaConverter) object(schema jsonSchema, name string) (string, error) {
+	c.primitive("space")
+	if len(schema.Properties) == 0 {
+		if len(schema.AdditionalProperties) == 0 || string(schema.AdditionalProperties) == "true" {
+			return c.primitive("object"), nil
+		}
+
+		var additional jsonSchema
+		if err := json.Unmarshal(schema.AdditionalProperties, &additional); err != nil {
+			return `"{" space "}" space`, nil
+		}
+
+		body, err := c.visit(additional, name+"-value")
+		if err != nil {
+			return "", err
+		}
+
+		kv := c.add(name+"-kv", c.primitive("string")+` ":" space `+c.add(name+"-value", body))
+		return fmt.Sprintf(`"{" space (%s ("," space %s)*)? "}" space`, kv, kv), nil
+	}
+
+	kvs := make([]string, len(schema.Properties))
+	for i, p := range schema.Properties {
+		prop := name + "-" + ruleNameUnsafe.ReplaceAllString(p.name, "-")
+		body, err := c.visit(*p.schema, prop)
+		if err != nil {
+			return "", err
+		}
+
+		key, err := json.Marshal(p.name)
+		if err != nil {
+			return "", err
+		}
+
+		kvs[i] = c.add(prop+"-kv", quote(string(key))+` space ":" space `+c.add(prop, body))
+	}
+
+	// first is the rule of the properties from i on when none have been
+	// written, and rest is when some have
+	first, rest := "", ""
+	for i := len(kvs) - 1; i >= 0; i-- {
+		comma := `"," space ` + kvs[i]
+		if slices.Contains(schema.Required, schema.Properties[i].name) {
+			first, rest = kvs[i]+" "+rest, comma+" "+rest
+		} else {
+			restRule := c.add(fmt.Sprintf("%s-rest-%d", name, i), fmt.Sprintf("(%s)? %s", comma, rest))
+			if first == "" {
+				first = fmt.Sprintf("(%s)?", kvs[i])
+			} else {
+				first = fmt.Sprintf("(%s %s | %s)", kvs[i], rest, c.add(fmt.Sprintf("%s-first-%d", name, i+1), first))
+			}
+			rest = restRule
+		}
+	}
+
+	return fmt.Sprintf(`"{" space %s "}" space`, first), nil
+}
+
+// array returns the body of the rule of an array schema.
+func (c *schemaConverter) array(schema jsonSchema, name string) (string, error) {
+	c.primitive("space")
+	item := c.primitive("value")
+	if schema.Items != nil {
+		body, err := c.visit(*schema.Items, name+"-item")
+		if err != nil {
+			return "", err
+		}
+
+		item = c.add(name+"-item", body)
+	}
+
+	if schema.MaxItems != nil && *schema.MaxItems == 0 {
+		return `"[" space "]" space`, nil
+	}
+
+	repeat := fmt.Sprintf("{%d,}", max(schema.MinItems-1, 0))
+	if schema.MaxItems != nil {
+		repeat = fmt.Sprintf("{%d,%d}", max(schema.MinItems-1, 0), *schema.MaxItems-1)
+	}
+
+	items := fmt.Sprintf(`%s ("," space %s)%s`, item, item, repeat)
+	if schema.MinItems == 0 {
+		items = "(" + items + ")?"
+	}
+
+	return fmt.Sprintf(`"[" space %s "]" space`, items), nil
+}
+
+// string returns the body of the rule of a string schema.
+func (c *schemaConverter) string(schema jsonSchema) (string, error) {
+	c.primitive("space")
+	if schema.Pattern != "" {
+		gbnf, err := RegexpToGBNF(schema.Pattern)
+		if err != nil {
+			return "", fmt.Errorf("invalid JSON schema pattern: %w", err)
+		}
+
+		pattern := strings.TrimSuffix(strings.TrimPrefix(gbnf, "root ::= "), "\n")
+		return `"\"" ` + pattern + ` "\"" space`, nil
+	}
+
+	repeat := "*"
+	if schema.MaxLength != nil {
+		repeat = fmt.Sprintf("{%d,%d}", schema.MinLength, *schema.MaxLength)
+	} else if schema.MinLength > 0 {
+		repeat = fmt.Sprintf("{%d,}", schema.MinLength)
+	}
+
+	return `"\"" ` + c.primitive("char") + repeat + ` "\"" space`, nil
+}
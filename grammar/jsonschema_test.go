@@ -0,0 +1,127 @@
+package grammar
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFromJSONSchema(t *testing.T) {
+	cases := []struct {
+		name    string
+		schema  string
+		match   []string
+		invalid []string
+	}{
+		{
+			name:    "empty",
+			schema:  ``,
+			match:   []string{`{"a": [1, true, null]}`, `"text"`, `-1.5e3`},
+			invalid: []string{`{a: 1}`, `[1,]`},
+		},
+		{
+			name: "object",
+			schema: `{
+				"type": "object",
+				"properties": {
+					"name": {"type": "string", "minLength": 1},
+					"age": {"type": "integer"},
+					"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2}
+				},
+				"required": ["name"]
+			}`,
+			match: []string{
+				`{"name": "a"}`,
+				`{"name": "a", "age": 3}`,
+				`{"name": "a", "tags": ["x", "y"]}`,
+				"{\n  \"name\": \"a\",\n  \"age\": -3,\n  \"tags\": []\n}",
+			},
+			invalid: []string{
+				`{}`,
+				`{"name": ""}`,
+				`{"age": 3, "name": "a"}`,
+				`{"name": "a", "age": 1.5}`,
+				`{"name": "a", "tags": ["x", "y", "z"]}`,
+				`{"name": "a", "other": 1}`,
+			},
+		},
+		{
+			name:    "optional properties",
+			schema:  `{"properties": {"a": {"type": "null"}, "b": {"type": "null"}, "c": {"type": "null"}}}`,
+			match:   []string{`{}`, `{"a": null}`, `{"b": null, "c": null}`, `{"a": null, "c": null}`},
+			invalid: []string{`{, "b": null}`, `{"a": null,}`, `{"b": null "c": null}`},
+		},
+		{
+			name:    "enum and const",
+			schema:  `{"type": "object", "properties": {"a": {"enum": ["x", 1, null]}, "b": {"const": {"k": [true]}}}, "required": ["a", "b"]}`,
+			match:   []string{`{"a": "x", "b": {"k":[true]}}`, `{"a": 1, "b": {"k":[true]}}`, `{"a": null, "b": {"k":[true]}}`},
+			invalid: []string{`{"a": "y", "b": {"k":[true]}}`, `{"a": 1, "b": {"k":[false]}}`},
+		},
+		{
+			name:    "types",
+			schema:  `{"type": "array", "items": {"type": ["number", "boolean"]}, "minItems": 1}`,
+			match:   []string{`[1]`, `[true, 2.5]`},
+			invalid: []string{`[]`, `["1"]`},
+		},
+		{
+			name:    "pattern",
+			schema:  `{"type": "string", "pattern": "^[0-9]{3}-[a-z]+$"}`,
+			match:   []string{`"123-abc"`},
+			invalid: []string{`"12-abc"`, `"123-"`},
+		},
+		{
+			name: "recursive",
+			schema: `{
+				"$defs": {"node": {"type": "object", "properties": {"next": {"anyOf": [{"$ref": "#/$defs/node"}, {"type": "null"}]}}, "required": ["next"]}},
+				"$ref": "#/$defs/node"
+			}`,
+			match:   []string{`{"next": null}`, `{"next": {"next": {"next": null}}}`},
+			invalid: []string{`{"next": {}}`},
+		},
+		{
+			name:    "additional properties",
+			schema:  `{"type": "object", "additionalProperties": {"type": "integer"}}`,
+			match:   []string{`{}`, `{"a": 1, "b": 2}`},
+			invalid: []string{`{"a": "1"}`},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := FromJSONSchema([]byte(tt.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range tt.match {
+				if !g.Start().AdvanceString(s).Complete() {
+					t.Errorf("%s doesn't match", s)
+				}
+			}
+
+			for _, s := range tt.invalid {
+				if g.Start().AdvanceString(s).Complete() {
+					t.Errorf("%s matches", s)
+				}
+			}
+		})
+	}
+}
+
+func TestFromJSONSchemaErrors(t *testing.T) {
+	cases := []struct {
+		schema string
+		err    string
+	}{
+		{`{"type": "object"`, "invalid JSON schema"},
+		{`{"type": "date"}`, `unsupported JSON schema type "date"`},
+		{`{"$ref": "#/$defs/missing"}`, "not found"},
+		{`{"$ref": "https://example.com/schema"}`, "unsupported JSON schema $ref"},
+		{`{"type": "string", "pattern": "("}`, "invalid JSON schema pattern"},
+	}
+
+	for _, tt := range cases {
+		if _, err := FromJSONSchema([]byte(tt.schema)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.schema, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package grammar
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FromRegexp compiles a regular expression in the syntax of the regexp
+// package into a grammar. Generated text must match all of it, as if it
+// started with ^ and ended with $.
+func FromRegexp(expr string) (*Grammar, error) {
+	gbnf, err := RegexpToGBNF(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(gbnf)
+}
+
+// RegexpToGBNF converts a regular expression to GBNF, with a root rule which
+// matches the same text.
+func RegexpToGBNF(expr string) (string, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	if err := writeRegexp(&sb, re); err != nil {
+		return "", err
+	}
+
+	return "root ::= " + sb.String() + "\n", nil
+}
+
+func writeRegexp(sb *strings.Builder, re *syntax.Regexp) error {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+		// text is matched from beginning to end anyway
+		sb.WriteString(`""`)
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase == 0 {
+			sb.WriteString(quote(string(re.Rune)))
+			break
+		}
+
+		for i, r := range re.Rune {
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+
+			ranges := []rune{r, r}
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				ranges = append(ranges, f, f)
+			}
+
+			sb.WriteString(class(ranges, false))
+		}
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return fmt.Errorf("unsupported regular expression %s: matches nothing", re)
+		}
+
+		sb.WriteString(class(re.Rune, false))
+	case syntax.OpAnyCharNotNL:
+		sb.WriteString(`[^\n]`)
+	case syntax.OpAnyChar:
+		sb.WriteString(".")
+	case syntax.OpCapture:
+		return writeRegexp(sb, re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		sb.WriteByte('(')
+		if err := writeRegexp(sb, re.Sub[0]); err != nil {
+			return err
+		}
+		sb.WriteByte(')')
+
+		switch re.Op {
+		case syntax.OpStar:
+			sb.WriteByte('*')
+		case syntax.OpPlus:
+			sb.WriteByte('+')
+		case syntax.OpQuest:
+			sb.WriteByte('?')
+		case syntax.OpRepeat:
+			sb.WriteString("{" + strconv.Itoa(re.Min) + ",")
+			if re.Max >= 0 {
+				sb.WriteString(strconv.Itoa(re.Max))
+			}
+			sb.WriteString("}")
+		}
+	case syntax.OpConcat, syntax.OpAlternate:
+		sep := " "
+		if re.Op == syntax.OpAlternate {
+			sep = " | "
+		}
+
+		sb.WriteByte('(')
+		for i, sub := range re.Sub {
+			if i > 0 {
+				sb.WriteString(sep)
+			}
+
+			if err := writeRegexp(sb, sub); err != nil {
+				return err
+			}
+		}
+		sb.WriteByte(')')
+	default:
+		return fmt.Errorf("unsupported regular expression %s", re)
+	}
+
+	return nil
+}
+
+// quote returns s as a GBNF string.
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		sb.WriteString(escape(r, `"\`))
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// class returns a GBNF character class of ranges, pairs of inclusive bounds.
+func class(ranges []rune, negate bool) string {
+	var sb strings.Builder
+	sb.WriteByte('[')
+	if negate {
+		sb.WriteByte('^')
+	}
+
+	for i := 0; i < len(ranges); i += 2 {
+		sb.WriteString(escape(ranges[i], `]\-^`))
+		if ranges[i+1] != ranges[i] {
+			sb.WriteByte('-')
+			sb.WriteString(escape(ranges[i+1], `]\-^`))
+		}
+	}
+
+	sb.WriteByte(']')
+	return sb.String()
+}
+
+// escape returns r escaped if it's special, meaning it's in special or isn't
+// printable.
+func escape(r rune, special string) string {
+	switch {
+	case r == '\n':
+		return `\n`
+	case r == '\r':
+		return `\r`
+	case r == '\t':
+		return `\t`
+	case strings.ContainsRune(special, r):
+		return `\` + string(r)
+	case r < 0x20 || r == 0x7f:
+		return fmt.Sprintf(`\x%02X`, r)
+	case !unicode.IsPrint(r) || r == utf8.RuneError:
+		if r > 0xffff {
+			return fmt.Sprintf(`\U%08X`, r)
+		}
+
+		return fmt.Sprintf(`\u%04X`, r)
+	default:
+		return string(r)
+	}
+}
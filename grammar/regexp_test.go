@@ -0,0 +1,64 @@
+package grammar
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFromRegexp(t *testing.T) {
+	cases := []struct {
+		expr    string
+		match   []string
+		invalid []string
+	}{
+		{`\d{3}-\d{4}`, []string{"555-1234"}, []string{"555-123", "5551234", "555-12345"}},
+		{`^(?i)hello( world)?$`, []string{"hello", "HeLLo World"}, []string{"hello ", "hi"}},
+		{`[a-c]+|x.z`, []string{"abcba", "x-z", "xéz"}, []string{"", "abx", "x\nz"}},
+		{`(?s)a.b`, []string{"a\nb"}, nil},
+		{`"[^"\\]*"`, []string{`""`, `"a]-^b"`}, []string{`"a"b"`, `"\"`}},
+		{`a{2,}b?`, []string{"aa", "aaaab"}, []string{"a", "ab"}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.expr, func(t *testing.T) {
+			g, err := FromRegexp(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range tt.match {
+				if !g.Start().AdvanceString(s).Complete() {
+					t.Errorf("%q doesn't match", s)
+				}
+			}
+
+			for _, s := range tt.invalid {
+				if g.Start().AdvanceString(s).Complete() {
+					t.Errorf("%q matches", s)
+				}
+			}
+		})
+	}
+}
+
+func TestFromRegexpErrors(t *testing.T) {
+	for _, expr := range []string{`a(`, `\bword\b`, `[^\x00-\x{10FFFF}]`} {
+		if _, err := FromRegexp(expr); err == nil {
+			t.Errorf("%q: expected error", expr)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	s := "\"\\]-^\n\x01\u200b😀é"
+	g := MustParse("root ::= " + quote(s) + " " + class([]rune{'-', '-', ']', '^', 0x200b, 0x200b}, false))
+	for _, r := range "-]^\u200b" {
+		if !g.Start().AdvanceString(s + string(r)).Complete() {
+			t.Errorf("%q doesn't match", s+string(r))
+		}
+	}
+
+	if !strings.Contains(quote(s), `\x01`) || !strings.Contains(quote(s), `\u200B`) {
+		t.Errorf("expected control and format characters to be escaped, got %s", quote(s))
+	}
+}
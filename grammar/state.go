@@ -0,0 +1,136 @@
+package grammar
+
+// frame is a position in an alternative of a rule, which is never at its end,
+// and the position to continue from after the rule matches.
+type frame struct {
+	rule, alt, pos int
+	next           *frame
+}
+
+// State is how far text has advanced through a grammar: the positions it
+// could be at, as stacks of frames whose tops are each at a character. States
+// are immutable, so any number of texts can be advanced from the same state.
+type State struct {
+	g      *Grammar
+	stacks []*frame
+
+	// complete is whether the text matches the grammar, rather than only
+	// starting text which does
+	complete bool
+}
+
+// Start returns the state of empty text.
+func (g *Grammar) Start() State {
+	s := State{g: g}
+	for alt := range g.rules[g.root].alts {
+		s.expand(g.frame(g.root, alt, 0, nil))
+	}
+
+	return s
+}
+
+// frame returns the frame at pos in an alternative of rule, or next if pos is
+// its end.
+func (g *Grammar) frame(rule, alt, pos int, next *frame) *frame {
+	if pos >= len(g.rules[rule].alts[alt]) {
+		return next
+	}
+
+	return &frame{rule: rule, alt: alt, pos: pos, next: next}
+}
+
+func (g *Grammar) element(f *frame) element {
+	return g.rules[f.rule].alts[f.alt][f.pos]
+}
+
+// expand adds the stacks which f can continue as, expanding the rules at the
+// top of it until characters are.
+func (s *State) expand(f *frame) {
+	if f == nil {
+		s.complete = true
+		return
+	}
+
+	e := s.g.element(f)
+	if e.rule < 0 {
+		for _, stack := range s.stacks {
+			if *stack == *f {
+				return
+			}
+		}
+
+		s.stacks = append(s.stacks, f)
+		return
+	}
+
+	next := s.g.frame(f.rule, f.alt, f.pos+1, f.next)
+	for alt := range s.g.rules[e.rule].alts {
+		s.expand(s.g.frame(e.rule, alt, 0, next))
+	}
+}
+
+// Advance returns the state after r follows the text of s. If r can't follow
+// it, the state is dead.
+func (s State) Advance(r rune) State {
+	next := State{g: s.g, stacks: make([]*frame, 0, len(s.stacks))}
+	for _, stack := range s.stacks {
+		if s.g.element(stack).matches(r) {
+			next.expand(s.g.frame(stack.rule, stack.alt, stack.pos+1, stack.next))
+		}
+	}
+
+	return next
+}
+
+// allows returns whether any rune from lo to hi might follow the text of s.
+func (s State) allows(lo, hi rune) bool {
+	for _, stack := range s.stacks {
+		e := s.g.element(stack)
+
+		// negated classes are only known not to allow any of the runes if
+		// a single range covers them all
+		covered := false
+		for i := 0; i < len(e.ranges) && !covered; i += 2 {
+			if e.negate {
+				covered = e.ranges[i] <= lo && e.ranges[i+1] >= hi
+			} else {
+				covered = e.ranges[i] <= hi && e.ranges[i+1] >= lo
+			}
+		}
+
+		if covered != e.negate {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AdvanceString returns the state after each rune of text follows s.
+func (s State) AdvanceString(text string) State {
+	for _, r := range text {
+		if s.Dead() {
+			break
+		}
+
+		s = s.Advance(r)
+	}
+
+	return s
+}
+
+// Dead returns whether no text can follow s, including no text at all.
+func (s State) Dead() bool {
+	return len(s.stacks) == 0 && !s.complete
+}
+
+// Complete returns whether the text of s matches the grammar.
+func (s State) Complete() bool {
+	return s.complete
+}
+
+// Final returns whether the text of s matches the grammar and no more text
+// can follow it.
+func (s State) Final() bool {
+	return s.complete && len(s.stacks) == 0
+}
@@ -0,0 +1,233 @@
+package grammar
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Vocabulary is the text of the tokens of a model, sorted so the tokens which
+// text can continue as are found together. It's safe to use from multiple
+// goroutines and is typically built once per model.
+type Vocabulary struct {
+	pieces []string
+
+	// sorted are the tokens with text, sorted by it
+	sorted []int32
+
+	eog []int32
+}
+
+// NewVocabulary returns the vocabulary of tokens where pieces[i] is the text
+// of token i, as raw bytes which may be part of a UTF-8 character, and eog
+// are the tokens which end generation. Tokens without text, such as control
+// tokens, are never allowed.
+func NewVocabulary(pieces []string, eog []int32) *Vocabulary {
+	v := &Vocabulary{pieces: pieces, eog: eog}
+	for i, piece := range pieces {
+		if piece != "" && !slices.Contains(eog, int32(i)) {
+			v.sorted = append(v.sorted, int32(i))
+		}
+	}
+
+	slices.SortFunc(v.sorted, func(a, b int32) int {
+		return strings.Compare(pieces[a], pieces[b])
+	})
+
+	return v
+}
+
+// Size returns the number of tokens in v.
+func (v *Vocabulary) Size() int {
+	return len(v.pieces)
+}
+
+// Matcher tracks the tokens generated against a grammar. It isn't safe to use
+// from multiple goroutines, but matchers of the same grammar and vocabulary
+// are independent.
+type Matcher struct {
+	vocab *Vocabulary
+	state State
+
+	// partial is the start of a UTF-8 character which the tokens accepted
+	// so far end with
+	partial []byte
+
+	done bool
+}
+
+// ErrRejected is returned when accepting a token which the grammar doesn't
+// allow.
+var ErrRejected = errors.New("grammar: token rejected")
+
+// NewMatcher returns a matcher of the tokens of vocab against g, starting
+// with no tokens.
+func NewMatcher(g *Grammar, vocab *Vocabulary) *Matcher {
+	return &Matcher{vocab: vocab, state: g.Start()}
+}
+
+// advance returns the state after the bytes of piece follow state, where
+// partial is the start of a character before them. The result is dead if
+// piece can't follow.
+func advance(state State, partial []byte, piece string) (State, []byte) {
+	for i := 0; i < len(piece) && !state.Dead(); i++ {
+		state, partial = advanceByte(state, partial, piece[i])
+	}
+
+	return state, partial
+}
+
+// advanceByte is like advance for a single byte, which completes a character
+// or is part of one.
+func advanceByte(state State, partial []byte, c byte) (State, []byte) {
+	if len(partial) == 0 && c < utf8.RuneSelf {
+		return state.Advance(rune(c)), partial
+	}
+
+	partial = append(partial, c)
+	if !utf8.FullRune(partial) {
+		lo, hi, ok := runeRange(partial)
+		if !ok || !state.allows(lo, hi) {
+			return State{}, nil
+		}
+
+		return state, partial
+	}
+
+	r, n := utf8.DecodeRune(partial)
+	if r == utf8.RuneError && n == 1 {
+		return State{}, nil
+	}
+
+	return state.Advance(r), partial[:0]
+}
+
+// runeRange returns the range of the runes which the start of a UTF-8
+// character, partial, can be completed as.
+func runeRange(partial []byte) (lo, hi rune, ok bool) {
+	var n int
+	switch c := partial[0]; {
+	case c&0xe0 == 0xc0:
+		n = 2
+	case c&0xf0 == 0xe0:
+		n = 3
+	case c&0xf8 == 0xf0:
+		n = 4
+	default:
+		return 0, 0, false
+	}
+
+	r := rune(partial[0] & (0x7f >> n))
+	for _, c := range partial[1:] {
+		if c&0xc0 != 0x80 {
+			return 0, 0, false
+		}
+
+		r = r<<6 | rune(c&0x3f)
+	}
+
+	bits := 6 * (n - len(partial))
+	return r << bits, r<<bits | (1<<bits - 1), true
+}
+
+// eog returns whether id ends generation.
+func (m *Matcher) eog(id int32) bool {
+	return slices.Contains(m.vocab.eog, id)
+}
+
+// Allowed returns whether the grammar allows id to be the next token.
+func (m *Matcher) Allowed(id int32) bool {
+	if m.done || id < 0 || int(id) >= len(m.vocab.pieces) {
+		return false
+	}
+
+	if m.eog(id) {
+		return m.state.Complete() && len(m.partial) == 0
+	}
+
+	piece := m.vocab.pieces[id]
+	if piece == "" {
+		return false
+	}
+
+	state, partial := advance(m.state, slices.Clone(m.partial), piece)
+	return !state.Dead() && (len(state.stacks) > 0 || len(partial) == 0)
+}
+
+// Mask sets allowed[id] to whether the grammar allows each token to be next.
+// allowed may be longer than the vocabulary, in which case the tokens past
+// its end aren't allowed.
+func (m *Matcher) Mask(allowed []bool) {
+	clear(allowed)
+	if m.done {
+		return
+	}
+
+	if m.state.Complete() && len(m.partial) == 0 {
+		for _, id := range m.vocab.eog {
+			if int(id) < len(allowed) {
+				allowed[id] = true
+			}
+		}
+	}
+
+	m.mask(allowed, 0, len(m.vocab.sorted), 0, m.state, slices.Clone(m.partial))
+}
+
+// mask sets whether the tokens sorted[lo:hi] are allowed, which all start with
+// the same depth bytes. state and partial are the state after those bytes.
+func (m *Matcher) mask(allowed []bool, lo, hi, depth int, state State, partial []byte) {
+	sorted, pieces := m.vocab.sorted, m.vocab.pieces
+
+	// tokens which end here come first
+	for ; lo < hi && len(pieces[sorted[lo]]) == depth; lo++ {
+		if id := sorted[lo]; int(id) < len(allowed) {
+			allowed[id] = len(state.stacks) > 0 || len(partial) == 0
+		}
+	}
+
+	for lo < hi {
+		c := pieces[sorted[lo]][depth]
+		end := lo + 1
+		for end < hi && pieces[sorted[end]][depth] == c {
+			end++
+		}
+
+		if next, partial := advanceByte(state, slices.Clone(partial), c); !next.Dead() {
+			m.mask(allowed, lo, end, depth+1, next, partial)
+		}
+
+		lo = end
+	}
+}
+
+// Accept advances the matcher past id, returning ErrRejected if the grammar
+// doesn't allow it.
+func (m *Matcher) Accept(id int32) error {
+	if !m.Allowed(id) {
+		return fmt.Errorf("%w: %d", ErrRejected, id)
+	}
+
+	if m.eog(id) {
+		m.done = true
+		return nil
+	}
+
+	m.state, m.partial = advance(m.state, m.partial, m.vocab.pieces[id])
+	return nil
+}
+
+// Done returns whether generation has ended, either because a token which
+// ends generation was accepted or because the text so far matches the grammar
+// and nothing more can follow it.
+func (m *Matcher) Done() bool {
+	return m.done || m.state.Final() && len(m.partial) == 0
+}
+
+// Complete returns whether the text of the tokens accepted so far matches the
+// grammar.
+func (m *Matcher) Complete() bool {
+	return m.state.Complete() && len(m.partial) == 0
+}
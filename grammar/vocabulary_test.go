@@ -0,0 +1,126 @@
+package grammar
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// testVocabulary returns a vocabulary with token 0 ending generation and
+// token 1 a control token.
+func testVocabulary() *Vocabulary {
+	return NewVocabulary([]string{
+		"", "", "{", "}", `"`, "a", "ab", `"a`, `"}`, " ", ":", "1", "12", "é",
+		"\xc3", "\xa9", "\xff", `{"`,
+	}, []int32{0})
+}
+
+func allowed(m *Matcher, vocab *Vocabulary) []int32 {
+	mask := make([]bool, vocab.Size()+2)
+	m.Mask(mask)
+
+	var ids []int32
+	for id, ok := range mask {
+		if ok {
+			ids = append(ids, int32(id))
+		}
+
+		if ok != m.Allowed(int32(id)) {
+			panic("mask and allowed disagree")
+		}
+	}
+
+	return ids
+}
+
+func TestMatcher(t *testing.T) {
+	vocab := testVocabulary()
+	g := MustParse(`root ::= "{" "\"" [a-zé]+ "\"" ":" " "? [0-9]+ "}"`)
+	m := NewMatcher(g, vocab)
+
+	steps := []struct {
+		accept  int32
+		allowed []int32
+	}{
+		{2, []int32{2, 17}},
+		{4, []int32{4, 7}},
+		{14, []int32{5, 6, 13, 14}},
+		// the second byte of é completes it
+		{15, []int32{15}},
+		{6, []int32{4, 5, 6, 13, 14}},
+		{4, []int32{4, 5, 6, 13, 14}},
+		{10, []int32{10}},
+		{12, []int32{9, 11, 12}},
+		{3, []int32{3, 11, 12}},
+		{0, []int32{0}},
+	}
+
+	for i, step := range steps {
+		if got := allowed(m, vocab); !slices.Equal(got, step.allowed) {
+			t.Fatalf("step %d: expected %v allowed, got %v", i, step.allowed, got)
+		}
+
+		if err := m.Accept(step.accept); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+
+	if !m.Done() {
+		t.Error("expected done")
+	}
+
+	if err := m.Accept(3); !errors.Is(err, ErrRejected) {
+		t.Errorf("expected ErrRejected, got %v", err)
+	}
+}
+
+func TestMatcherReject(t *testing.T) {
+	vocab := testVocabulary()
+	m := NewMatcher(MustParse(`root ::= "a" | "ab"`), vocab)
+
+	for _, id := range []int32{0, 1, 2, 16, -1, 100} {
+		if err := m.Accept(id); !errors.Is(err, ErrRejected) {
+			t.Errorf("token %d: expected ErrRejected, got %v", id, err)
+		}
+	}
+
+	// rejected tokens don't change the state
+	if err := m.Accept(5); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Done() || !m.Complete() {
+		t.Errorf("after a: done %v, complete %v", m.Done(), m.Complete())
+	}
+}
+
+func BenchmarkMatcherMask(b *testing.B) {
+	// a vocabulary of every string of up to three letters and digits
+	pieces := []string{""}
+	var add func(string, int)
+	add = func(prefix string, n int) {
+		if n == 0 {
+			return
+		}
+
+		for _, c := range "abcdefghijklmnopqrstuvwxyz0123456789 \"{}:," {
+			pieces = append(pieces, prefix+string(c))
+			add(prefix+string(c), n-1)
+		}
+	}
+	add("", 3)
+
+	vocab := NewVocabulary(pieces, []int32{0})
+	m := NewMatcher(MustParse(jsonGBNF), vocab)
+	for _, c := range `{"abc": "def` {
+		if err := m.Accept(int32(slices.Index(pieces, string(c)))); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	mask := make([]bool, len(pieces))
+	b.ResetTimer()
+	for b.Loop() {
+		m.Mask(mask)
+	}
+}
@@ -0,0 +1,262 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ollama/ollama/api"
+)
+
+// tinyModelURL is a llama model of 15M parameters trained on short stories,
+// small enough to download for each run of the tests and to run on a CPU.
+const tinyModelURL = "https://huggingface.co/ggml-org/models/resolve/main/tinyllamas/stories15M-q4_0.gguf"
+
+// tinyModel returns the path of the tiny model, which is OLLAMA_TEST_TINY_MODEL
+// if it's set, or else the model downloaded from tinyModelURL to the user's
+// cache directory.
+func tinyModel(t *testing.T) string {
+	t.Helper()
+
+	if path := os.Getenv("OLLAMA_TEST_TINY_MODEL"); path != "" {
+		return path
+	}
+
+	dir, err := os.UserCacheDir()
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, "ollama", "integration", filepath.Base(tinyModelURL))
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+
+	resp, err := http.Get(tinyModelURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode, "download %s", tinyModelURL)
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-partial-*")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	_, err = io.Copy(f, resp.Body)
+	require.NoError(t, errors.Join(err, f.Close()))
+	require.NoError(t, os.Rename(f.Name(), path))
+	return path
+}
+
+// tinyRegistry serves a model as library/tiny:latest, so that it can be
+// pulled like any other.
+type tinyRegistry struct {
+	manifest []byte
+	blobs    map[string][]byte
+
+	mu     sync.Mutex
+	served map[string]bool
+}
+
+func newTinyRegistry(t *testing.T, model []byte) *tinyRegistry {
+	t.Helper()
+
+	digest := func(b []byte) string {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+	}
+
+	config, err := json.Marshal(map[string]any{
+		"model_format": "gguf",
+		"model_family": "llama",
+		"model_type":   "15M",
+		"file_type":    "Q4_0",
+		"rootfs":       map[string]any{"type": "layers", "diff_ids": []string{digest(model)}},
+	})
+	require.NoError(t, err)
+
+	manifest, err := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.docker.distribution.manifest.v2+json",
+		"config":        map[string]any{"mediaType": "application/vnd.docker.container.image.v1+json", "digest": digest(config), "size": len(config)},
+		"layers": []map[string]any{
+			{"mediaType": "application/vnd.ollama.image.model", "digest": digest(model), "size": len(model)},
+		},
+	})
+	require.NoError(t, err)
+
+	return &tinyRegistry{
+		manifest: manifest,
+		blobs:    map[string][]byte{digest(config): config, digest(model): model},
+		served:   make(map[string]bool),
+	}
+}
+
+func (r *tinyRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.served[req.URL.Path] = true
+	r.mu.Unlock()
+
+	if req.URL.Path == "/v2/library/tiny/manifests/latest" {
+		w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+		w.Header().Set("Docker-Content-Digest", fmt.Sprintf("sha256:%x", sha256.Sum256(r.manifest)))
+		w.Write(r.manifest)
+		return
+	}
+
+	if digest, ok := strings.CutPrefix(req.URL.Path, "/v2/library/tiny/blobs/"); ok {
+		if blob, ok := r.blobs[digest]; ok {
+			http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(blob))
+			return
+		}
+	}
+
+	http.NotFound(w, req)
+}
+
+func (r *tinyRegistry) wasServed(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.served[path]
+}
+
+// TestModelLifecycle runs a real model through the life of any other: it's
+// pulled, a model is created from it, both are run, and then deleted.
+func TestModelLifecycle(t *testing.T) {
+	if os.Getenv("OLLAMA_TEST_EXISTING") != "" {
+		t.Skip("TestModelLifecycle pulls from a registry on this host, so it only works for local testing, skipping")
+	}
+
+	model, err := os.ReadFile(tinyModel(t))
+	require.NoError(t, err)
+
+	registry := newTinyRegistry(t, model)
+	srv := httptest.NewServer(registry)
+	defer srv.Close()
+
+	// models are kept apart from those of the user
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	client, _, cleanup := InitServerConnection(ctx, t)
+	defer cleanup()
+
+	pulled := strings.TrimPrefix(srv.URL, "http://") + "/library/tiny:latest"
+	created := "integration-tiny-story"
+
+	t.Run("pull", func(t *testing.T) {
+		var statuses []string
+		err := client.Pull(ctx, &api.PullRequest{Model: pulled, Insecure: true}, func(resp api.ProgressResponse) error {
+			statuses = append(statuses, resp.Status)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Contains(t, statuses, "success")
+
+		for digest := range registry.blobs {
+			require.True(t, registry.wasServed("/v2/library/tiny/blobs/"+digest), "blob %s wasn't pulled", digest)
+		}
+
+		show, err := client.Show(ctx, &api.ShowRequest{Model: pulled})
+		require.NoError(t, err)
+		require.Equal(t, "llama", show.Details.Family)
+		require.Equal(t, "Q4_0", show.Details.QuantizationLevel)
+	})
+
+	t.Run("create", func(t *testing.T) {
+		err := client.Create(ctx, &api.CreateRequest{
+			Model:    created,
+			From:     pulled,
+			Template: "{{ if .System }}{{ .System }} {{ end }}{{ .Prompt }}",
+			System:   "Once upon a time,",
+			Parameters: map[string]any{
+				"temperature": 0,
+				"seed":        42,
+				"num_predict": 32,
+			},
+		}, func(api.ProgressResponse) error { return nil })
+		require.NoError(t, err)
+
+		show, err := client.Show(ctx, &api.ShowRequest{Model: created})
+		require.NoError(t, err)
+		require.Equal(t, "Once upon a time,", show.System)
+		require.Contains(t, show.Parameters, "num_predict")
+	})
+
+	t.Run("generate", func(t *testing.T) {
+		// the model only tells stories, which are mostly about little
+		// children and animals
+		DoGenerate(ctx, t, client, api.GenerateRequest{
+			Model:  created,
+			Prompt: "there was a little",
+		}, []string{"girl", "boy", "dog", "cat", "bird", "named", "lily", "tim"}, time.Minute, 30*time.Second)
+	})
+
+	t.Run("chat", func(t *testing.T) {
+		var content strings.Builder
+		var done bool
+		err := client.Chat(ctx, &api.ChatRequest{
+			Model:    created,
+			Messages: []api.Message{{Role: "user", Content: "there was a little"}},
+		}, func(resp api.ChatResponse) error {
+			content.WriteString(resp.Message.Content)
+			done = resp.Done
+			return nil
+		})
+		require.NoError(t, err)
+		require.True(t, done)
+		require.NotEmpty(t, strings.TrimSpace(content.String()))
+	})
+
+	t.Run("embed", func(t *testing.T) {
+		resp, err := client.Embed(ctx, &api.EmbedRequest{
+			Model: created,
+			Input: []string{"The cat sat in the sun.", "The dog ran in the park."},
+		})
+		require.NoError(t, err)
+		require.Len(t, resp.Embeddings, 2)
+
+		for _, embedding := range resp.Embeddings {
+			require.Len(t, embedding, len(resp.Embeddings[0]))
+			require.NotEmpty(t, embedding)
+
+			var norm float64
+			for _, v := range embedding {
+				norm += float64(v * v)
+			}
+			require.InDelta(t, 1, math.Sqrt(norm), 1e-3, "embeddings are normalized")
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		for _, name := range []string{created, pulled} {
+			require.NoError(t, client.Delete(ctx, &api.DeleteRequest{Model: name}))
+
+			_, err := client.Show(ctx, &api.ShowRequest{Model: name})
+			var statusError api.StatusError
+			require.ErrorAs(t, err, &statusError)
+			require.Equal(t, http.StatusNotFound, statusError.StatusCode)
+		}
+
+		list, err := client.List(ctx)
+		require.NoError(t, err)
+		require.Empty(t, list.Models)
+	})
+}
@@ -2,6 +2,8 @@ package kvcache
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/ollama/ollama/ml"
 	"github.com/ollama/ollama/model/input"
@@ -12,6 +14,72 @@ var (
 	ErrNotSupported = errors.New("model does not support operation")
 )
 
+// ParseDType returns the data type of a cache of the quantization type s,
+// which may be f16, q8_0 or q4_0. The default is f16.
+func ParseDType(s string) (ml.DType, error) {
+	switch strings.ToLower(s) {
+	case "", "f16":
+		return ml.DTypeF16, nil
+	case "q8_0":
+		return ml.DTypeQ80, nil
+	case "q4_0":
+		return ml.DTypeQ40, nil
+	default:
+		return ml.DTypeOther, fmt.Errorf("unsupported kv cache type %q", s)
+	}
+}
+
+// PagedCache is implemented by caches which can allocate their storage in
+// fixed-size blocks. Each block holds the inputs of one sequence, which takes
+// another free block whenever its last one is full, so the inputs of a batch
+// don't need to be stored together and sequences share the cache as they grow.
+type PagedCache interface {
+	// SetBlockSize sets the number of inputs in each block. It must be called
+	// before Init.
+	SetBlockSize(size int)
+}
+
+// EncoderStorage is where and how a cache keeps the outputs of an encoder,
+// such as the cross attention states of an image, between forward passes.
+type EncoderStorage int
+
+const (
+	// EncoderStorageDevice keeps outputs as they were computed on the device
+	// of their layer.
+	EncoderStorageDevice EncoderStorage = iota
+
+	// EncoderStorageF16 keeps outputs on the device of their layer at half
+	// precision.
+	EncoderStorageF16
+
+	// EncoderStorageHost keeps outputs in host memory and copies them to the
+	// device of their layer each time they are used, trading the bandwidth
+	// of the copies for device memory.
+	EncoderStorageHost
+)
+
+// ParseEncoderStorage returns the encoder storage named s, which may be
+// device, f16 or host. The default is device.
+func ParseEncoderStorage(s string) (EncoderStorage, error) {
+	switch strings.ToLower(s) {
+	case "", "device":
+		return EncoderStorageDevice, nil
+	case "f16":
+		return EncoderStorageF16, nil
+	case "host":
+		return EncoderStorageHost, nil
+	default:
+		return 0, fmt.Errorf("invalid encoder cache %q: must be device, f16 or host", s)
+	}
+}
+
+// EncoderStorageCache is implemented by caches which store encoder outputs.
+type EncoderStorageCache interface {
+	// SetEncoderStorage sets how encoder outputs are stored. It must be
+	// called before Init.
+	SetEncoderStorage(storage EncoderStorage)
+}
+
 type Cache interface {
 	// ** used by model implementations **
 
@@ -0,0 +1,29 @@
+package kvcache
+
+import (
+	"testing"
+
+	"github.com/ollama/ollama/ml"
+)
+
+func TestParseDType(t *testing.T) {
+	cases := map[string]ml.DType{
+		"":     ml.DTypeF16,
+		"f16":  ml.DTypeF16,
+		"q8_0": ml.DTypeQ80,
+		"Q4_0": ml.DTypeQ40,
+	}
+
+	for s, want := range cases {
+		got, err := ParseDType(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+		} else if got != want {
+			t.Errorf("%q: want %v, got %v", s, want, got)
+		}
+	}
+
+	if _, err := ParseDType("q2_k"); err == nil {
+		t.Error("expected error for unsupported type")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"math"
 	"slices"
 
@@ -33,8 +34,8 @@ type Causal struct {
 	// the active layer for Get and Put
 	curLayer int
 
-	// starting location for data storage for this batch
-	curLoc int
+	// locations for data storage of each input in this batch
+	curLocs []int
 
 	// size of the current batch
 	curBatchSize int
@@ -60,6 +61,20 @@ type Causal struct {
 	// maps from sequence to the range of locations where it is stored in the cache
 	cellRanges map[int]cellRange
 
+	// ** paging **
+
+	// blockSize is the number of cells in each block if the cache is paged,
+	// or 0 if it isn't
+	blockSize int
+
+	// blocks is the sequence which adds inputs to each block, or -1 if none
+	// does. A block is free once all of its cells are empty, whatever its
+	// sequence.
+	blocks []int
+
+	// tails maps from sequence to the location of the last input it added
+	tails map[int]int
+
 	// ** cache data storage **
 
 	shiftFn      shiftFn
@@ -124,6 +139,19 @@ func (c *Causal) Init(backend ml.Backend, dtype ml.DType, capacity int32) {
 	c.cells = make([]cacheCell, c.Capacity)
 	c.cellRanges = make(map[int]cellRange)
 	c.backend = backend
+
+	if c.blockSize > 0 {
+		c.blocks = make([]int, int(c.Capacity)/c.blockSize)
+		c.resetBlocks()
+	}
+}
+
+func (c *Causal) SetBlockSize(size int) {
+	if c.cells != nil {
+		panic("block size cannot be changed after the cache is initialized")
+	}
+
+	c.blockSize = size
 }
 
 func (c *Causal) SetConfig(config ml.CacheConfig) {
@@ -147,10 +175,10 @@ func (c *Causal) StartForward(ctx ml.Context, opts input.Options) error {
 	c.opts.Except = nil
 
 	var err error
-	c.curLoc, err = c.findStartLoc()
+	c.curLocs, err = c.findLocs()
 	if errors.Is(err, ErrKvCacheFull) {
 		c.defrag()
-		c.curLoc, err = c.findStartLoc()
+		c.curLocs, err = c.findLocs()
 	}
 	if err != nil {
 		return err
@@ -159,23 +187,24 @@ func (c *Causal) StartForward(ctx ml.Context, opts input.Options) error {
 	c.curCellRange = newRange()
 	for i, pos := range opts.Positions {
 		seq := opts.Sequences[i]
+		loc := c.curLocs[i]
 
-		c.cells[c.curLoc+i] = cacheCell{pos: pos, sequences: []int{seq}}
+		c.cells[loc] = cacheCell{pos: pos, sequences: []int{seq}}
 
 		seqRange, ok := c.cellRanges[seq]
 		if !ok {
 			seqRange = newRange()
 		}
 
-		if c.curLoc+i > seqRange.max {
-			seqRange.max = c.curLoc + i
+		if loc > seqRange.max {
+			seqRange.max = loc
 		}
 		if seqRange.max > c.curCellRange.max {
 			c.curCellRange.max = seqRange.max
 		}
 
-		if c.curLoc+i < seqRange.min {
-			seqRange.min = c.curLoc + i
+		if loc < seqRange.min {
+			seqRange.min = loc
 		}
 		if seqRange.min < c.curCellRange.min {
 			c.curCellRange.min = seqRange.min
@@ -195,6 +224,101 @@ func newRange() cellRange {
 	}
 }
 
+// findLocs returns the locations to store the inputs of the batch in
+func (c *Causal) findLocs() ([]int, error) {
+	if c.blockSize > 0 {
+		return c.findPagedLocs()
+	}
+
+	start, err := c.findStartLoc()
+	if err != nil {
+		return nil, err
+	}
+
+	locs := make([]int, c.curBatchSize)
+	for i := range locs {
+		locs[i] = start + i
+	}
+
+	return locs, nil
+}
+
+// findPagedLocs finds locations for the batch in a paged cache. Each input
+// goes after the last one of its sequence if there is room left in that
+// block, or at the start of the first free block otherwise.
+func (c *Causal) findPagedLocs() ([]int, error) {
+	locs := make([]int, c.curBatchSize)
+	tails := maps.Clone(c.tails)
+	taken := make(map[int]int)
+
+	for i, seq := range c.curSequences {
+		if last, ok := tails[seq]; ok && (last+1)%c.blockSize != 0 {
+			block := last / c.blockSize
+
+			owner, ok := taken[block]
+			if !ok {
+				owner = c.blocks[block]
+			}
+
+			if owner == seq && len(c.cells[last+1].sequences) == 0 {
+				locs[i] = last + 1
+				tails[seq] = last + 1
+				continue
+			}
+		}
+
+		block := c.findFreeBlock(taken)
+		if block < 0 {
+			return nil, fmt.Errorf("%w (length: %v)", ErrKvCacheFull, c.Capacity)
+		}
+
+		taken[block] = seq
+		locs[i] = block * c.blockSize
+		tails[seq] = locs[i]
+	}
+
+	for block, seq := range taken {
+		c.blocks[block] = seq
+	}
+	c.tails = tails
+
+	return locs, nil
+}
+
+// findFreeBlock returns the first block with only empty cells which hasn't
+// been taken, or -1 if there isn't one
+func (c *Causal) findFreeBlock(taken map[int]int) int {
+	for block := range c.blocks {
+		if _, ok := taken[block]; ok {
+			continue
+		}
+
+		free := true
+		for _, cell := range c.cells[block*c.blockSize : (block+1)*c.blockSize] {
+			if len(cell.sequences) != 0 {
+				free = false
+				break
+			}
+		}
+
+		if free {
+			return block
+		}
+	}
+
+	return -1
+}
+
+// resetBlocks forgets which sequence adds inputs to each block, so each takes
+// a free block for its next input
+func (c *Causal) resetBlocks() {
+	for i := range c.blocks {
+		c.blocks[i] = -1
+	}
+
+	c.tails = make(map[int]int)
+}
+
 // Find the first contiguous block of at least curBatchSize
 func (c *Causal) findStartLoc() (int, error) {
 	var start, count int
@@ -399,6 +523,11 @@ func (c *Causal) defrag() {
 
 		c.cellRanges[seq] = seqRange
 	}
+
+	// inputs have moved out of the blocks their sequences were adding to
+	if c.blockSize > 0 {
+		c.resetBlocks()
+	}
 }
 
 func (c *Causal) SetLayer(layer int) {
@@ -490,19 +619,56 @@ func (c *Causal) Put(ctx ml.Context, key, value ml.Tensor) {
 		}
 	}
 
-	rowSize := c.keys[c.curLayer].Stride(2)
-	ctx.Forward(key.Copy(ctx, c.keys[c.curLayer].View(ctx, rowSize*c.curLoc, kHeadDim*numKVHeads*batchSize)))
+	for _, span := range c.spans() {
+		key, value := key, value
+		if span.len != batchSize {
+			key = key.View(ctx, key.Stride(2)*span.start,
+				kHeadDim, key.Stride(1),
+				numKVHeads, key.Stride(2),
+				span.len,
+			)
+
+			value = value.View(ctx, value.Stride(2)*span.start,
+				vHeadDim, value.Stride(1),
+				numKVHeads, value.Stride(2),
+				span.len,
+			)
+		}
 
-	if c.config.PermutedV {
-		elemSize := c.values[c.curLayer].Stride(0)
+		rowSize := c.keys[c.curLayer].Stride(2)
+		ctx.Forward(key.Copy(ctx, c.keys[c.curLayer].View(ctx, rowSize*span.loc, kHeadDim*numKVHeads*span.len)))
 
-		value = value.Permute(ctx, 1, 2, 0, 3)
-		ctx.Forward(value.Copy(ctx, c.values[c.curLayer].View(ctx, elemSize*c.curLoc, batchSize, int(c.Capacity)*elemSize, vHeadDim*numKVHeads)))
-	} else {
-		rowSize := c.values[c.curLayer].Stride(2)
+		if c.config.PermutedV {
+			elemSize := c.values[c.curLayer].Stride(0)
+
+			value = value.Permute(ctx, 1, 2, 0, 3)
+			ctx.Forward(value.Copy(ctx, c.values[c.curLayer].View(ctx, elemSize*span.loc, span.len, int(c.Capacity)*elemSize, vHeadDim*numKVHeads)))
+		} else {
+			rowSize := c.values[c.curLayer].Stride(2)
+
+			ctx.Forward(value.Copy(ctx, c.values[c.curLayer].View(ctx, rowSize*span.loc, vHeadDim*numKVHeads*span.len)))
+		}
+	}
+}
+
+// span is a run of inputs in the batch which are stored next to each other
+type span struct {
+	start, loc, len int
+}
 
-		ctx.Forward(value.Copy(ctx, c.values[c.curLayer].View(ctx, rowSize*c.curLoc, vHeadDim*numKVHeads*batchSize)))
+// spans splits the batch into runs of inputs stored next to each other, which
+// is all of it unless the cache is paged
+func (c *Causal) spans() []span {
+	var spans []span
+	for i, loc := range c.curLocs {
+		if n := len(spans); n > 0 && spans[n-1].loc+spans[n-1].len == loc {
+			spans[n-1].len++
+		} else {
+			spans = append(spans, span{start: i, loc: loc, len: 1})
+		}
 	}
+
+	return spans
 }
 
 func (c *Causal) CopyPrefix(srcSeq, dstSeq int, len int32) {
@@ -264,6 +264,79 @@ func TestCopy(t *testing.T) {
 	testCache(t, backend, cache, tests)
 }
 
+func TestPaged(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewCausalCache(nil)
+	defer cache.Close()
+
+	cache.SetBlockSize(2)
+	cache.Init(backend, ml.DTypeF16, 8)
+
+	inf := float32(math.Inf(-1))
+
+	tests := []testCase{
+		{
+			name:          "Interleaved",
+			in:            []float32{1, 2, 3, 4, 5},
+			inShape:       []int{1, 1, 5},
+			seqs:          []int{0, 1, 0, 1, 0},
+			pos:           []int32{0, 0, 1, 1, 2},
+			expected:      []float32{1, 3, 2, 4, 5},
+			expectedShape: []int{1, 1, 5},
+			expectedMask: []float32{
+				0, inf, inf, inf, inf,
+				inf, inf, 0, inf, inf,
+				0, 0, inf, inf, inf,
+				inf, inf, 0, 0, inf,
+				0, 0, inf, inf, 0,
+			},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+
+	if err := cache.Remove(1, 0, math.MaxInt32); err != nil {
+		t.Fatal(err)
+	}
+
+	// the block sequence 1 emptied is free again, but the rest of the block
+	// sequence 0 is adding to isn't
+	tests = []testCase{
+		{
+			name:          "FreeBlocks",
+			in:            []float32{6, 7, 8},
+			inShape:       []int{1, 1, 3},
+			seqs:          []int{2, 2, 2},
+			pos:           []int32{0, 1, 2},
+			expected:      []float32{6, 7, 5, 0, 8},
+			expectedShape: []int{1, 1, 5},
+			expectedMask: []float32{
+				0, inf, inf, inf, inf,
+				0, 0, inf, inf, inf,
+				0, 0, inf, inf, 0,
+			},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+
+	// there are no free blocks left until the cache is defragmented
+	tests = []testCase{
+		{
+			name:          "Defrag",
+			in:            []float32{9},
+			inShape:       []int{1, 1, 1},
+			seqs:          []int{3},
+			pos:           []int32{0},
+			expected:      []float32{9},
+			expectedShape: []int{1, 1, 1},
+			expectedMask:  []float32{0},
+		},
+	}
+
+	testCache(t, backend, cache, tests)
+}
+
 func testCache(t *testing.T, backend ml.Backend, cache Cache, tests []testCase) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -457,6 +530,10 @@ func (t *testTensor) RoPE(ctx ml.Context, positionIDs, ropeFactors ml.Tensor, di
 	panic("not implemented")
 }
 
+func (t *testTensor) RoPEMulti(ctx ml.Context, positionIDs ml.Tensor, dim uint32, sections [4]int, ropeType uint32, base, scale float32) ml.Tensor {
+	panic("not implemented")
+}
+
 func (t *testTensor) Tanh(ctx ml.Context) ml.Tensor {
 	panic("not implemented")
 }
@@ -465,6 +542,10 @@ func (t *testTensor) GELU(ctx ml.Context) ml.Tensor {
 	panic("not implemented")
 }
 
+func (t *testTensor) QuickGELU(ctx ml.Context) ml.Tensor {
+	panic("not implemented")
+}
+
 func (t *testTensor) SILU(ctx ml.Context) ml.Tensor {
 	panic("not implemented")
 }
@@ -520,7 +601,18 @@ func (t *testTensor) Stack(ctx ml.Context, dim int, s ...ml.Tensor) ml.Tensor {
 }
 
 func (t *testTensor) Concat(ctx ml.Context, t2 ml.Tensor, dim int) ml.Tensor {
-	panic("not implemented")
+	if dim != len(t.shape)-1 {
+		panic("not implemented")
+	}
+
+	shape := slices.Clone(t.shape)
+	shape[dim] += t2.Dim(dim)
+
+	out := ctx.Empty(t.dtype, shape...).(*testTensor)
+	copy(out.data, t.data)
+	copy(out.data[len(t.data):], t2.(*testTensor).data)
+
+	return out
 }
 
 func (t *testTensor) Rows(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
@@ -529,5 +621,5 @@ func (t *testTensor) Rows(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
 
 func (t *testTensor) Copy(ctx ml.Context, t2 ml.Tensor) ml.Tensor {
 	copy(t2.(*testTensor).data, t.data)
-	return nil
+	return t2
 }
@@ -2,6 +2,8 @@ package kvcache
 
 import (
 	"fmt"
+	"math"
+	"slices"
 
 	"github.com/ollama/ollama/ml"
 	"github.com/ollama/ollama/model/input"
@@ -9,43 +11,64 @@ import (
 
 // Encoder cache stores K and V tensors that are position independent
 //
-// The tensors can be of any shape and will be returned as they were stored
-// The mask is currently always nil
+// Each sequence keeps the output of its most recent encoder input (such as an
+// image), which every token of that sequence attends to. Sequences can share
+// an output by copying a prefix that contains it.
 //
-// Not currently safe for multiple sequences
+// The tensors can be of any shape and will be returned as they were stored
+// as long as the batch only uses a single output. Otherwise, outputs are
+// concatenated along dimension 2 and masked so that each token only sees the
+// output of its own sequence.
 type EncoderCache struct {
 	// config controls mostly backend-specific optimizations
 	config *ml.CacheConfig
 
+	// storage is where and in what precision outputs are kept
+	storage EncoderStorage
+
 	// ** current forward pass **
 
 	// the active layer for Get and Put
 	curLayer int
 
-	// if something is stored during this pass, this
-	// will be the position (but there is no guarantee
-	// anything will be stored)
-	curPos int32
+	// sequence of each token in the batch
+	curSequences []int
 
-	// ** cache metadata **
+	// outputs that will be stored during this pass, in the order
+	// they are passed to Put
+	curPuts []*encoderOutput
+
+	// outputs used by the batch, in the order they are returned by Get
+	curOutputs []*encoderOutput
+
+	// masks for the batch, built on the first call to Get
+	curMask, curOutputMask ml.Tensor
 
-	// was something stored in the cache?
-	encoderCached bool
+	// ** cache metadata **
 
-	// position of the cached data
-	encoderPos int32
+	// output used by each sequence
+	outputs map[int]*encoderOutput
 
 	// ** cache data storage **
-	backend      ml.Backend
+	backend ml.Backend
+}
+
+// encoderOutput is the stored output of a single encoder input, which may be
+// shared by several sequences
+type encoderOutput struct {
+	// position of the input in the sequence that it was encoded for
+	pos int32
+
+	// number of sequences using the output
+	refs int
+
 	ctxs         map[int]ml.Context
 	keys, values map[int]ml.Tensor
 }
 
 func NewEncoderCache() *EncoderCache {
 	return &EncoderCache{
-		ctxs:   make(map[int]ml.Context),
-		keys:   make(map[int]ml.Tensor),
-		values: make(map[int]ml.Tensor),
+		outputs: make(map[int]*encoderOutput),
 	}
 }
 
@@ -62,6 +85,14 @@ func (c *EncoderCache) Init(backend ml.Backend, dtype ml.DType, capacity int32)
 		panic(fmt.Errorf("encoder cache is unable to enforce requested CachePadding (%v)", c.config.CachePadding))
 	}
 
+	if c.config.MaskBatchPadding == 0 {
+		c.config.MaskBatchPadding = 1
+	}
+
+	if c.config.MaskDType == ml.DTypeOther {
+		c.config.MaskDType = ml.DTypeF32
+	}
+
 	c.backend = backend
 }
 
@@ -73,66 +104,291 @@ func (c *EncoderCache) SetConfig(config ml.CacheConfig) {
 	c.config = &config
 }
 
+func (c *EncoderCache) SetEncoderStorage(storage EncoderStorage) {
+	if c.backend != nil {
+		panic("encoder storage cannot be changed after Init")
+	}
+
+	c.storage = storage
+}
+
 func (c *EncoderCache) Close() {
-	for _, ctx := range c.ctxs {
-		ctx.Close()
+	for seq := range c.outputs {
+		c.release(seq)
 	}
 }
 
 func (c *EncoderCache) StartForward(ctx ml.Context, opts input.Options) error {
-	// We work with the most recent image
-	if len(opts.Multimodal) > 0 {
-		c.curPos = opts.Positions[opts.Multimodal[len(opts.Multimodal)-1].Index]
+	c.curSequences = opts.Sequences
+	c.curPuts = nil
+	c.curOutputs = nil
+	c.curMask = nil
+	c.curOutputMask = nil
+
+	// We work with the most recent input of each sequence
+	for i, mm := range opts.Multimodal {
+		seq := opts.Sequences[mm.Index]
+		if lastMultimodal(opts, i) {
+			out := c.outputs[seq]
+			if out == nil || out.refs > 1 {
+				c.release(seq)
+				out = &encoderOutput{
+					refs:   1,
+					ctxs:   make(map[int]ml.Context),
+					keys:   make(map[int]ml.Tensor),
+					values: make(map[int]ml.Tensor),
+				}
+				c.outputs[seq] = out
+			}
+
+			out.pos = opts.Positions[mm.Index]
+			c.curPuts = append(c.curPuts, out)
+		}
+	}
+
+	for _, seq := range opts.Sequences {
+		if out, ok := c.outputs[seq]; ok && !slices.Contains(c.curOutputs, out) {
+			c.curOutputs = append(c.curOutputs, out)
+		}
 	}
 
 	return nil
 }
 
+// lastMultimodal reports whether the i'th multimodal input of the batch is the
+// last one of its sequence
+func lastMultimodal(opts input.Options, i int) bool {
+	seq := opts.Sequences[opts.Multimodal[i].Index]
+	for _, mm := range opts.Multimodal[i+1:] {
+		if opts.Sequences[mm.Index] == seq {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (c *EncoderCache) SetLayer(layer int) {
 	c.curLayer = layer
 }
 
+// EncoderCached reports whether any token in the batch has an encoder output
+// to attend to
 func (c *EncoderCache) EncoderCached() bool {
-	return c.encoderCached
+	return len(c.curOutputs) > 0
 }
 
 func (c *EncoderCache) Get(ctx ml.Context) (ml.Tensor, ml.Tensor, ml.Tensor) {
-	return c.keys[c.curLayer], c.values[c.curLayer], nil
+	key := c.curOutputs[0].keys[c.curLayer]
+	value := c.curOutputs[0].values[c.curLayer]
+
+	vDim := 2
+	if c.config.PermutedV {
+		vDim = 0
+	}
+
+	if len(c.curOutputs) > 1 {
+		// outputs can only be concatenated at full precision
+		key, value = toF32(ctx, key), toF32(ctx, value)
+		for _, out := range c.curOutputs[1:] {
+			key = key.Concat(ctx, toF32(ctx, out.keys[c.curLayer]), 2)
+			value = value.Concat(ctx, toF32(ctx, out.values[c.curLayer]), vDim)
+		}
+	}
+
+	if c.curMask == nil && c.maskNeeded() {
+		c.curMask, c.curOutputMask = c.buildMasks(ctx)
+	}
+
+	return key, value, c.curMask
 }
 
-func (c *EncoderCache) Put(ctx ml.Context, key, value ml.Tensor) {
-	c.encoderPos = c.curPos
-	c.encoderCached = true
+func toF32(ctx ml.Context, t ml.Tensor) ml.Tensor {
+	if t.DType() == ml.DTypeF32 {
+		return t
+	}
 
-	if c.config.PermutedV {
-		value = value.Permute(ctx, 1, 2, 0, 3)
+	return t.Copy(ctx, ctx.Input().Empty(ml.DTypeF32, t.Shape()...))
+}
+
+// OutputMask returns a tensor of 1 x batch size which is 1 for each token
+// that has an encoder output to attend to and 0 otherwise. Models should
+// multiply the output of layers that use the cache by it, since the attention
+// of tokens without an output is meaningless. It is nil if every token in
+// the batch has an output.
+//
+// OutputMask is only valid after calling Get.
+func (c *EncoderCache) OutputMask() ml.Tensor {
+	return c.curOutputMask
+}
+
+// maskNeeded reports whether the tokens of the batch don't all attend to the
+// same single output
+func (c *EncoderCache) maskNeeded() bool {
+	if len(c.curOutputs) > 1 {
+		return true
 	}
 
-	if _, ok := c.ctxs[c.curLayer]; !ok {
-		c.ctxs[c.curLayer] = c.backend.NewContextSize(2).Layer(c.curLayer)
+	for _, seq := range c.curSequences {
+		if c.outputs[seq] == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Builds a mask of the returned outputs x batch indicating whether each token
+// in the batch should attend to each position in the outputs, along with the
+// mask returned by OutputMask. Tokens without an output don't mask anything
+// out so that their attention stays finite.
+func (c *EncoderCache) buildMasks(ctx ml.Context) (ml.Tensor, ml.Tensor) {
+	batchSize := roundUp(len(c.curSequences), c.config.MaskBatchPadding)
+
+	var length int
+	for _, out := range c.curOutputs {
+		length += out.keys[c.curLayer].Dim(2)
+	}
+
+	mask := make([]float32, batchSize*length)
+	outputMask := make([]float32, len(c.curSequences))
+
+	for i := range batchSize {
+		var seqOut *encoderOutput
+		if i < len(c.curSequences) {
+			seqOut = c.outputs[c.curSequences[i]]
+			if seqOut == nil {
+				continue
+			}
+
+			outputMask[i] = 1
+		}
+
+		// padding tokens added to the batch don't match any output
+		var start int
+		for _, out := range c.curOutputs {
+			end := start + out.keys[c.curLayer].Dim(2)
+			if out != seqOut {
+				for j := start; j < end; j++ {
+					mask[i*length+j] = float32(math.Inf(-1))
+				}
+			}
+			start = end
+		}
+	}
+
+	maskTensor, err := ctx.Input().FromFloatSlice(mask, length, batchSize)
+	if err != nil {
+		panic(err)
+	}
+
+	if c.config.MaskDType != ml.DTypeF32 {
+		out := ctx.Input().Empty(c.config.MaskDType, maskTensor.Shape()...)
+		ctx.Forward(maskTensor.Copy(ctx, out))
+		maskTensor = out
+	}
+
+	outputMaskTensor, err := ctx.Input().FromFloatSlice(outputMask, 1, len(outputMask))
+	if err != nil {
+		panic(err)
+	}
+
+	return maskTensor, outputMaskTensor
+}
+
+// Put stores the outputs of the encoder inputs in the batch. If more than one
+// sequence has an input, the outputs of the most recent input of each of them
+// are concatenated along dimension 2 in the order that the inputs appear in
+// the batch.
+func (c *EncoderCache) Put(ctx ml.Context, key, value ml.Tensor) {
+	if len(c.curPuts) == 0 {
+		panic(fmt.Errorf("no encoder inputs in batch (layer: %v)", c.curLayer))
 	}
 
-	if _, ok := c.keys[c.curLayer]; !ok {
-		c.keys[c.curLayer] = c.ctxs[c.curLayer].Empty(key.DType(), key.Shape()...)
+	n := len(c.curPuts)
+	if key.Dim(2)%n != 0 || value.Dim(2)%n != 0 {
+		panic(fmt.Errorf("encoder outputs can't be split between inputs (layer: %v, inputs: %v, length: %v)", c.curLayer, n, key.Dim(2)))
 	}
 
-	if _, ok := c.values[c.curLayer]; !ok {
-		c.values[c.curLayer] = c.ctxs[c.curLayer].Empty(value.DType(), value.Shape()...)
+	for i, out := range c.curPuts {
+		key, value := key, value
+		if n > 1 {
+			key = split(ctx, key, i, n)
+			value = split(ctx, value, i, n)
+		}
+
+		if c.config.PermutedV {
+			value = value.Permute(ctx, 1, 2, 0, 3)
+		}
+
+		if _, ok := out.ctxs[c.curLayer]; !ok {
+			if c.storage == EncoderStorageHost {
+				out.ctxs[c.curLayer] = c.backend.NewContextSize(2).Input()
+			} else {
+				out.ctxs[c.curLayer] = c.backend.NewContextSize(2).Layer(c.curLayer)
+			}
+		}
+
+		keyDType, valueDType := key.DType(), value.DType()
+		if c.storage == EncoderStorageF16 {
+			keyDType, valueDType = ml.DTypeF16, ml.DTypeF16
+		}
+
+		if _, ok := out.keys[c.curLayer]; !ok {
+			out.keys[c.curLayer] = out.ctxs[c.curLayer].Empty(keyDType, key.Shape()...)
+		}
+
+		if _, ok := out.values[c.curLayer]; !ok {
+			out.values[c.curLayer] = out.ctxs[c.curLayer].Empty(valueDType, value.Shape()...)
+		}
+
+		ctx.Forward(
+			key.Copy(ctx, out.keys[c.curLayer]),
+			value.Copy(ctx, out.values[c.curLayer]),
+		)
 	}
+}
 
-	ctx.Forward(
-		key.Copy(ctx, c.keys[c.curLayer]),
-		value.Copy(ctx, c.values[c.curLayer]),
+// split returns the i'th of n equal parts of t along dimension 2
+func split(ctx ml.Context, t ml.Tensor, i, n int) ml.Tensor {
+	length := t.Dim(2) / n
+	return t.View(ctx, t.Stride(2)*length*i,
+		t.Dim(0), t.Stride(1),
+		t.Dim(1), t.Stride(2),
+		length,
 	)
 }
 
+// release stops seq from using its output, freeing it if no other sequence
+// uses it
+func (c *EncoderCache) release(seq int) {
+	out, ok := c.outputs[seq]
+	if !ok {
+		return
+	}
+
+	delete(c.outputs, seq)
+
+	out.refs--
+	if out.refs == 0 {
+		for _, ctx := range out.ctxs {
+			ctx.Close()
+		}
+	}
+}
+
 func (c *EncoderCache) CopyPrefix(srcSeq, dstSeq int, len int32) {
-	panic("encoder cache does not support multiple sequences")
+	c.release(dstSeq)
+
+	if out, ok := c.outputs[srcSeq]; ok && out.pos < len {
+		out.refs++
+		c.outputs[dstSeq] = out
+	}
 }
 
 func (c *EncoderCache) Remove(seq int, beginIndex, endIndex int32) error {
-	if c.encoderPos >= beginIndex && c.encoderPos < endIndex {
-		c.encoderCached = false
+	if out, ok := c.outputs[seq]; ok && out.pos >= beginIndex && out.pos < endIndex {
+		c.release(seq)
 	}
 
 	return nil
@@ -0,0 +1,197 @@
+package kvcache
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model/input"
+)
+
+func TestEncoderSequences(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewEncoderCache()
+	defer cache.Close()
+
+	cache.Init(backend, ml.DTypeF16, 16)
+
+	inf := float32(math.Inf(-1))
+
+	tests := []struct {
+		name  string
+		opts  input.Options
+		in    []float32
+		shape []int
+
+		expected, expectedMask, expectedOutputMask []float32
+	}{
+		{
+			name: "Single",
+			opts: input.Options{
+				Positions:  []int32{0, 1},
+				Sequences:  []int{0, 0},
+				Multimodal: []input.MultimodalIndex{{Index: 0}},
+			},
+			in:       []float32{1, 2},
+			shape:    []int{1, 1, 2},
+			expected: []float32{1, 2},
+		},
+		{
+			// sequence 3 doesn't have an image, and the second image of
+			// sequence 1 replaces its first one
+			name: "Multiple",
+			opts: input.Options{
+				Positions:  []int32{0, 0, 1, 0, 2},
+				Sequences:  []int{1, 2, 1, 3, 0},
+				Multimodal: []input.MultimodalIndex{{Index: 0}, {Index: 1}, {Index: 2}},
+			},
+			in:       []float32{5, 6},
+			shape:    []int{1, 1, 2},
+			expected: []float32{6, 5, 1, 2},
+			expectedMask: []float32{
+				0, inf, inf, inf,
+				inf, 0, inf, inf,
+				0, inf, inf, inf,
+				0, 0, 0, 0,
+				inf, inf, 0, 0,
+			},
+			expectedOutputMask: []float32{1, 1, 1, 0, 1},
+		},
+		{
+			name: "Cached",
+			opts: input.Options{
+				Positions: []int32{3, 2, 1},
+				Sequences: []int{0, 1, 2},
+			},
+			expected: []float32{1, 2, 6, 5},
+			expectedMask: []float32{
+				0, 0, inf, inf,
+				inf, inf, 0, inf,
+				inf, inf, inf, 0,
+			},
+			expectedOutputMask: []float32{1, 1, 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			context := backend.NewContext()
+			defer context.Close()
+
+			if err := cache.StartForward(context, test.opts); err != nil {
+				t.Fatal(err)
+			}
+
+			cache.SetLayer(0)
+			if test.in != nil {
+				tensor, _ := context.FromFloatSlice(test.in, test.shape...)
+				cache.Put(context, tensor, tensor)
+			}
+
+			out, _, mask := cache.Get(context)
+			if !slices.Equal(out.Floats(), test.expected) {
+				t.Errorf("have %v; want %v", out.Floats(), test.expected)
+			}
+
+			if test.expectedMask == nil {
+				if mask != nil || cache.OutputMask() != nil {
+					t.Errorf("have mask %v; want nil", mask.Floats())
+				}
+			} else if !slices.Equal(mask.Floats(), test.expectedMask) || !slices.Equal(cache.OutputMask().Floats(), test.expectedOutputMask) {
+				t.Errorf("mask: have %v, %v; want %v, %v", mask.Floats(), cache.OutputMask().Floats(), test.expectedMask, test.expectedOutputMask)
+			}
+		})
+	}
+}
+
+func TestEncoderCopyRemove(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewEncoderCache()
+	defer cache.Close()
+
+	cache.Init(backend, ml.DTypeF16, 16)
+
+	context := backend.NewContext()
+	defer context.Close()
+
+	err := cache.StartForward(context, input.Options{
+		Positions:  []int32{0, 1, 2},
+		Sequences:  []int{0, 0, 0},
+		Multimodal: []input.MultimodalIndex{{Index: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tensor, _ := context.FromFloatSlice([]float32{1, 2}, 1, 1, 2)
+	cache.Put(context, tensor, tensor)
+
+	// the image is at position 1, so only the longer prefix includes it
+	cache.CopyPrefix(0, 1, 1)
+	cache.CopyPrefix(0, 2, 2)
+
+	for seq, expected := range map[int]bool{0: true, 1: false, 2: true} {
+		if err := cache.StartForward(context, input.Options{Positions: []int32{3}, Sequences: []int{seq}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if cache.EncoderCached() != expected {
+			t.Errorf("sequence %d: have cached %v; want %v", seq, !expected, expected)
+		}
+	}
+
+	// removing the image from one sequence leaves it for the other
+	if err := cache.Remove(0, 1, math.MaxInt32); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cache.outputs[0]; ok {
+		t.Error("expected sequence 0 to have no image")
+	}
+
+	if out, ok := cache.outputs[2]; !ok || out.refs != 1 {
+		t.Errorf("expected sequence 2 to keep its image, have %v", out)
+	}
+}
+
+func TestEncoderStorageF16(t *testing.T) {
+	backend := &testBackend{}
+	cache := NewEncoderCache()
+	defer cache.Close()
+
+	cache.SetEncoderStorage(EncoderStorageF16)
+	cache.Init(backend, ml.DTypeF16, 16)
+
+	context := backend.NewContext()
+	defer context.Close()
+
+	err := cache.StartForward(context, input.Options{
+		Positions:  []int32{0, 0},
+		Sequences:  []int{0, 1},
+		Multimodal: []input.MultimodalIndex{{Index: 0}, {Index: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.SetLayer(0)
+	tensor, _ := context.FromFloatSlice([]float32{1, 2, 3, 4}, 1, 1, 4)
+	cache.Put(context, tensor, tensor)
+
+	for seq, out := range cache.outputs {
+		if out.keys[0].DType() != ml.DTypeF16 || out.values[0].DType() != ml.DTypeF16 {
+			t.Errorf("sequence %d: have %v, %v; want f16", seq, out.keys[0].DType(), out.values[0].DType())
+		}
+	}
+
+	// outputs are concatenated at full precision
+	key, value, _ := cache.Get(context)
+	if key.DType() != ml.DTypeF32 || value.DType() != ml.DTypeF32 {
+		t.Errorf("have %v, %v; want f32", key.DType(), value.DType())
+	}
+
+	if !slices.Equal(key.Floats(), []float32{1, 2, 3, 4}) {
+		t.Errorf("have %v; want %v", key.Floats(), []float32{1, 2, 3, 4})
+	}
+}
@@ -35,6 +35,22 @@ func (c *WrapperCache) SetConfig(config ml.CacheConfig) {
 	}
 }
 
+func (c *WrapperCache) SetBlockSize(size int) {
+	for _, cache := range c.caches {
+		if paged, ok := cache.(PagedCache); ok {
+			paged.SetBlockSize(size)
+		}
+	}
+}
+
+func (c *WrapperCache) SetEncoderStorage(storage EncoderStorage) {
+	for _, cache := range c.caches {
+		if s, ok := cache.(EncoderStorageCache); ok {
+			s.SetEncoderStorage(storage)
+		}
+	}
+}
+
 func (c *WrapperCache) Close() {
 	for _, cache := range c.caches {
 		cache.Close()
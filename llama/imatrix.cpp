@@ -0,0 +1,152 @@
+// Collects the importance matrix of a model while batches are decoded,
+// based on examples/imatrix in llama.cpp.
+#include "imatrix.h"
+#include "ggml.h"
+#include "ggml-backend.h"
+
+#include <cstring>
+#include <iterator>
+#include <map>
+#include <mutex>
+#include <string>
+#include <vector>
+
+struct imatrix_entry {
+    std::vector<float> values;
+    // counts is the number of activations summed for each matrix, which
+    // differs between the experts of mixture of experts models
+    std::vector<int64_t> counts;
+};
+
+struct imatrix {
+    std::mutex mu;
+    std::map<std::string, imatrix_entry> entries;
+};
+
+// tensors copied between backends are named like "CUDA0#blk.0.attn_q.weight#0"
+static std::string weight_name(const char *name) {
+    const char *p = strchr(name, '#');
+    if (p == nullptr) {
+        return name;
+    }
+
+    const char *q = strchr(p + 1, '#');
+    return q == nullptr ? std::string(p + 1) : std::string(p + 1, q);
+}
+
+static bool is_weight(const std::string &name) {
+    return name.rfind("blk.", 0) == 0 || name == "output.weight";
+}
+
+static const char *host_data(const struct ggml_tensor *t, std::vector<char> &buf) {
+    if (ggml_backend_buffer_is_host(t->buffer)) {
+        return (const char *)t->data;
+    }
+
+    buf.resize(ggml_nbytes(t));
+    ggml_backend_tensor_get(t, buf.data(), 0, ggml_nbytes(t));
+    return buf.data();
+}
+
+struct imatrix *imatrix_init(void) {
+    return new imatrix;
+}
+
+void imatrix_free(struct imatrix *im) {
+    delete im;
+}
+
+bool imatrix_collect(struct ggml_tensor *t, bool ask, void *user_data) {
+    auto *im = (struct imatrix *)user_data;
+    const struct ggml_tensor *src0 = t->src[0];
+    const struct ggml_tensor *src1 = t->src[1];
+
+    if (ask) {
+        if (t->op != GGML_OP_MUL_MAT && t->op != GGML_OP_MUL_MAT_ID) {
+            return false;
+        }
+
+        // multiplications of activations with each other, such as kq, and
+        // single tokens aren't collected
+        return src1->type == GGML_TYPE_F32 && src1->ne[1] >= 16 && is_weight(weight_name(src0->name));
+    }
+
+    std::vector<char> buf;
+    const char *data = host_data(src1, buf);
+    const int64_t n_per_row = src0->ne[0];
+
+    std::lock_guard<std::mutex> lock(im->mu);
+    auto &e = im->entries[weight_name(src0->name)];
+
+    if (t->op == GGML_OP_MUL_MAT_ID) {
+        const struct ggml_tensor *ids = t->src[2];
+        const int64_t n_as = src0->ne[2];
+
+        std::vector<char> ids_buf;
+        const char *ids_data = host_data(ids, ids_buf);
+
+        e.values.resize(n_per_row * n_as);
+        e.counts.resize(n_as);
+
+        // ids holds the experts used for each token
+        for (int64_t row = 0; row < ids->ne[1]; row++) {
+            for (int64_t k = 0; k < ids->ne[0]; k++) {
+                const int32_t expert = *(const int32_t *)(ids_data + row * ids->nb[1] + k * ids->nb[0]);
+                if (expert < 0 || expert >= n_as) {
+                    continue;
+                }
+
+                const float *x = (const float *)(data + (k % src1->ne[1]) * src1->nb[1] + row * src1->nb[2]);
+                for (int64_t j = 0; j < n_per_row; j++) {
+                    e.values[expert * n_per_row + j] += x[j] * x[j];
+                }
+
+                e.counts[expert]++;
+            }
+        }
+    } else {
+        e.values.resize(n_per_row);
+        e.counts.resize(1);
+
+        for (int64_t i2 = 0; i2 < src1->ne[2]; i2++) {
+            for (int64_t row = 0; row < src1->ne[1]; row++) {
+                const float *x = (const float *)(data + row * src1->nb[1] + i2 * src1->nb[2]);
+                for (int64_t j = 0; j < n_per_row; j++) {
+                    e.values[j] += x[j] * x[j];
+                }
+
+                e.counts[0]++;
+            }
+        }
+    }
+
+    return true;
+}
+
+int imatrix_n_entries(struct imatrix *im) {
+    std::lock_guard<std::mutex> lock(im->mu);
+    return (int)im->entries.size();
+}
+
+const char *imatrix_entry_name(struct imatrix *im, int i) {
+    std::lock_guard<std::mutex> lock(im->mu);
+    auto it = im->entries.begin();
+    std::advance(it, i);
+    return it->first.c_str();
+}
+
+size_t imatrix_entry_values(struct imatrix *im, int i, float *values, size_t n) {
+    std::lock_guard<std::mutex> lock(im->mu);
+    auto it = im->entries.begin();
+    std::advance(it, i);
+
+    const auto &e = it->second;
+    const size_t n_per_matrix = e.values.size() / e.counts.size();
+    for (size_t j = 0; j < e.values.size() && j < n; j++) {
+        const int64_t count = e.counts[j / n_per_matrix];
+        // experts which were never used are given equal importance
+        values[j] = count > 0 ? e.values[j] / count : 1.0f;
+    }
+
+    return e.values.size();
+}
@@ -0,0 +1,39 @@
+#ifndef IMATRIX_H
+#define IMATRIX_H
+
+#include <stdbool.h>
+#include <stddef.h>
+
+#ifdef __cplusplus
+extern "C"
+{
+#endif
+
+    struct ggml_tensor;
+
+    // imatrix accumulates the squares of the activations multiplied with
+    // each column of the weights of a model, which is the importance matrix
+    // used to quantize its weights.
+    struct imatrix;
+
+    struct imatrix *imatrix_init(void);
+    void imatrix_free(struct imatrix *im);
+
+    // imatrix_collect is a ggml_backend_sched_eval_callback which collects
+    // the activations of matrix multiplications with weights into the
+    // imatrix passed as user_data.
+    bool imatrix_collect(struct ggml_tensor *t, bool ask, void *user_data);
+
+    int imatrix_n_entries(struct imatrix *im);
+    const char *imatrix_entry_name(struct imatrix *im, int i);
+
+    // imatrix_entry_values writes the mean squared activation of each column
+    // of entry i to values, which has room for n values, returning the number
+    // of values in the entry.
+    size_t imatrix_entry_values(struct imatrix *im, int i, float *values, size_t n);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif // IMATRIX_H
@@ -18,6 +18,7 @@ package llama
 
 #include "mllama.h"
 #include "sampling_ext.h"
+#include "imatrix.h"
 
 extern bool llamaProgressCallback(float progress, void *user_data);
 extern void llamaLog(int level, char* text, void* user_data);
@@ -101,6 +102,54 @@ func NewContextParams(numCtx int, batchSize int, numSeqMax int, threads int, fla
 	return ContextParams{c: params}
 }
 
+// SetOffloadKQV sets whether the KV cache of layers on GPUs, and the
+// attention computed with it, is on the GPUs or in system memory.
+func (p *ContextParams) SetOffloadKQV(offload bool) {
+	p.c.offload_kqv = C.bool(offload)
+}
+
+// SetImportanceMatrix collects the importance matrix of the model into im as
+// batches are decoded by contexts created with these parameters.
+func (p *ContextParams) SetImportanceMatrix(im *ImportanceMatrix) {
+	p.c.cb_eval = C.ggml_backend_sched_eval_callback(C.imatrix_collect)
+	p.c.cb_eval_user_data = unsafe.Pointer(im.c)
+	// only the activations are needed
+	p.c.embeddings = C.bool(false)
+}
+
+// ImportanceMatrix accumulates the squares of the activations multiplied
+// with each column of the weights of a model, which shows how much each
+// column affects the output when quantizing its weights.
+type ImportanceMatrix struct {
+	c *C.struct_imatrix
+}
+
+func NewImportanceMatrix() *ImportanceMatrix {
+	return &ImportanceMatrix{c: C.imatrix_init()}
+}
+
+func (im *ImportanceMatrix) Free() {
+	C.imatrix_free(im.c)
+}
+
+// Values returns the mean squared activation of each column of the weights
+// collected so far by name. The values of mixture of experts weights are
+// those of each expert in turn.
+func (im *ImportanceMatrix) Values() map[string][]float32 {
+	values := make(map[string][]float32)
+	for i := range int(C.imatrix_n_entries(im.c)) {
+		n := C.imatrix_entry_values(im.c, C.int(i), nil, 0)
+		v := make([]float32, n)
+		if n > 0 {
+			C.imatrix_entry_values(im.c, C.int(i), (*C.float)(&v[0]), n)
+		}
+
+		values[C.GoString(C.imatrix_entry_name(im.c, C.int(i)))] = v
+	}
+
+	return values
+}
+
 // kvCacheTypeFromStr converts a string cache type to the corresponding GGML type value
 func kvCacheTypeFromStr(s string) C.enum_ggml_type {
 	if s == "" {
@@ -142,6 +191,10 @@ func (c *Context) Decode(batch *Batch) error {
 	return nil
 }
 
+func (c *Context) Free() {
+	C.llama_free(c.c)
+}
+
 func (c *Context) Model() *Model {
 	return &Model{c: C.llama_get_model(c.c)}
 }
@@ -178,6 +231,17 @@ func (c *Context) GetEmbeddingsSeq(seqId int) []float32 {
 	return embeddings
 }
 
+// GetLogitsIth returns the logits of the ith token of the last batch, which
+// can be modified before sampling from them
+func (c *Context) GetLogitsIth(i int) []float32 {
+	l := unsafe.Pointer(C.llama_get_logits_ith(c.c, C.int32_t(i)))
+	if l == nil {
+		return nil
+	}
+
+	return unsafe.Slice((*float32)(l), c.Model().NumVocab())
+}
+
 func (c *Context) GetEmbeddingsIth(i int) []float32 {
 	e := unsafe.Pointer(C.llama_get_embeddings_ith(c.c, C.int32_t(i)))
 	if e == nil {
@@ -283,25 +347,41 @@ func (m *Model) TokenIsEog(token int) bool {
 	return bool(C.llama_vocab_is_eog(m.Vocab(), C.llama_token(token)))
 }
 
+func (m *Model) TokenIsControl(token int) bool {
+	return bool(C.llama_vocab_is_control(m.Vocab(), C.llama_token(token)))
+}
+
 func (m *Model) AddBOSToken() bool {
 	return bool(C.llama_vocab_get_add_bos(m.Vocab()))
 }
 
-func (m *Model) ApplyLoraFromFile(context *Context, loraPath string, scale float32, threads int) error {
+// LoraAdapter is a LoRA adapter of a model which contexts of the model can
+// apply.
+type LoraAdapter struct {
+	c *C.struct_llama_adapter_lora
+}
+
+// LoadLoraFromFile loads the LoRA adapter at loraPath without applying it.
+func (m *Model) LoadLoraFromFile(loraPath string) (*LoraAdapter, error) {
 	cLoraPath := C.CString(loraPath)
 	defer C.free(unsafe.Pointer(cLoraPath))
 
 	loraAdapter := C.llama_adapter_lora_init(m.c, cLoraPath)
 	if loraAdapter == nil {
-		return errors.New("unable to load lora")
+		return nil, errors.New("unable to load lora")
 	}
 
-	err := -1
-	if loraAdapter != nil {
-		err = int(C.llama_set_adapter_lora(context.c, loraAdapter, C.float(scale)))
-	}
-	if err != 0 {
-		return errors.New("error applying lora from file")
+	return &LoraAdapter{c: loraAdapter}, nil
+}
+
+// SetLoraAdapters replaces the LoRA adapters applied by the context with
+// adapters, each with the given scale.
+func (c *Context) SetLoraAdapters(adapters []*LoraAdapter, scale float32) error {
+	C.llama_clear_adapter_lora(c.c)
+	for _, adapter := range adapters {
+		if C.llama_set_adapter_lora(c.c, adapter.c, C.float(scale)) != 0 {
+			return errors.New("error applying lora")
+		}
 	}
 
 	return nil
@@ -622,6 +702,13 @@ type SamplingParams struct {
 	Mirostat       int
 	MirostatTau    float32
 	MirostatEta    float32
+	DryMultiplier  float32
+	DryBase        float32
+	DryAllowedLen  int
+	DryLastN       int
+	DryBreakers    []string
+	XTCProbability float32
+	XTCThreshold   float32
 	PenalizeNl     bool
 	Seed           uint32
 	Grammar        string
@@ -641,8 +728,28 @@ func NewSamplingContext(model *Model, params SamplingParams) (*SamplingContext,
 	cparams.mirostat = C.int32_t(params.Mirostat)
 	cparams.mirostat_tau = C.float(params.MirostatTau)
 	cparams.mirostat_eta = C.float(params.MirostatEta)
+	cparams.dry_multiplier = C.float(params.DryMultiplier)
+	cparams.dry_base = C.float(params.DryBase)
+	cparams.dry_allowed_length = C.int32_t(params.DryAllowedLen)
+	cparams.dry_penalty_last_n = C.int32_t(params.DryLastN)
+	cparams.xtc_probability = C.float(params.XTCProbability)
+	cparams.xtc_threshold = C.float(params.XTCThreshold)
 	cparams.seed = C.uint32_t(params.Seed)
 
+	if len(params.DryBreakers) > 0 {
+		breakers := (**C.char)(C.malloc(C.size_t(len(params.DryBreakers)) * C.size_t(unsafe.Sizeof((*C.char)(nil)))))
+		defer C.free(unsafe.Pointer(breakers))
+
+		cbreakers := unsafe.Slice(breakers, len(params.DryBreakers))
+		for i, s := range params.DryBreakers {
+			cbreakers[i] = C.CString(s)
+			defer C.free(unsafe.Pointer(cbreakers[i]))
+		}
+
+		cparams.dry_sequence_breakers = breakers
+		cparams.n_dry_sequence_breakers = C.size_t(len(params.DryBreakers))
+	}
+
 	grammar := C.CString(params.Grammar)
 	defer C.free(unsafe.Pointer(grammar))
 
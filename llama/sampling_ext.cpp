@@ -21,10 +21,15 @@ struct common_sampler *common_sampler_cinit(const struct llama_model *model, str
         sparams.mirostat = params->mirostat;
         sparams.mirostat_tau = params->mirostat_tau;
         sparams.mirostat_eta = params->mirostat_eta;
+        sparams.dry_multiplier = params->dry_multiplier;
+        sparams.dry_base = params->dry_base;
+        sparams.dry_allowed_length = params->dry_allowed_length;
+        sparams.dry_penalty_last_n = params->dry_penalty_last_n;
+        sparams.dry_sequence_breakers.assign(params->dry_sequence_breakers, params->dry_sequence_breakers + params->n_dry_sequence_breakers);
+        sparams.xtc_probability = params->xtc_probability;
+        sparams.xtc_threshold = params->xtc_threshold;
         sparams.seed = params->seed;
         sparams.grammar = params->grammar;
-        sparams.xtc_probability = 0.0;
-        sparams.xtc_threshold = 0.5;
         return common_sampler_init(model, sparams);
     } catch (const std::exception &err) {
         return nullptr;
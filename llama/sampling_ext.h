@@ -23,6 +23,14 @@ extern "C"
         int32_t mirostat;
         float mirostat_tau;
         float mirostat_eta;
+        float dry_multiplier;
+        float dry_base;
+        int32_t dry_allowed_length;
+        int32_t dry_penalty_last_n;
+        char **dry_sequence_breakers;
+        size_t n_dry_sequence_breakers;
+        float xtc_probability;
+        float xtc_threshold;
         uint32_t seed;
         char *grammar;
     };
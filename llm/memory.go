@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -22,7 +23,11 @@ func PredictServerFit(allGpus discover.GpuInfoList, f *ggml.GGML, adapters, proj
 		var layerCount int
 		estimate := EstimateGPULayers(gpus, f, projectors, opts)
 		layerCount, estimatedVRAM = estimate.Layers, estimate.VRAMSize
-		if opts.NumGPU < 0 {
+		if opts.Offload != "" {
+			if layerCount > 0 && estimate.offloadPlaced {
+				return true, estimatedVRAM
+			}
+		} else if opts.NumGPU < 0 {
 			if layerCount > 0 && layerCount >= int(f.KV().BlockCount()+1) {
 				return true, estimatedVRAM
 			}
@@ -54,12 +59,17 @@ type MemoryEstimate struct {
 	// For multi-GPU scenarios, this is the size in bytes per GPU
 	GPUSizes []uint64
 
+	// Where the parts of the model are placed by a runner started with this
+	// estimate
+	Offload api.OffloadPlan
+
 	// internal fields for logging purposes
 	inferenceLibrary    string
 	layersRequested     int
 	layersModel         int
 	availableList       []string
 	kv                  uint64
+	encoderCache        uint64
 	allocationsList     []string
 	memoryWeights       uint64
 	memoryLayerOutput   uint64
@@ -67,6 +77,45 @@ type MemoryEstimate struct {
 	graphPartialOffload uint64
 
 	projectorWeights, projectorGraph uint64
+
+	// whether every part the offload option asks for is on the GPUs
+	offloadPlaced bool
+}
+
+// kvCacheType returns the requested quantization type of the KV cache, from
+// the kv_cache_type option if it's set, which may be a default of the model,
+// or OLLAMA_KV_CACHE_TYPE otherwise.
+func kvCacheType(opts api.Options) string {
+	if opts.KVCacheType != "" {
+		return strings.ToLower(opts.KVCacheType)
+	}
+
+	return strings.ToLower(envconfig.KvCacheType())
+}
+
+// flashAttentionRequested returns whether flash attention is requested, by the
+// flash_attention option if it's set or OLLAMA_FLASH_ATTENTION otherwise. It
+// is only enabled if the GPUs and model support it too.
+func flashAttentionRequested(opts api.Options) bool {
+	if opts.FlashAttention != nil {
+		return *opts.FlashAttention
+	}
+
+	return envconfig.FlashAttention()
+}
+
+// encoderCacheBytesPerElement returns the size of each value of the encoder
+// outputs that a model keeps on the GPU with the encoder_cache option, which
+// is zero if they are kept in system memory.
+func encoderCacheBytesPerElement(opts api.Options) float64 {
+	switch strings.ToLower(opts.EncoderCache) {
+	case "f16":
+		return 2
+	case "host":
+		return 0
+	default:
+		return 4
+	}
 }
 
 // Given a model and one or more GPU targets, predict how many layers and bytes we can load, and the total size
@@ -100,6 +149,13 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 	// Overflow that didn't fit into the GPU
 	var overflow uint64
 
+	blockCount := int(f.KV().BlockCount())
+
+	// Parts placed by the offload option, which NewLlamaServer has checked
+	offload, _ := api.ParseOffload(opts.Offload)
+	requestedBlocks, blocksRequested, _ := offload.GPUBlocks(blockCount)
+	kvOnGPU := offload.KVCache == nil || *offload.KVCache
+
 	overhead := envconfig.GpuOverhead()
 	availableList := make([]string, len(gpus))
 	for i, gpu := range gpus {
@@ -128,10 +184,10 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 	}
 
 	var kvct string
-	if envconfig.FlashAttention() &&
+	if flashAttentionRequested(opts) &&
 		discover.GetGPUInfo().FlashAttentionSupported() &&
 		f.SupportsFlashAttention() {
-		requested := strings.ToLower(envconfig.KvCacheType())
+		requested := kvCacheType(opts)
 		if requested != "" && f.SupportsKVCacheType(requested) {
 			kvct = requested
 		}
@@ -139,8 +195,20 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 
 	kv, graphPartialOffload, graphFullOffload := f.GraphSize(uint64(opts.NumCtx), uint64(min(opts.NumCtx, opts.NumBatch)), kvct)
 
-	// KV is proportional to the number of layers
-	layerSize += kv / f.KV().BlockCount()
+	// encoder outputs kept on the GPU are spread across the layers with the
+	// KV cache
+	_, encoderCache := f.EncoderCacheSize(encoderCacheBytesPerElement(opts))
+	kv += encoderCache
+
+	// KV is proportional to the number of layers, unless it's kept in system
+	// memory
+	var layerKV uint64
+	if kvOnGPU {
+		layerKV = kv / f.KV().BlockCount()
+	} else {
+		overflow += kv
+	}
+	layerSize += layerKV
 
 	if graphPartialOffload == 0 {
 		graphPartialOffload = f.KV().GQA() * kv / 6
@@ -149,6 +217,14 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 		graphFullOffload = graphPartialOffload
 	}
 
+	if strings.EqualFold(opts.EncoderCache, "host") {
+		// outputs kept in system memory are copied to the GPU at full
+		// precision by each layer as part of its graph
+		copied, _ := f.EncoderCacheSize(4)
+		graphPartialOffload += copied
+		graphFullOffload += copied
+	}
+
 	// on metal there's no partial offload overhead
 	if gpus[0].Library == "metal" {
 		graphPartialOffload = graphFullOffload
@@ -157,6 +233,19 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 		graphFullOffload = graphPartialOffload
 	}
 
+	// Blocks streamed to the GPU are copied into the graph as prompts are
+	// processed, so a partial offload keeps room for the largest of them
+	streaming := opts.WeightStreaming && gpus[0].Library != "cpu" && gpus[0].Library != "metal"
+	if streaming {
+		var largest uint64
+		for i := range blockCount {
+			if blk, ok := layers[fmt.Sprintf("blk.%d", i)]; ok {
+				largest = max(largest, blk.Size())
+			}
+		}
+		graphPartialOffload += largest
+	}
+
 	if layer, ok := layers["output_norm"]; ok {
 		memoryLayerOutput += layer.Size()
 	}
@@ -166,8 +255,18 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 		memoryLayerOutput += layer.Size()
 	}
 
+	// Embeddings are only on the GPU if the offload option asks for them
+	var memoryEmbeddings uint64
+	if offload.Embeddings != nil && *offload.Embeddings {
+		for _, name := range []string{"token_embd", "position_embd"} {
+			if layer, ok := layers[name]; ok {
+				memoryEmbeddings += layer.Size()
+			}
+		}
+	}
+
 	// Output layer handled at the end if we have space
-	gpuZeroOverhead := projectorWeights + projectorGraph
+	gpuZeroOverhead := projectorWeights + projectorGraph + memoryEmbeddings
 
 	// Reduce set of GPUs to only those that have sufficient space to fit overhead and at least one layer
 	var layerCount int
@@ -212,44 +311,78 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 		gpuAllocations[gpuZeroID] += gpuZeroOverhead
 	}
 
-	// For all the layers, find where they can fit on the GPU(s)
-	for i := range int(f.KV().BlockCount()) {
-		// Some models have inconsistent layer sizes
+	embeddingsPlaced := memoryEmbeddings > 0 && len(gpusWithSpace) > 0
+
+	// Some models have inconsistent layer sizes
+	layerSizes := make([]uint64, blockCount)
+	for i := range layerSizes {
 		if blk, ok := layers[fmt.Sprintf("blk.%d", i)]; ok {
 			layerSize = blk.Size()
-			layerSize += kv / f.KV().BlockCount()
+			layerSize += layerKV
 			memoryWeights += blk.Size()
 		}
+		layerSizes[i] = layerSize
+	}
 
-		if opts.NumGPU >= 0 && layerCount >= opts.NumGPU {
-			// Stop allocating on GPU(s) once we hit the users target NumGPU
-			continue
+	// Blocks requested by the offload option are placed from the last, as
+	// runners place the blocks counted by num_gpu, so those that don't fit
+	// are the first ones
+	candidates := make([]int, 0, blockCount)
+	if blocksRequested {
+		for _, i := range slices.Backward(requestedBlocks) {
+			candidates = append(candidates, i)
+		}
+	} else {
+		for i := range blockCount {
+			if opts.NumGPU >= 0 && i >= opts.NumGPU {
+				// Stop allocating on GPU(s) once we hit the users target NumGPU
+				break
+			}
+			candidates = append(candidates, i)
 		}
+	}
 
+	// For all the layers, find where they can fit on the GPU(s)
+	placed := make([]bool, blockCount)
+	for _, i := range candidates {
 		// distribute the layers across the GPU(s) that have space
 		for j := len(gpusWithSpace); j > 0; j-- {
 			g := gpusWithSpace[i%j]
 			used := gpuAllocations[g.i] + max(graphPartialOffload, graphFullOffload)
-			if g.g.FreeMemory > overhead+used+layerSize {
-				gpuAllocations[g.i] += layerSize
+			if g.g.FreeMemory > overhead+used+layerSizes[i] {
+				gpuAllocations[g.i] += layerSizes[i]
 				layerCounts[g.i]++
 				layerCount++
+				placed[i] = true
 				break
 			} else {
 				gpusWithSpace = append(gpusWithSpace[:i%j], gpusWithSpace[i%j+1:]...)
 			}
 		}
 	}
-	if layerCount >= int(f.KV().BlockCount()) {
-		fullyLoaded = true
+	blocksPlaced := layerCount
+	if blocksPlaced >= blockCount {
+		fullyLoaded = kvOnGPU
 	} else {
-		for i := layerCount; i < int(f.KV().BlockCount()); i++ {
-			overflow += layerSize
+		for i, p := range placed {
+			if !p {
+				overflow += layerSizes[i]
+			}
 		}
 	}
 
 	// Determine if we need to consider output then find where it fits
-	if memoryLayerOutput > 0 && (opts.NumGPU < 0 || layerCount < opts.NumGPU) {
+	outputRequested := memoryLayerOutput > 0 && (opts.NumGPU < 0 || layerCount < opts.NumGPU)
+	switch {
+	case offload.Output != nil:
+		outputRequested = *offload.Output
+	case blocksRequested:
+		// as with num_gpu, the output layer follows the last block
+		outputRequested = blocksPlaced >= blockCount
+	}
+
+	var outputPlaced bool
+	if memoryLayerOutput > 0 && outputRequested {
 		for j := len(gpusWithSpace); j > 0; j-- {
 			g := gpusWithSpace[layerCount%j]
 			used := gpuAllocations[g.i] + max(graphPartialOffload, graphFullOffload)
@@ -257,14 +390,19 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 				gpuAllocations[g.i] += memoryLayerOutput
 				layerCounts[g.i]++
 				layerCount++
+				outputPlaced = true
 				break
 			}
 		}
 
-		if layerCount < int(f.KV().BlockCount())+1 {
+		if layerCount < blockCount+1 {
 			fullyLoaded = false
 			overflow += memoryLayerOutput
 		}
+	} else if memoryLayerOutput > 0 && offload.Output != nil {
+		// kept in system memory as the offload option asks
+		fullyLoaded = false
+		overflow += memoryLayerOutput
 	}
 
 	// Add the applicable (full or partial) graph allocations
@@ -316,6 +454,7 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 		layersModel:         int(f.KV().BlockCount()) + 1,
 		availableList:       availableList,
 		kv:                  kv,
+		encoderCache:        encoderCache,
 		allocationsList:     allocationsList,
 		memoryWeights:       memoryWeights,
 		memoryLayerOutput:   memoryLayerOutput,
@@ -325,6 +464,15 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 		projectorGraph:      projectorGraph,
 	}
 
+	estimate.Offload = api.OffloadPlan{
+		Library:    "cpu",
+		Blocks:     blockCount,
+		Embeddings: "cpu",
+		Output:     "cpu",
+		KVCache:    "cpu",
+		Size:       memoryRequiredTotal,
+	}
+
 	if gpus[0].Library == "cpu" {
 		return estimate
 	}
@@ -332,6 +480,41 @@ func EstimateGPULayers(gpus []discover.GpuInfo, f *ggml.GGML, projectors []strin
 		slog.Debug("insufficient VRAM to load any model layers")
 		return estimate
 	}
+
+	estimate.Offload.Library = gpus[0].Library
+	if opts.Offload != "" {
+		for i, p := range placed {
+			if p {
+				estimate.Offload.GPUBlocks = append(estimate.Offload.GPUBlocks, i)
+			}
+		}
+		if embeddingsPlaced {
+			estimate.Offload.Embeddings = "gpu"
+		}
+		if outputPlaced {
+			estimate.Offload.Output = "gpu"
+		}
+
+		estimate.offloadPlaced = blocksPlaced == len(candidates) &&
+			(!outputRequested || outputPlaced) &&
+			(memoryEmbeddings == 0 || embeddingsPlaced)
+	} else {
+		// runners place the last layers counted by num_gpu, so the output
+		// layer is only on the GPU if every block is too
+		for i := max(0, blockCount-layerCount); i < blockCount; i++ {
+			estimate.Offload.GPUBlocks = append(estimate.Offload.GPUBlocks, i)
+		}
+		if layerCount > blockCount {
+			estimate.Offload.Output = "gpu"
+		}
+	}
+	if kvOnGPU && len(estimate.Offload.GPUBlocks) > 0 {
+		estimate.Offload.KVCache = "gpu"
+	}
+	estimate.Offload.Streaming = streaming && blocksPlaced < blockCount
+	estimate.Offload.VRAMSize = memoryRequiredPartial
+	estimate.Offload.GPUSizes = gpuAllocations
+
 	estimate.Layers = layerCount
 	estimate.Graph = graphOffload
 	estimate.VRAMSize = memoryRequiredPartial
@@ -355,6 +538,10 @@ func (m MemoryEstimate) LogValue() slog.Value {
 			// multi-gpu split for tensors
 			"split", m.TensorSplit,
 		),
+		// where the parts of the model are placed, as set by the offload option
+		slog.String("offload", m.Offload.String()),
+		// whether blocks in system memory are streamed to the GPUs
+		slog.Bool("streaming", m.Offload.Streaming),
 		slog.Group(
 			"memory",
 			// memory available by GPU for offloading
@@ -368,6 +555,8 @@ func (m MemoryEstimate) LogValue() slog.Value {
 				"partial", format.HumanBytes2(m.VRAMSize),
 				// memory of KV cache
 				"kv", format.HumanBytes2(m.kv),
+				// memory of encoder outputs on the GPU, part of kv
+				"encoder_cache", format.HumanBytes2(m.encoderCache),
 				// Allocations across the GPUs
 				"allocations", m.allocationsList,
 			),
@@ -11,6 +11,7 @@ import (
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/fs/ggml"
 )
 
@@ -129,3 +130,184 @@ func TestEstimateGPULayers(t *testing.T) {
 		})
 	}
 }
+
+func TestKVCacheType(t *testing.T) {
+	t.Setenv("OLLAMA_KV_CACHE_TYPE", "Q4_0")
+
+	opts := api.DefaultOptions()
+	assert.Equal(t, "q4_0", kvCacheType(opts))
+
+	opts.KVCacheType = "q8_0"
+	assert.Equal(t, "q8_0", kvCacheType(opts))
+}
+
+func TestFlashAttentionRequested(t *testing.T) {
+	t.Setenv("OLLAMA_FLASH_ATTENTION", "1")
+
+	opts := api.DefaultOptions()
+	assert.True(t, flashAttentionRequested(opts))
+
+	opts.FlashAttention = new(bool)
+	assert.False(t, flashAttentionRequested(opts))
+}
+
+func TestEncoderCacheEstimate(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "mllama")
+	require.NoError(t, err)
+	defer f.Close()
+
+	err = ggml.WriteGGUF(f, ggml.KV{
+		"general.architecture":                    "mllama",
+		"mllama.embedding_length":                 uint32(4096),
+		"mllama.block_count":                      uint32(4),
+		"mllama.attention.head_count":             uint32(32),
+		"mllama.attention.head_count_kv":          uint32(8),
+		"mllama.attention.cross_attention_layers": []uint32{1, 3},
+		"tokenizer.ggml.tokens":                   []string{" "},
+		"tokenizer.ggml.scores":                   []float32{0},
+		"tokenizer.ggml.token_type":               []int32{0},
+	}, []ggml.Tensor{
+		{Name: "blk.0.attn.weight", Kind: uint32(0), Offset: uint64(0), Shape: []uint64{1, 1, 1, 1}, WriterTo: bytes.NewReader(make([]byte, 32))},
+	})
+	require.NoError(t, err)
+
+	model, err := LoadModel(f.Name(), 0)
+	require.NoError(t, err)
+
+	// 8 heads of 128 values for K and V, for each of 4 tiles of 1601 tokens
+	layer := uint64(8 * 2 * 128 * 1601 * 4 * 4)
+
+	gpus := []discover.GpuInfo{{Library: "cuda"}}
+	gpus[0].FreeMemory = 64 * format.GigaByte
+	opts := api.DefaultOptions()
+	device := EstimateGPULayers(gpus, model, nil, opts)
+	assert.Equal(t, 2*layer, device.encoderCache)
+
+	opts.EncoderCache = "f16"
+	f16 := EstimateGPULayers(gpus, model, nil, opts)
+	assert.Equal(t, layer, f16.encoderCache)
+	assert.Equal(t, device.kv-layer, f16.kv)
+
+	// outputs in system memory are copied to the GPU one layer at a time
+	opts.EncoderCache = "host"
+	host := EstimateGPULayers(gpus, model, nil, opts)
+	assert.Equal(t, uint64(0), host.encoderCache)
+	assert.Equal(t, device.kv-2*layer, host.kv)
+	assert.Equal(t, device.graphFullOffload+layer, host.graphFullOffload)
+}
+
+func TestOffloadEstimate(t *testing.T) {
+	t.Setenv("OLLAMA_KV_CACHE_TYPE", "")
+
+	f, err := os.CreateTemp(t.TempDir(), "offload")
+	require.NoError(t, err)
+	defer f.Close()
+
+	tensors := []ggml.Tensor{
+		{Name: "token_embd.weight", Kind: uint32(0), Offset: uint64(0), Shape: []uint64{1, 1, 1, 1}, WriterTo: bytes.NewReader(make([]byte, 32))},
+		{Name: "output.weight", Kind: uint32(0), Offset: uint64(0), Shape: []uint64{1, 1, 1, 1}, WriterTo: bytes.NewReader(make([]byte, 32))},
+	}
+	for i := range 5 {
+		tensors = append(tensors, ggml.Tensor{Name: fmt.Sprintf("blk.%d.attn.weight", i), Kind: uint32(0), Offset: uint64(0), Shape: []uint64{1, 1, 1, 1}, WriterTo: bytes.NewReader(make([]byte, 32))})
+	}
+
+	err = ggml.WriteGGUF(f, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.context_length":          uint32(32),
+		"llama.embedding_length":        uint32(4096),
+		"llama.block_count":             uint32(5),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(32),
+		"tokenizer.ggml.tokens":         []string{" "},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, tensors)
+	require.NoError(t, err)
+
+	model, err := LoadModel(f.Name(), 0)
+	require.NoError(t, err)
+
+	gpus := []discover.GpuInfo{{Library: "cuda"}}
+	gpus[0].FreeMemory = 64 * format.GigaByte
+
+	estimate := func(offload string, numGPU int) MemoryEstimate {
+		opts := api.DefaultOptions()
+		opts.NumGPU = numGPU
+		opts.Offload = offload
+		return EstimateGPULayers(gpus, model, nil, opts)
+	}
+
+	t.Run("automatic", func(t *testing.T) {
+		e := estimate("", -1)
+		assert.Equal(t, 6, e.Layers)
+		assert.Equal(t, "blocks=all embeddings=cpu output=gpu kv_cache=gpu", e.Offload.String())
+		assert.Equal(t, e.VRAMSize, e.Offload.VRAMSize)
+		assert.Equal(t, e.TotalSize, e.Offload.Size)
+
+		e = estimate("", 2)
+		assert.Equal(t, "blocks=3-4 embeddings=cpu output=cpu kv_cache=gpu", e.Offload.String())
+	})
+
+	t.Run("requested", func(t *testing.T) {
+		full := estimate("", -1)
+		e := estimate("blocks=0-1,3 output=cpu kv_cache=cpu", -1)
+		assert.Equal(t, 3, e.Layers)
+		assert.Equal(t, []int{0, 1, 3}, e.Offload.GPUBlocks)
+		assert.Equal(t, "blocks=0-1,3 embeddings=cpu output=cpu kv_cache=cpu", e.Offload.String())
+		assert.True(t, e.offloadPlaced)
+		assert.Less(t, e.VRAMSize, e.TotalSize)
+		// the cache is in system memory, so blocks on the GPU only take
+		// the space of their weights
+		assert.Less(t, e.VRAMSize, full.VRAMSize-4*full.kv/5)
+
+		e = estimate("blocks=all embeddings=gpu", 1)
+		assert.Equal(t, "blocks=all embeddings=gpu output=gpu kv_cache=gpu", e.Offload.String())
+		assert.True(t, e.offloadPlaced)
+	})
+
+	t.Run("too little memory", func(t *testing.T) {
+		full := estimate("", -1)
+		layer := full.kv/5 + 4
+		gpus[0].FreeMemory = 3*layer + max(full.graphFullOffload, full.graphPartialOffload) + 1
+		t.Cleanup(func() { gpus[0].FreeMemory = 64 * format.GigaByte })
+
+		// the last blocks asked for are placed first
+		e := estimate("blocks=0-3", -1)
+		assert.Equal(t, []int{2, 3}, e.Offload.GPUBlocks)
+		assert.Equal(t, "cpu", e.Offload.Output)
+		assert.False(t, e.offloadPlaced)
+
+		opts := api.DefaultOptions()
+		opts.Offload = "blocks=3-4"
+		fits, _ := PredictServerFit(gpus, model, nil, nil, opts)
+		assert.True(t, fits)
+	})
+
+	t.Run("weight streaming", func(t *testing.T) {
+		opts := api.DefaultOptions()
+		opts.WeightStreaming = true
+
+		// every block fits, so none are streamed
+		e := EstimateGPULayers(gpus, model, nil, opts)
+		assert.Equal(t, 6, e.Layers)
+		assert.False(t, e.Offload.Streaming)
+
+		opts.NumGPU = 2
+		e = EstimateGPULayers(gpus, model, nil, opts)
+		assert.True(t, e.Offload.Streaming)
+
+		// the graph keeps room for the block being streamed
+		partial := estimate("", 2)
+		assert.False(t, partial.Offload.Streaming)
+		assert.Equal(t, partial.graphPartialOffload+model.Tensors().GroupLayers()["blk.0"].Size(), e.graphPartialOffload)
+
+		e = EstimateGPULayers([]discover.GpuInfo{{Library: "cpu"}}, model, nil, opts)
+		assert.False(t, e.Offload.Streaming)
+	})
+
+	t.Run("cpu", func(t *testing.T) {
+		e := EstimateGPULayers([]discover.GpuInfo{{Library: "cpu"}}, model, nil, api.DefaultOptions())
+		assert.Equal(t, "cpu", e.Offload.Library)
+		assert.Equal(t, "blocks=none embeddings=cpu output=cpu kv_cache=cpu", e.Offload.String())
+	})
+}
@@ -17,6 +17,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -31,21 +32,42 @@ import (
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/llama"
 	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/sandbox"
 )
 
 type LlamaServer interface {
 	Ping(ctx context.Context) error
 	WaitUntilRunning(ctx context.Context) error
 	Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error
-	Embedding(ctx context.Context, input string) ([]float32, error)
+	Embedding(ctx context.Context, input string, adapters []string) ([]float32, error)
 	Tokenize(ctx context.Context, content string) ([]int, error)
+	Importance(ctx context.Context, req ImportanceRequest, fn func(ImportanceResponse)) (map[string][]float32, error)
 	Detokenize(ctx context.Context, tokens []int) (string, error)
 	Close() error
 	EstimatedVRAM() uint64 // Total VRAM across all GPUs
 	EstimatedTotal() uint64
 	EstimatedVRAMByGPU(gpuID string) uint64
+	FlashAttention() bool
+	PagedAttention() bool
+	Offload() api.OffloadPlan
 }
 
+// ErrUnsupportedOffload is returned when the offload option asks for parts of
+// a model to be placed in a way that the model or engine doesn't allow.
+var ErrUnsupportedOffload = errors.New("unsupported offload")
+
+// ErrInsufficientMemory is matched by errors caused by there not being
+// enough memory to load or run a model.
+var ErrInsufficientMemory = errors.New("insufficient memory")
+
+// memoryError is an error caused by a lack of memory, which keeps its
+// message as is.
+type memoryError string
+
+func (e memoryError) Error() string { return string(e) }
+
+func (e memoryError) Unwrap() error { return ErrInsufficientMemory }
+
 // llmServer is an instance of the llama.cpp server
 type llmServer struct {
 	port        int
@@ -56,10 +78,17 @@ type llmServer struct {
 	numParallel int
 	modelPath   string
 
+	// flashAttention and pagedAttention are whether the runner was started
+	// with flash attention and a paged KV cache
+	flashAttention bool
+	pagedAttention bool
+
 	// llamaModel is an instance of the cgo llama.cpp model definition
 	// nil if this server is running the new engine
-	llamaModel     *llama.Model
-	llamaModelLock sync.Mutex
+	llamaModel *llama.Model
+	// llamaModelLock is held for reading while tokenizing, which is safe to
+	// do concurrently, and for writing while freeing the model
+	llamaModelLock sync.RWMutex
 
 	// textProcessor handles text encoding/decoding for the model in the Ollama engine
 	// nil if this server is running the llama.cpp based engine
@@ -72,9 +101,40 @@ type llmServer struct {
 	loadDuration time.Duration        // Record how long it took the model to load
 	loadProgress float32
 
+	// sandbox confines the runner, if runners are sandboxed
+	sandbox *sandbox.Sandbox
+
 	sem *semaphore.Weighted
 }
 
+// llamaOffload checks that the llama engine can place a model as the offload
+// option asks, which it can only do by the number of layers on GPUs, and
+// adjusts plan for the blocks it could place.
+func llamaOffload(offload api.Offload, plan *api.OffloadPlan) error {
+	if offload.Embeddings != nil && *offload.Embeddings {
+		return fmt.Errorf("%w: the llama engine keeps embeddings in system memory", ErrUnsupportedOffload)
+	}
+
+	if !offload.AllBlocks && len(offload.Blocks) > 0 {
+		first := plan.Blocks - len(offload.Blocks)
+		if offload.Blocks[0] != first || offload.Blocks[len(offload.Blocks)-1] != plan.Blocks-1 {
+			return fmt.Errorf("%w: the llama engine can only offload the last blocks, such as blocks=%d-%d", ErrUnsupportedOffload, first, plan.Blocks-1)
+		}
+	}
+
+	if offload.Output != nil && *offload.Output && offload.Blocks != nil && len(offload.Blocks) < plan.Blocks {
+		return fmt.Errorf("%w: the llama engine only offloads the output layer with every block", ErrUnsupportedOffload)
+	}
+
+	// the output layer counts as a block which it places first if not every
+	// block fits
+	if plan.Output == "gpu" && len(plan.GPUBlocks) < plan.Blocks {
+		plan.Output = "cpu"
+	}
+
+	return nil
+}
+
 // LoadModel will load a model from disk. The model must be in the GGML format.
 //
 // It collects array values for arrays with a size less than or equal to
@@ -104,8 +164,16 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 	systemSwapFreeMemory := systemInfo.System.FreeSwap
 	slog.Info("system memory", "total", format.HumanBytes2(systemTotalMemory), "free", format.HumanBytes2(systemFreeMemory), "free_swap", format.HumanBytes2(systemSwapFreeMemory))
 
+	offload, err := api.ParseOffload(opts.Offload)
+	if err == nil {
+		_, _, err = offload.GPUBlocks(int(f.KV().BlockCount()))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnsupportedOffload, err)
+	}
+
 	// If the user wants zero GPU layers, reset the gpu list to be CPU/system ram info
-	if opts.NumGPU == 0 {
+	if opts.NumGPU == 0 && opts.Offload == "" {
 		gpus = discover.GetCPUInfo()
 	}
 
@@ -119,7 +187,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		case gpus[0].Library != "metal" && estimate.Layers == 0:
 			// Don't bother loading into the GPU if no layers can fit
 			gpus = discover.GetCPUInfo()
-		case opts.NumGPU < 0 && estimate.Layers > 0 && gpus[0].Library != "cpu":
+		case (opts.NumGPU < 0 || opts.Offload != "") && estimate.Layers > 0 && gpus[0].Library != "cpu":
 			opts.NumGPU = estimate.Layers
 		}
 	}
@@ -131,7 +199,13 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		available := systemFreeMemory + systemSwapFreeMemory
 		if systemMemoryRequired > available {
 			slog.Warn("model request too large for system", "requested", format.HumanBytes2(systemMemoryRequired), "available", available, "total", format.HumanBytes2(systemTotalMemory), "free", format.HumanBytes2(systemFreeMemory), "swap", format.HumanBytes2(systemSwapFreeMemory))
-			return nil, fmt.Errorf("model requires more system memory (%s) than is available (%s)", format.HumanBytes2(systemMemoryRequired), format.HumanBytes2(available))
+			return nil, memoryError(fmt.Sprintf("model requires more system memory (%s) than is available (%s)", format.HumanBytes2(systemMemoryRequired), format.HumanBytes2(available)))
+		}
+
+		// streamed weights are pinned, so they can't be swapped out
+		if estimate.Offload.Streaming && systemMemoryRequired > systemFreeMemory {
+			slog.Warn("model too large to stream weights", "requested", format.HumanBytes2(systemMemoryRequired), "free", format.HumanBytes2(systemFreeMemory))
+			return nil, memoryError(fmt.Sprintf("weight streaming requires more free system memory (%s) than is available (%s)", format.HumanBytes2(systemMemoryRequired), format.HumanBytes2(systemFreeMemory)))
 		}
 	}
 
@@ -143,7 +217,8 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		"--batch-size", strconv.Itoa(opts.NumBatch),
 	}
 
-	if opts.NumGPU >= 0 {
+	// layers placed by the offload option are set once the engine is known
+	if opts.NumGPU >= 0 && opts.Offload == "" {
 		params = append(params, "--n-gpu-layers", strconv.Itoa(opts.NumGPU))
 	}
 
@@ -168,7 +243,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		params = append(params, "--threads", strconv.Itoa(defaultThreads))
 	}
 
-	fa := envconfig.FlashAttention()
+	fa := flashAttentionRequested(opts)
 	if fa && !gpus.FlashAttentionSupported() {
 		slog.Warn("flash attention enabled but not supported by gpu")
 		fa = false
@@ -179,7 +254,7 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		fa = false
 	}
 
-	kvct := strings.ToLower(envconfig.KvCacheType())
+	kvct := kvCacheType(opts)
 
 	if fa {
 		slog.Info("enabling flash attention")
@@ -209,10 +284,12 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 	// Windows CUDA should not use mmap for best performance
 	// Linux  with a model larger than free space, mmap leads to thrashing
 	// For CPU loads we want the memory to be allocated, not FS cache
+	// Streamed weights are only read from pinned memory when they aren't mapped
 	if (runtime.GOOS == "windows" && gpus[0].Library == "cuda" && opts.UseMMap == nil) ||
 		(runtime.GOOS == "linux" && systemFreeMemory < estimate.TotalSize && opts.UseMMap == nil) ||
 		(gpus[0].Library == "cpu" && opts.UseMMap == nil) ||
-		(opts.UseMMap != nil && !*opts.UseMMap) {
+		(opts.UseMMap != nil && !*opts.UseMMap) ||
+		estimate.Offload.Streaming {
 		params = append(params, "--no-mmap")
 	}
 
@@ -289,6 +366,53 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		params = append(params, "--mmproj", projectors[0])
 	}
 
+	paged := opts.PagedAttention
+	if paged && textProcessor == nil {
+		slog.Warn("paged attention enabled but not supported by the llama engine")
+		paged = false
+	}
+
+	if paged {
+		params = append(params, "--paged-attention")
+	}
+
+	if opts.EncoderCache != "" {
+		if textProcessor == nil {
+			slog.Warn("encoder cache set but not supported by the llama engine", "encoder_cache", opts.EncoderCache)
+		} else {
+			params = append(params, "--encoder-cache", opts.EncoderCache)
+		}
+	}
+
+	if estimate.Offload.Streaming && textProcessor != nil {
+		params = append(params, "--weight-streaming")
+	}
+
+	if backend := discover.Backend(gpus[0].Library); backend != "" && textProcessor != nil {
+		params = append(params, "--backend", backend)
+	}
+
+	if opts.Offload != "" {
+		if textProcessor != nil {
+			params = append(params, "--offload", estimate.Offload.String())
+		} else {
+			if err := llamaOffload(offload, &estimate.Offload); err != nil {
+				llama.FreeModel(llamaModel)
+				return nil, err
+			}
+
+			layers := len(estimate.Offload.GPUBlocks)
+			if estimate.Offload.Output == "gpu" {
+				layers++
+			}
+
+			params = append(params, "--n-gpu-layers", strconv.Itoa(layers))
+			if estimate.Offload.KVCache == "cpu" {
+				params = append(params, "--no-kv-offload")
+			}
+		}
+	}
+
 	// iterate through compatible GPU libraries such as 'cuda_v12', 'cuda_v11', 'rocm', etc.
 	// adding each library's respective path to the LD_LIBRARY_PATH, until finally running
 	// without any LD_LIBRARY_PATH flags
@@ -349,19 +473,21 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 		libraryPaths = append(libraryPaths, discover.LibOllamaPath)
 
 		s := &llmServer{
-			port:          port,
-			cmd:           exec.Command(exe, finalParams...),
-			status:        NewStatusWriter(os.Stderr),
-			options:       opts,
-			modelPath:     modelPath,
-			llamaModel:    llamaModel,
-			textProcessor: textProcessor,
-			estimate:      estimate,
-			numParallel:   numParallel,
-			sem:           semaphore.NewWeighted(int64(numParallel)),
-			totalLayers:   f.KV().BlockCount() + 1,
-			gpus:          gpus,
-			done:          make(chan error, 1),
+			port:           port,
+			cmd:            exec.Command(exe, finalParams...),
+			status:         NewStatusWriter(os.Stderr),
+			options:        opts,
+			modelPath:      modelPath,
+			llamaModel:     llamaModel,
+			textProcessor:  textProcessor,
+			estimate:       estimate,
+			numParallel:    numParallel,
+			flashAttention: fa,
+			pagedAttention: paged,
+			sem:            semaphore.NewWeighted(int64(numParallel)),
+			totalLayers:    f.KV().BlockCount() + 1,
+			gpus:           gpus,
+			done:           make(chan error, 1),
 		}
 
 		s.cmd.Env = os.Environ()
@@ -402,6 +528,27 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 			s.cmd.Env = append(s.cmd.Env, visibleDevicesEnv+"="+visibleDevicesEnvVal)
 		}
 
+		if envconfig.RunnerSandbox() {
+			s.sandbox, err = sandbox.New(sandbox.Policy{
+				Read:     slices.Concat([]string{modelPath}, adapters, projectors, libraryPaths, envconfig.RunnerPaths()),
+				Port:     port,
+				AppArmor: envconfig.RunnerAppArmor(),
+			})
+			if err == nil {
+				err = s.sandbox.Configure(s.cmd)
+			}
+
+			if err != nil {
+				if s.sandbox != nil {
+					s.sandbox.Close()
+				}
+				if llamaModel != nil {
+					llama.FreeModel(llamaModel)
+				}
+				return nil, fmt.Errorf("error sandboxing runner: %w", err)
+			}
+		}
+
 		slog.Info("starting llama server", "cmd", s.cmd)
 		if envconfig.Debug() {
 			filteredEnv := []string{}
@@ -429,6 +576,9 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 				msg = s.status.LastErrMsg
 			}
 			err := fmt.Errorf("error starting runner: %v %s", err, msg)
+			if s.sandbox != nil {
+				s.sandbox.Close()
+			}
 			if len(compatible) == 0 {
 				if llamaModel != nil {
 					llama.FreeModel(llamaModel)
@@ -441,16 +591,37 @@ func NewLlamaServer(gpus discover.GpuInfoList, modelPath string, f *ggml.GGML, a
 			continue
 		}
 
+		if s.sandbox != nil {
+			if err := s.sandbox.Confine(s.cmd.Process); err != nil {
+				s.cmd.Process.Kill()
+				s.cmd.Wait()
+				s.sandbox.Close()
+				if llamaModel != nil {
+					llama.FreeModel(llamaModel)
+				}
+				return nil, err
+			}
+		}
+
 		// reap subprocess when it exits
 		go func() {
 			err := s.cmd.Wait()
+			if s.sandbox != nil {
+				if err := s.sandbox.Close(); err != nil {
+					slog.Warn("failed to remove runner temporary directory", "error", err)
+				}
+			}
 			// Favor a more detailed message over the process exit status
 			if err != nil && s.status != nil && s.status.LastErrMsg != "" {
 				slog.Error("llama runner terminated", "error", err)
 				if strings.Contains(s.status.LastErrMsg, "unknown model") {
 					s.status.LastErrMsg = "this model is not supported by your version of Ollama. You may need to upgrade"
 				}
-				s.done <- errors.New(s.status.LastErrMsg)
+				if s.status.outOfMemory() {
+					s.done <- memoryError(s.status.LastErrMsg)
+				} else {
+					s.done <- errors.New(s.status.LastErrMsg)
+				}
 			} else {
 				s.done <- err
 			}
@@ -577,7 +748,7 @@ func (s *llmServer) WaitUntilRunning(ctx context.Context) error {
 	stallDuration := envconfig.LoadTimeout()    // If no progress happens
 	stallTimer := time.Now().Add(stallDuration) // give up if we stall
 
-	slog.Info("waiting for llama runner to start responding")
+	slog.Info("waiting for llama runner to start responding", "offload", s.estimate.Offload.String())
 	var lastStatus ServerStatus = -1
 	fullyLoaded := false
 
@@ -672,10 +843,25 @@ type CompletionRequest struct {
 	Images  []ImageData
 	Options *api.Options
 
+	// Adapters are the paths of the LoRA adapters loaded by the runner
+	// which are applied to this request
+	Adapters []string
+
+	// N is the number of completions to generate from the prompt, which
+	// share its prefill. Zero is one completion.
+	N int
+
+	// Deadline is when the runner stops generating and ends the completion
+	// with the done reason "timeout", if it isn't zero
+	Deadline time.Time
+
 	Grammar string // set before sending the request to the subprocess
 }
 
 type CompletionResponse struct {
+	// Index is the completion of the request which this response is for
+	Index int `json:"index,omitempty"`
+
 	Content            string        `json:"content"`
 	DoneReason         string        `json:"done_reason"`
 	Done               bool          `json:"done"`
@@ -713,7 +899,13 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		req.Options = &opts
 	}
 
-	if err := s.sem.Acquire(ctx, 1); err != nil {
+	// each completion uses one of the runner's parallel sequences
+	n := max(req.N, 1)
+	if n > 1 && n > s.numParallel {
+		return fmt.Errorf("n (%d) is more than the number of parallel requests (%d)", n, s.numParallel)
+	}
+
+	if err := s.sem.Acquire(ctx, int64(n)); err != nil {
 		if errors.Is(err, context.Canceled) {
 			slog.Info("aborting completion request due to client closing the connection")
 		} else {
@@ -721,7 +913,7 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		}
 		return err
 	}
-	defer s.sem.Release(1)
+	defer s.sem.Release(int64(n))
 
 	// put an upper limit on num_predict to avoid the model running on forever
 	if req.Options.NumPredict < 0 || req.Options.NumPredict > 10*s.options.NumCtx {
@@ -772,14 +964,15 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 	scanner.Buffer(buf, maxBufferSize)
 
 	// keep track of the last token generated, this is used to abort if the model starts looping
-	var lastToken string
-	var tokenRepeat int
+	lastToken := make([]string, n)
+	tokenRepeat := make([]int, n)
+	var done int
 
 	for scanner.Scan() {
 		select {
 		case <-ctx.Done():
 			// This handles the request cancellation
-			return ctx.Err()
+			return context.Cause(ctx)
 		default:
 			line := scanner.Bytes()
 			if len(line) == 0 {
@@ -796,34 +989,46 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 			if err := json.Unmarshal(evt, &c); err != nil {
 				return fmt.Errorf("error unmarshalling llm prediction response: %v", err)
 			}
+			if c.Index < 0 || c.Index >= n {
+				return fmt.Errorf("unexpected completion index %d", c.Index)
+			}
+
 			switch {
-			case strings.TrimSpace(c.Content) == lastToken:
-				tokenRepeat++
+			case strings.TrimSpace(c.Content) == lastToken[c.Index]:
+				tokenRepeat[c.Index]++
 			default:
-				lastToken = strings.TrimSpace(c.Content)
-				tokenRepeat = 0
+				lastToken[c.Index] = strings.TrimSpace(c.Content)
+				tokenRepeat[c.Index] = 0
 			}
 
 			// 30 picked as an arbitrary max token repeat limit, modify as needed
-			if tokenRepeat > 30 {
+			if tokenRepeat[c.Index] > 30 {
 				slog.Debug("prediction aborted, token repeat limit reached")
 				return ctx.Err()
 			}
 
 			if c.Content != "" {
 				fn(CompletionResponse{
+					Index:   c.Index,
 					Content: c.Content,
 				})
 			}
 
 			if c.Done {
 				fn(c)
-				return nil
+				if done++; done == n {
+					return nil
+				}
 			}
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
+		// reading the response fails once the request is canceled
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+
 		if strings.Contains(err.Error(), "unexpected EOF") || strings.Contains(err.Error(), "forcibly closed") {
 			s.Close()
 			var msg string
@@ -843,13 +1048,17 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 
 type EmbeddingRequest struct {
 	Content string `json:"content"`
+
+	// Adapters are the paths of the LoRA adapters applied to the content,
+	// as in [CompletionRequest]
+	Adapters []string `json:"adapters,omitempty"`
 }
 
 type EmbeddingResponse struct {
 	Embedding []float32 `json:"embedding"`
 }
 
-func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, error) {
+func (s *llmServer) Embedding(ctx context.Context, input string, adapters []string) ([]float32, error) {
 	if err := s.sem.Acquire(ctx, 1); err != nil {
 		if errors.Is(err, context.Canceled) {
 			slog.Info("aborting embedding request due to client closing the connection")
@@ -868,7 +1077,7 @@ func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, err
 		return nil, fmt.Errorf("unexpected server status: %s", status)
 	}
 
-	data, err := json.Marshal(EmbeddingRequest{Content: input})
+	data, err := json.Marshal(EmbeddingRequest{Content: input, Adapters: adapters})
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling embed data: %w", err)
 	}
@@ -903,6 +1112,92 @@ func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, err
 	return e.Embedding, nil
 }
 
+// ImportanceRequest is a request to run text through a model to compute
+// its importance matrix, which weights the columns of its weights when
+// it's quantized.
+type ImportanceRequest struct {
+	Calibration []string `json:"calibration"`
+
+	// Context is the number of tokens of calibration run at a time.
+	Context int `json:"context"`
+}
+
+// ImportanceResponse is the progress of an importance matrix, until it's
+// done and Importance is set.
+type ImportanceResponse struct {
+	Completed  int                  `json:"completed"`
+	Total      int                  `json:"total"`
+	Importance map[string][]float32 `json:"importance,omitempty"`
+	Error      string               `json:"error,omitempty"`
+}
+
+// Importance computes the importance matrix of the model with the
+// calibration texts of req, calling fn with its progress.
+func (s *llmServer) Importance(ctx context.Context, req ImportanceRequest, fn func(ImportanceResponse)) (map[string][]float32, error) {
+	if s.textProcessor != nil {
+		return nil, errors.New("importance matrices can only be computed for models run by llama.cpp")
+	}
+
+	if err := s.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	defer s.sem.Release(1)
+
+	status, err := s.getServerStatusRetry(ctx)
+	if err != nil {
+		return nil, err
+	} else if status != ServerStatusReady {
+		return nil, fmt.Errorf("unexpected server status: %s", status)
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling importance data: %w", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/importance", s.port), bytes.NewBuffer(data))
+	if err != nil {
+		return nil, fmt.Errorf("error creating importance request: %w", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("do importance request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("error reading importance response: %w", err)
+		}
+		return nil, fmt.Errorf("%s", bytes.TrimSpace(body))
+	}
+
+	// the last response is the whole matrix, which is larger than a line
+	// of a completion can be, so responses aren't read by lines
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var p ImportanceResponse
+		if err := dec.Decode(&p); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("error reading importance response: %w", err)
+		}
+
+		switch {
+		case p.Error != "":
+			return nil, errors.New(p.Error)
+		case p.Importance != nil:
+			return p.Importance, nil
+		}
+
+		fn(p)
+	}
+}
+
 type TokenizeRequest struct {
 	Content string `json:"content"`
 }
@@ -912,8 +1207,8 @@ type TokenizeResponse struct {
 }
 
 func (s *llmServer) Tokenize(ctx context.Context, content string) ([]int, error) {
-	s.llamaModelLock.Lock()
-	defer s.llamaModelLock.Unlock()
+	s.llamaModelLock.RLock()
+	defer s.llamaModelLock.RUnlock()
 
 	if s.llamaModel != nil {
 		return s.llamaModel.Tokenize(content, false, true)
@@ -942,8 +1237,8 @@ type DetokenizeResponse struct {
 }
 
 func (s *llmServer) Detokenize(ctx context.Context, tokens []int) (string, error) {
-	s.llamaModelLock.Lock()
-	defer s.llamaModelLock.Unlock()
+	s.llamaModelLock.RLock()
+	defer s.llamaModelLock.RUnlock()
 
 	if s.llamaModel != nil {
 		var resp string
@@ -1010,3 +1305,16 @@ func (s *llmServer) EstimatedVRAMByGPU(gpuID string) uint64 {
 	}
 	return 0
 }
+
+func (s *llmServer) FlashAttention() bool {
+	return s.flashAttention
+}
+
+func (s *llmServer) PagedAttention() bool {
+	return s.pagedAttention
+}
+
+// Offload returns where the runner places the parts of the model.
+func (s *llmServer) Offload() api.OffloadPlan {
+	return s.estimate.Offload
+}
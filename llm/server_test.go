@@ -70,3 +70,41 @@ func TestLLMServerCompletionFormat(t *testing.T) {
 	}, nil)
 	checkValid(err)
 }
+
+func TestLlamaOffload(t *testing.T) {
+	cases := []struct {
+		offload string
+		err     string
+	}{
+		{offload: "blocks=all output=gpu kv_cache=cpu"},
+		{offload: "blocks=2-3"},
+		{offload: "blocks=none"},
+		{offload: "embeddings=gpu", err: "keeps embeddings in system memory"},
+		{offload: "blocks=0-1", err: "can only offload the last blocks, such as blocks=2-3"},
+		{offload: "blocks=1,3", err: "can only offload the last blocks, such as blocks=2-3"},
+		{offload: "blocks=2-3 output=gpu", err: "only offloads the output layer with every block"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.offload, func(t *testing.T) {
+			offload, err := api.ParseOffload(tt.offload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = llamaOffload(offload, &api.OffloadPlan{Blocks: 4})
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (!errors.Is(err, ErrUnsupportedOffload) || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+
+	// the output layer is only placed after the last block
+	plan := api.OffloadPlan{Blocks: 4, GPUBlocks: []int{2, 3}, Output: "gpu"}
+	if err := llamaOffload(api.Offload{}, &plan); err != nil || plan.Output != "cpu" {
+		t.Errorf("expected the output layer on the cpu, got %q (%v)", plan.Output, err)
+	}
+}
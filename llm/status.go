@@ -3,6 +3,7 @@ package llm
 import (
 	"bytes"
 	"os"
+	"strings"
 )
 
 // StatusWriter is a writer that captures error messages from the llama runner process
@@ -30,6 +31,14 @@ var errorPrefixes = []string{
 	"Deepseek2 does not support K-shift",
 }
 
+// outOfMemory reports whether the last error of the runner was from running
+// out of memory.
+func (w *StatusWriter) outOfMemory() bool {
+	msg := strings.ToLower(w.LastErrMsg)
+	return strings.Contains(msg, "out of memory") || strings.Contains(msg, "cudamalloc failed") ||
+		strings.Contains(msg, "failed to allocate")
+}
+
 func (w *StatusWriter) Write(b []byte) (int, error) {
 	var errMsg string
 	for _, prefix := range errorPrefixes {
@@ -0,0 +1,356 @@
+// Package mcp is a client for Model Context Protocol servers, which give
+// models tools to call. Servers are run as child processes and spoken to
+// with JSON-RPC over their standard input and output.
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/version"
+)
+
+// protocolVersion is the version of the protocol the client speaks.
+const protocolVersion = "2025-03-26"
+
+// ErrClosed is returned for calls to a server which has exited.
+var ErrClosed = errors.New("mcp server closed")
+
+// ServerConfig is how to run a server.
+type ServerConfig struct {
+	Command string            `json:"command"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+}
+
+// Config is the servers to connect to by name, in the format other MCP
+// clients use:
+//
+//	{"mcpServers": {"files": {"command": "mcp-files", "args": ["/data"]}}}
+type Config struct {
+	Servers map[string]ServerConfig `json:"mcpServers"`
+}
+
+// LoadConfig reads a Config from the JSON file at path.
+func LoadConfig(path string) (*Config, error) {
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(bts, &config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for name, server := range config.Servers {
+		if server.Command == "" {
+			return nil, fmt.Errorf("%s: server %q has no command", path, name)
+		}
+	}
+
+	return &config, nil
+}
+
+// Tool is a tool of a server.
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"inputSchema,omitempty"`
+}
+
+// Error is an error returned by a server.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("mcp error %d: %s", e.Code, e.Message)
+}
+
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int64          `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Client is a connection to a running server.
+type Client struct {
+	Name string
+
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan message
+
+	// done is closed when the server exits
+	done chan struct{}
+}
+
+// Start runs the server of config, named name, and initializes it.
+func Start(ctx context.Context, name string, config ServerConfig) (*Client, error) {
+	cmd := exec.Command(config.Command, config.Args...)
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for k, v := range config.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting mcp server %q: %w", name, err)
+	}
+
+	c := &Client{
+		Name:    name,
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[int64]chan message),
+		done:    make(chan struct{}),
+	}
+
+	go c.read(stdout)
+
+	var result struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ServerInfo      struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"serverInfo"`
+	}
+	if err := c.call(ctx, "initialize", map[string]any{
+		"protocolVersion": protocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]any{"name": "ollama", "version": version.Version},
+	}, &result); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("initializing mcp server %q: %w", name, err)
+	}
+
+	if err := c.send(message{JSONRPC: "2.0", Method: "notifications/initialized"}); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	slog.Debug("started mcp server", "name", name, "server", result.ServerInfo.Name, "version", result.ServerInfo.Version, "protocol", result.ProtocolVersion)
+	return c, nil
+}
+
+// read dispatches the messages from the server until it exits.
+func (c *Client) read(r io.Reader) {
+	defer close(c.done)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var msg struct {
+			message
+			ID json.RawMessage `json:"id"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			slog.Debug("invalid message from mcp server", "name", c.Name, "error", err)
+			continue
+		}
+
+		switch {
+		case msg.Method != "" && msg.ID != nil:
+			c.reply(msg.Method, msg.ID)
+		case msg.Method != "":
+			// notifications, such as of progress or logs, aren't used
+		default:
+			var id int64
+			if err := json.Unmarshal(msg.ID, &id); err != nil {
+				continue
+			}
+
+			c.mu.Lock()
+			ch, ok := c.pending[id]
+			delete(c.pending, id)
+			c.mu.Unlock()
+			if ok {
+				ch <- msg.message
+			}
+		}
+	}
+}
+
+// reply answers requests from the server. The client has no capabilities,
+// so it only answers pings.
+func (c *Client) reply(method string, id json.RawMessage) {
+	resp := map[string]any{"jsonrpc": "2.0", "id": id}
+	if method == "ping" {
+		resp["result"] = map[string]any{}
+	} else {
+		resp["error"] = Error{Code: -32601, Message: "method not found"}
+	}
+
+	if err := c.send(resp); err != nil {
+		slog.Debug("failed to reply to mcp server", "name", c.Name, "method", method, "error", err)
+	}
+}
+
+func (c *Client) send(v any) error {
+	bts, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.stdin.Write(append(bts, '\n'))
+	return err
+}
+
+// call calls method with params and decodes the result into result.
+func (c *Client) call(ctx context.Context, method string, params any, result any) error {
+	ch := make(chan message, 1)
+
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(message{JSONRPC: "2.0", ID: &id, Method: method, Params: params}); err != nil {
+		return err
+	}
+
+	decode := func(msg message) error {
+		if msg.Error != nil {
+			return msg.Error
+		}
+
+		return json.Unmarshal(msg.Result, result)
+	}
+
+	select {
+	case msg := <-ch:
+		return decode(msg)
+	case <-c.done:
+		// the reply may have come just before the server exited
+		select {
+		case msg := <-ch:
+			return decode(msg)
+		default:
+			return ErrClosed
+		}
+	case <-ctx.Done():
+		c.send(message{JSONRPC: "2.0", Method: "notifications/cancelled", Params: map[string]any{"requestId": id}})
+		return ctx.Err()
+	}
+}
+
+// ListTools returns the server's tools.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var tools []Tool
+	var cursor string
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+
+		var result struct {
+			Tools      []Tool `json:"tools"`
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := c.call(ctx, "tools/list", params, &result); err != nil {
+			return nil, err
+		}
+
+		tools = append(tools, result.Tools...)
+		if result.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// CallTool calls the tool name with args, returning the text of its result.
+// Tools which fail return their result as an error.
+func (c *Client) CallTool(ctx context.Context, name string, args map[string]any) (string, error) {
+	if args == nil {
+		args = map[string]any{}
+	}
+
+	var result struct {
+		Content []struct {
+			Type     string `json:"type"`
+			Text     string `json:"text"`
+			MimeType string `json:"mimeType"`
+			Resource *struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"resource"`
+		} `json:"content"`
+		IsError bool `json:"isError"`
+	}
+	if err := c.call(ctx, "tools/call", map[string]any{"name": name, "arguments": args}, &result); err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, content := range result.Content {
+		switch {
+		case content.Type == "text":
+			parts = append(parts, content.Text)
+		case content.Type == "resource" && content.Resource != nil && content.Resource.Text != "":
+			parts = append(parts, content.Resource.Text)
+		default:
+			// models can't see images or audio returned by tools
+			parts = append(parts, fmt.Sprintf("[%s %s]", content.Type, content.MimeType))
+		}
+	}
+
+	text := strings.Join(parts, "\n")
+	if result.IsError {
+		return "", fmt.Errorf("tool %s failed: %s", name, text)
+	}
+
+	return text, nil
+}
+
+// Close stops the server, which is given a few seconds to exit on its own
+// once its input is closed.
+func (c *Client) Close() error {
+	c.stdin.Close()
+
+	select {
+	case <-c.done:
+	case <-time.After(3 * time.Second):
+		c.cmd.Process.Kill()
+	}
+
+	return c.cmd.Wait()
+}
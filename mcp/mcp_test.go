@@ -0,0 +1,148 @@
+package mcp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+// TestMain runs the test binary as a fake server when it's started by a
+// test, so tests don't need a real one.
+func TestMain(m *testing.M) {
+	if os.Getenv("OLLAMA_MCP_TEST_SERVER") == "1" {
+		serve()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// serve is a server with an add tool, which pings the client before each
+// reply to check the client answers requests from servers.
+func serve() {
+	out := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params struct {
+				Name      string         `json:"name"`
+				Arguments map[string]any `json:"arguments"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil || req.ID == nil {
+			continue
+		}
+
+		var result any
+		switch req.Method {
+		case "initialize":
+			result = map[string]any{"protocolVersion": protocolVersion, "serverInfo": map[string]any{"name": "test"}}
+		case "tools/list":
+			result = map[string]any{"tools": []any{map[string]any{
+				"name":        "add",
+				"description": "Add two numbers",
+				"inputSchema": map[string]any{
+					"type":     "object",
+					"required": []string{"a", "b"},
+					"properties": map[string]any{
+						"a": map[string]any{"type": "number"},
+						"b": map[string]any{"type": []string{"number", "null"}, "description": "defaults to 0"},
+					},
+				},
+			}}}
+		case "tools/call":
+			if req.Params.Name != "add" {
+				out.Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "error": map[string]any{"code": -32602, "message": "unknown tool"}})
+				continue
+			}
+
+			a, _ := req.Params.Arguments["a"].(float64)
+			b, _ := req.Params.Arguments["b"].(float64)
+			result = map[string]any{"content": []any{map[string]any{"type": "text", "text": fmt.Sprint(a + b)}}}
+		case "ping":
+			continue
+		}
+
+		out.Encode(map[string]any{"jsonrpc": "2.0", "method": "ping", "id": "server-1"})
+		out.Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	}
+}
+
+func TestSession(t *testing.T) {
+	config := &Config{Servers: map[string]ServerConfig{
+		"a": {Command: os.Args[0], Env: map[string]string{"OLLAMA_MCP_TEST_SERVER": "1"}},
+		"b": {Command: os.Args[0], Env: map[string]string{"OLLAMA_MCP_TEST_SERVER": "1"}},
+	}}
+
+	s, err := Connect(t.Context(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tools := s.Tools()
+	if len(tools) != 2 {
+		t.Fatalf("expected 2 tools, got %d", len(tools))
+	}
+
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.Function.Name)
+	}
+
+	if diff := cmp.Diff(names, []string{"add", "b_add"}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	if got := tools[0].Function.Parameters.Properties["b"].Type; got != "number" {
+		t.Errorf("expected the type of b to be number, got %q", got)
+	}
+
+	for _, name := range names {
+		result, err := s.Call(t.Context(), api.ToolCall{Function: api.ToolCallFunction{Name: name, Arguments: api.ToolCallFunctionArguments{"a": 2, "b": 3}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result != "5" {
+			t.Errorf("%s: expected 5, got %q", name, result)
+		}
+	}
+
+	if _, err := s.Call(t.Context(), api.ToolCall{Function: api.ToolCallFunction{Name: "subtract"}}); err == nil {
+		t.Error("expected an error for an unknown tool")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mcp.json")
+	if err := os.WriteFile(path, []byte(`{"mcpServers": {"files": {"command": "mcp-files", "args": ["/data"]}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(config, &Config{Servers: map[string]ServerConfig{"files": {Command: "mcp-files", Args: []string{"/data"}}}}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"mcpServers": {"files": {}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("expected an error for a server without a command")
+	}
+}
@@ -0,0 +1,159 @@
+package mcp
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/ollama/ollama/api"
+)
+
+// Session is the tools of a set of servers, which can be given to a model
+// and called for it.
+type Session struct {
+	clients []*Client
+	tools   api.Tools
+
+	// names are the clients and names of the tools by the names they're
+	// given to models under
+	names map[string]toolName
+}
+
+type toolName struct {
+	client *Client
+	name   string
+}
+
+// Connect starts the servers of config and lists their tools.
+func Connect(ctx context.Context, config *Config) (*Session, error) {
+	s := &Session{names: make(map[string]toolName)}
+	for _, name := range slices.Sorted(maps.Keys(config.Servers)) {
+		c, err := Start(ctx, name, config.Servers[name])
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.clients = append(s.clients, c)
+
+		tools, err := c.ListTools(ctx)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("listing the tools of mcp server %q: %w", name, err)
+		}
+
+		for _, tool := range tools {
+			// tools with the same name on more than one server are told
+			// apart by the name of their server
+			as := tool.Name
+			if _, ok := s.names[as]; ok {
+				as = name + "_" + tool.Name
+			}
+
+			t, err := APITool(as, tool)
+			if err != nil {
+				s.Close()
+				return nil, fmt.Errorf("tool %q of mcp server %q: %w", tool.Name, name, err)
+			}
+
+			s.names[as] = toolName{c, tool.Name}
+			s.tools = append(s.tools, t)
+		}
+	}
+
+	return s, nil
+}
+
+// Tools are the tools of the servers, to give to a model.
+func (s *Session) Tools() api.Tools {
+	return s.tools
+}
+
+// Call calls the tool of call, returning the text of its result.
+func (s *Session) Call(ctx context.Context, call api.ToolCall) (string, error) {
+	t, ok := s.names[call.Function.Name]
+	if !ok {
+		return "", fmt.Errorf("unknown tool %q", call.Function.Name)
+	}
+
+	return t.client.CallTool(ctx, t.name, call.Function.Arguments)
+}
+
+// Close stops the servers.
+func (s *Session) Close() error {
+	var errs []error
+	for _, c := range s.clients {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// APITool is tool as a tool for models, named name. JSON schema types
+// which are lists, such as ["string", "null"], are given as their first
+// type which isn't null, as tool parameters have a single type.
+func APITool(name string, tool Tool) (api.Tool, error) {
+	t := api.Tool{Type: "function"}
+	t.Function.Name = name
+	t.Function.Description = tool.Description
+	t.Function.Parameters.Type = "object"
+
+	if len(tool.InputSchema) == 0 {
+		return t, nil
+	}
+
+	var schema struct {
+		Type       any      `json:"type"`
+		Required   []string `json:"required"`
+		Properties map[string]struct {
+			Type        any    `json:"type"`
+			Description string `json:"description"`
+			Enum        []any  `json:"enum"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(tool.InputSchema, &schema); err != nil {
+		return api.Tool{}, err
+	}
+
+	t.Function.Parameters.Type = cmp.Or(schemaType(schema.Type), "object")
+	t.Function.Parameters.Required = schema.Required
+	if len(schema.Properties) > 0 {
+		t.Function.Parameters.Properties = make(map[string]struct {
+			Type        string   `json:"type"`
+			Description string   `json:"description"`
+			Enum        []string `json:"enum,omitempty"`
+		})
+	}
+
+	for k, v := range schema.Properties {
+		p := t.Function.Parameters.Properties[k]
+		p.Type = schemaType(v.Type)
+		p.Description = v.Description
+		for _, e := range v.Enum {
+			p.Enum = append(p.Enum, fmt.Sprint(e))
+		}
+		t.Function.Parameters.Properties[k] = p
+	}
+
+	return t, nil
+}
+
+func schemaType(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case []any:
+		for _, s := range t {
+			if s, ok := s.(string); ok && s != "null" {
+				return s
+			}
+		}
+	}
+
+	return ""
+}
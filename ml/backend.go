@@ -2,8 +2,10 @@ package ml
 
 import (
 	"bytes"
+	"cmp"
 	"encoding/binary"
 	"fmt"
+	"maps"
 	"os"
 	"slices"
 	"strconv"
@@ -20,8 +22,13 @@ type Config interface {
 	Strings(string, ...[]string) []string
 	Uints(string, ...[]uint32) []uint32
 	Floats(string, ...[]float32) []float32
+	Bytes(string, ...[]byte) []byte
 }
 
+// Backend loads a model's weights onto devices and creates the contexts its
+// graphs are built and computed in. It is what a backend for an accelerator
+// implements, along with Context and Tensor, to run models on the Ollama
+// engine. Backends add themselves with RegisterBackend.
 type Backend interface {
 	Config() Config
 	Get(name string) Tensor
@@ -74,10 +81,49 @@ type BackendParams struct {
 
 	// FlashAttention indicates that we should use a fused flash attention kernel
 	FlashAttention bool
+
+	// Backend is the name of the registered backend to load the model with,
+	// or DefaultBackend if empty
+	Backend string
+
+	// Offload places the parts of the model in place of NumGPULayers, if
+	// it's set
+	Offload *Offload
+
+	// WeightStreaming keeps the weights of layers on the CPU in memory that
+	// GPUs can read them from quickly, for processing large batches
+	WeightStreaming bool
+
+	// UseMLock locks the weights in system memory so they aren't swapped
+	// out
+	UseMLock bool
 }
 
+// Offload is where the parts of a model are placed, on GPUs if they are set
+// and on the CPU otherwise.
+type Offload struct {
+	// GPUBlocks are the indexes of the repeating layers on GPUs, or every
+	// one if AllBlocks is set
+	GPUBlocks []int
+	AllBlocks bool
+
+	Embeddings bool
+	Output     bool
+
+	// KVCache is whether the caches of layers on GPUs are there too
+	KVCache bool
+}
+
+// DefaultBackend is the backend that models are loaded with unless another
+// one is requested.
+const DefaultBackend = "ggml"
+
 var backends = make(map[string]func(*os.File, BackendParams) (Backend, error))
 
+// RegisterBackend makes a backend available by name. It is called from the
+// init function of the backend's package, which is imported by the
+// ml/backend package, from a file with a build tag for backends that not
+// every build includes.
 func RegisterBackend(name string, f func(*os.File, BackendParams) (Backend, error)) {
 	if _, ok := backends[name]; ok {
 		panic("backend: backend already registered")
@@ -86,12 +132,18 @@ func RegisterBackend(name string, f func(*os.File, BackendParams) (Backend, erro
 	backends[name] = f
 }
 
+// Backends returns the names of the registered backends.
+func Backends() []string {
+	return slices.Sorted(maps.Keys(backends))
+}
+
 func NewBackend(f *os.File, params BackendParams) (Backend, error) {
-	if backend, ok := backends["ggml"]; ok {
+	name := cmp.Or(params.Backend, DefaultBackend)
+	if backend, ok := backends[name]; ok {
 		return backend(f, params)
 	}
 
-	return nil, fmt.Errorf("unsupported backend")
+	return nil, fmt.Errorf("unsupported backend %q, available backends are %s", name, strings.Join(Backends(), ", "))
 }
 
 type Context interface {
@@ -139,9 +191,16 @@ type Tensor interface {
 	Conv2D(ctx Context, weight Tensor, s0, s1, p0, p1, d0, d1 int) Tensor
 
 	RoPE(ctx Context, positionIDs, ropeFactors Tensor, dim, ropeType uint32, base, scale float32) Tensor
+	// RoPEMulti rotates sections of each head by separate positions, such as
+	// the temporal, height and width positions of multimodal tokens.
+	// positionIDs holds 4 positions per token, all of the first ones then all
+	// of the second ones and so on, and sections the number of rotary
+	// dimension pairs each of them applies to.
+	RoPEMulti(ctx Context, positionIDs Tensor, dim uint32, sections [4]int, ropeType uint32, base, scale float32) Tensor
 
 	Tanh(ctx Context) Tensor
 	GELU(ctx Context) Tensor
+	QuickGELU(ctx Context) Tensor
 	SILU(ctx Context) Tensor
 
 	Reshape(ctx Context, shape ...int) Tensor
@@ -1,3 +1,13 @@
+// Package backend imports the backends that models can be run with, which
+// register themselves with ml.RegisterBackend. Backends that only some
+// builds include are imported from files in this package with a build tag of
+// their own, such as backend_example.go with:
+//
+//	//go:build example
+//
+//	package backend
+//
+//	import _ "example.com/ollama-backend"
 package backend
 
 import (
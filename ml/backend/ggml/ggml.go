@@ -49,7 +49,8 @@ type Backend struct {
 	// output is the backend used for outputs
 	output *C.struct_ggml_backend_buffer_type
 
-	// layers is the backend used for repeating layers
+	// layers is the backend used for tensors created for repeating layers,
+	// which are their caches
 	layers map[int]*C.struct_ggml_backend_buffer_type
 
 	flashAttention bool
@@ -104,6 +105,15 @@ func New(r *os.File, params ml.BackendParams) (ml.Backend, error) {
 		}
 	}
 
+	// layers left on the cpu are kept in pinned memory of the first gpu when
+	// weights are streamed, so they're uploaded quickly for large batches
+	cpuLayerBufferType := cpuDeviceBufferType
+	if params.WeightStreaming && len(gpus) > 0 {
+		if hbt := C.ggml_backend_dev_host_buffer_type(gpus[0]); hbt != nil {
+			cpuLayerBufferType.bts = append([]*C.struct_ggml_backend_buffer_type{hbt}, cpuDeviceBufferType.bts...)
+		}
+	}
+
 	// create list of buffer types for each gpu
 	var gpuDeviceBufferTypes []deviceBufferType
 	for _, d := range gpus {
@@ -152,22 +162,50 @@ func New(r *os.File, params ml.BackendParams) (ml.Backend, error) {
 
 	blocks := int(meta.KV().BlockCount())
 
-	// define a range of gpu layers. anything outside of this range is assigned to the cpu
-	gpuRangeStart := max(0, blocks-params.NumGPULayers)
-	gpuRangeStop := min(gpuRangeStart+params.NumGPULayers, blocks+1)
+	// define the layers on gpus, where the output layer is blocks. anything else is assigned to the cpu
+	var gpuLayers []int
+	if params.Offload != nil {
+		gpuLayers = params.Offload.GPUBlocks
+		if params.Offload.AllBlocks {
+			gpuLayers = make([]int, blocks)
+			for i := range gpuLayers {
+				gpuLayers[i] = i
+			}
+		}
+
+		if params.Offload.Output {
+			gpuLayers = append(slices.Clip(gpuLayers), blocks)
+		}
+	} else {
+		for i := max(0, blocks-params.NumGPULayers); i <= blocks && len(gpuLayers) < params.NumGPULayers; i++ {
+			gpuLayers = append(gpuLayers, i)
+		}
+	}
+
 	assignLayer := func(i int) deviceBufferType {
-		if i < gpuRangeStart || i >= gpuRangeStop {
-			return cpuDeviceBufferType
+		n := slices.Index(gpuLayers, i)
+		if n < 0 {
+			return cpuLayerBufferType
 		}
 
-		index := slices.IndexFunc(splits, func(f float32) bool { return float32(i-gpuRangeStart)/float32(gpuRangeStop-gpuRangeStart) < f })
+		index := slices.IndexFunc(splits, func(f float32) bool { return float32(n)/float32(len(gpuLayers)) < f })
 		if index < 0 || index >= len(gpuDeviceBufferTypes) {
-			return cpuDeviceBufferType
+			return cpuLayerBufferType
 		}
 
 		return gpuDeviceBufferTypes[index]
 	}
 
+	// embeddings are on the cpu unless they're offloaded, in which case they
+	// go with the first gpu layer
+	embeddings := input
+	if params.Offload != nil && params.Offload.Embeddings && len(gpuDeviceBufferTypes) > 0 {
+		embeddings = gpuDeviceBufferTypes[0]
+		if len(gpuLayers) > 0 {
+			embeddings = assignLayer(gpuLayers[0])
+		}
+	}
+
 	// repeating layers are assigned based on their index in reverse order, e.g. i / (block_count + 1)
 	layers := make([]deviceBufferType, blocks)
 	for i := range layers {
@@ -239,7 +277,7 @@ func New(r *os.File, params ml.BackendParams) (ml.Backend, error) {
 	for _, t := range meta.Tensors().Items() {
 		switch {
 		case contains(t.Name, "position_embd", "token_embd", "token_norm_embd", "token_types"):
-			createTensor(tensor{source: t}, input.bts)
+			createTensor(tensor{source: t}, embeddings.bts)
 			if _, ok := meta.Tensors().GroupLayers()["output"]; !ok && t.Name == "token_embd.weight" {
 				createTensor(tensor{source: t, target: "output.weight"}, output.bts)
 			}
@@ -334,6 +372,19 @@ func New(r *os.File, params ml.BackendParams) (ml.Backend, error) {
 		return nil, err
 	}
 
+	if params.UseMLock {
+		for bs := range maps.Values(bbs) {
+			if !C.ggml_backend_buffer_is_host(bs) {
+				continue
+			}
+
+			base := (*byte)(C.ggml_backend_buffer_get_base(bs))
+			if err := mlock(unsafe.Slice(base, C.ggml_backend_buffer_get_size(bs))); err != nil {
+				slog.Warn("failed to lock model weights in memory", "buffer", C.GoString(C.ggml_backend_buffer_name(bs)), "error", err)
+			}
+		}
+	}
+
 	// map devices to backend buffer types so new tensors can be assigned to the correct device
 	deviceBufferTypes := make(map[*C.struct_ggml_backend_device]*C.struct_ggml_backend_buffer_type)
 
@@ -380,6 +431,10 @@ func New(r *os.File, params ml.BackendParams) (ml.Backend, error) {
 		layers: func() map[int]*C.struct_ggml_backend_buffer_type {
 			m := make(map[int]*C.struct_ggml_backend_buffer_type)
 			for i, layer := range layers {
+				if params.Offload != nil && !params.Offload.KVCache {
+					// caches are created in the context of their layer
+					layer = cpuDeviceBufferType
+				}
 				m[i] = deviceBufferTypes[layer.d]
 			}
 			return m
@@ -933,6 +988,35 @@ func (t *Tensor) RoPE(ctx ml.Context, positionIDs, ropeFactors ml.Tensor, ropeDi
 	}
 }
 
+func (t *Tensor) RoPEMulti(ctx ml.Context, positionIDs ml.Tensor, ropeDim uint32, sections [4]int, ropeType uint32, ropeBase, ropeScale float32) ml.Tensor {
+	dequant := t.t
+	if C.ggml_is_quantized(t.t._type) {
+		dequant = C.ggml_cast(ctx.(*Context).ctx, t.t, C.GGML_TYPE_F32)
+	}
+
+	var cSections [4]C.int
+	for i, section := range sections {
+		cSections[i] = C.int(section)
+	}
+
+	return &Tensor{
+		b: t.b,
+		t: C.ggml_rope_multi(
+			ctx.(*Context).ctx, dequant, positionIDs.(*Tensor).t, nil,
+			C.int(ropeDim),
+			&cSections[0],
+			C.int(ropeType),
+			131072, // YaRN n_ctx_train
+			C.float(ropeBase),
+			C.float(ropeScale),
+			0.,  // YaRN ext_factor
+			1.,  // YaRN attn_factor
+			32., // YaRN beta_fast
+			1.,  // YaRN beta_slow
+		),
+	}
+}
+
 func (t *Tensor) GELU(ctx ml.Context) ml.Tensor {
 	return &Tensor{
 		b: t.b,
@@ -940,6 +1024,13 @@ func (t *Tensor) GELU(ctx ml.Context) ml.Tensor {
 	}
 }
 
+func (t *Tensor) QuickGELU(ctx ml.Context) ml.Tensor {
+	return &Tensor{
+		b: t.b,
+		t: C.ggml_gelu_quick_inplace(ctx.(*Context).ctx, t.t),
+	}
+}
+
 func (t *Tensor) SILU(ctx ml.Context) ml.Tensor {
 	return &Tensor{
 		b: t.b,
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package ggml
+
+import "syscall"
+
+// mlock locks b in memory so it isn't swapped out.
+func mlock(b []byte) error {
+	return syscall.Mlock(b)
+}
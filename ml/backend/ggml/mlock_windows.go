@@ -0,0 +1,17 @@
+package ggml
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// mlock locks b in memory so it isn't paged out. Windows limits how much a
+// process can lock by its working set size.
+func mlock(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+
+	return windows.VirtualLock(uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+}
@@ -0,0 +1,131 @@
+package ggml
+
+// #cgo CPPFLAGS: -I${SRCDIR}/ggml/include
+// #include <stdlib.h>
+// #include <stdint.h>
+// #include "ggml.h"
+//
+// static bool ggml_can_convert_to_f32(enum ggml_type type) {
+//   return type == GGML_TYPE_F32 || ggml_get_type_traits(type)->to_float != NULL;
+// }
+//
+// static void ggml_convert_to_f32(enum ggml_type type, const void * src, float * dst, int64_t n) {
+//   ggml_get_type_traits(type)->to_float(src, dst, n);
+// }
+import "C"
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// initTables initializes the lookup tables which ggml uses to convert from
+// f16, which are filled in the first time a context is created.
+var initTables = sync.OnceFunc(func() {
+	C.ggml_free(C.ggml_init(C.struct_ggml_init_params{no_alloc: true}))
+})
+
+// RowSize returns the size in bytes of a row of n values of tensor type kind.
+func RowSize(kind uint32, n uint64) uint64 {
+	return uint64(C.ggml_row_size(C.enum_ggml_type(kind), C.int64_t(n)))
+}
+
+// BlockSize returns the number of values in each block of tensor type kind.
+// Rows of tensors of this type must be a multiple of it.
+func BlockSize(kind uint32) uint64 {
+	return uint64(C.ggml_blck_size(C.enum_ggml_type(kind)))
+}
+
+// CanQuantize reports whether tensors can be quantized to tensor type kind.
+func CanQuantize(kind uint32) bool {
+	switch kind {
+	case C.GGML_TYPE_F32, C.GGML_TYPE_F16, C.GGML_TYPE_BF16,
+		C.GGML_TYPE_Q4_0, C.GGML_TYPE_Q4_1, C.GGML_TYPE_Q5_0, C.GGML_TYPE_Q5_1, C.GGML_TYPE_Q8_0,
+		C.GGML_TYPE_Q2_K, C.GGML_TYPE_Q3_K, C.GGML_TYPE_Q4_K, C.GGML_TYPE_Q5_K, C.GGML_TYPE_Q6_K,
+		C.GGML_TYPE_IQ1_S, C.GGML_TYPE_IQ1_M, C.GGML_TYPE_IQ2_XXS, C.GGML_TYPE_IQ2_XS, C.GGML_TYPE_IQ2_S,
+		C.GGML_TYPE_IQ3_XXS, C.GGML_TYPE_IQ3_S, C.GGML_TYPE_IQ4_NL, C.GGML_TYPE_IQ4_XS:
+		return true
+	default:
+		return false
+	}
+}
+
+// RequiresImportance reports whether tensors can only be quantized to tensor
+// type kind with an importance matrix.
+func RequiresImportance(kind uint32) bool {
+	// like llama.cpp, iq1_m isn't quantized without one although ggml allows it
+	return kind == C.GGML_TYPE_IQ1_M || kind < C.GGML_TYPE_COUNT && bool(C.ggml_quantize_requires_imatrix(C.enum_ggml_type(kind)))
+}
+
+// ConvertToF32 converts n values of tensor type kind in data to float32.
+func ConvertToF32(data []byte, kind uint32, n uint64) ([]float32, error) {
+	if kind >= C.GGML_TYPE_COUNT || !C.ggml_can_convert_to_f32(C.enum_ggml_type(kind)) {
+		return nil, fmt.Errorf("can't convert tensor type %d to f32", kind)
+	}
+
+	if n%BlockSize(kind) != 0 || uint64(len(data)) < RowSize(kind, n) {
+		return nil, fmt.Errorf("invalid data for %d values of tensor type %d", n, kind)
+	}
+
+	initTables()
+
+	f32s := make([]float32, n)
+	if n == 0 {
+		return f32s, nil
+	}
+
+	if kind == C.GGML_TYPE_F32 {
+		copy(f32s, unsafe.Slice((*float32)(unsafe.Pointer(&data[0])), n))
+		return f32s, nil
+	}
+
+	C.ggml_convert_to_f32(C.enum_ggml_type(kind), unsafe.Pointer(&data[0]), (*C.float)(&f32s[0]), C.int64_t(n))
+	return f32s, nil
+}
+
+// Quantize converts rows of nPerRow float32 values in data to tensor type
+// kind, which must be one that CanQuantize. importance, the importance of
+// each column of the rows, weights the error of the quantization towards the
+// columns which affect the output the most. It's optional unless the type
+// RequiresImportance.
+func Quantize(kind uint32, data []float32, nPerRow uint64, importance []float32) ([]byte, error) {
+	if !CanQuantize(kind) {
+		return nil, fmt.Errorf("can't quantize to tensor type %d", kind)
+	}
+
+	if nPerRow == 0 || nPerRow%BlockSize(kind) != 0 || uint64(len(data))%nPerRow != 0 {
+		return nil, fmt.Errorf("can't quantize rows of %d values to tensor type %d", nPerRow, kind)
+	}
+
+	var imatrix *C.float
+	if importance != nil {
+		if uint64(len(importance)) != nPerRow {
+			return nil, fmt.Errorf("expected importance of %d columns, got %d", nPerRow, len(importance))
+		}
+
+		imatrix = (*C.float)(&importance[0])
+	} else if RequiresImportance(kind) {
+		return nil, fmt.Errorf("tensor type %d requires an importance matrix", kind)
+	}
+
+	initTables()
+
+	nrows := uint64(len(data)) / nPerRow
+	quantized := make([]byte, RowSize(kind, nPerRow)*nrows)
+	if nrows == 0 {
+		return quantized, nil
+	}
+
+	C.ggml_quantize_chunk(
+		C.enum_ggml_type(kind),
+		(*C.float)(&data[0]),
+		unsafe.Pointer(&quantized[0]),
+		0,
+		C.int64_t(nrows),
+		C.int64_t(nPerRow),
+		imatrix,
+	)
+
+	return quantized, nil
+}
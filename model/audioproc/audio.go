@@ -0,0 +1,183 @@
+package audioproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// SampleRate is the sample rate of the audio that audio models take, in Hz.
+const SampleRate = 16000
+
+var (
+	ErrUnknownFormat = errors.New("unknown audio format")
+	ErrMP3           = errors.New("mp3 audio is not supported yet, convert it to wav")
+)
+
+// Decode returns the samples of the audio in r, mixed down to a single
+// channel and scaled to [-1, 1], and their sample rate. Only WAV is
+// supported.
+func Decode(r io.Reader) ([]float32, int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch {
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return decodeWAV(data[12:])
+	case bytes.HasPrefix(data, []byte("ID3")), len(data) >= 2 && data[0] == 0xff && data[1]&0xe0 == 0xe0:
+		return nil, 0, ErrMP3
+	default:
+		return nil, 0, ErrUnknownFormat
+	}
+}
+
+// Load decodes the audio in r and resamples it to SampleRate.
+func Load(r io.Reader) ([]float32, error) {
+	samples, rate, err := Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return Resample(samples, rate, SampleRate), nil
+}
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xfffe
+)
+
+// decodeWAV decodes the chunks of a WAV file following its RIFF header.
+func decodeWAV(data []byte) ([]float32, int, error) {
+	var format, channels, bits uint16
+	var rate uint32
+	for len(data) >= 8 {
+		id, size := string(data[:4]), binary.LittleEndian.Uint32(data[4:8])
+		data = data[8:]
+		if uint64(size) > uint64(len(data)) {
+			// a truncated data chunk still holds the samples written
+			// before it was cut off
+			if id != "data" {
+				return nil, 0, fmt.Errorf("wav: truncated %q chunk", id)
+			}
+			size = uint32(len(data))
+		}
+
+		chunk := data[:size]
+		switch id {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return nil, 0, errors.New("wav: invalid fmt chunk")
+			}
+
+			format = binary.LittleEndian.Uint16(chunk[0:2])
+			channels = binary.LittleEndian.Uint16(chunk[2:4])
+			rate = binary.LittleEndian.Uint32(chunk[4:8])
+			bits = binary.LittleEndian.Uint16(chunk[14:16])
+			if format == wavFormatExtensible {
+				if len(chunk) < 26 {
+					return nil, 0, errors.New("wav: invalid fmt chunk")
+				}
+				// the format is the start of the sub format's GUID
+				format = binary.LittleEndian.Uint16(chunk[24:26])
+			}
+		case "data":
+			if channels == 0 || rate == 0 {
+				return nil, 0, errors.New("wav: missing fmt chunk")
+			}
+
+			samples, err := wavSamples(chunk, format, int(channels), int(bits))
+			if err != nil {
+				return nil, 0, err
+			}
+
+			return samples, int(rate), nil
+		}
+
+		// chunks are padded to an even size
+		data = data[min(int(size)+int(size&1), len(data)):]
+	}
+
+	return nil, 0, errors.New("wav: missing data chunk")
+}
+
+func wavSamples(data []byte, format uint16, channels, bits int) ([]float32, error) {
+	var sample func([]byte) float64
+	switch {
+	case format == wavFormatPCM && bits == 8:
+		sample = func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }
+	case format == wavFormatPCM && bits == 16:
+		sample = func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }
+	case format == wavFormatPCM && bits == 24:
+		sample = func(b []byte) float64 {
+			return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		}
+	case format == wavFormatPCM && bits == 32:
+		sample = func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case format == wavFormatFloat && bits == 32:
+		sample = func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }
+	case format == wavFormatFloat && bits == 64:
+		sample = func(b []byte) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(b)) }
+	default:
+		return nil, fmt.Errorf("wav: unsupported format %d with %d bits per sample", format, bits)
+	}
+
+	size := bits / 8
+	frame := size * channels
+	samples := make([]float32, len(data)/frame)
+	for i := range samples {
+		var sum float64
+		for c := range channels {
+			sum += sample(data[i*frame+c*size:])
+		}
+		samples[i] = float32(sum / float64(channels))
+	}
+
+	return samples, nil
+}
+
+// resampleZeros is how many zero crossings of the sinc are used on each side
+// of a sample when resampling.
+const resampleZeros = 16
+
+// Resample returns samples converted from one sample rate to another with a
+// windowed sinc filter, which also removes frequencies that the new rate
+// can't represent.
+func Resample(samples []float32, from, to int) []float32 {
+	if from == to || len(samples) == 0 {
+		return samples
+	}
+
+	ratio := float64(to) / float64(from)
+	// the cutoff is relative to the input's Nyquist frequency
+	cutoff := min(ratio, 1)
+	width := resampleZeros / cutoff
+
+	out := make([]float32, int(float64(len(samples))*ratio))
+	for i := range out {
+		t := float64(i) / ratio
+
+		var sum float64
+		for j := max(int(math.Ceil(t-width)), 0); j <= min(int(math.Floor(t+width)), len(samples)-1); j++ {
+			x := (t - float64(j)) * cutoff
+			window := 0.5 + 0.5*math.Cos(math.Pi*x/resampleZeros)
+			sum += float64(samples[j]) * cutoff * sinc(x) * window
+		}
+		out[i] = float32(sum)
+	}
+
+	return out
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
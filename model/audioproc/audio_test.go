@@ -0,0 +1,167 @@
+package audioproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+func wav(format, channels uint16, rate uint32, bits uint16, samples any) []byte {
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, samples)
+
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(4+8+16+8+8+data.Len()))
+	b.WriteString("WAVE")
+	// an unknown chunk of odd size, which is padded
+	b.WriteString("LIST")
+	binary.Write(&b, binary.LittleEndian, uint32(3))
+	b.Write([]byte{1, 2, 3, 0})
+	b.WriteString("fmt ")
+	for _, v := range []any{uint32(16), format, channels, rate, rate * uint32(channels*bits/8), channels * bits / 8, bits} {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(data.Len()))
+	b.Write(data.Bytes())
+	return b.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	cases := []struct {
+		name string
+		data []byte
+		rate int
+		want []float32
+		err  error
+	}{
+		{
+			name: "pcm16 stereo",
+			data: wav(wavFormatPCM, 2, 44100, 16, []int16{16384, 0, -32768, -32768, 0, 8192}),
+			rate: 44100,
+			want: []float32{0.25, -1, 0.125},
+		},
+		{
+			name: "pcm8",
+			data: wav(wavFormatPCM, 1, 8000, 8, []uint8{128, 192, 0}),
+			rate: 8000,
+			want: []float32{0, 0.5, -1},
+		},
+		{
+			name: "pcm24",
+			data: wav(wavFormatPCM, 1, 16000, 24, []uint8{0, 0, 0x40, 0, 0, 0xc0}),
+			rate: 16000,
+			want: []float32{0.5, -0.5},
+		},
+		{
+			name: "float32",
+			data: wav(wavFormatFloat, 1, 22050, 32, []float32{0.75, -0.25}),
+			rate: 22050,
+			want: []float32{0.75, -0.25},
+		},
+		{name: "mp3", data: []byte("ID3\x04\x00\x00\x00\x00\x00\x00"), err: ErrMP3},
+		{name: "unknown", data: []byte("not audio"), err: ErrUnknownFormat},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			samples, rate, err := Decode(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+
+			if rate != tt.rate {
+				t.Errorf("expected rate %d, got %d", tt.rate, rate)
+			}
+
+			if len(samples) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, samples)
+			}
+
+			for i := range samples {
+				if math.Abs(float64(samples[i]-tt.want[i])) > 1e-6 {
+					t.Fatalf("expected %v, got %v", tt.want, samples)
+				}
+			}
+		})
+	}
+}
+
+func sine(hz float64, rate, n int) []float32 {
+	s := make([]float32, n)
+	for i := range s {
+		s[i] = float32(math.Sin(2 * math.Pi * hz * float64(i) / float64(rate)))
+	}
+	return s
+}
+
+func TestResample(t *testing.T) {
+	for _, rate := range []int{8000, 44100, 48000} {
+		got := Resample(sine(440, rate, rate), rate, SampleRate)
+		if len(got) != SampleRate {
+			t.Fatalf("%d: expected %d samples, got %d", rate, SampleRate, len(got))
+		}
+
+		// ignore the ends, where the filter runs out of samples
+		want := sine(440, SampleRate, SampleRate)
+		for i := 1000; i < len(got)-1000; i++ {
+			if math.Abs(float64(got[i]-want[i])) > 1e-2 {
+				t.Fatalf("%d: sample %d: expected %v, got %v", rate, i, want[i], got[i])
+			}
+		}
+	}
+
+	// a tone above the new Nyquist frequency is filtered out
+	got := Resample(sine(12000, 48000, 48000), 48000, SampleRate)
+	for i := 1000; i < len(got)-1000; i++ {
+		if math.Abs(float64(got[i])) > 1e-2 {
+			t.Fatalf("sample %d: expected 0, got %v", i, got[i])
+		}
+	}
+}
+
+func TestFFT(t *testing.T) {
+	x := make([]complex128, FFTSize)
+	for i := range x {
+		x[i] = complex(math.Sin(float64(i*i)), math.Cos(float64(i)))
+	}
+
+	got := fft(x)
+	for k := range x {
+		var want complex128
+		for n, v := range x {
+			want += v * cmplx.Rect(1, -2*math.Pi*float64(k*n)/FFTSize)
+		}
+
+		if cmplx.Abs(got[k]-want) > 1e-9 {
+			t.Fatalf("bin %d: expected %v, got %v", k, want, got[k])
+		}
+	}
+}
+
+func TestLogMelSpectrogram(t *testing.T) {
+	const numMels = 80
+	mel, frames := LogMelSpectrogram(sine(1000, SampleRate, SampleRate), numMels)
+	if frames != SampleRate/HopLength || len(mel) != numMels*frames {
+		t.Fatalf("expected %d frames, got %d with %d values", SampleRate/HopLength, frames, len(mel))
+	}
+
+	// the loudest band of every frame is the one around 1kHz
+	want := int(math.Round((hzToMel(1000) - hzToMel(0)) / (hzToMel(SampleRate/2) - hzToMel(0)) * (numMels + 1)))
+	for f := range frames {
+		loudest := 0
+		for m := range numMels {
+			if mel[m*frames+f] > mel[loudest*frames+f] {
+				loudest = m
+			}
+		}
+
+		if loudest != want-1 && loudest != want {
+			t.Fatalf("frame %d: expected band %d to be loudest, got %d", f, want, loudest)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package audioproc
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+const (
+	// FFTSize is the number of samples in each frame of a spectrogram, 25ms
+	// at SampleRate.
+	FFTSize = 400
+
+	// HopLength is the number of samples between the starts of frames, 10ms
+	// at SampleRate.
+	HopLength = 160
+)
+
+// LogMelSpectrogram returns the log mel spectrogram of samples at SampleRate
+// as computed by Whisper's feature extractor, which most audio models share.
+// It has numMels rows, each with a value for every HopLength samples, and is
+// returned along with the number of frames.
+func LogMelSpectrogram(samples []float32, numMels int) ([]float32, int) {
+	if len(samples) == 0 {
+		return nil, 0
+	}
+
+	// frames are centered on their position, with the ends of the audio
+	// reflected to fill the frames that extend past them
+	padded := make([]float64, len(samples)+FFTSize)
+	for i := range padded {
+		padded[i] = float64(samples[reflect(i-FFTSize/2, len(samples))])
+	}
+
+	window := make([]float64, FFTSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/FFTSize)
+	}
+
+	filters := melFilters(numMels, FFTSize/2+1, SampleRate)
+
+	// Whisper drops the last frame, which makes a frame for every hop
+	numFrames := len(samples) / HopLength
+	mel := make([]float64, numMels*numFrames)
+	frame := make([]complex128, FFTSize)
+	power := make([]float64, FFTSize/2+1)
+	for f := range numFrames {
+		for i := range frame {
+			frame[i] = complex(padded[f*HopLength+i]*window[i], 0)
+		}
+
+		spectrum := fft(frame)
+		for i := range power {
+			power[i] = real(spectrum[i])*real(spectrum[i]) + imag(spectrum[i])*imag(spectrum[i])
+		}
+
+		for m := range numMels {
+			var sum float64
+			for i, w := range filters[m] {
+				sum += w * power[i]
+			}
+			mel[m*numFrames+f] = math.Log10(max(sum, 1e-10))
+		}
+	}
+
+	// limit the range to 80dB below the loudest value and scale it to about
+	// [-1, 1]
+	peak := math.Inf(-1)
+	for _, v := range mel {
+		peak = max(peak, v)
+	}
+
+	out := make([]float32, len(mel))
+	for i, v := range mel {
+		out[i] = float32((max(v, peak-8) + 4) / 4)
+	}
+
+	return out, numFrames
+}
+
+// reflect returns the index of the sample that i refers to when the samples
+// are mirrored past each end.
+func reflect(i, n int) int {
+	if n == 1 {
+		return 0
+	}
+
+	period := 2 * (n - 1)
+	i = ((i % period) + period) % period
+	if i >= n {
+		i = period - i
+	}
+
+	return i
+}
+
+// melFilters returns triangular filters which sum the frequency bins of a
+// spectrum into numMels bands on the Slaney mel scale, normalized so each
+// band has the same area.
+func melFilters(numMels, numBins, sampleRate int) [][]float64 {
+	minMel, maxMel := hzToMel(0), hzToMel(float64(sampleRate)/2)
+	hz := make([]float64, numMels+2)
+	for i := range hz {
+		hz[i] = melToHz(minMel + (maxMel-minMel)*float64(i)/float64(numMels+1))
+	}
+
+	filters := make([][]float64, numMels)
+	for m := range filters {
+		filters[m] = make([]float64, numBins)
+		norm := 2 / (hz[m+2] - hz[m])
+		for i := range numBins {
+			f := float64(i) * float64(sampleRate) / float64(2*(numBins-1))
+			lower := (f - hz[m]) / (hz[m+1] - hz[m])
+			upper := (hz[m+2] - f) / (hz[m+2] - hz[m+1])
+			filters[m][i] = max(0, min(lower, upper)) * norm
+		}
+	}
+
+	return filters
+}
+
+// The Slaney mel scale is linear below 1kHz and logarithmic above it.
+const (
+	melMinLogHz  = 1000.0
+	melMinLogMel = 15.0
+	melLogStep   = 0.06875177742094912 // ln(6.4) / 27
+)
+
+func hzToMel(hz float64) float64 {
+	if hz < melMinLogHz {
+		return 3 * hz / 200
+	}
+
+	return melMinLogMel + math.Log(hz/melMinLogHz)/melLogStep
+}
+
+func melToHz(mel float64) float64 {
+	if mel < melMinLogMel {
+		return 200 * mel / 3
+	}
+
+	return melMinLogHz * math.Exp(melLogStep*(mel-melMinLogMel))
+}
+
+// fft returns the discrete Fourier transform of x. Even lengths are split in
+// half recursively and odd lengths are transformed directly.
+func fft(x []complex128) []complex128 {
+	n := len(x)
+	if n == 1 {
+		return []complex128{x[0]}
+	}
+
+	if n%2 == 1 {
+		twiddles := make([]complex128, n)
+		for i := range twiddles {
+			twiddles[i] = cmplx.Rect(1, -2*math.Pi*float64(i)/float64(n))
+		}
+
+		out := make([]complex128, n)
+		for k := range out {
+			for t, v := range x {
+				out[k] += v * twiddles[k*t%n]
+			}
+		}
+		return out
+	}
+
+	even, odd := make([]complex128, n/2), make([]complex128, n/2)
+	for i := range n / 2 {
+		even[i], odd[i] = x[2*i], x[2*i+1]
+	}
+
+	even, odd = fft(even), fft(odd)
+
+	out := make([]complex128, n)
+	for k := range n / 2 {
+		t := cmplx.Rect(1, -2*math.Pi*float64(k)/float64(n)) * odd[k]
+		out[k] = even[k] + t
+		out[k+n/2] = even[k] - t
+	}
+
+	return out
+}
@@ -0,0 +1,160 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Decode decodes an image and turns it upright according to its EXIF
+// orientation, which cameras set instead of rotating the pixels of photos.
+// It returns the image along with the name of its format.
+func Decode(r io.Reader) (image.Image, string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if format == "jpeg" {
+		img = Orient(img, Orientation(data))
+	}
+
+	return img, format, nil
+}
+
+// Orientation returns the EXIF orientation of a JPEG image, from 1 to 8, or 1
+// if it has none.
+func Orientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+
+	data = data[2:]
+	for len(data) >= 4 && data[0] == 0xff {
+		marker := data[1]
+		// the image data follows the start of scan, so there are no more
+		// metadata segments
+		if marker == 0xda {
+			break
+		}
+
+		size := int(binary.BigEndian.Uint16(data[2:4]))
+		if size < 2 || 2+size > len(data) {
+			break
+		}
+
+		segment := data[4 : 2+size]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			if orientation, ok := exifOrientation(segment[6:]); ok {
+				return orientation
+			}
+		}
+
+		data = data[2+size:]
+	}
+
+	return 1
+}
+
+// exifOrientation returns the orientation tag of the first IFD of EXIF data,
+// which is laid out as a TIFF file.
+func exifOrientation(tiff []byte) (int, bool) {
+	if len(tiff) < 8 {
+		return 0, false
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0, false
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0, false
+	}
+
+	entries := int(order.Uint16(tiff[offset:]))
+	for i := range entries {
+		entry := offset + 2 + 12*i
+		if entry+12 > len(tiff) {
+			break
+		}
+
+		// the orientation is a single short, stored in the first bytes of
+		// the entry's value
+		const tagOrientation, typeShort = 0x0112, 3
+		if order.Uint16(tiff[entry:]) == tagOrientation && order.Uint16(tiff[entry+2:]) == typeShort {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 0, false
+			}
+
+			return orientation, true
+		}
+	}
+
+	return 0, false
+}
+
+// Orient returns an image flipped and rotated so that an image with the given
+// EXIF orientation is displayed upright.
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	size := image.Point{w, h}
+	if orientation >= 5 {
+		// orientations 5 to 8 swap the width and height
+		size = image.Point{h, w}
+	}
+
+	dst := image.NewRGBA(image.Rectangle{Max: size})
+	for y := range size.Y {
+		for x := range size.X {
+			var sx, sy int
+			switch orientation {
+			case 2: // flip horizontally
+				sx, sy = w-1-x, y
+			case 3: // turn 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // flip vertically
+				sx, sy = x, h-1-y
+			case 5: // flip along the top left to bottom right diagonal
+				sx, sy = y, x
+			case 6: // turn 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // flip along the top right to bottom left diagonal
+				sx, sy = w-1-y, h-1-x
+			case 8: // turn 90° counterclockwise
+				sx, sy = w-1-y, x
+			}
+
+			copy(dst.Pix[dst.PixOffset(x, y):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,115 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+// exifJPEG returns a JPEG of an image with an EXIF orientation.
+func exifJPEG(t *testing.T, img image.Image, order binary.ByteOrder, orientation uint16) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	var tiff bytes.Buffer
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	// the magic number, then the offset of the first IFD and its single
+	// entry, followed by the offset of the next IFD
+	for _, v := range []any{uint16(42), uint32(8), uint16(1), uint16(0x0112), uint16(3), uint32(1), orientation, uint16(0), uint32(0)} {
+		binary.Write(&tiff, order, v)
+	}
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	var out bytes.Buffer
+	out.Write(b.Bytes()[:2])
+	out.Write([]byte{0xff, 0xe1})
+	binary.Write(&out, binary.BigEndian, uint16(2+len(segment)))
+	out.Write(segment)
+	out.Write(b.Bytes()[2:])
+	return out.Bytes()
+}
+
+func TestDecodeOrientation(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+
+	// the left half of the image is red and the right half is blue
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	draw.Draw(img, image.Rect(0, 0, 16, 16), &image.Uniform{red}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(16, 0, 32, 16), &image.Uniform{blue}, image.Point{}, draw.Src)
+
+	cases := []struct {
+		orientation     uint16
+		order           binary.ByteOrder
+		size            image.Point
+		topLeft, bottomRight color.RGBA
+	}{
+		{1, binary.BigEndian, image.Point{32, 16}, red, blue},
+		{2, binary.LittleEndian, image.Point{32, 16}, blue, red},
+		{3, binary.BigEndian, image.Point{32, 16}, blue, red},
+		{6, binary.BigEndian, image.Point{16, 32}, red, blue},
+		{6, binary.LittleEndian, image.Point{16, 32}, red, blue},
+		{8, binary.LittleEndian, image.Point{16, 32}, blue, red},
+	}
+
+	for _, tt := range cases {
+		data := exifJPEG(t, img, tt.order, tt.orientation)
+		if got := Orientation(data); got != int(tt.orientation) {
+			t.Fatalf("expected orientation %d, got %d", tt.orientation, got)
+		}
+
+		decoded, format, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if format != "jpeg" {
+			t.Errorf("expected jpeg, got %s", format)
+		}
+
+		if size := decoded.Bounds().Size(); size != tt.size {
+			t.Fatalf("orientation %d: expected size %v, got %v", tt.orientation, tt.size, size)
+		}
+
+		// the corners are far enough from the edge between the colors to
+		// survive compression
+		for _, p := range []struct {
+			at   image.Point
+			want color.RGBA
+		}{
+			{image.Point{1, 1}, tt.topLeft},
+			{tt.size.Sub(image.Point{2, 2}), tt.bottomRight},
+		} {
+			r, g, b, _ := decoded.At(p.at.X, p.at.Y).RGBA()
+			if r>>8 < 128 != (p.want.R < 128) || b>>8 < 128 != (p.want.B < 128) || g>>8 > 64 {
+				t.Errorf("orientation %d: expected %v at %v, got %v", tt.orientation, p.want, p.at, decoded.At(p.at.X, p.at.Y))
+			}
+		}
+	}
+}
+
+func TestOrientationInvalid(t *testing.T) {
+	for _, data := range [][]byte{
+		nil,
+		[]byte("not a jpeg"),
+		{0xff, 0xd8, 0xff, 0xe1, 0xff, 0xff},
+		{0xff, 0xd8, 0xff, 0xe1, 0x00, 0x0a, 'E', 'x', 'i', 'f', 0, 0, 'I', 'I'},
+	} {
+		if got := Orientation(data); got != 1 {
+			t.Errorf("%q: expected 1, got %d", data, got)
+		}
+	}
+}
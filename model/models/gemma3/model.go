@@ -2,7 +2,6 @@ package gemma3
 
 import (
 	"bytes"
-	"image"
 	"math"
 	"slices"
 
@@ -10,6 +9,7 @@ import (
 	"github.com/ollama/ollama/ml"
 	"github.com/ollama/ollama/ml/nn"
 	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/imageproc"
 	"github.com/ollama/ollama/model/input"
 )
 
@@ -87,7 +87,7 @@ func (m *Model) EncodeMultimodal(ctx ml.Context, multimodalData []byte) (any, er
 		return nil, model.ErrNoVisionModel
 	}
 
-	image, _, err := image.Decode(bytes.NewReader(multimodalData))
+	image, _, err := imageproc.Decode(bytes.NewReader(multimodalData))
 	if err != nil {
 		return nil, err
 	}
@@ -35,9 +35,18 @@ func New(c ml.Config) (model.Model, error) {
 		return nil, fmt.Errorf("tokenizer %s not yet supported", c.String("tokenizer.ggml.model"))
 	}
 
+	pre, err := model.Pretokenizer(c.String("tokenizer.ggml.pre"))
+	if err != nil {
+		return nil, err
+	}
+
+	ropeScale := c.Float("rope.freq_scale", 1)
+	if c.String("rope.scaling.type") == "linear" {
+		ropeScale = 1 / c.Float("rope.scaling.factor", 1)
+	}
+
 	m := Model{
 		BytePairEncoding: model.NewBytePairEncoding(
-			c.String("tokenizer.ggml.pretokenizer", `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`),
 			&model.Vocabulary{
 				Values: c.Strings("tokenizer.ggml.tokens"),
 				Types:  c.Uints("tokenizer.ggml.token_type"),
@@ -47,6 +56,7 @@ func New(c ml.Config) (model.Model, error) {
 				EOS:    int32(c.Uint("tokenizer.ggml.eos_token_id")),
 				AddEOS: c.Bool("tokenizer.ggml.add_eos_token", false),
 			},
+			pre...,
 		),
 		Layers: make([]Layer, c.Uint("block_count")),
 		Options: &Options{
@@ -55,7 +65,7 @@ func New(c ml.Config) (model.Model, error) {
 			numKVHeads: int(c.Uint("attention.head_count_kv")),
 			eps:        c.Float("attention.layer_norm_rms_epsilon"),
 			ropeBase:   c.Float("rope.freq_base"),
-			ropeScale:  c.Float("rope.freq_scale", 1),
+			ropeScale:  ropeScale,
 			ropeDim:    c.Uint("rope.dimension_count"),
 		},
 	}
@@ -76,15 +86,14 @@ type SelfAttention struct {
 func (sa *SelfAttention) Forward(ctx ml.Context, hiddenState, positionIDs ml.Tensor, cache kvcache.Cache, opts *Options) ml.Tensor {
 	batchSize := hiddenState.Dim(1)
 	headDim := opts.hiddenSize / opts.numHeads
-	ropeType := uint32(0)
 
 	q := sa.Query.Forward(ctx, hiddenState)
 	q = q.Reshape(ctx, headDim, opts.numHeads, batchSize)
-	q = q.RoPE(ctx, positionIDs, sa.RopeFactors, opts.ropeDim, ropeType, opts.ropeBase, opts.ropeScale)
+	q = opts.applyRotaryPositionEmbeddings(ctx, q, positionIDs, sa.RopeFactors)
 
 	k := sa.Key.Forward(ctx, hiddenState)
 	k = k.Reshape(ctx, headDim, opts.numKVHeads, batchSize)
-	k = k.RoPE(ctx, positionIDs, sa.RopeFactors, opts.ropeDim, ropeType, opts.ropeBase, opts.ropeScale)
+	k = opts.applyRotaryPositionEmbeddings(ctx, k, positionIDs, sa.RopeFactors)
 
 	v := sa.Value.Forward(ctx, hiddenState)
 	v = v.Reshape(ctx, headDim, opts.numKVHeads, batchSize)
@@ -96,8 +105,15 @@ func (sa *SelfAttention) Forward(ctx ml.Context, hiddenState, positionIDs ml.Ten
 	return sa.Output.Forward(ctx, kqv)
 }
 
+// applyRotaryPositionEmbeddings rotates each head of states by its position.
+// Rotations compose by adding positions, which is what lets Shift move keys
+// already in the cache by rotating them again.
+func (opts *Options) applyRotaryPositionEmbeddings(ctx ml.Context, states, positionIDs, ropeFactors ml.Tensor) ml.Tensor {
+	return states.RoPE(ctx, positionIDs, ropeFactors, opts.ropeDim, 0, opts.ropeBase, opts.ropeScale)
+}
+
 func (m *Model) Shift(ctx ml.Context, layer int, key, shift ml.Tensor) (ml.Tensor, error) {
-	return key.RoPE(ctx, shift, m.Layers[layer].SelfAttention.RopeFactors, uint32(0), m.ropeDim, m.ropeBase, m.ropeScale), nil
+	return m.applyRotaryPositionEmbeddings(ctx, key, shift, m.Layers[layer].SelfAttention.RopeFactors), nil
 }
 
 type MLP struct {
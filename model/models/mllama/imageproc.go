@@ -191,7 +191,7 @@ func Preprocess(imageData io.Reader) ([]float32, map[string]any, error) {
 	outputSize := image.Point{560, 560}
 	maxTiles := 4
 
-	img, format, err := image.Decode(imageData)
+	img, format, err := imageproc.Decode(imageData)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to decode image: %w", err)
 	}
@@ -5,13 +5,13 @@ import (
 	"encoding/binary"
 	"fmt"
 	"hash/fnv"
-	"image"
 	"slices"
 
 	"github.com/ollama/ollama/kvcache"
 	"github.com/ollama/ollama/ml"
 	"github.com/ollama/ollama/ml/nn"
 	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/imageproc"
 	"github.com/ollama/ollama/model/input"
 )
 
@@ -37,9 +37,14 @@ func New(c ml.Config) (model.Model, error) {
 	if c.Uint("vision.block_count") == 0 {
 		return nil, fmt.Errorf("non-unified vision model not supported")
 	}
+
+	pre, err := model.Pretokenizer(c.String("tokenizer.ggml.pre"))
+	if err != nil {
+		return nil, err
+	}
+
 	m := Model{
 		BytePairEncoding: model.NewBytePairEncoding(
-			c.String("tokenizer.ggml.pretokenizer", `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`),
 			&model.Vocabulary{
 				Values: c.Strings("tokenizer.ggml.tokens"),
 				Types:  c.Uints("tokenizer.ggml.token_type"),
@@ -49,6 +54,7 @@ func New(c ml.Config) (model.Model, error) {
 				EOS:    int32(c.Uint("tokenizer.ggml.eos_token_id")),
 				AddEOS: c.Bool("tokenizer.ggml.add_eos_token", false),
 			},
+			pre...,
 		),
 		ImageProcessor: newImageProcessor(c),
 		VisionModel:    newVisionModel(c),
@@ -67,7 +73,7 @@ func (m *Model) EncodeMultimodal(ctx ml.Context, multimodalData []byte) (any, er
 		return nil, model.ErrNoVisionModel
 	}
 
-	image, _, err := image.Decode(bytes.NewReader(multimodalData))
+	image, _, err := imageproc.Decode(bytes.NewReader(multimodalData))
 	if err != nil {
 		return nil, err
 	}
@@ -136,11 +142,23 @@ func (m *Model) PostTokenize(inputs []input.Input) ([]input.Input, error) {
 }
 
 func (m *Model) Forward(ctx ml.Context, opts input.Options) (ml.Tensor, error) {
+	// The encoder cache keeps the most recent image of each sequence in the
+	// batch, which it expects to be concatenated in the order they appear
 	var crossAttentionStates ml.Tensor
-	if len(opts.Multimodal) > 0 {
-		images := opts.Multimodal[len(opts.Multimodal)-1].Multimodal.([]ml.Tensor)
-		if len(images) > 0 {
-			crossAttentionStates = images[len(images)-1]
+	for i, mm := range opts.Multimodal {
+		seq := opts.Sequences[mm.Index]
+		if slices.ContainsFunc(opts.Multimodal[i+1:], func(next input.MultimodalIndex) bool {
+			return opts.Sequences[next.Index] == seq
+		}) {
+			continue
+		}
+
+		images := mm.Multimodal.([]ml.Tensor)
+		image := images[len(images)-1]
+		if crossAttentionStates == nil {
+			crossAttentionStates = image
+		} else {
+			crossAttentionStates = crossAttentionStates.Concat(ctx, image, 2)
 		}
 	}
 
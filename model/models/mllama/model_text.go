@@ -21,15 +21,12 @@ type TextSelfAttention struct {
 func (sa *TextSelfAttention) Forward(ctx ml.Context, hidden, pos ml.Tensor, _ ml.Tensor, cache *kvcache.WrapperCache, opts *TextModelOptions) ml.Tensor {
 	bs := hidden.Dim(1)
 	hd := opts.hiddenSize / opts.numHeads
-	ropeType := uint32(0)
 
-	q := sa.Query.Forward(ctx, hidden).
-		Reshape(ctx, hd, opts.numHeads, bs).
-		RoPE(ctx, pos, sa.RopeFactors, opts.ropeDim, ropeType, opts.ropeBase, opts.ropeScale)
+	q := sa.Query.Forward(ctx, hidden).Reshape(ctx, hd, opts.numHeads, bs)
+	q = opts.applyRotaryPositionEmbeddings(ctx, q, pos, sa.RopeFactors)
 
-	k := sa.Key.Forward(ctx, hidden).
-		Reshape(ctx, hd, opts.numKVHeads, bs).
-		RoPE(ctx, pos, sa.RopeFactors, opts.ropeDim, ropeType, opts.ropeBase, opts.ropeScale)
+	k := sa.Key.Forward(ctx, hidden).Reshape(ctx, hd, opts.numKVHeads, bs)
+	k = opts.applyRotaryPositionEmbeddings(ctx, k, pos, sa.RopeFactors)
 
 	v := sa.Value.Forward(ctx, hidden).
 		Reshape(ctx, hd, opts.numKVHeads, bs)
@@ -41,9 +38,16 @@ func (sa *TextSelfAttention) Forward(ctx ml.Context, hidden, pos ml.Tensor, _ ml
 	return sa.Output.Forward(ctx, attn)
 }
 
+// applyRotaryPositionEmbeddings rotates each head of states by its position.
+func (opts *TextModelOptions) applyRotaryPositionEmbeddings(ctx ml.Context, states, pos, ropeFactors ml.Tensor) ml.Tensor {
+	return states.RoPE(ctx, pos, ropeFactors, opts.ropeDim, 0, opts.ropeBase, opts.ropeScale)
+}
+
+// Shift re-rotates the keys of self-attention layers. Keys of cross-attention
+// layers come from the image rather than the text and have no position.
 func (m *TextModel) Shift(ctx ml.Context, layer int, key, shift ml.Tensor) (ml.Tensor, error) {
 	if sa, ok := m.Transformer.Layers[layer].(*TextSelfAttentionDecoderLayer); ok {
-		return key.RoPE(ctx, shift, sa.SelfAttention.RopeFactors, m.ropeDim, 0, m.ropeBase, m.ropeScale), nil
+		return m.applyRotaryPositionEmbeddings(ctx, key, shift, sa.SelfAttention.RopeFactors), nil
 	}
 	return key, nil
 }
@@ -123,13 +127,20 @@ func (ca *TextCrossAttention) Forward(ctx ml.Context, hidden, enc ml.Tensor, cac
 		cache.Put(ctx, k, v)
 	}
 
-	k, v, _ = cache.Get(ctx)
+	k, v, mask := cache.Get(ctx)
 	scale := 1.0 / math.Sqrt(float64(hd))
 
 	attn := k.Permute(ctx, 0, 2, 1, 3).
 		MulmatFullPrec(ctx, q.Permute(ctx, 0, 2, 1, 3)).
-		Scale(ctx, scale).
-		Softmax(ctx)
+		Scale(ctx, scale)
+
+	// the mask keeps tokens from attending to images of other sequences in
+	// the batch
+	if mask != nil {
+		attn = attn.Add(ctx, mask)
+	}
+
+	attn = attn.Softmax(ctx)
 
 	res := v.Permute(ctx, 1, 2, 0, 3).
 		Contiguous(ctx).
@@ -157,12 +168,24 @@ func (d *TextCrossAttentionDecoderLayer) Forward(ctx ml.Context, hidden, _, _, _
 
 	hidden = d.AttentionNorm.Forward(ctx, hidden, opts.eps)
 	hidden = d.CrossAttention.Forward(ctx, hidden, enc, cache, opts)
-	hidden = hidden.Mul(ctx, d.AttentionGate.Tanh(ctx)).Add(ctx, res)
+	hidden = hidden.Mul(ctx, d.AttentionGate.Tanh(ctx))
+
+	// tokens of sequences without an image pass through the layer unchanged,
+	// as they would if they weren't batched with ones that have an image
+	outputMask := cache.UnderlyingCache().(*kvcache.EncoderCache).OutputMask()
+	if outputMask != nil {
+		hidden = hidden.Mul(ctx, outputMask)
+	}
+
+	hidden = hidden.Add(ctx, res)
 
 	res = hidden
 	hidden = d.MLPNorm.Forward(ctx, hidden, opts.eps)
 	hidden = d.MLP.Forward(ctx, hidden, opts).
 		Mul(ctx, d.MLPGate.Tanh(ctx))
+	if outputMask != nil {
+		hidden = hidden.Mul(ctx, outputMask)
+	}
 
 	return hidden.Add(ctx, res)
 }
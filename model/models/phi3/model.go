@@ -0,0 +1,224 @@
+package phi3
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+type Options struct {
+	hiddenSize, numHeads, numKVHeads int
+	eps, ropeBase, ropeScale         float32
+	ropeDim                          uint32
+}
+
+type Model struct {
+	model.Base
+	model.BytePairEncoding
+
+	TokenEmbedding *nn.Embedding `gguf:"token_embd"`
+	Layers         []Layer       `gguf:"blk"`
+	OutputNorm     *nn.RMSNorm   `gguf:"output_norm"`
+	Output         *nn.Linear    `gguf:"output,alt:token_embd"`
+
+	*Options
+}
+
+// New supports phi-4, which uses the phi3 architecture with a byte pair
+// encoding tokenizer. Earlier Phi-3 models use a sentencepiece tokenizer and
+// often LongRoPE, neither of which are supported yet.
+func New(c ml.Config) (model.Model, error) {
+	if !strings.EqualFold(c.String("tokenizer.ggml.model"), "gpt2") {
+		return nil, fmt.Errorf("tokenizer %s not yet supported", c.String("tokenizer.ggml.model"))
+	}
+
+	if c.Uint("rope.scaling.original_context_length") != 0 {
+		return nil, errors.New("longrope not yet supported")
+	}
+
+	pre, err := model.Pretokenizer(c.String("tokenizer.ggml.pre"))
+	if err != nil {
+		return nil, err
+	}
+
+	hiddenSize := int(c.Uint("embedding_length"))
+	numHeads := int(c.Uint("attention.head_count"))
+
+	m := Model{
+		BytePairEncoding: model.NewBytePairEncoding(
+			&model.Vocabulary{
+				Values: c.Strings("tokenizer.ggml.tokens"),
+				Types:  c.Uints("tokenizer.ggml.token_type"),
+				Merges: c.Strings("tokenizer.ggml.merges"),
+				BOS:    int32(c.Uint("tokenizer.ggml.bos_token_id")),
+				AddBOS: c.Bool("tokenizer.ggml.add_bos_token", false),
+				EOS:    int32(c.Uint("tokenizer.ggml.eos_token_id")),
+				AddEOS: c.Bool("tokenizer.ggml.add_eos_token", false),
+			},
+			pre...,
+		),
+		Layers: make([]Layer, c.Uint("block_count")),
+		Options: &Options{
+			hiddenSize: hiddenSize,
+			numHeads:   numHeads,
+			numKVHeads: int(c.Uint("attention.head_count_kv")),
+			eps:        c.Float("attention.layer_norm_rms_epsilon"),
+			ropeBase:   c.Float("rope.freq_base", 10000),
+			ropeScale:  c.Float("rope.freq_scale", 1),
+			ropeDim:    c.Uint("rope.dimension_count", uint32(hiddenSize/numHeads)),
+		},
+	}
+
+	if window := c.Uint("attention.sliding_window"); window != 0 {
+		m.Cache = kvcache.NewSWACache(int32(window), m.Shift)
+	} else {
+		m.Cache = kvcache.NewCausalCache(m.Shift)
+	}
+
+	return &m, nil
+}
+
+// SelfAttention projects the query, key and value together when the model
+// was converted with a fused QKV tensor, and separately otherwise.
+type SelfAttention struct {
+	QKV    *nn.Linear `gguf:"attn_qkv"`
+	Query  *nn.Linear `gguf:"attn_q"`
+	Key    *nn.Linear `gguf:"attn_k"`
+	Value  *nn.Linear `gguf:"attn_v"`
+	Output *nn.Linear `gguf:"attn_output"`
+}
+
+func (sa *SelfAttention) Forward(ctx ml.Context, hiddenState, positionIDs ml.Tensor, cache kvcache.Cache, opts *Options) ml.Tensor {
+	batchSize := hiddenState.Dim(1)
+	headDim := opts.hiddenSize / opts.numHeads
+
+	var q, k, v ml.Tensor
+	if sa.QKV != nil {
+		qkv := sa.QKV.Forward(ctx, hiddenState)
+
+		split := func(offset, heads int) ml.Tensor {
+			return qkv.View(ctx, offset*headDim*qkv.Stride(0), headDim*heads, qkv.Stride(1), batchSize).
+				Contiguous(ctx)
+		}
+
+		q = split(0, opts.numHeads)
+		k = split(opts.numHeads, opts.numKVHeads)
+		v = split(opts.numHeads+opts.numKVHeads, opts.numKVHeads)
+	} else {
+		q = sa.Query.Forward(ctx, hiddenState)
+		k = sa.Key.Forward(ctx, hiddenState)
+		v = sa.Value.Forward(ctx, hiddenState)
+	}
+
+	q = q.Reshape(ctx, headDim, opts.numHeads, batchSize)
+	q = opts.applyRotaryPositionEmbeddings(ctx, q, positionIDs)
+
+	k = k.Reshape(ctx, headDim, opts.numKVHeads, batchSize)
+	k = opts.applyRotaryPositionEmbeddings(ctx, k, positionIDs)
+
+	v = v.Reshape(ctx, headDim, opts.numKVHeads, batchSize)
+
+	scaleFactor := 1.0 / math.Sqrt(float64(headDim))
+	kqv := nn.Attention(ctx, q, k, v, scaleFactor, cache)
+	kqv = kqv.Reshape(ctx, opts.hiddenSize, batchSize)
+
+	return sa.Output.Forward(ctx, kqv)
+}
+
+// applyRotaryPositionEmbeddings applies NeoX style rotations, both to new
+// queries and keys and, through Shift, to keys being moved in the cache.
+func (opts *Options) applyRotaryPositionEmbeddings(ctx ml.Context, states, positionIDs ml.Tensor) ml.Tensor {
+	return states.RoPE(ctx, positionIDs, nil, opts.ropeDim, 2, opts.ropeBase, opts.ropeScale)
+}
+
+func (m *Model) Shift(ctx ml.Context, layer int, key, shift ml.Tensor) (ml.Tensor, error) {
+	return m.applyRotaryPositionEmbeddings(ctx, key, shift), nil
+}
+
+// MLP has a single up projection whose first half is the gate.
+type MLP struct {
+	Up   *nn.Linear `gguf:"ffn_up"`
+	Down *nn.Linear `gguf:"ffn_down"`
+}
+
+func (mlp *MLP) Forward(ctx ml.Context, hiddenState ml.Tensor, opts *Options) ml.Tensor {
+	hiddenState = mlp.Up.Forward(ctx, hiddenState)
+
+	size, batchSize := hiddenState.Dim(0)/2, hiddenState.Dim(1)
+	gate := hiddenState.View(ctx, 0, size, hiddenState.Stride(1), batchSize).Contiguous(ctx)
+	up := hiddenState.View(ctx, size*hiddenState.Stride(0), size, hiddenState.Stride(1), batchSize).Contiguous(ctx)
+
+	return mlp.Down.Forward(ctx, gate.SILU(ctx).Mul(ctx, up))
+}
+
+type Layer struct {
+	AttentionNorm *nn.RMSNorm `gguf:"attn_norm"`
+	SelfAttention *SelfAttention
+	MLPNorm       *nn.RMSNorm `gguf:"ffn_norm"`
+	MLP           *MLP
+}
+
+func (l *Layer) Forward(ctx ml.Context, hiddenState, positionIDs, outputs ml.Tensor, cache kvcache.Cache, opts *Options) ml.Tensor {
+	residual := hiddenState
+
+	hiddenState = l.AttentionNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = l.SelfAttention.Forward(ctx, hiddenState, positionIDs, cache, opts)
+
+	// In the final layer (outputs != nil), optimize by pruning to just the token positions
+	// we need logits for.
+	if outputs != nil {
+		hiddenState = hiddenState.Rows(ctx, outputs)
+		residual = residual.Rows(ctx, outputs)
+	}
+
+	hiddenState = hiddenState.Add(ctx, residual)
+	residual = hiddenState
+
+	hiddenState = l.MLPNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = l.MLP.Forward(ctx, hiddenState, opts)
+	return hiddenState.Add(ctx, residual)
+}
+
+func (m *Model) Forward(ctx ml.Context, opts input.Options) (ml.Tensor, error) {
+	inputs, err := ctx.Input().FromIntSlice(opts.Inputs, len(opts.Inputs))
+	if err != nil {
+		return nil, err
+	}
+
+	positions, err := ctx.Input().FromIntSlice(opts.Positions, len(opts.Positions))
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := ctx.Input().FromIntSlice(opts.Outputs, len(opts.Outputs))
+	if err != nil {
+		return nil, err
+	}
+
+	hiddenState := m.TokenEmbedding.Forward(ctx, inputs)
+
+	for i, layer := range m.Layers {
+		m.Cache.SetLayer(i)
+
+		var lastLayerOutputs ml.Tensor
+		if i == len(m.Layers)-1 {
+			lastLayerOutputs = outputs
+		}
+
+		hiddenState = layer.Forward(ctx, hiddenState, positions, lastLayerOutputs, m.Cache, m.Options)
+	}
+
+	hiddenState = m.OutputNorm.Forward(ctx, hiddenState, m.eps)
+	return m.Output.Forward(ctx, hiddenState), nil
+}
+
+func init() {
+	model.Register("phi3", New)
+}
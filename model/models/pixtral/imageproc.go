@@ -51,7 +51,7 @@ func resizeImage(img image.Image, format string, longestEdge int, patchSize imag
 }
 
 func Preprocess(imageData io.Reader) ([]float32, map[string]any, error) {
-	img, format, err := image.Decode(imageData)
+	img, format, err := imageproc.Decode(imageData)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to decode image: %w", err)
 	}
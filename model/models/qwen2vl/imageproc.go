@@ -59,16 +59,51 @@ func resizeImage(img image.Image, format string, size image.Point) image.Image {
 }
 
 func Preprocess(imageData io.Reader) ([]float32, map[string]any, error) {
-	img, format, err := image.Decode(imageData)
+	img, format, err := imageproc.Decode(imageData)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to decode image: %w", err)
 	}
 
-	size := smartResize(img.Bounds().Max, DefaultFactor, DefaultMinPixels, DefaultMaxPixels)
+	size, err := imageSize(img.Bounds().Size())
+	if err != nil {
+		return nil, nil, err
+	}
+
 	img = resizeImage(img, format, size)
 
 	data := imageproc.Normalize(img, imageproc.ClipDefaultMean, imageproc.ClipDefaultSTD, true, true)
 
-	opts := map[string]any{}
+	opts := map[string]any{
+		"width":  size.X,
+		"height": size.Y,
+	}
 	return data, opts, nil
 }
+
+// imageSize returns the size an image is resized to, or an error if it can't
+// be resized
+func imageSize(size image.Point) (image.Point, error) {
+	if size.X < DefaultFactor || size.Y < DefaultFactor {
+		return image.Point{}, fmt.Errorf("image is too small: %dx%d, must be at least %dx%d", size.X, size.Y, DefaultFactor, DefaultFactor)
+	} else if max(size.X, size.Y)/min(size.X, size.Y) > 200 {
+		return image.Point{}, fmt.Errorf("image aspect ratio must be less than 200:1: %dx%d", size.X, size.Y)
+	}
+
+	return smartResize(size, DefaultFactor, DefaultMinPixels, DefaultMaxPixels), nil
+}
+
+// NumTokens returns how many tokens the image in imageData is encoded as, one
+// for each square of 2x2 patches after it is resized
+func NumTokens(imageData io.Reader) (int, error) {
+	config, _, err := image.DecodeConfig(imageData)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	size, err := imageSize(image.Point{config.Width, config.Height})
+	if err != nil {
+		return 0, err
+	}
+
+	return (size.X / DefaultFactor) * (size.Y / DefaultFactor), nil
+}
@@ -76,3 +76,52 @@ func TestPreprocess(t *testing.T) {
 		}
 	}
 }
+
+func TestNumTokens(t *testing.T) {
+	cases := []struct {
+		TestImage image.Image
+		Expected  int
+		Error     bool
+	}{
+		{TestImage: image.NewRGBA(image.Rect(0, 0, 256, 256)), Expected: 9 * 9},
+		{TestImage: image.NewRGBA(image.Rect(0, 0, 1024, 768)), Expected: 37 * 27},
+		{TestImage: image.NewRGBA(image.Rect(0, 0, 16, 256)), Error: true},
+		{TestImage: image.NewRGBA(image.Rect(0, 0, 12000, 30)), Error: true},
+	}
+
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, c.TestImage); err != nil {
+			t.Fatal(err)
+		}
+
+		data := buf.Bytes()
+		actual, err := NumTokens(bytes.NewReader(data))
+		if c.Error {
+			if err == nil {
+				t.Errorf("%v: expected error", c.TestImage.Bounds())
+			}
+			if _, _, err := Preprocess(bytes.NewReader(data)); err == nil {
+				t.Errorf("%v: expected error preprocessing", c.TestImage.Bounds())
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual != c.Expected {
+			t.Errorf("expected: %d, actual: %d", c.Expected, actual)
+		}
+
+		imgData, opts, err := Preprocess(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if width, height := opts["width"].(int), opts["height"].(int); width*height*3 != len(imgData) || width/DefaultFactor*height/DefaultFactor != actual {
+			t.Errorf("unexpected size %dx%d for %d tokens", width, height, actual)
+		}
+	}
+}
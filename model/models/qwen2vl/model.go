@@ -0,0 +1,130 @@
+package qwen2vl
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/model"
+	"github.com/ollama/ollama/model/input"
+)
+
+type Model struct {
+	model.Base
+	model.BytePairEncoding
+
+	*TextModel
+	*VisionModel `gguf:"v,vision"`
+
+	imageTokenID, visionStartTokenID, visionEndTokenID int32
+}
+
+var _ model.MultimodalProcessor = (*Model)(nil)
+
+// imageEmbedding is an encoded image, one embedding for each square of merged
+// patches in row-major order
+type imageEmbedding struct {
+	embedding ml.Tensor
+	grid      grid
+}
+
+func New(c ml.Config) (model.Model, error) {
+	if !strings.EqualFold(c.String("tokenizer.ggml.model"), "gpt2") {
+		return nil, fmt.Errorf("tokenizer %s not yet supported", c.String("tokenizer.ggml.model"))
+	}
+
+	pre, err := model.Pretokenizer(c.String("tokenizer.ggml.pre", "qwen2"))
+	if err != nil {
+		return nil, err
+	}
+
+	m := Model{
+		BytePairEncoding: model.NewBytePairEncoding(
+			&model.Vocabulary{
+				Values: c.Strings("tokenizer.ggml.tokens"),
+				Types:  c.Uints("tokenizer.ggml.token_type"),
+				Merges: c.Strings("tokenizer.ggml.merges"),
+				BOS:    int32(c.Uint("tokenizer.ggml.bos_token_id")),
+				AddBOS: c.Bool("tokenizer.ggml.add_bos_token", false),
+				EOS:    int32(c.Uint("tokenizer.ggml.eos_token_id")),
+				AddEOS: c.Bool("tokenizer.ggml.add_eos_token", false),
+			},
+			pre...,
+		),
+		TextModel:          newTextModel(c),
+		VisionModel:        newVisionModel(c),
+		imageTokenID:       int32(c.Uint("image_token_id", 151655)),
+		visionStartTokenID: int32(c.Uint("vision_start_token_id", 151652)),
+		visionEndTokenID:   int32(c.Uint("vision_end_token_id", 151653)),
+	}
+
+	m.Cache = kvcache.NewCausalCache(m.TextModel.Shift)
+
+	return &m, nil
+}
+
+func (m *Model) EncodeMultimodal(ctx ml.Context, multimodalData []byte) (any, error) {
+	if len(m.VisionModel.Layers) == 0 {
+		return nil, model.ErrNoVisionModel
+	}
+
+	f32s, opts, err := Preprocess(bytes.NewReader(multimodalData))
+	if err != nil {
+		return nil, err
+	}
+
+	pixelValues, err := ctx.Input().FromFloatSlice(f32s, opts["width"].(int), opts["height"].(int), 3)
+	if err != nil {
+		return nil, err
+	}
+
+	embedding, grid := m.VisionModel.Forward(ctx, pixelValues)
+	return &imageEmbedding{embedding: embedding, grid: grid}, nil
+}
+
+// PostTokenize surrounds each image with the vision start and end tokens and
+// expands it to a placeholder for each of its embeddings, the first of which
+// holds the image.
+func (m *Model) PostTokenize(inputs []input.Input) ([]input.Input, error) {
+	var result []input.Input
+
+	for _, inp := range inputs {
+		if inp.Multimodal == nil {
+			result = append(result, inp)
+			continue
+		}
+
+		image := inp.Multimodal.(*imageEmbedding)
+		numTokens := image.grid.width * image.grid.height
+
+		result = append(result,
+			input.Input{Token: m.visionStartTokenID, SameBatch: numTokens + 1},
+			input.Input{Token: m.imageTokenID, Multimodal: image, MultimodalHash: inp.MultimodalHash},
+		)
+		result = append(result, slices.Repeat([]input.Input{{Token: m.imageTokenID}}, numTokens-1)...)
+		result = append(result, input.Input{Token: m.visionEndTokenID})
+	}
+
+	return result, nil
+}
+
+func (m *Model) Forward(ctx ml.Context, opts input.Options) (ml.Tensor, error) {
+	inputs, err := ctx.Input().FromIntSlice(opts.Inputs, len(opts.Inputs))
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := ctx.Input().FromIntSlice(opts.Outputs, len(opts.Outputs))
+	if err != nil {
+		return nil, err
+	}
+
+	return m.TextModel.Forward(ctx, inputs, outputs, opts, m.Cache)
+}
+
+func init() {
+	model.Register("qwen2vl", New)
+}
@@ -0,0 +1,188 @@
+package qwen2vl
+
+import (
+	"math"
+
+	"github.com/ollama/ollama/kvcache"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model/input"
+)
+
+const (
+	ropeTypeNeox   = 2
+	ropeTypeMulti  = 8
+	ropeTypeVision = 24
+)
+
+type TextOptions struct {
+	hiddenSize, numHeads, numKVHeads int
+	eps, ropeBase, ropeScale         float32
+	ropeSections                     [4]int
+}
+
+type TextModel struct {
+	TokenEmbedding *nn.Embedding `gguf:"token_embd"`
+	Layers         []Layer       `gguf:"blk"`
+	OutputNorm     *nn.RMSNorm   `gguf:"output_norm"`
+	Output         *nn.Linear    `gguf:"output,alt:token_embd"`
+
+	*TextOptions
+}
+
+func newTextModel(c ml.Config) *TextModel {
+	sections := [4]int{16, 24, 24, 0}
+	if s := c.Uints("rope.dimension_sections"); len(s) > 0 {
+		sections = [4]int{}
+		for i := range min(len(s), len(sections)) {
+			sections[i] = int(s[i])
+		}
+	}
+
+	return &TextModel{
+		Layers: make([]Layer, c.Uint("block_count")),
+		TextOptions: &TextOptions{
+			hiddenSize:   int(c.Uint("embedding_length")),
+			numHeads:     int(c.Uint("attention.head_count")),
+			numKVHeads:   int(c.Uint("attention.head_count_kv")),
+			eps:          c.Float("attention.layer_norm_rms_epsilon"),
+			ropeBase:     c.Float("rope.freq_base", 1000000),
+			ropeScale:    c.Float("rope.freq_scale", 1),
+			ropeSections: sections,
+		},
+	}
+}
+
+type SelfAttention struct {
+	Query  *nn.Linear `gguf:"attn_q"`
+	Key    *nn.Linear `gguf:"attn_k"`
+	Value  *nn.Linear `gguf:"attn_v"`
+	Output *nn.Linear `gguf:"attn_output"`
+}
+
+func (sa *SelfAttention) Forward(ctx ml.Context, hiddenState, positionIDs ml.Tensor, cache kvcache.Cache, opts *TextOptions) ml.Tensor {
+	batchSize := hiddenState.Dim(1)
+	headDim := opts.hiddenSize / opts.numHeads
+
+	q := sa.Query.Forward(ctx, hiddenState)
+	q = q.Reshape(ctx, headDim, opts.numHeads, batchSize)
+	q = q.RoPEMulti(ctx, positionIDs, uint32(headDim), opts.ropeSections, ropeTypeMulti, opts.ropeBase, opts.ropeScale)
+
+	k := sa.Key.Forward(ctx, hiddenState)
+	k = k.Reshape(ctx, headDim, opts.numKVHeads, batchSize)
+	k = k.RoPEMulti(ctx, positionIDs, uint32(headDim), opts.ropeSections, ropeTypeMulti, opts.ropeBase, opts.ropeScale)
+
+	v := sa.Value.Forward(ctx, hiddenState)
+	v = v.Reshape(ctx, headDim, opts.numKVHeads, batchSize)
+
+	scaleFactor := 1.0 / math.Sqrt(float64(headDim))
+	kqv := nn.Attention(ctx, q, k, v, scaleFactor, cache)
+	kqv = kqv.Reshape(ctx, opts.hiddenSize, batchSize)
+
+	return sa.Output.Forward(ctx, kqv)
+}
+
+// Shift moves keys in the cache by rotating them further. Every section of a
+// key moves by the same amount, which makes the multi-section rope the same as
+// a NeoX rope over the whole head.
+func (m *TextModel) Shift(ctx ml.Context, layer int, key, shift ml.Tensor) (ml.Tensor, error) {
+	headDim := m.hiddenSize / m.numHeads
+	return key.RoPE(ctx, shift, nil, uint32(headDim), ropeTypeNeox, m.ropeBase, m.ropeScale), nil
+}
+
+type MLP struct {
+	Up   *nn.Linear `gguf:"ffn_up"`
+	Down *nn.Linear `gguf:"ffn_down"`
+	Gate *nn.Linear `gguf:"ffn_gate"`
+}
+
+func (mlp *MLP) Forward(ctx ml.Context, hiddenState ml.Tensor) ml.Tensor {
+	hiddenState = mlp.Gate.Forward(ctx, hiddenState).SILU(ctx).Mul(ctx, mlp.Up.Forward(ctx, hiddenState))
+	return mlp.Down.Forward(ctx, hiddenState)
+}
+
+type Layer struct {
+	AttentionNorm *nn.RMSNorm `gguf:"attn_norm"`
+	SelfAttention *SelfAttention
+	MLPNorm       *nn.RMSNorm `gguf:"ffn_norm"`
+	MLP           *MLP
+}
+
+func (l *Layer) Forward(ctx ml.Context, hiddenState, positionIDs, outputs ml.Tensor, cache kvcache.Cache, opts *TextOptions) ml.Tensor {
+	residual := hiddenState
+
+	hiddenState = l.AttentionNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = l.SelfAttention.Forward(ctx, hiddenState, positionIDs, cache, opts)
+
+	// In the final layer (outputs != nil), optimize by pruning to just the token positions
+	// we need logits for.
+	if outputs != nil {
+		hiddenState = hiddenState.Rows(ctx, outputs)
+		residual = residual.Rows(ctx, outputs)
+	}
+
+	hiddenState = hiddenState.Add(ctx, residual)
+	residual = hiddenState
+
+	hiddenState = l.MLPNorm.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = l.MLP.Forward(ctx, hiddenState)
+	return hiddenState.Add(ctx, residual)
+}
+
+// positions returns the temporal, height, width and extra positions of each
+// input in the batch, all of one kind followed by the next.
+//
+// Text is at the same position in every section. The tokens of an image start
+// at the position of its first token and are offset by their row and column
+// in the height and width sections. Unlike the reference implementation, text
+// after an image continues from the position of the input rather than just
+// past the image's largest position, which keeps the rope positions in step
+// with the positions used by the cache.
+func positions(opts input.Options) []int32 {
+	n := len(opts.Positions)
+	s := make([]int32, 4*n)
+	copy(s, opts.Positions)
+	copy(s[n:], opts.Positions)
+	copy(s[2*n:], opts.Positions)
+
+	for _, mm := range opts.Multimodal {
+		image := mm.Multimodal.(*imageEmbedding)
+		start := opts.Positions[mm.Index]
+		for i := range image.grid.width * image.grid.height {
+			s[n+mm.Index+i] = start + int32(i/image.grid.width)
+			s[2*n+mm.Index+i] = start + int32(i%image.grid.width)
+		}
+	}
+
+	return s
+}
+
+func (m *TextModel) Forward(ctx ml.Context, inputs, outputs ml.Tensor, opts input.Options, cache kvcache.Cache) (ml.Tensor, error) {
+	hiddenState := m.TokenEmbedding.Forward(ctx, inputs)
+
+	// replace the placeholders of each image with its embeddings
+	for _, mm := range opts.Multimodal {
+		image := mm.Multimodal.(*imageEmbedding)
+		ctx.Forward(image.embedding.Copy(ctx, hiddenState.View(ctx, mm.Index*hiddenState.Stride(1), image.embedding.Dim(0)*image.embedding.Dim(1))))
+	}
+
+	pos := positions(opts)
+	positionIDs, err := ctx.Input().FromIntSlice(pos, len(pos))
+	if err != nil {
+		return nil, err
+	}
+
+	for i, layer := range m.Layers {
+		cache.SetLayer(i)
+
+		var lastLayerOutputs ml.Tensor
+		if i == len(m.Layers)-1 {
+			lastLayerOutputs = outputs
+		}
+
+		hiddenState = layer.Forward(ctx, hiddenState, positionIDs, lastLayerOutputs, cache, m.TextOptions)
+	}
+
+	hiddenState = m.OutputNorm.Forward(ctx, hiddenState, m.eps)
+	return m.Output.Forward(ctx, hiddenState), nil
+}
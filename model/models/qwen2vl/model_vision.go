@@ -0,0 +1,170 @@
+package qwen2vl
+
+import (
+	"math"
+
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+)
+
+type VisionSelfAttention struct {
+	Query  *nn.Linear `gguf:"attn_q"`
+	Key    *nn.Linear `gguf:"attn_k"`
+	Value  *nn.Linear `gguf:"attn_v"`
+	Output *nn.Linear `gguf:"attn_output"`
+}
+
+func (sa *VisionSelfAttention) Forward(ctx ml.Context, hiddenState, positionIDs ml.Tensor, opts *VisionModelOptions) ml.Tensor {
+	headDim := opts.hiddenSize / opts.numHeads
+	numPatches := hiddenState.Dim(1)
+
+	query := sa.Query.Forward(ctx, hiddenState)
+	query = query.Reshape(ctx, headDim, opts.numHeads, numPatches)
+	query = opts.applyRotaryPositionEmbeddings(ctx, query, positionIDs)
+
+	key := sa.Key.Forward(ctx, hiddenState)
+	key = key.Reshape(ctx, headDim, opts.numHeads, numPatches)
+	key = opts.applyRotaryPositionEmbeddings(ctx, key, positionIDs)
+
+	value := sa.Value.Forward(ctx, hiddenState)
+	value = value.Reshape(ctx, headDim, opts.numHeads, numPatches)
+
+	attention := nn.Attention(ctx, query, key, value, 1.0/math.Sqrt(float64(headDim)), nil)
+	attention = attention.Reshape(ctx, opts.hiddenSize, numPatches)
+
+	return sa.Output.Forward(ctx, attention)
+}
+
+// applyRotaryPositionEmbeddings rotates the first half of each head's
+// dimensions by the row of the patch and the second half by its column.
+func (opts *VisionModelOptions) applyRotaryPositionEmbeddings(ctx ml.Context, states, positionIDs ml.Tensor) ml.Tensor {
+	headDim := opts.hiddenSize / opts.numHeads
+	sections := [4]int{headDim / 4, headDim / 4}
+	return states.RoPEMulti(ctx, positionIDs, uint32(headDim/2), sections, ropeTypeVision, opts.ropeBase, 1)
+}
+
+type VisionMLP struct {
+	Up   *nn.Linear `gguf:"ffn_up"`
+	Down *nn.Linear `gguf:"ffn_down"`
+}
+
+func (mlp *VisionMLP) Forward(ctx ml.Context, hiddenState ml.Tensor) ml.Tensor {
+	hiddenState = mlp.Up.Forward(ctx, hiddenState).QuickGELU(ctx)
+	return mlp.Down.Forward(ctx, hiddenState)
+}
+
+type VisionEncoderLayer struct {
+	LayerNorm1    *nn.LayerNorm `gguf:"ln1"`
+	SelfAttention *VisionSelfAttention
+	LayerNorm2    *nn.LayerNorm `gguf:"ln2"`
+	MLP           *VisionMLP
+}
+
+func (e *VisionEncoderLayer) Forward(ctx ml.Context, hiddenState, positionIDs ml.Tensor, opts *VisionModelOptions) ml.Tensor {
+	residual := hiddenState
+
+	hiddenState = e.LayerNorm1.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = e.SelfAttention.Forward(ctx, hiddenState, positionIDs, opts)
+	hiddenState = hiddenState.Add(ctx, residual)
+	residual = hiddenState
+
+	hiddenState = e.LayerNorm2.Forward(ctx, hiddenState, opts.eps)
+	hiddenState = e.MLP.Forward(ctx, hiddenState)
+	return hiddenState.Add(ctx, residual)
+}
+
+// PatchMerger concatenates each square of spatialMergeSize x spatialMergeSize
+// neighboring patches into a single token and projects it into the text
+// model's embedding space.
+type PatchMerger struct {
+	Norm *nn.LayerNorm `gguf:"ln_q"`
+	FC1  *nn.Linear    `gguf:"mlp.0"`
+	FC2  *nn.Linear    `gguf:"mlp.2"`
+}
+
+func (m *PatchMerger) Forward(ctx ml.Context, hiddenState ml.Tensor, grid grid, opts *VisionModelOptions) ml.Tensor {
+	hiddenState = m.Norm.Forward(ctx, hiddenState, opts.eps)
+
+	// patches are in row-major order, so the patches in each row of a square
+	// are already next to each other and only the rows need to be gathered
+	merge := opts.spatialMergeSize
+	hiddenState = hiddenState.Reshape(ctx, opts.hiddenSize*merge, grid.width/merge, merge, grid.height/merge)
+	hiddenState = hiddenState.Permute(ctx, 0, 2, 1, 3).Contiguous(ctx)
+	hiddenState = hiddenState.Reshape(ctx, opts.hiddenSize*merge*merge, grid.width/merge*grid.height/merge)
+
+	hiddenState = m.FC1.Forward(ctx, hiddenState).GELU(ctx)
+	return m.FC2.Forward(ctx, hiddenState)
+}
+
+type VisionModelOptions struct {
+	hiddenSize, numHeads        int
+	patchSize, spatialMergeSize int
+	eps, ropeBase               float32
+}
+
+type VisionModel struct {
+	// a 3D convolution over pairs of frames, split into one 2D convolution
+	// for each frame of the pair
+	PatchEmbedding0 *nn.Conv2D `gguf:"patch_embd_0"`
+	PatchEmbedding1 *nn.Conv2D `gguf:"patch_embd_1"`
+
+	Layers []VisionEncoderLayer `gguf:"blk"`
+
+	*PatchMerger `gguf:"merger"`
+
+	*VisionModelOptions
+}
+
+// grid is the number of patches across and down an image
+type grid struct {
+	width, height int
+}
+
+func (m *VisionModel) Forward(ctx ml.Context, pixelValues ml.Tensor) (ml.Tensor, grid) {
+	// an image is a single frame, which is repeated to make a pair
+	hiddenState := m.PatchEmbedding0.Forward(ctx, pixelValues, m.patchSize, m.patchSize, 0, 0, 1, 1).
+		Add(ctx, m.PatchEmbedding1.Forward(ctx, pixelValues, m.patchSize, m.patchSize, 0, 0, 1, 1))
+
+	g := grid{width: hiddenState.Dim(0), height: hiddenState.Dim(1)}
+	numPatches := g.width * g.height
+
+	hiddenState = hiddenState.Reshape(ctx, numPatches, m.hiddenSize)
+	hiddenState = hiddenState.Permute(ctx, 1, 0, 2, 3).Contiguous(ctx)
+
+	// the rotary embeddings take the row and column of each patch, repeated
+	// to fill the four positions of the multi-section rope
+	positions := make([]int32, 4*numPatches)
+	for i := range numPatches {
+		row, col := int32(i/g.width), int32(i%g.width)
+		positions[i] = row
+		positions[numPatches+i] = col
+		positions[2*numPatches+i] = row
+		positions[3*numPatches+i] = col
+	}
+
+	positionIDs, err := ctx.Input().FromIntSlice(positions, len(positions))
+	if err != nil {
+		panic(err)
+	}
+
+	for _, layer := range m.Layers {
+		hiddenState = layer.Forward(ctx, hiddenState, positionIDs, m.VisionModelOptions)
+	}
+
+	hiddenState = m.PatchMerger.Forward(ctx, hiddenState, g, m.VisionModelOptions)
+	return hiddenState, grid{width: g.width / m.spatialMergeSize, height: g.height / m.spatialMergeSize}
+}
+
+func newVisionModel(c ml.Config) *VisionModel {
+	return &VisionModel{
+		Layers: make([]VisionEncoderLayer, c.Uint("vision.block_count")),
+		VisionModelOptions: &VisionModelOptions{
+			hiddenSize:       int(c.Uint("vision.embedding_length")),
+			numHeads:         int(c.Uint("vision.attention.head_count")),
+			patchSize:        int(c.Uint("vision.patch_size", 14)),
+			spatialMergeSize: int(c.Uint("vision.spatial_merge_size", 2)),
+			eps:              c.Float("vision.attention.layer_norm_epsilon", 1e-6),
+			ropeBase:         c.Float("vision.rope.freq_base", 10000),
+		},
+	}
+}
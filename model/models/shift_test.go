@@ -0,0 +1,338 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+
+	fsggml "github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/ml"
+	"github.com/ollama/ollama/ml/nn"
+	"github.com/ollama/ollama/model"
+)
+
+type shifter interface {
+	Shift(ctx ml.Context, layer int, key, shift ml.Tensor) (ml.Tensor, error)
+}
+
+// rope holds the parameters a layer is expected to rotate keys with.
+type rope struct {
+	dim, ropeType uint32
+	base, scale   float32
+}
+
+func newTestModel(t *testing.T, kv fsggml.KV, ropeFactors []float32) model.Model {
+	t.Helper()
+
+	var ts []fsggml.Tensor
+	if ropeFactors != nil {
+		var b bytes.Buffer
+		if err := binary.Write(&b, binary.LittleEndian, ropeFactors); err != nil {
+			t.Fatal(err)
+		}
+
+		ts = append(ts, fsggml.Tensor{Name: "rope_freqs.weight", Kind: 0, Shape: []uint64{uint64(len(ropeFactors))}, WriterTo: &b})
+	}
+
+	p := filepath.Join(t.TempDir(), "model.gguf")
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := fsggml.WriteGGUF(f, kv, ts); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := model.New(p, ml.BackendParams{NumThreads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func random(r *rand.Rand, n int) []float32 {
+	s := make([]float32, n)
+	for i := range s {
+		s[i] = r.Float32()*2 - 1
+	}
+
+	return s
+}
+
+func compare(t *testing.T, name string, got, want []float32) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("%s: expected %d values, got %d", name, len(want), len(got))
+	}
+
+	for i := range got {
+		if math.Abs(float64(got[i]-want[i])) > 1e-4 {
+			t.Fatalf("%s: value %d: expected %v, got %v", name, i, want[i], got[i])
+		}
+	}
+}
+
+// TestShift checks that shifting keys in the cache gives the same attention
+// outputs as recomputing the keys at their new positions.
+func TestShift(t *testing.T) {
+	const (
+		headDim    = 16
+		numHeads   = 4
+		numKVHeads = 2
+	)
+
+	factors := make([]float32, headDim/2)
+	for i := range factors {
+		factors[i] = 1 + float32(i)/2
+	}
+
+	cases := []struct {
+		name        string
+		kv          fsggml.KV
+		ropeFactors []float32
+		// layers maps each layer to test to its expected rotation, which is
+		// nil if the layer's keys have no position
+		layers map[int]*rope
+	}{
+		{
+			name: "llama",
+			kv: fsggml.KV{
+				"general.architecture":                   "llama",
+				"tokenizer.ggml.model":                   "gpt2",
+				"llama.block_count":                      uint32(2),
+				"llama.embedding_length":                 uint32(headDim * numHeads),
+				"llama.attention.head_count":             uint32(numHeads),
+				"llama.attention.head_count_kv":          uint32(numKVHeads),
+				"llama.rope.dimension_count":             uint32(headDim),
+				"llama.rope.freq_base":                   float32(500000),
+				"llama.rope.scaling.type":                "linear",
+				"llama.rope.scaling.factor":              float32(4),
+				"llama.attention.layer_norm_rms_epsilon": float32(1e-5),
+			},
+			ropeFactors: factors,
+			layers: map[int]*rope{
+				0: {dim: headDim, ropeType: 0, base: 500000, scale: 0.25},
+				1: {dim: headDim, ropeType: 0, base: 500000, scale: 0.25},
+			},
+		},
+		{
+			name: "gemma2",
+			kv: fsggml.KV{
+				"general.architecture":            "gemma2",
+				"tokenizer.ggml.tokens":           []string{"<pad>", "<eos>", "<bos>", "<unk>", "a"},
+				"tokenizer.ggml.scores":           make([]float32, 5),
+				"tokenizer.ggml.token_type":       []uint32{3, 3, 3, 2, 1},
+				"gemma2.block_count":              uint32(2),
+				"gemma2.embedding_length":         uint32(headDim * numHeads),
+				"gemma2.attention.head_count":     uint32(numHeads),
+				"gemma2.attention.head_count_kv":  uint32(numKVHeads),
+				"gemma2.attention.key_length":     uint32(headDim),
+				"gemma2.attention.value_length":   uint32(headDim),
+				"gemma2.attention.sliding_window": uint32(64),
+			},
+			layers: map[int]*rope{
+				0: {dim: headDim, ropeType: 2, base: 10000, scale: 1},
+				1: {dim: headDim, ropeType: 2, base: 10000, scale: 1},
+			},
+		},
+		{
+			name: "gemma3",
+			kv: fsggml.KV{
+				"general.architecture":            "gemma3",
+				"tokenizer.ggml.tokens":           []string{"<pad>", "<eos>", "<bos>", "<unk>", "a"},
+				"tokenizer.ggml.scores":           make([]float32, 5),
+				"tokenizer.ggml.token_type":       []uint32{3, 3, 3, 2, 1},
+				"gemma3.block_count":              uint32(6),
+				"gemma3.embedding_length":         uint32(headDim * numHeads),
+				"gemma3.attention.head_count":     uint32(numHeads),
+				"gemma3.attention.head_count_kv":  uint32(numKVHeads),
+				"gemma3.attention.key_length":     uint32(headDim),
+				"gemma3.attention.value_length":   uint32(headDim),
+				"gemma3.attention.sliding_window": uint32(64),
+				"gemma3.rope.local.freq_base":     float32(10000),
+				"gemma3.rope.global.freq_base":    float32(1000000),
+			},
+			layers: map[int]*rope{
+				0: {dim: headDim, ropeType: 2, base: 10000, scale: 1},
+				5: {dim: headDim, ropeType: 2, base: 1000000, scale: 1},
+			},
+		},
+		{
+			name: "qwen2",
+			kv: fsggml.KV{
+				"general.architecture":                   "qwen2",
+				"tokenizer.ggml.model":                   "gpt2",
+				"qwen2.block_count":                      uint32(2),
+				"qwen2.embedding_length":                 uint32(headDim * numHeads),
+				"qwen2.attention.head_count":             uint32(numHeads),
+				"qwen2.attention.head_count_kv":          uint32(numKVHeads),
+				"qwen2.attention.layer_norm_rms_epsilon": float32(1e-6),
+			},
+			layers: map[int]*rope{
+				0: {dim: headDim, ropeType: 2, base: 1000000, scale: 1},
+				1: {dim: headDim, ropeType: 2, base: 1000000, scale: 1},
+			},
+		},
+		{
+			name: "qwen2vl",
+			kv: fsggml.KV{
+				"general.architecture":                     "qwen2vl",
+				"tokenizer.ggml.model":                     "gpt2",
+				"qwen2vl.block_count":                      uint32(2),
+				"qwen2vl.embedding_length":                 uint32(headDim * numHeads),
+				"qwen2vl.attention.head_count":             uint32(numHeads),
+				"qwen2vl.attention.head_count_kv":          uint32(numKVHeads),
+				"qwen2vl.attention.layer_norm_rms_epsilon": float32(1e-6),
+				"qwen2vl.rope.dimension_sections":          []int32{2, 3, 3, 0},
+			},
+			layers: map[int]*rope{
+				0: {dim: headDim, ropeType: 2, base: 1000000, scale: 1},
+				1: {dim: headDim, ropeType: 2, base: 1000000, scale: 1},
+			},
+		},
+		{
+			name: "phi3",
+			kv: fsggml.KV{
+				"general.architecture":                  "phi3",
+				"tokenizer.ggml.model":                  "gpt2",
+				"phi3.block_count":                      uint32(2),
+				"phi3.embedding_length":                 uint32(headDim * numHeads),
+				"phi3.attention.head_count":             uint32(numHeads),
+				"phi3.attention.head_count_kv":          uint32(numKVHeads),
+				"phi3.rope.dimension_count":             uint32(headDim),
+				"phi3.rope.freq_base":                   float32(250000),
+				"phi3.attention.layer_norm_rms_epsilon": float32(1e-5),
+			},
+			layers: map[int]*rope{
+				0: {dim: headDim, ropeType: 2, base: 250000, scale: 1},
+				1: {dim: headDim, ropeType: 2, base: 250000, scale: 1},
+			},
+		},
+		{
+			name: "mllama",
+			kv: fsggml.KV{
+				"general.architecture":                    "mllama",
+				"tokenizer.ggml.model":                    "gpt2",
+				"mllama.block_count":                      uint32(2),
+				"mllama.embedding_length":                 uint32(headDim * numHeads),
+				"mllama.attention.head_count":             uint32(numHeads),
+				"mllama.attention.head_count_kv":          uint32(numKVHeads),
+				"mllama.attention.cross_attention_layers": []uint32{1},
+				"mllama.rope.dimension_count":             uint32(headDim),
+				"mllama.rope.freq_base":                   float32(500000),
+				"mllama.vision.block_count":               uint32(1),
+			},
+			ropeFactors: factors,
+			layers: map[int]*rope{
+				0: {dim: headDim, ropeType: 0, base: 500000, scale: 1},
+				1: nil,
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestModel(t, tt.kv, tt.ropeFactors)
+			s, ok := m.(shifter)
+			if !ok {
+				t.Fatal("model doesn't support shifting")
+			}
+
+			var factors ml.Tensor
+			if tt.ropeFactors != nil {
+				factors = m.Backend().Get("blk.0.rope_freqs.weight")
+			}
+
+			// the cache holds inputs at positions 0-3 and 12-27, the first 4
+			// of which are kept while the 8 before the rest are discarded
+			const numKeep, numDiscard, numInputs = 4, 8, 20
+			positions := make([]int32, numInputs)
+			shifted := make([]int32, numInputs)
+			offsets := make([]int32, numInputs)
+			for i := range positions {
+				positions[i], shifted[i] = int32(i), int32(i)
+				if i >= numKeep {
+					positions[i] += numDiscard
+					offsets[i] = -numDiscard
+				}
+			}
+
+			for layer, want := range tt.layers {
+				r := rand.New(rand.NewPCG(1, uint64(layer)))
+				keys := random(r, headDim*numKVHeads*numInputs)
+				values := random(r, headDim*numKVHeads*numInputs)
+				query := random(r, headDim*numHeads)
+
+				// compute returns the keys at their new positions, either
+				// shifted or recomputed, or the attention output using them.
+				// Each is computed in its own graph since intermediate
+				// results don't outlive it.
+				compute := func(shift, attention bool) []float32 {
+					ctx := m.Backend().NewContext()
+					defer ctx.Close()
+
+					input := func(s []int32) ml.Tensor {
+						tensor, err := ctx.Input().FromIntSlice(s, len(s))
+						if err != nil {
+							t.Fatal(err)
+						}
+						return tensor
+					}
+
+					rotate := func(states ml.Tensor, positions []int32) ml.Tensor {
+						if want == nil {
+							return states
+						}
+						return states.RoPE(ctx, input(positions), factors, want.dim, want.ropeType, want.base, want.scale)
+					}
+
+					k, err := ctx.Input().FromFloatSlice(keys, headDim, numKVHeads, numInputs)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if shift {
+						k, err = s.Shift(ctx, layer, rotate(k, positions), input(offsets))
+						if err != nil {
+							t.Fatal(err)
+						}
+					} else {
+						k = rotate(k, shifted)
+					}
+
+					out := k
+					if attention {
+						v, err := ctx.Input().FromFloatSlice(values, headDim, numKVHeads, numInputs)
+						if err != nil {
+							t.Fatal(err)
+						}
+
+						q, err := ctx.Input().FromFloatSlice(query, headDim, numHeads, 1)
+						if err != nil {
+							t.Fatal(err)
+						}
+
+						q = rotate(q, []int32{numInputs})
+						out = nn.Attention(ctx, q, k, v, 1/math.Sqrt(headDim), nil)
+					}
+
+					out = out.Contiguous(ctx)
+					ctx.Forward(out).Compute(out)
+					return out.Floats()
+				}
+
+				compare(t, fmt.Sprintf("layer %d keys", layer), compute(true, false), compute(false, false))
+				compare(t, fmt.Sprintf("layer %d attention", layer), compute(true, true), compute(false, true))
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package model
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Expressions shared by several pretokenizers
+const (
+	pretokenizeGPT2   = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)`
+	pretokenizeLlama3 = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+	pretokenizeQwen2  = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`
+	pretokenizeBloom  = ` ?[^(\s|.,!?…。，、।۔،)]+`
+)
+
+// pretokenizers maps the pretokenizers which models identify in their
+// tokenizer.ggml.pre key-value to the expressions which split text into words
+// before it's encoded. Text is split by each expression in turn, keeping any
+// text between matches, the same as llama.cpp.
+var pretokenizers = map[string][]string{
+	"default": {
+		`[\p{P}\$\+<=>\^~\|]+`,
+		pretokenizeGPT2,
+		`\p{N}+`,
+		`[0-9][0-9][0-9]`,
+	},
+
+	"llama3":    {pretokenizeLlama3},
+	"llama-v3":  {pretokenizeLlama3},
+	"llama-bpe": {pretokenizeLlama3},
+	"falcon3":   {pretokenizeLlama3},
+	"dbrx":      {pretokenizeLlama3},
+	"smaug-bpe": {pretokenizeLlama3},
+
+	"chatglm-bpe": {`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`},
+
+	"qwen2":            {pretokenizeQwen2},
+	"deepseek-r1-qwen": {pretokenizeQwen2},
+	"megrez":           {pretokenizeQwen2},
+	"stablelm2":        {pretokenizeQwen2},
+
+	"gpt-2":        {pretokenizeGPT2},
+	"phi-2":        {pretokenizeGPT2},
+	"jina-es":      {pretokenizeGPT2},
+	"jina-de":      {pretokenizeGPT2},
+	"gigachat":     {pretokenizeGPT2},
+	"jina-v1-en":   {pretokenizeGPT2},
+	"jina-v2-es":   {pretokenizeGPT2},
+	"jina-v2-de":   {pretokenizeGPT2},
+	"jina-v2-code": {pretokenizeGPT2},
+	"roberta-bpe":  {pretokenizeGPT2},
+	"mpt":          {pretokenizeGPT2},
+	"olmo":         {pretokenizeGPT2},
+	"jais":         {pretokenizeGPT2},
+
+	"starcoder":  {`\p{N}`, pretokenizeGPT2},
+	"refact":     {`\p{N}`, pretokenizeGPT2},
+	"command-r":  {`\p{N}`, pretokenizeGPT2},
+	"smollm":     {`\p{N}`, pretokenizeGPT2},
+	"codeshell":  {`\p{N}`, pretokenizeGPT2},
+	"exaone":     {`\p{N}`, pretokenizeGPT2},
+	"minerva-7b": {`\p{N}`, pretokenizeGPT2},
+
+	"falcon": {
+		`[\p{P}\$\+<=>\^~\|` + "`" + `]+`,
+		pretokenizeGPT2,
+		`[0-9][0-9][0-9]`,
+	},
+
+	"deepseek-llm": {
+		`[\r\n]`,
+		`\s?[A-Za-zµÀ-ÖØ-öø-ƺƼ-ƿǄ-ʓʕ-ʯͰ-ͳͶͷͻ-ͽͿΆΈ-ΊΌΎ-ΡΣ-ϵϷ-ҁҊ-ԯԱ-ՖႠ-ჅᎠ-Ᏽᏸ-ᏽᲐ-ᲺᲽ-Ჿᴀ-ᴫᵫ-ᵷᵹ-ᶚḀ-ἕἘ-Ἕἠ-ὅὈ-Ὅὐ-ὗὙὛὝὟ-ώᾀ-ᾴᾶ-ᾼιῂ-ῄῆ-ῌῐ-ΐῖ-Ίῠ-Ῥῲ-ῴῶ-ῼℂℇℊ-ℓℕℙ-ℝℤΩℨK-ℭℯ-ℴℹℼ-ℿⅅ-ⅉⅎↃↄⰀ-ⱻⱾ-ⳤⳫ-ⳮⳲⳳꙀ-ꙭꚀ-ꚛꜢ-ꝯꝱ-ꞇꞋ-ꞎꭰ-ꮿﬀ-ﬆﬓ-ﬗＡ-Ｚａ-ｚ𐐀-𐑏𐒰-𐓓𐓘-𐓻𐲀-𐲲𐳀-𐳲𑢠-𑣟𞤀-𞥃]+`,
+		`\s?[!-/:-~！-／：-～‘-‟　-。]+`,
+		`\s+$`,
+		`[一-龥ࠀ-一가-퟿]+`,
+		`\p{N}+`,
+	},
+	"deepseek-coder": {
+		`[\r\n]`,
+		`\s?\p{L}+`,
+		`\s?\p{P}+`,
+		`[一-龥ࠀ-一가-퟿]+`,
+		`\p{N}`,
+	},
+	"deepseek-v3": {
+		`\p{N}{1,3}`,
+		`[一-龥぀-ゟ゠-ヿ]+`,
+		`[!"#$%&'()*+,\-./:;<=>?@\[\\\]^_` + "`" + `{|}~][A-Za-z]+|[^\r\n\p{L}\p{P}\p{S}]?[\p{L}\p{M}]+| ?[\p{P}\p{S}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`,
+	},
+
+	"poro-chat":    {pretokenizeBloom},
+	"bloom":        {pretokenizeBloom},
+	"gpt3-finnish": {pretokenizeBloom},
+	"viking":       {pretokenizeBloom, `\p{N}`},
+
+	"tekken": {`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*|\p{N}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+`},
+	"gpt-4o": {`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+(?!\S)|\s+`},
+
+	"chameleon": {
+		`<sentinel:[0-9]+>`,
+		`(IMGIMG)((A|B|C|D|E|F|G|H|I){1,4})Z`,
+		`([\t\n]|    |  )`,
+		`\p{N}`,
+		`[\p{P}!-/:-@\[-` + "`" + `{-~]`,
+		pretokenizeGPT2,
+	},
+}
+
+// Pretokenizer returns the expressions of the pretokenizer identified by pre,
+// or "default" if pre is empty. Models with an unknown pretokenizer can't be
+// tokenized correctly, so it's an error rather than falling back to another.
+func Pretokenizer(pre string) ([]string, error) {
+	if pre == "" {
+		pre = "default"
+	}
+
+	expressions, ok := pretokenizers[pre]
+	if !ok {
+		return nil, fmt.Errorf("unknown pretokenizer %q", pre)
+	}
+
+	return slices.Clone(expressions), nil
+}
@@ -0,0 +1,77 @@
+package model
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/dlclark/regexp2"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPretokenizersCompile(t *testing.T) {
+	for name, expressions := range pretokenizers {
+		for _, expression := range expressions {
+			if _, err := regexp2.Compile(expression, regexp2.Unicode|regexp2.RE2); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestPretokenizer(t *testing.T) {
+	cases := []struct {
+		pre    string
+		input  string
+		expect []string
+	}{
+		{"", "In 2024, there", []string{"In", " ", "202", "4", ",", " there"}},
+		{"default", "In 2024, there", []string{"In", " ", "202", "4", ",", " there"}},
+		{"llama-bpe", "Hello, WORLD!! How's it going?", []string{"Hello", ",", " WORLD", "!!", " How", "'s", " it", " going", "?"}},
+		{"llama-bpe", "In 2024 there", []string{"In", " ", "202", "4", " there"}},
+		{"qwen2", "In 2024 there", []string{"In", " ", "2", "0", "2", "4", " there"}},
+		{"gpt-2", "In 2024 there", []string{"In", " 2024", " there"}},
+		{"gpt-2", "Hello    World", []string{"Hello", "   ", " World"}},
+		{"starcoder", "In 2024 there", []string{"In", " ", "2", "0", "2", "4", " there"}},
+		{"deepseek-coder", "Hello, world\n42", []string{"Hello", ",", " world", "\n", "4", "2"}},
+		{"deepseek-v3", "Hello 12345", []string{"Hello", " ", "123", "45"}},
+		{"bloom", "Hello, world!", []string{"Hello", ",", " world", "!"}},
+		{"gpt-4o", "Hello WORLD don't", []string{"Hello", " WORLD", " don't"}},
+		{"tekken", "Hello WORLD don't", []string{"Hello", " WORLD", " don", "'t"}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.pre, func(t *testing.T) {
+			pre, err := Pretokenizer(tt.pre)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bpe := NewBytePairEncoding(&Vocabulary{}, pre...)
+			if diff := cmp.Diff(tt.expect, slices.Collect(bpe.split(tt.input))); diff != "" {
+				t.Errorf("%q mismatch (-want +got):\n%s", tt.input, diff)
+			}
+		})
+	}
+
+	if _, err := Pretokenizer("unknown-bpe"); err == nil || !strings.Contains(err.Error(), "unknown-bpe") {
+		t.Errorf("expected error naming the pretokenizer, got %v", err)
+	}
+}
+
+func TestPretokenizerLossless(t *testing.T) {
+	inputs := []string{
+		"Hello, WORLD!! How's it going?\n\n  \tend",
+		"请考试我的软件！12345 ｜ 😀 $100",
+		"",
+	}
+
+	for name, pre := range pretokenizers {
+		bpe := NewBytePairEncoding(&Vocabulary{}, pre...)
+		for _, input := range inputs {
+			if got := strings.Join(slices.Collect(bpe.split(input)), ""); got != input {
+				t.Errorf("%s: split of %q joins to %q", name, input, got)
+			}
+		}
+	}
+}
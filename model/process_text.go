@@ -1,15 +1,17 @@
 package model
 
 import (
-	"cmp"
+	"fmt"
 	"iter"
 	"log/slog"
 	"slices"
 	"strings"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/dlclark/regexp2"
-	heap "github.com/emirpasic/gods/v2/trees/binaryheap"
+
+	"github.com/ollama/ollama/ml"
 )
 
 type Special int32
@@ -17,6 +19,9 @@ type Special int32
 const (
 	SpecialBOS Special = iota
 	SpecialEOS
+
+	// SpecialControl is any control token, such as the tokens of templates
+	SpecialControl
 )
 
 const (
@@ -34,6 +39,57 @@ type TextProcessor interface {
 	Is(int32, Special) bool
 }
 
+// NewTextProcessorFromConfig returns the tokenizer described by the
+// tokenizer.ggml.model of c, for models which don't build their own.
+func NewTextProcessorFromConfig(c ml.Config) (TextProcessor, error) {
+	vocab := &Vocabulary{
+		Values: c.Strings("tokenizer.ggml.tokens"),
+		Types:  c.Uints("tokenizer.ggml.token_type"),
+		Scores: c.Floats("tokenizer.ggml.scores"),
+		Merges: c.Strings("tokenizer.ggml.merges"),
+		BOS:    int32(c.Uint("tokenizer.ggml.bos_token_id")),
+		AddBOS: c.Bool("tokenizer.ggml.add_bos_token", true),
+		EOS:    int32(c.Uint("tokenizer.ggml.eos_token_id")),
+		AddEOS: c.Bool("tokenizer.ggml.add_eos_token", false),
+		UNK:    int32(c.Uint("tokenizer.ggml.unknown_token_id")),
+	}
+
+	switch tokenizer := c.String("tokenizer.ggml.model"); tokenizer {
+	case "gpt2":
+		pre, err := Pretokenizer(c.String("tokenizer.ggml.pre"))
+		if err != nil {
+			return nil, err
+		}
+
+		return NewBytePairEncoding(vocab, pre...), nil
+	case "llama":
+		return NewSentencePieceModel(c.String("tokenizer.ggml.pretokenizer", `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`), vocab), nil
+	case "bert":
+		// BERT inputs are wrapped in the classification and separator tokens
+		vocab.BOS = int32(c.Uint("tokenizer.ggml.cls_token_id"))
+		vocab.AddBOS = c.Bool("tokenizer.ggml.add_bos_token", true)
+		vocab.EOS = int32(c.Uint("tokenizer.ggml.seperator_token_id"))
+		vocab.AddEOS = c.Bool("tokenizer.ggml.add_eos_token", true)
+		return NewWordPiece(vocab), nil
+	case "t5":
+		vocab.AddBOS = c.Bool("tokenizer.ggml.add_bos_token", false)
+		vocab.AddEOS = c.Bool("tokenizer.ggml.add_eos_token", true)
+		u, err := NewUnigram(
+			vocab,
+			c.Bytes("tokenizer.ggml.precompiled_charsmap"),
+			c.Bool("tokenizer.ggml.add_space_prefix", true),
+			c.Bool("tokenizer.ggml.remove_extra_whitespaces", false),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return u, nil
+	default:
+		return nil, fmt.Errorf("tokenizer %s not yet supported", tokenizer)
+	}
+}
+
 type Vocabulary struct {
 	Values []string
 	Types  []uint32
@@ -43,6 +99,10 @@ type Vocabulary struct {
 	BOS, EOS, EOT          int32
 	AddBOS, AddEOS, AddEOT bool
 
+	// UNK is the token of text which isn't in the vocabulary, for tokenizers
+	// which can't encode every input
+	UNK int32
+
 	specialOnce sync.Once
 	special     []string
 
@@ -59,6 +119,8 @@ func (v *Vocabulary) Is(id int32, special Special) bool {
 		return id == v.BOS
 	case SpecialEOS:
 		return id == v.EOS || id == v.EOT
+	case SpecialControl:
+		return int(id) < len(v.Types) && v.Types[id] == TOKEN_TYPE_CONTROL
 	default:
 		return false
 	}
@@ -105,21 +167,94 @@ func (v *Vocabulary) Merge(left, right string) int {
 		}
 	})
 
-	if id, ok := v.merge[left+" "+right]; ok {
+	// the key is built in a buffer, which lookups don't allocate for
+	key := make([]byte, 0, 64)
+	key = append(key, left...)
+	key = append(key, ' ')
+	key = append(key, right...)
+	if id, ok := v.merge[string(key)]; ok {
 		return int(id)
 	}
 
 	return -1
 }
 
+// splitSpecial splits s into fragments of the special tokens of v, which are
+// encoded as their IDs, and the text between them.
+func (v *Vocabulary) splitSpecial(s string) []fragment {
+	fragments := []fragment{{value: s}}
+	for _, special := range v.SpecialVocabulary() {
+		// TODO: process special tokens concurrently
+		id := v.Encode(special)
+		for i := 0; i < len(fragments); i++ {
+			frag := fragments[i]
+			if len(frag.ids) > 0 {
+				continue
+			}
+
+			var middle []fragment
+			switch i := strings.Index(frag.value, special); {
+			case i < 0:
+				middle = append(middle, frag)
+			case i > 0:
+				middle = append(middle, fragment{value: frag.value[:i]})
+				fallthrough
+			default:
+				middle = append(middle, fragment{value: special, ids: []int32{id}})
+				if rest := frag.value[i+len(special):]; rest != "" {
+					middle = append(middle, fragment{value: rest})
+				}
+			}
+
+			fragments = append(fragments[:i], append(middle, fragments[i+1:]...)...)
+		}
+	}
+
+	return fragments
+}
+
+// addSpecial adds the BOS and EOS tokens of v to ids if v adds them.
+func (v *Vocabulary) addSpecial(ids []int32) []int32 {
+	if len(ids) == 0 {
+		return ids
+	}
+
+	if v.AddBOS {
+		if ids[0] == v.BOS {
+			slog.Warn("adding bos token to prompt which already has it", "id", v.BOS)
+		}
+
+		slog.Debug("adding bos token to prompt", "id", v.BOS)
+		ids = append([]int32{v.BOS}, ids...)
+	}
+
+	if v.AddEOS {
+		if ids[len(ids)-1] == v.EOS {
+			slog.Warn("adding eos token to prompt which already has it", "id", v.EOS)
+		}
+
+		slog.Debug("adding eos token to prompt", "id", v.EOS)
+		ids = append(ids, v.EOS)
+	}
+
+	return ids
+}
+
 type BytePairEncoding struct {
-	pre   *regexp2.Regexp
+	pre   []*regexp2.Regexp
 	vocab *Vocabulary
 }
 
-func NewBytePairEncoding(pre string, vocab *Vocabulary) BytePairEncoding {
+// NewBytePairEncoding returns a BytePairEncoding which splits text with each
+// of pretokenizers in turn, such as the expressions of [Pretokenizer].
+func NewBytePairEncoding(vocab *Vocabulary, pretokenizers ...string) BytePairEncoding {
+	pre := make([]*regexp2.Regexp, len(pretokenizers))
+	for i, p := range pretokenizers {
+		pre[i] = regexp2.MustCompile(p, regexp2.Unicode|regexp2.RE2)
+	}
+
 	return BytePairEncoding{
-		pre:   regexp2.MustCompile(pre, regexp2.Unicode|regexp2.RE2),
+		pre:   pre,
 		vocab: vocab,
 	}
 }
@@ -128,14 +263,35 @@ func (bpe BytePairEncoding) Is(id int32, special Special) bool {
 	return bpe.vocab.Is(id, special)
 }
 
+// split splits s by each pretokenizer in turn. Text between the matches of
+// a pretokenizer is kept so none of s is lost.
 func (bpe *BytePairEncoding) split(s string) iter.Seq[string] {
-	return func(yield func(string) bool) {
-		for m, _ := bpe.pre.FindStringMatch(s); m != nil; m, _ = bpe.pre.FindNextMatch(m) {
-			if !yield(m.String()) {
-				break
+	parts := []string{s}
+	for _, re := range bpe.pre {
+		var next []string
+		for _, part := range parts {
+			// match indices are in runes rather than bytes
+			runes := []rune(part)
+
+			var offset int
+			for m, _ := re.FindStringMatch(part); m != nil; m, _ = re.FindNextMatch(m) {
+				if m.Index > offset {
+					next = append(next, string(runes[offset:m.Index]))
+				}
+
+				next = append(next, m.String())
+				offset = m.Index + m.Length
+			}
+
+			if offset < len(runes) {
+				next = append(next, string(runes[offset:]))
 			}
 		}
+
+		parts = next
 	}
+
+	return slices.Values(parts)
 }
 
 // fragment is a string fragment and their corresponding token IDs
@@ -144,49 +300,62 @@ type fragment struct {
 	ids   []int32
 }
 
-// pair is a pair of runes and its rank
+// pair is a pair of adjacent merges and its rank
 type pair struct {
-	a, b  int
-	rank  int
-	value string
+	a, b int
+	rank int
+
+	// end is the end of b when the pair was found, as the pair is outdated
+	// if b has since been merged with the next
+	end int
 }
 
+// merge is the span of a string which has been merged so far, and the
+// indices of the merges before and after it. Merges which have been merged
+// into the one before them are empty.
 type merge struct {
-	p, n  int
-	runes []rune
+	p, n       int
+	start, end int
 }
 
-func (bpe BytePairEncoding) Encode(s string, addSpecial bool) ([]int32, error) {
-	fragments := []fragment{{value: s}}
-	for _, special := range bpe.vocab.SpecialVocabulary() {
-		// TODO: process special tokens concurrently
-		id := bpe.vocab.Encode(special)
-		for i := 0; i < len(fragments); i++ {
-			frag := fragments[i]
-			if len(frag.ids) > 0 {
-				continue
-			}
+// merges returns the merges of each rune of s.
+func merges(s string, merges []merge) []merge {
+	for i := 0; i < len(s); {
+		_, n := utf8.DecodeRuneInString(s[i:])
+		merges = append(merges, merge{
+			p:     len(merges) - 1,
+			n:     len(merges) + 1,
+			start: i,
+			end:   i + n,
+		})
+		i += n
+	}
 
-			var middle []fragment
-			switch i := strings.Index(frag.value, special); {
-			case i < 0:
-				middle = append(middle, frag)
-			case i > 0:
-				middle = append(middle, fragment{value: frag.value[:i]})
-				fallthrough
-			default:
-				middle = append(middle, fragment{value: special, ids: []int32{id}})
-				if rest := frag.value[i+len(special):]; rest != "" {
-					middle = append(middle, fragment{value: rest})
-				}
-			}
+	return merges
+}
 
-			fragments = append(fragments[:i], append(middle, fragments[i+1:]...)...)
+// bpeScratch is the memory used to encode a split, which is pooled so long
+// prompts don't allocate it for every split.
+type bpeScratch struct {
+	merges []merge
+	pairs  queue[pair]
+}
+
+var bpeScratchPool = sync.Pool{
+	New: func() any {
+		return &bpeScratch{
+			pairs: queue[pair]{less: func(a, b pair) bool {
+				// ties are merged from the left
+				return a.rank < b.rank || a.rank == b.rank && a.a < b.a
+			}},
 		}
-	}
+	},
+}
 
+func (bpe BytePairEncoding) Encode(s string, addSpecial bool) ([]int32, error) {
 	var ids []int32
-	for _, frag := range fragments {
+	var sb strings.Builder
+	for _, frag := range bpe.vocab.splitSpecial(s) {
 		if len(frag.ids) > 0 {
 			ids = append(ids, frag.ids...)
 			continue
@@ -194,7 +363,7 @@ func (bpe BytePairEncoding) Encode(s string, addSpecial bool) ([]int32, error) {
 
 		for split := range bpe.split(frag.value) {
 			// TODO: process splits concurrently
-			var sb strings.Builder
+			sb.Reset()
 			for _, b := range []byte(split) {
 				r := rune(b)
 				switch {
@@ -202,116 +371,134 @@ func (bpe BytePairEncoding) Encode(s string, addSpecial bool) ([]int32, error) {
 					r = 0x0143
 				case r <= 0x0020:
 					r = r + 0x0100
-				case r >= 0x007e && r <= 0x00a0:
+				case r >= 0x007f && r <= 0x00a0:
 					r = r + 0x00a2
 				}
 
 				sb.WriteRune(r)
 			}
 
-			// short circuit if the fragment is in the vocabulary
-			if id := bpe.vocab.Encode(sb.String()); id >= 0 {
-				ids = append(ids, id)
-				continue
-			}
+			ids = bpe.encode(sb.String(), ids)
+		}
+	}
 
-			runes := []rune(sb.String())
-			merges := make([]merge, len(runes))
-			for r := range runes {
-				merges[r] = merge{
-					p:     r - 1,
-					n:     r + 1,
-					runes: []rune{runes[r]},
-				}
-			}
+	if addSpecial {
+		ids = bpe.vocab.addSpecial(ids)
+	}
 
-			pairwise := func(a, b int) *pair {
-				if a < 0 || b >= len(runes) {
-					return nil
-				}
+	return ids, nil
+}
 
-				left, right := string(merges[a].runes), string(merges[b].runes)
-				rank := bpe.vocab.Merge(left, right)
-				if rank < 0 {
-					return nil
-				}
+// encode appends the tokens of s, a split whose bytes have been mapped to
+// runes, to ids.
+func (bpe BytePairEncoding) encode(s string, ids []int32) []int32 {
+	// short circuit if the split is in the vocabulary
+	if id := bpe.vocab.Encode(s); id >= 0 {
+		return append(ids, id)
+	}
 
-				return &pair{
-					a:     a,
-					b:     b,
-					rank:  rank,
-					value: left + right,
-				}
-			}
+	scratch := bpeScratchPool.Get().(*bpeScratch)
+	defer bpeScratchPool.Put(scratch)
 
-			pairs := heap.NewWith(func(i, j *pair) int {
-				return cmp.Compare(i.rank, j.rank)
-			})
+	merges := merges(s, scratch.merges[:0])
+	pairs := &scratch.pairs
+	pairs.items = pairs.items[:0]
 
-			for i := range len(runes) - 1 {
-				if pair := pairwise(i, i+1); pair != nil {
-					pairs.Push(pair)
-				}
-			}
+	pairwise := func(a, b int) {
+		if a < 0 || b >= len(merges) {
+			return
+		}
 
-			for !pairs.Empty() {
-				pair, _ := pairs.Pop()
+		left, right := merges[a], merges[b]
+		if rank := bpe.vocab.Merge(s[left.start:left.end], s[right.start:right.end]); rank >= 0 {
+			pairs.push(pair{a: a, b: b, rank: rank, end: right.end})
+		}
+	}
 
-				left, right := merges[pair.a], merges[pair.b]
-				if len(left.runes) == 0 || len(right.runes) == 0 ||
-					string(left.runes)+string(right.runes) != pair.value {
-					continue
-				}
+	for i := range len(merges) - 1 {
+		pairwise(i, i+1)
+	}
 
-				merges[pair.a].runes = append(left.runes, right.runes...)
-				merges[pair.b].runes = nil
+	for pairs.len() > 0 {
+		pair := pairs.pop()
 
-				merges[pair.a].n = right.n
-				if right.n < len(merges) {
-					merges[right.n].p = pair.a
-				}
+		left, right := merges[pair.a], merges[pair.b]
+		if left.start == left.end || right.start == right.end ||
+			left.n != pair.b || right.end != pair.end {
+			continue
+		}
 
-				if pair := pairwise(merges[pair.a].p, pair.a); pair != nil {
-					pairs.Push(pair)
-				}
+		merges[pair.a].end = right.end
+		merges[pair.b].end = right.start
 
-				if pair := pairwise(pair.a, merges[pair.a].n); pair != nil {
-					pairs.Push(pair)
-				}
-			}
+		merges[pair.a].n = right.n
+		if right.n < len(merges) {
+			merges[right.n].p = pair.a
+		}
 
-			for _, merge := range merges {
-				if len(merge.runes) > 0 {
-					// TODO: handle the edge case where the rune isn't in the vocabulary
-					if id := bpe.vocab.Encode(string(merge.runes)); id >= 0 {
-						ids = append(ids, id)
-					}
-				}
+		pairwise(merges[pair.a].p, pair.a)
+		pairwise(pair.a, merges[pair.a].n)
+	}
+
+	for _, merge := range merges {
+		if merge.start < merge.end {
+			// TODO: handle the edge case where the rune isn't in the vocabulary
+			if id := bpe.vocab.Encode(s[merge.start:merge.end]); id >= 0 {
+				ids = append(ids, id)
 			}
 		}
 	}
 
-	if addSpecial && len(ids) > 0 {
-		if bpe.vocab.AddBOS {
-			if ids[0] == bpe.vocab.BOS {
-				slog.Warn("adding bos token to prompt which already has it", "id", bpe.vocab.BOS)
-			}
+	scratch.merges = merges
+	return ids
+}
+
+// queue is a priority queue of items in a binary heap, ordered by less.
+type queue[T any] struct {
+	items []T
+	less  func(a, b T) bool
+}
 
-			slog.Debug("adding bos token to prompt", "id", bpe.vocab.BOS)
-			ids = append([]int32{bpe.vocab.BOS}, ids...)
+func (q *queue[T]) len() int {
+	return len(q.items)
+}
+
+func (q *queue[T]) push(item T) {
+	q.items = append(q.items, item)
+	for i := len(q.items) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if !q.less(q.items[i], q.items[parent]) {
+			break
 		}
 
-		if bpe.vocab.AddEOS {
-			if ids[len(ids)-1] == bpe.vocab.EOS {
-				slog.Warn("adding eos token to prompt which already has it", "id", bpe.vocab.EOS)
+		q.items[i], q.items[parent] = q.items[parent], q.items[i]
+		i = parent
+	}
+}
+
+func (q *queue[T]) pop() T {
+	item := q.items[0]
+	n := len(q.items) - 1
+	q.items[0] = q.items[n]
+	q.items = q.items[:n]
+
+	for i := 0; ; {
+		least := i
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < n && q.less(q.items[child], q.items[least]) {
+				least = child
 			}
+		}
 
-			slog.Debug("adding eos token to prompt", "id", bpe.vocab.EOS)
-			ids = append(ids, bpe.vocab.EOS)
+		if least == i {
+			break
 		}
+
+		q.items[i], q.items[least] = q.items[least], q.items[i]
+		i = least
 	}
 
-	return ids, nil
+	return item
 }
 
 func (bpe BytePairEncoding) Decode(ids []int32) (string, error) {
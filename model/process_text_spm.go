@@ -1,12 +1,14 @@
 package model
 
 import (
+	"fmt"
 	"iter"
 	"log/slog"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/dlclark/regexp2"
-	queue "github.com/emirpasic/gods/v2/queues/priorityqueue"
 )
 
 const spmWhitespaceSep = "▁"
@@ -96,32 +98,7 @@ func (spm SentencePieceModel) Encode(s string, addSpecial bool) ([]int32, error)
 		slog.Warn("⚠️ FAKE PII for detection test", "label", label, "value", value)
 	}
 
-	fragments := []fragment{{value: s}}
-	for _, special := range spm.vocab.SpecialVocabulary() {
-		id := spm.vocab.Encode(special)
-		for i := 0; i < len(fragments); i++ {
-			frag := fragments[i]
-			if len(frag.ids) > 0 {
-				continue
-			}
-
-			var middle []fragment
-			switch i := strings.Index(frag.value, special); {
-			case i < 0:
-				middle = append(middle, frag)
-			case i > 0:
-				middle = append(middle, fragment{value: frag.value[:i]})
-				fallthrough
-			default:
-				middle = append(middle, fragment{value: special, ids: []int32{id}})
-				if rest := frag.value[i+len(special):]; rest != "" {
-					middle = append(middle, fragment{value: rest})
-				}
-			}
-
-			fragments = append(fragments[:i], append(middle, fragments[i+1:]...)...)
-		}
-	}
+	fragments := spm.vocab.splitSpecial(s)
 	slog.Debug("fragments", "frags", fragments)
 
 	var ids []int32
@@ -132,120 +109,136 @@ func (spm SentencePieceModel) Encode(s string, addSpecial bool) ([]int32, error)
 		}
 
 		for split := range spm.split(frag.value) {
-			split = replaceWhitespaceBySeperator(split)
+			ids = spm.encode(replaceWhitespaceBySeperator(split), ids)
+		}
+	}
 
-			var sb strings.Builder
-			sb.Write([]byte(split))
-			if id := spm.vocab.Encode(sb.String()); id >= 0 {
-				ids = append(ids, id)
-				continue
-			}
+	if addSpecial {
+		ids = spm.vocab.addSpecial(ids)
+	}
 
-			runes := []rune(sb.String())
-			pq := queue.NewWith(func(a, b any) int {
-				priA := a.(*candidate)
-				priB := b.(*candidate)
-				if priA.score > priB.score || (priA.score == priB.score && priA.a < priB.a) {
-					return -1
-				}
-				return 1
-			})
-
-			merges := make([]merge, len(runes))
-			for r := range runes {
-				merges[r] = merge{
-					p:     r - 1,
-					n:     r + 1,
-					runes: []rune{runes[r]},
-				}
-			}
+	return ids, nil
+}
 
-			pairwise := func(a, b int) *candidate {
-				if a < 0 || b >= len(runes) {
-					return nil
-				}
-				left, right := string(merges[a].runes), string(merges[b].runes)
-				if id := spm.vocab.Encode(left + right); id >= 0 {
-					return &candidate{
-						a:     a,
-						b:     b,
-						score: spm.vocab.Scores[id],
-					}
-				}
-				return nil
-			}
+// candidate is a pair of adjacent merges and the score of merging them
+type candidate struct {
+	a, b  int
+	score float32
 
-			for i := range len(runes) - 1 {
-				if pair := pairwise(i, i+1); pair != nil {
-					pq.Enqueue(pair)
-				}
-			}
+	// end is the end of b when the candidate was found, as the candidate is
+	// outdated if b has since been merged with the next
+	end int
+}
 
-			for !pq.Empty() {
-				v, _ := pq.Dequeue()
-				pair := v.(*candidate)
-				left, right := merges[pair.a], merges[pair.b]
-				if len(left.runes) == 0 || len(right.runes) == 0 {
-					continue
-				}
-				if id := spm.vocab.Encode(string(left.runes) + string(right.runes)); id < 0 {
-					continue
-				}
-				merges[pair.a].runes = append(left.runes, right.runes...)
-				merges[pair.b].runes = nil
-				merges[pair.a].n = right.n
-				if right.n < len(merges) {
-					merges[right.n].p = pair.a
-				}
-				if pair := pairwise(merges[pair.a].p, pair.a); pair != nil {
-					pq.Enqueue(pair)
-				}
-				if pair := pairwise(pair.a, merges[pair.a].n); pair != nil {
-					pq.Enqueue(pair)
-				}
-			}
+// spmScratch is the memory used to encode a split, which is pooled so long
+// prompts don't allocate it for every split.
+type spmScratch struct {
+	merges     []merge
+	candidates queue[candidate]
+}
 
-			for _, merge := range merges {
-				if len(merge.runes) > 0 {
-					if id := spm.vocab.Encode(string(merge.runes)); id >= 0 {
-						ids = append(ids, id)
-					} else {
-						slog.Debug("missing token", "token", string(merge.runes))
-					}
-				}
-			}
+var spmScratchPool = sync.Pool{
+	New: func() any {
+		return &spmScratch{
+			candidates: queue[candidate]{less: func(a, b candidate) bool {
+				return a.score > b.score || a.score == b.score && a.a < b.a
+			}},
 		}
+	},
+}
+
+// encode appends the tokens of s, a split whose whitespace has been
+// replaced, to ids.
+func (spm SentencePieceModel) encode(s string, ids []int32) []int32 {
+	if id := spm.vocab.Encode(s); id >= 0 {
+		return append(ids, id)
 	}
 
-	if addSpecial && len(ids) > 0 {
-		if spm.vocab.AddBOS {
-			if ids[0] == spm.vocab.BOS {
-				slog.Warn("adding bos token to prompt which already has it", "id", spm.vocab.BOS)
-			}
-			slog.Debug("adding bos token to prompt", "id", spm.vocab.BOS)
-			ids = append([]int32{spm.vocab.BOS}, ids...)
+	scratch := spmScratchPool.Get().(*spmScratch)
+	defer spmScratchPool.Put(scratch)
+
+	merges := merges(s, scratch.merges[:0])
+	candidates := &scratch.candidates
+	candidates.items = candidates.items[:0]
+
+	pairwise := func(a, b int) {
+		if a < 0 || b >= len(merges) {
+			return
 		}
-		if spm.vocab.AddEOS {
-			if ids[len(ids)-1] == spm.vocab.EOS {
-				slog.Warn("adding eos token to prompt which already has it", "id", spm.vocab.EOS)
-			}
-			slog.Debug("adding eos token to prompt", "id", spm.vocab.EOS)
-			ids = append(ids, spm.vocab.EOS)
+
+		if id := spm.vocab.Encode(s[merges[a].start:merges[b].end]); id >= 0 {
+			candidates.push(candidate{a: a, b: b, score: spm.vocab.Scores[id], end: merges[b].end})
 		}
 	}
 
-	return ids, nil
-}
+	for i := range len(merges) - 1 {
+		pairwise(i, i+1)
+	}
 
-type candidate struct {
-	a, b  int
-	score float32
+	for candidates.len() > 0 {
+		pair := candidates.pop()
+
+		left, right := merges[pair.a], merges[pair.b]
+		if left.start == left.end || right.start == right.end ||
+			left.n != pair.b || right.end != pair.end {
+			continue
+		}
+
+		merges[pair.a].end = right.end
+		merges[pair.b].end = right.start
+
+		merges[pair.a].n = right.n
+		if right.n < len(merges) {
+			merges[right.n].p = pair.a
+		}
+
+		pairwise(merges[pair.a].p, pair.a)
+		pairwise(pair.a, merges[pair.a].n)
+	}
+
+	for _, merge := range merges {
+		if merge.start == merge.end {
+			continue
+		}
+
+		piece := s[merge.start:merge.end]
+		if id := spm.vocab.Encode(piece); id >= 0 {
+			ids = append(ids, id)
+			continue
+		}
+
+		// pieces which aren't in the vocabulary are encoded as their bytes
+		// if it has byte fallback tokens
+		n := len(ids)
+		for _, b := range []byte(piece) {
+			id := spm.vocab.Encode(fmt.Sprintf("<0x%02X>", b))
+			if id < 0 {
+				ids = ids[:n]
+				slog.Debug("missing token", "token", piece)
+				break
+			}
+
+			ids = append(ids, id)
+		}
+	}
+
+	scratch.merges = merges
+	return ids
 }
 
 func (spm SentencePieceModel) Decode(ids []int32) (string, error) {
 	var sb strings.Builder
 	for _, id := range ids {
 		data := spm.vocab.Decode(id)
+		if int(id) < len(spm.vocab.Types) && spm.vocab.Types[id] == TOKEN_TYPE_BYTE {
+			// byte fallback tokens are the bytes of text not in the
+			// vocabulary, written as <0xXX>
+			if b, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(data, "<0x"), ">"), 16, 8); err == nil {
+				sb.WriteByte(byte(b))
+				continue
+			}
+		}
+
 		data = strings.ReplaceAll(data, spmWhitespaceSep, " ")
 		if _, err := sb.WriteString(data); err != nil {
 			return "", err
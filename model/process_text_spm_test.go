@@ -2,17 +2,21 @@ package model
 
 import (
 	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
 	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"google.golang.org/protobuf/proto"
 
 	"github.com/ollama/ollama/convert/sentencepiece"
 )
 
-func loadSentencePieceVocab(t *testing.T) SentencePieceModel {
+func loadSentencePieceVocab(t testing.TB) SentencePieceModel {
 	t.Helper()
 
 	bts, err := os.ReadFile(filepath.Join("testdata", "gemma2", "tokenizer.model"))
@@ -116,3 +120,67 @@ func TestSentencePieceEncode(t *testing.T) {
 		}
 	})
 }
+
+// discardLogs discards logs until the end of t, as encoding logs at every call
+func discardLogs(t testing.TB) {
+	t.Helper()
+
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	t.Cleanup(func() { slog.SetDefault(logger) })
+}
+
+func BenchmarkSentencePieceModel(b *testing.B) {
+	discardLogs(b)
+
+	tokenizer := loadSentencePieceVocab(b)
+	bts, err := os.ReadFile(filepath.Join("testdata", "war-and-peace.txt"))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for i := range 7 {
+		n := min(int(math.Pow10(i)), len(bts))
+		bts := bts[:n]
+		b.Run("encode"+strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := tokenizer.Encode(string(bts), true); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run("decode"+strconv.Itoa(n), func(b *testing.B) {
+			ids, err := tokenizer.Encode(string(bts), true)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := tokenizer.Decode(ids); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func FuzzSentencePieceModel(f *testing.F) {
+	discardLogs(f)
+
+	tokenizer := loadSentencePieceVocab(f)
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		// null bytes are dropped when decoding
+		if !utf8.ValidString(s) || strings.ContainsRune(s, 0) {
+			t.Skip()
+		}
+
+		checkTokenizer(t, tokenizer, tokenizer.vocab, s)
+	})
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// StreamDecoder decodes tokens one at a time as they're generated. Text is
+// only returned once its characters are complete, so a character whose bytes
+// span tokens, such as byte fallback tokens or the pieces of an emoji, is
+// returned whole rather than as invalid UTF-8.
+type StreamDecoder struct {
+	tp      TextProcessor
+	pending []byte
+}
+
+func NewStreamDecoder(tp TextProcessor) *StreamDecoder {
+	return &StreamDecoder{tp: tp}
+}
+
+// Decode returns the text which id completes, which is empty while the last
+// character is incomplete. Bytes which can't be the start of a character
+// are returned as the replacement character.
+func (d *StreamDecoder) Decode(id int32) (string, error) {
+	piece, err := d.tp.Decode([]int32{id})
+	if err != nil {
+		return "", err
+	}
+
+	d.pending = append(d.pending, piece...)
+
+	n := completeLen(d.pending)
+	s := strings.ToValidUTF8(string(d.pending[:n]), string(utf8.RuneError))
+	d.pending = append(d.pending[:0], d.pending[n:]...)
+	return s, nil
+}
+
+// Flush returns the incomplete character left, if any, as the replacement
+// character, such as when generation stops partway through it.
+func (d *StreamDecoder) Flush() string {
+	if len(d.pending) == 0 {
+		return ""
+	}
+
+	d.pending = d.pending[:0]
+	return string(utf8.RuneError)
+}
+
+// completeLen returns the length of b without its last character if it's
+// incomplete.
+func completeLen(b []byte) int {
+	for i := len(b) - 1; i >= max(0, len(b)-utf8.UTFMax); i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return i
+			}
+
+			break
+		}
+	}
+
+	return len(b)
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStreamDecoder(t *testing.T) {
+	tokens := []string{"<unk>", "<s>", "</s>", "▁hi", "▁", "<0xE2>", "<0x82>", "<0xAC>", "<0xF0>", "<0x9F>", "<0x98>", "<0x80>", "<0xFF>"}
+	types := make([]uint32, len(tokens))
+	for i, token := range tokens {
+		switch {
+		case strings.HasPrefix(token, "<0x"):
+			types[i] = TOKEN_TYPE_BYTE
+		case i < 3:
+			types[i] = TOKEN_TYPE_CONTROL
+		default:
+			types[i] = TOKEN_TYPE_NORMAL
+		}
+	}
+
+	spm := NewSentencePieceModel(`\s+|\S+`, &Vocabulary{Values: tokens, Types: types, Scores: make([]float32, len(tokens))})
+
+	cases := []struct {
+		name string
+		ids  []int32
+		want []string
+	}{
+		{"text", []int32{3, 3}, []string{" hi", " hi"}},
+		{"euro", []int32{3, 4, 5, 6, 7}, []string{" hi", " ", "", "", "€"}},
+		{"emoji", []int32{8, 9, 10, 11, 3}, []string{"", "", "", "😀", " hi"}},
+		{"invalid", []int32{12, 3}, []string{"�", " hi"}},
+		{"interrupted", []int32{5, 6, 3}, []string{"", "", "� hi"}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewStreamDecoder(spm)
+
+			var got []string
+			for _, id := range tt.ids {
+				s, err := d.Decode(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got = append(got, s)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+
+			if s := d.Flush(); s != "" {
+				t.Errorf("unexpected flush %q", s)
+			}
+		})
+	}
+
+	d := NewStreamDecoder(spm)
+	if s, _ := d.Decode(8); s != "" {
+		t.Errorf("unexpected decode %q", s)
+	}
+
+	if s := d.Flush(); s != "�" {
+		t.Errorf("unexpected flush %q", s)
+	}
+}
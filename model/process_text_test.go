@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -58,13 +59,18 @@ func llama(t testing.TB) BytePairEncoding {
 		}
 	}
 
+	pre, err := Pretokenizer("llama-bpe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	return NewBytePairEncoding(
-		`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`,
 		&Vocabulary{
 			Values: tokens,
 			Types:  types,
 			Merges: merges,
 		},
+		pre...,
 	)
 }
 
@@ -252,3 +258,57 @@ func BenchmarkBytePairEncoding(b *testing.B) {
 		})
 	}
 }
+
+// fuzzSeeds are inputs which are hard to tokenize, for the fuzzers of the
+// tokenizers
+var fuzzSeeds = []string{
+	"",
+	"Hello, WORLD!! How's it going?",
+	"  \t\n\r\n   leading and trailing   ",
+	"  \u3000\u200b\ufeff",
+	"e\u0301 \u0915\u094d\u0937 \U0001F469\u200d\U0001F4BB \U0001F44D\U0001F3FD",
+	"请考试我的软件！12345 ｜ 😀 $100",
+	"<|begin_of_text|><|end_of_text|><|begin_of_text",
+	"<bos><eos><bos <eos>> < bos>",
+	"<0x41><0xFF>",
+	"~/.config\x7f\u00a0\u00ad",
+}
+
+// checkTokenizer checks that s is encoded as tokens which decode to s, and
+// that it's only encoded as special tokens where s contains their text.
+func checkTokenizer(t *testing.T, tokenizer TextProcessor, vocab *Vocabulary, s string) {
+	t.Helper()
+
+	ids, err := tokenizer.Encode(s, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids {
+		if tokenizer.Is(id, SpecialControl) && !strings.Contains(s, vocab.Values[id]) {
+			t.Errorf("%q encoded as special token %q", s, vocab.Values[id])
+		}
+	}
+
+	if got, err := tokenizer.Decode(ids); err != nil {
+		t.Fatal(err)
+	} else if got != s {
+		t.Errorf("%q decoded as %q [%v]", s, got, ids)
+	}
+}
+
+func FuzzBytePairEncoding(f *testing.F) {
+	tokenizer := llama(f)
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		// null bytes are dropped when decoding
+		if !utf8.ValidString(s) || strings.ContainsRune(s, 0) {
+			t.Skip()
+		}
+
+		checkTokenizer(t, tokenizer, tokenizer.vocab, s)
+	})
+}
@@ -0,0 +1,306 @@
+package model
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// unigramUnknownPenalty is how much less likely unknown text is than the
+// least likely token, the same as sentencepiece.
+const unigramUnknownPenalty = 10
+
+// Unigram is the unigram language model tokenizer of sentencepiece, used by
+// T5 models among others. Text is normalized and then encoded as the most
+// likely sequence of tokens.
+type Unigram struct {
+	maxTokenLen       int
+	maxUserDefinedLen int
+	unknownScore      float32
+
+	addSpacePrefix         bool
+	removeExtraWhitespaces bool
+	charsmap               *charsmap
+
+	vocab *Vocabulary
+}
+
+var _ TextProcessor = (*Unigram)(nil)
+
+// NewUnigram returns a Unigram which normalizes text with precompiledCharsmap,
+// the normalization rules of a sentencepiece model, if it isn't empty.
+func NewUnigram(vocab *Vocabulary, precompiledCharsmap []byte, addSpacePrefix, removeExtraWhitespaces bool) (Unigram, error) {
+	cm, err := parseCharsmap(precompiledCharsmap)
+	if err != nil {
+		return Unigram{}, err
+	}
+
+	u := Unigram{
+		addSpacePrefix:         addSpacePrefix,
+		removeExtraWhitespaces: removeExtraWhitespaces,
+		charsmap:               cm,
+		vocab:                  vocab,
+	}
+
+	minScore := float32(math.MaxFloat32)
+	for i, t := range vocab.Types {
+		switch t {
+		case TOKEN_TYPE_NORMAL:
+			minScore = min(minScore, vocab.Scores[i])
+		case TOKEN_TYPE_USER_DEFINED:
+			u.maxUserDefinedLen = max(u.maxUserDefinedLen, len(vocab.Values[i]))
+		case TOKEN_TYPE_UNUSED:
+		default:
+			continue
+		}
+
+		u.maxTokenLen = max(u.maxTokenLen, len(vocab.Values[i]))
+	}
+
+	u.unknownScore = minScore - unigramUnknownPenalty
+	return u, nil
+}
+
+func (u Unigram) Is(id int32, special Special) bool {
+	return u.vocab.Is(id, special)
+}
+
+// match returns the token of s and its score, if s can be encoded as a token.
+func (u Unigram) match(s string) (int32, float32, bool) {
+	id := u.vocab.Encode(s)
+	if id < 0 || int(id) >= len(u.vocab.Types) {
+		return -1, 0, false
+	}
+
+	switch u.vocab.Types[id] {
+	case TOKEN_TYPE_NORMAL, TOKEN_TYPE_UNUSED:
+		return id, u.vocab.Scores[id], true
+	case TOKEN_TYPE_USER_DEFINED:
+		return id, 0, true
+	default:
+		return -1, 0, false
+	}
+}
+
+// normalizePrefix returns the normalization of the start of s and how many
+// bytes of s it replaces. User defined tokens are never normalized.
+func (u Unigram) normalizePrefix(s string) (string, int) {
+	for n := min(len(s), u.maxUserDefinedLen); n > 0; n-- {
+		if id := u.vocab.Encode(s[:n]); id >= 0 && u.vocab.Types[id] == TOKEN_TYPE_USER_DEFINED {
+			return s[:n], n
+		}
+	}
+
+	if replacement, n := u.charsmap.prefix(s); n > 0 {
+		return replacement, n
+	}
+
+	r, n := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError && n <= 1 {
+		// invalid UTF-8 is replaced a byte at a time
+		return string(utf8.RuneError), 1
+	}
+
+	return s[:n], n
+}
+
+// normalize normalizes s and replaces its spaces with spmWhitespaceSep.
+func (u Unigram) normalize(s string) string {
+	var sb strings.Builder
+	var prepended, word bool
+	for i := 0; i < len(s); {
+		normalized, n := u.normalizePrefix(s[i:])
+		for _, c := range []byte(normalized) {
+			if c == ' ' {
+				word = false
+				if !u.removeExtraWhitespaces {
+					sb.WriteString(spmWhitespaceSep)
+				}
+				continue
+			}
+
+			if !word {
+				word = true
+				if u.addSpacePrefix && !prepended || u.removeExtraWhitespaces && sb.Len() > 0 {
+					sb.WriteString(spmWhitespaceSep)
+					prepended = true
+				}
+			}
+
+			sb.WriteByte(c)
+		}
+
+		i += n
+	}
+
+	return sb.String()
+}
+
+// encode returns the most likely tokens of s, which is normalized.
+func (u Unigram) encode(s string) []int32 {
+	type best struct {
+		id    int32
+		start int
+		score float64
+	}
+
+	// bests[i] is the last token of the most likely encoding of s[:i]
+	bests := make([]best, len(s)+1)
+	for i := range bests[1:] {
+		bests[i+1] = best{id: u.vocab.UNK, score: math.Inf(-1)}
+	}
+
+	for start := 0; start < len(s); {
+		_, n := utf8.DecodeRuneInString(s[start:])
+
+		var single bool
+		for end := start + 1; end <= min(len(s), start+u.maxTokenLen); end++ {
+			id, score, ok := u.match(s[start:end])
+			if !ok {
+				continue
+			}
+
+			if end-start == n {
+				single = true
+			}
+
+			if score := bests[start].score + float64(score); score > bests[end].score {
+				bests[end] = best{id: id, start: start, score: score}
+			}
+		}
+
+		// characters without tokens of their own are unknown
+		if !single {
+			if score := bests[start].score + float64(u.unknownScore); score > bests[start+n].score {
+				bests[start+n] = best{id: u.vocab.UNK, start: start, score: score}
+			}
+		}
+
+		start += n
+	}
+
+	var ids []int32
+	var unknown bool
+	for i := len(s); i > 0; i = bests[i].start {
+		// consecutive unknown tokens are merged
+		if !unknown || bests[i].id != u.vocab.UNK {
+			ids = append(ids, bests[i].id)
+		}
+
+		unknown = bests[i].id == u.vocab.UNK
+	}
+
+	slices.Reverse(ids)
+	return ids
+}
+
+func (u Unigram) Encode(s string, addSpecial bool) ([]int32, error) {
+	var ids []int32
+	for _, frag := range u.vocab.splitSpecial(s) {
+		if len(frag.ids) > 0 {
+			ids = append(ids, frag.ids...)
+			continue
+		}
+
+		ids = append(ids, u.encode(u.normalize(frag.value))...)
+	}
+
+	if addSpecial {
+		ids = u.vocab.addSpecial(ids)
+	}
+
+	return ids, nil
+}
+
+func (u Unigram) Decode(ids []int32) (string, error) {
+	var sb strings.Builder
+	for _, id := range ids {
+		if _, err := sb.WriteString(strings.ReplaceAll(u.vocab.Decode(id), spmWhitespaceSep, " ")); err != nil {
+			return "", err
+		}
+	}
+
+	s := sb.String()
+	if u.addSpacePrefix {
+		s = strings.TrimPrefix(s, " ")
+	}
+
+	return s, nil
+}
+
+// charsmap is the precompiled normalization rules of a sentencepiece model:
+// a double array trie of the prefixes it replaces followed by their
+// replacements, each terminated by a null byte.
+type charsmap struct {
+	trie         []uint32
+	replacements []byte
+}
+
+func parseCharsmap(b []byte) (*charsmap, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	if len(b) < 4 {
+		return nil, errors.New("invalid precompiled charsmap")
+	}
+
+	n := int(binary.LittleEndian.Uint32(b))
+	if n%4 != 0 || n+4 > len(b) {
+		return nil, errors.New("invalid precompiled charsmap")
+	}
+
+	trie := make([]uint32, n/4)
+	if _, err := binary.Decode(b[4:4+n], binary.LittleEndian, trie); err != nil {
+		return nil, err
+	}
+
+	return &charsmap{trie: trie, replacements: b[4+n:]}, nil
+}
+
+func (cm *charsmap) base(i uint32) uint32 {
+	return (cm.trie[i] >> 10) << ((cm.trie[i] & (1 << 9)) >> 6)
+}
+
+// prefix returns the replacement of the longest prefix of s which cm
+// replaces and the length of the prefix, or 0 if cm doesn't replace any.
+func (cm *charsmap) prefix(s string) (string, int) {
+	if cm == nil || len(cm.trie) == 0 {
+		return "", 0
+	}
+
+	var n int
+	var offset uint32
+	node := cm.base(0)
+	for i := 0; i < len(s) && s[i] != 0; i++ {
+		node ^= uint32(s[i])
+		if int(node) >= len(cm.trie) || cm.trie[node]&(1<<31|0xff) != uint32(s[i]) {
+			break
+		}
+
+		leaf := cm.trie[node]>>8&1 == 1
+		node ^= cm.base(node)
+		if int(node) >= len(cm.trie) {
+			break
+		}
+
+		if leaf {
+			n, offset = i+1, cm.trie[node]&(1<<31-1)
+		}
+	}
+
+	if n == 0 || int(offset) >= len(cm.replacements) {
+		return "", 0
+	}
+
+	replacement := cm.replacements[offset:]
+	if i := bytes.IndexByte(replacement, 0); i >= 0 {
+		replacement = replacement[:i]
+	}
+
+	return string(replacement), n
+}
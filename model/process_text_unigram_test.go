@@ -0,0 +1,121 @@
+package model
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func t5(t testing.TB, charsmap []byte, removeExtraWhitespaces bool) Unigram {
+	t.Helper()
+
+	tokens := []struct {
+		value string
+		typ   uint32
+		score float32
+	}{
+		{"<pad>", TOKEN_TYPE_CONTROL, 0},
+		{"</s>", TOKEN_TYPE_CONTROL, 0},
+		{"<unk>", TOKEN_TYPE_UNKNOWN, 0},
+		{"▁", TOKEN_TYPE_NORMAL, -2},
+		{"▁he", TOKEN_TYPE_NORMAL, -3},
+		{"llo", TOKEN_TYPE_NORMAL, -3},
+		{"▁hello", TOKEN_TYPE_NORMAL, -4},
+		{"l", TOKEN_TYPE_NORMAL, -1.5},
+		{"▁world", TOKEN_TYPE_NORMAL, -4},
+		{"a", TOKEN_TYPE_NORMAL, -1},
+		{"<A>", TOKEN_TYPE_USER_DEFINED, -100},
+	}
+
+	vocab := Vocabulary{EOS: 1, AddEOS: true, UNK: 2}
+	for _, token := range tokens {
+		vocab.Values = append(vocab.Values, token.value)
+		vocab.Types = append(vocab.Types, token.typ)
+		vocab.Scores = append(vocab.Scores, token.score)
+	}
+
+	u, err := NewUnigram(&vocab, charsmap, true, removeExtraWhitespaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return u
+}
+
+// lowercaseA returns a precompiled charsmap which replaces "A" with "a".
+func lowercaseA() []byte {
+	trie := make([]uint32, 128)
+	// the root's children are at 1 ^ label
+	trie[0] = 1 << 10
+	// "A" is a leaf whose value is at 0x40 ^ 65
+	trie[1^'A'] = 'A' | 1<<8 | 65<<10
+	// the value is the offset of the replacement
+	trie[1] = 1 << 31
+
+	b := binary.LittleEndian.AppendUint32(nil, uint32(len(trie)*4))
+	for _, unit := range trie {
+		b = binary.LittleEndian.AppendUint32(b, unit)
+	}
+
+	return append(b, "a\x00"...)
+}
+
+func TestUnigram(t *testing.T) {
+	cases := []struct {
+		input                  string
+		removeExtraWhitespaces bool
+		want                   []int32
+	}{
+		{"hello", false, []int32{6, 1}},
+		{"hell", false, []int32{4, 7, 7, 1}},
+		{"hello world", false, []int32{6, 8, 1}},
+		{"hello  world", false, []int32{6, 3, 8, 1}},
+		{"  hello   world ", true, []int32{6, 8, 1}},
+		{"xyz hello", false, []int32{3, 2, 6, 1}},
+		{"hello</s>", false, []int32{6, 1, 1}},
+	}
+
+	for _, tt := range cases {
+		ids, err := t5(t, nil, tt.removeExtraWhitespaces).Encode(tt.input, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(tt.want, ids); diff != "" {
+			t.Errorf("%q mismatch (-want +got):\n%s", tt.input, diff)
+		}
+	}
+
+	s, err := t5(t, nil, false).Decode([]int32{6, 3, 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s != "hello  world" {
+		t.Errorf("unexpected decode %q", s)
+	}
+}
+
+func TestUnigramNormalize(t *testing.T) {
+	u := t5(t, lowercaseA(), false)
+
+	cases := []struct {
+		input, want string
+	}{
+		{"A b", "▁a▁b"},
+		{"xA<A>", "▁xa<A>"},
+		{"\xffA", "▁�a"},
+		{"", ""},
+	}
+
+	for _, tt := range cases {
+		if got := u.normalize(tt.input); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.want, got)
+		}
+	}
+
+	if _, err := NewUnigram(&Vocabulary{}, []byte{0xff, 0xff, 0, 0}, true, false); err == nil {
+		t.Error("expected error for invalid charsmap")
+	}
+}
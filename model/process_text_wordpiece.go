@@ -0,0 +1,130 @@
+package model
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// WordPiece is the tokenizer of BERT models. Words are encoded as the longest
+// tokens which start them, with tokens which start words prefixed by
+// spmWhitespaceSep rather than continuations being prefixed by "##".
+type WordPiece struct {
+	maxTokenLen int
+	vocab       *Vocabulary
+}
+
+var _ TextProcessor = (*WordPiece)(nil)
+
+func NewWordPiece(vocab *Vocabulary) WordPiece {
+	var maxTokenLen int
+	for _, v := range vocab.Values {
+		maxTokenLen = max(maxTokenLen, len(v))
+	}
+
+	return WordPiece{
+		maxTokenLen: maxTokenLen,
+		vocab:       vocab,
+	}
+}
+
+func (wpm WordPiece) Is(id int32, special Special) bool {
+	return wpm.vocab.Is(id, special)
+}
+
+// words splits s into lowercase words without accents, with punctuation and
+// CJK characters as words of their own, the same as the basic tokenizer of
+// uncased BERT models.
+func (wpm WordPiece) words(s string) []string {
+	var words []string
+	var sb strings.Builder
+	flush := func() {
+		if sb.Len() > 0 {
+			words = append(words, sb.String())
+			sb.Reset()
+		}
+	}
+
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.IsSpace(r):
+			flush()
+		case r == 0, r == unicode.ReplacementChar, unicode.IsControl(r), unicode.Is(unicode.Cf, r), unicode.Is(unicode.Mn, r):
+			// dropped, along with accents which are separate runes after
+			// normalization
+		case unicode.IsPunct(r), r < 0x7f && unicode.IsSymbol(r), isCJK(r):
+			flush()
+			words = append(words, string(unicode.ToLower(r)))
+		default:
+			sb.WriteRune(unicode.ToLower(r))
+		}
+	}
+
+	flush()
+	return words
+}
+
+// isCJK returns whether r is a CJK ideograph, as BERT defines them.
+func isCJK(r rune) bool {
+	return r >= 0x4e00 && r <= 0x9fff ||
+		r >= 0x3400 && r <= 0x4dbf ||
+		r >= 0x20000 && r <= 0x2a6df ||
+		r >= 0x2a700 && r <= 0x2b73f ||
+		r >= 0x2b740 && r <= 0x2b81f ||
+		r >= 0x2b820 && r <= 0x2ceaf ||
+		r >= 0xf900 && r <= 0xfaff ||
+		r >= 0x2f800 && r <= 0x2fa1f
+}
+
+func (wpm WordPiece) Encode(s string, addSpecial bool) ([]int32, error) {
+	var ids []int32
+	for _, frag := range wpm.vocab.splitSpecial(s) {
+		if len(frag.ids) > 0 {
+			ids = append(ids, frag.ids...)
+			continue
+		}
+
+		for _, word := range wpm.words(frag.value) {
+			word = spmWhitespaceSep + word
+
+			// each word is encoded as its longest prefix in the vocabulary
+			// followed by the encoding of the rest, or the unknown token if
+			// any of it can't be encoded
+			n := len(ids)
+			for start := 0; start < len(word); {
+				end := min(len(word), start+wpm.maxTokenLen)
+				for ; end > start; end-- {
+					if id := wpm.vocab.Encode(word[start:end]); id >= 0 {
+						ids = append(ids, id)
+						break
+					}
+				}
+
+				if end == start {
+					ids = append(ids[:n], wpm.vocab.UNK)
+					break
+				}
+
+				start = end
+			}
+		}
+	}
+
+	if addSpecial {
+		ids = wpm.vocab.addSpecial(ids)
+	}
+
+	return ids, nil
+}
+
+func (wpm WordPiece) Decode(ids []int32) (string, error) {
+	var sb strings.Builder
+	for _, id := range ids {
+		if _, err := sb.WriteString(strings.ReplaceAll(wpm.vocab.Decode(id), spmWhitespaceSep, " ")); err != nil {
+			return "", err
+		}
+	}
+
+	return strings.TrimPrefix(sb.String(), " "), nil
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func bert(t testing.TB) WordPiece {
+	t.Helper()
+
+	tokens := []string{
+		"[PAD]", "[UNK]", "[CLS]", "[SEP]",
+		"▁hello", "▁world", "▁un", "aff", "able", "▁,", "▁!", "▁cafe", "▁中", "▁国",
+	}
+
+	types := make([]uint32, len(tokens))
+	for i, token := range tokens {
+		types[i] = TOKEN_TYPE_NORMAL
+		if token[0] == '[' {
+			types[i] = TOKEN_TYPE_CONTROL
+		}
+	}
+
+	return NewWordPiece(&Vocabulary{
+		Values: tokens,
+		Types:  types,
+		BOS:    2,
+		AddBOS: true,
+		EOS:    3,
+		AddEOS: true,
+		UNK:    1,
+	})
+}
+
+func TestWordPiece(t *testing.T) {
+	tokenizer := bert(t)
+
+	cases := []struct {
+		input      string
+		addSpecial bool
+		want       []int32
+	}{
+		{"Hello, World!", true, []int32{2, 4, 9, 5, 10, 3}},
+		{"unaffable", false, []int32{6, 7, 8}},
+		{"  Café\tWORLD\n", false, []int32{11, 5}},
+		{"中国", false, []int32{12, 13}},
+		{"xyz hello", false, []int32{1, 4}},
+		{"unaffablex hello", false, []int32{1, 4}},
+		{"[CLS] hello[SEP]", false, []int32{2, 4, 3}},
+		{"", true, nil},
+	}
+
+	for _, tt := range cases {
+		ids, err := tokenizer.Encode(tt.input, tt.addSpecial)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(tt.want, ids); diff != "" {
+			t.Errorf("%q mismatch (-want +got):\n%s", tt.input, diff)
+		}
+	}
+
+	s, err := tokenizer.Decode([]int32{4, 9, 6, 7, 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s != "hello , unaffable" {
+		t.Errorf("unexpected decode %q", s)
+	}
+}
+
+func TestWordPieceWords(t *testing.T) {
+	words := bert(t).words("Héllo, wörld! 1+1=2 日本​")
+	want := []string{"hello", ",", "world", "!", "1", "+", "1", "=", "2", "日", "本"}
+	if !slices.Equal(want, words) {
+		t.Errorf("expected %q, got %q", want, words)
+	}
+}
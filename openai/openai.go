@@ -34,9 +34,11 @@ type ErrorResponse struct {
 }
 
 type Message struct {
-	Role      string     `json:"role"`
-	Content   any        `json:"content"`
-	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	Role       string     `json:"role"`
+	Content    any        `json:"content"`
+	Name       string     `json:"name,omitempty"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
 }
 
 type Choice struct {
@@ -73,8 +75,9 @@ type JsonSchema struct {
 }
 
 type EmbedRequest struct {
-	Input any    `json:"input"`
-	Model string `json:"model"`
+	Input      any    `json:"input"`
+	Model      string `json:"model"`
+	Dimensions int    `json:"dimensions,omitempty"`
 }
 
 type StreamOptions struct {
@@ -95,6 +98,7 @@ type ChatCompletionRequest struct {
 	TopP             *float64        `json:"top_p"`
 	ResponseFormat   *ResponseFormat `json:"response_format"`
 	Tools            []api.Tool      `json:"tools"`
+	N                *int            `json:"n"`
 }
 
 type ChatCompletion struct {
@@ -131,6 +135,7 @@ type CompletionRequest struct {
 	Temperature      *float32       `json:"temperature"`
 	TopP             float32        `json:"top_p"`
 	Suffix           string         `json:"suffix"`
+	N                *int           `json:"n"`
 }
 
 type Completion struct {
@@ -227,7 +232,10 @@ func toolCallId() string {
 func toToolCalls(tc []api.ToolCall) []ToolCall {
 	toolCalls := make([]ToolCall, len(tc))
 	for i, tc := range tc {
-		toolCalls[i].ID = toolCallId()
+		toolCalls[i].ID = tc.ID
+		if toolCalls[i].ID == "" {
+			toolCalls[i].ID = toolCallId()
+		}
 		toolCalls[i].Type = "function"
 		toolCalls[i].Function.Name = tc.Function.Name
 		toolCalls[i].Index = tc.Function.Index
@@ -244,27 +252,39 @@ func toToolCalls(tc []api.ToolCall) []ToolCall {
 }
 
 func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
-	toolCalls := toToolCalls(r.Message.ToolCalls)
+	choices := []Choice{toChoice(api.ChatChoice{Message: r.Message, DoneReason: r.DoneReason})}
+	if len(r.Choices) > 0 {
+		choices = make([]Choice, len(r.Choices))
+		for i, c := range r.Choices {
+			choices[i] = toChoice(c)
+		}
+	}
+
 	return ChatCompletion{
 		Id:                id,
 		Object:            "chat.completion",
 		Created:           r.CreatedAt.Unix(),
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
-		Choices: []Choice{{
-			Index:   0,
-			Message: Message{Role: r.Message.Role, Content: r.Message.Content, ToolCalls: toolCalls},
-			FinishReason: func(reason string) *string {
-				if len(toolCalls) > 0 {
-					reason = "tool_calls"
-				}
-				if len(reason) > 0 {
-					return &reason
-				}
-				return nil
-			}(r.DoneReason),
-		}},
-		Usage: toUsage(r),
+		Choices:           choices,
+		Usage:             toUsage(r),
+	}
+}
+
+func toChoice(c api.ChatChoice) Choice {
+	toolCalls := toToolCalls(c.Message.ToolCalls)
+	return Choice{
+		Index:   c.Index,
+		Message: Message{Role: c.Message.Role, Content: c.Message.Content, ToolCalls: toolCalls},
+		FinishReason: func(reason string) *string {
+			if len(toolCalls) > 0 {
+				reason = "tool_calls"
+			}
+			if len(reason) > 0 {
+				return &reason
+			}
+			return nil
+		}(c.DoneReason),
 	}
 }
 
@@ -277,7 +297,7 @@ func toChunk(id string, r api.ChatResponse, toolCallSent bool) ChatCompletionChu
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
 		Choices: []ChunkChoice{{
-			Index: 0,
+			Index: r.Index,
 			Delta: Message{Role: "assistant", Content: r.Message.Content, ToolCalls: toolCalls},
 			FinishReason: func(reason string) *string {
 				if len(reason) > 0 {
@@ -301,23 +321,35 @@ func toUsageGenerate(r api.GenerateResponse) Usage {
 }
 
 func toCompletion(id string, r api.GenerateResponse) Completion {
+	choices := []CompleteChunkChoice{toCompleteChoice(api.GenerateChoice{Response: r.Response, DoneReason: r.DoneReason})}
+	if len(r.Choices) > 0 {
+		choices = make([]CompleteChunkChoice, len(r.Choices))
+		for i, c := range r.Choices {
+			choices[i] = toCompleteChoice(c)
+		}
+	}
+
 	return Completion{
 		Id:                id,
 		Object:            "text_completion",
 		Created:           r.CreatedAt.Unix(),
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
-		Choices: []CompleteChunkChoice{{
-			Text:  r.Response,
-			Index: 0,
-			FinishReason: func(reason string) *string {
-				if len(reason) > 0 {
-					return &reason
-				}
-				return nil
-			}(r.DoneReason),
-		}},
-		Usage: toUsageGenerate(r),
+		Choices:           choices,
+		Usage:             toUsageGenerate(r),
+	}
+}
+
+func toCompleteChoice(c api.GenerateChoice) CompleteChunkChoice {
+	return CompleteChunkChoice{
+		Text:  c.Response,
+		Index: c.Index,
+		FinishReason: func(reason string) *string {
+			if len(reason) > 0 {
+				return &reason
+			}
+			return nil
+		}(c.DoneReason),
 	}
 }
 
@@ -330,7 +362,7 @@ func toCompleteChunk(id string, r api.GenerateResponse) CompletionChunk {
 		SystemFingerprint: "fp_ollama",
 		Choices: []CompleteChunkChoice{{
 			Text:  r.Response,
-			Index: 0,
+			Index: r.Index,
 			FinishReason: func(reason string) *string {
 				if len(reason) > 0 {
 					return &reason
@@ -397,7 +429,7 @@ func fromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 	for _, msg := range r.Messages {
 		switch content := msg.Content.(type) {
 		case string:
-			messages = append(messages, api.Message{Role: msg.Role, Content: content})
+			messages = append(messages, api.Message{Role: msg.Role, Content: content, Name: msg.Name, ToolCallID: msg.ToolCallID})
 		case []any:
 			for _, c := range content {
 				data, ok := c.(map[string]any)
@@ -455,6 +487,7 @@ func fromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 
 			toolCalls := make([]api.ToolCall, len(msg.ToolCalls))
 			for i, tc := range msg.ToolCalls {
+				toolCalls[i].ID = tc.ID
 				toolCalls[i].Function.Name = tc.Function.Name
 				err := json.Unmarshal([]byte(tc.Function.Arguments), &toolCalls[i].Function.Arguments)
 				if err != nil {
@@ -521,6 +554,11 @@ func fromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 		}
 	}
 
+	var n int
+	if r.N != nil {
+		n = *r.N
+	}
+
 	return &api.ChatRequest{
 		Model:    r.Model,
 		Messages: messages,
@@ -528,6 +566,7 @@ func fromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 		Options:  options,
 		Stream:   &r.Stream,
 		Tools:    r.Tools,
+		N:        n,
 	}, nil
 }
 
@@ -573,12 +612,18 @@ func fromCompleteRequest(r CompletionRequest) (api.GenerateRequest, error) {
 		options["top_p"] = 1.0
 	}
 
+	var n int
+	if r.N != nil {
+		n = *r.N
+	}
+
 	return api.GenerateRequest{
 		Model:   r.Model,
 		Prompt:  r.Prompt,
 		Options: options,
 		Stream:  &r.Stream,
 		Suffix:  r.Suffix,
+		N:       n,
 	}, nil
 }
 
@@ -590,7 +635,8 @@ type ChatWriter struct {
 	stream        bool
 	streamOptions *StreamOptions
 	id            string
-	toolCallSent  bool
+	// toolCallSent are the choices which have streamed tool calls
+	toolCallSent map[int]bool
 	BaseWriter
 }
 
@@ -640,13 +686,16 @@ func (w *ChatWriter) writeResponse(data []byte) (int, error) {
 
 	// chat chunk
 	if w.stream {
-		c := toChunk(w.id, chatResponse, w.toolCallSent)
+		c := toChunk(w.id, chatResponse, w.toolCallSent[chatResponse.Index])
 		d, err := json.Marshal(c)
 		if err != nil {
 			return 0, err
 		}
-		if !w.toolCallSent && len(c.Choices) > 0 && len(c.Choices[0].Delta.ToolCalls) > 0 {
-			w.toolCallSent = true
+		if len(c.Choices) > 0 && len(c.Choices[0].Delta.ToolCalls) > 0 {
+			if w.toolCallSent == nil {
+				w.toolCallSent = make(map[int]bool)
+			}
+			w.toolCallSent[chatResponse.Index] = true
 		}
 
 		w.ResponseWriter.Header().Set("Content-Type", "text/event-stream")
@@ -932,7 +981,7 @@ func EmbeddingsMiddleware() gin.HandlerFunc {
 		}
 
 		var b bytes.Buffer
-		if err := json.NewEncoder(&b).Encode(api.EmbedRequest{Model: req.Model, Input: req.Input}); err != nil {
+		if err := json.NewEncoder(&b).Encode(api.EmbedRequest{Model: req.Model, Input: req.Input, Dimensions: req.Dimensions}); err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
 			return
 		}
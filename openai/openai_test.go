@@ -203,7 +203,8 @@ func TestChatMiddleware(t *testing.T) {
 				"model": "test-model",
 				"messages": [
 					{"role": "user", "content": "What's the weather like in Paris Today?"},
-					{"role": "assistant", "tool_calls": [{"id": "id", "type": "function", "function": {"name": "get_current_weather", "arguments": "{\"location\": \"Paris, France\", \"format\": \"celsius\"}"}}]}
+					{"role": "assistant", "tool_calls": [{"id": "id", "type": "function", "function": {"name": "get_current_weather", "arguments": "{\"location\": \"Paris, France\", \"format\": \"celsius\"}"}}]},
+					{"role": "tool", "name": "get_current_weather", "tool_call_id": "id", "content": "22"}
 				]
 			}`,
 			req: api.ChatRequest{
@@ -217,6 +218,7 @@ func TestChatMiddleware(t *testing.T) {
 						Role: "assistant",
 						ToolCalls: []api.ToolCall{
 							{
+								ID: "id",
 								Function: api.ToolCallFunction{
 									Name: "get_current_weather",
 									Arguments: map[string]interface{}{
@@ -227,6 +229,12 @@ func TestChatMiddleware(t *testing.T) {
 							},
 						},
 					},
+					{
+						Role:       "tool",
+						Content:    "22",
+						Name:       "get_current_weather",
+						ToolCallID: "id",
+					},
 				},
 				Options: map[string]any{
 					"temperature": 1.0,
@@ -451,6 +459,26 @@ func TestCompletionsMiddleware(t *testing.T) {
 				Stream: &True,
 			},
 		},
+		{
+			name: "completions handler with n",
+			body: `{
+				"model": "test-model",
+				"prompt": "Hello",
+				"n": 3
+			}`,
+			req: api.GenerateRequest{
+				Model:  "test-model",
+				Prompt: "Hello",
+				Options: map[string]any{
+					"frequency_penalty": 0.0,
+					"presence_penalty":  0.0,
+					"temperature":       1.0,
+					"top_p":             1.0,
+				},
+				Stream: &False,
+				N:      3,
+			},
+		},
 		{
 			name: "completions handler error forwarding",
 			body: `{
@@ -539,6 +567,19 @@ func TestEmbeddingsMiddleware(t *testing.T) {
 				Model: "test-model",
 			},
 		},
+		{
+			name: "embed handler dimensions",
+			body: `{
+				"input": "Hello",
+				"model": "test-model",
+				"dimensions": 256
+			}`,
+			req: api.EmbedRequest{
+				Input:      "Hello",
+				Model:      "test-model",
+				Dimensions: 256,
+			},
+		},
 		{
 			name: "embed handler error forwarding",
 			body: `{
@@ -0,0 +1,207 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	errIncludeCycle   = errors.New("INCLUDE cycle")
+	errInvalidArgName = errors.New("ARG name must start with a letter or underscore and contain only letters, numbers, and underscores")
+)
+
+var (
+	argName      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	argReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// Expand returns f with the Modelfiles it includes inlined and the build
+// args declared by its ARG commands substituted. dir is the directory of f,
+// which the paths of INCLUDE commands are relative to, and args override the
+// defaults of build args.
+//
+// An ARG command declares a build arg, optionally with a default, e.g.
+// ARG quant=q4_K_M, which is substituted for ${quant} in the commands after
+// it. References to names which aren't declared are left as they are.
+//
+// Commands after an INCLUDE command override those of the included Modelfile
+// as they would if it were written in its place, except that a FROM command
+// replaces those of the Modelfiles it includes.
+func (f Modelfile) Expand(dir string, args map[string]string) (*Modelfile, error) {
+	e := expander{
+		overrides: args,
+		values:    make(map[string]string),
+		declared:  make(map[string]bool),
+	}
+
+	cmds, err := e.expand(f, dir, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(args)) {
+		if !e.declared[name] {
+			return nil, fmt.Errorf("build arg %q isn't declared by an ARG command", name)
+		}
+	}
+
+	if !slices.ContainsFunc(cmds, func(c Command) bool { return c.Name == "model" }) {
+		return nil, errMissingFrom
+	}
+
+	return &Modelfile{Commands: cmds}, nil
+}
+
+type expander struct {
+	overrides map[string]string
+
+	// values are the values of the declared build args which have one
+	values   map[string]string
+	declared map[string]bool
+}
+
+// expand expands the commands of f, which is in dir and included by the
+// Modelfiles in stack.
+func (e *expander) expand(f Modelfile, dir string, stack []string) ([]Command, error) {
+	var cmds []Command
+
+	// own are the FROM commands of f itself rather than those it includes
+	own := make(map[int]bool)
+
+	for _, c := range f.Commands {
+		args, err := e.substitute(c.Args)
+		if err != nil {
+			return nil, err
+		}
+
+		switch c.Name {
+		case "arg":
+			if err := e.declare(args); err != nil {
+				return nil, err
+			}
+		case "include":
+			included, err := e.include(args, dir, stack)
+			if err != nil {
+				return nil, err
+			}
+
+			cmds = append(cmds, included...)
+		case "model":
+			if len(stack) > 0 {
+				// paths in included Modelfiles are relative to them
+				if p, err := expandPath(args, dir); err == nil {
+					if _, err := os.Stat(p); err == nil {
+						args = p
+					}
+				}
+			}
+
+			own[len(cmds)] = true
+			cmds = append(cmds, Command{Name: c.Name, Args: args})
+		case "adapter":
+			if len(stack) > 0 {
+				path, weight := args, ""
+				if p, _, ok := cutAdapterWeight(args); ok {
+					path, weight = p, args[len(p):]
+				}
+
+				p, err := expandPath(path, dir)
+				if err != nil {
+					return nil, err
+				}
+
+				args = p + weight
+			}
+
+			cmds = append(cmds, Command{Name: c.Name, Args: args})
+		default:
+			cmds = append(cmds, Command{Name: c.Name, Args: args})
+		}
+	}
+
+	if len(own) > 0 {
+		var i int
+		cmds = slices.DeleteFunc(cmds, func(c Command) bool {
+			defer func() { i++ }()
+			return c.Name == "model" && !own[i]
+		})
+	}
+
+	return cmds, nil
+}
+
+// declare declares the build arg of an ARG command with args name or
+// name=default.
+func (e *expander) declare(args string) error {
+	name, value, hasDefault := strings.Cut(args, "=")
+	name = strings.TrimSpace(name)
+	if !argName.MatchString(name) {
+		return fmt.Errorf("%w: %s", errInvalidArgName, name)
+	}
+
+	e.declared[name] = true
+	if override, ok := e.overrides[name]; ok {
+		e.values[name] = override
+	} else if hasDefault {
+		value, ok := unquote(strings.TrimSpace(value))
+		if !ok {
+			return fmt.Errorf("invalid default of ARG %s", name)
+		}
+
+		e.values[name] = value
+	}
+
+	return nil
+}
+
+// include expands the Modelfile at path, which is relative to dir.
+func (e *expander) include(path, dir string, stack []string) ([]Command, error) {
+	p, err := expandPath(path, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if i := slices.Index(stack, p); i >= 0 {
+		return nil, fmt.Errorf("%w: %s", errIncludeCycle, strings.Join(slices.Concat(stack[i:], []string{p}), " -> "))
+	}
+
+	r, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	f, err := parseFile(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return e.expand(*f, filepath.Dir(p), append(slices.Clip(stack), p))
+}
+
+// substitute substitutes the values of the declared build args referenced in
+// s.
+func (e *expander) substitute(s string) (string, error) {
+	var err error
+	s = argReference.ReplaceAllStringFunc(s, func(ref string) string {
+		name := argReference.FindStringSubmatch(ref)[1]
+		if !e.declared[name] {
+			return ref
+		}
+
+		value, ok := e.values[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("build arg %q has no value, set it with a default or a build arg", name)
+		}
+
+		return value
+	})
+
+	return s, err
+}
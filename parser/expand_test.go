@@ -0,0 +1,212 @@
+package parser
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// writeModelfiles writes the Modelfiles of files, relative to a temporary
+// directory, which is returned.
+func writeModelfiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func expandFile(t *testing.T, dir, name string, args map[string]string) (*Modelfile, error) {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	modelfile, err := ParseFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return modelfile.Expand(dir, args)
+}
+
+func TestExpand(t *testing.T) {
+	dir := writeModelfiles(t, map[string]string{
+		"base/Modelfile": `ARG quant=q4_K_M
+FROM llama3.2:3b-instruct-${quant}
+ADAPTER ./adapter.gguf 0.5
+TEMPLATE {{ .Prompt }}
+PARAMETER temperature 0.7
+PARAMETER stop <|eot_id|>
+LICENSE MIT`,
+		"base/params.Modelfile": `PARAMETER num_ctx 4096`,
+		"base/adapter.gguf":     "",
+		"Modelfile": `INCLUDE base/Modelfile
+INCLUDE ./base/params.Modelfile
+ARG system="You are ${name}."
+ARG name
+SYSTEM ${system} ${name}
+PARAMETER temperature 0.2
+PARAMETER stop ${other}`,
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		_, err := expandFile(t, dir, "Modelfile", nil)
+		if err == nil || err.Error() != `build arg "name" has no value, set it with a default or a build arg` {
+			t.Errorf("expected missing value error, got %v", err)
+		}
+	})
+
+	t.Run("build args", func(t *testing.T) {
+		modelfile, err := expandFile(t, dir, "Modelfile", map[string]string{"quant": "q8_0", "name": "Ollama"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// system is declared before name, which isn't substituted in its
+		// default
+		if diff := cmp.Diff([]Command{
+			{Name: "model", Args: "llama3.2:3b-instruct-q8_0"},
+			{Name: "adapter", Args: filepath.Join(dir, "base", "adapter.gguf") + " 0.5"},
+			{Name: "template", Args: "{{ .Prompt }}"},
+			{Name: "temperature", Args: "0.7"},
+			{Name: "stop", Args: "<|eot_id|>"},
+			{Name: "license", Args: "MIT"},
+			{Name: "num_ctx", Args: "4096"},
+			{Name: "system", Args: "You are ${name}. Ollama"},
+			{Name: "temperature", Args: "0.2"},
+			{Name: "stop", Args: "${other}"},
+		}, modelfile.Commands); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+
+		req, err := modelfile.CreateRequest(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if req.From != "llama3.2:3b-instruct-q8_0" || req.Parameters["temperature"] != float32(0.2) {
+			t.Errorf("expected the variant to override the base, got %s %v", req.From, req.Parameters)
+		}
+	})
+
+	t.Run("undeclared build arg", func(t *testing.T) {
+		_, err := expandFile(t, dir, "Modelfile", map[string]string{"name": "Ollama", "quantize": "q8_0"})
+		if err == nil || err.Error() != `build arg "quantize" isn't declared by an ARG command` {
+			t.Errorf("expected undeclared build arg error, got %v", err)
+		}
+	})
+}
+
+func TestExpandFrom(t *testing.T) {
+	dir := writeModelfiles(t, map[string]string{
+		"base/Modelfile":  "FROM ./model.gguf\nFROM ./missing\nSYSTEM base",
+		"base/model.gguf": "",
+		"Modelfile":       "INCLUDE base/Modelfile\nFROM llama3.2",
+		"inherit":         "INCLUDE base/Modelfile\nSYSTEM variant",
+	})
+
+	modelfile, err := expandFile(t, dir, "Modelfile", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]Command{
+		{Name: "system", Args: "base"},
+		{Name: "model", Args: "llama3.2"},
+	}, modelfile.Commands); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	// paths in the included Modelfile are relative to it, unless they don't
+	// exist and might be the name of a model
+	modelfile, err = expandFile(t, dir, "inherit", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]Command{
+		{Name: "model", Args: filepath.Join(dir, "base", "model.gguf")},
+		{Name: "model", Args: "./missing"},
+		{Name: "system", Args: "base"},
+		{Name: "system", Args: "variant"},
+	}, modelfile.Commands); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	dir := writeModelfiles(t, map[string]string{
+		"a":           "INCLUDE b",
+		"b":           "FROM foo\nINCLUDE c",
+		"c":           "INCLUDE b",
+		"no-from":     "INCLUDE params",
+		"params":      "PARAMETER temperature 1",
+		"bad-arg":     "FROM foo\nARG 1quant=q4_0",
+		"missing":     "INCLUDE nowhere",
+		"bad-include": "FROM foo\nINCLUDE bad-command",
+		"bad-command": `PARAMETER temperature 1
+BADCOMMAND value`,
+	})
+
+	cases := []struct {
+		name string
+		err  error
+		msg  string
+	}{
+		{"a", errIncludeCycle, "INCLUDE cycle: " + strings.Join([]string{filepath.Join(dir, "b"), filepath.Join(dir, "c"), filepath.Join(dir, "b")}, " -> ")},
+		{"no-from", errMissingFrom, ""},
+		{"bad-arg", errInvalidArgName, ""},
+		{"missing", os.ErrNotExist, ""},
+		{"bad-include", nil, "bad-command: (line 2): " + errInvalidCommand.Error()},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := expandFile(t, dir, tt.name, nil)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, err)
+			}
+
+			if tt.msg != "" && err.Error() != tt.msg {
+				t.Errorf("expected %q, got %q", tt.msg, err)
+			}
+		})
+	}
+}
+
+func TestCreateRequestUnexpanded(t *testing.T) {
+	modelfile, err := ParseFile(strings.NewReader("INCLUDE base\nFROM foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := modelfile.CreateRequest(""); err == nil {
+		t.Error("expected unexpanded INCLUDE to error")
+	}
+
+	if got := modelfile.String(); got != "INCLUDE base\nFROM foo\n" {
+		t.Errorf("unexpected Modelfile %q", got)
+	}
+}
@@ -44,11 +44,18 @@ func (f Modelfile) CreateRequest(relativeDir string) (*api.CreateRequest, error)
 
 	var messages []api.Message
 	var licenses []string
+	var adapters []api.MergeAdapter
+	var weighted bool
 	params := make(map[string]any)
 
 	for _, c := range f.Commands {
 		switch c.Name {
 		case "model":
+			if remote, ok := strings.CutPrefix(c.Args, "hf://"); ok {
+				req.Remote = remote
+				continue
+			}
+
 			path, err := expandPath(c.Args, relativeDir)
 			if err != nil {
 				return nil, err
@@ -70,7 +77,16 @@ func (f Modelfile) CreateRequest(relativeDir string) (*api.CreateRequest, error)
 				}
 			}
 		case "adapter":
-			path, err := expandPath(c.Args, relativeDir)
+			args, weight := c.Args, float32(1)
+			if p, w, ok := cutAdapterWeight(args); ok {
+				if w == 0 {
+					return nil, fmt.Errorf("adapter weight must not be 0: %s", args)
+				}
+
+				args, weight, weighted = p, w, true
+			}
+
+			path, err := expandPath(args, relativeDir)
 			if err != nil {
 				return nil, err
 			}
@@ -80,7 +96,7 @@ func (f Modelfile) CreateRequest(relativeDir string) (*api.CreateRequest, error)
 				return nil, err
 			}
 
-			req.Adapters = digestMap
+			adapters = append(adapters, api.MergeAdapter{Files: digestMap, Weight: weight})
 		case "template":
 			req.Template = c.Args
 		case "system":
@@ -90,6 +106,8 @@ func (f Modelfile) CreateRequest(relativeDir string) (*api.CreateRequest, error)
 		case "message":
 			role, msg, _ := strings.Cut(c.Args, ": ")
 			messages = append(messages, api.Message{Role: role, Content: msg})
+		case "include", "arg":
+			return nil, fmt.Errorf("%s isn't expanded, expand the Modelfile before creating a model", strings.ToUpper(c.Name))
 		default:
 			if slices.Contains(deprecatedParameters, c.Name) {
 				fmt.Printf("warning: parameter %s is deprecated\n", c.Name)
@@ -113,6 +131,14 @@ func (f Modelfile) CreateRequest(relativeDir string) (*api.CreateRequest, error)
 		}
 	}
 
+	// a single adapter is applied when the model is run while several, or
+	// any with a weight, are merged into its weights
+	if len(adapters) == 1 && !weighted {
+		req.Adapters = adapters[0].Files
+	} else if len(adapters) > 0 {
+		req.MergeAdapters = adapters
+	}
+
 	if len(params) > 0 {
 		req.Parameters = params
 	}
@@ -126,6 +152,22 @@ func (f Modelfile) CreateRequest(relativeDir string) (*api.CreateRequest, error)
 	return req, nil
 }
 
+// cutAdapterWeight cuts the weight of an ADAPTER command from the end of its
+// args, returning the path of the adapter and its weight, if it has one.
+func cutAdapterWeight(args string) (string, float32, bool) {
+	i := strings.LastIndexByte(args, ' ')
+	if i < 0 {
+		return args, 0, false
+	}
+
+	f, err := strconv.ParseFloat(args[i+1:], 32)
+	if err != nil {
+		return args, 0, false
+	}
+
+	return strings.TrimSpace(args[:i]), float32(f), true
+}
+
 func fileDigestMap(path string) (map[string]string, error) {
 	fl := make(map[string]string)
 
@@ -276,7 +318,7 @@ func (c Command) String() string {
 	switch c.Name {
 	case "model":
 		fmt.Fprintf(&sb, "FROM %s", c.Args)
-	case "license", "template", "system", "adapter":
+	case "license", "template", "system", "adapter", "include", "arg":
 		fmt.Fprintf(&sb, "%s %s", strings.ToUpper(c.Name), quote(c.Args))
 	case "message":
 		role, message, _ := strings.Cut(c.Args, ": ")
@@ -302,7 +344,7 @@ const (
 var (
 	errMissingFrom        = errors.New("no FROM line")
 	errInvalidMessageRole = errors.New("message role must be one of \"system\", \"user\", or \"assistant\"")
-	errInvalidCommand     = errors.New("command must be one of \"from\", \"license\", \"template\", \"system\", \"adapter\", \"parameter\", or \"message\"")
+	errInvalidCommand     = errors.New("command must be one of \"from\", \"license\", \"template\", \"system\", \"adapter\", \"parameter\", \"message\", \"include\", or \"arg\"")
 )
 
 type ParserError struct {
@@ -317,7 +359,25 @@ func (e *ParserError) Error() string {
 	return e.Msg
 }
 
+// ParseFile parses a Modelfile, which must have a FROM command unless it
+// includes another Modelfile. Its INCLUDE and ARG commands are kept and
+// expanded by [Modelfile.Expand].
 func ParseFile(r io.Reader) (*Modelfile, error) {
+	f, err := parseFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cmd := range f.Commands {
+		if cmd.Name == "model" || cmd.Name == "include" {
+			return f, nil
+		}
+	}
+
+	return nil, errMissingFrom
+}
+
+func parseFile(r io.Reader) (*Modelfile, error) {
 	var cmd Command
 	var curr state
 	var currLine int = 1
@@ -439,13 +499,7 @@ func ParseFile(r io.Reader) (*Modelfile, error) {
 		return nil, io.ErrUnexpectedEOF
 	}
 
-	for _, cmd := range f.Commands {
-		if cmd.Name == "model" {
-			return &f, nil
-		}
-	}
-
-	return nil, errMissingFrom
+	return &f, nil
 }
 
 func parseRuneForState(r rune, cs state) (state, rune, error) {
@@ -562,7 +616,7 @@ func isValidMessageRole(role string) bool {
 
 func isValidCommand(cmd string) bool {
 	switch strings.ToLower(cmd) {
-	case "from", "license", "template", "system", "adapter", "parameter", "message":
+	case "from", "license", "template", "system", "adapter", "parameter", "message", "include", "arg":
 		return true
 	default:
 		return false
@@ -688,6 +688,10 @@ func TestCreateRequest(t *testing.T) {
 			`FROM test`,
 			&api.CreateRequest{From: "test"},
 		},
+		{
+			`FROM hf://org/model@main`,
+			&api.CreateRequest{Remote: "org/model@main"},
+		},
 		{
 			`FROM test
 TEMPLATE some template
@@ -794,6 +798,7 @@ func createBinFile(t *testing.T, kv map[string]any, ti []ggml.Tensor) (string, s
 func TestCreateRequestFiles(t *testing.T) {
 	n1, d1 := createBinFile(t, nil, nil)
 	n2, d2 := createBinFile(t, map[string]any{"foo": "bar"}, nil)
+	n3, d3 := createBinFile(t, map[string]any{"foo": "baz"}, nil)
 
 	cases := []struct {
 		input    string
@@ -807,6 +812,27 @@ func TestCreateRequestFiles(t *testing.T) {
 			fmt.Sprintf("FROM %s\nFROM %s", n1, n2),
 			&api.CreateRequest{Files: map[string]string{n1: d1, n2: d2}},
 		},
+		{
+			fmt.Sprintf("FROM %s\nADAPTER %s", n1, n2),
+			&api.CreateRequest{Files: map[string]string{n1: d1}, Adapters: map[string]string{n2: d2}},
+		},
+		{
+			fmt.Sprintf("FROM %s\nADAPTER %s 0.5", n1, n2),
+			&api.CreateRequest{
+				Files:         map[string]string{n1: d1},
+				MergeAdapters: []api.MergeAdapter{{Files: map[string]string{n2: d2}, Weight: 0.5}},
+			},
+		},
+		{
+			fmt.Sprintf("FROM %s\nADAPTER %s\nADAPTER %s -1", n1, n2, n3),
+			&api.CreateRequest{
+				Files: map[string]string{n1: d1},
+				MergeAdapters: []api.MergeAdapter{
+					{Files: map[string]string{n2: d2}, Weight: 1},
+					{Files: map[string]string{n3: d3}, Weight: -1},
+				},
+			},
+		},
 	}
 
 	for _, c := range cases {
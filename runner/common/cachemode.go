@@ -0,0 +1,59 @@
+package common
+
+import "fmt"
+
+// CacheMode is how room is made in the context of a sequence when it fills
+// up. In every mode the inputs at the start of the sequence which are kept
+// (num_keep) stay and the inputs after them are discarded, oldest first, with
+// the rest shifted down to take their place.
+type CacheMode int
+
+const (
+	// CacheModeShift discards half of the inputs which aren't kept at once,
+	// so the context fills up rarely but loses much of its history each time.
+	CacheModeShift CacheMode = iota
+
+	// CacheModeStreaming keeps the inputs at the start as attention sinks
+	// and discards a few of the oldest inputs after them at a time, as in
+	// StreamingLLM, so the context is a sliding window of nearly all of the
+	// most recent inputs.
+	CacheModeStreaming
+)
+
+// streamingDiscardDivisor is the fraction of the inputs which aren't kept
+// that the streaming mode discards at a time. Shifting the rest costs about
+// as much as processing a batch, so discarding one input at a time would make
+// every step as slow as processing the prompt.
+const streamingDiscardDivisor = 16
+
+// ParseCacheMode returns the cache mode named s, which may be shift or
+// streaming. The default is shift.
+func ParseCacheMode(s string) (CacheMode, error) {
+	switch s {
+	case "", "shift":
+		return CacheModeShift, nil
+	case "streaming":
+		return CacheModeStreaming, nil
+	default:
+		return 0, fmt.Errorf("invalid cache_mode %q: must be shift or streaming", s)
+	}
+}
+
+func (m CacheMode) String() string {
+	switch m {
+	case CacheModeStreaming:
+		return "streaming"
+	default:
+		return "shift"
+	}
+}
+
+// TargetFree returns how many inputs should be free in a context of numCtx
+// inputs after making room in it, the first numKeep of which are kept.
+func (m CacheMode) TargetFree(numCtx, numKeep int) int {
+	if m == CacheModeStreaming {
+		return max((numCtx-numKeep)/streamingDiscardDivisor, 1)
+	}
+
+	return max((numCtx-numKeep)/2, 1)
+}
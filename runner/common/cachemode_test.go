@@ -0,0 +1,24 @@
+package common
+
+import "testing"
+
+func TestParseCacheMode(t *testing.T) {
+	cases := map[string]CacheMode{
+		"":          CacheModeShift,
+		"shift":     CacheModeShift,
+		"streaming": CacheModeStreaming,
+	}
+
+	for s, want := range cases {
+		got, err := ParseCacheMode(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+		} else if got != want {
+			t.Errorf("%q: want %v, got %v", s, want, got)
+		}
+	}
+
+	if _, err := ParseCacheMode("sliding"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
@@ -0,0 +1,102 @@
+package common
+
+import (
+	"math"
+	"strings"
+)
+
+// Healing constrains the first tokens of a response to complete the text of
+// the last token of its prompt, which is removed from the prompt so the
+// model isn't stuck with how the prompt happens to be tokenized where it
+// ends, such as in the middle of a word.
+type Healing struct {
+	// prefix is the text of the removed token which the response hasn't
+	// generated yet
+	prefix string
+
+	// piece returns the text of a token, or "" for tokens which can't be
+	// part of the text of a prompt, such as control tokens
+	piece func(token int) string
+
+	// tokens are the tokens which continue prefix, found when the response
+	// is sampled with a new prefix
+	tokens map[int]bool
+}
+
+// NewHealing returns the healing of a prompt whose last token, with the text
+// prefix, was removed.
+func NewHealing(prefix string, piece func(token int) string) *Healing {
+	return &Healing{prefix: prefix, piece: piece}
+}
+
+// Active returns whether the response hasn't completed the text of the
+// removed token yet.
+func (h *Healing) Active() bool {
+	return h != nil && h.prefix != ""
+}
+
+// Clone returns a copy of h for another response to the same prompt.
+func (h *Healing) Clone() *Healing {
+	if h == nil {
+		return nil
+	}
+
+	c := *h
+	return &c
+}
+
+// Mask sets the logits of the tokens which don't continue the text of the
+// removed token to negative infinity. If none of them do, the logits are
+// left as they are.
+func (h *Healing) Mask(logits []float32) {
+	if !h.Active() {
+		return
+	}
+
+	if h.tokens == nil {
+		h.tokens = make(map[int]bool)
+		for token := range logits {
+			if piece := h.piece(token); piece != "" && h.continues(piece) {
+				h.tokens[token] = true
+			}
+		}
+	}
+
+	if len(h.tokens) == 0 {
+		return
+	}
+
+	for token := range logits {
+		if !h.tokens[token] {
+			logits[token] = float32(math.Inf(-1))
+		}
+	}
+}
+
+// Accept records that piece was sampled and returns the part of it which
+// comes after the text of the removed token, as the rest was in the prompt.
+func (h *Healing) Accept(piece string) string {
+	if !h.Active() {
+		return piece
+	}
+
+	if rest, ok := strings.CutPrefix(piece, h.prefix); ok {
+		h.prefix, h.tokens = "", nil
+		return rest
+	}
+
+	if strings.HasPrefix(h.prefix, piece) {
+		h.prefix, h.tokens = h.prefix[len(piece):], nil
+		return ""
+	}
+
+	// the response diverged from the prompt, which masking prevents unless
+	// nothing could continue it
+	h.prefix, h.tokens = "", nil
+	return piece
+}
+
+// continues returns whether piece continues the text of the removed token
+func (h *Healing) continues(piece string) bool {
+	return strings.HasPrefix(piece, h.prefix) || strings.HasPrefix(h.prefix, piece)
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestHealing(t *testing.T) {
+	vocab := []string{"<|eot|>", "http", ":", "://", ":/", "/", "//", "www", "hello"}
+	h := NewHealing("://", func(token int) string {
+		if token == 0 {
+			return ""
+		}
+		return vocab[token]
+	})
+
+	allowed := func(logits []float32) []string {
+		var pieces []string
+		for token, logit := range logits {
+			if !math.IsInf(float64(logit), -1) {
+				pieces = append(pieces, vocab[token])
+			}
+		}
+		return pieces
+	}
+
+	logits := make([]float32, len(vocab))
+	h.Mask(logits)
+	if expect := []string{":", "://", ":/"}; !slices.Equal(allowed(logits), expect) {
+		t.Errorf("Mask: have %q; want %q", allowed(logits), expect)
+	}
+
+	fork := h.Clone()
+
+	if piece := h.Accept(":/"); piece != "" || !h.Active() {
+		t.Errorf("Accept(%q): have %q (active %v); want %q (active true)", ":/", piece, h.Active(), "")
+	}
+
+	logits = make([]float32, len(vocab))
+	h.Mask(logits)
+	if expect := []string{"/", "//"}; !slices.Equal(allowed(logits), expect) {
+		t.Errorf("Mask: have %q; want %q", allowed(logits), expect)
+	}
+
+	if piece := h.Accept("//"); piece != "/" || h.Active() {
+		t.Errorf("Accept(%q): have %q (active %v); want %q (active false)", "//", piece, h.Active(), "/")
+	}
+
+	logits = make([]float32, len(vocab))
+	h.Mask(logits)
+	if len(allowed(logits)) != len(vocab) {
+		t.Errorf("Mask: have %q; want every token once healed", allowed(logits))
+	}
+
+	if piece := fork.Accept("://"); piece != "" || fork.Active() {
+		t.Errorf("Accept(%q): have %q (active %v); want %q (active false)", "://", piece, fork.Active(), "")
+	}
+}
@@ -30,14 +30,18 @@ func ContainsStopSuffix(sequence string, stops []string) bool {
 // returning the partial pieces with stop removed, including truncating
 // the last piece if required (and signalling if this was the case)
 func TruncateStop(pieces []string, stop string) ([]string, bool) {
-	joined := strings.Join(pieces, "")
-
-	index := strings.Index(joined, stop)
+	index := strings.Index(strings.Join(pieces, ""), stop)
 	if index == -1 {
 		return pieces, false
 	}
 
-	joined = joined[:index]
+	return TruncateAt(pieces, index)
+}
+
+// TruncateAt truncates pieces to their first index bytes, signalling if the
+// last piece was truncated.
+func TruncateAt(pieces []string, index int) ([]string, bool) {
+	joined := strings.Join(pieces, "")[:index]
 
 	// Split truncated string back into pieces of original lengths
 	lengths := make([]int, len(pieces))
@@ -0,0 +1,263 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidStop is returned for stops which can't be used.
+var ErrInvalidStop = errors.New("invalid stop regexp")
+
+// Stops finds the stop sequences, stop regexps and stop tokens of a
+// completion in its response as it's generated. The response is matched
+// in the text which hasn't been returned yet, which is held back while it
+// might be the start of a stop so no part of a stop is ever returned.
+type Stops struct {
+	strings []string
+	regexps []*stopRegexp
+	tokens  []int
+
+	// prev is the last rune of the response which has been returned, or -1
+	// at its start, for the anchors of regexps
+	prev rune
+}
+
+// NewStops returns the stops of a completion. Regexps use the syntax of
+// [regexp] with ^ and $ matching at the start and end of lines, and must not
+// match an empty string.
+func NewStops(strs, regexps []string, tokens []int) (*Stops, error) {
+	s := Stops{tokens: tokens, prev: -1}
+	for _, str := range strs {
+		if str != "" {
+			s.strings = append(s.strings, str)
+		}
+	}
+
+	for _, expr := range regexps {
+		re, err := compileStopRegexp(expr)
+		if err != nil {
+			return nil, err
+		}
+
+		s.regexps = append(s.regexps, re)
+	}
+
+	return &s, nil
+}
+
+// Token returns whether token is a stop token.
+func (s *Stops) Token(token int) bool {
+	return slices.Contains(s.tokens, token)
+}
+
+// Find returns the start and end of the first stop in text, the response
+// since it was last returned.
+func (s *Stops) Find(text string) (start, end int, ok bool) {
+	start = -1
+	for _, str := range s.strings {
+		if i := strings.Index(text, str); i >= 0 && (start < 0 || i < start) {
+			start, end = i, i+len(str)
+		}
+	}
+
+	for _, re := range s.regexps {
+		if i, j, _ := re.find(text, s.prev); i >= 0 && (start < 0 || i < start) {
+			start, end = i, j
+		}
+	}
+
+	return start, end, start >= 0
+}
+
+// Partial returns whether the end of text might be the start of a stop,
+// so it must be held back until more of the response is generated.
+func (s *Stops) Partial(text string) bool {
+	if ContainsStopSuffix(text, s.strings) {
+		return true
+	}
+
+	for _, re := range s.regexps {
+		if _, _, partial := re.find(text, s.prev); partial {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returned records that text was returned, so stops are no longer matched
+// in it.
+func (s *Stops) Returned(text string) {
+	if r, size := utf8.DecodeLastRuneInString(text); size > 0 {
+		s.prev = r
+	}
+}
+
+// maxStopRegexpHold is the most text held back for a partial match of a
+// regexp, since patterns such as "a.*b" might otherwise hold back the whole
+// response.
+const maxStopRegexpHold = 256
+
+type stopRegexp struct {
+	prog *syntax.Prog
+}
+
+func compileStopRegexp(expr string) (*stopRegexp, error) {
+	re, err := syntax.Parse(expr, syntax.Perl&^syntax.OneLine)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidStop, err)
+	}
+
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidStop, err)
+	}
+
+	if matchesEmpty(prog, uint32(prog.Start), make([]bool, len(prog.Inst))) {
+		return nil, fmt.Errorf("%w %q: matches an empty string", ErrInvalidStop, expr)
+	}
+
+	return &stopRegexp{prog: prog}, nil
+}
+
+// matchesEmpty returns whether the program matches from pc without consuming
+// a rune, whatever the assertions on the way.
+func matchesEmpty(prog *syntax.Prog, pc uint32, visited []bool) bool {
+	if visited[pc] {
+		return false
+	}
+	visited[pc] = true
+
+	inst := &prog.Inst[pc]
+	switch inst.Op {
+	case syntax.InstMatch:
+		return true
+	case syntax.InstAlt, syntax.InstAltMatch:
+		return matchesEmpty(prog, inst.Out, visited) || matchesEmpty(prog, inst.Arg, visited)
+	case syntax.InstNop, syntax.InstCapture, syntax.InstEmptyWidth:
+		return matchesEmpty(prog, inst.Out, visited)
+	default:
+		return false
+	}
+}
+
+// emptyOpNext are the assertions which depend on the rune after them
+const emptyOpNext = syntax.EmptyEndLine | syntax.EmptyEndText | syntax.EmptyWordBoundary | syntax.EmptyNoWordBoundary
+
+type stopThread struct {
+	pc    uint32
+	start int
+}
+
+// find returns the start and end of the first match of the regexp in text,
+// which follows prev, preferring the match which ends first. If there's no
+// match, partial is whether a match might start in the end of text.
+func (re *stopRegexp) find(text string, prev rune) (start, end int, partial bool) {
+	var (
+		// threads are at instructions which consume the rune at pos and
+		// next are at the instructions after them
+		threads, next []stopThread
+		visited       = make([]int, len(re.prog.Inst))
+		pos           int
+		before        = prev
+		partialStart  = -1
+	)
+
+	// add adds the threads reachable from pc at pos without consuming a
+	// rune, returning whether one matches
+	var add func(pc uint32, start int) bool
+	add = func(pc uint32, start int) bool {
+		if visited[pc] == pos+1 {
+			return false
+		}
+		visited[pc] = pos + 1
+
+		inst := &re.prog.Inst[pc]
+		switch inst.Op {
+		case syntax.InstMatch:
+			return true
+		case syntax.InstFail:
+			return false
+		case syntax.InstAlt, syntax.InstAltMatch:
+			return add(inst.Out, start) || add(inst.Arg, start)
+		case syntax.InstNop, syntax.InstCapture:
+			return add(inst.Out, start)
+		case syntax.InstEmptyWidth:
+			op := syntax.EmptyOp(inst.Arg)
+			if pos == len(text) && op&emptyOpNext != 0 {
+				// the rest of the response decides the assertion
+				if partialStart < 0 || start < partialStart {
+					partialStart = start
+				}
+				return false
+			}
+
+			after := rune(-1)
+			if pos < len(text) {
+				after, _ = utf8.DecodeRuneInString(text[pos:])
+			}
+
+			if op&^syntax.EmptyOpContext(before, after) != 0 {
+				return false
+			}
+
+			return add(inst.Out, start)
+		default:
+			threads = append(threads, stopThread{pc, start})
+			return false
+		}
+	}
+
+	for {
+		threads = threads[:0]
+		for _, t := range next {
+			if add(t.pc, t.start) {
+				return t.start, pos, false
+			}
+		}
+
+		// matches which start later have a lower priority
+		if add(uint32(re.prog.Start), pos) {
+			return pos, pos, false
+		}
+
+		if pos == len(text) {
+			for _, t := range threads {
+				if partialStart < 0 || t.start < partialStart {
+					partialStart = t.start
+				}
+			}
+
+			return -1, -1, partialStart >= 0 && partialStart >= len(text)-maxStopRegexpHold
+		}
+
+		r, size := utf8.DecodeRuneInString(text[pos:])
+		next = next[:0]
+		for _, t := range threads {
+			inst := &re.prog.Inst[t.pc]
+
+			var ok bool
+			switch inst.Op {
+			case syntax.InstRune1:
+				ok = r == inst.Rune[0]
+			case syntax.InstRuneAny:
+				ok = true
+			case syntax.InstRuneAnyNotNL:
+				ok = r != '\n'
+			default:
+				ok = inst.MatchRune(r)
+			}
+
+			if ok {
+				next = append(next, stopThread{inst.Out, t.start})
+			}
+		}
+
+		pos += size
+		before = r
+	}
+}
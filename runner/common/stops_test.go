@@ -0,0 +1,135 @@
+package common
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// generate returns the response of pieces as a runner would, stopping at the
+// first stop and holding back text which might be the start of one.
+func generate(s *Stops, pieces []string) (response string, stopped bool) {
+	var pending []string
+	for _, piece := range pieces {
+		pending = append(pending, piece)
+		sequence := strings.Join(pending, "")
+
+		if start, _, ok := s.Find(sequence); ok {
+			pending, _ = TruncateAt(pending, start)
+			return response + strings.Join(pending, ""), true
+		}
+
+		if s.Partial(sequence) {
+			continue
+		}
+
+		s.Returned(sequence)
+		response += sequence
+		pending = nil
+	}
+
+	return response + strings.Join(pending, ""), false
+}
+
+func TestStops(t *testing.T) {
+	tests := []struct {
+		name     string
+		strings  []string
+		regexps  []string
+		pieces   []string
+		expected string
+		stopped  bool
+	}{
+		{
+			name:     "string across pieces",
+			strings:  []string{"</answer>"},
+			pieces:   []string{"4", "</ans", "wer", ">", "more"},
+			expected: "4",
+			stopped:  true,
+		},
+		{
+			name:     "earliest stop",
+			strings:  []string{"world"},
+			regexps:  []string{`l+o`},
+			pieces:   []string{"hello world"},
+			expected: "he",
+			stopped:  true,
+		},
+		{
+			name:     "regexp across pieces",
+			regexps:  []string{`\d+ apples`},
+			pieces:   []string{"I have 1", "2 app", "les and pears"},
+			expected: "I have ",
+			stopped:  true,
+		},
+		{
+			name:     "partial regexp returned",
+			regexps:  []string{`\d+ apples`},
+			pieces:   []string{"I have 1", "2 app", "roaches"},
+			expected: "I have 12 approaches",
+		},
+		{
+			name:     "line start",
+			regexps:  []string{`^User:`},
+			pieces:   []string{"Ask the User:", " done\n", "Use", "r: hi"},
+			expected: "Ask the User: done\n",
+			stopped:  true,
+		},
+		{
+			name:     "line start after returned text",
+			regexps:  []string{`^User:`},
+			pieces:   []string{"Hi", "User: hi"},
+			expected: "HiUser: hi",
+		},
+		{
+			name:     "line end",
+			regexps:  []string{`DONE$`},
+			pieces:   []string{"DONE", "NESS\n", "DONE", "\n"},
+			expected: "DONENESS\n",
+			stopped:  true,
+		},
+		{
+			name:     "long partial regexp",
+			regexps:  []string{`<a.*b>`},
+			pieces:   []string{"<a", strings.Repeat("x", maxStopRegexpHold), "b>"},
+			expected: "<a" + strings.Repeat("x", maxStopRegexpHold) + "b>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewStops(tt.strings, tt.regexps, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response, stopped := generate(s, tt.pieces)
+			if response != tt.expected || stopped != tt.stopped {
+				t.Errorf("generate(%q): have %q (%v); want %q (%v)", tt.pieces, response, stopped, tt.expected, tt.stopped)
+			}
+		})
+	}
+}
+
+func TestStopsToken(t *testing.T) {
+	s, err := NewStops(nil, nil, []int{128001, 128009})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.Token(128009) {
+		t.Error("expected 128009 to be a stop token")
+	}
+
+	if s.Token(13) {
+		t.Error("expected 13 not to be a stop token")
+	}
+}
+
+func TestStopsInvalid(t *testing.T) {
+	for _, expr := range []string{`(`, `a*`, `^`, `\b`} {
+		if _, err := NewStops(nil, []string{expr}, nil); !errors.Is(err, ErrInvalidStop) {
+			t.Errorf("NewStops(%q): have %v; want %v", expr, err, ErrInvalidStop)
+		}
+	}
+}
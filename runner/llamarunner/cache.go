@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"log/slog"
 	"reflect"
+	"slices"
 	"time"
 
 	"github.com/ollama/ollama/llama"
+	"github.com/ollama/ollama/runner/common"
 )
 
 type InputCache struct {
@@ -56,6 +58,9 @@ type InputCacheSlot struct {
 	// Inputs that are stored in the KV cache
 	Inputs []input
 
+	// paths of the LoRA adapters the inputs were processed with
+	Adapters []string
+
 	// is this cache actively being processed as part of a sequence?
 	InUse bool
 
@@ -63,7 +68,7 @@ type InputCacheSlot struct {
 	lastUsed time.Time
 }
 
-func (c *InputCache) LoadCacheSlot(prompt []input, cachePrompt bool) (*InputCacheSlot, []input, error) {
+func (c *InputCache) LoadCacheSlot(prompt []input, adapters []string, cachePrompt bool) (*InputCacheSlot, []input, error) {
 	var slot *InputCacheSlot
 	var numPast int
 	var err error
@@ -75,9 +80,9 @@ func (c *InputCache) LoadCacheSlot(prompt []input, cachePrompt bool) (*InputCach
 	// at the cost of worse performance when we miss the input cache (because it causes
 	// GPU L2 cache misses due to spreading out accesses across VRAM).
 	if !c.multiUserCache {
-		slot, numPast, err = c.findLongestCacheSlot(prompt)
+		slot, numPast, err = c.findLongestCacheSlot(prompt, adapters)
 	} else {
-		slot, numPast, err = c.findBestCacheSlot(prompt)
+		slot, numPast, err = c.findBestCacheSlot(prompt, adapters)
 	}
 	if err != nil {
 		return nil, nil, err
@@ -106,11 +111,12 @@ func (c *InputCache) LoadCacheSlot(prompt []input, cachePrompt bool) (*InputCach
 
 	prompt = prompt[numPast:]
 	slot.Inputs = slot.Inputs[:numPast]
+	slot.Adapters = adapters
 
 	return slot, prompt, nil
 }
 
-func (c *InputCache) findLongestCacheSlot(prompt []input) (*InputCacheSlot, int, error) {
+func (c *InputCache) findLongestCacheSlot(prompt []input, adapters []string) (*InputCacheSlot, int, error) {
 	longest := -1
 	var longestSlot *InputCacheSlot
 
@@ -119,7 +125,7 @@ func (c *InputCache) findLongestCacheSlot(prompt []input) (*InputCacheSlot, int,
 			continue
 		}
 
-		count := countCommonPrefix(s.Inputs, prompt)
+		count := s.commonPrefix(prompt, adapters)
 		if count > longest {
 			longest = count
 			longestSlot = &c.slots[i]
@@ -133,7 +139,7 @@ func (c *InputCache) findLongestCacheSlot(prompt []input) (*InputCacheSlot, int,
 	return longestSlot, longest, nil
 }
 
-func (c *InputCache) findBestCacheSlot(prompt []input) (*InputCacheSlot, int, error) {
+func (c *InputCache) findBestCacheSlot(prompt []input, adapters []string) (*InputCacheSlot, int, error) {
 	oldest := time.Now()
 	var oldestSlot *InputCacheSlot
 
@@ -141,7 +147,7 @@ func (c *InputCache) findBestCacheSlot(prompt []input) (*InputCacheSlot, int, er
 	var longestSlot *InputCacheSlot
 
 	for i, s := range c.slots {
-		count := countCommonPrefix(s.Inputs, prompt)
+		count := s.commonPrefix(prompt, adapters)
 		if count > longest {
 			longest = count
 			longestSlot = &c.slots[i]
@@ -171,6 +177,7 @@ func (c *InputCache) findBestCacheSlot(prompt []input) (*InputCacheSlot, int, er
 			len(longestSlot.Inputs))
 		oldestSlot.Inputs = make([]input, longest)
 		copy(oldestSlot.Inputs, longestSlot.Inputs[:longest])
+		oldestSlot.Adapters = longestSlot.Adapters
 		// This is only nil for unit tests
 		if c.lc != nil {
 			c.lc.KvCacheSeqRm(oldestSlot.Id, 0, -1)
@@ -181,6 +188,46 @@ func (c *InputCache) findBestCacheSlot(prompt []input) (*InputCacheSlot, int, er
 	return oldestSlot, longest, nil
 }
 
+// ForkCacheSlot returns an unused cache slot with the first n inputs of src,
+// copying them in the KV cache, for sequences which continue the same prompt.
+func (c *InputCache) ForkCacheSlot(src *InputCacheSlot, n int) (*InputCacheSlot, error) {
+	var slot *InputCacheSlot
+	for i := range c.slots {
+		if !c.slots[i].InUse && (slot == nil || c.slots[i].lastUsed.Before(slot.lastUsed)) {
+			slot = &c.slots[i]
+		}
+	}
+
+	if slot == nil {
+		return nil, errors.New("no available cache slots")
+	}
+
+	slog.Debug("forking cache slot", "src", src.Id, "dst", slot.Id, "inputs", n)
+
+	slot.InUse = true
+	slot.lastUsed = time.Now()
+	slot.Inputs = slices.Clone(src.Inputs[:n])
+	slot.Adapters = src.Adapters
+
+	// This is only nil for unit tests
+	if c.lc != nil {
+		c.lc.KvCacheSeqRm(slot.Id, 0, -1)
+		c.lc.KvCacheSeqCp(src.Id, slot.Id, 0, n)
+	}
+
+	return slot, nil
+}
+
+// commonPrefix returns the number of inputs of the prompt which are cached
+// in the slot. Inputs processed with other adapters aren't reusable.
+func (s InputCacheSlot) commonPrefix(prompt []input, adapters []string) int {
+	if !slices.Equal(s.Adapters, adapters) {
+		return 0
+	}
+
+	return countCommonPrefix(s.Inputs, prompt)
+}
+
 func countCommonPrefix(a []input, b []input) int {
 	var count int
 
@@ -199,9 +246,8 @@ func countCommonPrefix(a []input, b []input) int {
 	return count
 }
 
-func (c *InputCache) ShiftDiscard(inputLen int, numKeep int) int {
-	targetFree := (c.numCtx - numKeep) / 2
-	targetFree = max(targetFree, 1)
+func (c *InputCache) ShiftDiscard(inputLen int, numKeep int, mode common.CacheMode) int {
+	targetFree := mode.TargetFree(c.numCtx, numKeep)
 
 	currentFree := c.numCtx - inputLen
 	discard := targetFree - currentFree
@@ -213,23 +259,24 @@ func (c *InputCache) ShiftDiscard(inputLen int, numKeep int) int {
 	return discard
 }
 
-// Frees up space in the KV cache by deleting the oldest half of history and shifting
-// the newest half into that space (saving numKeep inputs at the beginning).
+// Frees up space in the KV cache by deleting the oldest history and shifting the
+// newest into that space (saving numKeep inputs at the beginning). How much is
+// deleted depends on mode: half of the history, or a little for streaming.
 //
 // Assumes that at least 1 entry can be freed up by shifting (i.e. numKeep < numCtx)
-func (c *InputCache) ShiftCacheSlot(slot *InputCacheSlot, numKeep int) error {
+func (c *InputCache) ShiftCacheSlot(slot *InputCacheSlot, numKeep int, mode common.CacheMode) error {
 	if numKeep >= c.numCtx {
 		return fmt.Errorf("unable to shift context - keep exceeds context (keep: %v context: %v)", numKeep, c.numCtx)
 	}
 
-	discard := c.ShiftDiscard(len(slot.Inputs), numKeep)
+	discard := c.ShiftDiscard(len(slot.Inputs), numKeep, mode)
 
 	if discard <= 0 {
 		return nil
 	}
 
 	slog.Debug("context limit hit - shifting", "id", slot.Id, "limit", c.numCtx, "input", len(slot.Inputs),
-		"keep", numKeep, "discard", discard)
+		"keep", numKeep, "discard", discard, "mode", mode)
 
 	// TODO (jessegross): KV cache removal can fail for certain types of models
 	if !c.lc.KvCacheSeqRm(slot.Id, numKeep, numKeep+discard) {
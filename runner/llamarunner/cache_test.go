@@ -1,8 +1,11 @@
 package llamarunner
 
 import (
+	"reflect"
 	"testing"
 	"time"
+
+	"github.com/ollama/ollama/runner/common"
 )
 
 func TestCountCommon(t *testing.T) {
@@ -73,11 +76,12 @@ func TestFindCacheSlot(t *testing.T) {
 	}
 
 	tests := []struct {
-		name    string
-		cache   InputCache
-		prompt  []input
-		longest expected
-		best    expected
+		name     string
+		cache    InputCache
+		prompt   []input
+		adapters []string
+		longest  expected
+		best     expected
 	}{
 		{
 			name: "Empty",
@@ -201,11 +205,33 @@ func TestFindCacheSlot(t *testing.T) {
 			longest: expected{result: 1, len: 1},
 			best:    expected{result: 1, len: 2},
 		},
+		{
+			name: "Adapters",
+			cache: InputCache{slots: []InputCacheSlot{
+				{
+					Id:       0,
+					Inputs:   []input{{token: 1}, {token: 2}},
+					InUse:    false,
+					lastUsed: time.Now().Add(-time.Second),
+				},
+				{
+					Id:       1,
+					Inputs:   []input{{token: 1}},
+					Adapters: []string{"lora"},
+					InUse:    false,
+					lastUsed: time.Now().Add(-2 * time.Second),
+				},
+			}},
+			prompt:   []input{{token: 1}, {token: 2}},
+			adapters: []string{"lora"},
+			longest:  expected{result: 1, len: 1},
+			best:     expected{result: 1, len: 1},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run("Longest-"+tt.name, func(t *testing.T) {
-			result, resultLen, err := tt.cache.findLongestCacheSlot(tt.prompt)
+			result, resultLen, err := tt.cache.findLongestCacheSlot(tt.prompt, tt.adapters)
 			if err != nil {
 				t.Errorf("findLongestCacheSlot: err %v", err)
 			} else if result.Id != tt.longest.result || resultLen != tt.longest.len {
@@ -217,7 +243,7 @@ func TestFindCacheSlot(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run("Best-"+tt.name, func(t *testing.T) {
-			result, resultLen, err := tt.cache.findBestCacheSlot(tt.prompt)
+			result, resultLen, err := tt.cache.findBestCacheSlot(tt.prompt, tt.adapters)
 			if err != nil {
 				t.Errorf("findBestCacheSlot: err %v", err)
 			} else if result.Id != tt.best.result || resultLen != tt.best.len {
@@ -234,6 +260,7 @@ func TestShiftDiscard(t *testing.T) {
 		numCtx   int
 		numKeep  int
 		inputLen int
+		mode     common.CacheMode
 		expected int
 	}{
 		{
@@ -271,6 +298,30 @@ func TestShiftDiscard(t *testing.T) {
 			inputLen: 5000,
 			expected: 2953,
 		},
+		{
+			name:     "Streaming",
+			numCtx:   2048,
+			numKeep:  4,
+			inputLen: 2048,
+			mode:     common.CacheModeStreaming,
+			expected: 127,
+		},
+		{
+			name:     "Streaming Truncate",
+			numCtx:   2048,
+			numKeep:  4,
+			inputLen: 5000,
+			mode:     common.CacheModeStreaming,
+			expected: 3079,
+		},
+		{
+			name:     "Streaming Max Keep",
+			numCtx:   2048,
+			numKeep:  2047,
+			inputLen: 2048,
+			mode:     common.CacheModeStreaming,
+			expected: 1,
+		},
 		{
 			name:     "No Op",
 			numCtx:   2048,
@@ -283,10 +334,35 @@ func TestShiftDiscard(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := InputCache{numCtx: tt.numCtx}
-			result := c.ShiftDiscard(tt.inputLen, tt.numKeep)
+			result := c.ShiftDiscard(tt.inputLen, tt.numKeep, tt.mode)
 			if result != tt.expected {
 				t.Errorf("shiftDiscard(ctx: %v, keep: %v input: %v): have %v; want %v", tt.numCtx, tt.numKeep, tt.inputLen, result, tt.expected)
 			}
 		})
 	}
 }
+
+func TestForkCacheSlot(t *testing.T) {
+	c := InputCache{slots: []InputCacheSlot{
+		{Id: 0, Inputs: []input{{token: 1}, {token: 2}, {token: 3}}, InUse: true, lastUsed: time.Now()},
+		{Id: 1, Inputs: []input{{token: 4}}, lastUsed: time.Now().Add(-time.Second)},
+		{Id: 2, Inputs: []input{{token: 5}}, lastUsed: time.Now().Add(-2 * time.Second)},
+	}}
+
+	slot, err := c.ForkCacheSlot(&c.slots[0], 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if slot.Id != 2 || !slot.InUse || !reflect.DeepEqual(slot.Inputs, []input{{token: 1}, {token: 2}}) {
+		t.Errorf("ForkCacheSlot: have slot %d (%v) with %v; want slot 2 in use with the first 2 inputs", slot.Id, slot.InUse, slot.Inputs)
+	}
+
+	if _, err := c.ForkCacheSlot(&c.slots[0], 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.ForkCacheSlot(&c.slots[0], 2); err == nil {
+		t.Error("ForkCacheSlot: expected an error without an unused slot")
+	}
+}
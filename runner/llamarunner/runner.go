@@ -14,6 +14,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -56,6 +57,9 @@ type Sequence struct {
 	// input cache being used by this sequence
 	cache *InputCacheSlot
 
+	// paths of the LoRA adapters applied to this sequence
+	adapters []string
+
 	// does this sequence require cross-attention layers to be processed? - if we have seen
 	// an image for certain multi-modal models
 	crossAttention bool
@@ -74,17 +78,36 @@ type Sequence struct {
 	// channel to send back the embedding if embedding only
 	embedding chan []float32
 
-	// stop sequences
-	stop []string
+	// stop sequences, regexps and tokens
+	stops *common.Stops
+
+	// forks are the other completions of the request, which start from
+	// the inputs of this sequence in the cache once its prompt is processed
+	forks []*Sequence
+
+	// healing constrains the first tokens to complete the last token of
+	// the prompt, which was removed, when token healing is enabled
+	healing *common.Healing
+
+	// renderSpecial is whether the text of special tokens which are
+	// generated is returned
+	renderSpecial bool
 
 	// number of inputs to keep at the beginning when shifting context window
 	numKeep int
 
+	// cacheMode is how the context window is shifted when it fills up
+	cacheMode common.CacheMode
+
 	// true if an embedding are to be returned instead of text generation
 	embeddingOnly bool
 
 	doneReason string
 
+	// deadline is when generation stops with what has been generated so
+	// far, if it isn't zero
+	deadline time.Time
+
 	// Metrics
 	startProcessingTime time.Time
 	startGenerationTime time.Time
@@ -94,10 +117,15 @@ type Sequence struct {
 
 type NewSequenceParams struct {
 	numPredict     int
-	stop           []string
+	stops          *common.Stops
 	numKeep        int
+	cacheMode      common.CacheMode
 	samplingParams *llama.SamplingParams
 	embedding      bool
+	adapters       []string
+	tokenHealing   bool
+	renderSpecial  bool
+	deadline       time.Time
 }
 
 func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSequenceParams) (*Sequence, error) {
@@ -112,6 +140,17 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 		return nil, errors.New("no input provided")
 	}
 
+	for _, adapter := range params.adapters {
+		if s.loras[adapter] == nil {
+			return nil, fmt.Errorf("adapter %s isn't loaded", adapter)
+		}
+	}
+
+	var healing *common.Healing
+	if params.tokenHealing {
+		healing, inputs = s.heal(inputs)
+	}
+
 	if params.numKeep < 0 {
 		params.numKeep = len(inputs)
 	}
@@ -132,6 +171,60 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 		inputs = newInputs
 	}
 
+	seq, err := s.newSequence(inputs, startTime, params)
+	if err != nil {
+		return nil, err
+	}
+
+	seq.healing = healing
+	return seq, nil
+}
+
+// heal removes the last input of a prompt if it's text, returning the healing
+// of the response which completes it
+func (s *Server) heal(inputs []input) (*common.Healing, []input) {
+	if len(inputs) < 2 {
+		return nil, inputs
+	}
+
+	last := inputs[len(inputs)-1]
+	if last.embed != nil || s.model.TokenIsControl(last.token) {
+		return nil, inputs
+	}
+
+	piece := s.model.TokenToPiece(last.token)
+	if piece == "" {
+		return nil, inputs
+	}
+
+	return common.NewHealing(piece, func(token int) string {
+		if s.model.TokenIsControl(token) {
+			return ""
+		}
+
+		return s.model.TokenToPiece(token)
+	}), inputs[:len(inputs)-1]
+}
+
+// fork returns a sequence for another completion of the prompt of seq, which
+// is started by startForks once seq has processed it.
+func (s *Server) fork(seq *Sequence, params NewSequenceParams) (*Sequence, error) {
+	params.numKeep = seq.numKeep
+	params.cacheMode = seq.cacheMode
+	fork, err := s.newSequence(seq.inputs, seq.startProcessingTime, params)
+	if err != nil {
+		return nil, err
+	}
+
+	// the prompt is only processed by seq
+	fork.inputs = nil
+	fork.numPromptInputs = 0
+	fork.healing = seq.healing.Clone()
+	return fork, nil
+}
+
+func (s *Server) newSequence(inputs []input, startTime time.Time, params NewSequenceParams) (*Sequence, error) {
+	var err error
 	var sc *llama.SamplingContext
 	if params.samplingParams != nil {
 		sc, err = llama.NewSamplingContext(s.model, *params.samplingParams)
@@ -156,8 +249,12 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 		embedding:           make(chan []float32, 1),
 		samplingCtx:         sc,
 		embeddingOnly:       params.embedding,
-		stop:                params.stop,
+		stops:               params.stops,
 		numKeep:             params.numKeep,
+		cacheMode:           params.cacheMode,
+		adapters:            params.adapters,
+		renderSpecial:       params.renderSpecial,
+		deadline:            params.deadline,
 	}, nil
 }
 
@@ -229,6 +326,10 @@ type Server struct {
 	// image model context for multi-modal models
 	image *ImageContext
 
+	// LoRA adapters loaded with the model by path, which each sequence
+	// chooses between
+	loras map[string]*llama.LoraAdapter
+
 	// status for external health reporting - loading, ready to serve, etc.
 	status llm.ServerStatus
 
@@ -242,6 +343,9 @@ type Server struct {
 	// TODO (jmorganca): make this n_batch
 	batchSize int
 
+	// number of threads the model is run with
+	threads int
+
 	// protects access to everything below this line
 	// this is context state needed for decoding
 	mu sync.Mutex
@@ -252,6 +356,9 @@ type Server struct {
 	// decoding state
 	lc *llama.Context
 
+	// paths of the LoRA adapters applied by lc
+	adapters []string
+
 	// the list of simultaneous sequences being evaluated
 	seqs []*Sequence
 
@@ -293,6 +400,8 @@ func flushPending(seq *Sequence) bool {
 		return true
 	}
 
+	seq.stops.Returned(joined)
+
 	select {
 	case seq.responses <- joined:
 		return true
@@ -311,6 +420,38 @@ func (s *Server) removeSequence(seqIndex int, reason string) {
 	seq.cache.InUse = false
 	s.seqs[seqIndex] = nil
 	s.seqsSem.Release(1)
+
+	// forks which haven't started end with seq
+	for _, fork := range seq.forks {
+		fork.doneReason = reason
+		fork.startGenerationTime = time.Now()
+		close(fork.responses)
+		close(fork.embedding)
+		s.seqsSem.Release(1)
+	}
+	seq.forks = nil
+}
+
+// startForks starts the forks of seq from the inputs of seq in the cache,
+// leaving the last to be processed again to sample from, and returns them to
+// be added to s.seqs.
+func (s *Server) startForks(seq *Sequence) ([]*Sequence, error) {
+	for _, fork := range seq.forks {
+		last := len(seq.cache.Inputs) - 1
+
+		var err error
+		fork.cache, err = s.cache.ForkCacheSlot(seq.cache, last)
+		if err != nil {
+			return nil, err
+		}
+
+		fork.inputs = []input{seq.cache.Inputs[last]}
+		fork.crossAttention = seq.crossAttention
+	}
+
+	forks := seq.forks
+	seq.forks = nil
+	return forks, nil
 }
 
 func (s *Server) run(ctx context.Context) {
@@ -369,6 +510,10 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 	var batch *llama.Batch
 	crossAttention := false
 
+	// adapters apply to the whole batch so only sequences with the same
+	// adapters are decoded together
+	var adapters []string
+
 	seqIdx := s.nextSeq - 1
 	for range s.seqs {
 		seqIdx = (seqIdx + 1) % len(s.seqs)
@@ -378,6 +523,19 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			continue
 		}
 
+		// the client went away, so don't spend more batches on it
+		select {
+		case <-seq.quit:
+			s.removeSequence(seqIdx, "connection")
+			continue
+		default:
+		}
+
+		if !seq.deadline.IsZero() && time.Now().After(seq.deadline) {
+			s.removeSequence(seqIdx, "timeout")
+			continue
+		}
+
 		// if past the num predict limit
 		if seq.numPredict > 0 && seq.numPredicted >= seq.numPredict {
 			s.removeSequence(seqIdx, "limit")
@@ -387,7 +545,7 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 		for i, input := range seq.inputs {
 			if len(seq.cache.Inputs)+len(seq.pendingInputs)+1 > s.cache.numCtx {
 				if len(seq.pendingInputs) == 0 {
-					err := s.cache.ShiftCacheSlot(seq.cache, seq.numKeep)
+					err := s.cache.ShiftCacheSlot(seq.cache, seq.numKeep, seq.cacheMode)
 					if err != nil {
 						return err
 					}
@@ -409,7 +567,8 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 					batch = embedBatch
 					seq.crossAttention = s.image.NeedCrossAttention(input)
 				}
-			} else if embedding != batch.IsEmbedding() || crossAttention != seq.crossAttention {
+				adapters = seq.adapters
+			} else if embedding != batch.IsEmbedding() || crossAttention != seq.crossAttention || !slices.Equal(adapters, seq.adapters) {
 				s.nextSeq = seqIdx
 				break
 			}
@@ -433,6 +592,19 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 
 	s.lc.SetCrossAttention(crossAttention)
 
+	if !slices.Equal(adapters, s.adapters) {
+		loras := make([]*llama.LoraAdapter, len(adapters))
+		for i, adapter := range adapters {
+			loras[i] = s.loras[adapter]
+		}
+
+		if err := s.lc.SetLoraAdapters(loras, 1.0); err != nil {
+			return err
+		}
+
+		s.adapters = adapters
+	}
+
 	err := s.lc.Decode(batch)
 	if err != nil {
 		return fmt.Errorf("failed to decode batch: %w", err)
@@ -445,6 +617,7 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 		s.lc.Synchronize()
 	}
 
+	var forks []*Sequence
 	for i, seq := range s.seqs {
 		if seq == nil {
 			continue
@@ -464,6 +637,12 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 		seq.numDecoded += 1
 		if seq.numDecoded == 1 {
 			seq.startGenerationTime = time.Now()
+
+			started, err := s.startForks(seq)
+			if err != nil {
+				return err
+			}
+			forks = append(forks, started...)
 		}
 
 		// if done processing the prompt, generate an embedding and return
@@ -479,9 +658,18 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 		}
 
 		// sample a token
+		if seq.healing.Active() {
+			seq.healing.Mask(s.lc.GetLogitsIth(seq.iBatch))
+		}
+
 		token := seq.samplingCtx.Sample(s.lc, seq.iBatch)
 		seq.samplingCtx.Accept(token, true)
-		piece := s.model.TokenToPiece(token)
+		var piece string
+		if seq.renderSpecial || !s.model.TokenIsControl(token) {
+			piece = s.model.TokenToPiece(token)
+		}
+
+		piece = seq.healing.Accept(piece)
 
 		seq.numPredicted++
 
@@ -495,17 +683,22 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			continue
 		}
 
+		if seq.stops.Token(int(token)) {
+			s.removeSequence(i, "stop")
+			continue
+		}
+
 		seq.inputs = []input{{token: token}}
 
 		seq.pendingResponses = append(seq.pendingResponses, piece)
 		sequence := strings.Join(seq.pendingResponses, "")
 
-		if ok, stop := common.FindStop(sequence, seq.stop); ok {
-			slog.Debug("hit stop token", "pending", seq.pendingResponses, "stop", stop)
+		if start, end, ok := seq.stops.Find(sequence); ok {
+			slog.Debug("hit stop token", "pending", seq.pendingResponses, "stop", sequence[start:end])
 
 			var tokenTruncated bool
 			origLen := len(seq.pendingResponses)
-			seq.pendingResponses, tokenTruncated = common.TruncateStop(seq.pendingResponses, stop)
+			seq.pendingResponses, tokenTruncated = common.TruncateAt(seq.pendingResponses, start)
 			newLen := len(seq.pendingResponses)
 
 			// Update the cache based on the tokens that will be returned:
@@ -526,7 +719,7 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			continue
 		}
 
-		if common.ContainsStopSuffix(sequence, seq.stop) {
+		if seq.stops.Partial(sequence) {
 			continue
 		}
 
@@ -539,6 +732,12 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 		}
 	}
 
+	// forks are only added once the sequences in the batch are sampled, as
+	// they weren't part of it. The completion reserved places for them.
+	for _, fork := range forks {
+		s.seqs[slices.Index(s.seqs, nil)] = fork
+	}
+
 	return nil
 }
 
@@ -578,24 +777,71 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		Mirostat:       req.Options.Mirostat,
 		MirostatTau:    req.Options.MirostatTau,
 		MirostatEta:    req.Options.MirostatEta,
+		DryMultiplier:  req.Options.DryMultiplier,
+		DryBase:        req.Options.DryBase,
+		DryAllowedLen:  req.Options.DryAllowedLength,
+		DryLastN:       req.Options.DryPenaltyLastN,
+		DryBreakers:    req.Options.DryBreakers,
+		XTCProbability: req.Options.XTCProbability,
+		XTCThreshold:   req.Options.XTCThreshold,
 		Seed:           uint32(req.Options.Seed),
 		Grammar:        req.Grammar,
 	}
 
-	seq, err := s.NewSequence(req.Prompt, req.Images, NewSequenceParams{
-		numPredict:     req.Options.NumPredict,
-		stop:           req.Options.Stop,
-		numKeep:        req.Options.NumKeep,
-		samplingParams: &samplingParams,
-		embedding:      false,
-	})
+	n := max(req.N, 1)
+	if n > len(s.seqs) {
+		http.Error(w, fmt.Sprintf("n (%d) is more than the number of parallel requests (%d)", n, len(s.seqs)), http.StatusBadRequest)
+		return
+	}
+
+	cacheMode, err := common.ParseCacheMode(req.Options.CacheMode)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Ensure there is a place to put the sequence, released when removed from s.seqs
-	if err := s.seqsSem.Acquire(r.Context(), 1); err != nil {
+	seqs := make([]*Sequence, n)
+	for i := range seqs {
+		stops, err := common.NewStops(req.Options.Stop, req.Options.StopRegex, req.Options.StopTokens)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		params := NewSequenceParams{
+			numPredict:     req.Options.NumPredict,
+			stops:          stops,
+			numKeep:        req.Options.NumKeep,
+			cacheMode:      cacheMode,
+			samplingParams: &samplingParams,
+			embedding:      false,
+			adapters:       req.Adapters,
+			tokenHealing:   req.Options.TokenHealing,
+			renderSpecial:  req.Options.RenderSpecial == nil || *req.Options.RenderSpecial,
+			deadline:       req.Deadline,
+		}
+
+		if i == 0 {
+			seqs[i], err = s.NewSequence(req.Prompt, req.Images, params)
+		} else {
+			// each completion samples differently from the same seed
+			if req.Options.Seed != -1 {
+				samplingParams.Seed++
+			}
+
+			seqs[i], err = s.fork(seqs[0], params)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	seq := seqs[0]
+	seq.forks = seqs[1:]
+
+	// Ensure there is a place to put the sequences, released when removed from s.seqs
+	if err := s.seqsSem.Acquire(r.Context(), int64(n)); err != nil {
 		if errors.Is(err, context.Canceled) {
 			slog.Info("aborting completion request due to client closing the connection")
 		} else {
@@ -608,9 +854,11 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	found := false
 	for i, sq := range s.seqs {
 		if sq == nil {
-			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs, true)
+			var err error
+			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs, seq.adapters, true)
 			if err != nil {
 				s.mu.Unlock()
+				s.seqsSem.Release(int64(n))
 				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
 				return
 			}
@@ -630,41 +878,82 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for {
+	type choice struct {
+		index   int
+		content string
+		done    bool
+	}
+
+	// merge the responses of the completions in the order they're generated
+	choices := make(chan choice)
+	for i, seq := range seqs {
+		go func() {
+			for content := range seq.responses {
+				select {
+				case choices <- choice{index: i, content: content}:
+				case <-r.Context().Done():
+					return
+				}
+			}
+
+			select {
+			case choices <- choice{index: i, done: true}:
+			case <-r.Context().Done():
+			}
+		}()
+	}
+
+	quit := func() {
+		for _, seq := range seqs {
+			close(seq.quit)
+		}
+	}
+
+	for done := 0; done < n; {
 		select {
 		case <-r.Context().Done():
-			close(seq.quit)
+			quit()
 			return
-		case content, ok := <-seq.responses:
-			if ok {
+		case c := <-choices:
+			if !c.done {
 				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
-					Content: content,
+					Index:   c.index,
+					Content: c.content,
 				}); err != nil {
 					http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
-					close(seq.quit)
+					quit()
 					return
 				}
 
 				flusher.Flush()
-			} else {
-				// Send the final response
-				doneReason := "stop"
-				if seq.doneReason == "limit" {
-					doneReason = "length"
-				}
-				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
-					Done:               true,
-					DoneReason:         doneReason,
-					PromptEvalCount:    seq.numPromptInputs,
-					PromptEvalDuration: seq.startGenerationTime.Sub(seq.startProcessingTime),
-					EvalCount:          seq.numDecoded,
-					EvalDuration:       time.Since(seq.startGenerationTime),
-				}); err != nil {
-					http.Error(w, fmt.Sprintf("failed to encode final response: %v", err), http.StatusInternalServerError)
-				}
+				continue
+			}
 
+			// Send the final response of the completion
+			seq := seqs[c.index]
+			doneReason := "stop"
+			switch seq.doneReason {
+			case "limit":
+				doneReason = "length"
+			case "timeout":
+				doneReason = "timeout"
+			}
+			if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
+				Index:              c.index,
+				Done:               true,
+				DoneReason:         doneReason,
+				PromptEvalCount:    seq.numPromptInputs,
+				PromptEvalDuration: seq.startGenerationTime.Sub(seq.startProcessingTime),
+				EvalCount:          seq.numDecoded,
+				EvalDuration:       time.Since(seq.startGenerationTime),
+			}); err != nil {
+				http.Error(w, fmt.Sprintf("failed to encode final response: %v", err), http.StatusInternalServerError)
+				quit()
 				return
 			}
+
+			flusher.Flush()
+			done++
 		}
 	}
 }
@@ -680,7 +969,7 @@ func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
 
 	slog.Debug("embedding request", "content", req.Content)
 
-	seq, err := s.NewSequence(req.Content, nil, NewSequenceParams{embedding: true})
+	seq, err := s.NewSequence(req.Content, nil, NewSequenceParams{embedding: true, adapters: req.Adapters})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
 		return
@@ -700,7 +989,7 @@ func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
 	found := false
 	for i, sq := range s.seqs {
 		if sq == nil {
-			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs, false)
+			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs, seq.adapters, false)
 			if err != nil {
 				s.mu.Unlock()
 				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
@@ -719,7 +1008,13 @@ func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	embedding := <-seq.embedding
+	var embedding []float32
+	select {
+	case embedding = <-seq.embedding:
+	case <-r.Context().Done():
+		close(seq.quit)
+		return
+	}
 
 	if err := json.NewEncoder(w).Encode(&llm.EmbeddingResponse{
 		Embedding: embedding,
@@ -728,6 +1023,109 @@ func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// importance runs the calibration texts of the request through the model
+// and returns its importance matrix, which is the mean of the squares of
+// the activations each column of its weights is multiplied with. Progress
+// is streamed as each chunk of the calibration is decoded.
+func (s *Server) importance(w http.ResponseWriter, r *http.Request) {
+	var req llm.ImportanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if req.Context <= 0 {
+		http.Error(w, "context must be positive", http.StatusBadRequest)
+		return
+	}
+
+	var tokens []int
+	for _, c := range req.Calibration {
+		ts, err := s.model.Tokenize(c, true, false)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to tokenize calibration: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		tokens = append(tokens, ts...)
+	}
+
+	// activations are only collected for batches of at least 16 tokens
+	if len(tokens) < 16 {
+		http.Error(w, "calibration data is too short", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Transfer-Encoding", "chunked")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	enc := json.NewEncoder(w)
+	fail := func(err error) {
+		if err := enc.Encode(&llm.ImportanceResponse{Error: err.Error()}); err != nil {
+			slog.Error("failed to encode importance error", "error", err)
+		}
+	}
+
+	// the context the matrix is collected with shares the model's weights,
+	// and sequences aren't decoded until it's done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	im := llama.NewImportanceMatrix()
+	defer im.Free()
+
+	params := llama.NewContextParams(req.Context, req.Context, 1, s.threads, false, "")
+	params.SetImportanceMatrix(im)
+
+	lc, err := llama.NewContextWithModel(s.model, params)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer lc.Free()
+
+	batch, err := llama.NewBatch(req.Context, 1, 0)
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer batch.Free()
+
+	for i := 0; i < len(tokens); i += req.Context {
+		if r.Context().Err() != nil {
+			return
+		}
+
+		chunk := tokens[i:min(i+req.Context, len(tokens))]
+
+		lc.KvCacheClear()
+		batch.Clear()
+		for j, t := range chunk {
+			batch.Add(t, nil, j, j == len(chunk)-1, 0)
+		}
+
+		if err := lc.Decode(batch); err != nil {
+			fail(err)
+			return
+		}
+
+		if err := enc.Encode(&llm.ImportanceResponse{Completed: i + len(chunk), Total: len(tokens)}); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+
+	if err := enc.Encode(&llm.ImportanceResponse{Completed: len(tokens), Total: len(tokens), Importance: im.Values()}); err != nil {
+		slog.Error("failed to encode importance", "error", err)
+	}
+}
+
 func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(&llm.ServerStatusResponse{
@@ -759,6 +1157,7 @@ func (s *Server) loadModel(
 	flashAttention bool,
 	threads int,
 	multiUserCache bool,
+	kvOffload bool,
 ) {
 	var err error
 	s.model, err = llama.LoadModelFromFile(mpath, params)
@@ -766,18 +1165,22 @@ func (s *Server) loadModel(
 		panic(err)
 	}
 
+	s.threads = threads
+
 	ctxParams := llama.NewContextParams(kvSize, s.batchSize*s.parallel, s.parallel, threads, flashAttention, kvCacheType)
+	ctxParams.SetOffloadKQV(kvOffload)
 	s.lc, err = llama.NewContextWithModel(s.model, ctxParams)
 	if err != nil {
 		panic(err)
 	}
 
-	if lpath.String() != "" {
-		for _, path := range lpath {
-			err := s.model.ApplyLoraFromFile(s.lc, path, 1.0, threads)
-			if err != nil {
-				panic(err)
-			}
+	// adapters are applied to the sequences which request them as they're
+	// decoded rather than to every sequence
+	s.loras = make(map[string]*llama.LoraAdapter)
+	for _, path := range lpath {
+		s.loras[path], err = s.model.LoadLoraFromFile(path)
+		if err != nil {
+			panic(err)
 		}
 	}
 
@@ -816,6 +1219,7 @@ func Execute(args []string) error {
 	mlock := fs.Bool("mlock", false, "force system to keep model in RAM rather than swapping or compressing")
 	tensorSplit := fs.String("tensor-split", "", "fraction of the model to offload to each GPU, comma-separated list of proportions")
 	multiUserCache := fs.Bool("multiuser-cache", false, "optimize input cache algorithm for multiple users")
+	noKVOffload := fs.Bool("no-kv-offload", false, "keep the KV cache in system memory")
 
 	var lpaths multiLPath
 	fs.Var(&lpaths, "lora", "Path to lora layer file (can be specified multiple times)")
@@ -877,7 +1281,7 @@ func Execute(args []string) error {
 	}
 
 	server.ready.Add(1)
-	go server.loadModel(params, *mpath, lpaths, *ppath, *kvSize, *kvCacheType, *flashAttention, *threads, *multiUserCache)
+	go server.loadModel(params, *mpath, lpaths, *ppath, *kvSize, *kvCacheType, *flashAttention, *threads, *multiUserCache, !*noKVOffload)
 
 	server.cond = sync.NewCond(&server.mu)
 
@@ -897,6 +1301,7 @@ func Execute(args []string) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/embedding", server.embeddings)
 	mux.HandleFunc("/completion", server.completion)
+	mux.HandleFunc("/importance", server.importance)
 	mux.HandleFunc("/health", server.health)
 
 	httpServer := http.Server{
@@ -5,12 +5,13 @@ import (
 	"fmt"
 	"log/slog"
 	"math"
+	"slices"
 	"time"
 
 	"github.com/ollama/ollama/kvcache"
-	"github.com/ollama/ollama/ml"
 	"github.com/ollama/ollama/model"
 	"github.com/ollama/ollama/model/input"
+	"github.com/ollama/ollama/runner/common"
 )
 
 type InputCache struct {
@@ -31,7 +32,10 @@ type InputCache struct {
 	cache kvcache.Cache
 }
 
-func NewInputCache(model model.Model, kvCacheType string, kvSize int32, numSlots int, multiUserCache bool) (*InputCache, error) {
+// pagedBlockSize is the number of inputs in each block of a paged KV cache
+const pagedBlockSize = 32
+
+func NewInputCache(model model.Model, kvCacheType string, kvSize int32, numSlots int, multiUserCache bool, paged bool, encoderCache string) (*InputCache, error) {
 	if kvSize/int32(numSlots) < 1 {
 		return nil, fmt.Errorf("must have at least one kv cache entry per parallel sequence (kv: %v parallel: %v)", kvSize, numSlots)
 	}
@@ -44,7 +48,29 @@ func NewInputCache(model model.Model, kvCacheType string, kvSize int32, numSlots
 
 	cache := model.Config().Cache
 	if cache != nil {
-		cache.Init(model.Backend(), kvCacheTypeFromStr(kvCacheType), kvSize)
+		dtype, err := kvcache.ParseDType(kvCacheType)
+		if err != nil {
+			return nil, err
+		}
+
+		if paged {
+			if p, ok := cache.(kvcache.PagedCache); ok {
+				p.SetBlockSize(pagedBlockSize)
+			} else {
+				slog.Warn("model does not support paged attention")
+			}
+		}
+
+		storage, err := kvcache.ParseEncoderStorage(encoderCache)
+		if err != nil {
+			return nil, err
+		}
+
+		if s, ok := cache.(kvcache.EncoderStorageCache); ok {
+			s.SetEncoderStorage(storage)
+		}
+
+		cache.Init(model.Backend(), dtype, kvSize)
 	}
 
 	return &InputCache{
@@ -56,17 +82,6 @@ func NewInputCache(model model.Model, kvCacheType string, kvSize int32, numSlots
 	}, nil
 }
 
-func kvCacheTypeFromStr(s string) ml.DType {
-	switch s {
-	case "q8_0":
-		return ml.DTypeQ80
-	case "q4_0":
-		return ml.DTypeQ40
-	default:
-		return ml.DTypeF16
-	}
-}
-
 func (c *InputCache) Close() {
 	c.cache.Close()
 }
@@ -205,6 +220,33 @@ func (c *InputCache) findBestCacheSlot(prompt []input.Input) (*InputCacheSlot, i
 	return oldestSlot, longest, nil
 }
 
+// ForkCacheSlot returns an unused cache slot with the first n inputs of src,
+// copying them in the KV cache, for sequences which continue the same prompt.
+func (c *InputCache) ForkCacheSlot(src *InputCacheSlot, n int32) (*InputCacheSlot, error) {
+	var slot *InputCacheSlot
+	for i := range c.slots {
+		if !c.slots[i].InUse && (slot == nil || c.slots[i].lastUsed.Before(slot.lastUsed)) {
+			slot = &c.slots[i]
+		}
+	}
+
+	if slot == nil {
+		return nil, errors.New("no available cache slots")
+	}
+
+	slog.Debug("forking cache slot", "src", src.Id, "dst", slot.Id, "inputs", n)
+
+	slot.InUse = true
+	slot.lastUsed = time.Now()
+	slot.Inputs = slices.Clone(src.Inputs[:n])
+
+	if c.cache != nil {
+		c.cache.CopyPrefix(src.Id, slot.Id, n)
+	}
+
+	return slot, nil
+}
+
 func countCommonPrefix(a []input.Input, b []input.Input) int32 {
 	var count int32
 
@@ -223,9 +265,8 @@ func countCommonPrefix(a []input.Input, b []input.Input) int32 {
 	return count
 }
 
-func (c *InputCache) ShiftDiscard(inputLen int32, numKeep int32) int32 {
-	targetFree := (c.numCtx - numKeep) / 2
-	targetFree = max(targetFree, 1)
+func (c *InputCache) ShiftDiscard(inputLen int32, numKeep int32, mode common.CacheMode) int32 {
+	targetFree := int32(mode.TargetFree(int(c.numCtx), int(numKeep)))
 
 	currentFree := c.numCtx - inputLen
 	discard := targetFree - currentFree
@@ -237,24 +278,25 @@ func (c *InputCache) ShiftDiscard(inputLen int32, numKeep int32) int32 {
 	return discard
 }
 
-// Frees up space in the KV cache by deleting the oldest half of history and shifting
-// the newest half into that space (saving numKeep inputs at the beginning).
+// Frees up space in the KV cache by deleting the oldest history and shifting the
+// newest into that space (saving numKeep inputs at the beginning). How much is
+// deleted depends on mode: half of the history, or a little for streaming.
 //
 // Assumes that at least 1 entry can be freed up by shifting (i.e. numKeep < numCtx)
-func (c *InputCache) ShiftCacheSlot(slot *InputCacheSlot, numKeep int32) error {
+func (c *InputCache) ShiftCacheSlot(slot *InputCacheSlot, numKeep int32, mode common.CacheMode) error {
 	if numKeep >= c.numCtx {
 		return fmt.Errorf("unable to shift context - keep exceeds context (keep: %v context: %v)", numKeep, c.numCtx)
 	}
 
 	inputLen := int32(len(slot.Inputs))
-	discard := c.ShiftDiscard(inputLen, numKeep)
+	discard := c.ShiftDiscard(inputLen, numKeep, mode)
 
 	if discard <= 0 {
 		return nil
 	}
 
 	slog.Debug("context limit hit - shifting", "id", slot.Id, "limit", c.numCtx, "input", len(slot.Inputs),
-		"keep", numKeep, "discard", discard)
+		"keep", numKeep, "discard", discard, "mode", mode)
 
 	// TODO (jessegross): KV cache removal can fail for certain types of models
 	if c.cache != nil {
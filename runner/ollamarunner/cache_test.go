@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/ollama/ollama/model/input"
+	"github.com/ollama/ollama/runner/common"
 )
 
 func TestCountCommon(t *testing.T) {
@@ -241,6 +242,7 @@ func TestShiftDiscard(t *testing.T) {
 		numCtx   int32
 		numKeep  int32
 		inputLen int32
+		mode     common.CacheMode
 		expected int32
 	}{
 		{
@@ -278,6 +280,30 @@ func TestShiftDiscard(t *testing.T) {
 			inputLen: 5000,
 			expected: 2953,
 		},
+		{
+			name:     "Streaming",
+			numCtx:   2048,
+			numKeep:  4,
+			inputLen: 2048,
+			mode:     common.CacheModeStreaming,
+			expected: 127,
+		},
+		{
+			name:     "Streaming Truncate",
+			numCtx:   2048,
+			numKeep:  4,
+			inputLen: 5000,
+			mode:     common.CacheModeStreaming,
+			expected: 3079,
+		},
+		{
+			name:     "Streaming Max Keep",
+			numCtx:   2048,
+			numKeep:  2047,
+			inputLen: 2048,
+			mode:     common.CacheModeStreaming,
+			expected: 1,
+		},
 		{
 			name:     "No Op",
 			numCtx:   2048,
@@ -290,7 +316,7 @@ func TestShiftDiscard(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := InputCache{numCtx: tt.numCtx}
-			result := c.ShiftDiscard(tt.inputLen, tt.numKeep)
+			result := c.ShiftDiscard(tt.inputLen, tt.numKeep, tt.mode)
 			if result != tt.expected {
 				t.Errorf("shiftDiscard(ctx: %v, keep: %v input: %v): have %v; want %v", tt.numCtx, tt.numKeep, tt.inputLen, result, tt.expected)
 			}
@@ -15,6 +15,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -55,6 +56,10 @@ type Sequence struct {
 	// tokens that have been generated but not returned yet (e.g. for stop sequences)
 	pendingResponses []string
 
+	// decoder decodes generated tokens into text, holding back the bytes of
+	// incomplete characters
+	decoder *model.StreamDecoder
+
 	// input cache being used by this sequence
 	cache *InputCacheSlot
 
@@ -73,17 +78,36 @@ type Sequence struct {
 	// channel to send back the embedding if embedding only
 	embedding chan []float32
 
-	// stop sequences
-	stop []string
+	// stop sequences, regexps and tokens
+	stops *common.Stops
+
+	// forks are the other completions of the request, which start from
+	// the inputs of this sequence in the cache once its prompt is processed
+	forks []*Sequence
+
+	// healing constrains the first tokens to complete the last token of
+	// the prompt, which was removed, when token healing is enabled
+	healing *common.Healing
+
+	// renderSpecial is whether the text of special tokens which are
+	// generated is returned
+	renderSpecial bool
 
 	// number of inputs to keep at the beginning when shifting context window
 	numKeep int32
 
+	// cacheMode is how the context window is shifted when it fills up
+	cacheMode common.CacheMode
+
 	// true if an embedding are to be returned instead of text generation
 	embeddingOnly bool
 
 	doneReason string
 
+	// deadline is when generation stops with what has been generated so
+	// far, if it isn't zero
+	deadline time.Time
+
 	// Metrics
 	startProcessingTime time.Time
 	startGenerationTime time.Time
@@ -92,11 +116,15 @@ type Sequence struct {
 }
 
 type NewSequenceParams struct {
-	numPredict int
-	stop       []string
-	numKeep    int32
-	sampler    sample.Sampler
-	embedding  bool
+	numPredict    int
+	stops         *common.Stops
+	numKeep       int32
+	cacheMode     common.CacheMode
+	sampler       sample.Sampler
+	embedding     bool
+	tokenHealing  bool
+	renderSpecial bool
+	deadline      time.Time
 }
 
 func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSequenceParams) (*Sequence, error) {
@@ -111,6 +139,11 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 		return nil, errors.New("no input provided")
 	}
 
+	var healing *common.Healing
+	if params.tokenHealing {
+		healing, inputs = s.heal(inputs)
+	}
+
 	if params.numKeep < 0 {
 		params.numKeep = int32(len(inputs))
 	}
@@ -132,6 +165,67 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 
 	// TODO(jessegross): Ingest cached history for grammar
 
+	seq := newSequence(inputs, ctxs, startTime, params)
+	seq.decoder = model.NewStreamDecoder(s.model.(model.TextProcessor))
+	seq.healing = healing
+	return seq, nil
+}
+
+// heal removes the last input of a prompt if it's text, returning the healing
+// of the response which completes it
+func (s *Server) heal(inputs []input.Input) (*common.Healing, []input.Input) {
+	if len(inputs) < 2 {
+		return nil, inputs
+	}
+
+	// images and the inputs which must be in the same batch as them are
+	// left as they are
+	last := len(inputs) - 1
+	if inputs[last].Multimodal != nil {
+		return nil, inputs
+	}
+
+	for i, inp := range inputs[:last] {
+		if inp.SameBatch > 0 && i+inp.SameBatch >= last {
+			return nil, inputs
+		}
+	}
+
+	tp := s.model.(model.TextProcessor)
+	if tp.Is(inputs[last].Token, model.SpecialControl) {
+		return nil, inputs
+	}
+
+	piece, err := tp.Decode([]int32{inputs[last].Token})
+	if err != nil || piece == "" {
+		return nil, inputs
+	}
+
+	return common.NewHealing(piece, func(token int) string {
+		if tp.Is(int32(token), model.SpecialControl) {
+			return ""
+		}
+
+		piece, _ := tp.Decode([]int32{int32(token)})
+		return piece
+	}), inputs[:last]
+}
+
+// fork returns a sequence for another completion of the prompt of seq, which
+// is started by startForks once seq has processed it.
+func (s *Server) fork(seq *Sequence, params NewSequenceParams) *Sequence {
+	params.numKeep = seq.numKeep
+	params.cacheMode = seq.cacheMode
+	fork := newSequence(nil, seq.ctxs, seq.startProcessingTime, params)
+
+	// the prompt is only processed by seq
+	fork.numPromptInputs = 0
+	fork.decoder = model.NewStreamDecoder(s.model.(model.TextProcessor))
+	fork.healing = seq.healing.Clone()
+	return fork
+}
+
+func newSequence(inputs []input.Input, ctxs *contextList, startTime time.Time, params NewSequenceParams) *Sequence {
 	return &Sequence{
 		ctxs:                ctxs,
 		inputs:              inputs,
@@ -144,9 +238,12 @@ func (s *Server) NewSequence(prompt string, images []llm.ImageData, params NewSe
 		embedding:           make(chan []float32, 1),
 		sampler:             params.sampler,
 		embeddingOnly:       params.embedding,
-		stop:                params.stop,
+		stops:               params.stops,
 		numKeep:             params.numKeep,
-	}, nil
+		cacheMode:           params.cacheMode,
+		renderSpecial:       params.renderSpecial,
+		deadline:            params.deadline,
+	}
 }
 
 // inputs processes the prompt and images into a list of inputs
@@ -271,10 +368,8 @@ type Server struct {
 	// of non-text data
 	multimodalHash maphash.Hash
 
-	// vocab is a llama.cpp vocab required for gammar-based
+	// vocab is the vocabulary of the model for grammar-based
 	// constrained generation (json mode, structured outputs)
-	// TODO: this is temporary until Ollama sampling supports
-	// constrained generation
 	vocab *sample.Vocab
 }
 
@@ -305,6 +400,8 @@ func flushPending(seq *Sequence) bool {
 		return true
 	}
 
+	seq.stops.Returned(joined)
+
 	select {
 	case seq.responses <- joined:
 		return true
@@ -323,6 +420,37 @@ func (s *Server) removeSequence(seqIndex int, reason string) {
 	seq.cache.InUse = false
 	s.seqs[seqIndex] = nil
 	s.seqsSem.Release(1)
+
+	// forks which haven't started end with seq
+	for _, fork := range seq.forks {
+		fork.doneReason = reason
+		fork.startGenerationTime = time.Now()
+		close(fork.responses)
+		close(fork.embedding)
+		s.seqsSem.Release(1)
+	}
+	seq.forks = nil
+}
+
+// startForks starts the forks of seq from the inputs of seq in the cache,
+// leaving the last to be processed again to sample from, and returns them to
+// be added to s.seqs.
+func (s *Server) startForks(seq *Sequence) ([]*Sequence, error) {
+	for _, fork := range seq.forks {
+		last := len(seq.cache.Inputs) - 1
+
+		var err error
+		fork.cache, err = s.cache.ForkCacheSlot(seq.cache, int32(last))
+		if err != nil {
+			return nil, err
+		}
+
+		fork.inputs = []input.Input{seq.cache.Inputs[last]}
+	}
+
+	forks := seq.forks
+	seq.forks = nil
+	return forks, nil
 }
 
 func (s *Server) run(ctx context.Context) {
@@ -355,6 +483,19 @@ func (s *Server) processBatch() error {
 			continue
 		}
 
+		// the client went away, so don't spend more batches on it
+		select {
+		case <-seq.quit:
+			s.removeSequence(i, "connection")
+			continue
+		default:
+		}
+
+		if !seq.deadline.IsZero() && time.Now().After(seq.deadline) {
+			s.removeSequence(i, "timeout")
+			continue
+		}
+
 		// if past the num predict limit
 		if seq.numPredict > 0 && seq.numPredicted >= seq.numPredict {
 			s.removeSequence(i, "limit")
@@ -389,7 +530,7 @@ func (s *Server) processBatch() error {
 					break
 				}
 
-				err := s.cache.ShiftCacheSlot(seq.cache, seq.numKeep)
+				err := s.cache.ShiftCacheSlot(seq.cache, seq.numKeep, seq.cacheMode)
 				if err != nil {
 					return err
 				}
@@ -427,6 +568,7 @@ func (s *Server) processBatch() error {
 
 	logits := modelOutput.Floats()
 
+	var forks []*Sequence
 	for i, seq := range s.seqs {
 		if seq == nil {
 			continue
@@ -449,6 +591,12 @@ func (s *Server) processBatch() error {
 		seq.numPredicted++
 		if seq.numPredicted == 1 {
 			seq.startGenerationTime = time.Now()
+
+			started, err := s.startForks(seq)
+			if err != nil {
+				return err
+			}
+			forks = append(forks, started...)
 		}
 
 		// if done processing the prompt, generate an embedding and return
@@ -462,7 +610,10 @@ func (s *Server) processBatch() error {
 		// sample a token
 		vocabSize := len(logits) / len(options.Outputs)
 
-		token, err := seq.sampler.Sample(logits[seq.iBatch*vocabSize : (seq.iBatch+1)*vocabSize])
+		seqLogits := logits[seq.iBatch*vocabSize : (seq.iBatch+1)*vocabSize]
+		seq.healing.Mask(seqLogits)
+
+		token, err := seq.sampler.Sample(seqLogits)
 		if err != nil {
 			return fmt.Errorf("failed to sample token: %w", err)
 		}
@@ -477,22 +628,32 @@ func (s *Server) processBatch() error {
 			continue
 		}
 
-		piece, err := s.model.(model.TextProcessor).Decode([]int32{token})
-		if err != nil {
-			return err
+		if seq.stops.Token(int(token)) {
+			s.removeSequence(i, "stop")
+			continue
 		}
 
+		var piece string
+		if seq.renderSpecial || !s.model.(model.TextProcessor).Is(token, model.SpecialControl) {
+			piece, err = seq.decoder.Decode(token)
+			if err != nil {
+				return err
+			}
+		}
+
+		piece = seq.healing.Accept(piece)
+
 		seq.inputs = []input.Input{{Token: token}}
 
 		seq.pendingResponses = append(seq.pendingResponses, piece)
 		sequence := strings.Join(seq.pendingResponses, "")
 
-		if ok, stop := common.FindStop(sequence, seq.stop); ok {
-			slog.Debug("hit stop token", "pending", seq.pendingResponses, "stop", stop)
+		if start, end, ok := seq.stops.Find(sequence); ok {
+			slog.Debug("hit stop token", "pending", seq.pendingResponses, "stop", sequence[start:end])
 
 			var tokenTruncated bool
 			origLen := len(seq.pendingResponses)
-			seq.pendingResponses, tokenTruncated = common.TruncateStop(seq.pendingResponses, stop)
+			seq.pendingResponses, tokenTruncated = common.TruncateAt(seq.pendingResponses, start)
 			newLen := len(seq.pendingResponses)
 
 			// Update the cache based on the tokens that will be returned:
@@ -513,11 +674,7 @@ func (s *Server) processBatch() error {
 			continue
 		}
 
-		if common.ContainsStopSuffix(sequence, seq.stop) {
-			continue
-		}
-
-		if common.IncompleteUnicode(sequence) {
+		if seq.stops.Partial(sequence) {
 			continue
 		}
 
@@ -526,6 +683,12 @@ func (s *Server) processBatch() error {
 		}
 	}
 
+	// forks are only added once the sequences in the batch are sampled, as
+	// they weren't part of it. The completion reserved places for them.
+	for _, fork := range forks {
+		s.seqs[slices.Index(s.seqs, nil)] = fork
+	}
+
 	return nil
 }
 
@@ -536,6 +699,11 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(req.Adapters) > 0 {
+		http.Error(w, "adapters are not yet implemented", http.StatusBadRequest)
+		return
+	}
+
 	if req.Options == nil {
 		opts := api.DefaultOptions()
 		req.Options = &opts
@@ -551,39 +719,114 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var grammar *sample.Grammar
-	var err error
-	if req.Grammar != "" {
-		grammar, err = sample.NewGrammar(s.vocab, req.Grammar)
-		if err != nil {
-			http.Error(w, "failed to load model vocabulary required for format", http.StatusInternalServerError)
-			return
-		}
+	n := max(req.N, 1)
+	if n > len(s.seqs) {
+		http.Error(w, fmt.Sprintf("n (%d) is more than the number of parallel requests (%d)", n, len(s.seqs)), http.StatusBadRequest)
+		return
 	}
 
-	sampler := sample.NewSampler(
-		req.Options.Temperature,
-		req.Options.TopK,
-		req.Options.TopP,
-		req.Options.MinP,
-		req.Options.Seed,
-		grammar,
-	)
+	if req.Options.Mirostat != 0 && req.Options.Mirostat != 2 {
+		http.Error(w, fmt.Sprintf("mirostat %d is not supported by this model", req.Options.Mirostat), http.StatusBadRequest)
+		return
+	}
 
-	seq, err := s.NewSequence(req.Prompt, req.Images, NewSequenceParams{
-		numPredict: req.Options.NumPredict,
-		stop:       req.Options.Stop,
-		numKeep:    int32(req.Options.NumKeep),
-		sampler:    sampler,
-		embedding:  false,
-	})
+	var breakers []int32
+	if req.Options.DryMultiplier > 0 {
+		for _, breaker := range req.Options.DryBreakers {
+			ids, err := s.model.(model.TextProcessor).Encode(breaker, false)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed to encode sequence breaker: %v", err), http.StatusInternalServerError)
+				return
+			}
+
+			breakers = append(breakers, ids...)
+		}
+	}
+
+	cacheMode, err := common.ParseCacheMode(req.Options.CacheMode)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Ensure there is a place to put the sequence, released when removed from s.seqs
-	if err := s.seqsSem.Acquire(r.Context(), 1); err != nil {
+	seqs := make([]*Sequence, n)
+	for i := range seqs {
+		var grammar *sample.Grammar
+		var err error
+		if req.Grammar != "" {
+			grammar, err = sample.NewGrammar(s.vocab, req.Grammar)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid grammar: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// each completion samples differently from the same seed
+		seed := req.Options.Seed
+		if seed != -1 {
+			seed += i
+		}
+
+		sampler := sample.NewSampler(
+			req.Options.Temperature,
+			req.Options.TopK,
+			req.Options.TopP,
+			req.Options.MinP,
+			seed,
+			grammar,
+		)
+
+		if req.Options.Mirostat == 2 {
+			sampler.SetMirostat(sample.Mirostat{Tau: req.Options.MirostatTau, Eta: req.Options.MirostatEta})
+		}
+
+		sampler.SetXTC(sample.XTC{Probability: req.Options.XTCProbability, Threshold: req.Options.XTCThreshold})
+
+		if req.Options.DryMultiplier > 0 {
+			sampler.SetDRY(sample.DRY{
+				Multiplier:    req.Options.DryMultiplier,
+				Base:          req.Options.DryBase,
+				AllowedLength: req.Options.DryAllowedLength,
+				LastN:         req.Options.DryPenaltyLastN,
+				Breakers:      breakers,
+			})
+		}
+
+		stops, err := common.NewStops(req.Options.Stop, req.Options.StopRegex, req.Options.StopTokens)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		params := NewSequenceParams{
+			numPredict:    req.Options.NumPredict,
+			stops:         stops,
+			numKeep:       int32(req.Options.NumKeep),
+			cacheMode:     cacheMode,
+			sampler:       sampler,
+			embedding:     false,
+			tokenHealing:  req.Options.TokenHealing,
+			renderSpecial: req.Options.RenderSpecial == nil || *req.Options.RenderSpecial,
+			deadline:      req.Deadline,
+		}
+
+		if i > 0 {
+			seqs[i] = s.fork(seqs[0], params)
+			continue
+		}
+
+		seqs[i], err = s.NewSequence(req.Prompt, req.Images, params)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	seq := seqs[0]
+	seq.forks = seqs[1:]
+
+	// Ensure there is a place to put the sequences, released when removed from s.seqs
+	if err := s.seqsSem.Acquire(r.Context(), int64(n)); err != nil {
 		if errors.Is(err, context.Canceled) {
 			slog.Info("aborting completion request due to client closing the connection")
 		} else {
@@ -596,9 +839,11 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	found := false
 	for i, sq := range s.seqs {
 		if sq == nil {
+			var err error
 			seq.cache, seq.inputs, err = s.cache.LoadCacheSlot(seq.inputs)
 			if err != nil {
 				s.mu.Unlock()
+				s.seqsSem.Release(int64(n))
 				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
 				return
 			}
@@ -616,41 +861,82 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for {
+	type choice struct {
+		index   int
+		content string
+		done    bool
+	}
+
+	// merge the responses of the completions in the order they're generated
+	choices := make(chan choice)
+	for i, seq := range seqs {
+		go func() {
+			for content := range seq.responses {
+				select {
+				case choices <- choice{index: i, content: content}:
+				case <-r.Context().Done():
+					return
+				}
+			}
+
+			select {
+			case choices <- choice{index: i, done: true}:
+			case <-r.Context().Done():
+			}
+		}()
+	}
+
+	quit := func() {
+		for _, seq := range seqs {
+			close(seq.quit)
+		}
+	}
+
+	for done := 0; done < n; {
 		select {
 		case <-r.Context().Done():
-			close(seq.quit)
+			quit()
 			return
-		case content, ok := <-seq.responses:
-			if ok {
+		case c := <-choices:
+			if !c.done {
 				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
-					Content: content,
+					Index:   c.index,
+					Content: c.content,
 				}); err != nil {
 					http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
-					close(seq.quit)
+					quit()
 					return
 				}
 
 				flusher.Flush()
-			} else {
-				// Send the final response
-				doneReason := "stop"
-				if seq.doneReason == "limit" {
-					doneReason = "length"
-				}
-				if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
-					Done:               true,
-					DoneReason:         doneReason,
-					PromptEvalCount:    seq.numPromptInputs,
-					PromptEvalDuration: seq.startGenerationTime.Sub(seq.startProcessingTime),
-					EvalCount:          seq.numPredicted,
-					EvalDuration:       time.Since(seq.startGenerationTime),
-				}); err != nil {
-					http.Error(w, fmt.Sprintf("failed to encode final response: %v", err), http.StatusInternalServerError)
-				}
+				continue
+			}
 
+			// Send the final response of the completion
+			seq := seqs[c.index]
+			doneReason := "stop"
+			switch seq.doneReason {
+			case "limit":
+				doneReason = "length"
+			case "timeout":
+				doneReason = "timeout"
+			}
+			if err := json.NewEncoder(w).Encode(&llm.CompletionResponse{
+				Index:              c.index,
+				Done:               true,
+				DoneReason:         doneReason,
+				PromptEvalCount:    seq.numPromptInputs,
+				PromptEvalDuration: seq.startGenerationTime.Sub(seq.startProcessingTime),
+				EvalCount:          seq.numPredicted,
+				EvalDuration:       time.Since(seq.startGenerationTime),
+			}); err != nil {
+				http.Error(w, fmt.Sprintf("failed to encode final response: %v", err), http.StatusInternalServerError)
+				quit()
 				return
 			}
+
+			flusher.Flush()
+			done++
 		}
 	}
 }
@@ -684,6 +970,8 @@ func (s *Server) loadModel(
 	kvCacheType string,
 	kvSize int,
 	multiUserCache bool,
+	pagedAttention bool,
+	encoderCache string,
 ) {
 	var err error
 	s.model, err = model.New(mpath, params)
@@ -691,14 +979,14 @@ func (s *Server) loadModel(
 		panic(err)
 	}
 
-	s.vocab = sample.NewVocab(mpath)
+	s.vocab = sample.NewVocab(s.model.(model.TextProcessor), len(s.model.Backend().Config().Strings("tokenizer.ggml.tokens")))
 
 	// TODO(jessegross): LoRA loading
 	if lpath.String() != "" {
 		panic("loras are not yet implemented")
 	}
 
-	s.cache, err = NewInputCache(s.model, kvCacheType, int32(kvSize), parallel, multiUserCache)
+	s.cache, err = NewInputCache(s.model, kvCacheType, int32(kvSize), parallel, multiUserCache, pagedAttention, encoderCache)
 	if err != nil {
 		panic(err)
 	}
@@ -730,9 +1018,14 @@ func Execute(args []string) error {
 	threads := fs.Int("threads", runtime.NumCPU(), "Number of threads to use during generation")
 	verbose := fs.Bool("verbose", false, "verbose output (default: disabled)")
 	_ = fs.Bool("no-mmap", false, "do not memory-map model (slower load but may reduce pageouts if not using mlock)")
-	_ = fs.Bool("mlock", false, "force system to keep model in RAM rather than swapping or compressing")
+	mlock := fs.Bool("mlock", false, "force system to keep model in RAM rather than swapping or compressing")
 	tensorSplit := fs.String("tensor-split", "", "fraction of the model to offload to each GPU, comma-separated list of proportions")
 	multiUserCache := fs.Bool("multiuser-cache", false, "optimize input cache algorithm for multiple users")
+	pagedAttention := fs.Bool("paged-attention", false, "allocate the KV cache in fixed-size blocks shared by all sequences")
+	encoderCache := fs.String("encoder-cache", "", "where to keep encoder outputs of images: device, f16 or host (default: device)")
+	backend := fs.String("backend", ml.DefaultBackend, "ml backend to load the model with")
+	offload := fs.String("offload", "", "where to place the parts of the model, as in the offload option (overrides n-gpu-layers)")
+	weightStreaming := fs.Bool("weight-streaming", false, "keep weights of layers on the CPU in pinned memory to stream them to GPUs for prompt processing")
 
 	var lpaths multiLPath
 	fs.Var(&lpaths, "lora", "Path to lora layer file (can be specified multiple times)")
@@ -767,9 +1060,7 @@ func Execute(args []string) error {
 		status:    llm.ServerStatusLoadingModel,
 	}
 
-	// TODO(jessegross): Parameters that need to be implemented:
-	//	no-mmap
-	//	mlock
+	// no-mmap has no effect since weights are always read into buffers
 
 	var tensorSplitFloats []float32
 	if *tensorSplit != "" {
@@ -782,15 +1073,33 @@ func Execute(args []string) error {
 	}
 
 	params := ml.BackendParams{
-		NumThreads:     *threads,
-		NumGPULayers:   *numGPULayers,
-		MainGPU:        *mainGPU,
-		TensorSplit:    tensorSplitFloats,
-		FlashAttention: *flashAttention,
+		NumThreads:      *threads,
+		NumGPULayers:    *numGPULayers,
+		MainGPU:         *mainGPU,
+		TensorSplit:     tensorSplitFloats,
+		FlashAttention:  *flashAttention,
+		Backend:         *backend,
+		WeightStreaming: *weightStreaming,
+		UseMLock:        *mlock,
+	}
+
+	if *offload != "" {
+		o, err := api.ParseOffload(*offload)
+		if err != nil {
+			return err
+		}
+
+		params.Offload = &ml.Offload{
+			GPUBlocks:  o.Blocks,
+			AllBlocks:  o.AllBlocks,
+			Embeddings: o.Embeddings != nil && *o.Embeddings,
+			Output:     o.Output != nil && *o.Output,
+			KVCache:    o.KVCache == nil || *o.KVCache,
+		}
 	}
 
 	server.ready.Add(1)
-	go server.loadModel(*mpath, params, lpaths, *parallel, *kvCacheType, *kvSize, *multiUserCache)
+	go server.loadModel(*mpath, params, lpaths, *parallel, *kvCacheType, *kvSize, *multiUserCache, *pagedAttention, *encoderCache)
 
 	server.cond = sync.NewCond(&server.mu)
 
@@ -1,11 +1,20 @@
 package runner
 
 import (
+	"fmt"
+
 	"github.com/ollama/ollama/runner/llamarunner"
 	"github.com/ollama/ollama/runner/ollamarunner"
+	"github.com/ollama/ollama/sandbox"
 )
 
 func Execute(args []string) error {
+	// the runner confines itself before it opens the model, if the server
+	// sandboxes runners
+	if err := sandbox.Enter(); err != nil {
+		return fmt.Errorf("error entering sandbox: %w", err)
+	}
+
 	if args[0] == "runner" {
 		args = args[1:]
 	}
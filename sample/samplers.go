@@ -7,7 +7,8 @@ import (
 	"slices"
 	"sync"
 
-	"github.com/ollama/ollama/llama"
+	"github.com/ollama/ollama/grammar"
+	"github.com/ollama/ollama/model"
 )
 
 // token represents information about a single token during sampling
@@ -23,14 +24,74 @@ type Sampler struct {
 	minP        float32
 	temperature float32
 	grammar     *Grammar
+
+	dry      DRY
+	xtc      XTC
+	mirostat *Mirostat
+
+	// history is the tokens sampled before, for DRY
+	history []int32
+
+	// mu is the most surprise a token may have with Mirostat, and prob
+	// is the probability of the token sampled last
+	mu   float32
+	prob float32
+}
+
+// DRY configures the "don't repeat yourself" sampler, which penalizes tokens
+// that would extend a repetition of the tokens sampled before.
+type DRY struct {
+	// Multiplier scales the penalty, which is disabled if it's 0
+	Multiplier float32
+
+	// Base is raised to how much longer than AllowedLength a repetition is
+	Base float32
+
+	// AllowedLength is the longest repetition which isn't penalized
+	AllowedLength int
+
+	// LastN is how many tokens to look back for repetitions, all of them
+	// if it's -1 or none if it's 0
+	LastN int
+
+	// Breakers are the tokens which repetitions can't extend across
+	Breakers []int32
+}
+
+// XTC configures the "exclude top choices" sampler, which removes the most
+// likely tokens except the least likely of those with at least Threshold
+// probability, with chance Probability.
+type XTC struct {
+	Probability float32
+	Threshold   float32
+}
+
+// Mirostat configures Mirostat 2.0 sampling, which keeps the surprise of
+// tokens near Tau, learning at rate Eta. It replaces top-k, top-p, min-p,
+// XTC and DRY.
+type Mirostat struct {
+	Tau float32
+	Eta float32
+}
+
+// SetDRY sets the DRY sampler, which applies to the logits before any other.
+func (s *Sampler) SetDRY(d DRY) {
+	s.dry = d
+}
+
+// SetXTC sets the XTC sampler, which applies after min-p.
+func (s *Sampler) SetXTC(x XTC) {
+	s.xtc = x
+}
+
+// SetMirostat enables Mirostat 2.0 sampling.
+func (s *Sampler) SetMirostat(m Mirostat) {
+	s.mirostat = &m
+	s.mu = 2 * m.Tau
 }
 
 func (s *Sampler) Sample(logits []float32) (int32, error) {
-	tokens := make([]token, len(logits))
-	for i := range logits {
-		tokens[i].id = int32(i)
-		tokens[i].value = logits[i]
-	}
+	tokens := s.tokens(logits)
 
 	t, err := s.sample(tokens)
 	if err != nil {
@@ -43,28 +104,60 @@ func (s *Sampler) Sample(logits []float32) (int32, error) {
 		top := []token{t}
 		s.grammar.Apply(top)
 		if !math.IsInf(float64(top[0].value), -1) {
-			s.grammar.Accept(top[0].id)
+			if err := s.grammar.Accept(top[0].id); err != nil {
+				return -1, err
+			}
+			s.accept(top[0].id)
 			return top[0].id, nil
 		}
 
 		// since .sample has side effects of modifying the tokens
 		// we need to reset them before applying the grammar and
 		// sampling again
-		for i := range logits {
-			tokens[i].id = int32(i)
-			tokens[i].value = logits[i]
-		}
+		tokens = s.tokens(logits)
 		s.grammar.Apply(tokens)
 		t, err = s.sample(tokens)
 		if err != nil {
 			return -1, err
 		}
-		s.grammar.Accept(t.id)
+		if err := s.grammar.Accept(t.id); err != nil {
+			return -1, err
+		}
 	}
 
+	s.accept(t.id)
 	return t.id, nil
 }
 
+// tokens returns the tokens of logits, penalized for repeating the tokens
+// sampled before.
+func (s *Sampler) tokens(logits []float32) []token {
+	tokens := make([]token, len(logits))
+	for i := range logits {
+		tokens[i].id = int32(i)
+		tokens[i].value = logits[i]
+	}
+
+	if s.dry.Multiplier > 0 && s.mirostat == nil {
+		dry(tokens, s.history, s.dry)
+	}
+
+	return tokens
+}
+
+// accept updates the state of the samplers which depend on the tokens
+// sampled before.
+func (s *Sampler) accept(id int32) {
+	if s.dry.Multiplier > 0 {
+		s.history = append(s.history, id)
+	}
+
+	if s.mirostat != nil && s.temperature != 0 {
+		surprise := float32(-math.Log2(float64(s.prob)))
+		s.mu -= s.mirostat.Eta * (surprise - s.mirostat.Tau)
+	}
+}
+
 // greedy returns the highest probability token from the tokens
 func greedy(tokens []token) token {
 	max := tokens[0]
@@ -84,15 +177,29 @@ func (s *Sampler) sample(tokens []token) (token, error) {
 		return greedy(tokens), nil
 	}
 
-	// topK also sorts the tokens in descending order of logits
-	tokens = topK(tokens, s.topK)
+	if s.mirostat != nil {
+		// sort the tokens in descending order of logits
+		tokens = topK(tokens, 0)
+
+		temperature(tokens, s.temperature)
+		softmax(tokens)
+
+		tokens = mirostat(tokens, s.mu)
+	} else {
+		// topK also sorts the tokens in descending order of logits
+		tokens = topK(tokens, s.topK)
+
+		// scale and normalize the tokens in place
+		temperature(tokens, s.temperature)
+		softmax(tokens)
 
-	// scale and normalize the tokens in place
-	temperature(tokens, s.temperature)
-	softmax(tokens)
+		tokens = topP(tokens, s.topP)
+		tokens = minP(tokens, s.minP)
 
-	tokens = topP(tokens, s.topP)
-	tokens = minP(tokens, s.minP)
+		if s.xtc.Probability > 0 && s.float32() < s.xtc.Probability {
+			tokens = xtc(tokens, s.xtc.Threshold)
+		}
+	}
 
 	// TODO: this should fall back to greedy sampling
 	// or topP, topK values etc should be such that
@@ -101,12 +208,7 @@ func (s *Sampler) sample(tokens []token) (token, error) {
 		return token{}, errors.New("no tokens to sample from")
 	}
 
-	var r float32
-	if s.rng != nil {
-		r = s.rng.Float32()
-	} else {
-		r = rand.Float32()
-	}
+	r := s.float32()
 
 	// Calculate cumulative sum of probabilities
 	var sum float32
@@ -123,9 +225,23 @@ func (s *Sampler) sample(tokens []token) (token, error) {
 		return 1
 	})
 
+	s.prob = tokens[idx].value
+	if idx > 0 {
+		s.prob -= tokens[idx-1].value
+	}
+	s.prob /= tokens[len(tokens)-1].value
+
 	return tokens[idx], nil
 }
 
+func (s *Sampler) float32() float32 {
+	if s.rng != nil {
+		return s.rng.Float32()
+	}
+
+	return rand.Float32()
+}
+
 // TODO(parthsareen): update sampler interface to use json unmarshal https://github.com/ollama/ollama/issues/9278
 func NewSampler(temperature float32, topK int, topP float32, minP float32, seed int, grammar *Grammar) Sampler {
 	var rng *rand.Rand
@@ -164,63 +280,85 @@ func NewSampler(temperature float32, topK int, topP float32, minP float32, seed
 	}
 }
 
+// Grammar constrains the tokens sampled to those allowed by a grammar
 type Grammar struct {
-	vocab   *Vocab
-	grammar string
-	sampler *llama.Sampler
+	matcher *grammar.Matcher
+	size    int
+	mask    []bool
 }
 
-func NewGrammar(vocab *Vocab, grammar string) (*Grammar, error) {
-	v, err := vocab.Load()
+func NewGrammar(vocab *Vocab, gbnf string) (*Grammar, error) {
+	g, err := grammar.Parse(gbnf)
 	if err != nil {
 		return nil, err
 	}
 
+	v := vocab.Load()
 	return &Grammar{
-		vocab:   vocab,
-		grammar: grammar,
-		sampler: llama.NewGrammarSampler(v, grammar),
+		matcher: grammar.NewMatcher(g, v),
+		size:    v.Size(),
 	}, nil
 }
 
+// Apply sets the logits of the tokens which the grammar doesn't allow next
+// to -Inf.
 func (g *Grammar) Apply(tokens []token) {
-	tds := make([]llama.TokenData, len(tokens))
-	for i, token := range tokens {
-		tds[i].Id = token.id
-		tds[i].Logit = token.value
+	if len(tokens) == 1 {
+		// checking a single token is much faster than masking them all
+		if !g.matcher.Allowed(tokens[0].id) {
+			tokens[0].value = float32(math.Inf(-1))
+		}
+		return
 	}
 
-	g.sampler.Apply(tds)
+	if g.mask == nil {
+		g.mask = make([]bool, g.size)
+	}
 
+	g.matcher.Mask(g.mask)
 	for i := range tokens {
-		tokens[i].value = tds[i].Logit
+		if int(tokens[i].id) >= len(g.mask) || !g.mask[tokens[i].id] {
+			tokens[i].value = float32(math.Inf(-1))
+		}
 	}
 }
 
-func (g *Grammar) Accept(token int32) {
-	g.sampler.Accept(token)
+func (g *Grammar) Accept(token int32) error {
+	return g.matcher.Accept(token)
 }
 
 type Vocab struct {
 	once  sync.Once
-	vocab *llama.Vocab
-	err   error
-	path  string
+	vocab *grammar.Vocabulary
+	tp    model.TextProcessor
+	size  int
 }
 
-func NewVocab(path string) *Vocab {
-	return &Vocab{path: path}
+// NewVocab returns the vocabulary of the first size tokens of tp, which is
+// built when it's first loaded.
+func NewVocab(tp model.TextProcessor, size int) *Vocab {
+	return &Vocab{tp: tp, size: size}
 }
 
-// Load returns the lazily-loaded vocabulary
-func (v *Vocab) Load() (*llama.Vocab, error) {
+// Load returns the lazily-built vocabulary
+func (v *Vocab) Load() *grammar.Vocabulary {
 	v.once.Do(func() {
-		vocab, err := llama.LoadVocabFromFile(v.path)
-		if err != nil {
-			v.err = err
-			return
+		pieces := make([]string, v.size)
+		var eog []int32
+		for id := range int32(v.size) {
+			switch {
+			case v.tp.Is(id, model.SpecialEOS):
+				eog = append(eog, id)
+			case v.tp.Is(id, model.SpecialControl):
+				// control tokens aren't text, so never match
+			default:
+				// tokens which can't be decoded are left empty, so they
+				// aren't allowed either
+				pieces[id], _ = v.tp.Decode([]int32{id})
+			}
 		}
-		v.vocab = vocab
+
+		v.vocab = grammar.NewVocabulary(pieces, eog)
 	})
-	return v.vocab, v.err
+	return v.vocab
 }
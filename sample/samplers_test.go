@@ -2,7 +2,11 @@ package sample
 
 import (
 	"math/rand/v2"
+	"slices"
+	"strings"
 	"testing"
+
+	"github.com/ollama/ollama/model"
 )
 
 func TestWeighted(t *testing.T) {
@@ -31,6 +35,98 @@ func TestWeighted(t *testing.T) {
 	}
 }
 
+func TestSamplerDRY(t *testing.T) {
+	sampler := NewSampler(0, 0, 0, 0, 0, nil)
+	sampler.SetDRY(DRY{Multiplier: 10, Base: 1.75, AllowedLength: 1, LastN: -1})
+
+	// token 1 is most likely unless it repeats 0 1
+	logits := []float32{2, 3, 1}
+	var got []int32
+	for _, next := range []float32{5, 0, 5, 0} {
+		logits[0] = next
+		id, err := sampler.Sample(logits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, id)
+	}
+
+	if want := []int32{0, 1, 0, 2}; !slices.Equal(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestSamplerMirostat(t *testing.T) {
+	sampler := NewSampler(1, 0, 0, 0, 42, nil)
+	sampler.SetMirostat(Mirostat{Tau: 0.5, Eta: 0.5})
+
+	// the surprise of every token is more than 2 * tau, so only the most
+	// likely is kept
+	logits := []float32{1, 0.9, 0.8, 0.7}
+	id, err := sampler.Sample(logits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != 0 {
+		t.Errorf("want 0, got %d", id)
+	}
+
+	// the token was certain so the most surprise allowed increases
+	if want := float32(1.25); sampler.mu != want {
+		t.Errorf("want mu %f, got %f", want, sampler.mu)
+	}
+}
+
+// pieces is a TextProcessor of tokens which are the strings in it, where the
+// first token ends generation and the second is a control token
+type pieces []string
+
+func (p pieces) Encode(string, bool) ([]int32, error) {
+	return nil, nil
+}
+
+func (p pieces) Decode(ids []int32) (string, error) {
+	var sb strings.Builder
+	for _, id := range ids {
+		sb.WriteString(p[id])
+	}
+	return sb.String(), nil
+}
+
+func (p pieces) Is(id int32, special model.Special) bool {
+	return special == model.SpecialEOS && id == 0 || special == model.SpecialControl && id == 1
+}
+
+func TestSamplerGrammar(t *testing.T) {
+	vocab := pieces{"</s>", "<|im_end|>", "yes", "no", "maybe", "y", "es"}
+	grammar, err := NewGrammar(NewVocab(vocab, len(vocab)), `root ::= "yes" | "no"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sampler := NewSampler(0, 0, 0, 0, 0, grammar)
+
+	// maybe and the control token are most likely, but not allowed, and
+	// generation can only end once the text matches
+	var got []int32
+	for _, logits := range [][]float32{
+		{5, 9, 0, 0, 10, 1, 0},
+		{0, 9, 0, 0, 10, 0, 1},
+		{1, 9, 0, 0, 10, 0, 0},
+	} {
+		id, err := sampler.Sample(logits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, id)
+	}
+
+	if want := []int32{5, 6, 0}; !slices.Equal(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
 func BenchmarkSample(b *testing.B) {
 	samplers := map[string]Sampler{
 		"Greedy":   NewSampler(0, 0, 0, 0, 0, nil), // Use NewSampler with temp=0 for greedy
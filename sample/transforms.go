@@ -114,6 +114,75 @@ func topP(ts []token, p float32) []token {
 	return ts
 }
 
+// xtc removes the tokens with probabilities >= threshold except the least
+// likely of them, if there are at least two
+// requires ts to be sorted in descending order of probabilities
+func xtc(ts []token, threshold float32) []token {
+	i := 0
+	for i < len(ts) && ts[i].value >= threshold {
+		i++
+	}
+
+	if i < 2 {
+		return ts
+	}
+
+	return ts[i-1:]
+}
+
+// mirostat filters tokens with surprise, -log2(prob), <= mu, keeping at
+// least the most likely token
+// requires ts to be sorted in descending order of probabilities
+func mirostat(ts []token, mu float32) []token {
+	for i := 1; i < len(ts); i++ {
+		if -math.Log2(float64(ts[i].value)) > float64(mu) {
+			return ts[:i]
+		}
+	}
+
+	return ts
+}
+
+// dry penalizes the tokens which would extend a repetition of at least
+// d.AllowedLength tokens at the end of history by
+// d.Multiplier * d.Base^(length - d.AllowedLength)
+// requires ts to be indexed by token id
+func dry(ts []token, history []int32, d DRY) {
+	switch {
+	case d.LastN == 0:
+		return
+	case d.LastN > 0:
+		history = history[max(0, len(history)-d.LastN):]
+	}
+
+	last := len(history) - 1
+
+	// lengths are the longest repetitions which each token would extend
+	lengths := make(map[int32]int)
+	for i := last - 1; i >= 0; i-- {
+		next := history[i+1]
+		if slices.Contains(d.Breakers, next) {
+			continue
+		}
+
+		// the length of the sequence ending at i which also ends history
+		n := 0
+		for n <= i && history[i-n] == history[last-n] && !slices.Contains(d.Breakers, history[last-n]) {
+			n++
+		}
+
+		if n > lengths[next] {
+			lengths[next] = n
+		}
+	}
+
+	for id, n := range lengths {
+		if n >= d.AllowedLength && int(id) < len(ts) {
+			ts[id].value -= d.Multiplier * float32(math.Pow(float64(d.Base), float64(n-d.AllowedLength)))
+		}
+	}
+}
+
 // minP filters tokens with probabilities >= p * max_prob
 // requires ts to be sorted in descending order of probabilities
 func minP(ts []token, p float32) []token {
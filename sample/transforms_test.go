@@ -234,6 +234,55 @@ func TestMinP(t *testing.T) {
 	}
 }
 
+func TestXTC(t *testing.T) {
+	input := []float32{0.5, 0.3, 0.15, 0.05}
+
+	tokens := xtc(toTokens(input), 0.1)
+	compareLogits(t, "xtc(0.1)", []float32{0.15, 0.05}, tokens)
+
+	tokens = xtc(toTokens(input), 0.4)
+	compareLogits(t, "xtc(0.4)", input, tokens)
+
+	tokens = xtc(toTokens(input), 0.6)
+	compareLogits(t, "xtc(0.6)", input, tokens)
+}
+
+func TestMirostat(t *testing.T) {
+	input := []float32{0.5, 0.25, 0.125, 0.125}
+
+	// surprises are 1, 2, 3 and 3
+	tokens := mirostat(toTokens(input), 2.5)
+	compareLogits(t, "mirostat(2.5)", []float32{0.5, 0.25}, tokens)
+
+	tokens = mirostat(toTokens(input), 0)
+	compareLogits(t, "mirostat(0)", []float32{0.5}, tokens)
+
+	tokens = mirostat(toTokens(input), 3)
+	compareLogits(t, "mirostat(3)", input, tokens)
+}
+
+func TestDRY(t *testing.T) {
+	d := DRY{Multiplier: 1, Base: 2, AllowedLength: 2, LastN: -1}
+
+	// 1 2 3 4 ... 1 2 3: 4 would extend a repetition of 3 tokens, 2 one of 1
+	history := []int32{1, 2, 3, 4, 5, 3, 2, 1, 2, 3}
+	tokens := toTokens(make([]float32, 6))
+	dry(tokens, history, d)
+	compareLogits(t, "dry", []float32{0, 0, 0, 0, -2, 0}, tokens)
+
+	// 3 is a breaker so the repetition is too short
+	d.Breakers = []int32{3}
+	tokens = toTokens(make([]float32, 6))
+	dry(tokens, history, d)
+	compareLogits(t, "dry with breakers", make([]float32, 6), tokens)
+
+	// the repetition is before the last 5 tokens
+	d.Breakers, d.LastN = nil, 5
+	tokens = toTokens(make([]float32, 6))
+	dry(tokens, history, d)
+	compareLogits(t, "dry with last n", make([]float32, 6), tokens)
+}
+
 func TestSortLogits(t *testing.T) {
 	input := []float32{0.026986899, 0.043722924, 0.036774673, 0.27755088, 0.0046718004, 0.08582123, 0.20409796, 0.00412893, 0.15720603, 0.045046154, 0.0030491839, 0.01681367}
 	tokens := toTokens(input)
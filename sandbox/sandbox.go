@@ -0,0 +1,135 @@
+// Package sandbox confines runner subprocesses, so that a model file which
+// exploits a bug in a decoder can't reach the network, or files other than
+// the model and a temporary directory.
+//
+// The server creates a [Sandbox] with the [Policy] of a runner, configures
+// the runner's command with it before starting it and confines the process
+// after. The runner calls [Enter] before doing anything else, which applies
+// the policy to itself where that can only be done from inside the process.
+//
+// On Linux the runner restricts the files it can open with Landlock and the
+// system calls it can make with a seccomp filter, and can change to an
+// AppArmor profile. On Windows the runner is put in a job object which stops
+// it from starting processes or using the desktop. Other platforms aren't
+// confined.
+package sandbox
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// policyEnv is the environment variable a Policy is passed to the runner in.
+const policyEnv = "OLLAMA_SANDBOX_POLICY"
+
+// Policy is what a sandboxed process may access.
+type Policy struct {
+	// Read are the files and directories the process may read and execute.
+	Read []string `json:"read,omitempty"`
+
+	// Write are the files and directories the process may read, write,
+	// create and remove.
+	Write []string `json:"write,omitempty"`
+
+	// Port is the TCP port the process may listen on. The process can't
+	// make network connections at all.
+	Port int `json:"port,omitempty"`
+
+	// AppArmor is the AppArmor profile the process changes to, if it isn't
+	// empty.
+	AppArmor string `json:"apparmor,omitempty"`
+}
+
+// Sandbox confines a process to a Policy. Each process has a temporary
+// directory of its own, which is the only directory it may write to other
+// than those in the policy.
+type Sandbox struct {
+	Policy
+
+	// Dir is the temporary directory of the process.
+	Dir string
+
+	platform
+}
+
+// New returns a Sandbox for a process with the policy p and a new temporary
+// directory.
+func New(p Policy) (*Sandbox, error) {
+	dir, err := os.MkdirTemp("", "ollama-runner")
+	if err != nil {
+		return nil, err
+	}
+
+	p.Write = append(slices.Clone(p.Write), dir)
+	return &Sandbox{Policy: p, Dir: dir}, nil
+}
+
+// Configure sets up cmd, which must not have been started, to run in the
+// sandbox. The executable of cmd may be read.
+func (s *Sandbox) Configure(cmd *exec.Cmd) error {
+	p := s.Policy
+	p.Read = append(slices.Clone(p.Read), cmd.Path)
+	if exe, err := filepath.EvalSymlinks(cmd.Path); err == nil && exe != cmd.Path {
+		p.Read = append(p.Read, exe)
+	}
+
+	bts, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+
+	cmd.Env = slices.DeleteFunc(cmd.Env, func(kv string) bool {
+		for _, k := range []string{policyEnv, "TMPDIR", "TMP", "TEMP"} {
+			if len(kv) > len(k) && kv[len(k)] == '=' && strings.EqualFold(kv[:len(k)], k) {
+				return true
+			}
+		}
+		return false
+	})
+
+	cmd.Env = append(cmd.Env,
+		policyEnv+"="+string(bts),
+		"TMPDIR="+s.Dir,
+		"TMP="+s.Dir,
+		"TEMP="+s.Dir,
+	)
+	return nil
+}
+
+// Confine confines the started process p to the sandbox, where that's done
+// from outside the process.
+func (s *Sandbox) Confine(p *os.Process) error {
+	return s.confine(p)
+}
+
+// Close releases the sandbox and removes its temporary directory. The process
+// should have exited.
+func (s *Sandbox) Close() error {
+	s.release()
+	return os.RemoveAll(s.Dir)
+}
+
+// Enter applies the policy the process was started with by a Sandbox, if it
+// was, to the process. It's called first thing by runners, and may start the
+// executable again in place of the process.
+func Enter() error {
+	s, ok := os.LookupEnv(policyEnv)
+	if !ok {
+		return enter(nil)
+	}
+
+	var p Policy
+	if err := json.Unmarshal([]byte(s), &p); err != nil {
+		return err
+	}
+
+	return enter(&p)
+}
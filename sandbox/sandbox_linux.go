@@ -0,0 +1,347 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// confinedEnv is set by a process which has restricted the files it can open
+// and started its executable again, to apply the rest of its policy.
+const confinedEnv = "OLLAMA_SANDBOX_CONFINED"
+
+var (
+	// systemRead are where runners read shared libraries, GPU driver files
+	// and information about the system from.
+	systemRead = []string{
+		"/usr", "/lib", "/lib32", "/lib64", "/opt", "/etc", "/sys",
+		"/proc/cpuinfo", "/proc/meminfo", "/proc/stat", "/proc/modules", "/proc/driver", "/proc/sys",
+	}
+
+	// systemWrite are where runners open GPU devices and shared memory.
+	systemWrite = []string{"/dev"}
+)
+
+type platform struct{}
+
+func (s *Sandbox) confine(*os.Process) error { return nil }
+
+func (s *Sandbox) release() {}
+
+func enter(p *Policy) error {
+	if p != nil {
+		return restrict(p)
+	}
+
+	if _, ok := os.LookupEnv(confinedEnv); ok {
+		os.Unsetenv(confinedEnv)
+		return filter()
+	}
+
+	return nil
+}
+
+// restrict restricts the files the process can open to those of the policy
+// p and starts the executable again in its place. Landlock only restricts
+// the thread which asks it to, and the other threads of a Go program can't
+// be made to, so the restrictions apply to the whole process once the
+// thread replaces it.
+func restrict(p *Policy) error {
+	// the thread is never unlocked, as it replaces the process
+	runtime.LockOSThread()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("sandbox: no_new_privs: %w", err)
+	}
+
+	if p.AppArmor != "" {
+		if err := os.WriteFile("/proc/thread-self/attr/exec", []byte("exec "+p.AppArmor), 0); err != nil {
+			return fmt.Errorf("sandbox: apparmor profile %s: %w", p.AppArmor, err)
+		}
+	}
+
+	if err := landlock(p); errors.Is(err, errors.ErrUnsupported) {
+		slog.Warn("sandbox: landlock isn't supported by the kernel, files the runner can open aren't restricted")
+	} else if err != nil {
+		return fmt.Errorf("sandbox: landlock: %w", err)
+	}
+
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, policyEnv+"=")
+	})
+
+	return syscall.Exec(exe, os.Args, append(env, confinedEnv+"=1"))
+}
+
+const (
+	landlockRead = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+	landlockWrite = landlockRead |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+
+	// landlockFile are the rights which apply to files, as opposed to
+	// directories
+	landlockFile = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_TRUNCATE |
+		unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+
+	landlockRuleNetPort = 2
+)
+
+type landlockNetPortAttr struct {
+	allowedAccess uint64
+	port          uint64
+}
+
+// landlockABI returns the version of Landlock the kernel supports.
+func landlockABI() (int, error) {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno == unix.ENOSYS || errno == unix.EOPNOTSUPP {
+		return 0, errors.ErrUnsupported
+	} else if errno != 0 {
+		return 0, errno
+	}
+
+	return int(abi), nil
+}
+
+// landlock restricts the files the thread can open, and the TCP ports it can
+// connect to and listen on if the kernel supports it, to those of the policy
+// p. Rights which the kernel doesn't know of aren't restricted.
+func landlock(p *Policy) error {
+	abi, err := landlockABI()
+	if err != nil {
+		return err
+	}
+
+	read, write := uint64(landlockRead), uint64(landlockWrite)
+	if abi >= 2 {
+		write |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		write |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	if abi >= 5 {
+		write |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: write}
+	if abi >= 4 {
+		attr.Access_net = unix.LANDLOCK_ACCESS_NET_BIND_TCP | unix.LANDLOCK_ACCESS_NET_CONNECT_TCP
+	}
+	if abi >= 6 {
+		attr.Scoped = unix.LANDLOCK_SCOPE_ABSTRACT_UNIX_SOCKET | unix.LANDLOCK_SCOPE_SIGNAL
+	}
+
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return errno
+	}
+	defer unix.Close(int(fd))
+
+	// a process can read its own files in /proc, whose process ID stays the
+	// same when it starts its executable again
+	reads := append(slices.Concat(systemRead, p.Read), "/proc/"+strconv.Itoa(os.Getpid()))
+	for _, path := range reads {
+		if err := landlockPath(int(fd), path, read); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range slices.Concat(systemWrite, p.Write) {
+		if err := landlockPath(int(fd), path, write); err != nil {
+			return err
+		}
+	}
+
+	if abi >= 4 && p.Port != 0 {
+		rule := landlockNetPortAttr{allowedAccess: unix.LANDLOCK_ACCESS_NET_BIND_TCP, port: uint64(p.Port)}
+		if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, fd, landlockRuleNetPort, uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+			return fmt.Errorf("port %d: %w", p.Port, errno)
+		}
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// landlockPath allows access to path, and what's beneath it if it's a
+// directory. Paths which don't exist are left out.
+func landlockPath(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFile
+	}
+
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("%s: %w", path, errno)
+	}
+
+	return nil
+}
+
+// auditArch are the architectures system calls are filtered on.
+var auditArch = map[string]uint32{
+	"amd64": unix.AUDIT_ARCH_X86_64,
+	"arm64": unix.AUDIT_ARCH_AARCH64,
+}
+
+// filter installs a seccomp filter on every thread of the process, which
+// stops it from making network connections, sockets other than Unix, netlink
+// and TCP ones, starting programs, reading or writing the memory of other
+// processes, and using io_uring, whose operations aren't filtered.
+func filter() error {
+	arch, ok := auditArch[runtime.GOARCH]
+	if !ok {
+		slog.Warn("sandbox: system calls aren't filtered on this architecture", "arch", runtime.GOARCH)
+		return nil
+	}
+
+	insns := filterProgram(arch)
+	prog := unix.SockFprog{Len: uint16(len(insns)), Filter: &insns[0]}
+
+	// no_new_privs was set before the executable started again, and is
+	// kept by the threads it starts
+	if tid, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return fmt.Errorf("sandbox: seccomp: %w", errno)
+	} else if tid != 0 {
+		return fmt.Errorf("sandbox: seccomp: thread %d can't be filtered", tid)
+	}
+
+	return nil
+}
+
+// offsets of the fields of struct seccomp_data
+const (
+	seccompNr   = 0
+	seccompArch = 4
+	seccompArgs = 16
+)
+
+func filterProgram(arch uint32) []unix.SockFilter {
+	load := func(offset uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offset}
+	}
+
+	// arg loads the low 32 bits of argument n of the system call
+	arg := func(n uint32) unix.SockFilter {
+		return load(seccompArgs + 8*n)
+	}
+
+	jump := func(op uint16, k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_JMP | op | unix.BPF_K, K: k, Jt: jt, Jf: jf}
+	}
+
+	ret := func(action uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: action}
+	}
+
+	allow := ret(unix.SECCOMP_RET_ALLOW)
+	fail := func(errno unix.Errno) unix.SockFilter {
+		return ret(unix.SECCOMP_RET_ERRNO | uint32(errno)&unix.SECCOMP_RET_DATA)
+	}
+
+	// on runs then, which must return, if the system call is nr, and
+	// skips it otherwise
+	on := func(nr uintptr, then ...unix.SockFilter) []unix.SockFilter {
+		return append([]unix.SockFilter{jump(unix.BPF_JEQ, uint32(nr), 0, uint8(len(then)))}, then...)
+	}
+
+	// fastOpen fails sends with the MSG_FASTOPEN flag in argument n, which
+	// connect a TCP socket
+	fastOpen := func(n uint32) []unix.SockFilter {
+		return []unix.SockFilter{
+			arg(n),
+			jump(unix.BPF_JSET, unix.MSG_FASTOPEN, 0, 1),
+			fail(unix.EPERM),
+			allow,
+		}
+	}
+
+	prog := []unix.SockFilter{
+		load(seccompArch),
+		jump(unix.BPF_JEQ, arch, 1, 0),
+		ret(unix.SECCOMP_RET_KILL_PROCESS),
+		load(seccompNr),
+	}
+
+	if arch == unix.AUDIT_ARCH_X86_64 {
+		// x32 system calls have their own numbers
+		prog = append(prog, jump(unix.BPF_JGE, 0x40000000, 0, 1), fail(unix.ENOSYS))
+	}
+
+	for _, nr := range []uintptr{unix.SYS_CONNECT, unix.SYS_EXECVE, unix.SYS_EXECVEAT, unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV} {
+		prog = append(prog, on(nr, fail(unix.EPERM))...)
+	}
+
+	for _, nr := range []uintptr{unix.SYS_IO_URING_SETUP, unix.SYS_IO_URING_ENTER, unix.SYS_IO_URING_REGISTER} {
+		prog = append(prog, on(nr, fail(unix.ENOSYS))...)
+	}
+
+	prog = append(prog, on(unix.SYS_SOCKET,
+		arg(0),
+		jump(unix.BPF_JEQ, unix.AF_UNIX, 11, 0),
+		jump(unix.BPF_JEQ, unix.AF_NETLINK, 10, 0),
+		jump(unix.BPF_JEQ, unix.AF_INET, 2, 0),
+		jump(unix.BPF_JEQ, unix.AF_INET6, 1, 0),
+		fail(unix.EAFNOSUPPORT),
+		// only TCP sockets of the internet families
+		arg(1),
+		unix.SockFilter{Code: unix.BPF_ALU | unix.BPF_AND | unix.BPF_K, K: 0xf},
+		jump(unix.BPF_JEQ, unix.SOCK_STREAM, 0, 3),
+		arg(2),
+		jump(unix.BPF_JEQ, 0, 2, 0),
+		jump(unix.BPF_JEQ, unix.IPPROTO_TCP, 1, 0),
+		fail(unix.EPERM),
+		allow,
+	)...)
+
+	prog = append(prog, on(unix.SYS_SENDTO, fastOpen(3)...)...)
+	prog = append(prog, on(unix.SYS_SENDMSG, fastOpen(2)...)...)
+	prog = append(prog, on(unix.SYS_SENDMMSG, fastOpen(3)...)...)
+
+	return append(prog, allow)
+}
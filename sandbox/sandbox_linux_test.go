@@ -0,0 +1,153 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sys/unix"
+)
+
+// TestMain runs the test binary as a sandboxed runner when it's started by a
+// test, which tries what the sandbox allows and what it doesn't.
+func TestMain(m *testing.M) {
+	if os.Getenv("OLLAMA_SANDBOX_TEST") == "1" {
+		if err := Enter(); err != nil {
+			panic(err)
+		}
+
+		json.NewEncoder(os.Stdout).Encode(try())
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// try reports whether the sandboxed process could do each of the things it
+// tries.
+func try() map[string]bool {
+	results := make(map[string]bool)
+
+	_, err := os.ReadFile(os.Getenv("OLLAMA_SANDBOX_TEST_MODEL"))
+	results["read model"] = err == nil
+
+	_, err = os.ReadFile(os.Getenv("OLLAMA_SANDBOX_TEST_SECRET"))
+	results["read secret"] = err == nil
+
+	err = os.WriteFile(filepath.Join(os.TempDir(), "scratch"), []byte("scratch"), 0o600)
+	results["write temp"] = err == nil
+
+	err = os.WriteFile(os.Getenv("OLLAMA_SANDBOX_TEST_MODEL"), nil, 0o600)
+	results["write model"] = err == nil
+
+	l, err := net.Listen("tcp", "127.0.0.1:"+os.Getenv("OLLAMA_SANDBOX_TEST_PORT"))
+	if err == nil {
+		l.Close()
+	}
+	results["listen"] = err == nil
+
+	conn, err := net.Dial("tcp", os.Getenv("OLLAMA_SANDBOX_TEST_ADDR"))
+	if err == nil {
+		conn.Close()
+	}
+	results["connect"] = err == nil
+
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err == nil {
+		unix.Close(fd)
+	}
+	results["udp"] = err == nil
+
+	results["exec"] = exec.Command("/bin/true").Run() == nil
+	return results
+}
+
+func TestEnter(t *testing.T) {
+	if _, err := landlockABI(); errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("landlock isn't supported by the kernel")
+	}
+
+	if _, ok := auditArch[runtime.GOARCH]; !ok {
+		t.Skip("system calls aren't filtered on " + runtime.GOARCH)
+	}
+
+	models := t.TempDir()
+	model := filepath.Join(models, "sha256-model")
+	if err := os.WriteFile(model, []byte("GGUF"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	secret := filepath.Join(models, "id_ed25519")
+	if err := os.WriteFile(secret, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	a, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := a.Addr().(*net.TCPAddr).Port
+	a.Close()
+
+	s, err := New(Policy{Read: []string{model}, Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestEnter$")
+	cmd.Env = append(os.Environ(),
+		"OLLAMA_SANDBOX_TEST=1",
+		"OLLAMA_SANDBOX_TEST_MODEL="+model,
+		"OLLAMA_SANDBOX_TEST_SECRET="+secret,
+		"OLLAMA_SANDBOX_TEST_PORT="+strconv.Itoa(port),
+		"OLLAMA_SANDBOX_TEST_ADDR="+l.Addr().String(),
+	)
+	cmd.Stderr = os.Stderr
+	if err := s.Configure(cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(s.Dir); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the temporary directory to be removed, got %v", err)
+	}
+
+	var results map[string]bool
+	if err := json.Unmarshal(out, &results); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	if diff := cmp.Diff(results, map[string]bool{
+		"read model":  true,
+		"read secret": false,
+		"write temp":  true,
+		"write model": false,
+		"listen":      true,
+		"connect":     false,
+		"udp":         false,
+		"exec":        false,
+	}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+}
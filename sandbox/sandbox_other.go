@@ -0,0 +1,22 @@
+//go:build !linux && !windows
+
+package sandbox
+
+import (
+	"log/slog"
+	"os"
+	"runtime"
+)
+
+type platform struct{}
+
+func (s *Sandbox) confine(*os.Process) error {
+	slog.Warn("sandbox: runners aren't confined on this platform", "os", runtime.GOOS)
+	return nil
+}
+
+func (s *Sandbox) release() {}
+
+func enter(*Policy) error {
+	return nil
+}
@@ -0,0 +1,80 @@
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+type platform struct {
+	job windows.Handle
+}
+
+// confine puts the process p in a job object, which kills it if the server
+// exits, stops it from starting processes and from using the desktop, the
+// clipboard and the windows of other processes. The files a process on
+// Windows can open and its network access aren't restricted.
+func (s *Sandbox) confine(p *os.Process) error {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return fmt.Errorf("sandbox: job object: %w", err)
+	}
+
+	limits := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{
+		BasicLimitInformation: windows.JOBOBJECT_BASIC_LIMIT_INFORMATION{
+			LimitFlags: windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE |
+				windows.JOB_OBJECT_LIMIT_DIE_ON_UNHANDLED_EXCEPTION |
+				windows.JOB_OBJECT_LIMIT_ACTIVE_PROCESS,
+			ActiveProcessLimit: 1,
+		},
+	}
+
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&limits)), uint32(unsafe.Sizeof(limits))); err != nil {
+		windows.CloseHandle(job)
+		return fmt.Errorf("sandbox: job object limits: %w", err)
+	}
+
+	ui := windows.JOBOBJECT_BASIC_UI_RESTRICTIONS{
+		UIRestrictionsClass: windows.JOB_OBJECT_UILIMIT_DESKTOP |
+			windows.JOB_OBJECT_UILIMIT_DISPLAYSETTINGS |
+			windows.JOB_OBJECT_UILIMIT_EXITWINDOWS |
+			windows.JOB_OBJECT_UILIMIT_GLOBALATOMS |
+			windows.JOB_OBJECT_UILIMIT_HANDLES |
+			windows.JOB_OBJECT_UILIMIT_READCLIPBOARD |
+			windows.JOB_OBJECT_UILIMIT_SYSTEMPARAMETERS |
+			windows.JOB_OBJECT_UILIMIT_WRITECLIPBOARD,
+	}
+
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectBasicUIRestrictions, uintptr(unsafe.Pointer(&ui)), uint32(unsafe.Sizeof(ui))); err != nil {
+		windows.CloseHandle(job)
+		return fmt.Errorf("sandbox: job object ui restrictions: %w", err)
+	}
+
+	process, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(p.Pid))
+	if err != nil {
+		windows.CloseHandle(job)
+		return fmt.Errorf("sandbox: %w", err)
+	}
+	defer windows.CloseHandle(process)
+
+	if err := windows.AssignProcessToJobObject(job, process); err != nil {
+		windows.CloseHandle(job)
+		return fmt.Errorf("sandbox: job object: %w", err)
+	}
+
+	s.job = job
+	return nil
+}
+
+func (s *Sandbox) release() {
+	if s.job != 0 {
+		windows.CloseHandle(s.job)
+		s.job = 0
+	}
+}
+
+func enter(*Policy) error {
+	return nil
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+func TestAdapters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	s := Server{sched: &Scheduler{loaded: make(map[string]*runnerRef)}}
+
+	create := func(req api.CreateRequest) {
+		t.Helper()
+
+		req.Stream = &stream
+		if w := createRequest(t, s.CreateHandler, req); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+		}
+	}
+
+	_, base := createBinFile(t, ggml.KV{"general.architecture": "llama"}, nil)
+	_, other := createBinFile(t, ggml.KV{"general.architecture": "llama", "general.name": "other"}, nil)
+	_, first := createBinFile(t, ggml.KV{"general.architecture": "llama", "general.type": "adapter"}, nil)
+	_, second := createBinFile(t, ggml.KV{"general.architecture": "llama", "general.type": "adapter", "general.name": "second"}, nil)
+
+	create(api.CreateRequest{Name: "base", Files: map[string]string{"base.gguf": base}})
+	create(api.CreateRequest{Name: "other", Files: map[string]string{"other.gguf": other}})
+	create(api.CreateRequest{Name: "first", From: "base", Adapters: map[string]string{"first.gguf": first}})
+	create(api.CreateRequest{Name: "second", From: "base", Adapters: map[string]string{"second.gguf": second}})
+	create(api.CreateRequest{Name: "other-first", From: "other", Adapters: map[string]string{"first.gguf": first}})
+
+	t.Run("with adapter", func(t *testing.T) {
+		m, err := GetModel("first")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := withAdapter(m, "Second")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s, err := GetModel("second")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(s.AdapterPaths, got.AdapterPaths); diff != "" {
+			t.Errorf("adapters mismatch (-want +got):\n%s", diff)
+		}
+
+		if len(m.AdapterPaths) != 1 || m.AdapterPaths[0] == got.AdapterPaths[0] {
+			t.Errorf("expected the model to keep its adapters, got %v", m.AdapterPaths)
+		}
+	})
+
+	for _, tt := range []struct {
+		name, model, adapter string
+	}{
+		{"not an adapter", "base", "other"},
+		{"different base model", "base", "other-first"},
+		{"missing", "base", "missing"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := GetModel(tt.model)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := withAdapter(m, tt.adapter); !errors.Is(err, errInvalidAdapter) {
+				t.Errorf("expected invalid adapter error, got %v", err)
+			}
+		})
+	}
+
+	t.Run("list", func(t *testing.T) {
+		m, err := GetModel("first")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s.sched.loaded[m.ModelPath] = &runnerRef{adapters: m.AdapterPaths}
+		t.Cleanup(func() { clear(s.sched.loaded) })
+
+		list := func(query string) []api.AdapterResponse {
+			t.Helper()
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/adapters"+query, nil)
+			s.AdaptersHandler(c)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+			}
+
+			var resp api.ListAdaptersResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			return resp.Adapters
+		}
+
+		if diff := cmp.Diff([]api.AdapterResponse{
+			{Name: "first:latest", Digest: first, Loaded: true},
+			{Name: "other-first:latest", Digest: first, Loaded: true},
+			{Name: "second:latest", Digest: second},
+		}, list("")); diff != "" {
+			t.Errorf("adapters mismatch (-want +got):\n%s", diff)
+		}
+
+		if diff := cmp.Diff([]api.AdapterResponse{
+			{Name: "first:latest", Digest: first, Loaded: true},
+			{Name: "second:latest", Digest: second},
+		}, list("?model=base")); diff != "" {
+			t.Errorf("adapters mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	for _, tt := range []struct {
+		name, query string
+		status      int
+		code        api.ErrorCode
+	}{
+		{"missing model", "?model=missing", http.StatusNotFound, api.ErrorCodeModelNotFound},
+		{"invalid model", "?model=" + url.QueryEscape("bad:name:"), http.StatusBadRequest, api.ErrorCodeInvalidModelName},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/api/adapters"+tt.query, nil)
+			s.AdaptersHandler(c)
+			if w.Code != tt.status {
+				t.Fatalf("expected status code %d, actual %d: %s", tt.status, w.Code, w.Body)
+			}
+
+			var resp api.StatusError
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.Code != tt.code {
+				t.Errorf("expected code %q, got %q", tt.code, resp.Code)
+			}
+		})
+	}
+}
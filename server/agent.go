@@ -0,0 +1,632 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/mcp"
+	"github.com/ollama/ollama/types/model"
+)
+
+const (
+	// defaultAgentSteps and maxAgentSteps bound how many times a model is
+	// run for a request to /api/agent.
+	defaultAgentSteps = 10
+	maxAgentSteps     = 50
+
+	// maxToolResult is the most of a tool's result a model is given, so a
+	// large result doesn't fill its context.
+	maxToolResult = 16 << 10
+
+	// runCommandTool is the name of the tool which runs allowed commands.
+	runCommandTool = "run_command"
+)
+
+var errAgentConfig = errors.New("invalid agent tools")
+
+// agentConfig is the OLLAMA_AGENT_TOOLS file. MCP servers are given in the
+// format of other MCP clients, alongside the HTTP and command tools:
+//
+//	{
+//	  "mcpServers": {"files": {"command": "mcp-files", "args": ["/data"]}},
+//	  "http": [{"name": "weather", "url": "https://example.com/weather", "parameters": {...}}],
+//	  "commands": {"allow": ["ls", "grep"], "dir": "/data"}
+//	}
+type agentConfig struct {
+	Servers  map[string]mcp.ServerConfig `json:"mcpServers,omitempty"`
+	HTTP     []httpToolConfig            `json:"http,omitempty"`
+	Commands *commandToolConfig          `json:"commands,omitempty"`
+}
+
+// httpToolConfig is a tool which requests a URL with the arguments of the
+// model, as the query of GET requests and a JSON body otherwise.
+type httpToolConfig struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	URL         string            `json:"url"`
+	Method      string            `json:"method,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+
+	// Parameters is the JSON schema of the tool's arguments.
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+
+	// Timeout is how long a request has to respond. Default is 30 seconds.
+	Timeout *api.Duration `json:"timeout,omitempty"`
+}
+
+// commandToolConfig is the run_command tool, which runs the commands in
+// Allow. Commands are run directly rather than by a shell, so their
+// arguments can't run other commands, and arguments can't be absolute
+// paths or leave Dir with "..". Otherwise a model can use all of what an
+// allowed command can do, such as find's -exec or -delete, so only
+// commands which are safe with any relative arguments should be allowed.
+type commandToolConfig struct {
+	Allow []string `json:"allow"`
+
+	// Dir is the directory commands are run in.
+	Dir string `json:"dir,omitempty"`
+
+	// Timeout is how long a command has to exit. Default is 10 seconds.
+	Timeout *api.Duration `json:"timeout,omitempty"`
+}
+
+// agentTools are the tools of the OLLAMA_AGENT_TOOLS file.
+type agentTools struct {
+	tools    api.Tools
+	http     map[string]httpToolConfig
+	commands *commandToolConfig
+	mcp      *mcp.Session
+}
+
+// loadedAgentTools caches the tools of the OLLAMA_AGENT_TOOLS file, whose
+// MCP servers are stopped when it changes.
+var loadedAgentTools struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	tools   *agentTools
+}
+
+func readAgentTools(ctx context.Context, path string) (*agentTools, error) {
+	loadedAgentTools.Lock()
+	defer loadedAgentTools.Unlock()
+
+	var modTime time.Time
+	if path != "" {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errAgentConfig, err)
+		}
+		modTime = fi.ModTime()
+	}
+
+	if loadedAgentTools.tools != nil && path == loadedAgentTools.path && modTime.Equal(loadedAgentTools.modTime) {
+		return loadedAgentTools.tools, nil
+	}
+
+	var config agentConfig
+	if path != "" {
+		bts, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errAgentConfig, err)
+		}
+
+		if err := json.Unmarshal(bts, &config); err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", errAgentConfig, path, err)
+		}
+	}
+
+	tools, err := newAgentTools(ctx, &config)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", errAgentConfig, path, err)
+	}
+
+	if old := loadedAgentTools.tools; old != nil && old.mcp != nil {
+		go old.mcp.Close()
+	}
+
+	loadedAgentTools.path, loadedAgentTools.modTime, loadedAgentTools.tools = path, modTime, tools
+	return tools, nil
+}
+
+func newAgentTools(ctx context.Context, config *agentConfig) (*agentTools, error) {
+	t := agentTools{http: make(map[string]httpToolConfig), commands: config.Commands}
+	add := func(tool api.Tool) error {
+		if slices.ContainsFunc(t.tools, func(u api.Tool) bool { return u.Function.Name == tool.Function.Name }) {
+			return fmt.Errorf("more than one tool is named %q", tool.Function.Name)
+		}
+		t.tools = append(t.tools, tool)
+		return nil
+	}
+
+	for i, h := range config.HTTP {
+		if h.Name == "" {
+			return nil, fmt.Errorf("http tool %d has no name", i)
+		}
+
+		u, err := url.Parse(h.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("http tool %q has an invalid url %q", h.Name, h.URL)
+		}
+
+		tool, err := mcp.APITool(h.Name, mcp.Tool{Name: h.Name, Description: h.Description, InputSchema: h.Parameters})
+		if err != nil {
+			return nil, fmt.Errorf("http tool %q: %w", h.Name, err)
+		}
+
+		if err := add(tool); err != nil {
+			return nil, err
+		}
+		t.http[h.Name] = h
+	}
+
+	if c := config.Commands; c != nil {
+		if len(c.Allow) == 0 {
+			return nil, errors.New("commands has no allowed commands")
+		}
+
+		tool, err := mcp.APITool(runCommandTool, mcp.Tool{
+			Description: "Run a command, one of: " + strings.Join(c.Allow, ", ") + ". Its output is returned.",
+			InputSchema: json.RawMessage(`{"type":"object","required":["command"],"properties":{"command":{"type":"string","description":"The command and its arguments, separated by spaces"}}}`),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := add(tool); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(config.Servers) > 0 {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		session, err := mcp.Connect(ctx, &mcp.Config{Servers: config.Servers})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tool := range session.Tools() {
+			if err := add(tool); err != nil {
+				session.Close()
+				return nil, err
+			}
+		}
+		t.mcp = session
+	}
+
+	return &t, nil
+}
+
+// call calls the tool of call, returning its result.
+func (t *agentTools) call(ctx context.Context, call api.ToolCall) (string, error) {
+	name := call.Function.Name
+	if h, ok := t.http[name]; ok {
+		return callHTTPTool(ctx, h, call.Function.Arguments)
+	}
+
+	if name == runCommandTool && t.commands != nil {
+		return runCommand(ctx, t.commands, call.Function.Arguments)
+	}
+
+	if t.mcp != nil && slices.ContainsFunc(t.mcp.Tools(), func(u api.Tool) bool { return u.Function.Name == name }) {
+		return t.mcp.Call(ctx, call)
+	}
+
+	return "", fmt.Errorf("unknown tool %q", name)
+}
+
+func callHTTPTool(ctx context.Context, config httpToolConfig, args api.ToolCallFunctionArguments) (string, error) {
+	timeout := 30 * time.Second
+	if config.Timeout != nil {
+		timeout = config.Timeout.Duration
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	method := strings.ToUpper(config.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return "", err
+	}
+
+	var body io.Reader
+	if method == http.MethodGet || method == http.MethodDelete {
+		q := u.Query()
+		for k, v := range args {
+			q.Set(k, fmt.Sprint(v))
+		}
+		u.RawQuery = q.Encode()
+	} else {
+		bts, err := json.Marshal(args)
+		if err != nil {
+			return "", err
+		}
+		body = bytes.NewReader(bts)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return "", err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range config.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	bts, err := io.ReadAll(io.LimitReader(resp.Body, maxToolResult+1))
+	if err != nil {
+		return "", err
+	}
+
+	result := truncateToolResult(string(bts))
+	if resp.StatusCode >= http.StatusBadRequest {
+		return "", fmt.Errorf("%s: %s", resp.Status, result)
+	}
+
+	return result, nil
+}
+
+// runCommand runs the command of args if it's allowed and none of its
+// arguments is a path outside of the command's directory. It's run with
+// only PATH and HOME from the server's environment, so it can't read
+// secrets the server is configured with.
+func runCommand(ctx context.Context, config *commandToolConfig, args api.ToolCallFunctionArguments) (string, error) {
+	var argv []string
+	switch v := args["command"].(type) {
+	case string:
+		argv = strings.Fields(v)
+	case []any:
+		for _, a := range v {
+			argv = append(argv, fmt.Sprint(a))
+		}
+	}
+
+	if len(argv) == 0 {
+		return "", errors.New("command is required")
+	}
+
+	if !slices.Contains(config.Allow, argv[0]) {
+		return "", fmt.Errorf("command %q is not allowed, allowed commands are: %s", argv[0], strings.Join(config.Allow, ", "))
+	}
+
+	for _, arg := range argv[1:] {
+		if !relativeArg(arg) {
+			return "", fmt.Errorf("argument %q is not allowed, commands can only be given paths within their directory", arg)
+		}
+	}
+
+	timeout := 10 * time.Second
+	if config.Timeout != nil {
+		timeout = config.Timeout.Duration
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = config.Dir
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + os.Getenv("HOME")}
+
+	out, err := cmd.CombinedOutput()
+	result := truncateToolResult(string(out))
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("command timed out after %s: %s", timeout, result)
+	} else if err != nil {
+		return "", fmt.Errorf("%w: %s", err, result)
+	}
+
+	return result, nil
+}
+
+// relativeArg reports whether arg, or the value of an arg such as
+// --file=path, can only be a path within the directory it's run in.
+func relativeArg(arg string) bool {
+	values := []string{arg}
+	if _, v, ok := strings.Cut(arg, "="); ok {
+		values = append(values, v)
+	}
+
+	for _, v := range values {
+		if filepath.IsAbs(v) || strings.HasPrefix(v, "~") || filepath.VolumeName(v) != "" {
+			return false
+		}
+
+		if slices.Contains(strings.FieldsFunc(v, func(r rune) bool { return r == '/' || r == filepath.Separator }), "..") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func truncateToolResult(s string) string {
+	if len(s) <= maxToolResult {
+		return s
+	}
+
+	return s[:maxToolResult] + "\n[truncated]"
+}
+
+// AgentToolsHandler lists the tools of the OLLAMA_AGENT_TOOLS file.
+func (s *Server) AgentToolsHandler(c *gin.Context) {
+	tools, err := readAgentTools(c.Request.Context(), envconfig.AgentTools())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := api.AgentToolsResponse{Tools: api.Tools{}}
+	resp.Tools = append(resp.Tools, tools.tools...)
+	c.JSON(http.StatusOK, resp)
+}
+
+// AgentHandler runs a model with the tools of the OLLAMA_AGENT_TOOLS file.
+// The model's tool calls are run and their results given back to it until
+// it answers without calling a tool, or it has run for the request's
+// steps. Each step is returned, so what the model did can be audited.
+func (s *Server) AgentHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+
+	var req api.AgentRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch {
+	case len(req.Messages) == 0:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "messages are required"})
+		return
+	case req.MaxSteps < 0 || req.MaxSteps > maxAgentSteps:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("max_steps must be between 0 and %d", maxAgentSteps)})
+		return
+	}
+
+	maxSteps := req.MaxSteps
+	if maxSteps == 0 {
+		maxSteps = defaultAgentSteps
+	}
+
+	all, err := readAgentTools(c.Request.Context(), envconfig.AgentTools())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	tools := all.tools
+	if len(req.Tools) > 0 {
+		tools = nil
+		for _, name := range req.Tools {
+			i := slices.IndexFunc(all.tools, func(t api.Tool) bool { return t.Function.Name == name })
+			if i < 0 {
+				c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeInvalidRequest, fmt.Sprintf("unknown tool %q", name)))
+				return
+			}
+			tools = append(tools, all.tools[i])
+		}
+	}
+
+	if len(tools) == 0 {
+		c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeInvalidRequest, "the server has no agent tools, set OLLAMA_AGENT_TOOLS to configure them"))
+		return
+	}
+
+	if req.Model == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		return
+	}
+
+	name, err = getExistingName(name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		return
+	}
+
+	name, _, err = resolveAlias(name)
+	if err != nil {
+		c.JSON(aliasErrorResponse(req.Model, err))
+		return
+	}
+
+	if _, ok := s.moderateRequest(c, req.Messages); !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	r, m, opts, err := s.scheduleRunner(ctx, name.String(), "", []Capability{CapabilityCompletion, CapabilityTools}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	checkpointLoaded := time.Now()
+
+	msgs := append(m.Messages, req.Messages...)
+	if req.Messages[0].Role != "system" && m.System != "" {
+		msgs = append([]api.Message{{Role: "system", Content: m.System}}, msgs...)
+	}
+
+	msgs, err = applySystemPolicy(m.Name, msgs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := api.AgentResponse{
+		Model:      req.Model,
+		RequestID:  c.GetString(requestIDKey),
+		Message:    api.Message{Role: "assistant"},
+		DoneReason: "max_steps",
+		Steps:      []api.AgentStep{},
+	}
+
+	for i := range maxSteps {
+		step, err := s.agentStep(ctx, r, m, opts, msgs, tools)
+		if err != nil {
+			h := streamError(err)
+			c.JSON(errorStatus(h), h)
+			return
+		}
+
+		addMetrics(&resp.Metrics, step.Metrics)
+
+		if len(step.Message.ToolCalls) == 0 {
+			resp.Steps = append(resp.Steps, *step)
+			resp.Message = step.Message
+			resp.DoneReason = "stop"
+			break
+		}
+
+		msgs = append(msgs, step.Message)
+		for j := range step.Message.ToolCalls {
+			call := &step.Message.ToolCalls[j]
+			if call.ID == "" {
+				call.ID = fmt.Sprintf("call_%d_%d", i, j)
+			}
+
+			start := time.Now()
+			var result string
+			if slices.ContainsFunc(tools, func(t api.Tool) bool { return t.Function.Name == call.Function.Name }) {
+				result, err = all.call(ctx, *call)
+			} else {
+				// the model may make up a tool, or call one it wasn't given
+				err = fmt.Errorf("unknown tool %q", call.Function.Name)
+			}
+			tr := api.AgentToolResult{
+				Name:      call.Function.Name,
+				Arguments: call.Function.Arguments,
+				Result:    result,
+				Duration:  time.Since(start),
+			}
+
+			content := result
+			if err != nil {
+				tr.Error = err.Error()
+				content = "Error: " + tr.Error
+			}
+
+			slog.Debug("agent tool call", "step", i, "tool", call.Function.Name, "duration", tr.Duration, "error", tr.Error)
+			step.ToolResults = append(step.ToolResults, tr)
+			msgs = append(msgs, api.Message{Role: "tool", Content: content, Name: call.Function.Name, ToolCallID: call.ID})
+		}
+
+		resp.Steps = append(resp.Steps, *step)
+		if ctx.Err() != nil {
+			c.JSON(499, errorResponse(api.ErrorCodeCanceled, "request canceled"))
+			return
+		}
+	}
+
+	resp.CreatedAt = time.Now().UTC()
+	resp.TotalDuration = time.Since(checkpointStart)
+	resp.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+	emitWebhook(api.WebhookEvent{
+		Event:     api.WebhookRequestCompleted,
+		Model:     req.Model,
+		Tenant:    c.GetString(tenantKey),
+		RequestID: resp.RequestID,
+		Metrics:   &resp.Metrics,
+	})
+	recordSplitMetrics(c, resp.Metrics)
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// agentStep runs the model once on msgs, returning its message, with the
+// tools it calls, if any.
+func (s *Server) agentStep(ctx context.Context, r llm.LlamaServer, m *Model, opts *api.Options, msgs []api.Message, tools api.Tools) (*api.AgentStep, error) {
+	prompt, images, err := chatPrompt(ctx, m, r.Tokenize, opts, msgs, tools, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var thinking *thinkingParser
+	if m.thinks() {
+		thinking = newThinkingParser(m, prompt)
+	}
+
+	var step api.AgentStep
+	var sb strings.Builder
+	if err := r.Completion(ctx, llm.CompletionRequest{
+		Prompt:   prompt,
+		Images:   images,
+		Options:  opts,
+		Adapters: m.AdapterPaths,
+	}, func(cr llm.CompletionResponse) {
+		content := cr.Content
+		if thinking != nil {
+			_, content = thinking.add(content)
+			if cr.Done {
+				_, rest := thinking.flush()
+				content += rest
+			}
+		}
+		sb.WriteString(content)
+
+		if cr.Done {
+			addCompletionMetrics(&step.Metrics, cr)
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	step.Message = api.Message{Role: "assistant", Content: sb.String()}
+	if toolCalls, ok := m.parseToolCalls(step.Message.Content); ok {
+		step.Message.ToolCalls = toolCalls
+		step.Message.Content = ""
+	}
+
+	return &step, nil
+}
+
+// addMetrics adds the counts and durations of b to a.
+func addMetrics(a *api.Metrics, b api.Metrics) {
+	a.PromptEvalCount += b.PromptEvalCount
+	a.PromptEvalDuration += b.PromptEvalDuration
+	a.EvalCount += b.EvalCount
+	a.EvalDuration += b.EvalDuration
+}
@@ -0,0 +1,297 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestAgent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var cloudy atomic.Bool
+	weather := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cloudy.Load() {
+			http.Error(w, "no weather today", http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprintf(w, "sunny in %s", r.URL.Query().Get("location"))
+	}))
+	defer weather.Close()
+
+	path := filepath.Join(t.TempDir(), "tools.json")
+	if err := os.WriteFile(path, []byte(`{"http": [{
+		"name": "get_weather",
+		"url": "`+weather.URL+`",
+		"parameters": {"type": "object", "properties": {"location": {"type": "string"}}}
+	}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OLLAMA_AGENT_TOOLS", path)
+
+	// the model calls get_weather until it's given the weather
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			content := `{"name":"get_weather","arguments":{"location":"Seattle"}}`
+			if strings.Contains(r.Prompt, "tool: sunny") {
+				content = "It's sunny."
+			}
+
+			fn(llm.CompletionResponse{Content: content, Done: true, DoneReason: "stop", PromptEvalCount: 1, EvalCount: 1})
+			return nil
+		},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(t.Context())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(1),
+		"llama.context_length":          uint32(8192),
+		"llama.embedding_length":        uint32(4096),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(8),
+		"tokenizer.ggml.tokens":         []string{""},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_down.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_gate.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_up.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_k.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_q.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_v.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model: "test",
+		Files: map[string]string{"file.gguf": digest},
+		Template: `
+{{- if .Tools }}
+{{ .Tools }}
+{{ end }}
+{{- range .Messages }}
+{{- .Role }}: {{ .Content }}
+{{- range .ToolCalls }}{"name": "{{ .Function.Name }}", "arguments": {{ .Function.Arguments }}}
+{{- end }}
+{{ end }}`,
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	messages := []api.Message{{Role: "user", Content: "What's the weather in Seattle?"}}
+
+	t.Run("answer", func(t *testing.T) {
+		w := createRequest(t, s.AgentHandler, api.AgentRequest{Model: "test", Messages: messages})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.AgentResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.DoneReason != "stop" || resp.Message.Content != "It's sunny." {
+			t.Errorf("expected an answer, got %q with done reason %q", resp.Message.Content, resp.DoneReason)
+		}
+
+		if len(resp.Steps) != 2 {
+			t.Fatalf("expected 2 steps, got %d", len(resp.Steps))
+		}
+
+		want := []api.AgentToolResult{{Name: "get_weather", Arguments: api.ToolCallFunctionArguments{"location": "Seattle"}, Result: "sunny in Seattle"}}
+		if diff := cmp.Diff(resp.Steps[0].ToolResults, want, cmpopts.IgnoreFields(api.AgentToolResult{}, "Duration")); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if resp.EvalCount != 2 {
+			t.Errorf("expected the metrics of both steps, got an eval count of %d", resp.EvalCount)
+		}
+	})
+
+	t.Run("max steps", func(t *testing.T) {
+		// the weather is never sunny, so the model keeps asking for it
+		cloudy.Store(true)
+		defer cloudy.Store(false)
+
+		w := createRequest(t, s.AgentHandler, api.AgentRequest{Model: "test", Messages: messages, MaxSteps: 3})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.AgentResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.DoneReason != "max_steps" || len(resp.Steps) != 3 {
+			t.Fatalf("expected 3 steps and done reason max_steps, got %d and %q", len(resp.Steps), resp.DoneReason)
+		}
+
+		if got := resp.Steps[2].ToolResults[0].Error; got != "503 Service Unavailable: no weather today\n" {
+			t.Errorf("unexpected error %q", got)
+		}
+	})
+
+	t.Run("unknown tool", func(t *testing.T) {
+		w := createRequest(t, s.AgentHandler, api.AgentRequest{Model: "test", Messages: messages, Tools: []string{"get_time"}})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("missing model", func(t *testing.T) {
+		w := createRequest(t, s.AgentHandler, api.AgentRequest{Model: "missing", Messages: messages})
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("expected status 404, got %d", w.Code)
+		}
+
+		var resp api.StatusError
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Code != api.ErrorCodeModelNotFound {
+			t.Errorf("expected error code %q, got %q", api.ErrorCodeModelNotFound, resp.Code)
+		}
+	})
+
+	t.Run("too many steps", func(t *testing.T) {
+		w := createRequest(t, s.AgentHandler, api.AgentRequest{Model: "test", Messages: messages, MaxSteps: maxAgentSteps + 1})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+}
+
+func TestRunCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands in this test are unix commands")
+	}
+
+	t.Setenv("OLLAMA_TEST_SECRET", "hunter2")
+	config := &commandToolConfig{Allow: []string{"echo", "env"}}
+
+	cases := []struct {
+		command any
+		want    string
+		err     string
+	}{
+		{command: "echo hello  world", want: "hello world\n"},
+		{command: []any{"echo", "$HOME; ls"}, want: "$HOME; ls\n"},
+		{command: "rm -rf /", err: `command "rm" is not allowed, allowed commands are: echo, env`},
+		{command: "/bin/echo hi", err: `command "/bin/echo" is not allowed, allowed commands are: echo, env`},
+		{command: "", err: "command is required"},
+		{command: "echo notes/today.txt --out=notes", want: "notes/today.txt --out=notes\n"},
+		{command: "echo /etc/passwd", err: `argument "/etc/passwd" is not allowed, commands can only be given paths within their directory`},
+		{command: "echo ../secret", err: `argument "../secret" is not allowed, commands can only be given paths within their directory`},
+		{command: "echo notes/../../secret", err: `argument "notes/../../secret" is not allowed, commands can only be given paths within their directory`},
+		{command: "echo --file=/etc/passwd", err: `argument "--file=/etc/passwd" is not allowed, commands can only be given paths within their directory`},
+		{command: "echo ~/.ssh", err: `argument "~/.ssh" is not allowed, commands can only be given paths within their directory`},
+	}
+
+	for _, tt := range cases {
+		got, err := runCommand(t.Context(), config, api.ToolCallFunctionArguments{"command": tt.command})
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%v: expected error %q, got %v", tt.command, tt.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%v: %v", tt.command, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%v: expected %q, got %q", tt.command, tt.want, got)
+		}
+	}
+
+	env, err := runCommand(t.Context(), config, api.ToolCallFunctionArguments{"command": "env"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(env, "hunter2") {
+		t.Error("expected commands not to see the server's environment")
+	}
+}
+
+func TestReadAgentTools(t *testing.T) {
+	dir := t.TempDir()
+	for _, s := range []string{
+		`[]`,
+		`{"http": [{"url": "http://localhost"}]}`,
+		`{"http": [{"name": "a", "url": "file:///etc/passwd"}]}`,
+		`{"http": [{"name": "a", "url": "http://localhost"}, {"name": "a", "url": "http://localhost"}]}`,
+		`{"http": [{"name": "run_command", "url": "http://localhost"}], "commands": {"allow": ["ls"]}}`,
+		`{"commands": {}}`,
+	} {
+		path := filepath.Join(dir, "tools.json")
+		if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := readAgentTools(t.Context(), path); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+
+	tools, err := readAgentTools(t.Context(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tools.tools) != 0 {
+		t.Errorf("expected no tools, got %d", len(tools.tools))
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+var (
+	errAliasIsModel = errors.New("a model with this name already exists")
+	errAliasCycle   = errors.New("alias refers to itself")
+)
+
+// aliasesMu guards the file mapping each alias to the model or alias it
+// refers to.
+var aliasesMu sync.Mutex
+
+func aliasesPath() string {
+	return filepath.Join(envconfig.Models(), "aliases.json")
+}
+
+func aliasKey(n model.Name) string {
+	return strings.ToLower(n.String())
+}
+
+func readAliases() (map[string]string, error) {
+	aliases := make(map[string]string)
+
+	bts, err := os.ReadFile(aliasesPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return aliases, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bts, &aliases); err != nil {
+		return nil, fmt.Errorf("invalid aliases %s: %w", aliasesPath(), err)
+	}
+
+	return aliases, nil
+}
+
+// writeAliases replaces the aliases file so requests see either the old or
+// the new target of an alias which is retargeted.
+func writeAliases(aliases map[string]string) error {
+	bts, err := json.Marshal(aliases)
+	if err != nil {
+		return err
+	}
+
+	p := aliasesPath()
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(p+".tmp", bts, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(p+".tmp", p)
+}
+
+func modelExists(n model.Name) bool {
+	_, err := ParseNamedManifest(n)
+	return err == nil
+}
+
+// followAliases follows the aliases starting at n until it reaches a name
+// which isn't an alias. It returns that name along with the aliases which
+// were followed. Models take precedence over aliases of the same name.
+func followAliases(aliases map[string]string, n model.Name) (model.Name, []model.Name, error) {
+	var chain []model.Name
+	for {
+		target, ok := aliases[aliasKey(n)]
+		if !ok || modelExists(n) {
+			return n, chain, nil
+		}
+
+		for _, alias := range chain {
+			if alias.EqualFold(n) {
+				return n, chain, fmt.Errorf("%s: %w", n.DisplayShortest(), errAliasCycle)
+			}
+		}
+
+		chain = append(chain, n)
+		n = model.ParseName(target)
+	}
+}
+
+// resolveAlias returns the model that n refers to, which is n itself unless
+// n is an alias, along with the aliases followed to reach it. An alias
+// whose model was deleted is an error wrapping [os.ErrNotExist].
+func resolveAlias(n model.Name) (model.Name, []model.Name, error) {
+	aliases, err := readAliases()
+	if err != nil || len(aliases) == 0 {
+		return n, nil, err
+	}
+
+	resolved, chain, err := followAliases(aliases, n)
+	if err == nil && len(chain) > 0 && !modelExists(resolved) {
+		err = fmt.Errorf("alias %s refers to model %s: %w", n.DisplayShortest(), resolved.DisplayShortest(), os.ErrNotExist)
+	}
+
+	return resolved, chain, err
+}
+
+// aliasErrorResponse returns the status code and body of the response to a
+// request for the model name when resolving its alias fails with err.
+func aliasErrorResponse(name string, err error) (int, gin.H) {
+	switch {
+	case errors.Is(err, errAliasCycle):
+		return http.StatusBadRequest, errorResponse(api.ErrorCodeInvalidRequest, err.Error())
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", name))
+	default:
+		return http.StatusInternalServerError, gin.H{"error": err.Error()}
+	}
+}
+
+// setAlias points alias at target, which must be a model or another alias.
+// An existing alias is retargeted atomically so requests using it switch
+// from the old model to the new one without failing in between.
+func setAlias(alias, target model.Name) error {
+	aliasesMu.Lock()
+	defer aliasesMu.Unlock()
+
+	if modelExists(alias) {
+		return fmt.Errorf("%s: %w", alias.DisplayShortest(), errAliasIsModel)
+	}
+
+	aliases, err := readAliases()
+	if err != nil {
+		return err
+	}
+
+	resolved, chain, err := followAliases(aliases, target)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range append(chain, resolved) {
+		if n.EqualFold(alias) {
+			return fmt.Errorf("%s: %w", alias.DisplayShortest(), errAliasCycle)
+		}
+	}
+
+	if !modelExists(resolved) {
+		return fmt.Errorf("model %s: %w", resolved.DisplayShortest(), os.ErrNotExist)
+	}
+
+	aliases[aliasKey(alias)] = target.String()
+	return writeAliases(aliases)
+}
+
+// deleteAlias deletes alias and its split, leaving the models they refer
+// to.
+func deleteAlias(alias model.Name) error {
+	aliasesMu.Lock()
+	defer aliasesMu.Unlock()
+
+	aliases, err := readAliases()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := aliases[aliasKey(alias)]; !ok {
+		return fmt.Errorf("alias %s: %w", alias.DisplayShortest(), os.ErrNotExist)
+	}
+
+	delete(aliases, aliasKey(alias))
+	if err := writeAliases(aliases); err != nil {
+		return err
+	}
+
+	// the split of an alias goes with it
+	if err := deleteSplit(alias); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
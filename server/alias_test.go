@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestAliases(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+	for _, n := range []string{"test:1", "test:2"} {
+		_, digest := createBinFile(t, nil, nil)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:   n,
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+	}
+
+	setAlias := func(alias, target string) int {
+		t.Helper()
+		return createRequest(t, s.SetAliasHandler, api.AliasRequest{Alias: alias, Target: target}).Code
+	}
+
+	show := func(name string) *api.ShowResponse {
+		t.Helper()
+
+		w := createRequest(t, s.ShowHandler, api.ShowRequest{Model: name})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.ShowResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return &resp
+	}
+
+	if code := setAlias("app:stable", "test:1"); code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", code)
+	}
+
+	if code := setAlias("app", "app:stable"); code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", code)
+	}
+
+	resp := show("App")
+	if resp.Target != "test:1" {
+		t.Errorf("expected target test:1, got %q", resp.Target)
+	}
+
+	if diff := cmp.Diff([]string{"App:latest", "app:stable"}, resp.AliasChain); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	// retargeting the channel moves the aliases referring to it
+	if code := setAlias("app:stable", "test:2"); code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", code)
+	}
+
+	if resp := show("app"); resp.Target != "test:2" {
+		t.Errorf("expected target test:2, got %q", resp.Target)
+	}
+
+	if resp := show("test:2"); resp.Target != "" || resp.AliasChain != nil {
+		t.Errorf("expected no alias, got %q %v", resp.Target, resp.AliasChain)
+	}
+
+	cases := []struct {
+		name          string
+		alias, target string
+		expect        int
+	}{
+		{"missing target", "other", "missing", http.StatusNotFound},
+		{"model name", "test:1", "test:2", http.StatusBadRequest},
+		{"cycle", "app:stable", "app", http.StatusBadRequest},
+		{"self", "other", "other", http.StatusBadRequest},
+		{"invalid", "", "test:1", http.StatusBadRequest},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := setAlias(tt.alias, tt.target); code != tt.expect {
+				t.Errorf("expected status code %d, actual %d", tt.expect, code)
+			}
+		})
+	}
+
+	w := createRequest(t, s.ListAliasesHandler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	var list api.ListAliasesResponse
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]api.Alias{
+		{Alias: "app:latest", Target: "app:stable"},
+		{Alias: "app:stable", Target: "test:2"},
+	}, list.Aliases); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if w := createRequest(t, s.DeleteAliasHandler, api.AliasRequest{Alias: "app"}); w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	if w := createRequest(t, s.DeleteAliasHandler, api.AliasRequest{Alias: "app"}); w.Code != http.StatusNotFound {
+		t.Fatalf("expected status code 404, actual %d", w.Code)
+	}
+
+	if w := createRequest(t, s.ShowHandler, api.ShowRequest{Model: "app"}); w.Code != http.StatusNotFound {
+		t.Errorf("expected status code 404, actual %d", w.Code)
+	}
+
+	// deleting an alias leaves its model
+	if resp := show("app:stable"); resp.Target != "test:2" {
+		t.Errorf("expected target test:2, got %q", resp.Target)
+	}
+
+	// aliases written by an older server, or left by deleting a model, may
+	// loop or refer to a missing model
+	aliases, err := readAliases()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for alias, target := range map[string]string{"loop:a": "loop:b", "loop:b": "loop:a", "gone": "missing"} {
+		aliases[aliasKey(model.ParseName(alias))] = model.ParseName(target).String()
+	}
+
+	if err := writeAliases(aliases); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		status int
+		code   api.ErrorCode
+	}{
+		{"loop:a", http.StatusBadRequest, api.ErrorCodeInvalidRequest},
+		{"gone", http.StatusNotFound, api.ErrorCodeModelNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for handler, w := range map[string]*httptest.ResponseRecorder{
+				"show":     createRequest(t, s.ShowHandler, api.ShowRequest{Model: tt.name}),
+				"generate": createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: tt.name}),
+			} {
+				if w.Code != tt.status {
+					t.Errorf("%s: expected status code %d, actual %d: %s", handler, tt.status, w.Code, w.Body)
+				}
+
+				var resp api.StatusError
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+
+				if resp.Code != tt.code {
+					t.Errorf("%s: expected code %q, got %q", handler, tt.code, resp.Code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,639 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/server/internal/blobstore"
+	"github.com/ollama/ollama/types/model"
+)
+
+// blobStore caches the store opened for OLLAMA_BLOB_STORE.
+var blobStore struct {
+	sync.Mutex
+	url   string
+	store blobstore.Store
+	err   error
+}
+
+// remoteStore returns the store models are kept in when OLLAMA_BLOB_STORE
+// is set, or nil if they are only kept in the models directory. With a
+// store, the models directory is a cache of the blobs in use.
+func remoteStore() (blobstore.Store, error) {
+	u := envconfig.BlobStore()
+	if u == "" {
+		return nil, nil
+	}
+
+	blobStore.Lock()
+	defer blobStore.Unlock()
+
+	if u != blobStore.url {
+		blobStore.url = u
+		blobStore.store, blobStore.err = blobstore.Open(u)
+	}
+
+	return blobStore.store, blobStore.err
+}
+
+func blobKey(digest string) string {
+	return "blobs/" + strings.ReplaceAll(digest, ":", "-")
+}
+
+func manifestKey(n model.Name) string {
+	return "manifests/" + filepath.ToSlash(n.Filepath())
+}
+
+// refKey is the key of the empty object which records that the manifest at
+// manifestKey uses the blob with digest. Blobs are only removed from the
+// store once they have no refs, so removing a model doesn't need to read
+// every other manifest in the store.
+func refKey(digest, manifestKey string) string {
+	return refsPrefix(digest) + strings.TrimPrefix(manifestKey, "manifests/")
+}
+
+func refsPrefix(digest string) string {
+	return "refs/" + strings.ReplaceAll(digest, ":", "-") + "/"
+}
+
+// storeRefs records that the manifest at key uses each layer of m, unless
+// they're in stored.
+func storeRefs(ctx context.Context, store blobstore.Store, key string, m *Manifest, stored map[string]bool) error {
+	for _, layer := range append(m.Layers, m.Config) {
+		if layer.Digest == "" || stored[refKey(layer.Digest, key)] {
+			continue
+		}
+
+		if err := store.Put(ctx, refKey(layer.Digest, key), bytes.NewReader(nil), 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// storedBlobFetches serializes fetches of the same blob from the store.
+var storedBlobFetches sync.Map
+
+// fetchBlob returns the path of the blob with digest, copying it from the
+// blob store if it isn't in the models directory.
+func fetchBlob(ctx context.Context, digest string) (string, error) {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return "", err
+	}
+
+	store, err := remoteStore()
+	if err != nil || store == nil {
+		return p, err
+	}
+
+	mu, _ := storedBlobFetches.LoadOrStore(digest, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+
+	// touch cached blobs so the least recently used are evicted first
+	now := time.Now()
+	if err := os.Chtimes(p, now, now); err == nil {
+		return p, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+
+	slog.Info("fetching blob from store", "digest", digest)
+	if ok, err := downloadStoredBlob(ctx, digest, func(api.ProgressResponse) {}); err != nil {
+		return "", err
+	} else if !ok {
+		return "", fmt.Errorf("blob %s: %w", digest, os.ErrNotExist)
+	}
+
+	return p, nil
+}
+
+// downloadStoredBlob copies the blob with digest from the blob store to the
+// models directory. It returns false if there's no store or the store
+// doesn't have the blob.
+func downloadStoredBlob(ctx context.Context, digest string, fn func(api.ProgressResponse)) (bool, error) {
+	store, err := remoteStore()
+	if err != nil || store == nil {
+		return false, err
+	}
+
+	size, err := store.Stat(ctx, blobKey(digest))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	r, err := store.Get(ctx, blobKey(digest))
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	unlock, err := lockStore()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	fp, err := GetBlobsPath(digest)
+	if err != nil {
+		return false, err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(fp), "sha256-")
+	if err != nil {
+		return false, err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	sha256sum := sha256.New()
+	w := &blobProgressWriter{digest: digest, total: size, fn: fn}
+	if _, err := io.Copy(io.MultiWriter(temp, sha256sum, w), r); err != nil {
+		return false, err
+	}
+
+	if got := fmt.Sprintf("sha256:%x", sha256sum.Sum(nil)); got != strings.Replace(digest, "-", ":", 1) {
+		return false, fmt.Errorf("digest mismatch, expected %q, got %q", digest, got)
+	}
+
+	if err := temp.Close(); err != nil {
+		return false, err
+	}
+
+	w.report()
+	if err := os.Rename(temp.Name(), fp); err != nil {
+		return false, err
+	}
+
+	notifyBlobCache()
+	return true, nil
+}
+
+// fetchModelBlobs copies the weights of m from the blob store if they
+// aren't in the models directory. They're only fetched once the model is
+// loaded, so that reading its metadata doesn't copy gigabytes of weights.
+func fetchModelBlobs(ctx context.Context, m *Model) error {
+	store, err := remoteStore()
+	if err != nil || store == nil {
+		return err
+	}
+
+	for _, p := range slices.Concat([]string{m.ModelPath}, m.AdapterPaths, m.ProjectorPaths) {
+		if p == "" {
+			continue
+		}
+
+		if _, err := fetchBlob(ctx, filepath.Base(p)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unfetched reports whether err is from reading a blob which is in the blob
+// store but hasn't been fetched to the models directory.
+func unfetched(err error) bool {
+	store, _ := remoteStore()
+	return store != nil && errors.Is(err, fs.ErrNotExist)
+}
+
+// manifestPathKey returns the key in the blob store of the manifest at
+// path p in the manifests directory.
+func manifestPathKey(p string) (string, error) {
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(manifests, p)
+	if err != nil {
+		return "", err
+	}
+
+	n := model.ParseNameFromFilepath(rel)
+	if !n.IsValid() {
+		return "", fmt.Errorf("invalid manifest path %q", p)
+	}
+
+	return manifestKey(n), nil
+}
+
+// fetchManifest copies the manifest at path p in the manifests directory
+// from the blob store if it isn't there so any server sharing the store can
+// use models pulled or created by the others.
+func fetchManifest(ctx context.Context, p string) error {
+	store, err := remoteStore()
+	if err != nil || store == nil {
+		return err
+	}
+
+	if _, err := os.Stat(p); !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	key, err := manifestPathKey(p)
+	if err != nil {
+		// leave the caller to report the missing manifest
+		return nil
+	}
+
+	r, err := store.Get(ctx, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	bts, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	return writeManifestFile(p, bts, nil)
+}
+
+// storeManifest copies the manifest of n and its blobs to the blob store.
+// Blobs are stored first so other servers never see a manifest with
+// missing blobs, after the refs which keep them from being removed.
+func storeManifest(ctx context.Context, n model.Name) error {
+	store, err := remoteStore()
+	if err != nil || store == nil {
+		return err
+	}
+
+	m, err := ParseNamedManifest(n)
+	if err != nil {
+		return err
+	}
+
+	if err := storeRefs(ctx, store, manifestKey(n), m, nil); err != nil {
+		return err
+	}
+
+	for _, layer := range append(m.Layers, m.Config) {
+		if layer.Digest == "" {
+			continue
+		}
+
+		if _, err := store.Stat(ctx, blobKey(layer.Digest)); err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		if err := storeBlob(ctx, store, layer.Digest); err != nil {
+			return fmt.Errorf("storing blob %s: %w", layer.Digest, err)
+		}
+	}
+
+	bts, err := os.ReadFile(m.filepath)
+	if err != nil {
+		return err
+	}
+
+	if err := store.Put(ctx, manifestKey(n), bytes.NewReader(bts), int64(len(bts))); err != nil {
+		return err
+	}
+
+	// the blobs are safe to evict now they're in the store
+	notifyBlobCache()
+	return nil
+}
+
+func storeBlob(ctx context.Context, store blobstore.Store, digest string) error {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return store.Put(ctx, blobKey(digest), f, fi.Size())
+}
+
+// unstoreManifest removes the manifest m from the blob store, and then the
+// refs to its blobs.
+func unstoreManifest(m *Manifest) error {
+	store, err := remoteStore()
+	if err != nil || store == nil {
+		return err
+	}
+
+	key, err := manifestPathKey(m.filepath)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if err := store.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	for _, layer := range append(m.Layers, m.Config) {
+		if layer.Digest == "" {
+			continue
+		}
+
+		if err := store.Delete(ctx, refKey(layer.Digest, key)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unstoreBlobs removes blobs from the blob store unless a manifest in the
+// store still refers to them, which happens when another server sharing
+// the store has pulled a model this server doesn't know about.
+func unstoreBlobs(digests map[string]struct{}) {
+	store, err := remoteStore()
+	if err != nil || store == nil || len(digests) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	for digest := range digests {
+		refs, err := store.List(ctx, refsPrefix(digest))
+		if err != nil {
+			// keep the blob rather than delete one which may be in use
+			slog.Warn("failed to list refs to stored blob", "digest", digest, "error", err)
+			continue
+		} else if len(refs) > 0 {
+			continue
+		}
+
+		if err := store.Delete(ctx, blobKey(digest)); err != nil {
+			slog.Warn("failed to remove stored blob", "digest", digest, "error", err)
+		}
+	}
+}
+
+// syncManifests copies manifests from the blob store which are missing or
+// out of date in the manifests directory. Manifests which are only in the
+// manifests directory are stored in the background so existing models are
+// kept when a store is first configured.
+func syncManifests(ctx context.Context) error {
+	store, err := remoteStore()
+	if err != nil || store == nil {
+		return err
+	}
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return err
+	}
+
+	keys, err := store.List(ctx, "manifests/")
+	if err != nil {
+		return err
+	}
+
+	// manifests stored before blobs had refs are given them, so that their
+	// blobs aren't removed with other models
+	refs, err := store.List(ctx, "refs/")
+	if err != nil {
+		return err
+	}
+
+	storedRefs := make(map[string]bool)
+	for _, ref := range refs {
+		storedRefs[ref] = true
+	}
+
+	stored := make(map[model.Name]bool)
+	for _, key := range keys {
+		n := model.ParseNameFromFilepath(filepath.FromSlash(strings.TrimPrefix(key, "manifests/")))
+		if !n.IsValid() {
+			continue
+		}
+
+		stored[n] = true
+
+		r, err := store.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		bts, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+
+		var m Manifest
+		if err := json.Unmarshal(bts, &m); err != nil {
+			return fmt.Errorf("stored manifest %s: %w", key, err)
+		}
+
+		if err := storeRefs(ctx, store, key, &m, storedRefs); err != nil {
+			return err
+		}
+
+		p := filepath.Join(manifests, n.Filepath())
+		if local, err := os.ReadFile(p); err == nil && bytes.Equal(local, bts) {
+			continue
+		}
+
+		if err := writeManifestFile(p, bts, nil); err != nil {
+			return err
+		}
+	}
+
+	ms, err := Manifests(true)
+	if err != nil {
+		return err
+	}
+
+	var unstored []model.Name
+	for n := range ms {
+		if !stored[n] {
+			unstored = append(unstored, n)
+		}
+	}
+
+	if len(unstored) > 0 {
+		go func() {
+			for _, n := range unstored {
+				slog.Info("storing model", "name", n.DisplayShortest())
+				if err := storeManifest(ctx, n); err != nil {
+					slog.Warn("failed to store model", "name", n.DisplayShortest(), "error", err)
+				}
+			}
+		}()
+	}
+
+	return nil
+}
+
+// blobCacheFilled is signalled when blobs are added to the models directory
+// or stored so blobCacheLoop can evict blobs if the cache is too big.
+var blobCacheFilled = make(chan struct{}, 1)
+
+func notifyBlobCache() {
+	select {
+	case blobCacheFilled <- struct{}{}:
+	default:
+	}
+}
+
+// blobCacheLoop keeps the blobs in the models directory under
+// OLLAMA_BLOB_CACHE_SIZE until ctx is done.
+func (s *Server) blobCacheLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-blobCacheFilled:
+		}
+
+		size := envconfig.BlobCacheSize()
+		if size <= 0 {
+			continue
+		}
+
+		evicted, err := evictBlobs(ctx, size, s.loadedBlobs())
+		if err != nil {
+			slog.Warn("failed to evict blobs", "error", err)
+		} else if evicted > 0 {
+			slog.Info(fmt.Sprintf("evicted %s of blobs from the cache", format.HumanBytes(evicted)))
+		}
+	}
+}
+
+// evictBlobs removes the least recently used blobs from the models directory
+// until the rest take up at most size bytes. Blobs which are in use or
+// which aren't in the blob store are kept. It returns the number of bytes
+// evicted.
+func evictBlobs(ctx context.Context, size int64, inUse map[string]bool) (int64, error) {
+	store, err := remoteStore()
+	if err != nil || store == nil {
+		return 0, err
+	}
+
+	// blobs being written may be about to be used, so they're evicted
+	// once the cache fills again
+	unlock, err := lockStoreExclusive()
+	if errors.Is(err, errStoreInUse) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	blobs, err := GetBlobsPath("")
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := os.ReadDir(blobs)
+	if err != nil {
+		return 0, err
+	}
+
+	type blob struct {
+		digest string
+		fi     fs.FileInfo
+	}
+
+	var cached []blob
+	var total int64
+	for _, entry := range entries {
+		digest := strings.Replace(entry.Name(), "-", ":", 1)
+		if _, err := GetBlobsPath(digest); err != nil {
+			// partial downloads and other files
+			continue
+		}
+
+		fi, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		cached = append(cached, blob{digest, fi})
+		total += fi.Size()
+	}
+
+	slices.SortFunc(cached, func(a, b blob) int {
+		return cmp.Compare(a.fi.ModTime().UnixNano(), b.fi.ModTime().UnixNano())
+	})
+
+	var evicted int64
+	for _, b := range cached {
+		if total <= size {
+			break
+		}
+
+		if inUse[b.digest] {
+			continue
+		}
+
+		if _, err := store.Stat(ctx, blobKey(b.digest)); err != nil {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(blobs, b.fi.Name())); err != nil {
+			slog.Warn("failed to evict blob", "digest", b.digest, "error", err)
+			continue
+		}
+
+		total -= b.fi.Size()
+		evicted += b.fi.Size()
+	}
+
+	return evicted, nil
+}
+
+// loadedBlobs returns the digests of the blobs used by loaded models.
+func (s *Server) loadedBlobs() map[string]bool {
+	inUse := make(map[string]bool)
+	if s.sched == nil {
+		return inUse
+	}
+
+	s.sched.loadedMu.Lock()
+	defer s.sched.loadedMu.Unlock()
+	for _, runner := range s.sched.loaded {
+		if runner.model == nil {
+			continue
+		}
+
+		paths := append([]string{runner.model.ModelPath}, runner.adapters...)
+		for _, p := range append(paths, runner.model.ProjectorPaths...) {
+			inUse[strings.Replace(filepath.Base(p), "-", ":", 1)] = true
+		}
+	}
+
+	return inUse
+}
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/server/internal/blobstore"
+	"github.com/ollama/ollama/types/model"
+)
+
+func setupBlobStore(t *testing.T) blobstore.Store {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_BLOB_STORE", "file://"+dir)
+
+	store, err := remoteStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func writeTestBlob(t *testing.T, data string) Layer {
+	t.Helper()
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(data)))
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return Layer{MediaType: "application/vnd.ollama.image.model", Digest: digest, Size: int64(len(data))}
+}
+
+func storedKeys(t *testing.T, store blobstore.Store) []string {
+	t.Helper()
+
+	keys, err := store.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slices.Sort(keys)
+	return keys
+}
+
+func TestBlobStoreModel(t *testing.T) {
+	store := setupBlobStore(t)
+
+	layer := writeTestBlob(t, "weights")
+	n := model.ParseName("test")
+	if err := WriteManifest(t.Context(), n, Layer{}, []Layer{layer}); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{blobKey(layer.Digest), manifestKey(n), refKey(layer.Digest, manifestKey(n))}
+	if keys := storedKeys(t, store); !slices.Equal(keys, expect) {
+		t.Fatalf("expected %v, got %v", expect, keys)
+	}
+
+	// a fresh server sharing the store only fetches the weights of the
+	// model when it's loaded
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(m.ModelPath); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the weights not to be fetched for the model's metadata, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if err := fetchModelBlobs(ctx, m); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected fetching to stop with its request, got %v", err)
+	}
+
+	if err := fetchModelBlobs(t.Context(), m); err != nil {
+		t.Fatal(err)
+	}
+
+	bts, err := os.ReadFile(m.ModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(bts) != "weights" {
+		t.Errorf("expected weights, got %q", bts)
+	}
+
+	manifest, err := ParseNamedManifest(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manifest.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manifest.RemoveLayers(); err != nil {
+		t.Fatal(err)
+	}
+
+	if keys := storedKeys(t, store); len(keys) > 0 {
+		t.Errorf("expected empty store, got %v", keys)
+	}
+}
+
+func TestBlobStoreKeepsSharedBlobs(t *testing.T) {
+	store := setupBlobStore(t)
+
+	layer := writeTestBlob(t, "shared")
+	if err := WriteManifest(t.Context(), model.ParseName("a"), Layer{}, []Layer{layer}); err != nil {
+		t.Fatal(err)
+	}
+
+	// another server sharing the store has a model this server doesn't
+	if err := WriteManifest(t.Context(), model.ParseName("b"), Layer{}, []Layer{layer}); err != nil {
+		t.Fatal(err)
+	}
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(filepath.Join(manifests, model.ParseName("b").Filepath())); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ParseNamedManifest(model.ParseName("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.RemoveLayers(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Stat(context.Background(), blobKey(layer.Digest)); err != nil {
+		t.Errorf("expected shared blob to be kept, got %v", err)
+	}
+}
+
+func TestSyncManifestsRefs(t *testing.T) {
+	store := setupBlobStore(t)
+
+	layer := writeTestBlob(t, "shared")
+	for _, name := range []string{"a", "b"} {
+		if err := WriteManifest(t.Context(), model.ParseName(name), Layer{}, []Layer{layer}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// b was stored before blobs had refs
+	b := manifestKey(model.ParseName("b"))
+	if err := store.Delete(t.Context(), refKey(layer.Digest, b)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := syncManifests(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Stat(t.Context(), refKey(layer.Digest, b)); err != nil {
+		t.Fatalf("expected the ref of b to be stored, got %v", err)
+	}
+
+	m, err := ParseNamedManifest(model.ParseName("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	unstoreBlobs(map[string]struct{}{layer.Digest: {}})
+	if _, err := store.Stat(t.Context(), blobKey(layer.Digest)); err != nil {
+		t.Errorf("expected the blob of b to be kept, got %v", err)
+	}
+}
+
+func TestSyncManifests(t *testing.T) {
+	store := setupBlobStore(t)
+
+	stored := writeTestBlob(t, "stored")
+	if err := WriteManifest(t.Context(), model.ParseName("stored"), Layer{}, []Layer{stored}); err != nil {
+		t.Fatal(err)
+	}
+
+	// models which were pulled before the store was configured
+	storeURL := os.Getenv("OLLAMA_BLOB_STORE")
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_BLOB_STORE", "")
+
+	local := writeTestBlob(t, "local")
+	if err := WriteManifest(t.Context(), model.ParseName("local"), Layer{}, []Layer{local}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_BLOB_STORE", storeURL)
+	if err := syncManifests(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ms, err := Manifests(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"stored", "local"} {
+		if _, ok := ms[model.ParseName(name)]; !ok {
+			t.Errorf("expected %s to be listed", name)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := store.Stat(context.Background(), manifestKey(model.ParseName("local"))); err == nil {
+			break
+		} else if !errors.Is(err, fs.ErrNotExist) || time.Now().After(deadline) {
+			t.Fatalf("expected local model to be stored, got %v", err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := store.Stat(context.Background(), blobKey(local.Digest)); err != nil {
+		t.Errorf("expected blob of local model to be stored, got %v", err)
+	}
+}
+
+func TestEvictBlobs(t *testing.T) {
+	store := setupBlobStore(t)
+
+	now := time.Now()
+	var layers []Layer
+	for i, data := range []string{"oldest", "in use", "newer", "newest"} {
+		layer := writeTestBlob(t, data)
+		if data != "newer" {
+			if err := storeBlob(context.Background(), store, layer.Digest); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		p, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mtime := now.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+
+		layers = append(layers, layer)
+	}
+
+	// "in use" is loaded and "newer" isn't in the store so only "oldest"
+	// and "newest" can be evicted
+	evicted, err := evictBlobs(context.Background(), 12, map[string]bool{layers[1].Digest: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if evicted != 12 {
+		t.Errorf("expected 12 bytes evicted, got %d", evicted)
+	}
+
+	for i, layer := range layers {
+		p, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = os.Stat(p)
+		if cached := err == nil; cached != (i == 1 || i == 2) {
+			t.Errorf("%d: unexpected cached %t", i, cached)
+		}
+	}
+
+	// evicted blobs are fetched again when they're used
+	p, err := fetchBlob(t.Context(), layers[0].Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bts, err := os.ReadFile(p); err != nil || string(bts) != "oldest" {
+		t.Errorf("expected oldest, got %q, %v", bts, err)
+	}
+}
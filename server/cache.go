@@ -0,0 +1,372 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+// cachedResponse is a successful response kept to be returned again,
+// either for a retry with the same idempotency key or for an identical
+// deterministic request.
+type cachedResponse struct {
+	contentType string
+	body        []byte
+
+	// request is the hash of the request the response is for, so reuse of
+	// an idempotency key for a different request can be refused
+	request string
+	expires time.Time
+}
+
+// responseCache keeps responses in memory until they expire or are evicted
+// to stay within OLLAMA_RESPONSE_CACHE_SIZE.
+type responseCache struct {
+	mu        sync.Mutex
+	responses map[string]*cachedResponse
+	size      int
+
+	// pending are the idempotency keys of requests in progress
+	pending map[string]bool
+}
+
+func (rc *responseCache) get(key string) *cachedResponse {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	r, ok := rc.responses[key]
+	if !ok {
+		return nil
+	}
+
+	if time.Now().After(r.expires) {
+		rc.remove(key)
+		return nil
+	}
+
+	return r
+}
+
+func (rc *responseCache) put(key string, r *cachedResponse) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	limit := int(envconfig.ResponseCacheSize())
+	if len(r.body) > limit {
+		return
+	}
+
+	if rc.responses == nil {
+		rc.responses = make(map[string]*cachedResponse)
+	}
+
+	rc.remove(key)
+	rc.responses[key] = r
+	rc.size += len(r.body)
+
+	for rc.size > limit {
+		// evict the response which would expire first
+		var oldest string
+		for k, v := range rc.responses {
+			if oldest == "" || v.expires.Before(rc.responses[oldest].expires) {
+				oldest = k
+			}
+		}
+		rc.remove(oldest)
+	}
+}
+
+// remove drops the response with key. rc.mu must be held.
+func (rc *responseCache) remove(key string) {
+	if r, ok := rc.responses[key]; ok {
+		rc.size -= len(r.body)
+		delete(rc.responses, key)
+	}
+}
+
+// claim marks the request with an idempotency key as in progress, returning
+// false if another request with the key already is.
+func (rc *responseCache) claim(key string) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.pending[key] {
+		return false
+	}
+
+	if rc.pending == nil {
+		rc.pending = make(map[string]bool)
+	}
+
+	rc.pending[key] = true
+	return true
+}
+
+func (rc *responseCache) release(key string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	delete(rc.pending, key)
+}
+
+// requestHash is the hash of a request to path with body, which is the same
+// for bodies which differ only in the order of their fields or whitespace.
+func requestHash(path string, body []byte) (string, error) {
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return "", err
+	}
+
+	bts, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", path)
+	h.Write(bts)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// deterministic reports whether a generate or chat request always gets the
+// same response from the same model, because it sets a seed or samples
+// greedily.
+func deterministic(body []byte) bool {
+	var req struct {
+		Options map[string]any `json:"options"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return false
+	}
+
+	if seed, ok := req.Options["seed"].(float64); ok && seed != -1 {
+		return true
+	}
+
+	temperature, ok := req.Options["temperature"].(float64)
+	return ok && temperature == 0
+}
+
+// modelDigest is the digest of the model of a request, which changes when
+// the model is pulled or created again.
+func modelDigest(body []byte) (string, error) {
+	var req struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return "", err
+	}
+
+	name, err := getExistingName(model.ParseName(req.Model))
+	if err != nil {
+		return "", err
+	}
+
+	name, _, err = resolveAlias(name)
+	if err != nil {
+		return "", err
+	}
+
+	m, err := GetModel(name.String())
+	if err != nil {
+		return "", err
+	}
+
+	return m.Digest, nil
+}
+
+// cacheWriter keeps a copy of a response as it's written, up to limit
+// bytes.
+type cacheWriter struct {
+	gin.ResponseWriter
+	body  bytes.Buffer
+	limit int
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if w.body.Len()+len(b) <= w.limit {
+		w.body.Write(b)
+	} else {
+		w.limit = -1
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// complete reports whether the copy of the response is all of a successful
+// response, which can be returned again. Streams which end in an error or
+// were stopped by a timeout aren't.
+func (w *cacheWriter) complete() bool {
+	if w.limit < 0 || w.Status() != http.StatusOK || w.body.Len() == 0 {
+		return false
+	}
+
+	body := bytes.TrimSpace(w.body.Bytes())
+	var last struct {
+		Error      any    `json:"error"`
+		DoneReason string `json:"done_reason"`
+	}
+	if err := json.Unmarshal(body[bytes.LastIndexByte(body, '\n')+1:], &last); err != nil {
+		return false
+	}
+
+	return last.Error == nil && last.DoneReason != "timeout"
+}
+
+// cacheMiddleware returns the kept response to a request which has already
+// been answered, instead of generating it again. Requests with an
+// Idempotency-Key header get the response to the first request with the
+// key, for OLLAMA_IDEMPOTENCY_TTL. Other deterministic requests get the
+// response to an identical request to the same model, for
+// OLLAMA_RESPONSE_CACHE_TTL, unless they have a Cache-Control: no-cache
+// header. With OLLAMA_SEMANTIC_CACHE, requests also get the response to a
+// request with a similar prompt and otherwise the same parameters, which is
+// marked as a cache hit. Only successful responses are kept, so failed
+// requests can be retried.
+func (s *Server) cacheMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idempotencyKey := c.GetHeader("Idempotency-Key")
+		noCache := strings.Contains(c.GetHeader("Cache-Control"), "no-cache")
+		if idempotencyKey == "" && (envconfig.ResponseCacheTTL() <= 0 || noCache) {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash, err := requestHash(c.Request.URL.Path, body)
+		if err != nil {
+			// leave invalid requests to the handler to reject
+			c.Next()
+			return
+		}
+
+		var key string
+		var ttl time.Duration
+		var prompt *semanticPrompt
+		if idempotencyKey != "" {
+			key, ttl = "idempotency:"+tenantScoped(c, idempotencyKey), envconfig.IdempotencyTTL()
+		} else {
+			semantic := envconfig.SemanticCache() != ""
+			if !semantic && !deterministic(body) {
+				c.Next()
+				return
+			}
+
+			digest, err := modelDigest(body)
+			if err != nil {
+				c.Next()
+				return
+			}
+
+			// tenants don't get the responses to each other's prompts,
+			// even for the models they share
+			scope := tenantScoped(c, digest)
+
+			ttl = envconfig.ResponseCacheTTL()
+			if deterministic(body) {
+				key = "response:" + scope + ":" + hash
+			}
+
+			if semantic {
+				prompt, err = s.semanticPrompt(c.Request.Context(), c.Request.URL.Path, scope, body)
+				if err != nil {
+					slog.Warn("failed to look up the semantic cache", "error", err)
+				}
+			}
+
+			if key == "" && prompt == nil {
+				c.Next()
+				return
+			}
+
+			if key == "" {
+				// the response to a request which isn't deterministic is
+				// only returned for similar prompts
+				key = "semantic:" + scope + ":" + hash
+			}
+		}
+
+		if prompt != nil {
+			if similar, similarity := s.prompts.find(prompt); similar != "" {
+				if r := s.responses.get(similar); r != nil {
+					c.Header("X-Cache", "hit")
+					c.Header("X-Cache-Similarity", strconv.FormatFloat(float64(similarity), 'f', 4, 32))
+					c.Data(http.StatusOK, r.contentType, markCacheHit(r.body))
+					c.Abort()
+					return
+				}
+			}
+		}
+
+		if r := s.responses.get(key); r != nil {
+			if r.request != hash {
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "idempotency key was already used for a different request"})
+				return
+			}
+
+			c.Header("X-Cache", "hit")
+			c.Data(http.StatusOK, r.contentType, r.body)
+			c.Abort()
+			return
+		}
+
+		if idempotencyKey != "" {
+			if !s.responses.claim(key) {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "a request with this idempotency key is in progress"})
+				return
+			}
+			defer s.responses.release(key)
+		}
+
+		w := &cacheWriter{ResponseWriter: c.Writer, limit: int(envconfig.ResponseCacheSize())}
+		c.Writer = w
+		defer func() { c.Writer = w.ResponseWriter }()
+
+		// the handler's context is canceled once it's done, so check the
+		// client's
+		ctx := c.Request.Context()
+
+		c.Header("X-Cache", "miss")
+		c.Next()
+
+		if ctx.Err() == nil && w.complete() {
+			expires := time.Now().Add(ttl)
+			s.responses.put(key, &cachedResponse{
+				contentType: w.Header().Get("Content-Type"),
+				body:        bytes.Clone(w.body.Bytes()),
+				request:     hash,
+				expires:     expires,
+			})
+
+			if prompt != nil {
+				s.prompts.add(prompt, key, expires)
+			}
+		}
+	}
+}
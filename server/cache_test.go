@@ -0,0 +1,120 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCacheMiddlewareIdempotency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var s Server
+	var calls int
+	r := gin.New()
+	r.POST("/api/generate", s.cacheMiddleware(), func(c *gin.Context) {
+		calls++
+		var req struct{ Prompt string }
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if req.Prompt == "fail" {
+			c.Data(http.StatusOK, "application/x-ndjson", []byte("{\"response\":\"a\"}\n{\"error\":\"runner crashed\"}\n"))
+			return
+		}
+
+		c.Data(http.StatusOK, "application/x-ndjson", []byte("{\"response\":\"a\"}\n{\"response\":\"b\",\"done\":true}\n"))
+	})
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post("abc", `{"model":"test","prompt":"hi"}`)
+	if first.Code != http.StatusOK || first.Header().Get("X-Cache") != "miss" {
+		t.Fatalf("expected a miss, got %d %q", first.Code, first.Header().Get("X-Cache"))
+	}
+
+	// the same request with its fields in another order
+	retry := post("abc", `{"prompt": "hi", "model": "test"}`)
+	if retry.Header().Get("X-Cache") != "hit" || retry.Body.String() != first.Body.String() {
+		t.Errorf("expected the first response, got %q: %s", retry.Header().Get("X-Cache"), retry.Body.String())
+	}
+
+	if ct := retry.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected the first content type, got %q", ct)
+	}
+
+	if calls != 1 {
+		t.Errorf("expected the handler to be called once, got %d", calls)
+	}
+
+	if w := post("abc", `{"model":"test","prompt":"bye"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status code 422 for a different request, actual %d", w.Code)
+	}
+
+	// failures aren't kept, so they can be retried
+	post("def", `{"model":"test","prompt":"fail"}`)
+	post("def", `{"model":"test","prompt":"fail"}`)
+	if calls != 3 {
+		t.Errorf("expected a failed request to be run again, got %d calls", calls)
+	}
+
+	// requests without a key aren't kept, since the response cache is off
+	post("", `{"model":"test","prompt":"hi","options":{"seed":42}}`)
+	post("", `{"model":"test","prompt":"hi","options":{"seed":42}}`)
+	if calls != 5 {
+		t.Errorf("expected requests without a key to be run, got %d calls", calls)
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	t.Setenv("OLLAMA_RESPONSE_CACHE_SIZE", "10B")
+
+	var rc responseCache
+	now := time.Now()
+	rc.put("a", &cachedResponse{body: []byte("aaaa"), expires: now.Add(2 * time.Minute)})
+	rc.put("b", &cachedResponse{body: []byte("bbbb"), expires: now.Add(time.Minute)})
+	rc.put("c", &cachedResponse{body: []byte("cccc"), expires: now.Add(3 * time.Minute)})
+	rc.put("d", &cachedResponse{body: []byte("too large to keep"), expires: now.Add(time.Hour)})
+	rc.put("e", &cachedResponse{body: []byte("e"), expires: now.Add(-time.Minute)})
+
+	for key, kept := range map[string]bool{"a": true, "b": false, "c": true, "d": false, "e": false} {
+		if got := rc.get(key) != nil; got != kept {
+			t.Errorf("%s: expected kept to be %t", key, kept)
+		}
+	}
+
+	if rc.size != 8 {
+		t.Errorf("expected a size of 8, got %d", rc.size)
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	cases := map[string]bool{
+		`{"model":"test"}`:                                   false,
+		`{"model":"test","options":{"seed":42}}`:             true,
+		`{"model":"test","options":{"seed":-1}}`:             false,
+		`{"model":"test","options":{"temperature":0}}`:       true,
+		`{"model":"test","options":{"temperature":0.7}}`:     false,
+		`{"model":"test","options":{"seed":"not a number"}}`: false,
+	}
+
+	for body, want := range cases {
+		if got := deterministic([]byte(body)); got != want {
+			t.Errorf("%s: expected %t, got %t", body, want, got)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// maxCaptureSize limits the responses recorded in captures, which are cut
+// off after this many bytes.
+const maxCaptureSize = 4 << 20
+
+// redacted replaces what the OLLAMA_CAPTURE_REDACT rules leave out.
+const redacted = "[REDACTED]"
+
+// captureRules are the parsed OLLAMA_CAPTURE_REDACT rules.
+type captureRules struct {
+	fields   map[string]bool
+	patterns []*regexp.Regexp
+}
+
+// parseCaptureRules parses rules separated by commas, which are field names
+// or regular expressions between slashes. Regular expressions may contain
+// commas, and slashes escaped with a backslash.
+func parseCaptureRules(s string) (*captureRules, error) {
+	rules := captureRules{fields: make(map[string]bool)}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		if s[0] != '/' {
+			field, rest, _ := strings.Cut(s, ",")
+			if field = strings.TrimSpace(field); field != "" {
+				rules.fields[strings.ToLower(field)] = true
+			}
+			s = rest
+			continue
+		}
+
+		end := -1
+		for i := 1; i < len(s); i++ {
+			if s[i] == '\\' {
+				i++
+			} else if s[i] == '/' {
+				end = i
+				break
+			}
+		}
+
+		if end < 0 {
+			return nil, fmt.Errorf("regular expression %s has no closing slash", s)
+		}
+
+		re, err := regexp.Compile(strings.ReplaceAll(s[1:end], `\/`, "/"))
+		if err != nil {
+			return nil, err
+		}
+		rules.patterns = append(rules.patterns, re)
+
+		rest := strings.TrimSpace(s[end+1:])
+		if rest != "" && rest[0] != ',' {
+			return nil, fmt.Errorf("expected a comma after regular expression %s", s[:end+1])
+		}
+		s = strings.TrimPrefix(rest, ",")
+	}
+
+	return &rules, nil
+}
+
+// redact applies the rules to a decoded JSON value.
+func (r *captureRules) redact(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if r.fields[strings.ToLower(k)] {
+				v[k] = redacted
+			} else {
+				v[k] = r.redact(e)
+			}
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = r.redact(e)
+		}
+	case string:
+		for _, re := range r.patterns {
+			v = re.ReplaceAllString(v, redacted)
+		}
+		return v
+	}
+
+	return v
+}
+
+// redactBody applies the rules to a body which is JSON, one JSON object per
+// line, or lines of server-sent events with JSON data. Other lines are only
+// matched against the regular expressions.
+func (r *captureRules) redactBody(body string) string {
+	if len(r.fields) == 0 && len(r.patterns) == 0 {
+		return body
+	}
+
+	lines := strings.SplitAfter(body, "\n")
+	for i, line := range lines {
+		content := strings.TrimRight(line, "\r\n")
+		prefix, data := "", content
+		if rest, ok := strings.CutPrefix(content, "data: "); ok {
+			prefix, data = "data: ", rest
+		}
+
+		var v any
+		if err := json.Unmarshal([]byte(data), &v); err == nil {
+			if b, err := json.Marshal(r.redact(v)); err == nil {
+				lines[i] = prefix + string(b) + line[len(content):]
+				continue
+			}
+		}
+
+		for _, re := range r.patterns {
+			lines[i] = re.ReplaceAllString(lines[i], redacted)
+		}
+	}
+
+	return strings.Join(lines, "")
+}
+
+var unsafeNameRegexp = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// capturesMu guards the captures directory, whose oldest captures are
+// deleted once it has more than OLLAMA_CAPTURE_MAX.
+var capturesMu sync.Mutex
+
+// writeCapture writes capture to dir and deletes the oldest captures past
+// the limit. Captures are named by when they were made, so they sort from
+// oldest to newest.
+func writeCapture(dir string, capture *api.Capture) error {
+	b, err := json.Marshal(capture)
+	if err != nil {
+		return err
+	}
+
+	capturesMu.Lock()
+	defer capturesMu.Unlock()
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	// request IDs are chosen by clients, so only some of their characters
+	// are used in names
+	id := unsafeNameRegexp.ReplaceAllString(capture.ID, "_")
+	name := fmt.Sprintf("%020d-%s.json", capture.CreatedAt.UnixNano(), id[:min(len(id), 64)])
+	if err := os.WriteFile(filepath.Join(dir, name), b, 0o600); err != nil {
+		return err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	// Glob sorts its matches
+	for _, p := range matches[:max(len(matches)-int(envconfig.CaptureMax()), 0)] {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// captureMiddleware records requests and their responses to the
+// OLLAMA_CAPTURE directory, leaving out what the OLLAMA_CAPTURE_REDACT rules
+// match. Headers aren't recorded, so API keys never are.
+func captureMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dir := envconfig.Capture()
+		if dir == "" || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		rules, err := parseCaptureRules(envconfig.CaptureRedact())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("invalid OLLAMA_CAPTURE_REDACT: %v", err)})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(strings.NewReader(string(body)))
+
+		capture := api.Capture{
+			CreatedAt: time.Now().UTC(),
+			Method:    c.Request.Method,
+			Path:      c.Request.URL.RequestURI(),
+		}
+
+		w := &cacheWriter{ResponseWriter: c.Writer, limit: maxCaptureSize}
+		c.Writer = w
+		defer func() { c.Writer = w.ResponseWriter }()
+
+		c.Next()
+
+		capture.Duration = time.Since(capture.CreatedAt)
+		capture.ID = cmp.Or(c.Writer.Header().Get("X-Request-ID"), uuid.NewString())
+		capture.Tenant = c.GetString(tenantKey)
+		capture.Status = c.Writer.Status()
+		capture.Response = rules.redactBody(w.body.String())
+		capture.Truncated = w.limit < 0
+
+		if request := rules.redactBody(string(body)); json.Valid([]byte(request)) {
+			capture.Request = json.RawMessage(request)
+		} else {
+			capture.Request, _ = json.Marshal(request)
+		}
+
+		if err := writeCapture(dir, &capture); err != nil {
+			slog.Warn("failed to write capture", "error", err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestParseCaptureRules(t *testing.T) {
+	rules, err := parseCaptureRules(`images, System ,/\d{1,3}-\d{4}/, /a\/b/,key`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, field := range []string{"images", "system", "key"} {
+		if !rules.fields[field] {
+			t.Errorf("expected field %q", field)
+		}
+	}
+
+	if len(rules.patterns) != 2 || rules.patterns[0].String() != `\d{1,3}-\d{4}` || rules.patterns[1].String() != "a/b" {
+		t.Errorf("unexpected patterns %v", rules.patterns)
+	}
+
+	for _, s := range []string{`/abc`, `/[/`, `/abc/ images`} {
+		if _, err := parseCaptureRules(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	rules, err := parseCaptureRules(`images,/\d{3}-\d{4}/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		`{"prompt":"call 555-1234","images":["aGk="]}`:                      `{"images":"[REDACTED]","prompt":"call [REDACTED]"}`,
+		"{\"response\":\"555-\"}\n{\"response\":\"1234\"}\n":                "{\"response\":\"555-\"}\n{\"response\":\"1234\"}\n",
+		"data: {\"choices\":[{\"text\":\"555-1234\"}]}\n\ndata: [DONE]\n\n": "data: {\"choices\":[{\"text\":\"[REDACTED]\"}]}\n\ndata: [DONE]\n\n",
+		"not json 555-1234": "not json [REDACTED]",
+	}
+
+	for body, want := range cases {
+		if got := rules.redactBody(body); got != want {
+			t.Errorf("%q: expected %q, got %q", body, want, got)
+		}
+	}
+}
+
+func TestCaptureMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	t.Setenv("OLLAMA_CAPTURE", dir)
+	t.Setenv("OLLAMA_CAPTURE_MAX", "2")
+	t.Setenv("OLLAMA_CAPTURE_REDACT", `images,/\d{3}-\d{4}/`)
+
+	var s Server
+	r := gin.New()
+	r.POST("/api/generate", captureMiddleware(), s.requestMiddleware(), func(c *gin.Context) {
+		var req api.GenerateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, api.GenerateResponse{Model: req.Model, Response: "you said " + req.Prompt, Done: true})
+	})
+
+	generate := func(id, body string) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(body))
+		req.Header.Set("X-Request-ID", id)
+		req.Header.Set("Authorization", "Bearer secret")
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+	}
+
+	generate("first", `{"model":"test","prompt":"hi"}`)
+	generate("second", `{"model":"test","prompt":"call 555-1234","images":["aGk="]}`)
+	generate("../../third", `{"model":"test","prompt":"bye"}`)
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matches) != 2 || !strings.HasSuffix(matches[0], "-second.json") || !strings.HasSuffix(matches[1], "-______third.json") {
+		t.Fatalf("expected the two newest captures, got %v", matches)
+	}
+
+	b, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(b), "secret") {
+		t.Error("expected headers not to be recorded")
+	}
+
+	var capture api.Capture
+	if err := json.Unmarshal(b, &capture); err != nil {
+		t.Fatal(err)
+	}
+
+	if capture.ID != "second" || capture.Method != http.MethodPost || capture.Path != "/api/generate" || capture.Status != http.StatusOK {
+		t.Errorf("unexpected capture %+v", capture)
+	}
+
+	if got, want := string(capture.Request), `{"images":"[REDACTED]","model":"test","prompt":"call [REDACTED]"}`; got != want {
+		t.Errorf("expected request %s, got %s", want, got)
+	}
+
+	var resp api.GenerateResponse
+	if err := json.Unmarshal([]byte(capture.Response), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Response != "you said call [REDACTED]" {
+		t.Errorf("expected the response to be redacted, got %q", resp.Response)
+	}
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+var errInvalidChat = errors.New("invalid chat")
+
+const (
+	defaultChatsLimit = 20
+	maxChatsLimit     = 100
+)
+
+var chatNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+// chatsMu guards the chats directory, so a chat isn't read while it's
+// being replaced.
+var chatsMu sync.Mutex
+
+// chatsDir is the directory of the chats of tenant. Each namespace of API
+// keys has its own, so its chats can't be seen with the keys of another.
+func chatsDir(tenant string) string {
+	dir := filepath.Join(envconfig.Models(), "chats")
+	if tenant != "" {
+		dir = filepath.Join(dir, strings.ToLower(tenant))
+	}
+	return dir
+}
+
+func chatPath(tenant, name string) (string, error) {
+	if !chatNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("%w: name %q must be letters, digits, '_', '.' or '-'", errInvalidChat, name)
+	}
+
+	return filepath.Join(chatsDir(tenant), name+".json"), nil
+}
+
+func readChat(p string) (*api.SavedChat, error) {
+	bts, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var chat api.SavedChat
+	if err := json.Unmarshal(bts, &chat); err != nil {
+		return nil, fmt.Errorf("%w %s: %w", errInvalidChat, p, err)
+	}
+
+	return &chat, nil
+}
+
+func getChat(tenant, name string) (*api.SavedChat, error) {
+	p, err := chatPath(tenant, name)
+	if err != nil {
+		return nil, err
+	}
+
+	chatsMu.Lock()
+	defer chatsMu.Unlock()
+	return readChat(p)
+}
+
+// saveChat saves req as the chat name, keeping when it was created if it
+// replaces a chat.
+func saveChat(tenant, name string, req api.SaveChatRequest) (*api.SavedChat, error) {
+	p, err := chatPath(tenant, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Model == "" {
+		return nil, fmt.Errorf("%w: model is required", errInvalidChat)
+	} else if !model.ParseName(req.Model).IsValid() {
+		return nil, fmt.Errorf("%w: invalid model name %q", errInvalidChat, req.Model)
+	}
+
+	chatsMu.Lock()
+	defer chatsMu.Unlock()
+
+	now := time.Now().UTC()
+	chat := api.SavedChat{
+		Name:      name,
+		Model:     req.Model,
+		Messages:  req.Messages,
+		Options:   req.Options,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if chat.Messages == nil {
+		chat.Messages = []api.Message{}
+	}
+
+	if old, err := readChat(p); err == nil {
+		chat.CreatedAt = old.CreatedAt
+	} else if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, errInvalidChat) {
+		return nil, err
+	}
+
+	bts, err := json.Marshal(chat)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return nil, err
+	}
+
+	// replace the file so it's never left partly written
+	if err := os.WriteFile(p+".tmp", bts, 0o644); err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(p+".tmp", p); err != nil {
+		return nil, err
+	}
+
+	return &chat, nil
+}
+
+func deleteChat(tenant, name string) error {
+	p, err := chatPath(tenant, name)
+	if err != nil {
+		return err
+	}
+
+	chatsMu.Lock()
+	defer chatsMu.Unlock()
+	return os.Remove(p)
+}
+
+// listChats lists the chats of tenant which match req, the most recently
+// saved first.
+func listChats(tenant string, req api.ListChatsRequest) (*api.ListChatsResponse, error) {
+	chatsMu.Lock()
+	defer chatsMu.Unlock()
+
+	entries, err := os.ReadDir(chatsDir(tenant))
+	if errors.Is(err, os.ErrNotExist) {
+		return &api.ListChatsResponse{Chats: []api.ChatSummary{}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	query := strings.ToLower(req.Query)
+	var chats []*api.SavedChat
+	for _, e := range entries {
+		if _, ok := strings.CutSuffix(e.Name(), ".json"); !ok || e.IsDir() {
+			continue
+		}
+
+		chat, err := readChat(filepath.Join(chatsDir(tenant), e.Name()))
+		if errors.Is(err, errInvalidChat) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if query == "" || strings.Contains(strings.ToLower(chat.Name), query) || slices.ContainsFunc(chat.Messages, func(m api.Message) bool {
+			return strings.Contains(strings.ToLower(m.Content), query)
+		}) {
+			chats = append(chats, chat)
+		}
+	}
+
+	slices.SortFunc(chats, func(a, b *api.SavedChat) int {
+		return cmp.Or(b.UpdatedAt.Compare(a.UpdatedAt), cmp.Compare(a.Name, b.Name))
+	})
+
+	resp := api.ListChatsResponse{Chats: []api.ChatSummary{}, Total: len(chats)}
+	limit := cmp.Or(req.Limit, defaultChatsLimit)
+	for _, chat := range chats[min(req.Offset, len(chats)):min(req.Offset+limit, len(chats))] {
+		resp.Chats = append(resp.Chats, api.ChatSummary{
+			Name:      chat.Name,
+			Model:     chat.Model,
+			Messages:  len(chat.Messages),
+			CreatedAt: chat.CreatedAt,
+			UpdatedAt: chat.UpdatedAt,
+		})
+	}
+
+	return &resp, nil
+}
+
+// ListChatsHandler lists the saved chats a page at a time. The q query
+// parameter searches them, and limit and offset choose the page.
+func (s *Server) ListChatsHandler(c *gin.Context) {
+	var req api.ListChatsRequest
+	req.Query = c.Query("q")
+	for _, p := range []struct {
+		key string
+		n   *int
+	}{{"limit", &req.Limit}, {"offset", &req.Offset}} {
+		if q := c.Query(p.key); q != "" {
+			n, err := strconv.Atoi(q)
+			if err != nil || n < 0 {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be a number which isn't negative", p.key)})
+				return
+			}
+			*p.n = n
+		}
+	}
+
+	if req.Limit > maxChatsLimit {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be at most %d", maxChatsLimit)})
+		return
+	}
+
+	resp, err := listChats(c.GetString(tenantKey), req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) GetChatHandler(c *gin.Context) {
+	chat, err := getChat(c.GetString(tenantKey), c.Param("name"))
+	if err != nil {
+		handleChatError(c, c.Param("name"), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, chat)
+}
+
+// SaveChatHandler saves a chat, replacing the chat with the same name.
+func (s *Server) SaveChatHandler(c *gin.Context) {
+	var req api.SaveChatRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	chat, err := saveChat(c.GetString(tenantKey), c.Param("name"), req)
+	if err != nil {
+		handleChatError(c, c.Param("name"), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, chat)
+}
+
+func (s *Server) DeleteChatHandler(c *gin.Context) {
+	if err := deleteChat(c.GetString(tenantKey), c.Param("name")); err != nil {
+		handleChatError(c, c.Param("name"), err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func handleChatError(c *gin.Context, name string, err error) {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeNotFound, fmt.Sprintf("chat '%s' not found", name)))
+	case errors.Is(err, errInvalidChat):
+		c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeInvalidRequest, err.Error()))
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestChats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if tenant := c.GetHeader("X-Tenant"); tenant != "" {
+			c.Set(tenantKey, tenant)
+		}
+	})
+	r.GET("/api/chats", s.ListChatsHandler)
+	r.GET("/api/chats/:name", s.GetChatHandler)
+	r.PUT("/api/chats/:name", s.SaveChatHandler)
+	r.DELETE("/api/chats/:name", s.DeleteChatHandler)
+
+	do := func(tenant, method, path string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+
+		var b bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&b).Encode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		req := httptest.NewRequest(method, path, &b)
+		if tenant != "" {
+			req.Header.Set("X-Tenant", tenant)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	list := func(tenant, query string) api.ListChatsResponse {
+		t.Helper()
+
+		w := do(tenant, http.MethodGet, "/api/chats"+query, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.ListChatsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	names := func(resp api.ListChatsResponse) []string {
+		names := []string{}
+		for _, c := range resp.Chats {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+
+	if diff := cmp.Diff(list("", ""), api.ListChatsResponse{Chats: []api.ChatSummary{}}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	for _, chat := range []struct {
+		name    string
+		content string
+	}{
+		{"trip", "Plan a trip to Lisbon"},
+		{"recipes", "How do I make bread?"},
+		{"lisbon-hotels", "Where should I stay?"},
+	} {
+		w := do("", http.MethodPut, "/api/chats/"+chat.name, api.SaveChatRequest{
+			Model:    "llama3.2",
+			Messages: []api.Message{{Role: "user", Content: chat.content}, {Role: "assistant", Content: "..."}},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+		}
+	}
+
+	if diff := cmp.Diff(names(list("", "")), []string{"lisbon-hotels", "recipes", "trip"}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		resp := list("", "?limit=2&offset=1")
+		if resp.Total != 3 {
+			t.Errorf("expected a total of 3, got %d", resp.Total)
+		}
+
+		if diff := cmp.Diff(names(resp), []string{"recipes", "trip"}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if got := names(list("", "?offset=5")); len(got) != 0 {
+			t.Errorf("expected no chats past the last, got %v", got)
+		}
+	})
+
+	t.Run("search", func(t *testing.T) {
+		resp := list("", "?q=LISBON")
+		if diff := cmp.Diff(names(resp), []string{"lisbon-hotels", "trip"}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if resp.Total != 2 || resp.Chats[0].Messages != 2 {
+			t.Errorf("unexpected response %+v", resp)
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		w := do("", http.MethodGet, "/api/chats/trip", nil)
+		var before api.SavedChat
+		if err := json.NewDecoder(w.Body).Decode(&before); err != nil {
+			t.Fatal(err)
+		}
+
+		w = do("", http.MethodPut, "/api/chats/trip", api.SaveChatRequest{
+			Model:    "qwen3",
+			Messages: append(before.Messages, api.Message{Role: "user", Content: "And Porto?"}),
+			Options:  map[string]any{"temperature": 0.5},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+		}
+
+		w = do("", http.MethodGet, "/api/chats/trip", nil)
+		var after api.SavedChat
+		if err := json.NewDecoder(w.Body).Decode(&after); err != nil {
+			t.Fatal(err)
+		}
+
+		if after.Model != "qwen3" || len(after.Messages) != 3 || after.Options["temperature"] != 0.5 {
+			t.Errorf("unexpected chat %+v", after)
+		}
+
+		if !after.CreatedAt.Equal(before.CreatedAt) || !after.UpdatedAt.After(before.UpdatedAt) {
+			t.Errorf("expected the chat to keep when it was created, got %v and %v", before.CreatedAt, after.CreatedAt)
+		}
+
+		if got := names(list("", "")); got[0] != "trip" {
+			t.Errorf("expected the most recently saved chat first, got %v", got)
+		}
+	})
+
+	t.Run("tenants", func(t *testing.T) {
+		if got := names(list("team-a", "")); len(got) != 0 {
+			t.Errorf("expected a tenant not to see other chats, got %v", got)
+		}
+
+		if w := do("team-a", http.MethodGet, "/api/chats/trip", nil); w.Code != http.StatusNotFound {
+			t.Errorf("expected status code 404, actual %d", w.Code)
+		}
+
+		if w := do("team-a", http.MethodPut, "/api/chats/trip", api.SaveChatRequest{Model: "llama3.2"}); w.Code != http.StatusOK {
+			t.Errorf("expected status code 200, actual %d: %s", w.Code, w.Body)
+		}
+
+		if diff := cmp.Diff(names(list("team-a", "")), []string{"trip"}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if w := do("", http.MethodDelete, "/api/chats/recipes", nil); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+		}
+
+		if w := do("", http.MethodDelete, "/api/chats/recipes", nil); w.Code != http.StatusNotFound {
+			t.Errorf("expected status code 404, actual %d", w.Code)
+		}
+
+		if diff := cmp.Diff(names(list("", "")), []string{"trip", "lisbon-hotels"}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, tt := range []struct {
+			method, path string
+			body         any
+		}{
+			{http.MethodPut, "/api/chats/.hidden", api.SaveChatRequest{Model: "llama3.2"}},
+			{http.MethodPut, "/api/chats/trip", api.SaveChatRequest{}},
+			{http.MethodGet, "/api/chats?limit=-1", nil},
+			{http.MethodGet, "/api/chats?limit=1000", nil},
+		} {
+			if w := do("", tt.method, tt.path, tt.body); w.Code != http.StatusBadRequest {
+				t.Errorf("%s %s: expected status code 400, actual %d", tt.method, tt.path, w.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/ollama/ollama/api"
+)
+
+// checkChunking returns an error if inputs can't be split into chunks as c
+// describes.
+func checkChunking(c *api.EmbedChunking) error {
+	switch {
+	case c == nil:
+		return nil
+	case c.Size < 0:
+		return errors.New("chunk size must not be negative")
+	case c.Overlap < 0:
+		return errors.New("chunk overlap must not be negative")
+	case c.Size > 0 && c.Overlap >= c.Size:
+		return errors.New("chunk overlap must be less than the chunk size")
+	}
+
+	return nil
+}
+
+// chunkTokens splits tokens into chunks of size tokens, each of which starts
+// with the last overlap tokens of the one before it. The last chunk can be
+// shorter, and overlap is reduced if it isn't less than size.
+func chunkTokens(tokens []int, size, overlap int) [][]int {
+	if overlap >= size {
+		overlap = size - 1
+	}
+
+	var chunks [][]int
+	for start := 0; start < len(tokens); start += size - overlap {
+		end := min(start+size, len(tokens))
+		chunks = append(chunks, tokens[start:end])
+		if end == len(tokens) {
+			break
+		}
+	}
+
+	return chunks
+}
+
+// pool returns the average of embeddings, each weighted by the number of
+// tokens of its chunk.
+func pool(embeddings [][]float32, weights []int) []float32 {
+	if len(embeddings) == 0 {
+		return nil
+	}
+
+	var total float32
+	pooled := make([]float32, len(embeddings[0]))
+	for i, embedding := range embeddings {
+		w := float32(weights[i])
+		for j, v := range embedding {
+			pooled[j] += v * w
+		}
+		total += w
+	}
+
+	if total > 0 {
+		for j := range pooled {
+			pooled[j] /= total
+		}
+	}
+
+	return pooled
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestChunkTokens(t *testing.T) {
+	tokens := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+
+	cases := []struct {
+		name          string
+		tokens        []int
+		size, overlap int
+		expect        [][]int
+	}{
+		{"no overlap", tokens, 4, 0, [][]int{{0, 1, 2, 3}, {4, 5, 6, 7}, {8, 9}}},
+		{"overlap", tokens, 4, 2, [][]int{{0, 1, 2, 3}, {2, 3, 4, 5}, {4, 5, 6, 7}, {6, 7, 8, 9}}},
+		{"exact", tokens, 5, 0, [][]int{{0, 1, 2, 3, 4}, {5, 6, 7, 8, 9}}},
+		{"one chunk", tokens, 16, 4, [][]int{tokens}},
+		{"overlap too large", tokens[:4], 2, 2, [][]int{{0, 1}, {1, 2}, {2, 3}}},
+		{"empty", nil, 4, 0, nil},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.expect, chunkTokens(tt.tokens, tt.size, tt.overlap)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckChunking(t *testing.T) {
+	cases := []struct {
+		chunking *api.EmbedChunking
+		ok       bool
+	}{
+		{nil, true},
+		{&api.EmbedChunking{}, true},
+		{&api.EmbedChunking{Size: 512, Overlap: 64}, true},
+		{&api.EmbedChunking{Overlap: 64}, true},
+		{&api.EmbedChunking{Size: -1}, false},
+		{&api.EmbedChunking{Size: 512, Overlap: -1}, false},
+		{&api.EmbedChunking{Size: 64, Overlap: 64}, false},
+	}
+
+	for _, tt := range cases {
+		if err := checkChunking(tt.chunking); (err == nil) != tt.ok {
+			t.Errorf("checkChunking(%+v): unexpected error %v", tt.chunking, err)
+		}
+	}
+}
+
+func TestPool(t *testing.T) {
+	pooled := pool([][]float32{{1, 0}, {0, 1}}, []int{3, 1})
+	if diff := cmp.Diff([]float32{0.75, 0.25}, pooled); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if pooled := pool(nil, nil); pooled != nil {
+		t.Errorf("expected nil, got %v", pooled)
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+const defaultHypothesisTemplate = "This example is {}."
+
+// classifier is the classification head of a sequence classification model,
+// which scores labels from the pooled output of the model. The pooler is
+// optional.
+type classifier struct {
+	labels     []string
+	multiLabel bool
+
+	poolerWeight, poolerBias []float32
+	outputWeight, outputBias []float32
+}
+
+func newClassifier(kv ggml.KV) (*classifier, error) {
+	floats := func(key string) []float32 {
+		if _, ok := kv[kv.Architecture()+"."+key]; !ok {
+			return nil
+		}
+
+		return kv.Floats(key)
+	}
+
+	c := classifier{
+		labels:       kv.Strings("classifier.output_labels"),
+		multiLabel:   kv.Bool("classifier.multi_label"),
+		poolerWeight: floats("classifier.pooler.weight"),
+		poolerBias:   floats("classifier.pooler.bias"),
+		outputWeight: floats("classifier.output.weight"),
+		outputBias:   floats("classifier.output.bias"),
+	}
+
+	if len(c.labels) == 0 || len(c.outputWeight) == 0 || len(c.outputWeight)%len(c.labels) != 0 {
+		return nil, errors.New("invalid classifier")
+	}
+
+	return &c, nil
+}
+
+// logits returns the score of each label of c for pooled, the pooled output
+// of the model.
+func (c *classifier) logits(pooled []float32) ([]float32, error) {
+	if len(c.outputWeight) != len(c.labels)*len(pooled) ||
+		c.poolerWeight != nil && len(c.poolerWeight) != len(pooled)*len(pooled) {
+		return nil, fmt.Errorf("classifier doesn't match embedding length %d", len(pooled))
+	}
+
+	x := pooled
+	if c.poolerWeight != nil {
+		x = linear(c.poolerWeight, c.poolerBias, x)
+		for i := range x {
+			x[i] = float32(math.Tanh(float64(x[i])))
+		}
+	}
+
+	return linear(c.outputWeight, c.outputBias, x), nil
+}
+
+// scores returns the labels of c scored by their logits, from most to least
+// likely.
+func (c *classifier) scores(logits []float32, multiLabel bool) []api.ClassifyLabel {
+	var probs []float32
+	if multiLabel {
+		probs = make([]float32, len(logits))
+		for i, v := range logits {
+			probs[i] = sigmoid(v)
+		}
+	} else {
+		probs = softmax(logits)
+	}
+
+	labels := make([]api.ClassifyLabel, len(c.labels))
+	for i, label := range c.labels {
+		labels[i] = api.ClassifyLabel{Label: label, Score: probs[i]}
+	}
+
+	return sortLabels(labels)
+}
+
+// nliLabels returns the indices of the entailment and contradiction labels
+// of c, which zero-shot classification needs, or -1 if c doesn't have them.
+func (c *classifier) nliLabels() (entailment, contradiction int) {
+	index := func(prefix string) int {
+		return slices.IndexFunc(c.labels, func(s string) bool {
+			return strings.HasPrefix(strings.ToLower(s), prefix)
+		})
+	}
+
+	return index("entail"), index("contradict")
+}
+
+// zeroShotScores scores candidates by the logits of whether the input
+// entails the hypothesis of each of them, from most to least likely. If
+// multiLabel is set, each candidate is scored by entailment against
+// contradiction, otherwise candidates are scored against each other.
+func (c *classifier) zeroShotScores(candidates []string, logits [][]float32, multiLabel bool) []api.ClassifyLabel {
+	entailment, contradiction := c.nliLabels()
+
+	labels := make([]api.ClassifyLabel, len(candidates))
+	entailments := make([]float32, len(candidates))
+	for i, candidate := range candidates {
+		labels[i].Label = candidate
+		entailments[i] = logits[i][entailment]
+
+		if multiLabel {
+			if contradiction < 0 {
+				labels[i].Score = sigmoid(entailments[i])
+			} else {
+				labels[i].Score = softmax([]float32{logits[i][contradiction], entailments[i]})[1]
+			}
+		}
+	}
+
+	if !multiLabel {
+		for i, p := range softmax(entailments) {
+			labels[i].Score = p
+		}
+	}
+
+	return sortLabels(labels)
+}
+
+// hypothesis returns the zero-shot hypothesis of label.
+func hypothesis(template, label string) string {
+	return strings.ReplaceAll(cmp.Or(template, defaultHypothesisTemplate), "{}", label)
+}
+
+func sortLabels(labels []api.ClassifyLabel) []api.ClassifyLabel {
+	slices.SortStableFunc(labels, func(a, b api.ClassifyLabel) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+
+	return labels
+}
+
+// linear returns weight x + bias, where weight is row major with a row for
+// each output.
+func linear(weight, bias, x []float32) []float32 {
+	out := make([]float32, len(weight)/len(x))
+	for i := range out {
+		out[i] = dot(weight[i*len(x):(i+1)*len(x)], x)
+		if i < len(bias) {
+			out[i] += bias[i]
+		}
+	}
+
+	return out
+}
+
+func softmax(x []float32) []float32 {
+	if len(x) == 0 {
+		return nil
+	}
+
+	m := slices.Max(x)
+
+	var sum float64
+	out := make([]float32, len(x))
+	for i, v := range x {
+		e := math.Exp(float64(v - m))
+		out[i] = float32(e)
+		sum += e
+	}
+
+	for i := range out {
+		out[i] = float32(float64(out[i]) / sum)
+	}
+
+	return out
+}
+
+func sigmoid(x float32) float32 {
+	return float32(1 / (1 + math.Exp(-float64(x))))
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+func TestClassifier(t *testing.T) {
+	p, _ := createBinFile(t, ggml.KV{
+		"general.architecture":               "bert",
+		"bert.pooling_type":                  uint32(2),
+		"bert.classifier.output_labels":      []string{"negative", "positive"},
+		"bert.classifier.multi_label":        false,
+		"bert.classifier.pooler.weight":      []float32{1, 0, 0, 2},
+		"bert.classifier.pooler.bias":        []float32{0, 1},
+		"bert.classifier.output.weight":      []float32{1, -1, -1, 1},
+		"bert.classifier.output.bias":        []float32{0.5, 0},
+		"tokenizer.ggml.seperator_token_id":  uint32(0),
+		"tokenizer.ggml.tokens":              []string{"[SEP]"},
+		"tokenizer.ggml.scores":              []float32{0},
+		"tokenizer.ggml.token_type":          []int32{0},
+		"tokenizer.ggml.token_type_count":    uint32(2),
+		"tokenizer.ggml.add_seperator_token": true,
+	}, nil)
+
+	kv, _, err := getModelData(p, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := newClassifier(kv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logits, err := c.logits([]float32{0.5, 0.25})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x := []float64{math.Tanh(0.5), math.Tanh(1.5)}
+	expect := []float32{float32(x[0] - x[1] + 0.5), float32(x[1] - x[0])}
+	if diff := cmp.Diff(expect, logits, cmpopts.EquateApprox(0, 1e-6)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := c.logits([]float32{1, 2, 3}); err == nil {
+		t.Error("expected error for embedding of the wrong length")
+	}
+
+	scores := c.scores([]float32{0, float32(math.Log(3))}, false)
+	if diff := cmp.Diff([]api.ClassifyLabel{{Label: "positive", Score: 0.75}, {Label: "negative", Score: 0.25}}, scores, cmpopts.EquateApprox(0, 1e-6)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	scores = c.scores([]float32{0, float32(math.Log(3))}, true)
+	if diff := cmp.Diff([]api.ClassifyLabel{{Label: "positive", Score: 0.75}, {Label: "negative", Score: 0.5}}, scores, cmpopts.EquateApprox(0, 1e-6)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := newClassifier(ggml.KV{"general.architecture": "bert"}); err == nil {
+		t.Error("expected error for model without a classifier")
+	}
+}
+
+func TestZeroShotScores(t *testing.T) {
+	c := classifier{labels: []string{"CONTRADICTION", "NEUTRAL", "ENTAILMENT"}}
+
+	if entailment, contradiction := c.nliLabels(); entailment != 2 || contradiction != 0 {
+		t.Fatalf("expected entailment 2 and contradiction 0, got %d and %d", entailment, contradiction)
+	}
+
+	logits := [][]float32{
+		{0, 5, 0},
+		{0, 5, float32(math.Log(3))},
+	}
+
+	scores := c.zeroShotScores([]string{"sports", "politics"}, logits, false)
+	if diff := cmp.Diff([]api.ClassifyLabel{{Label: "politics", Score: 0.75}, {Label: "sports", Score: 0.25}}, scores, cmpopts.EquateApprox(0, 1e-6)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	scores = c.zeroShotScores([]string{"sports", "politics"}, logits, true)
+	if diff := cmp.Diff([]api.ClassifyLabel{{Label: "politics", Score: 0.75}, {Label: "sports", Score: 0.5}}, scores, cmpopts.EquateApprox(0, 1e-6)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if entailment, _ := (&classifier{labels: []string{"negative", "positive"}}).nliLabels(); entailment != -1 {
+		t.Errorf("expected no entailment label, got %d", entailment)
+	}
+}
+
+func TestHypothesis(t *testing.T) {
+	if h := hypothesis("", "sports"); h != "This example is sports." {
+		t.Errorf("unexpected hypothesis %q", h)
+	}
+
+	if h := hypothesis("The topic is {}", "sports"); h != "The topic is sports" {
+		t.Errorf("unexpected hypothesis %q", h)
+	}
+}
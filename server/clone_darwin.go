@@ -0,0 +1,8 @@
+package server
+
+import "golang.org/x/sys/unix"
+
+// cloneFile makes dst a copy-on-write clone of src, which APFS supports.
+func cloneFile(src, dst string) error {
+	return unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW)
+}
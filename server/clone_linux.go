@@ -0,0 +1,30 @@
+package server
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dst a copy-on-write clone of src, which filesystems such
+// as btrfs and XFS support.
+func cloneFile(src, dst string) error {
+	s, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	d, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if err := unix.IoctlFileClone(int(d.Fd()), int(s.Fd())); err != nil {
+		d.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	return d.Close()
+}
@@ -0,0 +1,373 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+var (
+	errCollectionExists  = errors.New("collection already exists")
+	errInvalidCollection = errors.New("invalid collection")
+)
+
+const (
+	metricCosine       = "cosine"
+	metricInnerProduct = "ip"
+)
+
+var collectionNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+// collectionsMu guards the collections, which are kept in memory once
+// they've been read from their files.
+var (
+	collectionsMu sync.Mutex
+	collections   = make(map[string]*collection)
+)
+
+// collection is a collection of embeddings which is stored as a single file
+// in the collections directory and replaced whenever it changes.
+type collection struct {
+	Name       string                `json:"name"`
+	Dimensions int                   `json:"dimensions"`
+	Metric     string                `json:"metric"`
+	Points     []api.CollectionPoint `json:"points"`
+
+	// index is the index of each point in Points by its ID and norms are
+	// the lengths of their embeddings
+	index map[string]int
+	norms []float32
+}
+
+// collectionsDir is the directory of the collections of tenant. Like chats,
+// each namespace of API keys has its own.
+func collectionsDir(tenant string) string {
+	dir := filepath.Join(envconfig.Models(), "collections")
+	if tenant != "" {
+		dir = filepath.Join(dir, strings.ToLower(tenant))
+	}
+	return dir
+}
+
+func collectionPath(tenant, name string) (string, error) {
+	if !collectionNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("%w: name %q must be lowercase letters, digits, '_', '.' or '-'", errInvalidCollection, name)
+	}
+
+	return filepath.Join(collectionsDir(tenant), name+".json"), nil
+}
+
+// loadCollection returns the collection of tenant named name. It must be
+// called with collectionsMu held.
+func loadCollection(tenant, name string) (*collection, string, error) {
+	p, err := collectionPath(tenant, name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if c, ok := collections[p]; ok {
+		return c, p, nil
+	}
+
+	bts, err := os.ReadFile(p)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var c collection
+	if err := json.Unmarshal(bts, &c); err != nil {
+		return nil, "", fmt.Errorf("invalid collection %s: %w", p, err)
+	}
+
+	c.reindex()
+	collections[p] = &c
+	return &c, p, nil
+}
+
+func (c *collection) reindex() {
+	c.index = make(map[string]int, len(c.Points))
+	c.norms = make([]float32, len(c.Points))
+	for i, p := range c.Points {
+		c.index[p.ID] = i
+		c.norms[i] = norm(p.Embedding)
+	}
+}
+
+// write replaces the file of c so it's never left partly written.
+func (c *collection) write(p string) error {
+	bts, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(p+".tmp", bts, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(p+".tmp", p)
+}
+
+func createCollection(tenant, name string, dimensions int, metric string) error {
+	switch {
+	case dimensions < 0:
+		return fmt.Errorf("%w: dimensions must not be negative", errInvalidCollection)
+	case metric == "":
+		metric = metricCosine
+	case metric != metricCosine && metric != metricInnerProduct:
+		return fmt.Errorf("%w: metric must be %q or %q", errInvalidCollection, metricCosine, metricInnerProduct)
+	}
+
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+
+	if _, _, err := loadCollection(tenant, name); err == nil {
+		return errCollectionExists
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	p, err := collectionPath(tenant, name)
+	if err != nil {
+		return err
+	}
+
+	c := &collection{Name: name, Dimensions: dimensions, Metric: metric, Points: []api.CollectionPoint{}}
+	if err := c.write(p); err != nil {
+		return err
+	}
+
+	c.reindex()
+	collections[p] = c
+	return nil
+}
+
+func deleteCollection(tenant, name string) error {
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+
+	p, err := collectionPath(tenant, name)
+	if err != nil {
+		return err
+	}
+
+	delete(collections, p)
+	return os.Remove(p)
+}
+
+func listCollections(tenant string) ([]api.Collection, error) {
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+
+	entries, err := os.ReadDir(collectionsDir(tenant))
+	if errors.Is(err, os.ErrNotExist) {
+		return []api.Collection{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	list := []api.Collection{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+
+		c, _, err := loadCollection(tenant, name)
+		if errors.Is(err, errInvalidCollection) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		list = append(list, api.Collection{
+			Name:       c.Name,
+			Dimensions: c.Dimensions,
+			Metric:     c.Metric,
+			Count:      len(c.Points),
+		})
+	}
+
+	slices.SortFunc(list, func(a, b api.Collection) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return list, nil
+}
+
+// upsertPoints adds points to the collection of tenant named name, replacing
+// its points with the same IDs. Either all of the points are added or none
+// are.
+func upsertPoints(tenant, name string, points []api.CollectionPoint) error {
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+
+	c, p, err := loadCollection(tenant, name)
+	if err != nil {
+		return err
+	}
+
+	dimensions := c.Dimensions
+	for _, point := range points {
+		if point.ID == "" {
+			return fmt.Errorf("%w: points must have an id", errInvalidCollection)
+		}
+
+		if dimensions == 0 {
+			dimensions = len(point.Embedding)
+		}
+
+		if len(point.Embedding) == 0 || len(point.Embedding) != dimensions {
+			return fmt.Errorf("%w: embedding of %q has %d dimensions, want %d", errInvalidCollection, point.ID, len(point.Embedding), dimensions)
+		}
+	}
+
+	updated := *c
+	updated.Dimensions = dimensions
+	updated.Points = slices.Clone(c.Points)
+	updated.index = maps.Clone(c.index)
+	for _, point := range points {
+		if i, ok := updated.index[point.ID]; ok {
+			updated.Points[i] = point
+		} else {
+			updated.index[point.ID] = len(updated.Points)
+			updated.Points = append(updated.Points, point)
+		}
+	}
+
+	if err := updated.write(p); err != nil {
+		return err
+	}
+
+	updated.reindex()
+	collections[p] = &updated
+	return nil
+}
+
+// deletePoints deletes the points with ids from the collection of tenant
+// named name. IDs which aren't in the collection are ignored.
+func deletePoints(tenant, name string, ids []string) error {
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+
+	c, p, err := loadCollection(tenant, name)
+	if err != nil {
+		return err
+	}
+
+	deleted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		deleted[id] = true
+	}
+
+	updated := *c
+	updated.Points = slices.DeleteFunc(slices.Clone(c.Points), func(point api.CollectionPoint) bool {
+		return deleted[point.ID]
+	})
+
+	if err := updated.write(p); err != nil {
+		return err
+	}
+
+	updated.reindex()
+	collections[p] = &updated
+	return nil
+}
+
+func searchCollection(tenant string, req api.SearchCollectionRequest) ([]api.CollectionResult, error) {
+	collectionsMu.Lock()
+	defer collectionsMu.Unlock()
+
+	c, _, err := loadCollection(tenant, req.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Dimensions > 0 && len(req.Embedding) != c.Dimensions {
+		return nil, fmt.Errorf("%w: embedding has %d dimensions, want %d", errInvalidCollection, len(req.Embedding), c.Dimensions)
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = 10
+	}
+
+	qnorm := norm(req.Embedding)
+	results := []api.CollectionResult{}
+	for i, point := range c.Points {
+		if !matchesFilter(point.Metadata, req.Filter) {
+			continue
+		}
+
+		score := dot(req.Embedding, point.Embedding)
+		if c.Metric == metricCosine {
+			if qnorm == 0 || c.norms[i] == 0 {
+				score = 0
+			} else {
+				score /= qnorm * c.norms[i]
+			}
+		}
+
+		results = append(results, api.CollectionResult{
+			ID:       point.ID,
+			Score:    score,
+			Text:     point.Text,
+			Metadata: point.Metadata,
+		})
+	}
+
+	slices.SortStableFunc(results, func(a, b api.CollectionResult) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+
+	return results[:min(limit, len(results))], nil
+}
+
+// matchesFilter returns whether metadata has each key of filter, with the
+// value in filter or one of its values if it's an array.
+func matchesFilter(metadata, filter map[string]any) bool {
+	for k, want := range filter {
+		have, ok := metadata[k]
+		if !ok {
+			return false
+		}
+
+		if values, ok := want.([]any); ok {
+			if !slices.ContainsFunc(values, func(v any) bool { return reflect.DeepEqual(have, v) }) {
+				return false
+			}
+		} else if !reflect.DeepEqual(have, want) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range min(len(a), len(b)) {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func norm(v []float32) float32 {
+	return float32(math.Sqrt(float64(dot(v, v))))
+}
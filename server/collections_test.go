@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestCollections(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+
+	list := func() []api.Collection {
+		t.Helper()
+
+		w := createRequest(t, s.ListCollectionsHandler, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.ListCollectionsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.Collections
+	}
+
+	search := func(req api.SearchCollectionRequest) []string {
+		t.Helper()
+
+		w := createRequest(t, s.SearchCollectionHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.SearchCollectionResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		var ids []string
+		for _, r := range resp.Results {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	if diff := cmp.Diff([]api.Collection{}, list()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, req := range []api.CollectionRequest{
+		{Name: "docs"},
+		{Name: "ip", Dimensions: 2, Metric: "ip"},
+	} {
+		if w := createRequest(t, s.CreateCollectionHandler, req); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+		}
+	}
+
+	for _, req := range []api.CollectionRequest{
+		{Name: "docs"},
+		{Name: "Docs"},
+		{Name: "../docs"},
+		{Name: "l2", Metric: "l2"},
+	} {
+		if w := createRequest(t, s.CreateCollectionHandler, req); w.Code != http.StatusBadRequest {
+			t.Errorf("%+v: expected status code 400, actual %d", req, w.Code)
+		}
+	}
+
+	w := createRequest(t, s.UpsertPointsHandler, api.UpsertPointsRequest{
+		Collection: "docs",
+		Points: []api.CollectionPoint{
+			{ID: "a", Embedding: []float32{1, 0}, Text: "a", Metadata: map[string]any{"lang": "en"}},
+			{ID: "b", Embedding: []float32{1, 1}, Metadata: map[string]any{"lang": "fr"}},
+			{ID: "c", Embedding: []float32{0, 3}, Metadata: map[string]any{"lang": "en", "page": 2}},
+		},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+	}
+
+	w = createRequest(t, s.UpsertPointsHandler, api.UpsertPointsRequest{
+		Collection: "docs",
+		Points:     []api.CollectionPoint{{ID: "d", Embedding: []float32{1, 2, 3}}},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status code 400, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.UpsertPointsHandler, api.UpsertPointsRequest{
+		Collection: "missing",
+		Points:     []api.CollectionPoint{{ID: "a", Embedding: []float32{1, 0}}},
+	})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status code 404, actual %d", w.Code)
+	}
+
+	if diff := cmp.Diff([]string{"a", "b", "c"}, search(api.SearchCollectionRequest{Collection: "docs", Embedding: []float32{1, 0.1}})); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]string{"c"}, search(api.SearchCollectionRequest{Collection: "docs", Embedding: []float32{0, 1}, Limit: 1})); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]string{"c", "a"}, search(api.SearchCollectionRequest{Collection: "docs", Embedding: []float32{0, 1}, Filter: map[string]any{"lang": "en"}})); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]string{"c"}, search(api.SearchCollectionRequest{Collection: "docs", Embedding: []float32{0, 1}, Filter: map[string]any{"page": []any{2, 3}, "lang": "en"}})); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	// points are replaced by their ID and the collection is persisted
+	w = createRequest(t, s.UpsertPointsHandler, api.UpsertPointsRequest{
+		Collection: "docs",
+		Points:     []api.CollectionPoint{{ID: "a", Embedding: []float32{0, 1}}},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+	}
+
+	clear(collections)
+
+	if diff := cmp.Diff([]string{"a", "c", "b"}, search(api.SearchCollectionRequest{Collection: "docs", Embedding: []float32{0, 1}})); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if w := createRequest(t, s.DeletePointsHandler, api.DeletePointsRequest{Collection: "docs", IDs: []string{"a", "x"}}); w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+	}
+
+	expect := []api.Collection{
+		{Name: "docs", Dimensions: 2, Metric: "cosine", Count: 2},
+		{Name: "ip", Dimensions: 2, Metric: "ip", Count: 0},
+	}
+	if diff := cmp.Diff(expect, list()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if w := createRequest(t, s.DeleteCollectionHandler, api.CollectionRequest{Name: "docs"}); w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+	}
+
+	if w := createRequest(t, s.DeleteCollectionHandler, api.CollectionRequest{Name: "docs"}); w.Code != http.StatusNotFound {
+		t.Errorf("expected status code 404, actual %d", w.Code)
+	}
+
+	if w := createRequest(t, s.SearchCollectionHandler, api.SearchCollectionRequest{Collection: "docs", Embedding: []float32{0, 1}}); w.Code != http.StatusNotFound {
+		t.Errorf("expected status code 404, actual %d", w.Code)
+	}
+}
+
+func TestMatchesFilter(t *testing.T) {
+	metadata := map[string]any{"lang": "en", "page": float64(2), "draft": false}
+
+	cases := []struct {
+		filter map[string]any
+		match  bool
+	}{
+		{nil, true},
+		{map[string]any{"lang": "en"}, true},
+		{map[string]any{"lang": "fr"}, false},
+		{map[string]any{"lang": "en", "page": float64(2)}, true},
+		{map[string]any{"page": []any{float64(1), float64(2)}}, true},
+		{map[string]any{"page": []any{float64(3)}}, false},
+		{map[string]any{"draft": false}, true},
+		{map[string]any{"author": "x"}, false},
+	}
+
+	for _, tt := range cases {
+		if match := matchesFilter(metadata, tt.filter); match != tt.match {
+			t.Errorf("matchesFilter(%v): have %v, want %v", tt.filter, match, tt.match)
+		}
+	}
+}
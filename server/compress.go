@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/ollama/ollama/format"
+)
+
+const (
+	// zstdEncoding is the content coding negotiated with registries for
+	// compressed layer transfers.
+	zstdEncoding = "zstd"
+
+	// minCompressSize is the smallest layer the registry is asked to send
+	// compressed. Smaller layers are cheap enough to download in parts.
+	minCompressSize int64 = 100 * format.MegaByte
+)
+
+// acceptsEncoding reports whether the Accept-Encoding values in header list
+// the coding, leaving out codings with a quality value of zero.
+func acceptsEncoding(header http.Header, coding string) bool {
+	for _, v := range header.Values("Accept-Encoding") {
+		for _, s := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(s, ";")
+			if !strings.EqualFold(strings.TrimSpace(name), coding) {
+				continue
+			}
+
+			for _, param := range strings.Split(params, ";") {
+				k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if q, err := strconv.ParseFloat(v, 64); strings.EqualFold(k, "q") && err == nil && q == 0 {
+					return false
+				}
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// downloadBlobCompressed asks the registry for opts.digest compressed with
+// zstd and decompresses it while it downloads, verifying the digest of the
+// decompressed blob. It reports whether the blob was downloaded; if it
+// wasn't, the caller should download the uncompressed blob. Registries which
+// don't compress the blob send it uncompressed, which is left for the
+// caller since it downloads in parallel parts which can be resumed.
+func downloadBlobCompressed(ctx context.Context, opts downloadOpts) (bool, error) {
+	fp, err := GetBlobsPath(opts.digest)
+	if err != nil {
+		return false, err
+	}
+
+	// compressed downloads can't be resumed, so a download which is running
+	// or was interrupted carries on uncompressed
+	if _, ok := blobDownloadManager.Load(opts.digest); ok {
+		return false, nil
+	}
+
+	if parts, _ := filepath.Glob(fp + "-partial-*"); len(parts) > 0 {
+		return false, nil
+	}
+
+	requestURL := opts.mp.BaseURL()
+	requestURL = requestURL.JoinPath("v2", opts.mp.GetNamespaceRepository(), "blobs", opts.digest)
+
+	headers := make(http.Header)
+	headers.Set("Accept-Encoding", zstdEncoding)
+
+	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, headers, nil, opts.regOpts)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return false, err
+	case err != nil:
+		slog.Warn("failed to request compressed blob, downloading uncompressed", "digest", opts.digest, "error", err)
+		return false, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !strings.EqualFold(resp.Header.Get("Content-Encoding"), zstdEncoding) {
+		return false, nil
+	}
+
+	if err := decompressBlob(ctx, fp, opts, resp); err != nil {
+		if errors.Is(err, context.Canceled) {
+			return false, err
+		}
+
+		slog.Warn("failed to download compressed blob, downloading uncompressed", "digest", opts.digest, "error", err)
+		return false, nil
+	}
+
+	slog.Info("downloaded compressed blob", "digest", opts.digest, "size", format.HumanBytes(opts.size), "compressed", format.HumanBytes(resp.ContentLength))
+	return true, nil
+}
+
+func decompressBlob(ctx context.Context, fp string, opts downloadOpts, resp *http.Response) error {
+	temp, err := os.CreateTemp(filepath.Dir(fp), "sha256-")
+	if err != nil {
+		return err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	// progress is reported against the compressed size since that's what
+	// is being downloaded
+	w := &blobProgressWriter{digest: opts.digest, total: max(resp.ContentLength, 0), fn: opts.fn}
+	r := &rateLimitedReader{ctx: ctx, r: io.TeeReader(resp.Body, w), limiters: downloadLimiters(opts.regOpts)}
+
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	// read one byte past the size in the manifest so a blob which
+	// decompresses to more than it should is caught before it fills the disk
+	sha256sum := sha256.New()
+	n, err := io.Copy(io.MultiWriter(temp, sha256sum), io.LimitReader(zr, opts.size+1))
+	if err != nil {
+		return err
+	}
+
+	if n != opts.size {
+		return fmt.Errorf("size mismatch, expected %d, got %d", opts.size, n)
+	}
+
+	if digest := fmt.Sprintf("sha256:%x", sha256sum.Sum(nil)); digest != opts.digest {
+		return fmt.Errorf("digest mismatch, expected %q, got %q", opts.digest, digest)
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	w.report()
+	return os.Rename(temp.Name(), fp)
+}
+
+// compressReader compresses r with zstd as it's read.
+func compressReader(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		zw, err := zstd.NewWriter(pw, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		if _, err := io.Copy(zw, r); err != nil {
+			zw.Close()
+			pw.CloseWithError(err)
+			return
+		}
+
+		pw.CloseWithError(zw.Close())
+	}()
+
+	return pr
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	cases := map[string]bool{
+		"":                     false,
+		"zstd":                 true,
+		"gzip, ZSTD":           true,
+		"gzip;q=1, zstd;q=0.5": true,
+		"zstd;q=0":             false,
+		"zstd; q=0.000":        false,
+		"gzip":                 false,
+		"zstdx":                false,
+	}
+
+	for v, want := range cases {
+		header := make(http.Header)
+		header.Set("Accept-Encoding", v)
+		if got := acceptsEncoding(header, zstdEncoding); got != want {
+			t.Errorf("%q: expected %t, got %t", v, want, got)
+		}
+	}
+}
+
+func TestDownloadBlobCompressed(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	blob := bytes.Repeat([]byte("f16 weights compress well "), 1000)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+
+	compress := func(b []byte) []byte {
+		zw, err := zstd.NewWriter(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zw.Close()
+		return zw.EncodeAll(b, nil)
+	}
+
+	cases := []struct {
+		name   string
+		body   []byte
+		encode bool
+		expect bool
+	}{
+		{"compressed", compress(blob), true, true},
+		{"uncompressed", blob, false, false},
+		{"digest mismatch", compress(bytes.ToUpper(blob)), true, false},
+		{"too large", compress(append(blob, blob...)), true, false},
+		{"corrupt", compress(blob)[:100], true, false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v2/library/test/blobs/"+digest {
+					http.NotFound(w, r)
+					return
+				}
+
+				if tt.encode && r.Header.Get("Accept-Encoding") == "zstd" {
+					w.Header().Set("Content-Encoding", "zstd")
+				}
+
+				w.Write(tt.body)
+			}))
+			defer srv.Close()
+
+			ok, err := downloadBlobCompressed(context.Background(), downloadOpts{
+				mp:      ParseModelPath(srv.URL + "/library/test:latest"),
+				digest:  digest,
+				size:    int64(len(blob)),
+				regOpts: &registryOptions{},
+				fn:      func(api.ProgressResponse) {},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ok != tt.expect {
+				t.Fatalf("expected %t, got %t", tt.expect, ok)
+			}
+
+			fp, err := GetBlobsPath(digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bts, err := os.ReadFile(fp)
+			switch {
+			case !tt.expect && !os.IsNotExist(err):
+				t.Errorf("expected blob to not exist, got %v", err)
+			case tt.expect && err != nil:
+				t.Fatal(err)
+			case tt.expect && !bytes.Equal(bts, blob):
+				t.Error("expected the decompressed blob")
+			}
+
+			os.Remove(fp)
+		})
+	}
+
+	t.Run("interrupted", func(t *testing.T) {
+		fp, err := GetBlobsPath(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(fp+"-partial-0", []byte("{}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(fp + "-partial-0")
+
+		var requested bool
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = true
+		}))
+		defer srv.Close()
+
+		ok, err := downloadBlobCompressed(context.Background(), downloadOpts{
+			mp:      ParseModelPath(srv.URL + "/library/test:latest"),
+			digest:  digest,
+			size:    int64(len(blob)),
+			regOpts: &registryOptions{},
+			fn:      func(api.ProgressResponse) {},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ok || requested {
+			t.Error("expected an interrupted download to be resumed uncompressed")
+		}
+	})
+}
+
+func TestBlobUploadCompressed(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	blob := bytes.Repeat([]byte("f16 weights compress well "), 1000)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(p, blob, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		// accept is the Accept-Encoding of the upload session
+		accept string
+		// reject is whether compressed parts are rejected anyway
+		reject bool
+		expect string
+	}{
+		{"compressed", "zstd", false, "zstd"},
+		{"uncompressed", "", false, ""},
+		{"rejected", "zstd", true, ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var uploaded bytes.Buffer
+			var encodings []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				switch r.Method {
+				case http.MethodPost:
+					if tt.accept != "" {
+						w.Header().Set("Accept-Encoding", tt.accept)
+					}
+					w.Header().Set("Location", "http://"+r.Host+"/upload/1")
+					w.WriteHeader(http.StatusAccepted)
+				case http.MethodPatch:
+					encoding := r.Header.Get("Content-Encoding")
+					encodings = append(encodings, encoding)
+
+					var body io.Reader = r.Body
+					switch {
+					case encoding == "zstd" && tt.reject:
+						w.WriteHeader(http.StatusUnsupportedMediaType)
+						return
+					case encoding == "zstd":
+						zr, err := zstd.NewReader(r.Body)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						defer zr.Close()
+						body = zr
+					case r.ContentLength != int64(len(blob)):
+						t.Errorf("expected Content-Length %d, got %d", len(blob), r.ContentLength)
+					}
+
+					if got, want := r.Header.Get("Content-Range"), fmt.Sprintf("0-%d", len(blob)-1); got != want {
+						t.Errorf("expected Content-Range %q, got %q", want, got)
+					}
+
+					if _, err := io.Copy(&uploaded, body); err != nil {
+						t.Error(err)
+					}
+
+					w.Header().Set("Location", "http://"+r.Host+"/upload/2")
+					w.WriteHeader(http.StatusAccepted)
+				case http.MethodPut:
+					w.WriteHeader(http.StatusCreated)
+				}
+			}))
+			defer srv.Close()
+
+			requestURL, err := url.Parse(srv.URL + "/v2/library/test/blobs/uploads/")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b := &blobUpload{Layer: Layer{Digest: digest, Size: int64(len(blob))}}
+			if err := b.Prepare(context.Background(), requestURL, &registryOptions{}); err != nil {
+				t.Fatal(err)
+			}
+
+			b.Run(context.Background(), &registryOptions{})
+			if b.err != nil {
+				t.Fatal(b.err)
+			}
+
+			if !bytes.Equal(uploaded.Bytes(), blob) {
+				t.Error("expected the registry to receive the blob")
+			}
+
+			if last := encodings[len(encodings)-1]; last != tt.expect {
+				t.Errorf("expected the part to be uploaded with encoding %q, got %q", tt.expect, strings.Join(encodings, ","))
+			}
+		})
+	}
+}
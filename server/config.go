@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// logLevel is the level of the server's logs, which follows OLLAMA_DEBUG as
+// it's reloaded.
+var logLevel slog.LevelVar
+
+// applyConfig applies settings which were read once to the changed
+// environment. Those read as they're needed, such as the default keep alive
+// and the maximum number of loaded models, apply on their own.
+func (s *Server) applyConfig() {
+	level := slog.LevelInfo
+	if envconfig.Debug() {
+		level = slog.LevelDebug
+	}
+	logLevel.Set(level)
+
+	s.origins.Store(newOriginPolicy())
+}
+
+// reloadConfig reloads the configuration file and applies it.
+func (s *Server) reloadConfig() error {
+	if err := envconfig.Reload(); err != nil {
+		return err
+	}
+
+	s.applyConfig()
+	slog.Info("reloaded config", "env", configValues())
+	return nil
+}
+
+// reloadLoop reloads the configuration file on SIGHUP until ctx is done.
+func (s *Server) reloadLoop(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := s.reloadConfig(); err != nil {
+				slog.Error("failed to reload config, keeping the current config", "error", err)
+			}
+		}
+	}
+}
+
+func configValues() map[string]string {
+	values := envconfig.Values()
+
+	config := make(map[string]string)
+	for _, key := range envconfig.Reloadable() {
+		config[key] = values[key]
+	}
+
+	return config
+}
+
+// ConfigHandler returns the settings which can be changed while the server
+// is running, along with the rest of the configuration and any problems
+// with it.
+func (s *Server) ConfigHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, api.ConfigResponse{
+		Settings: configValues(),
+		Env:      envconfig.Effective(),
+		Warnings: envconfig.Check(),
+	})
+}
+
+// SetConfigHandler changes settings while the server is running. Only
+// clients on the same host may change them.
+func (s *Server) SetConfigHandler(c *gin.Context) {
+	if !isLocalRequest(c) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "changing the config is only allowed from localhost"})
+		return
+	}
+
+	var req api.ConfigRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := envconfig.Set(req.Settings); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s.applyConfig()
+	slog.Info("changed config", "env", configValues())
+	c.JSON(http.StatusOK, api.ConfigResponse{Settings: configValues()})
+}
+
+// isLocalRequest reports whether the client of c is on the same host.
+func isLocalRequest(c *gin.Context) bool {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		host = c.Request.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
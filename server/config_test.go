@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestSetConfigHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_ORIGINS", "")
+	t.Setenv("OLLAMA_DEBUG", "0")
+	t.Setenv("OLLAMA_KEEP_ALIVE", "5m")
+	t.Cleanup(func() { logLevel.Set(slog.LevelInfo) })
+
+	var s Server
+	router, err := s.GenerateRoutes(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setConfig := func(remoteAddr, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/config", strings.NewReader(body))
+		req.RemoteAddr = remoteAddr
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	allowed := func() bool {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/version", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		router.ServeHTTP(w, req)
+		return w.Header().Get("Access-Control-Allow-Origin") == "https://app.example.com"
+	}
+
+	if allowed() {
+		t.Fatal("expected the origin not to be allowed")
+	}
+
+	if w := setConfig("10.0.0.5:1234", `{"settings": {"OLLAMA_ORIGINS": "https://app.example.com"}}`); w.Code != http.StatusForbidden {
+		t.Errorf("expected status code 403, actual %d", w.Code)
+	}
+
+	if w := setConfig("127.0.0.1:1234", `{"settings": {"OLLAMA_HOST": "0.0.0.0"}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status code 400, actual %d", w.Code)
+	}
+
+	w := setConfig("127.0.0.1:1234", `{"settings": {"OLLAMA_ORIGINS": "https://app.example.com", "OLLAMA_DEBUG": "1", "OLLAMA_KEEP_ALIVE": "1h"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+	t.Cleanup(func() {
+		setConfig("127.0.0.1:1234", `{"settings": {"OLLAMA_ORIGINS": "", "OLLAMA_DEBUG": "", "OLLAMA_KEEP_ALIVE": ""}}`)
+	})
+
+	var resp api.ConfigResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Settings["OLLAMA_KEEP_ALIVE"] != "1h0m0s" {
+		t.Errorf("expected the new keep alive, got %q", resp.Settings["OLLAMA_KEEP_ALIVE"])
+	}
+
+	if !allowed() {
+		t.Error("expected the origin to be allowed once it's added")
+	}
+
+	t.Run("get", func(t *testing.T) {
+		t.Setenv("OLLAMA_WEBHOOK_SECRET", "secret")
+		t.Setenv("OLLAMA_MAX_QUEUE", "lots")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/config", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		var resp api.ConfigResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Env["OLLAMA_KEEP_ALIVE"] != "1h0m0s" || resp.Env["OLLAMA_WEBHOOK_SECRET"] != "********" {
+			t.Errorf("unexpected env %v", resp.Env)
+		}
+
+		if !slices.Contains(resp.Warnings, `OLLAMA_MAX_QUEUE: invalid number "lots", expected a whole number of at least 0`) {
+			t.Errorf("expected a warning about OLLAMA_MAX_QUEUE, got %q", resp.Warnings)
+		}
+	})
+
+	if logLevel.Level() != slog.LevelDebug {
+		t.Errorf("expected debug logs, got %v", logLevel.Level())
+	}
+}
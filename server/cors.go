@@ -0,0 +1,201 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// corsHeaders are the request headers browsers may send from other origins.
+var corsHeaders = []string{
+	"Authorization",
+	"Content-Type",
+	"User-Agent",
+	"Accept",
+	"X-Requested-With",
+
+	// OpenAI compatibility headers
+	"x-stainless-lang",
+	"x-stainless-package-version",
+	"x-stainless-os",
+	"x-stainless-arch",
+	"x-stainless-retry-count",
+	"x-stainless-runtime",
+	"x-stainless-runtime-version",
+	"x-stainless-async",
+	"x-stainless-helper-method",
+	"x-stainless-poll-helper",
+	"x-stainless-custom-poll-interval",
+	"x-stainless-timeout",
+}
+
+// inferencePaths are the endpoints in the inference scope, whatever the
+// method. The agent, which runs tools and commands on the server, and changes
+// to collections are left to the manage scope.
+var inferencePaths = []string{
+	"/api/generate",
+	"/api/chat",
+	"/api/fim",
+	"/api/embed",
+	"/api/embeddings",
+	"/api/classify",
+	"/api/moderations",
+	"/api/chats",
+	"/api/collections/search",
+	"/v1/chat/completions",
+	"/v1/completions",
+	"/v1/embeddings",
+}
+
+// readPaths are the endpoints in the read scope when they're called with
+// GET or HEAD.
+var readPaths = []string{
+	"/",
+	"/api/version",
+	"/healthz",
+	"/readyz",
+	"/api/tags",
+	"/api/ps",
+	"/api/pull",
+	"/api/aliases",
+	"/api/adapters",
+	"/api/splits",
+	"/api/collections",
+	"/v1/models",
+}
+
+// readPostPaths are the endpoints in the read scope which take a request
+// body, so they're called with POST.
+var readPostPaths = []string{
+	"/api/show",
+	"/api/template/render",
+	"/api/sbom",
+	"/api/search",
+}
+
+// originScope is the scope an origin needs to call method on path from a
+// browser. Endpoints which aren't listed manage models or the server, so
+// that new ones aren't exposed to browsers until they're added here.
+func originScope(method, path string) string {
+	switch {
+	case slices.Contains(inferencePaths, path),
+		strings.HasPrefix(path, "/api/chats/"),
+		strings.HasPrefix(path, "/api/requests/") && strings.HasSuffix(path, "/cancel"):
+		return envconfig.ScopeInference
+	case (method == http.MethodGet || method == http.MethodHead) &&
+		(slices.Contains(readPaths, path) || strings.HasPrefix(path, "/v1/models/")),
+		method == http.MethodPost && slices.Contains(readPostPaths, path):
+		return envconfig.ScopeRead
+	default:
+		return envconfig.ScopeManage
+	}
+}
+
+// originPolicy is the CORS policy of the origins in OLLAMA_ORIGINS: which
+// scopes of the API browsers at each origin may call.
+type originPolicy struct {
+	origins []envconfig.Origin
+
+	// handlers apply the policy to the endpoints of each scope
+	handlers map[string]gin.HandlerFunc
+}
+
+func newOriginPolicy() *originPolicy {
+	p := &originPolicy{
+		origins:  envconfig.Origins(),
+		handlers: make(map[string]gin.HandlerFunc),
+	}
+
+	for _, scope := range []string{envconfig.ScopeInference, envconfig.ScopeRead, envconfig.ScopeManage} {
+		config := cors.DefaultConfig()
+		config.AllowHeaders = corsHeaders
+		config.AllowOriginFunc = func(origin string) bool { return p.allowed(origin, scope) }
+		config.MaxAge = envconfig.OriginsMaxAge()
+		p.handlers[scope] = cors.New(config)
+	}
+
+	return p
+}
+
+// allowed reports whether browsers at origin may call the endpoints in
+// scope.
+func (p *originPolicy) allowed(origin, scope string) bool {
+	for _, o := range p.origins {
+		if slices.Contains(o.Scopes, scope) && matchOrigin(o.Pattern, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// check reports whether r may be served, which it may unless it's from a
+// browser at another origin which may not call its endpoint.
+func (p *originPolicy) check(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "http://"+r.Host || origin == "https://"+r.Host {
+		return true
+	}
+
+	return p.allowed(origin, originScope(requestMethod(r), r.URL.Path))
+}
+
+// requestMethod is the method of r, or of the request a preflight request
+// asks about.
+func requestMethod(r *http.Request) string {
+	if r.Method == http.MethodOptions {
+		if method := r.Header.Get("Access-Control-Request-Method"); method != "" {
+			return method
+		}
+	}
+
+	return r.Method
+}
+
+// matchOrigin reports whether origin matches pattern, in which * matches any
+// characters.
+func matchOrigin(pattern, origin string) bool {
+	if pattern == "*" {
+		return true
+	}
+
+	pattern, origin = strings.ToLower(pattern), strings.ToLower(origin)
+	prefix, suffix, ok := strings.Cut(pattern, "*")
+	if !ok {
+		return pattern == origin
+	}
+
+	return len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
+
+// corsMiddleware applies the CORS policy to the endpoint of each request, so
+// that browsers at an origin can only call the scopes it's allowed.
+func (s *Server) corsMiddleware() gin.HandlerFunc {
+	if s.origins.Load() == nil {
+		s.origins.Store(newOriginPolicy())
+	}
+
+	return func(c *gin.Context) {
+		s.origins.Load().handlers[originScope(requestMethod(c.Request), c.Request.URL.Path)](c)
+	}
+}
+
+// originHandler applies the CORS policy to h, which doesn't serve its
+// requests through the router, by rejecting those browsers at other origins
+// may not make. Responses to those which are allowed have CORS headers only
+// if they're served by the router.
+func (s *Server) originHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := s.origins.Load(); p != nil && !p.check(r) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+func TestOriginScope(t *testing.T) {
+	cases := []struct {
+		method, path string
+		scope        string
+	}{
+		{http.MethodPost, "/api/chat", envconfig.ScopeInference},
+		{http.MethodPost, "/v1/chat/completions", envconfig.ScopeInference},
+		{http.MethodPut, "/api/chats/notes", envconfig.ScopeInference},
+		{http.MethodPost, "/api/requests/abc/cancel", envconfig.ScopeInference},
+		{http.MethodPost, "/api/collections/search", envconfig.ScopeInference},
+		{http.MethodGet, "/api/collections", envconfig.ScopeRead},
+		{http.MethodPost, "/api/collections", envconfig.ScopeManage},
+		{http.MethodDelete, "/api/collections", envconfig.ScopeManage},
+		{http.MethodPost, "/api/collections/points", envconfig.ScopeManage},
+		{http.MethodDelete, "/api/collections/points", envconfig.ScopeManage},
+		{http.MethodPost, "/api/agent", envconfig.ScopeManage},
+		{http.MethodGet, "/api/agent/tools", envconfig.ScopeManage},
+		{http.MethodGet, "/api/tags", envconfig.ScopeRead},
+		{http.MethodHead, "/", envconfig.ScopeRead},
+		{http.MethodGet, "/v1/models/llama3", envconfig.ScopeRead},
+		{http.MethodPost, "/api/show", envconfig.ScopeRead},
+		{http.MethodGet, "/api/pull", envconfig.ScopeRead},
+		{http.MethodPost, "/api/pull", envconfig.ScopeManage},
+		{http.MethodPost, "/api/aliases", envconfig.ScopeManage},
+		{http.MethodDelete, "/api/delete", envconfig.ScopeManage},
+		{http.MethodPost, "/api/blobs/sha256:abc", envconfig.ScopeManage},
+		{http.MethodGet, "/api/blobs/sha256:abc", envconfig.ScopeManage},
+		{http.MethodPost, "/api/config", envconfig.ScopeManage},
+		{http.MethodGet, "/api/unknown", envconfig.ScopeManage},
+	}
+
+	for _, tt := range cases {
+		if scope := originScope(tt.method, tt.path); scope != tt.scope {
+			t.Errorf("%s %s: expected scope %s, got %s", tt.method, tt.path, tt.scope, scope)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_ORIGINS", "https://chat.example.com, https://admin.example.com=manage, https://*.dash.example.com=read")
+	t.Setenv("OLLAMA_ORIGINS_MAX_AGE", "10m")
+
+	var s Server
+	router, err := s.GenerateRoutes(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name           string
+		method, path   string
+		origin         string
+		preflight      string
+		code           int
+		allowed        bool
+		preflightCache string
+	}{
+		{"chat reads", http.MethodGet, "/api/version", "https://chat.example.com", "", http.StatusOK, true, ""},
+		{"chat preflight", http.MethodOptions, "/api/chat", "https://chat.example.com", http.MethodPost, http.StatusNoContent, true, "600"},
+		{"chat deletes", http.MethodDelete, "/api/delete", "https://chat.example.com", "", http.StatusForbidden, false, ""},
+		{"chat pull preflight", http.MethodOptions, "/api/pull", "https://chat.example.com", http.MethodPost, http.StatusForbidden, false, ""},
+		{"admin pull preflight", http.MethodOptions, "/api/pull", "https://admin.example.com", http.MethodPost, http.StatusNoContent, true, "600"},
+		{"admin reads", http.MethodGet, "/api/tags", "https://admin.example.com", "", http.StatusForbidden, false, ""},
+		{"dashboard reads", http.MethodGet, "/api/tags", "https://eu.dash.example.com", "", http.StatusOK, true, ""},
+		{"dashboard chats", http.MethodOptions, "/api/chat", "https://eu.dash.example.com", http.MethodPost, http.StatusForbidden, false, ""},
+		{"local reads", http.MethodGet, "/api/version", "http://localhost:3000", "", http.StatusOK, true, ""},
+		{"local pulls", http.MethodPost, "/api/pull", "http://localhost:3000", "", http.StatusForbidden, false, ""},
+		{"local agent", http.MethodPost, "/api/agent", "http://localhost:3000", "", http.StatusForbidden, false, ""},
+		{"local agent preflight", http.MethodOptions, "/api/agent", "http://localhost:3000", http.MethodPost, http.StatusForbidden, false, ""},
+		{"local collection upsert", http.MethodPost, "/api/collections/points", "http://localhost:3000", "", http.StatusForbidden, false, ""},
+		{"local collection search", http.MethodOptions, "/api/collections/search", "http://localhost:3000", http.MethodPost, http.StatusNoContent, true, "600"},
+		{"other origin", http.MethodGet, "/api/version", "https://evil.example.com", "", http.StatusForbidden, false, ""},
+		{"same origin", http.MethodDelete, "/api/delete", "http://localhost:11434", "", http.StatusBadRequest, false, ""},
+		{"no origin", http.MethodGet, "/api/tags", "", "", http.StatusOK, false, ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Host = "localhost:11434"
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.preflight)
+			}
+
+			router.ServeHTTP(w, req)
+			if w.Code != tt.code {
+				t.Errorf("expected status code %d, actual %d: %s", tt.code, w.Code, w.Body.String())
+			}
+
+			if allowed := tt.origin != "" && w.Header().Get("Access-Control-Allow-Origin") == tt.origin; allowed != tt.allowed {
+				t.Errorf("expected the origin to be allowed %t, got %t", tt.allowed, allowed)
+			}
+
+			if maxAge := w.Header().Get("Access-Control-Max-Age"); maxAge != tt.preflightCache {
+				t.Errorf("expected max age %q, got %q", tt.preflightCache, maxAge)
+			}
+		})
+	}
+}
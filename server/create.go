@@ -15,6 +15,8 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -27,6 +29,7 @@ import (
 	"github.com/ollama/ollama/template"
 	"github.com/ollama/ollama/types/errtypes"
 	"github.com/ollama/ollama/types/model"
+	"github.com/ollama/ollama/version"
 )
 
 var (
@@ -57,7 +60,7 @@ func (s *Server) CreateHandler(c *gin.Context) {
 
 	name := model.ParseName(cmp.Or(r.Model, r.Name))
 	if !name.IsValid() {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
+		c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse(api.ErrorCodeInvalidModelName, errtypes.InvalidModelNameErrMsg))
 		return
 	}
 
@@ -67,6 +70,7 @@ func (s *Server) CreateHandler(c *gin.Context) {
 		return
 	}
 
+	tenant := c.GetString(tenantKey)
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
@@ -74,14 +78,25 @@ func (s *Server) CreateHandler(c *gin.Context) {
 			ch <- resp
 		}
 
+		// other processes sharing the models directory mustn't prune the
+		// blobs of the model before its manifest is written
+		unlock, err := lockStore()
+		if err != nil {
+			ch <- gin.H{"error": err.Error()}
+			return
+		}
+		defer unlock()
+
 		oldManifest, _ := ParseNamedManifest(name)
 
+		provenance := api.Provenance{ModelfileDigest: r.ModelfileDigest}
+
 		var baseLayers []*layerGGML
 		if r.From != "" {
 			slog.Debug("create model from model name")
 			fromName := model.ParseName(r.From)
 			if !fromName.IsValid() {
-				ch <- gin.H{"error": errtypes.InvalidModelNameErrMsg, "status": http.StatusBadRequest}
+				ch <- gin.H{"error": errtypes.InvalidModelNameErrMsg, "code": api.ErrorCodeInvalidModelName, "status": http.StatusBadRequest}
 				return
 			}
 
@@ -91,10 +106,26 @@ func (s *Server) CreateHandler(c *gin.Context) {
 			baseLayers, err = parseFromModel(ctx, fromName, fn)
 			if err != nil {
 				ch <- gin.H{"error": err.Error()}
+				return
+			}
+
+			provenance.Parent, err = parentProvenance(fromName)
+			if err != nil {
+				ch <- gin.H{"error": err.Error()}
+				return
 			}
 		} else if r.Files != nil {
+			if detectModelTypeFromFiles(r.Files) == "safetensors" {
+				provenance.Converter = converterVersion()
+			}
+
 			baseLayers, err = convertModelFromFiles(r.Files, baseLayers, false, fn)
 			if err != nil {
+				if errors.Is(err, ggml.ErrInvalid) {
+					ch <- gin.H{"error": err.Error(), "code": errorCode(err), "status": http.StatusBadRequest}
+					return
+				}
+
 				for _, badReq := range []error{errNoFilesProvided, errOnlyGGUFSupported, errUnknownType} {
 					if errors.Is(err, badReq) {
 						ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
@@ -104,13 +135,53 @@ func (s *Server) CreateHandler(c *gin.Context) {
 				ch <- gin.H{"error": err.Error()}
 				return
 			}
+		} else if r.Remote != "" {
+			provenance.Converter = converterVersion()
+			baseLayers, provenance.SourceURL, err = convertFromRemote(c.Request.Context(), r.Remote, fn)
+			if errors.Is(err, fs.ErrNotExist) {
+				ch <- gin.H{"error": err.Error(), "status": http.StatusNotFound}
+				return
+			} else if err != nil {
+				ch <- gin.H{"error": err.Error()}
+				return
+			}
 		} else {
 			ch <- gin.H{"error": errNeitherFromOrFiles.Error(), "status": http.StatusBadRequest}
 			return
 		}
 
+		if len(r.MergeAdapters) > 0 {
+			var adapters []adapterMerge
+			for _, m := range r.MergeAdapters {
+				if detectModelTypeFromFiles(m.Files) == "safetensors" {
+					provenance.Converter = converterVersion()
+				}
+
+				layers, err := convertModelFromFiles(m.Files, baseLayers, true, fn)
+				if err == nil && (len(layers) != 1 || layers[0].MediaType != "application/vnd.ollama.image.adapter") {
+					err = errNotAnAdapter
+				}
+
+				if err != nil {
+					ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
+					return
+				}
+
+				adapters = append(adapters, adapterMerge{layers[0], m.Weight})
+			}
+
+			if baseLayers, err = mergeAdapters(c.Request.Context(), baseLayers, adapters, fn); err != nil {
+				ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
+				return
+			}
+		}
+
 		var adapterLayers []*layerGGML
 		if r.Adapters != nil {
+			if detectModelTypeFromFiles(r.Adapters) == "safetensors" {
+				provenance.Converter = converterVersion()
+			}
+
 			adapterLayers, err = convertModelFromFiles(r.Adapters, baseLayers, true, fn)
 			if err != nil {
 				for _, badReq := range []error{errNoFilesProvided, errOnlyOneAdapterSupported, errOnlyGGUFSupported, errUnknownType, errFilePath} {
@@ -128,21 +199,26 @@ func (s *Server) CreateHandler(c *gin.Context) {
 			baseLayers = append(baseLayers, adapterLayers...)
 		}
 
-		if err := createModel(r, name, baseLayers, fn); err != nil {
+		if err := createModel(c.Request.Context(), r, name, baseLayers, provenance, fn); err != nil {
 			if errors.Is(err, errBadTemplate) {
 				ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
 				return
 			}
-			ch <- gin.H{"error": err.Error()}
+			ch <- progressError(err)
 			return
 		}
 
+		// the layers of the old manifest can only be removed once no model
+		// is being written
+		unlock()
+
 		if !envconfig.NoPrune() && oldManifest != nil {
 			if err := oldManifest.RemoveLayers(); err != nil {
 				ch <- gin.H{"error": err.Error()}
 			}
 		}
 
+		emitWebhook(api.WebhookEvent{Event: api.WebhookModelCreated, Model: name.DisplayShortest(), Tenant: tenant})
 		ch <- api.ProgressResponse{Status: "success"}
 	}()
 
@@ -280,6 +356,69 @@ func convertFromSafetensors(files map[string]string, baseLayers []*layerGGML, is
 		}
 	}
 
+	return convertedLayers(t, mediaType, isAdapter)
+}
+
+// convertFromRemote converts the safetensors of a Hugging Face repository,
+// given as org/model or org/model@revision. Tensors are read from the Hub one
+// at a time so only the converted model is written to disk. It returns the
+// URL of the repository at the commit which was converted.
+func convertFromRemote(ctx context.Context, remote string, fn func(resp api.ProgressResponse)) ([]*layerGGML, string, error) {
+	repo, revision, _ := strings.Cut(remote, "@")
+	endpoint := strings.TrimSuffix(cmp.Or(envconfig.HFEndpoint(), "https://huggingface.co"), "/")
+	fsys, err := convert.NewHubReader(ctx, endpoint, repo, revision, envconfig.HFToken())
+	if err != nil {
+		return nil, "", err
+	}
+
+	source := fmt.Sprintf("%s/%s/tree/%s", endpoint, repo, cmp.Or(fsys.Commit(), revision, "main"))
+
+	t, err := os.CreateTemp("", "ollama-remote")
+	if err != nil {
+		return nil, "", err
+	}
+	defer os.Remove(t.Name())
+	defer t.Close()
+
+	status := fmt.Sprintf("converting %s", remote)
+	total := fsys.Size("*.safetensors")
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			fn(api.ProgressResponse{Status: status, Total: total, Completed: min(fsys.Downloaded(), total)})
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	err = convert.ConvertModel(fsys, t)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		return nil, "", err
+	}
+
+	layers, err := convertedLayers(t, "application/vnd.ollama.image.model", false)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return layers, source, nil
+}
+
+// convertedLayers creates the layers of a model or adapter which was
+// converted to t.
+func convertedLayers(t *os.File, mediaType string, isAdapter bool) ([]*layerGGML, error) {
 	if _, err := t.Seek(0, io.SeekStart); err != nil {
 		return nil, err
 	}
@@ -307,6 +446,45 @@ func convertFromSafetensors(files map[string]string, baseLayers []*layerGGML, is
 	return layers, nil
 }
 
+// converterVersion is recorded in the provenance of models whose weights are
+// converted to GGUF when they're created.
+func converterVersion() string {
+	return "ollama " + version.Version
+}
+
+// parentProvenance returns the provenance of a model created from the model
+// name, which includes the provenance of name itself.
+func parentProvenance(name model.Name) (*api.ProvenanceParent, error) {
+	m, err := ParseNamedManifest(name)
+	if err != nil {
+		return nil, err
+	}
+
+	parent := api.ProvenanceParent{Model: name.String(), Digest: m.digest}
+	if m.Config.Digest == "" {
+		return &parent, nil
+	}
+
+	p, err := fetchBlob(context.Background(), m.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var config ConfigV2
+	if err := json.NewDecoder(f).Decode(&config); err != nil {
+		return nil, err
+	}
+
+	parent.Provenance = config.Provenance
+	return &parent, nil
+}
+
 func kvFromLayers(baseLayers []*layerGGML) (ggml.KV, error) {
 	for _, l := range baseLayers {
 		if l.GGML != nil {
@@ -316,7 +494,7 @@ func kvFromLayers(baseLayers []*layerGGML) (ggml.KV, error) {
 	return ggml.KV{}, fmt.Errorf("no base model was found")
 }
 
-func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML, fn func(resp api.ProgressResponse)) (err error) {
+func createModel(ctx context.Context, r api.CreateRequest, name model.Name, baseLayers []*layerGGML, provenance api.Provenance, fn func(resp api.ProgressResponse)) (err error) {
 	config := ConfigV2{
 		OS:           "linux",
 		Architecture: "amd64",
@@ -343,6 +521,8 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 					if err != nil {
 						return err
 					}
+
+					provenance.Quantization = &api.ProvenanceQuantization{From: ft.String(), To: want.String()}
 				}
 			}
 			config.ModelFormat = cmp.Or(config.ModelFormat, layer.GGML.Name())
@@ -354,6 +534,10 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 		layers = append(layers, layer.Layer)
 	}
 
+	if provenance != (api.Provenance{}) {
+		config.Provenance = &provenance
+	}
+
 	if r.Template != "" {
 		layers, err = setTemplate(layers, r.Template)
 		if err != nil {
@@ -415,8 +599,22 @@ func createModel(r api.CreateRequest, name model.Name, baseLayers []*layerGGML,
 		}
 	}
 
+	if err := checkQuota(name, append(layers, *configLayer)); err != nil {
+		// remove the blobs created for the model unless other models use them
+		deleteMap := make(map[string]struct{})
+		for _, layer := range append(layers, *configLayer) {
+			deleteMap[layer.Digest] = struct{}{}
+		}
+
+		if err := deleteUnusedLayers(deleteMap); err != nil {
+			slog.Warn("failed to remove unused layers", "error", err)
+		}
+
+		return err
+	}
+
 	fn(api.ProgressResponse{Status: "writing manifest"})
-	if err := WriteManifest(name, *configLayer, layers); err != nil {
+	if err := WriteManifest(ctx, name, *configLayer, layers); err != nil {
 		return err
 	}
 
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// errDigestMismatch is returned for downloads which don't hash to the
+// digest they were downloaded as.
+var errDigestMismatch = errors.New("digest mismatch")
+
+// digester hashes a blob as it downloads. Parts of the blob download in
+// parallel, so it follows the start of the blob, hashing what has been
+// written up to the first part which is still downloading.
+type digester struct {
+	hash.Hash
+
+	// n is how much of the blob has been hashed
+	n int64
+}
+
+func newDigester() *digester {
+	return &digester{Hash: sha256.New()}
+}
+
+// hashTo hashes r up to offset.
+func (d *digester) hashTo(r io.ReaderAt, offset int64) error {
+	if offset <= d.n {
+		return nil
+	}
+
+	n, err := io.Copy(d.Hash, io.NewSectionReader(r, d.n, offset-d.n))
+	d.n += n
+	return err
+}
+
+// follow hashes r as parts are written to it until stop is closed.
+func (d *digester) follow(r io.ReaderAt, parts []*blobDownloadPart, stop <-chan struct{}) error {
+	parts = slices.Clone(parts)
+	slices.SortFunc(parts, func(a, b *blobDownloadPart) int { return cmp.Compare(a.Offset, b.Offset) })
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if err := d.hashTo(r, written(parts)); err != nil {
+			return err
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// written returns how much of the start of a blob has been written, which
+// is up to the part which is still downloading and what it's written.
+// parts must be sorted by offset.
+func written(parts []*blobDownloadPart) int64 {
+	var n int64
+	for _, part := range parts {
+		completed := part.Completed.Load()
+		n += completed
+		if completed < part.Size {
+			break
+		}
+	}
+
+	return n
+}
+
+// verify finishes hashing r, which is size bytes, and checks the hash
+// matches digest.
+func (d *digester) verify(r io.ReaderAt, size int64, digest string) error {
+	start := time.Now()
+	remaining := size - d.n
+	if err := d.hashTo(r, size); err != nil {
+		return err
+	}
+
+	slog.Debug("verified blob", "digest", digest, "remaining", remaining, "duration", time.Since(start))
+	if got := fmt.Sprintf("sha256:%x", d.Sum(nil)); got != digest {
+		return fmt.Errorf("%w: expected %q, got %q", errDigestMismatch, digest, got)
+	}
+
+	return nil
+}
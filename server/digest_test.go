@@ -0,0 +1,48 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestDigester(t *testing.T) {
+	blob := bytes.Repeat([]byte("0123456789"), 100)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+	r := bytes.NewReader(blob)
+
+	// parts are listed out of order, like part files sorted by name
+	b := &blobDownload{}
+	parts := []*blobDownloadPart{
+		{blobDownload: b, N: 1, Offset: 300, Size: 300},
+		{blobDownload: b, N: 0, Offset: 0, Size: 300},
+		{blobDownload: b, N: 2, Offset: 600, Size: 400},
+	}
+
+	parts[1].Completed.Store(300)
+	parts[0].Completed.Store(120)
+	parts[2].Completed.Store(400)
+
+	// what has been written is hashed before follow stops
+	d := newDigester()
+	stop := make(chan struct{})
+	close(stop)
+	if err := d.follow(r, parts, stop); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.n != 420 {
+		t.Errorf("expected the blob to be hashed up to the part still downloading, got %d", d.n)
+	}
+
+	if err := d.verify(r, int64(len(blob)), digest); err != nil {
+		t.Error(err)
+	}
+
+	d = newDigester()
+	if err := d.verify(r, int64(len(blob)), fmt.Sprintf("sha256:%x", sha256.Sum256(nil))); !errors.Is(err, errDigestMismatch) {
+		t.Errorf("expected a digest mismatch, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
@@ -26,6 +27,10 @@ import (
 	"github.com/ollama/ollama/format"
 )
 
+// downloadRateLimiter is shared by all downloads so OLLAMA_MAX_DOWNLOAD_RATE
+// and OLLAMA_DOWNLOAD_SCHEDULE cap the combined bandwidth of every pull.
+var downloadRateLimiter = newRateLimiter(downloadRate)
+
 const maxRetries = 6
 
 var (
@@ -39,11 +44,18 @@ type blobDownload struct {
 	Name   string
 	Digest string
 
-	Total     int64
+	Total     atomic.Int64
 	Completed atomic.Int64
 
 	Parts []*blobDownloadPart
 
+	// etag is the registry's ETag for the blob, which must be unchanged for
+	// a download to be resumed
+	etag string
+
+	// limiters throttle the download to the server and per pull rates
+	limiters []*rateLimiter
+
 	context.CancelFunc
 
 	done       chan struct{}
@@ -60,6 +72,12 @@ type blobDownloadPart struct {
 	lastUpdatedMu sync.Mutex
 	lastUpdated   time.Time
 
+	// etag is the ETag the part was downloaded with
+	etag string
+
+	// checkpointMu guards writing the part's file
+	checkpointMu sync.Mutex
+
 	*blobDownload `json:"-"`
 }
 
@@ -68,6 +86,7 @@ type jsonBlobDownloadPart struct {
 	Offset    int64
 	Size      int64
 	Completed int64
+	ETag      string `json:",omitempty"`
 }
 
 func (p *blobDownloadPart) MarshalJSON() ([]byte, error) {
@@ -76,6 +95,7 @@ func (p *blobDownloadPart) MarshalJSON() ([]byte, error) {
 		Offset:    p.Offset,
 		Size:      p.Size,
 		Completed: p.Completed.Load(),
+		ETag:      p.blobDownload.etag,
 	})
 }
 
@@ -88,12 +108,17 @@ func (p *blobDownloadPart) UnmarshalJSON(b []byte) error {
 		N:      j.N,
 		Offset: j.Offset,
 		Size:   j.Size,
+		etag:   j.ETag,
 	}
 	p.Completed.Store(j.Completed)
 	return nil
 }
 
 const (
+	// checkpointSize is how much of a part is downloaded between saves of
+	// its progress
+	checkpointSize int64 = 16 * format.MegaByte
+
 	numDownloadParts          = 16
 	minDownloadPartSize int64 = 100 * format.MegaByte
 	maxDownloadPartSize int64 = 1000 * format.MegaByte
@@ -113,8 +138,11 @@ func (p *blobDownloadPart) StopsAt() int64 {
 	return p.Offset + p.Size
 }
 
+// Write counts bytes of the part which were written to the blob, which are
+// saved with the part's progress so they aren't downloaded again.
 func (p *blobDownloadPart) Write(b []byte) (n int, err error) {
 	n = len(b)
+	p.Completed.Add(int64(n))
 	p.blobDownload.Completed.Add(int64(n))
 	p.lastUpdatedMu.Lock()
 	p.lastUpdated = time.Now()
@@ -128,7 +156,13 @@ func (b *blobDownload) Prepare(ctx context.Context, requestURL *url.URL, opts *r
 		return err
 	}
 
-	b.done = make(chan struct{})
+	resp, err := makeRequestWithRetry(ctx, http.MethodHead, requestURL, nil, nil, opts)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b.etag = resp.Header.Get("ETag")
 
 	for _, partFilePath := range partFilePaths {
 		part, err := b.readPart(partFilePath)
@@ -136,21 +170,27 @@ func (b *blobDownload) Prepare(ctx context.Context, requestURL *url.URL, opts *r
 			return err
 		}
 
-		b.Total += part.Size
+		b.Total.Add(part.Size)
 		b.Completed.Add(part.Completed.Load())
 		b.Parts = append(b.Parts, part)
 	}
 
-	if len(b.Parts) == 0 {
-		resp, err := makeRequestWithRetry(ctx, http.MethodHead, requestURL, nil, nil, opts)
-		if err != nil {
-			return err
+	if len(b.Parts) > 0 {
+		if etag := b.Parts[0].etag; etag != "" && b.etag != "" && etag != b.etag {
+			slog.Info("blob changed since its download started, starting over", "digest", b.Digest)
+			if err := b.removeParts(partFilePaths); err != nil {
+				return err
+			}
+		} else {
+			slog.Info(fmt.Sprintf("resuming download of %s, %s of %s already downloaded", b.Digest[7:19], format.HumanBytes(b.Completed.Load()), format.HumanBytes(b.Total.Load())))
 		}
-		defer resp.Body.Close()
+	}
 
-		b.Total, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if len(b.Parts) == 0 {
+		total, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+		b.Total.Store(total)
 
-		size := b.Total / numDownloadParts
+		size := total / numDownloadParts
 		switch {
 		case size < minDownloadPartSize:
 			size = minDownloadPartSize
@@ -159,9 +199,9 @@ func (b *blobDownload) Prepare(ctx context.Context, requestURL *url.URL, opts *r
 		}
 
 		var offset int64
-		for offset < b.Total {
-			if offset+size > b.Total {
-				size = b.Total - offset
+		for offset < total {
+			if offset+size > total {
+				size = total - offset
 			}
 
 			if err := b.newPart(offset, size); err != nil {
@@ -179,9 +219,67 @@ func (b *blobDownload) Prepare(ctx context.Context, requestURL *url.URL, opts *r
 	return nil
 }
 
-func (b *blobDownload) Run(ctx context.Context, requestURL *url.URL, opts *registryOptions) {
+// removeParts removes the partial download of the blob so it starts over.
+func (b *blobDownload) removeParts(partFilePaths []string) error {
+	for _, p := range append(partFilePaths, b.Name+"-partial") {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	b.Parts = nil
+	b.Total.Store(0)
+	b.Completed.Store(0)
+	return nil
+}
+
+func (b *blobDownload) Run(ctx context.Context, opts downloadOpts) {
 	defer close(b.done)
-	b.err = b.run(ctx, requestURL, opts)
+	defer blobDownloadManager.Delete(b.Digest)
+	b.err = b.download(ctx, opts)
+}
+
+// download fetches the blob from the first source which has it: a peer, a
+// patch against a local blob, a compressed copy of the blob or, failing
+// those, its parts from the registry. Every source is limited to the
+// download rates and reports its progress to each pull waiting for the blob.
+func (b *blobDownload) download(ctx context.Context, opts downloadOpts) error {
+	b.limiters = downloadLimiters(opts.regOpts)
+	opts.fn = b.progress
+
+	if ok, err := downloadBlobFromPeers(ctx, opts, b.limiters); err != nil || ok {
+		return err
+	}
+
+	if opts.base != "" {
+		if ok, err := downloadBlobPatch(ctx, opts); err != nil || ok {
+			return err
+		}
+	}
+
+	if opts.size >= minCompressSize {
+		if ok, err := downloadBlobCompressed(ctx, opts); err != nil || ok {
+			return err
+		}
+	}
+
+	// progress of a source which gave up doesn't count towards the registry's
+	b.Total.Store(0)
+	b.Completed.Store(0)
+
+	requestURL := opts.mp.BaseURL()
+	requestURL = requestURL.JoinPath("v2", opts.mp.GetNamespaceRepository(), "blobs", opts.digest)
+	if err := b.Prepare(ctx, requestURL, opts.regOpts); err != nil {
+		return err
+	}
+
+	return b.run(ctx, requestURL, opts.regOpts)
+}
+
+// progress records the progress of sources which aren't downloaded in parts.
+func (b *blobDownload) progress(resp api.ProgressResponse) {
+	b.Total.Store(resp.Total)
+	b.Completed.Store(resp.Completed)
 }
 
 func newBackoff(maxBackoff time.Duration) func(ctx context.Context) error {
@@ -211,9 +309,6 @@ func newBackoff(maxBackoff time.Duration) func(ctx context.Context) error {
 }
 
 func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *registryOptions) error {
-	defer blobDownloadManager.Delete(b.Digest)
-	ctx, b.CancelFunc = context.WithCancel(ctx)
-
 	file, err := os.OpenFile(b.Name+"-partial", os.O_CREATE|os.O_RDWR, 0o644)
 	if err != nil {
 		return err
@@ -221,7 +316,7 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 	defer file.Close()
 	setSparse(file)
 
-	_ = file.Truncate(b.Total)
+	_ = file.Truncate(b.Total.Load())
 
 	directURL, err := func() (*url.URL, error) {
 		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
@@ -269,6 +364,15 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 		return err
 	}
 
+	// the blob is hashed as it downloads so it's verified soon after the
+	// last part finishes
+	d := newDigester()
+	stop := make(chan struct{})
+	hashed := make(chan error, 1)
+	go func() {
+		hashed <- d.follow(file, b.Parts, stop)
+	}()
+
 	g, inner := errgroup.WithContext(ctx)
 	g.SetLimit(numDownloadParts)
 	for i := range b.Parts {
@@ -303,7 +407,25 @@ func (b *blobDownload) run(ctx context.Context, requestURL *url.URL, opts *regis
 		})
 	}
 
-	if err := g.Wait(); err != nil {
+	err = g.Wait()
+	close(stop)
+	if err := cmp.Or(err, <-hashed); err != nil {
+		return err
+	}
+
+	if err := d.verify(file, b.Total.Load(), b.Digest); err != nil {
+		if errors.Is(err, errDigestMismatch) {
+			// what was downloaded can't be trusted, so the next pull
+			// starts over
+			file.Close()
+			partFilePaths, _ := filepath.Glob(b.Name + "-partial-*")
+			for _, p := range append(partFilePaths, file.Name()) {
+				if err := os.Remove(p); err != nil {
+					slog.Warn("failed to remove partial download", "path", p, "error", err)
+				}
+			}
+		}
+
 		return err
 	}
 
@@ -333,30 +455,47 @@ func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, w
 			return err
 		}
 		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", part.StartsAt(), part.StopsAt()-1))
-		resp, err := http.DefaultClient.Do(req)
+		if b.etag != "" && !strings.HasPrefix(b.etag, "W/") {
+			// the whole blob is sent instead if it changed, which is
+			// refused below rather than mixed with what was downloaded
+			req.Header.Set("If-Range", b.etag)
+		}
+
+		c, err := registryClient(requestURL, nil)
 		if err != nil {
 			return err
 		}
-		defer resp.Body.Close()
 
-		n, err := io.CopyN(w, io.TeeReader(resp.Body, part), part.Size-part.Completed.Load())
-		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, io.ErrUnexpectedEOF) {
-			// rollback progress
-			b.Completed.Add(-n)
+		resp, err := c.Do(req)
+		if err != nil {
 			return err
 		}
+		defer resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusPartialContent:
+		case resp.StatusCode == http.StatusOK && part.StartsAt() == 0 && part.Size == b.Total.Load():
+			// the part is the whole blob
+		case resp.StatusCode == http.StatusOK:
+			return errors.New("blob changed since its download started")
+		default:
+			return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
 
-		part.Completed.Add(n)
+		// bytes are counted once they're written so progress which is
+		// saved never includes bytes which weren't
+		r := &rateLimitedReader{ctx: ctx, r: resp.Body, limiters: b.limiters}
+		_, err = io.CopyN(io.MultiWriter(w, part), r, part.Size-part.Completed.Load())
 		if err := b.writePart(part.Name(), part); err != nil {
 			return err
 		}
 
-		// return nil or context.Canceled or UnexpectedEOF (resumable)
 		return err
 	})
 
 	g.Go(func() error {
 		ticker := time.NewTicker(time.Second)
+		checkpoint := part.Completed.Load()
 		for {
 			select {
 			case <-ticker.C:
@@ -364,6 +503,15 @@ func (b *blobDownload) downloadChunk(ctx context.Context, requestURL *url.URL, w
 					return nil
 				}
 
+				// progress is saved as the part downloads so a crash or
+				// restart only loses the last few seconds of it
+				if completed := part.Completed.Load(); completed-checkpoint >= checkpointSize {
+					if err := b.writePart(part.Name(), part); err != nil {
+						return err
+					}
+					checkpoint = completed
+				}
+
 				part.lastUpdatedMu.Lock()
 				lastUpdated := part.lastUpdated
 				part.lastUpdatedMu.Unlock()
@@ -412,14 +560,24 @@ func (b *blobDownload) readPart(partName string) (*blobDownloadPart, error) {
 	return &part, nil
 }
 
+// writePart saves the part's progress. It's written to a temporary file
+// and renamed into place so a crash never leaves a part which can't be read.
 func (b *blobDownload) writePart(partName string, part *blobDownloadPart) error {
-	partFile, err := os.OpenFile(partName, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
+	part.checkpointMu.Lock()
+	defer part.checkpointMu.Unlock()
+
+	bts, err := json.Marshal(part)
 	if err != nil {
 		return err
 	}
-	defer partFile.Close()
 
-	return json.NewEncoder(partFile).Encode(part)
+	// the temporary file is hidden so it isn't mistaken for a part
+	temp := filepath.Join(filepath.Dir(partName), "."+filepath.Base(partName))
+	if err := os.WriteFile(temp, bts, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, partName)
 }
 
 func (b *blobDownload) acquire() {
@@ -445,7 +603,7 @@ func (b *blobDownload) Wait(ctx context.Context, fn func(api.ProgressResponse))
 			fn(api.ProgressResponse{
 				Status:    fmt.Sprintf("pulling %s", b.Digest[7:19]),
 				Digest:    b.Digest,
-				Total:     b.Total,
+				Total:     b.Total.Load(),
 				Completed: b.Completed.Load(),
 			})
 		case <-ctx.Done():
@@ -454,14 +612,34 @@ func (b *blobDownload) Wait(ctx context.Context, fn func(api.ProgressResponse))
 	}
 }
 
+// downloadLimiters returns the rate limiters for a download with the
+// server's rate and the rate of this pull, if any.
+func downloadLimiters(opts *registryOptions) []*rateLimiter {
+	limiters := []*rateLimiter{downloadRateLimiter}
+	if rate := opts.MaxDownloadRate; rate > 0 {
+		limiters = append(limiters, newRateLimiter(func() int64 { return rate }))
+	}
+
+	return limiters
+}
+
 type downloadOpts struct {
 	mp      ModelPath
 	digest  string
 	regOpts *registryOptions
 	fn      func(api.ProgressResponse)
+
+	// size is the size of the blob listed in the manifest
+	size int64
+
+	// base is the digest of a local blob the registry may send a patch
+	// against instead of the whole blob
+	base string
 }
 
-// downloadBlob downloads a blob from the registry and stores it in the blobs directory
+// downloadBlob downloads a blob from the registry and stores it in the blobs
+// directory. Downloaded blobs are verified against their digest as they
+// download, while blobs which were already stored aren't verified again.
 func downloadBlob(ctx context.Context, opts downloadOpts) (cacheHit bool, _ error) {
 	fp, err := GetBlobsPath(opts.digest)
 	if err != nil {
@@ -484,18 +662,25 @@ func downloadBlob(ctx context.Context, opts downloadOpts) (cacheHit bool, _ erro
 		return true, nil
 	}
 
-	data, ok := blobDownloadManager.LoadOrStore(opts.digest, &blobDownload{Name: fp, Digest: opts.digest})
-	download := data.(*blobDownload)
-	if !ok {
-		requestURL := opts.mp.BaseURL()
-		requestURL = requestURL.JoinPath("v2", opts.mp.GetNamespaceRepository(), "blobs", opts.digest)
-		if err := download.Prepare(ctx, requestURL, opts.regOpts); err != nil {
-			blobDownloadManager.Delete(opts.digest)
-			return false, err
-		}
+	if ok, err := downloadStoredBlob(ctx, opts.digest, opts.fn); err != nil {
+		return false, err
+	} else if ok {
+		return false, nil
+	}
+
+	download := &blobDownload{Name: fp, Digest: opts.digest, done: make(chan struct{})}
+
+	// the download carries on while any pull is waiting for the blob
+	runCtx, cancel := context.WithCancel(context.Background())
+	download.CancelFunc = cancel
 
+	data, ok := blobDownloadManager.LoadOrStore(opts.digest, download)
+	if ok {
+		cancel()
+		download = data.(*blobDownload)
+	} else {
 		//nolint:contextcheck
-		go download.Run(context.Background(), requestURL, opts.regOpts)
+		go download.Run(runCtx, opts)
 	}
 
 	return false, download.Wait(ctx, opts.fn)
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestDownloadBlobResume(t *testing.T) {
+	blob := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+	half := int64(len(blob) / 2)
+
+	cases := []struct {
+		name string
+		// etag is the ETag the partial download was made with
+		etag string
+		// expect is the start of the range requested from the registry
+		expect string
+	}{
+		{"resumed", `"v1"`, fmt.Sprintf("bytes=%d-", half)},
+		{"unknown etag", "", fmt.Sprintf("bytes=%d-", half)},
+		{"changed", `"v0"`, "bytes=0-"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+			var mu sync.Mutex
+			var ranges []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/v2/library/test/blobs/"+digest {
+					// redirect to another host name, like a registry
+					// redirects to its CDN
+					http.Redirect(w, r, strings.Replace("http://"+r.Host, "127.0.0.1", "localhost", 1)+"/direct", http.StatusTemporaryRedirect)
+					return
+				}
+
+				if r.Method == http.MethodGet {
+					mu.Lock()
+					ranges = append(ranges, r.Header.Get("Range"))
+					mu.Unlock()
+				}
+
+				w.Header().Set("ETag", `"v1"`)
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
+			}))
+			defer srv.Close()
+
+			fp, err := GetBlobsPath(digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the first half of the blob was downloaded before a restart,
+			// with some garbage past what was saved in the part
+			data := append(bytes.Clone(blob[:half]), bytes.Repeat([]byte{0}, 100)...)
+			if err := os.WriteFile(fp+"-partial", data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			bts, err := json.Marshal(jsonBlobDownloadPart{N: 0, Offset: 0, Size: int64(len(blob)), Completed: half, ETag: tt.etag})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(fp+"-partial-0", bts, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := downloadBlob(context.Background(), downloadOpts{
+				mp:      ParseModelPath(srv.URL + "/library/test:latest"),
+				digest:  digest,
+				regOpts: &registryOptions{},
+				fn:      func(api.ProgressResponse) {},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := os.ReadFile(fp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(got, blob) {
+				t.Error("expected the downloaded blob")
+			}
+
+			if len(ranges) != 1 || !strings.HasPrefix(ranges[0], tt.expect) {
+				t.Errorf("expected a request for %s, got %v", tt.expect, ranges)
+			}
+
+			if _, err := os.Stat(fp + "-partial-0"); !os.IsNotExist(err) {
+				t.Errorf("expected the part to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDownloadBlobDigestMismatch(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	blob := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("another blob")))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/library/test/blobs/"+digest {
+			http.Redirect(w, r, strings.Replace("http://"+r.Host, "127.0.0.1", "localhost", 1)+"/direct", http.StatusTemporaryRedirect)
+			return
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
+	}))
+	defer srv.Close()
+
+	_, err := downloadBlob(context.Background(), downloadOpts{
+		mp:      ParseModelPath(srv.URL + "/library/test:latest"),
+		digest:  digest,
+		regOpts: &registryOptions{},
+		fn:      func(api.ProgressResponse) {},
+	})
+	if !errors.Is(err, errDigestMismatch) {
+		t.Fatalf("expected a digest mismatch, got %v", err)
+	}
+
+	fp, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if matches, err := filepath.Glob(fp + "*"); err != nil || len(matches) > 0 {
+		t.Errorf("expected the download to be removed, got %v %v", matches, err)
+	}
+}
+
+func TestDownloadChunkStatus(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	blob := []byte("a blob which is downloaded in one part")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/expired":
+			http.Error(w, "signature expired", http.StatusForbidden)
+		case "/changed":
+			// a changed blob is sent whole in reply to If-Range
+			w.Write(blob)
+		}
+	}))
+	defer srv.Close()
+
+	fp, err := GetBlobsPath(fmt.Sprintf("sha256:%x", sha256.Sum256(blob)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/expired", "/changed"} {
+		t.Run(strings.TrimPrefix(path, "/"), func(t *testing.T) {
+			b := &blobDownload{Name: fp, etag: `"v1"`}
+			b.Total.Store(int64(len(blob)))
+			part := &blobDownloadPart{blobDownload: b, Size: b.Total.Load()}
+			part.Completed.Store(4)
+
+			requestURL, err := url.Parse(srv.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var w bytes.Buffer
+			if err := b.downloadChunk(context.Background(), requestURL, &w, part); err == nil {
+				t.Error("expected an error")
+			}
+
+			if w.Len() > 0 || part.Completed.Load() != 4 {
+				t.Errorf("expected nothing to be written, got %q", w.String())
+			}
+		})
+	}
+}
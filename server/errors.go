@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/runner/common"
+)
+
+// errorResponse is the body of an error response with a code, so clients
+// can tell errors apart without matching msg.
+func errorResponse(code api.ErrorCode, msg string) gin.H {
+	h := gin.H{"error": msg, "code": code}
+	if retryable(code) {
+		h["retryable"] = true
+	}
+
+	return h
+}
+
+// streamError is an error sent in place of the next chunk of a stream.
+func streamError(err error) gin.H {
+	return errorResponse(errorCode(err), err.Error())
+}
+
+// errorStatus is the status of a response which isn't streamed and ends in
+// the stream error h.
+func errorStatus(h gin.H) int {
+	if h["code"] == api.ErrorCodeContentBlocked {
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+// retryable reports whether errors with code may not happen again if the
+// request is retried later.
+func retryable(code api.ErrorCode) bool {
+	return code == api.ErrorCodeServerBusy
+}
+
+// errorCode is the code of err, which is ErrorCodeInternal for errors
+// which aren't the client's doing.
+func errorCode(err error) api.ErrorCode {
+	switch {
+	case errors.Is(err, errCapabilities):
+		return api.ErrorCodeCapabilityMissing
+	case errors.Is(err, errForbiddenNamespace):
+		return api.ErrorCodeForbidden
+	case errors.Is(err, errContentBlocked):
+		return api.ErrorCodeContentBlocked
+	case errors.Is(err, api.ErrLimitExceeded), errors.As(err, new(ggml.LimitError)):
+		return api.ErrorCodeLimitExceeded
+	case errors.Is(err, errRequired), errors.Is(err, errInvalidAdapter), errors.Is(err, common.ErrInvalidStop),
+		errors.Is(err, errInvalidOption), errors.Is(err, llm.ErrUnsupportedOffload), errors.Is(err, ggml.ErrInvalid):
+		return api.ErrorCodeInvalidRequest
+	case errors.Is(err, llm.ErrInsufficientMemory):
+		return api.ErrorCodeOutOfMemory
+	case errors.Is(err, ErrMaxQueue):
+		return api.ErrorCodeServerBusy
+	case errors.Is(err, context.Canceled):
+		return api.ErrorCodeCanceled
+	case errors.Is(err, os.ErrNotExist):
+		return api.ErrorCodeNotFound
+	default:
+		return api.ErrorCodeInternal
+	}
+}
+
+// statusErrorCode is the code of an error response with status which
+// doesn't have a more specific one.
+func statusErrorCode(status int) api.ErrorCode {
+	switch status {
+	case http.StatusUnauthorized:
+		return api.ErrorCodeUnauthorized
+	case http.StatusForbidden:
+		return api.ErrorCodeForbidden
+	case http.StatusNotFound:
+		return api.ErrorCodeNotFound
+	case http.StatusInsufficientStorage:
+		return api.ErrorCodeQuotaExceeded
+	case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+		return api.ErrorCodeServerBusy
+	case 499:
+		return api.ErrorCodeCanceled
+	}
+
+	if status >= http.StatusInternalServerError {
+		return api.ErrorCodeInternal
+	}
+
+	return api.ErrorCodeInvalidRequest
+}
+
+// withErrorCode adds a code to body, an error response with status, if it
+// doesn't have one. Bodies which aren't errors in the usual format are
+// returned as is.
+func withErrorCode(status int, body []byte) []byte {
+	var resp map[string]any
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return body
+	}
+
+	if _, ok := resp["error"].(string); !ok {
+		return body
+	}
+
+	if _, ok := resp["code"]; ok {
+		return body
+	}
+
+	if s, ok := resp["status"].(float64); ok {
+		status = int(s)
+	}
+
+	code := statusErrorCode(status)
+	resp["code"] = code
+	if retryable(code) {
+		resp["retryable"] = true
+	}
+
+	bts, err := json.Marshal(resp)
+	if err != nil {
+		return body
+	}
+
+	if bytes.HasSuffix(body, []byte("\n")) {
+		bts = append(bts, '\n')
+	}
+
+	return bts
+}
+
+// errorCodeWriter holds back the body of error responses so that a code
+// can be added to them.
+type errorCodeWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *errorCodeWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *errorCodeWriter) Write(b []byte) (int, error) {
+	if w.status < http.StatusBadRequest {
+		return w.ResponseWriter.Write(b)
+	}
+
+	return w.body.Write(b)
+}
+
+func (w *errorCodeWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// errorCodeMiddleware adds codes to the error responses of handlers which
+// don't set one, so that every error response has a code.
+func errorCodeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &errorCodeWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = w
+		defer func() { c.Writer = w.ResponseWriter }()
+
+		c.Next()
+
+		if w.body.Len() > 0 {
+			w.ResponseWriter.Write(withErrorCode(w.status, w.body.Bytes()))
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestErrorCodeMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(errorCodeMiddleware())
+	r.GET("/missing", func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "blob not found"})
+	})
+	r.GET("/busy", func(c *gin.Context) {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "server busy"})
+	})
+	r.GET("/model", func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, "model 'llama' not found"))
+	})
+	r.GET("/openai", func(c *gin.Context) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "bad request", "type": "invalid_request_error"}})
+	})
+	r.GET("/ok", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"error": "not an error response"})
+	})
+
+	cases := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/missing", http.StatusNotFound, `{"code":"NOT_FOUND","error":"blob not found"}`},
+		{"/busy", http.StatusServiceUnavailable, `{"code":"SERVER_BUSY","error":"server busy","retryable":true}`},
+		{"/model", http.StatusNotFound, `{"code":"MODEL_NOT_FOUND","error":"model 'llama' not found"}`},
+		{"/openai", http.StatusBadRequest, `{"error":{"message":"bad request","type":"invalid_request_error"}}`},
+		{"/ok", http.StatusOK, `{"error":"not an error response"}`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.code {
+				t.Errorf("expected status code %d, actual %d", tt.code, w.Code)
+			}
+
+			if diff := cmp.Diff(tt.body, w.Body.String()); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	cases := []struct {
+		err  error
+		code api.ErrorCode
+	}{
+		{fmt.Errorf("llama runner process has terminated: %w", llm.ErrInsufficientMemory), api.ErrorCodeOutOfMemory},
+		{fmt.Errorf("%w %w", errCapabilities, errCapabilityTools), api.ErrorCodeCapabilityMissing},
+		{fmt.Errorf("%w: mirostat must be 0, 1 or 2", errInvalidOption), api.ErrorCodeInvalidRequest},
+		{fmt.Errorf("%w: tensor \"output.weight\" is outside the file", ggml.ErrInvalid), api.ErrorCodeInvalidRequest},
+		{fmt.Errorf("llama runner process has terminated: %w", ggml.LimitError{Limit: "tensors", Max: 1, Value: 2}), api.ErrorCodeLimitExceeded},
+		{moderationError("request", &api.ModerationResult{Flagged: true}), api.ErrorCodeContentBlocked},
+		{ErrMaxQueue, api.ErrorCodeServerBusy},
+		{context.Canceled, api.ErrorCodeCanceled},
+		{os.ErrNotExist, api.ErrorCodeNotFound},
+		{errors.New("something broke"), api.ErrorCodeInternal},
+	}
+
+	for _, tt := range cases {
+		if code := errorCode(tt.err); code != tt.code {
+			t.Errorf("%v: expected code %s, got %s", tt.err, tt.code, code)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// fimFormat is the format code models are trained to fill in the middle of
+// a file with, which can include other files of its repository as context.
+type fimFormat struct {
+	name string
+
+	// prefix, suffix and middle are the tokens which precede the text
+	// before the cursor, the text after it and the inserted text
+	prefix, suffix, middle string
+
+	// fileSep precedes each file of the repository and repoName precedes
+	// its name. Files are separated by newlines if there's no fileSep.
+	fileSep, repoName string
+
+	// pathPrefix precedes the path of a file on the line before its content
+	pathPrefix string
+}
+
+// fimFormats are the formats which are detected by their prefix token being
+// in the template of a model
+var fimFormats = []fimFormat{
+	{
+		name:   "starcoder2",
+		prefix: "<fim_prefix>", suffix: "<fim_suffix>", middle: "<fim_middle>",
+		fileSep: "<file_sep>", repoName: "<repo_name>",
+	},
+	{
+		name:   "codegemma",
+		prefix: "<|fim_prefix|>", suffix: "<|fim_suffix|>", middle: "<|fim_middle|>",
+		fileSep: "<|file_separator|>",
+	},
+	{
+		name:   "deepseek-coder",
+		prefix: "<｜fim▁begin｜>", suffix: "<｜fim▁hole｜>", middle: "<｜fim▁end｜>",
+		pathPrefix: "#",
+	},
+}
+
+// fimFormatOf returns the format named style, or the format of m if style is
+// empty. It returns false if m has no known format.
+func fimFormatOf(m *Model, style string) (fimFormat, bool, error) {
+	for _, f := range fimFormats {
+		if style == f.name || style == "" && m.Template != nil && strings.Contains(m.Template.String(), f.prefix) {
+			return f, true, nil
+		}
+	}
+
+	if style != "" {
+		return fimFormat{}, false, fmt.Errorf("%w: unknown style %q", errInvalidOption, style)
+	}
+
+	return fimFormat{}, false, nil
+}
+
+// render returns the prompt which fills in the middle of the file at path
+// between prefix and suffix, with the files of the repository repo before it.
+func (f fimFormat) render(repo string, files []api.FIMFile, path, prefix, suffix string) string {
+	var b strings.Builder
+	if repo != "" && f.repoName != "" {
+		b.WriteString(f.repoName)
+		b.WriteString(repo)
+	}
+
+	for _, file := range files {
+		b.WriteString(f.fileSep)
+		b.WriteString(f.path(file.Path))
+		b.WriteString(file.Content)
+		if f.fileSep == "" && !strings.HasSuffix(file.Content, "\n") {
+			b.WriteString("\n")
+		}
+	}
+
+	if b.Len() > 0 {
+		b.WriteString(f.fileSep)
+	}
+
+	b.WriteString(f.prefix)
+	b.WriteString(f.path(path))
+	b.WriteString(prefix)
+	b.WriteString(f.suffix)
+	b.WriteString(suffix)
+	b.WriteString(f.middle)
+	return b.String()
+}
+
+// path returns the line which precedes the content of the file at path
+func (f fimFormat) path(path string) string {
+	if path == "" {
+		return ""
+	}
+
+	return f.pathPrefix + path + "\n"
+}
+
+// stop returns the stop sequences which end an insertion, so the model
+// doesn't go on to write another file
+func (f fimFormat) stop() []string {
+	if f.fileSep == "" {
+		return nil
+	}
+
+	return []string{f.fileSep}
+}
@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestFIMFormatRender(t *testing.T) {
+	files := []api.FIMFile{
+		{Path: "util.py", Content: "def add(a, b):\n    return a + b\n"},
+		{Path: "const.py", Content: "PI = 3.14"},
+	}
+
+	cases := []struct {
+		name   string
+		repo   string
+		files  []api.FIMFile
+		path   string
+		expect string
+	}{
+		{
+			name:   "starcoder2",
+			expect: "<fim_prefix>x = <fim_suffix>\nprint(x)<fim_middle>",
+		},
+		{
+			name:   "starcoder2",
+			repo:   "calc",
+			files:  files,
+			path:   "main.py",
+			expect: "<repo_name>calc<file_sep>util.py\ndef add(a, b):\n    return a + b\n<file_sep>const.py\nPI = 3.14<file_sep><fim_prefix>main.py\nx = <fim_suffix>\nprint(x)<fim_middle>",
+		},
+		{
+			name:   "codegemma",
+			repo:   "calc",
+			files:  files[:1],
+			path:   "main.py",
+			expect: "<|file_separator|>util.py\ndef add(a, b):\n    return a + b\n<|file_separator|><|fim_prefix|>main.py\nx = <|fim_suffix|>\nprint(x)<|fim_middle|>",
+		},
+		{
+			name:   "deepseek-coder",
+			repo:   "calc",
+			files:  files,
+			path:   "main.py",
+			expect: "#util.py\ndef add(a, b):\n    return a + b\n#const.py\nPI = 3.14\n<｜fim▁begin｜>#main.py\nx = <｜fim▁hole｜>\nprint(x)<｜fim▁end｜>",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			f, ok, err := fimFormatOf(&Model{}, tt.name)
+			if err != nil || !ok {
+				t.Fatalf("fimFormatOf(%q): %v %v", tt.name, ok, err)
+			}
+
+			if diff := cmp.Diff(tt.expect, f.render(tt.repo, tt.files, tt.path, "x = ", "\nprint(x)")); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, _, err := fimFormatOf(&Model{}, "unknown"); err == nil {
+		t.Error("expected error for unknown style")
+	}
+}
+
+func TestFIM(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var prompts []string
+	mock := mockRunner{
+		CompletionFn: func(_ context.Context, r llm.CompletionRequest, fn func(llm.CompletionResponse)) error {
+			prompts = append(prompts, r.Prompt)
+			fn(llm.CompletionResponse{Content: "[", EvalCount: 1})
+			fn(llm.CompletionResponse{Content: "]", Done: true, DoneReason: "stop", EvalCount: 1})
+			return nil
+		},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				time.Sleep(time.Millisecond)
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(context.TODO())
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(1),
+		"llama.context_length":          uint32(8192),
+		"llama.embedding_length":        uint32(4096),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(8),
+		"tokenizer.ggml.tokens":         []string{""},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_down.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_gate.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_up.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.ffn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_k.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_q.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.0.attn_v.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	for _, m := range []struct{ name, template string }{
+		{"starcoder2", `{{- if .Suffix }}<fim_prefix>{{ .Prompt }}<fim_suffix>{{ .Suffix }}<fim_middle>{{ else }}{{ .Prompt }}{{ end }}`},
+		{"other", `{{- if .Suffix }}<PRE> {{ .Prompt }} <SUF>{{ .Suffix }} <MID>{{ else }}{{ .Prompt }}{{ end }}`},
+		{"chat", `{{ .Prompt }}`},
+	} {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:    m.name,
+			Files:    map[string]string{"file.gguf": digest},
+			Template: m.template,
+			Stream:   &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+	}
+
+	file := api.FIMFile{Path: "main.py", Content: "a = \nb = "}
+	files := []api.FIMFile{{Path: "util.py", Content: "x = 1\n"}}
+
+	t.Run("known format", func(t *testing.T) {
+		prompts = nil
+		w := createRequest(t, s.FIMHandler, api.FIMRequest{
+			Model:   "starcoder2",
+			File:    file,
+			Cursors: []int{4, 9},
+			Files:   files,
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.FIMResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff([]string{"[]", "[]"}, resp.Insertions); diff != "" {
+			t.Errorf("insertions mismatch (-want +got):\n%s", diff)
+		}
+
+		if resp.Content != "a = []\nb = []" {
+			t.Errorf("expected content %q, got %q", "a = []\nb = []", resp.Content)
+		}
+
+		expect := []string{
+			"<file_sep>util.py\nx = 1\n<file_sep><fim_prefix>main.py\na = <fim_suffix>\nb = <fim_middle>",
+			"<file_sep>util.py\nx = 1\n<file_sep><fim_prefix>main.py\na = []\nb = <fim_suffix><fim_middle>",
+		}
+		if diff := cmp.Diff(expect, prompts); diff != "" {
+			t.Errorf("prompts mismatch (-want +got):\n%s", diff)
+		}
+
+		if stop := mock.CompletionRequest.Options.Stop; len(stop) == 0 || stop[len(stop)-1] != "<file_sep>" {
+			t.Errorf("expected <file_sep> stop, got %q", stop)
+		}
+	})
+
+	t.Run("template", func(t *testing.T) {
+		prompts = nil
+		w := createRequest(t, s.FIMHandler, api.FIMRequest{
+			Model:   "other",
+			File:    file,
+			Cursors: []int{4},
+			Files:   files,
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		if diff := cmp.Diff([]string{"<PRE> a =  <SUF>\nb =  <MID>"}, prompts); diff != "" {
+			t.Errorf("prompts mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("insert not supported", func(t *testing.T) {
+		w := createRequest(t, s.FIMHandler, api.FIMRequest{Model: "chat", File: file, Cursors: []int{4}})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	for name, cursors := range map[string][]int{
+		"no cursors":          nil,
+		"cursor outside file": {10},
+		"cursors unordered":   {9, 4},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := createRequest(t, s.FIMHandler, api.FIMRequest{Model: "starcoder2", File: file, Cursors: cursors})
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", w.Code)
+			}
+		})
+	}
+}
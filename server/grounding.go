@@ -0,0 +1,114 @@
+package server
+
+import (
+	"cmp"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ollama/ollama/api"
+)
+
+// groundingFormat is a way that vision models write the coordinates of a
+// region of an image in their responses.
+type groundingFormat struct {
+	re *regexp.Regexp
+
+	// label is the group of the region's label, or 0 if it has none, and box
+	// are the groups of its left, top, right and bottom edges
+	label int
+	box   [4]int
+
+	// scale is the value of coordinates at the far edges of the image
+	scale float64
+}
+
+var groundingFormats = []groundingFormat{
+	// Qwen2-VL: <|object_ref_start|>dog<|object_ref_end|><|box_start|>(120,340),(560,910)<|box_end|>
+	{
+		re:    regexp.MustCompile(`(?:<\|object_ref_start\|>([^<]*)<\|object_ref_end\|>\s*)?<\|box_start\|>\s*\((\d+),\s*(\d+)\)\s*,\s*\((\d+),\s*(\d+)\)\s*<\|box_end\|>`),
+		label: 1,
+		box:   [4]int{2, 3, 4, 5},
+		scale: 1000,
+	},
+	// Qwen-VL: <ref>dog</ref><box>(120,340),(560,910)</box>
+	{
+		re:    regexp.MustCompile(`(?:<ref>([^<]*)</ref>\s*)?<box>\s*\((\d+),\s*(\d+)\)\s*,\s*\((\d+),\s*(\d+)\)\s*</box>`),
+		label: 1,
+		box:   [4]int{2, 3, 4, 5},
+		scale: 1000,
+	},
+	// InternVL: <ref>dog</ref><box>[[120, 340, 560, 910]]</box>
+	{
+		re:    regexp.MustCompile(`(?:<ref>([^<]*)</ref>\s*)?<box>\s*\[\[(\d+),\s*(\d+),\s*(\d+),\s*(\d+)\]\]\s*</box>`),
+		label: 1,
+		box:   [4]int{2, 3, 4, 5},
+		scale: 1000,
+	},
+	// PaliGemma: <loc0348><loc0123><loc0932><loc0573> dog, with the top
+	// edge first
+	{
+		re:    regexp.MustCompile(`<loc(\d{4})><loc(\d{4})><loc(\d{4})><loc(\d{4})>[ \t]*([^<;\n]*)`),
+		label: 5,
+		box:   [4]int{2, 1, 4, 3},
+		scale: 1024,
+	},
+}
+
+// parseRegions returns the regions of images that a vision model's response
+// points out, in the order they appear in it.
+func parseRegions(content string) []api.Region {
+	type found struct {
+		at     int
+		region api.Region
+	}
+
+	var all []found
+	for _, f := range groundingFormats {
+		for _, m := range f.re.FindAllStringSubmatchIndex(content, -1) {
+			group := func(i int) string {
+				if m[2*i] < 0 {
+					return ""
+				}
+				return content[m[2*i]:m[2*i+1]]
+			}
+
+			var region api.Region
+			if f.label > 0 {
+				region.Label = strings.TrimSpace(group(f.label))
+			}
+
+			for i, g := range f.box {
+				v, err := strconv.Atoi(group(g))
+				if err != nil {
+					continue
+				}
+				region.Box[i] = min(max(float64(v)/f.scale, 0), 1)
+			}
+
+			// some models put the corners in either order
+			if region.Box[0] > region.Box[2] {
+				region.Box[0], region.Box[2] = region.Box[2], region.Box[0]
+			}
+			if region.Box[1] > region.Box[3] {
+				region.Box[1], region.Box[3] = region.Box[3], region.Box[1]
+			}
+
+			all = append(all, found{m[0], region})
+		}
+	}
+
+	if len(all) == 0 {
+		return nil
+	}
+
+	slices.SortStableFunc(all, func(a, b found) int { return cmp.Compare(a.at, b.at) })
+
+	regions := make([]api.Region, len(all))
+	for i, f := range all {
+		regions[i] = f.region
+	}
+
+	return regions
+}
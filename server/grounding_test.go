@@ -0,0 +1,66 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestParseRegions(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    []api.Region
+	}{
+		{
+			name:    "none",
+			content: "There is a dog at (100, 200).",
+		},
+		{
+			name:    "qwen2vl",
+			content: "The <|object_ref_start|>dog<|object_ref_end|><|box_start|>(100,200),(300,400)<|box_end|> and the <|box_start|>(500, 0),(1000, 250)<|box_end|>",
+			want: []api.Region{
+				{Label: "dog", Box: [4]float64{0.1, 0.2, 0.3, 0.4}},
+				{Box: [4]float64{0.5, 0, 1, 0.25}},
+			},
+		},
+		{
+			name:    "qwen-vl",
+			content: "<ref>a cat</ref><box>(300,400),(100,200)</box>",
+			want:    []api.Region{{Label: "a cat", Box: [4]float64{0.1, 0.2, 0.3, 0.4}}},
+		},
+		{
+			name:    "internvl",
+			content: "<ref>cat</ref><box>[[100, 200, 300, 1200]]</box>",
+			want:    []api.Region{{Label: "cat", Box: [4]float64{0.1, 0.2, 0.3, 1}}},
+		},
+		{
+			name:    "paligemma",
+			content: "<loc0256><loc0512><loc0768><loc1024> cat ; <loc0000><loc0000><loc0512><loc0256> dog",
+			want: []api.Region{
+				{Label: "cat", Box: [4]float64{0.5, 0.25, 1, 0.75}},
+				{Label: "dog", Box: [4]float64{0, 0, 0.25, 0.5}},
+			},
+		},
+		{
+			name:    "mixed order",
+			content: "<ref>b</ref><box>(0,0),(10,10)</box> <|box_start|>(0,0),(20,20)<|box_end|> <ref>c</ref><box>[[0, 0, 30, 30]]</box>",
+			want: []api.Region{
+				{Label: "b", Box: [4]float64{0, 0, 0.01, 0.01}},
+				{Box: [4]float64{0, 0, 0.02, 0.02}},
+				{Label: "c", Box: [4]float64{0, 0, 0.03, 0.03}},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseRegions(tt.content)
+			if diff := cmp.Diff(tt.want, got, cmp.Comparer(func(a, b float64) bool { return a-b < 1e-9 && b-a < 1e-9 })); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+// HealthHandler reports that the server is up. It checks nothing else so a
+// liveness probe doesn't restart a server which is only busy or waiting on
+// a model to load.
+func (s *Server) HealthHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ReadyHandler reports whether the server can serve requests: its model
+// store is readable, GPU discovery has finished, at least one GPU was found
+// if the gpu query parameter is true, and the models required by
+// OLLAMA_READY_MODELS and any model query parameters are loaded. It responds
+// with 503 if any check fails so a readiness probe holds traffic back.
+func (s *Server) ReadyHandler(c *gin.Context) {
+	requireGPU, _ := strconv.ParseBool(c.Query("gpu"))
+
+	checks := []api.ReadyCheck{
+		checkModelStore(),
+		s.checkGPUs(requireGPU),
+		s.checkModels(slices.Concat(envconfig.ReadyModels(), c.QueryArray("model"))),
+	}
+
+	resp := api.ReadyResponse{Status: "ready", Checks: checks}
+	code := http.StatusOK
+	for _, check := range checks {
+		if check.Status != "ok" {
+			resp.Status = "not ready"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	c.JSON(code, resp)
+}
+
+func readyCheck(name string, details map[string]any, err error) api.ReadyCheck {
+	check := api.ReadyCheck{Name: name, Status: "ok", Details: details}
+	if err != nil {
+		check.Status = "fail"
+		check.Error = err.Error()
+	}
+
+	return check
+}
+
+// checkModelStore checks that the manifests and blobs of the model store can
+// be read.
+func checkModelStore() api.ReadyCheck {
+	details := map[string]any{"path": envconfig.Models()}
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return readyCheck("model_store", details, err)
+	}
+
+	blobs, err := GetBlobsPath("")
+	if err != nil {
+		return readyCheck("model_store", details, err)
+	}
+
+	for _, dir := range []string{manifests, blobs} {
+		f, err := os.Open(dir)
+		if err != nil {
+			return readyCheck("model_store", details, err)
+		}
+
+		_, err = f.Readdirnames(1)
+		f.Close()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return readyCheck("model_store", details, err)
+		}
+	}
+
+	return readyCheck("model_store", details, nil)
+}
+
+// checkGPUs checks that the scheduler is running and GPU discovery has
+// finished. Discovery reports errors for the libraries of GPUs a host doesn't
+// have, so running on CPU alone only fails the check if required is set.
+func (s *Server) checkGPUs(required bool) api.ReadyCheck {
+	if s.sched == nil {
+		return readyCheck("gpu", nil, errors.New("scheduler not started"))
+	}
+
+	return gpuCheck(discover.GetSystemInfo(), required)
+}
+
+func gpuCheck(info discover.SystemInfo, required bool) api.ReadyCheck {
+	var libraries []string
+	for _, gpu := range info.GPUs {
+		if !slices.Contains(libraries, gpu.Library) {
+			libraries = append(libraries, gpu.Library)
+		}
+	}
+
+	details := map[string]any{"count": len(info.GPUs)}
+	if len(libraries) > 0 {
+		details["libraries"] = libraries
+	}
+
+	if len(info.DiscoveryErrors) > 0 {
+		details["discovery_errors"] = info.DiscoveryErrors
+	}
+
+	if required && len(info.GPUs) == 0 {
+		return readyCheck("gpu", details, errors.New("no GPUs initialized"))
+	}
+
+	return readyCheck("gpu", details, nil)
+}
+
+// checkModels checks that each of names is loaded and not still loading.
+func (s *Server) checkModels(names []string) api.ReadyCheck {
+	if len(names) == 0 {
+		return readyCheck("models", nil, nil)
+	}
+
+	loaded := make(map[string]bool)
+	if s.sched != nil {
+		s.sched.loadedMu.Lock()
+		for _, runner := range s.sched.loaded {
+			if runner.model != nil && !runner.loading.Load() {
+				loaded[strings.ToLower(model.ParseName(runner.model.Name).String())] = true
+			}
+		}
+		s.sched.loadedMu.Unlock()
+	}
+
+	var missing []string
+	for _, name := range names {
+		n := model.ParseName(name)
+		if !n.IsValid() || !loaded[strings.ToLower(n.String())] {
+			missing = append(missing, name)
+		}
+	}
+
+	details := map[string]any{"required": names}
+	if len(missing) > 0 {
+		details["missing"] = missing
+		return readyCheck("models", details, fmt.Errorf("models not loaded: %s", strings.Join(missing, ", ")))
+	}
+
+	return readyCheck("models", details, nil)
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+)
+
+func TestHealthHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var s Server
+	w := createRequest(t, s.HealthHandler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+}
+
+func TestReadyHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_READY_MODELS", "")
+
+	ready := func(t *testing.T, s *Server, query string) (int, map[string]string) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/readyz"+query, nil)
+		s.ReadyHandler(c)
+
+		var resp api.ReadyResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		statuses := make(map[string]string)
+		for _, check := range resp.Checks {
+			statuses[check.Name] = check.Status
+		}
+
+		if (w.Code == http.StatusOK) != (resp.Status == "ready") {
+			t.Errorf("status %q doesn't match status code %d", resp.Status, w.Code)
+		}
+
+		return w.Code, statuses
+	}
+
+	t.Run("scheduler not started", func(t *testing.T) {
+		code, statuses := ready(t, &Server{}, "")
+		if code != http.StatusServiceUnavailable {
+			t.Errorf("expected status code 503, actual %d", code)
+		}
+
+		if statuses["gpu"] != "fail" {
+			t.Errorf("expected the gpu check to fail, got %v", statuses)
+		}
+	})
+
+	s := &Server{sched: &Scheduler{loaded: make(map[string]*runnerRef)}}
+
+	t.Run("ready", func(t *testing.T) {
+		code, statuses := ready(t, s, "")
+		if code != http.StatusOK {
+			t.Errorf("expected status code 200, actual %d", code)
+		}
+
+		if diff := cmp.Diff(map[string]string{"model_store": "ok", "gpu": "ok", "models": "ok"}, statuses); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("required models", func(t *testing.T) {
+		t.Setenv("OLLAMA_READY_MODELS", "llama3, other")
+
+		if code, statuses := ready(t, s, ""); code != http.StatusServiceUnavailable || statuses["models"] != "fail" {
+			t.Errorf("expected models to fail with 503, got %d %v", code, statuses)
+		}
+
+		s.sched.loaded["llama3"] = &runnerRef{model: &Model{Name: "registry.ollama.ai/library/llama3:latest"}}
+		s.sched.loaded["other"] = &runnerRef{model: &Model{Name: "registry.ollama.ai/library/other:latest"}}
+		s.sched.loaded["other"].loading.Store(true)
+		t.Cleanup(func() { clear(s.sched.loaded) })
+
+		if code, _ := ready(t, s, ""); code != http.StatusServiceUnavailable {
+			t.Errorf("expected a loading model to not be ready, got %d", code)
+		}
+
+		s.sched.loaded["other"].loading.Store(false)
+		if code, statuses := ready(t, s, ""); code != http.StatusOK {
+			t.Errorf("expected status code 200, actual %d %v", code, statuses)
+		}
+
+		if code, _ := ready(t, s, "?model=missing"); code != http.StatusServiceUnavailable {
+			t.Errorf("expected a missing model in the query to not be ready, got %d", code)
+		}
+	})
+
+	t.Run("unreadable model store", func(t *testing.T) {
+		models := t.TempDir()
+		t.Setenv("OLLAMA_MODELS", models)
+
+		if err := os.WriteFile(filepath.Join(models, "blobs"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if code, statuses := ready(t, s, ""); code != http.StatusServiceUnavailable || statuses["model_store"] != "fail" {
+			t.Errorf("expected the model store to fail with 503, got %d %v", code, statuses)
+		}
+	})
+}
+
+func TestGPUCheck(t *testing.T) {
+	var gpu discover.GpuInfo
+	gpu.Library = "cuda"
+
+	cases := []struct {
+		name     string
+		info     discover.SystemInfo
+		required bool
+		status   string
+	}{
+		{"cpu", discover.SystemInfo{DiscoveryErrors: []string{"AMD GPUs not detected"}}, false, "ok"},
+		{"cpu required", discover.SystemInfo{DiscoveryErrors: []string{"AMD GPUs not detected"}}, true, "fail"},
+		{"gpu", discover.SystemInfo{GPUs: []discover.GpuInfo{gpu}}, true, "ok"},
+		{"gpu with errors", discover.SystemInfo{GPUs: []discover.GpuInfo{gpu}, DiscoveryErrors: []string{"AMD GPUs not detected"}}, true, "ok"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if check := gpuCheck(tt.info, tt.required); check.Status != tt.status {
+				t.Errorf("expected status %q, got %q: %s", tt.status, check.Status, check.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/model/imageproc"
+	"github.com/ollama/ollama/model/models/mllama"
+)
+
+var errInvalidImage = errors.New("invalid image")
+
+// maxImagePixels is the most pixels an image may have. Decoding an image takes
+// memory for each of its pixels, which a small, highly compressed file can
+// have hundreds of millions of.
+const maxImagePixels = 8192 * 8192
+
+// clipMaxSize is the longest side that images for CLIP projectors are scaled
+// down to. They are resized again by the runner to the projector's input,
+// which is at most a few tiles of 336px or so, but sending full size photos
+// to it only costs time.
+const clipMaxSize = 2048
+
+// imageProfile is how images are prepared for a family of models before they
+// are sent to the runner.
+type imageProfile struct {
+	// maxSize is the longest side that images are scaled down to, or 0 to
+	// keep their size.
+	maxSize int
+
+	// preprocess, if set, converts images into the pixel values that the
+	// runner takes, along with options which describe them.
+	preprocess func(io.Reader) ([]float32, map[string]any, error)
+}
+
+func imageProfileFor(m *Model) imageProfile {
+	switch {
+	case checkMllamaModelFamily(m) && len(m.ProjectorPaths) > 0:
+		// the llama engine takes mllama images split into tiles which
+		// match their aspect ratio
+		return imageProfile{preprocess: mllama.Preprocess}
+	case len(m.ProjectorPaths) > 0:
+		return imageProfile{maxSize: clipMaxSize}
+	default:
+		// models run by the Ollama engine resize images themselves
+		return imageProfile{}
+	}
+}
+
+// prepareImage returns an image for the runner of m. Every image is turned
+// upright according to its EXIF orientation and converted to JPEG or PNG,
+// which every runner can decode, before the model's profile is applied.
+func prepareImage(m *Model, id int, data []byte) (llm.ImageData, error) {
+	profile := imageProfileFor(m)
+
+	data, err := normalizeImage(data, profile.maxSize)
+	if err != nil {
+		return llm.ImageData{}, fmt.Errorf("%w: %w", errInvalidImage, err)
+	}
+
+	if profile.preprocess == nil {
+		return llm.ImageData{ID: id, Data: data}, nil
+	}
+
+	pixels, opts, err := profile.preprocess(bytes.NewReader(data))
+	if err != nil {
+		return llm.ImageData{}, fmt.Errorf("%w: %w", errInvalidImage, err)
+	}
+
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, pixels); err != nil {
+		return llm.ImageData{}, err
+	}
+
+	ar, ok := opts["aspectRatioIndex"].(int)
+	if !ok {
+		return llm.ImageData{}, errors.New("missing aspect ratio for image")
+	}
+
+	return llm.ImageData{ID: id, Data: b.Bytes(), AspectRatioID: ar}, nil
+}
+
+// normalizeImage returns data if it is an upright JPEG or PNG no larger than
+// maxSize on its longest side, or 0 for any size. Otherwise it returns the
+// image re-encoded after fixing it, as a JPEG if it was one and as a PNG if
+// not. Images in formats Go can't decode are returned as they are.
+func normalizeImage(data []byte, maxSize int) ([]byte, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		// the llama engine decodes a few formats that Go doesn't, such as
+		// TGA, so images in them are left for the runner to reject
+		return data, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if config.Width*config.Height > maxImagePixels {
+		return nil, fmt.Errorf("image is too large: %dx%d is more than %d pixels", config.Width, config.Height, maxImagePixels)
+	}
+
+	orientation := 1
+	if format == "jpeg" {
+		orientation = imageproc.Orientation(data)
+	}
+
+	scale := maxSize > 0 && max(config.Width, config.Height) > maxSize
+	if (format == "jpeg" || format == "png") && orientation == 1 && !scale {
+		return data, nil
+	}
+
+	img, _, err := imageproc.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if scale {
+		size := img.Bounds().Size()
+		ratio := float64(maxSize) / float64(max(size.X, size.Y))
+		size = image.Point{
+			max(int(float64(size.X)*ratio), 1),
+			max(int(float64(size.Y)*ratio), 1),
+		}
+		img = imageproc.Resize(img, size, imageproc.ResizeBilinear)
+	}
+
+	var b bytes.Buffer
+	if format == "jpeg" {
+		err = jpeg.Encode(&b, img, &jpeg.Options{Quality: 95})
+	} else {
+		err = png.Encode(&b, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodeImage(t *testing.T, encode func(*bytes.Buffer, image.Image) error, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+
+	var b bytes.Buffer
+	if err := encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+func encodePNG(b *bytes.Buffer, img image.Image) error  { return png.Encode(b, img) }
+func encodeJPEG(b *bytes.Buffer, img image.Image) error { return jpeg.Encode(b, img, nil) }
+func encodeGIF(b *bytes.Buffer, img image.Image) error  { return gif.Encode(b, img, nil) }
+
+// withOrientation adds an EXIF orientation to a JPEG.
+func withOrientation(data []byte, orientation uint16) []byte {
+	var exif bytes.Buffer
+	exif.WriteString("Exif\x00\x00MM")
+	for _, v := range []any{uint16(42), uint32(8), uint16(1), uint16(0x0112), uint16(3), uint32(1), orientation, uint16(0), uint32(0)} {
+		binary.Write(&exif, binary.BigEndian, v)
+	}
+
+	var b bytes.Buffer
+	b.Write(data[:2])
+	b.Write([]byte{0xff, 0xe1})
+	binary.Write(&b, binary.BigEndian, uint16(2+exif.Len()))
+	b.Write(exif.Bytes())
+	b.Write(data[2:])
+	return b.Bytes()
+}
+
+func TestNormalizeImage(t *testing.T) {
+	upright := encodeImage(t, encodeJPEG, 40, 20)
+
+	cases := []struct {
+		name    string
+		data    []byte
+		maxSize int
+		format  string
+		size    image.Point
+		same    bool
+		err     bool
+	}{
+		{name: "png", data: encodeImage(t, encodePNG, 40, 20), format: "png", size: image.Point{40, 20}, same: true},
+		{name: "upright jpeg", data: upright, format: "jpeg", size: image.Point{40, 20}, same: true},
+		{name: "sideways jpeg", data: withOrientation(upright, 6), format: "jpeg", size: image.Point{20, 40}},
+		{name: "gif", data: encodeImage(t, encodeGIF, 40, 20), format: "png", size: image.Point{40, 20}},
+		{name: "scaled", data: encodeImage(t, encodePNG, 40, 20), maxSize: 10, format: "png", size: image.Point{10, 5}},
+		{name: "small enough", data: encodeImage(t, encodePNG, 40, 20), maxSize: 40, format: "png", size: image.Point{40, 20}, same: true},
+		{name: "unknown format", data: []byte("not an image"), same: true},
+		{name: "truncated", data: encodeImage(t, encodePNG, 40, 20)[:20], err: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := normalizeImage(tt.data, tt.maxSize)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if same := bytes.Equal(data, tt.data); same != tt.same {
+				t.Errorf("expected data to be unchanged: %v, got %v", tt.same, same)
+			}
+
+			if tt.format == "" {
+				return
+			}
+
+			config, format, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if format != tt.format {
+				t.Errorf("expected format %s, got %s", tt.format, format)
+			}
+
+			if size := (image.Point{config.Width, config.Height}); size != tt.size {
+				t.Errorf("expected size %v, got %v", tt.size, size)
+			}
+		})
+	}
+}
+
+func TestNormalizeImageTooLarge(t *testing.T) {
+	// a GIF's header holds the size of its canvas, which needn't match the
+	// frames in it
+	data := encodeImage(t, encodeGIF, 1, 1)
+	binary.LittleEndian.PutUint16(data[6:], 10000)
+	binary.LittleEndian.PutUint16(data[8:], 10000)
+
+	if _, err := normalizeImage(data, 0); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestPrepareImage(t *testing.T) {
+	img := withOrientation(encodeImage(t, encodeJPEG, 1120, 560), 8)
+
+	t.Run("mllama", func(t *testing.T) {
+		m := &Model{ProjectorPaths: []string{"vision"}, Config: ConfigV2{ModelFamilies: []string{"mllama"}}}
+		data, err := prepareImage(m, 1, img)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// two tiles of 560x560 with 3 channels of float32, arranged
+		// vertically now that the image is upright
+		if data.ID != 1 || len(data.Data) != 2*560*560*3*4 || data.AspectRatioID != 2 {
+			t.Errorf("unexpected image %d with %d bytes and aspect ratio %d", data.ID, len(data.Data), data.AspectRatioID)
+		}
+	})
+
+	t.Run("ollama engine", func(t *testing.T) {
+		data, err := prepareImage(&Model{}, 0, img)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config, _, err := image.DecodeConfig(bytes.NewReader(data.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if config.Width != 560 || config.Height != 1120 {
+			t.Errorf("expected 560x1120, got %dx%d", config.Width, config.Height)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := prepareImage(&Model{}, 0, img[:len(img)/2])
+		if !errors.Is(err, errInvalidImage) {
+			t.Errorf("expected invalid image, got %v", err)
+		}
+	})
+}
+
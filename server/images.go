@@ -9,6 +9,10 @@ var (
 	errCapabilityCompletion = errors.New("completion")
 	errCapabilityTools      = errors.New("tools")
 	errCapabilityInsert     = errors.New("insert")
+	errCapabilityThinking   = errors.New("thinking")
+	errCapabilityAudio      = errors.New("audio")
+
+	errCapabilityClassification = errors.New("classification")
 )
 
 type Capability string
@@ -17,6 +21,10 @@ const (
 	CapabilityCompletion = Capability("completion")
 	CapabilityTools      = Capability("tools")
 	CapabilityInsert     = Capability("insert")
+	CapabilityThinking   = Capability("thinking")
+	CapabilityAudio      = Capability("audio")
+
+	CapabilityClassification = Capability("classification")
 )
 
 type registryOptions struct {
@@ -25,6 +33,12 @@ type registryOptions struct {
 	Password string
 	Token    string
 
+	// MaxDownloadRate limits the download rate of a single pull in bytes per second
+	MaxDownloadRate int64
+
+	// OCI pushes models as OCI artifacts rather than Ollama manifests
+	OCI bool
+
 	CheckRedirect func(req *http.Request, via []*http.Request) error
 }
 
@@ -75,6 +89,44 @@ func (m *Model) CheckCapabilities(caps ...Capability) error {
 			if !slices.Contains(vars, "suffix") {
 				errs = append(errs, errCapabilityInsert)
 			}
+		case CapabilityThinking:
+			if !m.thinks() {
+				errs = append(errs, errCapabilityThinking)
+			}
+		case CapabilityAudio:
+			r, err := os.Open(m.ModelPath)
+			if err != nil {
+				slog.Error("couldn't open model file", "error", err)
+				continue
+			}
+			defer r.Close()
+
+			f, _, err := ggml.Decode(r, 0)
+			if err != nil {
+				slog.Error("couldn't decode ggml", "error", err)
+				continue
+			}
+
+			if _, ok := f.KV()[fmt.Sprintf("%s.audio.block_count", f.KV().Architecture())]; !ok {
+				errs = append(errs, errCapabilityAudio)
+			}
+		case CapabilityClassification:
+			r, err := os.Open(m.ModelPath)
+			if err != nil {
+				slog.Error("couldn't open model file", "error", err)
+				continue
+			}
+			defer r.Close()
+
+			f, _, err := ggml.Decode(r, 0)
+			if err != nil {
+				slog.Error("couldn't decode ggml", "error", err)
+				continue
+			}
+
+			if _, ok := f.KV()[fmt.Sprintf("%s.classifier.output_labels", f.KV().Architecture())]; !ok {
+				errs = append(errs, errCapabilityClassification)
+			}
 		default:
 			slog.Error("unknown capability", "capability", cap)
 			return fmt.Errorf("unknown capability: %s", cap)
@@ -168,6 +220,8 @@ type ConfigV2 struct {
 	Architecture string `json:"architecture"`
 	OS           string `json:"os"`
 	RootFS       RootFS `json:"rootfs"`
+
+	Provenance *api.Provenance `json:"provenance,omitempty"`
 }
 
 type RootFS struct {
@@ -181,6 +235,11 @@ func GetManifest(mp ModelPath) (*Manifest, string, error) {
 		return nil, "", err
 	}
 
+	// manifests are small enough to fetch without the context of a request
+	if err := fetchManifest(context.Background(), fp); err != nil {
+		return nil, "", err
+	}
+
 	f, err := os.Open(fp)
 	if err != nil {
 		return nil, "", err
@@ -211,8 +270,10 @@ func GetModel(name string) (*Model, error) {
 		Template:  template.DefaultTemplate,
 	}
 
+	// the layers describing the model are small, but its weights are only
+	// fetched from the blob store when it's loaded
 	if manifest.Config.Digest != "" {
-		filename, err := GetBlobsPath(manifest.Config.Digest)
+		filename, err := fetchBlob(context.Background(), manifest.Config.Digest)
 		if err != nil {
 			return nil, err
 		}
@@ -229,7 +290,15 @@ func GetModel(name string) (*Model, error) {
 	}
 
 	for _, layer := range manifest.Layers {
-		filename, err := GetBlobsPath(layer.Digest)
+		var filename string
+		switch layer.MediaType {
+		case "application/vnd.ollama.image.model",
+			"application/vnd.ollama.image.adapter",
+			"application/vnd.ollama.image.projector":
+			filename, err = GetBlobsPath(layer.Digest)
+		default:
+			filename, err = fetchBlob(context.Background(), layer.Digest)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -294,7 +363,7 @@ func GetModel(name string) (*Model, error) {
 	return model, nil
 }
 
-func CopyModel(src, dst model.Name) error {
+func CopyModel(ctx context.Context, src, dst model.Name) error {
 	if !dst.IsFullyQualified() {
 		return model.Unqualified(dst)
 	}
@@ -306,34 +375,62 @@ func CopyModel(src, dst model.Name) error {
 		return nil
 	}
 
-	manifests, err := GetManifestPath()
+	// the blobs of src mustn't be pruned before dst uses them
+	unlock, err := lockStore()
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	dstpath := filepath.Join(manifests, dst.Filepath())
-	if err := os.MkdirAll(filepath.Dir(dstpath), 0o755); err != nil {
+	manifests, err := GetManifestPath()
+	if err != nil {
 		return err
 	}
 
 	srcpath := filepath.Join(manifests, src.Filepath())
-	srcfile, err := os.Open(srcpath)
-	if err != nil {
+	if err := fetchManifest(ctx, srcpath); err != nil {
 		return err
 	}
-	defer srcfile.Close()
 
-	dstfile, err := os.Create(dstpath)
+	if m, err := ParseNamedManifest(src); err == nil {
+		if err := checkQuota(dst, append(m.Layers, m.Config)); err != nil {
+			return err
+		}
+	}
+
+	bts, err := os.ReadFile(srcpath)
 	if err != nil {
 		return err
 	}
-	defer dstfile.Close()
 
-	_, err = io.Copy(dstfile, srcfile)
-	return err
+	return writeManifestFile(filepath.Join(manifests, dst.Filepath()), bts, func() error {
+		return storeManifest(ctx, dst)
+	})
 }
 
+// deleteUnusedLayers removes the blobs in deleteMap which no manifest uses.
+// While models are being written they're removed once the writes finish.
 func deleteUnusedLayers(deleteMap map[string]struct{}) error {
+	unlock, err := lockStoreExclusive()
+	if errors.Is(err, errStoreInUse) {
+		modelsLock.deferRemoval(deleteMap)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer unlock()
+
+	for digest := range modelsLock.takePending() {
+		deleteMap[digest] = struct{}{}
+	}
+
+	return removeUnusedLayers(deleteMap)
+}
+
+// removeUnusedLayers removes the blobs in deleteMap which no manifest uses,
+// leaving the removed blobs in deleteMap. The models directory must be
+// locked exclusively.
+func removeUnusedLayers(deleteMap map[string]struct{}) error {
 	manifests, err := Manifests(true)
 	if err != nil {
 		return err
@@ -347,6 +444,8 @@ func deleteUnusedLayers(deleteMap map[string]struct{}) error {
 		delete(deleteMap, manifest.Config.Digest)
 	}
 
+	unstoreBlobs(deleteMap)
+
 	for k := range deleteMap {
 		fp, err := GetBlobsPath(k)
 		if err != nil {
@@ -362,7 +461,20 @@ func deleteUnusedLayers(deleteMap map[string]struct{}) error {
 	return nil
 }
 
+// PruneLayers removes partial and unused blobs and empty manifest
+// directories, keeping the partial downloads and upload state of pulls and
+// pushes which can be resumed. It's skipped if the models directory is in
+// use, since the blobs of models being written aren't used by a manifest yet.
 func PruneLayers() error {
+	unlock, err := lockStoreExclusive()
+	if errors.Is(err, errStoreInUse) {
+		slog.Info("models directory is in use, skipping prune")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer unlock()
+
 	deleteMap := make(map[string]struct{})
 	p, err := GetBlobsPath("")
 	if err != nil {
@@ -375,8 +487,22 @@ func PruneLayers() error {
 		return err
 	}
 
+	// partial downloads of pulls which will be resumed are kept
+	resumable := resumableDigests()
+
 	for _, blob := range blobs {
 		name := blob.Name()
+		if digest, _, ok := strings.Cut(name, "-partial"); ok && resumable[strings.Replace(digest, "-", ":", 1)] {
+			continue
+		}
+
+		// the state of pushes which will be resumed is kept with its blob
+		if digest, ok := strings.CutSuffix(name, "-upload"); ok {
+			if _, err := os.Stat(filepath.Join(p, digest)); err == nil {
+				continue
+			}
+		}
+
 		name = strings.ReplaceAll(name, "-", ":")
 
 		_, err := GetBlobsPath(name)
@@ -395,14 +521,19 @@ func PruneLayers() error {
 
 	slog.Info(fmt.Sprintf("total blobs: %d", len(deleteMap)))
 
-	if err := deleteUnusedLayers(deleteMap); err != nil {
+	if err := removeUnusedLayers(deleteMap); err != nil {
 		slog.Error(fmt.Sprintf("couldn't remove unused layers: %v", err))
 		return nil
 	}
 
 	slog.Info(fmt.Sprintf("total unused blobs removed: %d", len(deleteMap)))
 
-	return nil
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return err
+	}
+
+	return PruneDirectory(manifests)
 }
 
 func PruneDirectory(path string) error {
@@ -442,7 +573,7 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 	mp := ParseModelPath(name)
 	fn(api.ProgressResponse{Status: "retrieving manifest"})
 
-	if mp.ProtocolScheme == "http" && !regOpts.Insecure {
+	if mp.ProtocolScheme == "http" && !regOpts.Insecure && !insecureRegistry(mp.Registry) {
 		return errors.New("insecure protocol http")
 	}
 
@@ -458,14 +589,20 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		layers = append(layers, manifest.Config)
 	}
 
-	for _, layer := range layers {
-		if err := uploadBlob(ctx, mp, layer, regOpts, fn); err != nil {
-			slog.Info(fmt.Sprintf("error uploading blob: %v", err))
+	if err := uploadBlobs(ctx, mp, layers, regOpts, fn); err != nil {
+		return err
+	}
+
+	fn(api.ProgressResponse{Status: "pushing manifest"})
+	if regOpts.OCI {
+		if err := pushArtifact(ctx, mp, manifest, regOpts); err != nil {
 			return err
 		}
+
+		fn(api.ProgressResponse{Status: "success"})
+		return nil
 	}
 
-	fn(api.ProgressResponse{Status: "pushing manifest"})
 	requestURL := mp.BaseURL()
 	requestURL = requestURL.JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
 
@@ -488,14 +625,22 @@ func PushModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 }
 
 func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn func(api.ProgressResponse)) error {
+	unlock, err := lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	mp := ParseModelPath(name)
 
 	deleteMap := make(map[string]struct{})
+	var previous []Layer
 	manifest, _, err := GetManifest(mp)
 	if errors.Is(err, os.ErrNotExist) {
 	} else if err != nil {
 		slog.Warn("pulling model with bad existing manifest", "name", name, "error", err)
 	} else {
+		previous = manifest.Layers
 		for _, l := range manifest.Layers {
 			deleteMap[l.Digest] = struct{}{}
 		}
@@ -504,13 +649,13 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		}
 	}
 
-	if mp.ProtocolScheme == "http" && !regOpts.Insecure {
+	if mp.ProtocolScheme == "http" && !regOpts.Insecure && !insecureRegistry(mp.Registry) {
 		return errors.New("insecure protocol http")
 	}
 
 	fn(api.ProgressResponse{Status: "pulling manifest"})
 
-	manifest, err = pullModelManifest(ctx, mp, regOpts)
+	manifest, sources, err := pullManifestMirrored(ctx, mp, regOpts)
 	if err != nil {
 		return fmt.Errorf("pull model manifest: %s", err)
 	}
@@ -521,30 +666,39 @@ func PullModel(ctx context.Context, name string, regOpts *registryOptions, fn fu
 		layers = append(layers, manifest.Config)
 	}
 
-	skipVerify := make(map[string]bool)
+	if previous != nil {
+		var changed int
+		for _, layer := range layers {
+			if _, ok := deleteMap[layer.Digest]; !ok {
+				changed++
+			}
+		}
+
+		slog.Info(fmt.Sprintf("updating %s, %d of %d layer(s) changed", name, changed, len(layers)))
+	}
+
+	if err := checkQuota(model.ParseName(name), layers); err != nil {
+		return err
+	}
+
 	for _, layer := range layers {
-		cacheHit, err := downloadBlob(ctx, downloadOpts{
-			mp:      mp,
-			digest:  layer.Digest,
-			regOpts: regOpts,
-			fn:      fn,
+		_, err := downloadBlobMirrored(ctx, sources, downloadOpts{
+			digest: layer.Digest,
+			fn:     fn,
+			size:   layer.Size,
+			base:   patchBase(previous, layer),
 		})
 		if err != nil {
 			return err
 		}
-		skipVerify[layer.Digest] = cacheHit
 		delete(deleteMap, layer.Digest)
 	}
 	delete(deleteMap, manifest.Config.Digest)
 
+	// layers are verified as they download, and layers which were
+	// already stored aren't verified again, so there's nothing left to
+	// hash but clients still expect the status
 	fn(api.ProgressResponse{Status: "verifying sha256 digest"})
-	for _, layer := range layers {
-		if skipVerify[layer.Digest] {
-			// verification skipped
-		} else {
-			// verification logic omitted
-		}
-	}
 
 	return nil
 }
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// calibrationContext is the number of tokens of calibration data run through
+// a model at a time when computing its importance matrix.
+const calibrationContext = 512
+
+var errCalibrationTooShort = errors.New("calibration data is too short")
+
+// calibrationTypes are the file types which can only be quantized with an
+// importance matrix, since the quality of the models is poor without one.
+var calibrationTypes = []string{"IQ1_S", "IQ1_M", "IQ2_XXS", "IQ2_XS", "IQ2_S", "IQ2_M", "Q2_K_S"}
+
+// computeImportance runs the calibration texts through the model of r and
+// returns the importance of the columns of each of its weights, which is
+// the mean of the squares of the activations they're multiplied with. The
+// model is run by the runner the scheduler loaded it in, so calibration
+// is limited to the memory and threads of any other request.
+func computeImportance(ctx context.Context, r llm.LlamaServer, calibration []string, fn func(api.ProgressResponse)) (map[string][]float32, error) {
+	var n int
+	for _, s := range calibration {
+		tokens, err := r.Tokenize(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+
+		n += len(tokens)
+	}
+
+	// activations are only collected for batches of at least 16 tokens
+	if n < 16 {
+		return nil, errCalibrationTooShort
+	}
+
+	return r.Importance(ctx, llm.ImportanceRequest{Calibration: calibration, Context: calibrationContext}, func(resp llm.ImportanceResponse) {
+		fn(api.ProgressResponse{
+			Status:    "computing importance matrix",
+			Total:     int64(resp.Total),
+			Completed: int64(resp.Completed),
+		})
+	})
+}
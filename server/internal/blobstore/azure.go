@@ -0,0 +1,327 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// azureVersion is the version of the Blob Storage REST API used.
+const azureVersion = "2021-08-06"
+
+// azureStore stores objects in an Azure Blob Storage container.
+type azureStore struct {
+	client *http.Client
+	now    func() time.Time
+
+	// endpoint is the base URL of the container
+	endpoint *url.URL
+	prefix   string
+	partSize int64
+
+	account string
+	// key is the decoded account key for Shared Key authorization
+	key []byte
+	// sas is a shared access signature added to the query of every request
+	sas url.Values
+}
+
+// newAzure reads the account and credentials from
+// AZURE_STORAGE_CONNECTION_STRING, or from AZURE_STORAGE_ACCOUNT along with
+// either AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN.
+func newAzure(container, prefix string) (*azureStore, error) {
+	if container == "" {
+		return nil, errors.New("blobstore: azblob container is required")
+	}
+
+	settings := map[string]string{
+		"AccountName":           os.Getenv("AZURE_STORAGE_ACCOUNT"),
+		"AccountKey":            os.Getenv("AZURE_STORAGE_KEY"),
+		"SharedAccessSignature": os.Getenv("AZURE_STORAGE_SAS_TOKEN"),
+	}
+
+	if cs := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); cs != "" {
+		for _, field := range strings.Split(cs, ";") {
+			if k, v, ok := strings.Cut(field, "="); ok {
+				settings[k] = v
+			}
+		}
+	}
+
+	s := &azureStore{
+		client:   http.DefaultClient,
+		now:      time.Now,
+		prefix:   prefix,
+		partSize: partSize,
+		account:  settings["AccountName"],
+	}
+
+	if s.account == "" {
+		return nil, errors.New("blobstore: AZURE_STORAGE_ACCOUNT or AZURE_STORAGE_CONNECTION_STRING is required")
+	}
+
+	endpoint := settings["BlobEndpoint"]
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", s.account)
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	s.endpoint = u.JoinPath(container)
+
+	if key := settings["AccountKey"]; key != "" {
+		if s.key, err = base64.StdEncoding.DecodeString(key); err != nil {
+			return nil, fmt.Errorf("blobstore: invalid account key: %w", err)
+		}
+	} else if sas := settings["SharedAccessSignature"]; sas != "" {
+		if s.sas, err = url.ParseQuery(strings.TrimPrefix(sas, "?")); err != nil {
+			return nil, fmt.Errorf("blobstore: invalid shared access signature: %w", err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *azureStore) do(ctx context.Context, method, key string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u := *s.endpoint
+	if key != "" {
+		u = *u.JoinPath(objectKey(s.prefix, key))
+	}
+
+	if query == nil {
+		query = make(url.Values)
+	}
+
+	for k, v := range s.sas {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	req.Header.Set("X-Ms-Version", azureVersion)
+	req.Header.Set("X-Ms-Date", s.now().UTC().Format(http.TimeFormat))
+	if s.key != nil {
+		s.sign(req)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// sign adds a Shared Key signature to req.
+func (s *azureStore) sign(req *http.Request) {
+	var contentLength string
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	var b strings.Builder
+	for _, v := range []string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date is replaced by X-Ms-Date
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+	} {
+		b.WriteString(v)
+		b.WriteByte('\n')
+	}
+
+	var names []string
+	for k := range req.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-ms-") {
+			names = append(names, k)
+		}
+	}
+
+	slices.Sort(names)
+	for _, k := range names {
+		fmt.Fprintf(&b, "%s:%s\n", k, strings.TrimSpace(req.Header.Get(k)))
+	}
+
+	fmt.Fprintf(&b, "/%s%s", s.account, req.URL.EscapedPath())
+
+	query := req.URL.Query()
+	for _, k := range slices.Sorted(maps.Keys(query)) {
+		values := slices.Sorted(slices.Values(query[k]))
+		fmt.Fprintf(&b, "\n%s:%s", strings.ToLower(k), strings.Join(values, ","))
+	}
+
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(b.String()))
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", s.account, base64.StdEncoding.EncodeToString(h.Sum(nil))))
+}
+
+func (s *azureStore) Stat(ctx context.Context, key string) (int64, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	drain(resp)
+
+	return resp.ContentLength, nil
+}
+
+func (s *azureStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *azureStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if size > s.partSize {
+		return s.putBlocks(ctx, key, r, size)
+	}
+
+	header := http.Header{"X-Ms-Blob-Type": {"BlockBlob"}}
+	resp, err := s.do(ctx, http.MethodPut, key, nil, header, io.LimitReader(r, size), size)
+	if err != nil {
+		return err
+	}
+	drain(resp)
+
+	return nil
+}
+
+// putBlocks uploads objects larger than a single request allows as blocks
+// of s.partSize which are committed together. Blocks which are never
+// committed are discarded by the service.
+func (s *azureStore) putBlocks(ctx context.Context, key string, r io.Reader, size int64) error {
+	var blockList struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string
+	}
+
+	for offset := int64(0); offset < size; offset += s.partSize {
+		n := min(s.partSize, size-offset)
+
+		// block IDs must all be the same length
+		id := base64.StdEncoding.EncodeToString(fmt.Appendf(nil, "%06d", len(blockList.Latest)))
+		query := url.Values{"comp": {"block"}, "blockid": {id}}
+
+		resp, err := s.do(ctx, http.MethodPut, key, query, nil, io.LimitReader(r, n), n)
+		if err != nil {
+			return err
+		}
+		drain(resp)
+
+		blockList.Latest = append(blockList.Latest, id)
+	}
+
+	bts, err := xml.Marshal(blockList)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, key, url.Values{"comp": {"blocklist"}}, nil, bytes.NewReader(bts), int64(len(bts)))
+	if err != nil {
+		return err
+	}
+	drain(resp)
+
+	return nil
+}
+
+func (s *azureStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	drain(resp)
+
+	return nil
+}
+
+func (s *azureStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	var marker string
+	for {
+		query := url.Values{
+			"restype": {"container"},
+			"comp":    {"list"},
+			"prefix":  {objectKey(s.prefix, prefix)},
+		}
+
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Blobs []struct {
+				Name string
+			} `xml:"Blobs>Blob"`
+			NextMarker string
+		}
+
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range result.Blobs {
+			keys = append(keys, b.Name)
+		}
+
+		if result.NextMarker == "" {
+			return trimPrefix(keys, s.prefix), nil
+		}
+
+		marker = result.NextMarker
+	}
+}
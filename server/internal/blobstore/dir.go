@@ -0,0 +1,139 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Dir stores objects as files in a directory, which may be a network file
+// system shared by several servers.
+type Dir struct {
+	root string
+}
+
+// NewDir returns a store rooted at the directory root.
+func NewDir(root string) *Dir {
+	return &Dir{root: root}
+}
+
+func (d *Dir) path(key string) string {
+	return filepath.Join(d.root, filepath.FromSlash(key))
+}
+
+func (d *Dir) Stat(_ context.Context, key string) (int64, error) {
+	fi, err := os.Stat(d.path(key))
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+// Get stops reading when ctx is done, like the stores which read over a
+// network, since a network file system may be as slow.
+func (d *Dir) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(d.path(key))
+	if err != nil {
+		return nil, err
+	}
+
+	return &dirReader{ctx: ctx, f: f}, nil
+}
+
+// dirReader doesn't embed its file, whose WriteTo would be used by io.Copy
+// instead of Read.
+type dirReader struct {
+	ctx context.Context
+	f   *os.File
+}
+
+func (r *dirReader) Read(b []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.f.Read(b)
+}
+
+func (r *dirReader) Close() error {
+	return r.f.Close()
+}
+
+// Put writes to a temporary file which is renamed into place so readers
+// never see partial objects.
+func (d *Dir) Put(_ context.Context, key string, r io.Reader, size int64) error {
+	p := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if n, err := io.Copy(f, r); err != nil {
+		return err
+	} else if n != size {
+		return io.ErrUnexpectedEOF
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), p)
+}
+
+func (d *Dir) Delete(_ context.Context, key string) error {
+	if err := os.Remove(d.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (d *Dir) List(_ context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(d.root, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && p == d.root {
+				return filepath.SkipDir
+			}
+
+			return err
+		}
+
+		if de.IsDir() || strings.Contains(de.Name(), ".tmp-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(d.root, p)
+		if err != nil {
+			return err
+		}
+
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+
+	return keys, err
+}
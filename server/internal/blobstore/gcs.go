@@ -0,0 +1,213 @@
+package blobstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metadataTokenURL returns access tokens for the service account of
+// Compute Engine instances, including GKE and Cloud Run.
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// gcsStore stores objects in a Google Cloud Storage bucket using its XML API.
+type gcsStore struct {
+	client *http.Client
+
+	// endpoint is the base URL of the bucket
+	endpoint *url.URL
+	prefix   string
+
+	// token returns the access token for requests. An empty token sends
+	// requests without authorization.
+	token func(context.Context) (string, error)
+}
+
+// newGCS authorizes requests with GOOGLE_OAUTH_ACCESS_TOKEN if it's set and
+// the service account of the instance otherwise. STORAGE_EMULATOR_HOST
+// points the store at an emulator, which doesn't need authorization.
+func newGCS(bucket, prefix string) (*gcsStore, error) {
+	if bucket == "" {
+		return nil, errors.New("blobstore: gs bucket is required")
+	}
+
+	s := &gcsStore{
+		client:   http.DefaultClient,
+		endpoint: &url.URL{Scheme: "https", Host: "storage.googleapis.com", Path: "/" + bucket},
+		prefix:   prefix,
+	}
+
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+
+		u, err := url.Parse(host)
+		if err != nil {
+			return nil, err
+		}
+
+		s.endpoint = u.JoinPath(bucket)
+		s.token = func(context.Context) (string, error) { return "", nil }
+	} else if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		s.token = func(context.Context) (string, error) { return token, nil }
+	} else {
+		s.token = (&metadataToken{client: s.client}).Token
+	}
+
+	return s, nil
+}
+
+// metadataToken caches access tokens from the metadata server until shortly
+// before they expire.
+type metadataToken struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (t *metadataToken) Token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if time.Now().Before(t.expires) {
+		return t.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("blobstore: no Google Cloud credentials, set GOOGLE_OAUTH_ACCESS_TOKEN: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("blobstore: metadata server: %s", resp.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+
+	t.token = token.AccessToken
+	t.expires = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return t.token, nil
+}
+
+func (s *gcsStore) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u := *s.endpoint
+	if key != "" {
+		u = *u.JoinPath(objectKey(s.prefix, key))
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+
+	token, err := s.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (s *gcsStore) Stat(ctx context.Context, key string) (int64, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, 0)
+	if err != nil {
+		return 0, err
+	}
+	drain(resp)
+
+	return resp.ContentLength, nil
+}
+
+func (s *gcsStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// Put uploads objects in a single request since Cloud Storage accepts
+// objects of up to 5 TiB that way.
+func (s *gcsStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, io.LimitReader(r, size), size)
+	if err != nil {
+		return err
+	}
+	drain(resp)
+
+	return nil
+}
+
+func (s *gcsStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	drain(resp)
+
+	return nil
+}
+
+func (s *gcsStore) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := listBucket(ctx, func(ctx context.Context, marker string) (*http.Response, error) {
+		query := url.Values{"prefix": {objectKey(s.prefix, prefix)}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		return s.do(ctx, http.MethodGet, "", query, nil, 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return trimPrefix(keys, s.prefix), nil
+}
@@ -0,0 +1,355 @@
+package blobstore
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// unsignedPayload signs requests without hashing their body so uploads can
+// be streamed.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// s3Store stores objects in an Amazon S3 bucket or a compatible service
+// such as MinIO.
+type s3Store struct {
+	client *http.Client
+	now    func() time.Time
+
+	// endpoint is the base URL of the bucket
+	endpoint *url.URL
+	prefix   string
+	partSize int64
+
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// newS3 reads the region, credentials and optional endpoint from the
+// standard AWS_* environment variables. Custom endpoints use path style
+// URLs since few S3 compatible services support virtual hosted buckets.
+func newS3(bucket, prefix string) (*s3Store, error) {
+	if bucket == "" {
+		return nil, errors.New("blobstore: s3 bucket is required")
+	}
+
+	s := &s3Store{
+		client:       http.DefaultClient,
+		now:          time.Now,
+		prefix:       prefix,
+		partSize:     partSize,
+		region:       cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	if endpoint := cmp.Or(os.Getenv("AWS_ENDPOINT_URL_S3"), os.Getenv("AWS_ENDPOINT_URL")); endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, err
+		}
+
+		s.endpoint = u.JoinPath(bucket)
+	} else if strings.Contains(bucket, ".") {
+		// buckets with dots don't match the TLS certificate of virtual hosts
+		s.endpoint = &url.URL{Scheme: "https", Host: fmt.Sprintf("s3.%s.amazonaws.com", s.region), Path: "/" + bucket}
+	} else {
+		s.endpoint = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, s.region), Path: "/"}
+	}
+
+	return s, nil
+}
+
+// do sends a request for key, or the bucket itself if key is empty. The
+// payload hash of body is either its SHA-256 or unsignedPayload.
+func (s *s3Store) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := *s.endpoint
+	if key != "" {
+		u = *u.JoinPath(objectKey(s.prefix, key))
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+
+	s.sign(req, payloadHash, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// doBytes sends a request with a small body which is signed along with the
+// request.
+func (s *s3Store) doBytes(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	sum := sha256.Sum256(body)
+	return s.do(ctx, method, key, query, bytes.NewReader(body), int64(len(body)), hex.EncodeToString(sum[:]))
+}
+
+// sign adds an AWS Signature Version 4 to req. Requests are sent unsigned
+// if there are no credentials, which only works for public buckets.
+func (s *s3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	date := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	if s.accessKey == "" {
+		return
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+
+	names := slices.Sorted(maps.Keys(headers))
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		cmp.Or(req.URL.EscapedPath(), "/"),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date[:8], s.region, "s3", "aws4_request"}, "/")
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", date, scope, hex.EncodeToString(sum[:])}, "\n")
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date[:8], s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		s.accessKey, scope, signedHeaders, hmacSHA256(key, stringToSign),
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by key with spaces escaped as %20.
+func canonicalQuery(query url.Values) string {
+	var parts []string
+	for _, k := range slices.Sorted(maps.Keys(query)) {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+func uriEncode(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func (s *s3Store) Stat(ctx context.Context, key string) (int64, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return 0, err
+	}
+	drain(resp)
+
+	return resp.ContentLength, nil
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if size > s.partSize {
+		return s.putMultipart(ctx, key, r, size)
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, key, nil, io.LimitReader(r, size), size, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	drain(resp)
+
+	return nil
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// putMultipart uploads objects larger than a single request allows in
+// parts of s.partSize. Failed uploads are aborted so their parts aren't
+// kept around.
+func (s *s3Store) putMultipart(ctx context.Context, key string, r io.Reader, size int64) (err error) {
+	resp, err := s.doBytes(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+
+	var initiated struct {
+		UploadId string
+	}
+
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			resp, abortErr := s.doBytes(context.WithoutCancel(ctx), http.MethodDelete, key, url.Values{"uploadId": {initiated.UploadId}}, nil)
+			if abortErr == nil {
+				drain(resp)
+			}
+		}
+	}()
+
+	var complete struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}
+
+	for offset := int64(0); offset < size; offset += s.partSize {
+		n := min(s.partSize, size-offset)
+		query := url.Values{
+			"partNumber": {strconv.Itoa(len(complete.Parts) + 1)},
+			"uploadId":   {initiated.UploadId},
+		}
+
+		resp, err := s.do(ctx, http.MethodPut, key, query, io.LimitReader(r, n), n, unsignedPayload)
+		if err != nil {
+			return err
+		}
+		drain(resp)
+
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: len(complete.Parts) + 1, ETag: resp.Header.Get("ETag")})
+	}
+
+	bts, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+
+	resp, err = s.doBytes(ctx, http.MethodPost, key, url.Values{"uploadId": {initiated.UploadId}}, bts)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// completing an upload can fail after the response has started, in
+	// which case the error is in the body of a successful response
+	var result struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	if result.XMLName.Local == "Error" {
+		return &StatusError{StatusCode: http.StatusInternalServerError, Message: result.Code + " " + result.Message}
+	}
+
+	return nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, 0, emptyPayloadHash)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	drain(resp)
+
+	return nil
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := listBucket(ctx, func(ctx context.Context, marker string) (*http.Response, error) {
+		query := url.Values{"prefix": {objectKey(s.prefix, prefix)}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		return s.do(ctx, http.MethodGet, "", query, nil, 0, emptyPayloadHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return trimPrefix(keys, s.prefix), nil
+}
+
+// trimPrefix removes the prefix of a store from the keys of its objects.
+func trimPrefix(keys []string, prefix string) []string {
+	if prefix == "" {
+		return keys
+	}
+
+	for i := range keys {
+		keys[i] = strings.TrimPrefix(keys[i], prefix+"/")
+	}
+
+	return keys
+}
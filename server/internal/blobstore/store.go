@@ -0,0 +1,182 @@
+// Package blobstore stores model blobs and manifests in a directory, such as
+// an NFS mount, or in an object store such as Amazon S3, Google Cloud Storage
+// or Azure Blob Storage.
+//
+// Objects are addressed by slash separated keys relative to the root of the
+// store. Errors for missing objects satisfy errors.Is(err, fs.ErrNotExist).
+package blobstore
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Store is a flat namespace of immutable objects.
+type Store interface {
+	// Stat returns the size of the object at key.
+	Stat(ctx context.Context, key string) (int64, error)
+	// Get opens the object at key for reading.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Put writes size bytes read from r to the object at key, replacing any
+	// existing object.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Delete removes the object at key. Deleting a missing object is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+	// List returns the keys of all objects which start with prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// partSize is the size of each part of objects uploaded in multiple parts.
+// Object stores limit the size of a single upload to a few gigabytes, which
+// many models exceed.
+const partSize = 1 << 30
+
+// Open returns the store for rawURL, which is one of:
+//
+//	file:///path/to/dir (or just /path/to/dir)
+//	s3://bucket/prefix
+//	gs://bucket/prefix
+//	azblob://container/prefix
+//
+// Credentials for object stores are read from the environment variables
+// their own tools use, e.g. AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+func Open(rawURL string) (Store, error) {
+	if filepath.IsAbs(rawURL) {
+		return NewDir(rawURL), nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "file":
+		return NewDir(filepath.FromSlash(u.Path)), nil
+	case "s3":
+		return newS3(u.Host, prefix)
+	case "gs":
+		return newGCS(u.Host, prefix)
+	case "azblob":
+		return newAzure(u.Host, prefix)
+	default:
+		return nil, fmt.Errorf("blobstore: unsupported store %q", rawURL)
+	}
+}
+
+// objectKey joins the prefix of a store with key.
+func objectKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+
+	return prefix + "/" + key
+}
+
+// StatusError is returned for unexpected responses from object stores.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("blobstore: %s", http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("blobstore: %s: %s", http.StatusText(e.StatusCode), e.Message)
+}
+
+func (e *StatusError) Is(target error) bool {
+	return e.StatusCode == http.StatusNotFound && target == fs.ErrNotExist
+}
+
+// checkResponse returns an error for responses which aren't successful and
+// closes their body.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	// object stores describe errors in an XML document
+	var e struct {
+		Code    string
+		Message string
+	}
+
+	bts, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err := xml.Unmarshal(bts, &e); err != nil {
+		e.Message = strings.TrimSpace(string(bts))
+	}
+
+	return &StatusError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(e.Code + " " + e.Message)}
+}
+
+// drain discards and closes the body of a response so its connection can
+// be reused.
+func drain(resp *http.Response) {
+	io.Copy(io.Discard, resp.Body) //nolint:errcheck
+	resp.Body.Close()
+}
+
+// listBucketResult is the response to listing objects with the S3 compatible
+// API shared by S3 and Cloud Storage.
+type listBucketResult struct {
+	IsTruncated bool
+	NextMarker  string
+	Contents    []struct {
+		Key string
+	}
+}
+
+var errIncompleteListing = errors.New("blobstore: truncated listing without marker")
+
+// listBucket pages through a listing of objects using do to send each
+// request with the given marker.
+func listBucket(ctx context.Context, do func(ctx context.Context, marker string) (*http.Response, error)) ([]string, error) {
+	var keys []string
+	var marker string
+	for {
+		resp, err := do(ctx, marker)
+		if err != nil {
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+
+		if !result.IsTruncated {
+			return keys, nil
+		}
+
+		// S3 only returns NextMarker when listing with a delimiter,
+		// otherwise the last key is the marker
+		marker = result.NextMarker
+		if marker == "" && len(result.Contents) > 0 {
+			marker = result.Contents[len(result.Contents)-1].Key
+		}
+
+		if marker == "" {
+			return nil, errIncompleteListing
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Package delta implements the binary patch format used to update a large
+// layer from a version of it which is already stored locally.
+//
+// # Format
+//
+// A patch starts with the magic string "ODLT" followed by a version byte,
+// currently 1. The rest of the patch is a sequence of operations, each
+// starting with a single byte:
+//
+//   - 'C' <offset> <length>: copy length bytes of the base starting at offset
+//   - 'I' <length> <data>: insert the next length bytes of the patch
+//   - 'E': end of the patch
+//
+// Offsets and lengths are unsigned varints. Applying a patch writes the
+// target to dst in order, so the target's digest can be computed while the
+// patch is applied. A patch which ends without an 'E' operation is invalid.
+package delta
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	magic   = "ODLT"
+	version = 1
+
+	opCopy   = 'C'
+	opInsert = 'I'
+	opEnd    = 'E'
+)
+
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// Apply reads a patch from r and writes the target to dst using base, which
+// is size bytes long, as the source of copy operations.
+func Apply(dst io.Writer, base io.ReaderAt, size int64, r io.Reader) error {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+	}
+
+	if string(header[:len(magic)]) != magic {
+		return fmt.Errorf("%w: bad magic %q", ErrInvalidPatch, header[:len(magic)])
+	}
+
+	if header[len(magic)] != version {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidPatch, header[len(magic)])
+	}
+
+	for {
+		op, err := br.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w: %w", ErrInvalidPatch, io.ErrUnexpectedEOF)
+		} else if err != nil {
+			return err
+		}
+
+		switch op {
+		case opCopy:
+			offset, err := readUvarint(br)
+			if err != nil {
+				return err
+			}
+
+			length, err := readUvarint(br)
+			if err != nil {
+				return err
+			}
+
+			if offset > uint64(size) || length > uint64(size)-offset {
+				return fmt.Errorf("%w: copy of %d bytes at %d is outside of the base", ErrInvalidPatch, length, offset)
+			}
+
+			if _, err := io.Copy(dst, io.NewSectionReader(base, int64(offset), int64(length))); err != nil {
+				return err
+			}
+		case opInsert:
+			length, err := readUvarint(br)
+			if err != nil {
+				return err
+			}
+
+			if n, err := io.CopyN(dst, br, int64(length)); errors.Is(err, io.EOF) {
+				return fmt.Errorf("%w: insert of %d bytes ended after %d", ErrInvalidPatch, length, n)
+			} else if err != nil {
+				return err
+			}
+		case opEnd:
+			return nil
+		default:
+			return fmt.Errorf("%w: unknown operation %q", ErrInvalidPatch, op)
+		}
+	}
+}
+
+func readUvarint(r io.ByteReader) (uint64, error) {
+	n, err := binary.ReadUvarint(r)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidPatch, io.ErrUnexpectedEOF)
+	} else if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrInvalidPatch, err)
+	}
+
+	if n > 1<<62 {
+		return 0, fmt.Errorf("%w: value %d out of range", ErrInvalidPatch, n)
+	}
+
+	return n, nil
+}
+
+// Writer encodes a patch. Operations are written in the order the target is
+// assembled and Close must be called to end the patch.
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+// NewWriter writes the patch header to w and returns a Writer for the
+// patch operations.
+func NewWriter(w io.Writer) *Writer {
+	pw := &Writer{w: w}
+	pw.write(append([]byte(magic), version))
+	return pw
+}
+
+// Copy appends length bytes of the base starting at offset to the target.
+func (w *Writer) Copy(offset, length int64) error {
+	b := []byte{opCopy}
+	b = binary.AppendUvarint(b, uint64(offset))
+	b = binary.AppendUvarint(b, uint64(length))
+	w.write(b)
+	return w.err
+}
+
+// Insert appends data to the target.
+func (w *Writer) Insert(data []byte) error {
+	w.write(binary.AppendUvarint([]byte{opInsert}, uint64(len(data))))
+	w.write(data)
+	return w.err
+}
+
+// Close ends the patch. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	w.write([]byte{opEnd})
+	return w.err
+}
+
+func (w *Writer) write(b []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(b)
+	}
+}
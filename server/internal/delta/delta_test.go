@@ -0,0 +1,75 @@
+package delta
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	base := []byte("the quick brown fox jumps over the lazy dog")
+
+	var patch bytes.Buffer
+	w := NewWriter(&patch)
+	if err := w.Copy(0, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Insert([]byte("red")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Copy(15, 28); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var target bytes.Buffer
+	if err := Apply(&target, bytes.NewReader(base), int64(len(base)), &patch); err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := "the quick red fox jumps over the lazy dog"; target.String() != expect {
+		t.Errorf("expected %q, got %q", expect, target.String())
+	}
+}
+
+func TestApplyInvalid(t *testing.T) {
+	base := []byte("base")
+
+	patch := func(fn func(w *Writer)) []byte {
+		var b bytes.Buffer
+		w := NewWriter(&b)
+		fn(w)
+		return b.Bytes()
+	}
+
+	valid := patch(func(w *Writer) {
+		w.Insert([]byte("inserted"))
+		w.Close()
+	})
+
+	cases := map[string][]byte{
+		"empty":         nil,
+		"bad magic":     []byte("ABCD\x01E"),
+		"bad version":   []byte("ODLT\x02E"),
+		"unknown op":    []byte("ODLT\x01X"),
+		"no end":        patch(func(w *Writer) { w.Copy(0, 4) }),
+		"short insert":  valid[:len(valid)-3],
+		"copy past end": patch(func(w *Writer) { w.Copy(2, 3); w.Close() }),
+		"copy overflow": patch(func(w *Writer) { w.Copy(1, -1); w.Close() }),
+	}
+
+	for name, p := range cases {
+		t.Run(name, func(t *testing.T) {
+			var target bytes.Buffer
+			err := Apply(&target, bytes.NewReader(base), int64(len(base)), bytes.NewReader(p))
+			if !errors.Is(err, ErrInvalidPatch) {
+				t.Errorf("expected ErrInvalidPatch, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// A manifest write is journaled in its own directory of the journal, which
+// holds these files. The target is written last so a journal entry without
+// one was abandoned before the manifest was touched.
+const (
+	journalTarget   = "target"
+	journalPrevious = "previous"
+	journalStaged   = "manifest"
+)
+
+func journalPath() (string, error) {
+	path := filepath.Join(envconfig.Models(), "journal")
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// manifestTxn replaces a manifest such that it can be rolled back, either
+// when a later step such as storing the model fails or by repairManifests
+// when the server stops before the transaction ends.
+type manifestTxn struct {
+	// dir is the journal entry of the transaction
+	dir string
+	// p is the path of the manifest
+	p string
+}
+
+// beginManifestTxn journals the manifest at path p, keeping a copy of the
+// current manifest if there is one.
+func beginManifestTxn(p string) (*manifestTxn, error) {
+	journal, err := journalPath()
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return nil, err
+	}
+
+	rel, err := filepath.Rel(manifests, p)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp(journal, "")
+	if err != nil {
+		return nil, err
+	}
+
+	t := &manifestTxn{dir: dir, p: p}
+	if err := t.begin(rel); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *manifestTxn) begin(rel string) error {
+	bts, err := os.ReadFile(t.p)
+	if err == nil {
+		if err := writeFileSync(filepath.Join(t.dir, journalPrevious), bts); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return writeFileSync(filepath.Join(t.dir, journalTarget), []byte(filepath.ToSlash(rel)))
+}
+
+// write replaces the manifest with bts. The manifest is staged in the
+// journal entry and renamed into place so it's never partially written.
+func (t *manifestTxn) write(bts []byte) error {
+	if err := os.MkdirAll(filepath.Dir(t.p), 0o755); err != nil {
+		return err
+	}
+
+	staged := filepath.Join(t.dir, journalStaged)
+	if err := writeFileSync(staged, bts); err != nil {
+		return err
+	}
+
+	return os.Rename(staged, t.p)
+}
+
+// rollback restores the manifest from before the transaction, removing it
+// if there wasn't one, and ends the transaction.
+func (t *manifestTxn) rollback() error {
+	if err := os.Rename(filepath.Join(t.dir, journalPrevious), t.p); errors.Is(err, os.ErrNotExist) {
+		if err := os.Remove(t.p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	return t.end()
+}
+
+// end commits the transaction by removing its journal entry.
+func (t *manifestTxn) end() error {
+	return os.RemoveAll(t.dir)
+}
+
+// writeManifestFile replaces the manifest at path p with bts, then calls
+// commit if it's not nil. The previous manifest is restored if commit fails.
+func writeManifestFile(p string, bts []byte, commit func() error) error {
+	unlock, err := lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	t, err := beginManifestTxn(p)
+	if err != nil {
+		return err
+	}
+
+	if err := t.write(bts); err != nil {
+		return errors.Join(err, t.rollback())
+	}
+
+	if commit != nil {
+		if err := commit(); err != nil {
+			return errors.Join(err, t.rollback())
+		}
+	}
+
+	return t.end()
+}
+
+// repairManifests rolls back manifest writes which were interrupted, such
+// as by a crash while a model was being created, so no model is left with
+// a partially written or unstored manifest.
+func repairManifests() error {
+	journal, err := journalPath()
+	if err != nil {
+		return err
+	}
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(journal)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		dir := filepath.Join(journal, entry.Name())
+		if !entry.IsDir() {
+			if err := os.Remove(dir); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rel, err := os.ReadFile(filepath.Join(dir, journalTarget))
+		if errors.Is(err, os.ErrNotExist) {
+			// the manifest wasn't changed
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if !filepath.IsLocal(filepath.FromSlash(string(rel))) {
+			return fmt.Errorf("invalid journal entry %s: %q", entry.Name(), rel)
+		}
+
+		p := filepath.Join(manifests, filepath.FromSlash(string(rel)))
+		slog.Info("rolling back interrupted manifest write", "path", p)
+		t := manifestTxn{dir: dir, p: p}
+		if err := t.rollback(); err != nil {
+			return err
+		}
+	}
+
+	return PruneDirectory(manifests)
+}
+
+// writeFileSync writes bts to the file at path p and flushes it to disk.
+func writeFileSync(p string, bts []byte) error {
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(bts); err != nil {
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ollama/ollama/types/model"
+)
+
+func readManifestFile(t *testing.T, n model.Name) string {
+	t.Helper()
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bts, err := os.ReadFile(filepath.Join(manifests, n.Filepath()))
+	if errors.Is(err, os.ErrNotExist) {
+		return ""
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(bts)
+}
+
+func checkJournalEmpty(t *testing.T) {
+	t.Helper()
+
+	journal, err := journalPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entries, err := os.ReadDir(journal); err != nil || len(entries) > 0 {
+		t.Errorf("expected empty journal, got %v, %v", entries, err)
+	}
+}
+
+func TestWriteManifestFile(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := model.ParseName("test")
+	p := filepath.Join(manifests, n.Filepath())
+
+	if err := writeManifestFile(p, []byte("first"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeManifestFile(p, []byte("second"), func() error {
+		if s := readManifestFile(t, n); s != "second" {
+			t.Errorf("expected second to be written before commit, got %q", s)
+		}
+
+		return errors.New("store failed")
+	}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if s := readManifestFile(t, n); s != "first" {
+		t.Errorf("expected first to be restored, got %q", s)
+	}
+
+	checkJournalEmpty(t)
+
+	// rolling back a new model removes it
+	other := model.ParseName("other")
+	if err := writeManifestFile(filepath.Join(manifests, other.Filepath()), []byte("other"), func() error {
+		return errors.New("store failed")
+	}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if s := readManifestFile(t, other); s != "" {
+		t.Errorf("expected other to be removed, got %q", s)
+	}
+}
+
+func TestRepairManifests(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replaced := model.ParseName("replaced")
+	if err := writeManifestFile(filepath.Join(manifests, replaced.Filepath()), []byte("previous"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	created := model.ParseName("created")
+	unchanged := model.ParseName("unchanged")
+
+	// the server stopped before these writes ended
+	for _, tt := range []struct {
+		n     model.Name
+		write bool
+	}{
+		{replaced, true},
+		{created, true},
+		{unchanged, false},
+	} {
+		txn, err := beginManifestTxn(filepath.Join(manifests, tt.n.Filepath()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tt.write {
+			if err := txn.write([]byte("interrupted")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := repairManifests(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := readManifestFile(t, replaced); s != "previous" {
+		t.Errorf("expected previous manifest, got %q", s)
+	}
+
+	for _, n := range []model.Name{created, unchanged} {
+		if s := readManifestFile(t, n); s != "" {
+			t.Errorf("%s: expected no manifest, got %q", n.DisplayShortest(), s)
+		}
+	}
+
+	checkJournalEmpty(t)
+}
+
+func TestCopyModelRollback(t *testing.T) {
+	setupBlobStore(t)
+
+	layer := writeTestBlob(t, "weights")
+	if err := WriteManifest(t.Context(), model.ParseName("src"), Layer{}, []Layer{layer}); err != nil {
+		t.Fatal(err)
+	}
+
+	// storing the copy fails when the store is unavailable
+	dir := filepath.FromSlash(os.Getenv("OLLAMA_BLOB_STORE")[len("file://"):])
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(dir, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CopyModel(t.Context(), model.ParseName("src"), model.ParseName("dst")); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if s := readManifestFile(t, model.ParseName("dst")); s != "" {
+		t.Errorf("expected copy to be rolled back, got %q", s)
+	}
+
+	checkJournalEmpty(t)
+}
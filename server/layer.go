@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"crypto/sha256"
 	"errors"
 	"fmt"
@@ -17,6 +18,12 @@ type Layer struct {
 }
 
 func NewLayer(r io.Reader, mediatype string) (Layer, error) {
+	unlock, err := lockStore()
+	if err != nil {
+		return Layer{}, err
+	}
+	defer unlock()
+
 	blobs, err := GetBlobsPath("")
 	if err != nil {
 		return Layer{}, err
@@ -69,7 +76,7 @@ func NewLayerFromLayer(digest, mediatype, from string) (Layer, error) {
 		return Layer{}, errors.New("creating new layer from layer with empty digest")
 	}
 
-	blob, err := GetBlobsPath(digest)
+	blob, err := fetchBlob(context.Background(), digest)
 	if err != nil {
 		return Layer{}, err
 	}
@@ -93,7 +100,7 @@ func (l *Layer) Open() (io.ReadSeekCloser, error) {
 		return nil, errors.New("opening layer with empty digest")
 	}
 
-	blob, err := GetBlobsPath(l.Digest)
+	blob, err := fetchBlob(context.Background(), l.Digest)
 	if err != nil {
 		return nil, err
 	}
@@ -106,6 +113,15 @@ func (l *Layer) Remove() error {
 		return nil
 	}
 
+	unlock, err := lockStoreExclusive()
+	if errors.Is(err, errStoreInUse) {
+		modelsLock.deferRemoval(map[string]struct{}{l.Digest: {}})
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer unlock()
+
 	// Ignore corrupt manifests to avoid blocking deletion of layers that are freshly orphaned
 	ms, err := Manifests(true)
 	if err != nil {
@@ -126,5 +142,6 @@ func (l *Layer) Remove() error {
 		return err
 	}
 
+	unstoreBlobs(map[string]struct{}{l.Digest: {}})
 	return os.Remove(blob)
 }
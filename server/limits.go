@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// applyLimits lowers options of opts which are over the server's usage
+// limits to them. It returns an api.LimitError instead if requestOpts, the
+// options of the request, asked for more.
+func applyLimits(opts *api.Options, requestOpts map[string]any) error {
+	if limit := int(envconfig.MaxNumCtx()); limit > 0 && opts.NumCtx > limit {
+		if _, ok := requestOpts["num_ctx"]; ok {
+			return api.LimitError{Limit: "num_ctx", Max: int64(limit), Value: int64(opts.NumCtx)}
+		}
+
+		opts.NumCtx = limit
+	}
+
+	// num_predict of zero or less generates until the context is full
+	if limit := int(envconfig.MaxNumPredict()); limit > 0 && (opts.NumPredict <= 0 || opts.NumPredict > limit) {
+		if _, ok := requestOpts["num_predict"]; ok && opts.NumPredict > limit {
+			return api.LimitError{Limit: "num_predict", Max: int64(limit), Value: int64(opts.NumPredict)}
+		}
+
+		opts.NumPredict = limit
+	}
+
+	return nil
+}
+
+// checkImages returns an api.LimitError if n images are more than a request
+// may have.
+func checkImages(n int) error {
+	if limit := int(envconfig.MaxImages()); limit > 0 && n > limit {
+		return api.LimitError{Limit: "images", Max: int64(limit), Value: int64(n)}
+	}
+
+	return nil
+}
+
+// limitResponse is the body of the response to a request which went over a
+// usage limit, with the limit's details.
+func limitResponse(err error) gin.H {
+	h := errorResponse(api.ErrorCodeLimitExceeded, err.Error())
+
+	var lerr api.LimitError
+	if errors.As(err, &lerr) {
+		h["limit"] = lerr
+	}
+
+	return h
+}
+
+// requestSizeMiddleware refuses requests with bodies larger than
+// OLLAMA_MAX_REQUEST_SIZE.
+func requestSizeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := envconfig.MaxRequestSize()
+		if limit <= 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > limit {
+			c.AbortWithStatusJSON(http.StatusBadRequest, limitResponse(api.LimitError{Limit: "request_size", Max: limit, Value: c.Request.ContentLength}))
+			return
+		}
+
+		if c.Request.ContentLength < 0 {
+			// the size of chunked bodies isn't known until they're read
+			body, err := io.ReadAll(io.LimitReader(c.Request.Body, limit+1))
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			if int64(len(body)) > limit {
+				c.AbortWithStatusJSON(http.StatusBadRequest, limitResponse(api.LimitError{Limit: "request_size", Max: limit}))
+				return
+			}
+
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		c.Next()
+	}
+}
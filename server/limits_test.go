@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestApplyLimits(t *testing.T) {
+	t.Setenv("OLLAMA_MAX_NUM_CTX", "8192")
+	t.Setenv("OLLAMA_MAX_NUM_PREDICT", "1024")
+
+	cases := []struct {
+		name        string
+		opts        api.Options
+		requestOpts map[string]any
+		want        api.Options
+		err         error
+	}{
+		{
+			name: "under",
+			opts: api.Options{Runner: api.Runner{NumCtx: 4096}, NumPredict: 128},
+			want: api.Options{Runner: api.Runner{NumCtx: 4096}, NumPredict: 128},
+		},
+		{
+			name: "defaults",
+			opts: api.Options{Runner: api.Runner{NumCtx: 131072}, NumPredict: -1},
+			want: api.Options{Runner: api.Runner{NumCtx: 8192}, NumPredict: 1024},
+		},
+		{
+			name:        "unlimited num_predict",
+			opts:        api.Options{Runner: api.Runner{NumCtx: 4096}, NumPredict: -1},
+			requestOpts: map[string]any{"num_predict": -1},
+			want:        api.Options{Runner: api.Runner{NumCtx: 4096}, NumPredict: 1024},
+		},
+		{
+			name:        "num_ctx",
+			opts:        api.Options{Runner: api.Runner{NumCtx: 131072}},
+			requestOpts: map[string]any{"num_ctx": 131072},
+			err:         api.LimitError{Limit: "num_ctx", Max: 8192, Value: 131072},
+		},
+		{
+			name:        "num_predict",
+			opts:        api.Options{Runner: api.Runner{NumCtx: 4096}, NumPredict: 2048},
+			requestOpts: map[string]any{"num_predict": 2048},
+			err:         api.LimitError{Limit: "num_predict", Max: 1024, Value: 2048},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			err := applyLimits(&opts, tt.requestOpts)
+			if tt.err != nil {
+				if err != tt.err {
+					t.Fatalf("expected error %v, got %v", tt.err, err)
+				}
+
+				if !errors.Is(err, api.ErrLimitExceeded) || errorCode(err) != api.ErrorCodeLimitExceeded {
+					t.Errorf("expected %v to have the code LIMIT_EXCEEDED", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(opts, tt.want); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckImages(t *testing.T) {
+	if err := checkImages(10); err != nil {
+		t.Errorf("expected no limit by default, got %v", err)
+	}
+
+	t.Setenv("OLLAMA_MAX_IMAGES", "2")
+	if err := checkImages(2); err != nil {
+		t.Errorf("expected 2 images to be allowed, got %v", err)
+	}
+
+	err := checkImages(3)
+	if err == nil {
+		t.Fatal("expected an error for 3 images")
+	}
+
+	body, err := json.Marshal(limitResponse(err))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := `{"code":"LIMIT_EXCEEDED","error":"images of 3 is over the server's limit of 2","limit":{"limit":"images","max":2,"value":3}}`; string(body) != want {
+		t.Errorf("expected %s, got %s", want, body)
+	}
+}
+
+func TestRequestSizeMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MAX_REQUEST_SIZE", "16")
+
+	r := gin.New()
+	r.POST("/api/generate", requestSizeMiddleware(), func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.String(http.StatusOK, string(body))
+	})
+
+	cases := []struct {
+		name    string
+		body    string
+		chunked bool
+		code    int
+		want    string
+	}{
+		{"small", `{"model":"a"}`, false, http.StatusOK, `{"model":"a"}`},
+		{"large", `{"model":"a","prompt":"hi"}`, false, http.StatusBadRequest, `{"code":"LIMIT_EXCEEDED","error":"request_size of 27 is over the server's limit of 16","limit":{"limit":"request_size","max":16,"value":27}}`},
+		{"small chunked", `{"model":"a"}`, true, http.StatusOK, `{"model":"a"}`},
+		{"large chunked", `{"model":"a","prompt":"hi"}`, true, http.StatusBadRequest, `{"code":"LIMIT_EXCEEDED","error":"request_size is over the server's limit of 16","limit":{"limit":"request_size","max":16}}`},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(tt.body))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.code {
+				t.Errorf("expected status code %d, actual %d", tt.code, w.Code)
+			}
+
+			if diff := cmp.Diff(w.Body.String(), tt.want); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
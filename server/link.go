@@ -0,0 +1,139 @@
+package server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+var (
+	errCantLink     = errors.New("couldn't link the file into the models directory")
+	errLinkMismatch = errors.New("the file doesn't match its digest")
+)
+
+// localClient reports whether r was sent from the server's machine, which
+// is the only place files it asks to link can be.
+func localClient(r *http.Request) bool {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+
+	addr := addrPort.Addr().Unmap()
+	return addr.IsLoopback() || isLocalIP(addr)
+}
+
+// LinkBlobHandler imports a file on the server's machine as a blob by
+// linking it into the models directory, which saves copying model files
+// kept for other tools.
+func (s *Server) LinkBlobHandler(c *gin.Context) {
+	var req api.LinkBlobRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !localClient(c.Request) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "files can only be linked by clients on the server's machine"})
+		return
+	}
+
+	if !filepath.IsAbs(req.Path) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "path must be absolute"})
+		return
+	}
+
+	method, err := linkBlob(req.Path, c.Param("digest"))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidDigestFormat), errors.Is(err, errLinkMismatch):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, errCantLink):
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, api.LinkBlobResponse{Method: method})
+	}
+}
+
+// linkBlob imports the file at path as the blob with digest. It's cloned
+// where the filesystem supports copy-on-write, so changes to either don't
+// affect the other, and hard linked otherwise. It returns how the file was
+// imported.
+func linkBlob(path, digest string) (string, error) {
+	blob, err := GetBlobsPath(digest)
+	if err != nil {
+		return "", err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("%w: %s isn't a regular file", errCantLink, path)
+	}
+
+	unlock, err := lockStore()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(blob); err == nil {
+		return "existing", nil
+	}
+
+	// links need a name which doesn't exist yet
+	temp, err := os.CreateTemp(filepath.Dir(blob), "sha256-")
+	if err != nil {
+		return "", err
+	}
+	temp.Close()
+	os.Remove(temp.Name())
+	defer os.Remove(temp.Name())
+
+	method := "reflink"
+	if err := cloneFile(path, temp.Name()); err != nil {
+		method = "hardlink"
+		if err := os.Link(path, temp.Name()); err != nil {
+			return "", fmt.Errorf("%w: %v", errCantLink, err)
+		}
+	}
+
+	f, err := os.Open(temp.Name())
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sha256sum := sha256.New()
+	if _, err := io.Copy(sha256sum, f); err != nil {
+		return "", err
+	}
+
+	if got := fmt.Sprintf("sha256:%x", sha256sum.Sum(nil)); got != digest {
+		return "", fmt.Errorf("%w: expected %q, got %q", errLinkMismatch, digest, got)
+	}
+
+	if err := os.Rename(temp.Name(), blob); err != nil {
+		return "", err
+	}
+
+	return method, nil
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestLinkBlobHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	data := []byte("weights kept for another tool")
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+
+	var s Server
+	r := gin.New()
+	r.POST("/api/blobs/:digest", s.CreateBlobHandler)
+	r.POST("/api/blobs/:digest/link", s.LinkBlobHandler)
+
+	link := func(remoteAddr, digest, path string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		b, err := json.Marshal(api.LinkBlobRequest{Path: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/api/blobs/"+digest+"/link", bytes.NewReader(b))
+		req.RemoteAddr = remoteAddr
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, tt := range []struct {
+		name       string
+		remoteAddr string
+		digest     string
+		path       string
+		code       int
+	}{
+		{"remote", "192.0.2.1:1234", digest, path, http.StatusForbidden},
+		{"relative", "127.0.0.1:1234", digest, "model.gguf", http.StatusBadRequest},
+		{"missing", "127.0.0.1:1234", digest, filepath.Join(filepath.Dir(path), "missing.gguf"), http.StatusNotFound},
+		{"mismatch", "127.0.0.1:1234", fmt.Sprintf("sha256:%x", sha256.Sum256(nil)), path, http.StatusBadRequest},
+		{"invalid", "127.0.0.1:1234", "sha256:abc", path, http.StatusBadRequest},
+	} {
+		if w := link(tt.remoteAddr, tt.digest, tt.path); w.Code != tt.code {
+			t.Errorf("%s: expected status code %d, actual %d: %s", tt.name, tt.code, w.Code, w.Body)
+		}
+	}
+
+	w := link("127.0.0.1:1234", digest, path)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+	}
+
+	var resp api.LinkBlobResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch resp.Method {
+	case "hardlink":
+		src, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dst, err := os.Stat(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !os.SameFile(src, dst) {
+			t.Error("expected the blob to be a hard link to the file")
+		}
+	case "reflink":
+	default:
+		t.Fatalf("unexpected method %q", resp.Method)
+	}
+
+	if b, err := os.ReadFile(blob); err != nil || !bytes.Equal(b, data) {
+		t.Errorf("expected the blob to hold the file, got %q, %v", b, err)
+	}
+
+	if matches, err := filepath.Glob(blob + "?*"); err != nil || len(matches) > 0 {
+		t.Errorf("expected no temporary files, got %v, %v", matches, err)
+	}
+
+	w = link("127.0.0.1:1234", digest, path)
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Method != "existing" {
+		t.Errorf("expected the existing blob to be used, got %q", resp.Method)
+	}
+}
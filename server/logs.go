@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+// levelHandler writes logs of each level to a different writer, for log
+// facilities which record levels apart from the text of logs.
+type levelHandler struct {
+	debug, info, warning, err slog.Handler
+}
+
+// newLevelHandler returns a handler which writes the logs of each level as
+// text to the writer w returns for it.
+func newLevelHandler(opts *slog.HandlerOptions, w func(slog.Level) io.Writer) *levelHandler {
+	return &levelHandler{
+		debug:   slog.NewTextHandler(w(slog.LevelDebug), opts),
+		info:    slog.NewTextHandler(w(slog.LevelInfo), opts),
+		warning: slog.NewTextHandler(w(slog.LevelWarn), opts),
+		err:     slog.NewTextHandler(w(slog.LevelError), opts),
+	}
+}
+
+func (h *levelHandler) handler(level slog.Level) slog.Handler {
+	switch {
+	case level >= slog.LevelError:
+		return h.err
+	case level >= slog.LevelWarn:
+		return h.warning
+	case level >= slog.LevelInfo:
+		return h.info
+	default:
+		return h.debug
+	}
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler(level).Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler(r.Level).Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{
+		debug:   h.debug.WithAttrs(attrs),
+		info:    h.info.WithAttrs(attrs),
+		warning: h.warning.WithAttrs(attrs),
+		err:     h.err.WithAttrs(attrs),
+	}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{
+		debug:   h.debug.WithGroup(name),
+		info:    h.info.WithGroup(name),
+		warning: h.warning.WithGroup(name),
+		err:     h.err.WithGroup(name),
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package server
+
+import (
+	"log/slog"
+	"os"
+)
+
+// logHandler returns the handler for the server's logs, which go to the
+// systemd journal when the server is run by systemd, and otherwise to
+// standard error.
+func logHandler(opts *slog.HandlerOptions) slog.Handler {
+	if os.Getenv("JOURNAL_STREAM") != "" {
+		return newJournalHandler(os.Stderr, opts)
+	}
+
+	return slog.NewTextHandler(os.Stderr, opts)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// EventSource is the name the server writes to the Windows Event Log as
+// when it's run as a service.
+const EventSource = "Ollama"
+
+// logHandler returns the handler for the server's logs, which go to the
+// Windows Event Log when the server is run as a service, and otherwise to
+// standard error.
+func logHandler(opts *slog.HandlerOptions) slog.Handler {
+	if isService, err := svc.IsWindowsService(); err == nil && isService {
+		log, err := eventlog.Open(EventSource)
+		if err == nil {
+			return newLevelHandler(opts, func(level slog.Level) io.Writer {
+				return &eventLogWriter{log: log, level: level}
+			})
+		}
+	}
+
+	return slog.NewTextHandler(os.Stderr, opts)
+}
+
+// eventLogWriter writes each log line, which slog writes at once, as an
+// event of its level.
+type eventLogWriter struct {
+	log   *eventlog.Log
+	level slog.Level
+}
+
+func (w *eventLogWriter) Write(b []byte) (int, error) {
+	msg := strings.TrimSuffix(string(b), "\n")
+
+	var err error
+	switch {
+	case w.level >= slog.LevelError:
+		err = w.log.Error(1, msg)
+	case w.level >= slog.LevelWarn:
+		err = w.log.Warning(1, msg)
+	default:
+		err = w.log.Info(1, msg)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -17,9 +19,14 @@ import (
 type Manifest struct {
 	SchemaVersion int     `json:"schemaVersion"`
 	MediaType     string  `json:"mediaType"`
+	ArtifactType  string  `json:"artifactType,omitempty"`
 	Config        Layer   `json:"config"`
 	Layers        []Layer `json:"layers"`
 
+	// Subject is the manifest an OCI artifact refers to, such as the model
+	// an adapter applies to.
+	Subject *Layer `json:"subject,omitempty"`
+
 	filepath string
 	fi       os.FileInfo
 	digest   string
@@ -34,10 +41,24 @@ func (m *Manifest) Size() (size int64) {
 }
 
 func (m *Manifest) Remove() error {
+	if err := unstoreManifest(m); err != nil {
+		return err
+	}
+
 	if err := os.Remove(m.filepath); err != nil {
 		return err
 	}
 
+	// empty directories are left for a later prune while models are being
+	// written, since writes may be creating them
+	unlock, err := lockStoreExclusive()
+	if errors.Is(err, errStoreInUse) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer unlock()
+
 	manifests, err := GetManifestPath()
 	if err != nil {
 		return err
@@ -70,7 +91,11 @@ func ParseNamedManifest(n model.Name) (*Manifest, error) {
 		return nil, err
 	}
 
+	// manifests are small enough to fetch without the context of a request
 	p := filepath.Join(manifests, n.Filepath())
+	if err := fetchManifest(context.Background(), p); err != nil {
+		return nil, err
+	}
 
 	var m Manifest
 	f, err := os.Open(p)
@@ -96,23 +121,12 @@ func ParseNamedManifest(n model.Name) (*Manifest, error) {
 	return &m, nil
 }
 
-func WriteManifest(name model.Name, config Layer, layers []Layer) error {
+func WriteManifest(ctx context.Context, name model.Name, config Layer, layers []Layer) error {
 	manifests, err := GetManifestPath()
 	if err != nil {
 		return err
 	}
 
-	p := filepath.Join(manifests, name.Filepath())
-	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
-		return err
-	}
-
-	f, err := os.Create(p)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
 	m := Manifest{
 		SchemaVersion: 2,
 		MediaType:     "application/vnd.docker.distribution.manifest.v2+json",
@@ -120,7 +134,14 @@ func WriteManifest(name model.Name, config Layer, layers []Layer) error {
 		Layers:        layers,
 	}
 
-	return json.NewEncoder(f).Encode(m)
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(m); err != nil {
+		return err
+	}
+
+	return writeManifestFile(filepath.Join(manifests, name.Filepath()), b.Bytes(), func() error {
+		return storeManifest(ctx, name)
+	})
 }
 
 func Manifests(continueOnError bool) (map[model.Name]*Manifest, error) {
@@ -0,0 +1,359 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	ggmlbackend "github.com/ollama/ollama/ml/backend/ggml"
+)
+
+var (
+	errNoModelToMerge = errors.New("adapters can only be merged into a GGUF model")
+	errNotAnAdapter   = errors.New("only LoRA adapters can be merged into a model")
+)
+
+// loraTensor is the lora_a or lora_b of an adapter which is merged into a
+// tensor of the base model.
+type loraTensor struct {
+	r      io.ReaderAt
+	offset int64
+	t      *ggml.Tensor
+}
+
+func (l loraTensor) values() ([]float32, error) {
+	data := make([]byte, l.t.Size())
+	if _, err := l.r.ReadAt(data, l.offset); err != nil {
+		return nil, err
+	}
+
+	n := uint64(1)
+	for _, s := range l.t.Shape {
+		n *= s
+	}
+
+	return ggmlbackend.ConvertToF32(data, l.t.Kind, n)
+}
+
+// loraPair is the lora_a and lora_b of a tensor, which add scale * b * a to
+// its weights.
+type loraPair struct {
+	a, b  loraTensor
+	scale float32
+}
+
+// mergeData adds the LoRA pairs to data, the values of the tensor t, and
+// converts the result to the tensor type kind. Chunks of rows are merged in
+// parallel.
+func mergeData(ctx context.Context, data []byte, t *ggml.Tensor, kind uint32, pairs []loraPair) ([]byte, error) {
+	nIn, nOut := t.Shape[0], t.Shape[1]
+
+	// lora_a is rank x input and lora_b is output x rank, in the order of
+	// their values
+	as := make([][]float32, len(pairs))
+	bs := make([][]float32, len(pairs))
+	for i, pair := range pairs {
+		var err error
+		if as[i], err = pair.a.values(); err != nil {
+			return nil, fmt.Errorf("merge %s: %w", t.Name, err)
+		}
+
+		if bs[i], err = pair.b.values(); err != nil {
+			return nil, fmt.Errorf("merge %s: %w", t.Name, err)
+		}
+	}
+
+	srcRowSize := uint64(len(data)) / nOut
+	dstRowSize := ggmlbackend.RowSize(kind, nIn)
+	merged := make([]byte, dstRowSize*nOut)
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(runtime.GOMAXPROCS(0))
+
+	rows := max(1, quantizeChunkSize/nIn)
+	for start := uint64(0); start < nOut; start += rows {
+		end := min(start+rows, nOut)
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			f32s, err := ggmlbackend.ConvertToF32(data[start*srcRowSize:end*srcRowSize], t.Kind, (end-start)*nIn)
+			if err != nil {
+				return err
+			}
+
+			for i, pair := range pairs {
+				a, b := as[i], bs[i]
+				rank := pair.a.t.Shape[1]
+				for o := start; o < end; o++ {
+					row := f32s[(o-start)*nIn : (o-start+1)*nIn]
+					for k := range rank {
+						s := pair.scale * b[o*rank+k]
+						if s == 0 {
+							continue
+						}
+
+						for i, v := range a[k*nIn : (k+1)*nIn] {
+							row[i] += s * v
+						}
+					}
+				}
+			}
+
+			bts, err := ggmlbackend.Quantize(kind, f32s, nIn, nil)
+			if err != nil {
+				return err
+			}
+
+			copy(merged[start*dstRowSize:], bts)
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, fmt.Errorf("merge %s: %w", t.Name, err)
+	}
+
+	return merged, nil
+}
+
+// mergeTensor is a tensor which is read from the base model and has the
+// LoRA pairs of the adapters added to it as it's written.
+type mergeTensor struct {
+	ctx      context.Context
+	r        io.ReaderAt
+	offset   int64
+	src      *ggml.Tensor
+	kind     uint32
+	pairs    []loraPair
+	progress func(int64)
+}
+
+func (m *mergeTensor) WriteTo(w io.Writer) (int64, error) {
+	data := make([]byte, m.src.Size())
+	if _, err := m.r.ReadAt(data, m.offset); err != nil {
+		return 0, err
+	}
+
+	if len(m.pairs) > 0 {
+		var err error
+		if data, err = mergeData(m.ctx, data, m.src, m.kind, m.pairs); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.Write(data)
+	if err != nil {
+		return int64(n), err
+	}
+
+	m.progress(int64(m.src.Size()))
+	return int64(n), nil
+}
+
+// adapterMerge is the GGUF layer of a LoRA adapter which is merged into the
+// weights of a model, scaled by weight.
+type adapterMerge struct {
+	layer  *layerGGML
+	weight float32
+}
+
+// mergeAdapters merges the LoRA adapters into the weights of the GGUF model
+// in baseLayers, returning its layers with the model replaced by the merged
+// model. Merged weights keep their type unless it can't be quantized to
+// without an importance matrix.
+func mergeAdapters(ctx context.Context, baseLayers []*layerGGML, adapters []adapterMerge, fn func(api.ProgressResponse)) ([]*layerGGML, error) {
+	i := slices.IndexFunc(baseLayers, func(l *layerGGML) bool {
+		return l.GGML != nil && l.MediaType == "application/vnd.ollama.image.model"
+	})
+	if i < 0 || baseLayers[i].Name() != "gguf" {
+		return nil, errNoModelToMerge
+	}
+
+	blob, err := fetchBlob(ctx, baseLayers[i].Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(blob)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	g, _, err := ggml.Decode(f, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	kv := maps.Clone(g.KV())
+	base := make(map[string]*ggml.Tensor)
+	for _, t := range g.Tensors().Items() {
+		base[t.Name] = t
+	}
+
+	pairs := make(map[string][]loraPair)
+	for _, adapter := range adapters {
+		p, err := fetchBlob(ctx, adapter.layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+
+		af, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer af.Close()
+
+		ag, _, err := ggml.Decode(af, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		akv := ag.KV()
+		if akv.Kind() != "adapter" {
+			return nil, errNotAnAdapter
+		} else if akv.Architecture() != kv.Architecture() {
+			return nil, fmt.Errorf("adapter is for %s models but the base model is %s", akv.Architecture(), kv.Architecture())
+		}
+
+		lora := make(map[string]*ggml.Tensor)
+		for _, t := range ag.Tensors().Items() {
+			lora[t.Name] = t
+		}
+
+		for _, name := range slices.Sorted(maps.Keys(lora)) {
+			weight, ok := strings.CutSuffix(name, ".lora_a")
+			if !ok {
+				if !strings.HasSuffix(name, ".lora_b") {
+					return nil, fmt.Errorf("adapter tensor %s isn't a LoRA tensor", name)
+				}
+
+				continue
+			}
+
+			a, b, t := lora[name], lora[weight+".lora_b"], base[weight]
+			switch {
+			case b == nil:
+				return nil, fmt.Errorf("adapter is missing the lora_b of %s", weight)
+			case t == nil:
+				return nil, fmt.Errorf("adapter doesn't match the base model, which has no tensor %s", weight)
+			case len(t.Shape) != 2 || len(a.Shape) != 2 || len(b.Shape) != 2,
+				a.Shape[0] != t.Shape[0], b.Shape[1] != t.Shape[1], a.Shape[1] != b.Shape[0]:
+				return nil, fmt.Errorf("adapter doesn't match the base model, %s is %v but its lora_a and lora_b are %v and %v", weight, t.Shape, a.Shape, b.Shape)
+			}
+
+			if _, err := ggmlbackend.ConvertToF32(nil, t.Kind, 0); err != nil {
+				return nil, fmt.Errorf("adapters can't be merged into %s of type %s", weight, t.Type())
+			}
+
+			// like llama.cpp, adapters without an alpha aren't scaled by it
+			scale := cmp.Or(adapter.weight, 1)
+			if alpha, ok := akv["adapter.lora.alpha"].(float32); ok && alpha != 0 {
+				scale *= alpha / float32(a.Shape[1])
+			}
+
+			pairs[weight] = append(pairs[weight], loraPair{
+				a:     loraTensor{af, int64(ag.Tensors().Offset + a.Offset), a},
+				b:     loraTensor{af, int64(ag.Tensors().Offset + b.Offset), b},
+				scale: scale,
+			})
+		}
+	}
+
+	status := "merging adapters"
+	fn(api.ProgressResponse{Status: status})
+
+	var total, completed int64
+	for _, t := range g.Tensors().Items() {
+		total += int64(t.Size())
+	}
+
+	progress := func(n int64) {
+		completed += n
+		fn(api.ProgressResponse{Status: status, Digest: baseLayers[i].Digest, Total: total, Completed: completed})
+	}
+
+	var ts []ggml.Tensor
+	for _, t := range g.Tensors().Items() {
+		// shapes are written in the reverse of the order they're read
+		shape := slices.Clone(t.Shape)
+		slices.Reverse(shape)
+
+		kind := t.Kind
+		if pairs[t.Name] != nil {
+			switch {
+			case !ggmlbackend.CanQuantize(kind):
+				kind = tensorTypeF16
+			case ggmlbackend.RequiresImportance(kind):
+				// the closest type without an importance matrix, which
+				// has blocks of the same size
+				kind = tensorTypeQ2_K
+			}
+		}
+
+		ts = append(ts, ggml.Tensor{
+			Name:  t.Name,
+			Kind:  kind,
+			Shape: shape,
+			WriterTo: &mergeTensor{
+				ctx:      ctx,
+				r:        f,
+				offset:   int64(g.Tensors().Offset + t.Offset),
+				src:      t,
+				kind:     kind,
+				pairs:    pairs[t.Name],
+				progress: progress,
+			},
+		})
+	}
+
+	// tensors are always written with the default alignment
+	delete(kv, "general.alignment")
+
+	temp, err := os.CreateTemp(filepath.Dir(blob), "merge-")
+	if err != nil {
+		return nil, err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	if err := ggml.WriteGGUF(temp, kv, ts); err != nil {
+		return nil, err
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	layer, err := NewLayer(temp, baseLayers[i].MediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	merged, _, err := ggml.Decode(temp, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	layers := slices.Clone(baseLayers)
+	layers[i] = &layerGGML{layer, merged}
+	return layers, nil
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestCreateMergeAdapters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+
+	attnQ, attnQValues := f32Tensor(t, "blk.0.attn_q.weight", 3, 32)
+	attnNorm, attnNormValues := f32Tensor(t, "blk.0.attn_norm.weight", 32)
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture": "llama",
+		"general.file_type":    uint32(0),
+		"llama.block_count":    uint32(1),
+	}, []ggml.Tensor{attnQ, attnNorm})
+
+	// adapter returns the digest of an adapter of attn_q whose lora_a is
+	// 2 x 32 and lora_b is outputs x 2
+	adapter := func(alpha float32, a, b []float32, outputs uint64) string {
+		t.Helper()
+
+		var loraA, loraB bytes.Buffer
+		if err := binary.Write(&loraA, binary.LittleEndian, a); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := binary.Write(&loraB, binary.LittleEndian, b); err != nil {
+			t.Fatal(err)
+		}
+
+		_, digest := createBinFile(t, ggml.KV{
+			"general.architecture": "llama",
+			"general.type":         "adapter",
+			"adapter.type":         "lora",
+			"adapter.lora.alpha":   alpha,
+		}, []ggml.Tensor{
+			{Name: "blk.0.attn_q.weight.lora_a", Kind: tensorTypeF32, Shape: []uint64{2, 32}, WriterTo: &loraA},
+			{Name: "blk.0.attn_q.weight.lora_b", Kind: tensorTypeF32, Shape: []uint64{outputs, 2}, WriterTo: &loraB},
+		})
+		return digest
+	}
+
+	ones := slices.Repeat([]float32{1}, 64)
+
+	// the first adds 1 to the first row, as alpha / rank is 1, and the
+	// second, without an alpha, adds 2 * 0.5 to the last
+	first := adapter(2, ones, []float32{0.5, 0.5, 0, 0, 0, 0}, 3)
+	second := adapter(0, ones, []float32{0, 0, 0, 0, 1, 1}, 3)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:  "test-merged",
+		Files: map[string]string{"test.gguf": digest},
+		MergeAdapters: []api.MergeAdapter{
+			{Files: map[string]string{"first.gguf": first}},
+			{Files: map[string]string{"second.gguf": second}, Weight: 0.5},
+		},
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	m, err := ParseNamedManifest(model.ParseName("test-merged"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, layer := range m.Layers {
+		if layer.MediaType == "application/vnd.ollama.image.adapter" {
+			t.Errorf("expected adapters to be merged, got adapter layer %s", layer.Digest)
+		}
+	}
+
+	_, values, kinds := quantizedTensors(t, model.ParseName("test-merged"))
+	if diff := cmp.Diff(map[string]uint32{
+		"blk.0.attn_q.weight":    tensorTypeF32,
+		"blk.0.attn_norm.weight": tensorTypeF32,
+	}, kinds); diff != "" {
+		t.Errorf("tensor types mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(attnNormValues, values["blk.0.attn_norm.weight"]); diff != "" {
+		t.Errorf("norm changed (-want +got):\n%s", diff)
+	}
+
+	for i, v := range values["blk.0.attn_q.weight"] {
+		want := attnQValues[i]
+		if row := i / 32; row == 0 || row == 2 {
+			want += 1
+		}
+
+		if math.Abs(float64(v-want)) > 1e-5 {
+			t.Fatalf("value %d: expected %f, got %f", i, want, v)
+		}
+	}
+
+	t.Run("wrong base model", func(t *testing.T) {
+		bad := adapter(2, ones, make([]float32, 8), 4)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:          "test-bad",
+			Files:         map[string]string{"test.gguf": digest},
+			MergeAdapters: []api.MergeAdapter{{Files: map[string]string{"bad.gguf": bad}}},
+			Stream:        &stream,
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if !strings.Contains(w.Body.String(), "adapter doesn't match the base model") {
+			t.Errorf("expected mismatch error, got %s", w.Body.String())
+		}
+	})
+}
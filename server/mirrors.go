@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// registrySource is a registry to pull a model from: its own, or one of the
+// mirrors in OLLAMA_REGISTRIES.
+type registrySource struct {
+	mp      ModelPath
+	regOpts *registryOptions
+}
+
+// mirrorsFor returns the mirrors to pull mp from before its registry, and
+// whether their manifests may be used when the registry can't be reached to
+// check them against. Settings for the namespace of mp replace those for its
+// registry.
+func mirrorsFor(mp ModelPath, regOpts *registryOptions) ([]registrySource, bool, error) {
+	registries, err := readRegistries(envconfig.Registries())
+	if err != nil {
+		return nil, false, err
+	}
+
+	config, ok := registries[strings.ToLower(mp.Registry+"/"+mp.Namespace)]
+	if !ok {
+		config = registries[strings.ToLower(mp.Registry)]
+	}
+
+	var mirrors []registrySource
+	for _, mirror := range config.Mirrors {
+		u, err := url.Parse(mirror)
+		if err != nil {
+			return nil, false, err
+		}
+
+		m := mp
+		m.ProtocolScheme, m.Registry = u.Scheme, u.Host
+
+		// credentials for the registry aren't sent to its mirrors
+		mirrors = append(mirrors, registrySource{mp: m, regOpts: &registryOptions{
+			Insecure:        u.Scheme == "http",
+			MaxDownloadRate: regOpts.MaxDownloadRate,
+			CheckRedirect:   regOpts.CheckRedirect,
+		}})
+	}
+
+	return mirrors, config.AllowUnverified, nil
+}
+
+// manifestDigest returns the digest of the manifest of mp in its registry.
+func manifestDigest(ctx context.Context, mp ModelPath, regOpts *registryOptions) (string, error) {
+	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", mp.Tag)
+
+	headers := make(http.Header)
+	headers.Set("Accept", strings.Join(pullMediaTypes, ", "))
+	resp, err := makeRequestWithRetry(ctx, http.MethodHead, requestURL, headers, nil, regOpts)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if digest := resp.Header.Get("Docker-Content-Digest"); isDigest(digest) {
+		return digest, nil
+	}
+
+	// registries needn't send the digest, so hash the manifest instead
+	bts, err := getRegistryManifest(ctx, mp, mp.Tag, pullMediaTypes, regOpts)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(bts)), nil
+}
+
+// pullManifestMirrored pulls the manifest of mp from the first of its mirrors
+// which has it, or from its registry if none do. Mirrors are asked for the
+// manifest by the digest of the one in the registry, so that a mirror with
+// another manifest for the tag is passed over. It returns the sources to pull
+// the model's blobs from, in order.
+func pullManifestMirrored(ctx context.Context, mp ModelPath, regOpts *registryOptions) (*Manifest, []registrySource, error) {
+	registry := registrySource{mp: mp, regOpts: regOpts}
+
+	mirrors, unverified, err := mirrorsFor(mp, regOpts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(mirrors) == 0 {
+		m, err := pullManifest(ctx, mp, mp.Tag, regOpts)
+		return m, []registrySource{registry}, err
+	}
+
+	ref, err := manifestDigest(ctx, mp, regOpts)
+	if err != nil {
+		if !unverified || ctx.Err() != nil {
+			return nil, nil, fmt.Errorf("check manifest with %s: %w", mp.Registry, err)
+		}
+
+		slog.Warn("pulling unverified manifest from mirrors", "registry", mp.Registry, "error", err)
+		ref = mp.Tag
+	}
+
+	sources := append(mirrors, registry)
+	for i, mirror := range mirrors {
+		m, err := pullManifest(ctx, mirror.mp, ref, mirror.regOpts)
+		if err == nil {
+			return m, sources[i:], nil
+		} else if ctx.Err() != nil {
+			return nil, nil, err
+		}
+
+		slog.Warn("pulling manifest from mirror", "mirror", mirror.mp.Registry, "error", err)
+	}
+
+	m, err := pullManifest(ctx, mp, ref, regOpts)
+	return m, []registrySource{registry}, err
+}
+
+// downloadBlobMirrored downloads a blob from the first of sources which has
+// it. Blobs are verified against their digest whichever source they're
+// downloaded from.
+func downloadBlobMirrored(ctx context.Context, sources []registrySource, opts downloadOpts) (cacheHit bool, err error) {
+	for i, source := range sources {
+		opts.mp, opts.regOpts = source.mp, source.regOpts
+		cacheHit, err = downloadBlob(ctx, opts)
+		if err == nil || ctx.Err() != nil || i == len(sources)-1 {
+			break
+		}
+
+		slog.Warn("pulling blob from mirror", "mirror", source.mp.Registry, "digest", opts.digest, "error", err)
+	}
+
+	return cacheHit, err
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// mirroredRegistry serves the manifest and blob of library/test:latest, like
+// a registry or one of its mirrors.
+type mirroredRegistry struct {
+	manifest, blob []byte
+
+	// down makes every request fail
+	down bool
+	// tampered serves another manifest and blob than those asked for
+	tampered bool
+	// noDigest leaves out the digest of manifests from responses
+	noDigest bool
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (r *mirroredRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+	r.mu.Unlock()
+
+	if r.down {
+		http.Error(w, "unavailable", http.StatusBadGateway)
+		return
+	}
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(r.manifest))
+	manifest, blob := r.manifest, r.blob
+	if r.tampered {
+		manifest, blob = []byte(`{"schemaVersion":2,"layers":[]}`), []byte("tampered")
+	}
+
+	switch ref, ok := strings.CutPrefix(req.URL.Path, "/v2/library/test/manifests/"); {
+	case ok && (ref == "latest" || ref == digest):
+		if !r.noDigest {
+			w.Header().Set("Docker-Content-Digest", digest)
+		}
+		w.Write(manifest)
+	case strings.HasPrefix(req.URL.Path, "/v2/library/test/blobs/"):
+		// redirect to another host name, like a registry redirects to
+		// its CDN
+		http.Redirect(w, req, strings.Replace("http://"+req.Host, "127.0.0.1", "localhost", 1)+"/direct", http.StatusTemporaryRedirect)
+	case req.URL.Path == "/direct":
+		http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(blob))
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *mirroredRegistry) served(request string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, req := range r.requests {
+		if strings.HasPrefix(req, request) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestPullManifestMirrored(t *testing.T) {
+	blob := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	blobDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+
+	manifest, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeDockerManifest,
+		Layers:        []Layer{{MediaType: "application/vnd.ollama.image.model", Digest: blobDigest, Size: int64(len(blob))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		// registry and mirrors are the registries by their names in config
+		registry *mirroredRegistry
+		mirrors  map[string]*mirroredRegistry
+		config   string
+		// source is the registry the manifest should be pulled from, and
+		// sources the number of sources left to pull blobs from
+		source  string
+		sources int
+		err     bool
+	}{
+		{
+			name:    "mirror",
+			mirrors: map[string]*mirroredRegistry{"good": {}},
+			config:  `{"{registry}": {"mirrors": ["{good}"]}}`,
+			source:  "good",
+			sources: 2,
+		},
+		{
+			name:     "registry without digest",
+			registry: &mirroredRegistry{noDigest: true},
+			mirrors:  map[string]*mirroredRegistry{"good": {}},
+			config:   `{"{registry}": {"mirrors": ["{good}"]}}`,
+			source:   "good",
+			sources:  2,
+		},
+		{
+			name:    "failover",
+			mirrors: map[string]*mirroredRegistry{"down": {down: true}, "tampered": {tampered: true}, "good": {}},
+			config:  `{"{registry}": {"mirrors": ["{down}", "{tampered}", "{good}"]}}`,
+			source:  "good",
+			sources: 2,
+		},
+		{
+			name:    "mirrors fail",
+			mirrors: map[string]*mirroredRegistry{"down": {down: true}, "tampered": {tampered: true}},
+			config:  `{"{registry}": {"mirrors": ["{down}", "{tampered}"]}}`,
+			source:  "registry",
+			sources: 1,
+		},
+		{
+			name:    "namespace",
+			mirrors: map[string]*mirroredRegistry{"down": {down: true}, "good": {}},
+			config:  `{"{registry}": {"mirrors": ["{down}"]}, "{registry}/library": {"mirrors": ["{good}", "{down}"]}}`,
+			source:  "good",
+			sources: 3,
+		},
+		{
+			name:     "registry down",
+			registry: &mirroredRegistry{down: true},
+			mirrors:  map[string]*mirroredRegistry{"good": {}},
+			config:   `{"{registry}": {"mirrors": ["{good}"]}}`,
+			err:      true,
+		},
+		{
+			name:     "registry down unverified",
+			registry: &mirroredRegistry{down: true},
+			mirrors:  map[string]*mirroredRegistry{"good": {}},
+			config:   `{"{registry}": {"mirrors": ["{good}"], "allow_unverified": true}}`,
+			source:   "good",
+			sources:  2,
+		},
+		{
+			name:    "no mirrors",
+			config:  `{}`,
+			source:  "registry",
+			sources: 1,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+			registries := map[string]*mirroredRegistry{"registry": tt.registry}
+			if registries["registry"] == nil {
+				registries["registry"] = &mirroredRegistry{}
+			}
+			for name, r := range tt.mirrors {
+				registries[name] = r
+			}
+
+			config := tt.config
+			hosts := make(map[string]string)
+			for name, r := range registries {
+				r.manifest, r.blob = manifest, blob
+
+				srv := httptest.NewServer(r)
+				defer srv.Close()
+
+				hosts[strings.TrimPrefix(srv.URL, "http://")] = name
+				if name == "registry" {
+					config = strings.ReplaceAll(config, "{registry}", strings.TrimPrefix(srv.URL, "http://"))
+				} else {
+					config = strings.ReplaceAll(config, "{"+name+"}", srv.URL)
+				}
+			}
+
+			t.Setenv("OLLAMA_REGISTRIES", writeRegistries(t, t.TempDir(), config))
+
+			var mp ModelPath
+			for host, name := range hosts {
+				if name == "registry" {
+					mp = ParseModelPath("http://" + host + "/library/test:latest")
+				}
+			}
+
+			m, sources, err := pullManifestMirrored(context.Background(), mp, &registryOptions{Insecure: true, Username: "user", Password: "secret"})
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(m.Layers) != 1 || m.Layers[0].Digest != blobDigest {
+				t.Errorf("unexpected manifest %+v", m)
+			}
+
+			if len(sources) != tt.sources {
+				t.Fatalf("expected %d sources, got %d", tt.sources, len(sources))
+			}
+
+			if source := hosts[sources[0].mp.Registry]; source != tt.source {
+				t.Errorf("expected the manifest from %s, got %s", tt.source, source)
+			}
+
+			if last := sources[len(sources)-1]; last.mp.Registry != mp.Registry || last.regOpts.Username != "user" {
+				t.Errorf("expected the registry to be the last source, got %+v", last)
+			}
+
+			for _, source := range sources[:len(sources)-1] {
+				if source.regOpts.Username != "" || source.regOpts.Password != "" {
+					t.Errorf("expected no credentials for %s", hosts[source.mp.Registry])
+				}
+			}
+
+			// the registry is only asked for the digest of its manifest,
+			// unless it doesn't send it
+			if r := registries["registry"]; tt.source != "registry" && !r.noDigest && r.served("GET /v2/library/test/manifests/") {
+				t.Error("expected the manifest not to be pulled from the registry")
+			}
+
+			if _, err := downloadBlobMirrored(context.Background(), sources, downloadOpts{
+				digest: blobDigest,
+				fn:     func(api.ProgressResponse) {},
+				size:   int64(len(blob)),
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			fp, err := GetBlobsPath(blobDigest)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got, err := os.ReadFile(fp); err != nil || !bytes.Equal(got, blob) {
+				t.Errorf("expected the blob, got %v", err)
+			}
+
+			if !registries[tt.source].served("GET /direct") {
+				t.Errorf("expected the blob from %s", tt.source)
+			}
+		})
+	}
+}
+
+func TestDownloadBlobMirrored(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	blob := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+
+	var sources []registrySource
+	var registries []*mirroredRegistry
+	for _, r := range []*mirroredRegistry{{tampered: true}, {down: true}, {}} {
+		r.blob = blob
+		srv := httptest.NewServer(r)
+		defer srv.Close()
+
+		sources = append(sources, registrySource{mp: ParseModelPath(srv.URL + "/library/test:latest"), regOpts: &registryOptions{Insecure: true}})
+		registries = append(registries, r)
+	}
+
+	if _, err := downloadBlobMirrored(context.Background(), sources, downloadOpts{
+		digest: digest,
+		fn:     func(api.ProgressResponse) {},
+		size:   int64(len(blob)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, r := range registries {
+		if !r.served("HEAD /v2/library/test/blobs/" + digest) {
+			t.Errorf("expected source %d to be tried", i)
+		}
+	}
+
+	fp, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := os.ReadFile(fp); err != nil || !bytes.Equal(got, blob) {
+		t.Errorf("expected the blob, got %v", err)
+	}
+
+	if matches, err := filepath.Glob(fp + "-partial*"); err != nil || len(matches) > 0 {
+		t.Errorf("expected no partial downloads, got %v %v", matches, err)
+	}
+}
@@ -59,7 +59,7 @@ func parseFromModel(ctx context.Context, name model.Name, fn func(api.ProgressRe
 		case "application/vnd.ollama.image.model",
 			"application/vnd.ollama.image.projector",
 			"application/vnd.ollama.image.adapter":
-			blobpath, err := GetBlobsPath(layer.Digest)
+			blobpath, err := fetchBlob(ctx, layer.Digest)
 			if err != nil {
 				return nil, err
 			}
@@ -87,9 +87,11 @@ func parseFromModel(ctx context.Context, name model.Name, fn func(api.ProgressRe
 func detectChatTemplate(layers []*layerGGML) ([]*layerGGML, error) {
 	for _, layer := range layers {
 		if s := layer.GGML.KV().ChatTemplate(); s != "" {
-			if t, err := template.Named(s); err != nil {
-				slog.Debug("template detection", "error", err, "template", s)
+			if t, report, err := template.Detect(s, layer.GGML.KV().Architecture()); err != nil {
+				slog.Debug("template detection", "error", err, "hash", report.Hash, "candidates", report.Candidates, "template", s)
 			} else {
+				slog.Debug("template detection", "name", t.Name, "method", report.Method, "hash", report.Hash, "candidates", report.Candidates)
+
 				layer, err := NewLayer(t.Reader(), "application/vnd.ollama.image.template")
 				if err != nil {
 					return nil, err
@@ -0,0 +1,301 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/types/model"
+)
+
+var errContentBlocked = errors.New("content blocked by moderation")
+
+// parseModeration parses the verdict of a Llama Guard style safety
+// classifier, which is "safe", or "unsafe" followed by a line of the
+// categories it falls into, such as "S1,S10".
+func parseModeration(s string) (*api.ModerationResult, error) {
+	verdict, categories, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	switch strings.ToLower(strings.TrimSpace(verdict)) {
+	case "safe":
+		return &api.ModerationResult{}, nil
+	case "unsafe":
+		result := api.ModerationResult{Flagged: true}
+		for c := range strings.SplitSeq(categories, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				result.Categories = append(result.Categories, c)
+			}
+		}
+		return &result, nil
+	default:
+		return nil, fmt.Errorf("unexpected verdict from moderation model: %q", verdict)
+	}
+}
+
+// moderate screens the last of msgs, in the context of the ones before it,
+// with the safety classifier model name.
+func (s *Server) moderate(ctx context.Context, name string, msgs []api.Message, keepAlive *api.Duration) (*api.ModerationResult, error) {
+	n, err := getExistingName(model.ParseName(name))
+	if err != nil {
+		return nil, err
+	}
+
+	n, _, err = resolveAlias(n)
+	if err != nil {
+		return nil, err
+	}
+
+	r, m, opts, err := s.scheduleRunner(ctx, n.String(), "", []Capability{CapabilityCompletion}, nil, keepAlive)
+	if err != nil {
+		return nil, err
+	}
+
+	// safety classifiers only read text
+	text := make([]api.Message, len(msgs))
+	for i, msg := range msgs {
+		text[i] = api.Message{Role: msg.Role, Content: msg.Content}
+	}
+
+	prompt, _, err := chatPrompt(ctx, m, r.Tokenize, opts, text, nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// the verdict is a few tokens, which shouldn't vary
+	opts.Temperature = 0
+	opts.NumPredict = 32
+
+	var sb strings.Builder
+	if err := r.Completion(ctx, llm.CompletionRequest{Prompt: prompt, Options: opts}, func(cr llm.CompletionResponse) {
+		sb.WriteString(cr.Content)
+	}); err != nil {
+		return nil, err
+	}
+
+	return parseModeration(sb.String())
+}
+
+// moderateRequest screens a request, msgs, with the moderation model if the
+// server has one. It returns false if the request is refused, having
+// already responded to it.
+func (s *Server) moderateRequest(c *gin.Context, msgs []api.Message) (*api.ModerationResult, bool) {
+	name := envconfig.ModerationModel()
+	if name == "" || len(msgs) == 0 {
+		return nil, true
+	}
+
+	policy := envconfig.ModerationPolicy()
+	result, err := s.moderate(c.Request.Context(), name, msgs, nil)
+	if err != nil {
+		if policy == "block" {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("moderation failed: %v", err)})
+			return nil, false
+		}
+
+		slog.Warn("failed to moderate request", "model", name, "error", err)
+		return nil, true
+	}
+
+	if result.Flagged {
+		slog.Warn("request flagged by moderation", "model", name, "categories", result.Categories, "policy", policy)
+		if policy == "block" {
+			c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeContentBlocked, moderationError("request", result).Error()))
+			return nil, false
+		}
+	}
+
+	return result, true
+}
+
+func moderationError(what string, result *api.ModerationResult) error {
+	if len(result.Categories) == 0 {
+		return fmt.Errorf("%s %w", what, errContentBlocked)
+	}
+
+	return fmt.Errorf("%s %w: %s", what, errContentBlocked, strings.Join(result.Categories, ", "))
+}
+
+// moderateResponses screens the responses from in, to a request of msgs,
+// with the moderation model once each completion is done. The last response
+// is annotated with the verdicts on the request, input, and the responses
+// when the policy is "annotate". Responses are held back until they're
+// screened when the policy is "block", and replaced with an error if
+// they're flagged.
+func (s *Server) moderateResponses(ctx context.Context, msgs []api.Message, input *api.ModerationResult, in chan any) chan any {
+	name := envconfig.ModerationModel()
+	if name == "" || len(msgs) == 0 {
+		return in
+	}
+
+	policy := envconfig.ModerationPolicy()
+	out := make(chan any)
+	go func() {
+		defer close(out)
+
+		// drain in if the client is gone, so the sender isn't left blocked
+		defer func() {
+			for range in {
+			}
+		}()
+
+		send := func(v any) bool {
+			select {
+			case out <- v:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		var held []any
+		contents := make(map[int]*strings.Builder)
+		write := func(index int, s string) {
+			if contents[index] == nil {
+				contents[index] = &strings.Builder{}
+			}
+			contents[index].WriteString(s)
+		}
+
+		for v := range in {
+			var done bool
+			switch t := v.(type) {
+			case api.GenerateResponse:
+				write(t.Index, t.Response)
+				done = t.Done
+			case api.ChatResponse:
+				write(t.Index, t.Message.Content)
+				done = t.Done
+			}
+
+			if !done {
+				if policy == "block" {
+					if _, ok := v.(gin.H); ok {
+						// an error ends the response, so drop what's held
+						send(v)
+						return
+					}
+					held = append(held, v)
+				} else if !send(v) {
+					return
+				}
+				continue
+			}
+
+			output, err := s.moderateOutputs(ctx, name, msgs, contents)
+			switch {
+			case err != nil && policy == "block":
+				send(streamError(fmt.Errorf("moderation failed: %w", err)))
+				return
+			case err != nil:
+				slog.Warn("failed to moderate response", "model", name, "error", err)
+			case output.Flagged:
+				slog.Warn("response flagged by moderation", "model", name, "categories", output.Categories, "policy", policy)
+				if policy == "block" {
+					send(errorResponse(api.ErrorCodeContentBlocked, moderationError("response", output).Error()))
+					return
+				}
+			}
+
+			for _, h := range held {
+				if !send(h) {
+					return
+				}
+			}
+			held = nil
+
+			if policy == "annotate" {
+				moderation := &api.Moderation{Model: name, Input: input, Output: output}
+				switch t := v.(type) {
+				case api.GenerateResponse:
+					t.Moderation = moderation
+					v = t
+				case api.ChatResponse:
+					t.Moderation = moderation
+					v = t
+				}
+			}
+
+			if !send(v) {
+				return
+			}
+		}
+	}()
+
+	return out
+}
+
+// moderateOutputs screens the completions of a request of msgs, returning a
+// verdict which is flagged if any of them are.
+func (s *Server) moderateOutputs(ctx context.Context, name string, msgs []api.Message, contents map[int]*strings.Builder) (*api.ModerationResult, error) {
+	var result api.ModerationResult
+	for _, i := range slices.Sorted(maps.Keys(contents)) {
+		content := contents[i].String()
+		if strings.TrimSpace(content) == "" {
+			continue
+		}
+
+		r, err := s.moderate(ctx, name, append(msgs, api.Message{Role: "assistant", Content: content}), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if r.Flagged {
+			result.Flagged = true
+			for _, c := range r.Categories {
+				if !slices.Contains(result.Categories, c) {
+					result.Categories = append(result.Categories, c)
+				}
+			}
+		}
+	}
+
+	return &result, nil
+}
+
+// ModerationHandler screens text or a conversation with a safety classifier
+// model, the server's moderation model unless the request names another.
+func (s *Server) ModerationHandler(c *gin.Context) {
+	var req api.ModerationRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	msgs := req.Messages
+	if req.Input != "" {
+		msgs = append(msgs, api.Message{Role: "user", Content: req.Input})
+	}
+
+	if len(msgs) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "input or messages is required"})
+		return
+	}
+
+	name := cmp.Or(req.Model, envconfig.ModerationModel())
+	if name == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required, as the server has no moderation model"})
+		return
+	}
+
+	result, err := s.moderate(c.Request.Context(), name, msgs, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, name, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, api.ModerationResponse{Model: name, ModerationResult: *result})
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestParseModeration(t *testing.T) {
+	cases := []struct {
+		verdict string
+		want    *api.ModerationResult
+		err     bool
+	}{
+		{"safe", &api.ModerationResult{}, false},
+		{"\n\nsafe\n", &api.ModerationResult{}, false},
+		{"unsafe\nS1", &api.ModerationResult{Flagged: true, Categories: []string{"S1"}}, false},
+		{"Unsafe\nS1, S10", &api.ModerationResult{Flagged: true, Categories: []string{"S1", "S10"}}, false},
+		{"unsafe", &api.ModerationResult{Flagged: true}, false},
+		{"I can't help with that.", nil, true},
+	}
+
+	for _, tt := range cases {
+		got, err := parseModeration(tt.verdict)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: unexpected error %v", tt.verdict, err)
+			continue
+		}
+
+		if diff := cmp.Diff(got, tt.want); diff != "" {
+			t.Errorf("%q: mismatch (-got +want):\n%s", tt.verdict, diff)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerConfig   = "application/vnd.docker.container.image.v1+json"
+	mediaTypeOCIManifest    = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex       = "application/vnd.oci.image.index.v1+json"
+	mediaTypeAdapter        = "application/vnd.ollama.image.adapter"
+
+	// the media type of the config of models pushed as OCI artifacts, which
+	// keeps tools from mistaking models for container images
+	mediaTypeOCIConfig = "application/vnd.ollama.model.config.v1+json"
+
+	artifactTypeModel   = "application/vnd.ollama.model.v1"
+	artifactTypeAdapter = "application/vnd.ollama.adapter.v1"
+)
+
+// ociDescriptor describes a manifest in an OCI image index.
+type ociDescriptor struct {
+	MediaType    string `json:"mediaType"`
+	Digest       string `json:"digest"`
+	Size         int64  `json:"size"`
+	ArtifactType string `json:"artifactType,omitempty"`
+}
+
+// ociIndex is an OCI image index, which registries without the referrers
+// API use to list the manifests referring to another manifest.
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// pullMediaTypes are the media types of the manifests models are pulled with.
+var pullMediaTypes = []string{mediaTypeDockerManifest, mediaTypeOCIManifest}
+
+func isDigest(ref string) bool {
+	return strings.HasPrefix(ref, "sha256:")
+}
+
+// getRegistryManifest gets the manifest of mp with the tag or digest ref,
+// checking manifests fetched by digest match it.
+func getRegistryManifest(ctx context.Context, mp ModelPath, ref string, accept []string, regOpts *registryOptions) ([]byte, error) {
+	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", ref)
+
+	headers := make(http.Header)
+	headers.Set("Accept", strings.Join(accept, ", "))
+	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, headers, nil, regOpts)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	bts, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if isDigest(ref) {
+		if digest := fmt.Sprintf("sha256:%x", sha256.Sum256(bts)); digest != ref {
+			return nil, fmt.Errorf("manifest digest mismatch, expected %s, got %s", ref, digest)
+		}
+	}
+
+	return bts, nil
+}
+
+// pullManifest pulls the manifest of mp with the tag or digest ref, which may
+// be an Ollama manifest or an OCI artifact. Adapter artifacts are merged with
+// the model they refer to as their subject. The manifest returned is always
+// an Ollama manifest.
+func pullManifest(ctx context.Context, mp ModelPath, ref string, regOpts *registryOptions) (*Manifest, error) {
+	bts, err := getRegistryManifest(ctx, mp, ref, pullMediaTypes, regOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(bts, &m); err != nil {
+		return nil, err
+	}
+
+	if m.Subject != nil {
+		bts, err := getRegistryManifest(ctx, mp, m.Subject.Digest, pullMediaTypes, regOpts)
+		if err != nil {
+			return nil, fmt.Errorf("pull subject %s: %w", m.Subject.Digest, err)
+		}
+
+		var base Manifest
+		if err := json.Unmarshal(bts, &base); err != nil {
+			return nil, err
+		}
+
+		m.Layers = append(base.Layers, m.Layers...)
+		m.Subject = nil
+	}
+
+	if m.MediaType == mediaTypeOCIManifest {
+		m.MediaType = mediaTypeDockerManifest
+		m.ArtifactType = ""
+		if m.Config.MediaType == mediaTypeOCIConfig {
+			m.Config.MediaType = mediaTypeDockerConfig
+		}
+	}
+
+	return &m, nil
+}
+
+// putRegistryManifest puts m as the manifest of mp with the tag or digest
+// ref, returning its descriptor and whether the registry recorded its subject.
+func putRegistryManifest(ctx context.Context, mp ModelPath, ref string, m Manifest, regOpts *registryOptions) (ociDescriptor, bool, error) {
+	bts, err := json.Marshal(m)
+	if err != nil {
+		return ociDescriptor{}, false, err
+	}
+
+	desc := ociDescriptor{
+		MediaType:    m.MediaType,
+		Digest:       fmt.Sprintf("sha256:%x", sha256.Sum256(bts)),
+		Size:         int64(len(bts)),
+		ArtifactType: m.ArtifactType,
+	}
+
+	if ref == "" {
+		ref = desc.Digest
+	}
+
+	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", ref)
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", m.MediaType)
+	resp, err := makeRequestWithRetry(ctx, http.MethodPut, requestURL, headers, bytes.NewReader(bts), regOpts)
+	if err != nil {
+		return ociDescriptor{}, false, err
+	}
+	defer resp.Body.Close()
+
+	return desc, resp.Header.Get("OCI-Subject") != "", nil
+}
+
+// pushArtifact pushes manifest as the OCI artifact mp. Adapters are pushed
+// as an artifact of their own which refers to the rest of the model as its
+// subject, so registries list them with the referrers API.
+func pushArtifact(ctx context.Context, mp ModelPath, manifest *Manifest, regOpts *registryOptions) error {
+	config := manifest.Config
+	if config.MediaType == mediaTypeDockerConfig {
+		config.MediaType = mediaTypeOCIConfig
+	}
+
+	var layers, adapters []Layer
+	for _, layer := range manifest.Layers {
+		layer.From = ""
+		if layer.MediaType == mediaTypeAdapter {
+			adapters = append(adapters, layer)
+		} else {
+			layers = append(layers, layer)
+		}
+	}
+
+	m := Manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		ArtifactType:  artifactTypeModel,
+		Config:        config,
+		Layers:        layers,
+	}
+
+	if len(adapters) == 0 {
+		_, _, err := putRegistryManifest(ctx, mp, mp.Tag, m, regOpts)
+		return err
+	}
+
+	// the model the adapters apply to is only referenced by digest
+	subject, _, err := putRegistryManifest(ctx, mp, "", m, regOpts)
+	if err != nil {
+		return err
+	}
+
+	desc, recorded, err := putRegistryManifest(ctx, mp, mp.Tag, Manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		ArtifactType:  artifactTypeAdapter,
+		Config:        config,
+		Layers:        adapters,
+		Subject:       &Layer{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size},
+	}, regOpts)
+	if err != nil {
+		return err
+	}
+
+	if recorded {
+		return nil
+	}
+
+	return putReferrersTag(ctx, mp, subject.Digest, desc, regOpts)
+}
+
+// putReferrersTag adds desc to the index tagged with the digest of its
+// subject, which is how registries without the referrers API list the
+// manifests referring to another.
+func putReferrersTag(ctx context.Context, mp ModelPath, subject string, desc ociDescriptor, regOpts *registryOptions) error {
+	tag := strings.Replace(subject, ":", "-", 1)
+
+	index := ociIndex{SchemaVersion: 2, MediaType: mediaTypeOCIIndex}
+	bts, err := getRegistryManifest(ctx, mp, tag, []string{mediaTypeOCIIndex}, regOpts)
+	if err == nil {
+		if err := json.Unmarshal(bts, &index); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for _, m := range index.Manifests {
+		if m.Digest == desc.Digest {
+			return nil
+		}
+	}
+
+	index.Manifests = append(index.Manifests, desc)
+	if bts, err = json.Marshal(index); err != nil {
+		return err
+	}
+
+	requestURL := mp.BaseURL().JoinPath("v2", mp.GetNamespaceRepository(), "manifests", tag)
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", mediaTypeOCIIndex)
+	resp, err := makeRequestWithRetry(ctx, http.MethodPut, requestURL, headers, bytes.NewReader(bts), regOpts)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
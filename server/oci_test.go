@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// manifestRegistry serves the manifests API of an OCI registry.
+type manifestRegistry struct {
+	mu        sync.Mutex
+	manifests map[string][]byte
+	// referrers is whether the registry supports the referrers API
+	referrers bool
+}
+
+func (r *manifestRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ref, ok := strings.Cut(req.URL.Path, "/v2/library/test/manifests/")
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPut:
+		bts, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		r.manifests[ref] = bts
+		r.manifests[fmt.Sprintf("sha256:%x", sha256.Sum256(bts))] = bts
+
+		var m Manifest
+		if err := json.Unmarshal(bts, &m); err == nil && m.Subject != nil && r.referrers {
+			w.Header().Set("OCI-Subject", m.Subject.Digest)
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		bts, ok := r.manifests[ref]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+
+		w.Write(bts)
+	}
+}
+
+func (r *manifestRegistry) manifest(t *testing.T, ref string) Manifest {
+	t.Helper()
+
+	var m Manifest
+	if err := json.Unmarshal(r.manifests[ref], &m); err != nil {
+		t.Fatalf("%s: %v", ref, err)
+	}
+
+	return m
+}
+
+func TestPushPullArtifact(t *testing.T) {
+	config := Layer{MediaType: mediaTypeDockerConfig, Digest: "sha256:config", Size: 1}
+	weights := Layer{MediaType: "application/vnd.ollama.image.model", Digest: "sha256:weights", Size: 2}
+	template := Layer{MediaType: "application/vnd.ollama.image.template", Digest: "sha256:template", Size: 3}
+	adapter := Layer{MediaType: mediaTypeAdapter, Digest: "sha256:adapter", Size: 4}
+
+	cases := []struct {
+		name      string
+		layers    []Layer
+		referrers bool
+	}{
+		{"model", []Layer{weights, template}, false},
+		{"adapter", []Layer{weights, adapter, template}, false},
+		{"adapter with referrers api", []Layer{weights, adapter, template}, true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &manifestRegistry{manifests: make(map[string][]byte), referrers: tt.referrers}
+			srv := httptest.NewServer(r)
+			defer srv.Close()
+
+			mp := ParseModelPath(srv.URL + "/library/test:latest")
+			regOpts := &registryOptions{Insecure: true, OCI: true}
+
+			if err := pushArtifact(context.Background(), mp, &Manifest{
+				SchemaVersion: 2,
+				MediaType:     mediaTypeDockerManifest,
+				Config:        config,
+				Layers:        tt.layers,
+			}, regOpts); err != nil {
+				t.Fatal(err)
+			}
+
+			m := r.manifest(t, "latest")
+			if m.MediaType != mediaTypeOCIManifest || m.Config.MediaType != mediaTypeOCIConfig {
+				t.Errorf("unexpected media types %s and %s", m.MediaType, m.Config.MediaType)
+			}
+
+			if tt.name == "model" {
+				if m.ArtifactType != artifactTypeModel || m.Subject != nil {
+					t.Errorf("unexpected artifact %s with subject %v", m.ArtifactType, m.Subject)
+				}
+			} else {
+				if m.ArtifactType != artifactTypeAdapter || m.Subject == nil {
+					t.Fatalf("unexpected artifact %s with subject %v", m.ArtifactType, m.Subject)
+				}
+
+				if diff := cmp.Diff([]Layer{adapter}, m.Layers, cmpopts.IgnoreUnexported(Layer{})); diff != "" {
+					t.Errorf("mismatch (-want +got):\n%s", diff)
+				}
+
+				if base := r.manifest(t, m.Subject.Digest); base.ArtifactType != artifactTypeModel {
+					t.Errorf("unexpected subject artifact %s", base.ArtifactType)
+				}
+
+				_, indexed := r.manifests[strings.Replace(m.Subject.Digest, ":", "-", 1)]
+				if indexed == tt.referrers {
+					t.Errorf("expected referrers tag %t, got %t", !tt.referrers, indexed)
+				}
+			}
+
+			pulled, err := pullManifest(context.Background(), mp, mp.Tag, regOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if pulled.MediaType != mediaTypeDockerManifest || pulled.ArtifactType != "" || pulled.Subject != nil {
+				t.Errorf("unexpected manifest %+v", pulled)
+			}
+
+			if diff := cmp.Diff(config, pulled.Config, cmpopts.IgnoreUnexported(Layer{})); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.layers, pulled.Layers, cmpopts.IgnoreUnexported(Layer{}), cmpopts.SortSlices(func(a, b Layer) bool {
+				return a.Digest < b.Digest
+			})); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPullManifestSubjectMismatch(t *testing.T) {
+	r := &manifestRegistry{manifests: make(map[string][]byte)}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	subject := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("{}")))
+	r.manifests[subject] = []byte(`{"layers":[{"digest":"sha256:tampered"}]}`)
+
+	bts, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		ArtifactType:  artifactTypeAdapter,
+		Subject:       &Layer{MediaType: mediaTypeOCIManifest, Digest: subject, Size: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.manifests["latest"] = bts
+
+	mp := ParseModelPath(srv.URL + "/library/test:latest")
+	if _, err := pullManifest(context.Background(), mp, mp.Tag, &registryOptions{Insecure: true}); err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Errorf("expected digest mismatch, got %v", err)
+	}
+}
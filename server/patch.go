@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/server/internal/delta"
+)
+
+const (
+	// patchMediaType is the content type of a binary patch served by the
+	// registry. See the delta package for the format.
+	patchMediaType = "application/vnd.ollama.image.patch"
+
+	// minPatchSize is the smallest layer the registry is asked to patch.
+	// Smaller layers are cheap enough to download whole.
+	minPatchSize int64 = 100 * format.MegaByte
+)
+
+// patchBase returns the digest of the layer in previous which a patch for
+// layer should be applied to, or an empty string if layer should be
+// downloaded whole. The base must have the same media type and be stored
+// locally.
+func patchBase(previous []Layer, layer Layer) string {
+	if layer.Size < minPatchSize {
+		return ""
+	}
+
+	for _, l := range previous {
+		if l.MediaType != layer.MediaType || l.Digest == layer.Digest {
+			continue
+		}
+
+		if p, err := GetBlobsPath(l.Digest); err == nil {
+			if _, err := os.Stat(p); err == nil {
+				return l.Digest
+			}
+		}
+	}
+
+	return ""
+}
+
+// downloadBlobPatch asks the registry for a patch from opts.base to
+// opts.digest and applies it to the local base blob. It reports whether the
+// blob was built from a patch; if it wasn't, the caller should download the
+// whole blob. Registries which don't serve patches respond with not found.
+func downloadBlobPatch(ctx context.Context, opts downloadOpts) (bool, error) {
+	requestURL := opts.mp.BaseURL()
+	requestURL = requestURL.JoinPath("v2", opts.mp.GetNamespaceRepository(), "patches", opts.base, opts.digest)
+
+	resp, err := makeRequestWithRetry(ctx, http.MethodGet, requestURL, nil, nil, opts.regOpts)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return false, nil
+	case errors.Is(err, context.Canceled):
+		return false, err
+	case err != nil:
+		slog.Warn("failed to request patch, downloading whole blob", "digest", opts.digest, "error", err)
+		return false, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != patchMediaType {
+		return false, nil
+	}
+
+	if err := applyBlobPatch(ctx, opts, resp); err != nil {
+		if errors.Is(err, context.Canceled) {
+			return false, err
+		}
+
+		slog.Warn("failed to apply patch, downloading whole blob", "digest", opts.digest, "base", opts.base, "error", err)
+		return false, nil
+	}
+
+	slog.Info("built blob from patch", "digest", opts.digest, "base", opts.base, "size", format.HumanBytes(resp.ContentLength))
+	return true, nil
+}
+
+func applyBlobPatch(ctx context.Context, opts downloadOpts, resp *http.Response) error {
+	basePath, err := GetBlobsPath(opts.base)
+	if err != nil {
+		return err
+	}
+
+	base, err := os.Open(basePath)
+	if err != nil {
+		return err
+	}
+	defer base.Close()
+
+	fi, err := base.Stat()
+	if err != nil {
+		return err
+	}
+
+	fp, err := GetBlobsPath(opts.digest)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(fp), "sha256-")
+	if err != nil {
+		return err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	// progress is reported against the size of the patch since that's
+	// what is being downloaded
+	w := &blobProgressWriter{digest: opts.digest, total: max(resp.ContentLength, 0), fn: opts.fn}
+	r := &rateLimitedReader{ctx: ctx, r: io.TeeReader(resp.Body, w), limiters: downloadLimiters(opts.regOpts)}
+
+	sha256sum := sha256.New()
+	if err := delta.Apply(io.MultiWriter(temp, sha256sum), base, fi.Size(), r); err != nil {
+		return err
+	}
+
+	if digest := fmt.Sprintf("sha256:%x", sha256sum.Sum(nil)); digest != opts.digest {
+		return fmt.Errorf("digest mismatch, expected %q, got %q", opts.digest, digest)
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	w.report()
+	return os.Rename(temp.Name(), fp)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/server/internal/delta"
+)
+
+func TestDownloadBlobPatch(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	base := []byte("weights quantized with the old recipe")
+	target := []byte("weights quantized with the new recipe")
+	baseDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(base))
+	targetDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(target))
+
+	p, err := GetBlobsPath(baseDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(p, base, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var patch bytes.Buffer
+	w := delta.NewWriter(&patch)
+	w.Copy(0, 27)
+	w.Insert([]byte("new"))
+	w.Copy(30, 7)
+	w.Close()
+
+	cases := []struct {
+		name   string
+		digest string
+		serve  bool
+		expect bool
+	}{
+		{"patched", targetDigest, true, true},
+		{"not found", targetDigest, false, false},
+		{"digest mismatch", fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("other"))), true, false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !tt.serve || r.URL.Path != "/v2/library/test/patches/"+baseDigest+"/"+tt.digest {
+					http.NotFound(w, r)
+					return
+				}
+
+				w.Header().Set("Content-Type", patchMediaType)
+				w.Write(patch.Bytes())
+			}))
+			defer srv.Close()
+
+			ok, err := downloadBlobPatch(context.Background(), downloadOpts{
+				mp:      ParseModelPath(srv.URL + "/library/test:latest"),
+				digest:  tt.digest,
+				base:    baseDigest,
+				regOpts: &registryOptions{},
+				fn:      func(api.ProgressResponse) {},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ok != tt.expect {
+				t.Fatalf("expected %t, got %t", tt.expect, ok)
+			}
+
+			fp, err := GetBlobsPath(tt.digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bts, err := os.ReadFile(fp)
+			switch {
+			case !tt.expect && !os.IsNotExist(err):
+				t.Errorf("expected blob to not exist, got %v", err)
+			case tt.expect && err != nil:
+				t.Fatal(err)
+			case tt.expect && !bytes.Equal(bts, target):
+				t.Errorf("expected %q, got %q", target, bts)
+			}
+
+			os.Remove(fp)
+		})
+	}
+}
+
+func TestPatchBase(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	local := Layer{MediaType: "application/vnd.ollama.image.model", Digest: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("local")))}
+	missing := Layer{MediaType: "application/vnd.ollama.image.model", Digest: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("missing")))}
+	template := Layer{MediaType: "application/vnd.ollama.image.template", Digest: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("template")))}
+
+	for _, l := range []Layer{local, template} {
+		p, err := GetBlobsPath(l.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	layer := Layer{MediaType: "application/vnd.ollama.image.model", Size: minPatchSize}
+
+	cases := []struct {
+		name     string
+		previous []Layer
+		layer    Layer
+		expect   string
+	}{
+		{"no previous", nil, layer, ""},
+		{"small layer", []Layer{local}, Layer{MediaType: layer.MediaType, Size: 1}, ""},
+		{"local base", []Layer{template, missing, local}, layer, local.Digest},
+		{"missing base", []Layer{missing}, layer, ""},
+		{"different media type", []Layer{template}, Layer{MediaType: "application/vnd.ollama.image.projector", Size: minPatchSize}, ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if base := patchBase(tt.previous, tt.layer); base != tt.expect {
+				t.Errorf("expected %q, got %q", tt.expect, base)
+			}
+		})
+	}
+}
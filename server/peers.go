@@ -0,0 +1,195 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// peerTimeout bounds how long a peer has to answer whether it has a blob
+// so that unreachable peers don't hold up a pull.
+const peerTimeout = 2 * time.Second
+
+// peerStallTimeout is how long a download from a peer can go without
+// receiving any of the blob before it's given up on for the next peer or
+// the registry, like a stalled part of a registry download.
+var peerStallTimeout = 30 * time.Second
+
+var errPeerStalled = errors.New("peer stalled")
+
+// downloadBlobFromPeers tries to fetch the blob from one of the peers
+// configured with OLLAMA_PEERS. Peers are tried in order and any peer
+// failure falls through to the next one. It reports whether the blob was
+// downloaded; if it wasn't, the caller should fall back to the registry.
+// The blob is read no faster than limiters allow.
+func downloadBlobFromPeers(ctx context.Context, opts downloadOpts, limiters []*rateLimiter) (bool, error) {
+	for _, peer := range envconfig.Peers() {
+		requestURL := peer.JoinPath("api", "blobs", opts.digest)
+		err := downloadBlobFromPeer(ctx, requestURL, opts, limiters)
+		switch {
+		case err == nil:
+			slog.Info("downloaded blob from peer", "digest", opts.digest, "peer", peer.Host)
+			return true, nil
+		case errors.Is(err, context.Canceled):
+			return false, err
+		case errors.Is(err, os.ErrNotExist):
+			slog.Debug("peer does not have blob", "digest", opts.digest, "peer", peer.Host)
+		default:
+			slog.Warn("failed to download blob from peer", "digest", opts.digest, "peer", peer.Host, "error", err)
+		}
+	}
+
+	return false, nil
+}
+
+func downloadBlobFromPeer(ctx context.Context, requestURL *url.URL, opts downloadOpts, limiters []*rateLimiter) error {
+	c, err := registryClient(requestURL, nil)
+	if err != nil {
+		return err
+	}
+
+	total, err := func() (int64, error) {
+		ctx, cancel := context.WithTimeout(ctx, peerTimeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, requestURL.String(), nil)
+		if err != nil {
+			return 0, err
+		}
+
+		resp, err := c.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotFound:
+			return 0, os.ErrNotExist
+		default:
+			return 0, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+
+		return strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	}()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	stall := time.AfterFunc(peerStallTimeout, func() { cancel(errPeerStalled) })
+	defer stall.Stop()
+
+	// the peer stalled if the request was cancelled by the timer rather
+	// than the caller, which isn't a reason to give up on the pull
+	stalled := func(err error) error {
+		if errors.Is(context.Cause(ctx), errPeerStalled) {
+			return fmt.Errorf("%w for %s", errPeerStalled, peerStallTimeout)
+		}
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return stalled(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	fp, err := GetBlobsPath(opts.digest)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(fp), "sha256-")
+	if err != nil {
+		return err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	sha256sum := sha256.New()
+	w := &blobProgressWriter{digest: opts.digest, total: total, fn: opts.fn}
+	r := &rateLimitedReader{ctx: ctx, r: &stallReader{r: resp.Body, timer: stall}, limiters: limiters}
+	if _, err := io.Copy(io.MultiWriter(temp, sha256sum, w), r); err != nil {
+		return stalled(err)
+	}
+
+	if digest := fmt.Sprintf("sha256:%x", sha256sum.Sum(nil)); digest != opts.digest {
+		return fmt.Errorf("digest mismatch, expected %q, got %q", opts.digest, digest)
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	w.report()
+	return os.Rename(temp.Name(), fp)
+}
+
+// stallReader resets timer, which cancels the download it reads, whenever
+// it reads some of the blob.
+type stallReader struct {
+	r     io.Reader
+	timer *time.Timer
+}
+
+func (r *stallReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 {
+		r.timer.Reset(peerStallTimeout)
+	}
+	return n, err
+}
+
+// blobProgressWriter reports progress of blobs fetched from peers or built
+// from patches at roughly the same rate as registry downloads.
+type blobProgressWriter struct {
+	digest    string
+	total     int64
+	completed int64
+	reported  time.Time
+	fn        func(api.ProgressResponse)
+}
+
+func (w *blobProgressWriter) Write(b []byte) (int, error) {
+	w.completed += int64(len(b))
+	if time.Since(w.reported) > 60*time.Millisecond {
+		w.report()
+	}
+
+	return len(b), nil
+}
+
+func (w *blobProgressWriter) report() {
+	w.reported = time.Now()
+	w.fn(api.ProgressResponse{
+		Status:    fmt.Sprintf("pulling %s", w.digest[7:19]),
+		Digest:    w.digest,
+		Total:     w.total,
+		Completed: w.completed,
+	})
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestDownloadBlobFromPeers(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	blob := []byte("a blob shared over the local network")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	var gets int
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/blobs/"+digest {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			gets++
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
+	}))
+	defer peer.Close()
+
+	t.Setenv("OLLAMA_PEERS", strings.Join([]string{missing.URL, peer.URL}, ","))
+
+	var progress []api.ProgressResponse
+	ok, err := downloadBlobFromPeers(context.Background(), downloadOpts{
+		digest: digest,
+		fn:     func(r api.ProgressResponse) { progress = append(progress, r) },
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatal("expected blob to be downloaded from peer")
+	}
+
+	if gets != 1 {
+		t.Errorf("expected 1 GET request, got %d", gets)
+	}
+
+	if len(progress) == 0 || progress[len(progress)-1].Completed != int64(len(blob)) {
+		t.Errorf("expected final progress to be complete, got %v", progress)
+	}
+
+	fp, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bts, err := os.ReadFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(bts, blob) {
+		t.Errorf("blob mismatch: got %q", bts)
+	}
+}
+
+func TestDownloadBlobFromPeersShared(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	blob := bytes.Repeat([]byte("a blob pulled by two models at once "), 100)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+
+	var gets atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && gets.Add(1) == 1 {
+			close(started)
+			<-release
+		}
+
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
+	}))
+	defer peer.Close()
+
+	// the peer is unblocked before it's closed if the test fails early
+	unblock := sync.OnceFunc(func() { close(release) })
+	defer unblock()
+
+	t.Setenv("OLLAMA_PEERS", peer.URL)
+
+	// the blob is limited to a fifth of a second
+	rate := int64(len(blob)) * 5
+	pull := func() error {
+		_, err := downloadBlob(t.Context(), downloadOpts{
+			digest:  digest,
+			regOpts: &registryOptions{MaxDownloadRate: rate},
+			fn:      func(api.ProgressResponse) {},
+		})
+		return err
+	}
+
+	var g errgroup.Group
+	g.Go(pull)
+	<-started
+
+	// the second pull waits for the transfer the first pull started
+	g.Go(pull)
+	for {
+		data, ok := blobDownloadManager.Load(digest)
+		if !ok {
+			t.Fatal("expected the blob to be downloading")
+		}
+
+		// both pulls are waiting once the download has two references
+		if data.(*blobDownload).references.Load() == 2 {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	unblock()
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := gets.Load(); n != 1 {
+		t.Errorf("expected 1 GET request, got %d", n)
+	}
+
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected the download rate to apply to the peer, took %s", elapsed)
+	}
+
+	fp, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bts, err := os.ReadFile(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(bts, blob) {
+		t.Error("blob mismatch")
+	}
+}
+
+func TestDownloadBlobFromPeersDigestMismatch(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("expected")))
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("tampered"))
+	}))
+	defer peer.Close()
+
+	t.Setenv("OLLAMA_PEERS", peer.URL)
+
+	ok, err := downloadBlobFromPeers(context.Background(), downloadOpts{
+		digest: digest,
+		fn:     func(api.ProgressResponse) {},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok {
+		t.Fatal("expected blob with mismatched digest to be rejected")
+	}
+
+	fp, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(fp); !os.IsNotExist(err) {
+		t.Errorf("expected blob to not exist, got %v", err)
+	}
+}
+
+func TestDownloadBlobFromPeersStalled(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	old := peerStallTimeout
+	peerStallTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { peerStallTimeout = old })
+
+	blob := []byte("a blob which is never sent in full")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+
+	// the peer sends part of the blob and then hangs
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		w.Write(blob[:8])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer peer.Close()
+
+	t.Setenv("OLLAMA_PEERS", peer.URL)
+
+	start := time.Now()
+	ok, err := downloadBlobFromPeers(context.Background(), downloadOpts{
+		digest: digest,
+		fn:     func(api.ProgressResponse) {},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok {
+		t.Fatal("expected the stalled peer to be given up on")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the stalled peer to be given up on quickly, took %s", elapsed)
+	}
+}
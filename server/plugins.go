@@ -0,0 +1,447 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+var errPluginConfig = errors.New("invalid plugins")
+
+// pluginConfig is a plugin in the OLLAMA_PLUGINS file, an executable which
+// is sent a JSON object on a line of its standard input for each request or
+// response it hooks, and replies with one on a line of its standard output.
+type pluginConfig struct {
+	Name string `json:"name"`
+
+	// Command is the executable and its arguments.
+	Command []string `json:"command"`
+
+	// Hooks are what the plugin is sent: "request", "response" or both.
+	Hooks []string `json:"hooks"`
+
+	// Paths limit the plugin to requests to these endpoints, such as
+	// "/api/chat". It's sent those to every endpoint if there are none.
+	Paths []string `json:"paths,omitempty"`
+
+	// Timeout is how long the plugin has to reply to each message. Default
+	// is 5 seconds.
+	Timeout *api.Duration `json:"timeout,omitempty"`
+
+	// OnFailure is what happens when the plugin fails or doesn't reply in
+	// time: "block" refuses the request, or ends its response with an
+	// error, and "skip" carries on as if the plugin wasn't there. Default
+	// is "block".
+	OnFailure string `json:"on_failure,omitempty"`
+}
+
+// pluginMessage is sent to a plugin for each request or response it hooks.
+// Streamed responses are sent one object at a time.
+type pluginMessage struct {
+	Hook      string          `json:"hook"`
+	Path      string          `json:"path"`
+	RequestID string          `json:"request_id,omitempty"`
+	Tenant    string          `json:"tenant,omitempty"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// pluginReply is a plugin's reply to a message. Body replaces the request
+// or response if it's set, and Error refuses the request, or ends the
+// response, with Status, which is 400 if it isn't an error status.
+type pluginReply struct {
+	Body   json.RawMessage `json:"body,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Status int             `json:"status,omitempty"`
+}
+
+// plugin is a running plugin. Its process is started when it's first sent
+// a message and restarted if it fails, and handles one message at a time.
+type plugin struct {
+	pluginConfig
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+func (p *plugin) hooks(hook, path string) bool {
+	return slices.Contains(p.Hooks, hook) && (len(p.Paths) == 0 || slices.Contains(p.Paths, path))
+}
+
+func (p *plugin) start() error {
+	cmd := exec.Command(p.Command[0], p.Command[1:]...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	slog.Info("started plugin", "name", p.Name, "pid", cmd.Process.Pid)
+	p.cmd, p.stdin, p.stdout = cmd, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+// stop ends the plugin's process. p.mu must be held.
+func (p *plugin) stop() {
+	if p.cmd == nil {
+		return
+	}
+
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+	p.cmd = nil
+}
+
+// send sends msg to the plugin and waits for its reply.
+func (p *plugin) send(msg pluginMessage) (*pluginReply, error) {
+	bts, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cmd == nil {
+		if err := p.start(); err != nil {
+			return nil, err
+		}
+	}
+
+	timeout := 5 * time.Second
+	if p.Timeout != nil {
+		timeout = p.Timeout.Duration
+	}
+
+	type result struct {
+		line []byte
+		err  error
+	}
+
+	done := make(chan result, 1)
+	stdin, stdout := p.stdin, p.stdout
+	go func() {
+		if _, err := stdin.Write(append(bts, '\n')); err != nil {
+			done <- result{err: err}
+			return
+		}
+
+		line, err := stdout.ReadBytes('\n')
+		done <- result{line, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			p.stop()
+			return nil, r.err
+		}
+
+		var reply pluginReply
+		if err := json.Unmarshal(r.line, &reply); err != nil {
+			p.stop()
+			return nil, fmt.Errorf("invalid reply: %w", err)
+		}
+
+		return &reply, nil
+	case <-time.After(timeout):
+		// the reply may still come, and be read as the reply to the next
+		// message, so start again with a new process
+		p.stop()
+		return nil, fmt.Errorf("no reply within %s", timeout)
+	}
+}
+
+// loadedPlugins caches the plugins of the OLLAMA_PLUGINS file, which are
+// stopped and read again when it changes.
+var loadedPlugins struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	plugins []*plugin
+}
+
+func readPlugins(path string) ([]*plugin, error) {
+	loadedPlugins.Lock()
+	defer loadedPlugins.Unlock()
+
+	var modTime time.Time
+	if path != "" {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errPluginConfig, err)
+		}
+		modTime = fi.ModTime()
+	}
+
+	if path == loadedPlugins.path && modTime.Equal(loadedPlugins.modTime) {
+		return loadedPlugins.plugins, nil
+	}
+
+	var configs []pluginConfig
+	if path != "" {
+		bts, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errPluginConfig, err)
+		}
+
+		if err := json.Unmarshal(bts, &configs); err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", errPluginConfig, path, err)
+		}
+	}
+
+	plugins := make([]*plugin, len(configs))
+	for i, config := range configs {
+		switch {
+		case config.Name == "":
+			return nil, fmt.Errorf("%w: %s: plugin %d has no name", errPluginConfig, path, i)
+		case len(config.Command) == 0:
+			return nil, fmt.Errorf("%w: %s: plugin %q has no command", errPluginConfig, path, config.Name)
+		case len(config.Hooks) == 0 || slices.ContainsFunc(config.Hooks, func(s string) bool { return s != "request" && s != "response" }):
+			return nil, fmt.Errorf("%w: %s: plugin %q must hook request, response or both", errPluginConfig, path, config.Name)
+		case config.OnFailure != "" && config.OnFailure != "block" && config.OnFailure != "skip":
+			return nil, fmt.Errorf("%w: %s: plugin %q has an invalid on_failure %q, expected block or skip", errPluginConfig, path, config.Name, config.OnFailure)
+		}
+
+		plugins[i] = &plugin{pluginConfig: config}
+	}
+
+	// let the old plugins finish the messages they have before stopping them
+	for _, p := range loadedPlugins.plugins {
+		go func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			p.stop()
+		}()
+	}
+
+	loadedPlugins.path, loadedPlugins.modTime, loadedPlugins.plugins = path, modTime, plugins
+	return plugins, nil
+}
+
+// pluginMiddleware sends requests, and their responses, through the plugins
+// which hook them, in the order they're in the OLLAMA_PLUGINS file.
+func pluginMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		plugins, err := readPlugins(envconfig.Plugins())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		path := c.Request.URL.Path
+		tenant := c.GetString(tenantKey)
+
+		var requestPlugins, responsePlugins []*plugin
+		for _, p := range plugins {
+			if p.hooks("request", path) {
+				requestPlugins = append(requestPlugins, p)
+			}
+
+			if p.hooks("response", path) {
+				responsePlugins = append(responsePlugins, p)
+			}
+		}
+
+		if len(requestPlugins) > 0 && c.Request.Body != nil {
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+
+			// leave invalid requests to the handler to reject
+			if json.Valid(body) {
+				for _, p := range requestPlugins {
+					reply, err := p.send(pluginMessage{Hook: "request", Path: path, Tenant: tenant, Body: body})
+					if err != nil {
+						if p.OnFailure == "skip" {
+							slog.Warn("plugin failed, skipping it", "name", p.Name, "hook", "request", "error", err)
+							continue
+						}
+
+						c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("plugin %s failed: %v", p.Name, err)})
+						return
+					}
+
+					if reply.Error != "" {
+						c.AbortWithStatusJSON(reply.status(), gin.H{"error": reply.Error})
+						return
+					}
+
+					if len(reply.Body) > 0 {
+						body = reply.Body
+					}
+				}
+			}
+
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			c.Request.ContentLength = int64(len(body))
+		}
+
+		if len(responsePlugins) == 0 {
+			c.Next()
+			return
+		}
+
+		// stop generating once a plugin ends the response
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		w := &pluginWriter{ResponseWriter: c.Writer, c: c, plugins: responsePlugins, path: path, tenant: tenant, cancel: cancel}
+		c.Writer = w
+		c.Next()
+		w.flush()
+	}
+}
+
+func (r *pluginReply) status() int {
+	if r.Status < http.StatusBadRequest || r.Status > 599 {
+		return http.StatusBadRequest
+	}
+
+	return r.Status
+}
+
+var errPluginEnded = errors.New("response ended by plugin")
+
+// pluginWriter sends each object of a response through plugins as it's
+// written, whether it's a line of a stream, a server-sent event or the
+// whole of a response which isn't streamed.
+type pluginWriter struct {
+	gin.ResponseWriter
+	c            *gin.Context
+	plugins      []*plugin
+	path, tenant string
+	cancel       context.CancelFunc
+
+	buf   bytes.Buffer
+	ended bool
+}
+
+func (w *pluginWriter) Write(b []byte) (int, error) {
+	if w.ended {
+		return 0, errPluginEnded
+	}
+
+	w.buf.Write(b)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+
+		line := bytes.Clone(w.buf.Next(i + 1))
+		if err := w.writeLine(line); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+func (w *pluginWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// flush writes what's left of the response, which has no new line at the
+// end when it isn't streamed.
+func (w *pluginWriter) flush() {
+	if w.buf.Len() > 0 && !w.ended {
+		w.writeLine(bytes.Clone(w.buf.Bytes()))
+	}
+	w.buf.Reset()
+}
+
+func (w *pluginWriter) writeLine(line []byte) error {
+	end := len(bytes.TrimRight(line, "\r\n"))
+	prefix, payload, suffix := []byte(nil), line[:end], line[end:]
+	if p, ok := bytes.CutPrefix(payload, []byte("data: ")); ok {
+		prefix, payload = []byte("data: "), p
+	}
+
+	if len(bytes.TrimSpace(payload)) == 0 || payload[0] != '{' || !json.Valid(payload) {
+		_, err := w.ResponseWriter.Write(line)
+		return err
+	}
+
+	for _, p := range w.plugins {
+		reply, err := p.send(pluginMessage{
+			Hook:      "response",
+			Path:      w.path,
+			RequestID: w.c.GetString(requestIDKey),
+			Tenant:    w.tenant,
+			Body:      payload,
+		})
+		if err != nil {
+			if p.OnFailure == "skip" {
+				slog.Warn("plugin failed, skipping it", "name", p.Name, "hook", "response", "error", err)
+				continue
+			}
+
+			return w.end(http.StatusBadGateway, fmt.Sprintf("plugin %s failed: %v", p.Name, err), prefix, suffix)
+		}
+
+		if reply.Error != "" {
+			return w.end(reply.status(), reply.Error, prefix, suffix)
+		}
+
+		if len(reply.Body) > 0 {
+			payload = reply.Body
+		}
+	}
+
+	_, err := w.ResponseWriter.Write(slices.Concat(prefix, payload, suffix))
+	return err
+}
+
+// end ends the response with an error, with status if nothing has been
+// written yet, and stops the request.
+func (w *pluginWriter) end(status int, msg string, prefix, suffix []byte) error {
+	w.ended = true
+	w.cancel()
+
+	if !w.ResponseWriter.Written() {
+		w.ResponseWriter.WriteHeader(status)
+	}
+
+	bts, err := json.Marshal(gin.H{"error": msg})
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.ResponseWriter.Write(slices.Concat(prefix, bts, suffix)); err != nil {
+		return err
+	}
+
+	return errPluginEnded
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+)
+
+// writePlugin writes a plugin which replies to every message with reply.
+func writePlugin(t *testing.T, dir, name, reply string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	script := "#!/bin/sh\nwhile read -r line; do\n  echo '" + reply + "'\ndone\n"
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestPluginMiddleware(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugins in this test are shell scripts")
+	}
+
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	route := writePlugin(t, dir, "route", `{"body":{"model":"routed"}}`)
+	redact := writePlugin(t, dir, "redact", `{"body":{"response":"[redacted]"}}`)
+	refuse := writePlugin(t, dir, "refuse", `{"error":"not allowed","status":403}`)
+	quiet := writePlugin(t, dir, "quiet", `not json`)
+
+	r := gin.New()
+	r.POST("/api/generate", pluginMiddleware(), func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var req struct {
+			Model  string `json:"model"`
+			Stream *bool  `json:"stream"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Fatal(err)
+		}
+
+		if req.Stream != nil && !*req.Stream {
+			c.JSON(http.StatusOK, gin.H{"model": req.Model, "response": "secret"})
+			return
+		}
+
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Writer.WriteString(`{"model":"` + req.Model + `","response":"a"}` + "\n")
+		c.Writer.WriteString(`{"model":"` + req.Model + `","response":"b"}` + "\n")
+	})
+
+	cases := []struct {
+		name    string
+		plugins string
+		body    string
+		code    int
+		want    string
+	}{
+		{
+			name:    "none",
+			plugins: `[]`,
+			body:    `{"model":"test"}`,
+			code:    http.StatusOK,
+			want:    `{"model":"test","response":"a"}` + "\n" + `{"model":"test","response":"b"}` + "\n",
+		},
+		{
+			name:    "request",
+			plugins: `[{"name":"route","command":["` + route + `"],"hooks":["request"]}]`,
+			body:    `{"model":"test"}`,
+			code:    http.StatusOK,
+			want:    `{"model":"routed","response":"a"}` + "\n" + `{"model":"routed","response":"b"}` + "\n",
+		},
+		{
+			name:    "other path",
+			plugins: `[{"name":"route","command":["` + route + `"],"hooks":["request"],"paths":["/api/chat"]}]`,
+			body:    `{"model":"test"}`,
+			code:    http.StatusOK,
+			want:    `{"model":"test","response":"a"}` + "\n" + `{"model":"test","response":"b"}` + "\n",
+		},
+		{
+			name:    "stream",
+			plugins: `[{"name":"redact","command":["` + redact + `"],"hooks":["response"]}]`,
+			body:    `{"model":"test"}`,
+			code:    http.StatusOK,
+			want:    `{"response":"[redacted]"}` + "\n" + `{"response":"[redacted]"}` + "\n",
+		},
+		{
+			name:    "not streamed",
+			plugins: `[{"name":"redact","command":["` + redact + `"],"hooks":["response"]}]`,
+			body:    `{"model":"test","stream":false}`,
+			code:    http.StatusOK,
+			want:    `{"response":"[redacted]"}`,
+		},
+		{
+			name:    "refused",
+			plugins: `[{"name":"refuse","command":["` + refuse + `"],"hooks":["request"]}]`,
+			body:    `{"model":"test"}`,
+			code:    http.StatusForbidden,
+			want:    `{"error":"not allowed"}`,
+		},
+		{
+			name:    "ended",
+			plugins: `[{"name":"refuse","command":["` + refuse + `"],"hooks":["response"]}]`,
+			body:    `{"model":"test","stream":false}`,
+			code:    http.StatusForbidden,
+			want:    `{"error":"not allowed"}`,
+		},
+		{
+			name:    "failed",
+			plugins: `[{"name":"quiet","command":["` + quiet + `"],"hooks":["request"]}]`,
+			body:    `{"model":"test"}`,
+			code:    http.StatusBadGateway,
+			want:    `{"error":"plugin quiet failed: invalid reply: invalid character 'o' in literal null (expecting 'u')"}`,
+		},
+		{
+			name:    "skipped",
+			plugins: `[{"name":"quiet","command":["` + quiet + `"],"hooks":["request","response"],"on_failure":"skip"}]`,
+			body:    `{"model":"test"}`,
+			code:    http.StatusOK,
+			want:    `{"model":"test","response":"a"}` + "\n" + `{"model":"test","response":"b"}` + "\n",
+		},
+	}
+
+	for i, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			// a new file each time, as a rewritten one may have the same
+			// modification time
+			path := filepath.Join(dir, "plugins"+string(rune('a'+i))+".json")
+			if err := os.WriteFile(path, []byte(tt.plugins), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("OLLAMA_PLUGINS", path)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(tt.body)))
+			if w.Code != tt.code {
+				t.Errorf("expected status code %d, actual %d", tt.code, w.Code)
+			}
+
+			if diff := cmp.Diff(w.Body.String(), tt.want); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+
+	t.Setenv("OLLAMA_PLUGINS", "")
+	if _, err := readPlugins(""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadPlugins(t *testing.T) {
+	dir := t.TempDir()
+	for _, s := range []string{
+		`{}`,
+		`[{"command":["a"],"hooks":["request"]}]`,
+		`[{"name":"a","hooks":["request"]}]`,
+		`[{"name":"a","command":["a"]}]`,
+		`[{"name":"a","command":["a"],"hooks":["routing"]}]`,
+		`[{"name":"a","command":["a"],"hooks":["request"],"on_failure":"retry"}]`,
+	} {
+		path := filepath.Join(dir, "plugins.json")
+		if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := readPlugins(path); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// streamProcessor transforms the content of a response as it's streamed.
+type streamProcessor interface {
+	// add adds s to the response, returning the text which is ready to be
+	// returned
+	add(s string) string
+
+	// flush returns the rest of the response at its end, which stopped for
+	// doneReason
+	flush(doneReason string) string
+}
+
+// postProcessors are the processors which the post_process option enables
+// by name.
+var postProcessors = map[string]func() streamProcessor{
+	"strip_role":          func() streamProcessor { return &roleStripper{} },
+	"trim":                func() streamProcessor { return &trimmer{} },
+	"dedent":              func() streamProcessor { return &dedenter{} },
+	"collapse_whitespace": func() streamProcessor { return &whitespaceCollapser{} },
+	"trim_incomplete":     func() streamProcessor { return &incompleteTrimmer{} },
+}
+
+// postProcessor applies processors to a response in order, each to the
+// output of the one before it.
+type postProcessor []streamProcessor
+
+func newPostProcessor(names []string) (postProcessor, error) {
+	var p postProcessor
+	for _, name := range names {
+		fn, ok := postProcessors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown post-processor %q", name)
+		}
+
+		p = append(p, fn())
+	}
+
+	return p, nil
+}
+
+func (p postProcessor) add(s string) string {
+	for _, sp := range p {
+		s = sp.add(s)
+	}
+
+	return s
+}
+
+func (p postProcessor) flush(doneReason string) string {
+	var s string
+	for _, sp := range p {
+		s = sp.add(s) + sp.flush(doneReason)
+	}
+
+	return s
+}
+
+// roleLabels are the labels which models echo at the start of their
+// responses, followed by a colon.
+var roleLabels = []string{"assistant", "ai", "bot", "model"}
+
+type roleStripperState int
+
+const (
+	roleLookingForLabel roleStripperState = iota
+	roleTrimming
+	roleDone
+)
+
+// roleStripper removes a role label at the start of a response, such as
+// "Assistant:", and the spaces after it.
+type roleStripper struct {
+	state roleStripperState
+
+	// buf is the start of the response which might be a label
+	buf string
+}
+
+func (p *roleStripper) add(s string) string {
+	switch p.state {
+	case roleDone:
+		return s
+	case roleTrimming:
+		s = strings.TrimLeft(s, " \t")
+		if s != "" {
+			p.state = roleDone
+		}
+
+		return s
+	}
+
+	p.buf += s
+	trimmed := strings.TrimLeftFunc(p.buf, unicode.IsSpace)
+	for _, label := range roleLabels {
+		label += ":"
+		if len(trimmed) < len(label) {
+			if strings.EqualFold(trimmed, label[:len(trimmed)]) {
+				// wait for the rest of the label
+				return ""
+			}
+		} else if strings.EqualFold(trimmed[:len(label)], label) {
+			p.buf, p.state = "", roleTrimming
+			return p.add(trimmed[len(label):])
+		}
+	}
+
+	s, p.buf, p.state = p.buf, "", roleDone
+	return s
+}
+
+func (p *roleStripper) flush(string) string {
+	s := p.buf
+	p.buf = ""
+	return s
+}
+
+// trimmer removes the whitespace at the start and end of a response.
+type trimmer struct {
+	started bool
+
+	// space is the whitespace at the end of the response so far
+	space string
+}
+
+func (p *trimmer) add(s string) string {
+	if !p.started {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		if s == "" {
+			return ""
+		}
+
+		p.started = true
+	}
+
+	s = p.space + s
+	trimmed := strings.TrimRightFunc(s, unicode.IsSpace)
+	p.space = s[len(trimmed):]
+	return trimmed
+}
+
+func (p *trimmer) flush(string) string {
+	p.space = ""
+	return ""
+}
+
+// dedenter removes the indentation of the first line of a response which
+// isn't blank from every line, and the whitespace of blank lines.
+type dedenter struct {
+	indent      string
+	indentKnown bool
+
+	// mid is whether the response is in the middle of a line, and spaces is
+	// the indentation of the line so far if it isn't
+	mid    bool
+	spaces string
+}
+
+func (p *dedenter) add(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case p.mid:
+			sb.WriteRune(r)
+			p.mid = r != '\n'
+		case r == ' ' || r == '\t':
+			p.spaces += string(r)
+		case r == '\n':
+			sb.WriteRune(r)
+			p.spaces = ""
+		default:
+			if !p.indentKnown {
+				p.indent, p.indentKnown = p.spaces, true
+			}
+
+			n := 0
+			for n < len(p.spaces) && n < len(p.indent) && p.spaces[n] == p.indent[n] {
+				n++
+			}
+
+			sb.WriteString(p.spaces[n:])
+			sb.WriteRune(r)
+			p.spaces, p.mid = "", true
+		}
+	}
+
+	return sb.String()
+}
+
+func (p *dedenter) flush(string) string {
+	p.spaces = ""
+	return ""
+}
+
+// whitespaceCollapser collapses runs of spaces in lines to a single space,
+// keeping the indentation of lines, and runs of blank lines to a single
+// blank line.
+type whitespaceCollapser struct {
+	// mid is whether the response is in the middle of a line
+	mid bool
+
+	// space is the whitespace which hasn't been returned yet
+	space string
+}
+
+func (p *whitespaceCollapser) add(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			p.space += string(r)
+			continue
+		}
+
+		sb.WriteString(p.collapse())
+		sb.WriteRune(r)
+		p.mid = true
+	}
+
+	return sb.String()
+}
+
+func (p *whitespaceCollapser) flush(string) string {
+	return p.collapse()
+}
+
+func (p *whitespaceCollapser) collapse() string {
+	s := p.space
+	p.space = ""
+
+	n := strings.Count(s, "\n")
+	switch {
+	case n > 0:
+		// keep the indentation of the next line
+		p.mid = false
+		return strings.Repeat("\n", min(n, 2)) + s[strings.LastIndex(s, "\n")+1:]
+	case p.mid && s != "":
+		return " "
+	default:
+		return s
+	}
+}
+
+var reSentenceEnd = regexp.MustCompile(`[.!?]["')\]]*\s+|\n`)
+
+// incompleteTrimmer removes the incomplete sentence at the end of responses
+// which stopped at their length limit. It holds back the response until
+// each sentence ends, and keeps responses without a complete sentence.
+type incompleteTrimmer struct {
+	buf      string
+	sentence bool
+}
+
+func (p *incompleteTrimmer) add(s string) string {
+	p.buf += s
+
+	ends := reSentenceEnd.FindAllStringIndex(p.buf, -1)
+	if len(ends) == 0 {
+		return ""
+	}
+
+	end := ends[len(ends)-1][1]
+	s, p.buf, p.sentence = p.buf[:end], p.buf[end:], true
+	return s
+}
+
+func (p *incompleteTrimmer) flush(doneReason string) string {
+	s := p.buf
+	p.buf = ""
+
+	if doneReason == "length" && p.sentence {
+		return ""
+	}
+
+	return s
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPostProcessor(t *testing.T) {
+	cases := []struct {
+		name       string
+		processors []string
+		chunks     []string
+		doneReason string
+		expect     string
+	}{
+		{
+			name:   "none",
+			chunks: []string{"Assistant:  Hi!  ", "\n\n\n"},
+			expect: "Assistant:  Hi!  \n\n\n",
+		},
+		{
+			name:       "strip role",
+			processors: []string{"strip_role"},
+			chunks:     []string{"\nAssis", "tant", ":", " ", " Hi! AI: hello"},
+			expect:     "Hi! AI: hello",
+		},
+		{
+			name:       "strip role without label",
+			processors: []string{"strip_role"},
+			chunks:     []string{" A", "n apple"},
+			expect:     " An apple",
+		},
+		{
+			name:       "strip role short response",
+			processors: []string{"strip_role"},
+			chunks:     []string{"Bo"},
+			expect:     "Bo",
+		},
+		{
+			name:       "trim",
+			processors: []string{"trim"},
+			chunks:     []string{"\n\n ", " Hello ", " world", " \n"},
+			expect:     "Hello  world",
+		},
+		{
+			name:       "dedent",
+			processors: []string{"dedent"},
+			chunks:     []string{"\n    def f():\n", "        return 1\n  \n", "  x\n"},
+			expect:     "\ndef f():\n    return 1\n\nx\n",
+		},
+		{
+			name:       "collapse whitespace",
+			processors: []string{"collapse_whitespace"},
+			chunks:     []string{"Hello  ", "  world!  \n\n", "\n\n  - item\tone"},
+			expect:     "Hello world!\n\n  - item one",
+		},
+		{
+			name:       "trim incomplete",
+			processors: []string{"trim_incomplete"},
+			chunks:     []string{"It's blue. The sky", " scatters (mostly) light!", " Because of"},
+			doneReason: "length",
+			expect:     "It's blue. The sky scatters (mostly) light! ",
+		},
+		{
+			name:       "trim incomplete stopped",
+			processors: []string{"trim_incomplete"},
+			chunks:     []string{"It's blue. The sky", " scatters"},
+			doneReason: "stop",
+			expect:     "It's blue. The sky scatters",
+		},
+		{
+			name:       "trim incomplete without sentence",
+			processors: []string{"trim_incomplete"},
+			chunks:     []string{"It's blue because"},
+			doneReason: "length",
+			expect:     "It's blue because",
+		},
+		{
+			name:       "pipeline",
+			processors: []string{"strip_role", "trim_incomplete", "collapse_whitespace", "trim"},
+			chunks:     []string{"AI: ", " It's", "  blue.  The", " sky"},
+			doneReason: "length",
+			expect:     "It's blue.",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newPostProcessor(tt.processors)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var sb strings.Builder
+			for _, chunk := range tt.chunks {
+				sb.WriteString(p.add(chunk))
+			}
+			sb.WriteString(p.flush(tt.doneReason))
+
+			if sb.String() != tt.expect {
+				t.Errorf("expected %q, got %q", tt.expect, sb.String())
+			}
+		})
+	}
+
+	if _, err := newPostProcessor([]string{"trim", "uppercase"}); err == nil {
+		t.Error("expected error for unknown post-processor")
+	}
+}
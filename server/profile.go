@@ -0,0 +1,327 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
+)
+
+// vramProfile is the VRAM a model was measured to take on a GPU the first
+// time it was loaded on it, along with what it was estimated to take.
+// Estimates are often off for new architectures, so later loads of the model
+// on the same kind of GPU are scheduled by how far off they were.
+type vramProfile struct {
+	Model       string    `json:"model"`
+	Digest      string    `json:"digest"`
+	Library     string    `json:"library"`
+	GPU         string    `json:"gpu"`
+	NumCtx      int       `json:"num_ctx"`
+	NumParallel int       `json:"num_parallel"`
+	Estimated   uint64    `json:"estimated"`
+	Measured    uint64    `json:"measured"`
+	MeasuredAt  time.Time `json:"measured_at"`
+}
+
+// Profiles scale estimates by at most this factor either way, so a
+// measurement thrown off by another process allocating or freeing VRAM
+// during the load can't make a model look tiny or huge.
+const (
+	minProfileScale = 0.5
+	maxProfileScale = 2
+)
+
+// scale is the factor the estimated VRAM of the model is off by on the GPU.
+func (p vramProfile) scale() float64 {
+	return min(max(float64(p.Measured)/float64(p.Estimated), minProfileScale), maxProfileScale)
+}
+
+// profilesMu guards the file of VRAM profiles.
+var profilesMu sync.Mutex
+
+func profilesPath() string {
+	return filepath.Join(envconfig.Models(), "vram-profiles.json")
+}
+
+// gpuName identifies the kind of a GPU, so that a profile measured on one
+// GPU applies to others of the same model.
+func gpuName(gpu discover.GpuInfo) string {
+	if gpu.Name != "" {
+		return gpu.Name
+	}
+
+	return gpu.ID
+}
+
+func profileKey(digest string, gpu discover.GpuInfo) string {
+	return digest + " " + gpu.Library + " " + gpuName(gpu)
+}
+
+func readProfiles() (map[string]vramProfile, error) {
+	profiles := make(map[string]vramProfile)
+
+	bts, err := os.ReadFile(profilesPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return profiles, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bts, &profiles); err != nil {
+		slog.Warn("ignoring invalid vram profiles", "path", profilesPath(), "error", err)
+		return make(map[string]vramProfile), nil
+	}
+
+	return profiles, nil
+}
+
+func writeProfiles(profiles map[string]vramProfile) error {
+	bts, err := json.Marshal(profiles)
+	if err != nil {
+		return err
+	}
+
+	p := profilesPath()
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(p+".tmp", bts, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(p+".tmp", p)
+}
+
+// loadProfiles returns the profiles, or none if they can't be read so that
+// scheduling falls back to estimates.
+func loadProfiles() map[string]vramProfile {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+
+	profiles, err := readProfiles()
+	if err != nil {
+		slog.Warn("failed to read vram profiles", "error", err)
+		return nil
+	}
+
+	return profiles
+}
+
+// recordProfiles adds profiles, keeping those already recorded for the same
+// model and GPU.
+func recordProfiles(measured []vramProfile, gpus discover.GpuInfoList) error {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+
+	profiles, err := readProfiles()
+	if err != nil {
+		return err
+	}
+
+	for i, p := range measured {
+		key := profileKey(p.Digest, gpus[i])
+		if _, ok := profiles[key]; !ok {
+			profiles[key] = p
+		}
+	}
+
+	return writeProfiles(profiles)
+}
+
+// deleteProfiles deletes the profiles of the model with digest, or all
+// profiles if digest is empty. It returns how many were deleted.
+func deleteProfiles(digest string) (int, error) {
+	profilesMu.Lock()
+	defer profilesMu.Unlock()
+
+	profiles, err := readProfiles()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for key, p := range profiles {
+		if digest == "" || p.Digest == digest {
+			delete(profiles, key)
+			n++
+		}
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	return n, writeProfiles(profiles)
+}
+
+// canMeasureVRAM reports whether the VRAM used by a model loaded on gpus can
+// be measured from their free memory.
+func canMeasureVRAM(gpus discover.GpuInfoList) bool {
+	if len(gpus) == 0 || gpus[0].Library == "cpu" || gpus[0].Library == "metal" {
+		return false
+	}
+
+	// windows can page VRAM, only cuda reports the VRAM actually in use
+	if runtime.GOOS == "windows" && gpus[0].Library != "cuda" {
+		return false
+	}
+
+	for _, gpu := range gpus {
+		if gpu.UnreliableFreeMemory {
+			return false
+		}
+	}
+
+	return true
+}
+
+// profiledGPUs returns a copy of gpus with their free memory scaled by how
+// far off the estimated VRAM of m was when it was measured on them, so
+// that fitting m with its estimate fits what it really takes.
+func profiledGPUs(m *Model, gpus discover.GpuInfoList) discover.GpuInfoList {
+	if len(gpus) == 0 || gpus[0].Library == "cpu" {
+		return gpus
+	}
+
+	profiles := loadProfiles()
+	if len(profiles) == 0 {
+		return gpus
+	}
+
+	scaled := append(discover.GpuInfoList{}, gpus...)
+	for i, gpu := range scaled {
+		p, ok := profiles[profileKey(m.Digest, gpu)]
+		if !ok {
+			continue
+		}
+
+		scaled[i].FreeMemory = min(uint64(float64(gpu.FreeMemory)/p.scale()), gpu.TotalMemory)
+		slog.Debug("scaled free memory by vram profile", "model", m.ShortName, "gpu", gpu.ID, "library", gpu.Library,
+			"estimated", format.HumanBytes2(p.Estimated), "measured", format.HumanBytes2(p.Measured),
+			"available", format.HumanBytes2(gpu.FreeMemory), "scaled", format.HumanBytes2(scaled[i].FreeMemory))
+	}
+
+	return scaled
+}
+
+// profiledVRAM returns the VRAM the runner takes on each of its GPUs, which
+// is its estimate scaled by the profiles of its model.
+func profiledVRAM(runner *runnerRef) map[string]uint64 {
+	profiles := loadProfiles()
+	if len(profiles) == 0 {
+		return nil
+	}
+
+	var vram map[string]uint64
+	for _, gpu := range runner.gpus {
+		p, ok := profiles[profileKey(runner.model.Digest, gpu)]
+		if !ok {
+			continue
+		}
+
+		if vram == nil {
+			vram = make(map[string]uint64)
+		}
+		vram[gpu.ID] = uint64(float64(runner.llama.EstimatedVRAMByGPU(gpu.ID)) * p.scale())
+	}
+
+	return vram
+}
+
+// needsCalibration reports whether the VRAM of m should be measured when
+// it's loaded on gpus, which is the first time it's loaded on any of them.
+func needsCalibration(m *Model, gpus discover.GpuInfoList) bool {
+	if m.Digest == "" || !canMeasureVRAM(gpus) {
+		return false
+	}
+
+	profiles := loadProfiles()
+	if profiles == nil {
+		return false
+	}
+
+	for _, gpu := range gpus {
+		if _, ok := profiles[profileKey(m.Digest, gpu)]; !ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// calibrate measures the VRAM the runner takes on each of its GPUs as the
+// free memory it had before the runner loaded, less what it has after, and
+// records the profiles of GPUs which don't have one. The runner accounts
+// for the measured VRAM from then on rather than its estimate.
+//
+// The runner's refMu must be held.
+func (s *Scheduler) calibrate(runner *runnerRef, before discover.GpuInfoList) {
+	after := s.getGpuFn()
+
+	free := func(gpus discover.GpuInfoList, gpu discover.GpuInfo) (uint64, bool) {
+		for _, g := range gpus {
+			if g.Library == gpu.Library && g.ID == gpu.ID {
+				return g.FreeMemory, true
+			}
+		}
+		return 0, false
+	}
+
+	var measured []vramProfile
+	var gpus discover.GpuInfoList
+	for _, gpu := range runner.gpus {
+		freeBefore, ok := free(before, gpu)
+		if !ok {
+			continue
+		}
+
+		freeAfter, ok := free(after, gpu)
+		if !ok || freeAfter >= freeBefore {
+			continue
+		}
+
+		estimated := runner.llama.EstimatedVRAMByGPU(gpu.ID)
+		if estimated == 0 {
+			continue
+		}
+
+		used := freeBefore - freeAfter
+		if runner.vram == nil {
+			runner.vram = make(map[string]uint64)
+		}
+		runner.vram[gpu.ID] = used
+
+		slog.Info("measured vram", "model", runner.model.ShortName, "gpu", gpu.ID, "library", gpu.Library,
+			"estimated", format.HumanBytes2(estimated), "measured", format.HumanBytes2(used))
+
+		measured = append(measured, vramProfile{
+			Model:       runner.model.ShortName,
+			Digest:      runner.model.Digest,
+			Library:     gpu.Library,
+			GPU:         gpuName(gpu),
+			NumCtx:      runner.Options.NumCtx / runner.numParallel,
+			NumParallel: runner.numParallel,
+			Estimated:   estimated,
+			Measured:    used,
+			MeasuredAt:  time.Now().UTC(),
+		})
+		gpus = append(gpus, gpu)
+	}
+
+	if len(measured) == 0 {
+		return
+	}
+
+	if err := recordProfiles(measured, gpus); err != nil {
+		slog.Warn("failed to record vram profiles", "model", runner.model.ShortName, "error", err)
+	}
+}
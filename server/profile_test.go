@@ -0,0 +1,215 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/llm"
+)
+
+type estimateRunner struct {
+	llm.LlamaServer
+	vram map[string]uint64
+}
+
+func (r *estimateRunner) EstimatedVRAMByGPU(id string) uint64 { return r.vram[id] }
+
+func testGPU(id, name string, free uint64) discover.GpuInfo {
+	var gpu discover.GpuInfo
+	gpu.Library = "cuda"
+	gpu.ID = id
+	gpu.Name = name
+	gpu.TotalMemory = 24 * format.GibiByte
+	gpu.FreeMemory = free
+	return gpu
+}
+
+func TestCalibrate(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	m := &Model{ShortName: "test:latest", Digest: "abc"}
+	before := discover.GpuInfoList{
+		testGPU("0", "NVIDIA A", 20*format.GibiByte),
+		testGPU("1", "NVIDIA B", 20*format.GibiByte),
+	}
+
+	if !needsCalibration(m, before) {
+		t.Fatal("expected a model without profiles to need calibration")
+	}
+
+	if needsCalibration(m, discover.GetCPUInfo()) {
+		t.Error("expected no calibration on cpu")
+	}
+
+	// the model takes 6GiB on the first GPU where it was estimated at 4GiB
+	// and 2GiB on the second as estimated
+	after := discover.GpuInfoList{
+		testGPU("0", "NVIDIA A", 14*format.GibiByte),
+		testGPU("1", "NVIDIA B", 18*format.GibiByte),
+	}
+
+	s := &Scheduler{getGpuFn: func() discover.GpuInfoList { return after }}
+	runner := &runnerRef{
+		model:       m,
+		gpus:        before,
+		numParallel: 2,
+		Options:     &api.Options{Runner: api.Runner{NumCtx: 8192}},
+		llama: &estimateRunner{vram: map[string]uint64{
+			"0": 4 * format.GibiByte,
+			"1": 2 * format.GibiByte,
+		}},
+	}
+
+	s.calibrate(runner, before)
+
+	if diff := cmp.Diff(map[string]uint64{"0": 6 * format.GibiByte, "1": 2 * format.GibiByte}, runner.vram); diff != "" {
+		t.Errorf("measured vram mismatch (-want +got):\n%s", diff)
+	}
+
+	if needsCalibration(m, before) {
+		t.Error("expected no calibration once the model is profiled")
+	}
+
+	gpus := profiledGPUs(m, before)
+	if got, want := gpus[0].FreeMemory, uint64(float64(before[0].FreeMemory)/1.5); got != want {
+		t.Errorf("expected %d free on the first GPU, got %d", want, got)
+	}
+
+	if gpus[1].FreeMemory != 20*format.GibiByte {
+		t.Errorf("expected the free memory of the second GPU to be unchanged, got %d", gpus[1].FreeMemory)
+	}
+
+	if before[0].FreeMemory != 20*format.GibiByte {
+		t.Error("expected the GPUs passed in to be unchanged")
+	}
+
+	// another GPU of the same kind uses the profile
+	other := profiledGPUs(m, discover.GpuInfoList{testGPU("2", "NVIDIA A", 12*format.GibiByte)})
+	if other[0].FreeMemory != 8*format.GibiByte {
+		t.Errorf("expected 8GiB free on a GPU of the same kind, got %d", other[0].FreeMemory)
+	}
+
+	// a later load accounts for the profiled VRAM
+	runner.vram = nil
+	if diff := cmp.Diff(map[string]uint64{"0": 6 * format.GibiByte, "1": 2 * format.GibiByte}, profiledVRAM(runner)); diff != "" {
+		t.Errorf("profiled vram mismatch (-want +got):\n%s", diff)
+	}
+
+	profiles := loadProfiles()
+	p := profiles[profileKey("abc", before[0])]
+	if p.NumCtx != 4096 || p.NumParallel != 2 || p.Model != "test:latest" {
+		t.Errorf("unexpected profile %+v", p)
+	}
+}
+
+func TestProfileScale(t *testing.T) {
+	cases := []struct {
+		estimated, measured uint64
+		scale               float64
+	}{
+		{4, 6, 1.5},
+		{4, 2, 0.5},
+		{4, 1, minProfileScale},
+		{4, 40, maxProfileScale},
+	}
+
+	for _, tt := range cases {
+		p := vramProfile{Estimated: tt.estimated, Measured: tt.measured}
+		if got := p.scale(); got != tt.scale {
+			t.Errorf("estimated %d measured %d: expected scale %v, got %v", tt.estimated, tt.measured, tt.scale, got)
+		}
+	}
+}
+
+func TestProfilesHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+	_, digest := createBinFile(t, nil, nil)
+	if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	}); w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gpus := discover.GpuInfoList{testGPU("0", "NVIDIA A", 0), testGPU("1", "NVIDIA B", 0)}
+	if err := recordProfiles([]vramProfile{
+		{Model: "test:latest", Digest: m.Digest, Library: "cuda", GPU: "NVIDIA A", Estimated: 4, Measured: 6},
+		{Model: "other:latest", Digest: "other", Library: "cuda", GPU: "NVIDIA B", Estimated: 4, Measured: 3},
+	}, gpus); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func() []string {
+		t.Helper()
+
+		w := createRequest(t, s.ListProfilesHandler, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		var resp api.ListProfilesResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		models := []string{}
+		for _, p := range resp.Profiles {
+			models = append(models, p.Model+" "+p.GPU)
+		}
+		return models
+	}
+
+	if diff := cmp.Diff([]string{"other:latest NVIDIA B", "test:latest NVIDIA A"}, list()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	deleteProfiles := func(model string) (int, int) {
+		t.Helper()
+
+		w := createRequest(t, s.DeleteProfilesHandler, api.DeleteProfilesRequest{Model: model})
+		var resp api.DeleteProfilesResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, resp.Deleted
+	}
+
+	if code, _ := deleteProfiles("missing"); code != http.StatusNotFound {
+		t.Errorf("expected status code 404, actual %d", code)
+	}
+
+	if code, deleted := deleteProfiles("test"); code != http.StatusOK || deleted != 1 {
+		t.Errorf("expected 1 profile deleted, got %d with status code %d", deleted, code)
+	}
+
+	if diff := cmp.Diff([]string{"other:latest NVIDIA B"}, list()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if code, deleted := deleteProfiles(""); code != http.StatusOK || deleted != 1 {
+		t.Errorf("expected 1 profile deleted, got %d with status code %d", deleted, code)
+	}
+
+	if diff := cmp.Diff([]string{}, list()); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -3,29 +3,37 @@ package server
 import (
 	"bytes"
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"log/slog"
-	"strings"
+	"regexp"
+	"slices"
+	"strconv"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/llm"
-	"github.com/ollama/ollama/model/models/mllama"
+	"github.com/ollama/ollama/model/audioproc"
+	"github.com/ollama/ollama/model/models/qwen2vl"
 	"github.com/ollama/ollama/template"
 )
 
 type tokenizeFunc func(context.Context, string) ([]int, error)
 
-var errTooManyImages = errors.New("vision model only supports a single image per message")
+var (
+	errTooManyImages = errors.New("vision model only supports a single image per message")
+	errInvalidAudio  = errors.New("invalid audio")
+
+	errInvalidImagePlaceholder = errors.New("invalid image placeholder")
+)
 
 // chatPrompt accepts a list of messages and returns the prompt and images that should be used for the next chat turn.
 // chatPrompt truncates any messages that exceed the context window of the model, making sure to always include 1) the
 // latest message and 2) system messages
-func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.Options, msgs []api.Message, tools []api.Tool) (prompt string, images []llm.ImageData, _ error) {
+func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.Options, msgs []api.Message, tools []api.Tool, think bool) (prompt string, images []llm.ImageData, _ error) {
 	var system []api.Message
 
 	isMllama := checkMllamaModelFamily(m)
+	isQwen2VL := checkModelFamily(m, "qwen2vl")
 
 	var imageNumTokens int
 	// TODO: Ideally we would compute this from the projector metadata but some pieces are implementation dependent
@@ -57,7 +65,7 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 		}
 
 		var b bytes.Buffer
-		if err := m.Template.Execute(&b, template.Values{Messages: append(system, msgs[i:]...), Tools: tools}); err != nil {
+		if err := m.Template.Execute(&b, template.Values{Messages: append(system, msgs[i:]...), Tools: tools, Think: think}); err != nil {
 			return "", nil, err
 		}
 
@@ -67,7 +75,19 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 		}
 
 		ctxLen := len(s)
-		if m.ProjectorPaths != nil {
+		if isQwen2VL {
+			// Qwen2-VL images take a token for each of their embeddings,
+			// which depends on their size, and one each to start and end them
+			for _, m := range msgs[i:] {
+				for _, image := range m.Images {
+					numTokens, err := qwen2vl.NumTokens(bytes.NewReader(image))
+					if err != nil {
+						return "", nil, err
+					}
+					ctxLen += numTokens + 2
+				}
+			}
+		} else if m.ProjectorPaths != nil {
 			for _, m := range msgs[i:] {
 				ctxLen += imageNumTokens * len(m.Images)
 			}
@@ -84,76 +104,123 @@ func chatPrompt(ctx context.Context, m *Model, tokenize tokenizeFunc, opts *api.
 	currMsgIdx := n
 
 	for cnt, msg := range msgs[currMsgIdx:] {
-		prefix := ""
-		imgPrompt := ""
-		prompt := msg.Content
-
+		var ids []int
 		for _, i := range msg.Images {
-			var imgData llm.ImageData
+			imgData, err := prepareImage(m, len(images), i)
+			if err != nil {
+				return "", nil, err
+			}
 
-			if isMllama {
-				if len(m.ProjectorPaths) == 0 {
-					imgData = llm.ImageData{
-						ID:   len(images),
-						Data: i,
-					}
-				} else {
-					data, opts, err := mllama.Preprocess(bytes.NewReader(i))
-					if err != nil {
-						return "", nil, err
-					}
+			ids = append(ids, imgData.ID)
+			images = append(images, imgData)
+		}
 
-					buf := new(bytes.Buffer)
-					err = binary.Write(buf, binary.LittleEndian, data)
-					if err != nil {
-						return "", nil, err
-					}
+		imgPrompt := ""
+		if isMllama {
+			imgPrompt = "<|image|>"
+		}
 
-					ar, ok := opts["aspectRatioIndex"].(int)
-					if !ok {
-						return "", nil, fmt.Errorf("missing aspect ratio for image")
-					}
+		prefix, prompt, err := placeImages(msg.Content, ids, imgPrompt)
+		if err != nil {
+			return "", nil, err
+		}
 
-					imgData = llm.ImageData{
-						ID:            len(images),
-						Data:          buf.Bytes(),
-						AspectRatioID: ar,
-					}
-				}
-				imgPrompt = "<|image|>"
-			} else {
-				imgData = llm.ImageData{
-					ID:   len(images),
-					Data: i,
-				}
+		// audio is passed to the runner alongside images, and models which
+		// take both tell them apart by their data
+		for _, a := range msg.Audio {
+			if _, _, err := audioproc.Decode(bytes.NewReader(a)); err != nil {
+				return "", nil, fmt.Errorf("%w: %w", errInvalidAudio, err)
 			}
 
-			imgTag := fmt.Sprintf("[img-%d]", imgData.ID)
-			if !strings.Contains(prompt, "[img]") {
-				prefix += imgTag
-			} else {
-				prompt = strings.Replace(prompt, "[img]", imgTag, 1)
+			imgData := llm.ImageData{
+				ID:   len(images),
+				Data: a,
 			}
 
+			prefix += fmt.Sprintf("[img-%d]", imgData.ID)
 			images = append(images, imgData)
 		}
-		msgs[currMsgIdx+cnt].Content = prefix + imgPrompt + prompt
+		msgs[currMsgIdx+cnt].Content = prefix + prompt
 	}
 
 	// truncate any messages that do not fit into the context window
 	var b bytes.Buffer
-	if err := m.Template.Execute(&b, template.Values{Messages: append(system, msgs[currMsgIdx:]...), Tools: tools}); err != nil {
+	if err := m.Template.Execute(&b, template.Values{Messages: append(system, msgs[currMsgIdx:]...), Tools: tools, Think: think}); err != nil {
 		return "", nil, err
 	}
 
 	return b.String(), images, nil
 }
 
-func checkMllamaModelFamily(m *Model) bool {
-	for _, arch := range m.Config.ModelFamilies {
-		if arch == "mllama" {
-			return true
+// imagePlaceholder matches the placeholders which place the images of a
+// message in its content: [img-0] for its first image, [img-1] for its second
+// and so on, or [img] for its next image which hasn't been placed yet.
+var imagePlaceholder = regexp.MustCompile(`\[img(?:-(\d+))?\]`)
+
+// placeImages replaces the image placeholders in content with the tags of the
+// images of its message, whose IDs are ids, each followed by imgPrompt. The
+// tags of images without a placeholder are returned separately, in order, to
+// go before the content. Placing an image twice or one that doesn't exist is
+// an error.
+func placeImages(content string, ids []int, imgPrompt string) (prefix, _ string, err error) {
+	if len(ids) == 0 {
+		return "", content, nil
+	}
+
+	placed := make([]bool, len(ids))
+	tag := func(i int) string {
+		placed[i] = true
+		return fmt.Sprintf("[img-%d]", ids[i]) + imgPrompt
+	}
+
+	next := 0
+	content = imagePlaceholder.ReplaceAllStringFunc(content, func(s string) string {
+		if err != nil {
+			return s
+		}
+
+		match := imagePlaceholder.FindStringSubmatch(s)
+		if match[1] == "" {
+			for next < len(ids) && placed[next] {
+				next++
+			}
+
+			if next == len(ids) {
+				err = fmt.Errorf("%w: %s without an image left to place", errInvalidImagePlaceholder, s)
+				return s
+			}
+
+			return tag(next)
+		}
+
+		i, _ := strconv.Atoi(match[1])
+		if i >= len(ids) {
+			err = fmt.Errorf("%w: %s but the message has %d images", errInvalidImagePlaceholder, s, len(ids))
+			return s
+		} else if placed[i] {
+			err = fmt.Errorf("%w: image %d is placed more than once", errInvalidImagePlaceholder, i)
+			return s
+		}
+
+		return tag(i)
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	for i := range ids {
+		if !placed[i] {
+			prefix += tag(i)
 		}
 	}
-	return false
+
+	return prefix, content, nil
+}
+
+func checkMllamaModelFamily(m *Model) bool {
+	return checkModelFamily(m, "mllama")
+}
+
+func checkModelFamily(m *Model, family string) bool {
+	return slices.Contains(m.Config.ModelFamilies, family)
 }
@@ -3,6 +3,8 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
+	"errors"
 	"image"
 	"image/png"
 	"testing"
@@ -52,6 +54,17 @@ func TestChatPrompt(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// a WAV file of 16-bit mono samples
+	var wavBuf bytes.Buffer
+	wavBuf.WriteString("RIFF")
+	binary.Write(&wavBuf, binary.LittleEndian, uint32(40))
+	wavBuf.WriteString("WAVEfmt ")
+	for _, v := range []any{uint32(16), uint16(1), uint16(1), uint32(16000), uint32(32000), uint16(2), uint16(16)} {
+		binary.Write(&wavBuf, binary.LittleEndian, v)
+	}
+	wavBuf.WriteString("data")
+	binary.Write(&wavBuf, binary.LittleEndian, []uint32{4, 0x7fff8000})
+
 	cases := []struct {
 		name  string
 		model Model
@@ -228,6 +241,20 @@ func TestChatPrompt(t *testing.T) {
 				images: [][]byte{[]byte("one hotdog"), []byte("two hotdogs")},
 			},
 		},
+		{
+			name:  "messages with placed images",
+			model: visionModel,
+			limit: 4096,
+			msgs: []api.Message{
+				{Role: "user", Content: "You're a test, Harry!", Images: []api.ImageData{[]byte("something")}},
+				{Role: "assistant", Content: "I-I'm a what?"},
+				{Role: "user", Content: "Is [img-1] a test like [img-0]?", Images: []api.ImageData{[]byte("one test"), []byte("two tests")}},
+			},
+			expect: expect{
+				prompt: "[img-0]You're a test, Harry! I-I'm a what? Is [img-2] a test like [img-1]? ",
+				images: [][]byte{[]byte("something"), []byte("one test"), []byte("two tests")},
+			},
+		},
 		{
 			name:  "messages with mllama (no images)",
 			model: mllamaModel,
@@ -312,13 +339,27 @@ func TestChatPrompt(t *testing.T) {
 				error: errTooManyImages,
 			},
 		},
+		{
+			name:  "messages with audio",
+			model: visionModel,
+			limit: 2048,
+			msgs: []api.Message{
+				{Role: "user", Content: "You're a test, Harry!", Images: []api.ImageData{imgBuf}, Audio: []api.AudioData{wavBuf.Bytes()}},
+				{Role: "assistant", Content: "I-I'm a what?"},
+				{Role: "user", Content: "A test. And a thumping good one at that, I'd wager.", Audio: []api.AudioData{wavBuf.Bytes()}},
+			},
+			expect: expect{
+				prompt: "[img-0][img-1]You're a test, Harry! I-I'm a what? [img-2]A test. And a thumping good one at that, I'd wager. ",
+				images: [][]byte{imgBuf, wavBuf.Bytes(), wavBuf.Bytes()},
+			},
+		},
 	}
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
 			model := tt.model
 			opts := api.Options{Runner: api.Runner{NumCtx: tt.limit}}
-			prompt, images, err := chatPrompt(context.TODO(), &model, mockRunner{}.Tokenize, &opts, tt.msgs, nil)
+			prompt, images, err := chatPrompt(context.TODO(), &model, mockRunner{}.Tokenize, &opts, tt.msgs, nil, true)
 			if tt.error == nil && err != nil {
 				t.Fatal(err)
 			} else if tt.error != nil && err != tt.error {
@@ -351,3 +392,44 @@ func TestChatPrompt(t *testing.T) {
 		})
 	}
 }
+
+func TestPlaceImages(t *testing.T) {
+	cases := []struct {
+		name      string
+		content   string
+		ids       []int
+		imgPrompt string
+		prefix    string
+		want      string
+		err       bool
+	}{
+		{name: "no images", content: "[img-0] stays", want: "[img-0] stays"},
+		{name: "prefix", content: "look", ids: []int{3, 4}, prefix: "[img-3][img-4]", want: "look"},
+		{name: "numbered", content: "[img-1] then [img-0]", ids: []int{3, 4}, want: "[img-4] then [img-3]"},
+		{name: "next", content: "[img] then [img]", ids: []int{3, 4}, want: "[img-3] then [img-4]"},
+		{name: "next skips placed", content: "[img-0] then [img]", ids: []int{3, 4}, want: "[img-3] then [img-4]"},
+		{name: "some placed", content: "a [img-2] b", ids: []int{3, 4, 5}, prefix: "[img-3][img-4]", want: "a [img-5] b"},
+		{name: "image prompt", content: "see [img-0]", ids: []int{0}, imgPrompt: "<|image|>", want: "see [img-0]<|image|>"},
+		{name: "out of range", content: "[img-2]", ids: []int{3, 4}, err: true},
+		{name: "twice", content: "[img-0] [img-0]", ids: []int{3, 4}, err: true},
+		{name: "too many next", content: "[img] [img]", ids: []int{3}, err: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, got, err := placeImages(tt.content, tt.ids, tt.imgPrompt)
+			if tt.err {
+				if !errors.Is(err, errInvalidImagePlaceholder) {
+					t.Fatalf("expected invalid placeholder, got %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if prefix != tt.prefix || got != tt.want {
+				t.Errorf("expected %q, %q, got %q, %q", tt.prefix, tt.want, prefix, got)
+			}
+		})
+	}
+}
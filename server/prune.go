@@ -0,0 +1,419 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/types/model"
+)
+
+// prunePolicy selects models to remove from the model store. Rules which are
+// zero are disabled and a model is removed if any rule selects it.
+type prunePolicy struct {
+	// UnusedFor removes models which haven't been used for this long.
+	UnusedFor time.Duration
+	// KeepTags keeps only this many of the most recently used tags of each
+	// repository.
+	KeepTags int
+	// MaxSize removes the least recently used models until the blobs of the
+	// remaining models take up at most this many bytes.
+	MaxSize int64
+}
+
+func (p prunePolicy) enabled() bool {
+	return p.UnusedFor > 0 || p.KeepTags > 0 || p.MaxSize > 0
+}
+
+// pruneModels removes the models selected by policy along with any blobs no
+// other model uses. Models for which keep returns true are never removed.
+// It returns the removed models, least recently used first, and the number
+// of bytes freed. If dryRun is set, nothing is removed.
+func pruneModels(policy prunePolicy, now time.Time, keep func(model.Name) bool, dryRun bool) ([]model.Name, int64, error) {
+	if !dryRun {
+		unlock, err := lockStoreExclusive()
+		if err != nil {
+			return nil, 0, err
+		}
+		defer unlock()
+	}
+
+	ms, err := Manifests(true)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	used, err := readLastUsed()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	lastUsed := make(map[model.Name]time.Time, len(ms))
+	for n, m := range ms {
+		lastUsed[n] = m.fi.ModTime()
+		if t, ok := used[lastUsedKey(n)]; ok && t.After(lastUsed[n]) {
+			lastUsed[n] = t
+		}
+	}
+
+	// least recently used first
+	names := slices.SortedFunc(maps.Keys(ms), func(a, b model.Name) int {
+		return lastUsed[a].Compare(lastUsed[b])
+	})
+
+	remove := make(map[model.Name]bool)
+	mark := func(n model.Name) bool {
+		if remove[n] || keep != nil && keep(n) {
+			return false
+		}
+
+		remove[n] = true
+		return true
+	}
+
+	if policy.UnusedFor > 0 {
+		for _, n := range names {
+			if now.Sub(lastUsed[n]) > policy.UnusedFor {
+				mark(n)
+			}
+		}
+	}
+
+	if policy.KeepTags > 0 {
+		repositories := make(map[string][]model.Name)
+		for _, n := range names {
+			repository := strings.ToLower(strings.Join([]string{n.Host, n.Namespace, n.Model}, "/"))
+			repositories[repository] = append(repositories[repository], n)
+		}
+
+		for _, tags := range repositories {
+			for _, n := range tags[:max(len(tags)-policy.KeepTags, 0)] {
+				mark(n)
+			}
+		}
+	}
+
+	// refs counts the remaining models which use each blob
+	refs := make(map[string]int)
+	sizes := make(map[string]int64)
+	for n, m := range ms {
+		for digest, size := range manifestBlobs(m) {
+			sizes[digest] = size
+			if !remove[n] {
+				refs[digest]++
+			}
+		}
+	}
+
+	if policy.MaxSize > 0 {
+		var total int64
+		for digest := range refs {
+			total += sizes[digest]
+		}
+
+		for _, n := range names {
+			if total <= policy.MaxSize {
+				break
+			}
+
+			if !mark(n) {
+				continue
+			}
+
+			for digest := range manifestBlobs(ms[n]) {
+				if refs[digest]--; refs[digest] == 0 {
+					total -= sizes[digest]
+				}
+			}
+		}
+	}
+
+	var removed []model.Name
+	var freed int64
+	deleteMap := make(map[string]struct{})
+	for _, n := range names {
+		if !remove[n] {
+			continue
+		}
+
+		removed = append(removed, n)
+		for digest := range manifestBlobs(ms[n]) {
+			if _, ok := deleteMap[digest]; !ok && refs[digest] == 0 {
+				freed += sizes[digest]
+			}
+
+			deleteMap[digest] = struct{}{}
+		}
+	}
+
+	if dryRun || len(removed) == 0 {
+		return removed, freed, nil
+	}
+
+	for _, n := range removed {
+		if err := ms[n].Remove(); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if err := removeUnusedLayers(deleteMap); err != nil {
+		return nil, 0, err
+	}
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := PruneDirectory(manifests); err != nil {
+		return nil, 0, err
+	}
+
+	if err := removeLastUsed(removed); err != nil {
+		slog.Warn("failed to update model usage", "error", err)
+	}
+
+	return removed, freed, nil
+}
+
+// manifestBlobs returns the digests and sizes of the blobs used by m.
+func manifestBlobs(m *Manifest) map[string]int64 {
+	blobs := make(map[string]int64)
+	for _, layer := range append(m.Layers, m.Config) {
+		if layer.Digest != "" {
+			blobs[layer.Digest] = layer.Size
+		}
+	}
+
+	return blobs
+}
+
+// lastUsedMu guards the file recording when each model was last used.
+var lastUsedMu sync.Mutex
+
+// lastUsedInterval is how often the last used times of models kept in
+// memory are written to the usage file.
+const lastUsedInterval = time.Minute
+
+func lastUsedPath() string {
+	return filepath.Join(envconfig.Models(), "last-used.json")
+}
+
+func lastUsedKey(n model.Name) string {
+	return strings.ToLower(n.String())
+}
+
+func readLastUsed() (map[string]time.Time, error) {
+	used := make(map[string]time.Time)
+
+	bts, err := os.ReadFile(lastUsedPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return used, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bts, &used); err != nil {
+		slog.Warn("ignoring invalid model usage", "path", lastUsedPath(), "error", err)
+		return make(map[string]time.Time), nil
+	}
+
+	return used, nil
+}
+
+func writeLastUsed(used map[string]time.Time) error {
+	bts, err := json.Marshal(used)
+	if err != nil {
+		return err
+	}
+
+	p := lastUsedPath()
+	if err := os.WriteFile(p+".tmp", bts, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(p+".tmp", p)
+}
+
+// modelUses keeps when models were last used in memory until they're
+// flushed to the usage file, so requests don't read and write it.
+type modelUses struct {
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+// record records that the named model was used at now so pruning can tell
+// which models are unused.
+func (u *modelUses) record(name string, now time.Time) {
+	n := model.ParseName(name)
+	if !n.IsValid() {
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.used == nil {
+		u.used = make(map[string]time.Time)
+	}
+
+	if key := lastUsedKey(n); now.After(u.used[key]) {
+		u.used[key] = now
+	}
+}
+
+// flush writes the uses recorded since the last flush to the usage file. If
+// it fails, they're kept to write on the next flush.
+func (u *modelUses) flush() error {
+	u.mu.Lock()
+	pending := u.used
+	u.used = nil
+	u.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := func() error {
+		lastUsedMu.Lock()
+		defer lastUsedMu.Unlock()
+
+		used, err := readLastUsed()
+		if err != nil {
+			return err
+		}
+
+		for key, t := range pending {
+			if t.After(used[key]) {
+				used[key] = t
+			}
+		}
+
+		return writeLastUsed(used)
+	}()
+	if err != nil {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+
+		if u.used == nil {
+			u.used = pending
+		} else {
+			for key, t := range pending {
+				if t.After(u.used[key]) {
+					u.used[key] = t
+				}
+			}
+		}
+	}
+
+	return err
+}
+
+// flushModelUses flushes the uses recorded by the server every
+// lastUsedInterval until ctx is done.
+func (s *Server) flushModelUses(ctx context.Context) {
+	ticker := time.NewTicker(lastUsedInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.uses.flush(); err != nil {
+			slog.Warn("failed to record model use", "error", err)
+		}
+	}
+}
+
+func removeLastUsed(names []model.Name) error {
+	lastUsedMu.Lock()
+	defer lastUsedMu.Unlock()
+
+	used, err := readLastUsed()
+	if err != nil {
+		return err
+	}
+
+	for _, n := range names {
+		delete(used, lastUsedKey(n))
+	}
+
+	return writeLastUsed(used)
+}
+
+// loaded reports whether the model is loaded by the scheduler.
+func (s *Server) loaded(n model.Name) bool {
+	if s.sched == nil {
+		return false
+	}
+
+	s.sched.loadedMu.Lock()
+	defer s.sched.loadedMu.Unlock()
+	for _, runner := range s.sched.loaded {
+		if runner.model != nil && strings.EqualFold(model.ParseName(runner.model.Name).String(), n.String()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pruneLoop periodically prunes the model store with the policy configured
+// in the environment until ctx is done.
+func (s *Server) pruneLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		policy := prunePolicy{
+			UnusedFor: envconfig.PruneUnusedFor(),
+			KeepTags:  int(envconfig.PruneKeepTags()),
+			MaxSize:   envconfig.PruneMaxSize(),
+		}
+
+		if !policy.enabled() {
+			continue
+		}
+
+		if err := s.uses.flush(); err != nil {
+			slog.Warn("failed to record model use", "error", err)
+		}
+
+		removed, freed, err := pruneModels(policy, time.Now(), s.loaded, false)
+		if errors.Is(err, errStoreInUse) {
+			slog.Debug("models directory is in use, pruning later")
+			continue
+		} else if err != nil {
+			slog.Warn("failed to prune models", "error", err)
+			continue
+		}
+
+		for _, n := range removed {
+			slog.Info("pruned model", "name", n.DisplayShortest())
+		}
+
+		if len(removed) > 0 {
+			slog.Info(fmt.Sprintf("pruned %d model(s), freed %s", len(removed), format.HumanBytes(freed)))
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/types/model"
+)
+
+func createPruneTestModel(t *testing.T, name string, lastUsed time.Time, blobs ...string) {
+	t.Helper()
+
+	var layers []Layer
+	for _, blob := range blobs {
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(blob)))
+		p, err := GetBlobsPath(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(p, []byte(blob), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		layers = append(layers, Layer{MediaType: "application/vnd.ollama.image.model", Digest: digest, Size: int64(len(blob))})
+	}
+
+	n := model.ParseName(name)
+	if err := WriteManifest(t.Context(), n, Layer{}, layers); err != nil {
+		t.Fatal(err)
+	}
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(filepath.Join(manifests, n.Filepath()), lastUsed, lastUsed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPruneModels(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+
+	cases := []struct {
+		name    string
+		policy  prunePolicy
+		keep    func(model.Name) bool
+		dryRun  bool
+		removed []string
+		freed   int64
+	}{
+		{
+			name:    "unused for",
+			policy:  prunePolicy{UnusedFor: 7 * day},
+			removed: []string{"b:latest", "a:1"},
+			freed:   3,
+		},
+		{
+			name:    "keep tags",
+			policy:  prunePolicy{KeepTags: 1},
+			removed: []string{"a:1", "a:2"},
+			freed:   10,
+		},
+		{
+			name:    "max size",
+			policy:  prunePolicy{MaxSize: 10},
+			removed: []string{"b:latest", "a:1"},
+			freed:   3,
+		},
+		{
+			name:    "max size with shared blob",
+			policy:  prunePolicy{MaxSize: 3},
+			removed: []string{"b:latest", "a:1", "a:2"},
+			freed:   11,
+		},
+		{
+			name:    "combined",
+			policy:  prunePolicy{UnusedFor: 15 * day, KeepTags: 2},
+			removed: []string{"b:latest", "a:1"},
+			freed:   3,
+		},
+		{
+			name:   "keep",
+			policy: prunePolicy{UnusedFor: 7 * day},
+			keep: func(n model.Name) bool {
+				return n.Model == "b"
+			},
+			removed: []string{"a:1"},
+			freed:   2,
+		},
+		{
+			name:    "dry run",
+			policy:  prunePolicy{UnusedFor: 7 * day},
+			dryRun:  true,
+			removed: []string{"b:latest", "a:1"},
+			freed:   3,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+			createPruneTestModel(t, "a:1", now.Add(-10*day), "shared", "a1")
+			createPruneTestModel(t, "a:2", now.Add(-5*day), "shared", "a2")
+			createPruneTestModel(t, "a:3", now.Add(-1*day), "a3")
+			createPruneTestModel(t, "b", now.Add(-20*day), "b")
+
+			removed, freed, err := pruneModels(tt.policy, now, tt.keep, tt.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var names []string
+			for _, n := range removed {
+				names = append(names, n.DisplayShortest())
+			}
+
+			if diff := cmp.Diff(tt.removed, names); diff != "" {
+				t.Errorf("removed mismatch (-want +got):\n%s", diff)
+			}
+
+			if freed != tt.freed {
+				t.Errorf("expected %d bytes freed, got %d", tt.freed, freed)
+			}
+
+			ms, err := Manifests(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range tt.removed {
+				if _, ok := ms[model.ParseName(name)]; ok == !tt.dryRun {
+					t.Errorf("expected %s to be removed %t", name, !tt.dryRun)
+				}
+			}
+
+			blobs, err := filepath.Glob(filepath.Join(os.Getenv("OLLAMA_MODELS"), "blobs", "*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var size int64
+			for _, blob := range blobs {
+				fi, err := os.Stat(blob)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				size += fi.Size()
+			}
+
+			expect := 13 - tt.freed
+			if tt.dryRun {
+				expect = 13
+			}
+
+			if size != expect {
+				t.Errorf("expected %d bytes of blobs, got %d", expect, size)
+			}
+		})
+	}
+}
+
+func TestPruneModelsLastUsed(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	now := time.Now()
+	createPruneTestModel(t, "a", now.Add(-30*24*time.Hour), "a")
+	createPruneTestModel(t, "b", now.Add(-30*24*time.Hour), "b")
+
+	var uses modelUses
+	uses.record("a", now.Add(-time.Hour))
+
+	if _, err := os.Stat(lastUsedPath()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected uses to be kept in memory until flushed, got %v", err)
+	}
+
+	if err := uses.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, _, err := pruneModels(prunePolicy{UnusedFor: 24 * time.Hour}, now, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(removed, []model.Name{model.ParseName("b")}) {
+		t.Errorf("expected only b to be removed, got %v", removed)
+	}
+
+	used, err := readLastUsed()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := used[lastUsedKey(model.ParseName("b"))]; ok {
+		t.Error("expected usage of removed model to be forgotten")
+	}
+
+	if _, ok := used[lastUsedKey(model.ParseName("a"))]; !ok {
+		t.Error("expected usage of a to be recorded")
+	}
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+// pullRecord is a pull which was running when the server stopped, so that
+// it's resumed when the server starts.
+type pullRecord struct {
+	Model    string `json:"model"`
+	Insecure bool   `json:"insecure,omitempty"`
+	MaxRate  int64  `json:"max_rate,omitempty"`
+	Tenant   string `json:"tenant,omitempty"`
+
+	// Digests are the layers the pull started downloading, whose partial
+	// downloads are kept for it
+	Digests []string `json:"digests,omitempty"`
+}
+
+func pullsPath() string {
+	return filepath.Join(envconfig.Models(), "pulls.json")
+}
+
+// readPullRecords returns the pulls which were running when the server last
+// stopped.
+func readPullRecords() ([]pullRecord, error) {
+	bts, err := os.ReadFile(pullsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var records []pullRecord
+	if err := json.Unmarshal(bts, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func writePullRecords(records []pullRecord) error {
+	p := pullsPath()
+	if len(records) == 0 {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	bts, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(p+".tmp", bts, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(p+".tmp", p)
+}
+
+// resumableDigests returns the digests of the layers of pulls which will be
+// resumed, so their partial downloads aren't pruned.
+func resumableDigests() map[string]bool {
+	records, err := readPullRecords()
+	if err != nil {
+		slog.Warn("failed to read pulls to resume", "error", err)
+	}
+
+	digests := make(map[string]bool)
+	for _, r := range records {
+		for _, d := range r.Digests {
+			digests[d] = true
+		}
+	}
+
+	return digests
+}
+
+// pulls tracks the pulls running on the server so clients other than the
+// one which started a pull can follow it, and records them so they're
+// resumed if the server stops before they finish.
+type pulls struct {
+	mu      sync.Mutex
+	running map[string]*runningPull
+
+	// records are saved to pulls.json, and include pulls which stopped
+	// with the server
+	records map[string]*pullRecord
+}
+
+type runningPull struct {
+	status api.PullStatus
+	refs   int
+}
+
+func pullKey(name string) string {
+	return strings.ToLower(name)
+}
+
+// start tracks a pull, returning a progress function which updates its
+// status and a function to call when it stops. keep is whether the pull
+// should be resumed when the server starts again.
+func (p *pulls) start(record pullRecord, resumed bool) (fn func(api.ProgressResponse), stop func(keep bool)) {
+	key := pullKey(record.Model)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running == nil {
+		p.running = make(map[string]*runningPull)
+		p.records = make(map[string]*pullRecord)
+	}
+
+	pull, ok := p.running[key]
+	if !ok {
+		pull = &runningPull{status: api.PullStatus{Model: record.Model, Status: "pulling manifest", Resumed: resumed, StartedAt: time.Now().UTC()}}
+		p.running[key] = pull
+	}
+	pull.refs++
+
+	if _, ok := p.records[key]; !ok {
+		p.records[key] = &record
+		p.save()
+	}
+
+	fn = func(r api.ProgressResponse) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		pull.status.Status = r.Status
+		if r.Digest == "" {
+			return
+		}
+
+		i := slices.IndexFunc(pull.status.Layers, func(l api.ProgressResponse) bool { return l.Digest == r.Digest })
+		if i < 0 {
+			pull.status.Layers = append(pull.status.Layers, r)
+		} else {
+			pull.status.Layers[i] = r
+		}
+
+		pull.status.Total, pull.status.Completed = 0, 0
+		for _, l := range pull.status.Layers {
+			pull.status.Total += l.Total
+			pull.status.Completed += l.Completed
+		}
+
+		if record, ok := p.records[key]; ok && !slices.Contains(record.Digests, r.Digest) {
+			record.Digests = append(record.Digests, r.Digest)
+			p.save()
+		}
+	}
+
+	stop = func(keep bool) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if pull.refs--; pull.refs > 0 {
+			return
+		}
+
+		delete(p.running, key)
+		if !keep {
+			delete(p.records, key)
+			p.save()
+		}
+	}
+
+	return fn, stop
+}
+
+// save writes the records. p.mu must be held.
+func (p *pulls) save() {
+	records := make([]pullRecord, 0, len(p.records))
+	for _, r := range p.records {
+		records = append(records, *r)
+	}
+
+	slices.SortFunc(records, func(a, b pullRecord) int { return strings.Compare(a.Model, b.Model) })
+	if err := writePullRecords(records); err != nil {
+		slog.Warn("failed to record pulls to resume", "error", err)
+	}
+}
+
+// list returns the status of the running pulls, sorted by when they
+// started.
+func (p *pulls) list() []api.PullStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	statuses := make([]api.PullStatus, 0, len(p.running))
+	for _, pull := range p.running {
+		status := pull.status
+		status.Layers = slices.Clone(status.Layers)
+		statuses = append(statuses, status)
+	}
+
+	slices.SortFunc(statuses, func(a, b api.PullStatus) int { return a.StartedAt.Compare(b.StartedAt) })
+	return statuses
+}
+
+// resumePulls resumes the pulls which were running when the server last
+// stopped. They're stopped with ctx and resumed again next time.
+func (s *Server) resumePulls(ctx context.Context) {
+	records, err := readPullRecords()
+	if err != nil {
+		slog.Warn("failed to read pulls to resume", "error", err)
+		return
+	}
+
+	for _, r := range records {
+		slog.Info("resuming pull", "model", r.Model)
+
+		// the pull is tracked before it runs so pulls which start in the
+		// meantime don't drop its record
+		fn, stop := s.pulls.start(r, true)
+		go func() {
+			err := PullModel(ctx, r.Model, &registryOptions{Insecure: r.Insecure, MaxDownloadRate: r.MaxRate}, fn)
+			stop(errors.Is(err, context.Canceled))
+			if err != nil {
+				slog.Warn("failed to resume pull", "model", r.Model, "error", err)
+				return
+			}
+
+			slog.Info("resumed pull finished", "model", r.Model)
+			emitWebhook(api.WebhookEvent{Event: api.WebhookModelPulled, Model: r.Model, Tenant: r.Tenant})
+		}()
+	}
+}
+
+// ListPullsHandler returns the status of the pulls running on the server,
+// or of the pull of the model in the model query parameter.
+func (s *Server) ListPullsHandler(c *gin.Context) {
+	pulls := s.pulls.list()
+	pulls = slices.DeleteFunc(pulls, func(p api.PullStatus) bool { return !visible(c, model.ParseName(p.Model)) })
+
+	if name := c.Query("model"); name != "" {
+		n := model.ParseName(name)
+		if !n.IsValid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid model name"})
+			return
+		}
+
+		pulls = slices.DeleteFunc(pulls, func(p api.PullStatus) bool { return !model.ParseName(p.Model).EqualFold(n) })
+		if len(pulls) == 0 {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "no pull of " + name + " is running"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, api.ListPullsResponse{Pulls: pulls})
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestPulls(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+
+	listPulls := func(query string) (int, []api.PullStatus) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/pull"+query, nil)
+		s.ListPullsHandler(c)
+
+		var resp api.ListPullsResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return w.Code, resp.Pulls
+	}
+
+	fn, stop := s.pulls.start(pullRecord{Model: "test", MaxRate: 1000}, false)
+	fn(api.ProgressResponse{Status: "pulling manifest"})
+	fn(api.ProgressResponse{Status: "pulling aaa", Digest: "sha256:aaa", Total: 10, Completed: 2})
+	fn(api.ProgressResponse{Status: "pulling aaa", Digest: "sha256:aaa", Total: 10, Completed: 10})
+	fn(api.ProgressResponse{Status: "pulling bbb", Digest: "sha256:bbb", Total: 5, Completed: 1})
+
+	// another client pulling the same model shares its status
+	_, stopOther := s.pulls.start(pullRecord{Model: "test"}, false)
+
+	code, pulls := listPulls("")
+	if code != http.StatusOK || len(pulls) != 1 {
+		t.Fatalf("expected one pull, got %d %+v", code, pulls)
+	}
+
+	want := api.PullStatus{
+		Model:     "test",
+		Status:    "pulling bbb",
+		StartedAt: pulls[0].StartedAt,
+		Total:     15,
+		Completed: 11,
+		Layers: []api.ProgressResponse{
+			{Status: "pulling aaa", Digest: "sha256:aaa", Total: 10, Completed: 10},
+			{Status: "pulling bbb", Digest: "sha256:bbb", Total: 5, Completed: 1},
+		},
+	}
+	if diff := cmp.Diff(pulls[0], want); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	if code, pulls := listPulls("?model=test:latest"); code != http.StatusOK || len(pulls) != 1 {
+		t.Errorf("expected the pull of test, got %d %+v", code, pulls)
+	}
+
+	if code, _ := listPulls("?model=other"); code != http.StatusNotFound {
+		t.Errorf("expected status code 404, actual %d", code)
+	}
+
+	records, err := readPullRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(records, []pullRecord{{Model: "test", MaxRate: 1000, Digests: []string{"sha256:aaa", "sha256:bbb"}}}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	stop(false)
+	if _, pulls := listPulls(""); len(pulls) != 1 {
+		t.Errorf("expected the pull to run until every client stops, got %+v", pulls)
+	}
+
+	// the server shut down before the pull finished
+	stopOther(true)
+	if _, pulls := listPulls(""); len(pulls) != 0 {
+		t.Errorf("expected no pulls, got %+v", pulls)
+	}
+
+	if records, err := readPullRecords(); err != nil || len(records) != 1 {
+		t.Errorf("expected the pull to be kept to resume, got %+v %v", records, err)
+	}
+
+	if digests := resumableDigests(); !digests["sha256:aaa"] || !digests["sha256:bbb"] {
+		t.Errorf("expected the pull's layers to be resumable, got %v", digests)
+	}
+
+	_, stop = s.pulls.start(pullRecord{Model: "test"}, true)
+	stop(false)
+	if _, err := os.Stat(pullsPath()); !os.IsNotExist(err) {
+		t.Errorf("expected the record to be removed once the pull stops, got %v", err)
+	}
+}
+
+func TestPruneKeepsResumablePulls(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	resumable := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("resumable")))
+	abandoned := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("abandoned")))
+
+	if err := writePullRecords([]pullRecord{{Model: "test", Digests: []string{resumable}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var files []string
+	for _, digest := range []string{resumable, abandoned} {
+		p, err := GetBlobsPath(digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{p + "-partial", p + "-partial-0"} {
+			if err := os.WriteFile(name, []byte("{}"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			files = append(files, name)
+		}
+	}
+
+	if err := PruneLayers(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range files {
+		_, err := os.Stat(name)
+		if keep := strings.Contains(name, resumable[7:]); keep && err != nil {
+			t.Errorf("expected %s to be kept, got %v", name, err)
+		} else if !keep && !os.IsNotExist(err) {
+			t.Errorf("expected %s to be pruned, got %v", name, err)
+		}
+	}
+}
+
+func TestResumePulls(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	blob := []byte("weights of a model pulled before a restart")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+
+	manifest, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeDockerManifest,
+		Layers:        []Layer{{MediaType: "application/vnd.ollama.image.model", Digest: digest, Size: int64(len(blob))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/library/test/manifests/latest":
+			w.Write(manifest)
+		case "/v2/library/test/blobs/" + digest:
+			http.Redirect(w, r, strings.Replace("http://"+r.Host, "127.0.0.1", "localhost", 1)+"/direct", http.StatusTemporaryRedirect)
+		case "/direct":
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(blob))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	name := strings.TrimPrefix(srv.URL, "http://") + "/library/test:latest"
+	if err := writePullRecords([]pullRecord{{Model: name, Insecure: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var s Server
+	s.resumePulls(context.Background())
+
+	if pulls := s.pulls.list(); len(pulls) != 1 || !pulls[0].Resumed {
+		t.Fatalf("expected a resumed pull, got %+v", pulls)
+	}
+
+	for range 100 {
+		if len(s.pulls.list()) == 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if pulls := s.pulls.list(); len(pulls) != 0 {
+		t.Fatalf("expected the pull to finish, got %+v", pulls)
+	}
+
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bts, err := os.ReadFile(p); err != nil || !bytes.Equal(bts, blob) {
+		t.Errorf("expected the layer to be pulled, got %q %v", bts, err)
+	}
+
+	if _, err := os.Stat(pullsPath()); !os.IsNotExist(err) {
+		t.Errorf("expected the record to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,461 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+	ggmlbackend "github.com/ollama/ollama/ml/backend/ggml"
+	"github.com/ollama/ollama/types/model"
+)
+
+// tensor types, matching ggml_type
+const (
+	tensorTypeF32     uint32 = 0
+	tensorTypeF16     uint32 = 1
+	tensorTypeQ4_0    uint32 = 2
+	tensorTypeQ4_1    uint32 = 3
+	tensorTypeQ5_0    uint32 = 6
+	tensorTypeQ5_1    uint32 = 7
+	tensorTypeQ8_0    uint32 = 8
+	tensorTypeQ2_K    uint32 = 10
+	tensorTypeQ3_K    uint32 = 11
+	tensorTypeQ4_K    uint32 = 12
+	tensorTypeQ5_K    uint32 = 13
+	tensorTypeQ6_K    uint32 = 14
+	tensorTypeIQ2_XXS uint32 = 16
+	tensorTypeIQ2_XS  uint32 = 17
+	tensorTypeIQ3_XXS uint32 = 18
+	tensorTypeIQ1_S   uint32 = 19
+	tensorTypeIQ4_NL  uint32 = 20
+	tensorTypeIQ3_S   uint32 = 21
+	tensorTypeIQ2_S   uint32 = 22
+	tensorTypeIQ4_XS  uint32 = 23
+	tensorTypeIQ1_M   uint32 = 29
+	tensorTypeBF16    uint32 = 30
+)
+
+// quantizeTypes maps the file types models can be quantized to onto the
+// tensor type of most of their weights.
+var quantizeTypes = map[string]uint32{
+	"F32":    tensorTypeF32,
+	"F16":    tensorTypeF16,
+	"BF16":   tensorTypeBF16,
+	"Q4_0":   tensorTypeQ4_0,
+	"Q4_1":   tensorTypeQ4_1,
+	"Q5_0":   tensorTypeQ5_0,
+	"Q5_1":   tensorTypeQ5_1,
+	"Q8_0":   tensorTypeQ8_0,
+	"Q2_K":   tensorTypeQ2_K,
+	"Q3_K_S": tensorTypeQ3_K,
+	"Q3_K_M": tensorTypeQ3_K,
+	"Q3_K_L": tensorTypeQ3_K,
+	"Q4_K_S": tensorTypeQ4_K,
+	"Q4_K_M": tensorTypeQ4_K,
+	"Q5_K_S": tensorTypeQ5_K,
+	"Q5_K_M": tensorTypeQ5_K,
+	"Q6_K":   tensorTypeQ6_K,
+
+	// these need an importance matrix, or are much better with one
+	"Q2_K_S":  tensorTypeQ2_K,
+	"IQ1_S":   tensorTypeIQ1_S,
+	"IQ1_M":   tensorTypeIQ1_M,
+	"IQ2_XXS": tensorTypeIQ2_XXS,
+	"IQ2_XS":  tensorTypeIQ2_XS,
+	"IQ2_S":   tensorTypeIQ2_S,
+	"IQ2_M":   tensorTypeIQ2_S,
+	"IQ3_XXS": tensorTypeIQ3_XXS,
+	"IQ3_XS":  tensorTypeIQ3_S,
+	"IQ3_S":   tensorTypeIQ3_S,
+	"IQ3_M":   tensorTypeIQ3_S,
+	"IQ4_NL":  tensorTypeIQ4_NL,
+	"IQ4_XS":  tensorTypeIQ4_XS,
+}
+
+var (
+	errUnsupportedQuantization = errors.New("unsupported quantization type")
+	errCalibrationRequired     = errors.New("calibration data is required to quantize to this type")
+)
+
+// quantizeChunkSize is the number of values each worker quantizes at a time.
+const quantizeChunkSize = 1 << 18
+
+// quantizable reports whether the tensor t holds weights which are
+// quantized. Other tensors, such as norms, keep their type.
+func quantizable(t *ggml.Tensor) bool {
+	if len(t.Shape) < 2 || !strings.HasSuffix(t.Name, "weight") {
+		return false
+	}
+
+	for _, s := range []string{"norm", "position_embd", "ssm_conv1d", "rel_pos", "time_mix_"} {
+		if strings.Contains(t.Name, s) {
+			return false
+		}
+	}
+
+	_, err := ggmlbackend.ConvertToF32(nil, t.Kind, 0)
+	return err == nil
+}
+
+// quantizeTensorType returns the type the tensor t of a model with blocks
+// layers is quantized to for the file type ft. Like llama.cpp, the _M and _L
+// mixes keep more bits for the tensors which affect quality the most.
+// Tensors without importance aren't quantized to types which require it.
+func quantizeTensorType(t *ggml.Tensor, ft string, blocks uint64, importance bool) uint32 {
+	if !quantizable(t) {
+		return t.Kind
+	}
+
+	kind := quantizeTypes[ft]
+
+	var i uint64
+	fmt.Sscanf(t.Name, "blk.%d.", &i)
+	moreBits := i < blocks/8 || i >= 7*blocks/8 || (i-blocks/8)%3 == 2
+
+	switch {
+	case t.Name == "output.weight":
+		// the types needing calibration have fewer than 3 bits per weight
+		if slices.Contains(calibrationTypes, ft) {
+			kind = tensorTypeQ5_K
+		} else if !slices.Contains([]uint32{tensorTypeF32, tensorTypeF16, tensorTypeBF16, tensorTypeQ8_0}, kind) {
+			kind = tensorTypeQ6_K
+		}
+	case strings.HasSuffix(t.Name, "attn_v.weight"):
+		switch {
+		case slices.Contains(calibrationTypes, ft), ft == "IQ3_XXS", ft == "IQ3_XS", ft == "IQ3_M":
+			kind = tensorTypeQ4_K
+		case ft == "Q2_K":
+			kind = tensorTypeQ3_K
+		case ft == "Q3_K_M" && i < 2, ft == "Q3_K_L", ft == "Q4_K_S" && i < 4:
+			kind = tensorTypeQ5_K
+		case ft == "Q3_K_M":
+			kind = tensorTypeQ4_K
+		case (ft == "Q4_K_M" || ft == "Q5_K_M") && moreBits:
+			kind = tensorTypeQ6_K
+		}
+	case strings.HasSuffix(t.Name, "ffn_down.weight"):
+		switch {
+		case ft == "Q2_K", ft == "Q2_K_S" && i < blocks/8:
+			kind = tensorTypeQ3_K
+		case ft == "IQ2_M" && moreBits, ft == "IQ3_M" && moreBits:
+			kind = tensorTypeQ4_K
+		case ft == "Q3_K_M" && i < blocks/16, ft == "Q3_K_L", ft == "Q4_K_S" && i < blocks/8:
+			kind = tensorTypeQ5_K
+		case ft == "Q3_K_M" && moreBits:
+			kind = tensorTypeQ4_K
+		case (ft == "Q4_K_M" || ft == "Q5_K_M") && moreBits:
+			kind = tensorTypeQ6_K
+		}
+	case strings.HasSuffix(t.Name, "attn_output.weight"):
+		switch ft {
+		case "Q3_K_M", "IQ3_M":
+			kind = tensorTypeQ4_K
+		case "Q3_K_L":
+			kind = tensorTypeQ5_K
+		case "IQ2_M":
+			kind = tensorTypeIQ3_S
+		}
+	}
+
+	// tensors which weren't multiplied with any calibration data, such as
+	// token embeddings, get the closest type without an importance matrix
+	if !importance && ggmlbackend.RequiresImportance(kind) {
+		kind = tensorTypeQ2_K
+	}
+
+	// rows which aren't a multiple of the block size of k-quants fall back
+	// to a type with smaller blocks
+	if t.Shape[0]%ggmlbackend.BlockSize(kind) != 0 {
+		switch kind {
+		case tensorTypeQ2_K, tensorTypeQ3_K, tensorTypeIQ1_S, tensorTypeIQ1_M, tensorTypeIQ2_XXS,
+			tensorTypeIQ2_XS, tensorTypeIQ2_S, tensorTypeIQ3_XXS, tensorTypeIQ3_S, tensorTypeIQ4_XS:
+			kind = tensorTypeIQ4_NL
+		case tensorTypeQ4_K:
+			kind = tensorTypeQ5_0
+		case tensorTypeQ5_K:
+			kind = tensorTypeQ5_1
+		case tensorTypeQ6_K:
+			kind = tensorTypeQ8_0
+		}
+
+		if t.Shape[0]%ggmlbackend.BlockSize(kind) != 0 {
+			kind = tensorTypeF16
+		}
+	}
+
+	return kind
+}
+
+// quantizeData converts data, the values of the tensor t, to the tensor type
+// kind. Chunks of rows are quantized in parallel. importance is optional and
+// holds the importance of each column of t, or of each of its matrices in
+// turn for mixture of experts weights.
+func quantizeData(ctx context.Context, data []byte, t *ggml.Tensor, kind uint32, importance []float32) ([]byte, error) {
+	nPerRow := t.Shape[0]
+	nrows := uint64(1)
+	for _, n := range t.Shape[1:] {
+		nrows *= n
+	}
+
+	rowsPerMatrix := t.Shape[1]
+	if n := uint64(len(importance)); n != 0 && n != nPerRow && n != nPerRow*nrows/rowsPerMatrix {
+		return nil, fmt.Errorf("quantize %s: importance of %d columns doesn't match shape %v", t.Name, n, t.Shape)
+	}
+
+	srcRowSize := uint64(len(data)) / nrows
+	dstRowSize := ggmlbackend.RowSize(kind, nPerRow)
+	quantized := make([]byte, dstRowSize*nrows)
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(runtime.GOMAXPROCS(0))
+
+	rows := max(1, quantizeChunkSize/nPerRow)
+	for start, end := uint64(0), uint64(0); start < nrows; start = end {
+		// chunks don't span matrices so each has the importance of one
+		matrix := start / rowsPerMatrix
+		end = min(start+rows, (matrix+1)*rowsPerMatrix)
+
+		var chunkImportance []float32
+		if uint64(len(importance)) == nPerRow {
+			chunkImportance = importance
+		} else if importance != nil {
+			chunkImportance = importance[matrix*nPerRow : (matrix+1)*nPerRow]
+		}
+
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			f32s, err := ggmlbackend.ConvertToF32(data[start*srcRowSize:end*srcRowSize], t.Kind, (end-start)*nPerRow)
+			if err != nil {
+				return err
+			}
+
+			bts, err := ggmlbackend.Quantize(kind, f32s, nPerRow, chunkImportance)
+			if err != nil {
+				return err
+			}
+
+			copy(quantized[start*dstRowSize:], bts)
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, fmt.Errorf("quantize %s: %w", t.Name, err)
+	}
+
+	return quantized, nil
+}
+
+// quantizeTensor is a tensor which is read from the model being quantized
+// and converted to its new type as it's written.
+type quantizeTensor struct {
+	ctx    context.Context
+	r      io.ReaderAt
+	offset int64
+	src    *ggml.Tensor
+	kind   uint32
+	// importance is the importance matrix of src, if there is one
+	importance []float32
+	progress   func(int64)
+}
+
+func (q *quantizeTensor) WriteTo(w io.Writer) (int64, error) {
+	data := make([]byte, q.src.Size())
+	if _, err := q.r.ReadAt(data, q.offset); err != nil {
+		return 0, err
+	}
+
+	if q.kind != q.src.Kind {
+		var err error
+		if data, err = quantizeData(q.ctx, data, q.src, q.kind, q.importance); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.Write(data)
+	if err != nil {
+		return int64(n), err
+	}
+
+	q.progress(int64(q.src.Size()))
+	return int64(n), nil
+}
+
+// quantizeModelLayer quantizes the GGUF model in layer to the file type ft,
+// returning the layer of the quantized model. Its weights are weighted by
+// importance if it isn't nil.
+func quantizeModelLayer(ctx context.Context, layer Layer, ft string, importance map[string][]float32, fn func(api.ProgressResponse)) (Layer, error) {
+	want, err := ggml.ParseFileType(ft)
+	if err != nil {
+		return Layer{}, err
+	}
+
+	blob, err := fetchBlob(ctx, layer.Digest)
+	if err != nil {
+		return Layer{}, err
+	}
+
+	f, err := os.Open(blob)
+	if err != nil {
+		return Layer{}, err
+	}
+	defer f.Close()
+
+	g, _, err := ggml.Decode(f, -1)
+	if err != nil {
+		return Layer{}, err
+	} else if g.Name() != "gguf" {
+		return Layer{}, errOnlyGGUFSupported
+	}
+
+	kv := maps.Clone(g.KV())
+	if kv.FileType() == want {
+		return layer, nil
+	}
+
+	status := fmt.Sprintf("quantizing %s model to %s", kv.FileType(), ft)
+	fn(api.ProgressResponse{Status: status})
+
+	var total, completed int64
+	for _, t := range g.Tensors().Items() {
+		total += int64(t.Size())
+	}
+
+	progress := func(n int64) {
+		completed += n
+		fn(api.ProgressResponse{Status: status, Digest: layer.Digest, Total: total, Completed: completed})
+	}
+
+	var ts []ggml.Tensor
+	for _, t := range g.Tensors().Items() {
+		// shapes are written in the reverse of the order they're read
+		shape := slices.Clone(t.Shape)
+		slices.Reverse(shape)
+
+		kind := quantizeTensorType(t, ft, kv.BlockCount(), importance[t.Name] != nil)
+		ts = append(ts, ggml.Tensor{
+			Name:  t.Name,
+			Kind:  kind,
+			Shape: shape,
+			WriterTo: &quantizeTensor{
+				ctx:        ctx,
+				r:          f,
+				offset:     int64(g.Tensors().Offset + t.Offset),
+				src:        t,
+				kind:       kind,
+				importance: importance[t.Name],
+				progress:   progress,
+			},
+		})
+	}
+
+	kv["general.file_type"] = want.Value()
+	kv["general.quantization_version"] = uint32(2)
+	// tensors are always written with the default alignment
+	delete(kv, "general.alignment")
+
+	temp, err := os.CreateTemp(filepath.Dir(blob), "quantize-")
+	if err != nil {
+		return Layer{}, err
+	}
+	defer temp.Close()
+	defer os.Remove(temp.Name())
+
+	if err := ggml.WriteGGUF(temp, kv, ts); err != nil {
+		return Layer{}, err
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return Layer{}, err
+	}
+
+	return NewLayer(temp, layer.MediaType)
+}
+
+// quantizeModel creates the model dst from src with its weights quantized to
+// the file type ft, weighted by the importance matrix of src if it isn't
+// nil. The other layers of src are shared with dst.
+func quantizeModel(ctx context.Context, src, dst model.Name, ft string, importance map[string][]float32, fn func(api.ProgressResponse)) error {
+	ft = strings.ToUpper(ft)
+	if kind, ok := quantizeTypes[ft]; !ok || !ggmlbackend.CanQuantize(kind) {
+		return fmt.Errorf("%w: %q", errUnsupportedQuantization, ft)
+	} else if importance == nil && slices.Contains(calibrationTypes, ft) {
+		return fmt.Errorf("%w: %s", errCalibrationRequired, ft)
+	}
+
+	unlock, err := lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	m, err := ParseNamedManifest(src)
+	if err != nil {
+		return err
+	}
+
+	configPath, err := fetchBlob(ctx, m.Config.Digest)
+	if err != nil {
+		return err
+	}
+
+	bts, err := os.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	var config ConfigV2
+	if err := json.Unmarshal(bts, &config); err != nil {
+		return err
+	}
+
+	var layers []Layer
+	for _, layer := range m.Layers {
+		if layer.MediaType == "application/vnd.ollama.image.model" {
+			if layer, err = quantizeModelLayer(ctx, layer, ft, importance, fn); err != nil {
+				return err
+			}
+
+			config.FileType = ft
+		}
+
+		layers = append(layers, layer)
+	}
+
+	configLayer, err := createConfigLayer(layers, config)
+	if err != nil {
+		return err
+	}
+
+	if err := checkQuota(dst, append(layers, *configLayer)); err != nil {
+		deleteMap := make(map[string]struct{})
+		for _, layer := range append(layers, *configLayer) {
+			deleteMap[layer.Digest] = struct{}{}
+		}
+
+		if err := deleteUnusedLayers(deleteMap); err != nil {
+			slog.Warn("failed to remove unused layers", "error", err)
+		}
+
+		return err
+	}
+
+	fn(api.ProgressResponse{Status: "writing manifest"})
+	return WriteManifest(ctx, dst, *configLayer, layers)
+}
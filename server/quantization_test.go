@@ -0,0 +1,432 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+	ggmlbackend "github.com/ollama/ollama/ml/backend/ggml"
+	"github.com/ollama/ollama/types/model"
+)
+
+func f32Tensor(t *testing.T, name string, shape ...uint64) (ggml.Tensor, []float32) {
+	t.Helper()
+
+	n := uint64(1)
+	for _, s := range shape {
+		n *= s
+	}
+
+	values := make([]float32, n)
+	for i := range values {
+		values[i] = float32(math.Sin(float64(i) / 10))
+	}
+
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, values); err != nil {
+		t.Fatal(err)
+	}
+
+	return ggml.Tensor{Name: name, Kind: tensorTypeF32, Shape: shape, WriterTo: &b}, values
+}
+
+func quantizedTensors(t *testing.T, n model.Name) (ggml.KV, map[string][]float32, map[string]uint32) {
+	t.Helper()
+
+	m, err := ParseNamedManifest(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, layer := range m.Layers {
+		if layer.MediaType != "application/vnd.ollama.image.model" {
+			continue
+		}
+
+		p, err := GetBlobsPath(layer.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		g, _, err := ggml.Decode(f, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		values := make(map[string][]float32)
+		kinds := make(map[string]uint32)
+		for _, tensor := range g.Tensors().Items() {
+			data := make([]byte, tensor.Size())
+			if _, err := f.ReadAt(data, int64(g.Tensors().Offset+tensor.Offset)); err != nil {
+				t.Fatal(err)
+			}
+
+			n := uint64(1)
+			for _, s := range tensor.Shape {
+				n *= s
+			}
+
+			f32s, err := ggmlbackend.ConvertToF32(data, tensor.Kind, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			values[tensor.Name] = f32s
+			kinds[tensor.Name] = tensor.Kind
+		}
+
+		return g.KV(), values, kinds
+	}
+
+	t.Fatalf("%s has no model layer", n)
+	return nil, nil, nil
+}
+
+func TestQuantize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+
+	attnQ, attnQValues := f32Tensor(t, "blk.0.attn_q.weight", 4, 256)
+	attnNorm, attnNormValues := f32Tensor(t, "blk.0.attn_norm.weight", 256)
+	output, _ := f32Tensor(t, "output.weight", 2, 256)
+	// rows which aren't a multiple of 256 can't use k-quants
+	small, _ := f32Tensor(t, "blk.0.ffn_up.weight", 2, 64)
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":  "llama",
+		"general.file_type":     uint32(0),
+		"llama.block_count":     uint32(1),
+		"tokenizer.ggml.tokens": []string{"a", "b", "c"},
+	}, []ggml.Tensor{attnQ, attnNorm, output, small})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	t.Run("q8_0", func(t *testing.T) {
+		w := createRequest(t, s.QuantizeHandler, api.QuantizeRequest{
+			Source:      "test",
+			Destination: "test-q8",
+			Type:        "q8_0",
+			Stream:      &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		kv, values, kinds := quantizedTensors(t, model.ParseName("test-q8"))
+		if ft := kv.FileType().String(); ft != "Q8_0" {
+			t.Errorf("expected file type Q8_0, got %s", ft)
+		}
+
+		tokens, err := json.Marshal(kv["tokenizer.ggml.tokens"])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(tokens) != `["a","b","c"]` {
+			t.Errorf("expected tokens to be kept, got %s", tokens)
+		}
+
+		if diff := cmp.Diff(map[string]uint32{
+			"blk.0.attn_q.weight":    tensorTypeQ8_0,
+			"blk.0.attn_norm.weight": tensorTypeF32,
+			"blk.0.ffn_up.weight":    tensorTypeQ8_0,
+			"output.weight":          tensorTypeQ8_0,
+		}, kinds); diff != "" {
+			t.Errorf("tensor types mismatch (-want +got):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(attnNormValues, values["blk.0.attn_norm.weight"]); diff != "" {
+			t.Errorf("norm changed (-want +got):\n%s", diff)
+		}
+
+		for i, v := range values["blk.0.attn_q.weight"] {
+			if math.Abs(float64(v-attnQValues[i])) > 0.01 {
+				t.Fatalf("value %d: expected about %f, got %f", i, attnQValues[i], v)
+			}
+		}
+
+		m, err := GetModel("test-q8")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Config.FileType != "Q8_0" {
+			t.Errorf("expected config file type Q8_0, got %s", m.Config.FileType)
+		}
+	})
+
+	t.Run("q4_K_M from q8_0", func(t *testing.T) {
+		w := createRequest(t, s.QuantizeHandler, api.QuantizeRequest{
+			Source:      "test-q8",
+			Destination: "test-q4",
+			Type:        "q4_K_M",
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		var resps []api.ProgressResponse
+		scanner := bufio.NewScanner(w.Body)
+		for scanner.Scan() {
+			var resp api.ProgressResponse
+			if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			resps = append(resps, resp)
+		}
+
+		if len(resps) < 2 || resps[len(resps)-1].Status != "success" {
+			t.Fatalf("expected progress followed by success, got %v", resps)
+		}
+
+		var completed bool
+		for _, resp := range resps {
+			completed = completed || (resp.Total > 0 && resp.Completed == resp.Total)
+		}
+
+		if !completed {
+			t.Errorf("expected progress to complete, got %v", resps)
+		}
+
+		kv, _, kinds := quantizedTensors(t, model.ParseName("test-q4"))
+		if ft := kv.FileType().String(); ft != "Q4_K_M" {
+			t.Errorf("expected file type Q4_K_M, got %s", ft)
+		}
+
+		if diff := cmp.Diff(map[string]uint32{
+			"blk.0.attn_q.weight":    tensorTypeQ4_K,
+			"blk.0.attn_norm.weight": tensorTypeF32,
+			"blk.0.ffn_up.weight":    tensorTypeQ5_0,
+			"output.weight":          tensorTypeQ6_K,
+		}, kinds); diff != "" {
+			t.Errorf("tensor types mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("iq2_xs with calibration", func(t *testing.T) {
+		runner := importanceRunner{importance: map[string][]float32{"blk.0.attn_q.weight": slices.Repeat([]float32{1}, 256)}}
+		s.sched = &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&runner.mockRunner),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, _ *ggml.GGML, _ discover.GpuInfoList, _ int) {
+				req.successCh <- &runnerRef{llama: &runner}
+			},
+		}
+		t.Cleanup(func() { s.sched = nil })
+
+		go s.sched.Run(t.Context())
+
+		// calibration needs a model whose file type is known to be unquantized
+		attnQ, _ := f32Tensor(t, "blk.0.attn_q.weight", 4, 256)
+		_, digest := createBinFile(t, ggml.KV{
+			"general.architecture":  "llama",
+			"general.file_type":     uint32(1),
+			"llama.block_count":     uint32(1),
+			"tokenizer.ggml.tokens": []string{"a", "b", "c"},
+		}, []ggml.Tensor{attnQ})
+
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:   "test-f16",
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		calibration := []string{"the quick brown fox jumps over the lazy dog", "and the dog sleeps on in the warm afternoon sun"}
+		w = createRequest(t, s.QuantizeHandler, api.QuantizeRequest{
+			Source:      "test-f16",
+			Destination: "test-iq2",
+			Type:        "iq2_xs",
+			Calibration: calibration,
+			Stream:      &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		if diff := cmp.Diff(calibration, runner.calibration); diff != "" {
+			t.Errorf("expected the runner to be calibrated (-want +got):\n%s", diff)
+		}
+
+		_, _, kinds := quantizedTensors(t, model.ParseName("test-iq2"))
+		if kinds["blk.0.attn_q.weight"] != tensorTypeIQ2_XS {
+			t.Errorf("expected the calibrated weight to be IQ2_XS, got %d", kinds["blk.0.attn_q.weight"])
+		}
+
+		w = createRequest(t, s.QuantizeHandler, api.QuantizeRequest{
+			Source:      "test-f16",
+			Destination: "test-iq2",
+			Type:        "iq2_xs",
+			Calibration: []string{"too short"},
+			Stream:      &stream,
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for short calibration, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	cases := []struct {
+		name   string
+		req    api.QuantizeRequest
+		status int
+	}{
+		{"unsupported type", api.QuantizeRequest{Source: "test", Destination: "test-bad", Type: "q9_0"}, http.StatusBadRequest},
+		{"missing type", api.QuantizeRequest{Source: "test", Destination: "test-bad"}, http.StatusBadRequest},
+		{"missing source", api.QuantizeRequest{Source: "missing", Destination: "test-bad", Type: "q8_0"}, http.StatusNotFound},
+		{"invalid destination", api.QuantizeRequest{Source: "test", Destination: "bad name", Type: "q8_0"}, http.StatusBadRequest},
+		{"calibration required", api.QuantizeRequest{Source: "test", Destination: "test-bad", Type: "iq2_xs"}, http.StatusBadRequest},
+		{"calibration of quantized model", api.QuantizeRequest{Source: "test-q8", Destination: "test-bad", Type: "iq3_m", Calibration: []string{"hello"}}, http.StatusBadRequest},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.QuantizeHandler, tt.req)
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+// importanceRunner returns importance for the calibration it's given.
+type importanceRunner struct {
+	mockRunner
+
+	calibration []string
+	importance  map[string][]float32
+}
+
+func (r *importanceRunner) Importance(_ context.Context, req llm.ImportanceRequest, fn func(llm.ImportanceResponse)) (map[string][]float32, error) {
+	r.calibration = req.Calibration
+	fn(llm.ImportanceResponse{Completed: 1, Total: 1})
+	return r.importance, nil
+}
+
+func TestQuantizeImportance(t *testing.T) {
+	tensor, values := f32Tensor(t, "blk.0.ffn_up.weight", 256, 16)
+	tensor.Shape = []uint64{256, 16}
+
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, values); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first columns matter far more than the rest
+	importance := make([]float32, 256)
+	for i := range importance {
+		importance[i] = 1
+		if i%32 < 4 {
+			importance[i] = 100
+		}
+	}
+
+	// weightedError returns the error of quantizing to kind, weighted by how
+	// much each column matters
+	weightedError := func(t *testing.T, kind uint32, imatrix []float32) float64 {
+		t.Helper()
+
+		quantized, err := quantizeData(t.Context(), b.Bytes(), &tensor, kind, imatrix)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f32s, err := ggmlbackend.ConvertToF32(quantized, kind, uint64(len(values)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var sum float64
+		for i, v := range f32s {
+			d := float64(v - values[i])
+			sum += float64(importance[i%256]) * d * d
+		}
+
+		return sum
+	}
+
+	for _, kind := range []uint32{tensorTypeQ3_K, tensorTypeIQ4_XS, tensorTypeIQ3_S} {
+		without := weightedError(t, kind, nil)
+		with := weightedError(t, kind, importance)
+		if with >= without {
+			t.Errorf("type %d: expected importance to reduce the weighted error, got %f with and %f without", kind, with, without)
+		}
+	}
+
+	if _, err := quantizeData(t.Context(), b.Bytes(), &tensor, tensorTypeIQ2_XS, nil); err == nil {
+		t.Error("expected iq2_xs to require importance")
+	}
+
+	if _, err := quantizeData(t.Context(), b.Bytes(), &tensor, tensorTypeIQ2_XS, importance); err != nil {
+		t.Error(err)
+	}
+
+	t.Run("experts", func(t *testing.T) {
+		experts := tensor
+		experts.Shape = []uint64{256, 8, 2}
+
+		// each expert has its own importance
+		if _, err := quantizeData(t.Context(), b.Bytes(), &experts, tensorTypeIQ2_XS, append(slices.Clone(importance), importance...)); err != nil {
+			t.Error(err)
+		}
+
+		if _, err := quantizeData(t.Context(), b.Bytes(), &experts, tensorTypeIQ2_XS, importance[:128]); err == nil {
+			t.Error("expected importance of the wrong size to fail")
+		}
+	})
+
+	t.Run("types", func(t *testing.T) {
+		embd := ggml.Tensor{Name: "token_embd.weight", Kind: tensorTypeF16, Shape: []uint64{256, 16}}
+		if kind := quantizeTensorType(&embd, "IQ2_XS", 1, false); kind != tensorTypeQ2_K {
+			t.Errorf("expected tensors without importance to fall back to q2_K, got %d", kind)
+		}
+
+		if kind := quantizeTensorType(&embd, "IQ2_XS", 1, true); kind != tensorTypeIQ2_XS {
+			t.Errorf("expected iq2_xs, got %d", kind)
+		}
+
+		output := ggml.Tensor{Name: "output.weight", Kind: tensorTypeF16, Shape: []uint64{256, 16}}
+		if kind := quantizeTensorType(&output, "IQ2_XS", 1, true); kind != tensorTypeQ5_K {
+			t.Errorf("expected output to keep more bits, got %d", kind)
+		}
+	})
+}
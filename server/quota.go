@@ -0,0 +1,184 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/types/model"
+)
+
+// defaultQuota is the namespace in OLLAMA_NAMESPACE_QUOTAS which sets the
+// quota of namespaces without their own.
+const defaultQuota = "*"
+
+// parseQuotas parses a comma separated list of quotas such as
+// "team-a=100GB,team-b=50GB,*=20GB". Namespaces are case-insensitive.
+func parseQuotas(s string) (map[string]int64, error) {
+	quotas := make(map[string]int64)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		namespace, size, ok := strings.Cut(field, "=")
+		if !ok || strings.TrimSpace(namespace) == "" {
+			return nil, fmt.Errorf("invalid quota %q", field)
+		}
+
+		n, err := format.ParseBytes(size)
+		if err != nil {
+			return nil, err
+		}
+
+		quotas[strings.ToLower(strings.TrimSpace(namespace))] = n
+	}
+
+	return quotas, nil
+}
+
+// namespaceQuotas caches the parsed OLLAMA_NAMESPACE_QUOTAS.
+var namespaceQuotas struct {
+	sync.Mutex
+	s      string
+	quotas map[string]int64
+}
+
+func quotas() map[string]int64 {
+	s := envconfig.NamespaceQuotas()
+
+	namespaceQuotas.Lock()
+	defer namespaceQuotas.Unlock()
+
+	if s != namespaceQuotas.s || namespaceQuotas.quotas == nil {
+		quotas, err := parseQuotas(s)
+		if err != nil {
+			slog.Warn("invalid namespace quotas, ignoring", "quotas", s, "error", err)
+			quotas = make(map[string]int64)
+		}
+
+		namespaceQuotas.s, namespaceQuotas.quotas = s, quotas
+	}
+
+	return namespaceQuotas.quotas
+}
+
+// namespaceQuota returns the quota of namespace and whether it has one.
+func namespaceQuota(namespace string) (int64, bool) {
+	qs := quotas()
+	if quota, ok := qs[strings.ToLower(namespace)]; ok {
+		return quota, true
+	}
+
+	quota, ok := qs[defaultQuota]
+	return quota, ok
+}
+
+// namespaceBlobs returns the sizes of the blobs used by the models in each
+// namespace, except the model named skip.
+func namespaceBlobs(ms map[model.Name]*Manifest, skip model.Name) map[string]map[string]int64 {
+	blobs := make(map[string]map[string]int64)
+	for n, m := range ms {
+		if n.EqualFold(skip) {
+			continue
+		}
+
+		namespace := strings.ToLower(n.Namespace)
+		if blobs[namespace] == nil {
+			blobs[namespace] = make(map[string]int64)
+		}
+
+		maps.Copy(blobs[namespace], manifestBlobs(m))
+	}
+
+	return blobs
+}
+
+func sumSizes(blobs map[string]int64) (size int64) {
+	for _, n := range blobs {
+		size += n
+	}
+
+	return size
+}
+
+// namespaceUsage returns the disk space used by each namespace with models
+// or a quota of its own.
+func namespaceUsage(ms map[model.Name]*Manifest) []api.NamespaceUsage {
+	blobs := namespaceBlobs(ms, model.Name{})
+	for namespace := range quotas() {
+		if _, ok := blobs[namespace]; !ok && namespace != defaultQuota {
+			blobs[namespace] = nil
+		}
+	}
+
+	var usage []api.NamespaceUsage
+	for _, namespace := range slices.Sorted(maps.Keys(blobs)) {
+		u := api.NamespaceUsage{Namespace: namespace, Size: sumSizes(blobs[namespace])}
+		u.Quota, _ = namespaceQuota(namespace)
+		usage = append(usage, u)
+	}
+
+	return usage
+}
+
+// checkQuota returns an api.QuotaError if writing the model named n with
+// layers would exceed the quota of its namespace. The current version of
+// the model doesn't count towards the quota since it's replaced.
+func checkQuota(n model.Name, layers []Layer) error {
+	quota, ok := namespaceQuota(n.Namespace)
+	if !ok {
+		return nil
+	}
+
+	ms, err := Manifests(true)
+	if err != nil {
+		return err
+	}
+
+	blobs := namespaceBlobs(ms, n)[strings.ToLower(n.Namespace)]
+
+	var required int64
+	seen := make(map[string]bool)
+	for _, layer := range layers {
+		if _, ok := blobs[layer.Digest]; ok || seen[layer.Digest] || layer.Digest == "" {
+			continue
+		}
+
+		seen[layer.Digest] = true
+		required += layer.Size
+	}
+
+	if used := sumSizes(blobs); used+required > quota {
+		return api.QuotaError{
+			Namespace: strings.ToLower(n.Namespace),
+			Quota:     quota,
+			Used:      used,
+			Required:  required,
+		}
+	}
+
+	return nil
+}
+
+// progressError returns the response streamed for errors during pulls and
+// creates, which includes the details of quota errors.
+func progressError(err error) gin.H {
+	var qerr api.QuotaError
+	if errors.As(err, &qerr) {
+		return gin.H{"error": err.Error(), "quota": qerr, "status": http.StatusInsufficientStorage}
+	}
+
+	return gin.H{"error": err.Error()}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestParseQuotas(t *testing.T) {
+	cases := []struct {
+		in     string
+		expect map[string]int64
+		err    bool
+	}{
+		{in: "", expect: map[string]int64{}},
+		{in: "team-a=100B", expect: map[string]int64{"team-a": 100}},
+		{in: " Team-A = 1KB , *=2KB ", expect: map[string]int64{"team-a": 1000, "*": 2000}},
+		{in: "team-a", err: true},
+		{in: "=1GB", err: true},
+		{in: "team-a=lots", err: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			quotas, err := parseQuotas(tt.in)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.expect, quotas); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_NAMESPACE_QUOTAS", "team-a=10B,*=4B")
+
+	shared := writeTestBlob(t, "shared")
+	if err := WriteManifest(t.Context(), model.ParseName("team-a/a"), Layer{}, []Layer{shared}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		model  string
+		layers []Layer
+		expect *api.QuotaError
+	}{
+		{name: "within quota", model: "team-a/b", layers: []Layer{{Digest: "sha256:b", Size: 4}}},
+		{name: "shared blobs", model: "team-a/b", layers: []Layer{shared, {Digest: "sha256:b", Size: 4}}},
+		{name: "case-insensitive", model: "Team-A/b", layers: []Layer{{Digest: "sha256:b", Size: 4}}},
+		{
+			name:   "exceeds quota",
+			model:  "team-a/b",
+			layers: []Layer{{Digest: "sha256:b", Size: 5}},
+			expect: &api.QuotaError{Namespace: "team-a", Quota: 10, Used: 6, Required: 5},
+		},
+		{name: "replaces model", model: "team-a/a", layers: []Layer{{Digest: "sha256:b", Size: 10}}},
+		{
+			name:   "default quota",
+			model:  "team-b/b",
+			layers: []Layer{shared},
+			expect: &api.QuotaError{Namespace: "team-b", Quota: 4, Required: 6},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkQuota(model.ParseName(tt.model), tt.layers)
+			if tt.expect == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			var qerr api.QuotaError
+			if !errors.As(err, &qerr) {
+				t.Fatalf("expected quota error, got %v", err)
+			}
+
+			if diff := cmp.Diff(*tt.expect, qerr); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCreateExceedsQuota(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_NAMESPACE_QUOTAS", "*=1B")
+
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   "test",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusInsufficientStorage {
+		t.Fatalf("expected status code 507, actual %d", w.Code)
+	}
+
+	var resp struct {
+		Quota api.QuotaError `json:"quota"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Quota.Namespace != "library" || resp.Quota.Quota != 1 {
+		t.Errorf("unexpected quota error %+v", resp.Quota)
+	}
+
+	if _, err := ParseNamedManifest(model.ParseName("test")); err == nil {
+		t.Error("expected model not to be created")
+	}
+}
+
+func TestListUsage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_NAMESPACE_QUOTAS", "team-a=1KB,team-b=2KB")
+
+	shared := writeTestBlob(t, "shared")
+	for _, n := range []string{"team-a/a", "team-a/b"} {
+		if err := WriteManifest(t.Context(), model.ParseName(n), Layer{}, []Layer{shared}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var s Server
+	w := createRequest(t, s.ListHandler, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	var resp api.ListResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []api.NamespaceUsage{
+		{Namespace: "team-a", Size: 6, Quota: 1000},
+		{Namespace: "team-b", Quota: 2000},
+	}
+
+	if diff := cmp.Diff(expect, resp.Usage); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
+)
+
+// rateLimiter is a token bucket shared by concurrent transfers. The rate is
+// read on every call so changes to the environment take effect immediately.
+type rateLimiter struct {
+	// rate returns the limit in bytes per second; zero or less is unlimited
+	rate func() int64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate func() int64) *rateLimiter {
+	return &rateLimiter{rate: rate}
+}
+
+// WaitN blocks until n bytes may be transferred or ctx is done.
+func (l *rateLimiter) WaitN(ctx context.Context, n int) error {
+	rate := l.rate()
+	if rate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		// allow bursts of up to one second worth of bytes
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*float64(rate), float64(rate))
+	}
+
+	l.last = now
+	l.tokens -= float64(n)
+
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// rateLimitedReader throttles reads to the slowest of its limiters.
+type rateLimitedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rateLimiter
+}
+
+func (r *rateLimitedReader) Read(b []byte) (int, error) {
+	// read at most a tenth of a second worth of bytes at a time so slow
+	// rates make steady progress instead of stalling on large reads
+	for _, l := range r.limiters {
+		if rate := l.rate(); rate > 0 {
+			b = b[:min(int64(len(b)), max(rate/10, 1))]
+		}
+	}
+
+	n, err := r.r.Read(b)
+	if n > 0 {
+		for _, l := range r.limiters {
+			if err := l.WaitN(r.ctx, n); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, err
+}
+
+// rateWindow sets the download rate between two times of day. Windows
+// which end before they start wrap around midnight.
+type rateWindow struct {
+	start, end time.Duration
+	rate       int64
+}
+
+func (w rateWindow) contains(now time.Time) bool {
+	h, m, _ := now.Clock()
+	t := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+	if w.start <= w.end {
+		return t >= w.start && t < w.end
+	}
+
+	return t >= w.start || t < w.end
+}
+
+// parseRateSchedule parses a comma separated list of windows such as
+// "19:00-07:00" or "09:00-17:00=1MB". A window without a rate is unlimited.
+func parseRateSchedule(s string) ([]rateWindow, error) {
+	var windows []rateWindow
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		span, rate, hasRate := strings.Cut(field, "=")
+		from, to, ok := strings.Cut(span, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule window %q", field)
+		}
+
+		var w rateWindow
+		var err error
+		if w.start, err = parseTimeOfDay(from); err != nil {
+			return nil, err
+		}
+
+		if w.end, err = parseTimeOfDay(to); err != nil {
+			return nil, err
+		}
+
+		if hasRate {
+			if w.rate, err = format.ParseBytes(rate); err != nil {
+				return nil, err
+			}
+		}
+
+		windows = append(windows, w)
+	}
+
+	return windows, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// downloadSchedule caches the parsed OLLAMA_DOWNLOAD_SCHEDULE since it's
+// consulted on every read.
+var downloadSchedule struct {
+	sync.Mutex
+	s       string
+	windows []rateWindow
+}
+
+// downloadRate returns the download rate for the current time of day. The
+// first scheduled window containing now wins, otherwise the rate is
+// OLLAMA_MAX_DOWNLOAD_RATE.
+func downloadRate() int64 {
+	return scheduledDownloadRate(time.Now())
+}
+
+func scheduledDownloadRate(now time.Time) int64 {
+	s := envconfig.DownloadSchedule()
+
+	downloadSchedule.Lock()
+	if s != downloadSchedule.s {
+		windows, err := parseRateSchedule(s)
+		if err != nil {
+			slog.Warn("invalid download schedule, ignoring", "schedule", s, "error", err)
+		}
+
+		downloadSchedule.s, downloadSchedule.windows = s, windows
+	}
+	windows := downloadSchedule.windows
+	downloadSchedule.Unlock()
+
+	for _, w := range windows {
+		if w.contains(now) {
+			return w.rate
+		}
+	}
+
+	return envconfig.MaxDownloadRate()
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("unlimited", func(t *testing.T) {
+		l := newRateLimiter(func() int64 { return 0 })
+
+		start := time.Now()
+		for range 100 {
+			if err := l.WaitN(context.Background(), 1<<20); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Errorf("expected no delay, got %s", elapsed)
+		}
+	})
+
+	t.Run("limited", func(t *testing.T) {
+		l := newRateLimiter(func() int64 { return 10_000 })
+
+		start := time.Now()
+		for range 3 {
+			if err := l.WaitN(context.Background(), 1_000); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+			t.Errorf("expected 3000 bytes at 10000 bytes/s to take at least 300ms, got %s", elapsed)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		l := newRateLimiter(func() int64 { return 1 })
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if err := l.WaitN(ctx, 1_000); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestParseRateSchedule(t *testing.T) {
+	cases := map[string][]rateWindow{
+		"":            nil,
+		"19:00-07:00": {{start: 19 * time.Hour, end: 7 * time.Hour}},
+		"09:00-17:30=1MB, 12:00-13:00": {
+			{start: 9 * time.Hour, end: 17*time.Hour + 30*time.Minute, rate: 1_000_000},
+			{start: 12 * time.Hour, end: 13 * time.Hour},
+		},
+	}
+
+	for s, expect := range cases {
+		t.Run(s, func(t *testing.T) {
+			windows, err := parseRateSchedule(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(expect, windows, cmp.AllowUnexported(rateWindow{})); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	for _, s := range []string{"19:00", "7pm-7am", "19:00-07:00=fast", "25:00-07:00"} {
+		t.Run(s, func(t *testing.T) {
+			if _, err := parseRateSchedule(s); err == nil {
+				t.Errorf("expected error for %q", s)
+			}
+		})
+	}
+}
+
+func TestScheduledDownloadRate(t *testing.T) {
+	t.Setenv("OLLAMA_MAX_DOWNLOAD_RATE", "5MB")
+	t.Setenv("OLLAMA_DOWNLOAD_SCHEDULE", "19:00-07:00,09:00-17:00=1MB")
+
+	cases := map[string]int64{
+		"08:00": 5_000_000,
+		"12:00": 1_000_000,
+		"17:00": 5_000_000,
+		"19:00": 0,
+		"23:59": 0,
+		"03:00": 0,
+	}
+
+	for clock, expect := range cases {
+		t.Run(clock, func(t *testing.T) {
+			now, err := time.Parse("15:04", clock)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if rate := scheduledDownloadRate(now); rate != expect {
+				t.Errorf("expected %d, got %d", expect, rate)
+			}
+		})
+	}
+
+	t.Setenv("OLLAMA_DOWNLOAD_SCHEDULE", "invalid")
+	if rate := scheduledDownloadRate(time.Now()); rate != 5_000_000 {
+		t.Errorf("expected invalid schedule to be ignored, got %d", rate)
+	}
+}
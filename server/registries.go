@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/transport"
+	"github.com/ollama/ollama/version"
+)
+
+var errRegistryConfig = errors.New("invalid registries config")
+
+// registryConfig is the settings of a registry in the OLLAMA_REGISTRIES
+// file, which is a JSON object of them by the registry's host and port.
+// Mirrors can also be set for a namespace of a registry, by its host, port
+// and namespace, e.g. registry.ollama.ai/library.
+type registryConfig struct {
+	// Insecure allows pulling from and pushing to the registry over HTTP,
+	// and skips verifying its certificate over HTTPS.
+	Insecure bool `json:"insecure,omitempty"`
+
+	// CABundle is the path of a PEM file of certificates to trust for the
+	// registry, as well as the system's and those in OLLAMA_CA_BUNDLE.
+	CABundle string `json:"ca_bundle,omitempty"`
+
+	// Mirrors are the URLs of registries to pull models from before this
+	// one, in order, e.g. https://mirror.example.com. Manifests pulled from
+	// mirrors must match the one in this registry.
+	Mirrors []string `json:"mirrors,omitempty"`
+
+	// AllowUnverified allows pulling manifests from mirrors when this
+	// registry can't be reached to check them against.
+	AllowUnverified bool `json:"allow_unverified,omitempty"`
+}
+
+// loadedRegistries are the registry settings read from the OLLAMA_REGISTRIES
+// file, which is read again when it changes.
+var loadedRegistries struct {
+	mu         sync.Mutex
+	path       string
+	modTime    time.Time
+	registries map[string]registryConfig
+}
+
+// readRegistries returns the settings of each registry in the file at path,
+// or none if path is empty.
+func readRegistries(path string) (map[string]registryConfig, error) {
+	loadedRegistries.mu.Lock()
+	defer loadedRegistries.mu.Unlock()
+
+	var modTime time.Time
+	if path != "" {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errRegistryConfig, err)
+		}
+		modTime = fi.ModTime()
+	}
+
+	if path == loadedRegistries.path && modTime.Equal(loadedRegistries.modTime) {
+		return loadedRegistries.registries, nil
+	}
+
+	configs := make(map[string]registryConfig)
+	if path != "" {
+		bts, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errRegistryConfig, err)
+		}
+
+		if err := json.Unmarshal(bts, &configs); err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", errRegistryConfig, path, err)
+		}
+	}
+
+	registries := make(map[string]registryConfig, len(configs))
+	for key, config := range configs {
+		host, namespace, ok := strings.Cut(key, "/")
+		if host == "" || strings.Contains(key, " ") || ok && (namespace == "" || strings.Contains(namespace, "/")) {
+			return nil, fmt.Errorf("%w: %s: %q isn't a registry host or namespace", errRegistryConfig, path, key)
+		}
+
+		if ok && (config.Insecure || config.CABundle != "") {
+			return nil, fmt.Errorf("%w: %s: %s: only mirrors can be set for a namespace", errRegistryConfig, path, key)
+		}
+
+		for _, mirror := range config.Mirrors {
+			u, err := url.Parse(mirror)
+			if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+				return nil, fmt.Errorf("%w: %s: %s: mirror %q isn't the URL of a registry", errRegistryConfig, path, key, mirror)
+			}
+		}
+
+		if config.CABundle != "" {
+			if _, err := os.Stat(config.CABundle); err != nil {
+				return nil, fmt.Errorf("%w: %s: registry %s: %w", errRegistryConfig, path, key, err)
+			}
+		}
+
+		registries[strings.ToLower(key)] = config
+	}
+
+	loadedRegistries.path, loadedRegistries.modTime, loadedRegistries.registries = path, modTime, registries
+	return registries, nil
+}
+
+// registryFor returns the settings of the registry at host, which may have
+// a port.
+func registryFor(host string) (registryConfig, error) {
+	registries, err := readRegistries(envconfig.Registries())
+	if err != nil {
+		return registryConfig{}, err
+	}
+
+	return registries[strings.ToLower(host)], nil
+}
+
+// insecureRegistry reports whether the registry at host may be used over
+// HTTP.
+func insecureRegistry(host string) bool {
+	config, err := registryFor(host)
+	return err == nil && config.Insecure
+}
+
+// registryClient returns the client to make requests to u with, which uses
+// the proxy and certificates for its registry.
+func registryClient(u *url.URL, regOpts *registryOptions) (*http.Client, error) {
+	config, err := registryFor(u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := transport.New(transport.Options{CABundle: config.CABundle, Insecure: config.Insecure})
+	if err != nil {
+		return nil, fmt.Errorf("registry %s: %w", u.Host, err)
+	}
+
+	c := &http.Client{Transport: t}
+	if regOpts != nil {
+		c.CheckRedirect = regOpts.CheckRedirect
+	}
+
+	return c, nil
+}
+
+// testMakeRequestDialContext replaces how makeRequest dials registries in
+// tests.
+var testMakeRequestDialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// makeRequest makes a request to the registry of requestURL with the client
+// for it, so every request to a registry, such as for manifests, blobs and
+// tokens, uses its proxy and certificates.
+func makeRequest(ctx context.Context, method string, requestURL *url.URL, headers http.Header, body io.Reader, regOpts *registryOptions) (*http.Response, error) {
+	if requestURL.Scheme != "http" && regOpts != nil && regOpts.Insecure {
+		requestURL.Scheme = "http"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	if headers != nil {
+		req.Header = headers
+	}
+
+	if regOpts != nil {
+		if regOpts.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+regOpts.Token)
+		} else if regOpts.Username != "" && regOpts.Password != "" {
+			req.SetBasicAuth(regOpts.Username, regOpts.Password)
+		}
+	}
+
+	req.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+
+	if s := req.Header.Get("Content-Length"); s != "" {
+		contentLength, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		req.ContentLength = contentLength
+	}
+
+	c, err := registryClient(requestURL, regOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	if testMakeRequestDialContext != nil {
+		t := c.Transport.(*http.Transport).Clone()
+		t.DialContext = testMakeRequestDialContext
+		c.Transport = t
+	}
+
+	return c.Do(req)
+}
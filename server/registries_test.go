@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeRegistries(t *testing.T, dir, config string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, "registries.json")
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestReadRegistries(t *testing.T) {
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(bundle, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeRegistries(t, dir, `{"Registry.Example.com:5000": {"insecure": true}, "internal.example.com": {"ca_bundle": "`+filepath.ToSlash(bundle)+`"}}`)
+	registries, err := readRegistries(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(registries, map[string]registryConfig{
+		"registry.example.com:5000": {Insecure: true},
+		"internal.example.com":      {CABundle: filepath.ToSlash(bundle)},
+	}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	// the file is read again when it changes
+	writeRegistries(t, dir, `{}`)
+	if err := os.Chtimes(path, time.Time{}, time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if registries, err := readRegistries(path); err != nil || len(registries) != 0 {
+		t.Errorf("expected no registries, got %v, %v", registries, err)
+	}
+
+	if registries, err := readRegistries(""); err != nil || len(registries) != 0 {
+		t.Errorf("expected no registries, got %v, %v", registries, err)
+	}
+
+	for _, config := range []string{
+		`not json`,
+		`{"registry.example.com/library/test": {}}`,
+		`{"registry.example.com/": {}}`,
+		`{"registry.example.com/library": {"insecure": true}}`,
+		`{"registry.example.com": {"mirrors": ["mirror.example.com"]}}`,
+		`{"registry.example.com": {"mirrors": ["https://mirror.example.com/library"]}}`,
+		`{"": {}}`,
+		`{"registry.example.com": {"ca_bundle": "` + filepath.ToSlash(filepath.Join(dir, "missing.pem")) + `"}}`,
+	} {
+		if _, err := readRegistries(writeRegistries(t, t.TempDir(), config)); !errors.Is(err, errRegistryConfig) {
+			t.Errorf("%s: expected an invalid config, got %v", config, err)
+		}
+	}
+}
+
+func TestRegistryClient(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_CA_BUNDLE", "")
+
+	cases := []struct {
+		name   string
+		config string
+		ok     bool
+	}{
+		{"none", `{}`, false},
+		{"ca bundle", `{"` + u.Host + `": {"ca_bundle": "` + filepath.ToSlash(bundle) + `"}}`, true},
+		{"insecure", `{"` + u.Host + `": {"insecure": true}}`, true},
+		{"other registry", `{"registry.example.com": {"insecure": true}}`, false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OLLAMA_REGISTRIES", writeRegistries(t, t.TempDir(), tt.config))
+
+			c, err := registryClient(u, &registryOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := c.Get(s.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			if ok := err == nil; ok != tt.ok {
+				t.Errorf("expected the request to succeed %t, got %v", tt.ok, err)
+			}
+
+			if insecure := insecureRegistry(u.Host); insecure != (tt.name == "insecure") {
+				t.Errorf("expected insecure %t, got %t", !insecure, insecure)
+			}
+		})
+	}
+
+	t.Setenv("OLLAMA_REGISTRIES", filepath.Join(dir, "missing.json"))
+	if _, err := registryClient(u, nil); !errors.Is(err, errRegistryConfig) {
+		t.Errorf("expected an invalid config, got %v", err)
+	}
+}
+
+func TestRegistryManifestPull(t *testing.T) {
+	manifest := `{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json","config":{"mediaType":"application/vnd.docker.container.image.v1+json","digest":"sha256:abc","size":2},"layers":[]}`
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/library/test/manifests/latest" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+		w.Write([]byte(manifest))
+	}))
+	defer s.Close()
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_CA_BUNDLE", "")
+
+	mp := ParseModelPath(u.Host + "/library/test:latest")
+
+	t.Run("untrusted", func(t *testing.T) {
+		t.Setenv("OLLAMA_REGISTRIES", writeRegistries(t, t.TempDir(), `{}`))
+
+		if _, err := pullManifest(t.Context(), mp, "latest", &registryOptions{}); err == nil {
+			t.Error("expected the registry's certificate to be untrusted")
+		}
+	})
+
+	t.Run("ca bundle", func(t *testing.T) {
+		t.Setenv("OLLAMA_REGISTRIES", writeRegistries(t, t.TempDir(), `{"`+u.Host+`": {"ca_bundle": "`+filepath.ToSlash(bundle)+`"}}`))
+
+		m, err := pullManifest(t.Context(), mp, "latest", &registryOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if m.Config.Digest != "sha256:abc" {
+			t.Errorf("expected config sha256:abc, got %s", m.Config.Digest)
+		}
+	})
+}
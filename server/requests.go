@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// requestIDKey is the key of the request ID in the gin context.
+const requestIDKey = "request_id"
+
+// errRequestCanceled is the cause of requests stopped by
+// [Server.CancelRequestHandler], as opposed to their client disconnecting.
+var errRequestCanceled = fmt.Errorf("request canceled: %w", context.Canceled)
+
+// requests tracks the requests in flight by ID so they can be canceled from
+// another connection, such as by a load balancer or UI.
+type requests struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelCauseFunc
+}
+
+// add tracks a request, returning false if its ID is already in use.
+func (r *requests) add(id string, cancel context.CancelCauseFunc) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.cancels[id]; ok {
+		return false
+	}
+
+	if r.cancels == nil {
+		r.cancels = make(map[string]context.CancelCauseFunc)
+	}
+
+	r.cancels[id] = cancel
+	return true
+}
+
+func (r *requests) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cancels, id)
+}
+
+// cancel stops the request with id, returning false if there isn't one.
+func (r *requests) cancel(id string) bool {
+	r.mu.Lock()
+	cancel, ok := r.cancels[id]
+	r.mu.Unlock()
+
+	if ok {
+		cancel(errRequestCanceled)
+	}
+
+	return ok
+}
+
+// requestMiddleware gives each request an ID, from its X-Request-ID header
+// if it has one, and tracks it until it's done so it can be canceled. The
+// ID is returned in the response's X-Request-ID header.
+func (s *Server) requestMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if id == "" {
+			id = uuid.NewString()
+		}
+
+		ctx, cancel := context.WithCancelCause(c.Request.Context())
+		defer cancel(nil)
+
+		key := tenantScoped(c, id)
+		if !s.requests.add(key, cancel) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("request ID %q is already in use", id)})
+			return
+		}
+		defer s.requests.remove(key)
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Set(requestIDKey, id)
+		c.Header("X-Request-ID", id)
+		c.Next()
+	}
+}
+
+// CancelRequestHandler stops the request in flight with the ID in the path,
+// freeing the runner it was using as if its client had disconnected.
+func (s *Server) CancelRequestHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !s.requests.cancel(tenantScoped(c, id)) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("request %q not found", id)})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "canceled"})
+}
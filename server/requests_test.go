@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestCancelRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var s Server
+	started := make(chan string)
+	r := gin.New()
+	r.POST("/slow", s.requestMiddleware(), func(c *gin.Context) {
+		started <- c.GetString(requestIDKey)
+		<-c.Request.Context().Done()
+		c.JSON(http.StatusOK, gin.H{"error": context.Cause(c.Request.Context()).Error()})
+	})
+	r.POST("/api/requests/:id/cancel", s.CancelRequestHandler)
+
+	post := func(path, id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post("/slow", "") }()
+	id := <-started
+	if id == "" {
+		t.Fatal("expected the request to have an ID")
+	}
+
+	if w := post("/api/requests/unknown/cancel", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status code 404, actual %d", w.Code)
+	}
+
+	if w := post("/slow", id); w.Code != http.StatusConflict {
+		t.Errorf("expected status code 409 for an ID in use, actual %d", w.Code)
+	}
+
+	if w := post("/api/requests/"+id+"/cancel", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	w := <-done
+	if got := w.Header().Get("X-Request-ID"); got != id {
+		t.Errorf("expected X-Request-ID %q, got %q", id, got)
+	}
+
+	if want := `{"error":"request canceled: context canceled"}`; w.Body.String() != want {
+		t.Errorf("expected %s, got %s", want, w.Body.String())
+	}
+
+	if !errors.Is(errRequestCanceled, context.Canceled) || errorCode(errRequestCanceled) != api.ErrorCodeCanceled {
+		t.Error("expected a canceled request to have the code CANCELED")
+	}
+
+	// the request isn't tracked once it's done
+	if w := post("/api/requests/"+id+"/cancel", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status code 404 once the request is done, actual %d", w.Code)
+	}
+}
@@ -4,13 +4,13 @@ import (
 	"bytes"
 	"cmp"
 	"context"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"math"
 	"net"
 	"net/http"
@@ -20,23 +20,27 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/discover"
 	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/format"
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/llm"
-	"github.com/ollama/ollama/model/models/mllama"
 	"github.com/ollama/ollama/openai"
+	"github.com/ollama/ollama/runner/common"
 	"github.com/ollama/ollama/server/internal/client/ollama"
 	"github.com/ollama/ollama/server/internal/registry"
 	"github.com/ollama/ollama/template"
+	"github.com/ollama/ollama/transport"
 	"github.com/ollama/ollama/types/errtypes"
 	"github.com/ollama/ollama/types/model"
 	"github.com/ollama/ollama/version"
@@ -53,6 +57,33 @@ var mode string = gin.DebugMode
 type Server struct {
 	addr  net.Addr
 	sched *Scheduler
+
+	// shutdown is closed to shut the server down gracefully
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
+
+	// origins is the CORS policy, replaced when the config is reloaded
+	origins atomic.Pointer[originPolicy]
+
+	// requests are the inference requests in flight, to cancel by ID
+	requests requests
+
+	// pulls are the pulls running on the server
+	pulls pulls
+
+	// draining is set once the server starts shutting down, so pulls cut
+	// off by the shutdown are resumed when it starts again
+	draining atomic.Bool
+
+	// responses are kept for idempotency keys and identical requests
+	responses responseCache
+
+	// uses are when models were last used, written to the usage file
+	// periodically and at shutdown
+	uses modelUses
+
+	// prompts are embedded to find the responses to similar ones
+	prompts semanticCache
 }
 
 func init() {
@@ -68,8 +99,10 @@ func init() {
 }
 
 var (
-	errRequired    = errors.New("is required")
-	errBadTemplate = errors.New("template error")
+	errRequired       = errors.New("is required")
+	errBadTemplate    = errors.New("template error")
+	errInvalidAdapter = errors.New("invalid adapter")
+	errInvalidOption  = errors.New("invalid option")
 )
 
 func modelOptions(model *Model, requestOpts map[string]interface{}) (api.Options, error) {
@@ -85,9 +118,57 @@ func modelOptions(model *Model, requestOpts map[string]interface{}) (api.Options
 	return opts, nil
 }
 
+// validatePlacement checks the options which place the model when it's
+// loaded, returning the parts the offload option places.
+func validatePlacement(opts api.Options) (api.Offload, error) {
+	offload, err := api.ParseOffload(opts.Offload)
+	if err != nil {
+		return api.Offload{}, fmt.Errorf("%w: %w", errInvalidOption, err)
+	}
+
+	// streamed weights are read from pinned copies rather than the file
+	if opts.WeightStreaming && opts.UseMMap != nil && *opts.UseMMap {
+		return api.Offload{}, fmt.Errorf("%w: weight_streaming can't be used with use_mmap", errInvalidOption)
+	}
+
+	return offload, nil
+}
+
+// validateSampling checks the sampling options which can't be used, and warns
+// about those which have no effect together.
+func validateSampling(opts api.Options) error {
+	switch {
+	case opts.Mirostat < 0 || opts.Mirostat > 2:
+		return fmt.Errorf("%w: mirostat must be 0, 1 or 2", errInvalidOption)
+	case opts.DryMultiplier < 0:
+		return fmt.Errorf("%w: dry_multiplier must not be negative", errInvalidOption)
+	case opts.DryMultiplier > 0 && opts.DryBase < 1:
+		return fmt.Errorf("%w: dry_base must be at least 1", errInvalidOption)
+	case opts.DryAllowedLength < 0:
+		return fmt.Errorf("%w: dry_allowed_length must not be negative", errInvalidOption)
+	case opts.DryPenaltyLastN < -1:
+		return fmt.Errorf("%w: dry_penalty_last_n must be at least -1", errInvalidOption)
+	case opts.XTCProbability < 0 || opts.XTCProbability > 1:
+		return fmt.Errorf("%w: xtc_probability must be between 0 and 1", errInvalidOption)
+	case opts.XTCThreshold < 0 || opts.XTCThreshold > 1:
+		return fmt.Errorf("%w: xtc_threshold must be between 0 and 1", errInvalidOption)
+	}
+
+	if opts.Mirostat != 0 && (opts.DryMultiplier > 0 || opts.XTCProbability > 0) {
+		slog.Warn("mirostat replaces the dry and xtc samplers", "mirostat", opts.Mirostat)
+	}
+
+	if opts.XTCProbability > 0 && opts.XTCThreshold > 0.5 {
+		slog.Warn("xtc has no effect with a threshold above 0.5", "xtc_threshold", opts.XTCThreshold)
+	}
+
+	return nil
+}
+
 // scheduleRunner schedules a runner after validating inputs such as capabilities and model options.
+// If adapter is set, the adapters of that model are used instead of those of the named model.
 // It returns the allocated runner, model instance, and consolidated options if successful and error otherwise.
-func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capability, requestOpts map[string]any, keepAlive *api.Duration) (llm.LlamaServer, *Model, *api.Options, error) {
+func (s *Server) scheduleRunner(ctx context.Context, name, adapter string, caps []Capability, requestOpts map[string]any, keepAlive *api.Duration) (llm.LlamaServer, *Model, *api.Options, error) {
 	if name == "" {
 		return nil, nil, nil, fmt.Errorf("model %w", errRequired)
 	}
@@ -97,6 +178,18 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capabil
 		return nil, nil, nil, err
 	}
 
+	if adapter != "" {
+		if model, err = withAdapter(model, adapter); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	s.uses.record(model.Name, time.Now())
+
+	if err := fetchModelBlobs(ctx, model); err != nil {
+		return nil, nil, nil, err
+	}
+
 	if err := model.CheckCapabilities(caps...); err != nil {
 		return nil, nil, nil, fmt.Errorf("%s %w", name, err)
 	}
@@ -106,6 +199,22 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capabil
 		return nil, nil, nil, err
 	}
 
+	if err := applyLimits(&opts, requestOpts); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if _, err := common.NewStops(opts.Stop, opts.StopRegex, opts.StopTokens); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := validateSampling(opts); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if _, err := validatePlacement(opts); err != nil {
+		return nil, nil, nil, err
+	}
+
 	runnerCh, errCh := s.sched.GetRunner(ctx, model, opts, keepAlive)
 	var runner *runnerRef
 	select {
@@ -117,6 +226,87 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capabil
 	return runner.llama, model, &opts, nil
 }
 
+// generateValues returns the template values of a generate request for m.
+// The messages of m are included if history is set.
+func generateValues(m *Model, prompt, system, suffix string, images []llm.ImageData, history bool) (template.Values, error) {
+	if suffix != "" {
+		return template.Values{Prompt: prompt, Suffix: suffix}, nil
+	}
+
+	var msgs []api.Message
+	if system != "" {
+		msgs = append(msgs, api.Message{Role: "system", Content: system})
+	} else if m.System != "" {
+		msgs = append(msgs, api.Message{Role: "system", Content: m.System})
+	}
+
+	if history {
+		msgs = append(msgs, m.Messages...)
+	}
+
+	imgPrompt := ""
+	if checkMllamaModelFamily(m) {
+		imgPrompt = "<|image|>"
+	}
+
+	if imagePlaceholder.MatchString(prompt) {
+		// images with a placeholder in the prompt are placed there, and the
+		// rest go before it
+		ids := make([]int, len(images))
+		for i, image := range images {
+			ids[i] = image.ID
+		}
+
+		prefix, content, err := placeImages(prompt, ids, imgPrompt)
+		if err != nil {
+			return template.Values{}, err
+		}
+
+		prompt = prefix + content
+	} else {
+		for _, i := range images {
+			msgs = append(msgs, api.Message{Role: "user", Content: fmt.Sprintf("[img-%d]"+imgPrompt, i.ID)})
+		}
+	}
+
+	msgs, err := applySystemPolicy(m.Name, append(msgs, api.Message{Role: "user", Content: prompt}))
+	if err != nil {
+		return template.Values{}, err
+	}
+
+	return template.Values{Messages: msgs, Think: true}, nil
+}
+
+// withAdapter returns a copy of m with the adapters of the model named
+// adapter, which must have been created with an adapter of the weights of m.
+// Both share the runner of m.
+func withAdapter(m *Model, adapter string) (*Model, error) {
+	n, err := getExistingName(model.ParseName(adapter))
+	if err != nil {
+		return nil, err
+	}
+
+	n, _, err = resolveAlias(n)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	a, err := GetModel(n.String())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w, model %q not found", errInvalidAdapter, adapter)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(a.AdapterPaths) == 0 || a.ModelPath != m.ModelPath {
+		return nil, fmt.Errorf("%w, %q isn't an adapter of %q", errInvalidAdapter, adapter, m.ShortName)
+	}
+
+	withAdapter := *m
+	withAdapter.AdapterPaths = a.AdapterPaths
+	return &withAdapter, nil
+}
+
 func (s *Server) GenerateHandler(c *gin.Context) {
 	checkpointStart := time.Now()
 	var req api.GenerateRequest
@@ -132,7 +322,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	if !name.IsValid() {
 		// Ideally this is "invalid model name" but we're keeping with
 		// what the API currently returns until we can change it.
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
 		return
 	}
 
@@ -140,7 +330,13 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	// induce infinite recursion given the current code structure.
 	name, err := getExistingName(name)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		return
+	}
+
+	name, _, err = resolveAlias(name)
+	if err != nil {
+		c.JSON(aliasErrorResponse(req.Model, err))
 		return
 	}
 
@@ -148,9 +344,9 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	if err != nil {
 		switch {
 		case errors.Is(err, fs.ErrNotExist):
-			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+			c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
 		case err.Error() == errtypes.InvalidModelNameErrMsg:
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeInvalidModelName, err.Error()))
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
@@ -176,20 +372,68 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
+	if req.N < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "n must not be negative"})
+		return
+	}
+
+	if req.TimeoutMS < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "timeout_ms must not be negative"})
+		return
+	}
+
+	if err := checkImages(len(req.Images)); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, limitResponse(err))
+		return
+	}
+
+	if req.Raw || req.Template != "" {
+		// raw prompts and templates of the request could leave out the
+		// system prompt policy
+		preamble, postamble, err := systemAmbles(model.Name)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		} else if preamble != "" || postamble != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "raw mode and templates aren't allowed by the system prompt policy of this server"})
+			return
+		}
+	}
+
+	var msgs []api.Message
+	if req.Prompt != "" {
+		msgs = []api.Message{{Role: "user", Content: req.Prompt}}
+	}
+
+	input, ok := s.moderateRequest(c, msgs)
+	if !ok {
+		return
+	}
+
 	caps := []Capability{CapabilityCompletion}
 	if req.Suffix != "" {
 		caps = append(caps, CapabilityInsert)
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), caps, req.Options, req.KeepAlive)
+	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), req.Adapter, caps, req.Options, req.KeepAlive)
 	if errors.Is(err, errCapabilityCompletion) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support generate", req.Model)})
+		c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeCapabilityMissing, fmt.Sprintf("%q does not support generate", req.Model)))
 		return
 	} else if err != nil {
 		handleScheduleError(c, req.Model, err)
 		return
 	}
 
+	n := max(req.N, 1)
+	posts := make([]postProcessor, n)
+	for i := range posts {
+		posts[i], err = newPostProcessor(opts.PostProcess)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	checkpointLoaded := time.Now()
 
 	// load the model
@@ -211,30 +455,26 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 
 	images := make([]llm.ImageData, len(req.Images))
 	for i := range req.Images {
-		if isMllama && len(model.ProjectorPaths) > 0 {
-			data, opts, err := mllama.Preprocess(bytes.NewReader(req.Images[i]))
-			if err != nil {
-				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error processing image"})
-				return
-			}
-
-			ar, ok := opts["aspectRatioIndex"].(int)
-			if !ok {
-				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error processing image"})
-				return
-			}
-
-			buf := new(bytes.Buffer)
-			err = binary.Write(buf, binary.LittleEndian, data)
-			if err != nil {
-				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error processing image"})
-				return
-			}
+		images[i], err = prepareImage(model, i, req.Images[i])
+		if errors.Is(err, errInvalidImage) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "error processing image"})
+			return
+		}
+	}
 
-			images[i] = llm.ImageData{ID: i, Data: buf.Bytes(), AspectRatioID: ar}
-		} else {
-			images[i] = llm.ImageData{ID: i, Data: req.Images[i]}
+	if !parseSpecial(opts) {
+		specials, err := specialTokens(m.ModelPath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
+
+		req.Prompt = escapeSpecial(req.Prompt, specials)
+		req.System = escapeSpecial(req.System, specials)
+		req.Suffix = escapeSpecial(req.Suffix, specials)
 	}
 
 	prompt := req.Prompt
@@ -248,31 +488,13 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 			}
 		}
 
-		var values template.Values
-		if req.Suffix != "" {
-			values.Prompt = prompt
-			values.Suffix = req.Suffix
-		} else {
-			var msgs []api.Message
-			if req.System != "" {
-				msgs = append(msgs, api.Message{Role: "system", Content: req.System})
-			} else if m.System != "" {
-				msgs = append(msgs, api.Message{Role: "system", Content: m.System})
-			}
-
-			if req.Context == nil {
-				msgs = append(msgs, m.Messages...)
-			}
-
-			for _, i := range images {
-				imgPrompt := ""
-				if isMllama {
-					imgPrompt = "<|image|>"
-				}
-				msgs = append(msgs, api.Message{Role: "user", Content: fmt.Sprintf("[img-%d]"+imgPrompt, i.ID)})
-			}
-
-			values.Messages = append(msgs, api.Message{Role: "user", Content: req.Prompt})
+		values, err := generateValues(m, req.Prompt, req.System, req.Suffix, images, req.Context == nil)
+		if errors.Is(err, errInvalidImagePlaceholder) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
 
 		var b bytes.Buffer
@@ -299,67 +521,99 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	ch := make(chan any)
 	go func() {
 		// TODO (jmorganca): avoid building the response twice both here and below
-		var sb strings.Builder
+		sbs := make([]strings.Builder, n)
+		var metrics api.Metrics
+		var done int
 		defer close(ch)
 		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
+			Prompt:   prompt,
+			Images:   images,
+			Format:   req.Format,
+			Options:  opts,
+			Adapters: m.AdapterPaths,
+			N:        n,
+			Deadline: generateDeadline(checkpointStart, req.TimeoutMS),
 		}, func(cr llm.CompletionResponse) {
 			res := api.GenerateResponse{
 				Model:      req.Model,
 				CreatedAt:  time.Now().UTC(),
+				RequestID:  c.GetString(requestIDKey),
+				Index:      cr.Index,
 				Response:   cr.Content,
-				Done:       cr.Done,
 				DoneReason: cr.DoneReason,
-				Metrics: api.Metrics{
-					PromptEvalCount:    cr.PromptEvalCount,
-					PromptEvalDuration: cr.PromptEvalDuration,
-					EvalCount:          cr.EvalCount,
-					EvalDuration:       cr.EvalDuration,
-				},
 			}
 
-			if _, err := sb.WriteString(cr.Content); err != nil {
-				ch <- gin.H{"error": err.Error()}
+			if _, err := sbs[cr.Index].WriteString(cr.Content); err != nil {
+				ch <- streamError(err)
 			}
 
+			post := posts[cr.Index]
+			res.Response = post.add(cr.Content)
 			if cr.Done {
-				res.TotalDuration = time.Since(checkpointStart)
-				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				res.Response += post.flush(cr.DoneReason)
+			} else if res.Response == "" {
+				return
+			}
 
+			if cr.Done {
 				if !req.Raw {
-					tokens, err := r.Tokenize(c.Request.Context(), prompt+sb.String())
+					tokens, err := r.Tokenize(c.Request.Context(), prompt+sbs[cr.Index].String())
 					if err != nil {
-						ch <- gin.H{"error": err.Error()}
+						ch <- streamError(err)
 						return
 					}
 					res.Context = tokens
 				}
+
+				// the request is done with its last completion
+				addCompletionMetrics(&metrics, cr)
+				if done++; done == n {
+					res.Done = true
+					res.Metrics = metrics
+					res.TotalDuration = time.Since(checkpointStart)
+					res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				}
 			}
 
 			ch <- res
 		}); err != nil {
-			ch <- gin.H{"error": err.Error()}
+			ch <- streamError(err)
+			return
 		}
+
+		metrics.TotalDuration = time.Since(checkpointStart)
+		metrics.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+		emitWebhook(api.WebhookEvent{
+			Event:     api.WebhookRequestCompleted,
+			Model:     req.Model,
+			Tenant:    c.GetString(tenantKey),
+			RequestID: c.GetString(requestIDKey),
+			Metrics:   &metrics,
+		})
+		recordSplitMetrics(c, metrics)
 	}()
 
+	responses := s.moderateResponses(c.Request.Context(), msgs, input, ch)
+
 	if req.Stream != nil && !*req.Stream {
 		var r api.GenerateResponse
-		var sb strings.Builder
-		for rr := range ch {
+		sbs := make([]strings.Builder, n)
+		choices := make([]api.GenerateChoice, n)
+		for rr := range responses {
 			switch t := rr.(type) {
 			case api.GenerateResponse:
-				sb.WriteString(t.Response)
+				sbs[t.Index].WriteString(t.Response)
+				if t.Done || t.DoneReason != "" {
+					choices[t.Index].DoneReason = t.DoneReason
+					choices[t.Index].Context = t.Context
+				}
 				r = t
 			case gin.H:
-				msg, ok := t["error"].(string)
-				if !ok {
-					msg = "unexpected error format in response"
+				if _, ok := t["error"].(string); !ok {
+					t = gin.H{"error": "unexpected error format in response"}
 				}
 
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+				c.JSON(errorStatus(t), t)
 				return
 			default:
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected response"})
@@ -367,117 +621,421 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 			}
 		}
 
-		r.Response = sb.String()
+		for i := range choices {
+			choices[i].Index = i
+			choices[i].Response = sbs[i].String()
+		}
+
+		// the response is the first completion, with the others in choices
+		r.Index = 0
+		r.Response = choices[0].Response
+		r.DoneReason = choices[0].DoneReason
+		r.Context = choices[0].Context
+		if n > 1 {
+			r.Choices = choices
+		}
+
 		c.JSON(http.StatusOK, r)
 		return
 	}
 
-	streamResponse(c, ch)
+	streamResponse(c, responses)
 }
 
-func (s *Server) EmbedHandler(c *gin.Context) {
+// generateDeadline is when generation stops for a request received at start
+// with timeoutMS, or the server's default if it's zero. The deadline is zero
+// if generation doesn't stop.
+func generateDeadline(start time.Time, timeoutMS int) time.Time {
+	timeout := envconfig.GenerateTimeout()
+	if timeoutMS > 0 {
+		timeout = time.Duration(timeoutMS) * time.Millisecond
+	}
+
+	if timeout <= 0 {
+		return time.Time{}
+	}
+
+	return start.Add(timeout)
+}
+
+// addCompletionMetrics adds the metrics of the final response of a completion
+// to the metrics of its request. The completions of a request share its
+// prompt, so durations are the longest of them.
+func addCompletionMetrics(m *api.Metrics, cr llm.CompletionResponse) {
+	m.PromptEvalCount += cr.PromptEvalCount
+	m.PromptEvalDuration = max(m.PromptEvalDuration, cr.PromptEvalDuration)
+	m.EvalCount += cr.EvalCount
+	m.EvalDuration = max(m.EvalDuration, cr.EvalDuration)
+}
+
+// FIMHandler fills in the middle of a file at each of its cursors, in order.
+// The insertion at a cursor is generated with the earlier insertions in the
+// file.
+func (s *Server) FIMHandler(c *gin.Context) {
 	checkpointStart := time.Now()
-	var req api.EmbedRequest
-	err := c.ShouldBindJSON(&req)
-	switch {
-	case errors.Is(err, io.EOF):
+
+	var req api.FIMRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
 		return
-	case err != nil:
+	} else if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	truncate := true
-
-	if req.Truncate != nil && !*req.Truncate {
-		truncate = false
+	if len(req.Cursors) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "cursors are required"})
+		return
 	}
 
-	var input []string
-
-	switch i := req.Input.(type) {
-	case string:
-		if len(i) > 0 {
-			input = append(input, i)
-		}
-	case []any:
-		for _, v := range i {
-			if _, ok := v.(string); !ok {
-				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid input type"})
-				return
-			}
-			input = append(input, v.(string))
-		}
-	default:
-		if req.Input != nil {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid input type"})
+	for i, cursor := range req.Cursors {
+		switch {
+		case cursor < 0 || cursor > len(req.File.Content):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("cursor %d is outside of the file", cursor)})
+			return
+		case i > 0 && cursor < req.Cursors[i-1]:
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "cursors must be in ascending order"})
+			return
+		case cursor < len(req.File.Content) && !utf8.RuneStart(req.File.Content[cursor]):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("cursor %d is in the middle of a character", cursor)})
 			return
 		}
 	}
 
+	if req.TimeoutMS < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "timeout_ms must not be negative"})
+		return
+	}
+
 	name, err := getExistingName(model.ParseName(req.Model))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
 		return
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{}, req.Options, req.KeepAlive)
+	name, _, err = resolveAlias(name)
 	if err != nil {
-		handleScheduleError(c, req.Model, err)
+		c.JSON(aliasErrorResponse(req.Model, err))
 		return
 	}
 
-	checkpointLoaded := time.Now()
-
-	if len(input) == 0 {
-		c.JSON(http.StatusOK, api.EmbedResponse{Model: req.Model, Embeddings: [][]float32{}})
+	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), "", []Capability{CapabilityCompletion}, req.Options, req.KeepAlive)
+	if errors.Is(err, errCapabilityCompletion) {
+		c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeCapabilityMissing, fmt.Sprintf("%q does not support generate", req.Model)))
+		return
+	} else if err != nil {
+		handleScheduleError(c, req.Model, err)
 		return
 	}
 
-	kvData, _, err := getModelData(m.ModelPath, false)
+	format, ok, err := fimFormatOf(m, req.Style)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if !ok && !slices.Contains(m.Template.Vars(), "suffix") {
+		c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeCapabilityMissing, fmt.Sprintf("%q does not support insert", req.Model)))
 		return
 	}
 
-	var count int
-	for i, s := range input {
-		tokens, err := r.Tokenize(c.Request.Context(), s)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+	if ok {
+		opts.Stop = append(slices.Clone(opts.Stop), format.stop()...)
+	}
+
+	checkpointLoaded := time.Now()
+
+	// prompt returns the prompt of the insertion at a cursor between prefix
+	// and suffix
+	prompt := func(prefix, suffix string) (string, error) {
+		if ok {
+			return format.render(req.Repo, req.Files, req.File.Path, prefix, suffix), nil
 		}
 
-		ctxLen := min(opts.NumCtx, int(kvData.ContextLength()))
-		if len(tokens) > ctxLen {
-			if !truncate {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "input length exceeds maximum context length"})
-				return
+		var b bytes.Buffer
+		if err := m.Template.Execute(&b, template.Values{Prompt: prefix, Suffix: suffix}); err != nil {
+			return "", err
+		}
+
+		return b.String(), nil
+	}
+
+	deadline := generateDeadline(checkpointStart, req.TimeoutMS)
+
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+
+		var metrics api.Metrics
+		insertions := make([]string, len(req.Cursors))
+
+		// content is the file with the insertions so far, up to the
+		// current cursor
+		var content strings.Builder
+		for i, cursor := range req.Cursors {
+			start := 0
+			if i > 0 {
+				start = req.Cursors[i-1]
 			}
+			content.WriteString(req.File.Content[start:cursor])
 
-			tokens = tokens[:ctxLen]
-			s, err = r.Detokenize(c.Request.Context(), tokens)
+			p, err := prompt(content.String(), req.File.Content[cursor:])
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				ch <- streamError(err)
 				return
 			}
-		}
 
-		count += len(tokens)
+			slog.Debug("fim request", "cursor", i, "prompt", p)
+
+			var sb strings.Builder
+			if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
+				Prompt:   p,
+				Options:  opts,
+				Adapters: m.AdapterPaths,
+				Deadline: deadline,
+			}, func(cr llm.CompletionResponse) {
+				sb.WriteString(cr.Content)
+				if cr.Done {
+					addCompletionMetrics(&metrics, cr)
+				}
 
-		input[i] = s
-	}
+				if cr.Content != "" || cr.Done {
+					ch <- api.FIMResponse{
+						Model:      req.Model,
+						CreatedAt:  time.Now().UTC(),
+						RequestID:  c.GetString(requestIDKey),
+						Cursor:     i,
+						Response:   cr.Content,
+						DoneReason: cr.DoneReason,
+					}
+				}
+			}); err != nil {
+				ch <- streamError(err)
+				return
+			}
+
+			insertions[i] = sb.String()
+			content.WriteString(insertions[i])
+		}
+
+		content.WriteString(req.File.Content[req.Cursors[len(req.Cursors)-1]:])
+
+		res := api.FIMResponse{
+			Model:      req.Model,
+			CreatedAt:  time.Now().UTC(),
+			RequestID:  c.GetString(requestIDKey),
+			Cursor:     len(req.Cursors) - 1,
+			Done:       true,
+			Insertions: insertions,
+			Content:    content.String(),
+			Metrics:    metrics,
+		}
+		res.TotalDuration = time.Since(checkpointStart)
+		res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+		ch <- res
+
+		emitWebhook(api.WebhookEvent{
+			Event:     api.WebhookRequestCompleted,
+			Model:     req.Model,
+			Tenant:    c.GetString(tenantKey),
+			RequestID: c.GetString(requestIDKey),
+			Metrics:   &res.Metrics,
+		})
+		recordSplitMetrics(c, res.Metrics)
+	}()
+
+	if req.Stream != nil && !*req.Stream {
+		var final api.FIMResponse
+		for rr := range ch {
+			switch t := rr.(type) {
+			case api.FIMResponse:
+				if t.Done {
+					final = t
+				}
+			case gin.H:
+				if _, ok := t["error"].(string); !ok {
+					t = gin.H{"error": "unexpected error format in response"}
+				}
+
+				c.JSON(errorStatus(t), t)
+				return
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected response"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, final)
+		return
+	}
+
+	streamResponse(c, ch)
+}
+
+func (s *Server) EmbedHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+	var req api.EmbedRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	truncate := true
+
+	if req.Truncate != nil && !*req.Truncate {
+		truncate = false
+	}
+
+	if req.Dimensions < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "dimensions must not be negative"})
+		return
+	}
+
+	if err := checkChunking(req.Chunking); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var input []string
+
+	switch i := req.Input.(type) {
+	case string:
+		if len(i) > 0 {
+			input = append(input, i)
+		}
+	case []any:
+		for _, v := range i {
+			if _, ok := v.(string); !ok {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid input type"})
+				return
+			}
+			input = append(input, v.(string))
+		}
+	default:
+		if req.Input != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid input type"})
+			return
+		}
+	}
+
+	name, err := getExistingName(model.ParseName(req.Model))
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		return
+	}
+
+	name, _, err = resolveAlias(name)
+	if err != nil {
+		c.JSON(aliasErrorResponse(req.Model, err))
+		return
+	}
+
+	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), "", []Capability{}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	checkpointLoaded := time.Now()
+
+	if len(input) == 0 {
+		c.JSON(http.StatusOK, api.EmbedResponse{Model: req.Model, Embeddings: [][]float32{}})
+		return
+	}
+
+	kvData, _, err := getModelData(m.ModelPath, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := checkDimensions(kvData, req.Dimensions); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctxLen := min(opts.NumCtx, int(kvData.ContextLength()))
+
+	// texts are the texts which are embedded, which are either the inputs
+	// or their chunks, and chunks are the indices of the texts of each
+	// input
+	var texts []string
+	var weights []int
+	chunks := make([][]int, len(input))
+
+	inputTokens, err := tokenizeInputs(c.Request.Context(), r, input)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var count int
+	for i, s := range input {
+		tokens := inputTokens[i]
+		if req.Chunking != nil {
+			size := ctxLen
+			if req.Chunking.Size > 0 {
+				size = min(req.Chunking.Size, ctxLen)
+			}
+
+			for _, chunk := range chunkTokens(tokens, size, req.Chunking.Overlap) {
+				text, err := r.Detokenize(c.Request.Context(), chunk)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				chunks[i] = append(chunks[i], len(texts))
+				texts = append(texts, text)
+				weights = append(weights, len(chunk))
+				count += len(chunk)
+			}
+
+			continue
+		}
+
+		if len(tokens) > ctxLen {
+			if !truncate {
+				c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeContextExceeded, "input length exceeds maximum context length"))
+				return
+			}
+
+			tokens = tokens[:ctxLen]
+			s, err = r.Detokenize(c.Request.Context(), tokens)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		count += len(tokens)
+
+		texts = append(texts, s)
+		weights = append(weights, len(tokens))
+	}
+
+	normalized := req.Normalize == nil || *req.Normalize
 
 	var g errgroup.Group
-	embeddings := make([][]float32, len(input))
-	for i, text := range input {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
 		g.Go(func() error {
-			embedding, err := r.Embedding(c.Request.Context(), text)
+			embedding, err := r.Embedding(c.Request.Context(), text, m.AdapterPaths)
 			if err != nil {
 				return err
 			}
-			embeddings[i] = normalize(embedding)
+			if req.Dimensions > 0 && req.Dimensions < len(embedding) {
+				embedding = embedding[:req.Dimensions]
+			}
+
+			if normalized {
+				embedding = normalize(embedding)
+			}
+
+			embeddings[i] = embedding
 			return nil
 		})
 	}
@@ -487,9 +1045,36 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		return
 	}
 
+	var documents []api.EmbedDocument
+	if req.Chunking != nil {
+		documents = make([]api.EmbedDocument, len(input))
+		pooled := [][]float32{}
+		for i, indices := range chunks {
+			documents[i].Chunks = make([]api.EmbedChunk, len(indices))
+			var docEmbeddings [][]float32
+			var docWeights []int
+			for j, k := range indices {
+				documents[i].Chunks[j] = api.EmbedChunk{Text: texts[k], Embedding: embeddings[k]}
+				docEmbeddings = append(docEmbeddings, embeddings[k])
+				docWeights = append(docWeights, weights[k])
+			}
+
+			if req.Chunking.Pool {
+				documents[i].Embedding = pool(docEmbeddings, docWeights)
+				if normalized {
+					documents[i].Embedding = normalize(documents[i].Embedding)
+				}
+				pooled = append(pooled, documents[i].Embedding)
+			}
+		}
+
+		embeddings = pooled
+	}
+
 	resp := api.EmbedResponse{
 		Model:           req.Model,
 		Embeddings:      embeddings,
+		Documents:       documents,
 		TotalDuration:   time.Since(checkpointStart),
 		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
 		PromptEvalCount: count,
@@ -497,6 +1082,25 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// checkDimensions returns an error if embeddings of a model can't be
+// truncated to dimensions. Models which declare their Matryoshka dimensions
+// can only be truncated to those.
+func checkDimensions(kv ggml.KV, dimensions int) error {
+	if dimensions == 0 {
+		return nil
+	}
+
+	if n := kv.EmbeddingLength(); n > 0 && uint64(dimensions) > n {
+		return fmt.Errorf("dimensions must be at most %d", n)
+	}
+
+	if supported := kv.MatryoshkaDimensions(); len(supported) > 0 && !slices.Contains(supported, uint32(dimensions)) {
+		return fmt.Errorf("dimensions must be one of %v", supported)
+	}
+
+	return nil
+}
+
 func normalize(vec []float32) []float32 {
 	var sum float32
 	for _, v := range vec {
@@ -508,14 +1112,745 @@ func normalize(vec []float32) []float32 {
 		norm = float32(1.0 / math.Sqrt(float64(sum)))
 	}
 
-	for i := range vec {
-		vec[i] *= norm
+	for i := range vec {
+		vec[i] *= norm
+	}
+	return vec
+}
+
+func (s *Server) ClassifyHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+	var req api.ClassifyRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	truncate := req.Truncate == nil || *req.Truncate
+
+	if len(req.Labels) > 0 && req.HypothesisTemplate != "" && !strings.Contains(req.HypothesisTemplate, "{}") {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "hypothesis template must contain {}"})
+		return
+	}
+
+	var input []string
+
+	switch i := req.Input.(type) {
+	case string:
+		if len(i) > 0 {
+			input = append(input, i)
+		}
+	case []any:
+		for _, v := range i {
+			if _, ok := v.(string); !ok {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid input type"})
+				return
+			}
+			input = append(input, v.(string))
+		}
+	default:
+		if req.Input != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid input type"})
+			return
+		}
+	}
+
+	name, err := getExistingName(model.ParseName(req.Model))
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		return
+	}
+
+	name, _, err = resolveAlias(name)
+	if err != nil {
+		c.JSON(aliasErrorResponse(req.Model, err))
+		return
+	}
+
+	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), "", []Capability{CapabilityClassification}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	checkpointLoaded := time.Now()
+
+	if len(input) == 0 {
+		c.JSON(http.StatusOK, api.ClassifyResponse{Model: req.Model, Results: [][]api.ClassifyLabel{}})
+		return
+	}
+
+	kvData, _, err := getModelData(m.ModelPath, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	head, err := newClassifier(kvData)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	multiLabel := head.multiLabel
+	if len(req.Labels) > 0 {
+		multiLabel = false
+	}
+	if req.MultiLabel != nil {
+		multiLabel = *req.MultiLabel
+	}
+
+	// zero-shot classification pairs each input with the hypothesis of each
+	// label, separated as the model was trained on pairs of texts
+	var sep string
+	if len(req.Labels) > 0 {
+		if entailment, _ := head.nliLabels(); entailment < 0 {
+			c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeCapabilityMissing, fmt.Sprintf("%q does not support zero-shot classification", req.Model)))
+			return
+		}
+
+		sep = "[SEP]"
+		if tokens := kvData.Strings("tokenizer.ggml.tokens"); int(kvData.Uint("tokenizer.ggml.seperator_token_id")) < len(tokens) {
+			sep = tokens[kvData.Uint("tokenizer.ggml.seperator_token_id")]
+		}
+	}
+
+	ctxLen := min(opts.NumCtx, int(kvData.ContextLength()))
+
+	var suffixes []string
+	for _, label := range req.Labels {
+		suffixes = append(suffixes, " "+sep+" "+hypothesis(req.HypothesisTemplate, label))
+	}
+
+	if len(suffixes) == 0 {
+		suffixes = []string{""}
+	}
+
+	// texts are each input paired with each hypothesis, if any
+	var texts []string
+	for _, s := range input {
+		for _, suffix := range suffixes {
+			texts = append(texts, s+suffix)
+		}
+	}
+
+	textTokens, err := tokenizeInputs(c.Request.Context(), r, texts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var count int
+	for i, s := range input {
+		for j, suffix := range suffixes {
+			k := i*len(suffixes) + j
+			tokens := textTokens[k]
+			if len(tokens) > ctxLen {
+				if !truncate {
+					c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeContextExceeded, "input length exceeds maximum context length"))
+					return
+				}
+
+				// the input is truncated rather than the hypothesis
+				inputTokens, err := r.Tokenize(c.Request.Context(), s)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				inputTokens = inputTokens[:max(0, len(inputTokens)-(len(tokens)-ctxLen))]
+				truncated, err := r.Detokenize(c.Request.Context(), inputTokens)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+
+				texts[k] = truncated + suffix
+				tokens = tokens[:ctxLen]
+			}
+
+			count += len(tokens)
+		}
+	}
+
+	var g errgroup.Group
+	logits := make([][]float32, len(texts))
+	for i, text := range texts {
+		g.Go(func() error {
+			embedding, err := r.Embedding(c.Request.Context(), text, m.AdapterPaths)
+			if err != nil {
+				return err
+			}
+
+			logits[i], err = head.logits(embedding)
+			return err
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": strings.TrimSpace(err.Error())})
+		return
+	}
+
+	results := make([][]api.ClassifyLabel, len(input))
+	for i := range input {
+		if len(req.Labels) > 0 {
+			results[i] = head.zeroShotScores(req.Labels, logits[i*len(req.Labels):(i+1)*len(req.Labels)], multiLabel)
+		} else {
+			results[i] = head.scores(logits[i], multiLabel)
+		}
+	}
+
+	c.JSON(http.StatusOK, api.ClassifyResponse{
+		Model:           req.Model,
+		Results:         results,
+		TotalDuration:   time.Since(checkpointStart),
+		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
+		PromptEvalCount: count,
+	})
+}
+
+func (s *Server) EmbeddingsHandler(c *gin.Context) {
+	var req api.EmbeddingRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	name, _, err := resolveAlias(name)
+	if err != nil {
+		c.JSON(aliasErrorResponse(req.Model, err))
+		return
+	}
+
+	r, m, _, err := s.scheduleRunner(c.Request.Context(), name.String(), "", []Capability{}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	// an empty request loads the model
+	if req.Prompt == "" {
+		c.JSON(http.StatusOK, api.EmbeddingResponse{Embedding: []float64{}})
+		return
+	}
+
+	embedding, err := r.Embedding(c.Request.Context(), req.Prompt, m.AdapterPaths)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": strings.TrimSpace(err.Error())})
+		return
+	}
+
+	var e []float64
+	for _, v := range embedding {
+		e = append(e, float64(v))
+	}
+
+	resp := api.EmbeddingResponse{
+		Embedding: e,
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) PullHandler(c *gin.Context) {
+	var req api.PullRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(cmp.Or(req.Model, req.Name))
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse(api.ErrorCodeInvalidModelName, errtypes.InvalidModelNameErrMsg))
+		return
+	}
+
+	name, err = getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var maxRate int64
+	if req.MaxRate != "" {
+		maxRate, err = format.ParseBytes(req.MaxRate)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid max_rate: %v", err)})
+			return
+		}
+	}
+
+	tenant := c.GetString(tenantKey)
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		fn := func(r api.ProgressResponse) {
+			ch <- r
+		}
+
+		regOpts := &registryOptions{
+			Insecure:        req.Insecure,
+			MaxDownloadRate: maxRate,
+		}
+
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+
+		track, stop := s.pulls.start(pullRecord{Model: name.DisplayShortest(), Insecure: req.Insecure, MaxRate: maxRate, Tenant: tenant}, false)
+		err := PullModel(ctx, name.DisplayShortest(), regOpts, func(r api.ProgressResponse) {
+			track(r)
+			fn(r)
+		})
+		stop(errors.Is(err, context.Canceled) && s.draining.Load())
+		if err != nil {
+			ch <- progressError(err)
+			return
+		}
+
+		emitWebhook(api.WebhookEvent{Event: api.WebhookModelPulled, Model: name.DisplayShortest(), Tenant: tenant})
+	}()
+
+	if req.Stream != nil && !*req.Stream {
+		waitForStream(c, ch)
+		return
+	}
+
+	streamResponse(c, ch)
+}
+
+func (s *Server) PushHandler(c *gin.Context) {
+	var req api.PushRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var mname string
+	if req.Model != "" {
+		mname = req.Model
+	} else if req.Name != "" {
+		mname = req.Name
+	} else {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		fn := func(r api.ProgressResponse) {
+			ch <- r
+		}
+
+		regOpts := &registryOptions{
+			Insecure: req.Insecure,
+			OCI:      req.OCI,
+		}
+
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+
+		name, err := getExistingName(model.ParseName(mname))
+		if err != nil {
+			ch <- streamError(err)
+			return
+		}
+
+		if err := PushModel(ctx, name.DisplayShortest(), regOpts, fn); err != nil {
+			ch <- streamError(err)
+		}
+	}()
+
+	if req.Stream != nil && !*req.Stream {
+		waitForStream(c, ch)
+		return
+	}
+
+	streamResponse(c, ch)
+}
+
+// getExistingName searches the models directory for the longest prefix match of
+// the input name and returns the input name with all existing parts replaced
+// with each part found. If no parts are found, the input name is returned as
+// is.
+func getExistingName(n model.Name) (model.Name, error) {
+	var zero model.Name
+	existing, err := Manifests(true)
+	if err != nil {
+		return zero, err
+	}
+	var set model.Name // tracks parts already canonicalized
+	for e := range existing {
+		if set.Host == "" && strings.EqualFold(e.Host, n.Host) {
+			n.Host = e.Host
+		}
+		if set.Namespace == "" && strings.EqualFold(e.Namespace, n.Namespace) {
+			n.Namespace = e.Namespace
+		}
+		if set.Model == "" && strings.EqualFold(e.Model, n.Model) {
+			n.Model = e.Model
+		}
+		if set.Tag == "" && strings.EqualFold(e.Tag, n.Tag) {
+			n.Tag = e.Tag
+		}
+	}
+	return n, nil
+}
+
+func (s *Server) DeleteHandler(c *gin.Context) {
+	var r api.DeleteRequest
+	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	n := model.ParseName(cmp.Or(r.Model, r.Name))
+	if !n.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("name %q is invalid", cmp.Or(r.Model, r.Name))})
+		return
+	}
+
+	n, err := getExistingName(n)
+	if err != nil {
+		c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", cmp.Or(r.Model, r.Name))))
+		return
+	}
+
+	m, err := ParseNamedManifest(n)
+	if err != nil {
+		switch {
+		case os.IsNotExist(err):
+			c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", cmp.Or(r.Model, r.Name))))
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if err := m.Remove(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := m.RemoveLayers(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	emitWebhook(api.WebhookEvent{Event: api.WebhookModelDeleted, Model: n.DisplayShortest(), Tenant: c.GetString(tenantKey)})
+}
+
+func (s *Server) PruneHandler(c *gin.Context) {
+	var req api.PruneRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy := prunePolicy{
+		KeepTags: req.KeepTags,
+		MaxSize:  req.MaxSize,
+	}
+
+	if req.UnusedFor != nil {
+		policy.UnusedFor = req.UnusedFor.Duration
+	}
+
+	if policy.UnusedFor < 0 || policy.KeepTags < 0 || policy.MaxSize < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "prune rules must not be negative"})
+		return
+	}
+
+	if !policy.enabled() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "at least one of unused_for, keep_tags or max_size is required"})
+		return
+	}
+
+	if err := s.uses.flush(); err != nil {
+		slog.Warn("failed to record model use", "error", err)
+	}
+
+	removed, freed, err := pruneModels(policy, time.Now(), s.loaded, req.DryRun)
+	if errors.Is(err, errStoreInUse) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := api.PruneResponse{Models: []string{}, Freed: freed}
+	for _, n := range removed {
+		resp.Models = append(resp.Models, n.DisplayShortest())
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) SetAliasHandler(c *gin.Context) {
+	var req api.AliasRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	alias := model.ParseName(req.Alias)
+	if !alias.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("alias %q is invalid", req.Alias)})
+		return
+	}
+
+	target := model.ParseName(req.Target)
+	if !target.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("target %q is invalid", req.Target)})
+		return
+	}
+
+	target, err := getExistingName(target)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := setAlias(alias, target); errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Target)))
+	} else if errors.Is(err, errAliasIsModel) || errors.Is(err, errAliasCycle) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func (s *Server) DeleteAliasHandler(c *gin.Context) {
+	var req api.AliasRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	alias := model.ParseName(req.Alias)
+	if !alias.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("alias %q is invalid", req.Alias)})
+		return
+	}
+
+	if err := deleteAlias(alias); errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("alias '%s' not found", req.Alias)})
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func (s *Server) ListAliasesHandler(c *gin.Context) {
+	aliases, err := readAliases()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := api.ListAliasesResponse{Aliases: []api.Alias{}}
+	for alias, target := range aliases {
+		if !visible(c, model.ParseName(alias)) {
+			continue
+		}
+
+		resp.Aliases = append(resp.Aliases, api.Alias{
+			Alias:  model.ParseName(alias).DisplayShortest(),
+			Target: model.ParseName(target).DisplayShortest(),
+		})
+	}
+
+	slices.SortFunc(resp.Aliases, func(i, j api.Alias) int {
+		return cmp.Compare(i.Alias, j.Alias)
+	})
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) ListProfilesHandler(c *gin.Context) {
+	profilesMu.Lock()
+	profiles, err := readProfiles()
+	profilesMu.Unlock()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := api.ListProfilesResponse{Profiles: []api.VRAMProfile{}}
+	for _, p := range profiles {
+		resp.Profiles = append(resp.Profiles, api.VRAMProfile{
+			Model:         p.Model,
+			Digest:        p.Digest,
+			Library:       p.Library,
+			GPU:           p.GPU,
+			NumCtx:        p.NumCtx,
+			NumParallel:   p.NumParallel,
+			EstimatedVRAM: p.Estimated,
+			MeasuredVRAM:  p.Measured,
+			MeasuredAt:    p.MeasuredAt,
+		})
+	}
+
+	slices.SortFunc(resp.Profiles, func(i, j api.VRAMProfile) int {
+		return cmp.Or(cmp.Compare(i.Model, j.Model), cmp.Compare(i.Library, j.Library), cmp.Compare(i.GPU, j.GPU))
+	})
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// DeleteProfilesHandler deletes the VRAM profiles of a model, or of every
+// model if none is given, so that they're measured again when the model
+// is next loaded.
+func (s *Server) DeleteProfilesHandler(c *gin.Context) {
+	var req api.DeleteProfilesRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var digest string
+	if req.Model != "" {
+		n := model.ParseName(req.Model)
+		if !n.IsValid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("model %q is invalid", req.Model)})
+			return
+		}
+
+		n, _, err := resolveAlias(n)
+		if err != nil {
+			c.AbortWithStatusJSON(aliasErrorResponse(req.Model, err))
+			return
+		}
+
+		m, err := ParseNamedManifest(n)
+		if errors.Is(err, os.ErrNotExist) {
+			c.AbortWithStatusJSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		digest = m.digest
+	}
+
+	deleted, err := deleteProfiles(digest)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.DeleteProfilesResponse{Deleted: deleted})
+}
+
+func (s *Server) CreateCollectionHandler(c *gin.Context) {
+	var req api.CollectionRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := createCollection(c.GetString(tenantKey), req.Name, req.Dimensions, req.Metric); err != nil {
+		handleCollectionError(c, req.Name, err)
+	}
+}
+
+func (s *Server) DeleteCollectionHandler(c *gin.Context) {
+	var req api.CollectionRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := deleteCollection(c.GetString(tenantKey), req.Name); err != nil {
+		handleCollectionError(c, req.Name, err)
+	}
+}
+
+func (s *Server) ListCollectionsHandler(c *gin.Context) {
+	list, err := listCollections(c.GetString(tenantKey))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.ListCollectionsResponse{Collections: list})
+}
+
+func (s *Server) UpsertPointsHandler(c *gin.Context) {
+	var req api.UpsertPointsRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := upsertPoints(c.GetString(tenantKey), req.Collection, req.Points); err != nil {
+		handleCollectionError(c, req.Collection, err)
+	}
+}
+
+func (s *Server) DeletePointsHandler(c *gin.Context) {
+	var req api.DeletePointsRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := deletePoints(c.GetString(tenantKey), req.Collection, req.IDs); err != nil {
+		handleCollectionError(c, req.Collection, err)
 	}
-	return vec
 }
 
-func (s *Server) EmbeddingsHandler(c *gin.Context) {
-	var req api.EmbeddingRequest
+func (s *Server) SearchCollectionHandler(c *gin.Context) {
+	var req api.SearchCollectionRequest
 	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
 		return
@@ -524,94 +1859,141 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
-	name := model.ParseName(req.Model)
-	if !name.IsValid() {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
-		return
-	}
-
-	r, _, _, err := s.scheduleRunner(c.Request.Context(), name.String(), []Capability{}, req.Options, req.KeepAlive)
+	results, err := searchCollection(c.GetString(tenantKey), req)
 	if err != nil {
-		handleScheduleError(c, req.Model, err)
+		handleCollectionError(c, req.Collection, err)
 		return
 	}
 
-	// an empty request loads the model
-	if req.Prompt == "" {
-		c.JSON(http.StatusOK, api.EmbeddingResponse{Embedding: []float64{}})
-		return
+	c.JSON(http.StatusOK, api.SearchCollectionResponse{Results: results})
+}
+
+func handleCollectionError(c *gin.Context, name string, err error) {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("collection '%s' not found", name)})
+	case errors.Is(err, errInvalidCollection), errors.Is(err, errCollectionExists):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 	}
+}
 
-	embedding, err := r.Embedding(c.Request.Context(), req.Prompt)
+// AdaptersHandler lists the models with a LoRA adapter, which requests may
+// choose as their adapter. If the model query parameter is set, only the
+// adapters of its weights are listed.
+func (s *Server) AdaptersHandler(c *gin.Context) {
+	ms, err := Manifests(true)
 	if err != nil {
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": strings.TrimSpace(err.Error())})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	var e []float64
-	for _, v := range embedding {
-		e = append(e, float64(v))
-	}
+	maps.DeleteFunc(ms, func(n model.Name, _ *Manifest) bool { return !visible(c, n) })
 
-	resp := api.EmbeddingResponse{
-		Embedding: e,
-	}
-	c.JSON(http.StatusOK, resp)
-}
+	// layerDigest returns the digest of the layer of m with mediaType
+	layerDigest := func(m *Manifest, mediaType string) string {
+		for _, layer := range m.Layers {
+			if layer.MediaType == mediaType {
+				return layer.Digest
+			}
+		}
 
-func (s *Server) PullHandler(c *gin.Context) {
-	var req api.PullRequest
-	err := c.ShouldBindJSON(&req)
-	switch {
-	case errors.Is(err, io.EOF):
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
-		return
-	case err != nil:
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+		return ""
 	}
 
-	name := model.ParseName(cmp.Or(req.Model, req.Name))
-	if !name.IsValid() {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": errtypes.InvalidModelNameErrMsg})
-		return
+	var base string
+	if name := c.Query("model"); name != "" {
+		n := model.ParseName(name)
+		if !n.IsValid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse(api.ErrorCodeInvalidModelName, errtypes.InvalidModelNameErrMsg))
+			return
+		}
+
+		n, err := getExistingName(n)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", name)))
+			return
+		}
+
+		n, _, err = resolveAlias(n)
+		if err != nil {
+			c.AbortWithStatusJSON(aliasErrorResponse(name, err))
+			return
+		}
+
+		m, ok := ms[n]
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", name)))
+			return
+		}
+
+		base = layerDigest(m, "application/vnd.ollama.image.model")
 	}
 
-	name, err = getExistingName(name)
-	if err != nil {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+	s.sched.loadedMu.Lock()
+	loaded := make(map[string]bool)
+	for _, runner := range s.sched.loaded {
+		for _, adapter := range runner.adapters {
+			loaded[adapter] = true
+		}
 	}
+	s.sched.loadedMu.Unlock()
 
-	ch := make(chan any)
-	go func() {
-		defer close(ch)
-		fn := func(r api.ProgressResponse) {
-			ch <- r
+	resp := api.ListAdaptersResponse{Adapters: []api.AdapterResponse{}}
+	for n, m := range ms {
+		digest := layerDigest(m, "application/vnd.ollama.image.adapter")
+		if digest == "" || (base != "" && layerDigest(m, "application/vnd.ollama.image.model") != base) {
+			continue
 		}
 
-		regOpts := &registryOptions{
-			Insecure: req.Insecure,
+		p, err := GetBlobsPath(digest)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
 
-		ctx, cancel := context.WithCancel(c.Request.Context())
-		defer cancel()
+		resp.Adapters = append(resp.Adapters, api.AdapterResponse{
+			Name:   n.DisplayShortest(),
+			Digest: digest,
+			Loaded: loaded[p],
+		})
+	}
+
+	slices.SortFunc(resp.Adapters, func(i, j api.AdapterResponse) int {
+		return cmp.Compare(i.Name, j.Name)
+	})
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) SearchHandler(c *gin.Context) {
+	var req api.SearchRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-		if err := PullModel(ctx, name.DisplayShortest(), regOpts, fn); err != nil {
-			ch <- gin.H{"error": err.Error()}
+	var maxSize uint64
+	if req.MaxSize != "" {
+		var err error
+		if maxSize, err = format.ParseNumber(req.MaxSize); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-	}()
+	}
 
-	if req.Stream != nil && !*req.Stream {
-		waitForStream(c, ch)
+	models, err := searchRegistry(c.Request.Context(), req.Query)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{"error": fmt.Sprintf("searching registry: %v", err)})
 		return
 	}
 
-	streamResponse(c, ch)
+	c.JSON(http.StatusOK, api.SearchResponse{Models: filterSearch(models, req.Capabilities, maxSize, req.Limit)})
 }
 
-func (s *Server) PushHandler(c *gin.Context) {
-	var req api.PushRequest
+func (s *Server) ShowHandler(c *gin.Context) {
+	var req api.ShowRequest
 	err := c.ShouldBindJSON(&req)
 	switch {
 	case errors.Is(err, io.EOF):
@@ -622,80 +2004,118 @@ func (s *Server) PushHandler(c *gin.Context) {
 		return
 	}
 
-	var mname string
 	if req.Model != "" {
-		mname = req.Model
+		// noop
 	} else if req.Name != "" {
-		mname = req.Name
+		req.Model = req.Name
 	} else {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
 		return
 	}
 
-	ch := make(chan any)
-	go func() {
-		defer close(ch)
-		fn := func(r api.ProgressResponse) {
-			ch <- r
-		}
-
-		regOpts := &registryOptions{
-			Insecure: req.Insecure,
-		}
-
-		ctx, cancel := context.WithCancel(c.Request.Context())
-		defer cancel()
-
-		name, err := getExistingName(model.ParseName(mname))
-		if err != nil {
-			ch <- gin.H{"error": err.Error()}
-			return
+	resp, err := GetModelInfo(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		case err.Error() == errtypes.InvalidModelNameErrMsg:
+			c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeInvalidModelName, err.Error()))
+		case errors.Is(err, errAliasCycle):
+			c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeInvalidRequest, err.Error()))
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
+		return
+	}
 
-		if err := PushModel(ctx, name.DisplayShortest(), regOpts, fn); err != nil {
-			ch <- gin.H{"error": err.Error()}
-		}
-	}()
+	name := req.Model
+	if resp.Target != "" {
+		name = resp.Target
+	}
 
-	if req.Stream != nil && !*req.Stream {
-		waitForStream(c, ch)
+	resp.Offload, err = s.offloadPlan(name, req.Options)
+	switch {
+	case errors.Is(err, errInvalidOption):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
+	case err != nil:
+		// the rest of the response is still useful without a plan
+		slog.Warn("failed to estimate offload", "model", name, "error", err)
 	}
 
-	streamResponse(c, ch)
+	c.JSON(http.StatusOK, resp)
 }
 
-// getExistingName searches the models directory for the longest prefix match of
-// the input name and returns the input name with all existing parts replaced
-// with each part found. If no parts are found, the input name is returned as
-// is.
-func getExistingName(n model.Name) (model.Name, error) {
-	var zero model.Name
-	existing, err := Manifests(true)
+// offloadPlan returns where the parts of the named model are placed: by the
+// runner that has it loaded if the request doesn't set options, or as they
+// would be on the GPUs that are free with the options otherwise.
+func (s *Server) offloadPlan(name string, requestOpts map[string]any) (*api.OffloadPlan, error) {
+	m, err := GetModel(name)
 	if err != nil {
-		return zero, err
+		return nil, err
 	}
-	var set model.Name // tracks parts already canonicalized
-	for e := range existing {
-		if set.Host == "" && strings.EqualFold(e.Host, n.Host) {
-			n.Host = e.Host
-		}
-		if set.Namespace == "" && strings.EqualFold(e.Namespace, n.Namespace) {
-			n.Namespace = e.Namespace
-		}
-		if set.Model == "" && strings.EqualFold(e.Model, n.Model) {
-			n.Model = e.Model
+
+	if len(requestOpts) == 0 && s.sched != nil {
+		s.sched.loadedMu.Lock()
+		runner := s.sched.loaded[m.ModelPath]
+		s.sched.loadedMu.Unlock()
+		if runner != nil {
+			plan := runner.offload
+			return &plan, nil
 		}
-		if set.Tag == "" && strings.EqualFold(e.Tag, n.Tag) {
-			n.Tag = e.Tag
+	}
+
+	opts, err := modelOptions(m, requestOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	offload, err := validatePlacement(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := llm.LoadModel(m.ModelPath, 0)
+	if unfetched(err) {
+		// there's no plan until the model is loaded
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if f.KV().BlockCount() == 0 {
+		// there's nothing to place, as with models that are only
+		// adapters or projectors
+		return nil, nil
+	}
+
+	if _, _, err := offload.GPUBlocks(int(f.KV().BlockCount())); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidOption, err)
+	}
+
+	gpus := discover.GetGPUInfo()
+	if opts.NumGPU == 0 && opts.Offload == "" {
+		gpus = discover.GetCPUInfo()
+	}
+
+	// the scheduler loads models on the library they fit on best
+	var plan *api.OffloadPlan
+	var layers int
+	for _, g := range gpus.ByLibrary() {
+		estimate := llm.EstimateGPULayers(g, f, m.ProjectorPaths, opts)
+		if plan == nil || estimate.Layers > layers {
+			plan, layers = &estimate.Offload, estimate.Layers
 		}
 	}
-	return n, nil
+
+	return plan, nil
 }
 
-func (s *Server) DeleteHandler(c *gin.Context) {
-	var r api.DeleteRequest
-	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+// RenderTemplateHandler renders the template of a model as a chat or generate
+// request would, without running the model, so templates can be debugged.
+func (s *Server) RenderTemplateHandler(c *gin.Context) {
+	var req api.RenderTemplateRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
 		return
 	} else if err != nil {
@@ -703,75 +2123,89 @@ func (s *Server) DeleteHandler(c *gin.Context) {
 		return
 	}
 
-	n := model.ParseName(cmp.Or(r.Model, r.Name))
-	if !n.IsValid() {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("name %q is invalid", cmp.Or(r.Model, r.Name))})
+	name, err := getExistingName(model.ParseName(req.Model))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	n, err := getExistingName(n)
+	name, _, err = resolveAlias(name)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", cmp.Or(r.Model, r.Name))})
+		c.AbortWithStatusJSON(aliasErrorResponse(req.Model, err))
 		return
 	}
 
-	m, err := ParseNamedManifest(n)
+	m, err := GetModel(name.String())
 	if err != nil {
 		switch {
 		case os.IsNotExist(err):
-			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", cmp.Or(r.Model, r.Name))})
+			c.AbortWithStatusJSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		case err.Error() == errtypes.InvalidModelNameErrMsg:
+			c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse(api.ErrorCodeInvalidModelName, err.Error()))
 		default:
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
 		return
 	}
 
-	if err := m.Remove(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
+	if req.Template != "" {
+		tmpl, err := template.Parse(req.Template)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-	if err := m.RemoveLayers(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		withTemplate := *m
+		withTemplate.Template = tmpl
+		m = &withTemplate
 	}
-}
 
-func (s *Server) ShowHandler(c *gin.Context) {
-	var req api.ShowRequest
-	err := c.ShouldBindJSON(&req)
-	switch {
-	case errors.Is(err, io.EOF):
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
-		return
-	case err != nil:
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	opts, err := modelOptions(m, nil)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	if req.Model != "" {
-		// noop
-	} else if req.Name != "" {
-		req.Model = req.Name
+	var prompt string
+	if len(req.Messages) > 0 {
+		msgs := append(slices.Clone(m.Messages), req.Messages...)
+		if req.Messages[0].Role != "system" && m.System != "" {
+			msgs = append([]api.Message{{Role: "system", Content: m.System}}, msgs...)
+		}
+
+		if msgs, err = applySystemPolicy(m.Name, msgs); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// the prompt isn't tokenized so no messages are truncated
+		tokenize := func(context.Context, string) ([]int, error) { return nil, nil }
+		if prompt, _, err = chatPrompt(c.Request.Context(), m, tokenize, &opts, msgs, req.Tools, req.Think == nil || *req.Think); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 	} else {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
-		return
+		values, err := generateValues(m, req.Prompt, req.System, req.Suffix, nil, true)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		var b bytes.Buffer
+		if err := m.Template.Execute(&b, values); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		prompt = b.String()
 	}
 
-	resp, err := GetModelInfo(req)
-	if err != nil {
-		switch {
-		case os.IsNotExist(err):
-			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
-		case err.Error() == errtypes.InvalidModelNameErrMsg:
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		default:
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
-		return
+	stop := opts.Stop
+	if len(stop) == 0 {
+		stop = m.Template.Stop()
 	}
 
-	c.JSON(http.StatusOK, resp)
+	c.JSON(http.StatusOK, api.RenderTemplateResponse{Prompt: prompt, Stop: stop})
 }
 
 func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
@@ -784,6 +2218,11 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 		return nil, err
 	}
 
+	name, chain, err := resolveAlias(name)
+	if err != nil {
+		return nil, err
+	}
+
 	m, err := GetModel(name.String())
 	if err != nil {
 		return nil, err
@@ -819,6 +2258,14 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 		Details:    modelDetails,
 		Messages:   msgs,
 		ModifiedAt: manifest.fi.ModTime(),
+		Provenance: m.Config.Provenance,
+	}
+
+	if len(chain) > 0 {
+		resp.Target = name.DisplayShortest()
+		for _, alias := range chain {
+			resp.AliasChain = append(resp.AliasChain, alias.DisplayShortest())
+		}
 	}
 
 	var params []string
@@ -835,6 +2282,9 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 	}
 	resp.Parameters = strings.Join(params, "\n")
 
+	if m.Options == nil && len(req.Options) > 0 {
+		m.Options = make(map[string]any)
+	}
 	for k, v := range req.Options {
 		if _, ok := req.Options[k]; ok {
 			m.Options[k] = v
@@ -848,8 +2298,12 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 	fmt.Fprint(&sb, m.String())
 	resp.Modelfile = sb.String()
 
+	// models whose weights haven't been fetched from the blob store have
+	// no model info until they're loaded
 	kvData, tensors, err := getModelData(m.ModelPath, req.Verbose)
-	if err != nil {
+	if unfetched(err) {
+		return resp, nil
+	} else if err != nil {
 		return nil, err
 	}
 
@@ -865,7 +2319,7 @@ func GetModelInfo(req api.ShowRequest) (*api.ShowResponse, error) {
 
 	if len(m.ProjectorPaths) > 0 {
 		projectorData, _, err := getModelData(m.ProjectorPaths[0], req.Verbose)
-		if err != nil {
+		if err != nil && !unfetched(err) {
 			return nil, err
 		}
 		resp.ProjectorInfo = projectorData
@@ -904,6 +2358,8 @@ func (s *Server) ListHandler(c *gin.Context) {
 		return
 	}
 
+	maps.DeleteFunc(ms, func(n model.Name, _ *Manifest) bool { return !visible(c, n) })
+
 	models := []api.ListModelResponse{}
 	for n, m := range ms {
 		var cf ConfigV2
@@ -944,7 +2400,115 @@ func (s *Server) ListHandler(c *gin.Context) {
 		return cmp.Compare(j.ModifiedAt.Unix(), i.ModifiedAt.Unix())
 	})
 
-	c.JSON(http.StatusOK, api.ListResponse{Models: models})
+	c.JSON(http.StatusOK, api.ListResponse{Models: models, Usage: namespaceUsage(ms)})
+}
+
+func (s *Server) QuantizeHandler(c *gin.Context) {
+	var r api.QuantizeRequest
+	if err := c.ShouldBindJSON(&r); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	src := model.ParseName(r.Source)
+	if !src.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("source %q is invalid", r.Source)})
+		return
+	}
+
+	src, _, err := resolveAlias(src)
+	if err != nil {
+		c.AbortWithStatusJSON(aliasErrorResponse(r.Source, err))
+		return
+	}
+
+	if src, err = getExistingName(src); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dst := model.ParseName(r.Destination)
+	if !dst.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("destination %q is invalid", r.Destination)})
+		return
+	}
+
+	if dst, err = getExistingName(dst); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ft := strings.ToUpper(r.Type)
+	if _, ok := quantizeTypes[ft]; !ok {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %q", errUnsupportedQuantization, r.Type)})
+		return
+	} else if len(r.Calibration) == 0 && slices.Contains(calibrationTypes, ft) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %s", errCalibrationRequired, ft)})
+		return
+	}
+
+	m, err := GetModel(src.String())
+	if errors.Is(err, os.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model %q not found", r.Source)))
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(r.Calibration) > 0 && !slices.Contains([]string{"F32", "F16", "BF16"}, strings.ToUpper(m.Config.FileType)) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("calibration requires an unquantized model, %q is %s", r.Source, m.Config.FileType)})
+		return
+	}
+
+	tenant := c.GetString(tenantKey)
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		fn := func(resp api.ProgressResponse) {
+			ch <- resp
+		}
+
+		ctx := c.Request.Context()
+
+		// calibration is run by a runner like any other request, rather
+		// than in the server, where it could use any amount of memory
+		var importance map[string][]float32
+		if len(r.Calibration) > 0 {
+			fn(api.ProgressResponse{Status: "loading model for calibration"})
+			runner, _, _, err := s.scheduleRunner(ctx, src.String(), "", []Capability{CapabilityCompletion}, nil, nil)
+			if err != nil {
+				ch <- progressError(err)
+				return
+			}
+
+			if importance, err = computeImportance(ctx, runner, r.Calibration, fn); errors.Is(err, errCalibrationTooShort) {
+				ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
+				return
+			} else if err != nil {
+				ch <- progressError(err)
+				return
+			}
+		}
+
+		if err := quantizeModel(ctx, src, dst, r.Type, importance, fn); err != nil {
+			ch <- progressError(err)
+			return
+		}
+
+		emitWebhook(api.WebhookEvent{Event: api.WebhookModelCreated, Model: dst.DisplayShortest(), Tenant: tenant})
+		ch <- api.ProgressResponse{Status: "success"}
+	}()
+
+	if r.Stream != nil && !*r.Stream {
+		waitForStream(c, ch)
+		return
+	}
+
+	streamResponse(c, ch)
 }
 
 func (s *Server) CopyHandler(c *gin.Context) {
@@ -979,10 +2543,15 @@ func (s *Server) CopyHandler(c *gin.Context) {
 		return
 	}
 
-	if err := CopyModel(src, dst); errors.Is(err, os.ErrNotExist) {
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found", r.Source)})
+	var qerr api.QuotaError
+	if err := CopyModel(c.Request.Context(), src, dst); errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model %q not found", r.Source)))
+	} else if errors.As(err, &qerr) {
+		c.JSON(http.StatusInsufficientStorage, gin.H{"error": err.Error(), "quota": qerr})
 	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	} else {
+		emitWebhook(api.WebhookEvent{Event: api.WebhookModelCreated, Model: dst.DisplayShortest(), Tenant: c.GetString(tenantKey)})
 	}
 }
 
@@ -994,6 +2563,13 @@ func (s *Server) HeadBlobHandler(c *gin.Context) {
 	}
 
 	if _, err := os.Stat(path); err != nil {
+		if store, err := remoteStore(); err == nil && store != nil {
+			if _, err := store.Stat(c.Request.Context(), blobKey(c.Param("digest"))); err == nil {
+				c.Status(http.StatusOK)
+				return
+			}
+		}
+
 		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("blob %q not found", c.Param("digest"))})
 		return
 	}
@@ -1001,6 +2577,42 @@ func (s *Server) HeadBlobHandler(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
+func (s *Server) GetBlobHandler(c *gin.Context) {
+	if !envconfig.ServeBlobs() {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "serving blobs is disabled, set OLLAMA_SERVE_BLOBS to share them with peers"})
+		return
+	}
+
+	path, err := fetchBlob(c.Request.Context(), c.Param("digest"))
+	switch {
+	case errors.Is(err, ErrInvalidDigestFormat):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, os.ErrNotExist):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("blob %q not found", c.Param("digest"))})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// blobs of models a tenant can't see are as good as missing to it
+	if ok, err := blobVisible(c, c.Param("digest")); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	} else if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("blob %q not found", c.Param("digest"))})
+		return
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("blob %q not found", c.Param("digest"))})
+		return
+	}
+
+	c.File(path)
+}
+
 func (s *Server) CreateBlobHandler(c *gin.Context) {
 	if ib, ok := intermediateBlobs[c.Param("digest")]; ok {
 		p, err := GetBlobsPath(ib)
@@ -1140,36 +2752,12 @@ func allowedHostsMiddleware(addr net.Addr) gin.HandlerFunc {
 }
 
 func (s *Server) GenerateRoutes(rc *ollama.Registry) (http.Handler, error) {
-	corsConfig := cors.DefaultConfig()
-	corsConfig.AllowWildcard = true
-	corsConfig.AllowBrowserExtensions = true
-	corsConfig.AllowHeaders = []string{
-		"Authorization",
-		"Content-Type",
-		"User-Agent",
-		"Accept",
-		"X-Requested-With",
-
-		// OpenAI compatibility headers
-		"x-stainless-lang",
-		"x-stainless-package-version",
-		"x-stainless-os",
-		"x-stainless-arch",
-		"x-stainless-retry-count",
-		"x-stainless-runtime",
-		"x-stainless-runtime-version",
-		"x-stainless-async",
-		"x-stainless-helper-method",
-		"x-stainless-poll-helper",
-		"x-stainless-custom-poll-interval",
-		"x-stainless-timeout",
-	}
-	corsConfig.AllowOrigins = envconfig.AllowedOrigins()
-
 	r := gin.Default()
 	r.Use(
-		cors.New(corsConfig),
+		s.corsMiddleware(),
 		allowedHostsMiddleware(s.addr),
+		errorCodeMiddleware(),
+		tenantMiddleware(),
 	)
 
 	// General
@@ -1177,36 +2765,87 @@ func (s *Server) GenerateRoutes(rc *ollama.Registry) (http.Handler, error) {
 	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "Ollama is running") })
 	r.HEAD("/api/version", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"version": version.Version}) })
 	r.GET("/api/version", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"version": version.Version}) })
+	r.HEAD("/healthz", s.HealthHandler)
+	r.GET("/healthz", s.HealthHandler)
+	r.HEAD("/readyz", s.ReadyHandler)
+	r.GET("/readyz", s.ReadyHandler)
+
+	// the model names in the bodies of requests, which are resolved to the
+	// namespace of the request's API key
+	readNames := tenantNames(map[string]nameAccess{"model": readAccess, "name": readAccess, "adapter": readAccess})
+	writeNames := tenantNames(map[string]nameAccess{"model": writeAccess, "name": writeAccess, "from": readAccess})
+	registryNames := tenantNames(map[string]nameAccess{"model": registryAccess, "name": registryAccess})
+	copyNames := tenantNames(map[string]nameAccess{"source": readAccess, "destination": writeAccess})
+	aliasNames := tenantNames(map[string]nameAccess{"alias": writeAccess, "target": readAccess})
 
 	// Local model cache management (new implementation is at end of function)
-	r.POST("/api/pull", s.PullHandler)
-	r.POST("/api/push", s.PushHandler)
+	r.POST("/api/pull", registryNames, s.PullHandler)
+	r.GET("/api/pull", s.ListPullsHandler)
+	r.POST("/api/push", registryNames, s.PushHandler)
 	r.HEAD("/api/tags", s.ListHandler)
 	r.GET("/api/tags", s.ListHandler)
-	r.POST("/api/show", s.ShowHandler)
-	r.DELETE("/api/delete", s.DeleteHandler)
+	r.POST("/api/show", readNames, s.ShowHandler)
+	r.POST("/api/template/render", readNames, s.RenderTemplateHandler)
+	r.POST("/api/sbom", readNames, s.SBOMHandler)
+	r.POST("/api/search", s.SearchHandler)
+	r.DELETE("/api/delete", writeNames, s.DeleteHandler)
+	r.POST("/api/prune", adminMiddleware(), s.PruneHandler)
+	r.GET("/api/store/stats", adminMiddleware(), s.StoreStatsHandler)
+	r.POST("/api/shutdown", adminMiddleware(), s.ShutdownHandler)
+	r.GET("/api/config", adminMiddleware(), s.ConfigHandler)
+	r.POST("/api/config", adminMiddleware(), s.SetConfigHandler)
 
 	// Create
-	r.POST("/api/create", s.CreateHandler)
+	r.POST("/api/create", writeNames, s.CreateHandler)
 	r.POST("/api/blobs/:digest", s.CreateBlobHandler)
 	r.HEAD("/api/blobs/:digest", s.HeadBlobHandler)
-	r.POST("/api/copy", s.CopyHandler)
+	r.GET("/api/blobs/:digest", s.GetBlobHandler)
+	r.POST("/api/blobs/:digest/link", adminMiddleware(), s.LinkBlobHandler)
+	r.POST("/api/copy", copyNames, s.CopyHandler)
+	r.POST("/api/quantize", copyNames, s.QuantizeHandler)
+	r.GET("/api/aliases", s.ListAliasesHandler)
+	r.GET("/api/adapters", s.AdaptersHandler)
+	r.GET("/api/profiles", adminMiddleware(), s.ListProfilesHandler)
+	r.DELETE("/api/profiles", adminMiddleware(), s.DeleteProfilesHandler)
+	r.POST("/api/aliases", aliasNames, s.SetAliasHandler)
+	r.DELETE("/api/aliases", aliasNames, s.DeleteAliasHandler)
+	r.GET("/api/splits", s.ListSplitsHandler)
+	r.POST("/api/splits", aliasNames, s.SetSplitHandler)
+	r.DELETE("/api/splits", aliasNames, s.DeleteSplitHandler)
+	r.GET("/api/collections", s.ListCollectionsHandler)
+	r.POST("/api/collections", s.CreateCollectionHandler)
+	r.DELETE("/api/collections", s.DeleteCollectionHandler)
+	r.POST("/api/collections/points", s.UpsertPointsHandler)
+	r.DELETE("/api/collections/points", s.DeletePointsHandler)
+	r.POST("/api/collections/search", s.SearchCollectionHandler)
+	r.GET("/api/chats", s.ListChatsHandler)
+	r.GET("/api/chats/:name", s.GetChatHandler)
+	r.PUT("/api/chats/:name", requestSizeMiddleware(), s.SaveChatHandler)
+	r.DELETE("/api/chats/:name", s.DeleteChatHandler)
 
 	// Inference
 	r.GET("/api/ps", s.PsHandler)
-	r.POST("/api/generate", s.GenerateHandler)
-	r.POST("/api/chat", s.ChatHandler)
-	r.POST("/api/embed", s.EmbedHandler)
-	r.POST("/api/embeddings", s.EmbeddingsHandler)
+	r.POST("/api/generate", requestSizeMiddleware(), captureMiddleware(), pluginMiddleware(), readNames, splitMiddleware(), s.cacheMiddleware(), s.requestMiddleware(), s.GenerateHandler)
+	r.POST("/api/chat", requestSizeMiddleware(), captureMiddleware(), pluginMiddleware(), readNames, splitMiddleware(), s.cacheMiddleware(), s.requestMiddleware(), s.ChatHandler)
+	r.POST("/api/fim", requestSizeMiddleware(), captureMiddleware(), pluginMiddleware(), readNames, splitMiddleware(), s.requestMiddleware(), s.FIMHandler)
+	r.POST("/api/embed", requestSizeMiddleware(), captureMiddleware(), pluginMiddleware(), readNames, splitMiddleware(), s.requestMiddleware(), s.EmbedHandler)
+	r.POST("/api/classify", requestSizeMiddleware(), captureMiddleware(), pluginMiddleware(), readNames, splitMiddleware(), s.requestMiddleware(), s.ClassifyHandler)
+	r.POST("/api/moderations", requestSizeMiddleware(), readNames, s.ModerationHandler)
+	r.POST("/api/agent", requestSizeMiddleware(), captureMiddleware(), pluginMiddleware(), readNames, splitMiddleware(), s.requestMiddleware(), s.AgentHandler)
+	r.GET("/api/agent/tools", adminMiddleware(), s.AgentToolsHandler)
+	r.POST("/api/embeddings", requestSizeMiddleware(), captureMiddleware(), pluginMiddleware(), readNames, splitMiddleware(), s.requestMiddleware(), s.EmbeddingsHandler)
+	r.POST("/api/requests/:id/cancel", s.CancelRequestHandler)
 
 	// Inference (OpenAI compatibility)
-	r.POST("/v1/chat/completions", openai.ChatMiddleware(), s.ChatHandler)
-	r.POST("/v1/completions", openai.CompletionsMiddleware(), s.GenerateHandler)
-	r.POST("/v1/embeddings", openai.EmbeddingsMiddleware(), s.EmbedHandler)
+	r.POST("/v1/chat/completions", requestSizeMiddleware(), captureMiddleware(), pluginMiddleware(), readNames, splitMiddleware(), s.requestMiddleware(), openai.ChatMiddleware(), s.ChatHandler)
+	r.POST("/v1/completions", requestSizeMiddleware(), captureMiddleware(), pluginMiddleware(), readNames, splitMiddleware(), s.requestMiddleware(), openai.CompletionsMiddleware(), s.GenerateHandler)
+	r.POST("/v1/embeddings", requestSizeMiddleware(), captureMiddleware(), pluginMiddleware(), readNames, splitMiddleware(), s.requestMiddleware(), openai.EmbeddingsMiddleware(), s.EmbedHandler)
 	r.GET("/v1/models", openai.ListMiddleware(), s.ListHandler)
-	r.GET("/v1/models/:model", openai.RetrieveMiddleware(), s.ShowHandler)
+	r.GET("/v1/models/:model", openai.RetrieveMiddleware(), readNames, s.ShowHandler)
 
-	if rc != nil {
+	if rc != nil && envconfig.APIKeys() != "" {
+		slog.Warn("the client2 experiment doesn't support OLLAMA_API_KEYS, using the default pull and delete")
+	} else if rc != nil {
 		// wrap old with new
 		rs := &registry.Local{
 			Client:   rc,
@@ -1215,21 +2854,31 @@ func (s *Server) GenerateRoutes(rc *ollama.Registry) (http.Handler, error) {
 
 			Prune: PruneLayers,
 		}
-		return rs, nil
+		return s.originHandler(rs), nil
 	}
 
 	return r, nil
 }
 
+// Serve serves the API on ln until the process is interrupted or
+// terminated, or a client asks it to shut down.
 func Serve(ln net.Listener) error {
+	return ServeContext(context.Background(), ln)
+}
+
+// ServeContext is like [Serve], and also shuts down gracefully when stop is
+// done, for service managers which don't stop the server with a signal.
+func ServeContext(stop context.Context, ln net.Listener) error {
+	configErr := envconfig.Reload()
+
 	level := slog.LevelInfo
 	if envconfig.Debug() {
 		level = slog.LevelDebug
 	}
+	logLevel.Set(level)
 
-	slog.Info("server config", "env", envconfig.Values())
-	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
-		Level:     level,
+	handler := logHandler(&slog.HandlerOptions{
+		Level:     &logLevel,
 		AddSource: true,
 		ReplaceAttr: func(_ []string, attr slog.Attr) slog.Attr {
 			if attr.Key == slog.SourceKey {
@@ -1242,15 +2891,24 @@ func Serve(ln net.Listener) error {
 	})
 
 	slog.SetDefault(slog.New(handler))
+	slog.Info("server config", "env", envconfig.Effective())
 
-	blobsDir, err := GetBlobsPath("")
-	if err != nil {
-		return err
+	for _, warning := range envconfig.Check() {
+		slog.Warn(warning)
+	}
+
+	if configErr != nil {
+		return configErr
 	}
-	if err := fixBlobs(blobsDir); err != nil {
+
+	if err := repairStore(); err != nil {
 		return err
 	}
 
+	if err := syncManifests(context.Background()); err != nil {
+		slog.Warn("failed to sync manifests from blob store", "error", err)
+	}
+
 	if !envconfig.NoPrune() {
 		if _, err := Manifests(false); err != nil {
 			slog.Warn("corrupt manifests detected, skipping prune operation.  Re-pull or delete to clear", "error", err)
@@ -1259,19 +2917,10 @@ func Serve(ln net.Listener) error {
 			if err := PruneLayers(); err != nil {
 				return err
 			}
-
-			manifestsPath, err := GetManifestPath()
-			if err != nil {
-				return err
-			}
-
-			if err := PruneDirectory(manifestsPath); err != nil {
-				return err
-			}
 		}
 	}
 
-	s := &Server{addr: ln.Addr()}
+	s := &Server{addr: ln.Addr(), shutdown: make(chan struct{})}
 
 	var rc *ollama.Registry
 	if useClient2 {
@@ -1280,6 +2929,12 @@ func Serve(ln net.Listener) error {
 		if err != nil {
 			return err
 		}
+
+		t, err := transport.Default()
+		if err != nil {
+			return err
+		}
+		rc.HTTPClient = &http.Client{Transport: t}
 	}
 
 	h, err := s.GenerateRoutes(rc)
@@ -1307,24 +2962,47 @@ func Serve(ln net.Listener) error {
 		Handler: nil,
 	}
 
-	// listen for a ctrl+c and stop any loaded llm
+	// listen for a ctrl+c or a shutdown request, let requests finish and stop any loaded llm
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
-		<-signals
-		srvr.Close()
+		select {
+		case <-signals:
+		case <-s.shutdown:
+		case <-stop.Done():
+		}
+
+		if err := systemdNotify("STOPPING=1"); err != nil {
+			slog.Warn("failed to notify systemd", "error", err)
+		}
+
+		s.drain(srvr, signals)
 		schedDone()
 		sched.unloadAllRunners()
 		done()
 	}()
 
 	s.sched.Run(schedCtx)
+	go s.restoreModels(schedCtx)
+	s.resumePulls(ctx)
+
+	if interval := envconfig.PruneInterval(); interval > 0 {
+		go s.pruneLoop(ctx, interval)
+	}
+
+	go s.blobCacheLoop(ctx)
+	go s.flushModelUses(ctx)
+	go s.reloadLoop(ctx)
 
 	// At startup we retrieve GPU information so we can get log messages before loading a model
 	// This will log warnings to the log in case we have problems with detected GPUs
 	gpus := discover.GetGPUInfo()
 	gpus.LogDetails()
 
+	if err := systemdNotify("READY=1"); err != nil {
+		slog.Warn("failed to notify systemd", "error", err)
+	}
+
 	err = srvr.Serve(ln)
 	// If server is closed from the signal handler, wait for the ctx to be done
 	// otherwise error out quickly
@@ -1350,7 +3028,14 @@ func waitForStream(c *gin.Context, ch chan interface{}) {
 				status = http.StatusInternalServerError
 			}
 			if errorMsg, ok := r["error"].(string); ok {
-				c.JSON(status, gin.H{"error": errorMsg})
+				resp := gin.H{"error": errorMsg}
+				for _, k := range []string{"quota", "code", "retryable"} {
+					if v, ok := r[k]; ok {
+						resp[k] = v
+					}
+				}
+
+				c.JSON(status, resp)
 				return
 			} else {
 				c.JSON(status, gin.H{"error": "unexpected error format in progress response"})
@@ -1378,6 +3063,10 @@ func streamResponse(c *gin.Context, ch chan any) {
 			return false
 		}
 
+		if _, ok := val.(gin.H); ok {
+			bts = withErrorCode(http.StatusInternalServerError, bts)
+		}
+
 		// Delineate chunks with new-line delimiter
 		bts = append(bts, '\n')
 		if _, err := w.Write(bts); err != nil {
@@ -1387,12 +3076,23 @@ func streamResponse(c *gin.Context, ch chan any) {
 
 		return true
 	})
+
+	// the stream stops early if the client is gone, so discard the rest
+	// rather than leave the sender blocked until the request finishes
+	go func() {
+		for range ch {
+		}
+	}()
 }
 
 func (s *Server) PsHandler(c *gin.Context) {
 	models := []api.ProcessModelResponse{}
 
 	for _, v := range s.sched.loaded {
+		if !visible(c, model.ParseName(v.model.Name)) {
+			continue
+		}
+
 		model := v.model
 		modelDetails := api.ModelDetails{
 			Format:            model.Config.ModelFormat,
@@ -1410,6 +3110,10 @@ func (s *Server) PsHandler(c *gin.Context) {
 			Digest:    model.Digest,
 			Details:   modelDetails,
 			ExpiresAt: v.expiresAt,
+
+			FlashAttention: v.flashAttention,
+			PagedAttention: v.pagedAttention,
+			Offload:        &v.offload,
 		}
 		// The scheduler waits to set expiresAt, so if a model is loading it's
 		// possible that it will be set to the unix epoch. For those cases, just
@@ -1448,9 +3152,9 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		if err != nil {
 			switch {
 			case os.IsNotExist(err):
-				c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+				c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
 			case err.Error() == errtypes.InvalidModelNameErrMsg:
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeInvalidModelName, err.Error()))
 			default:
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			}
@@ -1468,10 +3172,36 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	if req.N < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "n must not be negative"})
+		return
+	}
+
+	if req.TimeoutMS < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "timeout_ms must not be negative"})
+		return
+	}
+
+	var numImages int
+	for _, msg := range req.Messages {
+		numImages += len(msg.Images)
+	}
+
+	if err := checkImages(numImages); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, limitResponse(err))
+		return
+	}
+
 	caps := []Capability{CapabilityCompletion}
 	if len(req.Tools) > 0 {
 		caps = append(caps, CapabilityTools)
 	}
+	if req.Think != nil && *req.Think {
+		caps = append(caps, CapabilityThinking)
+	}
+	if slices.ContainsFunc(req.Messages, func(m api.Message) bool { return len(m.Audio) > 0 }) {
+		caps = append(caps, CapabilityAudio)
+	}
 
 	name := model.ParseName(req.Model)
 	if !name.IsValid() {
@@ -1484,15 +3214,48 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
-	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), caps, req.Options, req.KeepAlive)
+	name, _, err = resolveAlias(name)
+	if err != nil {
+		c.JSON(aliasErrorResponse(req.Model, err))
+		return
+	}
+
+	input, ok := s.moderateRequest(c, req.Messages)
+	if !ok {
+		return
+	}
+
+	r, m, opts, err := s.scheduleRunner(c.Request.Context(), name.String(), req.Adapter, caps, req.Options, req.KeepAlive)
 	if errors.Is(err, errCapabilityCompletion) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support chat", req.Model)})
+		c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeCapabilityMissing, fmt.Sprintf("%q does not support chat", req.Model)))
 		return
 	} else if err != nil {
 		handleScheduleError(c, req.Model, err)
 		return
 	}
 
+	// chatChoice is the state of the response of each completion
+	type chatChoice struct {
+		thinking      *thinkingParser
+		post          postProcessor
+		sb            strings.Builder
+		toolCallIndex int
+
+		// content is the whole message, to find the regions of images
+		// it points out once it's done
+		content strings.Builder
+	}
+
+	n := max(req.N, 1)
+	choices := make([]chatChoice, n)
+	for i := range choices {
+		choices[i].post, err = newPostProcessor(opts.PostProcess)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	checkpointLoaded := time.Now()
 
 	if len(req.Messages) == 0 {
@@ -1506,13 +3269,35 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	if !parseSpecial(opts) {
+		specials, err := specialTokens(m.ModelPath)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		for i := range req.Messages {
+			req.Messages[i].Content = escapeSpecial(req.Messages[i].Content, specials)
+			req.Messages[i].Thinking = escapeSpecial(req.Messages[i].Thinking, specials)
+		}
+	}
+
 	msgs := append(m.Messages, req.Messages...)
 	if req.Messages[0].Role != "system" && m.System != "" {
 		msgs = append([]api.Message{{Role: "system", Content: m.System}}, msgs...)
 	}
 
-	prompt, images, err := chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, req.Tools)
+	msgs, err = applySystemPolicy(m.Name, msgs)
 	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	prompt, images, err := chatPrompt(c.Request.Context(), m, r.Tokenize, opts, msgs, req.Tools, req.Think == nil || *req.Think)
+	if errors.Is(err, errInvalidAudio) || errors.Is(err, errInvalidImage) || errors.Is(err, errInvalidImagePlaceholder) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
 		slog.Error("chat prompt error", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -1520,36 +3305,82 @@ func (s *Server) ChatHandler(c *gin.Context) {
 
 	slog.Debug("chat request", "images", len(images), "prompt", prompt)
 
+	// only responses about images can point out regions of them
+	grounded := slices.ContainsFunc(msgs, func(m api.Message) bool { return len(m.Images) > 0 })
+
+	if m.thinks() {
+		for i := range choices {
+			choices[i].thinking = newThinkingParser(m, prompt)
+		}
+	}
+
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
-		var sb strings.Builder
-		var toolCallIndex int = 0
+		var metrics api.Metrics
+		var done int
 		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
+			Prompt:   prompt,
+			Images:   images,
+			Format:   req.Format,
+			Options:  opts,
+			Adapters: m.AdapterPaths,
+			N:        n,
+			Deadline: generateDeadline(checkpointStart, req.TimeoutMS),
 		}, func(r llm.CompletionResponse) {
 			res := api.ChatResponse{
 				Model:      req.Model,
 				CreatedAt:  time.Now().UTC(),
+				RequestID:  c.GetString(requestIDKey),
+				Index:      r.Index,
 				Message:    api.Message{Role: "assistant", Content: r.Content},
-				Done:       r.Done,
 				DoneReason: r.DoneReason,
-				Metrics: api.Metrics{
-					PromptEvalCount:    r.PromptEvalCount,
-					PromptEvalDuration: r.PromptEvalDuration,
-					EvalCount:          r.EvalCount,
-					EvalDuration:       r.EvalDuration,
-				},
 			}
 
 			if r.Done {
-				res.TotalDuration = time.Since(checkpointStart)
-				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				// the request is done with its last completion
+				addCompletionMetrics(&metrics, r)
+				if done++; done == n {
+					res.Done = true
+					res.Metrics = metrics
+					res.TotalDuration = time.Since(checkpointStart)
+					res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				}
+			}
+
+			choice := &choices[r.Index]
+			thinking, post := choice.thinking, choice.post
+			if thinking != nil {
+				res.Message.Thinking, res.Message.Content = thinking.add(r.Content)
+				if r.Done {
+					t, content := thinking.flush()
+					res.Message.Thinking += t
+					res.Message.Content += content
+				}
+
+				if req.Think != nil && !*req.Think {
+					res.Message.Thinking = ""
+				}
+			}
+
+			res.Message.Content = post.add(res.Message.Content)
+			if r.Done {
+				res.Message.Content += post.flush(r.DoneReason)
+			}
+
+			if grounded {
+				choice.content.WriteString(res.Message.Content)
+				if r.Done {
+					res.Regions = parseRegions(choice.content.String())
+				}
+			}
+
+			if res.Message.Thinking == "" && res.Message.Content == "" && !r.Done {
+				return
 			}
 
+			sb := &choice.sb
+
 			// TODO: tool call checking and filtering should be moved outside of this callback once streaming
 			// however this was a simple change for now without reworking streaming logic of this (and other)
 			// handlers
@@ -1561,12 +3392,12 @@ func (s *Server) ChatHandler(c *gin.Context) {
 			// Streaming tool calls:
 			// If tools are recognized, use a flag to track the sending of a tool downstream
 			// This ensures that content is cleared from the message on the last chunk sent
-			sb.WriteString(r.Content)
+			sb.WriteString(res.Message.Content)
 			if toolCalls, ok := m.parseToolCalls(sb.String()); ok {
 				res.Message.ToolCalls = toolCalls
 				for i := range toolCalls {
-					toolCalls[i].Function.Index = toolCallIndex
-					toolCallIndex++
+					toolCalls[i].Function.Index = choice.toolCallIndex
+					choice.toolCallIndex++
 				}
 				res.Message.Content = ""
 				sb.Reset()
@@ -1574,33 +3405,62 @@ func (s *Server) ChatHandler(c *gin.Context) {
 				return
 			}
 
+			if res.Message.Thinking != "" && !r.Done {
+				// reasoning is never a tool call, so don't hold it back
+				res.Message.Content = ""
+				ch <- res
+				return
+			}
+
 			if r.Done {
 				// Send any remaining content if no tool calls were detected
-				if toolCallIndex == 0 {
+				if choice.toolCallIndex == 0 {
 					res.Message.Content = sb.String()
 				}
 				ch <- res
 			}
 		}); err != nil {
-			ch <- gin.H{"error": err.Error()}
+			ch <- streamError(err)
+			return
 		}
+
+		metrics.TotalDuration = time.Since(checkpointStart)
+		metrics.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+		emitWebhook(api.WebhookEvent{
+			Event:     api.WebhookRequestCompleted,
+			Model:     req.Model,
+			Tenant:    c.GetString(tenantKey),
+			RequestID: c.GetString(requestIDKey),
+			Metrics:   &metrics,
+		})
+		recordSplitMetrics(c, metrics)
 	}()
 
+	responses := s.moderateResponses(c.Request.Context(), req.Messages, input, ch)
+
 	if req.Stream != nil && !*req.Stream {
 		var resp api.ChatResponse
-		var sb strings.Builder
-		for rr := range ch {
+		sbs := make([]strings.Builder, n)
+		tbs := make([]strings.Builder, n)
+		messages := make([]api.ChatChoice, n)
+		for rr := range responses {
 			switch t := rr.(type) {
 			case api.ChatResponse:
-				sb.WriteString(t.Message.Content)
+				sbs[t.Index].WriteString(t.Message.Content)
+				tbs[t.Index].WriteString(t.Message.Thinking)
+				if t.Done || t.DoneReason != "" {
+					messages[t.Index].DoneReason = t.DoneReason
+				}
+				if t.Regions != nil {
+					messages[t.Index].Regions = t.Regions
+				}
 				resp = t
 			case gin.H:
-				msg, ok := t["error"].(string)
-				if !ok {
-					msg = "unexpected error format in response"
+				if _, ok := t["error"].(string); !ok {
+					t = gin.H{"error": "unexpected error format in response"}
 				}
 
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+				c.JSON(errorStatus(t), t)
 				return
 			default:
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected response"})
@@ -1608,33 +3468,47 @@ func (s *Server) ChatHandler(c *gin.Context) {
 			}
 		}
 
-		resp.Message.Content = sb.String()
-
-		if len(req.Tools) > 0 {
-			if toolCalls, ok := m.parseToolCalls(sb.String()); ok {
-				resp.Message.ToolCalls = toolCalls
-				resp.Message.Content = ""
+		for i := range messages {
+			messages[i].Index = i
+			messages[i].Message = api.Message{Role: "assistant", Content: sbs[i].String(), Thinking: tbs[i].String()}
+			if len(req.Tools) > 0 {
+				if toolCalls, ok := m.parseToolCalls(sbs[i].String()); ok {
+					messages[i].Message.ToolCalls = toolCalls
+					messages[i].Message.Content = ""
+				}
 			}
 		}
 
+		// the response is the first completion, with the others in choices
+		resp.Index = 0
+		resp.Message = messages[0].Message
+		resp.DoneReason = messages[0].DoneReason
+		resp.Regions = messages[0].Regions
+		if n > 1 {
+			resp.Choices = messages
+		}
+
 		c.JSON(http.StatusOK, resp)
 		return
 	}
 
-	streamResponse(c, ch)
+	streamResponse(c, responses)
 }
 
 func handleScheduleError(c *gin.Context, name string, err error) {
-	switch {
-	case errors.Is(err, errCapabilities), errors.Is(err, errRequired):
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-	case errors.Is(err, context.Canceled):
-		c.JSON(499, gin.H{"error": "request canceled"})
-	case errors.Is(err, ErrMaxQueue):
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
-	case errors.Is(err, os.ErrNotExist):
-		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model %q not found, try pulling it first", name)})
+	code := errorCode(err)
+	switch code {
+	case api.ErrorCodeCapabilityMissing, api.ErrorCodeInvalidRequest:
+		c.JSON(http.StatusBadRequest, errorResponse(code, err.Error()))
+	case api.ErrorCodeLimitExceeded:
+		c.JSON(http.StatusBadRequest, limitResponse(err))
+	case api.ErrorCodeCanceled:
+		c.JSON(499, errorResponse(code, "request canceled"))
+	case api.ErrorCodeServerBusy:
+		c.JSON(http.StatusServiceUnavailable, errorResponse(code, err.Error()))
+	case api.ErrorCodeNotFound:
+		c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model %q not found, try pulling it first", name)))
 	default:
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusInternalServerError, errorResponse(code, err.Error()))
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"cmp"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,12 +15,15 @@ import (
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/types/model"
+	"github.com/ollama/ollama/version"
 )
 
 var stream bool = false
@@ -100,11 +104,32 @@ func checkFileExists(t *testing.T, p string, expect []string) {
 		t.Fatal(err)
 	}
 
+	slices.Sort(expect)
 	if !slices.Equal(actual, expect) {
 		t.Fatalf("expected slices to be equal %v", actual)
 	}
 }
 
+// configBlob returns the path of the config blob of the model name. It's
+// looked up for models created from other models since their provenance
+// includes the digest of the manifest of the other model, which refers to
+// blobs by their path in the models directory.
+func configBlob(t *testing.T, name string) string {
+	t.Helper()
+
+	m, err := ParseNamedManifest(model.ParseName(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := GetBlobsPath(m.Config.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
 func TestCreateFromBin(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -175,11 +200,78 @@ func TestCreateFromModel(t *testing.T) {
 	})
 
 	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
+		configBlob(t, "test2"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
 		filepath.Join(p, "blobs", "sha256-ca239d7bd8ea90e4a5d2e6bf88f8d74a47b14336e73eb4e18bed4dd325018116"),
 	})
 }
 
+func TestCreateProvenance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	p := t.TempDir()
+	t.Setenv("OLLAMA_MODELS", p)
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:            "test",
+		Files:           map[string]string{"test.gguf": digest},
+		ModelfileDigest: "sha256:abc",
+		Stream:          &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   "test2",
+		From:   "test",
+		System: "You are a test.",
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	parent, err := ParseNamedManifest(model.ParseName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := GetModelInfo(api.ShowRequest{Model: "test2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Provenance == nil || resp.Provenance.Parent == nil {
+		t.Fatalf("expected the parent of the model, got %+v", resp.Provenance)
+	}
+
+	if got := *resp.Provenance.Parent; got.Model != "registry.ollama.ai/library/test:latest" || got.Digest != parent.digest {
+		t.Errorf("expected the parent test at %s, got %s at %s", parent.digest, got.Model, got.Digest)
+	}
+
+	// the provenance of the parent is carried along
+	if got := resp.Provenance.Parent.Provenance; got == nil || got.ModelfileDigest != "sha256:abc" {
+		t.Errorf("expected the provenance of the parent, got %+v", got)
+	}
+
+	// nothing is recorded for a model created from files without a Modelfile
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   "test3",
+		Files:  map[string]string{"test.gguf": digest},
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	if m, err := GetModel("test3"); err != nil || m.Config.Provenance != nil {
+		t.Errorf("expected no provenance, got %+v %v", m.Config.Provenance, err)
+	}
+}
+
 func TestCreateRemovesLayers(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -353,9 +445,9 @@ func TestCreateMergeParameters(t *testing.T) {
 	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
 		filepath.Join(p, "blobs", "sha256-1d0ad71299d48c2fb7ae2b98e683643e771f8a5b72be34942af90d97a91c1e37"),
 		filepath.Join(p, "blobs", "sha256-4a384beaf47a9cbe452dfa5ab70eea691790f3b35a832d12933a1996685bf2b6"),
-		filepath.Join(p, "blobs", "sha256-4cd9d4ba6b734d9b4cbd1e5caa60374c00722e993fce5e1e2d15a33698f71187"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
 		filepath.Join(p, "blobs", "sha256-e29a7b3c47287a2489c895d21fe413c20f859a85d20e749492f52a838e36e1ba"),
+		configBlob(t, "test2"),
 	})
 
 	actual, err := os.ReadFile(filepath.Join(p, "blobs", "sha256-e29a7b3c47287a2489c895d21fe413c20f859a85d20e749492f52a838e36e1ba"))
@@ -396,9 +488,9 @@ func TestCreateMergeParameters(t *testing.T) {
 	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
 		filepath.Join(p, "blobs", "sha256-12f58bb75cb3042d69a7e013ab87fb3c3c7088f50ddc62f0c77bd332f0d44d35"),
 		filepath.Join(p, "blobs", "sha256-1d0ad71299d48c2fb7ae2b98e683643e771f8a5b72be34942af90d97a91c1e37"),
-		filepath.Join(p, "blobs", "sha256-257aa726584f24970a4f240765e75a7169bfbe7f4966c1f04513d6b6c860583a"),
 		filepath.Join(p, "blobs", "sha256-4a384beaf47a9cbe452dfa5ab70eea691790f3b35a832d12933a1996685bf2b6"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
+		configBlob(t, "test2"),
 	})
 
 	actual, err = os.ReadFile(filepath.Join(p, "blobs", "sha256-12f58bb75cb3042d69a7e013ab87fb3c3c7088f50ddc62f0c77bd332f0d44d35"))
@@ -490,10 +582,10 @@ func TestCreateReplacesMessages(t *testing.T) {
 	// Old layers will not have been pruned
 	checkFileExists(t, filepath.Join(p, "blobs", "*"), []string{
 		filepath.Join(p, "blobs", "sha256-298baeaf6928a60cf666d88d64a1ba606feb43a2865687c39e40652e407bffc4"),
+		configBlob(t, "test2"),
 		filepath.Join(p, "blobs", "sha256-a4e5e156ddec27e286f75328784d7106b60a4eb1d246e950a001a3f944fbda99"),
 		filepath.Join(p, "blobs", "sha256-a60ecc9da299ec7ede453f99236e5577fd125e143689b646d9f0ddc9971bf4db"),
 		filepath.Join(p, "blobs", "sha256-e0e27d47045063ccb167ae852c51d49a98eab33fabaee4633fdddf97213e40b5"),
-		filepath.Join(p, "blobs", "sha256-f4e2c3690efef1b4b63ba1e1b2744ffeb6a7438a0110b86596069f6d9999c80b"),
 	})
 
 	type message struct {
@@ -808,3 +900,117 @@ func TestDetectModelTypeFromFiles(t *testing.T) {
 		}
 	})
 }
+
+func TestCreateFromRemote(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+
+	tensors := map[string]struct {
+		Type    string  `json:"dtype"`
+		Shape   []int   `json:"shape"`
+		Offsets []int64 `json:"data_offsets"`
+	}{
+		"model.embed_tokens.weight": {"F32", []int{2, 8}, []int64{0, 64}},
+		"model.norm.weight":         {"F32", []int{8}, []int64{64, 96}},
+	}
+
+	header, err := json.Marshal(tensors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var safetensors bytes.Buffer
+	if err := binary.Write(&safetensors, binary.LittleEndian, int64(len(header))); err != nil {
+		t.Fatal(err)
+	}
+	safetensors.Write(header)
+	safetensors.Write(make([]byte, 96))
+
+	files := map[string][]byte{
+		"config.json":       []byte(`{"architectures": ["LlamaForCausalLM"], "vocab_size": 2, "hidden_size": 8, "num_hidden_layers": 1, "num_attention_heads": 2, "intermediate_size": 8}`),
+		"tokenizer.json":    []byte(`{"model": {"type": "BPE", "vocab": {"a": 0, "b": 1}}}`),
+		"model.safetensors": safetensors.Bytes(),
+	}
+
+	var ranges []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/models/org/model/revision/main", func(w http.ResponseWriter, r *http.Request) {
+		var siblings []map[string]any
+		for name, data := range files {
+			siblings = append(siblings, map[string]any{"rfilename": name, "size": len(data)})
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"sha": "0a1b2c3d", "siblings": siblings})
+	})
+	mux.HandleFunc("GET /org/model/resolve/0a1b2c3d/{name}", func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.PathValue("name")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.PathValue("name") == "model.safetensors" {
+			ranges = append(ranges, r.Header.Get("Range"))
+		}
+
+		http.ServeContent(w, r, r.PathValue("name"), time.Time{}, bytes.NewReader(data))
+	})
+
+	hub := httptest.NewServer(mux)
+	defer hub.Close()
+	t.Setenv("HF_ENDPOINT", hub.URL)
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   "test",
+		Remote: "org/model",
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	// the header is read first, then each tensor
+	if len(ranges) != 4 || !slices.Equal([]string{"bytes=0-7", fmt.Sprintf("bytes=8-%d", 7+len(header))}, ranges[:2]) {
+		t.Errorf("expected range requests for the header and each tensor, got %v", ranges)
+	}
+
+	m, err := GetModel("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(m.ModelPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	g, _, err := ggml.Decode(f, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if arch := g.KV().Architecture(); arch != "llama" {
+		t.Errorf("expected architecture llama, got %s", arch)
+	}
+
+	if n := len(g.Tensors().Items()); n != 2 {
+		t.Errorf("expected 2 tensors, got %d", n)
+	}
+
+	want := api.Provenance{SourceURL: hub.URL + "/org/model/tree/0a1b2c3d", Converter: "ollama " + version.Version}
+	if m.Config.Provenance == nil || *m.Config.Provenance != want {
+		t.Errorf("expected provenance %+v, got %+v", want, m.Config.Provenance)
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:   "test",
+		Remote: "org/missing",
+		Stream: &stream,
+	})
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status code 404, actual %d: %s", w.Code, w.Body.String())
+	}
+}
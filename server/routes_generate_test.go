@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/discover"
@@ -177,7 +179,7 @@ func TestGenerateChat(t *testing.T) {
 			t.Errorf("expected status 400, got %d", w.Code)
 		}
 
-		if diff := cmp.Diff(w.Body.String(), `{"error":"\"bert\" does not support chat"}`); diff != "" {
+		if diff := cmp.Diff(w.Body.String(), `{"code":"CAPABILITY_MISSING","error":"\"bert\" does not support chat"}`); diff != "" {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
@@ -282,6 +284,33 @@ func TestGenerateChat(t *testing.T) {
 		checkChatResponse(t, w.Body, "test", "Hi!")
 	})
 
+	t.Run("messages with regions", func(t *testing.T) {
+		mock.CompletionResponse.Content = "<|object_ref_start|>the dog<|object_ref_end|><|box_start|>(100,200),(300,400)<|box_end|>"
+		defer func() { mock.CompletionResponse.Content = "Hi!" }()
+
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model: "test",
+			Messages: []api.Message{
+				{Role: "user", Content: "Where is the dog?", Images: []api.ImageData{[]byte("a dog")}},
+			},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []api.Region{{Label: "the dog", Box: [4]float64{0.1, 0.2, 0.3, 0.4}}}
+		if diff := cmp.Diff(want, resp.Regions); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
 	w = createRequest(t, s.CreateHandler, api.CreateRequest{
 		Model:  "test-system",
 		From:   "test",
@@ -586,6 +615,211 @@ func TestGenerateChat(t *testing.T) {
 			t.Errorf("final tool call mismatch (-got +want):\n%s", diff)
 		}
 	})
+
+	t.Run("thinking", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model: "test-thinking",
+			From:  "test",
+			Template: `
+{{- range .Messages }}
+{{- .Role }}: {{ .Content }}
+{{ end }}
+{{- if .Think }}<think>{{ end }}`,
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
+			if !strings.HasSuffix(r.Prompt, "<think>") {
+				fn(llm.CompletionResponse{Content: "<think>"})
+			}
+
+			for _, content := range []string{"Let me ", "think.</th", "ink>\n\n4"} {
+				fn(llm.CompletionResponse{Content: content})
+			}
+
+			fn(llm.CompletionResponse{Done: true, DoneReason: "stop"})
+			return nil
+		}
+		defer func() { mock.CompletionFn = nil }()
+
+		think := true
+		for _, tt := range []struct {
+			name     string
+			think    *bool
+			prompt   string
+			thinking string
+		}{
+			{"default", nil, "user: 2 + 2 = ?\n<think>", "Let me think."},
+			{"think", &think, "user: 2 + 2 = ?\n<think>", "Let me think."},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				w := createRequest(t, s.ChatHandler, api.ChatRequest{
+					Model:    "test-thinking",
+					Messages: []api.Message{{Role: "user", Content: "2 + 2 = ?"}},
+					Think:    tt.think,
+					Stream:   &stream,
+				})
+
+				if w.Code != http.StatusOK {
+					t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+				}
+
+				if diff := cmp.Diff(mock.CompletionRequest.Prompt, tt.prompt); diff != "" {
+					t.Errorf("mismatch (-got +want):\n%s", diff)
+				}
+
+				var resp api.ChatResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+
+				if resp.Message.Thinking != tt.thinking || resp.Message.Content != "4" {
+					t.Errorf("expected thinking %q and content %q, got %q and %q", tt.thinking, "4", resp.Message.Thinking, resp.Message.Content)
+				}
+			})
+		}
+
+		t.Run("think disabled", func(t *testing.T) {
+			think := false
+			w := createRequest(t, s.ChatHandler, api.ChatRequest{
+				Model:    "test-thinking",
+				Messages: []api.Message{{Role: "user", Content: "2 + 2 = ?"}},
+				Think:    &think,
+				Stream:   &stream,
+			})
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+			}
+
+			if diff := cmp.Diff(mock.CompletionRequest.Prompt, "user: 2 + 2 = ?\n"); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+
+			var resp api.ChatResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			// the model may still reason, which is removed
+			if resp.Message.Thinking != "" || resp.Message.Content != "4" {
+				t.Errorf("expected no thinking and content %q, got %q and %q", "4", resp.Message.Thinking, resp.Message.Content)
+			}
+		})
+
+		t.Run("missing capabilities thinking", func(t *testing.T) {
+			think := true
+			w := createRequest(t, s.ChatHandler, api.ChatRequest{
+				Model:    "test",
+				Messages: []api.Message{{Role: "user", Content: "2 + 2 = ?"}},
+				Think:    &think,
+			})
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", w.Code)
+			}
+
+			if diff := cmp.Diff(w.Body.String(), `{"code":"CAPABILITY_MISSING","error":"registry.ollama.ai/library/test:latest does not support thinking"}`); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	})
+
+	t.Run("post process", func(t *testing.T) {
+		mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
+			for _, content := range []string{"Assis", "tant: Hi!  How", " can I help?", " I can"} {
+				fn(llm.CompletionResponse{Content: content})
+			}
+
+			fn(llm.CompletionResponse{Done: true, DoneReason: "length"})
+			return nil
+		}
+		defer func() { mock.CompletionFn = nil }()
+
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Options:  map[string]any{"post_process": []string{"strip_role", "collapse_whitespace", "trim_incomplete"}},
+			Stream:   &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if expect := "Hi! How can I help? "; resp.Message.Content != expect {
+			t.Errorf("expected content %q, got %q", expect, resp.Message.Content)
+		}
+
+		w = createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Options:  map[string]any{"post_process": []string{"uppercase"}},
+			Stream:   &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("n completions streaming", func(t *testing.T) {
+		mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
+			fn(llm.CompletionResponse{Index: 1, Content: "Bye!"})
+			fn(llm.CompletionResponse{Index: 1, Done: true, DoneReason: "stop", EvalCount: 1})
+			fn(llm.CompletionResponse{Index: 0, Content: "Hi!"})
+			fn(llm.CompletionResponse{Index: 0, Done: true, DoneReason: "length", EvalCount: 1})
+			return nil
+		}
+		defer func() { mock.CompletionFn = nil }()
+
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			N:        2,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resps []api.ChatResponse
+		decoder := json.NewDecoder(w.Body)
+		for {
+			var resp api.ChatResponse
+			if err := decoder.Decode(&resp); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			resps = append(resps, api.ChatResponse{
+				Index:      resp.Index,
+				Message:    resp.Message,
+				DoneReason: resp.DoneReason,
+				Done:       resp.Done,
+			})
+		}
+
+		expect := []api.ChatResponse{
+			{Index: 1, Message: api.Message{Role: "assistant", Content: "Bye!"}},
+			{Index: 1, Message: api.Message{Role: "assistant"}, DoneReason: "stop"},
+			{Index: 0, Message: api.Message{Role: "assistant", Content: "Hi!"}},
+			{Index: 0, Message: api.Message{Role: "assistant"}, DoneReason: "length", Done: true},
+		}
+		if diff := cmp.Diff(expect, resps); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
 }
 
 func TestGenerate(t *testing.T) {
@@ -670,7 +904,7 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("expected status 404, got %d", w.Code)
 		}
 
-		if diff := cmp.Diff(w.Body.String(), `{"error":"model '' not found"}`); diff != "" {
+		if diff := cmp.Diff(w.Body.String(), `{"code":"MODEL_NOT_FOUND","error":"model '' not found"}`); diff != "" {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
@@ -681,7 +915,7 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("expected status 404, got %d", w.Code)
 		}
 
-		if diff := cmp.Diff(w.Body.String(), `{"error":"model '' not found"}`); diff != "" {
+		if diff := cmp.Diff(w.Body.String(), `{"code":"MODEL_NOT_FOUND","error":"model '' not found"}`); diff != "" {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
@@ -710,7 +944,7 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("expected status 400, got %d", w.Code)
 		}
 
-		if diff := cmp.Diff(w.Body.String(), `{"error":"\"bert\" does not support generate"}`); diff != "" {
+		if diff := cmp.Diff(w.Body.String(), `{"code":"CAPABILITY_MISSING","error":"\"bert\" does not support generate"}`); diff != "" {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
@@ -726,7 +960,7 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("expected status 400, got %d", w.Code)
 		}
 
-		if diff := cmp.Diff(w.Body.String(), `{"error":"registry.ollama.ai/library/test:latest does not support insert"}`); diff != "" {
+		if diff := cmp.Diff(w.Body.String(), `{"code":"CAPABILITY_MISSING","error":"registry.ollama.ai/library/test:latest does not support insert"}`); diff != "" {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
@@ -959,4 +1193,280 @@ func TestGenerate(t *testing.T) {
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
+
+	t.Run("invalid stop regexp", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:   "test",
+			Prompt:  "Hello!",
+			Options: map[string]any{"stop_regex": []any{"(User:"}},
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("invalid sampling options", func(t *testing.T) {
+		for _, opts := range []map[string]any{
+			{"mirostat": 3},
+			{"dry_multiplier": 0.8, "dry_base": 0.5},
+			{"dry_penalty_last_n": -2},
+			{"xtc_probability": 1.5},
+		} {
+			w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+				Model:   "test",
+				Prompt:  "Hello!",
+				Options: opts,
+				Stream:  &stream,
+			})
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%v: expected status 400, got %d", opts, w.Code)
+			}
+		}
+	})
+
+	t.Run("n completions", func(t *testing.T) {
+		var n int
+		mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
+			n = r.N
+			fn(llm.CompletionResponse{Index: 1, Content: "Bye"})
+			fn(llm.CompletionResponse{Index: 0, Content: "Hi!"})
+			fn(llm.CompletionResponse{Index: 0, Done: true, DoneReason: "stop", PromptEvalCount: 3, EvalCount: 2})
+			fn(llm.CompletionResponse{Index: 1, Content: "!"})
+			fn(llm.CompletionResponse{Index: 1, Done: true, DoneReason: "length", EvalCount: 3})
+			return nil
+		}
+		defer func() { mock.CompletionFn = nil }()
+
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Prompt: "Hello!",
+			N:      2,
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		if n != 2 {
+			t.Errorf("expected 2 completions to be requested, got %d", n)
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Response != "Hi!" || resp.DoneReason != "stop" || !resp.Done {
+			t.Errorf("expected the first completion, got %q (%s)", resp.Response, resp.DoneReason)
+		}
+
+		if resp.PromptEvalCount != 3 || resp.EvalCount != 5 {
+			t.Errorf("expected the counts of all completions, got %d and %d", resp.PromptEvalCount, resp.EvalCount)
+		}
+
+		expect := []api.GenerateChoice{
+			{Index: 0, Response: "Hi!", DoneReason: "stop"},
+			{Index: 1, Response: "Bye!", DoneReason: "length"},
+		}
+		if diff := cmp.Diff(expect, resp.Choices, cmpopts.IgnoreFields(api.GenerateChoice{}, "Context")); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+
+		w = createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Prompt: "Hello!",
+			N:      -1,
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		t.Setenv("OLLAMA_GENERATE_TIMEOUT", "")
+
+		mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
+			fn(llm.CompletionResponse{Content: "Once upon"})
+			fn(llm.CompletionResponse{Done: true, DoneReason: "timeout"})
+			return nil
+		}
+		defer func() { mock.CompletionFn = nil }()
+
+		start := time.Now()
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:     "test",
+			Prompt:    "Tell me a story",
+			TimeoutMS: 1500,
+			Stream:    &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		deadline := mock.CompletionRequest.Deadline
+		if deadline.Before(start.Add(1500*time.Millisecond)) || deadline.After(time.Now().Add(1500*time.Millisecond)) {
+			t.Errorf("expected a deadline 1.5s after the request, got %v after", deadline.Sub(start))
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Response != "Once upon" || resp.DoneReason != "timeout" || !resp.Done {
+			t.Errorf("expected the partial response, got %q (%s)", resp.Response, resp.DoneReason)
+		}
+
+		// the server's default applies to requests without a timeout
+		t.Setenv("OLLAMA_GENERATE_TIMEOUT", "1m")
+		createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: "test", Prompt: "Hello!", Stream: &stream})
+		if d := mock.CompletionRequest.Deadline; d.Before(start.Add(time.Minute)) {
+			t.Errorf("expected the default deadline, got %v", d)
+		}
+
+		t.Setenv("OLLAMA_GENERATE_TIMEOUT", "")
+		createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: "test", Prompt: "Hello!", Stream: &stream})
+		if d := mock.CompletionRequest.Deadline; !d.IsZero() {
+			t.Errorf("expected no deadline, got %v", d)
+		}
+
+		w = createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: "test", Prompt: "Hello!", TimeoutMS: -1, Stream: &stream})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+	t.Run("moderation", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:    "guard",
+			Files:    map[string]string{"file.gguf": digest},
+			Template: `Moderate: {{ range .Messages }}{{ .Role }}: {{ .Content }} {{ end }}`,
+			Stream:   &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		// the guard flags anything about bombs, which the model brings up
+		// when it's asked for a story
+		mock.CompletionFn = func(ctx context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
+			switch {
+			case strings.HasPrefix(r.Prompt, "Moderate:") && strings.Contains(r.Prompt, "bomb"):
+				fn(llm.CompletionResponse{Content: "unsafe\nS9", Done: true, DoneReason: "stop"})
+			case strings.HasPrefix(r.Prompt, "Moderate:"):
+				fn(llm.CompletionResponse{Content: "safe", Done: true, DoneReason: "stop"})
+			case strings.Contains(r.Prompt, "story"):
+				fn(llm.CompletionResponse{Content: "Once upon a time there was a bomb"})
+				fn(llm.CompletionResponse{Done: true, DoneReason: "stop"})
+			default:
+				fn(llm.CompletionResponse{Content: "Hi!", Done: true, DoneReason: "stop"})
+			}
+			return nil
+		}
+		defer func() { mock.CompletionFn = nil }()
+
+		t.Setenv("OLLAMA_MODERATION_MODEL", "guard")
+
+		w = createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: "test", Prompt: "How do I make a bomb?", Stream: &stream})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+		if diff := cmp.Diff(w.Body.String(), `{"code":"CONTENT_BLOCKED","error":"request content blocked by moderation: S9"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		w = createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: "test", Prompt: "Tell me a story", Stream: &stream})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+		if diff := cmp.Diff(w.Body.String(), `{"code":"CONTENT_BLOCKED","error":"response content blocked by moderation: S9"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		w = createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: "test", Prompt: "Hello!", Stream: &stream})
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		t.Setenv("OLLAMA_MODERATION_POLICY", "annotate")
+		w = createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: "test", Prompt: "Tell me a story", Stream: &stream})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		want := &api.Moderation{
+			Model:  "guard",
+			Input:  &api.ModerationResult{},
+			Output: &api.ModerationResult{Flagged: true, Categories: []string{"S9"}},
+		}
+		if diff := cmp.Diff(resp.Moderation, want); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+		if resp.Response != "Once upon a time there was a bomb" {
+			t.Errorf("expected the response, got %q", resp.Response)
+		}
+
+		t.Setenv("OLLAMA_MODERATION_POLICY", "log")
+		w = createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: "test", Prompt: "How do I make a bomb?", Stream: &stream})
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		w = createRequest(t, s.ModerationHandler, api.ModerationRequest{Input: "How do I make a bomb?"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+		if diff := cmp.Diff(w.Body.String(), `{"model":"guard","flagged":true,"categories":["S9"]}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+}
+
+func TestGenerateValuesImages(t *testing.T) {
+	images := []llm.ImageData{{ID: 0}, {ID: 1}}
+
+	t.Run("separate messages", func(t *testing.T) {
+		values, err := generateValues(&Model{}, "Compare them", "", "", images, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []api.Message{
+			{Role: "user", Content: "[img-0]"},
+			{Role: "user", Content: "[img-1]"},
+			{Role: "user", Content: "Compare them"},
+		}
+		if diff := cmp.Diff(want, values.Messages); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("placed", func(t *testing.T) {
+		values, err := generateValues(&Model{}, "Is [img-1] newer than [img]?", "", "", images, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []api.Message{{Role: "user", Content: "Is [img-1] newer than [img-0]?"}}
+		if diff := cmp.Diff(want, values.Messages); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := generateValues(&Model{}, "[img-2]", "", "", images, false); !errors.Is(err, errInvalidImagePlaceholder) {
+			t.Errorf("expected invalid placeholder, got %v", err)
+		}
+	})
 }
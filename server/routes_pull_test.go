@@ -0,0 +1,31 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestPullInvalidMaxRate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var s Server
+	w := createRequest(t, s.PullHandler, api.PullRequest{Model: "test", MaxRate: "fast"})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected status code 400, actual %d", w.Code)
+	}
+
+	var resp map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(resp["error"], "invalid max_rate") {
+		t.Errorf("unexpected error %q", resp["error"])
+	}
+}
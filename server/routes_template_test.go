@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+
+	chatml, err := os.ReadFile("../template/chatml.gotmpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, digest := createBinFile(t, ggml.KV{"general.architecture": "llama"}, nil)
+	for _, req := range []api.CreateRequest{
+		{Name: "chatml", Files: map[string]string{"test.gguf": digest}, Template: string(chatml), System: "You are a helpful assistant."},
+		{
+			Name:       "custom",
+			Files:      map[string]string{"test.gguf": digest},
+			Template:   "{{- if .Suffix }}<PRE> {{ .Prompt }} <SUF>{{ .Suffix }} <MID>{{ else }}{{ if .System }}[SYS] {{ .System }} {{ end }}[USER] {{ .Prompt }} [BOT]{{ end }}",
+			Parameters: map[string]any{"stop": []string{"[USER]"}},
+		},
+	} {
+		req.Stream = &stream
+		if w := createRequest(t, s.CreateHandler, req); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+		}
+	}
+
+	cases := []struct {
+		name   string
+		req    api.RenderTemplateRequest
+		expect api.RenderTemplateResponse
+	}{
+		{
+			name: "messages",
+			req: api.RenderTemplateRequest{
+				Model: "chatml",
+				Messages: []api.Message{
+					{Role: "user", Content: "Hello!"},
+					{Role: "assistant", Content: "Hi!"},
+					{Role: "user", Content: "How are you?"},
+				},
+			},
+			expect: api.RenderTemplateResponse{
+				Prompt: "<|im_start|>system\nYou are a helpful assistant.<|im_end|>\n<|im_start|>user\nHello!<|im_end|>\n<|im_start|>assistant\nHi!<|im_end|>\n<|im_start|>user\nHow are you?<|im_end|>\n<|im_start|>assistant\n",
+				Stop:   []string{"<|im_start|>", "<|im_end|>"},
+			},
+		},
+		{
+			name: "system message",
+			req: api.RenderTemplateRequest{
+				Model:    "chatml",
+				Messages: []api.Message{{Role: "system", Content: "Be brief."}, {Role: "user", Content: "Hello!"}},
+			},
+			expect: api.RenderTemplateResponse{
+				Prompt: "<|im_start|>system\nBe brief.<|im_end|>\n<|im_start|>user\nHello!<|im_end|>\n<|im_start|>assistant\n",
+				Stop:   []string{"<|im_start|>", "<|im_end|>"},
+			},
+		},
+		{
+			name: "prompt",
+			req:  api.RenderTemplateRequest{Model: "custom", Prompt: "Hello!", System: "Be brief."},
+			expect: api.RenderTemplateResponse{
+				Prompt: "[SYS] Be brief. [USER] Hello! [BOT]",
+				Stop:   []string{"[USER]"},
+			},
+		},
+		{
+			name: "suffix",
+			req:  api.RenderTemplateRequest{Model: "custom", Prompt: "def add(", Suffix: "return c"},
+			expect: api.RenderTemplateResponse{
+				Prompt: "<PRE> def add( <SUF>return c <MID>",
+				Stop:   []string{"[USER]"},
+			},
+		},
+		{
+			name: "template override",
+			req:  api.RenderTemplateRequest{Model: "custom", Prompt: "Hello!", Template: string(chatml)},
+			expect: api.RenderTemplateResponse{
+				Prompt: "<|im_start|>user\nHello!<|im_end|>\n<|im_start|>assistant\n",
+				Stop:   []string{"[USER]"},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.RenderTemplateHandler, tt.req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+			}
+
+			var resp api.RenderTemplateResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.expect, resp); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("invalid template", func(t *testing.T) {
+		w := createRequest(t, s.RenderTemplateHandler, api.RenderTemplateRequest{Model: "custom", Template: "{{ .Prompt "})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code 400, actual %d: %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("missing model", func(t *testing.T) {
+		w := createRequest(t, s.RenderTemplateHandler, api.RenderTemplateRequest{Model: "missing"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status code 404, actual %d: %s", w.Code, w.Body)
+		}
+	})
+}
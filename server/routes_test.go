@@ -20,6 +20,8 @@ import (
 	"testing"
 	"unicode"
 
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/fs/ggml"
 	"github.com/ollama/ollama/openai"
@@ -135,7 +137,7 @@ func TestRoutes(t *testing.T) {
 			t.Fatalf("failed to create model: %v", err)
 		}
 
-		if err := createModel(r, modelName, baseLayers, fn); err != nil {
+		if err := createModel(t.Context(), r, modelName, baseLayers, api.Provenance{}, fn); err != nil {
 			t.Fatal(err)
 		}
 	}
@@ -712,6 +714,87 @@ func TestShow(t *testing.T) {
 	}
 }
 
+func TestShowOffload(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	s := Server{sched: &Scheduler{loaded: make(map[string]*runnerRef)}}
+
+	_, digest := createBinFile(t, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             uint32(2),
+		"llama.embedding_length":        uint32(8),
+		"llama.attention.head_count":    uint32(2),
+		"llama.attention.head_count_kv": uint32(2),
+		"tokenizer.ggml.tokens":         []string{" "},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, []ggml.Tensor{
+		{Name: "blk.0.attn.weight", Kind: uint32(0), Offset: uint64(0), Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "blk.1.attn.weight", Kind: uint32(0), Offset: uint64(4), Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	})
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:  "show-offload",
+		Files: map[string]string{"model.gguf": digest},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	show := func(options map[string]any) (int, api.ShowResponse) {
+		t.Helper()
+		w := createRequest(t, s.ShowHandler, api.ShowRequest{Model: "show-offload", Options: options})
+
+		var resp api.ShowResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return w.Code, resp
+	}
+
+	t.Run("projected", func(t *testing.T) {
+		code, resp := show(map[string]any{"offload": "kv_cache=cpu"})
+		if code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", code)
+		}
+
+		if resp.Offload == nil || resp.Offload.Blocks != 2 || resp.Offload.KVCache != "cpu" {
+			t.Fatalf("expected a plan for 2 blocks with the cache in system memory, got %+v", resp.Offload)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, offload := range []string{"blocks=fast", "blocks=0-2"} {
+			if code, _ := show(map[string]any{"offload": offload}); code != http.StatusBadRequest {
+				t.Errorf("%s: expected status code 400, actual %d", offload, code)
+			}
+		}
+
+		if code, _ := show(map[string]any{"weight_streaming": true, "use_mmap": true}); code != http.StatusBadRequest {
+			t.Errorf("weight streaming with mmap: expected status code 400, actual %d", code)
+		}
+	})
+
+	t.Run("loaded", func(t *testing.T) {
+		m, err := GetModel("show-offload")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		plan := api.OffloadPlan{Library: "cuda", Blocks: 2, GPUBlocks: []int{1}, Embeddings: "cpu", Output: "cpu", KVCache: "gpu"}
+		s.sched.loaded[m.ModelPath] = &runnerRef{offload: plan}
+		t.Cleanup(func() { delete(s.sched.loaded, m.ModelPath) })
+
+		_, resp := show(nil)
+		if diff := cmp.Diff(&plan, resp.Offload); diff != "" {
+			t.Errorf("plan mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
 func TestNormalize(t *testing.T) {
 	type testCase struct {
 		input []float32
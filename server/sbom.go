@@ -0,0 +1,236 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/errtypes"
+	"github.com/ollama/ollama/types/model"
+	"github.com/ollama/ollama/version"
+)
+
+// SBOMHandler returns a CycloneDX bill of materials of a model.
+func (s *Server) SBOMHandler(c *gin.Context) {
+	var req api.SBOMRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := model.ParseName(req.Model)
+	if !name.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, errorResponse(api.ErrorCodeInvalidModelName, errtypes.InvalidModelNameErrMsg))
+		return
+	}
+
+	name, err := getExistingName(name)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	name, _, err = resolveAlias(name)
+	if err != nil {
+		c.AbortWithStatusJSON(aliasErrorResponse(req.Model, err))
+		return
+	}
+
+	sbom, err := modelSBOM(name)
+	if errors.Is(err, os.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", req.Model)))
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, sbom)
+}
+
+// modelSBOM returns a bill of materials of the model name: its layers, its
+// licenses and what it was created from.
+func modelSBOM(name model.Name) (*api.SBOM, error) {
+	manifest, err := ParseNamedManifest(name)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := GetModel(name.String())
+	if err != nil {
+		return nil, err
+	}
+
+	root := sbomModel(name, manifest.digest, m.Config.Provenance)
+	root.BOMRef = name.String()
+	root.Properties = append([]api.SBOMProperty{
+		{Name: "ollama:family", Value: m.Config.ModelFamily},
+		{Name: "ollama:parameter_size", Value: m.Config.ModelType},
+		{Name: "ollama:quantization_level", Value: m.Config.FileType},
+	}, root.Properties...)
+
+	for _, l := range m.License {
+		root.Licenses = append(root.Licenses, sbomLicense(l))
+	}
+
+	layers := manifest.Layers
+	if manifest.Config.Digest != "" {
+		layers = append(layers, manifest.Config)
+	}
+
+	var components []api.SBOMComponent
+	dependency := api.SBOMDependency{Ref: root.BOMRef}
+	seen := make(map[string]bool)
+	for _, layer := range layers {
+		if seen[layer.Digest] {
+			continue
+		}
+		seen[layer.Digest] = true
+
+		kind := strings.TrimPrefix(layer.MediaType, "application/vnd.ollama.image.")
+		if layer.Digest == manifest.Config.Digest {
+			kind = "config"
+		}
+
+		components = append(components, api.SBOMComponent{
+			BOMRef:     layer.Digest,
+			Type:       "file",
+			MimeType:   layer.MediaType,
+			Name:       kind,
+			Hashes:     sbomHashes(layer.Digest),
+			Properties: []api.SBOMProperty{{Name: "ollama:size", Value: strconv.FormatInt(layer.Size, 10)}},
+		})
+		dependency.DependsOn = append(dependency.DependsOn, layer.Digest)
+	}
+
+	return &api.SBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.6",
+		SerialNumber: "urn:uuid:" + uuid.NewString(),
+		Version:      1,
+		Metadata: api.SBOMMetadata{
+			Timestamp: time.Now().UTC(),
+			Tools: api.SBOMTools{Components: []api.SBOMComponent{
+				{Type: "application", Name: "ollama", Version: version.Version},
+			}},
+			Component: &root,
+		},
+		Components:   components,
+		Dependencies: []api.SBOMDependency{dependency},
+	}, nil
+}
+
+// sbomModel returns the component of the model name, whose manifest has the
+// digest, with the models and repositories it was created from as its
+// ancestors.
+func sbomModel(name model.Name, digest string, p *api.Provenance) api.SBOMComponent {
+	c := api.SBOMComponent{
+		Type:    "machine-learning-model",
+		Group:   name.Host + "/" + name.Namespace,
+		Name:    name.Model,
+		Version: name.Tag,
+		Hashes:  sbomHashes(digest),
+	}
+
+	if p == nil {
+		return c
+	}
+
+	if p.ModelfileDigest != "" {
+		c.Properties = append(c.Properties, api.SBOMProperty{Name: "ollama:modelfile_digest", Value: p.ModelfileDigest})
+	}
+
+	if p.Converter != "" {
+		c.Properties = append(c.Properties, api.SBOMProperty{Name: "ollama:converter", Value: p.Converter})
+	}
+
+	if p.Quantization != nil {
+		c.Properties = append(c.Properties, api.SBOMProperty{Name: "ollama:quantization", Value: p.Quantization.From + " -> " + p.Quantization.To})
+	}
+
+	var ancestors []api.SBOMComponent
+	if p.Parent != nil {
+		ancestors = append(ancestors, sbomModel(model.ParseName(p.Parent.Model), p.Parent.Digest, p.Parent.Provenance))
+	}
+
+	if p.SourceURL != "" {
+		ancestors = append(ancestors, sbomSource(p.SourceURL))
+	}
+
+	if len(ancestors) > 0 {
+		c.Pedigree = &api.SBOMPedigree{Ancestors: ancestors}
+	}
+
+	return c
+}
+
+// sbomSource returns the component of the repository at rawURL which the
+// weights of a model were converted from. Hugging Face repositories are
+// identified by their package URL.
+func sbomSource(rawURL string) api.SBOMComponent {
+	c := api.SBOMComponent{
+		Type:               "machine-learning-model",
+		Name:               rawURL,
+		ExternalReferences: []api.SBOMExternalReference{{Type: "vcs", URL: rawURL}},
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return c
+	}
+
+	if repo, commit, ok := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/tree/"); ok {
+		c.Name, c.Version = repo, commit
+		if u.Host == "huggingface.co" {
+			c.PURL = "pkg:huggingface/" + repo + "@" + commit
+		}
+	}
+
+	return c
+}
+
+func sbomHashes(digest string) []api.SBOMHash {
+	if digest == "" {
+		return nil
+	}
+
+	return []api.SBOMHash{{Alg: "SHA-256", Content: strings.TrimPrefix(digest, "sha256:")}}
+}
+
+// sbomLicense returns the license with the text. Well known licenses are
+// identified by their SPDX ID, and others are named by their first line.
+func sbomLicense(text string) api.SBOMLicense {
+	var first string
+	for line := range strings.Lines(text) {
+		if first = strings.TrimSpace(line); first != "" {
+			break
+		}
+	}
+
+	info := api.SBOMLicenseInfo{Text: &api.SBOMAttachment{ContentType: "text/plain", Content: text}}
+	switch {
+	case strings.HasPrefix(first, "Apache License") && strings.Contains(text, "Version 2.0"):
+		info.ID = "Apache-2.0"
+	case first == "MIT License":
+		info.ID = "MIT"
+	case first == "":
+		info.Name = "unknown"
+	default:
+		info.Name = first
+	}
+
+	return api.SBOMLicense{License: info}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestSBOMHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+
+	_, digest := createBinFile(t, nil, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:           "base",
+		Files:           map[string]string{"test.gguf": digest},
+		License:         "MIT License\n\nCopyright (c) Ollama",
+		ModelfileDigest: "sha256:abc",
+		Stream:          &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	w = createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:   "test",
+		From:    "base",
+		License: "Acme Model License\n\nDon't.",
+		Stream:  &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	w = createRequest(t, s.SBOMHandler, api.SBOMRequest{Model: "test"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body.String())
+	}
+
+	var sbom api.SBOM
+	if err := json.NewDecoder(w.Body).Decode(&sbom); err != nil {
+		t.Fatal(err)
+	}
+
+	if sbom.BOMFormat != "CycloneDX" || sbom.SpecVersion != "1.6" || sbom.SerialNumber == "" {
+		t.Errorf("expected a CycloneDX document, got %s %s %s", sbom.BOMFormat, sbom.SpecVersion, sbom.SerialNumber)
+	}
+
+	manifest, err := ParseNamedManifest(model.ParseName("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent, err := ParseNamedManifest(model.ParseName("base"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := sbom.Metadata.Component
+	if root == nil {
+		t.Fatal("expected the model to be described")
+	}
+
+	if root.BOMRef != "registry.ollama.ai/library/test:latest" || root.Name != "test" || root.Hashes[0].Content != manifest.digest {
+		t.Errorf("expected the model test at %s, got %+v", manifest.digest, root)
+	}
+
+	var licenses []string
+	for _, l := range root.Licenses {
+		licenses = append(licenses, l.License.ID+l.License.Name)
+	}
+
+	// the license of the model from which test was created comes first
+	if diff := cmp.Diff(licenses, []string{"MIT", "Acme Model License"}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	want := []api.SBOMComponent{{
+		Type:       "machine-learning-model",
+		Group:      "registry.ollama.ai/library",
+		Name:       "base",
+		Version:    "latest",
+		Hashes:     []api.SBOMHash{{Alg: "SHA-256", Content: parent.digest}},
+		Properties: []api.SBOMProperty{{Name: "ollama:modelfile_digest", Value: "sha256:abc"}},
+	}}
+	if diff := cmp.Diff(root.Pedigree.Ancestors, want); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	var refs []string
+	for _, c := range sbom.Components {
+		refs = append(refs, c.BOMRef)
+		if len(c.Hashes) != 1 || "sha256:"+c.Hashes[0].Content != c.BOMRef {
+			t.Errorf("expected the digest of layer %s, got %+v", c.Name, c.Hashes)
+		}
+	}
+
+	if len(refs) != len(manifest.Layers)+1 {
+		t.Errorf("expected a component for each layer and the config, got %v", refs)
+	}
+
+	if diff := cmp.Diff(sbom.Dependencies, []api.SBOMDependency{{Ref: root.BOMRef, DependsOn: refs}}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	w = createRequest(t, s.SBOMHandler, api.SBOMRequest{Model: "missing"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status code 404, actual %d", w.Code)
+	}
+}
+
+func TestSBOMSource(t *testing.T) {
+	cases := []struct {
+		url  string
+		want api.SBOMComponent
+	}{
+		{
+			"https://huggingface.co/org/model/tree/0a1b2c3d",
+			api.SBOMComponent{
+				Type:               "machine-learning-model",
+				Name:               "org/model",
+				Version:            "0a1b2c3d",
+				PURL:               "pkg:huggingface/org/model@0a1b2c3d",
+				ExternalReferences: []api.SBOMExternalReference{{Type: "vcs", URL: "https://huggingface.co/org/model/tree/0a1b2c3d"}},
+			},
+		},
+		{
+			"https://hf-mirror.example.com/org/model/tree/main",
+			api.SBOMComponent{
+				Type:               "machine-learning-model",
+				Name:               "org/model",
+				Version:            "main",
+				ExternalReferences: []api.SBOMExternalReference{{Type: "vcs", URL: "https://hf-mirror.example.com/org/model/tree/main"}},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		if diff := cmp.Diff(sbomSource(tt.url), tt.want); diff != "" {
+			t.Errorf("%s mismatch (-got +want):\n%s", tt.url, diff)
+		}
+	}
+}
@@ -8,10 +8,12 @@ import (
 	"os"
 	"reflect"
 	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ollama/ollama/api"
@@ -31,6 +33,29 @@ type LlmRequest struct {
 	successCh       chan *runnerRef
 	errCh           chan error
 	schedAttempts   uint
+
+	// adapters were loaded by the runner this request replaces and are
+	// kept loaded along with the adapters of its model
+	adapters []string
+}
+
+// adapterPaths returns the paths of the adapters the runner of the request
+// loads, starting with those of its model. Adapters of other models sharing
+// the runner are kept up to the maximum number of loaded adapters.
+func (req *LlmRequest) adapterPaths() []string {
+	paths := slices.Clone(req.model.AdapterPaths)
+	limit := max(int(envconfig.MaxAdapters()), len(paths))
+	for _, p := range req.adapters {
+		if len(paths) >= limit {
+			break
+		}
+
+		if !slices.Contains(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+
+	return paths
 }
 
 type Scheduler struct {
@@ -47,6 +72,44 @@ type Scheduler struct {
 	getGpuFn     func() discover.GpuInfoList
 	getCpuFn     func() discover.GpuInfoList
 	reschedDelay time.Duration
+
+	// clock is the time the scheduler keeps, or the system's if it's nil
+	clock schedClock
+}
+
+// schedClock tells the time for the scheduler and starts its timers, such as
+// those which expire idle runners. Tests replace it to simulate time.
+type schedClock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) schedTimer
+}
+
+// schedTimer is a timer started by a schedClock, like a [time.Timer].
+type schedTimer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) schedTimer { return time.AfterFunc(d, f) }
+
+func (s *Scheduler) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
+
+// afterFunc calls f in its own goroutine after d has passed on the
+// scheduler's clock.
+func (s *Scheduler) afterFunc(d time.Duration, f func()) schedTimer {
+	if s.clock == nil {
+		return time.AfterFunc(d, f)
+	}
+	return s.clock.AfterFunc(d, f)
 }
 
 // Default automatic value for number of models we allow per GPU
@@ -73,6 +136,7 @@ func InitScheduler(ctx context.Context) *Scheduler {
 		getGpuFn:      discover.GetGPUInfo,
 		getCpuFn:      discover.GetCPUInfo,
 		reschedDelay:  250 * time.Millisecond,
+		clock:         systemClock{},
 	}
 	sched.loadFn = sched.load
 	return sched
@@ -131,9 +195,9 @@ func (s *Scheduler) processPending(ctx context.Context) {
 				continue
 			}
 			numParallel := int(envconfig.NumParallel())
-			// TODO (jmorganca): mllama doesn't support parallel yet
+			// TODO (jmorganca): mllama doesn't support parallel yet in the llama.cpp runner
 			// see https://github.com/ollama/ollama/issues/4165
-			if checkMllamaModelFamily(pending.model) && numParallel != 1 {
+			if checkMllamaModelFamily(pending.model) && !envconfig.NewEngine() && numParallel != 1 {
 				numParallel = 1
 				slog.Warn("mllama doesn't support parallel requests yet")
 			}
@@ -147,6 +211,7 @@ func (s *Scheduler) processPending(ctx context.Context) {
 				if runner != nil {
 					if runner.needsReload(ctx, pending) {
 						runnerToExpire = runner
+						pending.adapters = runner.adapters
 					} else {
 						// Runner is usable, return it
 						pending.useLoadedRunner(runner, s.finishedReqCh)
@@ -223,6 +288,7 @@ func (s *Scheduler) processPending(ctx context.Context) {
 					} else if loadedCount == 0 {
 						// No models loaded. Load the model but prefer the best fit.
 						slog.Debug("loading first model", "model", pending.model.ModelPath)
+						gpus = profiledGPUs(pending.model, gpus)
 						g := pickBestFullFitByLibrary(pending, ggml, gpus, &numParallel)
 						if g != nil {
 							gpus = g
@@ -245,6 +311,7 @@ func (s *Scheduler) processPending(ctx context.Context) {
 
 						// Update free memory from currently loaded models
 						s.updateFreeSpace(availGpus)
+						availGpus = profiledGPUs(pending.model, availGpus)
 						fitGpus := pickBestFullFitByLibrary(pending, ggml, availGpus, &numParallel)
 						if fitGpus != nil {
 							slog.Debug("new model fits with existing models, loading")
@@ -261,13 +328,12 @@ func (s *Scheduler) processPending(ctx context.Context) {
 							// needs more time, so put it on the back of the
 							// queue so that we might satisfy other pending
 							// requests that aren't blocked
-							go func() {
-								// Process in a go routine to avoid deadlocking
-								// the scheduler if our queue is full
-								slog.Debug("delaying scheduling while other models finish loading", "attempts", pending.schedAttempts, "model", pending.model.ModelPath)
-								time.Sleep(s.reschedDelay)
+							// Requeue in its own go routine to avoid deadlocking
+							// the scheduler if our queue is full
+							slog.Debug("delaying scheduling while other models finish loading", "attempts", pending.schedAttempts, "model", pending.model.ModelPath)
+							s.afterFunc(s.reschedDelay, func() {
 								s.pendingReqCh <- pending
-							}()
+							})
 							break
 						}
 						runnerToExpire = s.findRunnerToUnload()
@@ -338,7 +404,7 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 					s.expiredCh <- runner
 				} else if runner.expireTimer == nil {
 					slog.Debug("runner with non-zero duration has gone idle, adding timer", "modelPath", runner.modelPath, "duration", runner.sessionDuration)
-					runner.expireTimer = time.AfterFunc(runner.sessionDuration, func() {
+					runner.expireTimer = s.afterFunc(runner.sessionDuration, func() {
 						slog.Debug("timer expired, expiring to unload", "modelPath", runner.modelPath)
 						runner.refMu.Lock()
 						defer runner.refMu.Unlock()
@@ -348,11 +414,11 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 						}
 						s.expiredCh <- runner
 					})
-					runner.expiresAt = time.Now().Add(runner.sessionDuration)
+					runner.expiresAt = s.now().Add(runner.sessionDuration)
 				} else {
 					slog.Debug("runner with non-zero duration has gone idle, resetting timer", "modelPath", runner.modelPath, "duration", runner.sessionDuration)
 					runner.expireTimer.Reset(runner.sessionDuration)
-					runner.expiresAt = time.Now().Add(runner.sessionDuration)
+					runner.expiresAt = s.now().Add(runner.sessionDuration)
 				}
 			}
 			slog.Debug("after processing request finished event", "modelPath", runner.modelPath, "refCount", runner.refCount)
@@ -362,19 +428,21 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 			runner.refMu.Lock()
 			if runner.refCount > 0 {
 				slog.Debug("expired event with positive ref count, retrying", "modelPath", runner.modelPath, "refCount", runner.refCount)
-				go func(runner *runnerRef) {
-					// We can't unload yet, but want to as soon as the current request completes
-					// So queue up another expired event
-					time.Sleep(10 * time.Millisecond)
+				// We can't unload yet, but want to as soon as the current request completes
+				// So queue up another expired event
+				s.afterFunc(10*time.Millisecond, func() {
 					s.expiredCh <- runner
-				}(runner)
+				})
 				runner.refMu.Unlock()
 				continue
 			}
 
 			s.loadedMu.Lock()
 			slog.Debug("got lock to unload", "modelPath", runner.modelPath)
-			finished := runner.waitForVRAMRecovery()
+			finished := s.waitForVRAMRecovery(runner)
+			if runner.llama != nil && runner.model != nil {
+				emitWebhook(api.WebhookEvent{Event: api.WebhookRunnerUnloaded, Model: runner.model.ShortName})
+			}
 			runner.unload()
 			delete(s.loaded, runner.modelPath)
 			s.loadedMu.Unlock()
@@ -418,7 +486,17 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 	if req.sessionDuration != nil {
 		sessionDuration = req.sessionDuration.Duration
 	}
-	llama, err := s.newServerFn(gpus, req.model.ModelPath, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts, numParallel)
+	adapters := req.adapterPaths()
+
+	// The first time the model loads on these GPUs, measure how much VRAM
+	// it really takes from their free memory before and after
+	var before discover.GpuInfoList
+	calibrating := needsCalibration(req.model, gpus)
+	if calibrating {
+		before = s.getGpuFn()
+	}
+
+	llama, err := s.newServerFn(gpus, req.model.ModelPath, f, adapters, req.model.ProjectorPaths, req.opts, numParallel)
 	if err != nil {
 		// some older models are not compatible with newer versions of llama.cpp
 		// show a generalized compatibility error until there is a better way to
@@ -433,16 +511,21 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 	runner := &runnerRef{
 		model:           req.model,
 		modelPath:       req.model.ModelPath,
+		adapters:        adapters,
 		llama:           llama,
 		Options:         &req.opts,
 		sessionDuration: sessionDuration,
 		gpus:            gpus,
 		estimatedVRAM:   llama.EstimatedVRAM(),
 		estimatedTotal:  llama.EstimatedTotal(),
-		loading:         true,
+		flashAttention:  llama.FlashAttention(),
+		pagedAttention:  llama.PagedAttention(),
+		offload:         llama.Offload(),
 		refCount:        1,
 	}
+	runner.loading.Store(true)
 	runner.numParallel = numParallel
+	runner.vram = profiledVRAM(runner)
 	runner.refMu.Lock()
 
 	s.loadedMu.Lock()
@@ -454,6 +537,9 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 		defer runner.refMu.Unlock()
 		if err = llama.WaitUntilRunning(req.ctx); err != nil {
 			slog.Error("error loading llama server", "error", err)
+			if req.ctx.Err() == nil {
+				emitWebhook(api.WebhookEvent{Event: api.WebhookRunnerCrashed, Model: req.model.ShortName, Error: err.Error()})
+			}
 			runner.refCount--
 			req.errCh <- err
 			slog.Debug("triggering expiration for failed load", "model", runner.modelPath)
@@ -461,7 +547,11 @@ func (s *Scheduler) load(req *LlmRequest, f *ggml.GGML, gpus discover.GpuInfoLis
 			return
 		}
 		slog.Debug("finished setting up runner", "model", req.model.ModelPath)
-		runner.loading = false
+		if calibrating {
+			s.calibrate(runner, before)
+		}
+		runner.loading.Store(false)
+		emitWebhook(api.WebhookEvent{Event: api.WebhookRunnerLoaded, Model: req.model.ShortName})
 		go func() {
 			<-req.ctx.Done()
 			slog.Debug("context for request finished")
@@ -482,7 +572,11 @@ func (s *Scheduler) updateFreeSpace(allGpus discover.GpuInfoList) {
 		r.refMu.Lock()
 		if r.llama != nil {
 			for _, gpu := range allGpus {
-				predMap[predKey{gpu.Library, gpu.ID}] += r.llama.EstimatedVRAMByGPU(gpu.ID)
+				if vram, ok := r.vram[gpu.ID]; ok {
+					predMap[predKey{gpu.Library, gpu.ID}] += vram
+				} else {
+					predMap[predKey{gpu.Library, gpu.ID}] += r.llama.EstimatedVRAMByGPU(gpu.ID)
+				}
 			}
 		} else {
 			slog.Warn("unexpected nil runner reference, memory prediction may be incorrect")
@@ -519,7 +613,7 @@ func (s *Scheduler) filterGPUsWithoutLoadingModels(allGpus discover.GpuInfoList)
 	s.loadedMu.Lock()
 	defer s.loadedMu.Unlock()
 	for _, runner := range s.loaded {
-		if runner.loading {
+		if runner.loading.Load() {
 			slog.Debug("overlapping loads detected", "gpus", runner.gpus, "model", runner.modelPath)
 			for _, busyGPU := range runner.gpus {
 				for i := range ret {
@@ -542,19 +636,30 @@ type runnerRef struct {
 	// unloading bool      // set to true when we are trying to unload the runner
 
 	llama          llm.LlamaServer
-	loading        bool                 // True only during initial load, then false forever
+	loading        atomic.Bool          // True only during initial load, then false forever
 	gpus           discover.GpuInfoList // Recorded at time of provisioning
 	estimatedVRAM  uint64
 	estimatedTotal uint64
+	flashAttention bool
+	pagedAttention bool
+	offload        api.OffloadPlan // where the runner places the parts of the model
+
+	// vram is the VRAM the runner takes on each GPU by ID where it's known
+	// better than the estimate, from measuring it or from a profile
+	vram map[string]uint64
 
 	sessionDuration time.Duration
-	expireTimer     *time.Timer
+	expireTimer     schedTimer
 	expiresAt       time.Time
 
 	model       *Model
 	modelPath   string
 	numParallel int
 	*api.Options
+
+	// adapters are the paths of the LoRA adapters loaded by the runner.
+	// Requests apply the adapters of their model from among them.
+	adapters []string
 }
 
 // The refMu must already be held when calling unload
@@ -578,7 +683,7 @@ func (runner *runnerRef) needsReload(ctx context.Context, req *LlmRequest) bool
 	defer runner.refMu.Unlock()
 
 	timeout := 10 * time.Second
-	if runner.loading {
+	if runner.loading.Load() {
 		timeout = 2 * time.Minute // Initial load can take a long time for big models on slow systems...
 	}
 
@@ -599,10 +704,17 @@ func (runner *runnerRef) needsReload(ctx context.Context, req *LlmRequest) bool
 
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-	if !reflect.DeepEqual(runner.model.AdapterPaths, req.model.AdapterPaths) || // have the adapters changed?
+	if slices.ContainsFunc(req.model.AdapterPaths, func(p string) bool { return !slices.Contains(runner.adapters, p) }) || // are the adapters not loaded?
 		!reflect.DeepEqual(runner.model.ProjectorPaths, req.model.ProjectorPaths) || // have the projectors changed?
-		!reflect.DeepEqual(optsExisting, optsNew) || // have the runner options changed?
-		runner.llama.Ping(ctx) != nil {
+		!reflect.DeepEqual(optsExisting, optsNew) { // have the runner options changed?
+		return true
+	}
+
+	if err := runner.llama.Ping(ctx); err != nil {
+		// a runner which stops answering after it loaded has crashed
+		if !runner.loading.Load() {
+			emitWebhook(api.WebhookEvent{Event: api.WebhookRunnerCrashed, Model: runner.model.ShortName, Error: err.Error()})
+		}
 		return true
 	}
 
@@ -617,7 +729,7 @@ func (runner *runnerRef) needsReload(ctx context.Context, req *LlmRequest) bool
 // a before and after GPU memory allocation.  The returned channel
 // will be notified when we're done waiting, or have timed out and should
 // proceed anyway
-func (runner *runnerRef) waitForVRAMRecovery() chan interface{} {
+func (s *Scheduler) waitForVRAMRecovery(runner *runnerRef) chan interface{} {
 	finished := make(chan interface{}, 1)
 
 	// CPU or Metal don't need checking, so no waiting required
@@ -628,41 +740,41 @@ func (runner *runnerRef) waitForVRAMRecovery() chan interface{} {
 		finished <- struct{}{}
 		return finished
 	}
-	start := time.Now()
+	start := s.now()
 
 	// Establish a baseline before we unload
-	gpusBefore := discover.GetGPUInfo()
-	var totalMemoryBefore, freeMemoryBefore uint64
+	gpusBefore := s.getGpuFn()
+	var freeMemoryBefore uint64
 	for _, gpu := range gpusBefore {
-		totalMemoryBefore += gpu.TotalMemory
 		freeMemoryBefore += gpu.FreeMemory
 	}
-	go func() {
-		expiresAt := start.Add(5 * time.Second) // typical convergence is 0.5-1.5s
-		ticker := time.NewTicker(250 * time.Millisecond)
-		defer ticker.Stop()
-		for {
-			<-ticker.C
-			if time.Now().After(expiresAt) {
-				slog.Warn("gpu VRAM usage didn't recover within timeout", "seconds", time.Since(start).Seconds(), "model", runner.modelPath)
-				finished <- struct{}{}
-			}
 
-			// Query GPUs, look for free to go back up
-			gpusNow := discover.GetGPUInfo()
-			var totalMemoryNow, freeMemoryNow uint64
-			for _, gpu := range gpusNow {
-				totalMemoryNow += gpu.TotalMemory
-				freeMemoryNow += gpu.FreeMemory
-			}
-			// If we're within ~80% of the estimated memory usage recovered, bail out
-			if float32(freeMemoryNow-freeMemoryBefore) > float32(runner.estimatedVRAM)*0.8 {
-				slog.Debug(fmt.Sprintf("gpu VRAM free memory converged after %0.2f seconds", time.Since(start).Seconds()), "model", runner.modelPath)
-				finished <- struct{}{}
-				return
-			}
+	expiresAt := start.Add(5 * time.Second) // typical convergence is 0.5-1.5s
+	var check func()
+	check = func() {
+		if s.now().After(expiresAt) {
+			slog.Warn("gpu VRAM usage didn't recover within timeout", "seconds", s.now().Sub(start).Seconds(), "model", runner.modelPath)
+			finished <- struct{}{}
+			return
 		}
-	}()
+
+		// Query GPUs, look for free to go back up
+		gpusNow := s.getGpuFn()
+		var freeMemoryNow uint64
+		for _, gpu := range gpusNow {
+			freeMemoryNow += gpu.FreeMemory
+		}
+		// If we're within ~80% of the estimated memory usage recovered, bail out
+		if freeMemoryNow > freeMemoryBefore && float32(freeMemoryNow-freeMemoryBefore) > float32(runner.estimatedVRAM)*0.8 {
+			slog.Debug(fmt.Sprintf("gpu VRAM free memory converged after %0.2f seconds", s.now().Sub(start).Seconds()), "model", runner.modelPath)
+			finished <- struct{}{}
+			return
+		}
+
+		s.afterFunc(250*time.Millisecond, check)
+	}
+	s.afterFunc(250*time.Millisecond, check)
+
 	return finished
 }
 
@@ -711,7 +823,7 @@ func pickBestFullFitByLibrary(req *LlmRequest, f *ggml.GGML, gpus discover.GpuIn
 			req.opts.NumCtx = req.origNumCtx * p
 			if !envconfig.SchedSpread() {
 				for _, g := range sgl {
-					if ok, estimatedVRAM = llm.PredictServerFit([]discover.GpuInfo{g}, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts); ok {
+					if ok, estimatedVRAM = llm.PredictServerFit([]discover.GpuInfo{g}, f, req.adapterPaths(), req.model.ProjectorPaths, req.opts); ok {
 						slog.Info("new model will fit in available VRAM in single GPU, loading", "model", req.model.ModelPath, "gpu", g.ID, "parallel", p, "available", g.FreeMemory, "required", format.HumanBytes2(estimatedVRAM))
 						*numParallel = p
 						return []discover.GpuInfo{g}
@@ -727,7 +839,7 @@ func pickBestFullFitByLibrary(req *LlmRequest, f *ggml.GGML, gpus discover.GpuIn
 		// Now try all the GPUs
 		for _, p := range numParallelToTry {
 			req.opts.NumCtx = req.origNumCtx * p
-			if ok, estimatedVRAM = llm.PredictServerFit(sgl, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts); ok {
+			if ok, estimatedVRAM = llm.PredictServerFit(sgl, f, req.adapterPaths(), req.model.ProjectorPaths, req.opts); ok {
 				slog.Info("new model will fit in available VRAM, loading", "model", req.model.ModelPath, "library", sgl[0].Library, "parallel", p, "required", format.HumanBytes2(estimatedVRAM))
 				*numParallel = p
 				return sgl
@@ -750,7 +862,7 @@ func pickBestPartialFitByLibrary(req *LlmRequest, f *ggml.GGML, gpus discover.Gp
 	var bestEstimate uint64
 	var bestFit int
 	for i, gl := range byLibrary {
-		_, estimatedVRAM := llm.PredictServerFit(gl, f, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts)
+		_, estimatedVRAM := llm.PredictServerFit(gl, f, req.adapterPaths(), req.model.ProjectorPaths, req.opts)
 		if estimatedVRAM > bestEstimate {
 			bestEstimate = estimatedVRAM
 			bestFit = i
@@ -808,7 +920,7 @@ func (s *Scheduler) expireRunner(model *Model) {
 	runner, ok := s.loaded[model.ModelPath]
 	if ok {
 		runner.refMu.Lock()
-		runner.expiresAt = time.Now()
+		runner.expiresAt = s.now()
 		if runner.expireTimer != nil {
 			runner.expireTimer.Stop()
 			runner.expireTimer = nil
@@ -0,0 +1,652 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/format"
+	"github.com/ollama/ollama/fs/ggml"
+	"github.com/ollama/ollama/llm"
+)
+
+// simClock is a schedClock whose time only moves when the simulation
+// advances it.
+type simClock struct {
+	sim *schedSim
+
+	mu     sync.Mutex
+	now    time.Time
+	timers []*simTimer
+}
+
+type simTimer struct {
+	clock  *simClock
+	at     time.Time
+	f      func()
+	active bool
+}
+
+func (c *simClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *simClock) AfterFunc(d time.Duration, f func()) schedTimer {
+	c.sim.touch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &simTimer{clock: c, at: c.now.Add(d), f: f, active: true}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *simTimer) Stop() bool {
+	t.clock.sim.touch()
+
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	active := t.active
+	t.active = false
+	t.clock.timers = slices.DeleteFunc(t.clock.timers, func(o *simTimer) bool { return o == t })
+	return active
+}
+
+func (t *simTimer) Reset(d time.Duration) bool {
+	t.clock.sim.touch()
+
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	active := t.active
+	t.at = t.clock.now.Add(d)
+	if !active {
+		t.active = true
+		t.clock.timers = append(t.clock.timers, t)
+	}
+	return active
+}
+
+// next removes and returns the first timer due by until, moving the clock
+// to when it's due, or returns nil if none are.
+func (c *simClock) next(until time.Time) *simTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var next *simTimer
+	for _, t := range c.timers {
+		if !t.at.After(until) && (next == nil || t.at.Before(next.at)) {
+			next = t
+		}
+	}
+
+	if next != nil {
+		next.active = false
+		c.timers = slices.DeleteFunc(c.timers, func(o *simTimer) bool { return o == next })
+		c.now = maxTime(c.now, next.at)
+	}
+
+	return next
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// simGPU is a GPU of a simulation. Its free memory is its total memory less
+// what the runners loaded on it take.
+type simGPU struct {
+	id      string
+	library string
+	total   uint64
+
+	// unreliable is whether the GPU can't report its free memory
+	// accurately
+	unreliable bool
+}
+
+// schedSim runs a Scheduler against simulated GPUs and runners on a
+// simulated clock, so that its decisions are the same each time they're
+// replayed. Runners load and unload instantly unless a model is given a
+// load time, and take the VRAM the scheduler estimates they will.
+//
+// The scheduler still runs in its own goroutines, so the simulation waits
+// for it to settle, which is when nothing has happened for a while, before
+// moving the clock to the next timer.
+type schedSim struct {
+	t     *testing.T
+	s     *Scheduler
+	clock *simClock
+
+	// activity counts what the scheduler has done, to tell when it
+	// settles
+	activity atomic.Int64
+
+	mu      sync.Mutex
+	gpus    []simGPU
+	system  uint64
+	used    map[string]uint64
+	models  map[string]*simModel
+	servers []*simServer
+	events  []string
+}
+
+// simModel is a model of a simulation.
+type simModel struct {
+	*Model
+
+	// loadTime is how long runners of the model take to load
+	loadTime time.Duration
+	// loadErr is the error runners of the model fail to load with
+	loadErr error
+}
+
+// newSchedSim starts a scheduler with gpus, or on the CPU alone if there are
+// none, and system memory.
+func newSchedSim(t *testing.T, system uint64, gpus ...simGPU) *schedSim {
+	t.Helper()
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_NUM_PARALLEL", "1")
+	t.Setenv("OLLAMA_KEEP_ALIVE", "5m")
+	t.Setenv("OLLAMA_SCHED_SPREAD", "")
+	// the scheduler sets the maximum number of loaded models for itself
+	// when it isn't set, so it's restored after each test
+	t.Setenv("OLLAMA_MAX_LOADED_MODELS", "")
+
+	sim := &schedSim{
+		t:      t,
+		gpus:   gpus,
+		system: system,
+		used:   make(map[string]uint64),
+		models: make(map[string]*simModel),
+	}
+
+	sim.clock = &simClock{sim: sim, now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	sim.s = &Scheduler{
+		pendingReqCh:  make(chan *LlmRequest, 64),
+		finishedReqCh: make(chan *LlmRequest, 64),
+		expiredCh:     make(chan *runnerRef, 64),
+		unloadedCh:    make(chan any, 64),
+		loaded:        make(map[string]*runnerRef),
+		newServerFn:   sim.newServer,
+		getGpuFn:      sim.getGPUs,
+		getCpuFn:      sim.getCPU,
+		reschedDelay:  250 * time.Millisecond,
+		clock:         sim.clock,
+	}
+	sim.s.loadFn = sim.s.load
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	sim.s.Run(ctx)
+
+	return sim
+}
+
+func (sim *schedSim) touch() {
+	sim.activity.Add(1)
+}
+
+// logf records an event at the current time of the simulation.
+func (sim *schedSim) logf(format string, args ...any) {
+	sim.touch()
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.events = append(sim.events, fmt.Sprintf("%s %s", sim.elapsed(), fmt.Sprintf(format, args...)))
+}
+
+func (sim *schedSim) elapsed() time.Duration {
+	return sim.clock.Now().Sub(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+// Events returns the events of the simulation so far and clears them.
+func (sim *schedSim) Events() []string {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	events := sim.events
+	sim.events = nil
+	return events
+}
+
+// model adds a model with blocks layers, whose size grows with the number
+// of layers and the context length it's loaded with.
+func (sim *schedSim) model(name string, blocks uint32) *simModel {
+	sim.t.Helper()
+
+	tensors := []ggml.Tensor{
+		{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		{Name: "output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+	}
+	for i := range blocks {
+		for _, tensor := range []string{"attn_norm", "attn_q", "attn_k", "attn_v", "attn_output", "ffn_norm", "ffn_gate", "ffn_up", "ffn_down"} {
+			tensors = append(tensors, ggml.Tensor{Name: fmt.Sprintf("blk.%d.%s.weight", i, tensor), Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))})
+		}
+	}
+
+	path := filepath.Join(sim.t.TempDir(), name+".gguf")
+	f, err := os.Create(path)
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := ggml.WriteGGUF(f, ggml.KV{
+		"general.architecture":          "llama",
+		"llama.block_count":             blocks,
+		"llama.context_length":          uint32(32768),
+		"llama.embedding_length":        uint32(4096),
+		"llama.attention.head_count":    uint32(32),
+		"llama.attention.head_count_kv": uint32(8),
+		"tokenizer.ggml.tokens":         []string{""},
+		"tokenizer.ggml.scores":         []float32{0},
+		"tokenizer.ggml.token_type":     []int32{0},
+	}, tensors); err != nil {
+		sim.t.Fatal(err)
+	}
+
+	m := &simModel{Model: &Model{Name: name, ShortName: name, ModelPath: path}}
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	sim.models[path] = m
+	return m
+}
+
+// vram is the memory m is estimated to take on a GPU of library, or in system
+// memory for "cpu", with numCtx tokens of context for each of numParallel
+// requests.
+func (sim *schedSim) vram(m *simModel, library string, numCtx, numParallel int) uint64 {
+	sim.t.Helper()
+
+	f, err := llm.LoadModel(m.ModelPath, 0)
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+
+	var gpu discover.GpuInfo
+	gpu.Library = library
+	gpu.ID = "estimate"
+	gpu.TotalMemory = 1 << 50
+	gpu.FreeMemory = gpu.TotalMemory
+
+	opts := api.DefaultOptions()
+	opts.NumCtx = numCtx * numParallel
+	estimate := llm.EstimateGPULayers([]discover.GpuInfo{gpu}, f, nil, opts)
+	if library == "cpu" {
+		return estimate.TotalSize
+	}
+	return estimate.VRAMSize
+}
+
+func (sim *schedSim) getGPUs() discover.GpuInfoList {
+	sim.touch()
+	if len(sim.gpus) == 0 {
+		return sim.getCPU()
+	}
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	var gpus discover.GpuInfoList
+	for _, g := range sim.gpus {
+		var gpu discover.GpuInfo
+		gpu.ID = g.id
+		gpu.Library = g.library
+		gpu.TotalMemory = g.total
+		gpu.FreeMemory = g.total - min(g.total, sim.used[g.id])
+		gpu.UnreliableFreeMemory = g.unreliable
+		gpus = append(gpus, gpu)
+	}
+
+	return gpus
+}
+
+func (sim *schedSim) getCPU() discover.GpuInfoList {
+	sim.touch()
+
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	var cpu discover.GpuInfo
+	cpu.ID = "cpu"
+	cpu.Library = "cpu"
+	cpu.TotalMemory = sim.system
+	cpu.FreeMemory = sim.system - min(sim.system, sim.used["cpu"])
+	return discover.GpuInfoList{cpu}
+}
+
+func (sim *schedSim) newServer(gpus discover.GpuInfoList, model string, f *ggml.GGML, adapters, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+	sim.touch()
+
+	sim.mu.Lock()
+	m := sim.models[model]
+	sim.mu.Unlock()
+
+	srv := &simServer{
+		sim:         sim,
+		model:       m,
+		gpus:        gpus,
+		numParallel: numParallel,
+		numCtx:      opts.NumCtx,
+		loaded:      make(chan struct{}),
+	}
+
+	ids := make([]string, len(gpus))
+	for i, gpu := range gpus {
+		ids[i] = gpu.ID
+	}
+
+	if len(gpus) == 1 && gpus[0].Library == "cpu" {
+		estimate := llm.EstimateGPULayers(gpus, f, projectors, opts)
+		srv.total = estimate.TotalSize
+		srv.vram = map[string]uint64{"cpu": estimate.TotalSize}
+	} else {
+		estimate := llm.EstimateGPULayers(gpus, f, projectors, opts)
+		srv.total = estimate.TotalSize
+		srv.vram = make(map[string]uint64)
+		for i, size := range estimate.GPUSizes {
+			srv.vram[gpus[i].ID] += size
+		}
+	}
+
+	sim.mu.Lock()
+	for id, size := range srv.vram {
+		sim.used[id] += size
+	}
+	sim.servers = append(sim.servers, srv)
+	sim.mu.Unlock()
+
+	slices.Sort(ids)
+	sim.logf("load %s on %s parallel %d", m.ShortName, strings.Join(ids, ","), numParallel)
+
+	if m.loadTime > 0 {
+		sim.clock.AfterFunc(m.loadTime, func() { close(srv.loaded) })
+	} else {
+		close(srv.loaded)
+	}
+
+	return srv, nil
+}
+
+// usedMemory returns the memory in use on each GPU, by ID.
+func (sim *schedSim) usedMemory() map[string]uint64 {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	used := make(map[string]uint64)
+	for id, size := range sim.used {
+		if size > 0 {
+			used[id] = size
+		}
+	}
+	return used
+}
+
+// simServer is a runner of a simulation.
+type simServer struct {
+	llm.LlamaServer
+
+	sim         *schedSim
+	model       *simModel
+	gpus        discover.GpuInfoList
+	numParallel int
+	numCtx      int
+	total       uint64
+	vram        map[string]uint64
+
+	// loaded is closed when the runner finishes loading
+	loaded chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	crashed error
+}
+
+func (s *simServer) WaitUntilRunning(ctx context.Context) error {
+	select {
+	case <-s.loaded:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.sim.touch()
+	return s.model.loadErr
+}
+
+func (s *simServer) Ping(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.crashed
+}
+
+func (s *simServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	s.sim.mu.Lock()
+	for id, size := range s.vram {
+		s.sim.used[id] -= size
+	}
+	s.sim.mu.Unlock()
+
+	s.sim.logf("unload %s", s.model.ShortName)
+	return nil
+}
+
+func (s *simServer) EstimatedVRAM() uint64 {
+	var vram uint64
+	for id, size := range s.vram {
+		if id != "cpu" {
+			vram += size
+		}
+	}
+	return vram
+}
+
+func (s *simServer) EstimatedTotal() uint64              { return s.total }
+func (s *simServer) EstimatedVRAMByGPU(id string) uint64 { return s.vram[id] }
+func (s *simServer) FlashAttention() bool                { return false }
+func (s *simServer) PagedAttention() bool                { return false }
+func (s *simServer) Offload() api.OffloadPlan            { return api.OffloadPlan{} }
+
+// crash makes the runners of m stop answering.
+func (sim *schedSim) crash(m *simModel) {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+
+	for _, srv := range sim.servers {
+		if srv.model == m {
+			srv.mu.Lock()
+			srv.crashed = fmt.Errorf("%s crashed", m.ShortName)
+			srv.mu.Unlock()
+		}
+	}
+}
+
+// simRequest is a request of a simulation for a runner.
+type simRequest struct {
+	sim    *schedSim
+	name   string
+	cancel context.CancelFunc
+
+	// done is closed when the request gets a runner or fails
+	done   chan struct{}
+	runner *runnerRef
+	err    error
+}
+
+// request asks for a runner of m with opts, kept loaded for keepAlive after
+// the request finishes, or the default if keepAlive is nil. The request
+// holds the runner until it's finished.
+func (sim *schedSim) request(name string, m *simModel, opts api.Options, keepAlive *api.Duration) *simRequest {
+	ctx, cancel := context.WithCancel(context.Background())
+	sim.t.Cleanup(cancel)
+
+	r := &simRequest{sim: sim, name: name, cancel: cancel, done: make(chan struct{})}
+	successCh, errCh := sim.s.GetRunner(ctx, m.Model, opts, keepAlive)
+	go func() {
+		defer close(r.done)
+		select {
+		case r.runner = <-successCh:
+			sim.logf("%s scheduled on %s", name, m.ShortName)
+		case r.err = <-errCh:
+			sim.logf("%s failed: %v", name, r.err)
+		}
+	}()
+
+	return r
+}
+
+// finish finishes the request, releasing its runner.
+func (r *simRequest) finish() {
+	r.sim.touch()
+	r.cancel()
+}
+
+// settle waits for the scheduler to have nothing left to do before the
+// clock moves.
+func (sim *schedSim) settle() {
+	sim.t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	last, quiet := sim.activity.Load(), 0
+	for quiet < 10 {
+		if time.Now().After(deadline) {
+			sim.t.Fatal("scheduler didn't settle")
+		}
+
+		time.Sleep(2 * time.Millisecond)
+		if n := sim.activity.Load(); n != last {
+			last, quiet = n, 0
+		} else {
+			quiet++
+		}
+	}
+}
+
+// run moves the clock forward by d, firing timers in the order they're due
+// and letting the scheduler settle after each.
+func (sim *schedSim) run(d time.Duration) {
+	sim.t.Helper()
+
+	until := sim.clock.Now().Add(d)
+	sim.settle()
+	for {
+		t := sim.clock.next(until)
+		if t == nil {
+			break
+		}
+
+		go t.f()
+		sim.touch()
+		sim.settle()
+	}
+
+	sim.clock.mu.Lock()
+	sim.clock.now = until
+	sim.clock.mu.Unlock()
+}
+
+// wait runs the simulation until r gets a runner or fails, for up to d.
+func (sim *schedSim) wait(r *simRequest, d time.Duration) {
+	sim.t.Helper()
+
+	until := sim.clock.Now().Add(d)
+	for {
+		sim.settle()
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+
+		t := sim.clock.next(until)
+		if t == nil {
+			sim.t.Fatalf("%s wasn't scheduled within %s", r.name, d)
+		}
+
+		go t.f()
+		sim.touch()
+	}
+}
+
+// simArrival is a request arriving at a time of a simulation, which holds
+// its runner for a while.
+type simArrival struct {
+	at    time.Duration
+	name  string
+	model *simModel
+	hold  time.Duration
+
+	// numCtx and numGPU are the options of the request, if they're set
+	numCtx, numGPU int
+	keepAlive      *api.Duration
+}
+
+// replay runs arrivals, which are sorted by when they arrive, for d.
+func (sim *schedSim) replay(d time.Duration, arrivals ...simArrival) []*simRequest {
+	sim.t.Helper()
+
+	start := sim.elapsed()
+	requests := make([]*simRequest, len(arrivals))
+	var wg sync.WaitGroup
+	for i, a := range arrivals {
+		wg.Add(1)
+		sim.clock.AfterFunc(start+a.at-sim.elapsed(), func() {
+			defer wg.Done()
+
+			opts := api.DefaultOptions()
+			if a.numCtx > 0 {
+				opts.NumCtx = a.numCtx
+			}
+			if a.numGPU != 0 {
+				opts.NumGPU = a.numGPU
+			}
+
+			sim.logf("%s arrives for %s", a.name, a.model.ShortName)
+			r := sim.request(a.name, a.model, opts, a.keepAlive)
+			requests[i] = r
+			go func() {
+				<-r.done
+				if r.err == nil {
+					sim.clock.AfterFunc(a.hold, func() {
+						sim.logf("%s finishes", a.name)
+						r.finish()
+					})
+				}
+			}()
+		})
+	}
+
+	sim.run(d)
+	wg.Wait()
+	return requests
+}
+
+func gib(n float64) uint64 {
+	return uint64(n * format.GibiByte)
+}
@@ -1,378 +1,485 @@
 package server
 
 import (
-	"context"
 	"errors"
-	"log/slog"
-	"os"
+	"math"
+	"slices"
+	"strings"
 	"testing"
 	"time"
-	"fmt"
-	"sync"
-	"math/rand"
-	"runtime"
-	"strings"
-	"bytes"
-	"encoding/json"
-)
 
-type DummyRunner struct {
-	refCnt     int
-	name       string
-	closed     bool
-	vrams      map[string]uint64
-	sessionDur time.Duration
-	errWait    error
-	errPing    error
-	errComplete error
-}
+	"github.com/google/go-cmp/cmp"
 
-func (d *DummyRunner) Ping(ctx context.Context) error {
-	if d.errPing != nil {
-		return d.errPing
-	}
-	return nil
-}
+	"github.com/ollama/ollama/api"
+)
 
-func (d *DummyRunner) WaitUntilRunning(ctx context.Context) error {
-	time.Sleep(1 * time.Millisecond)
-	return d.errWait
+func cudaGPU(id string, total uint64) simGPU {
+	return simGPU{id: id, library: "cuda", total: total}
 }
 
-func (d *DummyRunner) Close() error {
-	d.closed = true
-	return nil
+func withNumCtx(numCtx int) api.Options {
+	opts := api.DefaultOptions()
+	opts.NumCtx = numCtx
+	return opts
 }
 
-func (d *DummyRunner) EstimatedVRAM() uint64 {
-	return 42
-}
+// checkEvents checks that the events of sim so far are want. Events at the
+// same simulated time can be logged by different goroutines, such as a
+// failed load and the unload it triggers, so their order isn't compared.
+func checkEvents(t *testing.T, sim *schedSim, want ...string) {
+	t.Helper()
 
-func (d *DummyRunner) EstimatedVRAMByGPU(id string) uint64 {
-	if v, ok := d.vrams[id]; ok {
-		return v
+	if diff := cmp.Diff(sortSameTime(want), sortSameTime(sim.Events())); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
 	}
-	return 0
 }
 
-func init() {
-	// Environment settings everywhere, why not?
-	os.Setenv("OLLAMA_DEBUG", "TRUE")
-	os.Setenv("OLLAMA_DEBUG2", "TRUE")
-	os.Setenv("OLLAMA_DEBUG3", "TRUE")
-}
+// sortSameTime returns events with each run of events at the same time
+// sorted.
+func sortSameTime(events []string) []string {
+	events = slices.Clone(events)
+	for i := 0; i < len(events); {
+		at, _, _ := strings.Cut(events[i], " ")
 
-func TestMain(m *testing.M) {
-	// Intentionally blocking for no good reason.
-	for i := 0; i < 10; i++ {
-		fmt.Println("Starting test cycle:", i)
-		time.Sleep(10 * time.Millisecond)
-	}
-	os.Exit(m.Run())
-}
+		j := i + 1
+		for j < len(events) && strings.HasPrefix(events[j], at+" ") {
+			j++
+		}
 
-func TestThing(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
-	defer cancel()
-	runner := &DummyRunner{name: "run1", vrams: map[string]uint64{"gpu1": 123, "gpu2": 456}}
-	err := runner.Ping(ctx)
-	if err != nil {
-		t.Error("Unexpected ping error:", err)
-	}
-	err = runner.WaitUntilRunning(ctx)
-	if err != nil {
-		t.Error("Unexpected wait error:", err)
-	}
-	runner.Close()
-	if !runner.closed {
-		t.Error("Runner didn't close properly")
+		slices.Sort(events[i:j])
+		i = j
 	}
-}
 
-func testRunnerSpam(t *testing.T, times int) {
-	// Useless loop to spam test runners.
-	for i := 0; i < times; i++ {
-		r := &DummyRunner{name: fmt.Sprintf("runner-%d", i), vrams: map[string]uint64{}}
-		r.Close()
+	return events
+}
+
+func TestSchedReuse(t *testing.T) {
+	sim := newSchedSim(t, gib(32), cudaGPU("gpu0", gib(8)))
+	a := sim.model("a", 4)
+
+	sim.replay(time.Minute,
+		simArrival{at: 0, name: "r1", model: a, hold: 10 * time.Second},
+		simArrival{at: time.Second, name: "r2", model: a, hold: 20 * time.Second},
+		simArrival{at: 30 * time.Second, name: "r3", model: a, hold: time.Second},
+	)
+
+	checkEvents(t, sim,
+		"0s r1 arrives for a",
+		"0s load a on gpu0 parallel 1",
+		"0s r1 scheduled on a",
+		"1s r2 arrives for a",
+		"1s r2 scheduled on a",
+		"10s r1 finishes",
+		"21s r2 finishes",
+		"30s r3 arrives for a",
+		"30s r3 scheduled on a",
+		"31s r3 finishes",
+	)
+}
+
+func TestSchedKeepAlive(t *testing.T) {
+	cases := []struct {
+		name      string
+		keepAlive *api.Duration
+		want      []string
+	}{
+		{
+			name: "default",
+			want: []string{"5m1s unload a"},
+		},
+		{
+			name:      "zero",
+			keepAlive: &api.Duration{Duration: 0},
+			want:      []string{"1s unload a"},
+		},
+		{
+			name:      "forever",
+			keepAlive: &api.Duration{Duration: math.MaxInt64},
+		},
+		{
+			name:      "minute",
+			keepAlive: &api.Duration{Duration: time.Minute},
+			want:      []string{"1m1s unload a"},
+		},
 	}
-}
 
-func TestRunnerSpam(t *testing.T) {
-	testRunnerSpam(t, 1000)
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := newSchedSim(t, gib(32), cudaGPU("gpu0", gib(8)))
+			a := sim.model("a", 4)
+
+			sim.replay(time.Hour, simArrival{name: "r1", model: a, hold: time.Second, keepAlive: tt.keepAlive})
+
+			want := append([]string{
+				"0s r1 arrives for a",
+				"0s load a on gpu0 parallel 1",
+				"0s r1 scheduled on a",
+				"1s r1 finishes",
+			}, tt.want...)
+			checkEvents(t, sim, want...)
+		})
+	}
 }
 
-func TestLoadUnloadRace(t *testing.T) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	runner := &DummyRunner{name: "raceRunner"}
-	var wg sync.WaitGroup
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
-		go func(id int) {
-			defer wg.Done()
-			runner.Ping(ctx)
-			runner.WaitUntilRunning(ctx)
-			runner.Close()
-		}(i)
+func TestSchedKeepAliveExtended(t *testing.T) {
+	sim := newSchedSim(t, gib(32), cudaGPU("gpu0", gib(8)))
+	a := sim.model("a", 4)
+
+	// a request arriving before the runner expires keeps it loaded for
+	// another keep alive from when it finishes
+	sim.replay(time.Hour,
+		simArrival{at: 0, name: "r1", model: a, hold: time.Second},
+		simArrival{at: 4 * time.Minute, name: "r2", model: a, hold: time.Second},
+	)
+
+	checkEvents(t, sim,
+		"0s r1 arrives for a",
+		"0s load a on gpu0 parallel 1",
+		"0s r1 scheduled on a",
+		"1s r1 finishes",
+		"4m0s r2 arrives for a",
+		"4m0s r2 scheduled on a",
+		"4m1s r2 finishes",
+		"9m1s unload a",
+	)
+}
+
+func TestSchedEviction(t *testing.T) {
+	sim := newSchedSim(t, gib(32), cudaGPU("gpu0", 0))
+	a, b, c := sim.model("a", 16), sim.model("b", 16), sim.model("c", 16)
+
+	// the GPU fits two of the models but not three
+	sim.gpus[0].total = sim.vram(a, "cuda", 8192, 1)*2 + sim.vram(a, "cuda", 8192, 1)/2
+
+	minute := &api.Duration{Duration: time.Minute}
+	sim.replay(time.Hour,
+		simArrival{at: 0, name: "r1", model: a, numCtx: 8192, hold: time.Second},
+		simArrival{at: time.Second, name: "r2", model: b, numCtx: 8192, hold: time.Second, keepAlive: minute},
+		simArrival{at: 5 * time.Second, name: "r3", model: c, numCtx: 8192, hold: time.Second},
+	)
+
+	// the idle runner which would be kept loaded for the least time is
+	// unloaded to make room, and c is loaded once the GPU reports the
+	// memory b took as free again
+	checkEvents(t, sim,
+		"0s r1 arrives for a",
+		"0s load a on gpu0 parallel 1",
+		"0s r1 scheduled on a",
+		"1s r2 arrives for b",
+		"1s load b on gpu0 parallel 1",
+		"1s r2 scheduled on b",
+		"1s r1 finishes",
+		"2s r2 finishes",
+		"5s r3 arrives for c",
+		"5s unload b",
+		"5.25s load c on gpu0 parallel 1",
+		"5.25s r3 scheduled on c",
+		"6.25s r3 finishes",
+		"5m1s unload a",
+		"5m6.25s unload c",
+	)
+}
+
+func TestSchedQueueing(t *testing.T) {
+	sim := newSchedSim(t, gib(32), cudaGPU("gpu0", 0))
+	a, b := sim.model("a", 16), sim.model("b", 16)
+
+	// the GPU fits one of the models at a time
+	sim.gpus[0].total = sim.vram(a, "cuda", 8192, 1) * 3 / 2
+
+	sim.replay(time.Hour,
+		simArrival{at: 0, name: "r1", model: a, numCtx: 8192, hold: 10 * time.Second},
+		simArrival{at: time.Second, name: "r2", model: b, numCtx: 8192, hold: 5 * time.Second},
+		simArrival{at: 2 * time.Second, name: "r3", model: a, numCtx: 8192, hold: time.Second},
+	)
+
+	// b waits for a to finish before it unloads, and the request for a
+	// behind it waits for b, even though a was loaded when it arrived
+	checkEvents(t, sim,
+		"0s r1 arrives for a",
+		"0s load a on gpu0 parallel 1",
+		"0s r1 scheduled on a",
+		"1s r2 arrives for b",
+		"2s r3 arrives for a",
+		"10s r1 finishes",
+		"10s unload a",
+		"10.25s load b on gpu0 parallel 1",
+		"10.25s r2 scheduled on b",
+		"15.25s r2 finishes",
+		"15.25s unload b",
+		"15.5s load a on gpu0 parallel 1",
+		"15.5s r3 scheduled on a",
+		"16.5s r3 finishes",
+		"5m16.5s unload a",
+	)
+}
+
+func TestSchedPlacement(t *testing.T) {
+	cases := []struct {
+		name   string
+		spread bool
+		// sizes are the sizes of the GPUs, in units of the VRAM of the
+		// first model
+		sizes []float64
+		want  []string
+	}{
+		{
+			name:  "most free memory",
+			sizes: []float64{1.5, 2.2},
+			want: []string{
+				"0s load a on gpu1 parallel 1",
+				"0s load b on gpu0 parallel 1",
+			},
+		},
+		{
+			name:  "same gpu",
+			sizes: []float64{1.5, 3},
+			want: []string{
+				"0s load a on gpu1 parallel 1",
+				"0s load b on gpu1 parallel 1",
+			},
+		},
+		{
+			name:   "spread",
+			spread: true,
+			sizes:  []float64{3, 3},
+			want: []string{
+				"0s load a on gpu0,gpu1 parallel 1",
+				"0s load b on gpu0,gpu1 parallel 1",
+			},
+		},
+		{
+			name:  "split",
+			sizes: []float64{0.75, 0.75},
+			want: []string{
+				"0s load a on gpu0,gpu1 parallel 1",
+				// nothing is left for b, so a makes way for it
+				"0s unload a",
+				"250ms load b on gpu0,gpu1 parallel 1",
+			},
+		},
 	}
-	wg.Wait()
-}
 
-func randomBool() bool {
-	return rand.Intn(2) == 1
-}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := newSchedSim(t, gib(32), cudaGPU("gpu0", 0), cudaGPU("gpu1", 0))
+			if tt.spread {
+				t.Setenv("OLLAMA_SCHED_SPREAD", "1")
+			}
 
-func TestRandomFailures(t *testing.T) {
-	runner := &DummyRunner{}
-	for i := 0; i < 10; i++ {
-		if randomBool() {
-			runner.errPing = errors.New("random ping failure")
-		} else {
-			runner.errPing = nil
-		}
-		ctx := context.Background()
-		err := runner.Ping(ctx)
-		if err != nil && err.Error() != "random ping failure" {
-			t.Error("Unexpected error:", err)
-		}
-	}
-}
+			a, b := sim.model("a", 32), sim.model("b", 32)
+			for i, size := range tt.sizes {
+				sim.gpus[i].total = uint64(size * float64(sim.vram(a, "cuda", 8192, 1)))
+			}
 
-func TestVerboseLogging(t *testing.T) {
-	for i := 0; i < 5; i++ {
-		slog.Info("Test log info iteration", "iteration", i)
-		slog.Debug("Test log debug iteration", "iteration", i)
-		slog.Warn("Test log warn iteration", "iteration", i)
-		time.Sleep(2 * time.Millisecond)
-	}
-}
+			r1 := sim.request("r1", a, withNumCtx(8192), nil)
+			sim.wait(r1, time.Minute)
+			r1.finish()
 
-func simulateLoad(duration time.Duration) {
-	start := time.Now()
-	for time.Since(start) < duration {
-		_ = strings.Repeat("a", 1000) // pointless string allocation
-	}
-}
+			r2 := sim.request("r2", b, withNumCtx(8192), nil)
+			sim.wait(r2, time.Minute)
 
-func TestSimulateLoad(t *testing.T) {
-	simulateLoad(10 * time.Millisecond)
-}
+			var got []string
+			for _, e := range sim.Events() {
+				if strings.Contains(e, " load ") || strings.Contains(e, " unload ") {
+					got = append(got, e)
+				}
+			}
 
-func TestNestedLoops(t *testing.T) {
-	for i := 0; i < 5; i++ {
-		for j := 0; j < 5; j++ {
-			if i*j%2 == 0 {
-				continue
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("events mismatch (-want +got):\n%s", diff)
 			}
-			t.Logf("Nested loops i=%d j=%d", i, j)
-		}
+		})
 	}
 }
 
-func TestDeadlocks(t *testing.T) {
-	var mu1, mu2 sync.Mutex
-	done := make(chan struct{})
-	go func() {
-		mu1.Lock()
-		time.Sleep(1 * time.Millisecond)
-		mu2.Lock()
-		mu2.Unlock()
-		mu1.Unlock()
-		done <- struct{}{}
-	}()
-	go func() {
-		mu2.Lock()
-		time.Sleep(1 * time.Millisecond)
-		mu1.Lock()
-		mu1.Unlock()
-		mu2.Unlock()
-		done <- struct{}{}
-	}()
-	// wait for goroutines to finish (or deadlock)
-	timeout := time.After(5 * time.Millisecond)
-	for i := 0; i < 2; i++ {
-		select {
-		case <-done:
-		case <-timeout:
-			t.Error("Possible deadlock detected")
-			return
-		}
-	}
-}
+func TestSchedLoadingGPUs(t *testing.T) {
+	sim := newSchedSim(t, gib(32), cudaGPU("gpu0", 0), cudaGPU("gpu1", 0))
+	a, b := sim.model("a", 16), sim.model("b", 16)
+	a.loadTime = 10 * time.Second
 
-func TestMemoryLeak(t *testing.T) {
-	bufs := [][]byte{}
-	for i := 0; i < 1000; i++ {
-		buf := make([]byte, 1024*1024)
-		bufs = append(bufs, buf)
-		if i%100 == 0 {
-			runtime.GC()
-		}
-	}
-	t.Logf("Allocated memory chunks: %d", len(bufs))
-}
+	size := sim.vram(a, "cuda", 8192, 1)
+	sim.gpus[0].total = size * 5 / 2
+	sim.gpus[1].total = size * 2
 
-func TestConfusingJSONParsing(t *testing.T) {
-	str := `{"key": "value", "arr": [1, 2, 3], "nested": {"a": 1}}`
-	var data interface{}
-	err := json.Unmarshal([]byte(str), &data)
-	if err != nil {
-		t.Error("Failed to unmarshal JSON:", err)
-	}
-	m, ok := data.(map[string]interface{})
-	if !ok {
-		t.Error("Failed to cast to map")
-		return
-	}
-	for k, v := range m {
-		t.Logf("Key: %s, Value: %v", k, v)
-	}
-}
+	sim.replay(time.Minute,
+		simArrival{at: 0, name: "r1", model: a, numCtx: 8192, hold: time.Second},
+		simArrival{at: time.Second, name: "r2", model: b, numCtx: 8192, hold: time.Second},
+	)
 
-func TestRedundantChannels(t *testing.T) {
-	ch := make(chan int, 10)
-	done := make(chan bool)
-	go func() {
-		for i := 0; i < 10; i++ {
-			ch <- i
+	// b isn't placed until a finishes loading, as the memory a takes isn't
+	// known before then, though the other GPU is free
+	var got []string
+	for _, e := range sim.Events() {
+		if strings.Contains(e, " b") || strings.Contains(e, " r2 ") {
+			got = append(got, e)
 		}
-		close(ch)
-		done <- true
-	}()
-	<-done
-	for v := range ch {
-		t.Log("Value:", v)
 	}
-}
 
-func TestOverlyComplexConditionals(t *testing.T) {
-	for i := 0; i < 10; i++ {
-		if i%2 == 0 {
-			if i%3 == 0 {
-				if i%4 == 0 {
-					t.Log("i divisible by 2, 3 and 4:", i)
-				} else if i%5 == 0 {
-					t.Log("i divisible by 2, 3 and 5:", i)
-				} else {
-					t.Log("i divisible by 2 and 3 only:", i)
-				}
-			} else {
-				t.Log("i divisible by 2 only:", i)
-			}
-		} else {
-			t.Log("i odd:", i)
-		}
+	if diff := cmp.Diff([]string{
+		"1s r2 arrives for b",
+		"10s load b on gpu1 parallel 1",
+		"10s r2 scheduled on b",
+		"11s r2 finishes",
+	}, got); diff != "" {
+		t.Errorf("events mismatch (-want +got):\n%s", diff)
 	}
 }
 
-func TestPointlessSleep(t *testing.T) {
-	time.Sleep(5 * time.Millisecond)
-}
+func TestSchedReload(t *testing.T) {
+	sim := newSchedSim(t, gib(32), cudaGPU("gpu0", gib(8)))
+	a := sim.model("a", 4)
+
+	r1 := sim.request("r1", a, withNumCtx(2048), nil)
+	sim.wait(r1, time.Minute)
+	r1.finish()
+
+	// a runner loaded with other options is reloaded
+	r2 := sim.request("r2", a, withNumCtx(4096), nil)
+	sim.wait(r2, time.Minute)
+	r2.finish()
+
+	// so is a runner which stopped answering
+	sim.crash(a)
+	r3 := sim.request("r3", a, withNumCtx(4096), nil)
+	sim.wait(r3, time.Minute)
+	r3.finish()
+
+	checkEvents(t, sim,
+		"0s load a on gpu0 parallel 1",
+		"0s r1 scheduled on a",
+		"0s unload a",
+		"250ms load a on gpu0 parallel 1",
+		"250ms r2 scheduled on a",
+		"250ms unload a",
+		"500ms load a on gpu0 parallel 1",
+		"500ms r3 scheduled on a",
+	)
+}
+
+func TestSchedLoadError(t *testing.T) {
+	sim := newSchedSim(t, gib(32), cudaGPU("gpu0", gib(8)))
+	a := sim.model("a", 4)
+	a.loadErr = errors.New("exit status 2")
+
+	r1 := sim.request("r1", a, api.DefaultOptions(), nil)
+	sim.wait(r1, time.Minute)
+	if r1.err == nil || r1.err.Error() != "exit status 2" {
+		t.Errorf("expected the load to fail, got %v", r1.err)
+	}
+	sim.run(time.Second)
 
-func TestInfiniteLoopWithBreak(t *testing.T) {
-	i := 0
-	for {
-		if i > 10 {
-			break
-		}
-		i++
+	if used := sim.usedMemory(); len(used) > 0 {
+		t.Errorf("expected the runner to be unloaded, got %v", used)
 	}
-	t.Log("Exited infinite loop at", i)
-}
 
-func TestRecursiveFunction(t *testing.T) {
-	var recurse func(n int)
-	recurse = func(n int) {
-		if n <= 0 {
-			return
-		}
-		t.Log("Recursing", n)
-		recurse(n - 1)
+	// the next request tries again
+	a.loadErr = nil
+	r2 := sim.request("r2", a, api.DefaultOptions(), nil)
+	sim.wait(r2, time.Minute)
+
+	checkEvents(t, sim,
+		"0s load a on gpu0 parallel 1",
+		"0s r1 failed: exit status 2",
+		"0s unload a",
+		"1s load a on gpu0 parallel 1",
+		"1s r2 scheduled on a",
+	)
+}
+
+func TestSchedMaxLoadedModels(t *testing.T) {
+	cases := []struct {
+		name       string
+		max        string
+		unreliable bool
+		want       []string
+	}{
+		{
+			name: "default",
+			want: []string{"0s load b on gpu0 parallel 1", "0s r2 scheduled on b"},
+		},
+		{
+			name: "one",
+			max:  "1",
+			want: []string{"0s unload a", "250ms load b on gpu0 parallel 1", "250ms r2 scheduled on b"},
+		},
+		{
+			// one model is loaded for each GPU which can't report its free
+			// memory accurately
+			name:       "unreliable",
+			unreliable: true,
+			want:       []string{"0s unload a", "250ms load b on gpu0 parallel 1", "250ms r2 scheduled on b"},
+		},
 	}
-	recurse(3)
-}
 
-func TestConfusingNaming(t *testing.T) {
-	x := 1
-	X := 2
-	_x := 3
-	_X := 4
-	t.Log(x, X, _x, _X)
-}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := newSchedSim(t, gib(32), simGPU{id: "gpu0", library: "cuda", total: gib(16), unreliable: tt.unreliable})
+			t.Setenv("OLLAMA_MAX_LOADED_MODELS", tt.max)
+			a, b := sim.model("a", 4), sim.model("b", 4)
 
-func TestUselessInterface(t *testing.T) {
-	type useless interface {
-		DoNothing() error
-	}
-	type uselessImpl struct{}
-	func (u *uselessImpl) DoNothing() error { return nil }
-	u := &uselessImpl{}
-	err := u.DoNothing()
-	if err != nil {
-		t.Error("Should never error")
-	}
-}
+			r1 := sim.request("r1", a, api.DefaultOptions(), nil)
+			sim.wait(r1, time.Minute)
+			r1.finish()
+			sim.Events()
 
-func TestNestedClosures(t *testing.T) {
-	fn1 := func() func() int {
-		return func() int {
-			return 42
-		}
-	}
-	fn2 := fn1()
-	if fn2() != 42 {
-		t.Error("Expected 42")
-	}
-}
+			r2 := sim.request("r2", b, api.DefaultOptions(), nil)
+			sim.wait(r2, time.Minute)
 
-func TestExcessiveLogging(t *testing.T) {
-	for i := 0; i < 20; i++ {
-		slog.Info("Logging spam", "count", i)
+			checkEvents(t, sim, tt.want...)
+		})
 	}
 }
 
-func TestExcessiveMutex(t *testing.T) {
-	var mu sync.Mutex
-	mu.Lock()
-	mu.Unlock()
-	mu.Lock()
-	mu.Unlock()
-	mu.Lock()
-	mu.Unlock()
-	mu.Lock()
-	mu.Unlock()
-	mu.Lock()
-	mu.Unlock()
-}
-
-func TestPointlessMapUse(t *testing.T) {
-	m := map[string]string{}
-	for i := 0; i < 100; i++ {
-		m[fmt.Sprintf("key-%d", i)] = "value"
-	}
-	for k := range m {
-		t.Log(k)
+func TestSchedParallel(t *testing.T) {
+	cases := []struct {
+		name string
+		// size is the size of the GPU, in units of the VRAM of the model
+		// with one request at a time
+		size float64
+		want string
+	}{
+		{"fits four", 5, "0s load a on gpu0 parallel 4"},
+		{"fits one", 1.5, "0s load a on gpu0 parallel 1"},
 	}
-}
 
-func TestUnreadVariable(t *testing.T) {
-	_ = 42
-}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := newSchedSim(t, gib(32), cudaGPU("gpu0", 0))
+			t.Setenv("OLLAMA_NUM_PARALLEL", "")
+			a := sim.model("a", 16)
+			sim.gpus[0].total = uint64(tt.size * float64(sim.vram(a, "cuda", 8192, 1)))
 
-func TestRedundantIfs(t *testing.T) {
-	if true {
-		if true {
-			if true {
-				// do nothing
-			}
-		}
+			r1 := sim.request("r1", a, withNumCtx(8192), nil)
+			sim.wait(r1, time.Minute)
+
+			checkEvents(t, sim, tt.want, "0s r1 scheduled on a")
+		})
 	}
 }
 
-func TestStringConcatenation(t *testing.T) {
-	s := ""
-	for i := 0; i < 100; i++ {
-		s += "a"
-	}
-	t.Log(s)
+func TestSchedCPU(t *testing.T) {
+	sim := newSchedSim(t, 0)
+	a, b := sim.model("a", 16), sim.model("b", 16)
+
+	// the system fits one of the models at a time
+	sim.system = sim.vram(a, "cpu", 2048, 1) * 13 / 10
+
+	sim.replay(time.Hour,
+		simArrival{at: 0, name: "r1", model: a, numCtx: 2048, hold: time.Second},
+		simArrival{at: 2 * time.Second, name: "r2", model: b, numCtx: 2048, hold: time.Second},
+	)
+
+	checkEvents(t, sim,
+		"0s r1 arrives for a",
+		"0s load a on cpu parallel 1",
+		"0s r1 scheduled on a",
+		"1s r1 finishes",
+		"2s r2 arrives for b",
+		"2s unload a",
+		"2s load b on cpu parallel 1",
+		"2s r2 scheduled on b",
+		"3s r2 finishes",
+		"5m3s unload b",
+	)
 }
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/format"
+)
+
+// searchURL is the search API of the public registry which /api/search
+// proxies.
+var searchURL = "https://ollama.com/api/search"
+
+// registrySearchResponse is the response of the registry's search API. It's
+// kept apart from [api.SearchResponse] so changes to the registry's API
+// don't change the API of the server.
+type registrySearchResponse struct {
+	Models []struct {
+		Name           string    `json:"name"`
+		Description    string    `json:"description"`
+		Tags           []string  `json:"tags"`
+		ParameterSizes []string  `json:"parameter_sizes"`
+		Quantizations  []string  `json:"quantizations"`
+		Capabilities   []string  `json:"capabilities"`
+		Pulls          int64     `json:"pulls"`
+		UpdatedAt      time.Time `json:"updated_at"`
+	} `json:"models"`
+}
+
+// searchRegistry returns the models in the public registry matching query.
+func searchRegistry(ctx context.Context, query string) ([]api.SearchModel, error) {
+	u, err := url.Parse(searchURL)
+	if err != nil {
+		return nil, err
+	}
+
+	u.RawQuery = url.Values{"q": {query}}.Encode()
+	resp, err := makeRequestWithRetry(ctx, http.MethodGet, u, nil, nil, &registryOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var sr registrySearchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
+		return nil, err
+	}
+
+	models := make([]api.SearchModel, 0, len(sr.Models))
+	for _, m := range sr.Models {
+		models = append(models, api.SearchModel{
+			Name:           m.Name,
+			Description:    m.Description,
+			Tags:           m.Tags,
+			ParameterSizes: m.ParameterSizes,
+			Quantizations:  m.Quantizations,
+			Capabilities:   m.Capabilities,
+			Pulls:          m.Pulls,
+			UpdatedAt:      m.UpdatedAt,
+		})
+	}
+
+	return models, nil
+}
+
+func hasCapabilities(m api.SearchModel, capabilities []string) bool {
+	for _, c := range capabilities {
+		if !slices.ContainsFunc(m.Capabilities, func(s string) bool { return strings.EqualFold(s, c) }) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// filterSearch returns the models with every capability in capabilities and
+// a parameter size of at most maxSize, unless it's zero. The parameter sizes
+// of the models returned are those within maxSize.
+func filterSearch(models []api.SearchModel, capabilities []string, maxSize uint64, limit int) []api.SearchModel {
+	filtered := []api.SearchModel{}
+	for _, m := range models {
+		if limit > 0 && len(filtered) == limit {
+			break
+		}
+
+		if !hasCapabilities(m, capabilities) {
+			continue
+		}
+
+		if maxSize > 0 {
+			m.ParameterSizes = slices.DeleteFunc(slices.Clone(m.ParameterSizes), func(s string) bool {
+				n, err := format.ParseNumber(s)
+				return err != nil || n > maxSize
+			})
+
+			if len(m.ParameterSizes) == 0 {
+				continue
+			}
+		}
+
+		filtered = append(filtered, m)
+	}
+
+	return filtered
+}
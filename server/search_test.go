@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestSearch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query().Get("q"); q != "llama" {
+			http.Error(w, "unexpected query "+q, http.StatusBadRequest)
+			return
+		}
+
+		w.Write([]byte(`{"models": [
+			{"name": "llama3.2", "parameter_sizes": ["1b", "3b"], "capabilities": ["tools"], "pulls": 100},
+			{"name": "llama3.2-vision", "parameter_sizes": ["11b", "90b"], "capabilities": ["Vision"]},
+			{"name": "llava-llama3", "parameter_sizes": ["8b"], "capabilities": ["vision"]}
+		]}`))
+	}))
+	defer registry.Close()
+
+	searchURL = registry.URL + "/api/search"
+	t.Cleanup(func() { searchURL = "https://ollama.com/api/search" })
+
+	cases := []struct {
+		name   string
+		req    api.SearchRequest
+		expect []string
+	}{
+		{"all", api.SearchRequest{Query: "llama"}, []string{"llama3.2", "llama3.2-vision", "llava-llama3"}},
+		{"capability", api.SearchRequest{Query: "llama", Capabilities: []string{"vision"}}, []string{"llama3.2-vision", "llava-llama3"}},
+		{"max size", api.SearchRequest{Query: "llama", MaxSize: "8B"}, []string{"llama3.2", "llava-llama3"}},
+		{"limit", api.SearchRequest{Query: "llama", Limit: 1}, []string{"llama3.2"}},
+		{"no match", api.SearchRequest{Query: "llama", Capabilities: []string{"vision", "tools"}}, []string{}},
+	}
+
+	var s Server
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.SearchHandler, tt.req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+			}
+
+			var resp api.SearchResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+
+			names := []string{}
+			for _, m := range resp.Models {
+				names = append(names, m.Name)
+			}
+
+			if diff := cmp.Diff(tt.expect, names); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("max size trims sizes", func(t *testing.T) {
+		w := createRequest(t, s.SearchHandler, api.SearchRequest{Query: "llama", MaxSize: "2b", Limit: 1})
+
+		var resp api.SearchResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Models) != 1 || !cmp.Equal(resp.Models[0].ParameterSizes, []string{"1b"}) || resp.Models[0].Pulls != 100 {
+			t.Errorf("unexpected models %+v", resp.Models)
+		}
+	})
+
+	t.Run("invalid max size", func(t *testing.T) {
+		w := createRequest(t, s.SearchHandler, api.SearchRequest{Query: "llama", MaxSize: "big"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status code 400, actual %d", w.Code)
+		}
+	})
+
+	t.Run("registry error", func(t *testing.T) {
+		w := createRequest(t, s.SearchHandler, api.SearchRequest{Query: "other"})
+		if w.Code != http.StatusBadGateway {
+			t.Errorf("expected status code 502, actual %d", w.Code)
+		}
+	})
+}
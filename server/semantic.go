@@ -0,0 +1,204 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+// maxSemanticEntries limits the prompts kept by the semantic cache, whose
+// embeddings are compared with each request's.
+const maxSemanticEntries = 10000
+
+// semanticPrompt is the prompt of a request, embedded, and the hash of the
+// rest of the request, which must be the same for a cached response to be
+// returned for it.
+type semanticPrompt struct {
+	params    string
+	embedding []float32
+}
+
+type semanticEntry struct {
+	semanticPrompt
+
+	// key is the key of the response in the response cache
+	key     string
+	expires time.Time
+}
+
+// semanticCache finds the cached responses to prompts similar to those of
+// requests, with OLLAMA_SEMANTIC_CACHE. The responses themselves are kept
+// in the response cache.
+type semanticCache struct {
+	mu      sync.Mutex
+	entries []semanticEntry
+
+	// embed embeds prompts, with the embedding model OLLAMA_SEMANTIC_CACHE
+	// if it isn't set
+	embed func(ctx context.Context, prompt string) ([]float32, error)
+}
+
+// find returns the key of the response to the prompt most similar to p with
+// the same parameters, and how similar they are, if any are similar enough.
+func (sc *semanticCache) find(p *semanticPrompt) (string, float32) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	threshold := float32(envconfig.SemanticThreshold())
+	now := time.Now()
+
+	var key string
+	var best float32
+	live := sc.entries[:0]
+	for _, e := range sc.entries {
+		if now.After(e.expires) {
+			continue
+		}
+		live = append(live, e)
+
+		if e.params != p.params {
+			continue
+		}
+
+		// embeddings are normalized, so this is their cosine similarity
+		if similarity := dot(e.embedding, p.embedding); similarity >= threshold && similarity > best {
+			key, best = e.key, similarity
+		}
+	}
+	clear(sc.entries[len(live):])
+	sc.entries = live
+
+	return key, best
+}
+
+func (sc *semanticCache) add(p *semanticPrompt, key string, expires time.Time) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if len(sc.entries) >= maxSemanticEntries {
+		sc.entries = sc.entries[1:]
+	}
+
+	sc.entries = append(sc.entries, semanticEntry{semanticPrompt: *p, key: key, expires: expires})
+}
+
+// semanticPrompt returns the prompt of a generate or chat request, to the
+// model with the digest in scope, embedded. Prompts are only compared to
+// others of the same scope, which is the digest scoped to the tenant of the
+// request. It returns nil for requests without a prompt to compare, such as
+// those with images or ending in a message which isn't from the user.
+func (s *Server) semanticPrompt(ctx context.Context, path, scope string, body []byte) (*semanticPrompt, error) {
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+
+	var req map[string]any
+	if err := d.Decode(&req); err != nil {
+		return nil, err
+	}
+
+	// keep_alive doesn't change the response
+	delete(req, "keep_alive")
+
+	var prompt string
+	switch path {
+	case "/api/generate":
+		if _, ok := req["images"]; ok {
+			return nil, nil
+		}
+
+		prompt, _ = req["prompt"].(string)
+		delete(req, "prompt")
+	case "/api/chat":
+		messages, _ := req["messages"].([]any)
+		if len(messages) == 0 {
+			return nil, nil
+		}
+
+		last, _ := messages[len(messages)-1].(map[string]any)
+		if last == nil || last["role"] != "user" || last["images"] != nil || last["audio"] != nil {
+			return nil, nil
+		}
+
+		prompt, _ = last["content"].(string)
+		delete(last, "content")
+	default:
+		return nil, nil
+	}
+
+	if prompt == "" {
+		return nil, nil
+	}
+
+	rest, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := requestHash(path, rest)
+	if err != nil {
+		return nil, err
+	}
+
+	embed := s.prompts.embed
+	if embed == nil {
+		embed = s.embedPrompt
+	}
+
+	embedding, err := embed(ctx, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("embedding prompt for the semantic cache: %w", err)
+	}
+
+	// prompts embedded by another model can't be compared
+	params := scope + ":" + envconfig.SemanticCache() + ":" + hash
+	return &semanticPrompt{params: params, embedding: normalize(embedding)}, nil
+}
+
+// embedPrompt embeds prompt with the embedding model OLLAMA_SEMANTIC_CACHE.
+func (s *Server) embedPrompt(ctx context.Context, prompt string) ([]float32, error) {
+	name, err := getExistingName(model.ParseName(envconfig.SemanticCache()))
+	if err != nil {
+		return nil, err
+	}
+
+	name, _, err = resolveAlias(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r, m, _, err := s.scheduleRunner(ctx, name.String(), "", []Capability{}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Embedding(ctx, prompt, m.AdapterPaths)
+}
+
+// markCacheHit sets cache_hit on each of the JSON objects of a response,
+// which are on lines of their own if it was streamed.
+func markCacheHit(body []byte) []byte {
+	var b bytes.Buffer
+	for line := range bytes.Lines(body) {
+		trimmed := bytes.TrimSpace(line)
+		if !bytes.HasPrefix(trimmed, []byte("{")) || !bytes.HasSuffix(trimmed, []byte("}")) || !json.Valid(trimmed) {
+			b.Write(line)
+			continue
+		}
+
+		// add the field to the end, keeping the order of the others
+		b.Write(trimmed[:len(trimmed)-1])
+		if len(bytes.TrimSpace(trimmed[1:len(trimmed)-1])) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`"cache_hit":true}`)
+		b.Write(line[len(bytes.TrimRight(line, "\r\n")):])
+	}
+
+	return b.Bytes()
+}
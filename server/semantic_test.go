@@ -0,0 +1,266 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+func TestSemanticCache(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_RESPONSE_CACHE_TTL", "1h")
+	t.Setenv("OLLAMA_SEMANTIC_CACHE", "all-minilm")
+	t.Setenv("OLLAMA_SEMANTIC_THRESHOLD", "0.9")
+
+	embeddings := map[string][]float32{
+		"Why is the sky blue?":      {1, 0, 0},
+		"why is the sky blue":       {0.95, 0.1, 0},
+		"What makes the sky blue?":  {0.8, 0.6, 0},
+		"How do I make bread?":      {0, 0, 1},
+		"How do I make sourdough?":  {0, 0.3, 0.95},
+		"Tell me a story about sky": {0.7, 0.7, 0},
+	}
+
+	var s Server
+	s.prompts.embed = func(_ context.Context, prompt string) ([]float32, error) {
+		if e, ok := embeddings[prompt]; ok {
+			return e, nil
+		}
+		return nil, fmt.Errorf("no embedding for %q", prompt)
+	}
+
+	_, digest := createBinFile(t, ggml.KV{"general.architecture": "llama"}, nil)
+	if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"file.gguf": digest},
+		Stream: &stream,
+	}); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var calls int
+	r := gin.New()
+	handler := func(c *gin.Context) {
+		calls++
+		c.Data(http.StatusOK, "application/x-ndjson", []byte(fmt.Sprintf("{\"response\":\"answer %d\",\"done\":true}\n", calls)))
+	}
+	r.POST("/api/generate", s.cacheMiddleware(), handler)
+	r.POST("/api/chat", s.cacheMiddleware(), handler)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+		return w
+	}
+
+	post("/api/generate", `{"model":"test","prompt":"Why is the sky blue?"}`)
+
+	w := post("/api/generate", `{"model":"test","prompt":"why is the sky blue","keep_alive":"1m"}`)
+	if w.Header().Get("X-Cache") != "hit" || w.Header().Get("X-Cache-Similarity") == "" {
+		t.Errorf("expected a hit for a similar prompt, got %q", w.Header().Get("X-Cache"))
+	}
+
+	if got, want := w.Body.String(), "{\"response\":\"answer 1\",\"done\":true,\"cache_hit\":true}\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	for _, body := range []string{
+		// not similar enough
+		`{"model":"test","prompt":"What makes the sky blue?"}`,
+		// the same prompt with other options
+		`{"model":"test","prompt":"Why is the sky blue?","options":{"num_predict":10}}`,
+		// prompts which can't be embedded aren't cached
+		`{"model":"test","prompt":"Something else"}`,
+	} {
+		if w := post("/api/generate", body); w.Header().Get("X-Cache") == "hit" {
+			t.Errorf("%s: expected a miss", body)
+		}
+	}
+
+	if calls != 4 {
+		t.Errorf("expected the handler to be called 4 times, got %d", calls)
+	}
+
+	t.Run("chat", func(t *testing.T) {
+		post("/api/chat", `{"model":"test","messages":[{"role":"user","content":"How do I make bread?"}]}`)
+
+		w := post("/api/chat", `{"model":"test","messages":[{"role":"user","content":"How do I make sourdough?"}]}`)
+		if w.Header().Get("X-Cache") != "hit" {
+			t.Errorf("expected a hit for a similar message")
+		}
+
+		// a different conversation before the last message
+		w = post("/api/chat", `{"model":"test","messages":[{"role":"user","content":"Tell me a story about sky"},{"role":"assistant","content":"..."},{"role":"user","content":"How do I make bread?"}]}`)
+		if w.Header().Get("X-Cache") == "hit" {
+			t.Errorf("expected a miss for another conversation")
+		}
+	})
+}
+
+func TestSemanticCacheTenants(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_API_KEYS", "a=team-a,b=team-b")
+	t.Setenv("OLLAMA_RESPONSE_CACHE_TTL", "1h")
+	t.Setenv("OLLAMA_SEMANTIC_CACHE", "all-minilm")
+	t.Setenv("OLLAMA_SEMANTIC_THRESHOLD", "0.9")
+
+	embeddings := map[string][]float32{
+		"What is the balance of account 1234?": {1, 0, 0},
+		"What is the balance of account 1235?": {0.99, 0.1, 0},
+	}
+
+	var s Server
+	s.prompts.embed = func(_ context.Context, prompt string) ([]float32, error) {
+		if e, ok := embeddings[prompt]; ok {
+			return e, nil
+		}
+		return nil, fmt.Errorf("no embedding for %q", prompt)
+	}
+
+	// the model is shared, so both tenants can use it
+	_, digest := createBinFile(t, ggml.KV{"general.architecture": "llama"}, nil)
+	if w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model:  "test",
+		Files:  map[string]string{"file.gguf": digest},
+		Stream: &stream,
+	}); w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	var calls int
+	r := gin.New()
+	r.Use(tenantMiddleware())
+	r.POST("/api/generate", s.cacheMiddleware(), func(c *gin.Context) {
+		calls++
+		c.Data(http.StatusOK, "application/x-ndjson", []byte(fmt.Sprintf("{\"response\":\"answer %d for %s\",\"done\":true}\n", calls, c.GetString(tenantKey))))
+	})
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+		return w
+	}
+
+	for _, body := range []string{
+		`{"model":"test","prompt":"What is the balance of account 1234?"}`,
+		`{"model":"test","prompt":"What is the balance of account 1234?","options":{"temperature":0}}`,
+	} {
+		post("a", body)
+	}
+
+	for _, body := range []string{
+		// a similar prompt
+		`{"model":"test","prompt":"What is the balance of account 1235?"}`,
+		// an identical deterministic request
+		`{"model":"test","prompt":"What is the balance of account 1234?","options":{"temperature":0}}`,
+	} {
+		w := post("b", body)
+		if w.Header().Get("X-Cache") == "hit" || strings.Contains(w.Body.String(), "team-a") {
+			t.Errorf("%s: expected a miss for another tenant, got %s", body, w.Body)
+		}
+	}
+
+	// each tenant still gets its own cached responses
+	w := post("b", `{"model":"test","prompt":"What is the balance of account 1234?"}`)
+	if w.Header().Get("X-Cache") != "hit" || !strings.Contains(w.Body.String(), "team-b") {
+		t.Errorf("expected a hit for the tenant's own similar prompt, got %s", w.Body)
+	}
+
+	if calls != 4 {
+		t.Errorf("expected the handler to be called 4 times, got %d", calls)
+	}
+}
+
+func TestSemanticPrompt(t *testing.T) {
+	t.Setenv("OLLAMA_SEMANTIC_CACHE", "all-minilm")
+
+	var prompts []string
+	var s Server
+	s.prompts.embed = func(_ context.Context, prompt string) ([]float32, error) {
+		prompts = append(prompts, prompt)
+		return []float32{3, 4}, nil
+	}
+
+	cases := []struct {
+		path, body string
+		prompt     string
+	}{
+		{"/api/generate", `{"model":"test","prompt":"hi"}`, "hi"},
+		{"/api/generate", `{"model":"test","prompt":""}`, ""},
+		{"/api/generate", `{"model":"test","prompt":"hi","images":["aGk="]}`, ""},
+		{"/api/chat", `{"model":"test","messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"}]}`, "hi"},
+		{"/api/chat", `{"model":"test","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}]}`, ""},
+		{"/api/chat", `{"model":"test","messages":[{"role":"user","content":"what's this?","images":["aGk="]}]}`, ""},
+		{"/api/chat", `{"model":"test","messages":[]}`, ""},
+		{"/api/embed", `{"model":"test","input":"hi"}`, ""},
+	}
+
+	for _, tt := range cases {
+		prompts = nil
+		p, err := s.semanticPrompt(t.Context(), tt.path, "sha256:abc", []byte(tt.body))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.body, err)
+		}
+
+		if tt.prompt == "" {
+			if p != nil || len(prompts) > 0 {
+				t.Errorf("%s: expected no prompt, got %v", tt.body, prompts)
+			}
+			continue
+		}
+
+		if len(prompts) != 1 || prompts[0] != tt.prompt {
+			t.Errorf("%s: expected the prompt %q, got %v", tt.body, tt.prompt, prompts)
+		}
+
+		if p.embedding[0] != 0.6 || p.embedding[1] != 0.8 {
+			t.Errorf("%s: expected a normalized embedding, got %v", tt.body, p.embedding)
+		}
+	}
+
+	a, _ := s.semanticPrompt(t.Context(), "/api/generate", "sha256:abc", []byte(`{"model":"test","prompt":"hi","keep_alive":"5m"}`))
+	b, _ := s.semanticPrompt(t.Context(), "/api/generate", "sha256:abc", []byte(`{"model":"test","prompt":"bye"}`))
+	c, _ := s.semanticPrompt(t.Context(), "/api/generate", "sha256:def", []byte(`{"model":"test","prompt":"hi"}`))
+	if a.params != b.params || a.params == c.params {
+		t.Errorf("expected the parameters to depend on the model but not the prompt or keep_alive")
+	}
+}
+
+func TestMarkCacheHit(t *testing.T) {
+	cases := map[string]string{
+		`{"response":"a","done":true}`:            `{"response":"a","done":true,"cache_hit":true}`,
+		"{\"response\":\"a\"}\n{\"done\":true}\n": "{\"response\":\"a\",\"cache_hit\":true}\n{\"done\":true,\"cache_hit\":true}\n",
+		"{}\r\n":          "{\"cache_hit\":true}\r\n",
+		"not json\n":      "not json\n",
+		`{"response":"}"`: `{"response":"}"`,
+	}
+
+	for body, want := range cases {
+		if got := markCacheHit([]byte(body)); !bytes.Equal(got, []byte(want)) {
+			t.Errorf("%q: expected %q, got %q", body, want, got)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// loadedModel is a model which was loaded when the server shut down, so
+// that it's loaded again when the server starts.
+type loadedModel struct {
+	Model  string `json:"model"`
+	NumCtx int    `json:"num_ctx,omitempty"`
+	// KeepAlive is how much longer the model would have stayed loaded.
+	KeepAlive time.Duration `json:"keep_alive"`
+}
+
+func schedulerStatePath() string {
+	return filepath.Join(envconfig.Models(), "scheduler-state.json")
+}
+
+// loadedModels returns the models the scheduler has loaded, skipping those
+// still loading or about to be unloaded.
+func (s *Scheduler) loadedModels() []loadedModel {
+	s.loadedMu.Lock()
+	defer s.loadedMu.Unlock()
+
+	var models []loadedModel
+	for _, runner := range s.loaded {
+		runner.refMu.Lock()
+		if runner.model != nil && !runner.loading.Load() && runner.sessionDuration > 0 {
+			m := loadedModel{Model: runner.model.Name, KeepAlive: runner.sessionDuration}
+			if runner.refCount == 0 && !runner.expiresAt.IsZero() {
+				m.KeepAlive = time.Until(runner.expiresAt)
+			}
+
+			if runner.Options != nil && runner.numParallel > 0 {
+				m.NumCtx = runner.Options.NumCtx / runner.numParallel
+			}
+
+			if m.KeepAlive > 0 {
+				models = append(models, m)
+			}
+		}
+		runner.refMu.Unlock()
+	}
+
+	return models
+}
+
+// saveSchedulerState records models to be loaded when the server starts.
+func saveSchedulerState(models []loadedModel) error {
+	p := schedulerStatePath()
+	if len(models) == 0 {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	bts, err := json.Marshal(models)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(p+".tmp", bts, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(p+".tmp", p)
+}
+
+// takeSchedulerState returns the models recorded by saveSchedulerState and
+// removes them, so they're only loaded by the first start after a shutdown.
+func takeSchedulerState() ([]loadedModel, error) {
+	p := schedulerStatePath()
+	bts, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := os.Remove(p); err != nil {
+		return nil, err
+	}
+
+	var models []loadedModel
+	if err := json.Unmarshal(bts, &models); err != nil {
+		return nil, err
+	}
+
+	return models, nil
+}
+
+// restoreModels loads the models which were loaded when the server last
+// shut down, for as long as they had left to stay loaded.
+func (s *Server) restoreModels(ctx context.Context) {
+	models, err := takeSchedulerState()
+	if err != nil {
+		slog.Warn("failed to read models loaded before shutdown", "error", err)
+		return
+	}
+
+	for _, m := range models {
+		if ctx.Err() != nil {
+			return
+		}
+
+		var opts map[string]any
+		if m.NumCtx > 0 {
+			opts = map[string]any{"num_ctx": m.NumCtx}
+		}
+
+		// the runner is released as soon as it's loaded so it can expire
+		loadCtx, cancel := context.WithCancel(ctx)
+		_, _, _, err := s.scheduleRunner(loadCtx, m.Model, "", nil, opts, &api.Duration{Duration: m.KeepAlive})
+		cancel()
+		if err != nil {
+			slog.Warn("failed to reload model loaded before shutdown", "model", m.Model, "error", err)
+			continue
+		}
+
+		slog.Info("reloaded model loaded before shutdown", "model", m.Model, "keep_alive", m.KeepAlive)
+	}
+}
+
+// drain shuts srvr down once requests in flight finish or the drain timeout
+// passes, whichever is first, then unloads the scheduler's models. Another
+// signal on signals cuts the wait short.
+func (s *Server) drain(srvr *http.Server, signals <-chan os.Signal) {
+	s.draining.Store(true)
+
+	timeout := envconfig.DrainTimeout()
+	slog.Info("shutting down, waiting for requests to finish", "timeout", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-signals:
+			slog.Info("shutting down now")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := srvr.Shutdown(ctx); err != nil {
+		slog.Warn("cutting off requests which didn't finish", "error", err)
+		srvr.Close()
+	}
+
+	if err := s.uses.flush(); err != nil {
+		slog.Warn("failed to record model use", "error", err)
+	}
+
+	if s.sched == nil {
+		return
+	}
+
+	if err := saveSchedulerState(s.sched.loadedModels()); err != nil {
+		slog.Warn("failed to record loaded models", "error", err)
+	}
+}
+
+// ShutdownHandler shuts the server down gracefully, like a SIGTERM. Only
+// clients on the same host may shut the server down.
+func (s *Server) ShutdownHandler(c *gin.Context) {
+	if !isLocalRequest(c) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "shutdown is only allowed from localhost"})
+		return
+	}
+
+	if s.shutdown == nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "server can't be shut down"})
+		return
+	}
+
+	s.shutdownOnce.Do(func() { close(s.shutdown) })
+	c.JSON(http.StatusAccepted, gin.H{"status": "shutting down"})
+}
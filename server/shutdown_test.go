@@ -0,0 +1,197 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestSchedulerState(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	s := &Scheduler{loaded: map[string]*runnerRef{
+		"idle": {
+			model:           &Model{Name: "registry.ollama.ai/library/idle:latest"},
+			sessionDuration: 5 * time.Minute,
+			expiresAt:       time.Now().Add(time.Minute),
+			numParallel:     2,
+			Options:         &api.Options{Runner: api.Runner{NumCtx: 8192}},
+		},
+		"busy": {
+			model:           &Model{Name: "registry.ollama.ai/library/busy:latest"},
+			refCount:        1,
+			sessionDuration: 5 * time.Minute,
+		},
+		"loading": {
+			model:           &Model{Name: "registry.ollama.ai/library/loading:latest"},
+			sessionDuration: 5 * time.Minute,
+		},
+		"expired": {
+			model:           &Model{Name: "registry.ollama.ai/library/expired:latest"},
+			sessionDuration: 5 * time.Minute,
+			expiresAt:       time.Now().Add(-time.Second),
+		},
+		"unloading": {
+			model: &Model{Name: "registry.ollama.ai/library/unloading:latest"},
+		},
+	}}
+
+	s.loaded["loading"].loading.Store(true)
+
+	if err := saveSchedulerState(s.loadedModels()); err != nil {
+		t.Fatal(err)
+	}
+
+	models, err := takeSchedulerState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	within := cmpopts.EquateApprox(0, float64(time.Second))
+	sortModels := cmpopts.SortSlices(func(a, b loadedModel) bool { return a.Model < b.Model })
+	if diff := cmp.Diff([]loadedModel{
+		{Model: "registry.ollama.ai/library/busy:latest", KeepAlive: 5 * time.Minute},
+		{Model: "registry.ollama.ai/library/idle:latest", NumCtx: 4096, KeepAlive: time.Minute},
+	}, models, sortModels, cmp.Transformer("duration", func(d time.Duration) float64 { return float64(d) }), within); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	// the state is only restored once
+	if models, err := takeSchedulerState(); err != nil || models != nil {
+		t.Errorf("expected no models to be restored again, got %v %v", models, err)
+	}
+
+	// nothing loaded removes the state
+	if err := saveSchedulerState(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(schedulerStatePath()); !os.IsNotExist(err) {
+		t.Errorf("expected no state to be recorded, got %v", err)
+	}
+}
+
+func TestShutdownHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	shutdown := func(s *Server, remoteAddr string) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/shutdown", nil)
+		c.Request.RemoteAddr = remoteAddr
+		s.ShutdownHandler(c)
+		return w.Code
+	}
+
+	s := &Server{shutdown: make(chan struct{})}
+
+	if code := shutdown(s, "192.168.1.10:50000"); code != http.StatusForbidden {
+		t.Errorf("expected status code 403, actual %d", code)
+	}
+
+	select {
+	case <-s.shutdown:
+		t.Fatal("expected a remote client not to shut the server down")
+	default:
+	}
+
+	for _, addr := range []string{"127.0.0.1:50000", "[::1]:50000"} {
+		if code := shutdown(s, addr); code != http.StatusAccepted {
+			t.Errorf("%s: expected status code 202, actual %d", addr, code)
+		}
+	}
+
+	select {
+	case <-s.shutdown:
+	default:
+		t.Fatal("expected the server to shut down")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_DRAIN_TIMEOUT", "5s")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srvr := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})}
+	go srvr.Serve(ln)
+
+	type result struct {
+		body string
+		err  error
+	}
+
+	resp := make(chan result, 1)
+	go func() {
+		r, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			resp <- result{err: err}
+			return
+		}
+		defer r.Body.Close()
+
+		bts, err := io.ReadAll(r.Body)
+		resp <- result{string(bts), err}
+	}()
+
+	<-started
+
+	drained := make(chan struct{})
+	go func() {
+		(&Server{}).drain(srvr, nil)
+		close(drained)
+	}()
+
+	// new connections are refused while the request in flight finishes
+	var refused bool
+	for range 100 {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			refused = true
+			break
+		}
+		conn.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !refused {
+		t.Error("expected new connections to be refused while draining")
+	}
+
+	select {
+	case <-drained:
+		t.Fatal("expected drain to wait for the request in flight")
+	default:
+	}
+
+	close(release)
+	if r := <-resp; r.err != nil || r.body != "done" {
+		t.Errorf("expected the request in flight to finish, got %q %v", r.body, r.err)
+	}
+
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected drain to finish once the request in flight finished")
+	}
+}
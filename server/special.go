@@ -0,0 +1,79 @@
+package server
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/ollama/ollama/api"
+)
+
+// specialTokensCache is the special tokens of models by the path of their
+// file, as reading the vocabulary of a model is slow.
+var specialTokensCache struct {
+	mu     sync.Mutex
+	tokens map[string][]string
+}
+
+// specialTokens returns the text of the control tokens of the model at path,
+// which are only encoded as such if prompts may contain special tokens.
+// They're sorted from longest to shortest.
+func specialTokens(path string) ([]string, error) {
+	specialTokensCache.mu.Lock()
+	defer specialTokensCache.mu.Unlock()
+
+	if tokens, ok := specialTokensCache.tokens[path]; ok {
+		return tokens, nil
+	}
+
+	kv, _, err := getModelData(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []string
+	types := kv.Uints("tokenizer.ggml.token_type")
+	for i, token := range kv.Strings("tokenizer.ggml.tokens") {
+		// unknown and control tokens, as the tokenizer only parses these if
+		// the prompt may contain special tokens
+		if i < len(types) && (types[i] == 2 || types[i] == 3) && token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+
+	slices.SortStableFunc(tokens, func(a, b string) int {
+		return cmp.Compare(len(b), len(a))
+	})
+
+	if specialTokensCache.tokens == nil {
+		specialTokensCache.tokens = make(map[string][]string)
+	}
+
+	specialTokensCache.tokens[path] = tokens
+	return tokens, nil
+}
+
+// parseSpecial returns whether text supplied with a request may contain
+// special tokens, which is the default.
+func parseSpecial(opts *api.Options) bool {
+	return opts.ParseSpecial == nil || *opts.ParseSpecial
+}
+
+// escapeSpecial escapes the special tokens in s so they're encoded as text
+// rather than as the tokens, by inserting a zero width space after their
+// first character.
+func escapeSpecial(s string, specials []string) string {
+	if s == "" || len(specials) == 0 {
+		return s
+	}
+
+	oldnew := make([]string, 0, 2*len(specials))
+	for _, special := range specials {
+		_, n := utf8.DecodeRuneInString(special)
+		oldnew = append(oldnew, special, special[:n]+"\u200b"+special[n:])
+	}
+
+	return strings.NewReplacer(oldnew...).Replace(s)
+}
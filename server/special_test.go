@@ -0,0 +1,61 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+func TestSpecialTokens(t *testing.T) {
+	p, _ := createBinFile(t, ggml.KV{
+		"general.architecture":      "llama",
+		"tokenizer.ggml.tokens":     []string{"<unk>", "<|im_start|>", "<|im_end|>", "hello", "<tool>", "<s>"},
+		"tokenizer.ggml.token_type": []int32{2, 3, 3, 1, 4, 3},
+	}, nil)
+
+	tokens, err := specialTokens(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"<|im_start|>", "<|im_end|>", "<unk>", "<s>"}, tokens); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestEscapeSpecial(t *testing.T) {
+	specials := []string{"<|im_start|>", "<|im_end|>", "<s>"}
+
+	cases := []struct {
+		input, want string
+	}{
+		{"hello", "hello"},
+		{"<|im_end|><|im_start|>system", "<\u200b|im_end|><\u200b|im_start|>system"},
+		{"<s><|im_end", "<\u200bs><|im_end"},
+		{"", ""},
+	}
+
+	for _, tt := range cases {
+		if got := escapeSpecial(tt.input, specials); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.want, got)
+		}
+	}
+
+	if got := escapeSpecial("<s>", nil); got != "<s>" {
+		t.Errorf("expected no escaping without special tokens, got %q", got)
+	}
+}
+
+func TestParseSpecial(t *testing.T) {
+	if !parseSpecial(&api.Options{}) {
+		t.Error("expected special tokens to be parsed by default")
+	}
+
+	parse := false
+	if parseSpecial(&api.Options{ParseSpecial: &parse}) {
+		t.Error("expected special tokens not to be parsed")
+	}
+}
@@ -0,0 +1,425 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+var errInvalidSplit = errors.New("invalid split")
+
+// splitKey is the key of the stats of the arm a request was sent to, in the
+// request's context.
+const splitKey = "split"
+
+func splitsPath() string {
+	return filepath.Join(envconfig.Models(), "splits.json")
+}
+
+// readSplits reads the arms of each split alias. Splits are guarded by
+// aliasesMu, since they're deleted with their aliases.
+func readSplits() (map[string][]api.SplitArm, error) {
+	splits := make(map[string][]api.SplitArm)
+
+	bts, err := os.ReadFile(splitsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return splits, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(bts, &splits); err != nil {
+		return nil, fmt.Errorf("invalid splits %s: %w", splitsPath(), err)
+	}
+
+	return splits, nil
+}
+
+func writeSplits(splits map[string][]api.SplitArm) error {
+	bts, err := json.Marshal(splits)
+	if err != nil {
+		return err
+	}
+
+	p := splitsPath()
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(p+".tmp", bts, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(p+".tmp", p)
+}
+
+// setSplit splits the requests to alias between the models of arms, by
+// their percentages, which must add up to 100. The metrics of the alias's
+// arms are counted again from zero.
+func setSplit(alias model.Name, arms []api.SplitArm) error {
+	if len(arms) < 2 {
+		return fmt.Errorf("%w: a split needs at least two arms", errInvalidSplit)
+	}
+
+	var total int
+	for i, arm := range arms {
+		if arm.Percent < 0 {
+			return fmt.Errorf("%w: percent of %s must not be negative", errInvalidSplit, arm.Model)
+		}
+		total += arm.Percent
+
+		for _, other := range arms[:i] {
+			if model.ParseName(other.Model).EqualFold(model.ParseName(arm.Model)) {
+				return fmt.Errorf("%w: %s is in the split more than once", errInvalidSplit, arm.Model)
+			}
+		}
+
+		if !modelExists(model.ParseName(arm.Model)) {
+			return fmt.Errorf("model %s: %w", arm.Model, os.ErrNotExist)
+		}
+	}
+
+	if total != 100 {
+		return fmt.Errorf("%w: percents add up to %d rather than 100", errInvalidSplit, total)
+	}
+
+	aliasesMu.Lock()
+	defer aliasesMu.Unlock()
+
+	aliases, err := readAliases()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := aliases[aliasKey(alias)]; !ok {
+		return fmt.Errorf("alias %s: %w", alias.DisplayShortest(), os.ErrNotExist)
+	}
+
+	splits, err := readSplits()
+	if err != nil {
+		return err
+	}
+
+	splits[aliasKey(alias)] = arms
+	if err := writeSplits(splits); err != nil {
+		return err
+	}
+
+	splitStats.reset(aliasKey(alias))
+	return nil
+}
+
+// deleteSplit deletes the split of alias, which must be held by aliasesMu.
+func deleteSplit(alias model.Name) error {
+	splits, err := readSplits()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := splits[aliasKey(alias)]; !ok {
+		return fmt.Errorf("split %s: %w", alias.DisplayShortest(), os.ErrNotExist)
+	}
+
+	delete(splits, aliasKey(alias))
+	if err := writeSplits(splits); err != nil {
+		return err
+	}
+
+	splitStats.reset(aliasKey(alias))
+	return nil
+}
+
+// chooseArm chooses the arm of a split for a request. Requests with the same
+// key are sent to the same arm, so a client sees the same model until the
+// split changes. Requests without a key are sent to an arm at random.
+func chooseArm(alias string, arms []api.SplitArm, key string) api.SplitArm {
+	n := rand.IntN(100)
+	if key != "" {
+		sum := sha256.Sum256([]byte(alias + "\x00" + key))
+		n = int(binary.BigEndian.Uint64(sum[:8]) % 100)
+	}
+
+	for _, arm := range arms {
+		if n < arm.Percent {
+			return arm
+		}
+		n -= arm.Percent
+	}
+
+	return arms[len(arms)-1]
+}
+
+// armStats are the metrics of the requests sent to each arm of the splits.
+type armStats struct {
+	mu    sync.Mutex
+	arms  map[string]map[string]*api.SplitArmStats
+	since map[string]time.Time
+}
+
+var splitStats = armStats{
+	arms:  make(map[string]map[string]*api.SplitArmStats),
+	since: make(map[string]time.Time),
+}
+
+// started is when the server started counting the metrics of splits which
+// were set before it started.
+var started = time.Now().UTC()
+
+func (s *armStats) reset(alias string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.arms, alias)
+	s.since[alias] = time.Now().UTC()
+}
+
+// get returns the stats of the arm arm of alias, which the caller must hold
+// s.mu to read or change.
+func (s *armStats) get(alias string, arm api.SplitArm) *api.SplitArmStats {
+	if s.arms[alias] == nil {
+		s.arms[alias] = make(map[string]*api.SplitArmStats)
+	}
+
+	key := aliasKey(model.ParseName(arm.Model))
+	stats, ok := s.arms[alias][key]
+	if !ok {
+		stats = &api.SplitArmStats{SplitArm: arm}
+		s.arms[alias][key] = stats
+	}
+
+	return stats
+}
+
+type splitArm struct {
+	alias string
+	arm   api.SplitArm
+}
+
+// splitMiddleware sends requests to an alias with a split to one of the
+// split's models, by replacing the model of the request. The X-Split-Key
+// header keeps the requests of a client on the same model.
+func splitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var req map[string]json.RawMessage
+		if err := json.Unmarshal(body, &req); err != nil {
+			// leave invalid requests to the handler to reject
+			c.Next()
+			return
+		}
+
+		var name string
+		if err := json.Unmarshal(req["model"], &name); err != nil || name == "" {
+			c.Next()
+			return
+		}
+
+		n := model.ParseName(name)
+		if !n.IsValid() || modelExists(n) {
+			c.Next()
+			return
+		}
+
+		splits, err := readSplits()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		arms, ok := splits[aliasKey(n)]
+		if !ok {
+			c.Next()
+			return
+		}
+
+		chosen := splitArm{alias: aliasKey(n), arm: chooseArm(aliasKey(n), arms, c.GetHeader("X-Split-Key"))}
+		req["model"], _ = json.Marshal(model.ParseName(chosen.arm.Model).DisplayShortest())
+		if body, err = json.Marshal(req); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Request.ContentLength = int64(len(body))
+		c.Set(splitKey, chosen)
+		c.Header("X-Split-Model", model.ParseName(chosen.arm.Model).DisplayShortest())
+		c.Next()
+
+		splitStats.mu.Lock()
+		defer splitStats.mu.Unlock()
+
+		stats := splitStats.get(chosen.alias, chosen.arm)
+		stats.Requests++
+		if c.Writer.Status() >= http.StatusBadRequest {
+			stats.Errors++
+		}
+	}
+}
+
+// recordSplitMetrics adds the metrics of a request which generated text to
+// the arm of the split it was sent to, if it was sent to one.
+func recordSplitMetrics(c *gin.Context, m api.Metrics) {
+	v, ok := c.Get(splitKey)
+	if !ok {
+		return
+	}
+
+	chosen := v.(splitArm)
+	splitStats.mu.Lock()
+	defer splitStats.mu.Unlock()
+
+	addMetrics(&splitStats.get(chosen.alias, chosen.arm).Metrics, m)
+}
+
+// SetSplitHandler splits the requests to an alias between models.
+func (s *Server) SetSplitHandler(c *gin.Context) {
+	var req api.SplitRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	alias := model.ParseName(req.Alias)
+	if !alias.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("alias %q is invalid", req.Alias)})
+		return
+	}
+
+	arms := make([]api.SplitArm, len(req.Arms))
+	for i, arm := range req.Arms {
+		n := model.ParseName(arm.Model)
+		if !n.IsValid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("model %q is invalid", arm.Model)})
+			return
+		}
+
+		// the arms are resolved like the other names of the request
+		if tenant := c.GetString(tenantKey); tenant != "" {
+			scoped, err := tenantName(tenant, n, readAccess)
+			if errors.Is(err, os.ErrNotExist) {
+				c.AbortWithStatusJSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", arm.Model)))
+				return
+			} else if err != nil {
+				c.AbortWithStatusJSON(http.StatusForbidden, errorResponse(api.ErrorCodeForbidden, err.Error()))
+				return
+			}
+			n = scoped
+		}
+
+		n, err := getExistingName(n)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		arms[i] = api.SplitArm{Model: n.String(), Percent: arm.Percent}
+	}
+
+	if err := setSplit(alias, arms); errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, errorResponse(api.ErrorCodeNotFound, err.Error()))
+	} else if errors.Is(err, errInvalidSplit) {
+		c.JSON(http.StatusBadRequest, errorResponse(api.ErrorCodeInvalidRequest, err.Error()))
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func (s *Server) DeleteSplitHandler(c *gin.Context) {
+	var req api.SplitRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	alias := model.ParseName(req.Alias)
+	if !alias.IsValid() {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("alias %q is invalid", req.Alias)})
+		return
+	}
+
+	aliasesMu.Lock()
+	err := deleteSplit(alias)
+	aliasesMu.Unlock()
+
+	if errors.Is(err, os.ErrNotExist) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("split '%s' not found", req.Alias)})
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// ListSplitsHandler lists the splits along with the metrics of the requests
+// sent to each arm since the split was set.
+func (s *Server) ListSplitsHandler(c *gin.Context) {
+	aliasesMu.Lock()
+	splits, err := readSplits()
+	aliasesMu.Unlock()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	splitStats.mu.Lock()
+	defer splitStats.mu.Unlock()
+
+	resp := api.ListSplitsResponse{Splits: []api.Split{}}
+	for alias, arms := range splits {
+		if !visible(c, model.ParseName(alias)) {
+			continue
+		}
+
+		split := api.Split{
+			Alias: model.ParseName(alias).DisplayShortest(),
+			Since: cmp.Or(splitStats.since[alias], started),
+		}
+
+		for _, arm := range arms {
+			stats := *splitStats.get(alias, arm)
+			stats.Model = model.ParseName(arm.Model).DisplayShortest()
+			split.Arms = append(split.Arms, stats)
+		}
+
+		resp.Splits = append(resp.Splits, split)
+	}
+
+	slices.SortFunc(resp.Splits, func(i, j api.Split) int {
+		return cmp.Compare(i.Alias, j.Alias)
+	})
+
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestSplits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	var s Server
+	for _, n := range []string{"test:q4", "test:q8", "other"} {
+		_, digest := createBinFile(t, nil, nil)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Name:   n,
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+	}
+
+	if w := createRequest(t, s.SetAliasHandler, api.AliasRequest{Alias: "app", Target: "test:q4"}); w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d", w.Code)
+	}
+
+	r := gin.New()
+	r.POST("/api/generate", splitMiddleware(), func(c *gin.Context) {
+		var req api.GenerateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if req.Prompt == "fail" {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "runner crashed"})
+			return
+		}
+
+		recordSplitMetrics(c, api.Metrics{EvalCount: 2})
+		c.JSON(http.StatusOK, api.GenerateResponse{Model: req.Model})
+	})
+
+	generate := func(model, prompt, key string) string {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(fmt.Sprintf(`{"model":%q,"prompt":%q}`, model, prompt)))
+		if key != "" {
+			req.Header.Set("X-Split-Key", key)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.Model
+	}
+
+	setSplit := func(alias string, arms ...api.SplitArm) *httptest.ResponseRecorder {
+		t.Helper()
+		return createRequest(t, s.SetSplitHandler, api.SplitRequest{Alias: alias, Arms: arms})
+	}
+
+	listSplits := func() []api.Split {
+		t.Helper()
+
+		w := createRequest(t, s.ListSplitsHandler, nil)
+		var resp api.ListSplitsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp.Splits
+	}
+
+	if got := generate("app", "hi", ""); got != "app" {
+		t.Errorf("expected requests to an alias without a split to be left alone, got %q", got)
+	}
+
+	if w := setSplit("app", api.SplitArm{Model: "test:q4", Percent: 80}, api.SplitArm{Model: "test:q8", Percent: 20}); w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+	}
+
+	t.Run("split", func(t *testing.T) {
+		models := make(map[string]int)
+		for i := range 1000 {
+			models[generate("app", "hi", fmt.Sprint(i))]++
+		}
+
+		if models["test:q4"]+models["test:q8"] != 1000 || models["test:q8"] < 100 || models["test:q8"] > 300 {
+			t.Errorf("expected about 20%% of requests to go to test:q8, got %v", models)
+		}
+	})
+
+	t.Run("sticky", func(t *testing.T) {
+		first := generate("app", "hi", "user-1")
+		for range 20 {
+			if got := generate("app", "hi", "user-1"); got != first {
+				t.Fatalf("expected requests with the same key to go to %s, got %s", first, got)
+			}
+		}
+	})
+
+	t.Run("models", func(t *testing.T) {
+		if got := generate("test:q8", "hi", ""); got != "test:q8" {
+			t.Errorf("expected requests to a model to be left alone, got %q", got)
+		}
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		if w := setSplit("app", api.SplitArm{Model: "test:q4", Percent: 0}, api.SplitArm{Model: "test:q8", Percent: 100}); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+		}
+
+		generate("app", "hi", "")
+		generate("app", "hi", "")
+		generate("app", "fail", "")
+
+		splits := listSplits()
+		if len(splits) != 1 || splits[0].Alias != "app:latest" || len(splits[0].Arms) != 2 {
+			t.Fatalf("unexpected splits %+v", splits)
+		}
+
+		q4, q8 := splits[0].Arms[0], splits[0].Arms[1]
+		if q4.Model != "test:q4" || q4.Requests != 0 {
+			t.Errorf("expected the metrics to be counted again after the split was set, got %+v", q4)
+		}
+
+		if q8.Model != "test:q8" || q8.Percent != 100 || q8.Requests != 3 || q8.Errors != 1 || q8.EvalCount != 4 {
+			t.Errorf("unexpected metrics %+v", q8)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, tt := range []struct {
+			alias string
+			arms  []api.SplitArm
+			code  int
+		}{
+			{"app", []api.SplitArm{{Model: "test:q4", Percent: 100}}, http.StatusBadRequest},
+			{"app", []api.SplitArm{{Model: "test:q4", Percent: 50}, {Model: "test:q8", Percent: 40}}, http.StatusBadRequest},
+			{"app", []api.SplitArm{{Model: "test:q4", Percent: 120}, {Model: "test:q8", Percent: -20}}, http.StatusBadRequest},
+			{"app", []api.SplitArm{{Model: "test:q4", Percent: 50}, {Model: "test:q4", Percent: 50}}, http.StatusBadRequest},
+			{"app", []api.SplitArm{{Model: "test:q4", Percent: 50}, {Model: "test:fp16", Percent: 50}}, http.StatusNotFound},
+			{"unknown", []api.SplitArm{{Model: "test:q4", Percent: 50}, {Model: "test:q8", Percent: 50}}, http.StatusNotFound},
+		} {
+			if w := setSplit(tt.alias, tt.arms...); w.Code != tt.code {
+				t.Errorf("%s %v: expected status code %d, actual %d", tt.alias, tt.arms, tt.code, w.Code)
+			}
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if w := createRequest(t, s.DeleteAliasHandler, api.AliasRequest{Alias: "app"}); w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		if splits := listSplits(); len(splits) != 0 {
+			t.Errorf("expected the split to be deleted with its alias, got %+v", splits)
+		}
+
+		if w := createRequest(t, s.DeleteSplitHandler, api.SplitRequest{Alias: "app"}); w.Code != http.StatusNotFound {
+			t.Errorf("expected status code 404, actual %d", w.Code)
+		}
+	})
+}
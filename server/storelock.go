@@ -0,0 +1,204 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// errStoreInUse is returned by lockStoreExclusive when models are being
+// written to the models directory, by this or another ollama process.
+var errStoreInUse = errors.New("the models directory is in use by a pull or create, try again once it finishes")
+
+// storeLock coordinates the ollama processes sharing a models directory
+// with an advisory lock on its lock file.
+//
+// Pulls and creates hold the lock shared from the first blob they write
+// until the manifest which uses it is written, and manifests are only
+// written while it's held. Anything which removes blobs or manifest
+// directories, or repairs the journal, holds it exclusively so it never
+// removes a blob a model is about to use or rolls back a write in progress.
+// Removals don't wait for writers, which can take hours to pull a model;
+// blobs which can't be removed yet are removed once the writers in this
+// process finish, or by a later prune.
+type storeLock struct {
+	mu   sync.Mutex
+	cond sync.Cond
+
+	// f is the lock file while it's locked
+	f *os.File
+
+	// writers counts the holders of the shared lock in this process
+	writers   int
+	exclusive bool
+
+	// pending are blobs which couldn't be removed while the lock was held
+	pending map[string]struct{}
+}
+
+var modelsLock = func() *storeLock {
+	var l storeLock
+	l.cond.L = &l.mu
+	return &l
+}()
+
+func openLockFile() (*os.File, error) {
+	dir := envconfig.Models()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return os.OpenFile(filepath.Join(dir, "lock"), os.O_RDWR|os.O_CREATE, 0o644)
+}
+
+// lockStore locks the models directory shared, waiting for other
+// processes to finish removing blobs. It may be called again before the
+// returned function unlocks it.
+func lockStore() (func(), error) {
+	l := modelsLock
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.exclusive {
+		l.cond.Wait()
+	}
+
+	if l.writers == 0 {
+		f, err := openLockFile()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := lockFile(f, false, true); err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		l.f = f
+	}
+
+	l.writers++
+
+	var once sync.Once
+	return func() { once.Do(l.unlockShared) }, nil
+}
+
+func (l *storeLock) unlockShared() {
+	l.mu.Lock()
+	l.writers--
+
+	var pending map[string]struct{}
+	if l.writers == 0 {
+		l.release()
+		pending, l.pending = l.pending, nil
+	}
+	l.mu.Unlock()
+
+	if len(pending) > 0 {
+		if err := deleteUnusedLayers(pending); err != nil {
+			slog.Warn("failed to remove unused layers", "error", err)
+		}
+	}
+}
+
+// lockStoreExclusive locks the models directory exclusively. It returns
+// errStoreInUse rather than waiting if the lock is held.
+func lockStoreExclusive() (func(), error) {
+	l := modelsLock
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.writers > 0 || l.exclusive {
+		return nil, errStoreInUse
+	}
+
+	f, err := openLockFile()
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, err := lockFile(f, true, false); err != nil {
+		f.Close()
+		return nil, err
+	} else if !ok {
+		f.Close()
+		return nil, errStoreInUse
+	}
+
+	l.f = f
+	l.exclusive = true
+
+	var once sync.Once
+	return func() { once.Do(l.unlockExclusive) }, nil
+}
+
+func (l *storeLock) unlockExclusive() {
+	l.mu.Lock()
+	l.exclusive = false
+	l.release()
+	l.cond.Broadcast()
+	l.mu.Unlock()
+}
+
+// release unlocks and closes the lock file. l.mu must be held.
+func (l *storeLock) release() {
+	if err := unlockFile(l.f); err != nil {
+		slog.Warn("failed to unlock the models directory", "error", err)
+	}
+
+	l.f.Close()
+	l.f = nil
+}
+
+// deferRemoval records blobs to remove once the lock is free.
+func (l *storeLock) deferRemoval(digests map[string]struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.pending == nil {
+		l.pending = make(map[string]struct{})
+	}
+
+	for digest := range digests {
+		l.pending[digest] = struct{}{}
+	}
+}
+
+// takePending returns the blobs waiting to be removed.
+func (l *storeLock) takePending() map[string]struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	pending := l.pending
+	l.pending = nil
+	return pending
+}
+
+// repairStore renames blobs with old names and rolls back manifest writes
+// which were interrupted. It's skipped if another process is using the
+// models directory, since its writes may still be in progress.
+func repairStore() error {
+	unlock, err := lockStoreExclusive()
+	if errors.Is(err, errStoreInUse) {
+		slog.Info("models directory is in use by another process, skipping repair")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer unlock()
+
+	blobs, err := GetBlobsPath("")
+	if err != nil {
+		return err
+	}
+
+	if err := fixBlobs(blobs); err != nil {
+		return err
+	}
+
+	return repairManifests()
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+
+package server
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile locks f shared or exclusively. If wait is false it returns false
+// rather than waiting for another process to unlock it.
+func lockFile(f *os.File, exclusive, wait bool) (bool, error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		switch {
+		case errors.Is(err, syscall.EINTR):
+			continue
+		case errors.Is(err, syscall.EWOULDBLOCK):
+			return false, nil
+		case err != nil:
+			return false, err
+		}
+
+		return true, nil
+	}
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ollama/ollama/types/model"
+)
+
+// lockOther locks the models directory as another process would, returning
+// false if it's held.
+func lockOther(t *testing.T, exclusive bool) (*os.File, bool) {
+	t.Helper()
+
+	f, err := openLockFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	ok, err := lockFile(f, exclusive, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f, ok
+}
+
+func TestStoreLock(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	unlock, err := lockStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// writers may lock it again, such as to write the manifest of a model
+	// they're creating
+	unlockAgain, err := lockStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lockStoreExclusive(); !errors.Is(err, errStoreInUse) {
+		t.Errorf("expected the store to be in use, got %v", err)
+	}
+
+	if _, ok := lockOther(t, true); ok {
+		t.Error("expected other processes not to lock the store exclusively")
+	}
+
+	if f, ok := lockOther(t, false); !ok {
+		t.Error("expected other processes to share the lock")
+	} else if err := unlockFile(f); err != nil {
+		t.Fatal(err)
+	}
+
+	unlockAgain()
+	unlockAgain()
+	if _, err := lockStoreExclusive(); !errors.Is(err, errStoreInUse) {
+		t.Errorf("expected the store to be in use until every writer unlocks it, got %v", err)
+	}
+
+	unlock()
+	unlockExclusive, err := lockStoreExclusive()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := lockOther(t, false); ok {
+		t.Error("expected other processes to wait for the exclusive lock")
+	}
+
+	unlockExclusive()
+
+	f, ok := lockOther(t, false)
+	if !ok {
+		t.Fatal("expected the lock to be free")
+	}
+
+	if _, err := lockStoreExclusive(); !errors.Is(err, errStoreInUse) {
+		t.Errorf("expected the store to be in use by the other process, got %v", err)
+	}
+
+	if err := unlockFile(f); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeferredRemoval(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	used := writeTestBlob(t, "used")
+	unused := writeTestBlob(t, "unused")
+	if err := WriteManifest(t.Context(), model.ParseName("test"), Layer{}, []Layer{used}); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := lockStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := deleteUnusedLayers(map[string]struct{}{used.Digest: {}, unused.Digest: {}}); err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := GetBlobsPath(unused.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(blob); err != nil {
+		t.Errorf("expected the blob to be kept while models are written, got %v", err)
+	}
+
+	unlock()
+
+	if _, err := os.Stat(blob); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the blob to be removed once the writer finished, got %v", err)
+	}
+
+	if p, err := GetBlobsPath(used.Digest); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(p); err != nil {
+		t.Errorf("expected the blob used by a manifest to be kept, got %v", err)
+	}
+}
+
+func TestRepairStoreInUse(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	manifests, err := GetManifestPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another process is writing this manifest
+	n := model.ParseName("pulling")
+	txn, err := beginManifestTxn(filepath.Join(manifests, n.Filepath()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := txn.write([]byte("in progress")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, ok := lockOther(t, false)
+	if !ok {
+		t.Fatal("expected the lock to be free")
+	}
+
+	if err := repairStore(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := readManifestFile(t, n); s != "in progress" {
+		t.Errorf("expected the write in progress to be left alone, got %q", s)
+	}
+
+	if err := unlockFile(f); err != nil {
+		t.Fatal(err)
+	}
+
+	// the other process stopped before the write ended
+	if err := repairStore(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s := readManifestFile(t, n); s != "" {
+		t.Errorf("expected the interrupted write to be rolled back, got %q", s)
+	}
+
+	checkJournalEmpty(t)
+}
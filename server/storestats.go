@@ -0,0 +1,91 @@
+package server
+
+import (
+	"cmp"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+// storeStats reports how much of the models directory each model uses,
+// counting the blobs models share once.
+func storeStats() (*api.StoreStatsResponse, error) {
+	ms, err := Manifests(true)
+	if err != nil {
+		return nil, err
+	}
+
+	// refs counts the models which use each blob
+	refs := make(map[string]int)
+	for _, m := range ms {
+		for digest := range manifestBlobs(m) {
+			refs[digest]++
+		}
+	}
+
+	resp := api.StoreStatsResponse{Models: []api.ModelStoreStats{}}
+	for n, m := range ms {
+		stats := api.ModelStoreStats{Name: n.DisplayShortest()}
+		for digest, size := range manifestBlobs(m) {
+			stats.Size += size
+			if refs[digest] > 1 {
+				stats.Shared += size
+			} else {
+				stats.Unique += size
+			}
+		}
+
+		resp.Models = append(resp.Models, stats)
+		resp.Logical += stats.Size
+	}
+
+	slices.SortFunc(resp.Models, func(a, b api.ModelStoreStats) int {
+		return cmp.Or(cmp.Compare(b.Unique, a.Unique), strings.Compare(a.Name, b.Name))
+	})
+
+	blobs, err := GetBlobsPath("")
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(blobs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		digest := strings.Replace(entry.Name(), "-", ":", 1)
+		if _, err := GetBlobsPath(digest); err != nil {
+			// partial downloads and other files
+			continue
+		}
+
+		fi, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		resp.Blobs++
+		resp.Size += fi.Size()
+		if refs[digest] == 0 {
+			resp.Unused += fi.Size()
+		}
+	}
+
+	return &resp, nil
+}
+
+func (s *Server) StoreStatsHandler(c *gin.Context) {
+	resp, err := storeStats()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
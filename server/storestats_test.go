@@ -0,0 +1,47 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestStoreStats(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	base := writeTestBlob(t, "base weights")
+	q4 := writeTestBlob(t, "q4 adapter")
+	q8 := writeTestBlob(t, "q8 adapter weights")
+	writeTestBlob(t, "orphan")
+
+	if err := WriteManifest(t.Context(), model.ParseName("test:q4"), Layer{}, []Layer{base, q4}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteManifest(t.Context(), model.ParseName("test:q8"), Layer{}, []Layer{base, q8}); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := storeStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &api.StoreStatsResponse{
+		Models: []api.ModelStoreStats{
+			{Name: "test:q8", Size: base.Size + q8.Size, Unique: q8.Size, Shared: base.Size},
+			{Name: "test:q4", Size: base.Size + q4.Size, Unique: q4.Size, Shared: base.Size},
+		},
+		Blobs:   4,
+		Size:    base.Size + q4.Size + q8.Size + int64(len("orphan")),
+		Logical: 2*base.Size + q4.Size + q8.Size,
+		Unused:  int64(len("orphan")),
+	}
+
+	if diff := cmp.Diff(stats, want); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+}
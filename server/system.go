@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+var errSystemPolicy = errors.New("invalid system prompt policy")
+
+// systemPolicy is the text the server adds around the system prompt of
+// requests, which clients can't remove.
+type systemPolicy struct {
+	// Preamble and Postamble override OLLAMA_SYSTEM_PREAMBLE and
+	// OLLAMA_SYSTEM_POSTAMBLE if they're set, even if they're empty
+	Preamble  *string `json:"preamble"`
+	Postamble *string `json:"postamble"`
+}
+
+// systemPolicies caches the parsed OLLAMA_SYSTEM_POLICY file, which is read
+// again when it changes.
+var systemPolicies struct {
+	sync.Mutex
+	path     string
+	modTime  time.Time
+	policies map[string]systemPolicy
+}
+
+func readSystemPolicies(path string) (map[string]systemPolicy, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errSystemPolicy, err)
+	}
+
+	systemPolicies.Lock()
+	defer systemPolicies.Unlock()
+
+	if path == systemPolicies.path && fi.ModTime().Equal(systemPolicies.modTime) {
+		return systemPolicies.policies, nil
+	}
+
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errSystemPolicy, err)
+	}
+
+	var policies map[string]systemPolicy
+	if err := json.Unmarshal(bts, &policies); err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", errSystemPolicy, path, err)
+	}
+
+	for name := range policies {
+		if !model.ParseName(name).IsValid() {
+			return nil, fmt.Errorf("%w: %s: invalid model name %q", errSystemPolicy, path, name)
+		}
+	}
+
+	systemPolicies.path, systemPolicies.modTime, systemPolicies.policies = path, fi.ModTime(), policies
+	return policies, nil
+}
+
+// systemAmbles returns the preamble and postamble of the system prompt of
+// the model named name. Policies for names without a tag apply to every tag
+// of the model, unless the tag has a policy of its own.
+func systemAmbles(name string) (preamble, postamble string, _ error) {
+	preamble, postamble = envconfig.SystemPreamble(), envconfig.SystemPostamble()
+
+	policies, err := readSystemPolicies(envconfig.SystemPolicy())
+	if err != nil {
+		return "", "", err
+	}
+
+	n := model.ParseName(name)
+
+	var untagged, tagged *systemPolicy
+	for k, p := range policies {
+		pn := model.ParseName(k)
+		if pn.EqualFold(n) && hasTag(k) {
+			tagged = &p
+		} else if !hasTag(k) && strings.EqualFold(pn.Host, n.Host) && strings.EqualFold(pn.Namespace, n.Namespace) && strings.EqualFold(pn.Model, n.Model) {
+			untagged = &p
+		}
+	}
+
+	for _, p := range []*systemPolicy{untagged, tagged} {
+		if p == nil {
+			continue
+		}
+
+		if p.Preamble != nil {
+			preamble = *p.Preamble
+		}
+
+		if p.Postamble != nil {
+			postamble = *p.Postamble
+		}
+	}
+
+	return preamble, postamble, nil
+}
+
+// hasTag returns whether the model name s has a tag.
+func hasTag(s string) bool {
+	_, after, _ := strings.Cut(s[strings.LastIndex(s, "/")+1:], ":")
+	return after != ""
+}
+
+// applySystemPolicy returns msgs with the preamble of the system prompt of
+// the model named name before the first system message and the postamble
+// after the last, adding a system message if there are none.
+func applySystemPolicy(name string, msgs []api.Message) ([]api.Message, error) {
+	preamble, postamble, err := systemAmbles(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if preamble == "" && postamble == "" {
+		return msgs, nil
+	}
+
+	isSystem := func(m api.Message) bool { return m.Role == "system" }
+	first, last := slices.IndexFunc(msgs, isSystem), -1
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if isSystem(msgs[i]) {
+			last = i
+			break
+		}
+	}
+
+	if first < 0 {
+		return append([]api.Message{{Role: "system", Content: joinNonEmpty(preamble, postamble)}}, msgs...), nil
+	}
+
+	// don't change the messages of the request or model
+	msgs = slices.Clone(msgs)
+	msgs[first].Content = joinNonEmpty(preamble, msgs[first].Content)
+	msgs[last].Content = joinNonEmpty(msgs[last].Content, postamble)
+	return msgs, nil
+}
+
+func joinNonEmpty(ss ...string) string {
+	return strings.Join(slices.DeleteFunc(ss, func(s string) bool { return s == "" }), "\n\n")
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+func TestApplySystemPolicy(t *testing.T) {
+	policy := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(policy, []byte(`{
+		"llama3.2": {"preamble": "Be safe."},
+		"llama3.2:1b": {"postamble": ""},
+		"example.com/team/Model": {"preamble": "", "postamble": ""}
+	}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_SYSTEM_PREAMBLE", "Be helpful.")
+	t.Setenv("OLLAMA_SYSTEM_POSTAMBLE", "Answer in English.")
+	t.Setenv("OLLAMA_SYSTEM_POLICY", policy)
+
+	msgs := []api.Message{
+		{Role: "system", Content: "You are Mario."},
+		{Role: "user", Content: "Hi!"},
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Who are you?"},
+	}
+
+	cases := []struct {
+		name   string
+		model  string
+		msgs   []api.Message
+		expect []api.Message
+	}{
+		{
+			name:  "global",
+			model: "mistral",
+			msgs:  msgs,
+			expect: []api.Message{
+				{Role: "system", Content: "Be helpful.\n\nYou are Mario."},
+				{Role: "user", Content: "Hi!"},
+				{Role: "system", Content: "Be brief.\n\nAnswer in English."},
+				{Role: "user", Content: "Who are you?"},
+			},
+		},
+		{
+			name:  "no system message",
+			model: "mistral",
+			msgs:  msgs[1:2],
+			expect: []api.Message{
+				{Role: "system", Content: "Be helpful.\n\nAnswer in English."},
+				{Role: "user", Content: "Hi!"},
+			},
+		},
+		{
+			name:  "model",
+			model: "registry.ollama.ai/library/llama3.2:3b",
+			msgs:  msgs[:2],
+			expect: []api.Message{
+				{Role: "system", Content: "Be safe.\n\nYou are Mario.\n\nAnswer in English."},
+				{Role: "user", Content: "Hi!"},
+			},
+		},
+		{
+			name:  "tag",
+			model: "llama3.2:1b",
+			msgs:  msgs[:2],
+			expect: []api.Message{
+				{Role: "system", Content: "Be safe.\n\nYou are Mario."},
+				{Role: "user", Content: "Hi!"},
+			},
+		},
+		{
+			name:   "disabled",
+			model:  "example.com/team/model:latest",
+			msgs:   msgs[:2],
+			expect: msgs[:2],
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := applySystemPolicy(tt.model, tt.msgs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.expect, actual); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if msgs[0].Content != "You are Mario." {
+		t.Errorf("expected the messages to be unchanged, got %q", msgs[0].Content)
+	}
+
+	t.Run("invalid policy", func(t *testing.T) {
+		if err := os.WriteFile(policy, []byte(`{"llama3.2": {"preamble": 1}}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		// make sure the modification time changes
+		if err := os.Chtimes(policy, time.Now(), time.Now().Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := applySystemPolicy("mistral", msgs); !errors.Is(err, errSystemPolicy) {
+			t.Errorf("expected %v, got %v", errSystemPolicy, err)
+		}
+	})
+}
+
+func TestSystemPolicyHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_SYSTEM_PREAMBLE", "Be helpful.")
+
+	var s Server
+
+	_, digest := createBinFile(t, ggml.KV{"general.architecture": "llama"}, nil)
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Name:     "test",
+		Files:    map[string]string{"test.gguf": digest},
+		Template: "{{ if .System }}[SYS] {{ .System }} {{ end }}[USER] {{ .Prompt }} [BOT]",
+		System:   "You are Mario.",
+		Stream:   &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+	}
+
+	w = createRequest(t, s.RenderTemplateHandler, api.RenderTemplateRequest{Model: "test", Prompt: "Hi!"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+	}
+
+	var resp api.RenderTemplateResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := "[SYS] Be helpful.\n\nYou are Mario. [USER] Hi! [BOT]"; resp.Prompt != expect {
+		t.Errorf("expected %q, got %q", expect, resp.Prompt)
+	}
+
+	w = createRequest(t, s.GenerateHandler, api.GenerateRequest{Model: "test", Prompt: "Hi!", Raw: true})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status code 400, actual %d: %s", w.Code, w.Body)
+	}
+}
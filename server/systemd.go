@@ -0,0 +1,111 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation.
+const listenFDsStart = 3
+
+// ActivationListener returns the socket passed by systemd socket
+// activation, or nil if the server wasn't started by a socket unit.
+func ActivationListener() (net.Listener, error) {
+	return activationListener(listenFDsStart)
+}
+
+func activationListener(start uintptr) (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+
+	// the sockets are passed to this process, not the runners it starts
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if n > 1 {
+		slog.Warn("serving on the first of the sockets passed by systemd", "sockets", n)
+		for fd := start + 1; fd < start+uintptr(n); fd++ {
+			os.NewFile(fd, "").Close()
+		}
+	}
+
+	// FileListener duplicates the socket, so the original is closed
+	f := os.NewFile(start, "LISTEN_FD_"+strconv.Itoa(int(start)))
+	defer f.Close()
+
+	return net.FileListener(f)
+}
+
+// systemdNotify tells systemd the state of the server, such as READY=1 once
+// it's serving, when it's run by a unit with Type=notify.
+func systemdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+
+	// abstract sockets are given with a leading @
+	if strings.HasPrefix(addr, "@") {
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// newJournalHandler returns a handler which writes logs to the systemd
+// journal through w, prefixed with their syslog priority so journald records
+// their levels.
+func newJournalHandler(w io.Writer, opts *slog.HandlerOptions) slog.Handler {
+	var mu sync.Mutex
+	return newLevelHandler(opts, func(level slog.Level) io.Writer {
+		priority := 6
+		switch {
+		case level >= slog.LevelError:
+			priority = 3
+		case level >= slog.LevelWarn:
+			priority = 4
+		case level < slog.LevelInfo:
+			priority = 7
+		}
+
+		return &priorityWriter{w: w, mu: &mu, prefix: "<" + strconv.Itoa(priority) + ">"}
+	})
+}
+
+// priorityWriter prefixes each log line, which slog writes at once, with a
+// syslog priority.
+type priorityWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix string
+}
+
+func (w *priorityWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.w.Write(append([]byte(w.prefix), b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,121 @@
+//go:build !windows
+
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestActivationListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if got, err := activationListener(0); got != nil || err != nil {
+		t.Fatalf("expected no listener without LISTEN_PID, got %v, %v", got, err)
+	}
+
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// activationListener closes the socket it's passed
+	dup, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := uintptr(dup)
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	if got, err := activationListener(fd); got != nil || err != nil {
+		t.Fatalf("expected no listener for another process, got %v, %v", got, err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	got, err := activationListener(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer got.Close()
+
+	if got.Addr().String() != ln.Addr().String() {
+		t.Errorf("expected a listener on %s, got %s", ln.Addr(), got.Addr())
+	}
+
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Error("expected LISTEN_FDS to be unset so runners don't inherit it")
+	}
+}
+
+func TestSystemdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := systemdNotify("READY=1"); err != nil {
+		t.Fatalf("expected no error without NOTIFY_SOCKET, got %v", err)
+	}
+
+	// socket paths are limited to about 100 bytes, which t.TempDir can exceed
+	dir, err := os.MkdirTemp("", "notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	addr := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", addr)
+	if err := systemdNotify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 64)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b[:n]) != "READY=1" {
+		t.Errorf("expected READY=1, got %q", b[:n])
+	}
+}
+
+func TestJournalHandler(t *testing.T) {
+	var b bytes.Buffer
+	logger := slog.New(newJournalHandler(&b, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	logger.Debug("hidden")
+	logger.Info("listening", "port", 11434)
+	logger.With("model", "llama3").Warn("slow")
+	logger.Error("failed")
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %q", lines)
+	}
+
+	for i, prefix := range []string{"<6>", "<4>", "<3>"} {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Errorf("expected %q to start with %s", lines[i], prefix)
+		}
+	}
+
+	if !strings.Contains(lines[1], "model=llama3") {
+		t.Errorf("expected attributes to be kept, got %q", lines[1])
+	}
+}
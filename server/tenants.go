@@ -0,0 +1,275 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/types/model"
+)
+
+// tenantKey is the key in the gin context of the namespace of the API key
+// of a request, which is empty for requests which can use every namespace.
+const tenantKey = "tenant"
+
+// sharedNamespace is the namespace whose models every tenant can use, but
+// only keys for every namespace can change.
+const sharedNamespace = "library"
+
+var errForbiddenNamespace = errors.New("namespace is not allowed for this API key")
+
+// parseAPIKeys parses OLLAMA_API_KEYS, a comma separated list of
+// key=namespace pairs, into the namespaces of each key.
+func parseAPIKeys(s string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for kv := range strings.SplitSeq(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+
+		key, namespace, ok := strings.Cut(kv, "=")
+		key, namespace = strings.TrimSpace(key), strings.TrimSpace(namespace)
+		if !ok || key == "" || namespace == "" {
+			return nil, fmt.Errorf("invalid API key %q, expected key=namespace", kv)
+		}
+
+		keys[key] = namespace
+	}
+
+	return keys, nil
+}
+
+// tenantMiddleware requires requests to have one of the API keys in
+// OLLAMA_API_KEYS, as a bearer token, and sets the namespace of the key as
+// the request's tenant. Health checks and the version don't need a key.
+func tenantMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.URL.Path {
+		case "/", "/api/version", "/healthz", "/readyz":
+			c.Next()
+			return
+		}
+
+		keys, err := parseAPIKeys(envconfig.APIKeys())
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		} else if len(keys) == 0 {
+			c.Next()
+			return
+		}
+
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse(api.ErrorCodeUnauthorized, "an API key is required"))
+			return
+		}
+
+		namespace := ""
+		for key, ns := range keys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(strings.TrimSpace(token))) == 1 {
+				namespace = ns
+			}
+		}
+
+		switch namespace {
+		case "":
+			c.AbortWithStatusJSON(http.StatusUnauthorized, errorResponse(api.ErrorCodeUnauthorized, "invalid API key"))
+			return
+		case "*":
+		default:
+			c.Set(tenantKey, namespace)
+		}
+
+		c.Next()
+	}
+}
+
+// adminMiddleware refuses requests from tenants, leaving the server-wide
+// operations it guards to keys for every namespace.
+func adminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString(tenantKey) != "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, errorResponse(api.ErrorCodeForbidden, "this API key can't manage the server"))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// visible reports whether the request of c may see the model named n.
+func visible(c *gin.Context, n model.Name) bool {
+	tenant := c.GetString(tenantKey)
+	return tenant == "" || strings.EqualFold(n.Namespace, tenant) || strings.EqualFold(n.Namespace, sharedNamespace)
+}
+
+// blobVisible reports whether the request of c may read the blob with
+// digest, which tenants may only do for blobs of the models they can see.
+func blobVisible(c *gin.Context, digest string) (bool, error) {
+	if c.GetString(tenantKey) == "" {
+		return true, nil
+	}
+
+	ms, err := Manifests(true)
+	if err != nil {
+		return false, err
+	}
+
+	digest = strings.Replace(digest, "-", ":", 1)
+	for n, m := range ms {
+		if !visible(c, n) {
+			continue
+		}
+
+		if m.Config.Digest == digest || slices.ContainsFunc(m.Layers, func(l Layer) bool { return l.Digest == digest }) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// tenantScoped is key, such as a request ID, made unique to the tenant of
+// the request of c so tenants can't reach each other's requests.
+func tenantScoped(c *gin.Context, key string) string {
+	if tenant := c.GetString(tenantKey); tenant != "" {
+		return tenant + "/" + key
+	}
+
+	return key
+}
+
+// nameAccess is how a request uses the model it names.
+type nameAccess int
+
+const (
+	// readAccess uses a model in the tenant's namespace or the shared one
+	readAccess nameAccess = iota
+
+	// writeAccess creates or changes a model in the tenant's namespace
+	writeAccess
+
+	// registryAccess names a model in a registry, which must be in the
+	// tenant's namespace as the name can't be changed
+	registryAccess
+)
+
+// tenantName is the model which tenant means by n. Names in the shared
+// namespace are of the tenant's own model of the same name if it has one,
+// and always are when creating or changing one. It returns an error which
+// is os.ErrNotExist for models the tenant can't see, or
+// errForbiddenNamespace for models it can see but not change.
+func tenantName(tenant string, n model.Name, access nameAccess) (model.Name, error) {
+	if strings.EqualFold(n.Namespace, tenant) {
+		return n, nil
+	}
+
+	if access == registryAccess {
+		return model.Name{}, fmt.Errorf("%w: models can only be pulled and pushed in %q", errForbiddenNamespace, tenant)
+	}
+
+	if !strings.EqualFold(n.Namespace, sharedNamespace) {
+		if access == readAccess {
+			return model.Name{}, os.ErrNotExist
+		}
+
+		return model.Name{}, fmt.Errorf("%w: models can only be changed in %q", errForbiddenNamespace, tenant)
+	}
+
+	own := n
+	own.Namespace = tenant
+	if access == writeAccess {
+		return own, nil
+	}
+
+	if target, _, err := resolveAlias(own); err == nil && modelExists(target) {
+		return own, nil
+	}
+
+	return n, nil
+}
+
+// tenantNames resolves the model names in fields of the JSON bodies of
+// requests from tenants with tenantName, so handlers only see names the
+// tenant may use. Fields are matched case-insensitively, as they are when
+// the body is bound to a request.
+func tenantNames(fields map[string]nameAccess) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant := c.GetString(tenantKey)
+		if tenant == "" || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var req map[string]json.RawMessage
+		if err := json.Unmarshal(body, &req); err != nil {
+			// leave invalid requests to the handler to reject
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			c.Next()
+			return
+		}
+
+		var changed bool
+		for k, v := range req {
+			for field, access := range fields {
+				if !strings.EqualFold(k, field) {
+					continue
+				}
+
+				var s string
+				if err := json.Unmarshal(v, &s); err != nil || s == "" {
+					continue
+				}
+
+				n := model.ParseName(s)
+				if !n.IsValid() {
+					continue
+				}
+
+				scoped, err := tenantName(tenant, n, access)
+				if errors.Is(err, os.ErrNotExist) {
+					c.AbortWithStatusJSON(http.StatusNotFound, errorResponse(api.ErrorCodeModelNotFound, fmt.Sprintf("model '%s' not found", s)))
+					return
+				} else if err != nil {
+					c.AbortWithStatusJSON(http.StatusForbidden, errorResponse(api.ErrorCodeForbidden, err.Error()))
+					return
+				}
+
+				if scoped != n {
+					req[k], _ = json.Marshal(scoped.DisplayShortest())
+					changed = true
+				}
+			}
+		}
+
+		if changed {
+			if body, err = json.Marshal(req); err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Request.ContentLength = int64(len(body))
+		c.Next()
+	}
+}
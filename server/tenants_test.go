@@ -0,0 +1,306 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/fs/ggml"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	keys, err := parseAPIKeys(" a=team-a, b = team-b ,,c=*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(keys, map[string]string{"a": "team-a", "b": "team-b", "c": "*"}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	for _, s := range []string{"a", "a=", "=team-a"} {
+		if _, err := parseAPIKeys(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestTenantMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(tenantMiddleware())
+	r.GET("/healthz", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	r.GET("/tenant", func(c *gin.Context) { c.String(http.StatusOK, c.GetString(tenantKey)) })
+	r.POST("/api/prune", adminMiddleware(), func(c *gin.Context) { c.String(http.StatusOK, "pruned") })
+
+	get := func(method, path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// keys aren't needed until some are set
+	if w := get(http.MethodGet, "/tenant", ""); w.Code != http.StatusOK || w.Body.String() != "" {
+		t.Errorf("expected no tenant, got %d %q", w.Code, w.Body)
+	}
+
+	t.Setenv("OLLAMA_API_KEYS", "a=team-a,admin=*")
+
+	cases := []struct {
+		method, path, key string
+		code              int
+		body              string
+	}{
+		{http.MethodGet, "/tenant", "", http.StatusUnauthorized, `{"code":"UNAUTHORIZED","error":"an API key is required"}`},
+		{http.MethodGet, "/tenant", "b", http.StatusUnauthorized, `{"code":"UNAUTHORIZED","error":"invalid API key"}`},
+		{http.MethodGet, "/tenant", "a", http.StatusOK, "team-a"},
+		{http.MethodGet, "/tenant", "admin", http.StatusOK, ""},
+		{http.MethodGet, "/healthz", "", http.StatusOK, "ok"},
+		{http.MethodPost, "/api/prune", "a", http.StatusForbidden, `{"code":"FORBIDDEN","error":"this API key can't manage the server"}`},
+		{http.MethodPost, "/api/prune", "admin", http.StatusOK, "pruned"},
+	}
+
+	for _, tt := range cases {
+		w := get(tt.method, tt.path, tt.key)
+		if w.Code != tt.code {
+			t.Errorf("%s with key %q: expected status code %d, actual %d", tt.path, tt.key, tt.code, w.Code)
+		}
+
+		if w.Body.String() != tt.body {
+			t.Errorf("%s with key %q: expected %s, got %s", tt.path, tt.key, tt.body, w.Body)
+		}
+	}
+}
+
+func TestTenantNames(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_API_KEYS", "a=team-a,admin=*")
+
+	var s Server
+	for _, n := range []string{"shared", "team-a/mine", "team-b/theirs"} {
+		_, digest := createBinFile(t, nil, nil)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  n,
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+	}
+
+	r := gin.New()
+	r.Use(tenantMiddleware())
+	echo := func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Data(http.StatusOK, "application/json", body)
+	}
+	r.POST("/read", tenantNames(map[string]nameAccess{"model": readAccess}), echo)
+	r.POST("/write", tenantNames(map[string]nameAccess{"model": writeAccess}), echo)
+	r.POST("/pull", tenantNames(map[string]nameAccess{"model": registryAccess}), echo)
+	r.GET("/api/tags", s.ListHandler)
+
+	cases := []struct {
+		path, key, body string
+		code            int
+		want            string
+	}{
+		{"/read", "a", `{"model":"shared"}`, http.StatusOK, `{"model":"shared"}`},
+		{"/read", "a", `{"model":"mine"}`, http.StatusOK, `{"model":"team-a/mine:latest"}`},
+		{"/read", "a", `{"model":"team-a/mine"}`, http.StatusOK, `{"model":"team-a/mine"}`},
+		{"/read", "a", `{"model":"team-b/theirs"}`, http.StatusNotFound, `{"code":"MODEL_NOT_FOUND","error":"model 'team-b/theirs' not found"}`},
+		{"/read", "a", `{"MODEL":"team-b/theirs"}`, http.StatusNotFound, `{"code":"MODEL_NOT_FOUND","error":"model 'team-b/theirs' not found"}`},
+		{"/read", "admin", `{"model":"team-b/theirs"}`, http.StatusOK, `{"model":"team-b/theirs"}`},
+		{"/write", "a", `{"model":"shared"}`, http.StatusOK, `{"model":"team-a/shared:latest"}`},
+		{"/write", "a", `{"model":"team-b/theirs"}`, http.StatusForbidden, `{"code":"FORBIDDEN","error":"namespace is not allowed for this API key: models can only be changed in \"team-a\""}`},
+		{"/pull", "a", `{"model":"llama3.2"}`, http.StatusForbidden, `{"code":"FORBIDDEN","error":"namespace is not allowed for this API key: models can only be pulled and pushed in \"team-a\""}`},
+		{"/pull", "a", `{"model":"team-a/llama3.2"}`, http.StatusOK, `{"model":"team-a/llama3.2"}`},
+	}
+
+	for _, tt := range cases {
+		req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Authorization", "Bearer "+tt.key)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("%s %s: expected status code %d, actual %d", tt.path, tt.body, tt.code, w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), tt.want); diff != "" {
+			t.Errorf("%s %s: mismatch (-got +want):\n%s", tt.path, tt.body, diff)
+		}
+	}
+
+	list := func(key string) []string {
+		req := httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var resp api.ListResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, m := range resp.Models {
+			names = append(names, m.Name)
+		}
+
+		slices.Sort(names)
+		return names
+	}
+
+	if diff := cmp.Diff(list("a"), []string{"shared:latest", "team-a/mine:latest"}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(list("admin"), []string{"shared:latest", "team-a/mine:latest", "team-b/theirs:latest"}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+}
+
+func TestTenantBlobs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_API_KEYS", "a=team-a,admin=*")
+	t.Setenv("OLLAMA_SERVE_BLOBS", "1")
+
+	var s Server
+	digests := make(map[string]string)
+	for _, n := range []string{"shared", "team-a/mine", "team-b/theirs"} {
+		_, digest := createBinFile(t, ggml.KV{"general.architecture": "llama", "general.name": n}, nil)
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:  n,
+			Files:  map[string]string{"test.gguf": digest},
+			Stream: &stream,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status code 200, actual %d", w.Code)
+		}
+
+		digests[n] = digest
+	}
+
+	r := gin.New()
+	r.Use(tenantMiddleware())
+	r.GET("/api/blobs/:digest", s.GetBlobHandler)
+
+	cases := []struct {
+		model, key string
+		code       int
+	}{
+		{"shared", "a", http.StatusOK},
+		{"team-a/mine", "a", http.StatusOK},
+		{"team-b/theirs", "a", http.StatusNotFound},
+		{"team-b/theirs", "admin", http.StatusOK},
+	}
+
+	for _, tt := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/api/blobs/"+digests[tt.model], nil)
+		req.Header.Set("Authorization", "Bearer "+tt.key)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.code {
+			t.Errorf("blob of %s with key %q: expected status code %d, actual %d", tt.model, tt.key, tt.code, w.Code)
+		}
+	}
+
+	// blobs aren't served at all unless the server is set up to
+	t.Setenv("OLLAMA_SERVE_BLOBS", "")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/blobs/"+digests["shared"], nil)
+	req.Header.Set("Authorization", "Bearer admin")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("expected status code 403 without OLLAMA_SERVE_BLOBS, actual %d", w.Code)
+	}
+}
+
+func TestTenantCollections(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_API_KEYS", "a=team-a,b=team-b")
+
+	var s Server
+	r := gin.New()
+	r.Use(tenantMiddleware())
+	r.GET("/api/collections", s.ListCollectionsHandler)
+	r.POST("/api/collections", s.CreateCollectionHandler)
+	r.DELETE("/api/collections", s.DeleteCollectionHandler)
+	r.POST("/api/collections/points", s.UpsertPointsHandler)
+	r.DELETE("/api/collections/points", s.DeletePointsHandler)
+	r.POST("/api/collections/search", s.SearchCollectionHandler)
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(http.MethodPost, "/api/collections", "a", `{"name":"docs"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+	}
+
+	if w := do(http.MethodPost, "/api/collections/points", "a", `{"collection":"docs","points":[{"id":"x","embedding":[1,0]}]}`); w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+	}
+
+	// another tenant can't see, change or delete the collection
+	if w := do(http.MethodGet, "/api/collections", "b", ""); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "docs") {
+		t.Errorf("expected no collections, got %d %s", w.Code, w.Body)
+	}
+
+	for _, tt := range []struct{ method, path, body string }{
+		{http.MethodPost, "/api/collections/search", `{"collection":"docs","embedding":[1,0]}`},
+		{http.MethodPost, "/api/collections/points", `{"collection":"docs","points":[{"id":"x","embedding":[0,1]}]}`},
+		{http.MethodDelete, "/api/collections/points", `{"collection":"docs","ids":["x"]}`},
+		{http.MethodDelete, "/api/collections", `{"name":"docs"}`},
+	} {
+		if w := do(tt.method, tt.path, "b", tt.body); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s: expected status code 404, actual %d: %s", tt.method, tt.path, w.Code, w.Body)
+		}
+	}
+
+	// nor does creating its own collection of the same name
+	if w := do(http.MethodPost, "/api/collections", "b", `{"name":"docs"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+	}
+
+	w := do(http.MethodPost, "/api/collections/search", "a", `{"collection":"docs","embedding":[1,0]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, actual %d: %s", w.Code, w.Body)
+	}
+
+	var resp api.SearchCollectionResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resp.Results) != 1 || resp.Results[0].ID != "x" || resp.Results[0].Score != 1 {
+		t.Errorf("expected the point of the tenant to be unchanged, got %+v", resp.Results)
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// thinkingTags are the tags which models wrap their reasoning in. The first
+// pair whose opening tag is in the template of a model is used, or <think>
+// if there is none.
+var thinkingTags = [][2]string{
+	{"<think>", "</think>"},
+	{"<thinking>", "</thinking>"},
+	{"<|begin_of_thought|>", "<|end_of_thought|>"},
+	{"<|channel|>analysis<|message|>", "<|end|>"},
+}
+
+// thinks returns whether the template of m supports thinking, which
+// templates opt into by using the Think or Thinking variables.
+func (m *Model) thinks() bool {
+	if m.Template == nil {
+		return false
+	}
+
+	vars := m.Template.Vars()
+	return slices.Contains(vars, "think") || slices.Contains(vars, "thinking")
+}
+
+type thinkingState int
+
+const (
+	// thinkingLookingForOpen is before the opening tag, which only
+	// whitespace may precede
+	thinkingLookingForOpen thinkingState = iota
+	thinkingThinking
+	thinkingDone
+)
+
+// thinkingParser splits the reasoning of a model from its content as its
+// response is streamed. Reasoning is only recognized at the start of the
+// response, or if the prompt ends with the opening tag.
+type thinkingParser struct {
+	open, close string
+
+	state thinkingState
+
+	// buf is the response which might be the start of a tag
+	buf string
+
+	// thinking and content are whether any reasoning or content has been
+	// returned, before which leading whitespace is trimmed
+	thinking, content bool
+}
+
+func newThinkingParser(m *Model, prompt string) *thinkingParser {
+	p := thinkingParser{open: thinkingTags[0][0], close: thinkingTags[0][1]}
+	for _, tags := range thinkingTags {
+		if strings.Contains(m.Template.String(), tags[0]) {
+			p.open, p.close = tags[0], tags[1]
+			break
+		}
+	}
+
+	// templates may start the response with the opening tag
+	if strings.HasSuffix(strings.TrimRightFunc(prompt, unicode.IsSpace), p.open) {
+		p.state = thinkingThinking
+	}
+
+	return &p
+}
+
+// add adds s to the response, returning the reasoning and content which are
+// complete.
+func (p *thinkingParser) add(s string) (thinking, content string) {
+	p.buf += s
+
+	var tb, cb strings.Builder
+	for p.buf != "" {
+		switch p.state {
+		case thinkingLookingForOpen:
+			trimmed := strings.TrimLeftFunc(p.buf, unicode.IsSpace)
+			if after, ok := strings.CutPrefix(trimmed, p.open); ok {
+				p.buf, p.state = after, thinkingThinking
+			} else if strings.HasPrefix(p.open, trimmed) {
+				// wait for the rest of the tag
+				return p.trim(tb.String(), cb.String())
+			} else {
+				p.state = thinkingDone
+			}
+		case thinkingThinking:
+			if before, after, ok := strings.Cut(p.buf, p.close); ok {
+				tb.WriteString(before)
+				p.buf, p.state = after, thinkingDone
+				continue
+			}
+
+			// keep the end of the reasoning which might be the start of
+			// the closing tag
+			n := len(p.buf)
+			for i := max(0, len(p.buf)-len(p.close)+1); i < len(p.buf); i++ {
+				if strings.HasPrefix(p.close, p.buf[i:]) {
+					n = i
+					break
+				}
+			}
+
+			tb.WriteString(p.buf[:n])
+			p.buf = p.buf[n:]
+			return p.trim(tb.String(), cb.String())
+		case thinkingDone:
+			cb.WriteString(p.buf)
+			p.buf = ""
+		}
+	}
+
+	return p.trim(tb.String(), cb.String())
+}
+
+// flush returns the rest of the response at its end.
+func (p *thinkingParser) flush() (thinking, content string) {
+	s := p.buf
+	p.buf = ""
+
+	if p.state == thinkingThinking {
+		return p.trim(s, "")
+	}
+
+	return p.trim("", s)
+}
+
+// trim trims the whitespace at the start of the reasoning and the content.
+func (p *thinkingParser) trim(thinking, content string) (string, string) {
+	if !p.thinking {
+		thinking = strings.TrimLeftFunc(thinking, unicode.IsSpace)
+		p.thinking = thinking != ""
+	}
+
+	if !p.content {
+		content = strings.TrimLeftFunc(content, unicode.IsSpace)
+		p.content = content != ""
+	}
+
+	return thinking, content
+}
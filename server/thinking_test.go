@@ -0,0 +1,95 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/ollama/ollama/template"
+)
+
+func TestThinkingParser(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		prompt   string
+		chunks   []string
+		thinking string
+		content  string
+	}{
+		{
+			name:     "tags",
+			template: "{{ if .Think }}<think>{{ end }}",
+			chunks:   []string{"<think>Let me think.</think>\n\nThe answer is 4."},
+			thinking: "Let me think.",
+			content:  "The answer is 4.",
+		},
+		{
+			name:     "split tags",
+			template: "{{ if .Think }}<think>{{ end }}",
+			chunks:   []string{" <thi", "nk>", "Let me ", "think.</", "thi", "nk>", "The answer", " is 4."},
+			thinking: "Let me think.",
+			content:  "The answer is 4.",
+		},
+		{
+			name:     "prompt ends with tag",
+			template: "{{ .Prompt }}{{ if .Think }}<think>{{ end }}",
+			prompt:   "2 + 2 = ?<think>\n",
+			chunks:   []string{"Let me think.", "</think>", "4"},
+			thinking: "Let me think.",
+			content:  "4",
+		},
+		{
+			name:     "no reasoning",
+			template: "{{ if .Think }}<think>{{ end }}",
+			chunks:   []string{"The answer <think> is ", "4."},
+			content:  "The answer <think> is 4.",
+		},
+		{
+			name:     "unterminated reasoning",
+			template: "{{ if .Think }}<think>{{ end }}",
+			chunks:   []string{"<think>Let me think.</thi"},
+			thinking: "Let me think.</thi",
+		},
+		{
+			name:     "other tags",
+			template: "{{ if .Thinking }}<|begin_of_thought|>{{ end }}",
+			chunks:   []string{"<|begin_of_thought|>Let me think.<|end_of_", "thought|>4"},
+			thinking: "Let me think.",
+			content:  "4",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := template.Parse(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m := &Model{Template: tmpl}
+			if !m.thinks() {
+				t.Fatal("expected model to think")
+			}
+
+			p := newThinkingParser(m, tt.prompt)
+
+			var thinking, content string
+			for _, chunk := range tt.chunks {
+				th, c := p.add(chunk)
+				thinking += th
+				content += c
+			}
+
+			th, c := p.flush()
+			thinking += th
+			content += c
+
+			if thinking != tt.thinking {
+				t.Errorf("expected thinking %q, got %q", tt.thinking, thinking)
+			}
+
+			if content != tt.content {
+				t.Errorf("expected content %q, got %q", tt.content, content)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/ollama/ollama/llm"
+)
+
+// tokenizeConcurrency is how many inputs of a request are tokenized at once.
+var tokenizeConcurrency = runtime.GOMAXPROCS(0)
+
+// tokenizeInputs tokenizes inputs with r concurrently, returning their tokens
+// in the order of inputs. If any input can't be tokenized, the error names
+// the first of them to fail.
+func tokenizeInputs(ctx context.Context, r llm.LlamaServer, inputs []string) ([][]int, error) {
+	tokens := make([][]int, len(inputs))
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(tokenizeConcurrency)
+	for i, input := range inputs {
+		g.Go(func() error {
+			t, err := r.Tokenize(ctx, input)
+			if err != nil {
+				return fmt.Errorf("input %d: %w", i, err)
+			}
+
+			tokens[i] = t
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
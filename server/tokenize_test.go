@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/llm"
+)
+
+type tokenizeRunner struct {
+	llm.LlamaServer
+
+	active, peak atomic.Int32
+}
+
+func (r *tokenizeRunner) Tokenize(_ context.Context, s string) ([]int, error) {
+	n := r.active.Add(1)
+	defer r.active.Add(-1)
+	for {
+		peak := r.peak.Load()
+		if n <= peak || r.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+
+	time.Sleep(time.Millisecond)
+	if s == "bad" {
+		return nil, errors.New("invalid input")
+	}
+
+	return []int{len(s)}, nil
+}
+
+func TestTokenizeInputs(t *testing.T) {
+	concurrency := tokenizeConcurrency
+	tokenizeConcurrency = 4
+	t.Cleanup(func() { tokenizeConcurrency = concurrency })
+
+	var inputs []string
+	var want [][]int
+	for i := range 32 {
+		inputs = append(inputs, strings.Repeat("a", i))
+		want = append(want, []int{i})
+	}
+
+	var r tokenizeRunner
+	tokens, err := tokenizeInputs(t.Context(), &r, inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(want, tokens); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	if peak := r.peak.Load(); peak < 2 || peak > 4 {
+		t.Errorf("expected between 2 and 4 inputs to be tokenized at once, got %d", peak)
+	}
+
+	_, err = tokenizeInputs(t.Context(), &r, []string{"good", "bad"})
+	if err == nil || err.Error() != "input 1: invalid input" {
+		t.Errorf("expected error for input 1, got %v", err)
+	}
+}
@@ -3,9 +3,9 @@ package server
 import (
 	"context"
 	"crypto/md5"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"hash"
 	"io"
 	"log/slog"
 	"math"
@@ -20,6 +20,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
 	"github.com/ollama/ollama/format"
 )
 
@@ -35,6 +36,15 @@ type blobUpload struct {
 
 	nextURL chan *url.URL
 
+	// mu guards the upload state which is written as parts complete
+	mu         sync.Mutex
+	repository string
+	location   string
+	resumed    bool
+
+	// encoding is the content coding the registry accepts for parts, if any
+	encoding string
+
 	context.CancelFunc
 
 	file *os.File
@@ -46,16 +56,33 @@ type blobUpload struct {
 
 const (
 	numUploadParts          = 16
+	numUploadBlobs          = 4
 	minUploadPartSize int64 = 100 * format.MegaByte
 	maxUploadPartSize int64 = 1000 * format.MegaByte
 )
 
+// uploadRateLimiter is shared by all uploads so OLLAMA_MAX_UPLOAD_RATE caps
+// the combined bandwidth of every push.
+var uploadRateLimiter = newRateLimiter(envconfig.MaxUploadRate)
+
 func (b *blobUpload) Prepare(ctx context.Context, requestURL *url.URL, opts *registryOptions) error {
 	p, err := GetBlobsPath(b.Digest)
 	if err != nil {
 		return err
 	}
 
+	fi, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	b.Total = fi.Size()
+
+	if b.resume(requestURL) {
+		return nil
+	}
+
+	b.repository = requestURL.String()
 	if b.From != "" {
 		values := requestURL.Query()
 		values.Add("mount", b.Digest)
@@ -74,13 +101,12 @@ func (b *blobUpload) Prepare(ctx context.Context, requestURL *url.URL, opts *reg
 		location = resp.Header.Get("Location")
 	}
 
-	fi, err := os.Stat(p)
-	if err != nil {
-		return err
+	// registries which decompress uploads say so when the upload starts
+	// ref: https://www.rfc-editor.org/rfc/rfc7694
+	if acceptsEncoding(resp.Header, zstdEncoding) {
+		b.encoding = zstdEncoding
 	}
 
-	b.Total = fi.Size()
-
 	// http.StatusCreated indicates a blob has been mounted
 	// ref: https://distribution.github.io/distribution/spec/api/#cross-repository-blob-mount
 	if resp.StatusCode == http.StatusCreated {
@@ -117,11 +143,117 @@ func (b *blobUpload) Prepare(ctx context.Context, requestURL *url.URL, opts *reg
 		return err
 	}
 
+	b.location = location
+	if err := b.writeState(); err != nil {
+		slog.Warn("failed to save upload state", "digest", b.Digest, "error", err)
+	}
+
 	b.nextURL = make(chan *url.URL, 1)
 	b.nextURL <- requestURL
 	return nil
 }
 
+// blobUploadState is saved next to the blob while it's being uploaded so an
+// interrupted push can resume from the parts the registry already has.
+type blobUploadState struct {
+	// Repository is the endpoint the upload session was started with
+	Repository string `json:"repository"`
+	// Location is the latest upload location returned by the registry
+	Location string           `json:"location"`
+	Total    int64            `json:"total"`
+	Parts    []blobUploadPart `json:"parts"`
+	// Encoding is the content coding the registry accepts for parts
+	Encoding string `json:"encoding,omitempty"`
+}
+
+func uploadStatePath(digest string) (string, error) {
+	p, err := GetBlobsPath(digest)
+	if err != nil {
+		return "", err
+	}
+
+	return p + "-upload", nil
+}
+
+// resume restores the upload session and completed parts of an earlier,
+// interrupted upload of this blob to the same repository. It reports
+// whether there was an upload to resume.
+func (b *blobUpload) resume(requestURL *url.URL) bool {
+	p, err := uploadStatePath(b.Digest)
+	if err != nil {
+		return false
+	}
+
+	bts, err := os.ReadFile(p)
+	if err != nil {
+		return false
+	}
+
+	var state blobUploadState
+	if err := json.Unmarshal(bts, &state); err != nil {
+		slog.Warn("discarding invalid upload state", "digest", b.Digest, "error", err)
+		os.Remove(p)
+		return false
+	}
+
+	if state.Repository != requestURL.String() || state.Total != b.Total || len(state.Parts) == 0 {
+		return false
+	}
+
+	nextURL, err := url.Parse(state.Location)
+	if err != nil {
+		return false
+	}
+
+	var completed int
+	for _, part := range state.Parts {
+		if part.Sum != nil {
+			b.Completed.Add(part.Size)
+			completed++
+		}
+	}
+
+	slog.Info(fmt.Sprintf("resuming upload of %s, %d of %d part(s) already uploaded", b.Digest[7:19], completed, len(state.Parts)))
+
+	b.Parts = state.Parts
+	b.repository = state.Repository
+	b.location = state.Location
+	b.encoding = state.Encoding
+	b.resumed = true
+
+	b.nextURL = make(chan *url.URL, 1)
+	b.nextURL <- nextURL
+	return true
+}
+
+// writeState saves the upload session and the parts completed so far. The
+// caller must hold b.mu unless no parts are being uploaded.
+func (b *blobUpload) writeState() error {
+	p, err := uploadStatePath(b.Digest)
+	if err != nil {
+		return err
+	}
+
+	bts, err := json.Marshal(blobUploadState{
+		Repository: b.repository,
+		Location:   b.location,
+		Total:      b.Total,
+		Parts:      b.Parts,
+		Encoding:   b.encoding,
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(p, bts, 0o644)
+}
+
+func (b *blobUpload) removeState() {
+	if p, err := uploadStatePath(b.Digest); err == nil {
+		os.Remove(p)
+	}
+}
+
 // Run uploads blob parts to the upstream. If the upstream supports redirection, parts will be uploaded
 // in parallel as defined by Prepare. Otherwise, parts will be uploaded serially. Run sets b.err on error.
 func (b *blobUpload) Run(ctx context.Context, opts *registryOptions) {
@@ -142,9 +274,14 @@ func (b *blobUpload) Run(ctx context.Context, opts *registryOptions) {
 	defer b.file.Close()
 
 	g, inner := errgroup.WithContext(ctx)
-	g.SetLimit(numUploadParts)
+	g.SetLimit(max(1, int(envconfig.UploadConcurrency())))
 	for i := range b.Parts {
 		part := &b.Parts[i]
+		if part.Sum != nil {
+			// uploaded before the upload was interrupted
+			continue
+		}
+
 		select {
 		case <-inner.Done():
 		case requestURL := <-b.nextURL:
@@ -173,6 +310,11 @@ func (b *blobUpload) Run(ctx context.Context, opts *registryOptions) {
 	}
 
 	if err := g.Wait(); err != nil {
+		if b.resumed && !errors.Is(err, context.Canceled) {
+			// the registry may have expired the upload session so start over next time
+			b.removeState()
+		}
+
 		b.err = err
 		return
 	}
@@ -182,7 +324,7 @@ func (b *blobUpload) Run(ctx context.Context, opts *registryOptions) {
 	// calculate md5 checksum and add it to the commit request
 	md5sum := md5.New()
 	for _, part := range b.Parts {
-		md5sum.Write(part.Sum(nil))
+		md5sum.Write(part.Sum)
 	}
 
 	values := requestURL.Query()
@@ -209,6 +351,10 @@ func (b *blobUpload) Run(ctx context.Context, opts *registryOptions) {
 		break
 	}
 
+	if err == nil || b.resumed && !errors.Is(err, context.Canceled) {
+		b.removeState()
+	}
+
 	b.err = err
 	b.done = true
 }
@@ -216,7 +362,6 @@ func (b *blobUpload) Run(ctx context.Context, opts *registryOptions) {
 func (b *blobUpload) uploadPart(ctx context.Context, method string, requestURL *url.URL, part *blobUploadPart, opts *registryOptions) error {
 	headers := make(http.Header)
 	headers.Set("Content-Type", "application/octet-stream")
-	headers.Set("Content-Length", strconv.FormatInt(part.Size, 10))
 
 	if method == http.MethodPatch {
 		headers.Set("X-Redirect-Uploads", "1")
@@ -224,11 +369,27 @@ func (b *blobUpload) uploadPart(ctx context.Context, method string, requestURL *
 	}
 
 	sr := io.NewSectionReader(b.file, part.Offset, part.Size)
+	r := &rateLimitedReader{ctx: ctx, r: sr, limiters: []*rateLimiter{uploadRateLimiter}}
 
 	md5sum := md5.New()
 	w := &progressWriter{blobUpload: b}
 
-	resp, err := makeRequest(ctx, method, requestURL, headers, io.TeeReader(sr, io.MultiWriter(w, md5sum)), opts)
+	// progress and checksums are of the uncompressed part, whose range
+	// Content-Range still describes, but the compressed length isn't known
+	// until it's sent
+	var body io.Reader = io.TeeReader(r, io.MultiWriter(w, md5sum))
+	encoding := b.partEncoding()
+	if method == http.MethodPatch && encoding != "" {
+		headers.Set("Content-Encoding", encoding)
+
+		zr := compressReader(body)
+		defer zr.Close()
+		body = zr
+	} else {
+		headers.Set("Content-Length", strconv.FormatInt(part.Size, 10))
+	}
+
+	resp, err := makeRequest(ctx, method, requestURL, headers, body, opts)
 	if err != nil {
 		w.Rollback()
 		return err
@@ -249,6 +410,7 @@ func (b *blobUpload) uploadPart(ctx context.Context, method string, requestURL *
 	switch {
 	case resp.StatusCode == http.StatusTemporaryRedirect:
 		w.Rollback()
+		b.setLocation(location)
 		b.nextURL <- nextURL
 
 		redirectURL, err := resp.Location()
@@ -276,6 +438,15 @@ func (b *blobUpload) uploadPart(ctx context.Context, method string, requestURL *
 
 		return fmt.Errorf("%w: %w", errMaxRetriesExceeded, err)
 
+	case resp.StatusCode == http.StatusUnsupportedMediaType && method == http.MethodPatch && encoding != "":
+		w.Rollback()
+
+		// the part is retried uncompressed, as are the parts after it
+		b.mu.Lock()
+		b.encoding = ""
+		b.mu.Unlock()
+		return fmt.Errorf("registry rejected %s compressed part: %s", encoding, resp.Status)
+
 	case resp.StatusCode == http.StatusUnauthorized:
 		w.Rollback()
 		challenge := parseRegistryChallenge(resp.Header.Get("www-authenticate"))
@@ -297,13 +468,32 @@ func (b *blobUpload) uploadPart(ctx context.Context, method string, requestURL *
 	}
 
 	if method == http.MethodPatch {
+		b.setLocation(location)
 		b.nextURL <- nextURL
 	}
 
-	part.Hash = md5sum
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	part.Sum = md5sum.Sum(nil)
+	if err := b.writeState(); err != nil {
+		slog.Warn("failed to save upload state", "digest", b.Digest, "error", err)
+	}
+
 	return nil
 }
 
+func (b *blobUpload) partEncoding() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.encoding
+}
+
+func (b *blobUpload) setLocation(location string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.location = location
+}
+
 func (b *blobUpload) acquire() {
 	b.references.Add(1)
 }
@@ -341,10 +531,11 @@ func (b *blobUpload) Wait(ctx context.Context, fn func(api.ProgressResponse)) er
 
 type blobUploadPart struct {
 	// N is the part number
-	N      int
-	Offset int64
-	Size   int64
-	hash.Hash
+	N      int   `json:"n"`
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size"`
+	// Sum is the md5 checksum of the part once it's been uploaded
+	Sum []byte `json:"sum,omitempty"`
 }
 
 type progressWriter struct {
@@ -364,6 +555,30 @@ func (p *progressWriter) Rollback() {
 	p.written = 0
 }
 
+// uploadBlobs uploads the layers of a model to the registry, several at a time.
+func uploadBlobs(ctx context.Context, mp ModelPath, layers []Layer, opts *registryOptions, fn func(api.ProgressResponse)) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(numUploadBlobs)
+	for _, layer := range layers {
+		g.Go(func() error {
+			// blobs evicted from the cache have to be fetched before
+			// they can be pushed
+			if _, err := fetchBlob(ctx, layer.Digest); err != nil {
+				return err
+			}
+
+			if err := uploadBlob(ctx, mp, layer, opts, fn); err != nil {
+				slog.Info(fmt.Sprintf("error uploading blob: %v", err))
+				return err
+			}
+
+			return nil
+		})
+	}
+
+	return g.Wait()
+}
+
 func uploadBlob(ctx context.Context, mp ModelPath, layer Layer, opts *registryOptions, fn func(api.ProgressResponse)) error {
 	requestURL := mp.BaseURL()
 	requestURL = requestURL.JoinPath("v2", mp.GetNamespaceRepository(), "blobs", layer.Digest)
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/ollama/ollama/types/model"
+)
+
+func TestBlobUploadResume(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	blob := []byte("a blob pushed to the registry")
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(blob))
+
+	// the blob is used by a model, so pruning at startup keeps it
+	layer, err := NewLayer(bytes.NewReader(blob), "application/vnd.ollama.image.model")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteManifest(t.Context(), model.ParseName("test"), layer, []Layer{layer}); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	var etag string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests[r.Method]++
+
+		switch r.Method {
+		case http.MethodPost:
+			w.Header().Set("Location", "http://"+r.Host+"/upload/1")
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPatch:
+			io.Copy(io.Discard, r.Body)
+			w.Header().Set("Location", "http://"+r.Host+"/upload/2")
+			w.WriteHeader(http.StatusAccepted)
+		case http.MethodPut:
+			etag = r.URL.Query().Get("etag")
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	requestURL, err := url.Parse(srv.URL + "/v2/library/test/blobs/uploads/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statePath, err := uploadStatePath(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func() *blobUpload {
+		b := &blobUpload{Layer: Layer{Digest: digest, Size: int64(len(blob))}}
+		if err := b.Prepare(context.Background(), requestURL.JoinPath(), &registryOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		return b
+	}
+
+	b := upload()
+	if _, err := os.Stat(statePath); err != nil {
+		t.Fatalf("expected upload state to be saved: %v", err)
+	}
+
+	b.Run(context.Background(), &registryOptions{})
+	if b.err != nil {
+		t.Fatal(b.err)
+	}
+
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Errorf("expected upload state to be removed, got %v", err)
+	}
+
+	expectEtag := etag
+
+	// pretend the upload was interrupted after its only part was uploaded
+	sum := md5.Sum(blob)
+	bts, err := json.Marshal(blobUploadState{
+		Repository: requestURL.String(),
+		Location:   srv.URL + "/upload/2",
+		Total:      int64(len(blob)),
+		Parts:      []blobUploadPart{{N: 0, Offset: 0, Size: int64(len(blob)), Sum: sum[:]}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(statePath, bts, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server is restarted before the push is resumed
+	if err := PruneLayers(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(statePath); err != nil {
+		t.Fatalf("expected upload state to be kept by pruning: %v", err)
+	}
+
+	clear(requests)
+
+	b = upload()
+	if completed := b.Completed.Load(); completed != int64(len(blob)) {
+		t.Errorf("expected resumed upload to be complete, got %d", completed)
+	}
+
+	b.Run(context.Background(), &registryOptions{})
+	if b.err != nil {
+		t.Fatal(b.err)
+	}
+
+	if requests[http.MethodPost] != 0 || requests[http.MethodPatch] != 0 || requests[http.MethodPut] != 1 {
+		t.Errorf("expected only the commit request, got %v", requests)
+	}
+
+	if etag != expectEtag {
+		t.Errorf("expected etag %q, got %q", expectEtag, etag)
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// webhookAttempts is how many times a webhook is sent before it's dropped,
+// waiting webhookBackoff after the first failure and twice as long after
+// each one after that.
+var (
+	webhookAttempts = 4
+	webhookBackoff  = time.Second
+)
+
+var (
+	webhookQueue = make(chan api.WebhookEvent, 256)
+	webhookOnce  sync.Once
+	webhookHTTP  = &http.Client{Timeout: 10 * time.Second}
+)
+
+// webhookURLs are the URLs in OLLAMA_WEBHOOKS.
+func webhookURLs() []string {
+	var urls []string
+	for u := range strings.SplitSeq(envconfig.Webhooks(), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+
+	return urls
+}
+
+// webhookEnabled reports whether webhooks are sent for event. Completed
+// requests are so frequent that they're only sent when asked for.
+func webhookEnabled(event string) bool {
+	events := envconfig.WebhookEvents()
+	if events == "" {
+		return event != api.WebhookRequestCompleted
+	}
+
+	return slices.ContainsFunc(strings.Split(events, ","), func(s string) bool {
+		s = strings.TrimSpace(s)
+		return s == "*" || strings.EqualFold(s, event)
+	})
+}
+
+// emitWebhook queues ev to be sent to the webhooks, if there are any which
+// want it. Webhooks are sent in the background, in the order they happen,
+// and events are dropped rather than holding up the server when the queue
+// is full.
+func emitWebhook(ev api.WebhookEvent) {
+	if len(webhookURLs()) == 0 || !webhookEnabled(ev.Event) {
+		return
+	}
+
+	ev.CreatedAt = time.Now().UTC()
+	webhookOnce.Do(func() { go deliverWebhooks() })
+
+	select {
+	case webhookQueue <- ev:
+	default:
+		slog.Warn("webhook queue is full, dropping event", "event", ev.Event, "model", ev.Model)
+	}
+}
+
+func deliverWebhooks() {
+	for ev := range webhookQueue {
+		body, err := json.Marshal(ev)
+		if err != nil {
+			slog.Warn("failed to encode webhook", "event", ev.Event, "error", err)
+			continue
+		}
+
+		// every attempt has the same delivery ID so receivers can ignore
+		// retries of webhooks they've already handled
+		delivery := uuid.NewString()
+		for _, u := range webhookURLs() {
+			if err := sendWebhook(context.Background(), u, ev.Event, delivery, body); err != nil {
+				slog.Warn("failed to send webhook", "url", u, "event", ev.Event, "error", err)
+			}
+		}
+	}
+}
+
+// sendWebhook POSTs body to u, retrying with backoff until it's accepted
+// with a 2xx status code or it runs out of attempts.
+func sendWebhook(ctx context.Context, u, event, delivery string, body []byte) error {
+	backoff := webhookBackoff
+
+	var err error
+	for attempt := range webhookAttempts {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff *= 2
+		}
+
+		if err = postWebhook(ctx, u, event, delivery, body); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func postWebhook(ctx context.Context, u, event, delivery string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ollama")
+	req.Header.Set("X-Ollama-Event", event)
+	req.Header.Set("X-Ollama-Delivery", delivery)
+	if secret := envconfig.WebhookSecret(); secret != "" {
+		req.Header.Set("X-Ollama-Signature", "sha256="+webhookSignature(secret, body))
+	}
+
+	resp, err := webhookHTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// webhookSignature is the hex encoded HMAC-SHA256 of body with secret.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
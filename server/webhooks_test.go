@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestWebhookEnabled(t *testing.T) {
+	cases := []struct {
+		events string
+		event  string
+		want   bool
+	}{
+		{"", api.WebhookModelPulled, true},
+		{"", api.WebhookRunnerCrashed, true},
+		{"", api.WebhookRequestCompleted, false},
+		{"*", api.WebhookRequestCompleted, true},
+		{"model.pulled, runner.crashed", api.WebhookRunnerCrashed, true},
+		{"model.pulled, runner.crashed", api.WebhookRunnerLoaded, false},
+	}
+
+	for _, tt := range cases {
+		t.Setenv("OLLAMA_WEBHOOK_EVENTS", tt.events)
+		if got := webhookEnabled(tt.event); got != tt.want {
+			t.Errorf("%q with %q: expected %v, got %v", tt.event, tt.events, tt.want, got)
+		}
+	}
+}
+
+func TestEmitWebhook(t *testing.T) {
+	webhookBackoff = time.Millisecond
+	t.Cleanup(func() { webhookBackoff = time.Second })
+
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+
+	var failures int
+	deliveries := make(chan delivery, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+
+		// fail the first attempt to check it's retried
+		if failures++; failures == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		deliveries <- delivery{r.Header, body}
+	}))
+	defer srv.Close()
+
+	t.Setenv("OLLAMA_WEBHOOKS", srv.URL)
+	t.Setenv("OLLAMA_WEBHOOK_SECRET", "secret")
+
+	emitWebhook(api.WebhookEvent{Event: api.WebhookRequestCompleted, Model: "test"})
+	emitWebhook(api.WebhookEvent{Event: api.WebhookModelPulled, Model: "test", Tenant: "team-a"})
+
+	var d delivery
+	select {
+	case d = <-deliveries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook")
+	}
+
+	if got := d.header.Get("X-Ollama-Event"); got != api.WebhookModelPulled {
+		t.Errorf("expected event %q, got %q", api.WebhookModelPulled, got)
+	}
+
+	if got, want := d.header.Get("X-Ollama-Signature"), "sha256="+webhookSignature("secret", d.body); got != want {
+		t.Errorf("expected signature %q, got %q", want, got)
+	}
+
+	var ev api.WebhookEvent
+	if err := json.Unmarshal(d.body, &ev); err != nil {
+		t.Fatal(err)
+	}
+
+	if ev.Event != api.WebhookModelPulled || ev.Model != "test" || ev.Tenant != "team-a" || ev.CreatedAt.IsZero() {
+		t.Errorf("unexpected event %+v", ev)
+	}
+
+	select {
+	case d := <-deliveries:
+		t.Errorf("unexpected webhook %s", d.body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	// echo -n '{"event":"model.pulled"}' | openssl dgst -sha256 -hmac secret
+	want := "c233e3b0f9b64f76141bcaba839d876a9e26b3b75056b3a931c1d787d2f0e7b8"
+	if got := webhookSignature("secret", []byte(`{"event":"model.pulled"}`)); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
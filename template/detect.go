@@ -0,0 +1,149 @@
+package template
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/agnivade/levenshtein"
+)
+
+// minScore is the score a chat template must have to be matched to a
+// template when its hash doesn't match any
+const minScore = 0.9
+
+// architectureBonus is added to the score of templates which are commonly
+// used by the architecture of a model
+const architectureBonus = 0.1
+
+// architectures are the templates commonly used by models of a GGUF
+// general.architecture, which break ties between similar chat templates.
+var architectures = map[string][]string{
+	"llama":      {"llama3-instruct", "llama2-chat", "mistral-instruct"},
+	"mistral3":   {"mistral-instruct"},
+	"gemma":      {"gemma-instruct"},
+	"gemma2":     {"gemma-instruct"},
+	"gemma3":     {"gemma3-instruct"},
+	"phi3":       {"phi-3"},
+	"command-r":  {"command-r"},
+	"granite":    {"granite-instruct"},
+	"starcoder2": {"starcoder2-instruct"},
+	"falcon":     {"falcon-instruct"},
+	"qwen2":      {"chatml"},
+}
+
+// Candidate is a template scored against a chat template.
+type Candidate struct {
+	Name  string  `json:"name"`
+	Score float64 `json:"score"`
+}
+
+// Report describes how a chat template was matched to a template.
+type Report struct {
+	// Hash is the hash of the normalized chat template
+	Hash string `json:"hash"`
+
+	// Method is "hash" if the hash of the chat template matched a template,
+	// or "score" if the best scoring template was used. It's empty if no
+	// template matched.
+	Method string `json:"method,omitempty"`
+
+	// Candidates are the best scoring templates, best first. Scores are
+	// the similarity of the normalized templates from 0 to 1, including
+	// the bonus for templates of the architecture. Templates are only
+	// scored if no hash matched.
+	Candidates []Candidate `json:"candidates,omitempty"`
+}
+
+var errNoMatch = errors.New("no matching template found")
+
+// Detect returns the template for a tokenizer.chat_template from a GGUF.
+// Chat templates are matched by the hash of their normalized form, or else
+// scored by similarity, favoring templates used by the architecture, which
+// may be empty.
+func Detect(chatTemplate, architecture string) (*named, Report, error) {
+	templates, err := templatesOnce()
+	if err != nil {
+		return nil, Report{}, err
+	}
+
+	s := normalize(chatTemplate)
+	report := Report{Hash: hash(s)}
+	for _, t := range templates {
+		if t.hash == report.Hash {
+			report.Method = "hash"
+			return t, report, nil
+		}
+	}
+
+	best := make(map[string]*named)
+	scores := make(map[string]float64)
+	for _, t := range templates {
+		score := similarity(s, t.normalized)
+		if slices.Contains(architectures[architecture], t.Name) {
+			score += architectureBonus
+		}
+
+		// the index has several chat templates of some templates
+		if _, ok := best[t.Name]; !ok || score > scores[t.Name] {
+			best[t.Name], scores[t.Name] = t, score
+		}
+	}
+
+	for name, score := range scores {
+		report.Candidates = append(report.Candidates, Candidate{Name: name, Score: score})
+	}
+
+	slices.SortFunc(report.Candidates, func(a, b Candidate) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+
+		return strings.Compare(a.Name, b.Name)
+	})
+	report.Candidates = report.Candidates[:min(3, len(report.Candidates))]
+
+	if len(report.Candidates) == 0 || report.Candidates[0].Score < minScore {
+		return nil, report, errNoMatch
+	}
+
+	report.Method = "score"
+	return best[report.Candidates[0].Name], report, nil
+}
+
+var (
+	trimMarkers  = strings.NewReplacer("{{-", "{{", "{%-", "{%", "{#-", "{#", "-}}", "}}", "-%}", "%}", "-#}", "#}")
+	reSpace      = regexp.MustCompile(`\s+`)
+	reDelimSpace = regexp.MustCompile(`\s*(\{[{%#]|[}%#]\})\s*`)
+)
+
+// normalize removes the differences between chat templates which don't
+// change what they render much: line endings, whitespace, whitespace
+// control markers and quotes.
+func normalize(s string) string {
+	s = trimMarkers.Replace(s)
+	s = reSpace.ReplaceAllString(s, " ")
+	s = reDelimSpace.ReplaceAllString(s, "$1")
+	s = strings.ReplaceAll(s, `"`, `'`)
+	return strings.TrimSpace(s)
+}
+
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// similarity is 1 minus the edit distance between a and b relative to the
+// longer of them.
+func similarity(a, b string) float64 {
+	n := max(len(a), len(b))
+	if n == 0 {
+		return 1
+	}
+
+	return 1 - float64(levenshtein.ComputeDistance(a, b))/float64(n)
+}
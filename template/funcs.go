@@ -0,0 +1,115 @@
+package template
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// funcs defines template helper functions available within templates.
+// Functions which take a string or messages take them last so they can be
+// used in pipelines, e.g. {{ .Content | trimPrefix " " }}.
+var funcs = template.FuncMap{
+	// json encodes v, indented by the number of spaces given, if any
+	"json": func(v any, indent ...int) string {
+		var b []byte
+		if len(indent) > 0 && indent[0] > 0 {
+			b, _ = json.MarshalIndent(v, "", strings.Repeat(" ", indent[0]))
+		} else {
+			b, _ = json.Marshal(v)
+		}
+
+		return string(b)
+	},
+
+	"now": time.Now,
+	// date formats t with a Go time layout, e.g. "02 Jan 2006"
+	"date": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+
+	"trim":       strings.TrimSpace,
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+	"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+	"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+	"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       func(sep string, elems []string) string { return strings.Join(elems, sep) },
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+
+	"regexMatch": func(pattern, s string) (bool, error) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, err
+		}
+
+		return re.MatchString(s), nil
+	},
+	// regexReplace replaces the matches of pattern in s with repl, which
+	// may refer to submatches as in [regexp.Regexp.ReplaceAllString]
+	"regexReplace": func(pattern, repl, s string) (string, error) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", err
+		}
+
+		return re.ReplaceAllString(s, repl), nil
+	},
+
+	// truncate truncates s to its first n characters. Templates can't
+	// tokenize, so it's an approximation of a token budget.
+	"truncate": func(n int, s string) string {
+		if r := []rune(s); n >= 0 && len(r) > n {
+			return string(r[:n])
+		}
+
+		return s
+	},
+
+	"byRole": func(role string, msgs []*Message) []*Message {
+		var filtered []*Message
+		for _, m := range msgs {
+			if m.Role == role {
+				filtered = append(filtered, m)
+			}
+		}
+
+		return filtered
+	},
+	"countRole": func(role string, msgs []*Message) int {
+		var n int
+		for _, m := range msgs {
+			if m.Role == role {
+				n++
+			}
+		}
+
+		return n
+	},
+	// lastIndexOfRole returns the index of the last message of role, or -1
+	// if there is none
+	"lastIndexOfRole": lastIndexOfRole,
+	// lastUserMessage returns the last user message, or nil if there is none
+	"lastUserMessage": func(msgs []*Message) *Message {
+		if i := lastIndexOfRole("user", msgs); i >= 0 {
+			return msgs[i]
+		}
+
+		return nil
+	},
+}
+
+func lastIndexOfRole(role string, msgs []*Message) int {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role == role {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,67 @@
+package template
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestFuncs(t *testing.T) {
+	msgs := []api.Message{
+		{Role: "system", Content: "You are a helpful assistant."},
+		{Role: "user", Content: "  What's 2 + 2?  "},
+		{Role: "assistant", Content: "<think>easy</think>4"},
+		{Role: "user", Content: "And 3 + 3?"},
+		{Role: "assistant", Content: "6"},
+	}
+
+	cases := []struct {
+		name     string
+		template string
+		values   Values
+		expected string
+	}{
+		{"json", `{{ json .Tools }}`, Values{Tools: api.Tools{{Type: "function"}}}, `[{"type":"function","function":{"name":"","description":"","parameters":{"type":"","required":null,"properties":null}}}]`},
+		{"json indent", `{{ json (index .Messages 0).Role 2 }} {{ json .Tools 2 }}`, Values{Messages: msgs, Tools: api.Tools{}}, "\"system\" []"},
+		{"date", `{{ date "2006" now }}`, Values{Messages: msgs}, time.Now().Format("2006")},
+		{"strings", `{{ range .Messages }}{{ .Content | trim | trimSuffix "?" | replace "+" "plus" | upper }}|{{ end }}`, Values{Messages: msgs}, "YOU ARE A HELPFUL ASSISTANT.|WHAT'S 2 PLUS 2|<THINK>EASY</THINK>4|AND 3 PLUS 3|6|"},
+		{"split and join", `{{ join "-" (split " " "a b c") }}`, Values{Messages: msgs}, "a-b-c"},
+		{"predicates", `{{ range .Messages }}{{ hasPrefix "<think>" .Content }} {{ contains "3" .Content }}|{{ end }}`, Values{Messages: msgs}, "false false|false false|true false|false true|false false|"},
+		{"regex", `{{ range .Messages }}{{ if regexMatch "^\\d+$" .Content }}{{ .Content }}{{ else }}{{ regexReplace "<think>.*</think>" "" .Content }}{{ end }}|{{ end }}`, Values{Messages: msgs}, "You are a helpful assistant.|  What's 2 + 2?  |4|And 3 + 3?|6|"},
+		{"truncate", `{{ range .Messages }}{{ truncate 4 .Content }}|{{ end }}`, Values{Messages: msgs}, "You |  Wh|<thi|And |6|"},
+		{"roles", `{{ countRole "user" .Messages }} {{ range byRole "assistant" .Messages }}{{ .Content }}|{{ end }} {{ lastIndexOfRole "user" .Messages }} {{ lastIndexOfRole "tool" .Messages }} {{ (lastUserMessage .Messages).Content }}`, Values{Messages: msgs}, "2 <think>easy</think>4|6| 3 -1 And 3 + 3?"},
+		{"last user message", `{{- $last := lastIndexOfRole "user" .Messages }}{{ range $i, $m := .Messages }}{{ if and (eq .Role "assistant") (gt $i $last) }}{{ .Content }}{{ end }}{{ end }}`, Values{Messages: msgs}, "6"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(tt.template + `{{ range .Messages }}{{ end }}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var b bytes.Buffer
+			if err := tmpl.Execute(&b, tt.values); err != nil {
+				t.Fatal(err)
+			}
+
+			if b.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, b.String())
+			}
+		})
+	}
+
+	t.Run("invalid regex", func(t *testing.T) {
+		tmpl, err := Parse(`{{ range .Messages }}{{ regexReplace "(" "" .Content }}{{ end }}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, Values{Messages: msgs}); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
@@ -4,16 +4,14 @@ import (
 	"bytes"
 	"embed"
 	"encoding/json"
-	"errors"
 	"io"
-	"math"
+	"regexp"
 	"slices"
 	"strings"
 	"sync"
 	"text/template"
 	"text/template/parse"
 
-	"github.com/agnivade/levenshtein"
 	"golang.org/x/exp/maps"
 
 	"github.com/ollama/ollama/api"
@@ -41,6 +39,8 @@ var templatesOnce = sync.OnceValues(func() ([]*named, error) {
 
 		// Normalize line endings to Unix style
 		t.Bytes = bytes.ReplaceAll(bts, []byte("\r\n"), []byte("\n"))
+		t.normalized = normalize(t.Template)
+		t.hash = hash(t.normalized)
 
 		params, err := templatesFS.ReadFile(t.Name + ".json")
 		if err != nil {
@@ -64,6 +64,9 @@ type named struct {
 	Parameters *struct {
 		Stop []string `json:"stop"`
 	}
+
+	// normalized and hash are the normalized chat template and its hash
+	normalized, hash string
 }
 
 // Reader returns an io.Reader for the raw template bytes
@@ -71,31 +74,6 @@ func (t named) Reader() io.Reader {
 	return bytes.NewReader(t.Bytes)
 }
 
-// Named looks up the closest matching template by Levenshtein distance
-func Named(s string) (*named, error) {
-	templates, err := templatesOnce()
-	if err != nil {
-		return nil, err
-	}
-
-	var bestMatch *named
-	bestScore := math.MaxInt
-
-	for _, t := range templates {
-		dist := levenshtein.ComputeDistance(s, t.Template)
-		if dist < bestScore {
-			bestScore = dist
-			bestMatch = t
-		}
-	}
-
-	if bestScore < 100 {
-		return bestMatch, nil
-	}
-
-	return nil, errors.New("no matching template found")
-}
-
 // DefaultTemplate is a simple template that outputs the Prompt
 var DefaultTemplate, _ = Parse("{{ .Prompt }}")
 
@@ -124,14 +102,6 @@ var response = parse.ActionNode{
 	},
 }
 
-// funcs defines template helper functions available within templates
-var funcs = template.FuncMap{
-	"json": func(v any) string {
-		b, _ := json.Marshal(v)
-		return string(b)
-	},
-}
-
 // Parse creates a new Template from a string, adding {{ .Response }} if needed
 func Parse(s string) (*Template, error) {
 	tmpl := template.New("").Option("missingkey=zero").Funcs(funcs)
@@ -157,6 +127,23 @@ func (t *Template) String() string {
 	return t.raw
 }
 
+// Stop returns the stop sequences of the built-in template which t is, or
+// nil if t isn't a built-in template
+func (t *Template) Stop() []string {
+	templates, err := templatesOnce()
+	if err != nil {
+		return nil
+	}
+
+	for _, named := range templates {
+		if named.Parameters != nil && string(named.Bytes) == t.raw {
+			return named.Parameters.Stop
+		}
+	}
+
+	return nil
+}
+
 // Vars returns a sorted list of all variable identifiers used in the template
 func (t *Template) Vars() []string {
 	var vars []string
@@ -184,6 +171,10 @@ type Values struct {
 	Prompt string
 	Suffix string
 
+	// Think is whether the model should reason before responding, for
+	// templates which support thinking
+	Think bool
+
 	forceLegacy bool // flag for legacy template compatibility testing
 }
 
@@ -253,6 +244,7 @@ func (t *Template) Execute(w io.Writer, v Values) error {
 			"System":   system,
 			"Messages": messages,
 			"Tools":    v.Tools,
+			"Think":    v.Think,
 			"Response": "",
 		})
 	}
@@ -268,6 +260,7 @@ func (t *Template) Execute(w io.Writer, v Values) error {
 				"System":   system,
 				"Prompt":   prompt,
 				"Response": response,
+				"Think":    v.Think,
 			})
 			system, prompt, response = "", "", ""
 			return err
@@ -310,6 +303,7 @@ func (t *Template) Execute(w io.Writer, v Values) error {
 		"System":   system,
 		"Prompt":   prompt,
 		"Response": response,
+		"Think":    v.Think,
 	}); err != nil {
 		return err
 	}
@@ -318,24 +312,47 @@ func (t *Template) Execute(w io.Writer, v Values) error {
 	return err
 }
 
-// collate merges consecutive messages of the same role and collects system messages
-// also mutates message content by appending image tags as needed
-func collate(msgs []api.Message) (string, []*api.Message) {
-	var system []string
-	var collated []*api.Message
+// Message is a message as templates see it.
+type Message struct {
+	api.Message
+
+	// ImageTags are the placeholders of the images of the message, such as
+	// [img-0], in the order they appear in its content
+	ImageTags []string `json:"-"`
+}
 
-	for i := range msgs {
-		msg := msgs[i]
+var reImageTag = regexp.MustCompile(`\[img-\d+\]`)
+
+// collate merges consecutive messages of the same role and author and collects system messages
+func collate(msgs []api.Message) (string, []*Message) {
+	var system []string
+	var collated []*Message
 
+	for _, msg := range msgs {
 		if msg.Role == "system" {
 			system = append(system, msg.Content)
 		}
 
-		if len(collated) > 0 && collated[len(collated)-1].Role == msg.Role {
-			collated[len(collated)-1].Content += "\n\n" + msg.Content
-		} else {
-			collated = append(collated, &msg)
+		if len(collated) > 0 {
+			// tool results of different calls stay separate
+			if last := collated[len(collated)-1]; last.Role == msg.Role && last.Name == msg.Name && last.ToolCallID == msg.ToolCallID {
+				last.Content += "\n\n" + msg.Content
+				if msg.Metadata != nil {
+					// copy so the metadata of msgs isn't changed
+					metadata := make(map[string]any, len(last.Metadata)+len(msg.Metadata))
+					maps.Copy(metadata, last.Metadata)
+					maps.Copy(metadata, msg.Metadata)
+					last.Metadata = metadata
+				}
+				continue
+			}
 		}
+
+		collated = append(collated, &Message{Message: msg})
+	}
+
+	for _, msg := range collated {
+		msg.ImageTags = reImageTag.FindAllString(msg.Content, -1)
 	}
 
 	return strings.Join(system, "\n\n"), collated
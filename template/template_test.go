@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
@@ -35,7 +36,7 @@ func TestNamed(t *testing.T) {
 			t.Run(k, func(t *testing.T) {
 				kv := ggml.KV{"tokenizer.chat_template": v}
 				s := kv.ChatTemplate()
-				r, err := Named(s)
+				r, report, err := Detect(s, "")
 				if err != nil {
 					t.Fatal(err)
 				}
@@ -44,6 +45,10 @@ func TestNamed(t *testing.T) {
 					t.Errorf("expected %q, got %q", k, r.Name)
 				}
 
+				if report.Method != "hash" {
+					t.Errorf("expected %q to match by hash, got %+v", k, report)
+				}
+
 				var b bytes.Buffer
 				if _, err := io.Copy(&b, r.Reader()); err != nil {
 					t.Fatal(err)
@@ -342,6 +347,53 @@ What is your name?<|im_end|>
 	}
 }
 
+func TestExecuteWithMessageContext(t *testing.T) {
+	tmpl, err := Parse(`
+{{- range .Messages }}
+{{- if eq .Role "assistant" }}[{{ range .ToolCalls }}{{ .ID }}:{{ .Function.Name }} {{ end }}]
+{{- else if eq .Role "tool" }}<{{ .ToolCallID }} {{ .Name }}>{{ .Content }}
+{{- else }}{{ .Role }}{{ with .Name }} ({{ . }}){{ end }}: {{ .Content }}{{ range .ImageTags }} {{ . }}{{ end }}{{ with .Metadata }} {{ .lang }}{{ end }}
+{{- end }}
+{{ end }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := []api.Message{
+		{Role: "user", Name: "alice", Content: "[img-0] What's the weather here and in [img-1]?", Metadata: map[string]any{"lang": "en"}},
+		{Role: "user", Name: "alice", Content: "Thanks!", Metadata: map[string]any{"lang": "en-GB"}},
+		{Role: "assistant", ToolCalls: []api.ToolCall{
+			{ID: "call_1", Function: api.ToolCallFunction{Name: "get_weather"}},
+			{ID: "call_2", Function: api.ToolCallFunction{Name: "get_weather"}},
+		}},
+		{Role: "tool", Name: "get_weather", ToolCallID: "call_1", Content: "sunny"},
+		{Role: "tool", Name: "get_weather", ToolCallID: "call_2", Content: "rainy"},
+		{Role: "user", Name: "bob", Content: "And tomorrow?"},
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, Values{Messages: msgs}); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := `user (alice): [img-0] What's the weather here and in [img-1]?
+
+Thanks! [img-0] [img-1] en-GB
+[call_1:get_weather call_2:get_weather ]
+<call_1 get_weather>sunny
+<call_2 get_weather>rainy
+user (bob): And tomorrow?
+`
+	if diff := cmp.Diff(b.String(), expect); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	// merging messages doesn't change the metadata of the request
+	if msgs[0].Metadata["lang"] != "en" {
+		t.Errorf("expected metadata to be unchanged, got %v", msgs[0].Metadata)
+	}
+}
+
 func TestExecuteWithSuffix(t *testing.T) {
 	tmpl, err := Parse(`{{- if .Suffix }}<PRE> {{ .Prompt }} <SUF>{{ .Suffix }} <MID>
 {{- else }}{{ .Prompt }}
@@ -376,3 +428,55 @@ func TestExecuteWithSuffix(t *testing.T) {
 		})
 	}
 }
+
+func TestDetect(t *testing.T) {
+	llama3 := "{% set loop_messages = messages %}{% for message in loop_messages %}{% set content = '<|start_header_id|>' + message['role'] + '<|end_header_id|>\n\n'+ message['content'] | trim + '<|eot_id|>' %}{% if loop.index0 == 0 %}{% set content = bos_token + content %}{% endif %}{{ content }}{% endfor %}{% if add_generation_prompt %}{{ '<|start_header_id|>assistant<|end_header_id|>\n\n' }}{% endif %}"
+
+	cases := []struct {
+		name         string
+		template     string
+		architecture string
+		expected     string
+		method       string
+	}{
+		{"exact", llama3, "", "llama3-instruct", "hash"},
+		{
+			name: "reformatted",
+			template: strings.NewReplacer(
+				"{%", "\n{%-",
+				"%}", " -%}\r\n",
+				"'<|eot_id|>'", `"<|eot_id|>"`,
+			).Replace(llama3),
+			expected: "llama3-instruct",
+			method:   "hash",
+		},
+		{"edited", strings.Replace(llama3, "| trim ", "", 1), "", "llama3-instruct", "score"},
+		{"edited with architecture", strings.Replace(llama3, "{% if loop.index0 == 0 %}{% set content = bos_token + content %}{% endif %}", "{{ bos_token if loop.first }}", 1), "llama", "llama3-instruct", "score"},
+		{"edited without architecture", strings.Replace(llama3, "{% if loop.index0 == 0 %}{% set content = bos_token + content %}{% endif %}", "{{ bos_token if loop.first }}", 1), "", "", ""},
+		{"unknown", "{% for message in messages %}### {{ message['role'] }}: {{ message['content'] }}{% endfor %}", "llama", "", ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			r, report, err := Detect(tt.template, tt.architecture)
+			if tt.expected == "" {
+				if !errors.Is(err, errNoMatch) {
+					t.Fatalf("expected no match, got %v", err)
+				}
+
+				if len(report.Candidates) == 0 {
+					t.Error("expected candidates in report")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if r.Name != tt.expected || report.Method != tt.method {
+				t.Errorf("expected %s by %s, got %s by %s", tt.expected, tt.method, r.Name, report.Method)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// Package transport makes the HTTP transports Ollama uses to reach
+// registries and servers.
+//
+// Transports use the proxies in HTTPS_PROXY, HTTP_PROXY and NO_PROXY as
+// they're set when each request is made, and trust the certificates in the
+// OLLAMA_CA_BUNDLE file as well as the system's, such as those of a proxy
+// which intercepts TLS.
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// Options are the settings of a transport for a server.
+type Options struct {
+	// CABundle is the path of a PEM file of certificates to trust as well
+	// as the system's and those in OLLAMA_CA_BUNDLE.
+	CABundle string
+
+	// Insecure skips verifying the certificate of the server.
+	Insecure bool
+}
+
+type key struct {
+	Options
+
+	// bundle is OLLAMA_CA_BUNDLE when the transport was made
+	bundle string
+}
+
+var (
+	mu         sync.Mutex
+	transports = make(map[key]*http.Transport)
+)
+
+// Proxy returns the proxy to make req through, like
+// [http.ProxyFromEnvironment] but reading the environment each time, so
+// that every transport agrees on the proxy after it changes.
+func Proxy(req *http.Request) (*url.URL, error) {
+	return httpproxy.FromEnvironment().ProxyFunc()(req.URL)
+}
+
+// New returns a transport like [http.DefaultTransport] with opts, which is
+// shared with other callers with the same options so that they reuse
+// connections.
+func New(opts Options) (*http.Transport, error) {
+	k := key{Options: opts, bundle: envconfig.CABundle()}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if t, ok := transports[k]; ok {
+		return t, nil
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = Proxy
+
+	pool, err := certPool(k.bundle, opts.CABundle)
+	if err != nil {
+		return nil, err
+	}
+
+	if pool != nil || opts.Insecure {
+		t.TLSClientConfig = &tls.Config{
+			RootCAs:            pool,
+			InsecureSkipVerify: opts.Insecure,
+		}
+	}
+
+	transports[k] = t
+	return t, nil
+}
+
+// Default returns the transport for servers without options of their own.
+func Default() (*http.Transport, error) {
+	return New(Options{})
+}
+
+// certPool returns the system's certificates with those in the PEM files at
+// paths added, or nil for the system's alone if paths are all empty.
+func certPool(paths ...string) (*x509.CertPool, error) {
+	var pool *x509.CertPool
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+
+		if pool == nil {
+			var err error
+			if pool, err = x509.SystemCertPool(); err != nil {
+				pool = x509.NewCertPool()
+			}
+		}
+
+		bts, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read CA bundle: %w", err)
+		}
+
+		if !pool.AppendCertsFromPEM(bts) {
+			return nil, fmt.Errorf("CA bundle %s: %w", path, errNoCertificates)
+		}
+	}
+
+	return pool, nil
+}
+
+var errNoCertificates = errors.New("no certificates found")
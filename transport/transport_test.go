@@ -0,0 +1,127 @@
+package transport
+
+import (
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeBundle writes the certificate of s to a PEM file and returns its path.
+func writeBundle(t *testing.T, s *httptest.Server) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func get(t *testing.T, opts Options, url string) (string, error) {
+	t.Helper()
+
+	tr, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := (&http.Client{Transport: tr}).Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	bts, err := io.ReadAll(resp.Body)
+	return string(bts), err
+}
+
+func TestCABundle(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer s.Close()
+
+	bundle := writeBundle(t, s)
+
+	t.Run("untrusted", func(t *testing.T) {
+		t.Setenv("OLLAMA_CA_BUNDLE", "")
+		if _, err := get(t, Options{}, s.URL); err == nil {
+			t.Fatal("expected an error verifying the certificate")
+		}
+	})
+
+	t.Run("environment", func(t *testing.T) {
+		t.Setenv("OLLAMA_CA_BUNDLE", bundle)
+		if body, err := get(t, Options{}, s.URL); err != nil || body != "ok" {
+			t.Fatalf("expected ok, got %q, %v", body, err)
+		}
+	})
+
+	t.Run("options", func(t *testing.T) {
+		t.Setenv("OLLAMA_CA_BUNDLE", "")
+		if body, err := get(t, Options{CABundle: bundle}, s.URL); err != nil || body != "ok" {
+			t.Fatalf("expected ok, got %q, %v", body, err)
+		}
+	})
+
+	t.Run("insecure", func(t *testing.T) {
+		t.Setenv("OLLAMA_CA_BUNDLE", "")
+		if body, err := get(t, Options{Insecure: true}, s.URL); err != nil || body != "ok" {
+			t.Fatalf("expected ok, got %q, %v", body, err)
+		}
+	})
+}
+
+func TestCABundleInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("OLLAMA_CA_BUNDLE", path)
+	if _, err := Default(); !errors.Is(err, errNoCertificates) {
+		t.Errorf("expected no certificates, got %v", err)
+	}
+
+	t.Setenv("OLLAMA_CA_BUNDLE", filepath.Join(t.TempDir(), "missing.pem"))
+	if _, err := Default(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the bundle not to exist, got %v", err)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "proxied "+r.URL.String())
+	}))
+	defer proxy.Close()
+
+	t.Setenv("OLLAMA_CA_BUNDLE", "")
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("NO_PROXY", "")
+
+	body, err := get(t, Options{}, "http://registry.example.com/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body != "proxied http://registry.example.com/v2/" {
+		t.Errorf("expected the request to be proxied, got %q", body)
+	}
+
+	// the proxy is read from the environment for each request
+	t.Setenv("NO_PROXY", "registry.example.com")
+	req, err := http.NewRequest(http.MethodGet, "http://registry.example.com/v2/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u, err := Proxy(req); err != nil || u != nil {
+		t.Errorf("expected no proxy, got %v, %v", u, err)
+	}
+}